@@ -0,0 +1,157 @@
+// Package client embeds the Netbird client in a Go application. Connect registers the peer, persists its key and runs
+// the Engine in the background, which is what the netbird daemon does on the up command.
+// The low-level Engine is exposed for the embedders that need to assemble it themselves
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/netbirdio/netbird/client/internal"
+	mgm "github.com/netbirdio/netbird/management/client"
+	signal "github.com/netbirdio/netbird/signal/client"
+)
+
+type (
+	// Config is the client config persisted in the config file
+	Config = internal.Config
+	// Engine manages the Wireguard interface and the connections to the remote peers
+	Engine = internal.Engine
+	// EngineConfig is the config of the Engine built from the Config and the Management Service login response
+	EngineConfig = internal.EngineConfig
+	// EngineStatus is the connectivity status of the running Engine
+	EngineStatus = internal.EngineStatus
+	// StatusType is the status of the client: Idle, Connecting, Connected, NeedsLogin or LoginFailed
+	StatusType = internal.StatusType
+)
+
+// NewEngine creates a new Engine, see Engine.Start
+func NewEngine(ctx context.Context, cancel context.CancelFunc, signalClient signal.Client, mgmClient mgm.Client,
+	config *EngineConfig) *Engine {
+	return internal.NewEngine(ctx, cancel, signalClient, mgmClient, config)
+}
+
+// ClientOptions are the options of the client connected with Connect
+type ClientOptions struct {
+	// ManagementURL is the URL of the Management Service, the one in the config file or the default one if not set
+	ManagementURL string
+	// AdminURL is the URL of the Admin Panel, the one in the config file or the default one if not set
+	AdminURL string
+	// SetupKey registers the peer on the first connect, not required once the peer has been registered
+	SetupKey string
+	// ConfigPath is the path of the config file holding the Wireguard key of the peer, it is created if it doesn't exist
+	ConfigPath string
+	// PreSharedKey is an optional Wireguard pre-shared key of the connections to the remote peers
+	PreSharedKey string
+}
+
+// Status is the status of a Client
+type Status struct {
+	// Status is the status of the client
+	Status StatusType
+	// Err is the error the client keeps retrying after, nil if there is none
+	Err error
+	// Engine is the connectivity status of the Engine, nil if the Engine isn't running
+	Engine *EngineStatus
+}
+
+// Client is a handle of the client connected with Connect
+type Client struct {
+	config *Config
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// Connect logs the peer in to the Management Service, registering it with the setup key if needed, and starts
+// the Engine in the background. It returns once the peer has been logged in, the Engine reconnects on failures
+// until the client is stopped with Client.Stop. The context limits the login only
+func Connect(ctx context.Context, opts ClientOptions) (*Client, error) {
+	if opts.ConfigPath == "" {
+		return nil, fmt.Errorf("config path is required")
+	}
+
+	config, err := internal.GetConfig(opts.ManagementURL, opts.AdminURL, opts.ConfigPath, opts.PreSharedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading config %s: %v", opts.ConfigPath, err)
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = internal.Login(ctx, config, opts.SetupKey, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed logging in to Management Service %s: %v", config.ManagementURL, err)
+	}
+
+	return run(config), nil
+}
+
+// run runs the Engine in the background until the client is stopped
+func run(config *Config) *Client {
+	ctx, cancel := context.WithCancel(internal.CtxInitState(context.Background()))
+	c := &Client{
+		config: config,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(c.done)
+		err := internal.RunClient(ctx, config)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+		}
+	}()
+
+	return c
+}
+
+// Config returns the config of the client
+func (c *Client) Config() *Config {
+	return c.config
+}
+
+// Status returns the status of the client and the connectivity status of its Engine
+func (c *Client) Status() Status {
+	state := internal.CtxGetState(c.ctx)
+	status, err := state.Status()
+
+	c.mu.Lock()
+	if c.err != nil {
+		err = c.err
+	}
+	c.mu.Unlock()
+
+	return Status{
+		Status: status,
+		Err:    err,
+		Engine: state.EngineStatus(),
+	}
+}
+
+// Done is closed once the client has stopped, either by Stop or when it has given up reconnecting (see Status)
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Stop stops the Engine, removing the Wireguard interface, and waits until it has stopped or the context is done
+func (c *Client) Stop(ctx context.Context) error {
+	c.cancel()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
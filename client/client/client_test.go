@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/testutil"
+	"github.com/netbirdio/netbird/util"
+)
+
+func TestConnect_ConfigPathRequired(t *testing.T) {
+	_, err := Connect(context.Background(), ClientOptions{ManagementURL: "https://api.example.com:33073"})
+	assert.Error(t, err)
+}
+
+func TestConnect_ManagementUnavailable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := Connect(ctx, ClientOptions{ManagementURL: "http://127.0.0.1:1", ConfigPath: path})
+	require.Error(t, err, "expecting the login to fail")
+
+	// the config and the key are persisted even if the login has failed, so the peer keeps its identity
+	config, err := internal.ReadConfig("", "", path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:1", config.ManagementURL.String())
+	assert.NotEmpty(t, config.PrivateKey)
+}
+
+func TestClient_Stop(t *testing.T) {
+	config, err := internal.GetConfig("http://127.0.0.1:1", "", filepath.Join(t.TempDir(), "config.json"), "")
+	require.NoError(t, err)
+
+	c := run(config)
+
+	require.Eventually(t, func() bool {
+		return c.Status().Status == internal.StatusConnecting
+	}, 5*time.Second, 50*time.Millisecond, "expecting the client to keep connecting to the unavailable Management Service")
+	assert.Nil(t, c.Status().Engine, "expecting no Engine to be running")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	require.NoError(t, c.Stop(ctx))
+
+	select {
+	case <-c.Done():
+	default:
+		t.Fatal("expecting the client to be done once stopped")
+	}
+}
+
+func TestConnect(t *testing.T) {
+	services := testutil.StartServices(t, testutil.Options{})
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	// the config is created up front with an interface and a port of its own, the defaults may be in use
+	config, err := internal.GetConfig(services.ManagementURL, "", path, "")
+	require.NoError(t, err)
+	port := 33150
+	config.WgIface = "utun150"
+	config.WgPort = &port
+	require.NoError(t, util.WriteJson(path, config))
+
+	connect := func(setupKey string) *Client {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		c, err := Connect(ctx, ClientOptions{ConfigPath: path, SetupKey: setupKey})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			status := c.Status()
+			return status.Status == internal.StatusConnected && status.Engine != nil
+		}, 20*time.Second, 100*time.Millisecond, "expecting the client to connect, got %+v", c.Status())
+		return c
+	}
+	stop := func(c *Client) {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+		require.NoError(t, c.Stop(ctx))
+		<-c.Done()
+	}
+
+	c := connect(services.SetupKey)
+	assert.Nil(t, c.Status().Err)
+	assert.Equal(t, "utun150", c.Config().WgIface)
+
+	key, err := c.Config().WgPrivateKey()
+	require.NoError(t, err)
+	peer, err := services.AccountManager.GetPeer(key.PublicKey().String())
+	require.NoError(t, err, "expecting the peer to be registered with the setup key")
+	stop(c)
+
+	// the peer is logged in with the key persisted in the config, the setup key isn't needed anymore
+	c = connect("")
+	reconnected, err := c.Config().WgPrivateKey()
+	require.NoError(t, err)
+	assert.Equal(t, key, reconnected, "expecting the persisted key to be reused")
+	again, err := services.AccountManager.GetPeer(key.PublicKey().String())
+	require.NoError(t, err)
+	assert.Equal(t, peer.IP.String(), again.IP.String(), "expecting the same peer to be logged in")
+	stop(c)
+}
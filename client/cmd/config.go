@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/netbirdio/netbird/client/internal"
+)
+
+var (
+	checkConnectivity bool
+	keyProtection     string
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "validates, migrates and encrypts the Netbird config file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validates the Netbird config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := handleRebrand(cmd)
+		if err != nil {
+			return err
+		}
+
+		config, err := internal.ValidateConfigFile(configPath)
+		if err == nil && checkConnectivity {
+			err = internal.CheckConfigConnectivity(context.Background(), config)
+		}
+		if err != nil {
+			var problems internal.ConfigProblems
+			if !errors.As(err, &problems) {
+				return fmt.Errorf("failed validating config %s: %v", configPath, err)
+			}
+			cmd.Printf("Config %s has %d problem(s):\n", configPath, len(problems))
+			for _, problem := range problems {
+				cmd.Printf("  - %s\n", problem)
+			}
+			return fmt.Errorf("config %s is invalid", configPath)
+		}
+
+		cmd.Printf("Config %s is valid\n", configPath)
+		warnings := config.Warnings()
+		if len(warnings) > 0 {
+			cmd.Printf("Config %s has %d warning(s):\n", configPath, len(warnings))
+			for _, warning := range warnings {
+				cmd.Printf("  - %s\n", warning)
+			}
+		}
+		return nil
+	},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "rewrites the fields of the Netbird config file in an old format to the current format",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := handleRebrand(cmd)
+		if err != nil {
+			return err
+		}
+
+		changes, err := internal.MigrateConfigFile(configPath)
+		if err != nil {
+			return fmt.Errorf("failed migrating config %s: %v", configPath, err)
+		}
+		if len(changes) == 0 {
+			cmd.Printf("Config %s is up to date\n", configPath)
+			return nil
+		}
+
+		cmd.Printf("Migrated config %s (backup saved to %s.bak):\n", configPath, configPath)
+		for _, change := range changes {
+			cmd.Printf("  - %s\n", change)
+		}
+		return nil
+	},
+}
+
+var configEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "encrypts the Wireguard private key kept in plaintext in the Netbird config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := handleRebrand(cmd)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := internal.EncryptConfigFile(configPath, keyProtection)
+		if err != nil {
+			return fmt.Errorf("failed encrypting config %s: %v", configPath, err)
+		}
+		if !encrypted {
+			cmd.Printf("Wireguard private key in config %s is encrypted already\n", configPath)
+			return nil
+		}
+
+		cmd.Printf("Encrypted the Wireguard private key in config %s, restart the daemon to use it\n", configPath)
+		if _, err := os.Stat(configPath + ".bak"); err == nil {
+			cmd.Printf("The backup %s.bak may still hold the plaintext key, remove it\n", configPath)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/util"
+)
+
+var (
+	// debugBundleOutput is the path the debug bundle is written to set with the --output flag of the debug bundle command
+	debugBundleOutput string
+	// captureDuration and captureMaxBytes limit the packet capture, set with the --duration and --max-bytes flags
+	// of the debug capture command
+	captureDuration time.Duration
+	captureMaxBytes int64
+)
+
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "troubleshooting commands",
+}
+
+var debugBundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "writes a debug bundle for troubleshooting",
+	Long: "asks the Netbird Service for a zip archive with the recent logs, the connection status with the ICE details " +
+		"of the peers, the config, the network interfaces and the routes, and writes it to a file. " +
+		"The keys are redacted from the archive, so it can be attached to a support request",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		resp, err := proto.NewDaemonServiceClient(conn).DebugBundle(cmd.Context(), &proto.DebugBundleRequest{})
+		if err != nil {
+			return fmt.Errorf("debug bundle failed: %v", status.Convert(err).Message())
+		}
+
+		output := debugBundleOutput
+		if output == "" {
+			output = fmt.Sprintf("netbird-debug-%s.zip", time.Now().Format("20060102-150405"))
+		}
+		err = os.WriteFile(output, resp.GetArchive(), 0600)
+		if err != nil {
+			return fmt.Errorf("failed writing debug bundle: %v", err)
+		}
+
+		cmd.Printf("Debug bundle has been written to %s\n", output)
+		return nil
+	},
+}
+
+var debugCaptureCmd = &cobra.Command{
+	Use:   "capture",
+	Short: "captures the packets of the Wireguard interface",
+	Long: "asks the Netbird Service to capture the packets sent and received through the Wireguard interface " +
+		"to a pcap file in its state directory for the duration, at most 5 minutes. The capture stops earlier " +
+		"on an interrupt or once the file has reached the size limit. Requires running the command as root, " +
+		"or from an elevated prompt on Windows. Without the kernel Wireguard of Linux the packets are captured " +
+		"in the userspace mode only",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		client := proto.NewDaemonServiceClient(conn)
+		resp, err := client.StartCapture(cmd.Context(), &proto.StartCaptureRequest{
+			Duration: durationpb.New(captureDuration),
+			MaxBytes: captureMaxBytes,
+		})
+		if err != nil {
+			return fmt.Errorf("starting capture failed: %v", status.Convert(err).Message())
+		}
+		cmd.Printf("Capturing packets to %s for %s, press Ctrl+C to stop\n", resp.GetPath(), captureDuration)
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(interrupt)
+		select {
+		case <-time.After(captureDuration):
+		case <-interrupt:
+		}
+
+		stopResp, err := client.StopCapture(cmd.Context(), &proto.StopCaptureRequest{})
+		if err != nil {
+			return fmt.Errorf("stopping capture failed: %v", status.Convert(err).Message())
+		}
+
+		cmd.Printf("Captured %d packets (%d bytes) to %s\n", stopResp.GetPackets(), stopResp.GetBytes(), stopResp.GetPath())
+		return nil
+	},
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/skratchdot/open-golang/open"
 	"google.golang.org/grpc/codes"
@@ -24,7 +25,7 @@ var loginCmd = &cobra.Command{
 
 		cmd.SetOut(cmd.OutOrStdout())
 
-		err := util.InitLog(logLevel, "console")
+		err := util.InitLog(logLevel, "console", logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -65,6 +66,7 @@ var loginCmd = &cobra.Command{
 			SetupKey:      setupKey,
 			PreSharedKey:  preSharedKey,
 			ManagementUrl: managementURL,
+			ReRegister:    reRegister,
 		}
 
 		var loginErr error
@@ -76,6 +78,8 @@ var loginCmd = &cobra.Command{
 			loginResp, backOffErr = client.Login(ctx, &loginRequest)
 			if s, ok := gstatus.FromError(backOffErr); ok && (s.Code() == codes.InvalidArgument ||
 				s.Code() == codes.PermissionDenied ||
+				s.Code() == codes.ResourceExhausted ||
+				s.Code() == codes.AlreadyExists ||
 				s.Code() == codes.NotFound ||
 				s.Code() == codes.Unimplemented) {
 				loginErr = backOffErr
@@ -92,7 +96,7 @@ var loginCmd = &cobra.Command{
 		}
 
 		if loginResp.NeedsSSOLogin {
-			openURL(cmd, loginResp.VerificationURIComplete)
+			openURL(cmd, loginResp.VerificationURIComplete, loginResp.UserCode)
 
 			_, err = client.WaitSSOLogin(ctx, &proto.WaitSSOLoginRequest{UserCode: loginResp.UserCode})
 			if err != nil {
@@ -110,8 +114,13 @@ func foregroundLogin(ctx context.Context, cmd *cobra.Command, config *internal.C
 	needsLogin := false
 
 	err := WithBackOff(func() error {
-		err := internal.Login(ctx, config, "", "")
-		if s, ok := gstatus.FromError(err); ok && (s.Code() == codes.InvalidArgument || s.Code() == codes.PermissionDenied) {
+		if reRegister {
+			// a peer registering again doesn't login with its registered key
+			needsLogin = true
+			return nil
+		}
+		err := internal.Login(ctx, config, "", "", false)
+		if errors.Is(err, internal.ErrLoginRequired) || errors.Is(err, internal.ErrInvalidSetupKey) {
 			needsLogin = true
 			return nil
 		}
@@ -122,17 +131,32 @@ func foregroundLogin(ctx context.Context, cmd *cobra.Command, config *internal.C
 	}
 
 	jwtToken := ""
+	deviceAuthToken := ""
 	if setupKey == "" && needsLogin {
 		tokenInfo, err := foregroundGetTokenInfo(ctx, cmd, config)
 		if err != nil {
 			return fmt.Errorf("interactive sso login failed: %v", err)
 		}
-		jwtToken = tokenInfo.AccessToken
+		if tokenInfo.TokenType == internal.ManagementDeviceAuthTokenType {
+			deviceAuthToken = tokenInfo.AccessToken
+		} else {
+			jwtToken = tokenInfo.AccessToken
+		}
 	}
 
+	var loginErr error
 	err = WithBackOff(func() error {
-		err := internal.Login(ctx, config, setupKey, jwtToken)
-		if s, ok := gstatus.FromError(err); ok && (s.Code() == codes.InvalidArgument || s.Code() == codes.PermissionDenied) {
+		var err error
+		if deviceAuthToken != "" {
+			err = internal.LoginWithDeviceAuth(ctx, config, deviceAuthToken, reRegister)
+		} else {
+			err = internal.Login(ctx, config, setupKey, jwtToken, reRegister)
+		}
+		if errors.Is(err, internal.ErrLoginRequired) || errors.Is(err, internal.ErrInvalidSetupKey) ||
+			errors.Is(err, internal.ErrInvalidConfig) || errors.Is(err, internal.ErrPeersLimitReached) ||
+			errors.Is(err, internal.ErrPeerAlreadyRegistered) {
+			// retrying won't help, e.g. the setup key was revoked
+			loginErr = err
 			return nil
 		}
 		return err
@@ -141,17 +165,21 @@ func foregroundLogin(ctx context.Context, cmd *cobra.Command, config *internal.C
 		return fmt.Errorf("backoff cycle failed: %v", err)
 	}
 
+	if loginErr != nil {
+		return fmt.Errorf("login failed: %v", loginErr)
+	}
+
 	return nil
 }
 
 func foregroundGetTokenInfo(ctx context.Context, cmd *cobra.Command, config *internal.Config) (*internal.TokenInfo, error) {
+	var oauthClient internal.OAuthClient
 	providerConfig, err := internal.GetDeviceAuthorizationFlowInfo(ctx, config)
 	if err != nil {
 		s, ok := gstatus.FromError(err)
 		if ok && s.Code() == codes.NotFound {
-			return nil, fmt.Errorf("no SSO provider returned from management. " +
-				"If you are using hosting Netbird see documentation at " +
-				"https://github.com/netbirdio/netbird/tree/main/management for details")
+			// no identity provider device flow, the user approves the login in the dashboard instead
+			oauthClient = internal.NewManagementDeviceFlow(config)
 		} else if ok && s.Code() == codes.Unimplemented {
 			mgmtURL := managementURL
 			if mgmtURL == "" {
@@ -162,26 +190,31 @@ func foregroundGetTokenInfo(ctx context.Context, cmd *cobra.Command, config *int
 		} else {
 			return nil, fmt.Errorf("getting device authorization flow info failed with error: %v", err)
 		}
+	} else {
+		oauthClient = internal.NewHostedDeviceFlow(
+			providerConfig.ProviderConfig.Audience,
+			providerConfig.ProviderConfig.ClientID,
+			providerConfig.ProviderConfig.Domain,
+		)
 	}
 
-	hostedClient := internal.NewHostedDeviceFlow(
-		providerConfig.ProviderConfig.Audience,
-		providerConfig.ProviderConfig.ClientID,
-		providerConfig.ProviderConfig.Domain,
-	)
-
-	flowInfo, err := hostedClient.RequestDeviceCode(context.TODO())
+	flowInfo, err := oauthClient.RequestDeviceCode(context.TODO())
 	if err != nil {
+		if s, ok := gstatus.FromError(err); ok && s.Code() == codes.Unimplemented {
+			return nil, fmt.Errorf("no SSO provider returned from management. " +
+				"If you are using hosting Netbird see documentation at " +
+				"https://github.com/netbirdio/netbird/tree/main/management for details")
+		}
 		return nil, fmt.Errorf("getting a request device code failed: %v", err)
 	}
 
-	openURL(cmd, flowInfo.VerificationURIComplete)
+	openURL(cmd, flowInfo.VerificationURIComplete, flowInfo.UserCode)
 
 	waitTimeout := time.Duration(flowInfo.ExpiresIn)
 	waitCTX, c := context.WithTimeout(context.TODO(), waitTimeout*time.Second)
 	defer c()
 
-	tokenInfo, err := hostedClient.WaitToken(waitCTX, flowInfo)
+	tokenInfo, err := oauthClient.WaitToken(waitCTX, flowInfo)
 	if err != nil {
 		return nil, fmt.Errorf("waiting for browser login failed: %v", err)
 	}
@@ -189,11 +222,14 @@ func foregroundGetTokenInfo(ctx context.Context, cmd *cobra.Command, config *int
 	return &tokenInfo, nil
 }
 
-func openURL(cmd *cobra.Command, verificationURIComplete string) {
+func openURL(cmd *cobra.Command, verificationURIComplete string, userCode string) {
 	err := open.Run(verificationURIComplete)
 	cmd.Printf("Please do the SSO login in your browser. \n" +
 		"If your browser didn't open automatically, use this URL to log in:\n\n" +
 		" " + verificationURIComplete + " \n\n")
+	if userCode != "" {
+		cmd.Printf("Confirm the login with the code:\n\n %s \n\n", userCode)
+	}
 	if err != nil {
 		cmd.Printf("Alternatively, you may want to use a setup key, see:\n\n https://www.netbird.io/docs/overview/setup-keys\n")
 	}
@@ -7,7 +7,10 @@ import (
 
 	"github.com/netbirdio/netbird/client/internal"
 	"github.com/netbirdio/netbird/iface"
+	mgmt "github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/testutil"
 	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func TestLogin(t *testing.T) {
@@ -51,3 +54,73 @@ func TestLogin(t *testing.T) {
 		t.Errorf("expected non empty Private key, got empty")
 	}
 }
+
+func TestLogin_ReRegister(t *testing.T) {
+	config := &mgmt.Config{}
+	_, err := util.ReadJson("../testdata/management.json", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services := testutil.StartServices(t, testutil.Options{
+		StoreFile:  "../testdata/store.json",
+		Stuns:      config.Stuns,
+		TURNConfig: config.TURNConfig,
+	})
+
+	confPath := t.TempDir() + "/config.json"
+	login := func(setupKey string, reRegister bool) error {
+		rootCmd.SetArgs([]string{
+			"login",
+			"--config",
+			confPath,
+			"--log-file",
+			"console",
+			"--setup-key",
+			setupKey,
+			fmt.Sprintf("--re-register=%t", reRegister),
+			"--management-url",
+			services.ManagementURL,
+		})
+		return rootCmd.Execute()
+	}
+
+	validKey := strings.ToUpper("a2c8e62b-38f5-4553-b31e-dd66c696cebb")
+	unknownKey := "11111111-2222-3333-4444-555555555555"
+	if err := login(validKey, false); err != nil {
+		t.Fatal(err)
+	}
+
+	actualConf := &internal.Config{}
+	if _, err := util.ReadJson(confPath, actualConf); err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := wgtypes.ParseKey(actualConf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registered, err := services.AccountManager.GetPeer(privateKey.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a registered peer logs in without sending the setup key
+	if err := login(unknownKey, false); err != nil {
+		t.Errorf("expecting the registered peer to log in, got %v", err)
+	}
+
+	// a peer registering again sends the setup key, which has to be valid
+	if err := login(unknownKey, true); err == nil {
+		t.Error("expecting the registration with an unknown setup key to fail")
+	}
+
+	if err := login(validKey, true); err != nil {
+		t.Fatalf("expecting the registered peer to register again, got %v", err)
+	}
+	peer, err := services.AccountManager.GetPeer(privateKey.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peer.IP.Equal(registered.IP) {
+		t.Errorf("expecting the existing peer to be reused with IP %s, got %s", registered.IP, peer.IP)
+	}
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/util"
+)
+
+// logComponents are the log levels of the components set by the log-level command
+var logComponents string
+
+var logLevelCmd = &cobra.Command{
+	Use:   "log-level [level]",
+	Short: "sets the log level of the running Netbird Service without restarting it",
+	Long: "sets the log level of the running Netbird Service without restarting it (e.g. netbird log-level debug).\n" +
+		"The log levels of the components set with --components are kept until the next up command",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		req := &proto.SetLogLevelRequest{Components: logComponents}
+		if len(args) > 0 {
+			req.Level = args[0]
+		}
+		if req.Level == "" && req.Components == "" {
+			return fmt.Errorf("expecting a log level or --components")
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		daemonClient := proto.NewDaemonServiceClient(conn)
+
+		_, err = daemonClient.SetLogLevel(cmd.Context(), req)
+		if err != nil {
+			return fmt.Errorf("set log level failed: %v", status.Convert(err).Message())
+		}
+
+		cmd.Println("Log level has been set")
+		return nil
+	},
+}
@@ -23,6 +23,9 @@ import (
 	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/server"
+	nbssh "github.com/netbirdio/netbird/client/ssh"
+	"github.com/netbirdio/netbird/util"
 )
 
 var (
@@ -32,6 +35,7 @@ var (
 	oldDefaultConfigPathDir string
 	oldDefaultConfigPath    string
 	logLevel                string
+	logFormat               string
 	defaultLogFileDir       string
 	defaultLogFile          string
 	oldDefaultLogFileDir    string
@@ -41,6 +45,7 @@ var (
 	managementURL           string
 	adminURL                string
 	setupKey                string
+	reRegister              bool
 	preSharedKey            string
 	rootCmd                 = &cobra.Command{
 		Use:          "netbird",
@@ -85,17 +90,40 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&adminURL, "admin-url", "https://app.netbird.io", "Admin Panel URL [http|https]://[host]:[port]")
 	rootCmd.PersistentFlags().StringVar(&configPath, "config", defaultConfigPath, "Netbird config file location")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "sets Netbird log level")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", util.LogFormatText, "sets Netbird log format [text|json]")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", defaultLogFile, "sets Netbird log path. If console is specified the the log will be output to stdout")
 	rootCmd.PersistentFlags().StringVar(&setupKey, "setup-key", "", "Setup key obtained from the Management Service Dashboard (used to register peer)")
+	rootCmd.PersistentFlags().BoolVar(&reRegister, "re-register", false, "registers the peer with the setup key or the SSO login even if it is registered already (e.g. a re-enrolled machine), the existing peer is reused")
 	rootCmd.PersistentFlags().StringVar(&preSharedKey, "preshared-key", "", "Sets Wireguard PreSharedKey property. If set, then only peers that have the same key can communicate.")
 	rootCmd.AddCommand(serviceCmd)
 	rootCmd.AddCommand(upCmd)
 	rootCmd.AddCommand(downCmd)
 	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(routesCmd)
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(logLevelCmd)
+	rootCmd.AddCommand(rotateKeyCmd)
+	rootCmd.AddCommand(updateCmd)
+	rootCmd.AddCommand(sshCmd)
+	rootCmd.AddCommand(debugCmd)
 	serviceCmd.AddCommand(runCmd, startCmd, stopCmd, restartCmd) // service control commands are subcommands of service
 	serviceCmd.AddCommand(installCmd, uninstallCmd)              // service installer commands are subcommands of service
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "prints the status as JSON")
+	configCmd.AddCommand(configValidateCmd, configMigrateCmd, configEncryptCmd)
+	debugCmd.AddCommand(debugBundleCmd, debugCaptureCmd)
+	debugBundleCmd.Flags().StringVarP(&debugBundleOutput, "output", "o", "", "path of the debug bundle (default netbird-debug-<time>.zip in the current directory)")
+	debugCaptureCmd.Flags().DurationVar(&captureDuration, "duration", server.DefaultCaptureDuration, "duration of the capture, at most 5m")
+	debugCaptureCmd.Flags().Int64Var(&captureMaxBytes, "max-bytes", 0, "size limit of the pcap file, the default limit of the service is used if it is 0")
+	logLevelCmd.Flags().StringVar(&logComponents, "components", "", "sets the log levels of the components (e.g. peer=debug,engine=info)")
+	upCmd.Flags().StringVar(&exitNode, "exit-node", "", "routes all traffic through the peer (by its key, name or IP) while it is connected, an empty value routes it through the default route again (Linux only)")
+	updateCmd.Flags().BoolVar(&updateCheckOnly, "check", false, "reports the available update without installing it")
+	sshCmd.Flags().IntVarP(&sshPort, "port", "p", nbssh.DefaultSSHPort, "port of the SSH server of the peer")
+	// the flags of the remote command follow the peer
+	sshCmd.Flags().SetInterspersed(false)
+	configValidateCmd.Flags().BoolVar(&checkConnectivity, "check-connectivity", false, "checks that the Management Service and the Admin Panel are reachable")
+	configEncryptCmd.Flags().StringVar(&keyProtection, "protection", internal.DefaultKeyProtection(), "encrypts the key with a passphrase (read from NB_CONFIG_PASSPHRASE or the Linux user keyring), the macOS keychain or Windows dpapi")
 }
 
 // SetupCloseHandler handles SIGTERM signal and exits with success
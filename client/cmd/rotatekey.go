@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/util"
+)
+
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "rotates the Wireguard key of the peer without registering it again",
+	Long: "rotates the Wireguard key of the peer without registering it again, the peer keeps its IP, name and groups.\n" +
+		"The connections of the running Netbird Service are restarted with the new key. " +
+		"Set KeyRotationInterval in the config to rotate the key periodically",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		daemonClient := proto.NewDaemonServiceClient(conn)
+
+		resp, err := daemonClient.RotateKey(cmd.Context(), &proto.RotateKeyRequest{})
+		if err != nil {
+			return fmt.Errorf("rotate key failed: %v", status.Convert(err).Message())
+		}
+
+		cmd.Printf("Wireguard key has been rotated, the new public key is %s\n", resp.GetPublicKey())
+		return nil
+	},
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/util"
+)
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "list routes installed by the Netbird Service",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		daemonClient := proto.NewDaemonServiceClient(conn)
+
+		resp, err := daemonClient.ListRoutes(cmd.Context(), &proto.ListRoutesRequest{})
+		if err != nil {
+			return fmt.Errorf("list routes failed: %v", status.Convert(err).Message())
+		}
+
+		if len(resp.GetRoutes()) == 0 {
+			cmd.Println("No routes installed")
+			return nil
+		}
+
+		peerNames := map[string]string{}
+		statusResp, err := daemonClient.Status(cmd.Context(), &proto.StatusRequest{})
+		if err == nil {
+			for _, peer := range statusResp.GetPeers() {
+				peerNames[peer.GetPubKey()] = peer.GetName()
+			}
+		}
+
+		cmd.Println("Routes:")
+		for _, route := range resp.GetRoutes() {
+			via := "local network"
+			if route.GetPeerPubKey() != "" {
+				via = peerLabel(route.GetPeerPubKey(), peerNames[route.GetPeerPubKey()])
+			}
+			cmd.Printf(" %s dev %s via %s\n", route.GetNetwork(), route.GetInterface(), via)
+		}
+
+		return nil
+	},
+}
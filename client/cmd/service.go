@@ -10,12 +10,14 @@ import (
 	"google.golang.org/grpc"
 
 	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/server"
 )
 
 type program struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	serv   *grpc.Server
+	daemon *server.Server
 }
 
 func newProgram(ctx context.Context, cancel context.CancelFunc) *program {
@@ -18,11 +18,15 @@ import (
 	"google.golang.org/grpc"
 )
 
+// serviceStopTimeout limits the time the service waits for the Engine to stop, the service managers kill
+// the services that don't stop in time (e.g. 20 seconds on Windows)
+const serviceStopTimeout = 10 * time.Second
+
 func (p *program) Start(svc service.Service) error {
 	// Start should not block. Do the actual work async.
 	log.Info("starting Netbird service") //nolint
 	// in any case, even if configuration does not exists we run daemon to serve CLI gRPC API.
-	p.serv = grpc.NewServer()
+	p.serv = grpc.NewServer(grpc.Creds(server.PeerCredentials()))
 
 	split := strings.Split(daemonAddr, "://")
 	switch split[0] {
@@ -43,6 +47,7 @@ func (p *program) Start(svc service.Service) error {
 	if err != nil {
 		return fmt.Errorf("failed to listen daemon interface: %w", err)
 	}
+	p.daemon = server.New(p.ctx, managementURL, adminURL, configPath, logFile)
 	go func() {
 		defer listen.Close()
 
@@ -54,11 +59,10 @@ func (p *program) Start(svc service.Service) error {
 			}
 		}
 
-		serverInstance := server.New(p.ctx, managementURL, adminURL, configPath, logFile)
-		if err := serverInstance.Start(); err != nil {
+		if err := p.daemon.Start(); err != nil {
 			log.Fatalf("failed to start daemon: %v", err)
 		}
-		proto.RegisterDaemonServiceServer(p.serv, serverInstance)
+		proto.RegisterDaemonServiceServer(p.serv, p.daemon)
 
 		log.Printf("started daemon server: %v", split[1])
 		if err := p.serv.Serve(listen); err != nil {
@@ -68,6 +72,8 @@ func (p *program) Start(svc service.Service) error {
 	return nil
 }
 
+// Stop is called by the service manager (e.g. on the stop and shutdown requests of the Windows Service Control Manager).
+// It stops the Engine gracefully, so the Wireguard interface and the routes are removed before the process exits
 func (p *program) Stop(srv service.Service) error {
 	p.cancel()
 
@@ -75,7 +81,14 @@ func (p *program) Stop(srv service.Service) error {
 		p.serv.Stop()
 	}
 
-	time.Sleep(time.Second * 2)
+	if p.daemon != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serviceStopTimeout)
+		defer cancel()
+		if err := p.daemon.Stop(ctx); err != nil {
+			log.Warnf("connections haven't been closed in %s: %v", serviceStopTimeout, err)
+		}
+	}
+
 	log.Info("stopped Netbird service") //nolint
 	return nil
 }
@@ -93,7 +106,7 @@ var runCmd = &cobra.Command{
 			return err
 		}
 
-		err = util.InitLog(logLevel, logFile)
+		err = util.InitLog(logLevel, logFile, logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -127,7 +140,7 @@ var startCmd = &cobra.Command{
 			return err
 		}
 
-		err = util.InitLog(logLevel, logFile)
+		err = util.InitLog(logLevel, logFile, logFormat)
 		if err != nil {
 			return err
 		}
@@ -162,7 +175,7 @@ var stopCmd = &cobra.Command{
 			return err
 		}
 
-		err = util.InitLog(logLevel, logFile)
+		err = util.InitLog(logLevel, logFile, logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -195,7 +208,7 @@ var restartCmd = &cobra.Command{
 			return err
 		}
 
-		err = util.InitLog(logLevel, logFile)
+		err = util.InitLog(logLevel, logFile, logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"runtime"
 
+	"github.com/kardianos/service"
 	"github.com/spf13/cobra"
 )
 
@@ -29,6 +30,10 @@ var installCmd = &cobra.Command{
 			configPath,
 			"--log-level",
 			logLevel,
+			"--log-format",
+			logFormat,
+			"--log-file",
+			logFile,
 		}
 
 		if managementURL != "" {
@@ -41,6 +46,8 @@ var installCmd = &cobra.Command{
 			svcConfig.Dependencies = []string{"After=network.target syslog.target"}
 		}
 
+		svcConfig.Option = serviceOptions()
+
 		ctx, cancel := context.WithCancel(cmd.Context())
 
 		s, err := newSVC(newProgram(ctx, cancel), svcConfig)
@@ -59,6 +66,21 @@ var installCmd = &cobra.Command{
 	},
 }
 
+// serviceOptions returns the options of the service managers, each of them picks its own: the Windows Service
+// Control Manager restarts the crashed daemon, launchd starts it on boot and keeps it running, systemd restarts it
+// (Restart=always by default)
+func serviceOptions() service.KeyValue {
+	return service.KeyValue{
+		// Windows
+		"OnFailure":              "restart",
+		"OnFailureDelayDuration": "5s",
+		"OnFailureResetPeriod":   60,
+		// macOS
+		"RunAtLoad": true,
+		"KeepAlive": true,
+	}
+}
+
 var uninstallCmd = &cobra.Command{
 	Use:   "uninstall",
 	Short: "uninstalls Netbird service from system",
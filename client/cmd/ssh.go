@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	nbssh "github.com/netbirdio/netbird/client/ssh"
+	"github.com/netbirdio/netbird/util"
+)
+
+// sshPort is the port of the SSH server of the peer set with the --port flag of the ssh command
+var sshPort int
+
+var sshCmd = &cobra.Command{
+	Use:   "ssh [user@]<peer> [command]",
+	Short: "runs a shell or a command on a peer over the tunnel",
+	Long: "runs a shell or a command on a peer (by its key, name or IP) over the tunnel, no sshd is required on the peer.\n" +
+		"The peer runs an SSH server if ServerSSHAllowed is set in its config and SSH is enabled for it on the Management Service, " +
+		"only the peers allowed by the Management Service can connect with a login mapped to a local user by ServerSSHUsers. The user defaults to the current one. " +
+		"The SSH key of the peer is read from the config, so the command usually requires the root privileges",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		userName, selector := parseSSHTarget(args[0])
+		if userName == "" {
+			current, err := user.Current()
+			if err != nil {
+				return fmt.Errorf("failed looking up the current user: %v", err)
+			}
+			userName = current.Username
+		}
+
+		config, err := internal.ReadConfig("", "", configPath, nil)
+		if err != nil {
+			return fmt.Errorf("failed reading config %s: %v", configPath, err)
+		}
+		if config.SSHKey == "" {
+			return fmt.Errorf("config %s has no SSH key yet, it is generated when the Netbird Service starts", configPath)
+		}
+
+		ctx := internal.CtxInitState(context.Background())
+
+		conn, err := DialClientGRPCServer(ctx, daemonAddr)
+		if err != nil {
+			return fmt.Errorf("failed to connect to daemon error: %v\n"+
+				"If the daemon is not running please run: "+
+				"\nnetbird service install \nnetbird service start\n", err)
+		}
+		defer conn.Close()
+
+		resp, err := proto.NewDaemonServiceClient(conn).Status(cmd.Context(), &proto.StatusRequest{})
+		if err != nil {
+			return fmt.Errorf("status failed: %v", status.Convert(err).Message())
+		}
+
+		peer := findSSHPeer(selector, resp.GetPeers())
+		switch {
+		case peer == nil:
+			return fmt.Errorf("peer %s not found", selector)
+		case peer.GetIp() == "":
+			return fmt.Errorf("peer %s isn't reachable, the Netbird Service isn't connected", selector)
+		case peer.GetSshHostKey() == "":
+			return fmt.Errorf("peer %s has no SSH key, its client doesn't support SSH", selector)
+		}
+
+		addr := net.JoinHostPort(peer.GetIp(), strconv.Itoa(sshPort))
+		client, err := nbssh.Dial(addr, userName, []byte(config.SSHKey), peer.GetSshHostKey())
+		if err != nil {
+			return err
+		}
+
+		if len(args) > 1 {
+			err = client.Exec(strings.Join(args[1:], " "))
+		} else {
+			err = client.OpenTerminal()
+		}
+		_ = client.Close()
+
+		// the exit status of the remote command is the exit status of the command, like the one of ssh
+		var exitErr *ssh.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitStatus())
+		}
+		return err
+	},
+}
+
+// parseSSHTarget splits the [user@]<peer> argument of the ssh command, the user is empty if not set
+func parseSSHTarget(target string) (string, string) {
+	i := strings.LastIndex(target, "@")
+	if i < 0 {
+		return "", target
+	}
+	return target[:i], target[i+1:]
+}
+
+// findSSHPeer returns the peer selected by its key, name or IP, nil if there is none
+func findSSHPeer(selector string, peers []*proto.PeerState) *proto.PeerState {
+	for _, peer := range peers {
+		if peer.GetPubKey() == selector || strings.EqualFold(peer.GetName(), selector) || peer.GetIp() == selector {
+			return peer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/netbirdio/netbird/client/proto"
+)
+
+func TestParseSSHTarget(t *testing.T) {
+	testCases := []struct {
+		target string
+		user   string
+		peer   string
+	}{
+		{target: "laptop", peer: "laptop"},
+		{target: "admin@laptop", user: "admin", peer: "laptop"},
+		{target: "admin@corp@100.64.0.10", user: "admin@corp", peer: "100.64.0.10"},
+	}
+
+	for _, testCase := range testCases {
+		user, peer := parseSSHTarget(testCase.target)
+		if user != testCase.user || peer != testCase.peer {
+			t.Errorf("expecting %s to be user %q and peer %q, got %q and %q", testCase.target, testCase.user, testCase.peer, user, peer)
+		}
+	}
+}
+
+func TestFindSSHPeer(t *testing.T) {
+	peers := []*proto.PeerState{
+		{PubKey: "keyA", Name: "Laptop", Ip: "100.64.0.10"},
+		{PubKey: "keyB", Name: "server", Ip: "100.64.0.11"},
+	}
+
+	for selector, expected := range map[string]string{"keyB": "keyB", "laptop": "keyA", "100.64.0.11": "keyB"} {
+		peer := findSSHPeer(selector, peers)
+		if peer == nil || peer.GetPubKey() != expected {
+			t.Errorf("expecting %s to select peer %s, got %v", selector, expected, peer)
+		}
+	}
+
+	if peer := findSSHPeer("phone", peers); peer != nil {
+		t.Errorf("expecting no peer to be selected, got %v", peer)
+	}
+}
@@ -2,16 +2,25 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
 	"github.com/netbirdio/netbird/util"
 
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/status"
 
 	"github.com/netbirdio/netbird/client/internal"
+	internalPeer "github.com/netbirdio/netbird/client/internal/peer"
 	"github.com/netbirdio/netbird/client/proto"
 )
 
+var statusJSON bool
+
 var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "status of the Netbird Service",
@@ -20,7 +29,7 @@ var statusCmd = &cobra.Command{
 
 		cmd.SetOut(cmd.OutOrStdout())
 
-		err := util.InitLog(logLevel, "console")
+		err := util.InitLog(logLevel, "console", logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -40,7 +49,15 @@ var statusCmd = &cobra.Command{
 			return fmt.Errorf("status failed: %v", status.Convert(err).Message())
 		}
 
-		cmd.Printf("Status: %s\n\n", resp.GetStatus())
+		output := toStatusOutput(resp, time.Now())
+		if statusJSON {
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(output)
+		}
+
+		printStatus(cmd, output)
+
 		if resp.GetStatus() == string(internal.StatusNeedsLogin) || resp.GetStatus() == string(internal.StatusLoginFailed) {
 
 			cmd.Printf("Run UP command to log in with SSO (interactive login):\n\n" +
@@ -53,3 +70,393 @@ var statusCmd = &cobra.Command{
 		return nil
 	},
 }
+
+// statusOutput is the JSON representation of the daemon status. The fields are stable for scripting,
+// the ones without a value are null
+type statusOutput struct {
+	Status     string        `json:"status"`
+	WgPort     int32         `json:"wgPort"`
+	WgMode     string        `json:"wgMode"`
+	Management *streamOutput `json:"management"`
+	Signal     *streamOutput `json:"signal"`
+	Relays     []string      `json:"relays"`
+	// RelaysExpireAt is the expiration time of the TURN credentials, null if they don't expire
+	RelaysExpireAt *time.Time          `json:"relaysExpireAt"`
+	Peers          []peerOutput        `json:"peers"`
+	ClientUpdate   *clientUpdateOutput `json:"clientUpdate"`
+	// ConnFailures counts the failed connection attempts to the peers by the failure class
+	ConnFailures map[string]int64 `json:"connFailures"`
+	// RejectedEndpoints counts the endpoints negotiated with the peers rejected because the peers haven't advertised them
+	RejectedEndpoints int64 `json:"rejectedEndpoints"`
+	// ExitNode is the key of the peer selected as the exit node, empty if there is none
+	ExitNode string `json:"exitNode"`
+	// ExitNodeActive indicates that all the traffic is currently routed through the exit node
+	ExitNodeActive bool `json:"exitNodeActive"`
+	// AvailableUpdate is the version of a newer client release found by the update check, empty if there is none
+	AvailableUpdate string `json:"availableUpdate"`
+	// NetworkRoutes are the networks behind the routing peers
+	NetworkRoutes []networkRouteOutput `json:"networkRoutes"`
+	// AllowedIPsConflicts are the allowed IPs of the peers refused because another peer has the same or an overlapping one
+	AllowedIPsConflicts []allowedIPsConflictOutput `json:"allowedIpsConflicts"`
+	// WgStatsPolledAt is the time of the latest read of the Wireguard statistics (e.g. the handshakes of the peers),
+	// null if they haven't been read yet
+	WgStatsPolledAt *time.Time `json:"wgStatsPolledAt"`
+}
+
+type allowedIPsConflictOutput struct {
+	// Prefix is the allowed IP of Peer that keeps it
+	Prefix string `json:"prefix"`
+	Peer   string `json:"peer"`
+	// ConflictingPrefix is the allowed IP refused to ConflictingPeer, equal to Prefix for a duplicate
+	ConflictingPrefix string `json:"conflictingPrefix"`
+	ConflictingPeer   string `json:"conflictingPeer"`
+	Duplicate         bool   `json:"duplicate"`
+}
+
+type networkRouteOutput struct {
+	Network string `json:"network"`
+	// Peer is the key of the routing peer the network is routed through, empty if none of them is connected
+	Peer string `json:"peer"`
+	// Peers are the keys of the routing peers of the network, the most preferred first
+	Peers []string `json:"peers"`
+}
+
+type streamOutput struct {
+	Connected bool       `json:"connected"`
+	Since     *time.Time `json:"since"`
+	// URL is the service the client is connected to, omitted unless it fails over between several services
+	URL string `json:"url,omitempty"`
+}
+
+type peerOutput struct {
+	PubKey        string     `json:"pubKey"`
+	Name          string     `json:"name"`
+	ConnStatus    string     `json:"connStatus"`
+	ConnType      string     `json:"connType"`
+	RelayAddress  string     `json:"relayAddress"`
+	IPFamily      string     `json:"ipFamily"`
+	UpgradedFrom  string     `json:"upgradedFrom"`
+	UpgradedAt    *time.Time `json:"upgradedAt"`
+	LastFailure   string     `json:"lastFailure"`
+	LastHandshake *time.Time `json:"lastHandshake"`
+	// HandshakeAgeSeconds is the number of seconds since the last handshake
+	HandshakeAgeSeconds *int64   `json:"handshakeAgeSeconds"`
+	RttMs               *float64 `json:"rttMs"`
+	Loss                *float64 `json:"loss"`
+	// Trace is the timeline of the latest connection attempt, empty unless the connections are traced
+	Trace []traceOutput `json:"trace"`
+}
+
+type traceOutput struct {
+	Phase string    `json:"phase"`
+	At    time.Time `json:"at"`
+}
+
+type clientUpdateOutput struct {
+	CurrentVersion     string `json:"currentVersion"`
+	MinVersion         string `json:"minVersion"`
+	RecommendedVersion string `json:"recommendedVersion"`
+	DownloadURL        string `json:"downloadUrl"`
+}
+
+// toStatusOutput converts the daemon status response, the handshake ages are computed relative to now
+func toStatusOutput(resp *proto.StatusResponse, now time.Time) statusOutput {
+	output := statusOutput{
+		Status:              resp.GetStatus(),
+		WgPort:              resp.GetWgPort(),
+		AvailableUpdate:     resp.GetAvailableUpdate(),
+		RejectedEndpoints:   resp.GetRejectedEndpoints(),
+		ExitNode:            resp.GetExitNode(),
+		ExitNodeActive:      resp.GetExitNodeActive(),
+		WgMode:              resp.GetWgMode(),
+		Management:          toStreamOutput(resp.GetManagement()),
+		Signal:              toStreamOutput(resp.GetSignal()),
+		Relays:              []string{},
+		Peers:               []peerOutput{},
+		NetworkRoutes:       []networkRouteOutput{},
+		AllowedIPsConflicts: []allowedIPsConflictOutput{},
+	}
+	output.Relays = append(output.Relays, resp.GetRelays()...)
+	if resp.GetRelaysExpireAt() != nil {
+		relaysExpireAt := resp.GetRelaysExpireAt().AsTime()
+		output.RelaysExpireAt = &relaysExpireAt
+	}
+	if resp.GetWgStatsPolledAt() != nil {
+		wgStatsPolledAt := resp.GetWgStatsPolledAt().AsTime()
+		output.WgStatsPolledAt = &wgStatsPolledAt
+	}
+	for _, route := range resp.GetNetworkRoutes() {
+		output.NetworkRoutes = append(output.NetworkRoutes, networkRouteOutput{
+			Network: route.GetNetwork(),
+			Peer:    route.GetPeer(),
+			Peers:   append([]string{}, route.GetPeers()...),
+		})
+	}
+	for _, conflict := range resp.GetAllowedIpsConflicts() {
+		output.AllowedIPsConflicts = append(output.AllowedIPsConflicts, allowedIPsConflictOutput{
+			Prefix:            conflict.GetPrefix(),
+			Peer:              conflict.GetPeer(),
+			ConflictingPrefix: conflict.GetConflictingPrefix(),
+			ConflictingPeer:   conflict.GetConflictingPeer(),
+			Duplicate:         conflict.GetDuplicate(),
+		})
+	}
+	output.ConnFailures = make(map[string]int64, len(resp.GetConnFailures()))
+	for class, count := range resp.GetConnFailures() {
+		output.ConnFailures[class] = count
+	}
+
+	if clientUpdate := resp.GetClientUpdate(); clientUpdate != nil {
+		output.ClientUpdate = &clientUpdateOutput{
+			CurrentVersion:     clientUpdate.GetCurrentVersion(),
+			MinVersion:         clientUpdate.GetMinVersion(),
+			RecommendedVersion: clientUpdate.GetRecommendedVersion(),
+			DownloadURL:        clientUpdate.GetDownloadUrl(),
+		}
+	}
+
+	probes := make(map[string]*proto.PeerProbe, len(resp.GetPeerProbes()))
+	for _, probe := range resp.GetPeerProbes() {
+		probes[probe.GetPubKey()] = probe
+	}
+
+	for _, peer := range resp.GetPeers() {
+		peerOut := peerOutput{
+			PubKey:       peer.GetPubKey(),
+			Name:         peer.GetName(),
+			ConnStatus:   peer.GetConnStatus(),
+			ConnType:     peer.GetConnType(),
+			RelayAddress: peer.GetRelayAddress(),
+			IPFamily:     peer.GetIpFamily(),
+			UpgradedFrom: peer.GetUpgradedFrom(),
+			LastFailure:  peer.GetLastFailure(),
+			Trace:        []traceOutput{},
+		}
+		for _, event := range peer.GetTrace() {
+			peerOut.Trace = append(peerOut.Trace, traceOutput{Phase: event.GetPhase(), At: event.GetAt().AsTime()})
+		}
+		if peer.GetUpgradedAt() != nil {
+			upgradedAt := peer.GetUpgradedAt().AsTime()
+			peerOut.UpgradedAt = &upgradedAt
+		}
+		if peer.GetLastHandshake() != nil {
+			lastHandshake := peer.GetLastHandshake().AsTime()
+			age := int64(now.Sub(lastHandshake) / time.Second)
+			peerOut.LastHandshake = &lastHandshake
+			peerOut.HandshakeAgeSeconds = &age
+		}
+		if probe, ok := probes[peer.GetPubKey()]; ok {
+			rtt, loss := probe.GetRttMs(), probe.GetLoss()
+			peerOut.RttMs = &rtt
+			peerOut.Loss = &loss
+		}
+		output.Peers = append(output.Peers, peerOut)
+	}
+
+	return output
+}
+
+func toStreamOutput(state *proto.StreamState) *streamOutput {
+	if state == nil {
+		return nil
+	}
+	output := &streamOutput{Connected: state.GetConnected(), URL: state.GetUrl()}
+	if state.GetSince() != nil {
+		since := state.GetSince().AsTime()
+		output.Since = &since
+	}
+	return output
+}
+
+// printStatus prints the status in a human readable form with a table of the peers
+func printStatus(cmd *cobra.Command, output statusOutput) {
+	cmd.Printf("Status: %s\n", output.Status)
+	if output.WgPort != 0 {
+		cmd.Printf("Wireguard port: %d\n", output.WgPort)
+	}
+	if output.WgMode != "" {
+		cmd.Printf("Wireguard mode: %s\n", output.WgMode)
+	}
+	if output.WgStatsPolledAt != nil {
+		cmd.Printf("Wireguard statistics read at: %s\n", output.WgStatsPolledAt.Local().Format(time.RFC3339))
+	}
+	if output.Management != nil {
+		cmd.Printf("Management: %s\n", streamLabel(output.Management))
+	}
+	if output.Signal != nil {
+		cmd.Printf("Signal: %s\n", streamLabel(output.Signal))
+	}
+	if len(output.Relays) > 0 {
+		cmd.Printf("Relays: %s\n", strings.Join(output.Relays, ", "))
+		if output.RelaysExpireAt != nil {
+			cmd.Printf("Relay credentials expire at: %s\n", output.RelaysExpireAt.Local().Format(time.RFC3339))
+		}
+	}
+	if len(output.ConnFailures) > 0 {
+		cmd.Printf("Failed connection attempts: %s\n", failuresLabel(output.ConnFailures))
+	}
+	if output.RejectedEndpoints > 0 {
+		cmd.Printf("Rejected peer endpoints: %d\n", output.RejectedEndpoints)
+	}
+	if output.ExitNode != "" {
+		exitNodeName := ""
+		for _, peer := range output.Peers {
+			if peer.PubKey == output.ExitNode {
+				exitNodeName = peer.Name
+			}
+		}
+		exitNodeState := "inactive"
+		if output.ExitNodeActive {
+			exitNodeState = "active"
+		}
+		cmd.Printf("Exit node: %s (%s)\n", peerLabel(output.ExitNode, exitNodeName), exitNodeState)
+	}
+	peerNames := make(map[string]string, len(output.Peers))
+	for _, peer := range output.Peers {
+		peerNames[peer.PubKey] = peer.Name
+	}
+	if len(output.NetworkRoutes) > 0 {
+		cmd.Println("Network routes:")
+		for _, route := range output.NetworkRoutes {
+			cmd.Printf("  %s %s\n", route.Network, networkRouteLabel(route, peerNames))
+		}
+	}
+	if len(output.AllowedIPsConflicts) > 0 {
+		cmd.Println("Allowed IPs conflicts:")
+		for _, conflict := range output.AllowedIPsConflicts {
+			cmd.Printf("  %s\n", allowedIPsConflictLabel(conflict, peerNames))
+		}
+	}
+	cmd.Println()
+
+	if output.AvailableUpdate != "" {
+		cmd.Printf("Update available: %s, run netbird update to install it\n\n", output.AvailableUpdate)
+	}
+
+	clientUpdate := output.ClientUpdate
+	if clientUpdate != nil && clientUpdate.RecommendedVersion != "" && clientUpdate.RecommendedVersion != clientUpdate.CurrentVersion {
+		cmd.Printf("A new client version is recommended by the Management Service: %s (running %s)\n",
+			clientUpdate.RecommendedVersion, clientUpdate.CurrentVersion)
+		if clientUpdate.MinVersion != "" {
+			cmd.Printf("Minimal supported version: %s\n", clientUpdate.MinVersion)
+		}
+		cmd.Printf("Download it from: %s\n\n", clientUpdate.DownloadURL)
+	}
+
+	if len(output.Peers) > 0 {
+		cmd.Println("Peers:")
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, " PEER\tSTATUS\tTYPE\tFAMILY\tHANDSHAKE\tRTT\tLOSS") //nolint
+		for _, peer := range output.Peers {
+			connType := valueOrDash(peer.ConnType)
+			if peer.RelayAddress != "" {
+				connType = fmt.Sprintf("%s (%s)", peer.ConnType, peer.RelayAddress)
+			}
+			if peer.ConnType == string(internalPeer.ConnTypeSignalRelayed) {
+				connType = fmt.Sprintf("%s (degraded)", peer.ConnType)
+			}
+			if peer.UpgradedFrom != "" && peer.UpgradedAt != nil {
+				connType = fmt.Sprintf("%s (upgraded from %s at %s)", peer.ConnType, peer.UpgradedFrom,
+					peer.UpgradedAt.Local().Format(time.RFC3339))
+			}
+			handshake := "-"
+			if peer.HandshakeAgeSeconds != nil {
+				handshake = fmt.Sprintf("%s ago", time.Duration(*peer.HandshakeAgeSeconds)*time.Second)
+			}
+			rtt, loss := "-", "-"
+			if peer.RttMs != nil {
+				rtt = fmt.Sprintf("%.2fms", *peer.RttMs)
+				loss = fmt.Sprintf("%.0f%%", *peer.Loss*100)
+			}
+			connStatus := valueOrDash(peer.ConnStatus)
+			if peer.LastFailure != "" && peer.ConnStatus != "Connected" {
+				connStatus = fmt.Sprintf("%s (%s)", peer.ConnStatus, peer.LastFailure)
+			}
+			fmt.Fprintf(w, " %s\t%s\t%s\t%s\t%s\t%s\t%s\n", peerLabel(peer.PubKey, peer.Name), //nolint
+				connStatus, connType, valueOrDash(peer.IPFamily), handshake, rtt, loss)
+		}
+		_ = w.Flush()
+		cmd.Println()
+
+		for _, peer := range output.Peers {
+			if len(peer.Trace) > 0 {
+				cmd.Printf("Connection trace of %s: %s\n", peerLabel(peer.PubKey, peer.Name), traceLabel(peer.Trace))
+			}
+		}
+	}
+}
+
+// traceLabel returns the phases of a connection trace with the time elapsed since the first one,
+// e.g. "offer-sent +0s, answer-received +120ms, gathering-done +1.5s"
+func traceLabel(trace []traceOutput) string {
+	labels := make([]string, 0, len(trace))
+	for _, event := range trace {
+		elapsed := event.At.Sub(trace[0].At).Round(time.Millisecond)
+		labels = append(labels, fmt.Sprintf("%s +%s", event.Phase, elapsed))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// failuresLabel returns the failed connection attempt counts sorted by the failure class, e.g. "ice-failed 2, no-candidates 1"
+func failuresLabel(failures map[string]int64) string {
+	classes := make([]string, 0, len(failures))
+	for class := range failures {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	labels := make([]string, 0, len(classes))
+	for _, class := range classes {
+		labels = append(labels, fmt.Sprintf("%s %d", class, failures[class]))
+	}
+	return strings.Join(labels, ", ")
+}
+
+// streamLabel returns a human readable status of a stream
+func streamLabel(stream *streamOutput) string {
+	label := "disconnected"
+	if stream.Connected {
+		label = "connected"
+	}
+	if stream.Since != nil {
+		label = fmt.Sprintf("%s since %s", label, stream.Since.Local().Format(time.RFC3339))
+	}
+	if stream.URL != "" {
+		label = fmt.Sprintf("%s (%s)", label, stream.URL)
+	}
+	return label
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// networkRouteLabel describes the routing peer a network is routed through out of its routing peers
+func networkRouteLabel(route networkRouteOutput, peerNames map[string]string) string {
+	if route.Peer == "" {
+		return fmt.Sprintf("unreachable, none of %d routing peers is connected", len(route.Peers))
+	}
+	return fmt.Sprintf("via %s, %d routing peers", peerLabel(route.Peer, peerNames[route.Peer]), len(route.Peers))
+}
+
+// allowedIPsConflictLabel describes an allowed IP refused to a peer because of a conflict with another peer
+func allowedIPsConflictLabel(conflict allowedIPsConflictOutput, peerNames map[string]string) string {
+	kind := "overlaps with"
+	if conflict.Duplicate {
+		kind = "duplicates"
+	}
+	return fmt.Sprintf("%s of %s refused, %s %s of %s", conflict.ConflictingPrefix,
+		peerLabel(conflict.ConflictingPeer, peerNames[conflict.ConflictingPeer]), kind, conflict.Prefix,
+		peerLabel(conflict.Peer, peerNames[conflict.Peer]))
+}
+
+// peerLabel returns a human readable label of a remote peer, its public key if the peer has no name
+func peerLabel(pubKey, name string) string {
+	if name == "" {
+		return pubKey
+	}
+	return fmt.Sprintf("%s (%s)", name, pubKey)
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestStatusOutputJSON(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	resp := &proto.StatusResponse{
+		Status:         "Connected",
+		WgPort:         51820,
+		WgMode:         "userspace",
+		Management:     &proto.StreamState{Connected: true, Since: timestamppb.New(now.Add(-time.Hour))},
+		Signal:         &proto.StreamState{Connected: false},
+		Relays:         []string{"turn:turn.wiretrustee.com:3468"},
+		RelaysExpireAt: timestamppb.New(now.Add(time.Hour)),
+		Peers: []*proto.PeerState{
+			{
+				PubKey:        "peerA",
+				Name:          "peer-a",
+				ConnStatus:    "Connected",
+				ConnType:      "relayed",
+				RelayAddress:  "10.0.0.1:3468",
+				IpFamily:      "ipv6",
+				LastHandshake: timestamppb.New(now.Add(-30 * time.Second)),
+				Trace: []*proto.TraceEvent{
+					{Phase: "offer-sent", At: timestamppb.New(now.Add(-time.Minute))},
+					{Phase: "answer-received", At: timestamppb.New(now.Add(-time.Minute + 120*time.Millisecond))},
+				},
+			},
+			{PubKey: "peerB", Name: "peer-b", ConnStatus: "Connecting", LastFailure: "ice-failed"},
+		},
+		PeerProbes:      []*proto.PeerProbe{{PubKey: "peerA", RttMs: 12.5, Loss: 0.1}},
+		ConnFailures:    map[string]int64{"ice-failed": 2},
+		AvailableUpdate: "v0.9.1",
+		NetworkRoutes: []*proto.NetworkRouteState{
+			{Network: "192.168.10.0/24", Peer: "peerA", Peers: []string{"peerB", "peerA"}},
+		},
+		AllowedIpsConflicts: []*proto.AllowedIPsConflictState{
+			{Prefix: "10.10.0.0/16", Peer: "peerA", ConflictingPrefix: "10.10.0.0/16", ConflictingPeer: "peerB", Duplicate: true},
+		},
+		WgStatsPolledAt: timestamppb.New(now.Add(-time.Second)),
+	}
+
+	data, err := json.Marshal(toStatusOutput(resp, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var output map[string]interface{}
+	err = json.Unmarshal(data, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"status", "wgPort", "wgMode", "management", "signal", "relays", "relaysExpireAt", "peers", "clientUpdate", "connFailures", "availableUpdate", "rejectedEndpoints", "exitNode", "exitNodeActive", "networkRoutes", "allowedIpsConflicts", "wgStatsPolledAt"} {
+		if _, ok := output[key]; !ok {
+			t.Errorf("expecting key %s in the status output %s", key, data)
+		}
+	}
+
+	if output["availableUpdate"] != "v0.9.1" {
+		t.Errorf("expecting the available update v0.9.1, got %v", output["availableUpdate"])
+	}
+
+	signal := output["signal"].(map[string]interface{})
+	if signal["connected"] != false || signal["since"] != nil {
+		t.Errorf("expecting a disconnected signal stream without a timestamp, got %v", signal)
+	}
+
+	peers := output["peers"].([]interface{})
+	if len(peers) != 2 {
+		t.Fatalf("expecting 2 peers, got %d", len(peers))
+	}
+
+	connected := peers[0].(map[string]interface{})
+	if connected["connType"] != "relayed" || connected["relayAddress"] != "10.0.0.1:3468" {
+		t.Errorf("expecting a relayed connection through 10.0.0.1:3468, got %v", connected)
+	}
+	if connected["ipFamily"] != "ipv6" {
+		t.Errorf("expecting an ipv6 connection, got %v", connected)
+	}
+	if connected["handshakeAgeSeconds"] != float64(30) || connected["rttMs"] != 12.5 {
+		t.Errorf("expecting a handshake 30 seconds ago and a 12.5ms rtt, got %v", connected)
+	}
+
+	if trace := connected["trace"].([]interface{}); len(trace) != 2 {
+		t.Errorf("expecting a trace of 2 phases, got %v", trace)
+	}
+
+	connecting := peers[1].(map[string]interface{})
+	if connecting["lastFailure"] != "ice-failed" {
+		t.Errorf("expecting the last connection attempt to have failed with ice-failed, got %v", connecting)
+	}
+	for _, key := range []string{"lastHandshake", "handshakeAgeSeconds", "rttMs", "loss"} {
+		value, ok := connecting[key]
+		if !ok || value != nil {
+			t.Errorf("expecting key %s of a connecting peer to be null, got %v", key, connecting)
+		}
+	}
+}
+
+func TestNetworkRouteLabel(t *testing.T) {
+	peerNames := map[string]string{"peerA": "gateway-a"}
+
+	route := networkRouteOutput{Network: "192.168.10.0/24", Peer: "peerA", Peers: []string{"peerA", "peerB"}}
+	expected := "via gateway-a (peerA), 2 routing peers"
+	if label := networkRouteLabel(route, peerNames); label != expected {
+		t.Errorf("expecting network route label %q, got %q", expected, label)
+	}
+
+	route.Peer = ""
+	expected = "unreachable, none of 2 routing peers is connected"
+	if label := networkRouteLabel(route, peerNames); label != expected {
+		t.Errorf("expecting network route label %q, got %q", expected, label)
+	}
+}
+
+func TestAllowedIPsConflictLabel(t *testing.T) {
+	peerNames := map[string]string{"peerA": "gateway-a"}
+
+	conflict := allowedIPsConflictOutput{Prefix: "10.10.0.0/16", Peer: "peerA", ConflictingPrefix: "10.10.0.0/16",
+		ConflictingPeer: "peerB", Duplicate: true}
+	expected := "10.10.0.0/16 of peerB refused, duplicates 10.10.0.0/16 of gateway-a (peerA)"
+	if label := allowedIPsConflictLabel(conflict, peerNames); label != expected {
+		t.Errorf("expecting allowed IPs conflict label %q, got %q", expected, label)
+	}
+
+	conflict.ConflictingPrefix, conflict.Duplicate = "10.10.1.0/24", false
+	expected = "10.10.1.0/24 of peerB refused, overlaps with 10.10.0.0/16 of gateway-a (peerA)"
+	if label := allowedIPsConflictLabel(conflict, peerNames); label != expected {
+		t.Errorf("expecting allowed IPs conflict label %q, got %q", expected, label)
+	}
+}
+
+func TestTraceLabel(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	trace := []traceOutput{
+		{Phase: "offer-sent", At: start},
+		{Phase: "answer-received", At: start.Add(120 * time.Millisecond)},
+		{Phase: "handshake-complete", At: start.Add(8 * time.Second)},
+	}
+
+	expected := "offer-sent +0s, answer-received +120ms, handshake-complete +8s"
+	if label := traceLabel(trace); label != expected {
+		t.Errorf("expecting trace label %q, got %q", expected, label)
+	}
+}
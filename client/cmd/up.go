@@ -12,6 +12,9 @@ import (
 	gstatus "google.golang.org/grpc/status"
 )
 
+// exitNode is the peer selected with the --exit-node flag of the up command
+var exitNode string
+
 var upCmd = &cobra.Command{
 	Use:   "up",
 	Short: "install, login and start Netbird client",
@@ -20,7 +23,7 @@ var upCmd = &cobra.Command{
 
 		cmd.SetOut(cmd.OutOrStdout())
 
-		err := util.InitLog(logLevel, "console")
+		err := util.InitLog(logLevel, "console", logFormat)
 		if err != nil {
 			return fmt.Errorf("failed initializing log %v", err)
 		}
@@ -38,6 +41,9 @@ var upCmd = &cobra.Command{
 			if err != nil {
 				return fmt.Errorf("get config file: %v", err)
 			}
+			if cmd.Flag("exit-node").Changed {
+				config.ExitNode = exitNode
+			}
 
 			err = foregroundLogin(ctx, cmd, config, setupKey)
 			if err != nil {
@@ -71,15 +77,27 @@ var upCmd = &cobra.Command{
 			return fmt.Errorf("unable to get daemon status: %v", err)
 		}
 
+		upRequest := &proto.UpRequest{}
+		if cmd.Flag("exit-node").Changed {
+			upRequest.ExitNode = &exitNode
+		}
+
 		if status.Status == string(internal.StatusConnected) {
-			cmd.Println("Already connected")
-			return nil
+			if upRequest.ExitNode == nil {
+				cmd.Println("Already connected")
+				return nil
+			}
+			// the exit node is applied when the client connects
+			if _, err := client.Down(ctx, &proto.DownRequest{}); err != nil {
+				return fmt.Errorf("call service down method: %v", err)
+			}
 		}
 
 		loginRequest := proto.LoginRequest{
 			SetupKey:      setupKey,
 			PreSharedKey:  preSharedKey,
 			ManagementUrl: managementURL,
+			ReRegister:    reRegister,
 		}
 
 		var loginErr error
@@ -91,6 +109,8 @@ var upCmd = &cobra.Command{
 			loginResp, backOffErr = client.Login(ctx, &loginRequest)
 			if s, ok := gstatus.FromError(backOffErr); ok && (s.Code() == codes.InvalidArgument ||
 				s.Code() == codes.PermissionDenied ||
+				s.Code() == codes.ResourceExhausted ||
+				s.Code() == codes.AlreadyExists ||
 				s.Code() == codes.NotFound ||
 				s.Code() == codes.Unimplemented) {
 				loginErr = backOffErr
@@ -108,7 +128,7 @@ var upCmd = &cobra.Command{
 
 		if loginResp.NeedsSSOLogin {
 
-			openURL(cmd, loginResp.VerificationURIComplete)
+			openURL(cmd, loginResp.VerificationURIComplete, loginResp.UserCode)
 
 			_, err = client.WaitSSOLogin(ctx, &proto.WaitSSOLoginRequest{UserCode: loginResp.UserCode})
 			if err != nil {
@@ -116,10 +136,15 @@ var upCmd = &cobra.Command{
 			}
 		}
 
-		if _, err := client.Up(ctx, &proto.UpRequest{}); err != nil {
+		if _, err := client.Up(ctx, upRequest); err != nil {
 			return fmt.Errorf("call service up method: %v", err)
 		}
-		cmd.Println("Connected")
+
+		status, err = client.Status(ctx, &proto.StatusRequest{})
+		if err != nil {
+			return fmt.Errorf("unable to get daemon status: %v", err)
+		}
+		cmd.Printf("Status: %s\n", status.GetStatus())
 		return nil
 	},
 }
@@ -27,6 +27,7 @@ func TestUpDaemon(t *testing.T) {
 	rootCmd.SetArgs([]string{
 		"login",
 		"--daemon-addr", "tcp://" + cliAddr,
+		"--management-url", "http://" + mgmAddr,
 		"--setup-key", "A2C8E62B-38F5-4553-B31E-DD66C696CEBB",
 		"--log-file", "",
 	})
@@ -71,5 +72,29 @@ func TestUpDaemon(t *testing.T) {
 		t.Errorf("expected no error while running up command, got %v", err)
 		return
 	}
-	// we can't check status here, because context already canceled
+	if status, err := state.Status(); err != nil || status != internal.StatusIdle {
+		t.Errorf("wrong status after down: %s, %v", status, err)
+		return
+	}
+
+	config, err := internal.ReadConfig("", "", confPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.DisableAutoConnect {
+		t.Errorf("expecting the down state to be persisted")
+	}
+
+	rootCmd.SetArgs([]string{"down", "--daemon-addr", "tcp://" + cliAddr})
+	if err := rootCmd.Execute(); err != nil {
+		t.Errorf("expected no error while running down command again, got %v", err)
+		return
+	}
+
+	// a restarted daemon doesn't connect until the up command
+	restartedCtx := internal.CtxInitState(context.Background())
+	startClientDaemon(t, restartedCtx, "http://"+mgmAddr, confPath)
+	if status, err := internal.CtxGetState(restartedCtx).Status(); err != nil || status != internal.StatusIdle {
+		t.Errorf("wrong status after restart: %s, %v", status, err)
+	}
 }
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/kardianos/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	gstatus "google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/internal/update"
+	"github.com/netbirdio/netbird/client/system"
+	"github.com/netbirdio/netbird/util"
+)
+
+// updateTimeout limits the check and the download of the latest release
+const updateTimeout = 10 * time.Minute
+
+// updateCheckOnly reports the available update without installing it
+var updateCheckOnly bool
+
+var updateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "updates Netbird to the latest release",
+	Long: "updates Netbird to the latest release of the UpdateReleaseURL set in the config (the project releases by default).\n" +
+		"The release is verified against its checksums, signed with the release signing key embedded in the build " +
+		"or with UpdatePublicKey if it is set in the config. The signature verification is skipped only if UpdateSkipSignature " +
+		"is set in the config. The binary is replaced and the running Netbird service is restarted. " +
+		"The current installation is kept if any step fails",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		SetFlagsFromEnvVars()
+
+		cmd.SetOut(cmd.OutOrStdout())
+
+		err := util.InitLog(logLevel, "console", logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		releaseURL, publicKey, err := updateSettings()
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), updateTimeout)
+		defer cancel()
+		client := &http.Client{}
+
+		release, err := update.LatestRelease(ctx, client, releaseURL)
+		if err != nil {
+			return err
+		}
+
+		current := system.NetbirdVersion()
+		newer, err := release.NewerThan(current)
+		if err != nil {
+			return fmt.Errorf("failed comparing the running version %s with the latest release %s: %v", current, release.Version, err)
+		}
+		if !newer {
+			cmd.Printf("Netbird %s is up to date\n", current)
+			return nil
+		}
+		if updateCheckOnly {
+			cmd.Printf("Update available: %s (running %s)\n", release.Version, current)
+			return nil
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		executable, err = filepath.EvalSymlinks(executable)
+		if err != nil {
+			return err
+		}
+
+		cmd.Printf("Downloading Netbird %s\n", release.Version)
+		binary, err := update.Download(ctx, client, release, runtime.GOOS, runtime.GOARCH, publicKey, filepath.Dir(executable))
+		if err != nil {
+			return err
+		}
+		// the downloaded binary is gone once it has been installed
+		defer os.Remove(binary) //nolint
+
+		installation, err := update.Install(binary, executable)
+		if err != nil {
+			return err
+		}
+
+		restarted, err := restartService(cmd.Context())
+		if err != nil {
+			if rollbackErr := installation.Rollback(); rollbackErr != nil {
+				return fmt.Errorf("failed restarting Netbird service: %v, restoring Netbird %s failed: %v", err, current, rollbackErr)
+			}
+			if _, restartErr := restartService(cmd.Context()); restartErr != nil {
+				return fmt.Errorf("failed restarting Netbird service: %v, restored Netbird %s but its restart failed: %v",
+					err, current, restartErr)
+			}
+			return fmt.Errorf("failed restarting Netbird service: %v, restored Netbird %s", err, current)
+		}
+		installation.Commit()
+
+		cmd.Printf("Netbird has been updated to %s\n", release.Version)
+		if restarted {
+			cmd.Println("Netbird service has been restarted")
+		}
+		return nil
+	},
+}
+
+// updateSettings returns the release URL and the public key the releases are signed with from the config,
+// the defaults if there is no config
+func updateSettings() (string, ed25519.PublicKey, error) {
+	config, err := internal.ReadConfig("", "", configPath, nil)
+	if s, ok := gstatus.FromError(err); ok && s.Code() == codes.NotFound {
+		publicKey, err := update.PublicKey("", false)
+		return update.DefaultReleaseURL, publicKey, err
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed reading the config %s: %v", configPath, err)
+	}
+
+	releaseURL := config.UpdateReleaseURL
+	if releaseURL == "" {
+		releaseURL = update.DefaultReleaseURL
+	}
+
+	publicKey, err := update.PublicKey(config.UpdatePublicKey, config.UpdateSkipSignature)
+	if err != nil {
+		return "", nil, err
+	}
+	if publicKey == nil {
+		log.Warnf("UpdateSkipSignature is set, the release is verified against its checksums only")
+	}
+	return releaseURL, publicKey, nil
+}
+
+// restartService restarts Netbird service if it is running, so it runs the updated binary.
+// Returns false if the service isn't installed or isn't running
+func restartService(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s, err := newSVC(newProgram(ctx, cancel), newSVCConfig())
+	if err != nil {
+		return false, err
+	}
+
+	status, err := s.Status()
+	if err != nil || status != service.StatusRunning {
+		return false, nil
+	}
+
+	return true, s.Restart()
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"net/netip"
+	"sort"
+	"strings"
+
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// allowedIPsConflict is an allowed IP of a remote peer refused because another remote peer already has the same or
+// an overlapping one
+type allowedIPsConflict struct {
+	// prefix is the allowed IP of peer that keeps it
+	prefix string
+	peer   string
+	// conflictingPrefix is the allowed IP refused to conflictingPeer, equal to prefix for a duplicate
+	conflictingPrefix string
+	conflictingPeer   string
+	// duplicate distinguishes the same prefix of both peers from different but overlapping prefixes
+	duplicate bool
+}
+
+// allowedIPClaim is an allowed IP of a remote peer of a NetworkMap update
+type allowedIPClaim struct {
+	peer   string
+	prefix string
+	// network is the prefix with the host bits masked, as Wireguard applies it
+	network netip.Prefix
+	// owned indicates that the connection to the peer already has the allowed IP
+	owned bool
+}
+
+// withoutAllowedIPsConflicts returns the remote peers of the update without the allowed IPs that are the same as or
+// overlap with an allowed IP of another remote peer: Wireguard silently moves a duplicate allowed IP to the last
+// configured peer and sends the traffic of an overlapping one to the peer with the longest prefix.
+// The peer already having a conflicting allowed IP keeps it, otherwise the first peer of the update does.
+// The peers left without allowed IPs are dropped. Returns the refused allowed IPs as conflicts
+func (e *Engine) withoutAllowedIPsConflicts(peersUpdate []*mgmProto.RemotePeerConfig) ([]*mgmProto.RemotePeerConfig, []allowedIPsConflict) {
+	claims := make([]allowedIPClaim, 0, len(peersUpdate))
+	for _, p := range peersUpdate {
+		conn, connected := e.peerConns[p.GetWgPubKey()]
+		for _, allowedIP := range p.GetAllowedIps() {
+			network, err := netip.ParsePrefix(allowedIP)
+			if err != nil {
+				// left to fail when the peer is configured
+				continue
+			}
+			claims = append(claims, allowedIPClaim{
+				peer:    p.GetWgPubKey(),
+				prefix:  allowedIP,
+				network: network.Masked(),
+				owned:   connected && hasAllowedIP(conn.GetAllowedIPs(), allowedIP),
+			})
+		}
+	}
+	sort.SliceStable(claims, func(i, j int) bool {
+		return claims[i].owned && !claims[j].owned
+	})
+
+	// two single IP prefixes overlap only when they are the same, so a single IP prefix is compared by the range
+	// only with the accepted network prefixes
+	accepted := make(map[netip.Prefix]allowedIPClaim, len(claims))
+	acceptedClaims := make([]allowedIPClaim, 0, len(claims))
+	var acceptedNetworks []allowedIPClaim
+	var conflicts []allowedIPsConflict
+	var refused map[string]map[string]struct{}
+	for _, claim := range claims {
+		owner, found := accepted[claim.network]
+		if !found || owner.peer == claim.peer {
+			compared := acceptedNetworks
+			if !claim.network.IsSingleIP() {
+				compared = acceptedClaims
+			}
+			owner, found = overlappingClaim(compared, claim)
+		}
+		if !found {
+			accepted[claim.network] = claim
+			acceptedClaims = append(acceptedClaims, claim)
+			if !claim.network.IsSingleIP() {
+				acceptedNetworks = append(acceptedNetworks, claim)
+			}
+			continue
+		}
+
+		conflicts = append(conflicts, allowedIPsConflict{
+			prefix:            owner.prefix,
+			peer:              owner.peer,
+			conflictingPrefix: claim.prefix,
+			conflictingPeer:   claim.peer,
+			duplicate:         owner.network == claim.network,
+		})
+		if refused == nil {
+			refused = map[string]map[string]struct{}{}
+		}
+		if refused[claim.peer] == nil {
+			refused[claim.peer] = map[string]struct{}{}
+		}
+		refused[claim.peer][claim.prefix] = struct{}{}
+	}
+	if len(conflicts) == 0 {
+		return peersUpdate, nil
+	}
+
+	update := make([]*mgmProto.RemotePeerConfig, 0, len(peersUpdate))
+	for _, p := range peersUpdate {
+		refusedIPs, ok := refused[p.GetWgPubKey()]
+		if !ok {
+			update = append(update, p)
+			continue
+		}
+		p = proto.Clone(p).(*mgmProto.RemotePeerConfig)
+		var allowedIPs []string
+		for _, allowedIP := range p.GetAllowedIps() {
+			if _, ok := refusedIPs[allowedIP]; !ok {
+				allowedIPs = append(allowedIPs, allowedIP)
+			}
+		}
+		if len(allowedIPs) == 0 {
+			log.Warnf("all allowed IPs of peer %s conflict with other peers, not connecting to it", p.GetWgPubKey())
+			continue
+		}
+		p.AllowedIps = allowedIPs
+		update = append(update, p)
+	}
+	return update, conflicts
+}
+
+// updateAllowedIPsConflicts records the conflicting allowed IPs of the latest NetworkMap and reports them to the
+// Management Service when they have changed. The caller holds the lock
+func (e *Engine) updateAllowedIPsConflicts(conflicts []allowedIPsConflict) {
+	if allowedIPsConflictsEqual(e.allowedIPsConflicts, conflicts) {
+		return
+	}
+	for _, conflict := range conflicts {
+		kind := "overlaps with"
+		if conflict.duplicate {
+			kind = "duplicates"
+		}
+		log.Warnf("refused allowed IP %s of peer %s: it %s allowed IP %s of peer %s", conflict.conflictingPrefix,
+			conflict.conflictingPeer, kind, conflict.prefix, conflict.peer)
+	}
+	if len(conflicts) == 0 {
+		log.Infof("conflicts of the allowed IPs of the remote peers have been resolved")
+	}
+	e.allowedIPsConflicts = conflicts
+
+	e.sendFeedback(toProtoAllowedIPsConflicts(conflicts))
+}
+
+// toProtoAllowedIPsConflicts converts the conflicting allowed IPs to the ones reported to the Management Service
+func toProtoAllowedIPsConflicts(conflicts []allowedIPsConflict) []*mgmProto.AllowedIPsConflict {
+	var result []*mgmProto.AllowedIPsConflict
+	for _, conflict := range conflicts {
+		conflictType := mgmProto.AllowedIPsConflict_OVERLAP
+		if conflict.duplicate {
+			conflictType = mgmProto.AllowedIPsConflict_DUPLICATE
+		}
+		result = append(result, &mgmProto.AllowedIPsConflict{
+			Type:              conflictType,
+			Prefix:            conflict.prefix,
+			Peer:              conflict.peer,
+			ConflictingPrefix: conflict.conflictingPrefix,
+			ConflictingPeer:   conflict.conflictingPeer,
+		})
+	}
+	return result
+}
+
+// allowedIPsConflictsEqual returns true if both lists hold the same conflicts in the same order
+func allowedIPsConflictsEqual(a, b []allowedIPsConflict) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hasAllowedIP returns true if the comma separated allowed IPs of a connection have the allowed IP
+func hasAllowedIP(joined string, allowedIP string) bool {
+	for joined != "" {
+		var current string
+		current, joined, _ = strings.Cut(joined, ",")
+		if current == allowedIP {
+			return true
+		}
+	}
+	return false
+}
+
+// overlappingClaim returns the first of the claims of another peer overlapping with the claim
+func overlappingClaim(claims []allowedIPClaim, claim allowedIPClaim) (allowedIPClaim, bool) {
+	for _, candidate := range claims {
+		if candidate.peer != claim.peer && candidate.network.Overlaps(claim.network) {
+			return candidate, true
+		}
+	}
+	return allowedIPClaim{}, false
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newAllowedIPsTestEngine(t *testing.T, mgmtClient *mgmt.MockClient) *Engine {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun113",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33113,
+	})
+}
+
+func TestEngine_WithoutAllowedIPsConflicts(t *testing.T) {
+	engine := newAllowedIPsTestEngine(t, &mgmt.MockClient{})
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys = append(keys, key.PublicKey().String())
+	}
+	peerA, peerB, peerC, peerD, peerE := keys[0], keys[1], keys[2], keys[3], keys[4]
+
+	// peerB has had 10.10.0.0/16 before peerA has been given the same prefix
+	conn, err := engine.createPeerConn(peerB, "100.64.0.11/32,10.10.0.0/16", 0)
+	require.NoError(t, err)
+	engine.peerConns[peerB] = conn
+
+	update := []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: peerA, AllowedIps: []string{"100.64.0.10/32", "10.10.0.0/16", "192.168.0.0/16"}},
+		{WgPubKey: peerB, AllowedIps: []string{"100.64.0.11/32", "10.10.0.0/16"}},
+		{WgPubKey: peerC, AllowedIps: []string{"100.64.0.12/32", "192.168.10.0/24"}},
+		{WgPubKey: peerD, AllowedIps: []string{"100.64.0.10/32"}},
+		{WgPubKey: peerE, AllowedIps: []string{"100.64.0.14/32", "10.10.1.1/32"}},
+	}
+
+	peers, conflicts := engine.withoutAllowedIPsConflicts(update)
+
+	assert.Equal(t, []allowedIPsConflict{
+		{prefix: "10.10.0.0/16", peer: peerB, conflictingPrefix: "10.10.0.0/16", conflictingPeer: peerA, duplicate: true},
+		{prefix: "192.168.0.0/16", peer: peerA, conflictingPrefix: "192.168.10.0/24", conflictingPeer: peerC},
+		{prefix: "100.64.0.10/32", peer: peerA, conflictingPrefix: "100.64.0.10/32", conflictingPeer: peerD, duplicate: true},
+		{prefix: "10.10.0.0/16", peer: peerB, conflictingPrefix: "10.10.1.1/32", conflictingPeer: peerE},
+	}, conflicts)
+
+	// peerD is left without allowed IPs
+	allowedIPs := map[string][]string{}
+	for _, p := range peers {
+		allowedIPs[p.GetWgPubKey()] = p.GetAllowedIps()
+	}
+	assert.Equal(t, map[string][]string{
+		peerA: {"100.64.0.10/32", "192.168.0.0/16"},
+		peerB: {"100.64.0.11/32", "10.10.0.0/16"},
+		peerC: {"100.64.0.12/32"},
+		peerE: {"100.64.0.14/32"},
+	}, allowedIPs)
+
+	// the update itself isn't modified
+	assert.Equal(t, []string{"100.64.0.10/32", "10.10.0.0/16", "192.168.0.0/16"}, update[0].GetAllowedIps())
+
+	// an update without conflicts is returned as is
+	update = update[1:3]
+	update[1] = &mgmtProto.RemotePeerConfig{WgPubKey: peerC, AllowedIps: []string{"100.64.0.12/32", "10.20.0.0/16"}}
+	peers, conflicts = engine.withoutAllowedIPsConflicts(update)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, update, peers)
+}
+
+func TestEngine_ReportAllowedIPsConflicts(t *testing.T) {
+	reports := make(chan *mgmtProto.FeedbackRequest, 10)
+	engine := newAllowedIPsTestEngine(t, &mgmt.MockClient{
+		SendFeedbackFunc: func(feedback *mgmtProto.FeedbackRequest) error {
+			reports <- feedback
+			return nil
+		},
+	})
+
+	nextReport := func() *mgmtProto.FeedbackRequest {
+		select {
+		case feedback := <-reports:
+			return feedback
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the conflicts to be reported to the Management Service")
+			return nil
+		}
+	}
+
+	conflicts := []allowedIPsConflict{
+		{prefix: "10.10.0.0/16", peer: "peerB", conflictingPrefix: "10.10.0.0/16", conflictingPeer: "peerA", duplicate: true},
+		{prefix: "192.168.0.0/16", peer: "peerA", conflictingPrefix: "192.168.10.0/24", conflictingPeer: "peerC"},
+	}
+	engine.syncMsgMux.Lock()
+	engine.updateAllowedIPsConflicts(conflicts)
+	engine.syncMsgMux.Unlock()
+
+	feedback := nextReport()
+	require.Len(t, feedback.GetAllowedIpsConflicts(), 2)
+	duplicate, overlap := feedback.GetAllowedIpsConflicts()[0], feedback.GetAllowedIpsConflicts()[1]
+	assert.Equal(t, mgmtProto.AllowedIPsConflict_DUPLICATE, duplicate.GetType())
+	assert.Equal(t, "peerA", duplicate.GetConflictingPeer())
+	assert.Equal(t, mgmtProto.AllowedIPsConflict_OVERLAP, overlap.GetType())
+	assert.Equal(t, "192.168.10.0/24", overlap.GetConflictingPrefix())
+	assert.Equal(t, "192.168.0.0/16", overlap.GetPrefix())
+
+	// the same conflicts of the next NetworkMap aren't reported again
+	engine.syncMsgMux.Lock()
+	engine.updateAllowedIPsConflicts(append([]allowedIPsConflict{}, conflicts...))
+	engine.syncMsgMux.Unlock()
+	select {
+	case feedback := <-reports:
+		t.Fatalf("expected unchanged conflicts not to be reported again, got %v", feedback)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// the resolved conflicts are cleared
+	engine.syncMsgMux.Lock()
+	engine.updateAllowedIPsConflicts(nil)
+	engine.syncMsgMux.Unlock()
+	assert.Empty(t, nextReport().GetAllowedIpsConflicts())
+}
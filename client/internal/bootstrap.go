@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgm "github.com/netbirdio/netbird/management/client"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+)
+
+// bootstrapLoginMaxInterval is the longest interval between the login attempts of a client started from the
+// bootstrap bundle while the Management Service is unreachable
+var bootstrapLoginMaxInterval = 30 * time.Second
+
+// readBootstrapBundle reads a bootstrap bundle exported by the Management Service, the SyncResponse sealed for our peer
+// with the key of the Management Service. The bundle is rejected unless it has been sealed with the server key
+func readBootstrapBundle(path string, serverKey wgtypes.Key, ourPrivateKey wgtypes.Key) (*mgmProto.SyncResponse, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &mgmProto.EncryptedMessage{}
+	if err := proto.Unmarshal(content, bundle); err != nil {
+		return nil, fmt.Errorf("not a bootstrap bundle: %v", err)
+	}
+	if bundle.GetWgPubKey() != serverKey.String() {
+		return nil, fmt.Errorf("bundle is sealed with key %s, expecting the key of the Management Service %s",
+			bundle.GetWgPubKey(), serverKey.String())
+	}
+
+	// the bundle can only be opened with the key of the Management Service it has been sealed with and our key
+	update := &mgmProto.SyncResponse{}
+	if err := encryption.DecryptMessage(serverKey, ourPrivateKey, bundle.GetBody(), update); err != nil {
+		return nil, fmt.Errorf("bundle isn't sealed for this peer by the Management Service: %v", err)
+	}
+	return update, nil
+}
+
+// readBootstrap reads the bootstrap bundle of the config, nil if it isn't set or can't be used.
+// The Engine comes up without the bundle then and waits for the NetworkMap of the Management Service
+func (e *Engine) readBootstrap() *mgmProto.SyncResponse {
+	if e.config.BootstrapFile == "" {
+		return nil
+	}
+	// the key reported by an unauthenticated Management Service can't vouch for the bundle
+	if e.config.BootstrapServerKey == nil {
+		log.Errorf("ignoring bootstrap bundle %s, the key of the Management Service it is sealed with isn't set",
+			e.config.BootstrapFile)
+		return nil
+	}
+
+	update, err := readBootstrapBundle(e.config.BootstrapFile, *e.config.BootstrapServerKey, e.config.WgPrivateKey)
+	if err != nil {
+		log.Errorf("ignoring bootstrap bundle %s: %v", e.config.BootstrapFile, err)
+		return nil
+	}
+	return update
+}
+
+// readConfigBootstrap reads the bootstrap bundle of the config sealed with BootstrapServerKey, nil if it isn't set
+// or can't be used. The client connects to the Management Service before starting the Engine then
+func readConfigBootstrap(config *Config, ourPrivateKey wgtypes.Key) *mgmProto.SyncResponse {
+	if config.BootstrapFile == "" || config.BootstrapServerKey == "" {
+		return nil
+	}
+	serverKey, err := wgtypes.ParseKey(config.BootstrapServerKey)
+	if err != nil {
+		log.Errorf("ignoring bootstrap bundle %s, invalid bootstrap server key: %v", config.BootstrapFile, err)
+		return nil
+	}
+
+	update, err := readBootstrapBundle(config.BootstrapFile, serverKey, ourPrivateKey)
+	if err != nil {
+		log.Errorf("ignoring bootstrap bundle %s: %v", config.BootstrapFile, err)
+		return nil
+	}
+	if update.GetPeerConfig().GetAddress() == "" || update.GetWiretrusteeConfig().GetSignal().GetUri() == "" {
+		log.Errorf("ignoring bootstrap bundle %s without the address of the peer or the Signal Service", config.BootstrapFile)
+		return nil
+	}
+	return update
+}
+
+// connectFromBootstrap creates the clients of the primary Management Service and the Signal Service of the bootstrap
+// bundle without waiting for the connections, so the Engine comes up with the bundle while they are unreachable.
+// The clients connect in the background
+func connectFromBootstrap(ctx context.Context, config *Config, bundle *mgmProto.SyncResponse, ourPrivateKey wgtypes.Key,
+	clientCert *encryption.CertificateReloader) (*mgm.GrpcClient, *signal.GrpcClient, error) {
+	mgmClient, err := mgm.NewLazyClientWithTLS(ctx, config.ManagementURL.Host, ourPrivateKey,
+		serviceTLSConfig(config.ManagementURL.Scheme == "https", clientCert))
+	if err != nil {
+		return nil, nil, wrapError(ErrManagementUnreachable, err)
+	}
+
+	signalConfig := bundle.GetWiretrusteeConfig().GetSignal()
+	signalClient, err := signal.NewLazyClientWithTLS(ctx, signalConfig.GetUri(), ourPrivateKey,
+		serviceTLSConfig(signalConfig.GetProtocol() == mgmProto.HostConfig_HTTPS, clientCert))
+	if err != nil {
+		_ = mgmClient.Close()
+		return nil, nil, wrapError(ErrSignalUnreachable, err)
+	}
+	return mgmClient, signalClient, nil
+}
+
+// loginAfterBootstrap logs in to the Management Service the Engine started from the bootstrap bundle has been waiting
+// for, retrying until it is reachable. The NetworkMap of the bundle is reconciled by the Sync stream, the Engine is
+// stopped if the login is denied and restarted if the bundle points at another Signal Service
+func loginAfterBootstrap(ctx context.Context, cancel context.CancelFunc, mgmClient mgm.Client,
+	bundle *mgmProto.SyncResponse, labels map[string]string) {
+	backOff := backoff.WithContext(&backoff.ExponentialBackOff{
+		InitialInterval:     time.Second,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         bootstrapLoginMaxInterval,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}, ctx)
+
+	var loginResp *mgmProto.LoginResponse
+	operation := func() error {
+		serverKey, err := mgmClient.GetServerPublicKey()
+		if err != nil {
+			log.Debugf("Management Service is still unreachable, running from the bootstrap bundle: %v", err)
+			return err
+		}
+		loginResp, err = mgmClient.Login(*serverKey, systemInfo(ctx, labels))
+		if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
+			return backoff.Permanent(wrapError(ErrLoginRequired, err))
+		}
+		return err
+	}
+	err := backoff.Retry(operation, backOff)
+	if ctx.Err() != nil {
+		return
+	}
+	if errors.Is(err, ErrLoginRequired) {
+		log.Info("peer registration required. Please run `netbird status` for details")
+		CtxGetState(ctx).Set(StatusNeedsLogin)
+		cancel()
+		return
+	}
+	if err != nil {
+		log.Errorf("failed logging in to the Management Service after starting from the bootstrap bundle: %v", err)
+		cancel()
+		return
+	}
+
+	if loginResp.GetWiretrusteeConfig().GetSignal().GetUri() != bundle.GetWiretrusteeConfig().GetSignal().GetUri() {
+		log.Infof("Signal Service has changed since the bootstrap bundle has been exported, reconnecting")
+		_ = CtxGetState(ctx).Wrap(ErrResetConnection)
+		cancel()
+		return
+	}
+	log.Infof("logged in to the Management Service, reconciling the bootstrap bundle with its NetworkMap")
+}
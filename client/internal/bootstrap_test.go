@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/protobuf/proto"
+
+	nbssh "github.com/netbirdio/netbird/client/ssh"
+	"github.com/netbirdio/netbird/encryption"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/netbirdio/netbird/testutil"
+)
+
+// writeBootstrapBundle seals the update for the peer with the sealing key, the bundle claims to be sealed with
+// the claimed key
+func writeBootstrapBundle(t *testing.T, path string, claimed wgtypes.Key, sealing wgtypes.Key, peerKey wgtypes.Key,
+	update *mgmtProto.SyncResponse) {
+	t.Helper()
+	body, err := encryption.EncryptMessage(peerKey.PublicKey(), sealing, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := proto.Marshal(&mgmtProto.EncryptedMessage{WgPubKey: claimed.PublicKey().String(), Body: body})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func generateBootstrapTestKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEngine_BootstrapFile(t *testing.T) {
+	serverKey := generateBootstrapTestKey(t)
+	key := generateBootstrapTestKey(t)
+	path := filepath.Join(t.TempDir(), "bootstrap.bin")
+
+	peer1 := &mgmtProto.RemotePeerConfig{
+		WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+		AllowedIps: []string{"100.64.0.10/32"},
+	}
+	peer2 := &mgmtProto.RemotePeerConfig{
+		WgPubKey:   "LLHf3Ma6z6mdLbriAJbqhX9+nM/B71lgw2+91q3LlhU=",
+		AllowedIps: []string{"100.64.0.11/32"},
+	}
+	peerConfig := &mgmtProto.PeerConfig{Address: "100.64.0.5/24"}
+	writeBootstrapBundle(t, path, serverKey, serverKey, key, &mgmtProto.SyncResponse{
+		PeerConfig: peerConfig,
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:      5,
+			PeerConfig:  peerConfig,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{peer1, peer2},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the Management Service isn't reachable until an update is sent
+	updates := make(chan *mgmtProto.SyncResponse)
+	defer close(updates)
+	mgmClient := &mgmt.MockClient{
+		SyncFunc: func(msgHandler func(msg *mgmtProto.SyncResponse) error) error {
+			for msg := range updates {
+				if err := msgHandler(msg); err != nil {
+					t.Error(err)
+				}
+			}
+			return nil
+		},
+	}
+
+	serverPublicKey := serverKey.PublicKey()
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmClient, &EngineConfig{
+		WgIfaceName:        "utun118",
+		WgPrivateKey:       key,
+		WgPort:             33118,
+		MonitorOnly:        true,
+		BootstrapFile:      path,
+		BootstrapServerKey: &serverPublicKey,
+	})
+	err := engine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := engine.Stop(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the bundle is applied before the Engine has started
+	if peers := engine.GetPeers(); len(peers) != 2 {
+		t.Fatalf("expecting the peers of the bootstrap bundle, got %v", peers)
+	}
+	if engine.config.WgAddr != peerConfig.GetAddress() {
+		t.Errorf("expecting the address %s of the bootstrap bundle, got %s", peerConfig.GetAddress(), engine.config.WgAddr)
+	}
+
+	// the NetworkMap of the Management Service replaces the bundle even with a lower serial of a new epoch
+	updates <- &mgmtProto.SyncResponse{
+		Epoch: 7,
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:      3,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{peer2},
+		},
+	}
+	timeout := time.After(2 * time.Second)
+	for {
+		peers := engine.GetPeers()
+		if len(peers) == 1 && peers[0] == peer2.GetWgPubKey() {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatalf("expecting the bootstrap bundle to be reconciled with the NetworkMap, got %v", peers)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEngine_BootstrapFile_Rejected(t *testing.T) {
+	serverKey := generateBootstrapTestKey(t)
+	otherKey := generateBootstrapTestKey(t)
+	key := generateBootstrapTestKey(t)
+	update := &mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial: 1,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{{
+				WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+				AllowedIps: []string{"100.64.0.10/32"},
+			}},
+		},
+	}
+
+	tt := []struct {
+		name  string
+		write func(path string)
+	}{
+		{
+			name: "AnotherServer",
+			write: func(path string) {
+				writeBootstrapBundle(t, path, otherKey, otherKey, key, update)
+			},
+		},
+		{
+			name: "ForgedServerKey",
+			write: func(path string) {
+				writeBootstrapBundle(t, path, serverKey, otherKey, key, update)
+			},
+		},
+		{
+			name: "AnotherPeer",
+			write: func(path string) {
+				writeBootstrapBundle(t, path, serverKey, serverKey, otherKey, update)
+			},
+		},
+		{
+			name: "NotABundle",
+			write: func(path string) {
+				if err := os.WriteFile(path, []byte("not a bundle"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name:  "Missing",
+			write: func(path string) {},
+		},
+	}
+
+	for i, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bootstrap.bin")
+			tc.write(path)
+
+			serverPublicKey := serverKey.PublicKey()
+			if _, err := readBootstrapBundle(path, serverPublicKey, key); err == nil {
+				t.Fatal("expecting the bundle to be rejected")
+			}
+
+			// the Engine comes up without the bundle
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+				WgIfaceName:        "utun119",
+				WgAddr:             "100.64.0.1/24",
+				WgPrivateKey:       key,
+				WgPort:             33119 + i,
+				MonitorOnly:        true,
+				BootstrapFile:      path,
+				BootstrapServerKey: &serverPublicKey,
+			})
+			if err := engine.Start(); err != nil {
+				t.Fatal(err)
+			}
+			defer engine.Stop() //nolint
+
+			if peers := engine.GetPeers(); len(peers) != 0 {
+				t.Errorf("expecting no peers from a rejected bundle, got %v", peers)
+			}
+		})
+	}
+}
+
+// gatedProxy forwards the TCP connections to the target once opened, until then they are closed right away
+// the way an unreachable service drops them
+type gatedProxy struct {
+	listener net.Listener
+	target   string
+	open     int32
+}
+
+func newGatedProxy(t *testing.T, target string) *gatedProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &gatedProxy{listener: listener, target: target}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go proxy.serve()
+	return proxy
+}
+
+func (p *gatedProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		if atomic.LoadInt32(&p.open) == 0 {
+			_ = conn.Close()
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			target, err := net.Dial("tcp", p.target)
+			if err != nil {
+				return
+			}
+			defer target.Close()
+			go func() {
+				_, _ = io.Copy(target, conn)
+			}()
+			_, _ = io.Copy(conn, target)
+		}()
+	}
+}
+
+func TestRunClient_BootstrapManagementUnreachable(t *testing.T) {
+	bootstrapLoginMaxInterval = 200 * time.Millisecond
+	t.Cleanup(func() { bootstrapLoginMaxInterval = 30 * time.Second })
+
+	services := startTestServices(t, testutil.Options{})
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	peer, err := services.CreatePeer(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	management := newGatedProxy(t, services.ManagementAddr)
+
+	// the bundle has been exported with a remote peer that has been removed since
+	serverKey := generateBootstrapTestKey(t)
+	removedPeer := &mgmtProto.RemotePeerConfig{
+		WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+		AllowedIps: []string{"100.64.0.250/32"},
+	}
+	peerConfig := &mgmtProto.PeerConfig{Address: peer.Address}
+	path := filepath.Join(t.TempDir(), "bootstrap.bin")
+	writeBootstrapBundle(t, path, serverKey, serverKey, peer.Key, &mgmtProto.SyncResponse{
+		WiretrusteeConfig: &mgmtProto.WiretrusteeConfig{
+			Signal: &mgmtProto.HostConfig{Uri: services.SignalAddr, Protocol: mgmtProto.HostConfig_HTTP},
+		},
+		PeerConfig: peerConfig,
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:      1,
+			PeerConfig:  peerConfig,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{removedPeer},
+		},
+	})
+	sshKey, err := nbssh.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		ManagementURL:       &url.URL{Scheme: "http", Host: management.listener.Addr().String()},
+		PrivateKey:          peer.Key.String(),
+		PlaintextPrivateKey: true,
+		WgIface:             peer.IfaceName,
+		WgPort:              &peer.WgPort,
+		SSHKey:              string(sshKey),
+		BootstrapFile:       path,
+		BootstrapServerKey:  serverKey.PublicKey().String(),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunClient(ctx, config)
+	}()
+	defer func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("expecting the client to stop")
+		}
+	}()
+
+	// waitForPeers waits until the running Engine has exactly the remote peers
+	waitForPeers := func(expected string, message string) {
+		t.Helper()
+		timeout := time.After(10 * time.Second)
+		for {
+			if status := CtxGetState(ctx).EngineStatus(); status != nil {
+				if len(status.Peers) == 1 && status.Peers[0].PubKey == expected {
+					return
+				}
+			}
+			select {
+			case err := <-done:
+				t.Fatalf("client has stopped: %v", err)
+			case <-timeout:
+				t.Fatal(message)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	}
+
+	// the Engine comes up with the bundle although the Management Service is unreachable
+	waitForPeers(removedPeer.GetWgPubKey(), "expecting the Engine to come up with the peers of the bootstrap bundle")
+	if status := CtxGetState(ctx).EngineStatus(); status.Management.Connected {
+		t.Error("expecting the Management Service to be unreachable")
+	}
+
+	// once reachable the client logs in and the NetworkMap of the Management Service replaces the bundle
+	remotePeer, err := services.CreatePeer(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&management.open, 1)
+	waitForPeers(remotePeer.Key.PublicKey().String(), "expecting the bootstrap bundle to be reconciled with the NetworkMap")
+	status, err := CtxGetState(ctx).Status()
+	if err != nil || status != StatusConnected {
+		t.Errorf("expecting the client to stay connected, got %s: %v", status, err)
+	}
+}
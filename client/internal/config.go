@@ -3,18 +3,30 @@ package internal
 import (
 	"context"
 	"fmt"
-	mgm "github.com/netbirdio/netbird/management/client"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/netbirdio/netbird/client/internal/peer"
+	"github.com/netbirdio/netbird/client/ssh"
 	"github.com/netbirdio/netbird/iface"
 	"github.com/netbirdio/netbird/util"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
+const (
+	// privateKeyEnv is an environment variable holding the Wireguard private key of the local peer.
+	// It takes precedence over the key file and the config
+	privateKeyEnv = "NB_PRIVATE_KEY"
+	// privateKeyFileEnv is an environment variable holding a path to the file with the Wireguard private key
+	privateKeyFileEnv = "NB_PRIVATE_KEY_FILE"
+)
+
 var managementURLDefault *url.URL
 
 func ManagementURLDefault() *url.URL {
@@ -32,18 +44,174 @@ func init() {
 // Config Configuration type
 type Config struct {
 	// Wireguard private key of local peer
-	PrivateKey     string
-	PreSharedKey   string
-	ManagementURL  *url.URL
-	AdminURL       *url.URL
-	WgIface        string
-	IFaceBlackList []string
+	PrivateKey string
+	// PrivateKeyFile is a path to a file holding the Wireguard private key of local peer (e.g. mounted by a secret manager).
+	// If set, it is used instead of PrivateKey. The key is generated and persisted on the first run if the file doesn't exist
+	PrivateKeyFile string
+	// EncryptedPrivateKey is the Wireguard private key of local peer encrypted at rest with PrivateKeyProtection,
+	// used instead of PrivateKey. Run the config encrypt command to encrypt the key of an existing config
+	EncryptedPrivateKey string
+	// PrivateKeyProtection is how EncryptedPrivateKey is encrypted: passphrase, keychain (macOS) or dpapi (Windows)
+	PrivateKeyProtection string
+	// PlaintextPrivateKey keeps the Wireguard private key in plaintext, e.g. on headless servers without a passphrase
+	// or a keychain. Set it for a new config with the NB_PLAINTEXT_PRIVATE_KEY environment variable
+	PlaintextPrivateKey bool
+	PreSharedKey        string
+	ManagementURL       *url.URL
+	AdminURL            *url.URL
+	WgIface             string
+	IFaceBlackList      []string
+	// Labels are user defined labels (e.g. env=prod) reported to the Management Service
+	Labels map[string]string
+	// RequestedIP is an optional IP of the account network the peer asks to get assigned on registration
+	// (e.g. a gateway that must keep a fixed IP). It has no effect once the peer is registered
+	RequestedIP string
+	// ProbeInterval is an interval of the connection quality probe (RTT and loss) of the connected peers.
+	// The probe is disabled if not set
+	ProbeInterval util.Duration
+	// IPFamilyPreference is the IP family (auto, ipv4 or ipv6) preferred by the connections to the peers.
+	// Set it to ipv4 on networks with broken IPv6
+	IPFamilyPreference peer.IPFamily
+	// EnableTCPFallback relays the connections to the peers over TURN/TCP and TURN/TLS on networks blocking UDP.
+	// The relay is slower than a direct connection, so it is disabled by default
+	EnableTCPFallback bool
+	// TURNRefreshMargin is how long before the TURN credentials sent by the Management Service expire the client
+	// requests new ones if they haven't been pushed yet. Defaults to 1/8 of the credentials lifetime
+	TURNRefreshMargin util.Duration
+	// IceLite runs lightweight ICE-lite agents that speed up the connection setup of the peers with a public,
+	// unfirewalled address (e.g. datacenter servers). Ignored when the host has no public address
+	IceLite bool
+	// OnDemand connects to a peer only when there is traffic to it and tears the idle connections down,
+	// saving the battery of the mobile devices. Connections take a few seconds longer to establish
+	OnDemand bool
+	// IdleTimeout is the time without traffic after which a connection to a peer is torn down in the OnDemand mode
+	// and the peer is kept dormant again, 5m if not set
+	IdleTimeout util.Duration
+	// EnableSignalRelay relays the connection to a peer through the Signal Service when neither a direct connection
+	// nor a TURN relay works. The connection is slow and capped at SignalRelayLimitKbps, so it is disabled by default
+	EnableSignalRelay bool
+	// SignalRelayLimitKbps caps the bandwidth of a connection relayed through the Signal Service in kbit/s,
+	// 1024 if not set
+	SignalRelayLimitKbps int
+	// PeerConnectionTimeout limits each attempt to connect to a peer, an attempt hanging longer (e.g. gathering
+	// the candidates of a blackholed STUN server) is aborted and retried. 45s if not set
+	PeerConnectionTimeout util.Duration
+	// StagedICE connects to a peer over the host candidates first, then over the STUN ones and over the TURN relay
+	// last, escalating only when the previous stage has failed. It saves the relay traffic when a direct connection
+	// works, the peers should enable it both
+	StagedICE bool
+	// MaxConcurrentDials limits the connection attempts to the peers negotiating at the same time, so the connections
+	// to the peers of a large network ramp up in batches instead of flooding the Signal Service. Not limited if not set
+	MaxConcurrentDials int
+	// BootstrapFile is a bootstrap bundle exported by the Management Service for the peer (the /admin/bootstrap
+	// endpoint), the peer comes up with the configuration and the network map of the bundle before it can reach
+	// the Management Service and reconciles them with the network map the Management Service sends once it is
+	// reachable. Requires BootstrapServerKey
+	BootstrapFile string
+	// BootstrapServerKey is the Wireguard public key of the Management Service the bootstrap bundle has to be sealed
+	// with, required with BootstrapFile
+	BootstrapServerKey string
+	// WgPort is the listen port of the Wireguard interface, iface.DefaultWgPort if not set.
+	// 0 picks a random free UDP port on every start (e.g. when the default port clashes with another application)
+	WgPort *int
+	// WgMode forces the kernel or the userspace Wireguard (auto, kernel or userspace). In the auto mode the kernel
+	// Wireguard is used if available and the client falls back to the userspace one otherwise
+	WgMode iface.WGMode
+	// LogLevels are the log levels of the components (e.g. peer=debug,engine=info) that differ from the global log level.
+	// The WT_LOG environment variable overrides them
+	LogLevels string
+	// LogMaxSizeMB is the size of the log file of the daemon at which it is rotated, 5 MB if not set
+	LogMaxSizeMB int
+	// LogMaxBackups is the number of the rotated log files kept, 10 if not set
+	LogMaxBackups int
+	// LogMaxAgeDays is the number of days the rotated log files are kept, 30 if not set
+	LogMaxAgeDays int
+	// DisableLogCompression keeps the rotated log files uncompressed
+	DisableLogCompression bool
+	// DisableAutoConnect is set by the down command, so the daemon doesn't connect on start until the up command
+	DisableAutoConnect bool
+	// KeyRotationInterval rotates the Wireguard private key of local peer periodically, the peer keeps its IP, name and
+	// groups on the Management Service. The rotation is disabled if not set
+	KeyRotationInterval util.Duration
+	// KeyRotatedAt is the time the Wireguard private key has been rotated last, zero if it has never been rotated
+	KeyRotatedAt time.Time
+	// PendingPrivateKey is the new Wireguard private key of a rotation the Management Service hasn't confirmed
+	// (e.g. the connection broke during the request), sealed like EncryptedPrivateKey if the key is encrypted.
+	// Kept in a .pending file next to the key file if the key is read from a file
+	PendingPrivateKey string
+	// UpdateReleaseURL is the latest client release the update command installs and the update check compares
+	// the running version with, update.DefaultReleaseURL if not set
+	UpdateReleaseURL string
+	// UpdatePublicKey is the base64 encoded ed25519 public key the checksums of the releases are signed with,
+	// the release signing key embedded in the build if not set
+	UpdatePublicKey string
+	// UpdateSkipSignature disables the signature verification of the releases, they are verified against their
+	// checksums only. Meant for the release URLs serving unsigned builds
+	UpdateSkipSignature bool
+	// AutoUpdateCheck checks daily for a newer client release while the daemon runs, the status command shows
+	// the available update. The update isn't installed automatically, run the update command to install it
+	AutoUpdateCheck bool
+	// ExitNode is the peer (by its key, name or IP) all the traffic is routed through while it is connected,
+	// set by the up command with the --exit-node flag. Supported on Linux only
+	ExitNode string
+	// TraceConnections records the timeline of each connection attempt to a peer (offer sent, answer received,
+	// candidates gathered, connectivity check succeeded, Wireguard handshake), shown by the status command
+	TraceConnections bool
+	// SecondaryManagementURLs are the Management Services sharing the account state with the primary one (ManagementURL).
+	// The client fails over to them in order while the primary one is unreachable and returns to it once it has recovered
+	SecondaryManagementURLs []*url.URL
+	// PostUp is a command run by the system shell after the Wireguard interface has been created and PostDown one after
+	// it has been torn down, like PostUp and PostDown of wg-quick. %i is replaced with the interface name,
+	// the NB_INTERFACE and NB_ADDRESS environment variables hold the interface name and address
+	PostUp   string
+	PostDown string
+	// DNSZone is the zone (e.g. wt.local) the peers are reachable by their names in, e.g. laptop.wt.local.
+	// The names are resolved by a responder on the Netbird IP, the resolver of the system (systemd-resolved on Linux,
+	// macOS, NRPT on Windows) sends it the queries of the zone only. The names aren't resolved if not set
+	DNSZone string
+	// DNSPort is the port of the responder, 53 if not set. Other ports aren't supported by NRPT on Windows and require
+	// systemd 246 or later on Linux
+	DNSPort int
+	// SSHKey is the PEM encoded SSH key of the peer generated with the config, the host key of its SSH server and the key
+	// it authenticates to the SSH servers of the remote peers with
+	SSHKey string
+	// ServerSSHAllowed allows the Management Service to enable the SSH server of the peer. The server listens on
+	// the Netbird IP and accepts the connections of the remote peers allowed by the Management Service only
+	ServerSSHAllowed bool
+	// ServerSSHUsers maps the logins the remote peers can use to the local users the SSH sessions run as,
+	// e.g. {"admin": "ubuntu"}. The other logins are refused, so no one can log in if empty
+	ServerSSHUsers map[string]string
+	// ServerSSHRootAllowed allows the SSH sessions to run as a user with uid 0 (e.g. root), refused by default
+	ServerSSHRootAllowed bool
+	// ClampMSS lowers the MSS of the TCP connections entering and leaving the Wireguard interface, so the connections
+	// through a gateway peer behind a link with a smaller MTU (e.g. PPPoE) don't stall. Disabled by default
+	ClampMSS bool
+	// MSS is the value the MSS is clamped to, the MTU of the Wireguard interface minus the IPv4 and TCP headers if not set
+	MSS int
+	// ClientCertFile and ClientKeyFile are the PEM encoded client certificate and its private key presented to
+	// the Management and the Signal services requiring mutual TLS. They are read again once they change or the daemon
+	// receives SIGHUP
+	ClientCertFile string
+	ClientKeyFile  string
+	// ClientPKCS12File is a PKCS#12 bundle of the client certificate and its private key used instead of ClientCertFile
+	// and ClientKeyFile, ClientPKCS12Password is its password
+	ClientPKCS12File     string
+	ClientPKCS12Password string
+
+	// path is the file the config has been read from, the rotated Wireguard key is persisted there
+	path string
 }
 
 // createNewConfig creates a new config generating a new Wireguard key and saving to file
 func createNewConfig(managementURL, adminURL, configPath, preSharedKey string) (*Config, error) {
-	wgKey := generateKey()
-	config := &Config{PrivateKey: wgKey, WgIface: iface.WgInterfaceDefault, IFaceBlackList: []string{}}
+	config := &Config{WgIface: iface.WgInterfaceDefault, IFaceBlackList: []string{}}
+	// avoid storing the key in the config if it is provided externally
+	if keyFile := os.Getenv(privateKeyFileEnv); keyFile != "" {
+		config.PrivateKeyFile = keyFile
+	} else if os.Getenv(privateKeyEnv) == "" {
+		config.PrivateKey = generateKey()
+		config.protectNewPrivateKey()
+	}
 	if managementURL != "" {
 		URL, err := parseURL("Management URL", managementURL)
 		if err != nil {
@@ -60,10 +228,17 @@ func createNewConfig(managementURL, adminURL, configPath, preSharedKey string) (
 
 	config.IFaceBlackList = []string{iface.WgInterfaceDefault, "tun0"}
 
-	err := util.WriteJson(configPath, config)
+	sshKey, err := ssh.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	config.SSHKey = string(sshKey)
+
+	err = util.WriteJson(configPath, config)
 	if err != nil {
 		return nil, err
 	}
+	config.path = configPath
 
 	return config, nil
 }
@@ -94,6 +269,7 @@ func ReadConfig(managementURL, adminURL, configPath string, preSharedKey *string
 	if _, err := util.ReadJson(configPath, config); err != nil {
 		return nil, err
 	}
+	config.path = configPath
 
 	refresh := false
 
@@ -160,6 +336,134 @@ func generateKey() string {
 	return key.String()
 }
 
+// WgPrivateKey returns the Wireguard private key of local peer.
+// The key is taken from the NB_PRIVATE_KEY environment variable, then from the key file
+// (Config.PrivateKeyFile or NB_PRIVATE_KEY_FILE environment variable) and finally from the config itself, decrypted
+// if it is encrypted at rest. A key that can't be decrypted is reported as ErrPrivateKeyLocked
+func (c *Config) WgPrivateKey() (wgtypes.Key, error) {
+	if key := os.Getenv(privateKeyEnv); key != "" {
+		return wgtypes.ParseKey(strings.TrimSpace(key))
+	}
+
+	if keyFile := c.privateKeyFile(); keyFile != "" {
+		return readOrCreateKeyFile(keyFile)
+	}
+
+	if c.EncryptedPrivateKey != "" {
+		return c.decryptPrivateKey()
+	}
+
+	return wgtypes.ParseKey(c.PrivateKey)
+}
+
+// privateKeyFile returns the path to the file with the Wireguard private key of local peer, empty if the key is kept
+// in the config
+func (c *Config) privateKeyFile() string {
+	if c.PrivateKeyFile != "" {
+		return c.PrivateKeyFile
+	}
+	return os.Getenv(privateKeyFileEnv)
+}
+
+// generateSSHKey generates the SSH key of the config created before the SSH support and persists it to the config file
+func (c *Config) generateSSHKey() error {
+	sshKey, err := ssh.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+	c.SSHKey = string(sshKey)
+	if c.path == "" {
+		return nil
+	}
+	return util.WriteJson(c.path, c)
+}
+
+// LogRotation returns the rotation of the log file of the daemon, util.DefaultLogRotation for the settings not set
+func (c *Config) LogRotation() util.LogRotation {
+	rotation := util.DefaultLogRotation
+	if c.LogMaxSizeMB > 0 {
+		rotation.MaxSizeMB = c.LogMaxSizeMB
+	}
+	if c.LogMaxBackups > 0 {
+		rotation.MaxBackups = c.LogMaxBackups
+	}
+	if c.LogMaxAgeDays > 0 {
+		rotation.MaxAgeDays = c.LogMaxAgeDays
+	}
+	if c.DisableLogCompression {
+		rotation.Compress = false
+	}
+	return rotation
+}
+
+// readOrCreateKeyFile reads the Wireguard private key from the file.
+// If the file doesn't exist, generates a new key and persists it with 0600 permissions
+func readOrCreateKeyFile(path string) (wgtypes.Key, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return wgtypes.Key{}, err
+		}
+		err = writeKeyFile(path, key)
+		if err != nil {
+			return wgtypes.Key{}, err
+		}
+		log.Infof("generated a new Wireguard private key file %s", path)
+		return key, nil
+	}
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	// file permissions don't apply on Windows
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		log.Warnf("Wireguard private key file %s is accessible by other users (permissions %#o), it should have 0600 permissions",
+			path, info.Mode().Perm())
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	key, err := wgtypes.ParseKey(strings.TrimSpace(string(content)))
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed parsing Wireguard private key file %s: %v", path, err)
+	}
+	return key, nil
+}
+
+// writeKeyFile persists the Wireguard private key to the file with 0600 permissions. The file is replaced atomically,
+// so a failed write doesn't leave a corrupted key behind
+func writeKeyFile(path string, key wgtypes.Key) error {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(path), ".*"+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed writing Wireguard private key file %s: %v", path, err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.WriteString(key.String() + "\n")
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), 0600)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing Wireguard private key file %s: %v", path, err)
+	}
+	return nil
+}
+
 // DeviceAuthorizationFlow represents Device Authorization Flow information
 type DeviceAuthorizationFlow struct {
 	Provider       string
@@ -180,19 +484,14 @@ type ProviderConfig struct {
 
 func GetDeviceAuthorizationFlowInfo(ctx context.Context, config *Config) (DeviceAuthorizationFlow, error) {
 	// validate our peer's Wireguard PRIVATE key
-	myPrivateKey, err := wgtypes.ParseKey(config.PrivateKey)
+	myPrivateKey, err := config.WgPrivateKey()
 	if err != nil {
-		log.Errorf("failed parsing Wireguard key %s: [%s]", config.PrivateKey, err.Error())
+		log.Errorf("failed parsing Wireguard key: [%s]", err.Error())
 		return DeviceAuthorizationFlow{}, err
 	}
 
-	var mgmTlsEnabled bool
-	if config.ManagementURL.Scheme == "https" {
-		mgmTlsEnabled = true
-	}
-
 	log.Debugf("connecting to Management Service %s", config.ManagementURL.String())
-	mgmClient, err := mgm.NewClient(ctx, config.ManagementURL.Host, myPrivateKey, mgmTlsEnabled)
+	mgmClient, err := newManagementClient(ctx, config, myPrivateKey)
 	if err != nil {
 		log.Errorf("failed connecting to Management Service %s %v", config.ManagementURL.String(), err)
 		return DeviceAuthorizationFlow{}, err
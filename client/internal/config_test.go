@@ -4,10 +4,12 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/netbirdio/netbird/util"
 	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -27,6 +29,7 @@ func TestGetConfig(t *testing.T) {
 
 	assert.Equal(t, config.ManagementURL.String(), managementURL)
 	assert.Equal(t, config.PreSharedKey, preSharedKey)
+	assert.NotEmpty(t, config.SSHKey, "expecting SSH key to be generated with the config")
 
 	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
 		t.Errorf("config file was expected to be created under path %s", path)
@@ -58,3 +61,80 @@ func TestGetConfig(t *testing.T) {
 	}
 	assert.Equal(t, readConf.(*Config).ManagementURL.String(), newManagementURL)
 }
+
+func TestWgPrivateKey(t *testing.T) {
+	inlineKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// case 1: key stored in the config
+	config := &Config{PrivateKey: inlineKey.String()}
+	key, err := config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, inlineKey, key)
+
+	// case 2: key file doesn't exist -> generate and persist it
+	keyFile := filepath.Join(t.TempDir(), "keys", "wg.key")
+	config = &Config{PrivateKey: inlineKey.String(), PrivateKeyFile: keyFile}
+	generated, err := config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, inlineKey, generated)
+
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("key file was expected to be created under path %s", keyFile)
+	}
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// case 3: existing key file -> read it
+	key, err = config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, generated, key)
+
+	// case 4: loose permissions only produce a warning
+	err = os.Chmod(keyFile, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, generated, key)
+
+	// case 5: key file from the environment
+	t.Setenv(privateKeyFileEnv, keyFile)
+	key, err = (&Config{PrivateKey: inlineKey.String()}).WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, generated, key)
+
+	// case 6: key from the environment takes precedence
+	envKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(privateKeyEnv, envKey.String())
+	key, err = config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, envKey, key)
+
+	// case 7: malformed key file
+	err = os.WriteFile(keyFile, []byte("invalid"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(privateKeyEnv, "")
+	_, err = config.WgPrivateKey()
+	assert.Error(t, err)
+}
+
+func TestConfig_LogRotation(t *testing.T) {
+	config := &Config{}
+	assert.Equal(t, util.DefaultLogRotation, config.LogRotation())
+
+	config.LogMaxSizeMB = 50
+	config.LogMaxAgeDays = 7
+	config.DisableLogCompression = true
+	assert.Equal(t, util.LogRotation{MaxSizeMB: 50, MaxBackups: 10, MaxAgeDays: 7}, config.LogRotation())
+}
@@ -0,0 +1,442 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/dns"
+	"github.com/netbirdio/netbird/client/internal/update"
+	"github.com/netbirdio/netbird/encryption"
+	"github.com/netbirdio/netbird/iface"
+	mgm "github.com/netbirdio/netbird/management/client"
+	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// connectivityCheckTimeout limits the connectivity check of each service of the config
+const connectivityCheckTimeout = 10 * time.Second
+
+// ConfigProblems are the problems found by the config validation, each of them is a human readable sentence
+type ConfigProblems []string
+
+func (p ConfigProblems) Error() string {
+	return "invalid config: " + strings.Join(p, "; ")
+}
+
+// Validate checks the config the same way the client does when it connects, so that the problems are reported
+// at once instead of failing on the first of them. Returns ConfigProblems if the config is invalid
+func (c *Config) Validate() error {
+	var problems ConfigProblems
+
+	if err := c.validatePrivateKey(); err != nil {
+		problems = append(problems, fmt.Sprintf("Wireguard private key is invalid: %v", err))
+	}
+	if c.PreSharedKey != "" {
+		if _, err := wgtypes.ParseKey(c.PreSharedKey); err != nil {
+			problems = append(problems, fmt.Sprintf("PreSharedKey is not a valid Wireguard key: %v", err))
+		}
+	}
+	if c.ManagementURL == nil {
+		problems = append(problems, "ManagementURL is not set, run netbird login with --management-url")
+	} else if err := validateServiceURL(c.ManagementURL); err != nil {
+		problems = append(problems, fmt.Sprintf("ManagementURL %s is invalid: %v", c.ManagementURL, err))
+	}
+	for _, secondary := range c.SecondaryManagementURLs {
+		if err := validateServiceURL(secondary); err != nil {
+			problems = append(problems, fmt.Sprintf("SecondaryManagementURLs %s is invalid: %v", secondary, err))
+		}
+	}
+	if c.AdminURL != nil {
+		if err := validateServiceURL(c.AdminURL); err != nil {
+			problems = append(problems, fmt.Sprintf("AdminURL %s is invalid: %v", c.AdminURL, err))
+		}
+	}
+	if c.WgIface == "" {
+		problems = append(problems, "WgIface is not set")
+	}
+	if c.RequestedIP != "" && net.ParseIP(c.RequestedIP) == nil {
+		problems = append(problems, fmt.Sprintf("RequestedIP %s is not an IP address", c.RequestedIP))
+	}
+	if !c.IPFamilyPreference.IsValid() {
+		problems = append(problems, fmt.Sprintf("IPFamilyPreference %s is not supported, use auto, ipv4 or ipv6", c.IPFamilyPreference))
+	}
+	if c.WgPort != nil && (*c.WgPort < 0 || *c.WgPort > 65535) {
+		problems = append(problems, fmt.Sprintf("WgPort %d is not a valid port, use 0-65535", *c.WgPort))
+	}
+	if !c.WgMode.IsValid() {
+		problems = append(problems, fmt.Sprintf("WgMode %s is not supported, use auto, kernel or userspace", c.WgMode))
+	}
+	if c.ProbeInterval.Duration < 0 {
+		problems = append(problems, fmt.Sprintf("ProbeInterval %s is negative", c.ProbeInterval.Duration))
+	}
+	if c.IdleTimeout.Duration < 0 {
+		problems = append(problems, fmt.Sprintf("IdleTimeout %s is negative", c.IdleTimeout.Duration))
+	}
+	if c.SignalRelayLimitKbps < 0 {
+		problems = append(problems, fmt.Sprintf("SignalRelayLimitKbps %d is negative", c.SignalRelayLimitKbps))
+	}
+	if c.PeerConnectionTimeout.Duration < 0 {
+		problems = append(problems, fmt.Sprintf("PeerConnectionTimeout %s is negative", c.PeerConnectionTimeout.Duration))
+	}
+	if c.MaxConcurrentDials < 0 {
+		problems = append(problems, fmt.Sprintf("MaxConcurrentDials %d is negative", c.MaxConcurrentDials))
+	}
+	if c.BootstrapServerKey != "" {
+		if _, err := wgtypes.ParseKey(c.BootstrapServerKey); err != nil {
+			problems = append(problems, fmt.Sprintf("BootstrapServerKey is not a valid Wireguard key: %v", err))
+		}
+	} else if c.BootstrapFile != "" {
+		problems = append(problems, "BootstrapFile is set, but BootstrapServerKey the bundle is sealed with isn't")
+	}
+	if c.KeyRotationInterval.Duration < 0 {
+		problems = append(problems, fmt.Sprintf("KeyRotationInterval %s is negative", c.KeyRotationInterval.Duration))
+	} else if c.KeyRotationInterval.Duration > 0 && os.Getenv(privateKeyEnv) != "" {
+		problems = append(problems, fmt.Sprintf("KeyRotationInterval is set, but the Wireguard private key set by %s can't be rotated", privateKeyEnv))
+	}
+	if c.UpdateReleaseURL != "" {
+		if releaseURL, err := url.ParseRequestURI(c.UpdateReleaseURL); err != nil {
+			problems = append(problems, fmt.Sprintf("UpdateReleaseURL %s is invalid: %v", c.UpdateReleaseURL, err))
+		} else if err := validateServiceURL(releaseURL); err != nil {
+			problems = append(problems, fmt.Sprintf("UpdateReleaseURL %s is invalid: %v", c.UpdateReleaseURL, err))
+		}
+	}
+	if c.UpdatePublicKey != "" {
+		if _, err := update.ParsePublicKey(c.UpdatePublicKey); err != nil {
+			problems = append(problems, fmt.Sprintf("UpdatePublicKey is not a valid ed25519 public key: %v", err))
+		}
+	}
+	if c.DNSZone != "" {
+		if err := dns.ValidateZone(c.DNSZone); err != nil {
+			problems = append(problems, fmt.Sprintf("DNSZone is invalid: %v", err))
+		}
+	}
+	if c.DNSPort < 0 || c.DNSPort > 65535 {
+		problems = append(problems, fmt.Sprintf("DNSPort %d is not a valid port, use 1-65535", c.DNSPort))
+	}
+	if c.MSS != 0 {
+		if err := iface.ValidateMSS(c.MSS, iface.DefaultMTU); err != nil {
+			problems = append(problems, fmt.Sprintf("MSS is invalid: %v", err))
+		}
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		problems = append(problems, "ClientCertFile and ClientKeyFile must be set together")
+	}
+	if c.ClientPKCS12File != "" && c.ClientCertFile != "" {
+		problems = append(problems, "ClientPKCS12File and ClientCertFile are mutually exclusive, set one of them")
+	}
+	if err := util.ValidateComponentLevels(c.LogLevels); err != nil {
+		problems = append(problems, fmt.Sprintf("LogLevels are invalid: %v", err))
+	}
+	if c.LogMaxSizeMB < 0 {
+		problems = append(problems, fmt.Sprintf("LogMaxSizeMB %d is negative", c.LogMaxSizeMB))
+	}
+	if c.LogMaxBackups < 0 {
+		problems = append(problems, fmt.Sprintf("LogMaxBackups %d is negative", c.LogMaxBackups))
+	}
+	if c.LogMaxAgeDays < 0 {
+		problems = append(problems, fmt.Sprintf("LogMaxAgeDays %d is negative", c.LogMaxAgeDays))
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// Warnings returns the settings of the config that are valid but likely not what the user wants,
+// e.g. a setting that has no effect without another one. The client runs with them
+func (c *Config) Warnings() []string {
+	var warnings []string
+	if c.IdleTimeout.Duration > 0 && !c.OnDemand {
+		warnings = append(warnings, "IdleTimeout is set, but the connections are torn down in the OnDemand mode only")
+	}
+	if c.UpdateSkipSignature {
+		warnings = append(warnings, "UpdateSkipSignature is set, the updates are verified against their checksums only")
+	}
+	return warnings
+}
+
+// validatePrivateKey checks the Wireguard private key the way WgPrivateKey reads it, without generating the key file
+func (c *Config) validatePrivateKey() error {
+	if key := os.Getenv(privateKeyEnv); key != "" {
+		if _, err := wgtypes.ParseKey(strings.TrimSpace(key)); err != nil {
+			return fmt.Errorf("%s: %v", privateKeyEnv, err)
+		}
+		return nil
+	}
+
+	if keyFile := c.privateKeyFile(); keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if os.IsNotExist(err) {
+			// the key file is generated on the first start
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := wgtypes.ParseKey(strings.TrimSpace(string(content))); err != nil {
+			return fmt.Errorf("key file %s: %v", keyFile, err)
+		}
+		return nil
+	}
+
+	if c.EncryptedPrivateKey != "" {
+		_, err := c.decryptPrivateKey()
+		return err
+	}
+
+	_, err := wgtypes.ParseKey(c.PrivateKey)
+	return err
+}
+
+// validateServiceURL checks the URL of the Management Service or the Admin Panel
+func validateServiceURL(serviceURL *url.URL) error {
+	if serviceURL.Scheme != "https" && serviceURL.Scheme != "http" {
+		return fmt.Errorf("unsupported scheme %q, supported format [http|https]://[host]:[port]", serviceURL.Scheme)
+	}
+	if serviceURL.Host == "" {
+		return fmt.Errorf("host is not set")
+	}
+	return nil
+}
+
+// ValidateConfigFile reads and validates the config file. In addition to Config.Validate it reports the problems
+// the client silently ignores: the unknown fields (e.g. typos) and the fields in an old format (see MigrateConfigFile)
+func ValidateConfigFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, ConfigProblems{fmt.Sprintf("config file %s is not a valid JSON object: %v", path, err)}
+	}
+
+	var problems ConfigProblems
+	known := configFieldNames()
+	for _, name := range sortedFieldNames(fields) {
+		if !isKnownConfigField(known, name) {
+			problems = append(problems, fmt.Sprintf("unknown field %s is ignored", name))
+		}
+	}
+	for _, change := range migrateConfigFields(fields) {
+		problems = append(problems, fmt.Sprintf("%s, run netbird config migrate", change))
+	}
+	if len(problems) > 0 {
+		// the old format can't be parsed, the remaining problems are found once the config is migrated
+		return nil, problems
+	}
+
+	config := &Config{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return nil, ConfigProblems{fmt.Sprintf("config file %s can't be parsed: %v", path, err)}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// MigrateConfigFile rewrites the fields of the config file that are in an old format (e.g. the URLs written as strings
+// or the labels written as a key=value list) to the current format. The unknown fields are kept, the original file is
+// backed up to path.bak. Returns the descriptions of the changes, none if the config is up to date
+func MigrateConfigFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, fmt.Errorf("config file %s is not a valid JSON object: %v", path, err)
+	}
+
+	changes := migrateConfigFields(fields)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path+".bak", content, stat.Mode().Perm()); err != nil {
+		return nil, fmt.Errorf("failed backing up config file %s: %v", path, err)
+	}
+	if err := util.WriteJson(path, fields); err != nil {
+		return nil, err
+	}
+
+	return changes, nil
+}
+
+// migrateConfigFields converts the fields in an old format in place and returns the descriptions of the changes
+func migrateConfigFields(fields map[string]json.RawMessage) []string {
+	var changes []string
+	for _, name := range sortedFieldNames(fields) {
+		var value interface{}
+		if err := json.Unmarshal(fields[name], &value); err != nil {
+			continue
+		}
+		text, isString := value.(string)
+		if !isString {
+			continue
+		}
+
+		var migrated interface{}
+		var change string
+		switch strings.ToLower(name) {
+		case "managementurl", "adminurl":
+			parsed, err := url.ParseRequestURI(text)
+			if err != nil {
+				continue
+			}
+			migrated, change = parsed, fmt.Sprintf("%s is a string instead of a URL object", name)
+		case "ifaceblacklist":
+			migrated, change = splitList(text), fmt.Sprintf("%s is a comma separated string instead of a list", name)
+		case "labels":
+			labels := make(map[string]string)
+			for _, pair := range splitList(text) {
+				key, value, _ := strings.Cut(pair, "=")
+				labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+			migrated, change = labels, fmt.Sprintf("%s are a key=value list instead of an object", name)
+		case "wgport":
+			port, err := strconv.Atoi(strings.TrimSpace(text))
+			if err != nil {
+				continue
+			}
+			migrated, change = port, fmt.Sprintf("%s is a string instead of a number", name)
+		default:
+			continue
+		}
+
+		raw, err := json.Marshal(migrated)
+		if err != nil {
+			continue
+		}
+		fields[name] = raw
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// CheckConfigConnectivity checks that the Management Service and the Admin Panel of the config are reachable
+func CheckConfigConnectivity(ctx context.Context, config *Config) error {
+	var problems ConfigProblems
+
+	clientCert, err := config.ClientCertificate()
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("client certificate can't be read: %v", err))
+	}
+
+	if config.ManagementURL != nil {
+		if err := checkManagementConnectivity(ctx, config.ManagementURL, clientCert); err != nil {
+			problems = append(problems, fmt.Sprintf("Management Service %s is unreachable: %v", config.ManagementURL, err))
+		}
+	}
+	for _, secondary := range config.SecondaryManagementURLs {
+		if err := checkManagementConnectivity(ctx, secondary, clientCert); err != nil {
+			problems = append(problems, fmt.Sprintf("secondary Management Service %s is unreachable: %v", secondary, err))
+		}
+	}
+	if config.AdminURL != nil {
+		if err := checkHTTPConnectivity(ctx, config.AdminURL); err != nil {
+			problems = append(problems, fmt.Sprintf("Admin Panel %s is unreachable: %v", config.AdminURL, err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// checkManagementConnectivity connects to the Management Service with a throwaway key and fetches its public key,
+// the client certificate is presented to the services requiring mutual TLS if it isn't nil
+func checkManagementConnectivity(ctx context.Context, managementURL *url.URL, clientCert *encryption.CertificateReloader) error {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+	defer cancel()
+
+	client, err := mgm.NewClientWithTLS(ctx, managementURL.Host, key, serviceTLSConfig(managementURL.Scheme == "https", clientCert))
+	if err != nil {
+		return err
+	}
+	defer client.Close() //nolint
+
+	_, err = client.GetServerPublicKey()
+	return err
+}
+
+// checkHTTPConnectivity checks that the URL responds, regardless of the status
+func checkHTTPConnectivity(ctx context.Context, serviceURL *url.URL) error {
+	ctx, cancel := context.WithTimeout(ctx, connectivityCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serviceURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// configFieldNames returns the names of the Config fields as they are written to the config file
+func configFieldNames() []string {
+	configType := reflect.TypeOf(Config{})
+	names := make([]string, 0, configType.NumField())
+	for i := 0; i < configType.NumField(); i++ {
+		names = append(names, configType.Field(i).Name)
+	}
+	return names
+}
+
+// isKnownConfigField checks whether the field is a Config field, the case is ignored like when the config is parsed
+func isKnownConfigField(known []string, name string) bool {
+	for _, field := range known {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedFieldNames(fields map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitList splits a comma separated list skipping the empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
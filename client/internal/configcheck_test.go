@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/util"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	config := &Config{
+		PrivateKey:    key.String(),
+		ManagementURL: managementURLDefault,
+		WgIface:       "wt0",
+	}
+	assert.NoError(t, config.Validate())
+
+	port := 70000
+	config.PreSharedKey = "not a key"
+	config.RequestedIP = "100.64.0.300"
+	config.WgPort = &port
+	config.LogLevels = "peer"
+	config.LogMaxBackups = -1
+	config.WgMode = "fast"
+	config.DNSZone = "wt_local"
+	config.MSS = 100
+	config.ClientCertFile = "/etc/netbird/client.pem"
+	config.IdleTimeout = util.Duration{Duration: -time.Minute}
+	config.BootstrapServerKey = "not a key"
+
+	err = config.Validate()
+	var problems ConfigProblems
+	require.True(t, errors.As(err, &problems), "expecting ConfigProblems, got %v", err)
+	assert.Len(t, problems, 11, "expecting all the problems to be reported, got %v", problems)
+
+	// the bundle can't be validated against the key reported by the Management Service it is read before reaching
+	config.BootstrapServerKey = ""
+	config.BootstrapFile = "/etc/netbird/bootstrap.bin"
+	err = config.Validate()
+	require.True(t, errors.As(err, &problems), "expecting ConfigProblems, got %v", err)
+	assert.Contains(t, problems, "BootstrapFile is set, but BootstrapServerKey the bundle is sealed with isn't")
+}
+
+func TestConfig_Warnings(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	config := &Config{
+		PrivateKey:    key.String(),
+		ManagementURL: managementURLDefault,
+		WgIface:       "wt0",
+	}
+	assert.Empty(t, config.Warnings())
+
+	config.IdleTimeout = util.Duration{Duration: time.Minute}
+	config.UpdateSkipSignature = true
+	assert.NoError(t, config.Validate(), "expecting the warnings not to invalidate the config")
+	assert.Equal(t, []string{
+		"IdleTimeout is set, but the connections are torn down in the OnDemand mode only",
+		"UpdateSkipSignature is set, the updates are verified against their checksums only",
+	}, config.Warnings())
+
+	config.OnDemand = true
+	config.UpdateSkipSignature = false
+	assert.Empty(t, config.Warnings())
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	config, err := GetConfig("https://api.example.com:33073", "https://app.example.com", path, "")
+	require.NoError(t, err)
+
+	validated, err := ValidateConfigFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, config.ManagementURL.String(), validated.ManagementURL.String())
+
+	err = os.WriteFile(path, []byte(`{"PrivateKey": "`+config.PrivateKey+`", "WgIface": "wt0", "ManagmentURL": "https://api.example.com:33073"}`), 0600)
+	require.NoError(t, err)
+
+	_, err = ValidateConfigFile(path)
+	var problems ConfigProblems
+	require.True(t, errors.As(err, &problems), "expecting ConfigProblems, got %v", err)
+	assert.Equal(t, ConfigProblems{"unknown field ManagmentURL is ignored"}, problems)
+}
+
+func TestMigrateConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	old := `{"PrivateKey": "` + key.String() + `", "WgIface": "wt0", "ManagementURL": "https://api.example.com:33073",` +
+		` "IFaceBlackList": "wt0, tun0", "Labels": "env=prod,team=ops", "WgPort": "51821", "CustomField": true}`
+	err = os.WriteFile(path, []byte(old), 0600)
+	require.NoError(t, err)
+
+	_, err = ValidateConfigFile(path)
+	var problems ConfigProblems
+	require.True(t, errors.As(err, &problems), "expecting ConfigProblems, got %v", err)
+	assert.Len(t, problems, 5, "expecting the unknown field and the fields in the old format, got %v", problems)
+
+	changes, err := MigrateConfigFile(path)
+	require.NoError(t, err)
+	assert.Len(t, changes, 4)
+
+	backup, err := os.ReadFile(path + ".bak")
+	require.NoError(t, err)
+	assert.Equal(t, old, string(backup))
+
+	config, err := ReadConfig("", "", path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.example.com:33073", config.ManagementURL.String())
+	assert.Equal(t, []string{"wt0", "tun0"}, config.IFaceBlackList)
+	assert.Equal(t, map[string]string{"env": "prod", "team": "ops"}, config.Labels)
+	assert.Equal(t, 51821, *config.WgPort)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "CustomField", "expecting the unknown fields to be kept")
+
+	changes, err = MigrateConfigFile(path)
+	require.NoError(t, err)
+	assert.Empty(t, changes, "expecting the migrated config to be up to date")
+}
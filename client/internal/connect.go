@@ -2,15 +2,17 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"time"
 
-	"github.com/netbirdio/netbird/client/system"
-
+	"github.com/netbirdio/netbird/encryption"
 	"github.com/netbirdio/netbird/iface"
 	mgm "github.com/netbirdio/netbird/management/client"
 	mgmProto "github.com/netbirdio/netbird/management/proto"
 	signal "github.com/netbirdio/netbird/signal/client"
-	log "github.com/sirupsen/logrus"
+	"github.com/netbirdio/netbird/util"
 
 	"github.com/cenkalti/backoff/v4"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -20,6 +22,27 @@ import (
 
 // RunClient with main logic.
 func RunClient(ctx context.Context, config *Config) error {
+	// an invalid config won't get valid by retrying, so the client fails at once with all the problems found
+	err := config.Validate()
+	if err != nil {
+		return wrapError(ErrInvalidConfig, err)
+	}
+	for _, warning := range config.Warnings() {
+		log.Warnf("config: %s", warning)
+	}
+
+	err = util.InitComponentLevels(config.LogLevels)
+	if err != nil {
+		log.Warnf("failed setting the log levels of the components: %v", err)
+	}
+
+	if config.SSHKey == "" {
+		err = config.generateSSHKey()
+		if err != nil {
+			log.Warnf("failed generating SSH key, the SSH server and client are disabled: %v", err)
+		}
+	}
+
 	backOff := &backoff.ExponentialBackOff{
 		InitialInterval:     time.Second,
 		RandomizationFactor: backoff.DefaultRandomizationFactor,
@@ -39,6 +62,10 @@ func RunClient(ctx context.Context, config *Config) error {
 	}()
 
 	wrapErr := state.Wrap
+	// a failed automatic key rotation is retried after keyRotationRetryInterval
+	var keyRotationNotBefore time.Time
+	// the bootstrap bundle is used by the first attempt only, the later ones wait for the Management Service
+	bootstrapPending := config.BootstrapFile != ""
 	operation := func() error {
 		// if context cancelled we not start new backoff cycle
 		select {
@@ -48,47 +75,97 @@ func RunClient(ctx context.Context, config *Config) error {
 		}
 
 		state.Set(StatusConnecting)
+
+		// the key is rotated before connecting, the Management Service rejects the old key once it has been replaced.
+		// A pending rotation is completed first, the Management Service may know the peer by the new key only
+		next := config.nextKeyRotation()
+		rotationDue := !next.IsZero() && !time.Now().Before(next) && !time.Now().Before(keyRotationNotBefore)
+		if rotationDue || config.keyRotationPending() {
+			if _, err := RotateKey(ctx, config); err != nil {
+				log.Errorf("failed rotating the Wireguard key, retrying in %s: %v", keyRotationRetryInterval, err)
+				keyRotationNotBefore = time.Now().Add(keyRotationRetryInterval)
+			}
+		}
+
 		// validate our peer's Wireguard PRIVATE key
-		myPrivateKey, err := wgtypes.ParseKey(config.PrivateKey)
+		myPrivateKey, err := config.WgPrivateKey()
+		if errors.Is(err, ErrPrivateKeyLocked) || errors.Is(err, ErrInvalidConfig) {
+			log.Errorf("failed reading Wireguard key: %v", err)
+			return backoff.Permanent(wrapErr(err))
+		}
 		if err != nil {
-			log.Errorf("failed parsing Wireguard key %s: [%s]", config.PrivateKey, err.Error())
-			return wrapErr(err)
+			log.Errorf("failed parsing Wireguard key: [%s]", err.Error())
+			// the key won't get valid by retrying
+			return backoff.Permanent(wrapErr(wrapError(ErrInvalidConfig, err)))
 		}
 
-		var mgmTlsEnabled bool
-		if config.ManagementURL.Scheme == "https" {
-			mgmTlsEnabled = true
+		// the client certificate presented to the services requiring mutual TLS, nil if it isn't set
+		clientCert, err := config.ClientCertificate()
+		if err != nil {
+			log.Errorf("failed reading client certificate: %v", err)
+			return wrapErr(fmt.Errorf("failed reading client certificate: %w", err))
 		}
 
 		engineCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		// connect (just a connection, no stream yet) and login to Management Service to get an initial global Wiretrustee config
-		mgmClient, loginResp, err := connectToManagement(engineCtx, config.ManagementURL.Host, myPrivateKey, mgmTlsEnabled)
-		if err != nil {
-			log.Debug(err)
-			if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
-				log.Info("peer registration required. Please run `netbird status` for details")
-				state.Set(StatusNeedsLogin)
-				return nil
-			}
-			return wrapErr(err)
+		var bundle *mgmProto.SyncResponse
+		if bootstrapPending {
+			bootstrapPending = false
+			bundle = readConfigBootstrap(config, myPrivateKey)
 		}
 
-		// with the global Wiretrustee config in hand connect (just a connection, no stream yet) Signal
-		signalClient, err := connectToSignal(engineCtx, loginResp.GetWiretrusteeConfig(), myPrivateKey)
-		if err != nil {
-			log.Error(err)
-			return wrapErr(err)
-		}
+		managementURLs := config.managementURLs()
+		managementURL := config.ManagementURL
+		var mgmClient *mgm.GrpcClient
+		var signalClient *signal.GrpcClient
+		var wtConfig *mgmProto.WiretrusteeConfig
+		var peerConfig *mgmProto.PeerConfig
+		if bundle != nil {
+			// the Engine comes up with the bootstrap bundle without waiting for the services, the client logs in
+			// once the Management Service is reachable
+			mgmClient, signalClient, err = connectFromBootstrap(engineCtx, config, bundle, myPrivateKey, clientCert)
+			if err != nil {
+				log.Error(err)
+				return wrapErr(err)
+			}
+			wtConfig, peerConfig = bundle.GetWiretrusteeConfig(), bundle.GetPeerConfig()
+		} else {
+			// connect (just a connection, no stream yet) and login to Management Service to get an initial global Wiretrustee config.
+			// The primary Management Service is preferred, the secondary ones are tried in order if it is unreachable
+			var loginResp *mgmProto.LoginResponse
+			mgmClient, loginResp, managementURL, err = connectToManagementServers(engineCtx, managementURLs, myPrivateKey, clientCert, config.Labels)
+			if err != nil {
+				log.Debug(err)
+				if errors.Is(err, ErrLoginRequired) {
+					log.Info("peer registration required. Please run `netbird status` for details")
+					state.Set(StatusNeedsLogin)
+					return nil
+				}
+				return wrapErr(err)
+			}
 
-		peerConfig := loginResp.GetPeerConfig()
+			// with the global Wiretrustee config in hand connect (just a connection, no stream yet) Signal
+			signalClient, err = connectToSignal(engineCtx, loginResp.GetWiretrusteeConfig(), myPrivateKey, clientCert)
+			if err != nil {
+				log.Error(err)
+				return wrapErr(err)
+			}
+			wtConfig, peerConfig = loginResp.GetWiretrusteeConfig(), loginResp.GetPeerConfig()
+		}
 
 		engineConfig, err := createEngineConfig(myPrivateKey, config, peerConfig)
 		if err != nil {
 			log.Error(err)
 			return wrapErr(err)
 		}
+		engineConfig.ManagementURLs = managementURLs
+		engineConfig.ManagementURL = managementURL
+		engineConfig.ClientCertificate = clientCert
+		for _, u := range managementURLs {
+			engineConfig.ServiceHosts = append(engineConfig.ServiceHosts, u.Host)
+		}
+		engineConfig.ServiceHosts = append(engineConfig.ServiceHosts, wtConfig.GetSignal().GetUri())
 
 		engine := NewEngine(engineCtx, cancel, signalClient, mgmClient, engineConfig)
 		err = engine.Start()
@@ -99,12 +176,26 @@ func RunClient(ctx context.Context, config *Config) error {
 
 		log.Print("Netbird engine started, my IP is: ", peerConfig.Address)
 		state.Set(StatusConnected)
+		if bundle != nil {
+			go loginAfterBootstrap(engineCtx, cancel, mgmClient, bundle, config.Labels)
+		}
 
-		<-engineCtx.Done()
+		rotateKey, stopKeyRotation := keyRotationTimer(config, keyRotationNotBefore)
+		defer stopKeyRotation()
+
+		keyRotationDue := false
+		select {
+		case <-engineCtx.Done():
+		case <-rotateKey:
+			log.Info("Wireguard key is due to be rotated, stopping Netbird engine")
+			keyRotationDue = true
+			cancel()
+		}
 
 		backOff.Reset()
 
-		err = mgmClient.Close()
+		// the Engine replaces the client when it fails over to another Management Service
+		err = engine.managementClient().Close()
 		if err != nil {
 			log.Errorf("failed closing Management Service client %v", err)
 			return wrapErr(err)
@@ -123,14 +214,18 @@ func RunClient(ctx context.Context, config *Config) error {
 
 		log.Info("stopped Netbird client")
 
-		if _, err := state.Status(); err == ErrResetConnection {
+		if keyRotationDue {
+			return errKeyRotated
+		}
+
+		if _, err := state.Status(); errors.Is(err, ErrResetConnection) {
 			return err
 		}
 
 		return nil
 	}
 
-	err := backoff.Retry(operation, backOff)
+	err = backoff.Retry(operation, backOff)
 	if err != nil {
 		log.Errorf("exiting client retry loop due to unrecoverable error: %s", err)
 		return err
@@ -140,32 +235,87 @@ func RunClient(ctx context.Context, config *Config) error {
 
 // createEngineConfig converts configuration received from Management Service to EngineConfig
 func createEngineConfig(key wgtypes.Key, config *Config, peerConfig *mgmProto.PeerConfig) (*EngineConfig, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, err)
+	}
+
+	wgPort := iface.DefaultWgPort
+	if config.WgPort != nil {
+		wgPort = *config.WgPort
+	}
+
 	iFaceBlackList := make(map[string]struct{})
 	for i := 0; i < len(config.IFaceBlackList); i += 2 {
 		iFaceBlackList[config.IFaceBlackList[i]] = struct{}{}
 	}
 
 	engineConf := &EngineConfig{
-		WgIfaceName:    config.WgIface,
-		WgAddr:         peerConfig.Address,
-		IFaceBlackList: iFaceBlackList,
-		WgPrivateKey:   key,
-		WgPort:         iface.DefaultWgPort,
+		WgIfaceName:           config.WgIface,
+		WgMode:                config.WgMode,
+		WgAddr:                peerConfig.Address,
+		IFaceBlackList:        iFaceBlackList,
+		WgPrivateKey:          key,
+		WgPort:                wgPort,
+		Labels:                config.Labels,
+		ProbeInterval:         config.ProbeInterval.Duration,
+		IPFamilyPreference:    config.IPFamilyPreference,
+		EnableTCPFallback:     config.EnableTCPFallback,
+		IceLite:               config.IceLite,
+		TURNRefreshMargin:     config.TURNRefreshMargin.Duration,
+		OnDemand:              config.OnDemand,
+		IdleTimeout:           config.IdleTimeout.Duration,
+		EnableSignalRelay:     config.EnableSignalRelay,
+		SignalRelayLimitKbps:  config.SignalRelayLimitKbps,
+		PeerConnectionTimeout: config.PeerConnectionTimeout.Duration,
+		StagedICE:             config.StagedICE,
+		MaxConcurrentDials:    config.MaxConcurrentDials,
+		BootstrapFile:         config.BootstrapFile,
+		ExitNode:              config.ExitNode,
+		TraceConnections:      config.TraceConnections,
+		DNSZone:               config.DNSZone,
+		DNSPort:               config.DNSPort,
+		SSHKey:                []byte(config.SSHKey),
+		ServerSSHAllowed:      config.ServerSSHAllowed,
+		ServerSSHUsers:        config.ServerSSHUsers,
+		ServerSSHRootAllowed:  config.ServerSSHRootAllowed,
 	}
 
 	if config.PreSharedKey != "" {
 		preSharedKey, err := wgtypes.ParseKey(config.PreSharedKey)
 		if err != nil {
-			return nil, err
+			return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid pre-shared key: %w", err))
 		}
 		engineConf.PreSharedKey = &preSharedKey
 	}
 
+	if config.BootstrapServerKey != "" {
+		serverKey, err := wgtypes.ParseKey(config.BootstrapServerKey)
+		if err != nil {
+			return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid bootstrap server key: %w", err))
+		}
+		engineConf.BootstrapServerKey = &serverKey
+	}
+
+	if config.ClampMSS {
+		engineConf.MSSClamp = config.MSS
+		if engineConf.MSSClamp == 0 {
+			engineConf.MSSClamp = iface.DefaultMSS(iface.DefaultMTU)
+		}
+	}
+
+	if config.PostUp != "" {
+		engineConf.PostUp = commandHook(config.PostUp)
+	}
+	if config.PostDown != "" {
+		engineConf.PostDown = commandHook(config.PostDown)
+	}
+
 	return engineConf, nil
 }
 
 // connectToSignal creates Signal Service client and established a connection
-func connectToSignal(ctx context.Context, wtConfig *mgmProto.WiretrusteeConfig, ourPrivateKey wgtypes.Key) (*signal.GrpcClient, error) {
+func connectToSignal(ctx context.Context, wtConfig *mgmProto.WiretrusteeConfig, ourPrivateKey wgtypes.Key, clientCert *encryption.CertificateReloader) (*signal.GrpcClient, error) {
 	var sigTLSEnabled bool
 	if wtConfig.Signal.Protocol == mgmProto.HostConfig_HTTPS {
 		sigTLSEnabled = true
@@ -173,32 +323,36 @@ func connectToSignal(ctx context.Context, wtConfig *mgmProto.WiretrusteeConfig,
 		sigTLSEnabled = false
 	}
 
-	signalClient, err := signal.NewClient(ctx, wtConfig.Signal.Uri, ourPrivateKey, sigTLSEnabled)
+	signalClient, err := signal.NewClientWithTLS(ctx, wtConfig.Signal.Uri, ourPrivateKey, serviceTLSConfig(sigTLSEnabled, clientCert))
 	if err != nil {
 		log.Errorf("error while connecting to the Signal Exchange Service %s: %s", wtConfig.Signal.Uri, err)
-		return nil, status.Errorf(codes.FailedPrecondition, "failed connecting to Signal Service : %s", err)
+		return nil, wrapError(ErrSignalUnreachable, err)
 	}
 
 	return signalClient, nil
 }
 
 // connectToManagement creates Management Services client, establishes a connection, logs-in and gets a global Wiretrustee config (signal, turn, stun hosts, etc)
-func connectToManagement(ctx context.Context, managementAddr string, ourPrivateKey wgtypes.Key, tlsEnabled bool) (*mgm.GrpcClient, *mgmProto.LoginResponse, error) {
+func connectToManagement(ctx context.Context, managementAddr string, ourPrivateKey wgtypes.Key, tlsConfig *tls.Config, labels map[string]string) (*mgm.GrpcClient, *mgmProto.LoginResponse, error) {
 	log.Debugf("connecting to Management Service %s", managementAddr)
-	client, err := mgm.NewClient(ctx, managementAddr, ourPrivateKey, tlsEnabled)
+	client, err := mgm.NewClientWithTLS(ctx, managementAddr, ourPrivateKey, tlsConfig)
 	if err != nil {
-		return nil, nil, status.Errorf(codes.FailedPrecondition, "failed connecting to Management Service : %s", err)
+		return nil, nil, wrapError(ErrManagementUnreachable, err)
 	}
 	log.Debugf("connected to management server %s", managementAddr)
 
 	serverPublicKey, err := client.GetServerPublicKey()
 	if err != nil {
-		return nil, nil, status.Errorf(codes.FailedPrecondition, "failed while getting Management Service public key: %s", err)
+		_ = client.Close()
+		return nil, nil, wrapError(ErrManagementUnreachable, fmt.Errorf("failed getting the public key: %w", err))
 	}
 
-	sysInfo := system.GetInfo(ctx)
-	loginResp, err := client.Login(*serverPublicKey, sysInfo)
+	loginResp, err := client.Login(*serverPublicKey, systemInfo(ctx, labels))
 	if err != nil {
+		_ = client.Close()
+		if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
+			return nil, nil, wrapError(ErrLoginRequired, err)
+		}
 		return nil, nil, err
 	}
 
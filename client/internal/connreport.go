@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// connOutcomesReportInterval is an interval of the reports of the connection outcomes to the Management Service,
+// nothing is sent unless a connection attempt has finished since the previous report
+var connOutcomesReportInterval = time.Minute
+
+// connOutcomes counts the outcomes of the attempts to connect to a remote peer since the previous report
+type connOutcomes struct {
+	connected uint32
+	failures  map[peer.FailureClass]uint32
+}
+
+// recordConnOutcome counts the outcome of an attempt to connect to the remote peer, an empty class for the
+// established connection
+func (e *Engine) recordConnOutcome(peerKey string, class peer.FailureClass) {
+	e.connOutcomesMux.Lock()
+	defer e.connOutcomesMux.Unlock()
+
+	outcomes, ok := e.connOutcomes[peerKey]
+	if !ok {
+		outcomes = &connOutcomes{failures: map[peer.FailureClass]uint32{}}
+		e.connOutcomes[peerKey] = outcomes
+	}
+	if class == "" {
+		outcomes.connected++
+		return
+	}
+	outcomes.failures[class]++
+}
+
+// takeConnOutcomes returns the outcomes counted since the previous report and resets them
+func (e *Engine) takeConnOutcomes() []*mgmProto.PeerConnectionOutcome {
+	e.connOutcomesMux.Lock()
+	defer e.connOutcomesMux.Unlock()
+
+	var report []*mgmProto.PeerConnectionOutcome
+	for peerKey, outcomes := range e.connOutcomes {
+		failures := make(map[string]uint32, len(outcomes.failures))
+		for class, count := range outcomes.failures {
+			failures[string(class)] = count
+		}
+		report = append(report, &mgmProto.PeerConnectionOutcome{
+			Peer:      peerKey,
+			Connected: outcomes.connected,
+			Failures:  failures,
+		})
+	}
+	e.connOutcomes = map[string]*connOutcomes{}
+	return report
+}
+
+// restoreConnOutcomes counts the outcomes of a failed report again, so they are sent with the next one
+func (e *Engine) restoreConnOutcomes(report []*mgmProto.PeerConnectionOutcome) {
+	for _, outcome := range report {
+		for i := uint32(0); i < outcome.GetConnected(); i++ {
+			e.recordConnOutcome(outcome.GetPeer(), "")
+		}
+		for class, count := range outcome.GetFailures() {
+			for i := uint32(0); i < count; i++ {
+				e.recordConnOutcome(outcome.GetPeer(), peer.FailureClass(class))
+			}
+		}
+	}
+}
+
+// hasConnOutcomes returns true if a connection attempt has finished since the previous report
+func (e *Engine) hasConnOutcomes() bool {
+	e.connOutcomesMux.Lock()
+	defer e.connOutcomesMux.Unlock()
+	return len(e.connOutcomes) > 0
+}
+
+// watchConnOutcomes periodically reports the outcomes of the connection attempts to the Management Service,
+// so that the admins see the peers the others fail to connect to and why
+func (e *Engine) watchConnOutcomes() {
+	go func() {
+		ticker := time.NewTicker(connOutcomesReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if !e.hasConnOutcomes() {
+				continue
+			}
+			// the report replaces the conflicts recorded by the Management Service, so the current ones are sent along
+			e.syncMsgMux.Lock()
+			conflicts := toProtoAllowedIPsConflicts(e.allowedIPsConflicts)
+			e.syncMsgMux.Unlock()
+			e.sendFeedback(conflicts)
+		}
+	}()
+}
+
+// sendFeedback reports the conflicting allowed IPs along with the connection outcomes counted so far to the
+// Management Service. The report is sent without holding the lock, a report superseded meanwhile by a newer one
+// is dropped and its outcomes are sent with the newer one
+func (e *Engine) sendFeedback(conflicts []*mgmProto.AllowedIPsConflict) {
+	serial := atomic.AddUint32(&e.feedbackSerial, 1)
+	go func() {
+		e.feedbackMux.Lock()
+		defer e.feedbackMux.Unlock()
+		if atomic.LoadUint32(&e.feedbackSerial) != serial {
+			return
+		}
+		outcomes := e.takeConnOutcomes()
+		err := e.managementClient().SendFeedback(&mgmProto.FeedbackRequest{
+			AllowedIpsConflicts: conflicts,
+			ConnectionOutcomes:  outcomes,
+		})
+		if err != nil {
+			log.Warnf("failed sending feedback to the Management Service: %v", err)
+			e.restoreConnOutcomes(outcomes)
+		}
+	}()
+}
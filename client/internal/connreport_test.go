@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	sProto "github.com/netbirdio/netbird/signal/proto"
+)
+
+func TestEngine_ReportConnOutcomes(t *testing.T) {
+	interval := connOutcomesReportInterval
+	connOutcomesReportInterval = 50 * time.Millisecond
+	t.Cleanup(func() { connOutcomesReportInterval = interval })
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peerKey := remoteKey.PublicKey().String()
+
+	// the first report doesn't reach the Management Service
+	reports := make(chan *mgmtProto.FeedbackRequest, 10)
+	failReport := true
+	mgmtClient := &mgmt.MockClient{
+		SendFeedbackFunc: func(feedback *mgmtProto.FeedbackRequest) error {
+			if failReport {
+				failReport = false
+				return fmt.Errorf("management is unavailable")
+			}
+			reports <- feedback
+			return nil
+		},
+	}
+	// the Signal Service acknowledges that the remote peer isn't connected
+	signalClient := &signal.MockClient{
+		SendFunc: func(msg *sProto.Message) error {
+			return fmt.Errorf("%w: %s", signal.ErrPeerNotConnected, msg.GetRemoteKey())
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine := NewEngine(ctx, cancel, signalClient, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun120",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33120,
+	})
+	conflict := allowedIPsConflict{prefix: "10.10.0.0/16", peer: "peerB", conflictingPrefix: "10.10.0.0/16",
+		conflictingPeer: "peerA", duplicate: true}
+	engine.allowedIPsConflicts = []allowedIPsConflict{conflict}
+
+	conn, err := engine.createPeerConn(peerKey, "100.64.0.10/32", 0)
+	require.NoError(t, err)
+	err = conn.Open()
+	assert.Equal(t, peer.FailurePeerOffline, peer.FailureClassOf(err))
+
+	engine.watchConnOutcomes()
+
+	var feedback *mgmtProto.FeedbackRequest
+	select {
+	case feedback = <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection outcomes to be reported to the Management Service")
+	}
+
+	// the outcomes of the failed report are sent with the next one along with the current conflicts
+	require.Len(t, feedback.GetConnectionOutcomes(), 1)
+	outcome := feedback.GetConnectionOutcomes()[0]
+	assert.Equal(t, peerKey, outcome.GetPeer())
+	assert.Equal(t, uint32(0), outcome.GetConnected())
+	assert.Equal(t, map[string]uint32{string(peer.FailurePeerOffline): 1}, outcome.GetFailures())
+	require.Len(t, feedback.GetAllowedIpsConflicts(), 1)
+	assert.Equal(t, "peerA", feedback.GetAllowedIpsConflicts()[0].GetConflictingPeer())
+
+	// nothing is reported until another attempt has finished
+	select {
+	case feedback := <-reports:
+		t.Fatalf("expected no report without new outcomes, got %v", feedback)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	engine.recordConnOutcome(peerKey, "")
+	select {
+	case feedback = <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the established connection to be reported to the Management Service")
+	}
+	require.Len(t, feedback.GetConnectionOutcomes(), 1)
+	assert.Equal(t, uint32(1), feedback.GetConnectionOutcomes()[0].GetConnected())
+	assert.Empty(t, feedback.GetConnectionOutcomes()[0].GetFailures())
+}
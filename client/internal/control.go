@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sProto "github.com/netbirdio/netbird/signal/proto"
+)
+
+const (
+	// MaxControlMessageSize is the maximal size of the payload of a control message
+	MaxControlMessageSize = 4096
+	// controlMessageRate is the number of control messages per second a peer sends to and accepts from a remote peer
+	controlMessageRate = 5
+	// controlMessageBurst is the number of control messages a peer sends to and accepts from a remote peer at once
+	controlMessageBurst = 20
+)
+
+var (
+	// ErrControlMessageTooLarge is returned when the payload of a control message exceeds MaxControlMessageSize
+	ErrControlMessageTooLarge = errors.New("control message is too large")
+	// ErrControlMessageRateLimited is returned when too many control messages have been sent to a remote peer,
+	// the message can be sent again later
+	ErrControlMessageRateLimited = errors.New("control message rate limit exceeded")
+)
+
+// ControlMessageHandler receives the payload of a control message sent by a remote peer, see Engine.SendControlMessage.
+// The handler is called by the receiver of the Signal stream, so it shouldn't block
+type ControlMessageHandler func(peerKey string, payload []byte)
+
+// SetControlMessageHandler sets the handler of the control messages sent by the remote peers,
+// the messages are dropped if the handler is nil
+func (e *Engine) SetControlMessageHandler(handler ControlMessageHandler) {
+	e.controlMux.Lock()
+	defer e.controlMux.Unlock()
+	e.controlHandler = handler
+}
+
+// SendControlMessage sends an application control message (e.g. asking the remote peer to reconnect) to a remote peer
+// of the NetworkMap. The message is encrypted with the Wireguard keys of the peers and relayed by the Signal Service,
+// so the remote peer doesn't have to be connected. Returns an error wrapping signal.ErrPeerNotConnected if the remote
+// peer isn't connected to the Signal Service and ErrControlMessageRateLimited if too many messages have been sent to it
+func (e *Engine) SendControlMessage(peerKey string, payload []byte) error {
+	if len(payload) > MaxControlMessageSize {
+		return fmt.Errorf("%w: %d bytes, maximal size is %d bytes", ErrControlMessageTooLarge, len(payload),
+			MaxControlMessageSize)
+	}
+	if !e.peerExists(peerKey) {
+		return fmt.Errorf("peer %s isn't a remote peer of the network map", peerKey)
+	}
+	if !e.controlSendLimiter.allow(peerKey, time.Now()) {
+		return fmt.Errorf("peer %s: %w", peerKey, ErrControlMessageRateLimited)
+	}
+
+	return e.signal.Send(&sProto.Message{
+		Key:       e.config.WgPrivateKey.PublicKey().String(),
+		RemoteKey: peerKey,
+		Body: &sProto.Body{
+			Type: sProto.Body_CONTROL,
+			Data: payload,
+		},
+	})
+}
+
+// receiveControlMessage hands a control message of a remote peer to the handler. The messages of the peers missing in
+// the NetworkMap and the messages exceeding the rate limit of the remote peer are dropped
+func (e *Engine) receiveControlMessage(peerKey string, payload []byte) error {
+	if !e.peerExists(peerKey) {
+		return fmt.Errorf("control message of unknown peer %s", peerKey)
+	}
+	if !e.controlReceiveLimiter.allow(peerKey, time.Now()) {
+		log.Debugf("peer %s has exceeded the control message rate limit, dropping the message", peerKey)
+		return nil
+	}
+
+	e.controlMux.Lock()
+	handler := e.controlHandler
+	e.controlMux.Unlock()
+	if handler == nil {
+		log.Debugf("no control message handler set, dropping the message of peer %s", peerKey)
+		return nil
+	}
+
+	handler(peerKey, payload)
+	return nil
+}
+
+// controlRateLimiter is a token bucket per remote peer limiting the control messages, so the applications don't
+// flood the Signal Service and the remote peers
+type controlRateLimiter struct {
+	// rate is the number of tokens added per second and burst the maximal number of tokens of a bucket
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newControlRateLimiter(rate float64, burst int) *controlRateLimiter {
+	return &controlRateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token of the remote peer, returns false if the bucket of the peer is empty
+func (l *controlRateLimiter) allow(peerKey string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[peerKey]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updatedAt: now}
+		l.buckets[peerKey] = bucket
+	}
+
+	if elapsed := now.Sub(bucket.updatedAt); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * l.rate
+		if bucket.tokens > l.burst {
+			bucket.tokens = l.burst
+		}
+		bucket.updatedAt = now
+	}
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// remove forgets the bucket of a removed remote peer
+func (l *controlRateLimiter) remove(peerKey string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.buckets, peerKey)
+}
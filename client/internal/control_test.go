@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netbirdio/netbird/testutil"
+)
+
+func TestControlRateLimiter(t *testing.T) {
+	limiter := newControlRateLimiter(2, 3)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("peer", now), "expecting the burst to be allowed")
+	}
+	assert.False(t, limiter.allow("peer", now), "expecting the messages exceeding the burst to be limited")
+	assert.True(t, limiter.allow("other", now), "expecting the peers to be limited separately")
+
+	// 2 messages per second
+	assert.True(t, limiter.allow("peer", now.Add(500*time.Millisecond)))
+	assert.False(t, limiter.allow("peer", now.Add(500*time.Millisecond)))
+
+	// the bucket doesn't fill up beyond the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("peer", later))
+	}
+	assert.False(t, limiter.allow("peer", later))
+
+	limiter.remove("peer")
+	assert.True(t, limiter.allow("peer", later), "expecting a removed peer to start with a full bucket")
+}
+
+func TestEngine_ControlMessages(t *testing.T) {
+	// the harness gives the peers their own ports and interfaces
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	services := startTestServices(t, testutil.Options{})
+
+	var engines []*Engine
+	for i := 0; i < 2; i++ {
+		engine, err := createEngine(ctx, cancel, services, "")
+		require.NoError(t, err)
+		err = engine.Start()
+		require.NoError(t, err)
+		engines = append(engines, engine)
+	}
+	defer func() {
+		for _, engine := range engines {
+			_ = engine.mgmClient.Close()
+			err := engine.Stop()
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	sender, receiver := engines[0], engines[1]
+	senderKey := sender.config.WgPrivateKey.PublicKey().String()
+	receiverKey := receiver.config.WgPrivateKey.PublicKey().String()
+
+	// the peers exchange the messages once they are in the network maps of each other
+	require.Eventually(t, func() bool {
+		return sender.peerExists(receiverKey) && receiver.peerExists(senderKey)
+	}, 10*time.Second, 100*time.Millisecond, "expecting the peers to receive the network maps")
+
+	type controlMessage struct {
+		peerKey string
+		payload string
+	}
+	received := make(chan controlMessage, 10)
+	receiver.SetControlMessageHandler(func(peerKey string, payload []byte) {
+		received <- controlMessage{peerKey: peerKey, payload: string(payload)}
+	})
+
+	err := sender.SendControlMessage(receiverKey, []byte("please reconnect"))
+	require.NoError(t, err)
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, controlMessage{peerKey: senderKey, payload: "please reconnect"}, msg)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting the remote peer to receive the control message")
+	}
+
+	err = sender.SendControlMessage(receiverKey, make([]byte, MaxControlMessageSize+1))
+	assert.True(t, errors.Is(err, ErrControlMessageTooLarge), "expecting a too large message to be rejected, got %v", err)
+
+	err = sender.SendControlMessage("unknown", []byte("hello"))
+	assert.Error(t, err, "expecting a message to a peer missing in the network map to be rejected")
+
+	// the burst has been taken by the first message and the rejected ones don't count
+	for i := 1; i < controlMessageBurst; i++ {
+		err = sender.SendControlMessage(receiverKey, []byte("hello"))
+		require.NoError(t, err)
+	}
+	err = sender.SendControlMessage(receiverKey, []byte("hello"))
+	assert.True(t, errors.Is(err, ErrControlMessageRateLimited), "expecting the messages exceeding the burst to be limited, got %v", err)
+}
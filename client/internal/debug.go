@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"sort"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	"github.com/netbirdio/netbird/iface"
+)
+
+// RedactedSecret replaces the secrets in the redacted snapshots
+const RedactedSecret = "**********"
+
+// DebugSnapshot is the state of the running Engine included in the debug bundle, free of keys and credentials
+type DebugSnapshot struct {
+	// NetworkSerial is the serial of the latest NetworkMap applied
+	NetworkSerial uint64
+	// PeerCount is the number of the remote peers of the latest NetworkMap applied
+	PeerCount int
+	// Interface is the name of the Wireguard interface and Address its address in the CIDR notation
+	Interface string
+	Address   string
+	// Status is the connectivity status of the Engine
+	Status EngineStatus
+	// Peers are the ICE details of the connections to the remote peers sorted by the peer key
+	Peers []PeerDebugInfo
+}
+
+// PeerDebugInfo is the ICE details of the connection to a remote peer
+type PeerDebugInfo struct {
+	PubKey   string
+	ConnType peer.ConnType
+	IPFamily peer.IPFamily
+	// RemoteAddress is the address of the selected remote candidate and RelayAddress the address of the TURN relay,
+	// empty if the peer isn't connected or the connection isn't relayed respectively
+	RemoteAddress string
+	RelayAddress  string
+	LastFailure   peer.FailureClass
+	// Trace is the timeline of the latest connection attempt, empty if the tracing is disabled
+	Trace []peer.TraceEvent
+}
+
+// DebugSnapshot returns the state of the Engine for the debug bundle
+func (e *Engine) DebugSnapshot() DebugSnapshot {
+	snapshot := DebugSnapshot{Status: e.GetStatus(), Peers: []PeerDebugInfo{}}
+
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	snapshot.NetworkSerial = e.networkSerial
+	snapshot.PeerCount = len(e.peerConns)
+	snapshot.Interface = e.config.WgIfaceName
+	snapshot.Address = e.config.WgAddr
+
+	for key, conn := range e.peerConns {
+		snapshot.Peers = append(snapshot.Peers, PeerDebugInfo{
+			PubKey:        key,
+			ConnType:      conn.ConnType(),
+			IPFamily:      conn.IPFamily(),
+			RemoteAddress: conn.RemoteAddress(),
+			RelayAddress:  conn.RelayAddress(),
+			LastFailure:   conn.LastFailure(),
+			Trace:         conn.Trace(),
+		})
+	}
+	sort.Slice(snapshot.Peers, func(i, j int) bool {
+		return snapshot.Peers[i].PubKey < snapshot.Peers[j].PubKey
+	})
+
+	return snapshot
+}
+
+// StartPacketCapture starts capturing the packets of the Wireguard interface to a pcap file at the path,
+// the capture stops once it is closed, the file would exceed maxBytes or the interface is removed
+func (e *Engine) StartPacketCapture(path string, maxBytes int64) (*iface.PacketCapture, error) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	return e.wgInterface.StartCapture(path, maxBytes)
+}
+
+// Redacted returns a copy of the config with the keys replaced by RedactedSecret, suitable for the debug bundle
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	for _, secret := range []*string{&redacted.PrivateKey, &redacted.EncryptedPrivateKey, &redacted.PreSharedKey, &redacted.SSHKey,
+		&redacted.ClientPKCS12Password} {
+		if *secret != "" {
+			*secret = RedactedSecret
+		}
+	}
+	return &redacted
+}
+
+// Secrets returns the keys of the config that must not leave the machine, e.g. to be scrubbed from the logs
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, secret := range []string{c.PrivateKey, c.EncryptedPrivateKey, c.PreSharedKey, c.SSHKey, c.ClientPKCS12Password} {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	// the key encrypted at rest is in plaintext in memory, e.g. in the output of wg
+	if c.EncryptedPrivateKey != "" {
+		if key, err := c.decryptPrivateKey(); err == nil {
+			secrets = append(secrets, key.String())
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,10 @@
+package dns
+
+import "net"
+
+// hostManager configures the resolver of the OS to send the queries of the domains, the zone followed by the match
+// domains of the upstream nameservers, to the responder listening on the IP and the port of the Wireguard interface
+type hostManager interface {
+	applyDNSConfig(ifaceName string, ip net.IP, port int, domains []string) error
+	restoreHostDNS(ifaceName string, domains []string) error
+}
@@ -0,0 +1,43 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// scutilHostManager publishes a supplemental DNS configuration of the domains in the dynamic store of the system
+// configuration with scutil, so the resolver of macOS sends only the queries of the domains to the responder
+type scutilHostManager struct{}
+
+func newHostManager() hostManager {
+	return scutilHostManager{}
+}
+
+func (scutilHostManager) applyDNSConfig(ifaceName string, ip net.IP, port int, domains []string) error {
+	return runScutil(fmt.Sprintf("d.init\n"+
+		"d.add ServerAddresses * %s\n"+
+		"d.add ServerPort # %d\n"+
+		"d.add SupplementalMatchDomains * %s\n"+
+		"set %s\n", ip, port, strings.Join(domains, " "), scutilKey(ifaceName)))
+}
+
+func (scutilHostManager) restoreHostDNS(ifaceName string, _ []string) error {
+	return runScutil(fmt.Sprintf("remove %s\n", scutilKey(ifaceName)))
+}
+
+// scutilKey returns the key of the DNS configuration of the interface in the dynamic store
+func scutilKey(ifaceName string) string {
+	return "State:/Network/Service/Netbird-" + ifaceName + "/DNS"
+}
+
+func runScutil(commands string) error {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader(commands + "quit\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("scutil failed: %v, output: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	resolvedDest    = "org.freedesktop.resolve1"
+	resolvedPath    = "/org/freedesktop/resolve1"
+	resolvedManager = "org.freedesktop.resolve1.Manager"
+)
+
+// resolvedDNSAddress is a DNS server of a link of systemd-resolved
+type resolvedDNSAddress struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedDNSAddressEx is a DNS server of a link of systemd-resolved listening on a custom port, systemd 246 or later
+type resolvedDNSAddressEx struct {
+	Family  int32
+	Address []byte
+	Port    uint16
+	Name    string
+}
+
+// resolvedDomain is a domain of a link of systemd-resolved, a routing only one isn't used as a search domain
+type resolvedDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// resolvedHostManager configures systemd-resolved through D-Bus to send the queries of the domains to the DNS server
+// of the Wireguard interface link. The link configuration is dropped by systemd-resolved when the interface is removed
+type resolvedHostManager struct{}
+
+func newHostManager() hostManager {
+	return resolvedHostManager{}
+}
+
+func (resolvedHostManager) applyDNSConfig(ifaceName string, ip net.IP, port int, domains []string) error {
+	link, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return err
+	}
+	manager, err := resolvedObject()
+	if err != nil {
+		return err
+	}
+
+	family, address := int32(unix.AF_INET), ip.To4()
+	if address == nil {
+		family, address = unix.AF_INET6, ip.To16()
+	}
+	if port == DefaultPort {
+		err = manager.Call(resolvedManager+".SetLinkDNS", 0, int32(link.Index),
+			[]resolvedDNSAddress{{Family: family, Address: address}}).Err
+	} else {
+		err = manager.Call(resolvedManager+".SetLinkDNSEx", 0, int32(link.Index),
+			[]resolvedDNSAddressEx{{Family: family, Address: address, Port: uint16(port)}}).Err
+	}
+	if err != nil {
+		return fmt.Errorf("failed setting DNS server of link %s in systemd-resolved: %w", ifaceName, err)
+	}
+
+	linkDomains := make([]resolvedDomain, 0, len(domains))
+	for _, domain := range domains {
+		linkDomains = append(linkDomains, resolvedDomain{Domain: domain, RoutingOnly: true})
+	}
+	err = manager.Call(resolvedManager+".SetLinkDomains", 0, int32(link.Index), linkDomains).Err
+	if err != nil {
+		return fmt.Errorf("failed setting domains of link %s in systemd-resolved: %w", ifaceName, err)
+	}
+	return nil
+}
+
+func (resolvedHostManager) restoreHostDNS(ifaceName string, _ []string) error {
+	link, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		// systemd-resolved has dropped the configuration of the removed link
+		return nil
+	}
+	manager, err := resolvedObject()
+	if err != nil {
+		return err
+	}
+	return manager.Call(resolvedManager+".RevertLink", 0, int32(link.Index)).Err
+}
+
+// resolvedObject returns the manager object of systemd-resolved, an error if it isn't running
+func resolvedObject() (dbus.BusObject, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("systemd-resolved is unavailable: %w", err)
+	}
+	return conn.Object(resolvedDest, dbus.ObjectPath(resolvedPath)), nil
+}
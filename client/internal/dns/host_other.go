@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package dns
+
+import (
+	"errors"
+	"net"
+)
+
+// unsupportedHostManager leaves the resolver of the OS unconfigured, the responder can be queried directly only
+type unsupportedHostManager struct{}
+
+func newHostManager() hostManager {
+	return unsupportedHostManager{}
+}
+
+func (unsupportedHostManager) applyDNSConfig(string, net.IP, int, []string) error {
+	return errors.New("configuring the resolver of the system isn't supported on this platform")
+}
+
+func (unsupportedHostManager) restoreHostDNS(string, []string) error {
+	return nil
+}
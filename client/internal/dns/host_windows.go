@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// nrptRulesKey is the registry key of the Name Resolution Policy Table rules of the DNS client
+const nrptRulesKey = `SYSTEM\CurrentControlSet\Services\Dnscache\Parameters\DnsPolicyConfig`
+
+// nrptHostManager adds a Name Resolution Policy Table rule sending the queries of the domains to the responder.
+// The table has no port setting, so the responder has to listen on DefaultPort
+type nrptHostManager struct{}
+
+func newHostManager() hostManager {
+	return nrptHostManager{}
+}
+
+func (nrptHostManager) applyDNSConfig(ifaceName string, ip net.IP, port int, domains []string) error {
+	if port != DefaultPort {
+		return fmt.Errorf("the Name Resolution Policy Table supports DNS servers on port %d only", DefaultPort)
+	}
+
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, nrptRuleKey(ifaceName), registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed creating NRPT rule: %w", err)
+	}
+	defer key.Close() //nolint
+
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, "."+domain)
+	}
+	values := []func() error{
+		func() error { return key.SetDWordValue("Version", 2) },
+		func() error { return key.SetStringsValue("Name", names) },
+		func() error { return key.SetStringValue("GenericDNSServers", ip.String()) },
+		// 0x8 makes the rule a generic DNS server one
+		func() error { return key.SetDWordValue("ConfigOptions", 0x8) },
+		func() error { return key.SetStringValue("Comment", "Netbird peers of zone "+domains[0]) },
+	}
+	for _, setValue := range values {
+		if err := setValue(); err != nil {
+			return fmt.Errorf("failed setting NRPT rule: %w", err)
+		}
+	}
+
+	flushDNSCache()
+	return nil
+}
+
+func (nrptHostManager) restoreHostDNS(ifaceName string, _ []string) error {
+	err := registry.DeleteKey(registry.LOCAL_MACHINE, nrptRuleKey(ifaceName))
+	if err != nil && err != registry.ErrNotExist {
+		return fmt.Errorf("failed removing NRPT rule: %w", err)
+	}
+	flushDNSCache()
+	return nil
+}
+
+// nrptRuleKey returns the registry key of the rule of the interface
+func nrptRuleKey(ifaceName string) string {
+	return nrptRulesKey + `\Netbird-` + ifaceName
+}
+
+// flushDNSCache drops the cached answers, including the negative ones, of the names of the domains
+func flushDNSCache() {
+	out, err := exec.Command("ipconfig", "/flushdns").CombinedOutput()
+	if err != nil {
+		log.Debugf("failed flushing DNS cache: %v, output: %s", err, out)
+	}
+}
@@ -0,0 +1,6 @@
+package dns
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the DNS responder of the client, its level is set by the dns component level
+var log = util.Logger("dns")
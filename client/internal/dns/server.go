@@ -0,0 +1,454 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultPort is the port the responder listens on if not configured, the only one supported by all the OS resolvers
+	DefaultPort = 53
+	// recordTTL is the TTL of the answers in seconds, short because the records change with the NetworkMap
+	recordTTL = 60
+	// maxMessageSize is the size of a DNS message over UDP without EDNS0
+	maxMessageSize = 512
+	// maxForwardedSize is the size of the largest response of an upstream nameserver relayed to the querier
+	maxForwardedSize = 65535
+	// upstreamTimeout is how long an upstream nameserver is waited for before trying the next one
+	upstreamTimeout = 2 * time.Second
+)
+
+// Server is a DNS responder answering the A and AAAA queries for the names of the peers of a zone (e.g. wt.local).
+// It listens on the address of the Wireguard interface and integrates with the resolver of the OS, so only the names
+// of the zone are resolved by it. The queries of the match domains of the upstream nameservers are forwarded to them,
+// the other queries outside the zone are refused, the responder doesn't recurse
+type Server struct {
+	mux sync.RWMutex
+	// zone is the lowercase fully qualified zone, e.g. wt.local.
+	zone string
+	// records are the IPs of the names of the peers by the lowercase name relative to the zone
+	records map[string][]net.IP
+	// customRecords are the IPs of the custom names by the lowercase name relative to the zone,
+	// they take precedence over the names of the peers
+	customRecords map[string][]net.IP
+	// upstreams are the addresses, IP:port, of the nameservers by the lowercase fully qualified match domain
+	upstreams map[string][]string
+
+	conn net.PacketConn
+	host hostManager
+	// ifaceName is the interface the OS resolver has been configured for, empty if it hasn't been
+	ifaceName string
+	// ip and port are the address the responder listens on
+	ip   net.IP
+	port int
+}
+
+// NewServer creates a DNS responder of the zone. It doesn't listen until started
+func NewServer(zone string) (*Server, error) {
+	if err := ValidateZone(zone); err != nil {
+		return nil, err
+	}
+	return &Server{
+		zone:          fqdn(zone),
+		records:       map[string][]net.IP{},
+		customRecords: map[string][]net.IP{},
+		upstreams:     map[string][]string{},
+		host:          newHostManager(),
+	}, nil
+}
+
+// Zone returns the zone of the responder without the trailing dot
+func (s *Server) Zone() string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return strings.TrimSuffix(s.zone, ".")
+}
+
+// UpdateZone moves the names to another zone and reconfigures the OS resolver if it has been configured
+func (s *Server) UpdateZone(zone string) error {
+	if err := ValidateZone(zone); err != nil {
+		return err
+	}
+	oldZone := s.Zone()
+	zone = fqdn(zone)
+	if zone == oldZone+"." {
+		return nil
+	}
+
+	oldDomains := s.domains()
+	s.mux.Lock()
+	s.zone = zone
+	s.mux.Unlock()
+	log.Infof("resolving names of zone %s instead of %s", s.Zone(), oldZone)
+
+	return s.reconfigureHost(oldDomains)
+}
+
+// UpdateUpstreams replaces the upstream nameservers, the addresses as IP:port by the match domains, and reconfigures
+// the OS resolver if it has been configured. The queries of a match domain and of its subdomains are forwarded to its
+// nameservers, tried in order, the most specific match domain wins and the zone takes precedence
+func (s *Server) UpdateUpstreams(upstreams map[string][]string) error {
+	updated := make(map[string][]string, len(upstreams))
+	for domain, nameServers := range upstreams {
+		if err := ValidateZone(domain); err != nil {
+			return fmt.Errorf("invalid match domain: %w", err)
+		}
+		updated[fqdn(domain)] = nameServers
+	}
+
+	oldDomains := s.domains()
+	s.mux.Lock()
+	s.upstreams = updated
+	s.mux.Unlock()
+
+	if domainsEqual(oldDomains, s.domains()) {
+		return nil
+	}
+	return s.reconfigureHost(oldDomains)
+}
+
+// domains returns the zone followed by the sorted match domains outside of it without the trailing dots
+func (s *Server) domains() []string {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	matchDomains := make([]string, 0, len(s.upstreams))
+	for domain := range s.upstreams {
+		if domain == s.zone || strings.HasSuffix(domain, "."+s.zone) {
+			continue
+		}
+		matchDomains = append(matchDomains, strings.TrimSuffix(domain, "."))
+	}
+	sort.Strings(matchDomains)
+	return append([]string{strings.TrimSuffix(s.zone, ".")}, matchDomains...)
+}
+
+func domainsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reconfigureHost replaces the configuration of the OS resolver for the old domains if it has been configured
+func (s *Server) reconfigureHost(oldDomains []string) error {
+	if s.ifaceName == "" {
+		return nil
+	}
+	err := s.host.restoreHostDNS(s.ifaceName, oldDomains)
+	if err != nil {
+		log.Warnf("failed removing the resolver configuration of domains %v: %v", oldDomains, err)
+	}
+	domains := s.domains()
+	err = s.host.applyDNSConfig(s.ifaceName, s.ip, s.port, domains)
+	if err != nil {
+		s.ifaceName = ""
+		return fmt.Errorf("failed configuring the resolver of the system for domains %v: %w", domains, err)
+	}
+	return nil
+}
+
+// Start listens on the IP of the interface and configures the OS resolver to send it the queries of the zone.
+// A failure to configure the OS resolver is logged only, the responder can still be queried directly
+func (s *Server) Start(ifaceName string, ip net.IP, port int) error {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed listening for DNS queries on %s port %d: %w", ip, port, err)
+	}
+	s.conn = conn
+	s.ip = ip
+	s.port = conn.LocalAddr().(*net.UDPAddr).Port
+	go s.serve(conn)
+	log.Infof("resolving names of zone %s on %s", s.Zone(), conn.LocalAddr())
+
+	err = s.host.applyDNSConfig(ifaceName, ip, s.port, s.domains())
+	if err != nil {
+		log.Warnf("failed configuring the resolver of the system for zone %s, query %s directly: %v",
+			s.Zone(), conn.LocalAddr(), err)
+		return nil
+	}
+	s.ifaceName = ifaceName
+	return nil
+}
+
+// Stop removes the configuration of the OS resolver and stops listening
+func (s *Server) Stop() error {
+	var err error
+	if s.ifaceName != "" {
+		err = s.host.restoreHostDNS(s.ifaceName, s.domains())
+		if err != nil {
+			err = fmt.Errorf("failed removing the resolver configuration of zone %s: %w", s.Zone(), err)
+		}
+		s.ifaceName = ""
+	}
+	if s.conn != nil {
+		if closeErr := s.conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		s.conn = nil
+	}
+	return err
+}
+
+// Addr returns the address the responder listens on, nil if it hasn't been started
+func (s *Server) Addr() net.Addr {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.LocalAddr()
+}
+
+// UpdateRecords replaces the records of the names of the peers with the IPs of the names, the names are relative to the zone
+func (s *Server) UpdateRecords(records map[string][]net.IP) {
+	updated := lowercaseNames(records)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.records = updated
+}
+
+// UpdateCustomRecords replaces the custom records of the zone, the names are relative to the zone.
+// A custom name takes precedence over the name of a peer
+func (s *Server) UpdateCustomRecords(records map[string][]net.IP) {
+	updated := lowercaseNames(records)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.customRecords = updated
+}
+
+func lowercaseNames(records map[string][]net.IP) map[string][]net.IP {
+	lowercase := make(map[string][]net.IP, len(records))
+	for name, ips := range records {
+		lowercase[strings.ToLower(name)] = ips
+	}
+	return lowercase
+}
+
+// fqdn returns the lowercase fully qualified domain
+func fqdn(domain string) string {
+	return strings.ToLower(strings.Trim(domain, ".")) + "."
+}
+
+// serve answers the queries received on the connection until it is closed. Every query is answered in its own
+// goroutine, so a slow upstream nameserver doesn't hold up the others
+func (s *Server) serve(conn net.PacketConn) {
+	for {
+		buf := make([]byte, maxMessageSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debugf("failed reading DNS query: %v", err)
+			continue
+		}
+
+		go func(query []byte, addr net.Addr) {
+			resp, err := s.answer(query)
+			if err != nil {
+				log.Debugf("dropping DNS query from %s: %v", addr, err)
+				return
+			}
+			_, err = conn.WriteTo(resp, addr)
+			if err != nil {
+				log.Debugf("failed sending DNS response to %s: %v", addr, err)
+			}
+		}(buf[:n], addr)
+	}
+}
+
+// answer returns the response to the query, an error if the query is malformed and is dropped
+func (s *Server) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if header.Response {
+		return nil, errors.New("not a query")
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	respHeader := dnsmessage.Header{
+		ID:               header.ID,
+		Response:         true,
+		OpCode:           header.OpCode,
+		RecursionDesired: header.RecursionDesired,
+	}
+	name := strings.ToLower(question.Name.String())
+
+	s.mux.RLock()
+	var ips []net.IP
+	var upstreams []string
+	switch {
+	case header.OpCode != 0:
+		respHeader.RCode = dnsmessage.RCodeNotImplemented
+	case name != s.zone && !strings.HasSuffix(name, "."+s.zone):
+		if upstreams = s.upstreamsOf(name); upstreams == nil {
+			respHeader.RCode = dnsmessage.RCodeRefused
+		}
+	default:
+		respHeader.Authoritative = true
+		relative := strings.TrimSuffix(strings.TrimSuffix(name, s.zone), ".")
+		found := true
+		if ips = s.customRecords[relative]; ips == nil {
+			ips, found = s.records[relative]
+		}
+		if !found && name != s.zone {
+			respHeader.RCode = dnsmessage.RCodeNameError
+		}
+	}
+	s.mux.RUnlock()
+
+	if upstreams != nil {
+		resp, err := forward(query, header.ID, upstreams)
+		if err == nil {
+			return resp, nil
+		}
+		log.Debugf("failed forwarding DNS query of %s: %v", name, err)
+		respHeader.RCode = dnsmessage.RCodeServerFailure
+	}
+
+	builder := dnsmessage.NewBuilder(make([]byte, 0, maxMessageSize), respHeader)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	resourceHeader := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: recordTTL}
+	for _, ip := range ips {
+		switch ip4 := ip.To4(); {
+		case question.Type == dnsmessage.TypeA && ip4 != nil:
+			var a [4]byte
+			copy(a[:], ip4)
+			err = builder.AResource(resourceHeader, dnsmessage.AResource{A: a})
+		case question.Type == dnsmessage.TypeAAAA && ip4 == nil:
+			var aaaa [16]byte
+			copy(aaaa[:], ip.To16())
+			err = builder.AAAAResource(resourceHeader, dnsmessage.AAAAResource{AAAA: aaaa})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// upstreamsOf returns the nameservers of the most specific match domain of the fully qualified name, nil if none.
+// The caller holds the lock
+func (s *Server) upstreamsOf(name string) []string {
+	for domain := name; domain != "."; {
+		if upstreams, ok := s.upstreams[domain]; ok {
+			return upstreams
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			return nil
+		}
+		domain = domain[i+1:]
+	}
+	return nil
+}
+
+// forward sends the query to the nameservers in order and returns the first response matching its ID
+func forward(query []byte, id uint16, upstreams []string) ([]byte, error) {
+	var err error
+	for _, upstream := range upstreams {
+		var resp []byte
+		resp, err = exchange(query, id, upstream)
+		if err == nil {
+			return resp, nil
+		}
+		log.Debugf("upstream nameserver %s failed: %v", upstream, err)
+	}
+	return nil, err
+}
+
+// exchange sends the query to the nameserver over UDP and waits for the response until upstreamTimeout
+func exchange(query []byte, id uint16, upstream string) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", upstream, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() //nolint
+	err = conn.SetDeadline(time.Now().Add(upstreamTimeout))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxForwardedSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil || !header.Response || header.ID != id {
+			// not the response to the query, keep waiting
+			continue
+		}
+		return buf[:n], nil
+	}
+}
+
+// ValidateZone returns an error if the zone isn't a domain of letters, digits and hyphens
+func ValidateZone(zone string) error {
+	zone = strings.ToLower(strings.Trim(zone, "."))
+	if zone == "" {
+		return errors.New("empty DNS zone")
+	}
+	for _, label := range strings.Split(zone, ".") {
+		if label == "" || len(label) > 63 || Label(label) != label {
+			return fmt.Errorf("invalid DNS zone %s, use a domain of letters, digits and hyphens", zone)
+		}
+	}
+	return nil
+}
+
+// Label returns the name of a peer as a DNS label: lowercase letters, digits and hyphens, e.g. "Bob's Laptop"
+// becomes bob-s-laptop. Empty if nothing of the name is left
+func Label(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			hyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		hyphen = true
+	}
+	label := b.String()
+	if len(label) > 63 {
+		label = strings.TrimSuffix(label[:63], "-")
+	}
+	return label
+}
@@ -0,0 +1,233 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeHostManager records the resolver configuration of the system, the space separated domains by the interface
+type fakeHostManager struct {
+	configured map[string]string
+}
+
+func (h *fakeHostManager) applyDNSConfig(ifaceName string, ip net.IP, port int, domains []string) error {
+	h.configured[ifaceName] = strings.Join(domains, " ")
+	return nil
+}
+
+func (h *fakeHostManager) restoreHostDNS(ifaceName string, _ []string) error {
+	delete(h.configured, ifaceName)
+	return nil
+}
+
+func query(t *testing.T, addr net.Addr, name string, qtype dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := req.Pack()
+	require.NoError(t, err)
+
+	conn, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close() //nolint
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err = conn.Write(packed)
+	require.NoError(t, err)
+
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	var resp dnsmessage.Message
+	require.NoError(t, resp.Unpack(buf[:n]))
+	assert.Equal(t, uint16(42), resp.Header.ID)
+	return resp
+}
+
+func answeredIPs(resp dnsmessage.Message) []string {
+	var ips []string
+	for _, answer := range resp.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]).String())
+		}
+	}
+	return ips
+}
+
+func TestServer(t *testing.T) {
+	server, err := NewServer("WT.local.")
+	require.NoError(t, err)
+	host := &fakeHostManager{configured: map[string]string{}}
+	server.host = host
+
+	err = server.Start("wt0", net.IPv4(127, 0, 0, 1), 0)
+	require.NoError(t, err)
+	defer server.Stop() //nolint
+	assert.Equal(t, map[string]string{"wt0": "wt.local"}, host.configured)
+
+	server.UpdateRecords(map[string][]net.IP{
+		"laptop": {net.ParseIP("100.64.0.10")},
+		"server": {net.ParseIP("100.64.0.11"), net.ParseIP("fd00::11")},
+	})
+
+	resp := query(t, server.Addr(), "Laptop.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, resp.RCode)
+	assert.True(t, resp.Authoritative)
+	assert.Equal(t, []string{"100.64.0.10"}, answeredIPs(resp))
+
+	resp = query(t, server.Addr(), "server.wt.local.", dnsmessage.TypeAAAA)
+	assert.Equal(t, []string{"fd00::11"}, answeredIPs(resp))
+
+	// the name exists, but it has no IPv6 address
+	resp = query(t, server.Addr(), "laptop.wt.local.", dnsmessage.TypeAAAA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, resp.RCode)
+	assert.Empty(t, resp.Answers)
+
+	resp = query(t, server.Addr(), "phone.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+
+	// no recursion outside the zone
+	resp = query(t, server.Addr(), "example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, resp.RCode)
+	assert.False(t, resp.RecursionAvailable)
+	assert.Empty(t, resp.Answers)
+
+	// the records follow the NetworkMap
+	server.UpdateRecords(map[string][]net.IP{"phone": {net.ParseIP("100.64.0.12")}})
+	resp = query(t, server.Addr(), "phone.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"100.64.0.12"}, answeredIPs(resp))
+	resp = query(t, server.Addr(), "laptop.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+
+	require.NoError(t, server.Stop())
+	assert.Empty(t, host.configured)
+	assert.Nil(t, server.Addr())
+}
+
+func TestServer_PushedConfig(t *testing.T) {
+	server, err := NewServer("wt.local")
+	require.NoError(t, err)
+	host := &fakeHostManager{configured: map[string]string{}}
+	server.host = host
+
+	err = server.Start("wt0", net.IPv4(127, 0, 0, 1), 0)
+	require.NoError(t, err)
+	defer server.Stop() //nolint
+
+	server.UpdateRecords(map[string][]net.IP{"laptop": {net.ParseIP("100.64.0.10")}})
+	server.UpdateCustomRecords(map[string][]net.IP{
+		"DB":     {net.ParseIP("10.0.0.5")},
+		"laptop": {net.ParseIP("10.0.0.6")},
+	})
+
+	resp := query(t, server.Addr(), "db.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"10.0.0.5"}, answeredIPs(resp))
+	// a custom name takes precedence over the name of a peer
+	resp = query(t, server.Addr(), "laptop.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"10.0.0.6"}, answeredIPs(resp))
+
+	require.NoError(t, server.UpdateZone("NetBird.cloud."))
+	assert.Equal(t, "netbird.cloud", server.Zone())
+	assert.Equal(t, map[string]string{"wt0": "netbird.cloud"}, host.configured)
+	resp = query(t, server.Addr(), "db.netbird.cloud.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"10.0.0.5"}, answeredIPs(resp))
+	resp = query(t, server.Addr(), "db.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, resp.RCode)
+
+	assert.Error(t, server.UpdateZone("net_bird.cloud"))
+	assert.Equal(t, "netbird.cloud", server.Zone())
+
+	// removing the custom records uncovers the names of the peers
+	server.UpdateCustomRecords(nil)
+	resp = query(t, server.Addr(), "laptop.netbird.cloud.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"100.64.0.10"}, answeredIPs(resp))
+	resp = query(t, server.Addr(), "db.netbird.cloud.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+}
+
+func TestServer_Upstreams(t *testing.T) {
+	upstream, err := NewServer("corp.example")
+	require.NoError(t, err)
+	upstream.host = &fakeHostManager{configured: map[string]string{}}
+	require.NoError(t, upstream.Start("upstream0", net.IPv4(127, 0, 0, 1), 0))
+	defer upstream.Stop() //nolint
+	upstream.UpdateRecords(map[string][]net.IP{"db.eu": {net.ParseIP("10.0.0.5")}})
+
+	// a closed port makes the first nameserver fail
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, closed.Close())
+
+	server, err := NewServer("wt.local")
+	require.NoError(t, err)
+	host := &fakeHostManager{configured: map[string]string{}}
+	server.host = host
+	require.NoError(t, server.Start("wt0", net.IPv4(127, 0, 0, 1), 0))
+	defer server.Stop() //nolint
+	server.UpdateRecords(map[string][]net.IP{"laptop": {net.ParseIP("100.64.0.10")}})
+
+	err = server.UpdateUpstreams(map[string][]string{
+		"Corp.Example.": {closed.LocalAddr().String(), upstream.Addr().String()},
+		// the zone takes precedence over a match domain
+		"wt.local": {closed.LocalAddr().String()},
+		// the closed nameserver only, the queries fail
+		"eu.corp.example": {closed.LocalAddr().String()},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"wt0": "wt.local corp.example eu.corp.example"}, host.configured)
+
+	resp := query(t, server.Addr(), "db.eu.corp.example.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeServerFailure, resp.RCode)
+	assert.Empty(t, resp.Answers)
+
+	require.NoError(t, server.UpdateUpstreams(map[string][]string{
+		"corp.example": {closed.LocalAddr().String(), upstream.Addr().String()},
+	}))
+	assert.Equal(t, map[string]string{"wt0": "wt.local corp.example"}, host.configured)
+
+	resp = query(t, server.Addr(), "DB.eu.corp.example.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeSuccess, resp.RCode)
+	assert.True(t, resp.Authoritative, "expecting the answer of the upstream nameserver")
+	assert.Equal(t, []string{"10.0.0.5"}, answeredIPs(resp))
+	resp = query(t, server.Addr(), "cache.corp.example.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode)
+	resp = query(t, server.Addr(), "laptop.wt.local.", dnsmessage.TypeA)
+	assert.Equal(t, []string{"100.64.0.10"}, answeredIPs(resp))
+	resp = query(t, server.Addr(), "example.com.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, resp.RCode)
+
+	// the match domains move with the zone
+	require.NoError(t, server.UpdateZone("netbird.cloud"))
+	assert.Equal(t, map[string]string{"wt0": "netbird.cloud corp.example"}, host.configured)
+
+	assert.Error(t, server.UpdateUpstreams(map[string][]string{"corp_example": {upstream.Addr().String()}}))
+
+	require.NoError(t, server.UpdateUpstreams(nil))
+	assert.Equal(t, map[string]string{"wt0": "netbird.cloud"}, host.configured)
+	resp = query(t, server.Addr(), "db.eu.corp.example.", dnsmessage.TypeA)
+	assert.Equal(t, dnsmessage.RCodeRefused, resp.RCode)
+}
+
+func TestValidateZone(t *testing.T) {
+	assert.NoError(t, ValidateZone("wt.local"))
+	assert.NoError(t, ValidateZone("My-Net.example.com."))
+	assert.Error(t, ValidateZone(""))
+	assert.Error(t, ValidateZone("wt..local"))
+	assert.Error(t, ValidateZone("wt_local"))
+}
+
+func TestLabel(t *testing.T) {
+	assert.Equal(t, "bob-s-laptop", Label("Bob's Laptop"))
+	assert.Equal(t, "db-1", Label("--db 1--"))
+	assert.Equal(t, "", Label("ü"))
+}
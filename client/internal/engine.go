@@ -2,24 +2,31 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"net/url"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/netbirdio/netbird/client/internal/dns"
 	"github.com/netbirdio/netbird/client/internal/peer"
 	"github.com/netbirdio/netbird/client/internal/proxy"
+	"github.com/netbirdio/netbird/client/ssh"
+	"github.com/netbirdio/netbird/client/system"
+	"github.com/netbirdio/netbird/encryption"
 	"github.com/netbirdio/netbird/iface"
 	mgm "github.com/netbirdio/netbird/management/client"
 	mgmProto "github.com/netbirdio/netbird/management/proto"
 	signal "github.com/netbirdio/netbird/signal/client"
 	sProto "github.com/netbirdio/netbird/signal/proto"
-	"github.com/netbirdio/netbird/util"
 	"github.com/pion/ice/v2"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // PeerConnectionTimeoutMax is a timeout of an initial connection attempt to a remote peer.
@@ -31,12 +38,30 @@ const (
 	PeerConnectionTimeoutMin = 30000 // ms
 )
 
+// systemInfoCheckInterval is an interval of checking whether the system information (e.g. hostname) has changed
+const systemInfoCheckInterval = time.Minute
+
+// cachedEndpointTTL is a grace period during which the last known working endpoint of a remote peer
+// is used to re-establish the connection while Signal is unavailable
+const cachedEndpointTTL = 30 * time.Minute
+
+// cachedRelayEndpointTTL is a grace period of the TURN relay address of a remote peer, the default lifetime of
+// a TURN allocation. The relay address is released once the remote peer stops refreshing the allocation
+const cachedRelayEndpointTTL = 10 * time.Minute
+
 var ErrResetConnection = fmt.Errorf("reset connection")
 
+// previousAddrTimeout is how long the interface keeps its previous address after it has been readdressed,
+// so the connections of the remote peers that haven't received the new address yet aren't dropped
+var previousAddrTimeout = 30 * time.Second
+
 // EngineConfig is a config for the Engine
 type EngineConfig struct {
+	// WgPort is the listen port of the Wireguard interface. 0 picks a random free UDP port when the interface is created
 	WgPort      int
 	WgIfaceName string
+	// WgMode forces the kernel or the userspace Wireguard, the kernel one is tried first if not set
+	WgMode iface.WGMode
 
 	// WgAddr is a Wireguard local address (Netbird Network IP)
 	WgAddr string
@@ -54,25 +79,160 @@ type EngineConfig struct {
 
 	// UDPMuxSrflxPort default value 0 - the system will pick an available port
 	UDPMuxSrflxPort int
+
+	// Labels are user defined labels reported to the Management Service together with the system information
+	Labels map[string]string
+
+	// ProbeInterval is an interval of the connection quality probe of the connected remote peers.
+	// The default value 0 disables the probe
+	ProbeInterval time.Duration
+
+	// IPFamilyPreference orders the ICE candidate gathering and the STUN and TURN servers of the peer connections by the IP family
+	IPFamilyPreference peer.IPFamily
+
+	// MonitorOnly makes the Engine only record the NetworkMap received from the Management Service.
+	// Neither the Wireguard interface nor the connections to the remote peers are created, so no root privileges are required
+	MonitorOnly bool
+
+	// CandidateHarvester gathers the local ICE candidates of the connections to the remote peers (e.g. supplied by
+	// the mobile platform bindings). The connections are re-established when it reports a network change.
+	// The default harvester of the peer package is used if not set
+	CandidateHarvester peer.CandidateHarvester
+
+	// EnableTCPFallback makes the peer connections relay over TURN/TCP and TURN/TLS when UDP is blocked
+	EnableTCPFallback bool
+
+	// IceLite makes the peer connections run ICE-lite agents advertising the public host addresses only,
+	// the remote full agents take the controlling role. Refused when there is no host with a public address
+	IceLite bool
+
+	// TURNRefreshMargin is how long before the TURN credentials expire new ones are requested from the Management Service
+	// unless it has pushed them already. 1/8 of the credentials lifetime if not set
+	TURNRefreshMargin time.Duration
+
+	// OnDemand keeps the remote peers dormant (neither ICE negotiation nor Wireguard keepalive) and connects to a peer
+	// only when there is traffic to it or it offers a connection. Connections idle for IdleTimeout are torn down
+	OnDemand bool
+	// IdleTimeout is the time without traffic after which a connection is torn down in the on-demand mode,
+	// DefaultIdleTimeout if not set
+	IdleTimeout time.Duration
+
+	// EnableSignalRelay relays the Wireguard packets of the peer connections through the Signal Service stream
+	// as a last resort when ICE fails repeatedly
+	EnableSignalRelay bool
+	// SignalRelayLimitKbps caps the bandwidth of each connection relayed through the Signal Service,
+	// peer.DefaultSignalRelayLimitKbps if not set
+	SignalRelayLimitKbps int
+	// PeerConnectionTimeout limits each connection attempt to a remote peer, peer.DefaultAttemptTimeout if not set
+	PeerConnectionTimeout time.Duration
+	// StagedICE makes the peer connections try the host candidates first, then the server-reflexive ones and
+	// the relay ones last, each stage limited by ICEStageTimeouts
+	StagedICE bool
+	// ICEStageTimeouts limit the stages of the staged connection attempts, the peer package defaults if not set
+	ICEStageTimeouts peer.StageTimeouts
+	// MaxConcurrentDials limits the connection attempts to the remote peers negotiating at the same time, so the
+	// connections to the peers of a large NetworkMap ramp up in batches. Not limited if not set
+	MaxConcurrentDials int
+
+	// BootstrapFile is a bootstrap bundle sealed by the Management Service for the peer, applied when the Engine starts
+	// so it comes up without waiting for the first NetworkMap. The NetworkMap received from the Management Service
+	// replaces it. WgAddr is taken from the bundle if not set
+	BootstrapFile string
+	// BootstrapServerKey is the public key of the Management Service the bootstrap bundle has to be sealed with,
+	// asked from the Management Service if nil
+	BootstrapServerKey *wgtypes.Key
+
+	// ExitNode selects the remote peer (by its key, name or IP) all the traffic is routed through while it is connected.
+	// Supported on Linux only
+	ExitNode string
+	// ServiceHosts are the addresses of the Management and Signal services, routed around the exit node
+	ServiceHosts []string
+
+	// TraceConnections records the timeline of each connection attempt to a remote peer, reported in the status
+	TraceConnections bool
+
+	// PostUp is invoked after the Wireguard interface has been created by Start
+	PostUp InterfaceHook
+	// PostDown is invoked after the Wireguard interface has been torn down by Stop, even if tearing it down has failed
+	PostDown InterfaceHook
+
+	// PreNetworkMapApply and PostNetworkMapApply are invoked before and after each NetworkMap is applied with its serial
+	// and the diff of the remote peers, the PostNetworkMapApply one only if the NetworkMap has been applied.
+	// Not invoked in the monitor only mode
+	PreNetworkMapApply  NetworkMapHook
+	PostNetworkMapApply NetworkMapHook
+	// NetworkMapHookTimeout limits each NetworkMap hook, DefaultNetworkMapHookTimeout if not set
+	NetworkMapHookTimeout time.Duration
+
+	// DNSZone is the zone (e.g. wt.local) the names of the remote peers are resolved in by a DNS responder listening
+	// on the address of the Wireguard interface. The names aren't resolved if not set
+	DNSZone string
+	// DNSPort is the port of the DNS responder, dns.DefaultPort if not set
+	DNSPort int
+
+	// SSHKey is the PEM encoded SSH key of the peer, the host key of its SSH server. No SSH key is reported to
+	// the Management Service if not set
+	SSHKey []byte
+	// ServerSSHAllowed allows the Management Service to enable the SSH server listening on the address of
+	// the Wireguard interface
+	ServerSSHAllowed bool
+	// ServerSSHUsers maps the logins of the SSH server to the local users the sessions run as, the other logins are refused
+	ServerSSHUsers map[string]string
+	// ServerSSHRootAllowed allows the sessions of the SSH server to run as a user with uid 0
+	ServerSSHRootAllowed bool
+
+	// WgStatsInterval is the interval of reading the Wireguard interface statistics shared by the features polling them
+	// (e.g. the handshake checks of the static endpoints and the idle connection checks), DefaultWgStatsInterval if not set
+	WgStatsInterval time.Duration
+
+	// MSSClamp is the MSS the TCP connections through the Wireguard interface are clamped to, not clamped if 0
+	MSSClamp int
+
+	// ManagementURLs are the Management Services the client fails over between, the primary one first
+	ManagementURLs []*url.URL
+	// ManagementURL is the one of ManagementURLs the client has connected to on start
+	ManagementURL *url.URL
+	// ClientCertificate is presented to the Management Services requiring mutual TLS, e.g. when failing over to
+	// another one. Not presented if nil
+	ClientCertificate *encryption.CertificateReloader
 }
 
 // Engine is a mechanism responsible for reacting on Signal and Management stream events and managing connections to the remote peers.
 type Engine struct {
 	// signal is a Signal Service client
 	signal signal.Client
-	// mgmClient is a Management Service client
+	// mgmClient is a client of the Management Service at mgmURL, both are replaced when the client fails over to
+	// another Management Service. Guarded by mgmMux
 	mgmClient mgm.Client
+	mgmURL    *url.URL
+	mgmMux    sync.Mutex
+	// connectManagement connects and logs in to a Management Service, used to fail over to another one
+	connectManagement func(ctx context.Context, managementURL *url.URL) (mgm.Client, error)
 	// peerConns is a map that holds all the peers that are known to this peer
 	peerConns map[string]*peer.Conn
 
 	// syncMsgMux is used to guarantee sequential Management Service message processing
 	syncMsgMux *sync.Mutex
+	// peersMux guards the peer maps read without syncMsgMux, so neither the Signal messages nor the status reads wait
+	// for a NetworkMap being applied. peerConns is changed holding both locks and read holding either of them,
+	// offlinePeers, dormantPeers and peerSSHKeys are accessed holding peersMux. syncMsgMux is always locked first
+	peersMux *sync.RWMutex
+	// statusMux guards the connection failure counters and networkStatus
+	statusMux *sync.RWMutex
+	// networkStatus is the network part of the EngineStatus, published once a change has been applied
+	networkStatus EngineStatus
 
 	config *EngineConfig
 	// STUNs is a list of STUN servers used by ICE
 	STUNs []*ice.URL
 	// TURNs is a list of STUN servers used by ICE
 	TURNs []*ice.URL
+	// turnCredentialsExpiresAt is the expiration time of the TURN credentials, zero if they don't expire
+	turnCredentialsExpiresAt time.Time
+	// turnRefreshTimer requests new TURN credentials shortly before turnCredentialsExpiresAt
+	turnRefreshTimer *time.Timer
+	// previousAddrTimer removes the previous address of the interface after it has been readdressed
+	previousAddrTimer *time.Timer
 
 	cancel context.CancelFunc
 
@@ -87,6 +247,125 @@ type Engine struct {
 
 	// networkSerial is the latest CurrentSerial (state ID) of the network sent by the Management service
 	networkSerial uint64
+	// networkEpoch is the epoch of the Management service instance that sent the networkSerial
+	networkEpoch uint64
+
+	// sysInfo is the latest system information reported to the Management service
+	sysInfo *system.Info
+
+	// clientUpdate is the latest client version recommendation sent by the Management service
+	clientUpdate *ClientUpdate
+
+	// pinger is used by the connection quality probe
+	pinger Pinger
+	// peerProbes is the connection quality of the connected remote peers measured in the last probe round
+	peerProbes map[string]ProbeStats
+
+	// peerEndpoints holds the last known working Wireguard endpoints of the remote peers.
+	// Used to re-establish connections without negotiation when Signal is unavailable
+	peerEndpoints map[string]*cachedEndpoint
+
+	// peerStaticEndpoints holds the static endpoints of the remote peers sent by the Management service.
+	// Used to configure Wireguard directly without negotiation
+	peerStaticEndpoints map[string]*staticEndpoint
+
+	// staticEndpoint is the static endpoint of this peer sent by the Management service.
+	// Remote peers connect to it without negotiation
+	staticEndpoint string
+
+	// routeMetric is the metric of the routes through the Wireguard interface sent by the Management service,
+	// 0 is the default metric of the OS
+	routeMetric uint32
+
+	// peerRateLimits holds the egress rate limits in kbit/s of the remote peers sent by the Management service
+	peerRateLimits map[string]uint64
+
+	// sourceFilterSet indicates that the source filter has been set to the allowed IPs of the current peer connections,
+	// so it is updated only when they change
+	sourceFilterSet bool
+
+	// firewallRules are the firewall rules of the latest NetworkMap applied to the Wireguard interface, nil if there are none
+	firewallRules []iface.FirewallRule
+
+	// monitoredPeers holds the remote peers of the latest NetworkMap in the monitor only mode (peer key -> allowed IPs)
+	monitoredPeers map[string]string
+
+	// peerNames holds the friendly names of the remote peers of the latest NetworkMap (peer key -> name),
+	// nil until the first NetworkMap
+	peerNames map[string]string
+
+	// dormantPeers holds the activity listeners of the remote peers kept dormant in the on-demand mode
+	dormantPeers map[string]*activityListener
+
+	// offlinePeers holds the remote peers the Management service reports offline, each channel is closed
+	// once the peer comes online
+	offlinePeers map[string]chan struct{}
+
+	// controlHandler receives the control messages of the remote peers, guarded by controlMux
+	controlHandler ControlMessageHandler
+	controlMux     *sync.Mutex
+	// controlSendLimiter and controlReceiveLimiter limit the rate of the control messages sent to and received from
+	// each remote peer
+	controlSendLimiter    *controlRateLimiter
+	controlReceiveLimiter *controlRateLimiter
+
+	// connFailures counts the failed connection attempts to the remote peers by the failure class
+	connFailures map[peer.FailureClass]int
+	// rejectedEndpoints counts the negotiated endpoints rejected because the remote peers haven't advertised them
+	rejectedEndpoints int
+
+	// exitNodeKey is the key of the remote peer selected as the exit node, an empty string if it isn't in the NetworkMap
+	exitNodeKey string
+	// exitNodeRouted indicates that all the traffic is currently routed through the exit node
+	exitNodeRouted bool
+
+	// networkRoutes are the networks behind the routing peers sent by the Management Service by the network prefix
+	networkRoutes map[string]*networkRoute
+
+	// allowedIPsConflicts are the allowed IPs of the remote peers of the latest NetworkMap refused because another
+	// remote peer has the same or an overlapping one
+	allowedIPsConflicts []allowedIPsConflict
+	// feedbackMux serializes the reports of the conflicts and of the connection outcomes sent to the Management Service,
+	// feedbackSerial identifies the latest one
+	feedbackMux    *sync.Mutex
+	feedbackSerial uint32
+	// connOutcomes counts the outcomes of the connection attempts by the remote peer since the previous report
+	connOutcomes    map[string]*connOutcomes
+	connOutcomesMux *sync.Mutex
+	// interfaceUp indicates that Start has created the Wireguard interface and Stop hasn't torn it down yet
+	interfaceUp bool
+
+	// dnsServer resolves the names of the remote peers, nil if no DNS zone has been configured
+	dnsServer *dns.Server
+	// dnsConfig is the last DNS config received from Management, nil if none
+	dnsConfig *mgmProto.DNSConfig
+
+	// dialLimiter limits the connection attempts negotiating at the same time, nil if they aren't limited
+	dialLimiter *peer.DialLimiter
+
+	// sshServer accepts the SSH connections of the remote peers allowed by the Management Service, nil if it isn't running
+	sshServer *ssh.Server
+	// peerSSHKeys are the SSH public keys of the remote peers of the latest NetworkMap by the peer key,
+	// the host keys of their SSH servers
+	peerSSHKeys map[string]string
+
+	// wgStats shares the reads of the Wireguard interface statistics between the features polling them
+	wgStats *wgStatsCache
+
+	// mssClamped is set once the MSS clamp has been installed on the Wireguard interface
+	mssClamped bool
+}
+
+// cachedEndpoint is a remote peer Wireguard endpoint learned from a direct connection or the TURN relay address
+// of a remote peer connected through its relay. Connections proxied otherwise can't be re-established without
+// ICE negotiation, so their endpoints aren't cached
+type cachedEndpoint struct {
+	addr *net.UDPAddr
+	// relayed indicates that addr is the TURN relay address of the remote peer
+	relayed   bool
+	expiresAt time.Time
+	// restored indicates that the Wireguard peer is currently configured with this endpoint without negotiation
+	restored bool
 }
 
 // Peer is an instance of the Connection Peer
@@ -100,23 +379,85 @@ func NewEngine(
 	ctx context.Context, cancel context.CancelFunc,
 	signalClient signal.Client, mgmClient mgm.Client, config *EngineConfig,
 ) *Engine {
-	return &Engine{
-		ctx:           ctx,
-		cancel:        cancel,
-		signal:        signalClient,
-		mgmClient:     mgmClient,
-		peerConns:     map[string]*peer.Conn{},
-		syncMsgMux:    &sync.Mutex{},
-		config:        config,
-		STUNs:         []*ice.URL{},
-		TURNs:         []*ice.URL{},
-		networkSerial: 0,
+	var dialLimiter *peer.DialLimiter
+	if config.MaxConcurrentDials > 0 {
+		dialLimiter = peer.NewDialLimiter(config.MaxConcurrentDials)
+	}
+
+	connectManagement := func(ctx context.Context, managementURL *url.URL) (mgm.Client, error) {
+		tlsConfig := serviceTLSConfig(managementURL.Scheme == "https", config.ClientCertificate)
+		client, _, err := connectToManagement(ctx, managementURL.Host, config.WgPrivateKey, tlsConfig, config.Labels)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
 	}
+
+	engine := &Engine{
+		ctx:                 ctx,
+		cancel:              cancel,
+		signal:              signalClient,
+		mgmClient:           mgmClient,
+		mgmURL:              config.ManagementURL,
+		connectManagement:   connectManagement,
+		peerConns:           map[string]*peer.Conn{},
+		syncMsgMux:          &sync.Mutex{},
+		feedbackMux:         &sync.Mutex{},
+		connOutcomes:        map[string]*connOutcomes{},
+		connOutcomesMux:     &sync.Mutex{},
+		config:              config,
+		STUNs:               []*ice.URL{},
+		TURNs:               []*ice.URL{},
+		networkSerial:       0,
+		pinger:              newICMPPinger(),
+		peerProbes:          map[string]ProbeStats{},
+		peerEndpoints:       map[string]*cachedEndpoint{},
+		peerStaticEndpoints: map[string]*staticEndpoint{},
+		peerRateLimits:      map[string]uint64{},
+		monitoredPeers:      map[string]string{},
+		dormantPeers:        map[string]*activityListener{},
+		offlinePeers:        map[string]chan struct{}{},
+		connFailures:        map[peer.FailureClass]int{},
+		networkRoutes:       map[string]*networkRoute{},
+		dialLimiter:         dialLimiter,
+	}
+	engine.controlMux = &sync.Mutex{}
+	engine.peersMux = &sync.RWMutex{}
+	engine.statusMux = &sync.RWMutex{}
+	engine.publishNetworkStatus()
+	engine.controlSendLimiter = newControlRateLimiter(controlMessageRate, controlMessageBurst)
+	engine.controlReceiveLimiter = newControlRateLimiter(controlMessageRate, controlMessageBurst)
+	// the interface is created by Start, so it is looked up on each read
+	engine.wgStats = newWgStatsCache(func() (map[string]iface.PeerStats, error) {
+		return engine.wgInterface.GetStats()
+	}, config.WgStatsInterval)
+
+	return engine
 }
 
 func (e *Engine) Stop() error {
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetInstalledRoutesSource(nil)
+		state.SetEngineStatusSource(nil)
+		state.SetDebugSnapshotSource(nil)
+		state.SetPacketCaptureSource(nil)
+		state.SetWgPort(0)
+	}
+
 	e.syncMsgMux.Lock()
 	defer e.syncMsgMux.Unlock()
+	defer e.runPostDown()
+
+	e.stopTURNRefresh()
+	if e.previousAddrTimer != nil {
+		e.previousAddrTimer.Stop()
+	}
+	e.stopDNSServer()
+	e.stopSSHServer()
+
+	if e.config.ExitNode != "" && !e.config.MonitorOnly {
+		e.removeExitNodeRoute()
+	}
 
 	err := e.removeAllPeers()
 	if err != nil {
@@ -125,10 +466,27 @@ func (e *Engine) Stop() error {
 
 	// very ugly but we want to remove peers from the WireGuard interface first before removing interface.
 	// Removing peers happens in the conn.CLose() asynchronously
-	time.Sleep(500 * time.Millisecond)
+	if !e.config.MonitorOnly {
+		time.Sleep(500 * time.Millisecond)
+	}
 
 	log.Debugf("removing Netbird interface %s", e.config.WgIfaceName)
 	if e.wgInterface.Interface != nil {
+		err = e.wgInterface.RemoveSourceFilter()
+		if err != nil && !errors.Is(err, iface.ErrSourceFilterNotSupported) {
+			log.Warnf("failed removing source filter of Netbird interface %s: %v", e.config.WgIfaceName, err)
+		}
+
+		e.removeMSSClamp()
+
+		if e.firewallRules != nil {
+			err = e.wgInterface.RemoveFirewallRules()
+			if err != nil {
+				log.Warnf("failed removing firewall rules of Netbird interface %s: %v", e.config.WgIfaceName, err)
+			}
+			e.firewallRules = nil
+		}
+
 		err = e.wgInterface.Close()
 		if err != nil {
 			log.Errorf("failed closing Netbird interface %s %v", e.config.WgIfaceName, err)
@@ -167,11 +525,105 @@ func (e *Engine) Stop() error {
 
 // Start creates a new Wireguard tunnel interface and listens to events from Signal and Management services
 // Connections to remote peers are not established here.
-// However, they will be established once an event with a list of peers to connect to will be received from Management Service
+// However, they will be established once an event with a list of peers to connect to will be received from Management Service.
+// In the monitor only mode the interface isn't created and only the events are received
 func (e *Engine) Start() error {
 	e.syncMsgMux.Lock()
 	defer e.syncMsgMux.Unlock()
+	defer e.publishNetworkStatus()
+
+	bootstrap := e.readBootstrap()
+	if e.config.WgAddr == "" && bootstrap != nil {
+		e.config.WgAddr = bootstrap.GetPeerConfig().GetAddress()
+	}
+
+	if e.config.MonitorOnly {
+		log.Infof("starting Netbird Engine in the monitor only mode, Wireguard interface %s won't be created", e.config.WgIfaceName)
+	} else {
+		err := e.createInterface()
+		if err != nil {
+			return err
+		}
+		e.checkIceLite()
+		e.setMSSClamp()
+		e.runPostUp()
+		if e.config.DNSZone != "" {
+			e.startDNSServer()
+		}
+	}
+
+	e.receiveSignalEvents()
+	e.receiveManagementEvents()
+	if len(e.config.ManagementURLs) > 1 {
+		e.watchManagementFailover()
+	}
+
+	if e.config.CandidateHarvester != nil {
+		e.config.CandidateHarvester.OnNetworkChange(e.NotifyNetworkChange)
+	}
+	if !e.config.MonitorOnly {
+		e.watchNetworkChanges()
+	}
+
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetInstalledRoutesSource(e.GetInstalledRoutes)
+		state.SetEngineStatusSource(e.GetStatus)
+		state.SetDebugSnapshotSource(e.DebugSnapshot)
+		state.SetPacketCaptureSource(e.StartPacketCapture)
+	}
+
+	// the system information has been already sent with the login request, but without the Wireguard port
+	e.sysInfo = systemInfo(e.ctx, e.config.Labels)
+	e.watchSystemInfo()
+	e.watchConnOutcomes()
 
+	if e.config.ProbeInterval > 0 && !e.config.MonitorOnly {
+		e.watchConnectionQuality()
+	}
+
+	if e.config.OnDemand && !e.config.MonitorOnly {
+		e.watchIdlePeers()
+	}
+
+	if e.config.ExitNode != "" && !e.config.MonitorOnly {
+		// routes left behind by a previous run that hasn't been stopped gracefully
+		err := e.wgInterface.RemoveDefaultRoute()
+		if errors.Is(err, iface.ErrExitNodeNotSupported) {
+			log.Warnf("ignoring exit node %s: %v", e.config.ExitNode, err)
+			e.config.ExitNode = ""
+		} else {
+			if err != nil {
+				log.Warnf("failed removing stale routes through exit node: %v", err)
+			}
+			e.watchExitNode()
+		}
+	}
+
+	if !e.config.MonitorOnly {
+		// routes left behind by a previous run that hasn't been stopped gracefully
+		err := e.wgInterface.RemoveNetworkRoutes()
+		if err != nil && !errors.Is(err, iface.ErrNetworkRoutesNotSupported) {
+			log.Warnf("failed removing stale routes to the networks of the routing peers: %v", err)
+		}
+		e.watchNetworkRoutes()
+	}
+
+	// the NetworkMap of the Management Service is applied over the bundle once it is received
+	if bootstrap != nil {
+		err := e.applySync(bootstrap)
+		if err != nil {
+			log.Errorf("failed applying bootstrap bundle %s: %v", e.config.BootstrapFile, err)
+		} else {
+			log.Infof("applied bootstrap bundle %s, NetworkMap serial %d", e.config.BootstrapFile,
+				bootstrap.GetNetworkMap().GetSerial())
+		}
+	}
+
+	return nil
+}
+
+// createInterface creates and configures the Wireguard interface and opens the UDP sockets used by ICE
+func (e *Engine) createInterface() error {
 	wgIfaceName := e.config.WgIfaceName
 	wgAddr := e.config.WgAddr
 	myPrivateKey := e.config.WgPrivateKey
@@ -180,19 +632,20 @@ func (e *Engine) Start() error {
 	e.wgInterface, err = iface.NewWGIface(wgIfaceName, wgAddr, iface.DefaultMTU)
 	if err != nil {
 		log.Errorf("failed creating wireguard interface instance %s: [%s]", wgIfaceName, err.Error())
-		return err
+		return wrapError(ErrInterface, err)
 	}
+	e.wgInterface.Mode = e.config.WgMode
 
 	e.udpMuxConn, err = net.ListenUDP("udp4", &net.UDPAddr{Port: e.config.UDPMuxPort})
 	if err != nil {
 		log.Errorf("failed listening on UDP port %d: [%s]", e.config.UDPMuxPort, err.Error())
-		return err
+		return wrapInterfaceError(err)
 	}
 
 	e.udpMuxConnSrflx, err = net.ListenUDP("udp4", &net.UDPAddr{Port: e.config.UDPMuxSrflxPort})
 	if err != nil {
 		log.Errorf("failed listening on UDP port %d: [%s]", e.config.UDPMuxSrflxPort, err.Error())
-		return err
+		return wrapInterfaceError(err)
 	}
 
 	e.udpMux = ice.NewUDPMuxDefault(ice.UDPMuxParams{UDPConn: e.udpMuxConn})
@@ -201,43 +654,107 @@ func (e *Engine) Start() error {
 	err = e.wgInterface.Create()
 	if err != nil {
 		log.Errorf("failed creating tunnel interface %s: [%s]", wgIfaceName, err.Error())
-		return err
+		return wrapInterfaceError(err)
 	}
 
 	err = e.wgInterface.Configure(myPrivateKey.String(), e.config.WgPort)
 	if err != nil {
 		log.Errorf("failed configuring Wireguard interface [%s]: %s", wgIfaceName, err.Error())
-		return err
+		return wrapInterfaceError(err)
 	}
 
-	e.receiveSignalEvents()
-	e.receiveManagementEvents()
+	if e.config.WgPort == 0 {
+		port, err := e.wgInterface.GetListenPort()
+		if err != nil {
+			log.Errorf("failed getting the listen port picked by Wireguard interface [%s]: %s", wgIfaceName, err.Error())
+			return wrapError(ErrInterface, err)
+		}
+		e.config.WgPort = *port
+		log.Infof("Wireguard interface %s listens on the randomly picked port %d", wgIfaceName, e.config.WgPort)
+	}
+
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetWgPort(e.config.WgPort)
+	}
+
+	// no remote peer is allowed to send until the first NetworkMap arrives
+	e.updateSourceFilter(nil)
 
 	return nil
 }
 
-// removePeers finds and removes peers that do not exist anymore in the network map received from the Management Service
-func (e *Engine) removePeers(peersUpdate []*mgmProto.RemotePeerConfig) error {
-	currentPeers := make([]string, 0, len(e.peerConns))
-	for p := range e.peerConns {
-		currentPeers = append(currentPeers, p)
+// updateAddress readdresses the Wireguard interface when the Management service has assigned a new IP to the peer
+func (e *Engine) updateAddress(address string) error {
+	if address == "" || address == e.config.WgAddr {
+		return nil
 	}
 
-	newPeers := make([]string, 0, len(peersUpdate))
-	for _, p := range peersUpdate {
-		newPeers = append(newPeers, p.GetWgPubKey())
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return wrapError(ErrInvalidNetworkMap, fmt.Errorf("invalid peer address %s: %w", address, err))
 	}
 
-	toRemove := util.SliceDiff(currentPeers, newPeers)
+	log.Infof("peer address has changed from %s to %s, readdressing interface %s", e.config.WgAddr, address, e.config.WgIfaceName)
+	err := e.wgInterface.UpdateAddr(address)
+	if err != nil {
+		return wrapInterfaceError(fmt.Errorf("failed readdressing interface %s to %s: %w", e.config.WgIfaceName, address, err))
+	}
+	e.config.WgAddr = address
+	e.schedulePreviousAddrRemoval()
 
-	for _, p := range toRemove {
-		err := e.removePeer(p)
+	// the platforms dropping the routes through the interface along with its address get them back,
+	// the exit node routes are restored by the exit node watcher
+	e.restoreNetworkRoutes()
+
+	if e.dnsServer != nil {
+		e.stopDNSServer()
+		e.listenDNS()
+	}
+	if e.sshServer != nil {
+		e.stopSSHServer()
+		e.listenSSH()
+	}
+
+	return nil
+}
+
+// schedulePreviousAddrRemoval removes the previous address of the interface once the remote peers have received
+// the new one with their NetworkMaps. Until then the interface accepts the traffic sent to both addresses.
+// The caller holds the lock
+func (e *Engine) schedulePreviousAddrRemoval() {
+	if e.previousAddrTimer != nil {
+		e.previousAddrTimer.Stop()
+	}
+	e.previousAddrTimer = time.AfterFunc(previousAddrTimeout, func() {
+		e.syncMsgMux.Lock()
+		defer e.syncMsgMux.Unlock()
+
+		// the Engine has been stopped meanwhile
+		if e.ctx.Err() != nil {
+			return
+		}
+		err := e.wgInterface.RemovePreviousAddr()
 		if err != nil {
-			return err
+			log.Warnf("failed removing previous address of interface %s: %v", e.config.WgIfaceName, err)
 		}
-		log.Infof("removed peer %s", p)
+	})
+}
+
+// updateRouteMetric sets the metric of the routes through the Wireguard interface when the Management service
+// has assigned a new one, so that they win over or give way to the routes of other interfaces (e.g. a corporate VPN)
+func (e *Engine) updateRouteMetric(metric uint32) {
+	if metric == e.routeMetric {
+		return
 	}
-	return nil
+
+	err := e.wgInterface.SetRouteMetric(metric)
+	if errors.Is(err, iface.ErrRouteMetricNotSupported) {
+		log.Warnf("ignoring route metric %d: %v", metric, err)
+	} else if err != nil {
+		// retried with the next NetworkMap
+		log.Warnf("failed setting metric of the routes through interface %s to %d: %v", e.config.WgIfaceName, metric, err)
+		return
+	}
+	e.routeMetric = metric
 }
 
 func (e *Engine) removeAllPeers() error {
@@ -254,9 +771,48 @@ func (e *Engine) removeAllPeers() error {
 // removePeer closes an existing peer connection and removes a peer
 func (e *Engine) removePeer(peerKey string) error {
 	log.Debugf("removing peer from engine %s", peerKey)
+
+	if endpoint, ok := e.peerEndpoints[peerKey]; ok {
+		delete(e.peerEndpoints, peerKey)
+		// the Wireguard peer configured from cache isn't owned by the Conn, so it won't be removed on Close
+		if endpoint.restored {
+			err := e.wgInterface.RemovePeer(peerKey)
+			if err != nil {
+				log.Warnf("failed removing peer %s restored from cache: %v", peerKey, err)
+			}
+		}
+	}
+
+	// the Wireguard peer configured with a static endpoint or to accept connections to the static endpoint of this peer
+	// isn't owned by the Conn either
+	endpoint, static := e.peerStaticEndpoints[peerKey]
+	delete(e.peerStaticEndpoints, peerKey)
+	if (static && endpoint.active) || e.staticEndpoint != "" {
+		err := e.wgInterface.RemovePeer(peerKey)
+		if err != nil {
+			log.Warnf("failed removing peer %s configured without negotiation: %v", peerKey, err)
+		}
+	}
+
+	e.removeDormantPeer(peerKey)
+	// the connection attempts waiting for the peer to come online give up
+	e.markPeerOnline(peerKey)
+	e.controlSendLimiter.remove(peerKey)
+	e.controlReceiveLimiter.remove(peerKey)
+
+	if peerKey == e.exitNodeKey && e.exitNodeRouted {
+		log.Warnf("exit node %s has been removed, routing traffic through the default route of the system", peerKey)
+		e.removeExitNodeRoute()
+	}
+
+	e.removeNetworkRoutesOf(peerKey)
+
 	conn, exists := e.peerConns[peerKey]
 	if exists {
+		e.removePeerRateLimit(peerKey, conn.GetAllowedIPs())
+		e.peersMux.Lock()
 		delete(e.peerConns, peerKey)
+		e.peersMux.Unlock()
 		err := conn.Close()
 		if err != nil {
 			switch err.(type) {
@@ -272,7 +828,9 @@ func (e *Engine) removePeer(peerKey string) error {
 
 // GetPeerConnectionStatus returns a connection Status or nil if peer connection wasn't found
 func (e *Engine) GetPeerConnectionStatus(peerKey string) peer.ConnStatus {
+	e.peersMux.RLock()
 	conn, exists := e.peerConns[peerKey]
+	e.peersMux.RUnlock()
 	if exists && conn != nil {
 		return conn.Status()
 	}
@@ -280,21 +838,39 @@ func (e *Engine) GetPeerConnectionStatus(peerKey string) peer.ConnStatus {
 	return -1
 }
 
-func (e *Engine) GetPeers() []string {
+// GetPeerName returns the friendly name of the remote peer or an empty string if the peer is unknown
+func (e *Engine) GetPeerName(peerKey string) string {
 	e.syncMsgMux.Lock()
 	defer e.syncMsgMux.Unlock()
 
+	return e.peerNames[peerKey]
+}
+
+// GetPeers returns the keys of the remote peers of the latest NetworkMap
+func (e *Engine) GetPeers() []string {
 	peers := []string{}
+	if e.config.MonitorOnly {
+		e.syncMsgMux.Lock()
+		defer e.syncMsgMux.Unlock()
+		for s := range e.monitoredPeers {
+			peers = append(peers, s)
+		}
+		return peers
+	}
+
+	e.peersMux.RLock()
+	defer e.peersMux.RUnlock()
 	for s := range e.peerConns {
 		peers = append(peers, s)
 	}
 	return peers
 }
 
-// GetConnectedPeers returns a connection Status or nil if peer connection wasn't found
+// GetConnectedPeers returns a connection Status or nil if peer connection wasn't found.
+// No connections are established in the monitor only mode, so none of the peers is connected
 func (e *Engine) GetConnectedPeers() []string {
-	e.syncMsgMux.Lock()
-	defer e.syncMsgMux.Unlock()
+	e.peersMux.RLock()
+	defer e.peersMux.RUnlock()
 
 	peers := []string{}
 	for s, conn := range e.peerConns {
@@ -324,7 +900,25 @@ func signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtyp
 	return nil
 }
 
-func signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.Key, s signal.Client, isAnswer bool) error {
+// signalRelayPacket sends a Wireguard packet of a connection relayed through the Signal Service to the remote peer.
+// The packets are sent over the Signal stream, which is faster than a request per packet
+func signalRelayPacket(packet []byte, myKey wgtypes.Key, remoteKey wgtypes.Key, s signal.Client) error {
+	body, err := encryption.EncryptMessage(remoteKey, myKey, &sProto.Body{
+		Type: sProto.Body_RELAY,
+		Data: packet,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.SendToStream(&sProto.EncryptedMessage{
+		Key:       myKey.PublicKey().String(),
+		RemoteKey: remoteKey.String(),
+		Body:      body,
+	})
+}
+
+func signalAuth(uFrag string, pwd string, iceLite bool, myKey wgtypes.Key, remoteKey wgtypes.Key, s signal.Client, isAnswer bool) error {
 	var t sProto.Body_Type
 	if isAnswer {
 		t = sProto.Body_ANSWER
@@ -333,8 +927,9 @@ func signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.K
 	}
 
 	msg, err := signal.MarshalCredential(myKey, remoteKey, &signal.Credential{
-		UFrag: uFrag,
-		Pwd:   pwd,
+		UFrag:   uFrag,
+		Pwd:     pwd,
+		IceLite: iceLite,
 	}, t)
 	if err != nil {
 		return err
@@ -350,7 +945,14 @@ func signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.K
 func (e *Engine) handleSync(update *mgmProto.SyncResponse) error {
 	e.syncMsgMux.Lock()
 	defer e.syncMsgMux.Unlock()
+	defer e.publishNetworkStatus()
+
+	return e.applySync(update)
+}
 
+// applySync applies a SyncResponse of the Management Service or of the bootstrap bundle. The caller holds the lock
+func (e *Engine) applySync(update *mgmProto.SyncResponse) error {
+	// a configuration-only update (e.g. TURN credentials refresh) comes without the NetworkMap
 	if update.GetWiretrusteeConfig() != nil {
 		err := e.updateTURNs(update.GetWiretrusteeConfig().GetTurns())
 		if err != nil {
@@ -362,9 +964,26 @@ func (e *Engine) handleSync(update *mgmProto.SyncResponse) error {
 			return err
 		}
 
+		// existing connections pick up the new STUN and TURN URLs on the next connection attempt
+		e.updatePeersStunTurn()
+
 		// todo update signal
 	}
 
+	if update.GetClientUpdate() != nil {
+		e.updateClientUpdate(update.GetClientUpdate())
+	}
+
+	// a new instance of the Management service might have started over with a lower serial (e.g. restored store)
+	if update.GetEpoch() != 0 && update.GetEpoch() != e.networkEpoch {
+		if e.networkEpoch != 0 {
+			log.Infof("Management service epoch changed from %d to %d, resetting the network serial %d",
+				e.networkEpoch, update.GetEpoch(), e.networkSerial)
+		}
+		e.networkEpoch = update.GetEpoch()
+		e.networkSerial = 0
+	}
+
 	if update.GetNetworkMap() != nil {
 		// only apply new changes and ignore old ones
 		err := e.updateNetworkMap(update.GetNetworkMap())
@@ -373,17 +992,59 @@ func (e *Engine) handleSync(update *mgmProto.SyncResponse) error {
 		}
 	}
 
+	e.applyPresenceUpdates(update.GetPresenceUpdates())
+
 	return nil
 }
 
+// updateClientUpdate records the client version recommendation and makes it available to the daemon.
+// Nothing is executed, a supervising process is responsible for the update
+func (e *Engine) updateClientUpdate(update *mgmProto.ClientUpdate) {
+	clientUpdate := &ClientUpdate{
+		MinVersion:         update.GetMinVersion(),
+		RecommendedVersion: update.GetRecommendedVersion(),
+		DownloadURL:        update.GetDownloadUrl(),
+	}
+
+	if clientUpdate.RecommendedVersion != "" && clientUpdate.RecommendedVersion != system.NetbirdVersion() {
+		log.Infof("Management Service recommends client version %s (running %s), download it from %s",
+			clientUpdate.RecommendedVersion, system.NetbirdVersion(), clientUpdate.DownloadURL)
+	}
+
+	e.clientUpdate = clientUpdate
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetClientUpdate(clientUpdate)
+	}
+}
+
+// GetClientUpdate returns the latest client version recommendation received from the Management Service or nil
+func (e *Engine) GetClientUpdate() *ClientUpdate {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	return e.clientUpdate
+}
+
 // receiveManagementEvents connects to the Management Service event stream to receive updates from the management service
 // E.g. when a new peer has been registered and we are allowed to connect to it.
 func (e *Engine) receiveManagementEvents() {
+	client := e.managementClient()
 	go func() {
-		err := e.mgmClient.Sync(func(update *mgmProto.SyncResponse) error {
+		err := client.Sync(func(update *mgmProto.SyncResponse) error {
 			return e.handleSync(update)
 		})
+		if client != e.managementClient() {
+			// the client has failed over to another Management Service, the Sync of the replaced client has stopped
+			return
+		}
 		if err != nil {
+			// the login of the peer has expired or the peer has been removed, retrying won't help until it logs in again
+			if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
+				log.Warnf("access to Management Service denied, the peer has to log in again: %s", s.Message())
+				CtxGetState(e.ctx).Set(StatusNeedsLogin)
+				e.cancel()
+				return
+			}
 			// happens if management is unavailable for a long time.
 			// We want to cancel the operation of the whole client
 			_ = CtxGetState(e.ctx).Wrap(ErrResetConnection)
@@ -395,6 +1056,148 @@ func (e *Engine) receiveManagementEvents() {
 	log.Debugf("connecting to Management Service updates stream")
 }
 
+// managementClient returns the client of the Management Service the client is connected to
+func (e *Engine) managementClient() mgm.Client {
+	e.mgmMux.Lock()
+	defer e.mgmMux.Unlock()
+	return e.mgmClient
+}
+
+// managementURL returns the URL of the Management Service the client is connected to
+func (e *Engine) managementURL() *url.URL {
+	e.mgmMux.Lock()
+	defer e.mgmMux.Unlock()
+	return e.mgmURL
+}
+
+// watchSystemInfo periodically checks the system information and sends it to the Management Service
+// when it has changed (e.g. hostname change) so that the peer record stays fresh.
+// The first check is done immediately to report the Wireguard port that is known only once the interface is configured.
+// The port can differ from the previous run when it is picked at random, so the remote peers learn the new one
+func (e *Engine) watchSystemInfo() {
+	go func() {
+		err := e.updateSystemInfo(e.systemInfo())
+		if err != nil {
+			log.Warnf("failed reporting system info to Management Service: %v", err)
+		}
+
+		ticker := time.NewTicker(systemInfoCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				err := e.updateSystemInfo(e.systemInfo())
+				if err != nil {
+					log.Warnf("failed updating system info on Management Service: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// systemInfo collects the system information reported to the Management Service including the Wireguard port and
+// the SSH public key. The port isn't reported in the monitor only mode as there is no interface
+func (e *Engine) systemInfo() *system.Info {
+	info := systemInfo(e.ctx, e.config.Labels)
+	if !e.config.MonitorOnly {
+		info.WgPort = e.config.WgPort
+	}
+	if len(e.config.SSHKey) > 0 {
+		info.SSHPubKey = e.sshPublicKey()
+	}
+	return info
+}
+
+// updateSystemInfo re-sends the system information with a login request if it differs from the last reported one
+func (e *Engine) updateSystemInfo(info *system.Info) error {
+	if reflect.DeepEqual(e.sysInfo, info) {
+		return nil
+	}
+
+	log.Debugf("system info has changed, updating Management Service")
+	serverKey, err := e.managementClient().GetServerPublicKey()
+	if err != nil {
+		return err
+	}
+
+	_, err = e.managementClient().Login(*serverKey, info)
+	if err != nil {
+		return err
+	}
+
+	e.sysInfo = info
+	return nil
+}
+
+// watchConnectionQuality periodically probes the connected remote peers measuring RTT and loss.
+// It surfaces degraded connections (e.g. over a relay) that the connection status alone doesn't reveal
+func (e *Engine) watchConnectionQuality() {
+	go func() {
+		ticker := time.NewTicker(e.config.ProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				e.probeConnectedPeers()
+			}
+		}
+	}()
+}
+
+// probeConnectedPeers runs a single probe round against the connected remote peers and stores the results
+func (e *Engine) probeConnectedPeers() {
+	e.syncMsgMux.Lock()
+	var targets []probeTarget
+	for key, conn := range e.peerConns {
+		if conn.Status() != peer.StatusConnected {
+			continue
+		}
+		ip, err := probeIP(conn.GetAllowedIPs())
+		if err != nil {
+			log.Debugf("skipping probe of peer %s: %v", key, err)
+			continue
+		}
+		targets = append(targets, probeTarget{key: key, ip: ip})
+	}
+	e.syncMsgMux.Unlock()
+
+	// the lock isn't held while probing to not block Management and Signal messages processing
+	probes := probePeers(e.ctx, e.pinger, targets)
+
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	e.peerProbes = make(map[string]ProbeStats, len(probes))
+	for key, stats := range probes {
+		// peer might have been removed while probing
+		if _, ok := e.peerConns[key]; !ok {
+			continue
+		}
+		log.Debugf("probed peer %s: rtt %s, loss %.0f%%", key, stats.RTT, stats.Loss*100)
+		e.peerProbes[key] = stats
+	}
+
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetPeerProbes(e.peerProbes)
+	}
+}
+
+// GetPeerProbes returns the connection quality of the remote peers measured in the last probe round
+func (e *Engine) GetPeerProbes() map[string]ProbeStats {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	probes := make(map[string]ProbeStats, len(e.peerProbes))
+	for key, stats := range e.peerProbes {
+		probes[key] = stats
+	}
+	return probes
+}
+
 func (e *Engine) updateSTUNs(stuns []*mgmProto.HostConfig) error {
 	if len(stuns) == 0 {
 		return nil
@@ -429,10 +1232,22 @@ func (e *Engine) updateTURNs(turns []*mgmProto.ProtectedHostConfig) error {
 		newTURNs = append(newTURNs, url)
 	}
 	e.TURNs = newTURNs
+	e.trackTURNCredentials(turns)
 
 	return nil
 }
 
+// updatePeersStunTurn passes the current STUN and TURN URLs to the existing peer connections
+func (e *Engine) updatePeersStunTurn() {
+	var stunTurn []*ice.URL
+	stunTurn = append(stunTurn, e.STUNs...)
+	stunTurn = append(stunTurn, e.TURNs...)
+
+	for _, conn := range e.peerConns {
+		conn.SetStunTurn(stunTurn)
+	}
+}
+
 func (e *Engine) updateNetworkMap(networkMap *mgmProto.NetworkMap) error {
 	serial := networkMap.GetSerial()
 	if e.networkSerial > serial {
@@ -442,39 +1257,110 @@ func (e *Engine) updateNetworkMap(networkMap *mgmProto.NetworkMap) error {
 
 	log.Debugf("got peers update from Management Service, total peers to connect to = %d", len(networkMap.GetRemotePeers()))
 
+	e.updatePeerNames(networkMap)
+
+	if e.config.MonitorOnly {
+		monitoredPeers := map[string]string{}
+		if !networkMap.GetRemotePeersIsEmpty() {
+			for _, p := range networkMap.GetRemotePeers() {
+				monitoredPeers[p.GetWgPubKey()] = strings.Join(p.GetAllowedIps(), ",")
+			}
+		}
+		e.monitoredPeers = monitoredPeers
+		e.networkSerial = serial
+		return nil
+	}
+
+	var remotePeers []*mgmProto.RemotePeerConfig
+	var conflicts []allowedIPsConflict
+	if !networkMap.GetRemotePeersIsEmpty() {
+		remotePeers, conflicts = e.withoutAllowedIPsConflicts(networkMap.GetRemotePeers())
+		remotePeers = e.withExitNode(remotePeers)
+	}
+	diff := e.networkMapDiff(remotePeers)
+	e.runNetworkMapHook("PreNetworkMapApply", e.config.PreNetworkMapApply, serial, diff)
+
+	e.staticEndpoint = networkMap.GetPeerConfig().GetStaticEndpoint()
+
+	err := e.updateAddress(networkMap.GetPeerConfig().GetAddress())
+	if err != nil {
+		return err
+	}
+
+	e.updateRouteMetric(networkMap.GetPeerConfig().GetRouteMetric())
+
 	// cleanup request, most likely our peer has been deleted
 	if networkMap.GetRemotePeersIsEmpty() {
 		err := e.removeAllPeers()
 		if err != nil {
-			return err
+			return wrapError(ErrInterface, err)
 		}
+		e.updateSourceFilter(nil)
+		e.updateAllowedIPsConflicts(nil)
 	} else {
-		err := e.removePeers(networkMap.GetRemotePeers())
+		e.updateAllowedIPsConflicts(conflicts)
+		sourcesChanged, err := e.reconcilePeers(remotePeers, diff)
 		if err != nil {
 			return err
 		}
+		// the connection attempts of the new peers start once the update is applied, so they skip the offline peers
+		e.updatePeersPresence(remotePeers)
 
-		err = e.addNewPeers(networkMap.GetRemotePeers())
-		if err != nil {
-			return err
+		e.updatePeerRateLimits(remotePeers)
+		if sourcesChanged || !e.sourceFilterSet {
+			e.updateSourceFilter(remotePeers)
 		}
 	}
 
+	e.updateNetworkRoutes(networkMap.GetRoutes())
+
+	e.updateDNSConfig(networkMap.GetDnsConfig())
+	e.updateDNSRecords(networkMap.GetRemotePeers())
+
+	e.updateSSH(networkMap.GetPeerConfig().GetSshConfig(), networkMap.GetRemotePeers())
+
+	e.updateFirewall(networkMap)
+
 	e.networkSerial = serial
+	e.runNetworkMapHook("PostNetworkMapApply", e.config.PostNetworkMapApply, serial, diff)
 	return nil
 }
 
+// updatePeerNames records the friendly names of the remote peers of the NetworkMap and makes them available to the daemon
+func (e *Engine) updatePeerNames(networkMap *mgmProto.NetworkMap) {
+	if e.peerNamesUnchanged(networkMap) {
+		return
+	}
+
+	peerNames := map[string]string{}
+	if !networkMap.GetRemotePeersIsEmpty() {
+		for _, p := range networkMap.GetRemotePeers() {
+			peerNames[p.GetWgPubKey()] = p.GetName()
+		}
+	}
+	e.peerNames = peerNames
+
+	if state, ok := ctxLookupState(e.ctx); ok {
+		state.SetPeerNames(peerNames)
+	}
+}
+
 // addNewPeers finds and adds peers that were not know before but arrived from the Management service with the update
 func (e *Engine) addNewPeers(peersUpdate []*mgmProto.RemotePeerConfig) error {
 	for _, p := range peersUpdate {
 		peerKey := p.GetWgPubKey()
 		peerIPs := p.GetAllowedIps()
 		if _, ok := e.peerConns[peerKey]; !ok {
-			conn, err := e.createPeerConn(peerKey, strings.Join(peerIPs, ","))
+			conn, err := e.createPeerConn(peerKey, strings.Join(peerIPs, ","), int(p.GetWgPort()))
 			if err != nil {
-				return err
+				return wrapError(ErrInvalidNetworkMap, fmt.Errorf("peer %s: %w", peerKey, err))
 			}
+			e.peersMux.Lock()
 			e.peerConns[peerKey] = conn
+			e.peersMux.Unlock()
+			if p.GetStaticEndpoint() != "" {
+				e.peerStaticEndpoints[peerKey] = &staticEndpoint{addr: p.GetStaticEndpoint()}
+			}
 
 			go e.connWorker(conn, peerKey)
 		}
@@ -483,13 +1369,139 @@ func (e *Engine) addNewPeers(peersUpdate []*mgmProto.RemotePeerConfig) error {
 	return nil
 }
 
-func (e Engine) connWorker(conn *peer.Conn, peerKey string) {
+// updatePeerRateLimits applies the egress rate limits of the remote peers that have changed since the previous update.
+// Rate limiting is supported on Linux only, elsewhere the limits are logged and ignored
+func (e *Engine) updatePeerRateLimits(peersUpdate []*mgmProto.RemotePeerConfig) {
+	for _, p := range peersUpdate {
+		peerKey := p.GetWgPubKey()
+		rateLimit := p.GetRateLimitKbps()
+		if e.peerRateLimits[peerKey] == rateLimit {
+			continue
+		}
+
+		// the limit is remembered even if it fails to apply to not retry and log it on every update
+		if rateLimit == 0 {
+			delete(e.peerRateLimits, peerKey)
+		} else {
+			e.peerRateLimits[peerKey] = rateLimit
+		}
+
+		peerIP, err := peerIPFromAllowedIPs(p.GetAllowedIps())
+		if err != nil {
+			log.Warnf("failed updating rate limit of peer %s: %v", peerKey, err)
+			continue
+		}
+
+		if rateLimit == 0 {
+			err = e.wgInterface.RemovePeerRateLimit(peerIP)
+		} else {
+			err = e.wgInterface.SetPeerRateLimit(peerIP, rateLimit)
+		}
+		if err != nil {
+			log.Warnf("failed updating rate limit of peer %s to %d kbit/s: %v", peerKey, rateLimit, err)
+		}
+	}
+}
+
+// removePeerRateLimit removes the egress rate limit of a remote peer if it was set
+func (e *Engine) removePeerRateLimit(peerKey string, allowedIPs string) {
+	if _, ok := e.peerRateLimits[peerKey]; !ok {
+		return
+	}
+	delete(e.peerRateLimits, peerKey)
+
+	peerIP, err := peerIPFromAllowedIPs(strings.Split(allowedIPs, ","))
+	if err != nil {
+		log.Warnf("failed removing rate limit of peer %s: %v", peerKey, err)
+		return
+	}
+
+	err = e.wgInterface.RemovePeerRateLimit(peerIP)
+	if err != nil && !errors.Is(err, iface.ErrRateLimitNotSupported) {
+		log.Warnf("failed removing rate limit of peer %s: %v", peerKey, err)
+	}
+}
+
+// updateSourceFilter makes the Wireguard interface accept only the packets coming from the allowed IPs of the remote peers,
+// dropping the packets with spoofed sources. Source filtering is supported on Linux and macOS and for IPv4 only,
+// elsewhere all the sources are accepted
+func (e *Engine) updateSourceFilter(peersUpdate []*mgmProto.RemotePeerConfig) {
+	var sources []*net.IPNet
+	for _, p := range peersUpdate {
+		for _, allowedIP := range p.GetAllowedIps() {
+			_, source, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				log.Warnf("ignoring invalid allowed IP %s of peer %s in the source filter: %v", allowedIP, p.GetWgPubKey(), err)
+				continue
+			}
+			if source.IP.To4() == nil {
+				continue
+			}
+			sources = append(sources, source)
+		}
+	}
+
+	// the filter is marked as set even if it fails to not retry and log it on every update
+	e.sourceFilterSet = true
+	err := e.wgInterface.SetAllowedSources(sources)
+	if err != nil && !errors.Is(err, iface.ErrSourceFilterNotSupported) {
+		log.Warnf("failed updating source filter of Netbird interface %s: %v", e.config.WgIfaceName, err)
+	}
+}
+
+// peerIPFromAllowedIPs returns the IP of a remote peer, which is the first of its allowed IPs
+func peerIPFromAllowedIPs(allowedIPs []string) (net.IP, error) {
+	if len(allowedIPs) == 0 {
+		return nil, fmt.Errorf("peer has no allowed IPs")
+	}
+
+	ip, _, err := net.ParseCIDR(allowedIPs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return ip, nil
+}
+
+func (e *Engine) connWorker(conn *peer.Conn, peerKey string) {
+	// peers with a static endpoint are connected without negotiation while their endpoint works
+	if endpoint := e.getStaticEndpoint(peerKey); endpoint != "" {
+		if !e.connectStaticEndpoint(peerKey, conn.GetWgAllowedIPs(), endpoint) {
+			return
+		}
+	}
+
+	// lastFailure is the class of the attempts that have failed failures times in a row
+	var lastFailure peer.FailureClass
+	failures := 0
 	for {
+		// an offline peer can't answer the offers, the attempts wait until it comes online and then connect right away
+		wasOffline, exists := e.waitPeerOnline(peerKey)
+		if !exists {
+			log.Debugf("offline peer %s doesn't exist anymore, won't connect", peerKey)
+			return
+		}
+		if wasOffline {
+			failures = 0
+		}
 
-		// randomize starting time a bit
+		// randomize starting time a bit, backing off after the failed attempts
 		min := 500
 		max := 2000
-		time.Sleep(time.Duration(rand.Intn(max-min)+min) * time.Millisecond)
+		delay := time.Duration(rand.Intn(max-min)+min)*time.Millisecond + connRetryBackoff(lastFailure, failures)
+		// the offer of a peer the last attempt has found offline means it has come back, it is answered right away
+		var remoteOffered <-chan struct{}
+		if lastFailure == peer.FailurePeerOffline {
+			remoteOffered = conn.RemoteOffered()
+		}
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-time.After(delay):
+		case <-remoteOffered:
+			log.Debugf("offline peer %s has offered the connection, connecting right away", peerKey)
+			failures = 0
+		}
 
 		// if peer has been removed -> give up
 		if !e.peerExists(peerKey) {
@@ -497,26 +1509,67 @@ func (e Engine) connWorker(conn *peer.Conn, peerKey string) {
 			return
 		}
 
+		// the peer has gone offline while backing off
+		if e.isPeerOffline(peerKey) {
+			continue
+		}
+
+		if e.config.OnDemand && !e.waitPeerActivity(conn, peerKey) {
+			log.Debugf("dormant peer %s doesn't exist anymore, won't connect", peerKey)
+			return
+		}
+
 		if !e.signal.Ready() {
 			log.Infof("signal client isn't ready, skipping connection attempt %s", peerKey)
+			e.restoreFromCachedEndpoint(peerKey, conn.GetWgAllowedIPs())
 			continue
 		}
 
+		e.resetCachedEndpoint(peerKey)
+		e.acceptStaticEndpointConnection(conn, peerKey)
 		err := conn.Open()
 		if err != nil {
 			log.Debugf("connection to peer %s failed: %v", peerKey, err)
 		}
+
+		class := peer.FailureClassOf(err)
+		switch {
+		case class == "":
+			failures = 0
+		case class == lastFailure:
+			failures++
+		default:
+			failures = 1
+		}
+		lastFailure = class
+		if class != "" {
+			e.countConnFailure(class)
+		}
 	}
 }
 
-func (e Engine) peerExists(peerKey string) bool {
-	e.syncMsgMux.Lock()
-	defer e.syncMsgMux.Unlock()
+// countConnFailure counts a failed connection attempt of the class
+func (e *Engine) countConnFailure(class peer.FailureClass) {
+	e.statusMux.Lock()
+	defer e.statusMux.Unlock()
+	e.connFailures[class]++
+}
+
+// countRejectedEndpoint counts an endpoint rejected because the remote peer hasn't advertised it
+func (e *Engine) countRejectedEndpoint() {
+	e.statusMux.Lock()
+	defer e.statusMux.Unlock()
+	e.rejectedEndpoints++
+}
+
+func (e *Engine) peerExists(peerKey string) bool {
+	e.peersMux.RLock()
+	defer e.peersMux.RUnlock()
 	_, ok := e.peerConns[peerKey]
 	return ok
 }
 
-func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, error) {
+func (e *Engine) createPeerConn(pubKey string, allowedIPs string, remoteWgPort int) (*peer.Conn, error) {
 	var stunTurn []*ice.URL
 	stunTurn = append(stunTurn, e.STUNs...)
 	stunTurn = append(stunTurn, e.TURNs...)
@@ -532,19 +1585,32 @@ func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, er
 		WgInterface:  e.wgInterface,
 		AllowedIps:   allowedIPs,
 		PreSharedKey: e.config.PreSharedKey,
+		RemoteWgPort: remoteWgPort,
 	}
 
 	// randomize connection timeout
 	timeout := time.Duration(rand.Intn(PeerConnectionTimeoutMax-PeerConnectionTimeoutMin)+PeerConnectionTimeoutMin) * time.Millisecond
 	config := peer.ConnConfig{
-		Key:                pubKey,
-		LocalKey:           e.config.WgPrivateKey.PublicKey().String(),
-		StunTurn:           stunTurn,
-		InterfaceBlackList: interfaceBlacklist,
-		Timeout:            timeout,
-		UDPMux:             e.udpMux,
-		UDPMuxSrflx:        e.udpMuxSrflx,
-		ProxyConfig:        proxyConfig,
+		Key:                  pubKey,
+		LocalKey:             e.config.WgPrivateKey.PublicKey().String(),
+		StunTurn:             stunTurn,
+		InterfaceBlackList:   interfaceBlacklist,
+		Timeout:              timeout,
+		UDPMux:               e.udpMux,
+		UDPMuxSrflx:          e.udpMuxSrflx,
+		ProxyConfig:          proxyConfig,
+		IPFamilyPreference:   e.config.IPFamilyPreference,
+		CandidateHarvester:   e.config.CandidateHarvester,
+		EnableTCPFallback:    e.config.EnableTCPFallback,
+		IceLite:              e.config.IceLite,
+		EnableSignalRelay:    e.config.EnableSignalRelay,
+		SignalRelayLimitKbps: e.config.SignalRelayLimitKbps,
+		AttemptTimeout:       e.config.PeerConnectionTimeout,
+		StagedICE:            e.config.StagedICE,
+		StageTimeouts:        e.config.ICEStageTimeouts,
+		Trace:                e.config.TraceConnections,
+		DialLimiter:          e.dialLimiter,
+		WgStats:              e.wgStats.PeerStats,
 	}
 
 	peerConn, err := peer.NewConn(config)
@@ -558,7 +1624,11 @@ func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, er
 	}
 
 	signalOffer := func(uFrag string, pwd string) error {
-		return signalAuth(uFrag, pwd, e.config.WgPrivateKey, wgPubKey, e.signal, false)
+		err := signalAuth(uFrag, pwd, e.config.IceLite, e.config.WgPrivateKey, wgPubKey, e.signal, false)
+		if errors.Is(err, signal.ErrPeerNotConnected) {
+			return fmt.Errorf("%w: %v", peer.ErrPeerOffline, err)
+		}
+		return err
 	}
 
 	signalCandidate := func(candidate ice.Candidate) error {
@@ -566,25 +1636,107 @@ func (e Engine) createPeerConn(pubKey string, allowedIPs string) (*peer.Conn, er
 	}
 
 	signalAnswer := func(uFrag string, pwd string) error {
-		return signalAuth(uFrag, pwd, e.config.WgPrivateKey, wgPubKey, e.signal, true)
+		return signalAuth(uFrag, pwd, e.config.IceLite, e.config.WgPrivateKey, wgPubKey, e.signal, true)
 	}
 
 	peerConn.SetSignalCandidate(signalCandidate)
 	peerConn.SetSignalOffer(signalOffer)
 	peerConn.SetSignalAnswer(signalAnswer)
+	peerConn.SetSignalRelayPacket(func(packet []byte) error {
+		return signalRelayPacket(packet, e.config.WgPrivateKey, wgPubKey, e.signal)
+	})
+	peerConn.SetOnDirectConnection(func(endpoint *net.UDPAddr) {
+		e.cacheEndpoint(pubKey, endpoint, false)
+	})
+	peerConn.SetOnRelayedConnection(func(relay *net.UDPAddr) {
+		e.cacheEndpoint(pubKey, relay, true)
+	})
+
+	peerConn.SetOnEndpointRejected(func(string) {
+		e.countRejectedEndpoint()
+	})
+
+	peerConn.SetOnAttemptOutcome(func(class peer.FailureClass) {
+		e.recordConnOutcome(pubKey, class)
+	})
 
 	return peerConn, nil
 }
 
+// cacheEndpoint remembers a working Wireguard endpoint of the remote peer, relayed if it is the TURN relay address
+// of the remote peer. The relay address expires sooner, with the TURN allocation
+func (e *Engine) cacheEndpoint(peerKey string, addr *net.UDPAddr, relayed bool) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if _, ok := e.peerConns[peerKey]; !ok {
+		return
+	}
+	ttl := cachedEndpointTTL
+	if relayed {
+		ttl = cachedRelayEndpointTTL
+	}
+	e.peerEndpoints[peerKey] = &cachedEndpoint{addr: addr, relayed: relayed, expiresAt: time.Now().Add(ttl)}
+}
+
+// resetCachedEndpoint marks the cached endpoint of the remote peer as not in use.
+// Called before a negotiated connection attempt that takes over the Wireguard peer configuration
+func (e *Engine) resetCachedEndpoint(peerKey string) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if endpoint, ok := e.peerEndpoints[peerKey]; ok {
+		endpoint.restored = false
+	}
+}
+
+// restoreFromCachedEndpoint configures the Wireguard peer with the last known working endpoint of the remote peer
+// when the connection can't be negotiated (Signal is unavailable). Returns true if the peer has been restored.
+// Expired endpoints are discarded
+func (e *Engine) restoreFromCachedEndpoint(peerKey string, allowedIPs string) bool {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	endpoint, ok := e.peerEndpoints[peerKey]
+	if !ok || endpoint.restored {
+		return false
+	}
+
+	if time.Now().After(endpoint.expiresAt) {
+		log.Debugf("cached endpoint %s of peer %s has expired", endpoint.addr, peerKey)
+		delete(e.peerEndpoints, peerKey)
+		return false
+	}
+
+	err := e.wgInterface.UpdatePeer(peerKey, allowedIPs, proxy.DefaultWgKeepAlive, endpoint.addr, e.config.PreSharedKey)
+	if err != nil {
+		log.Warnf("failed restoring connection to peer %s using cached endpoint %s: %v", peerKey, endpoint.addr, err)
+		return false
+	}
+
+	endpoint.restored = true
+	if endpoint.relayed {
+		log.Infof("signal client isn't ready, restored connection to peer %s using its cached relay address %s", peerKey, endpoint.addr)
+		return true
+	}
+	log.Infof("signal client isn't ready, restored connection to peer %s using cached endpoint %s", peerKey, endpoint.addr)
+	return true
+}
+
 // receiveSignalEvents connects to the Signal Service event stream to negotiate connection with remote peers
 func (e *Engine) receiveSignalEvents() {
 	go func() {
 		// connect to a stream of messages coming from the signal server
 		err := e.signal.Receive(func(msg *sProto.Message) error {
-			e.syncMsgMux.Lock()
-			defer e.syncMsgMux.Unlock()
+			// the control messages are handed to the application without holding the lock, so it can reply right away
+			if msg.GetBody().GetType() == sProto.Body_CONTROL {
+				return e.receiveControlMessage(msg.GetKey(), msg.GetBody().GetData())
+			}
 
+			// the Conn of each peer handles its messages on its own, so they aren't blocked by a NetworkMap being applied
+			e.peersMux.RLock()
 			conn := e.peerConns[msg.Key]
+			e.peersMux.RUnlock()
 			if conn == nil {
 				return fmt.Errorf("wrongly addressed message %s", msg.Key)
 			}
@@ -595,9 +1747,14 @@ func (e *Engine) receiveSignalEvents() {
 				if err != nil {
 					return err
 				}
+				// the offer of a dormant peer is queued until the woken up peer proceeds with it
+				e.wakePeer(msg.Key)
+				// the peer offering a connection is online even if the Management service hasn't reported it yet
+				e.markPeerOnline(msg.Key)
 				conn.OnRemoteOffer(peer.IceCredentials{
-					UFrag: remoteCred.UFrag,
-					Pwd:   remoteCred.Pwd,
+					UFrag:   remoteCred.UFrag,
+					Pwd:     remoteCred.Pwd,
+					IceLite: remoteCred.IceLite,
 				})
 			case sProto.Body_ANSWER:
 				remoteCred, err := signal.UnMarshalCredential(msg)
@@ -605,8 +1762,9 @@ func (e *Engine) receiveSignalEvents() {
 					return err
 				}
 				conn.OnRemoteAnswer(peer.IceCredentials{
-					UFrag: remoteCred.UFrag,
-					Pwd:   remoteCred.Pwd,
+					UFrag:   remoteCred.UFrag,
+					Pwd:     remoteCred.Pwd,
+					IceLite: remoteCred.IceLite,
 				})
 			case sProto.Body_CANDIDATE:
 				candidate, err := ice.UnmarshalCandidate(msg.GetBody().Payload)
@@ -615,6 +1773,8 @@ func (e *Engine) receiveSignalEvents() {
 					return err
 				}
 				conn.OnRemoteCandidate(candidate)
+			case sProto.Body_RELAY:
+				conn.OnRelayPacket(msg.GetBody().GetData())
 			}
 
 			return nil
@@ -0,0 +1,550 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	"github.com/netbirdio/netbird/iface"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/netbirdio/netbird/testutil"
+	"github.com/pion/turn/v2"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestEngine_UpdatePeerRateLimits(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ifaceName := "utun105"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33105,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rateLimitClasses := func() []netlink.Class {
+		classes, err := netlink.ClassList(link, netlink.MakeHandle(1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return classes
+	}
+
+	remotePeer := &mgmtProto.RemotePeerConfig{
+		WgPubKey:      remoteKey.PublicKey().String(),
+		AllowedIps:    []string{"100.64.0.10/32"},
+		RateLimitKbps: 1000,
+	}
+
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 1, RemotePeers: []*mgmtProto.RemotePeerConfig{remotePeer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engine.peerRateLimits[remotePeer.WgPubKey] != 1000 {
+		t.Errorf("expected peer rate limit 1000 kbit/s, got %d", engine.peerRateLimits[remotePeer.WgPubKey])
+	}
+	classes := rateLimitClasses()
+	if len(classes) != 1 {
+		t.Fatalf("expected a single rate limit class, got %d", len(classes))
+	}
+	if htbClass, ok := classes[0].(*netlink.HtbClass); !ok || htbClass.Rate != 1000*1000/8 {
+		t.Errorf("rate limit class has mismatched rate %v", classes[0])
+	}
+
+	// a limit of 0 lifts the shaping of the peer
+	remotePeer.RateLimitKbps = 0
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 2, RemotePeers: []*mgmtProto.RemotePeerConfig{remotePeer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := engine.peerRateLimits[remotePeer.WgPubKey]; ok {
+		t.Error("expected peer rate limit to be removed")
+	}
+	if len(rateLimitClasses()) != 0 {
+		t.Error("expected rate limit class to be removed")
+	}
+
+	// removing a limited peer removes the shaping as well
+	remotePeer.RateLimitKbps = 500
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 3, RemotePeers: []*mgmtProto.RemotePeerConfig{remotePeer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rateLimitClasses()) != 1 {
+		t.Fatal("expected a rate limit class of the peer")
+	}
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 4, RemotePeersIsEmpty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(engine.peerRateLimits) != 0 || len(rateLimitClasses()) != 0 {
+		t.Error("expected rate limit to be removed together with the peer")
+	}
+}
+
+func TestEngine_UpdateRouteMetric(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ifaceName := "utun110"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33110,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, network, _ := net.ParseCIDR("100.64.0.0/24")
+	routeMetrics := func() []int {
+		routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{LinkIndex: link.Attrs().Index, Dst: network},
+			netlink.RT_FILTER_OIF|netlink.RT_FILTER_DST)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var metrics []int
+		for _, route := range routes {
+			metrics = append(metrics, route.Priority)
+		}
+		return metrics
+	}
+
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial:             1,
+		PeerConfig:         &mgmtProto.PeerConfig{Address: "100.64.0.1/24", RouteMetric: 50},
+		RemotePeersIsEmpty: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics := routeMetrics(); len(metrics) != 1 || metrics[0] != 50 {
+		t.Errorf("expected a single route to the interface network with metric 50, got metrics %v", metrics)
+	}
+
+	// the metric survives readdressing the interface
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial:             2,
+		PeerConfig:         &mgmtProto.PeerConfig{Address: "100.64.0.2/24", RouteMetric: 50},
+		RemotePeersIsEmpty: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics := routeMetrics(); len(metrics) != 1 || metrics[0] != 50 {
+		t.Errorf("expected the readdressed interface route to keep metric 50, got metrics %v", metrics)
+	}
+
+	// a metric of 0 restores the default route of the kernel
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial:             3,
+		PeerConfig:         &mgmtProto.PeerConfig{Address: "100.64.0.2/24"},
+		RemotePeersIsEmpty: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics := routeMetrics(); len(metrics) != 1 || metrics[0] != 0 {
+		t.Errorf("expected a single route to the interface network with metric 0, got metrics %v", metrics)
+	}
+}
+
+func TestEngine_UpgradeRelayedConnection(t *testing.T) {
+	turnServer, turnPort, err := startTURN("netbird", "netbird")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer turnServer.Close() //nolint
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	services := startTestServices(t, testutil.Options{
+		TURNConfig: &server.TURNConfig{
+			Turns: []*server.Host{{
+				Proto:    "udp",
+				URI:      fmt.Sprintf("turn:127.0.0.1:%d", turnPort),
+				Username: "netbird",
+				Password: "netbird",
+			}},
+		},
+	})
+
+	// the relay wins the first negotiation: the existing interfaces don't give host candidates
+	// and the relay candidates are allocated on the loopback TURN server
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blackList := make(map[string]struct{})
+	for _, netIface := range interfaces {
+		blackList[netIface.Name] = struct{}{}
+	}
+
+	var engines []*Engine
+	for i := 0; i < 2; i++ {
+		engine, err := createEngine(ctx, cancel, services, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine.config.IFaceBlackList = blackList
+		err = engine.Start()
+		if err != nil {
+			t.Fatal(err)
+		}
+		engines = append(engines, engine)
+	}
+	defer func() {
+		for _, engine := range engines {
+			_ = engine.mgmClient.Close()
+			err := engine.Stop()
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	connTypes := func() []PeerConnStatus {
+		var statuses []PeerConnStatus
+		for _, engine := range engines {
+			statuses = append(statuses, engine.GetStatus().Peers...)
+		}
+		return statuses
+	}
+	waitConnTypes := func(timeout time.Duration, match func(status PeerConnStatus) bool) bool {
+		deadline := time.Now().Add(timeout)
+		for time.Now().Before(deadline) {
+			statuses := connTypes()
+			matched := len(statuses) == len(engines)
+			for _, status := range statuses {
+				matched = matched && match(status)
+			}
+			if matched {
+				return true
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+		return false
+	}
+
+	relayed := waitConnTypes(30*time.Second, func(status PeerConnStatus) bool {
+		return status.ConnType == peer.ConnTypeRelayed
+	})
+	if !relayed {
+		t.Fatalf("expecting the peers to be connected through the relay, got %v", connTypes())
+	}
+
+	// the peers can reach each other without the relay once the LAN comes up. It is a tunnel link,
+	// the network monitor ignores it and doesn't re-establish the connection
+	lan := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "nbupgrade0"}, Mode: netlink.TUNTAP_MODE_TUN}
+	err = netlink.LinkAdd(lan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netlink.LinkDel(lan) //nolint
+	addr, err := netlink.ParseAddr("10.99.0.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = netlink.AddrAdd(lan, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = netlink.LinkSetUp(lan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgraded := waitConnTypes(60*time.Second, func(status PeerConnStatus) bool {
+		return status.ConnType == peer.ConnTypeDirect && status.UpgradedFrom == peer.ConnTypeRelayed &&
+			!status.UpgradedAt.IsZero() && status.Status == "Connected"
+	})
+	if !upgraded {
+		t.Fatalf("expecting the relayed connection to be upgraded to a direct one, got %v", connTypes())
+	}
+}
+
+// bindingFilterConn drops the STUN binding requests, so the TURN server gives no server reflexive candidates
+type bindingFilterConn struct {
+	net.PacketConn
+}
+
+func (c *bindingFilterConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		// a STUN message header is 20 bytes long, the binding request type is 0x0001
+		if err != nil || n < 20 || binary.BigEndian.Uint16(p) != 0x0001 {
+			return n, addr, err
+		}
+	}
+}
+
+// startTURN starts a TURN server on the loopback with the static credentials and returns its port.
+// The server relays only, it doesn't answer the STUN binding requests
+func startTURN(username string, password string) (*turn.Server, int, error) {
+	listener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, 0, err
+	}
+	conn := &bindingFilterConn{PacketConn: listener}
+
+	realm := "netbird.io"
+	key := turn.GenerateAuthKey(username, realm, password)
+	s, err := turn.NewServer(turn.ServerConfig{
+		Realm: realm,
+		AuthHandler: func(u string, r string, srcAddr net.Addr) ([]byte, bool) {
+			return key, u == username
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn: conn,
+			RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		}},
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, 0, err
+	}
+
+	return s, conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+func TestEngine_NetworkRouteFailover(t *testing.T) {
+	networkRouteCheckInterval = 100 * time.Millisecond
+	networkRouteHoldDown = 2 * time.Second
+	defer func() {
+		networkRouteCheckInterval = time.Second
+		networkRouteHoldDown = 30 * time.Second
+	}()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ifaceName := "utun112"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33112,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+	err = engine.wgInterface.Configure(key.String(), 33112)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gateways are connected with their static endpoints, the handshakes never complete but the traffic to them
+	// triggers the handshake initiations counted by Wireguard
+	var gateways []string
+	for i := 0; i < 2; i++ {
+		gatewayKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peerKey := gatewayKey.PublicKey().String()
+		allowedIPs := fmt.Sprintf("100.64.0.%d/32", 10+i)
+		endpoint := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40110 + i}
+
+		conn, err := engine.createPeerConn(peerKey, allowedIPs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine.peerConns[peerKey] = conn
+		engine.peerStaticEndpoints[peerKey] = &staticEndpoint{addr: endpoint.String(), active: true}
+		err = engine.wgInterface.UpdatePeer(peerKey, allowedIPs, 0, endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gateways = append(gateways, peerKey)
+	}
+	gatewayA, gatewayB := gateways[0], gateways[1]
+
+	network := "192.168.210.0/24"
+	destination := net.ParseIP("192.168.210.5")
+
+	routedThrough := func() string {
+		client, err := wgctrl.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		device, err := client.Device(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range device.Peers {
+			for _, allowedIP := range p.AllowedIPs {
+				if allowedIP.String() == network {
+					return p.PublicKey.String()
+				}
+			}
+		}
+		return ""
+	}
+	osRouted := func() bool {
+		link, err := netlink.LinkByName(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routes, err := netlink.RouteGet(destination)
+		return err == nil && len(routes) > 0 && routes[0].LinkIndex == link.Attrs().Index
+	}
+	txBytes := func(peerKey string) int64 {
+		stats, err := engine.wgInterface.GetPeerStats(peerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats.TxBytes
+	}
+	// assertTrafficThrough sends packets to the network until Wireguard sends them to the gateway
+	assertTrafficThrough := func(peerKey string) {
+		before := txBytes(peerKey)
+		conn, err := net.Dial("udp", net.JoinHostPort(destination.String(), "9"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		deadline := time.Now().Add(10 * time.Second)
+		for txBytes(peerKey) == before {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the traffic to network %s to be sent to gateway %s", network, peerKey)
+			}
+			_, _ = conn.Write([]byte("ping"))
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+	waitRoutedThrough := func(peerKey string, timeout time.Duration) {
+		deadline := time.Now().Add(timeout)
+		for routedThrough() != peerKey {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected network %s to be routed through %q, got %q", network, peerKey, routedThrough())
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	setConnected := func(peerKey string, connected bool) {
+		engine.syncMsgMux.Lock()
+		defer engine.syncMsgMux.Unlock()
+		engine.peerStaticEndpoints[peerKey].active = connected
+	}
+
+	engine.syncMsgMux.Lock()
+	engine.updateNetworkRoutes([]*mgmtProto.Route{
+		{ID: "backup", Prefix: network, Peer: gatewayB, Metric: 20},
+		{ID: "primary", Prefix: network, Peer: gatewayA, Metric: 10},
+	})
+	engine.syncMsgMux.Unlock()
+	engine.watchNetworkRoutes()
+
+	// the network is routed through the preferred gateway
+	if peerKey := routedThrough(); peerKey != gatewayA {
+		t.Fatalf("expected network %s to be routed through the preferred gateway %s, got %q", network, gatewayA, peerKey)
+	}
+	if !osRouted() {
+		t.Fatalf("expected network %s to be routed through interface %s", network, ifaceName)
+	}
+	assertTrafficThrough(gatewayA)
+
+	// the active gateway goes down, the traffic shifts to the backup one within a few seconds
+	setConnected(gatewayA, false)
+	waitRoutedThrough(gatewayB, 3*time.Second)
+	assertTrafficThrough(gatewayB)
+	status := engine.GetStatus()
+	if len(status.NetworkRoutes) != 1 || status.NetworkRoutes[0].Peer != gatewayB {
+		t.Errorf("expected the status to show network %s routed through %s, got %v", network, gatewayB, status.NetworkRoutes)
+	}
+
+	// the preferred gateway is back, the network is moved back only after the hold-down
+	setConnected(gatewayA, true)
+	time.Sleep(networkRouteHoldDown / 2)
+	if peerKey := routedThrough(); peerKey != gatewayB {
+		t.Fatalf("expected network %s to stay routed through %s during the hold-down, got %q", network, gatewayB, peerKey)
+	}
+	waitRoutedThrough(gatewayA, 3*time.Second)
+	assertTrafficThrough(gatewayA)
+
+	// both gateways are down, the route is removed
+	setConnected(gatewayA, false)
+	setConnected(gatewayB, false)
+	waitRoutedThrough("", 3*time.Second)
+	if osRouted() {
+		t.Errorf("expected no route to network %s once none of the gateways is connected", network)
+	}
+	status = engine.GetStatus()
+	if len(status.NetworkRoutes) != 1 || status.NetworkRoutes[0].Peer != "" {
+		t.Errorf("expected the status to show network %s unreachable, got %v", network, status.NetworkRoutes)
+	}
+}
@@ -4,39 +4,26 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"os"
-	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/netbirdio/netbird/client/system"
+	"github.com/netbirdio/netbird/iface"
 	mgmt "github.com/netbirdio/netbird/management/client"
 	mgmtProto "github.com/netbirdio/netbird/management/proto"
-	"github.com/netbirdio/netbird/management/server"
 	signal "github.com/netbirdio/netbird/signal/client"
 	"github.com/netbirdio/netbird/signal/proto"
-	signalServer "github.com/netbirdio/netbird/signal/server"
-	"github.com/netbirdio/netbird/util"
-	log "github.com/sirupsen/logrus"
+	"github.com/netbirdio/netbird/testutil"
+	"github.com/netbirdio/netbird/testutil/harness"
+	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/keepalive"
-)
-
-var (
-	kaep = keepalive.EnforcementPolicy{
-		MinTime:             15 * time.Second,
-		PermitWithoutStream: true,
-	}
-
-	kasp = keepalive.ServerParameters{
-		MaxConnectionIdle:     15 * time.Second,
-		MaxConnectionAgeGrace: 5 * time.Second,
-		Time:                  5 * time.Second,
-		Timeout:               2 * time.Second,
-	}
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestEngine_UpdateNetworkMap(t *testing.T) {
@@ -68,17 +55,17 @@ func TestEngine_UpdateNetworkMap(t *testing.T) {
 
 	peer1 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
-		AllowedIps: []string{"100.64.0.10/24"},
+		AllowedIps: []string{"100.64.0.10/32"},
 	}
 
 	peer2 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
-		AllowedIps: []string{"100.64.0.11/24"},
+		AllowedIps: []string{"100.64.0.11/32"},
 	}
 
 	peer3 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "GGHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
-		AllowedIps: []string{"100.64.0.12/24"},
+		AllowedIps: []string{"100.64.0.12/32"},
 	}
 
 	case1 := testCase{
@@ -155,7 +142,29 @@ func TestEngine_UpdateNetworkMap(t *testing.T) {
 		expectedSerial: 5,
 	}
 
-	for _, c := range []testCase{case1, case2, case3, case4, case5} {
+	// 6th case - a large account, the update is reconciled peer by peer
+	largeMap := &mgmtProto.NetworkMap{Serial: 6}
+	var largePeers []string
+	for i := 0; i < 1000; i++ {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		largeMap.RemotePeers = append(largeMap.RemotePeers, &mgmtProto.RemotePeerConfig{
+			WgPubKey:   peerKey.PublicKey().String(),
+			AllowedIps: []string{fmt.Sprintf("100.64.%d.%d/32", i/250, i%250+1)},
+		})
+		largePeers = append(largePeers, peerKey.PublicKey().String())
+	}
+	case6 := testCase{
+		name:           "input with a large number of peers to add",
+		networkMap:     largeMap,
+		expectedLen:    1000,
+		expectedPeers:  largePeers,
+		expectedSerial: 6,
+	}
+
+	for _, c := range []testCase{case1, case2, case3, case4, case5, case6} {
 		t.Run(c.name, func(t *testing.T) {
 			err = engine.updateNetworkMap(c.networkMap)
 			if err != nil {
@@ -178,6 +187,17 @@ func TestEngine_UpdateNetworkMap(t *testing.T) {
 			}
 		})
 	}
+
+	// re-applying an unchanged large NetworkMap must not allocate per peer
+	allocs := testing.AllocsPerRun(10, func() {
+		err = engine.updateNetworkMap(largeMap)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocs > 100 {
+		t.Errorf("expecting an unchanged NetworkMap of 1000 peers to be reconciled with less than 100 allocations, got %.0f", allocs)
+	}
 }
 
 func TestEngine_Sync(t *testing.T) {
@@ -225,15 +245,15 @@ func TestEngine_Sync(t *testing.T) {
 
 	peer1 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
-		AllowedIps: []string{"100.64.0.10/24"},
+		AllowedIps: []string{"100.64.0.10/32"},
 	}
 	peer2 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "LLHf3Ma6z6mdLbriAJbqhX9+nM/B71lgw2+91q3LlhU=",
-		AllowedIps: []string{"100.64.0.11/24"},
+		AllowedIps: []string{"100.64.0.11/32"},
 	}
 	peer3 := &mgmtProto.RemotePeerConfig{
 		WgPubKey:   "GGHf3Ma6z6mdLbriAJbqhX9+nM/B71lgw2+91q3LlhU=",
-		AllowedIps: []string{"100.64.0.12/24"},
+		AllowedIps: []string{"100.64.0.12/32"},
 	}
 	// 1st update with just 1 peer and serial larger than the current serial of the engine => apply update
 	updates <- &mgmtProto.SyncResponse{
@@ -260,216 +280,1690 @@ func TestEngine_Sync(t *testing.T) {
 	}
 }
 
-func TestEngine_MultiplePeers(t *testing.T) {
-	// log.SetLevel(log.DebugLevel)
+func TestEngine_RandomWgPort(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	sentInfo := make(chan *system.Info, 1)
+	mgmtClient := &mgmt.MockClient{
+		GetServerPublicKeyFunc: func() (*wgtypes.Key, error) {
+			return &key, nil
+		},
+		LoginFunc: func(serverKey wgtypes.Key, info *system.Info) (*mgmtProto.LoginResponse, error) {
+			sentInfo <- info
+			return &mgmtProto.LoginResponse{}, nil
+		},
+	}
 
-	dir := t.TempDir()
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun106",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       0,
+	})
+	err = engine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop() //nolint
 
-	err := util.CopyFileContents("../testdata/store.json", filepath.Join(dir, "store.json"))
+	port, err := engine.wgInterface.GetListenPort()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		err = os.Remove(filepath.Join(dir, "store.json")) //nolint
+	if *port == 0 || engine.config.WgPort != *port {
+		t.Fatalf("expecting a random port to be picked and used by the engine, got %d, engine uses %d", *port, engine.config.WgPort)
+	}
+	if got := CtxGetState(ctx).WgPort(); got != *port {
+		t.Errorf("expecting Wireguard port %d to be available in the context state, got %d", *port, got)
+	}
+
+	select {
+	case info := <-sentInfo:
+		if info.WgPort != *port {
+			t.Errorf("expecting Wireguard port %d to be reported to Management Service, got %d", *port, info.WgPort)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting Wireguard port to be reported to Management Service")
+	}
+
+	// a remote peer restarted with another port is reconnected to it
+	peerKey := "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	for serial, remotePort := range []int32{0, 40000} {
+		engine.syncMsgMux.Lock()
+		err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+			Serial: uint64(serial + 1),
+			RemotePeers: []*mgmtProto.RemotePeerConfig{{
+				WgPubKey:   peerKey,
+				AllowedIps: []string{"100.64.0.10/32"},
+				WgPort:     remotePort,
+			}},
+		})
+		conn := engine.peerConns[peerKey]
+		engine.syncMsgMux.Unlock()
 		if err != nil {
 			t.Fatal(err)
-			return
 		}
-	}()
-
-	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
-	defer cancel()
+		if conn == nil || conn.GetRemoteWgPort() != int(remotePort) {
+			t.Fatalf("expecting connection to the remote port %d, got %v", remotePort, conn)
+		}
+	}
+}
 
-	sport := 10010
-	sigServer, err := startSignal(sport)
+func TestEngine_MonitorOnly(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
 		t.Fatal(err)
 		return
 	}
-	defer sigServer.Stop()
-	mport := 33081
-	mgmtServer, err := startManagement(mport, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// feed updates to Engine via mocked Management client
+	updates := make(chan *mgmtProto.SyncResponse)
+	defer close(updates)
+	syncFunc := func(msgHandler func(msg *mgmtProto.SyncResponse) error) error {
+		for msg := range updates {
+			err := msgHandler(msg)
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		return nil
+	}
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{SyncFunc: syncFunc}, &EngineConfig{
+		WgIfaceName:  "utun101",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33101,
+		MonitorOnly:  true,
+	})
+
+	err = engine.Start()
 	if err != nil {
 		t.Fatal(err)
 		return
 	}
-	defer mgmtServer.GracefulStop()
+	defer func() {
+		err := engine.Stop()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
 
-	setupKey := "A2C8E62B-38F5-4553-B31E-DD66C696CEBB"
+	if _, err := net.InterfaceByName("utun101"); err == nil {
+		t.Fatal("expecting no Wireguard interface to be created in the monitor only mode")
+	}
 
-	mu := sync.Mutex{}
-	engines := []*Engine{}
-	numPeers := 10
-	wg := sync.WaitGroup{}
-	wg.Add(numPeers)
-	// create and start peers
-	for i := 0; i < numPeers; i++ {
-		j := i
-		go func() {
-			engine, err := createEngine(ctx, cancel, setupKey, j, mport, sport)
-			if err != nil {
-				wg.Done()
-				t.Errorf("unable to create the engine for peer %d with error %v", j, err)
+	peer1 := &mgmtProto.RemotePeerConfig{
+		WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+		AllowedIps: []string{"100.64.0.10/24"},
+	}
+	peer2 := &mgmtProto.RemotePeerConfig{
+		WgPubKey:   "LLHf3Ma6z6mdLbriAJbqhX9+nM/B71lgw2+91q3LlhU=",
+		AllowedIps: []string{"100.64.0.11/24"},
+	}
+
+	waitForPeers := func(expected int, serial uint64) {
+		timeout := time.After(time.Second * 2)
+		for {
+			select {
+			case <-timeout:
+				t.Fatalf("timeout while waiting for %d peers with serial %d, got %v", expected, serial, engine.GetPeers())
 				return
+			default:
 			}
-			mu.Lock()
-			defer mu.Unlock()
-			err = engine.Start()
-			if err != nil {
-				t.Errorf("unable to start engine for peer %d with error %v", j, err)
-				wg.Done()
+
+			engine.syncMsgMux.Lock()
+			applied := engine.networkSerial == serial
+			engine.syncMsgMux.Unlock()
+			if applied && len(engine.GetPeers()) == expected {
 				return
 			}
-			engines = append(engines, engine)
-			wg.Done()
-		}()
+			time.Sleep(10 * time.Millisecond)
+		}
 	}
 
-	// wait until all have been created and started
-	wg.Wait()
-	if len(engines) != numPeers {
-		t.Fatal("not all peers was started")
+	updates <- &mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:      10,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{peer1, peer2},
+		},
 	}
-	// check whether all the peer have expected peers connected
+	waitForPeers(2, 10)
 
-	expectedConnected := numPeers * (numPeers - 1)
+	if connected := engine.GetConnectedPeers(); len(connected) != 0 {
+		t.Errorf("expecting no connected peers in the monitor only mode, got %v", connected)
+	}
+	engine.syncMsgMux.Lock()
+	conns := len(engine.peerConns)
+	engine.syncMsgMux.Unlock()
+	if conns != 0 {
+		t.Errorf("expecting no peer connections in the monitor only mode, got %d", conns)
+	}
 
-	// adjust according to timeouts
-	timeout := 50 * time.Second
-	timeoutChan := time.After(timeout)
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-loop:
-	for {
-		select {
-		case <-timeoutChan:
-			t.Fatalf("waiting for expected connections timeout after %s", timeout.String())
-			break loop
-		case <-ticker.C:
-			totalConnected := 0
-			for _, engine := range engines {
-				totalConnected = totalConnected + len(engine.GetConnectedPeers())
-			}
-			if totalConnected == expectedConnected {
-				log.Infof("total connected=%d", totalConnected)
-				break loop
-			}
-			log.Infof("total connected=%d", totalConnected)
-		}
+	updates <- &mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:      11,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{peer2},
+		},
 	}
-	// cleanup test
-	for n, peerEngine := range engines {
-		t.Logf("stopping peer with interface %s from multipeer test, loopIndex %d", peerEngine.wgInterface.Name, n)
-		errStop := peerEngine.mgmClient.Close()
-		if errStop != nil {
-			log.Infoln("got error trying to close management clients from engine: ", errStop)
-		}
-		errStop = peerEngine.Stop()
-		if errStop != nil {
-			log.Infoln("got error trying to close testing peers engine: ", errStop)
-		}
+	waitForPeers(1, 11)
+
+	if peers := engine.GetPeers(); peers[0] != peer2.GetWgPubKey() {
+		t.Errorf("expecting peer %s to remain, got %v", peer2.GetWgPubKey(), peers)
+	}
+
+	updates <- &mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:             12,
+			RemotePeersIsEmpty: true,
+		},
 	}
+	waitForPeers(0, 12)
 }
 
-func createEngine(ctx context.Context, cancel context.CancelFunc, setupKey string, i int, mport int, sport int) (*Engine, error) {
+func TestEngine_PeerNames(t *testing.T) {
 	key, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
-		return nil, err
-	}
-	mgmtClient, err := mgmt.NewClient(ctx, fmt.Sprintf("localhost:%d", mport), key, false)
-	if err != nil {
-		return nil, err
+		t.Fatal(err)
+		return
 	}
-	signalClient, err := signal.NewClient(ctx, fmt.Sprintf("localhost:%d", sport), key, false)
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun102",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33102,
+		MonitorOnly:  true,
+	})
+
+	peerKey := "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial: 1,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{{
+				WgPubKey:   peerKey,
+				AllowedIps: []string{"100.64.0.10/24"},
+				Name:       "Office Gateway",
+			}},
+		},
+	})
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
 
-	publicKey, err := mgmtClient.GetServerPublicKey()
-	if err != nil {
-		return nil, err
+	if name := engine.GetPeerName(peerKey); name != "Office Gateway" {
+		t.Errorf("expecting peer name Office Gateway, got %s", name)
+	}
+	if names := CtxGetState(ctx).PeerNames(); names[peerKey] != "Office Gateway" {
+		t.Errorf("expecting peer name Office Gateway to be available in the context state, got %v", names)
 	}
 
-	info := system.GetInfo(ctx)
-	resp, err := mgmtClient.Register(*publicKey, setupKey, "", info)
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial:             2,
+			RemotePeersIsEmpty: true,
+		},
+	})
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
 
-	var ifaceName string
-	if runtime.GOOS == "darwin" {
-		ifaceName = fmt.Sprintf("utun1%d", i)
-	} else {
-		ifaceName = fmt.Sprintf("wt%d", i)
+	if name := engine.GetPeerName(peerKey); name != "" {
+		t.Errorf("expecting no name of a removed peer, got %s", name)
 	}
-
-	wgPort := 33100 + i
-	conf := &EngineConfig{
-		WgIfaceName:  ifaceName,
-		WgAddr:       resp.PeerConfig.Address,
-		WgPrivateKey: key,
-		WgPort:       wgPort,
+	if names := CtxGetState(ctx).PeerNames(); len(names) != 0 {
+		t.Errorf("expecting no peer names in the context state, got %v", names)
 	}
-
-	return NewEngine(ctx, cancel, signalClient, mgmtClient, conf), nil
 }
 
-func startSignal(port int) (*grpc.Server, error) {
-	s := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
+func TestEngine_ExitNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName: "utun102",
+		WgAddr:      "100.64.0.1/24",
+		WgPort:      33102,
+	})
 
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
-	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+	exitKey := "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	otherKey := "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	peersUpdate := []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: otherKey, AllowedIps: []string{"100.64.0.20/32"}, Name: "laptop"},
+		{WgPubKey: exitKey, AllowedIps: []string{"100.64.0.10/32"}, Name: "Office Gateway"},
 	}
 
-	proto.RegisterSignalExchangeServer(s, signalServer.NewServer())
-
-	go func() {
-		if err = s.Serve(lis); err != nil {
-			log.Fatalf("failed to serve: %v", err)
+	for _, selector := range []string{exitKey, "office gateway", "100.64.0.10"} {
+		if found := findExitNode(selector, peersUpdate); found != exitKey {
+			t.Errorf("expecting exit node %s selected by %s, got %s", exitKey, selector, found)
 		}
-	}()
+	}
+	if found := findExitNode("100.64.0.99", peersUpdate); found != "" {
+		t.Errorf("expecting no exit node for an unknown peer, got %s", found)
+	}
+
+	// no exit node selected
+	if update := engine.withExitNode(peersUpdate); len(update[1].GetAllowedIps()) != 1 {
+		t.Errorf("expecting no default route through a peer without an exit node, got %v", update[1].GetAllowedIps())
+	}
+
+	engine.config.ExitNode = "Office Gateway"
+	update := engine.withExitNode(peersUpdate)
+	if engine.exitNodeKey != exitKey {
+		t.Errorf("expecting exit node %s, got %s", exitKey, engine.exitNodeKey)
+	}
+	expected := []string{"100.64.0.10/32", exitNodeAllowedIP}
+	if allowedIPs := update[1].GetAllowedIps(); fmt.Sprint(allowedIPs) != fmt.Sprint(expected) {
+		t.Errorf("expecting allowed IPs %v of the exit node, got %v", expected, allowedIPs)
+	}
+	if allowedIPs := update[0].GetAllowedIps(); len(allowedIPs) != 1 {
+		t.Errorf("expecting allowed IPs of another peer unchanged, got %v", allowedIPs)
+	}
+	// the NetworkMap isn't modified
+	if allowedIPs := peersUpdate[1].GetAllowedIps(); len(allowedIPs) != 1 {
+		t.Errorf("expecting allowed IPs of the update unchanged, got %v", allowedIPs)
+	}
 
-	return s, nil
+	// the exit node has left the network
+	engine.withExitNode(peersUpdate[:1])
+	if engine.exitNodeKey != "" {
+		t.Errorf("expecting no exit node once it has left the network, got %s", engine.exitNodeKey)
+	}
 }
 
-func startManagement(port int, dataDir string) (*grpc.Server, error) {
-	config := &server.Config{
-		Stuns:      []*server.Host{},
-		TURNConfig: &server.TURNConfig{},
-		Signal: &server.Host{
-			Proto: "http",
-			URI:   "localhost:10000",
-		},
-		Datadir:    dataDir,
-		HttpConfig: nil,
+func TestEngine_InstalledRoutes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the network routes are supported on Linux only")
 	}
 
-	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	key, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
-	s := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
-	store, err := server.NewStore(config.Datadir)
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	ifaceName := "utun105"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33105,
+	})
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
 	if err != nil {
-		log.Fatalf("failed creating a store: %s: %v", config.Datadir, err)
+		t.Fatal(err)
 	}
-	peersUpdateManager := server.NewPeersUpdateManager()
-	accountManager, err := server.BuildManager(store, peersUpdateManager, nil)
+	err = engine.wgInterface.Create()
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
-	turnManager := server.NewTimeBasedAuthSecretsManager(peersUpdateManager, config.TURNConfig)
-	mgmtServer, err := server.NewServer(config, accountManager, peersUpdateManager, turnManager)
-	if err != nil {
-		return nil, err
+	defer engine.wgInterface.Close() //nolint
+
+	restoredKey := "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	pendingKey := "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	for peerKey, allowedIPs := range map[string]string{
+		restoredKey: "100.64.0.10/32,10.10.0.0/16",
+		pendingKey:  "100.64.0.11/32,10.20.0.0/16",
+	} {
+		conn, err := engine.createPeerConn(peerKey, allowedIPs, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		engine.peerConns[peerKey] = conn
 	}
-	mgmtProto.RegisterManagementServiceServer(s, mgmtServer)
-	go func() {
-		if err = s.Serve(lis); err != nil {
-			log.Fatalf("failed to serve: %v", err)
+	// the Wireguard peer has been configured with the cached endpoint, the other peer isn't connected yet
+	engine.peerEndpoints[restoredKey] = &cachedEndpoint{
+		addr:      &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: iface.DefaultWgPort},
+		expiresAt: time.Now().Add(cachedEndpointTTL),
+		restored:  true,
+	}
+	// the routes are read from the OS, the per peer allowed IPs within the interface network aren't routes
+	for _, network := range []string{"10.10.1.0/24", "10.20.0.0/16"} {
+		err = engine.wgInterface.AddNetworkRoute(network)
+		if err != nil {
+			t.Fatal(err)
 		}
-	}()
+	}
 
-	return s, nil
+	expected := []RouteInfo{
+		{Network: "10.20.0.0/16", Interface: ifaceName},
+		{Network: "100.64.0.0/24", Interface: ifaceName},
+		{Network: "10.10.1.0/24", Peer: restoredKey, Interface: ifaceName},
+	}
+	routes, err := engine.GetInstalledRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != len(expected) {
+		t.Fatalf("expecting routes %v, got %v", expected, routes)
+	}
+	for i, route := range routes {
+		if route != expected[i] {
+			t.Errorf("expecting route %v, got %v", expected[i], route)
+		}
+	}
+
+	CtxGetState(ctx).SetInstalledRoutesSource(engine.GetInstalledRoutes)
+	if routes, err := CtxGetState(ctx).InstalledRoutes(); err != nil || len(routes) != len(expected) {
+		t.Errorf("expecting routes %v to be available in the context state, got %v, %v", expected, routes, err)
+	}
+
+	// a removed route is gone and the routes of a removed peer aren't attributed to it anymore
+	err = engine.wgInterface.RemoveNetworkRoute("10.20.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.syncMsgMux.Lock()
+	err = engine.removePeer(restoredKey)
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err = engine.GetInstalledRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []RouteInfo{
+		{Network: "10.10.1.0/24", Interface: ifaceName},
+		{Network: "100.64.0.0/24", Interface: ifaceName},
+	}
+	if len(routes) != len(expected) || routes[0] != expected[0] || routes[1] != expected[1] {
+		t.Errorf("expecting routes %v, got %v", expected, routes)
+	}
+
+	CtxGetState(ctx).SetInstalledRoutesSource(nil)
+	if routes, err := CtxGetState(ctx).InstalledRoutes(); err != nil || routes != nil {
+		t.Errorf("expecting no routes without a running engine, got %v, %v", routes, err)
+	}
+}
+
+func TestRoutePeer(t *testing.T) {
+	parse := func(cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return network
+	}
+	allowedIPs := map[string][]*net.IPNet{
+		"exit":    {parse("100.64.0.2/32"), parse("0.0.0.0/0")},
+		"router":  {parse("100.64.0.3/32"), parse("10.10.0.0/16")},
+		"router2": {parse("10.10.0.0/16")},
+	}
+
+	testCases := []struct {
+		network  string
+		expected string
+	}{
+		{network: "0.0.0.0/1", expected: "exit"},
+		{network: "10.10.1.0/24", expected: "router"},
+		{network: "10.10.0.0/16", expected: "router"},
+		{network: "10.0.0.0/8", expected: "exit"},
+		{network: "100.64.0.3/32", expected: "router"},
+	}
+	for _, testCase := range testCases {
+		if got := routePeer(parse(testCase.network), allowedIPs); got != testCase.expected {
+			t.Errorf("expecting route to %s to be attributed to %s, got %s", testCase.network, testCase.expected, got)
+		}
+	}
+	if got := routePeer(parse("10.0.0.0/8"), map[string][]*net.IPNet{"router": {parse("10.10.0.0/16")}}); got != "" {
+		t.Errorf("expecting a route wider than the allowed IPs not to be attributed, got %s", got)
+	}
+}
+
+func TestEngine_CredentialsUpdate(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun100",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33100,
+	})
+
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		NetworkMap: &mgmtProto.NetworkMap{
+			Serial: 5,
+			RemotePeers: []*mgmtProto.RemotePeerConfig{{
+				WgPubKey:   "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=",
+				AllowedIps: []string{"100.64.0.10/24"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// credentials refresh comes without the NetworkMap
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		WiretrusteeConfig: &mgmtProto.WiretrusteeConfig{
+			Turns: []*mgmtProto.ProtectedHostConfig{{
+				HostConfig: &mgmtProto.HostConfig{Uri: "turn:turn.wiretrustee.com:3478", Protocol: mgmtProto.HostConfig_UDP},
+				User:       "refreshed",
+				Password:   "password",
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(engine.TURNs) != 1 || engine.TURNs[0].Username != "refreshed" {
+		t.Errorf("expecting TURN credentials to be updated, got %v", engine.TURNs)
+	}
+	if engine.networkSerial != 5 {
+		t.Errorf("expecting the network serial to remain 5, got %d", engine.networkSerial)
+	}
+	if len(engine.GetPeers()) != 1 {
+		t.Errorf("expecting the peers to remain unchanged, got %v", engine.GetPeers())
+	}
+}
+
+func TestEngine_TURNCredentialsRefresh(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	turn := func(user string, expiresAt time.Time) *mgmtProto.ProtectedHostConfig {
+		return &mgmtProto.ProtectedHostConfig{
+			HostConfig: &mgmtProto.HostConfig{Uri: "turn:turn.wiretrustee.com:3478", Protocol: mgmtProto.HostConfig_UDP},
+			User:       user,
+			Password:   "password",
+			ExpiresAt:  timestamppb.New(expiresAt),
+		}
+	}
+
+	requested := make(chan time.Time, 1)
+	mgmClient := &mgmt.MockClient{
+		GetTURNCredentialsFunc: func() (*mgmtProto.TURNCredentialsResponse, error) {
+			select {
+			case requested <- time.Now():
+			default:
+			}
+			return &mgmtProto.TURNCredentialsResponse{
+				Turns: []*mgmtProto.ProtectedHostConfig{turn("refreshed", time.Now().Add(time.Hour))},
+			}, nil
+		},
+	}
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmClient, &EngineConfig{
+		WgIfaceName:       "utun100",
+		WgAddr:            "100.64.0.1/24",
+		WgPrivateKey:      key,
+		WgPort:            33100,
+		TURNRefreshMargin: time.Second,
+	})
+	defer func() {
+		engine.syncMsgMux.Lock()
+		engine.stopTURNRefresh()
+		engine.syncMsgMux.Unlock()
+	}()
+
+	ttl := 2 * time.Second
+	expiresAt := time.Now().Add(ttl)
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		WiretrusteeConfig: &mgmtProto.WiretrusteeConfig{
+			Turns: []*mgmtProto.ProtectedHostConfig{turn("initial", expiresAt)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case requestedAt := <-requested:
+		if !requestedAt.Before(expiresAt) {
+			t.Errorf("expecting new TURN credentials to be requested before the expiration at %s, got at %s", expiresAt, requestedAt)
+		}
+	case <-time.After(ttl):
+		t.Fatalf("expecting new TURN credentials to be requested before the expiration in %s", ttl)
+	}
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		engine.syncMsgMux.Lock()
+		refreshed := len(engine.TURNs) == 1 && engine.TURNs[0].Username == "refreshed"
+		renewedUntil := engine.turnCredentialsExpiresAt
+		engine.syncMsgMux.Unlock()
+		if refreshed {
+			if !renewedUntil.After(expiresAt) {
+				t.Errorf("expecting the refreshed credentials to expire after %s, got %s", expiresAt, renewedUntil)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expecting the requested TURN credentials to be applied, got %v", engine.TURNs)
+		}
+	}
+}
+
+func TestEngine_TURNCredentialsPushed(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	requested := make(chan struct{}, 1)
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{
+		GetTURNCredentialsFunc: func() (*mgmtProto.TURNCredentialsResponse, error) {
+			requested <- struct{}{}
+			return &mgmtProto.TURNCredentialsResponse{}, nil
+		},
+	}, &EngineConfig{
+		WgIfaceName:       "utun100",
+		WgAddr:            "100.64.0.1/24",
+		WgPrivateKey:      key,
+		WgPort:            33100,
+		TURNRefreshMargin: 500 * time.Millisecond,
+	})
+	defer func() {
+		engine.syncMsgMux.Lock()
+		engine.stopTURNRefresh()
+		engine.syncMsgMux.Unlock()
+	}()
+
+	push := func(expiresAt time.Time) {
+		err := engine.handleSync(&mgmtProto.SyncResponse{
+			WiretrusteeConfig: &mgmtProto.WiretrusteeConfig{
+				Turns: []*mgmtProto.ProtectedHostConfig{{
+					HostConfig: &mgmtProto.HostConfig{Uri: "turn:turn.wiretrustee.com:3478", Protocol: mgmtProto.HostConfig_UDP},
+					User:       fmt.Sprint(expiresAt.Unix()),
+					Password:   "password",
+					ExpiresAt:  timestamppb.New(expiresAt),
+				}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the credentials pushed by the Management Service before the refresh margin postpone the request
+	push(time.Now().Add(time.Second))
+	time.Sleep(300 * time.Millisecond)
+	push(time.Now().Add(time.Hour))
+
+	select {
+	case <-requested:
+		t.Errorf("expecting no request of new TURN credentials after they have been pushed")
+	case <-time.After(time.Second):
+	}
+}
+
+func TestEngine_EpochChange(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun100",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33100,
+	})
+
+	type testCase struct {
+		name           string
+		epoch          uint64
+		serial         uint64
+		expectedSerial uint64
+	}
+
+	testCases := []testCase{
+		{name: "initial sync", epoch: 1, serial: 10, expectedSerial: 10},
+		{name: "outdated serial of the same epoch is ignored", epoch: 1, serial: 3, expectedSerial: 10},
+		{name: "lower serial of a new epoch is applied", epoch: 2, serial: 3, expectedSerial: 3},
+		{name: "update without epoch keeps the epoch", epoch: 0, serial: 2, expectedSerial: 3},
+		{name: "newer serial of the same epoch is applied", epoch: 2, serial: 4, expectedSerial: 4},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			err = engine.handleSync(&mgmtProto.SyncResponse{
+				Epoch: c.epoch,
+				NetworkMap: &mgmtProto.NetworkMap{
+					Serial:             c.serial,
+					RemotePeersIsEmpty: true,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if engine.networkSerial != c.expectedSerial {
+				t.Errorf("expecting Engine.networkSerial to be equal to %d, actual %d", c.expectedSerial, engine.networkSerial)
+			}
+		})
+	}
+}
+
+func TestEngine_ClientUpdate(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun100",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33100,
+	})
+
+	if engine.GetClientUpdate() != nil {
+		t.Fatal("expected no client update recommendation before receiving one from the Management Service")
+	}
+
+	err = engine.handleSync(&mgmtProto.SyncResponse{
+		ClientUpdate: &mgmtProto.ClientUpdate{
+			MinVersion:         "0.6.0",
+			RecommendedVersion: "0.7.0",
+			DownloadUrl:        "https://example.com/netbird",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ClientUpdate{
+		MinVersion:         "0.6.0",
+		RecommendedVersion: "0.7.0",
+		DownloadURL:        "https://example.com/netbird",
+	}
+
+	got := engine.GetClientUpdate()
+	if got == nil || *got != *expected {
+		t.Fatalf("expected engine to record client update %v, got %v", expected, got)
+	}
+
+	got = CtxGetState(ctx).ClientUpdate()
+	if got == nil || *got != *expected {
+		t.Fatalf("expected client update %v to be available in the context state, got %v", expected, got)
+	}
+}
+
+func TestEngine_UpdateSystemInfo(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sentInfo []*system.Info
+	mgmtClient := &mgmt.MockClient{
+		GetServerPublicKeyFunc: func() (*wgtypes.Key, error) {
+			return &key, nil
+		},
+		LoginFunc: func(serverKey wgtypes.Key, info *system.Info) (*mgmtProto.LoginResponse, error) {
+			sentInfo = append(sentInfo, info)
+			return &mgmtProto.LoginResponse{}, nil
+		},
+	}
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun100",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33100,
+	})
+	engine.sysInfo = &system.Info{Hostname: "old-hostname", Labels: map[string]string{"env": "dev"}}
+
+	err = engine.updateSystemInfo(&system.Info{Hostname: "old-hostname", Labels: map[string]string{"env": "dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentInfo) != 0 {
+		t.Fatalf("expected no system info update to be sent when nothing has changed, got %d", len(sentInfo))
+	}
+
+	err = engine.updateSystemInfo(&system.Info{Hostname: "new-hostname", Labels: map[string]string{"env": "dev"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentInfo) != 1 || sentInfo[0].Hostname != "new-hostname" {
+		t.Fatalf("expected system info with the new hostname to be sent, got %v", sentInfo)
+	}
+
+	err = engine.updateSystemInfo(&system.Info{Hostname: "new-hostname", Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sentInfo) != 2 || sentInfo[1].Labels["env"] != "prod" {
+		t.Fatalf("expected system info with the new labels to be sent, got %v", sentInfo)
+	}
+}
+
+func TestEngine_RestoreFromCachedEndpoint(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey := remoteKey.PublicKey().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Signal is down: the client isn't ready and every message fails
+	signalClient := &signal.MockClient{
+		ReadyFunc: func() bool {
+			return false
+		},
+		SendFunc: func(msg *proto.Message) error {
+			return fmt.Errorf("signal is unavailable")
+		},
+	}
+
+	ifaceName := "utun104"
+	engine := NewEngine(ctx, cancel, signalClient, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33104,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+	err = engine.wgInterface.Configure(key.String(), 33104)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := engine.createPeerConn(peerKey, "100.64.0.10/32", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.peerConns[peerKey] = conn
+
+	wgPeerEndpoint := func() *net.UDPAddr {
+		client, err := wgctrl.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		device, err := client.Device(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range device.Peers {
+			if p.PublicKey.String() == peerKey {
+				return p.Endpoint
+			}
+		}
+		return nil
+	}
+
+	// nothing has been cached yet
+	if engine.restoreFromCachedEndpoint(peerKey, conn.GetAllowedIPs()) {
+		t.Fatal("expected no connection to be restored without a cached endpoint")
+	}
+
+	// a previous direct connection has been established
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: iface.DefaultWgPort}
+	engine.cacheEndpoint(peerKey, endpoint, false)
+
+	// the connection worker restores the connection without negotiation
+	go engine.connWorker(conn, peerKey)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for wgPeerEndpoint() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the connection to be restored using the cached endpoint")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := wgPeerEndpoint(); got.String() != endpoint.String() {
+		t.Errorf("expected Wireguard peer endpoint %s, got %s", endpoint, got)
+	}
+
+	// the endpoint is in use, it isn't restored again
+	if engine.restoreFromCachedEndpoint(peerKey, conn.GetAllowedIPs()) {
+		t.Error("expected the already restored endpoint not to be restored again")
+	}
+
+	// removing the peer removes the restored Wireguard peer and stops the worker
+	engine.syncMsgMux.Lock()
+	err = engine.removePeer(peerKey)
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wgPeerEndpoint() != nil {
+		t.Error("expected the restored Wireguard peer to be removed")
+	}
+
+	// expired endpoints are discarded
+	otherKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPeerKey := otherKey.PublicKey().String()
+	engine.syncMsgMux.Lock()
+	engine.peerEndpoints[otherPeerKey] = &cachedEndpoint{addr: endpoint, expiresAt: time.Now().Add(-time.Minute)}
+	engine.syncMsgMux.Unlock()
+	if engine.restoreFromCachedEndpoint(otherPeerKey, "100.64.0.11/32") {
+		t.Error("expected an expired endpoint not to be restored")
+	}
+	if _, ok := engine.peerEndpoints[otherPeerKey]; ok {
+		t.Error("expected an expired endpoint to be removed from the cache")
+	}
+
+	// a previous connection through the TURN relay of the peer has been established
+	conn, err = engine.createPeerConn(peerKey, "100.64.0.10/32", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.peerConns[peerKey] = conn
+	relay := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40001}
+	engine.cacheEndpoint(peerKey, relay, true)
+
+	// the relay address expires with the TURN allocation, before a direct endpoint would
+	cached := engine.peerEndpoints[peerKey]
+	if !cached.relayed || cached.expiresAt.After(time.Now().Add(cachedRelayEndpointTTL)) {
+		t.Errorf("expected the relay address to expire within %s, expires at %s", cachedRelayEndpointTTL, cached.expiresAt)
+	}
+	if !engine.restoreFromCachedEndpoint(peerKey, conn.GetAllowedIPs()) {
+		t.Fatal("expected the connection to be restored using the cached relay address")
+	}
+	if got := wgPeerEndpoint(); got == nil || got.String() != relay.String() {
+		t.Errorf("expected Wireguard peer endpoint %s, got %s", relay, got)
+	}
+
+	engine.syncMsgMux.Lock()
+	err = engine.removePeer(peerKey)
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngine_StaticEndpoint(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey := remoteKey.PublicKey().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var sentMux sync.Mutex
+	sent := 0
+	signalClient := &signal.MockClient{
+		ReadyFunc: func() bool {
+			return true
+		},
+		SendFunc: func(msg *proto.Message) error {
+			sentMux.Lock()
+			defer sentMux.Unlock()
+			sent++
+			return nil
+		},
+	}
+	sentMessages := func() int {
+		sentMux.Lock()
+		defer sentMux.Unlock()
+		return sent
+	}
+
+	ifaceName := "utun108"
+	engine := NewEngine(ctx, cancel, signalClient, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33108,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+	err = engine.wgInterface.Configure(key.String(), 33108)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wgPeerEndpoint := func() *net.UDPAddr {
+		client, err := wgctrl.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		device, err := client.Device(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range device.Peers {
+			if p.PublicKey.String() == peerKey {
+				return p.Endpoint
+			}
+		}
+		return nil
+	}
+
+	staticEndpointHandshakeTimeout = 2 * time.Second
+	staticEndpointCheckInterval = 100 * time.Millisecond
+	defer func() {
+		staticEndpointHandshakeTimeout = 20 * time.Second
+		staticEndpointCheckInterval = 5 * time.Second
+	}()
+
+	endpoint := "192.0.2.10:51820"
+	engine.syncMsgMux.Lock()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial: 1,
+		RemotePeers: []*mgmtProto.RemotePeerConfig{
+			{WgPubKey: peerKey, AllowedIps: []string{"100.64.0.10/32"}, StaticEndpoint: endpoint},
+		},
+	})
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wireguard is configured with the static endpoint directly
+	deadline := time.Now().Add(5 * time.Second)
+	for wgPeerEndpoint() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the Wireguard peer to be configured with the static endpoint")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := wgPeerEndpoint(); got.String() != endpoint {
+		t.Errorf("expected Wireguard peer endpoint %s, got %s", endpoint, got)
+	}
+	engine.syncMsgMux.Lock()
+	allowedIPs := engine.routedAllowedIPs()
+	engine.syncMsgMux.Unlock()
+	if len(allowedIPs[peerKey]) != 1 {
+		t.Errorf("expected the traffic to the peer configured with the static endpoint to be routed, got %v", allowedIPs)
+	}
+
+	// the signal exchange is skipped while waiting for the handshake
+	time.Sleep(staticEndpointHandshakeTimeout / 2)
+	if got := sentMessages(); got != 0 {
+		t.Fatalf("expected no signal messages to be sent to the peer with a static endpoint, got %d", got)
+	}
+
+	// nothing answers on the static endpoint, the connection falls back to the negotiation
+	deadline = time.Now().Add(10 * time.Second)
+	for sentMessages() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the connection to fall back to the negotiation when there is no handshake")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if wgPeerEndpoint() != nil {
+		t.Error("expected the Wireguard peer configured with the static endpoint to be removed on fallback")
+	}
+
+	engine.syncMsgMux.Lock()
+	err = engine.removeAllPeers()
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEngine_RoutingPeerAllowedIPs(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey := remoteKey.PublicKey().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ifaceName := "utun121"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33121,
+	})
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = engine.wgInterface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.wgInterface.Close() //nolint
+	err = engine.wgInterface.Configure(key.String(), 33121)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wgPeerAllowedIPs := func() []string {
+		client, err := wgctrl.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		device, err := client.Device(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var allowedIPs []string
+		for _, p := range device.Peers {
+			if p.PublicKey.String() == peerKey {
+				for _, allowedIP := range p.AllowedIPs {
+					allowedIPs = append(allowedIPs, allowedIP.String())
+				}
+			}
+		}
+		sort.Strings(allowedIPs)
+		return allowedIPs
+	}
+
+	// the Management Service appends the prefixes of the routes to the allowed IPs of the routing peer
+	engine.syncMsgMux.Lock()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial: 1,
+		RemotePeers: []*mgmtProto.RemotePeerConfig{
+			{WgPubKey: peerKey, AllowedIps: []string{"100.64.0.10/32", "10.10.0.0/16", "192.168.20.0/24"},
+				StaticEndpoint: "192.0.2.10:51820"},
+		},
+	})
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		engine.syncMsgMux.Lock()
+		defer engine.syncMsgMux.Unlock()
+		_ = engine.removeAllPeers()
+	}()
+
+	expected := []string{"10.10.0.0/16", "100.64.0.10/32", "192.168.20.0/24"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := wgPeerAllowedIPs()
+		if reflect.DeepEqual(got, expected) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the Wireguard peer to be configured with the allowed IPs %v, got %v", expected, got)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestEngine_LoginExpired(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	mgmtClient := &mgmt.MockClient{
+		SyncFunc: func(msgHandler func(msg *mgmtProto.SyncResponse) error) error {
+			return status.Errorf(codes.PermissionDenied, "login of peer %s has expired, please log in again", key.PublicKey().String())
+		},
+	}
+
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun109",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33109,
+	})
+
+	engine.receiveManagementEvents()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the engine to stop when the login has expired")
+	}
+
+	state, err := CtxGetState(ctx).Status()
+	if err != nil {
+		t.Fatalf("expected no connection reset when the login has expired, got %v", err)
+	}
+	if state != StatusNeedsLogin {
+		t.Errorf("expected status %s when the login has expired, got %s", StatusNeedsLogin, state)
+	}
+}
+
+func TestEngine_MultiplePeers(t *testing.T) {
+	// log.SetLevel(log.DebugLevel)
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	services := startTestServices(t, testutil.Options{})
+
+	mu := sync.Mutex{}
+	engines := []*Engine{}
+	numPeers := 10
+	wg := sync.WaitGroup{}
+	wg.Add(numPeers)
+	// create and start peers
+	for i := 0; i < numPeers; i++ {
+		j := i
+		go func() {
+			engine, err := createEngine(ctx, cancel, services, "")
+			if err != nil {
+				wg.Done()
+				t.Errorf("unable to create the engine for peer %d with error %v", j, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			err = engine.Start()
+			if err != nil {
+				t.Errorf("unable to start engine for peer %d with error %v", j, err)
+				wg.Done()
+				return
+			}
+			engines = append(engines, engine)
+			wg.Done()
+		}()
+	}
+
+	// wait until all have been created and started
+	wg.Wait()
+	if len(engines) != numPeers {
+		t.Fatal("not all peers was started")
+	}
+	// check whether all the peer have expected peers connected
+
+	expectedConnected := numPeers * (numPeers - 1)
+
+	// adjust according to timeouts
+	timeout := 50 * time.Second
+	timeoutChan := time.After(timeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-timeoutChan:
+			t.Fatalf("waiting for expected connections timeout after %s", timeout.String())
+			break loop
+		case <-ticker.C:
+			totalConnected := 0
+			for _, engine := range engines {
+				totalConnected = totalConnected + len(engine.GetConnectedPeers())
+			}
+			if totalConnected == expectedConnected {
+				log.Infof("total connected=%d", totalConnected)
+				break loop
+			}
+			log.Infof("total connected=%d", totalConnected)
+		}
+	}
+	// cleanup test
+	for n, peerEngine := range engines {
+		t.Logf("stopping peer with interface %s from multipeer test, loopIndex %d", peerEngine.wgInterface.Name, n)
+		errStop := peerEngine.mgmClient.Close()
+		if errStop != nil {
+			log.Infoln("got error trying to close management clients from engine: ", errStop)
+		}
+		errStop = peerEngine.Stop()
+		if errStop != nil {
+			log.Infoln("got error trying to close testing peers engine: ", errStop)
+		}
+	}
+}
+
+func TestEngine_UpdateAddress(t *testing.T) {
+	previousAddrTimeout = 2 * time.Second
+	defer func() {
+		previousAddrTimeout = 30 * time.Second
+	}()
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	services := startTestServices(t, testutil.Options{})
+	accountManager := services.AccountManager
+
+	// the peer registers with a fixed IP
+	engine, err := createEngine(ctx, cancel, services, "100.64.0.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if engine.config.WgAddr != "100.64.0.50/16" {
+		t.Fatalf("expecting the peer to be registered with the requested IP, got %s", engine.config.WgAddr)
+	}
+
+	err = engine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = engine.mgmClient.Close()
+		err = engine.Stop()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if !waitInterfaceAddr(engine.config.WgIfaceName, "100.64.0.50", 5*time.Second) {
+		t.Fatal("expecting the interface to have the requested IP")
+	}
+
+	peerKey := engine.config.WgPrivateKey.PublicKey().String()
+	_, err = accountManager.UpdatePeerIP("bf1c8084-ba50-4ce7-9439-34653001fc3b", peerKey, net.ParseIP("100.64.0.60"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !waitInterfaceAddr(engine.config.WgIfaceName, "100.64.0.60", 10*time.Second) {
+		t.Fatal("expecting the interface to be readdressed with the IP assigned by the Management service")
+	}
+	// the remote peers still sending to the previous IP until they receive the new one aren't cut off
+	if !waitInterfaceAddr(engine.config.WgIfaceName, "100.64.0.50", 0) {
+		t.Error("expecting the interface to keep the previous IP for a while")
+	}
+	if !waitInterfaceAddrRemoved(engine.config.WgIfaceName, "100.64.0.50", previousAddrTimeout+5*time.Second) {
+		t.Error("expecting the previous IP to be removed from the interface")
+	}
+	if !waitInterfaceAddr(engine.config.WgIfaceName, "100.64.0.60", 0) {
+		t.Error("expecting the interface to keep the new IP")
+	}
+}
+
+// waitInterfaceAddrRemoved waits until the interface doesn't have the IP and reports whether it has been removed
+func waitInterfaceAddrRemoved(ifaceName string, ip string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for waitInterfaceAddr(ifaceName, ip, 0) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// waitInterfaceAddr waits until the interface has the IP and reports whether it has it
+func waitInterfaceAddr(ifaceName string, ip string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if netIface, err := net.InterfaceByName(ifaceName); err == nil {
+			addrs, _ := netIface.Addrs()
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.String() == ip {
+					return true
+				}
+			}
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// testSetupKey is the reusable setup key of the account of the store fixture ../testdata/store.json
+const testSetupKey = "A2C8E62B-38F5-4553-B31E-DD66C696CEBB"
+
+// startTestServices starts the Management and the Signal services of the test with the store fixture, the peers
+// register with its setup key
+func startTestServices(t *testing.T, opts testutil.Options) *harness.Harness {
+	t.Helper()
+	opts.StoreFile = "../testdata/store.json"
+	opts.SetupKey = testSetupKey
+	services := harness.NewHarness(t, opts)
+	services.Start()
+	return services
+}
+
+// createEngine creates the Engine of a new peer registered with the services of the harness,
+// the peer gets the requested IP if it isn't empty
+func createEngine(ctx context.Context, cancel context.CancelFunc, services *harness.Harness, requestedIP string) (*Engine, error) {
+	peer, err := services.CreatePeer(ctx, requestedIP)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &EngineConfig{
+		WgIfaceName:  peer.IfaceName,
+		WgAddr:       peer.Address,
+		WgPrivateKey: peer.Key,
+		WgPort:       peer.WgPort,
+	}
+
+	return NewEngine(ctx, cancel, peer.SignalClient, peer.ManagementClient, conf), nil
+}
+
+func TestEngine_NotifyNetworkChange(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mgmtReconnected, signalReconnected := false, false
+	engine := NewEngine(ctx, cancel,
+		&signal.MockClient{ReconnectFunc: func() { signalReconnected = true }},
+		&mgmt.MockClient{ReconnectFunc: func() { mgmtReconnected = true }},
+		&EngineConfig{
+			WgIfaceName:  "utun106",
+			WgAddr:       "100.64.0.1/24",
+			WgPrivateKey: key,
+			WgPort:       33106,
+		})
+
+	engine.NotifyNetworkChange()
+	if !mgmtReconnected || !signalReconnected {
+		t.Errorf("expecting the Management and the Signal streams to reconnect on a network change, got %t and %t",
+			mgmtReconnected, signalReconnected)
+	}
+
+	mgmtReconnected, signalReconnected = false, false
+	cancel()
+	engine.NotifyNetworkChange()
+	if mgmtReconnected || signalReconnected {
+		t.Error("expecting a stopped Engine not to reconnect on a network change")
+	}
+}
+
+func TestEngine_MaxConcurrentDials(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the remote peers never answer, so the connection attempts keep negotiating until they time out
+	offersMux := sync.Mutex{}
+	offers := map[string]struct{}{}
+	signalClient := &signal.MockClient{
+		ReadyFunc: func() bool {
+			return true
+		},
+		SendFunc: func(msg *proto.Message) error {
+			if msg.GetBody().GetType() == proto.Body_OFFER {
+				offersMux.Lock()
+				offers[msg.GetRemoteKey()] = struct{}{}
+				offersMux.Unlock()
+			}
+			return nil
+		},
+	}
+	offered := func() []string {
+		offersMux.Lock()
+		defer offersMux.Unlock()
+		var keys []string
+		for k := range offers {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+
+	engine := NewEngine(ctx, cancel, signalClient, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:        "utun115",
+		WgAddr:             "100.64.0.1/16",
+		WgPrivateKey:       key,
+		WgPort:             33115,
+		MaxConcurrentDials: 3,
+	})
+	defer engine.Stop() //nolint
+
+	var remotePeers []*mgmtProto.RemotePeerConfig
+	for i := 0; i < 100; i++ {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		remotePeers = append(remotePeers, &mgmtProto.RemotePeerConfig{
+			WgPubKey:   peerKey.PublicKey().String(),
+			AllowedIps: []string{fmt.Sprintf("100.64.%d.%d/32", i/250, i%250+2)},
+		})
+	}
+
+	engine.syncMsgMux.Lock()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 1, RemotePeers: remotePeers})
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all the connection attempts have started after their randomized delay
+	time.Sleep(3 * time.Second)
+	dialing := offered()
+	if len(dialing) != 3 {
+		t.Fatalf("expecting 3 connection attempts negotiating at the same time, got %d", len(dialing))
+	}
+
+	// the slot of a removed peer is taken by the next peer waiting
+	var remaining []*mgmtProto.RemotePeerConfig
+	for _, p := range remotePeers {
+		if p.GetWgPubKey() != dialing[0] {
+			remaining = append(remaining, p)
+		}
+	}
+	engine.syncMsgMux.Lock()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 2, RemotePeers: remaining})
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(offered()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if got := len(offered()); got != 4 {
+		t.Fatalf("expecting a waiting connection attempt to start once a slot is free, %d attempts have started", got)
+	}
+	if got := engine.dialLimiter.Dialing(); got != 3 {
+		t.Errorf("expecting 3 connection attempts negotiating, got %d", got)
+	}
+}
+
+// TestEngine_ConcurrentAccess applies NetworkMaps while the Signal messages of the remote peers are received and the
+// status of the peers is read. Meant to be run with the race detector
+func TestEngine_ConcurrentAccess(t *testing.T) {
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := make(chan func(msg *proto.Message) error, 1)
+	signalClient := &signal.MockClient{
+		ReceiveFunc: func(msgHandler func(msg *proto.Message) error) error {
+			handlers <- msgHandler
+			<-ctx.Done()
+			return nil
+		},
+		ReadyFunc: func() bool {
+			return true
+		},
+		SendFunc: func(msg *proto.Message) error {
+			return nil
+		},
+	}
+
+	engine := NewEngine(ctx, cancel, signalClient, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun117",
+		WgAddr:       "100.64.0.1/16",
+		WgPrivateKey: key,
+		WgPort:       33117,
+	})
+	defer engine.Stop() //nolint
+
+	engine.receiveSignalEvents()
+	var handleSignal func(msg *proto.Message) error
+	select {
+	case handleSignal = <-handlers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting the engine to receive the Signal messages")
+	}
+
+	var peerKeys []wgtypes.Key
+	var remotePeers []*mgmtProto.RemotePeerConfig
+	for i := 0; i < 20; i++ {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peerKeys = append(peerKeys, peerKey)
+		remotePeers = append(remotePeers, &mgmtProto.RemotePeerConfig{
+			WgPubKey:   peerKey.PublicKey().String(),
+			AllowedIps: []string{fmt.Sprintf("100.64.0.%d/32", i+2)},
+		})
+	}
+
+	const iterations = 50
+	wg := sync.WaitGroup{}
+
+	// the remote peers are added and removed by the NetworkMaps
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			peers := remotePeers[i%2*5 : len(remotePeers)-i%3*5]
+			engine.syncMsgMux.Lock()
+			err := engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: uint64(i + 1), RemotePeers: peers})
+			engine.syncMsgMux.Unlock()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	// the remote peers negotiate the connections whether or not they are in the NetworkMap at the moment
+	for _, peerKey := range peerKeys {
+		wg.Add(1)
+		go func(peerKey wgtypes.Key) {
+			defer wg.Done()
+			credential := &signal.Credential{UFrag: "ufrag", Pwd: "pwd"}
+			for i := 0; i < iterations; i++ {
+				for _, bodyType := range []proto.Body_Type{proto.Body_OFFER, proto.Body_ANSWER} {
+					msg, err := signal.MarshalCredential(peerKey, key.PublicKey(), credential, bodyType)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					// the messages of the peers missing in the NetworkMap are rejected
+					_ = handleSignal(msg)
+				}
+				_ = handleSignal(&proto.Message{
+					Key:       peerKey.PublicKey().String(),
+					RemoteKey: key.PublicKey().String(),
+					Body: &proto.Body{
+						Type:    proto.Body_CANDIDATE,
+						Payload: fmt.Sprintf("candidate:1 1 udp 2130706431 10.0.0.%d 51820 typ host", i%250+1),
+					},
+				})
+			}
+		}(peerKey)
+	}
+
+	// the status of the peers is read by the daemon
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < iterations; i++ {
+			for _, peerKey := range engine.GetConnectedPeers() {
+				engine.GetPeerConnectionStatus(peerKey)
+			}
+			engine.GetPeers()
+			engine.GetStatus()
+		}
+	}()
+
+	wg.Wait()
+
+	// the Signal messages and the status reads don't wait for a NetworkMap being applied
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg, err := signal.MarshalCredential(peerKeys[10], key.PublicKey(), &signal.Credential{UFrag: "ufrag", Pwd: "pwd"},
+			proto.Body_OFFER)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = handleSignal(msg)
+		engine.GetConnectedPeers()
+		engine.GetStatus()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting the Signal messages and the status reads not to wait for the NetworkMap lock")
+	}
 }
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+)
+
+// TestMain runs the Engine tests on mock adapters, the Windows CI runners have no Wintun driver
+func TestMain(m *testing.M) {
+	iface.UseMockAdapters(true)
+	os.Exit(m.Run())
+}
+
+func TestEngine_MockAdapter(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+	defer cancel()
+
+	mgmtClient := &mgmt.MockClient{
+		GetServerPublicKeyFunc: func() (*wgtypes.Key, error) {
+			return &key, nil
+		},
+	}
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+		WgIfaceName:  "utun140",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33140,
+	})
+	err = engine.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Stop() //nolint
+
+	port, err := engine.wgInterface.GetListenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *port != 33140 {
+		t.Errorf("expecting the configured port 33140 to be applied to the mock adapter, got %d", *port)
+	}
+
+	// the network map with a new address and a remote peer is applied to the mock adapter
+	peerKey := "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+	engine.syncMsgMux.Lock()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{
+		Serial:     1,
+		PeerConfig: &mgmtProto.PeerConfig{Address: "100.64.0.2/24"},
+		RemotePeers: []*mgmtProto.RemotePeerConfig{{
+			WgPubKey:   peerKey,
+			AllowedIps: []string{"100.64.0.10/32"},
+		}},
+	})
+	_, connected := engine.peerConns[peerKey]
+	engine.syncMsgMux.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !connected {
+		t.Errorf("expecting a connection to peer %s", peerKey)
+	}
+	if address := engine.wgInterface.Address.IP.String(); address != "100.64.0.2" {
+		t.Errorf("expecting the address of the mock adapter to be updated to 100.64.0.2, got %s", address)
+	}
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"errors"
+	"syscall"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ClientError is a kind of failure of the client that callers can act upon, e.g. ask the user for a new setup key
+// or retry later. The errors returned by the client match their kind with errors.Is. The kind carries the gRPC code
+// the error is reported with by the daemon, so the kinds can be told apart on the other side of the daemon API as well
+type ClientError struct {
+	code codes.Code
+	msg  string
+}
+
+var (
+	// ErrInvalidConfig is returned when the client config is invalid, retrying won't help until it is fixed
+	ErrInvalidConfig = &ClientError{codes.InvalidArgument, "invalid config"}
+	// ErrPrivateKeyLocked is returned when the Wireguard private key is encrypted in the config and can't be decrypted,
+	// e.g. the passphrase hasn't been provided
+	ErrPrivateKeyLocked = &ClientError{codes.FailedPrecondition, "Wireguard private key is encrypted and can't be unlocked"}
+	// ErrInvalidSetupKey is returned when the setup key is malformed, unknown, revoked, expired or used up
+	ErrInvalidSetupKey = &ClientError{codes.InvalidArgument, "invalid setup key, provide a valid one"}
+	// ErrLoginRequired is returned when the peer isn't registered or its login has expired and no setup key or SSO
+	// token has been provided
+	ErrLoginRequired = &ClientError{codes.PermissionDenied, "login required, run netbird login"}
+	// ErrPeerAlreadyRegistered is returned when a peer with the same Wireguard key is already registered
+	ErrPeerAlreadyRegistered = &ClientError{codes.AlreadyExists, "peer is already registered"}
+	// ErrPeersLimitReached is returned when the account can't register more peers
+	ErrPeersLimitReached = &ClientError{codes.ResourceExhausted,
+		"the account has reached its maximum number of peers, remove unused peers or ask the account administrator to raise the limit"}
+	// ErrManagementUnreachable is returned when no Management Service can be connected to, the client retries
+	ErrManagementUnreachable = &ClientError{codes.FailedPrecondition, "failed connecting to Management Service"}
+	// ErrSignalUnreachable is returned when the Signal Service can't be connected to, the client retries
+	ErrSignalUnreachable = &ClientError{codes.FailedPrecondition, "failed connecting to Signal Service"}
+	// ErrInterfaceBusy is returned when the Wireguard interface or a port the client listens on is used by another
+	// process, e.g. another client instance
+	ErrInterfaceBusy = &ClientError{codes.Unavailable, "Wireguard interface or port is in use"}
+	// ErrInterface is returned when the Wireguard interface can't be created or configured for another reason
+	ErrInterface = &ClientError{codes.Internal, "failed setting up Wireguard interface"}
+	// ErrInvalidNetworkMap is returned when a NetworkMap sent by the Management Service can't be applied
+	ErrInvalidNetworkMap = &ClientError{codes.InvalidArgument, "invalid NetworkMap"}
+)
+
+func (e *ClientError) Error() string {
+	return e.msg
+}
+
+// GRPCStatus returns the status the error is reported with by the daemon
+func (e *ClientError) GRPCStatus() *status.Status {
+	return status.New(e.code, e.msg)
+}
+
+// wrappedClientError is a failure of a known kind with the error that has caused it
+type wrappedClientError struct {
+	kind  *ClientError
+	cause error
+}
+
+// wrapError returns the error as a failure of the kind. A nil error is returned as nil
+func wrapError(kind *ClientError, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &wrappedClientError{kind: kind, cause: err}
+}
+
+func (e *wrappedClientError) Error() string {
+	return e.kind.msg + ": " + messageOf(e.cause)
+}
+
+func (e *wrappedClientError) Unwrap() error {
+	return e.cause
+}
+
+// Is matches the kind of the error, the cause is matched by errors.Is through Unwrap
+func (e *wrappedClientError) Is(target error) bool {
+	return target == e.kind
+}
+
+// GRPCStatus returns the status the error is reported with by the daemon: the code of the kind and the whole message
+func (e *wrappedClientError) GRPCStatus() *status.Status {
+	return status.New(e.kind.code, e.Error())
+}
+
+// messageOf returns the message of an error without the code prefix of the gRPC status errors
+func messageOf(err error) string {
+	if s, ok := status.FromError(err); ok {
+		return s.Message()
+	}
+	return err.Error()
+}
+
+// wrapInterfaceError returns a failure to set up the Wireguard interface as ErrInterfaceBusy when the interface or
+// the port is in use, as ErrInterface otherwise
+func wrapInterfaceError(err error) error {
+	if errors.Is(err, syscall.EADDRINUSE) || errors.Is(err, syscall.EBUSY) {
+		return wrapError(ErrInterfaceBusy, err)
+	}
+	return wrapError(ErrInterface, err)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWrapError(t *testing.T) {
+	cause := status.Errorf(codes.NotFound, "setup key not found")
+	err := fmt.Errorf("failed logging in: %w", wrapError(ErrInvalidSetupKey, cause))
+
+	assert.ErrorIs(t, err, ErrInvalidSetupKey)
+	assert.False(t, errors.Is(err, ErrLoginRequired))
+	// the cause stays reachable
+	assert.ErrorIs(t, err, cause)
+	assert.Equal(t, "failed logging in: invalid setup key, provide a valid one: setup key not found", err.Error())
+
+	// the daemon reports the failure with the code of its kind
+	s, ok := status.FromError(wrapError(ErrInvalidSetupKey, cause))
+	assert.True(t, ok)
+	assert.Equal(t, codes.InvalidArgument, s.Code())
+	assert.Equal(t, "invalid setup key, provide a valid one: setup key not found", s.Message())
+	assert.Equal(t, codes.PermissionDenied, status.Code(ErrLoginRequired))
+
+	assert.NoError(t, wrapError(ErrInterface, nil))
+}
+
+func TestWrapInterfaceError(t *testing.T) {
+	busy := &os.SyscallError{Syscall: "bind", Err: syscall.EADDRINUSE}
+	err := wrapInterfaceError(fmt.Errorf("failed listening on UDP port 51820: %w", busy))
+	assert.ErrorIs(t, err, ErrInterfaceBusy)
+	assert.False(t, errors.Is(err, ErrInterface))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	err = wrapInterfaceError(errors.New("operation not supported"))
+	assert.ErrorIs(t, err, ErrInterface)
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// exitNodeAllowedIP is added to the allowed IPs of the exit node, so Wireguard sends it the traffic to any destination
+	// and accepts the replies from any source
+	exitNodeAllowedIP = "0.0.0.0/0"
+	// exitNodeCheckInterval is an interval of reconciling the routes through the exit node with its connection
+	exitNodeCheckInterval = 5 * time.Second
+)
+
+// findExitNode returns the key of the remote peer of the update selected as the exit node by its key, name or IP,
+// an empty string if there is none
+func findExitNode(selector string, peersUpdate []*mgmProto.RemotePeerConfig) string {
+	if selector == "" {
+		return ""
+	}
+
+	for _, p := range peersUpdate {
+		if p.GetWgPubKey() == selector || strings.EqualFold(p.GetName(), selector) {
+			return p.GetWgPubKey()
+		}
+		ip, err := peerIPFromAllowedIPs(p.GetAllowedIps())
+		if err == nil && ip.String() == selector {
+			return p.GetWgPubKey()
+		}
+	}
+	return ""
+}
+
+// withExitNode returns the remote peers of the update with exitNodeAllowedIP added to the allowed IPs of the exit node
+// selected in the config and records its key. The update is returned unchanged if no exit node has been selected
+func (e *Engine) withExitNode(peersUpdate []*mgmProto.RemotePeerConfig) []*mgmProto.RemotePeerConfig {
+	exitNode := findExitNode(e.config.ExitNode, peersUpdate)
+	if exitNode != e.exitNodeKey {
+		if exitNode == "" {
+			log.Warnf("exit node %s isn't a peer of the network, routing traffic through the default route of the system",
+				e.config.ExitNode)
+		} else {
+			log.Infof("exit node %s is peer %s", e.config.ExitNode, exitNode)
+		}
+		e.exitNodeKey = exitNode
+	}
+	if exitNode == "" {
+		return peersUpdate
+	}
+
+	update := make([]*mgmProto.RemotePeerConfig, 0, len(peersUpdate))
+	for _, p := range peersUpdate {
+		if p.GetWgPubKey() == exitNode {
+			p = proto.Clone(p).(*mgmProto.RemotePeerConfig)
+			p.AllowedIps = append(p.AllowedIps, exitNodeAllowedIP)
+		}
+		update = append(update, p)
+	}
+	return update
+}
+
+// watchExitNode periodically routes all the traffic through the exit node while it is connected and removes the routes
+// once it is disconnected, so the traffic isn't blackholed
+func (e *Engine) watchExitNode() {
+	go func() {
+		ticker := time.NewTicker(exitNodeCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				e.updateExitNodeRoute()
+			}
+		}
+	}()
+}
+
+// updateExitNodeRoute routes all the traffic through the exit node if it is connected, the hosts the client and
+// the Wireguard interface connect to are routed through the gateway of the system. The routes are removed otherwise
+func (e *Engine) updateExitNodeRoute() {
+	e.syncMsgMux.Lock()
+	routed := e.exitNodeKey != "" && e.peerRouted(e.exitNodeKey)
+	var hosts []string
+	var ips []net.IP
+	if routed {
+		hosts, ips = e.exitNodeBypass()
+	}
+	e.syncMsgMux.Unlock()
+
+	// the hosts are resolved without holding the lock
+	for _, host := range hosts {
+		resolved, err := net.LookupIP(host)
+		if err != nil {
+			log.Debugf("failed resolving %s to route it around exit node: %v", host, err)
+			continue
+		}
+		ips = append(ips, resolved...)
+	}
+
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	// the Engine has been stopped meanwhile
+	if e.ctx.Err() != nil {
+		return
+	}
+	defer e.publishNetworkStatus()
+
+	if !routed {
+		if e.exitNodeRouted {
+			log.Warnf("exit node %s is disconnected, routing traffic through the default route of the system", e.exitNodeKey)
+			e.removeExitNodeRoute()
+		}
+		return
+	}
+
+	err := e.wgInterface.SetDefaultRoute(ips)
+	if err != nil {
+		log.Errorf("failed routing traffic through exit node %s: %v", e.exitNodeKey, err)
+		return
+	}
+	if !e.exitNodeRouted {
+		log.Infof("routing all traffic through exit node %s", e.exitNodeKey)
+		e.exitNodeRouted = true
+	}
+}
+
+// removeExitNodeRoute removes the routes through the exit node, the caller holds the lock
+func (e *Engine) removeExitNodeRoute() {
+	err := e.wgInterface.RemoveDefaultRoute()
+	if err != nil && !errors.Is(err, iface.ErrExitNodeNotSupported) {
+		log.Errorf("failed removing routes through exit node %s: %v", e.exitNodeKey, err)
+	}
+	e.exitNodeRouted = false
+}
+
+// exitNodeBypass returns the hosts and the IPs the client and the Wireguard interface connect to: the Management and
+// the Signal Service, the STUN and TURN servers and the endpoints of the remote peers. The caller holds the lock
+func (e *Engine) exitNodeBypass() ([]string, []net.IP) {
+	var hosts []string
+	var ips []net.IP
+	addHost := func(host string) {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			return
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+			return
+		}
+		hosts = append(hosts, host)
+	}
+
+	for _, host := range e.config.ServiceHosts {
+		addHost(host)
+	}
+	for _, url := range e.STUNs {
+		addHost(url.Host)
+	}
+	for _, url := range e.TURNs {
+		addHost(url.Host)
+	}
+	for peerKey, conn := range e.peerConns {
+		if address := conn.RemoteAddress(); address != "" {
+			addHost(address)
+		}
+		if endpoint, ok := e.peerEndpoints[peerKey]; ok && endpoint.restored {
+			ips = append(ips, endpoint.addr.IP)
+		}
+		if endpoint, ok := e.peerStaticEndpoints[peerKey]; ok && endpoint.active {
+			addHost(endpoint.addr)
+		}
+	}
+	return hosts, ips
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgm "github.com/netbirdio/netbird/management/client"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	// managementFailoverTimeout is how long the Sync stream may stay disconnected before the client fails over to
+	// another Management Service
+	managementFailoverTimeout = 30 * time.Second
+	// managementFailoverCheckInterval is an interval of the checks of the Sync stream and of the primary
+	// Management Service while connected to a secondary one
+	managementFailoverCheckInterval = 15 * time.Second
+)
+
+// managementURLs returns the Management Services the client connects to in the order of preference, the primary one first
+func (config *Config) managementURLs() []*url.URL {
+	urls := []*url.URL{config.ManagementURL}
+	for _, secondary := range config.SecondaryManagementURLs {
+		if secondary.String() != config.ManagementURL.String() {
+			urls = append(urls, secondary)
+		}
+	}
+	return urls
+}
+
+// connectToManagementServers connects and logs in to the first reachable Management Service trying them in order.
+// Returns the URL of the connected service along with the client. A denied login is returned at once: the services
+// share the account state, so the others deny it as well
+func connectToManagementServers(ctx context.Context, managementURLs []*url.URL, ourPrivateKey wgtypes.Key, clientCert *encryption.CertificateReloader, labels map[string]string) (*mgm.GrpcClient, *mgmProto.LoginResponse, *url.URL, error) {
+	var err error
+	for i, managementURL := range managementURLs {
+		client, loginResp, connErr := connectToManagement(ctx, managementURL.Host, ourPrivateKey, serviceTLSConfig(managementURL.Scheme == "https", clientCert), labels)
+		if connErr == nil {
+			if i > 0 {
+				log.Warnf("primary Management Service %s is unreachable, connected to secondary %s",
+					managementURLs[0], managementURL)
+			}
+			return client, loginResp, managementURL, nil
+		}
+		if errors.Is(connErr, ErrLoginRequired) {
+			return nil, nil, nil, connErr
+		}
+		log.Warnf("failed connecting to Management Service %s: %v", managementURL, connErr)
+		err = connErr
+	}
+	return nil, nil, nil, err
+}
+
+// watchManagementFailover replaces the Management Service client when the Sync stream has been disconnected for
+// managementFailoverTimeout, so the client fails over to another Management Service, and when the primary Management
+// Service accepts the login again while the client is connected to a secondary one. The Engine keeps running with
+// the peers connected meanwhile
+func (e *Engine) watchManagementFailover() {
+	go func() {
+		ticker := time.NewTicker(managementFailoverCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := e.failOverManagement()
+			if errors.Is(err, ErrLoginRequired) {
+				log.Warnf("Management Service denied the login, the peer has to log in again: %v", err)
+				CtxGetState(e.ctx).Set(StatusNeedsLogin)
+				e.cancel()
+				return
+			}
+			if err != nil {
+				log.Debugf("staying connected to Management Service %s: %v", e.managementURL(), err)
+			}
+		}
+	}()
+}
+
+// failOverManagement switches to the most preferred Management Service accepting the login if the current one is
+// unreachable, or to the primary one once it accepts the login while the client is connected to a secondary one
+func (e *Engine) failOverManagement() error {
+	client, current := e.managementClient(), e.managementURL()
+	primary := e.config.ManagementURLs[0]
+
+	since := client.StatusSince()
+	if !client.StreamConnected() && !since.IsZero() && time.Since(since) >= managementFailoverTimeout {
+		log.Infof("Management Service %s is unreachable, failing over", current)
+		var err error
+		for _, managementURL := range e.config.ManagementURLs {
+			if managementURL.String() == current.String() {
+				continue
+			}
+			err = e.switchManagement(managementURL)
+			if err == nil || errors.Is(err, ErrLoginRequired) {
+				return err
+			}
+			log.Warnf("failed failing over to Management Service %s: %v", managementURL, err)
+		}
+		return err
+	}
+
+	if current.String() == primary.String() {
+		return nil
+	}
+	return e.switchManagement(primary)
+}
+
+// switchManagement logs in to the Management Service and replaces the current client with its client once the login
+// has succeeded, the updates are received from the new one
+func (e *Engine) switchManagement(managementURL *url.URL) error {
+	client, err := e.connectManagement(e.ctx, managementURL)
+	if err != nil {
+		return err
+	}
+
+	e.mgmMux.Lock()
+	replaced, replacedURL := e.mgmClient, e.mgmURL
+	e.mgmClient, e.mgmURL = client, managementURL
+	e.mgmMux.Unlock()
+
+	log.Infof("switched from Management Service %s to %s", replacedURL, managementURL)
+	if err := replaced.Close(); err != nil {
+		log.Warnf("failed closing the client of Management Service %s: %v", replacedURL, err)
+	}
+	e.receiveManagementEvents()
+	return nil
+}
@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/netbirdio/netbird/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectToManagementServers(t *testing.T) {
+	services := startTestServices(t, testutil.Options{})
+	accountManager := services.AccountManager
+
+	// nothing listens on the port of the failed primary Management Service
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	primaryURL := &url.URL{Scheme: "http", Host: lis.Addr().String()}
+	require.NoError(t, lis.Close())
+	secondaryURL := &url.URL{Scheme: "http", Host: services.ManagementAddr}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("Login Denied", func(t *testing.T) {
+		// the secondary service denies the login of an unregistered peer, the primary one isn't tried
+		_, _, _, err := connectToManagementServers(ctx, []*url.URL{secondaryURL, primaryURL}, key, nil, nil)
+		assert.ErrorIs(t, err, ErrLoginRequired)
+		// the daemon reports the failure with the code of its kind
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	peer, err := accountManager.AddPeer(services.SetupKey, "",
+		&server.Peer{Key: key.PublicKey().String(), Name: "failover"})
+	require.NoError(t, err)
+
+	t.Run("Failover To Secondary", func(t *testing.T) {
+		client, loginResp, connectedURL, err := connectToManagementServers(ctx, []*url.URL{primaryURL, secondaryURL}, key, nil, nil)
+		require.NoError(t, err)
+		defer client.Close() //nolint
+
+		assert.Equal(t, secondaryURL, connectedURL)
+		assert.Equal(t, peer.IP.String()+"/16", loginResp.GetPeerConfig().GetAddress())
+	})
+
+	t.Run("All Unreachable", func(t *testing.T) {
+		_, _, _, err := connectToManagementServers(ctx, []*url.URL{primaryURL}, key, nil, nil)
+		assert.ErrorIs(t, err, ErrManagementUnreachable)
+	})
+}
+
+func TestConfig_ManagementURLs(t *testing.T) {
+	primary := &url.URL{Scheme: "https", Host: "primary:33073"}
+	secondary := &url.URL{Scheme: "https", Host: "secondary:33073"}
+
+	config := &Config{ManagementURL: primary, SecondaryManagementURLs: []*url.URL{primary, secondary}}
+
+	assert.Equal(t, []*url.URL{primary, secondary}, config.managementURLs())
+}
+
+func TestLogin_SecondaryManagement(t *testing.T) {
+	services := startTestServices(t, testutil.Options{})
+
+	// nothing listens on the port of the failed primary Management Service
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	primaryURL := &url.URL{Scheme: "http", Host: lis.Addr().String()}
+	require.NoError(t, lis.Close())
+	secondaryURL := &url.URL{Scheme: "http", Host: services.ManagementAddr}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	config := &Config{PrivateKey: key.String(), ManagementURL: primaryURL, SecondaryManagementURLs: []*url.URL{secondaryURL}}
+
+	err = Login(context.Background(), config, "", "", false)
+	assert.ErrorIs(t, err, ErrLoginRequired, "the secondary service should deny the login of an unregistered peer")
+
+	err = Login(context.Background(), config, services.SetupKey, "", false)
+	require.NoError(t, err)
+	_, err = services.AccountManager.GetPeer(key.PublicKey().String())
+	assert.NoError(t, err, "the peer should be registered on the secondary service")
+
+	config.SecondaryManagementURLs = nil
+	err = Login(context.Background(), config, "", "", false)
+	assert.ErrorIs(t, err, ErrManagementUnreachable)
+}
+
+func TestEngine_ManagementFailover(t *testing.T) {
+	managementFailoverTimeout = 200 * time.Millisecond
+	managementFailoverCheckInterval = 50 * time.Millisecond
+	defer func() {
+		managementFailoverTimeout = 30 * time.Second
+		managementFailoverCheckInterval = 15 * time.Second
+	}()
+
+	primary := &url.URL{Scheme: "https", Host: "primary:33073"}
+	secondary := &url.URL{Scheme: "https", Host: "secondary:33073"}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	newEngine := func(connected *url.URL, mgmtClient *mgmt.MockClient) (*Engine, context.Context) {
+		ctx, cancel := context.WithCancel(CtxInitState(context.Background()))
+		t.Cleanup(cancel)
+		engine := NewEngine(ctx, cancel, &signal.MockClient{}, mgmtClient, &EngineConfig{
+			WgIfaceName:    "utun110",
+			WgAddr:         "100.64.0.1/24",
+			WgPrivateKey:   key,
+			WgPort:         33110,
+			ManagementURLs: []*url.URL{primary, secondary},
+			ManagementURL:  connected,
+		})
+		return engine, ctx
+	}
+
+	// newConnectedClient returns a client with a connected Sync stream, synced is closed once its Sync has started
+	newConnectedClient := func() (*mgmt.MockClient, chan struct{}) {
+		synced := make(chan struct{})
+		var once sync.Once
+		return &mgmt.MockClient{
+			StreamConnectedFunc: func() bool { return true },
+			StatusSinceFunc:     func() time.Time { return time.Now() },
+			SyncFunc: func(func(msg *mgmtProto.SyncResponse) error) error {
+				once.Do(func() { close(synced) })
+				return nil
+			},
+		}, synced
+	}
+
+	waitSwitched := func(t *testing.T, engine *Engine, ctx context.Context, to *url.URL, synced chan struct{}) {
+		t.Helper()
+		select {
+		case <-synced:
+		case <-ctx.Done():
+			t.Fatal("expected the Engine to keep running while failing over")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the client to switch to Management Service %s", to)
+		}
+		assert.Equal(t, to, engine.managementURL())
+		assert.NoError(t, ctx.Err(), "expected the Engine to keep running while failing over")
+	}
+
+	t.Run("Primary Unreachable", func(t *testing.T) {
+		since := time.Now()
+		closed := make(chan struct{})
+		engine, ctx := newEngine(primary, &mgmt.MockClient{
+			StreamConnectedFunc: func() bool { return false },
+			StatusSinceFunc:     func() time.Time { return since },
+			CloseFunc: func() error {
+				close(closed)
+				return nil
+			},
+		})
+		secondaryClient, synced := newConnectedClient()
+		engine.connectManagement = func(_ context.Context, managementURL *url.URL) (mgmt.Client, error) {
+			if managementURL == secondary {
+				return secondaryClient, nil
+			}
+			return nil, fmt.Errorf("connection refused")
+		}
+		engine.watchManagementFailover()
+
+		waitSwitched(t, engine, ctx, secondary, synced)
+		assert.GreaterOrEqual(t, time.Since(since), managementFailoverTimeout)
+		assert.Equal(t, secondaryClient, engine.managementClient())
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Error("expected the client of the unreachable Management Service to be closed")
+		}
+	})
+
+	t.Run("Primary Recovered", func(t *testing.T) {
+		secondaryClient, _ := newConnectedClient()
+		engine, ctx := newEngine(secondary, secondaryClient)
+
+		primaryClient, synced := newConnectedClient()
+		mu := sync.Mutex{}
+		recovered := false
+		engine.connectManagement = func(_ context.Context, managementURL *url.URL) (mgmt.Client, error) {
+			assert.Equal(t, primary, managementURL)
+			mu.Lock()
+			defer mu.Unlock()
+			if !recovered {
+				// the primary service is reachable, but doesn't accept the login yet
+				return nil, status.Error(codes.Unavailable, "account store is loading")
+			}
+			return primaryClient, nil
+		}
+		engine.watchManagementFailover()
+
+		select {
+		case <-synced:
+			t.Fatal("expected the client to stay connected to the secondary Management Service until the login succeeds")
+		case <-time.After(300 * time.Millisecond):
+		}
+		assert.Equal(t, secondary, engine.managementURL())
+
+		mu.Lock()
+		recovered = true
+		mu.Unlock()
+
+		waitSwitched(t, engine, ctx, primary, synced)
+	})
+
+	t.Run("Login Denied", func(t *testing.T) {
+		secondaryClient, _ := newConnectedClient()
+		engine, ctx := newEngine(secondary, secondaryClient)
+		engine.connectManagement = func(_ context.Context, managementURL *url.URL) (mgmt.Client, error) {
+			return nil, wrapError(ErrLoginRequired, status.Error(codes.PermissionDenied, "login expired"))
+		}
+		engine.watchManagementFailover()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the client to stop when the login is denied")
+		}
+		s, _ := CtxGetState(ctx).Status()
+		assert.Equal(t, StatusNeedsLogin, s)
+	})
+
+	t.Run("Connected To Primary", func(t *testing.T) {
+		primaryClient, _ := newConnectedClient()
+		engine, ctx := newEngine(primary, primaryClient)
+		engine.connectManagement = func(_ context.Context, managementURL *url.URL) (mgmt.Client, error) {
+			t.Errorf("expected no connections to Management Service %s while connected to the primary one", managementURL)
+			return nil, fmt.Errorf("unexpected")
+		}
+		engine.watchManagementFailover()
+
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the client to stay connected to the primary Management Service")
+		case <-time.After(300 * time.Millisecond):
+		}
+	})
+}
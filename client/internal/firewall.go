@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"errors"
+	"math"
+	"net"
+	"reflect"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// updateFirewall applies the firewall rules of the NetworkMap to the Wireguard interface when they have changed.
+// The rules replace the ones applied before at once and are removed if the NetworkMap has none
+func (e *Engine) updateFirewall(networkMap *mgmProto.NetworkMap) {
+	rules := toFirewallRules(networkMap.GetFirewallRules(), networkMap.GetRemotePeers())
+	if reflect.DeepEqual(rules, e.firewallRules) {
+		return
+	}
+
+	var err error
+	if rules == nil {
+		err = e.wgInterface.RemoveFirewallRules()
+	} else {
+		err = e.wgInterface.SetFirewallRules(rules)
+	}
+	if errors.Is(err, iface.ErrFirewallNotSupported) {
+		log.Warnf("ignoring firewall rules of Netbird interface %s: %v", e.config.WgIfaceName, err)
+	} else if err != nil {
+		// retried with the next NetworkMap
+		log.Warnf("failed updating firewall rules of Netbird interface %s: %v", e.config.WgIfaceName, err)
+		return
+	}
+	e.firewallRules = rules
+}
+
+// toFirewallRules converts the firewall rules of the NetworkMap, resolving their source peers to the IPv4 allowed IPs
+// of the remote peers. The rules of source peers missing from the NetworkMap can't match and are skipped.
+// Returns nil if there are no rules, the traffic isn't filtered then
+func toFirewallRules(rules []*mgmProto.FirewallRule, peers []*mgmProto.RemotePeerConfig) []iface.FirewallRule {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	peerSources := map[string][]*net.IPNet{}
+	for _, p := range peers {
+		for _, allowedIP := range p.GetAllowedIps() {
+			_, source, err := net.ParseCIDR(allowedIP)
+			if err != nil || source.IP.To4() == nil {
+				continue
+			}
+			peerSources[p.GetWgPubKey()] = append(peerSources[p.GetWgPubKey()], source)
+		}
+	}
+
+	converted := make([]iface.FirewallRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.GetPort() > math.MaxUint16 {
+			log.Warnf("ignoring firewall rule with invalid port %d", rule.GetPort())
+			continue
+		}
+		fwRule := iface.FirewallRule{Port: uint16(rule.GetPort())}
+
+		// an unknown action drops the traffic rather than accepting it
+		fwRule.Action = iface.FirewallDrop
+		if rule.GetAction() == mgmProto.FirewallRule_ACCEPT {
+			fwRule.Action = iface.FirewallAccept
+		}
+
+		switch rule.GetProtocol() {
+		case mgmProto.FirewallRule_ALL:
+			fwRule.Protocol = iface.FirewallProtocolAll
+		case mgmProto.FirewallRule_TCP:
+			fwRule.Protocol = iface.FirewallProtocolTCP
+		case mgmProto.FirewallRule_UDP:
+			fwRule.Protocol = iface.FirewallProtocolUDP
+		case mgmProto.FirewallRule_ICMP:
+			fwRule.Protocol = iface.FirewallProtocolICMP
+		default:
+			log.Warnf("ignoring firewall rule with unknown protocol %d", rule.GetProtocol())
+			continue
+		}
+
+		if rule.GetSourcePeer() != "" {
+			sources, ok := peerSources[rule.GetSourcePeer()]
+			if !ok {
+				log.Debugf("skipping firewall rule of source peer %s missing from the network map", rule.GetSourcePeer())
+				continue
+			}
+			fwRule.Sources = sources
+		}
+
+		converted = append(converted, fwRule)
+	}
+
+	return converted
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestToFirewallRules(t *testing.T) {
+	peers := []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: "peer1", AllowedIps: []string{"100.64.0.10/32", "10.10.0.0/24"}},
+		{WgPubKey: "peer2", AllowedIps: []string{"100.64.0.11/32"}},
+	}
+
+	assert.Nil(t, toFirewallRules(nil, peers), "no rules disable the filter")
+
+	rules := toFirewallRules([]*mgmtProto.FirewallRule{
+		{Action: mgmtProto.FirewallRule_ACCEPT, Protocol: mgmtProto.FirewallRule_TCP, Port: 22, SourcePeer: "peer1"},
+		{Action: mgmtProto.FirewallRule_DROP, Protocol: mgmtProto.FirewallRule_ICMP},
+		{Action: mgmtProto.FirewallRule_ACCEPT, SourcePeer: "unknown"},
+		{Action: mgmtProto.FirewallRule_ACCEPT, Protocol: mgmtProto.FirewallRule_UDP, Port: 70000},
+		{Action: mgmtProto.FirewallRule_Action(7), Port: 53},
+	}, peers)
+
+	_, peer1IP, _ := net.ParseCIDR("100.64.0.10/32")
+	_, peer1Net, _ := net.ParseCIDR("10.10.0.0/24")
+	expected := []iface.FirewallRule{
+		{Action: iface.FirewallAccept, Protocol: iface.FirewallProtocolTCP, Port: 22, Sources: []*net.IPNet{peer1IP, peer1Net}},
+		{Action: iface.FirewallDrop, Protocol: iface.FirewallProtocolICMP},
+		{Action: iface.FirewallDrop, Protocol: iface.FirewallProtocolAll, Port: 53},
+	}
+	assert.Equal(t, expected, rules)
+
+	rules = toFirewallRules([]*mgmtProto.FirewallRule{{Action: mgmtProto.FirewallRule_ACCEPT, SourcePeer: "unknown"}}, peers)
+	assert.NotNil(t, rules, "skipping all the rules must still drop the traffic no rule matches")
+	assert.Empty(t, rules)
+}
+
+func TestEngine_UpdateFirewall(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ifaceName := "utun111"
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  ifaceName,
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33111,
+	})
+
+	engine.wgInterface, err = iface.NewWGIface(ifaceName, "100.64.0.1/24", iface.DefaultMTU)
+	require.NoError(t, err)
+	// the packet filter of the userspace mode is available on every platform
+	engine.wgInterface.Mode = iface.WGModeUserspace
+	require.NoError(t, engine.wgInterface.Create())
+	defer engine.wgInterface.Close() //nolint
+
+	engine.syncMsgMux.Lock()
+	defer engine.syncMsgMux.Unlock()
+
+	rules := []*mgmtProto.FirewallRule{{Action: mgmtProto.FirewallRule_ACCEPT, Protocol: mgmtProto.FirewallRule_TCP, Port: 22}}
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 1, RemotePeersIsEmpty: true, FirewallRules: rules})
+	require.NoError(t, err)
+	assert.Equal(t, []iface.FirewallRule{{Action: iface.FirewallAccept, Protocol: iface.FirewallProtocolTCP, Port: 22}},
+		engine.firewallRules)
+
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 2, RemotePeersIsEmpty: true})
+	require.NoError(t, err)
+	assert.Nil(t, engine.firewallRules, "expected the firewall rules to be removed")
+}
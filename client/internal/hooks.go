@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// hookTimeout limits a hook command, so a hanging command doesn't block the Engine
+	hookTimeout = time.Minute
+	// DefaultNetworkMapHookTimeout limits a NetworkMap hook if no other timeout is configured
+	DefaultNetworkMapHookTimeout = 5 * time.Second
+)
+
+// InterfaceHook is invoked with the name and the address of the Wireguard interface after the interface has been
+// created (PostUp) or torn down (PostDown), e.g. to set up routing, DNS or firewall rules outside Netbird's control
+type InterfaceHook func(ifaceName string, address string) error
+
+// NetworkMapHook is invoked before (PreNetworkMapApply) and after (PostNetworkMapApply) the Engine applies
+// a NetworkMap with its serial and the diff of the remote peers, e.g. to update an external inventory in lock-step.
+// The Engine waits for the hook until the context is done, the hook is abandoned then
+type NetworkMapHook func(ctx context.Context, serial uint64, diff NetworkMapDiff)
+
+// commandHook returns a hook running the command in the system shell like PostUp and PostDown of wg-quick do.
+// %i in the command is replaced with the interface name, the NB_INTERFACE and NB_ADDRESS environment variables
+// hold the interface name and address
+func commandHook(command string) InterfaceHook {
+	return func(ifaceName string, address string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+		defer cancel()
+
+		cmd := shellCommand(ctx, strings.ReplaceAll(command, "%i", ifaceName))
+		cmd.Env = append(os.Environ(), "NB_INTERFACE="+ifaceName, "NB_ADDRESS="+address)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("command %q failed: %v, output: %s", command, err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
+
+// shellCommand returns the command run by the system shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// runPostUp invokes the PostUp hook once the Wireguard interface has been created. The caller holds the lock
+func (e *Engine) runPostUp() {
+	e.interfaceUp = true
+	if e.config.PostUp == nil {
+		return
+	}
+	log.Debugf("running PostUp hook of interface %s", e.config.WgIfaceName)
+	err := e.config.PostUp(e.config.WgIfaceName, e.config.WgAddr)
+	if err != nil {
+		log.Errorf("PostUp hook of interface %s failed: %v", e.config.WgIfaceName, err)
+	}
+}
+
+// runPostDown invokes the PostDown hook once the Wireguard interface created by Start has been torn down, even if
+// tearing it down has failed. The caller holds the lock
+func (e *Engine) runPostDown() {
+	if !e.interfaceUp {
+		return
+	}
+	e.interfaceUp = false
+	if e.config.PostDown == nil {
+		return
+	}
+	log.Debugf("running PostDown hook of interface %s", e.config.WgIfaceName)
+	err := e.config.PostDown(e.config.WgIfaceName, e.config.WgAddr)
+	if err != nil {
+		log.Errorf("PostDown hook of interface %s failed: %v", e.config.WgIfaceName, err)
+	}
+}
+
+// runNetworkMapHook invokes the NetworkMap hook waiting for it at most NetworkMapHookTimeout, so a hanging hook
+// doesn't block the sync loop. The caller holds the lock
+func (e *Engine) runNetworkMapHook(name string, hook NetworkMapHook, serial uint64, diff NetworkMapDiff) {
+	if hook == nil {
+		return
+	}
+
+	timeout := e.config.NetworkMapHookTimeout
+	if timeout <= 0 {
+		timeout = DefaultNetworkMapHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(e.ctx, timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hook(ctx, serial, diff)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warnf("%s hook of NetworkMap %d hasn't returned in %s, continuing without it", name, serial, timeout)
+	}
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type hookCall struct {
+	ifaceName string
+	address   string
+}
+
+func TestEngine_InterfaceHooks(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var postUp, postDown []hookCall
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun114",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33114,
+		PostUp: func(ifaceName string, address string) error {
+			postUp = append(postUp, hookCall{ifaceName, address})
+			return nil
+		},
+		PostDown: func(ifaceName string, address string) error {
+			postDown = append(postDown, hookCall{ifaceName, address})
+			return nil
+		},
+	})
+
+	err = engine.Start()
+	require.NoError(t, err)
+	assert.Equal(t, []hookCall{{"utun114", "100.64.0.1/24"}}, postUp)
+	assert.Empty(t, postDown)
+
+	// the client is being killed, the engine is stopped after its context has been cancelled
+	cancel()
+	_ = engine.Stop()
+	assert.Equal(t, []hookCall{{"utun114", "100.64.0.1/24"}}, postDown)
+
+	// PostDown runs once per interface created
+	_ = engine.Stop()
+	assert.Len(t, postDown, 1)
+}
+
+func TestCommandHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test command is a POSIX shell one")
+	}
+	out := filepath.Join(t.TempDir(), "hook.out")
+
+	hook := commandHook("echo %i $NB_INTERFACE $NB_ADDRESS > " + out)
+	err := hook("wt0", "100.64.0.1/16")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "wt0 wt0 100.64.0.1/16\n", string(content))
+
+	err = commandHook("echo failed >&2; exit 3")("wt0", "100.64.0.1/16")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "output: failed")
+}
+
+type networkMapHookCall struct {
+	serial uint64
+	diff   NetworkMapDiff
+	peers  int
+}
+
+func TestEngine_NetworkMapHooks(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var engine *Engine
+	var pre, post []networkMapHookCall
+	engine = NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun115",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33115,
+		// the hooks run in lock-step with the sync loop, so they see the connections before and after the apply
+		PreNetworkMapApply: func(_ context.Context, serial uint64, diff NetworkMapDiff) {
+			pre = append(pre, networkMapHookCall{serial, diff, len(engine.peerConns)})
+		},
+		PostNetworkMapApply: func(_ context.Context, serial uint64, diff NetworkMapDiff) {
+			post = append(post, networkMapHookCall{serial, diff, len(engine.peerConns)})
+		},
+	})
+
+	peer1 := &mgmtProto.RemotePeerConfig{WgPubKey: "RRHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=", AllowedIps: []string{"100.64.0.10/32"}}
+	peer2 := &mgmtProto.RemotePeerConfig{WgPubKey: "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=", AllowedIps: []string{"100.64.0.11/32"}}
+	peer3 := &mgmtProto.RemotePeerConfig{WgPubKey: "GGHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU=", AllowedIps: []string{"100.64.0.12/32"}}
+	peer2Moved := &mgmtProto.RemotePeerConfig{WgPubKey: peer2.WgPubKey, AllowedIps: []string{"100.64.0.21/32"}}
+
+	updates := []struct {
+		networkMap *mgmtProto.NetworkMap
+		diff       NetworkMapDiff
+		before     int
+		after      int
+	}{
+		{
+			networkMap: &mgmtProto.NetworkMap{Serial: 1, RemotePeers: []*mgmtProto.RemotePeerConfig{peer1, peer2}},
+			diff:       NetworkMapDiff{Added: []string{peer2.WgPubKey, peer1.WgPubKey}},
+			before:     0,
+			after:      2,
+		},
+		{
+			networkMap: &mgmtProto.NetworkMap{Serial: 2, RemotePeers: []*mgmtProto.RemotePeerConfig{peer2Moved, peer3}},
+			diff:       NetworkMapDiff{Added: []string{peer3.WgPubKey}, Removed: []string{peer1.WgPubKey}, Updated: []string{peer2.WgPubKey}},
+			before:     2,
+			after:      2,
+		},
+		{
+			networkMap: &mgmtProto.NetworkMap{Serial: 3, RemotePeersIsEmpty: true},
+			diff:       NetworkMapDiff{Removed: []string{peer3.WgPubKey, peer2.WgPubKey}},
+			before:     2,
+			after:      0,
+		},
+	}
+	for i, update := range updates {
+		err = engine.updateNetworkMap(update.networkMap)
+		require.NoError(t, err)
+
+		serial := update.networkMap.Serial
+		require.Len(t, pre, i+1)
+		require.Len(t, post, i+1)
+		assert.Equal(t, networkMapHookCall{serial, update.diff, update.before}, pre[i])
+		assert.Equal(t, networkMapHookCall{serial, update.diff, update.after}, post[i])
+	}
+
+	// an outdated NetworkMap isn't applied, so the hooks aren't invoked
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 1, RemotePeers: []*mgmtProto.RemotePeerConfig{peer1}})
+	require.NoError(t, err)
+	assert.Len(t, pre, len(updates))
+}
+
+func TestEngine_NetworkMapHookTimeout(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	defer close(release)
+	hookCtxDone := make(chan struct{})
+	engine := NewEngine(ctx, cancel, &signal.MockClient{}, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:           "utun116",
+		WgAddr:                "100.64.0.1/24",
+		WgPrivateKey:          key,
+		WgPort:                33116,
+		NetworkMapHookTimeout: 100 * time.Millisecond,
+		// the hook ignores its context and blocks
+		PreNetworkMapApply: func(hookCtx context.Context, _ uint64, _ NetworkMapDiff) {
+			<-hookCtx.Done()
+			close(hookCtxDone)
+			<-release
+		},
+	})
+
+	start := time.Now()
+	err = engine.updateNetworkMap(&mgmtProto.NetworkMap{Serial: 1, RemotePeersIsEmpty: true})
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second, "expecting the sync loop not to wait for a blocked hook")
+	assert.Equal(t, uint64(1), engine.networkSerial)
+
+	select {
+	case <-hookCtxDone:
+	case <-time.After(time.Second):
+		t.Error("expecting the context of the hook to be done once it has timed out")
+	}
+}
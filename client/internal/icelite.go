@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"net"
+	"sort"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+)
+
+// cgnatNetwork is the shared address space of the carrier-grade NATs (RFC 6598), not reachable from the Internet
+var cgnatNetwork = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkIceLite refuses the ICE-lite mode when the host has no public address the remote peers could reach
+// the lite agents on, the connections are negotiated by full ICE agents then
+func (e *Engine) checkIceLite() {
+	if !e.config.IceLite {
+		return
+	}
+
+	addrs, err := hostInterfaceAddrs()
+	if err != nil {
+		log.Warnf("refusing ICE-lite mode: failed listing the host interfaces: %v, running a full ICE agent", err)
+		e.config.IceLite = false
+		return
+	}
+
+	blackList := make(map[string]struct{}, len(e.config.IFaceBlackList)+1)
+	for name := range e.config.IFaceBlackList {
+		blackList[name] = struct{}{}
+	}
+	blackList[e.config.WgIfaceName] = struct{}{}
+
+	ip := publicHostAddress(addrs, blackList, e.config.IPFamilyPreference == peer.IPFamilyIPv6)
+	if ip == nil {
+		log.Warnf("refusing ICE-lite mode: no host candidate with a public address is available, running a full ICE agent")
+		e.config.IceLite = false
+		return
+	}
+
+	log.Infof("running an ICE-lite agent, the remote peers connect to the public host address %s", ip)
+}
+
+// hostInterfaceAddrs returns the addresses of the up and non-loopback interfaces by the interface name
+func hostInterfaceAddrs() (map[string][]net.Addr, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make(map[string][]net.Addr)
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 || i.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := i.Addrs()
+		if err != nil {
+			log.Debugf("failed getting the addresses of interface %s: %v", i.Name, err)
+			continue
+		}
+		addrs[i.Name] = ifaceAddrs
+	}
+	return addrs, nil
+}
+
+// publicHostAddress returns the first public address of the interfaces ICE gathers the host candidates on,
+// skipping the blacklisted interfaces. IPv6 addresses are considered only if ipv6 is set. Nil if there is none
+func publicHostAddress(addrs map[string][]net.Addr, blackList map[string]struct{}, ipv6 bool) net.IP {
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		if _, ok := blackList[name]; ok {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, addr := range addrs[name] {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP
+			if ip.To4() == nil && !ipv6 {
+				continue
+			}
+			if ip.IsUnspecified() || cgnatNetwork.Contains(ip) || !peer.IsPublicIP(ip) {
+				continue
+			}
+			return ip
+		}
+	}
+	return nil
+}
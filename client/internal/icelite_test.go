@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ipNet(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network.IP = ip
+	return network
+}
+
+func TestPublicHostAddress(t *testing.T) {
+	testCases := []struct {
+		name      string
+		addrs     map[string][]net.Addr
+		blackList map[string]struct{}
+		ipv6      bool
+		expected  net.IP
+	}{
+		{
+			name: "Public IPv4 Address",
+			addrs: map[string][]net.Addr{
+				"eth0": {ipNet(t, "10.0.0.5/24"), ipNet(t, "198.51.100.7/24")},
+			},
+			expected: net.ParseIP("198.51.100.7"),
+		},
+		{
+			name: "Private Addresses Only",
+			addrs: map[string][]net.Addr{
+				"eth0": {ipNet(t, "192.168.1.5/24"), ipNet(t, "fe80::1/64")},
+				"eth1": {ipNet(t, "100.64.0.9/10")},
+			},
+		},
+		{
+			name: "Blacklisted Public Address",
+			addrs: map[string][]net.Addr{
+				"eth0": {ipNet(t, "192.168.1.5/24")},
+				"wt0":  {ipNet(t, "198.51.100.7/24")},
+			},
+			blackList: map[string]struct{}{"wt0": {}},
+		},
+		{
+			name: "Public IPv6 Address Without IPv6 Preference",
+			addrs: map[string][]net.Addr{
+				"eth0": {ipNet(t, "192.168.1.5/24"), ipNet(t, "2001:db8::7/64")},
+			},
+		},
+		{
+			name: "Public IPv6 Address With IPv6 Preference",
+			addrs: map[string][]net.Addr{
+				"eth0": {ipNet(t, "192.168.1.5/24"), ipNet(t, "2001:db8::7/64")},
+			},
+			ipv6:     true,
+			expected: net.ParseIP("2001:db8::7"),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			ip := publicHostAddress(testCase.addrs, testCase.blackList, testCase.ipv6)
+			if testCase.expected == nil {
+				assert.Nil(t, ip)
+				return
+			}
+			assert.True(t, testCase.expected.Equal(ip), "expected %s, got %s", testCase.expected, ip)
+		})
+	}
+}
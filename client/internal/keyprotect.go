@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/util"
+)
+
+const (
+	// KeyProtectionPassphrase encrypts the Wireguard private key with a key derived from a passphrase (scrypt).
+	// The passphrase is taken from the NB_CONFIG_PASSPHRASE environment variable or, on Linux, from the user keyring
+	// of the kernel
+	KeyProtectionPassphrase = "passphrase"
+	// KeyProtectionKeychain encrypts the Wireguard private key with a key kept in the macOS Keychain
+	KeyProtectionKeychain = "keychain"
+	// KeyProtectionDPAPI encrypts the Wireguard private key with the Windows Data Protection API bound to the machine
+	KeyProtectionDPAPI = "dpapi"
+)
+
+const (
+	// configPassphraseEnv is an environment variable holding the passphrase the Wireguard private key is encrypted with
+	configPassphraseEnv = "NB_CONFIG_PASSPHRASE"
+	// plaintextPrivateKeyEnv keeps the Wireguard private key of a new config in plaintext, see Config.PlaintextPrivateKey
+	plaintextPrivateKeyEnv = "NB_PLAINTEXT_PRIVATE_KEY"
+	// passphraseKeyringKey is the description of the user key in the kernel keyring holding the passphrase on Linux
+	passphraseKeyringKey = "netbird:config-passphrase"
+)
+
+// scrypt parameters of the key derived from the passphrase, the recommended interactive ones
+const (
+	scryptN       = 1 << 15
+	scryptR       = 8
+	scryptP       = 1
+	scryptSaltLen = 16
+	aesKeyLen     = 32
+)
+
+// keyProtector encrypts the Wireguard private key kept in the config at rest
+type keyProtector interface {
+	// seal encrypts the key
+	seal(plaintext []byte) ([]byte, error)
+	// open decrypts the key sealed by seal
+	open(sealed []byte) ([]byte, error)
+	// unlockHint tells the user how to make the key decryptable when open fails
+	unlockHint() string
+}
+
+// newKeyProtector returns the protector of a kind, the default one of the platform if the kind is empty
+func newKeyProtector(protection string) (keyProtector, error) {
+	if protection == "" {
+		protection = DefaultKeyProtection()
+	}
+	if protection == KeyProtectionPassphrase {
+		return passphraseProtector{}, nil
+	}
+	if protector := platformKeyProtector(protection); protector != nil {
+		return protector, nil
+	}
+	return nil, fmt.Errorf("private key protection %q is not supported on %s", protection, runtime.GOOS)
+}
+
+// passphraseProtector encrypts the key with AES-256-GCM and a key derived from a passphrase with scrypt.
+// The sealed key is the salt, the nonce and the ciphertext
+type passphraseProtector struct{}
+
+func (passphraseProtector) seal(plaintext []byte) ([]byte, error) {
+	passphrase, err := configPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, scryptSaltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, aesKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := sealAESGCM(key, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, sealed...), nil
+}
+
+func (passphraseProtector) open(sealed []byte) ([]byte, error) {
+	if len(sealed) < scryptSaltLen {
+		return nil, fmt.Errorf("encrypted private key is truncated")
+	}
+	passphrase, err := configPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key([]byte(passphrase), sealed[:scryptSaltLen], scryptN, scryptR, scryptP, aesKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openAESGCM(key, sealed[scryptSaltLen:])
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase")
+	}
+	return plaintext, nil
+}
+
+func (passphraseProtector) unlockHint() string {
+	if runtime.GOOS == "linux" {
+		return fmt.Sprintf("set the passphrase in the %s environment variable of the daemon or add it to the user "+
+			"keyring of the kernel with: keyctl add user %s <passphrase> @u", configPassphraseEnv, passphraseKeyringKey)
+	}
+	return fmt.Sprintf("set the passphrase in the %s environment variable of the daemon", configPassphraseEnv)
+}
+
+// configPassphrase returns the passphrase the Wireguard private key is encrypted with, from the environment first
+// and from the keyring of the platform then
+func configPassphrase() (string, error) {
+	if passphrase := os.Getenv(configPassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := keyringPassphrase()
+	if err != nil {
+		return "", fmt.Errorf("failed reading the passphrase from the keyring: %v", err)
+	}
+	if passphrase == "" {
+		return "", fmt.Errorf("no passphrase provided")
+	}
+	return passphrase, nil
+}
+
+// sealAESGCM encrypts the plaintext with AES-GCM, the result is the nonce followed by the ciphertext
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// openAESGCM decrypts the result of sealAESGCM
+func openAESGCM(key, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is truncated")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// encryptPrivateKey moves the Wireguard private key kept in the config to EncryptedPrivateKey, sealed with
+// the protection (the default one of the platform if empty). The config isn't changed on failure
+func (c *Config) encryptPrivateKey(protection string) error {
+	if protection == "" {
+		protection = DefaultKeyProtection()
+	}
+	protector, err := newKeyProtector(protection)
+	if err != nil {
+		return err
+	}
+	sealed, err := protector.seal([]byte(c.PrivateKey))
+	if err != nil {
+		return err
+	}
+	c.EncryptedPrivateKey = base64.StdEncoding.EncodeToString(sealed)
+	c.PrivateKeyProtection = protection
+	c.PrivateKey = ""
+	return nil
+}
+
+// decryptPrivateKey returns the Wireguard private key sealed in EncryptedPrivateKey. A key that can't be decrypted
+// is reported as ErrPrivateKeyLocked with a hint how to unlock it
+func (c *Config) decryptPrivateKey() (wgtypes.Key, error) {
+	protector, err := newKeyProtector(c.PrivateKeyProtection)
+	if err != nil {
+		return wgtypes.Key{}, wrapError(ErrPrivateKeyLocked, err)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(c.EncryptedPrivateKey)
+	if err != nil {
+		return wgtypes.Key{}, wrapError(ErrInvalidConfig, fmt.Errorf("EncryptedPrivateKey is not valid base64: %v", err))
+	}
+	plaintext, err := protector.open(sealed)
+	if err != nil {
+		return wgtypes.Key{}, wrapError(ErrPrivateKeyLocked, fmt.Errorf("%v, %s", err, protector.unlockHint()))
+	}
+	return wgtypes.ParseKey(string(plaintext))
+}
+
+// protectNewPrivateKey encrypts the Wireguard private key of a new config with the default protection of the platform
+// unless the plaintext key has been requested. The key stays in plaintext if it can't be protected without
+// the user (e.g. no passphrase has been provided)
+func (c *Config) protectNewPrivateKey() {
+	if c.PrivateKey == "" {
+		return
+	}
+	if plaintext, _ := strconv.ParseBool(os.Getenv(plaintextPrivateKeyEnv)); plaintext {
+		c.PlaintextPrivateKey = true
+		return
+	}
+	if err := c.encryptPrivateKey(""); err != nil {
+		log.Infof("keeping the Wireguard private key in plaintext, it can't be encrypted with the %s protection: %v",
+			DefaultKeyProtection(), err)
+	}
+}
+
+// EncryptConfigFile encrypts the Wireguard private key kept in plaintext in the config file with the protection
+// (the default one of the platform if empty). The other fields are kept as they are and no plaintext backup is left.
+// Returns false if the key is encrypted already
+func EncryptConfigFile(path, protection string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return false, fmt.Errorf("config file %s is not a valid JSON object: %v", path, err)
+	}
+	config := &Config{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return false, fmt.Errorf("failed parsing config file %s: %v", path, err)
+	}
+
+	if config.PlaintextPrivateKey {
+		return false, fmt.Errorf("PlaintextPrivateKey is set, unset it to encrypt the Wireguard private key")
+	}
+	if config.PrivateKey == "" {
+		if config.EncryptedPrivateKey != "" {
+			return false, nil
+		}
+		return false, fmt.Errorf("the Wireguard private key isn't kept in the config (PrivateKeyFile or %s), "+
+			"protect it where it is kept", privateKeyEnv)
+	}
+	if _, err := wgtypes.ParseKey(config.PrivateKey); err != nil {
+		return false, fmt.Errorf("PrivateKey is not a valid Wireguard key: %v", err)
+	}
+
+	if err := config.encryptPrivateKey(protection); err != nil {
+		return false, err
+	}
+
+	for name := range fields {
+		switch strings.ToLower(name) {
+		case "privatekey", "encryptedprivatekey", "privatekeyprotection":
+			delete(fields, name)
+		}
+	}
+	if fields["EncryptedPrivateKey"], err = json.Marshal(config.EncryptedPrivateKey); err != nil {
+		return false, err
+	}
+	if fields["PrivateKeyProtection"], err = json.Marshal(config.PrivateKeyProtection); err != nil {
+		return false, err
+	}
+
+	// the config is replaced atomically, so the plaintext key isn't left behind in a partially written file
+	if err := util.WriteJson(path, fields); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// keychainService and keychainAccount name the generic password item of the Keychain holding the key
+	// the Wireguard private key is encrypted with
+	keychainService = "netbird"
+	keychainAccount = "config-private-key"
+)
+
+// DefaultKeyProtection returns the protection of the Wireguard private key used unless another one is requested
+func DefaultKeyProtection() string {
+	return KeyProtectionKeychain
+}
+
+// platformKeyProtector returns the protector of a kind specific to the platform, nil if it isn't supported
+func platformKeyProtector(protection string) keyProtector {
+	if protection == KeyProtectionKeychain {
+		return keychainProtector{}
+	}
+	return nil
+}
+
+// keyringPassphrase returns the passphrase kept in the keyring of the platform, the Keychain holds a key instead
+func keyringPassphrase() (string, error) {
+	return "", nil
+}
+
+// keychainProtector encrypts the key with AES-256-GCM and a random key kept in the Keychain of the user running
+// the daemon (the System keychain for root), managed with the security tool
+type keychainProtector struct{}
+
+func (keychainProtector) seal(plaintext []byte) ([]byte, error) {
+	key, err := keychainKey()
+	if err != nil {
+		key = make([]byte, aesKeyLen)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		out, err := exec.Command("security", "add-generic-password", "-U", "-s", keychainService,
+			"-a", keychainAccount, "-w", base64.StdEncoding.EncodeToString(key)).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed adding the key to the Keychain: %v: %s", err, bytes.TrimSpace(out))
+		}
+	}
+	return sealAESGCM(key, plaintext)
+}
+
+func (keychainProtector) open(sealed []byte) ([]byte, error) {
+	key, err := keychainKey()
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openAESGCM(key, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("the key in the Keychain doesn't match")
+	}
+	return plaintext, nil
+}
+
+func (keychainProtector) unlockHint() string {
+	return fmt.Sprintf("run the daemon as the user whose Keychain holds the %s item of the %s service and check "+
+		"that the Keychain is unlocked", keychainAccount, keychainService)
+}
+
+// keychainKey reads the key the Wireguard private key is encrypted with from the Keychain
+func keychainKey() ([]byte, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", keychainAccount,
+		"-w").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the key from the Keychain: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil || len(key) != aesKeyLen {
+		return nil, fmt.Errorf("the key in the Keychain is malformed")
+	}
+	return key, nil
+}
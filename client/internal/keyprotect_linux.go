@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultKeyProtection returns the protection of the Wireguard private key used unless another one is requested
+func DefaultKeyProtection() string {
+	return KeyProtectionPassphrase
+}
+
+// platformKeyProtector returns the protector of a kind specific to the platform, nil if it isn't supported
+func platformKeyProtector(string) keyProtector {
+	return nil
+}
+
+// keyringPassphrase returns the passphrase kept in the user keyring of the kernel, empty if there is none
+func keyringPassphrase() (string, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", passphraseKeyringKey, 0)
+	if errors.Is(err, unix.ENOKEY) || errors.Is(err, unix.ENOSYS) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return "", err
+	}
+	if n < len(buf) {
+		buf = buf[:n]
+	}
+	return strings.TrimRight(string(buf), "\n"), nil
+}
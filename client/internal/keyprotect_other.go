@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package internal
+
+// DefaultKeyProtection returns the protection of the Wireguard private key used unless another one is requested
+func DefaultKeyProtection() string {
+	return KeyProtectionPassphrase
+}
+
+// platformKeyProtector returns the protector of a kind specific to the platform, nil if it isn't supported
+func platformKeyProtector(string) keyProtector {
+	return nil
+}
+
+// keyringPassphrase returns the passphrase kept in the keyring of the platform, there is none here
+func keyringPassphrase() (string, error) {
+	return "", nil
+}
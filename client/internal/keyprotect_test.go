@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/util"
+)
+
+func TestConfig_EncryptPrivateKey(t *testing.T) {
+	t.Setenv(configPassphraseEnv, "correct horse battery staple")
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	config := &Config{PrivateKey: key.String()}
+	err = config.encryptPrivateKey(KeyProtectionPassphrase)
+	assert.NoError(t, err)
+	assert.Empty(t, config.PrivateKey, "expecting the plaintext key to be removed from the config")
+	assert.Equal(t, KeyProtectionPassphrase, config.PrivateKeyProtection)
+	assert.NotContains(t, config.EncryptedPrivateKey, key.String())
+
+	decrypted, err := config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+	assert.NoError(t, config.validatePrivateKey())
+
+	t.Setenv(configPassphraseEnv, "wrong")
+	_, err = config.WgPrivateKey()
+	assert.True(t, errors.Is(err, ErrPrivateKeyLocked), "expecting a wrong passphrase to lock the key, got %v", err)
+	assert.Contains(t, err.Error(), "wrong passphrase")
+	assert.Contains(t, err.Error(), configPassphraseEnv, "expecting the error to tell how to unlock the key")
+	assert.Error(t, config.validatePrivateKey())
+
+	t.Setenv(configPassphraseEnv, "")
+	if passphrase, _ := keyringPassphrase(); passphrase == "" {
+		_, err = config.WgPrivateKey()
+		assert.True(t, errors.Is(err, ErrPrivateKeyLocked), "expecting a missing passphrase to lock the key, got %v", err)
+	}
+
+	config.PrivateKeyProtection = "unknown"
+	_, err = config.WgPrivateKey()
+	assert.True(t, errors.Is(err, ErrPrivateKeyLocked))
+}
+
+func TestConfig_SaveEncryptedWgPrivateKey(t *testing.T) {
+	t.Setenv(configPassphraseEnv, "passphrase")
+	oldKey, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	newKey, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	config := &Config{PrivateKey: oldKey.String(), path: filepath.Join(t.TempDir(), "config.json")}
+	assert.NoError(t, config.encryptPrivateKey(KeyProtectionPassphrase))
+
+	err = config.saveWgPrivateKey(newKey, time.Now().UTC())
+	assert.NoError(t, err)
+	assert.Empty(t, config.PrivateKey, "expecting the rotated key to stay encrypted")
+
+	read, err := ReadConfig("", "", config.path, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, read.PrivateKey)
+	key, err := read.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, newKey, key)
+}
+
+func TestCreateNewConfig_PrivateKeyProtection(t *testing.T) {
+	// the keychain and DPAPI protections of the other platforms would leave the test keys in the system
+	if DefaultKeyProtection() == KeyProtectionPassphrase {
+		t.Setenv(configPassphraseEnv, "passphrase")
+		config, err := createNewConfig("", "", filepath.Join(t.TempDir(), "config.json"), "")
+		assert.NoError(t, err)
+		assert.Empty(t, config.PrivateKey, "expecting the key of a new config to be encrypted")
+		assert.NotEmpty(t, config.EncryptedPrivateKey)
+		_, err = config.WgPrivateKey()
+		assert.NoError(t, err)
+	}
+
+	t.Setenv(plaintextPrivateKeyEnv, "true")
+	config, err := createNewConfig("", "", filepath.Join(t.TempDir(), "config.json"), "")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, config.PrivateKey)
+	assert.Empty(t, config.EncryptedPrivateKey)
+	assert.True(t, config.PlaintextPrivateKey)
+}
+
+func TestEncryptConfigFile(t *testing.T) {
+	t.Setenv(configPassphraseEnv, "passphrase")
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "config.json")
+	err = util.WriteJson(path, map[string]interface{}{
+		"PrivateKey":    key.String(),
+		"WgIface":       "wt0",
+		"UnknownOption": "kept",
+	})
+	assert.NoError(t, err)
+
+	encrypted, err := EncryptConfigFile(path, KeyProtectionPassphrase)
+	assert.NoError(t, err)
+	assert.True(t, encrypted)
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(content), key.String()), "expecting no plaintext key in the config")
+	fields := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(content, &fields))
+	assert.Equal(t, "kept", fields["UnknownOption"])
+	assert.NotContains(t, fields, "PrivateKey")
+	_, err = os.Stat(path + ".bak")
+	assert.True(t, os.IsNotExist(err), "expecting no plaintext backup")
+
+	config, err := ReadConfig("", "", path, nil)
+	assert.NoError(t, err)
+	decrypted, err := config.WgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, key, decrypted)
+
+	encrypted, err = EncryptConfigFile(path, KeyProtectionPassphrase)
+	assert.NoError(t, err)
+	assert.False(t, encrypted, "expecting the key to be encrypted already")
+
+	// the key kept out of the config and the plaintext key requested by the user aren't encrypted
+	for _, fields := range []map[string]interface{}{
+		{"PrivateKeyFile": filepath.Join(t.TempDir(), "wg.key")},
+		{"PrivateKey": key.String(), "PlaintextPrivateKey": true},
+	} {
+		assert.NoError(t, util.WriteJson(path, fields))
+		_, err = EncryptConfigFile(path, KeyProtectionPassphrase)
+		assert.Error(t, err)
+	}
+}
+
+func TestConfig_SaveEncryptedPendingWgPrivateKey(t *testing.T) {
+	t.Setenv(configPassphraseEnv, "passphrase")
+	key, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+	pendingKey, err := wgtypes.GeneratePrivateKey()
+	assert.NoError(t, err)
+
+	config := &Config{PrivateKey: key.String(), path: filepath.Join(t.TempDir(), "config.json")}
+	assert.NoError(t, config.encryptPrivateKey(KeyProtectionPassphrase))
+
+	assert.NoError(t, config.savePendingWgPrivateKey(&pendingKey))
+	assert.NotContains(t, config.PendingPrivateKey, pendingKey.String(), "expecting the pending key to be encrypted")
+
+	read, err := ReadConfig("", "", config.path, nil)
+	assert.NoError(t, err)
+	pending, err := read.pendingWgPrivateKey()
+	assert.NoError(t, err)
+	assert.Equal(t, &pendingKey, pending)
+
+	assert.NoError(t, read.savePendingWgPrivateKey(nil))
+	assert.False(t, read.keyRotationPending())
+}
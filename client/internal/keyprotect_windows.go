@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// DefaultKeyProtection returns the protection of the Wireguard private key used unless another one is requested
+func DefaultKeyProtection() string {
+	return KeyProtectionDPAPI
+}
+
+// platformKeyProtector returns the protector of a kind specific to the platform, nil if it isn't supported
+func platformKeyProtector(protection string) keyProtector {
+	if protection == KeyProtectionDPAPI {
+		return dpapiProtector{}
+	}
+	return nil
+}
+
+// keyringPassphrase returns the passphrase kept in the keyring of the platform, there is none on Windows
+func keyringPassphrase() (string, error) {
+	return "", nil
+}
+
+// dpapiProtector encrypts the key with the Data Protection API bound to the machine, so the service running
+// as LocalSystem and the administrators running the CLI on the same machine can decrypt it, copies of the config
+// on other machines can't
+type dpapiProtector struct{}
+
+func (dpapiProtector) seal(plaintext []byte) ([]byte, error) {
+	var out windows.DataBlob
+	err := windows.CryptProtectData(newDataBlob(plaintext), nil, nil, 0, nil,
+		windows.CRYPTPROTECT_LOCAL_MACHINE|windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed encrypting with DPAPI: %v", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+func (dpapiProtector) open(sealed []byte) ([]byte, error) {
+	if len(sealed) == 0 {
+		return nil, fmt.Errorf("encrypted private key is empty")
+	}
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(newDataBlob(sealed), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting with DPAPI: %v", err)
+	}
+	return takeDataBlob(&out), nil
+}
+
+func (dpapiProtector) unlockHint() string {
+	return "the key can be decrypted only on the machine the config has been encrypted on, " +
+		"copy the config back there or register this machine as a new peer"
+}
+
+func newDataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies the data allocated by DPAPI and frees it
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	data := make([]byte, blob.Size)
+	copy(data, unsafe.Slice(blob.Data, blob.Size))
+	return data
+}
@@ -0,0 +1,314 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/client/system"
+	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// keyRotationRetryInterval is a delay before the next automatic key rotation after a failed one
+var keyRotationRetryInterval = time.Hour
+
+// errKeyRotated stops the client running with the old Wireguard key, so it connects again with the new one
+var errKeyRotated = errors.New("wireguard key has been rotated")
+
+// RotateKey replaces the Wireguard private key of local peer with a newly generated one keeping the peer's IP,
+// name and groups on the Management Service. The new key is persisted as pending before the Management Service is
+// asked to replace the key and becomes the current one once the request succeeds. It is dropped only if the
+// Management Service rejects the request, a request failing otherwise (e.g. a timeout) may have replaced the key,
+// so the next rotation completes it with the key the Management Service knows. The client must not run with the
+// old key meanwhile. Returns the new public key
+func RotateKey(ctx context.Context, config *Config) (wgtypes.Key, error) {
+	if os.Getenv(privateKeyEnv) != "" {
+		return wgtypes.Key{}, fmt.Errorf("the Wireguard private key is set by %s and can't be rotated", privateKeyEnv)
+	}
+	if config.path == "" {
+		return wgtypes.Key{}, fmt.Errorf("the config hasn't been read from a file, the rotated key can't be persisted")
+	}
+
+	oldKey, err := config.WgPrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed reading the current Wireguard key: %v", err)
+	}
+
+	pendingKey, err := config.pendingWgPrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed reading the pending Wireguard key: %v", err)
+	}
+
+	mgmClient, err := newManagementClient(ctx, config, oldKey)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed connecting to Management Service %s: %v", config.ManagementURL.String(), err)
+	}
+	defer func() {
+		if err := mgmClient.Close(); err != nil {
+			log.Warnf("failed closing Management Service client: %v", err)
+		}
+	}()
+
+	serverKey, err := mgmClient.GetServerPublicKey()
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed while getting Management Service public key: %v", err)
+	}
+
+	var newKey wgtypes.Key
+	if pendingKey != nil {
+		// the previous rotation has failed without knowing whether the Management Service has replaced the key
+		newKey = *pendingKey
+		if pendingKeyRegistered(ctx, config, *serverKey, newKey) {
+			log.Infof("Management Service has replaced the Wireguard key before, completing the rotation")
+			return newKey.PublicKey(), config.completeKeyRotation(newKey)
+		}
+	} else {
+		newKey, err = wgtypes.GeneratePrivateKey()
+		if err != nil {
+			return wgtypes.Key{}, err
+		}
+		err = config.savePendingWgPrivateKey(&newKey)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("failed persisting the new Wireguard key: %v", err)
+		}
+	}
+
+	_, err = mgmClient.ReplaceKey(*serverKey, newKey)
+	if err != nil {
+		if !keyReplacementRejected(err) {
+			return wgtypes.Key{}, fmt.Errorf("failed replacing the Wireguard key on Management Service, "+
+				"keeping the new key pending until the next rotation: %v", err)
+		}
+		if dropErr := config.savePendingWgPrivateKey(nil); dropErr != nil {
+			return wgtypes.Key{}, fmt.Errorf("failed replacing the Wireguard key on Management Service: %v, "+
+				"dropping the new key failed: %v", err, dropErr)
+		}
+		return wgtypes.Key{}, fmt.Errorf("failed replacing the Wireguard key on Management Service, keeping the old key: %v", err)
+	}
+
+	err = config.completeKeyRotation(newKey)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	log.Infof("rotated the Wireguard key, the new public key is %s", newKey.PublicKey().String())
+	return newKey.PublicKey(), nil
+}
+
+// keyReplacementRejected tells whether the Management Service has rejected the request to replace the key, so it
+// still knows the old key. Other failures (e.g. a timeout or a broken connection) may happen after the key has been
+// replaced
+func keyReplacementRejected(err error) bool {
+	s, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	switch s.Code() {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.Unauthenticated, codes.Unimplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// pendingKeyRegistered tells whether the Management Service knows local peer by the pending key of a rotation
+func pendingKeyRegistered(ctx context.Context, config *Config, serverKey wgtypes.Key, pendingKey wgtypes.Key) bool {
+	mgmClient, err := newManagementClient(ctx, config, pendingKey)
+	if err != nil {
+		log.Debugf("failed connecting to Management Service with the pending Wireguard key: %v", err)
+		return false
+	}
+	defer func() {
+		if err := mgmClient.Close(); err != nil {
+			log.Warnf("failed closing Management Service client: %v", err)
+		}
+	}()
+
+	_, err = mgmClient.Login(serverKey, system.GetInfo(ctx))
+	if err != nil {
+		log.Debugf("Management Service doesn't accept the pending Wireguard key: %v", err)
+		return false
+	}
+	return true
+}
+
+// completeKeyRotation makes the pending key of a rotation the current one once the Management Service has replaced
+// the key. The pending key is dropped only after the current one has been persisted
+func (c *Config) completeKeyRotation(key wgtypes.Key) error {
+	keyFile := c.privateKeyFile()
+	if keyFile == "" {
+		// the key and the pending key are persisted to the config at once
+		c.PendingPrivateKey = ""
+	}
+	err := c.saveWgPrivateKey(key, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed persisting the new Wireguard key, it stays pending: %v", err)
+	}
+	if keyFile != "" {
+		return c.savePendingWgPrivateKey(nil)
+	}
+	return nil
+}
+
+// keyRotationPending tells whether a rotation has to be completed before connecting, the Management Service may
+// know local peer by the pending key only
+func (c *Config) keyRotationPending() bool {
+	if keyFile := c.privateKeyFile(); keyFile != "" {
+		_, err := os.Stat(pendingKeyFile(keyFile))
+		return err == nil
+	}
+	return c.PendingPrivateKey != ""
+}
+
+// pendingWgPrivateKey returns the pending key of a rotation, nil if there is none
+func (c *Config) pendingWgPrivateKey() (*wgtypes.Key, error) {
+	if keyFile := c.privateKeyFile(); keyFile != "" {
+		content, err := os.ReadFile(pendingKeyFile(keyFile))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		key, err := wgtypes.ParseKey(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, err
+		}
+		return &key, nil
+	}
+
+	if c.PendingPrivateKey == "" {
+		return nil, nil
+	}
+	pending := c.PendingPrivateKey
+	if c.EncryptedPrivateKey != "" {
+		protector, err := newKeyProtector(c.PrivateKeyProtection)
+		if err != nil {
+			return nil, wrapError(ErrPrivateKeyLocked, err)
+		}
+		sealed, err := base64.StdEncoding.DecodeString(pending)
+		if err != nil {
+			return nil, fmt.Errorf("PendingPrivateKey is not valid base64: %v", err)
+		}
+		plaintext, err := protector.open(sealed)
+		if err != nil {
+			return nil, wrapError(ErrPrivateKeyLocked, err)
+		}
+		pending = string(plaintext)
+	}
+	key, err := wgtypes.ParseKey(pending)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// savePendingWgPrivateKey persists the pending key of a rotation next to the current key, nil drops it
+func (c *Config) savePendingWgPrivateKey(key *wgtypes.Key) error {
+	if keyFile := c.privateKeyFile(); keyFile != "" {
+		if key == nil {
+			err := os.Remove(pendingKeyFile(keyFile))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			return nil
+		}
+		return writeKeyFile(pendingKeyFile(keyFile), *key)
+	}
+
+	prevPending := c.PendingPrivateKey
+	c.PendingPrivateKey = ""
+	if key != nil {
+		c.PendingPrivateKey = key.String()
+		// the pending key is encrypted with the protection of the current one
+		if c.EncryptedPrivateKey != "" {
+			protector, err := newKeyProtector(c.PrivateKeyProtection)
+			if err != nil {
+				c.PendingPrivateKey = prevPending
+				return err
+			}
+			sealed, err := protector.seal([]byte(key.String()))
+			if err != nil {
+				c.PendingPrivateKey = prevPending
+				return fmt.Errorf("failed encrypting the Wireguard key: %v", err)
+			}
+			c.PendingPrivateKey = base64.StdEncoding.EncodeToString(sealed)
+		}
+	}
+
+	err := util.WriteJson(c.path, c)
+	if err != nil {
+		c.PendingPrivateKey = prevPending
+		return err
+	}
+	return nil
+}
+
+// pendingKeyFile is the file the pending key of a rotation is kept in when the key is read from keyFile
+func pendingKeyFile(keyFile string) string {
+	return keyFile + ".pending"
+}
+
+// saveWgPrivateKey persists the Wireguard private key where WgPrivateKey reads it from together with the time
+// the key has been rotated
+func (c *Config) saveWgPrivateKey(key wgtypes.Key, rotatedAt time.Time) error {
+	privateKey, encryptedKey, prevRotatedAt := c.PrivateKey, c.EncryptedPrivateKey, c.KeyRotatedAt
+
+	keyFile := c.privateKeyFile()
+	if keyFile == "" {
+		c.PrivateKey = key.String()
+		// the rotated key stays encrypted with the protection of the previous one
+		if encryptedKey != "" {
+			if err := c.encryptPrivateKey(c.PrivateKeyProtection); err != nil {
+				c.PrivateKey = privateKey
+				return fmt.Errorf("failed encrypting the Wireguard key: %v", err)
+			}
+		}
+	}
+	c.KeyRotatedAt = rotatedAt
+
+	err := util.WriteJson(c.path, c)
+	if err != nil {
+		c.PrivateKey, c.EncryptedPrivateKey, c.KeyRotatedAt = privateKey, encryptedKey, prevRotatedAt
+		return err
+	}
+
+	if keyFile != "" {
+		return writeKeyFile(keyFile, key)
+	}
+	return nil
+}
+
+// nextKeyRotation returns when the Wireguard key is due to be rotated automatically, zero if the rotation is disabled.
+// A key that has never been rotated is due at once as its age is unknown
+func (c *Config) nextKeyRotation() time.Time {
+	if c.KeyRotationInterval.Duration <= 0 {
+		return time.Time{}
+	}
+	if c.KeyRotatedAt.IsZero() {
+		return time.Now()
+	}
+	return c.KeyRotatedAt.Add(c.KeyRotationInterval.Duration)
+}
+
+// keyRotationTimer returns a channel receiving when the Wireguard key is due to be rotated automatically,
+// not before notBefore (e.g. after a failed rotation). Nil if the rotation is disabled
+func keyRotationTimer(config *Config, notBefore time.Time) (<-chan time.Time, func()) {
+	next := config.nextKeyRotation()
+	if next.IsZero() {
+		return nil, func() {}
+	}
+	if next.Before(notBefore) {
+		next = notBefore
+	}
+	timer := time.NewTimer(time.Until(next))
+	return timer.C, func() { timer.Stop() }
+}
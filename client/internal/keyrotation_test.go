@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/testutil"
+	"github.com/netbirdio/netbird/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRotateKey(t *testing.T) {
+	services := startTestServices(t, testutil.Options{})
+	accountManager := services.AccountManager
+
+	managementURL, err := url.Parse(services.ManagementURL)
+	require.NoError(t, err)
+
+	register := func(key wgtypes.Key) *server.Peer {
+		peer, err := accountManager.AddPeer(services.SetupKey, "",
+			&server.Peer{Key: key.PublicKey().String(), Name: key.PublicKey().String()[:8]})
+		require.NoError(t, err)
+		return peer
+	}
+
+	t.Run("Key In Config", func(t *testing.T) {
+		oldKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		registered := register(oldKey)
+
+		config := &Config{PrivateKey: oldKey.String(), ManagementURL: managementURL, WgIface: "wt0",
+			path: filepath.Join(t.TempDir(), "config.json")}
+
+		newKey, err := RotateKey(context.Background(), config)
+		require.NoError(t, err)
+
+		peer, err := accountManager.GetPeer(newKey.String())
+		require.NoError(t, err)
+		assert.True(t, registered.IP.Equal(peer.IP), "the peer should keep its IP with the new key")
+		_, err = accountManager.GetPeer(oldKey.PublicKey().String())
+		assert.Error(t, err, "the old key should be unknown")
+
+		saved, err := ReadConfig("", "", config.path, nil)
+		require.NoError(t, err)
+		savedKey, err := saved.WgPrivateKey()
+		require.NoError(t, err)
+		assert.Equal(t, newKey, savedKey.PublicKey())
+		assert.WithinDuration(t, time.Now(), saved.KeyRotatedAt, time.Minute)
+	})
+
+	t.Run("Key File", func(t *testing.T) {
+		oldKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		register(oldKey)
+
+		keyFile := filepath.Join(t.TempDir(), "private.key")
+		require.NoError(t, writeKeyFile(keyFile, oldKey))
+		config := &Config{PrivateKeyFile: keyFile, ManagementURL: managementURL, WgIface: "wt0",
+			path: filepath.Join(t.TempDir(), "config.json")}
+
+		newKey, err := RotateKey(context.Background(), config)
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(keyFile)
+		require.NoError(t, err)
+		savedKey, err := wgtypes.ParseKey(strings.TrimSpace(string(content)))
+		require.NoError(t, err)
+		assert.Equal(t, newKey, savedKey.PublicKey())
+		assert.Empty(t, config.PrivateKey, "the key shouldn't be copied to the config")
+	})
+
+	t.Run("Rollback On Failed Request", func(t *testing.T) {
+		// the Management Service rejects the key of an unregistered peer
+		oldKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+
+		config := &Config{PrivateKey: oldKey.String(), ManagementURL: managementURL, WgIface: "wt0",
+			path: filepath.Join(t.TempDir(), "config.json")}
+
+		_, err = RotateKey(context.Background(), config)
+		require.Error(t, err)
+
+		assert.Equal(t, oldKey.String(), config.PrivateKey)
+		assert.True(t, config.KeyRotatedAt.IsZero())
+		saved, err := ReadConfig("", "", config.path, nil)
+		require.NoError(t, err)
+		assert.Equal(t, oldKey.String(), saved.PrivateKey, "the old key should be restored")
+		assert.Empty(t, saved.PendingPrivateKey, "the rejected key should be dropped")
+	})
+
+	t.Run("Pending Key Replaced Before", func(t *testing.T) {
+		// the Management Service has replaced the key, but the client hasn't received the response
+		oldKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		registered := register(oldKey)
+		pendingKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		_, err = accountManager.ReplacePeerKey(oldKey.PublicKey().String(), pendingKey.PublicKey().String())
+		require.NoError(t, err)
+
+		config := &Config{PrivateKey: oldKey.String(), ManagementURL: managementURL, WgIface: "wt0",
+			path: filepath.Join(t.TempDir(), "config.json")}
+		require.NoError(t, config.savePendingWgPrivateKey(&pendingKey))
+		require.True(t, config.keyRotationPending())
+
+		newKey, err := RotateKey(context.Background(), config)
+		require.NoError(t, err)
+		assert.Equal(t, pendingKey.PublicKey(), newKey, "the pending key should become the current one")
+
+		peer, err := accountManager.GetPeer(newKey.String())
+		require.NoError(t, err)
+		assert.True(t, registered.IP.Equal(peer.IP), "the peer should keep its IP with the new key")
+
+		saved, err := ReadConfig("", "", config.path, nil)
+		require.NoError(t, err)
+		assert.Equal(t, pendingKey.String(), saved.PrivateKey)
+		assert.False(t, saved.keyRotationPending(), "the pending key should be dropped")
+	})
+
+	t.Run("Pending Key Not Replaced", func(t *testing.T) {
+		// the request to replace the key hasn't reached the Management Service
+		oldKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		register(oldKey)
+		pendingKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+
+		keyFile := filepath.Join(t.TempDir(), "private.key")
+		require.NoError(t, writeKeyFile(keyFile, oldKey))
+		config := &Config{PrivateKeyFile: keyFile, ManagementURL: managementURL, WgIface: "wt0",
+			path: filepath.Join(t.TempDir(), "config.json")}
+		require.NoError(t, config.savePendingWgPrivateKey(&pendingKey))
+
+		newKey, err := RotateKey(context.Background(), config)
+		require.NoError(t, err)
+		assert.Equal(t, pendingKey.PublicKey(), newKey, "the rotation should be retried with the pending key")
+		_, err = accountManager.GetPeer(newKey.String())
+		require.NoError(t, err)
+
+		savedKey, err := config.WgPrivateKey()
+		require.NoError(t, err)
+		assert.Equal(t, pendingKey, savedKey)
+		assert.NoFileExists(t, pendingKeyFile(keyFile), "the pending key should be dropped")
+	})
+
+	t.Run("Key From Environment", func(t *testing.T) {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		t.Setenv(privateKeyEnv, key.String())
+
+		config := &Config{ManagementURL: managementURL, WgIface: "wt0", path: filepath.Join(t.TempDir(), "config.json")}
+		_, err = RotateKey(context.Background(), config)
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyReplacementRejected(t *testing.T) {
+	assert.True(t, keyReplacementRejected(status.Error(codes.PermissionDenied, "not registered")))
+	assert.True(t, keyReplacementRejected(status.Error(codes.InvalidArgument, "invalid key")))
+	assert.False(t, keyReplacementRejected(status.Error(codes.DeadlineExceeded, "timeout")),
+		"the key may have been replaced when the request times out")
+	assert.False(t, keyReplacementRejected(status.Error(codes.Unavailable, "connection closed")))
+	assert.False(t, keyReplacementRejected(errors.New("failed to decrypt replace key message")))
+}
+
+func TestConfig_NextKeyRotation(t *testing.T) {
+	config := &Config{}
+	assert.True(t, config.nextKeyRotation().IsZero(), "the rotation should be disabled by default")
+
+	config.KeyRotationInterval = util.Duration{Duration: 24 * time.Hour}
+	assert.WithinDuration(t, time.Now(), config.nextKeyRotation(), time.Minute,
+		"a key that has never been rotated should be due at once")
+
+	rotatedAt := time.Now().Add(-time.Hour)
+	config.KeyRotatedAt = rotatedAt
+	assert.Equal(t, rotatedAt.Add(24*time.Hour), config.nextKeyRotation())
+}
@@ -0,0 +1,6 @@
+package internal
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the engine of the client, including the connection to the Management and the Signal services, its level is set by the engine component level
+var log = util.Logger("engine")
@@ -2,67 +2,91 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"net/url"
 
 	"github.com/google/uuid"
 	"github.com/netbirdio/netbird/client/system"
 	mgm "github.com/netbirdio/netbird/management/client"
 	mgmProto "github.com/netbirdio/netbird/management/proto"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
-func Login(ctx context.Context, config *Config, setupKey string, jwtToken string) error {
+// Login logs the peer in to the Management Service, registering it with the setup key or the JWT if it isn't
+// registered yet. With reRegister the peer registers with them even if it is registered already (e.g. a re-enrolled
+// machine), the Management Service reuses the existing peer
+func Login(ctx context.Context, config *Config, setupKey string, jwtToken string, reRegister bool) error {
 	// validate our peer's Wireguard PRIVATE key
-	myPrivateKey, err := wgtypes.ParseKey(config.PrivateKey)
+	myPrivateKey, err := config.WgPrivateKey()
 	if err != nil {
-		log.Errorf("failed parsing Wireguard key %s: [%s]", config.PrivateKey, err.Error())
-		return err
+		log.Errorf("failed parsing Wireguard key: [%s]", err.Error())
+		return wrapError(ErrInvalidConfig, err)
 	}
 
-	var mgmTlsEnabled bool
-	if config.ManagementURL.Scheme == "https" {
-		mgmTlsEnabled = true
+	// the primary Management Service is preferred, the secondary ones are tried in order if it is unreachable.
+	// The services share the account state, so the login result of the first reachable one is final
+	for _, managementURL := range config.managementURLs() {
+		err = loginTo(ctx, config, managementURL, myPrivateKey, setupKey, jwtToken, reRegister)
+		if !errors.Is(err, ErrManagementUnreachable) {
+			return err
+		}
+		log.Warnf("failed logging in to Management Service %s: %v", managementURL, err)
 	}
+	return err
+}
 
-	log.Debugf("connecting to Management Service %s", config.ManagementURL.String())
-	mgmClient, err := mgm.NewClient(ctx, config.ManagementURL.Host, myPrivateKey, mgmTlsEnabled)
+// loginTo logs the peer in to one of the Management Services of the config, see Login
+func loginTo(ctx context.Context, config *Config, managementURL *url.URL, myPrivateKey wgtypes.Key, setupKey string, jwtToken string, reRegister bool) error {
+	log.Debugf("connecting to Management Service %s", managementURL.String())
+	mgmClient, err := newManagementClientTo(ctx, config, managementURL, myPrivateKey)
 	if err != nil {
-		log.Errorf("failed connecting to Management Service %s %v", config.ManagementURL.String(), err)
-		return err
+		log.Errorf("failed connecting to Management Service %s %v", managementURL.String(), err)
+		return wrapError(ErrManagementUnreachable, err)
 	}
-	log.Debugf("connected to management Service %s", config.ManagementURL.String())
+	log.Debugf("connected to management Service %s", managementURL.String())
+	defer func() {
+		if err := mgmClient.Close(); err != nil {
+			log.Errorf("failed closing Management Service client: %v", err)
+		}
+	}()
 
 	serverKey, err := mgmClient.GetServerPublicKey()
 	if err != nil {
 		log.Errorf("failed while getting Management Service public key: %v", err)
-		return err
+		return wrapError(ErrManagementUnreachable, err)
 	}
 
-	_, err = loginPeer(ctx, *serverKey, mgmClient, setupKey, jwtToken)
+	_, err = loginPeer(*serverKey, mgmClient, setupKey, jwtToken, systemInfo(ctx, config.Labels), config.RequestedIP, reRegister)
 	if err != nil {
 		log.Errorf("failed logging-in peer on Management Service : %v", err)
 		return err
 	}
 
-	err = mgmClient.Close()
-	if err != nil {
-		log.Errorf("failed closing Management Service client: %v", err)
-		return err
-	}
-
 	return nil
 }
 
+// systemInfo collects the system information of the machine and attaches the user defined labels to it
+func systemInfo(ctx context.Context, labels map[string]string) *system.Info {
+	info := system.GetInfo(ctx)
+	info.Labels = labels
+	return info
+}
+
 // loginPeer attempts to login to Management Service. If peer wasn't registered, tries the registration flow.
-func loginPeer(ctx context.Context, serverPublicKey wgtypes.Key, client *mgm.GrpcClient, setupKey string, jwtToken string) (*mgmProto.LoginResponse, error) {
-	sysInfo := system.GetInfo(ctx)
+// With reRegister and a setup key or a JWT the peer registers without trying to login first
+func loginPeer(serverPublicKey wgtypes.Key, client *mgm.GrpcClient, setupKey string, jwtToken string, sysInfo *system.Info, requestedIP string, reRegister bool) (*mgmProto.LoginResponse, error) {
+	if reRegister && (setupKey != "" || jwtToken != "") {
+		log.Infof("registering peer again on Management Service")
+		return registerPeer(serverPublicKey, client, setupKey, jwtToken, sysInfo, requestedIP, true)
+	}
+
 	loginResp, err := client.Login(serverPublicKey, sysInfo)
 	if err != nil {
 		if s, ok := status.FromError(err); ok && s.Code() == codes.PermissionDenied {
 			log.Debugf("peer registration required")
-			return registerPeer(ctx, serverPublicKey, client, setupKey, jwtToken)
+			return registerPeer(serverPublicKey, client, setupKey, jwtToken, sysInfo, requestedIP, false)
 		} else {
 			return nil, err
 		}
@@ -75,17 +99,32 @@ func loginPeer(ctx context.Context, serverPublicKey wgtypes.Key, client *mgm.Grp
 
 // registerPeer checks whether setupKey was provided via cmd line and if not then it prompts user to enter a key.
 // Otherwise tries to register with the provided setupKey via command line.
-func registerPeer(ctx context.Context, serverPublicKey wgtypes.Key, client *mgm.GrpcClient, setupKey string, jwtToken string) (*mgmProto.LoginResponse, error) {
+func registerPeer(serverPublicKey wgtypes.Key, client *mgm.GrpcClient, setupKey string, jwtToken string, info *system.Info, requestedIP string, reRegister bool) (*mgmProto.LoginResponse, error) {
+	if setupKey == "" && jwtToken == "" {
+		return nil, ErrLoginRequired
+	}
 	validSetupKey, err := uuid.Parse(setupKey)
 	if err != nil && jwtToken == "" {
-		return nil, status.Errorf(codes.InvalidArgument, "invalid setup-key or no sso information provided, err: %v", err)
+		return nil, wrapError(ErrInvalidSetupKey, err)
 	}
 
 	log.Debugf("sending peer registration request to Management Service")
-	info := system.GetInfo(ctx)
-	loginResp, err := client.Register(serverPublicKey, validSetupKey.String(), jwtToken, info)
+	loginResp, err := client.Register(serverPublicKey, validSetupKey.String(), jwtToken, info, requestedIP, reRegister)
 	if err != nil {
 		log.Errorf("failed registering peer %v,%s", err, validSetupKey.String())
+		if s, ok := status.FromError(err); ok {
+			switch s.Code() {
+			case codes.ResourceExhausted:
+				return nil, wrapError(ErrPeersLimitReached, err)
+			case codes.AlreadyExists:
+				return nil, wrapError(ErrPeerAlreadyRegistered, err)
+			case codes.NotFound, codes.PermissionDenied, codes.FailedPrecondition:
+				// the Management Service refuses an unknown, revoked, expired or used up setup key with these codes
+				if jwtToken == "" {
+					return nil, wrapError(ErrInvalidSetupKey, err)
+				}
+			}
+		}
 		return nil, err
 	}
 
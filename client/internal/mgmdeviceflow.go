@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mgm "github.com/netbirdio/netbird/management/client"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ManagementDeviceFlowClientID identifies the device authorization brokered by the Management Service
+	ManagementDeviceFlowClientID = "netbird-management"
+	// ManagementDeviceAuthTokenType is the type of the tokens returned by the ManagementDeviceFlow,
+	// the tokens register the peer with LoginWithDeviceAuth instead of being used as JWT
+	ManagementDeviceAuthTokenType = "device_auth"
+
+	// managementDeviceFlowMaxInterval limits the polling backoff when the Management Service is unavailable
+	managementDeviceFlowMaxInterval = 30 * time.Second
+)
+
+// ManagementDeviceFlow is an OAuthClient for the deployments without an identity provider device flow.
+// The peer shows a user code that a user of the account approves in the dashboard or with the API,
+// the Management Service then issues a one-time token that registers the peer in the account of the user
+type ManagementDeviceFlow struct {
+	config *Config
+}
+
+// NewManagementDeviceFlow returns a device flow brokered by the Management Service of the config
+func NewManagementDeviceFlow(config *Config) *ManagementDeviceFlow {
+	return &ManagementDeviceFlow{config: config}
+}
+
+// GetClientID returns the client ID of the Management Service device flow
+func (m *ManagementDeviceFlow) GetClientID(ctx context.Context) string {
+	return ManagementDeviceFlowClientID
+}
+
+// RequestDeviceCode starts a device authorization of the peer on the Management Service
+func (m *ManagementDeviceFlow) RequestDeviceCode(ctx context.Context) (DeviceAuthInfo, error) {
+	var info DeviceAuthInfo
+	err := withManagementClient(ctx, m.config, func(client *mgm.GrpcClient, serverKey wgtypes.Key) error {
+		resp, err := client.StartDeviceAuth(serverKey)
+		if err != nil {
+			return err
+		}
+		verificationURI := m.config.AdminURL.String()
+		info = DeviceAuthInfo{
+			DeviceCode:              resp.GetDeviceCode(),
+			UserCode:                resp.GetUserCode(),
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI,
+			ExpiresIn:               int(resp.GetExpiresIn()),
+			Interval:                int(resp.GetInterval()),
+		}
+		return nil
+	})
+	if err != nil {
+		return DeviceAuthInfo{}, err
+	}
+
+	return info, nil
+}
+
+// WaitToken polls the Management Service until the device authorization has been approved and returns the token
+// registering the peer. The polling backs off while the Management Service is unavailable
+func (m *ManagementDeviceFlow) WaitToken(ctx context.Context, info DeviceAuthInfo) (TokenInfo, error) {
+	baseInterval := time.Duration(info.Interval) * time.Second
+	if baseInterval <= 0 {
+		baseInterval = time.Second
+	}
+	interval := baseInterval
+
+	for {
+		select {
+		case <-ctx.Done():
+			return TokenInfo{}, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var token string
+		err := withManagementClient(ctx, m.config, func(client *mgm.GrpcClient, serverKey wgtypes.Key) error {
+			resp, err := client.PollDeviceAuth(serverKey, info.DeviceCode)
+			if err != nil {
+				return err
+			}
+			token = resp.GetDeviceAuthToken()
+			return nil
+		})
+		if err != nil {
+			switch status.Code(err) {
+			case codes.NotFound:
+				return TokenInfo{}, fmt.Errorf("device authorization has expired, please login again")
+			case codes.Unimplemented, codes.PermissionDenied, codes.InvalidArgument:
+				return TokenInfo{}, err
+			}
+			log.Debugf("failed polling device authorization, retrying: %v", err)
+			interval *= 2
+			if interval > managementDeviceFlowMaxInterval {
+				interval = managementDeviceFlowMaxInterval
+			}
+			continue
+		}
+		interval = baseInterval
+
+		if token == "" {
+			continue
+		}
+
+		return TokenInfo{
+			AccessToken: token,
+			TokenType:   ManagementDeviceAuthTokenType,
+		}, nil
+	}
+}
+
+// RotateAccessToken isn't supported, the device authorization tokens are used once
+func (m *ManagementDeviceFlow) RotateAccessToken(ctx context.Context, refreshToken string) (TokenInfo, error) {
+	return TokenInfo{}, status.Errorf(codes.Unimplemented, "device authorization tokens can't be rotated")
+}
+
+// LoginWithDeviceAuth registers the peer with the token of an approved device authorization (see ManagementDeviceFlow).
+// With reRegister an already registered peer registers again, the Management Service reuses the existing peer
+func LoginWithDeviceAuth(ctx context.Context, config *Config, deviceAuthToken string, reRegister bool) error {
+	return withManagementClient(ctx, config, func(client *mgm.GrpcClient, serverKey wgtypes.Key) error {
+		_, err := client.RegisterWithDeviceAuth(serverKey, deviceAuthToken, systemInfo(ctx, config.Labels), config.RequestedIP, reRegister)
+		if err != nil {
+			log.Errorf("failed registering peer with device authorization: %v", err)
+			return err
+		}
+		log.Infof("peer has been successfully registered on Management Service")
+		return nil
+	})
+}
+
+// withManagementClient connects to the Management Service of the config, runs f and closes the connection
+func withManagementClient(ctx context.Context, config *Config, f func(client *mgm.GrpcClient, serverKey wgtypes.Key) error) error {
+	myPrivateKey, err := config.WgPrivateKey()
+	if err != nil {
+		log.Errorf("failed parsing Wireguard key: [%s]", err.Error())
+		return wrapError(ErrInvalidConfig, err)
+	}
+
+	mgmClient, err := newManagementClient(ctx, config, myPrivateKey)
+	if err != nil {
+		log.Errorf("failed connecting to Management Service %s %v", config.ManagementURL.String(), err)
+		return wrapError(ErrManagementUnreachable, err)
+	}
+	defer func() {
+		err := mgmClient.Close()
+		if err != nil {
+			log.Errorf("failed closing Management Service client: %v", err)
+		}
+	}()
+
+	serverKey, err := mgmClient.GetServerPublicKey()
+	if err != nil {
+		log.Errorf("failed while getting Management Service public key: %v", err)
+		return wrapError(ErrManagementUnreachable, err)
+	}
+
+	return f(mgmClient, *serverKey)
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"errors"
+
+	"github.com/netbirdio/netbird/iface"
+)
+
+// setMSSClamp clamps the MSS of the TCP connections through the Wireguard interface if it is configured
+func (e *Engine) setMSSClamp() {
+	if e.config.MSSClamp == 0 {
+		return
+	}
+	err := e.wgInterface.SetMSSClamp(e.config.MSSClamp)
+	if errors.Is(err, iface.ErrMSSClampNotSupported) {
+		log.Warnf("not clamping MSS: %v", err)
+		return
+	}
+	if err != nil {
+		log.Errorf("failed clamping MSS to %d: %v", e.config.MSSClamp, err)
+		return
+	}
+	e.mssClamped = true
+	log.Infof("clamped MSS of interface %s to %d", e.config.WgIfaceName, e.config.MSSClamp)
+}
+
+// removeMSSClamp removes the MSS clamp of the Wireguard interface if it has been set
+func (e *Engine) removeMSSClamp() {
+	if !e.mssClamped {
+		return
+	}
+	err := e.wgInterface.RemoveMSSClamp()
+	if err != nil {
+		log.Warnf("failed removing MSS clamp: %v", err)
+	}
+	e.mssClamped = false
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgm "github.com/netbirdio/netbird/management/client"
+)
+
+var (
+	clientCertsMux sync.Mutex
+	// clientCerts are the client certificates read by the process by their files, each is read once and then watched
+	// for the rotation, so all the connections present the same certificate
+	clientCerts = make(map[string]*encryption.CertificateReloader)
+)
+
+// ClientCertificate returns the client certificate of the config presented to the Management and the Signal services
+// requiring mutual TLS, nil if it isn't set. The certificate is read again once its files change or the process
+// receives SIGHUP
+func (c *Config) ClientCertificate() (*encryption.CertificateReloader, error) {
+	var id string
+	switch {
+	case c.ClientPKCS12File != "":
+		id = strings.Join([]string{"pkcs12", c.ClientPKCS12File, c.ClientPKCS12Password}, "\x00")
+	case c.ClientCertFile != "":
+		id = strings.Join([]string{"pem", c.ClientCertFile, c.ClientKeyFile}, "\x00")
+	default:
+		return nil, nil
+	}
+
+	clientCertsMux.Lock()
+	defer clientCertsMux.Unlock()
+
+	if cert, ok := clientCerts[id]; ok {
+		return cert, nil
+	}
+
+	var cert *encryption.CertificateReloader
+	var err error
+	if c.ClientPKCS12File != "" {
+		cert, err = encryption.NewPKCS12Reloader(c.ClientPKCS12File, c.ClientPKCS12Password)
+	} else {
+		cert, err = encryption.NewKeyPairReloader(c.ClientCertFile, c.ClientKeyFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the certificate is shared by the connections of the process, so it is watched for as long as the process runs
+	err = cert.Watch(context.Background())
+	if err != nil {
+		log.Warnf("client certificate changes won't be noticed, send SIGHUP after updating it: %v", err)
+	}
+	clientCerts[id] = cert
+	return cert, nil
+}
+
+// serviceTLSConfig returns the TLS config of a connection to the Management or the Signal service, nil if TLS is
+// disabled. The client certificate is presented to the services requiring mutual TLS if it isn't nil
+func serviceTLSConfig(tlsEnabled bool, clientCert *encryption.CertificateReloader) *tls.Config {
+	if !tlsEnabled {
+		return nil
+	}
+	// the server name (SNI) is taken from the address
+	tlsConfig := &tls.Config{}
+	if clientCert != nil {
+		tlsConfig.GetClientCertificate = clientCert.GetClientCertificate
+	}
+	return tlsConfig
+}
+
+// newManagementClient connects to the Management Service of the config presenting the client certificate of the config
+// if it is set
+func newManagementClient(ctx context.Context, config *Config, key wgtypes.Key) (*mgm.GrpcClient, error) {
+	return newManagementClientTo(ctx, config, config.ManagementURL, key)
+}
+
+// newManagementClientTo connects to one of the Management Services of the config (see managementURLs) presenting
+// the client certificate of the config if it is set
+func newManagementClientTo(ctx context.Context, config *Config, managementURL *url.URL, key wgtypes.Key) (*mgm.GrpcClient, error) {
+	clientCert, err := config.ClientCertificate()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := serviceTLSConfig(managementURL.Scheme == "https", clientCert)
+	return mgm.NewClientWithTLS(ctx, managementURL.Host, key, tlsConfig)
+}
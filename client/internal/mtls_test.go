@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netbirdio/netbird/testutil"
+)
+
+func TestConfig_ClientCertificate(t *testing.T) {
+	config := &Config{}
+	clientCert, err := config.ClientCertificate()
+	require.NoError(t, err)
+	assert.Nil(t, clientCert, "expecting no client certificate if it isn't set")
+	assert.Nil(t, serviceTLSConfig(false, clientCert), "expecting no TLS config if TLS is disabled")
+	assert.Nil(t, serviceTLSConfig(true, clientCert).GetClientCertificate)
+
+	ca, err := testutil.NewTestCA(t.TempDir())
+	require.NoError(t, err)
+	config.ClientCertFile, config.ClientKeyFile, err = ca.IssueClientCert("peer")
+	require.NoError(t, err)
+
+	clientCert, err = config.ClientCertificate()
+	require.NoError(t, err)
+	require.NotNil(t, clientCert)
+
+	again, err := (&Config{ClientCertFile: config.ClientCertFile, ClientKeyFile: config.ClientKeyFile}).ClientCertificate()
+	require.NoError(t, err)
+	assert.Same(t, clientCert, again, "expecting the certificate to be read once per process")
+
+	tlsConfig := serviceTLSConfig(true, clientCert)
+	require.NotNil(t, tlsConfig.GetClientCertificate)
+	cert, err := tlsConfig.GetClientCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, clientCert.Certificate(), cert)
+
+	_, err = (&Config{ClientCertFile: config.ClientCertFile, ClientKeyFile: config.ClientCertFile}).ClientCertificate()
+	assert.Error(t, err, "expecting a certificate without its private key to fail")
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"time"
+)
+
+// networkChangeDebounce is a period the network changes are collected for before the connections are re-established.
+// A switch of the network comes with a burst of link, address and route changes
+const networkChangeDebounce = 2 * time.Second
+
+// watchNetworkChanges re-establishes the connections once the operating system reports a change of the network
+func (e *Engine) watchNetworkChanges() {
+	changes, err := subscribeNetworkChanges(e.ctx, e.config.WgIfaceName)
+	if err != nil {
+		log.Warnf("failed watching the network changes, broken connections will be detected by the keepalive: %v", err)
+		return
+	}
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case _, ok := <-changes:
+				if !ok {
+					log.Debugf("stopped watching the network changes")
+					return
+				}
+				debounce = time.After(networkChangeDebounce)
+			case <-debounce:
+				debounce = nil
+				e.NotifyNetworkChange()
+			}
+		}
+	}()
+}
+
+// NotifyNetworkChange reconnects to the Management and the Signal Service and closes the connections to the remote
+// peers, so the connection workers re-establish them with the candidates of the changed network.
+// The Engine calls it on the network changes it detects, platforms delivering the network change events
+// themselves (e.g. mobile) call it directly
+func (e *Engine) NotifyNetworkChange() {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	log.Infof("network has changed, reconnecting to the Management Service, the Signal Service and %d peers", len(e.peerConns))
+
+	e.managementClient().Reconnect()
+	e.signal.Reconnect()
+
+	for peerKey, conn := range e.peerConns {
+		err := conn.Close()
+		if err != nil {
+			log.Debugf("failed closing connection to peer %s on the network change: %v", peerKey, err)
+		}
+	}
+}
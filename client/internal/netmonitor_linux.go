@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"context"
+	"net"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// subscribeNetworkChanges reports the link state, address and default route changes of the network interfaces until
+// the context is done. The changes of the ignored interface (the Engine's Wireguard interface) and the other
+// tunnel interfaces are skipped
+func subscribeNetworkChanges(ctx context.Context, ignoredIface string) (<-chan struct{}, error) {
+	done := make(chan struct{})
+	links := make(chan netlink.LinkUpdate)
+	addrs := make(chan netlink.AddrUpdate)
+	routes := make(chan netlink.RouteUpdate)
+
+	err := netlink.LinkSubscribe(links, done)
+	if err != nil {
+		close(done)
+		return nil, err
+	}
+	err = netlink.AddrSubscribe(addrs, done)
+	if err != nil {
+		close(done)
+		return nil, err
+	}
+	err = netlink.RouteSubscribe(routes, done)
+	if err != nil {
+		close(done)
+		return nil, err
+	}
+
+	// linksUp holds whether the links are up to skip the updates that don't change the state
+	linksUp := make(map[int]bool)
+	ignoredLinks := make(map[int]bool)
+	if existing, err := netlink.LinkList(); err == nil {
+		for _, link := range existing {
+			linksUp[link.Attrs().Index] = isLinkUp(link)
+			ignoredLinks[link.Attrs().Index] = isIgnoredLink(link, ignoredIface)
+		}
+	}
+
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(changes)
+		defer close(done)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case update, ok := <-links:
+				if !ok {
+					return
+				}
+				attrs := update.Attrs()
+				ignoredLinks[attrs.Index] = isIgnoredLink(update.Link, ignoredIface)
+				if ignoredLinks[attrs.Index] {
+					continue
+				}
+				if update.Header.Type == unix.RTM_DELLINK {
+					delete(linksUp, attrs.Index)
+					delete(ignoredLinks, attrs.Index)
+					notify()
+					continue
+				}
+				up := isLinkUp(update.Link)
+				if wasUp, known := linksUp[attrs.Index]; !known || wasUp != up {
+					linksUp[attrs.Index] = up
+					notify()
+				}
+			case update, ok := <-addrs:
+				if !ok {
+					return
+				}
+				ip := update.LinkAddress.IP
+				if ignoredLinks[update.LinkIndex] || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+					continue
+				}
+				notify()
+			case update, ok := <-routes:
+				if !ok {
+					return
+				}
+				if ignoredLinks[update.LinkIndex] || !isDefaultRoute(update.Route) {
+					continue
+				}
+				notify()
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// isIgnoredLink checks whether the changes of a link are skipped: the loopback and the tunnel links
+// (e.g. the Wireguard interfaces of this and other Engines)
+func isIgnoredLink(link netlink.Link, ignoredIface string) bool {
+	attrs := link.Attrs()
+	if attrs.Name == ignoredIface || attrs.Flags&net.FlagLoopback != 0 {
+		return true
+	}
+	switch link.Type() {
+	case "wireguard", "tuntap":
+		return true
+	}
+	return false
+}
+
+// isLinkUp checks whether a link is administratively and operationally up
+func isLinkUp(link netlink.Link) bool {
+	attrs := link.Attrs()
+	return attrs.Flags&net.FlagUp != 0 && attrs.OperState != netlink.OperDown
+}
+
+// isDefaultRoute checks whether a route is an IPv4 or IPv6 default route
+func isDefaultRoute(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
+}
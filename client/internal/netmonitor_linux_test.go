@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestSubscribeNetworkChanges(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := subscribeNetworkChanges(ctx, "wtbridge1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addBridgeLink := func(name string, addr string) {
+		link := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}
+		err := netlink.LinkAdd(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			netlink.LinkDel(link) //nolint
+		})
+		ip, err := netlink.ParseAddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = netlink.AddrAdd(link, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = netlink.LinkSetUp(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addBridgeLink("wtbridge1", "10.99.1.1/24")
+	select {
+	case <-changes:
+		t.Fatal("expecting the changes of the ignored interface to be skipped")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	addBridgeLink("wtbridge0", "10.99.0.1/24")
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting a new interface with an address to be reported as a network change")
+	}
+}
@@ -0,0 +1,80 @@
+//go:build !linux
+// +build !linux
+
+package internal
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// networkCheckInterval is an interval of checking whether the addresses of the network interfaces have changed
+// on the platforms without the network change events
+const networkCheckInterval = 3 * time.Second
+
+// subscribeNetworkChanges reports the changes of the network interfaces that are up and their addresses until
+// the context is done. The ignored interface (the Engine's Wireguard interface) and the loopback are skipped
+func subscribeNetworkChanges(ctx context.Context, ignoredIface string) (<-chan struct{}, error) {
+	last, err := networkFingerprint(ignoredIface)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+
+		ticker := time.NewTicker(networkCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := networkFingerprint(ignoredIface)
+				if err != nil {
+					log.Debugf("failed checking the network interfaces: %v", err)
+					continue
+				}
+				if current == last {
+					continue
+				}
+				last = current
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changes, nil
+}
+
+// networkFingerprint lists the addresses of the network interfaces that are up
+func networkFingerprint(ignoredIface string) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+
+	var addresses []string
+	for _, iface := range ifaces {
+		if iface.Name == ignoredIface || iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			addresses = append(addresses, iface.Name+"="+addr.String())
+		}
+	}
+	sort.Strings(addresses)
+
+	return strings.Join(addresses, ","), nil
+}
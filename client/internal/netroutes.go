@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/netbirdio/netbird/iface"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+var (
+	// networkRouteCheckInterval is an interval of reconciling the routing peers of the networks with their connections,
+	// a network is moved to another routing peer within it once its routing peer has disconnected
+	networkRouteCheckInterval = time.Second
+	// networkRouteHoldDown is how long a more preferred routing peer has to stay connected before a network is moved
+	// back to it, so that a flapping routing peer doesn't move the network back and forth
+	networkRouteHoldDown = 30 * time.Second
+)
+
+// networkRoute is a network behind the routing peers sent by the Management Service
+type networkRoute struct {
+	// candidates are the routes to the network through the routing peers ordered by the metric, the most preferred first
+	candidates []*mgmProto.Route
+	// active is the key of the routing peer the network is routed through, empty if none of them is connected
+	active string
+	// connectedSince is the time each routing peer has been connected continuously since
+	connectedSince map[string]time.Time
+}
+
+// candidate returns the route to the network through the routing peer, nil if the peer doesn't route the network
+func (r *networkRoute) candidate(peerKey string) *mgmProto.Route {
+	for _, route := range r.candidates {
+		if route.GetPeer() == peerKey {
+			return route
+		}
+	}
+	return nil
+}
+
+// updateNetworkRoutes records the routes of a NetworkMap update and moves the networks to the routing peers.
+// The networks no longer routed are removed. The caller holds the lock
+func (e *Engine) updateNetworkRoutes(routes []*mgmProto.Route) {
+	candidates := map[string][]*mgmProto.Route{}
+	for _, route := range routes {
+		_, network, err := net.ParseCIDR(route.GetPrefix())
+		if err != nil {
+			log.Warnf("ignoring route %s with invalid prefix %s: %v", route.GetID(), route.GetPrefix(), err)
+			continue
+		}
+		candidates[network.String()] = append(candidates[network.String()], route)
+	}
+
+	for network, route := range e.networkRoutes {
+		if _, ok := candidates[network]; !ok {
+			e.unassignNetworkRoute(network, route)
+			delete(e.networkRoutes, network)
+			log.Infof("network %s is no longer routed", network)
+		}
+	}
+
+	for network, routes := range candidates {
+		sort.SliceStable(routes, func(i, j int) bool {
+			return routes[i].GetMetric() < routes[j].GetMetric()
+		})
+		route, ok := e.networkRoutes[network]
+		if !ok {
+			route = &networkRoute{connectedSince: map[string]time.Time{}}
+			e.networkRoutes[network] = route
+		}
+		route.candidates = routes
+		if route.active != "" && route.candidate(route.active) == nil {
+			log.Infof("peer %s no longer routes network %s", route.active, network)
+			e.unassignNetworkRoute(network, route)
+		}
+	}
+
+	e.selectNetworkRoutes()
+}
+
+// watchNetworkRoutes periodically moves the networks to the connected routing peers
+func (e *Engine) watchNetworkRoutes() {
+	go func() {
+		ticker := time.NewTicker(networkRouteCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				e.syncMsgMux.Lock()
+				// the Engine has been stopped meanwhile
+				if e.ctx.Err() == nil {
+					e.selectNetworkRoutes()
+					e.publishNetworkStatus()
+				}
+				e.syncMsgMux.Unlock()
+			}
+		}
+	}()
+}
+
+// selectNetworkRoutes routes each network through the most preferred connected routing peer. A network is moved
+// at once when its routing peer has disconnected, but it is moved to a more preferred routing peer only once the peer
+// has stayed connected for networkRouteHoldDown. The caller holds the lock
+func (e *Engine) selectNetworkRoutes() {
+	now := time.Now()
+	for network, route := range e.networkRoutes {
+		var best *mgmProto.Route
+		for _, candidate := range route.candidates {
+			peerKey := candidate.GetPeer()
+			if !e.peerRouted(peerKey) {
+				delete(route.connectedSince, peerKey)
+				continue
+			}
+			if _, ok := route.connectedSince[peerKey]; !ok {
+				route.connectedSince[peerKey] = now
+			}
+			if best == nil {
+				best = candidate
+			}
+		}
+
+		switch {
+		case best == nil:
+			if route.active != "" {
+				log.Warnf("no routing peer of network %s is connected", network)
+				e.unassignNetworkRoute(network, route)
+			}
+		case route.active == best.GetPeer():
+		case route.active == "":
+			e.assignNetworkRoute(network, route, best.GetPeer())
+		case !e.peerRouted(route.active):
+			log.Warnf("routing peer %s of network %s is disconnected, failing over to peer %s", route.active, network,
+				best.GetPeer())
+			e.assignNetworkRoute(network, route, best.GetPeer())
+		case best.GetMetric() < route.candidate(route.active).GetMetric() &&
+			now.Sub(route.connectedSince[best.GetPeer()]) >= networkRouteHoldDown:
+			log.Infof("preferred routing peer %s of network %s has been connected for %s, moving the network from peer %s",
+				best.GetPeer(), network, networkRouteHoldDown, route.active)
+			e.assignNetworkRoute(network, route, best.GetPeer())
+		}
+	}
+}
+
+// assignNetworkRoute routes the network through the routing peer. The network is added to the allowed IPs of the routing
+// peer first, so that Wireguard moves it from the previous one at once. The caller holds the lock
+func (e *Engine) assignNetworkRoute(network string, route *networkRoute, peerKey string) {
+	conn, ok := e.peerConns[peerKey]
+	if !ok {
+		return
+	}
+	conn.SetRoutedNetworks(append(conn.GetRoutedNetworks(), network))
+	err := e.wgInterface.SetAllowedIPs(peerKey, conn.GetWgAllowedIPs())
+	if err != nil {
+		log.Errorf("failed routing network %s through peer %s: %v", network, peerKey, err)
+	}
+
+	previous := route.active
+	route.active = peerKey
+	if previous != "" {
+		e.releaseNetworkRoute(network, previous)
+	} else {
+		err = e.wgInterface.AddNetworkRoute(network)
+		if err != nil {
+			log.Warnf("failed adding route to network %s: %v", network, err)
+		}
+	}
+	log.Infof("routing network %s through peer %s", network, peerKey)
+}
+
+// unassignNetworkRoute stops routing the network through the interface. The caller holds the lock
+func (e *Engine) unassignNetworkRoute(network string, route *networkRoute) {
+	if route.active == "" {
+		return
+	}
+	e.releaseNetworkRoute(network, route.active)
+	route.active = ""
+
+	err := e.wgInterface.RemoveNetworkRoute(network)
+	if err != nil && !errors.Is(err, iface.ErrNetworkRoutesNotSupported) {
+		log.Warnf("failed removing route to network %s: %v", network, err)
+	}
+}
+
+// releaseNetworkRoute removes the network from the allowed IPs of the routing peer. The caller holds the lock
+func (e *Engine) releaseNetworkRoute(network string, peerKey string) {
+	conn, ok := e.peerConns[peerKey]
+	if !ok {
+		return
+	}
+
+	var networks []string
+	for _, routed := range conn.GetRoutedNetworks() {
+		if routed != network {
+			networks = append(networks, routed)
+		}
+	}
+	conn.SetRoutedNetworks(networks)
+
+	err := e.wgInterface.SetAllowedIPs(peerKey, conn.GetWgAllowedIPs())
+	if err != nil {
+		log.Warnf("failed removing network %s from the allowed IPs of peer %s: %v", network, peerKey, err)
+	}
+}
+
+// restoreNetworkRoutes adds the routes of the networks routed through the interface again, e.g. after the interface
+// has been readdressed. The caller holds the lock
+func (e *Engine) restoreNetworkRoutes() {
+	for network, route := range e.networkRoutes {
+		if route.active == "" {
+			continue
+		}
+		err := e.wgInterface.AddNetworkRoute(network)
+		if err != nil && !errors.Is(err, iface.ErrNetworkRoutesNotSupported) {
+			log.Warnf("failed restoring route to network %s: %v", network, err)
+		}
+	}
+}
+
+// removeNetworkRoutesOf stops routing the networks through a removed routing peer, the networks are moved to another
+// routing peer by the next selection. The caller holds the lock
+func (e *Engine) removeNetworkRoutesOf(peerKey string) {
+	for network, route := range e.networkRoutes {
+		if route.active == peerKey {
+			e.unassignNetworkRoute(network, route)
+		}
+	}
+}
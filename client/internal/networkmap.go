@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// NetworkMapDiff is the change of the remote peers a NetworkMap update applies, the keys are sorted
+type NetworkMapDiff struct {
+	// Added are the remote peers connected to and Removed the ones disconnected from
+	Added   []string
+	Removed []string
+	// Updated are the remote peers reconnected because their Wireguard port, allowed IPs or static endpoint have changed
+	Updated []string
+}
+
+// networkMapDiff compares the remote peers of a NetworkMap update with the peer connections. The caller holds the lock
+func (e *Engine) networkMapDiff(peersUpdate []*mgmProto.RemotePeerConfig) NetworkMapDiff {
+	diff := NetworkMapDiff{}
+	updateKeys := make(map[string]struct{}, len(peersUpdate))
+	for _, p := range peersUpdate {
+		peerKey := p.GetWgPubKey()
+		updateKeys[peerKey] = struct{}{}
+
+		conn, ok := e.peerConns[peerKey]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, peerKey)
+		case e.peerConfigChanged(conn, p):
+			diff.Updated = append(diff.Updated, peerKey)
+		}
+	}
+
+	for peerKey := range e.peerConns {
+		if _, ok := updateKeys[peerKey]; !ok {
+			diff.Removed = append(diff.Removed, peerKey)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Updated)
+	return diff
+}
+
+// reconcilePeers brings the peer connections in line with the remote peers of a NetworkMap update applying its diff.
+// It removes the peers missing in the update and the peers which Wireguard port, allowed IPs or static endpoint
+// have changed, and connects the new and the changed ones. The unchanged peers are skipped.
+// Returns true if the allowed IPs of the connections have changed
+func (e *Engine) reconcilePeers(peersUpdate []*mgmProto.RemotePeerConfig, diff NetworkMapDiff) (bool, error) {
+	sourcesChanged := len(diff.Added) > 0
+	for _, peerKey := range diff.Removed {
+		err := e.removePeer(peerKey)
+		if err != nil {
+			return sourcesChanged, wrapError(ErrInterface, err)
+		}
+		sourcesChanged = true
+		log.Infof("removed peer %s", peerKey)
+	}
+
+	if len(diff.Added) == 0 && len(diff.Updated) == 0 {
+		return sourcesChanged, nil
+	}
+
+	reconnected := make(map[string]struct{}, len(diff.Added)+len(diff.Updated))
+	for _, peerKey := range diff.Added {
+		reconnected[peerKey] = struct{}{}
+	}
+	for _, peerKey := range diff.Updated {
+		reconnected[peerKey] = struct{}{}
+	}
+
+	var toAdd []*mgmProto.RemotePeerConfig
+	for _, p := range peersUpdate {
+		peerKey := p.GetWgPubKey()
+		if _, ok := reconnected[peerKey]; !ok {
+			continue
+		}
+
+		if conn, ok := e.peerConns[peerKey]; ok {
+			if !allowedIPsEqual(conn.GetAllowedIPs(), p.GetAllowedIps()) {
+				sourcesChanged = true
+			}
+			err := e.removePeer(peerKey)
+			if err != nil {
+				return sourcesChanged, wrapError(ErrInterface, err)
+			}
+		}
+		toAdd = append(toAdd, p)
+	}
+
+	return sourcesChanged, e.addNewPeers(toAdd)
+}
+
+// peerConfigChanged returns true if the Wireguard port (e.g. picked at random after a restart), the allowed IPs
+// (e.g. a fixed IP assigned by the account admin) or the static endpoint of a connected remote peer differ
+// from the update, so the peer has to be reconnected
+func (e *Engine) peerConfigChanged(conn *peer.Conn, p *mgmProto.RemotePeerConfig) bool {
+	peerKey := p.GetWgPubKey()
+	if conn.GetRemoteWgPort() != int(p.GetWgPort()) {
+		log.Infof("Wireguard port of peer %s has changed from %d to %d, reconnecting", peerKey, conn.GetRemoteWgPort(), p.GetWgPort())
+		return true
+	}
+
+	if !allowedIPsEqual(conn.GetAllowedIPs(), p.GetAllowedIps()) {
+		log.Infof("allowed IPs of peer %s have changed from %s to %s, reconnecting", peerKey, conn.GetAllowedIPs(),
+			strings.Join(p.GetAllowedIps(), ","))
+		return true
+	}
+
+	return e.staticEndpointChanged(peerKey, p.GetStaticEndpoint())
+}
+
+// allowedIPsEqual compares the comma separated allowed IPs of a connection with the allowed IPs of an update
+// without joining them
+func allowedIPsEqual(joined string, allowedIPs []string) bool {
+	for i, allowedIP := range allowedIPs {
+		if i > 0 {
+			if !strings.HasPrefix(joined, ",") {
+				return false
+			}
+			joined = joined[1:]
+		}
+		if !strings.HasPrefix(joined, allowedIP) {
+			return false
+		}
+		joined = joined[len(allowedIP):]
+	}
+	return joined == ""
+}
+
+// peerNamesUnchanged returns true if the friendly names of the remote peers of the NetworkMap are the recorded ones
+func (e *Engine) peerNamesUnchanged(networkMap *mgmProto.NetworkMap) bool {
+	if e.peerNames == nil {
+		return false
+	}
+
+	var remotePeers []*mgmProto.RemotePeerConfig
+	if !networkMap.GetRemotePeersIsEmpty() {
+		remotePeers = networkMap.GetRemotePeers()
+	}
+	if len(remotePeers) != len(e.peerNames) {
+		return false
+	}
+	for _, p := range remotePeers {
+		name, ok := e.peerNames[p.GetWgPubKey()]
+		if !ok || name != p.GetName() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedIPsEqual(t *testing.T) {
+	testCases := []struct {
+		joined     string
+		allowedIPs []string
+		expected   bool
+	}{
+		{"100.64.0.10/32", []string{"100.64.0.10/32"}, true},
+		{"100.64.0.10/32,10.0.0.0/24", []string{"100.64.0.10/32", "10.0.0.0/24"}, true},
+		{"", nil, true},
+		{"100.64.0.10/32", []string{"100.64.0.1/32"}, false},
+		{"100.64.0.10/32", []string{"100.64.0.10/3"}, false},
+		{"100.64.0.10/32,10.0.0.0/24", []string{"100.64.0.10/32"}, false},
+		{"100.64.0.10/32", []string{"100.64.0.10/32", "10.0.0.0/24"}, false},
+		{"100.64.0.10/32", nil, false},
+	}
+
+	for _, c := range testCases {
+		assert.Equal(t, c.expected, allowedIPsEqual(c.joined, c.allowedIPs), "%s and %v", c.joined, c.allowedIPs)
+	}
+}
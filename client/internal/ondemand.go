@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"net"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+)
+
+const (
+	// DefaultIdleTimeout is the time without traffic after which a connection to a remote peer is torn down
+	// in the on-demand mode
+	DefaultIdleTimeout = 5 * time.Minute
+	// idleCheckInterval is an interval of the idle connections check in the on-demand mode
+	idleCheckInterval = 30 * time.Second
+	// idleTrafficThreshold is the number of bytes per idleCheckInterval below which a connection is considered idle.
+	// Wireguard keepalives and handshakes don't exceed it
+	idleTrafficThreshold = 1024
+)
+
+// activityListener is a local UDP socket configured as the Wireguard endpoint of a dormant remote peer.
+// Wireguard sends a handshake initiation to it as soon as there is traffic to the peer, which wakes the peer up
+type activityListener struct {
+	conn *net.UDPConn
+	done chan struct{}
+}
+
+// newActivityListener listens on a random local port until the first packet is received or the listener is closed
+func newActivityListener() (*activityListener, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &activityListener{
+		conn: conn,
+		done: make(chan struct{}),
+	}
+	go l.listen()
+	return l, nil
+}
+
+func (l *activityListener) listen() {
+	defer close(l.done)
+
+	buf := make([]byte, 1500)
+	_, _, err := l.conn.ReadFrom(buf)
+	if err == nil {
+		_ = l.conn.Close()
+	}
+}
+
+// Addr returns the address to configure as the Wireguard endpoint of the dormant peer
+func (l *activityListener) Addr() *net.UDPAddr {
+	return l.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Done is closed once there is traffic to the peer or the listener has been closed
+func (l *activityListener) Done() <-chan struct{} {
+	return l.done
+}
+
+// Close stops listening, e.g. to wake the peer up or when the peer has been removed
+func (l *activityListener) Close() error {
+	return l.conn.Close()
+}
+
+// idleTracker tracks the traffic of the connected remote peers to find the idle ones
+type idleTracker struct {
+	timeout time.Duration
+	peers   map[string]*peerTraffic
+}
+
+type peerTraffic struct {
+	bytes int64
+	// activeAt is the last time the traffic exceeded idleTrafficThreshold
+	activeAt time.Time
+}
+
+func newIdleTracker(timeout time.Duration) *idleTracker {
+	return &idleTracker{
+		timeout: timeout,
+		peers:   make(map[string]*peerTraffic),
+	}
+}
+
+// Update records the total traffic of the peer and returns true if the peer has been idle for the timeout
+func (t *idleTracker) Update(peerKey string, bytes int64, now time.Time) bool {
+	traffic, ok := t.peers[peerKey]
+	// the counters are reset when the Wireguard peer is re-created
+	if !ok || bytes < traffic.bytes || bytes-traffic.bytes > idleTrafficThreshold {
+		t.peers[peerKey] = &peerTraffic{bytes: bytes, activeAt: now}
+		return false
+	}
+
+	traffic.bytes = bytes
+	return now.Sub(traffic.activeAt) >= t.timeout
+}
+
+// Remove forgets the peer, e.g. when it has been disconnected
+func (t *idleTracker) Remove(peerKey string) {
+	delete(t.peers, peerKey)
+}
+
+// waitPeerActivity keeps the remote peer dormant until there is traffic to it or it sends a connection offer.
+// Returns false if the peer has been removed meanwhile
+func (e *Engine) waitPeerActivity(conn *peer.Conn, peerKey string) bool {
+	listener, err := e.makePeerDormant(conn, peerKey)
+	if err != nil {
+		log.Warnf("failed making peer %s dormant, connecting right away: %v", peerKey, err)
+		return e.peerExists(peerKey)
+	}
+	if listener == nil {
+		return false
+	}
+
+	<-listener.Done()
+
+	e.peersMux.Lock()
+	defer e.peersMux.Unlock()
+
+	if e.dormantPeers[peerKey] == listener {
+		delete(e.dormantPeers, peerKey)
+	}
+	_, exists := e.peerConns[peerKey]
+	if exists {
+		log.Debugf("waking up dormant peer %s", peerKey)
+	}
+	return exists
+}
+
+// makePeerDormant points the Wireguard peer to an activityListener without keepalive and marks the peer idle.
+// Returns nil if the peer has been removed
+func (e *Engine) makePeerDormant(conn *peer.Conn, peerKey string) (*activityListener, error) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if _, ok := e.peerConns[peerKey]; !ok {
+		return nil, nil
+	}
+
+	listener, err := newActivityListener()
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.wgInterface.UpdatePeer(peerKey, conn.GetWgAllowedIPs(), 0, listener.Addr(), e.config.PreSharedKey)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	conn.SetIdle()
+	e.peersMux.Lock()
+	e.dormantPeers[peerKey] = listener
+	e.peersMux.Unlock()
+	log.Debugf("peer %s is dormant until there is traffic to it", peerKey)
+
+	return listener, nil
+}
+
+// wakePeer wakes a dormant remote peer up, e.g. when it offers a connection. Returns false if the peer isn't dormant
+func (e *Engine) wakePeer(peerKey string) bool {
+	e.peersMux.Lock()
+	listener, ok := e.dormantPeers[peerKey]
+	delete(e.dormantPeers, peerKey)
+	e.peersMux.Unlock()
+	if !ok {
+		return false
+	}
+
+	err := listener.Close()
+	if err != nil {
+		log.Debugf("failed closing activity listener of peer %s: %v", peerKey, err)
+	}
+	return true
+}
+
+// removeDormantPeer removes the Wireguard peer of a dormant remote peer, which isn't owned by its Conn
+func (e *Engine) removeDormantPeer(peerKey string) {
+	if !e.wakePeer(peerKey) {
+		return
+	}
+
+	err := e.wgInterface.RemovePeer(peerKey)
+	if err != nil {
+		log.Warnf("failed removing dormant peer %s: %v", peerKey, err)
+	}
+}
+
+// watchIdlePeers periodically tears down the connections to the remote peers without traffic for the idle timeout.
+// The peers become dormant again until there is traffic to them
+func (e *Engine) watchIdlePeers() {
+	timeout := e.config.IdleTimeout
+	if timeout <= 0 {
+		timeout = DefaultIdleTimeout
+	}
+	tracker := newIdleTracker(timeout)
+
+	go func() {
+		ticker := time.NewTicker(idleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-ticker.C:
+				e.closeIdlePeers(tracker)
+			}
+		}
+	}()
+}
+
+// closeIdlePeers closes the connections to the remote peers that have been idle for the timeout of the tracker
+func (e *Engine) closeIdlePeers(tracker *idleTracker) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	now := time.Now()
+	for peerKey := range tracker.peers {
+		if conn, ok := e.peerConns[peerKey]; !ok || conn.Status() != peer.StatusConnected {
+			tracker.Remove(peerKey)
+		}
+	}
+
+	for peerKey, conn := range e.peerConns {
+		if conn.Status() != peer.StatusConnected {
+			continue
+		}
+
+		stats, err := e.wgStats.PeerStats(peerKey)
+		if err != nil {
+			log.Debugf("failed getting traffic of peer %s: %v", peerKey, err)
+			continue
+		}
+
+		if !tracker.Update(peerKey, stats.RxBytes+stats.TxBytes, now) {
+			continue
+		}
+
+		log.Infof("closing connection to peer %s idle for %s", peerKey, tracker.timeout)
+		tracker.Remove(peerKey)
+		err = conn.Close()
+		if err != nil {
+			log.Debugf("failed closing idle connection to peer %s: %v", peerKey, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityListener(t *testing.T) {
+	listener, err := newActivityListener()
+	require.NoError(t, err)
+	defer listener.Close() //nolint
+
+	select {
+	case <-listener.Done():
+		t.Fatal("expecting the listener to wait for the traffic")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Wireguard sends a handshake initiation once there is traffic to the dormant peer
+	conn, err := net.DialUDP("udp", nil, listener.Addr())
+	require.NoError(t, err)
+	defer conn.Close() //nolint
+	_, err = conn.Write([]byte("handshake initiation"))
+	require.NoError(t, err)
+
+	select {
+	case <-listener.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting the traffic to wake the peer up")
+	}
+}
+
+func TestActivityListener_Close(t *testing.T) {
+	listener, err := newActivityListener()
+	require.NoError(t, err)
+
+	require.NoError(t, listener.Close())
+
+	select {
+	case <-listener.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting the closed listener to be done")
+	}
+}
+
+func TestIdleTracker(t *testing.T) {
+	tracker := newIdleTracker(5 * time.Minute)
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.False(t, tracker.Update("peer", 10000, start), "a new peer isn't idle")
+	// keepalives and handshakes don't count as traffic
+	assert.False(t, tracker.Update("peer", 10200, start.Add(2*time.Minute)))
+	assert.False(t, tracker.Update("peer", 10400, start.Add(4*time.Minute)))
+	assert.True(t, tracker.Update("peer", 10600, start.Add(5*time.Minute)), "expecting the peer to be idle for the timeout")
+
+	assert.False(t, tracker.Update("peer", 20000, start.Add(6*time.Minute)), "the traffic makes the peer active")
+	assert.False(t, tracker.Update("peer", 20000, start.Add(10*time.Minute)))
+	assert.True(t, tracker.Update("peer", 20000, start.Add(11*time.Minute)))
+
+	// the counters start over when the Wireguard peer is re-created
+	assert.False(t, tracker.Update("peer", 100, start.Add(12*time.Minute)))
+
+	tracker.Remove("peer")
+	assert.False(t, tracker.Update("peer", 100, start.Add(20*time.Minute)), "a removed peer is tracked anew")
+}
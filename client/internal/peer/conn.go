@@ -2,17 +2,26 @@ package peer
 
 import (
 	"context"
-	"github.com/netbirdio/netbird/iface"
+	"errors"
+	"fmt"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/netbirdio/netbird/client/internal/proxy"
+	"github.com/netbirdio/netbird/iface"
 	"github.com/pion/ice/v2"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 )
 
+// DefaultAttemptTimeout limits a connection attempt to a remote peer if no other timeout is configured
+const DefaultAttemptTimeout = 45 * time.Second
+
+// allCandidateTypes are the types of the local candidates gathered to establish a connection
+var allCandidateTypes = []ice.CandidateType{ice.CandidateTypeHost, ice.CandidateTypeServerReflexive, ice.CandidateTypeRelay}
+
 // ConnConfig is a peer Connection configuration
 type ConnConfig struct {
 
@@ -29,17 +38,67 @@ type ConnConfig struct {
 	InterfaceBlackList []string
 
 	Timeout time.Duration
+	// AttemptTimeout limits a connection attempt from the offer to the established connection, the attempt is aborted
+	// and classified once it has passed. DefaultAttemptTimeout if not set
+	AttemptTimeout time.Duration
 
 	ProxyConfig proxy.Config
 
 	UDPMux      ice.UDPMux
 	UDPMuxSrflx ice.UniversalUDPMux
+
+	// IPFamilyPreference orders the ICE candidate gathering and the STUN and TURN servers by the IP family
+	IPFamilyPreference IPFamily
+
+	// CandidateHarvester gathers the local candidates, the default one is used if not set
+	CandidateHarvester CandidateHarvester
+
+	// EnableTCPFallback gathers relay candidates over TCP and TLS too, so the connection survives networks blocking UDP
+	EnableTCPFallback bool
+
+	// IceLite runs an ICE-lite agent gathering the host candidates only, the remote full agent takes the controlling role.
+	// Suitable for peers with a public, unfirewalled address
+	IceLite bool
+
+	// EnableSignalRelay relays the connection through the Signal Service as a last resort when ICE fails
+	// SignalRelayAttempts times in a row, e.g. when neither a direct connection nor a TURN relay works
+	EnableSignalRelay bool
+	// SignalRelayLimitKbps caps the bandwidth of the connection relayed through the Signal Service,
+	// DefaultSignalRelayLimitKbps if not set
+	SignalRelayLimitKbps int
+
+	// StagedICE negotiates the connection in stages instead of over all the candidates at once: over the host
+	// candidates first, then the server-reflexive ones and the relay ones last, escalating only when ICE has failed.
+	// It avoids the TURN relay when a direct connection works and bounds the time to fall back to the relay.
+	// The remote peers should run the staged mode too
+	StagedICE bool
+	// StageTimeouts limit the stages of the staged connection attempts
+	StageTimeouts StageTimeouts
+
+	// Trace records the timeline of each connection attempt, see Conn.Trace
+	Trace bool
+
+	// DialLimiter limits the connection attempts negotiating at the same time, shared by the connections of the Engine.
+	// Not limited if not set
+	DialLimiter *DialLimiter
+
+	// WgStats returns the Wireguard statistics of a remote peer shared with the other pollers of the Engine,
+	// read from the interface of the ProxyConfig if not set
+	WgStats func(peerKey string) (*iface.PeerStats, error)
 }
 
 // IceCredentials ICE protocol credentials struct
 type IceCredentials struct {
 	UFrag string
 	Pwd   string
+	// IceLite indicates that the remote peer runs an ICE-lite agent
+	IceLite bool
+}
+
+// remoteOffer is an offer of the remote peer queued until the connection proceeds with it
+type remoteOffer struct {
+	IceCredentials
+	received time.Time
 }
 
 type Conn struct {
@@ -51,31 +110,97 @@ type Conn struct {
 	// signalOffer is a handler function to signal remote peer our connection offer (credentials)
 	signalOffer  func(uFrag string, pwd string) error
 	signalAnswer func(uFrag string, pwd string) error
-
-	// remoteOffersCh is a channel used to wait for remote credentials to proceed with the connection
-	remoteOffersCh chan IceCredentials
+	// onDirectConnection is a handler function to be notified about the remote Wireguard endpoint of a direct connection
+	onDirectConnection func(endpoint *net.UDPAddr)
+	// onRelayedConnection is a handler function to be notified about the TURN relay address of the remote peer
+	onRelayedConnection func(relay *net.UDPAddr)
+	// signalRelayPacket is a handler function to send a Wireguard packet to the remote peer through the Signal Service
+	signalRelayPacket func(packet []byte) error
+	// onEndpointRejected is a handler function to be notified about an endpoint the remote peer hasn't advertised
+	onEndpointRejected func(endpoint string)
+	// onAttemptOutcome is a handler function to be notified about the outcome of every connection attempt
+	onAttemptOutcome func(class FailureClass)
+
+	// remoteOffersCh queues the latest offer of the remote peer until the connection is ready to proceed with it
+	remoteOffersCh chan remoteOffer
+	// remoteOfferedCh is notified when the remote peer offers the connection
+	remoteOfferedCh chan struct{}
+	// remoteUFrag is the user fragment of the remote credentials the last negotiation has proceeded with
+	remoteUFrag string
 	// remoteAnswerCh is a channel used to wait for remote credentials answer (confirmation of our offer) to proceed with the connection
 	remoteAnswerCh     chan IceCredentials
 	closeCh            chan struct{}
 	ctx                context.Context
 	notifyDisconnected context.CancelFunc
+	// relayRequestCh is notified when the remote peer relays the connection through the Signal Service
+	relayRequestCh chan struct{}
+	// relayRefreshCh is notified when the TURN credentials of the relayed connection have changed
+	relayRefreshCh chan struct{}
 
 	agent  *ice.Agent
 	status ConnStatus
+	// ipFamily is the IP family of the selected ICE candidate pair of the established connection
+	ipFamily IPFamily
+	// connType and relayAddress describe the established connection
+	connType     ConnType
+	relayAddress string
+	// remoteAddress is the address of the remote candidate of the established connection the packets are sent to
+	remoteAddress string
+	// remoteRelay is the TURN relay address of the remote peer if the established connection goes through it
+	remoteRelay *net.UDPAddr
+	// upgradedFrom is the type of the relayed connection upgraded at upgradedAt, empty if it hasn't been upgraded
+	upgradedFrom ConnType
+	upgradedAt   time.Time
+	// relayCredentialsStale indicates that the TURN credentials have changed since the relayed connection was negotiated,
+	// so its TURN allocation is refreshed with the old ones until they expire
+	relayCredentialsStale bool
 
 	proxy proxy.Proxy
+
+	// failedAttempts is the number of ICE negotiations failed in a row
+	failedAttempts int
+	// relayConn passes the packets through the Signal Service while the connection is relayed
+	relayConn *signalRelayConn
+	// lastFailure is the class of the latest failed attempt, empty once the connection has been established
+	lastFailure FailureClass
+	// localCandidates and remoteCandidates count the candidates of the current attempt. The ICE Agent drops its
+	// candidates once the negotiation has failed, so they are counted to classify the failure
+	localCandidates  int
+	remoteCandidates int
+	// advertisedEndpoints are the addresses of the candidates the remote peer has signalled in the current attempt,
+	// the negotiated endpoint has to be one of them
+	advertisedEndpoints map[string]struct{}
+	// trace holds the phases reached by the latest connection attempt, nil if the tracing is disabled
+	trace []TraceEvent
+	// stage is the stage reached by the latest staged connection attempt and remoteCandidateTypes the types of
+	// the remote candidates its negotiation uses, nil if the staged mode is disabled
+	stage                ICEStage
+	remoteCandidateTypes []ice.CandidateType
+	// routedNetworks are the networks behind the remote peer routed through it, added to the allowed IPs of the
+	// Wireguard peer
+	routedNetworks []string
+
+	// log carries the key of the remote peer and the name of the Wireguard interface in every entry
+	log *logrus.Entry
 }
 
 // NewConn creates a new not opened Conn to the remote peer.
 // To establish a connection run Conn.Open
 func NewConn(config ConnConfig) (*Conn, error) {
 	return &Conn{
-		config:         config,
-		mu:             sync.Mutex{},
-		status:         StatusDisconnected,
-		closeCh:        make(chan struct{}),
-		remoteOffersCh: make(chan IceCredentials),
-		remoteAnswerCh: make(chan IceCredentials),
+		config:          config,
+		mu:              sync.Mutex{},
+		status:          StatusDisconnected,
+		closeCh:         make(chan struct{}),
+		remoteOffersCh:  make(chan remoteOffer, 1),
+		remoteOfferedCh: make(chan struct{}, 1),
+		remoteAnswerCh:  make(chan IceCredentials),
+		relayRequestCh:  make(chan struct{}, 1),
+		relayRefreshCh:  make(chan struct{}, 1),
+		log: log.WithFields(logrus.Fields{
+			"peer":  config.Key,
+			"iface": config.ProxyConfig.WgInterface.Name,
+		}),
 	}, nil
 }
 
@@ -106,140 +231,350 @@ func interfaceFilter(blackList []string) func(string) bool {
 	}
 }
 
-func (conn *Conn) reCreateAgent() error {
+// reCreateAgent creates the ICE Agent of a new negotiation over the local and the remote candidates of the given types,
+// replacing the one of the previous stage of a staged connection attempt
+func (conn *Conn) reCreateAgent(candidateTypes []ice.CandidateType) error {
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
 
-	failedTimeout := 6 * time.Second
+	if conn.agent != nil {
+		err := conn.agent.Close()
+		if err != nil {
+			conn.log.Debugf("failed closing ICE Agent of peer %s: %v", conn.config.Key, err)
+		}
+		conn.agent = nil
+	}
+	if conn.notifyDisconnected != nil {
+		conn.notifyDisconnected()
+	}
+
+	conn.localCandidates = 0
+	conn.remoteCandidates = 0
+	conn.advertisedEndpoints = map[string]struct{}{}
+	conn.remoteCandidateTypes = nil
+	if conn.config.StagedICE {
+		conn.remoteCandidateTypes = candidateTypes
+	}
+
 	var err error
-	conn.agent, err = ice.NewAgent(&ice.AgentConfig{
-		MulticastDNSMode: ice.MulticastDNSModeDisabled,
-		NetworkTypes:     []ice.NetworkType{ice.NetworkTypeUDP4},
-		Urls:             conn.config.StunTurn,
-		CandidateTypes:   []ice.CandidateType{ice.CandidateTypeHost, ice.CandidateTypeServerReflexive, ice.CandidateTypeRelay},
-		FailedTimeout:    &failedTimeout,
-		InterfaceFilter:  interfaceFilter(conn.config.InterfaceBlackList),
-		UDPMux:           conn.config.UDPMux,
-		UDPMuxSrflx:      conn.config.UDPMuxSrflx,
-	})
+	conn.agent, err = conn.newAgent(candidateTypes)
 	if err != nil {
 		return err
 	}
 
-	err = conn.agent.OnCandidate(conn.onICECandidate)
+	// the context of each negotiation is cancelled by its own agent only
+	ctx, cancel := context.WithCancel(context.Background())
+	conn.ctx, conn.notifyDisconnected = ctx, cancel
+	return conn.agent.OnConnectionStateChange(conn.onICEConnectionStateChange(cancel))
+}
+
+// newAgent creates an ICE Agent gathering the local candidates of the given types.
+// The caller registers the connection state callback
+func (conn *Conn) newAgent(candidateTypes []ice.CandidateType) (*ice.Agent, error) {
+	failedTimeout := 6 * time.Second
+	preference := conn.config.IPFamilyPreference
+	udpMux, udpMuxSrflx := conn.config.UDPMux, conn.config.UDPMuxSrflx
+	if preference == IPFamilyIPv6 {
+		// the shared UDP sockets are IPv4 only
+		udpMux, udpMuxSrflx = nil, nil
+	}
+
+	stunTurn := conn.config.StunTurn
+	if conn.config.EnableTCPFallback {
+		stunTurn = withTCPRelays(stunTurn)
+	}
+	urls := orderStunTurn(stunTurn, preference, net.LookupIP)
+
+	if conn.config.IceLite {
+		// a lite agent is reachable on its public host address and has no use of the STUN and TURN servers
+		candidateTypes = []ice.CandidateType{ice.CandidateTypeHost}
+		urls, udpMuxSrflx = nil, nil
+	}
+
+	agent, err := ice.NewAgent(&ice.AgentConfig{
+		MulticastDNSMode: ice.MulticastDNSModeDisabled,
+		NetworkTypes:     preference.networkTypes(),
+		Urls:             urls,
+		CandidateTypes:   candidateTypes,
+		FailedTimeout:    &failedTimeout,
+		InterfaceFilter:  interfaceFilter(conn.config.InterfaceBlackList),
+		UDPMux:           udpMux,
+		UDPMuxSrflx:      udpMuxSrflx,
+		Lite:             conn.config.IceLite,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = conn.agent.OnConnectionStateChange(conn.onICEConnectionStateChange)
+	err = agent.OnCandidate(conn.onICECandidate)
 	if err != nil {
-		return err
+		return agent, err
 	}
 
-	err = conn.agent.OnSelectedCandidatePairChange(conn.onICESelectedCandidatePair)
+	err = agent.OnSelectedCandidatePairChange(conn.onICESelectedCandidatePair)
 	if err != nil {
-		return err
+		return agent, err
 	}
 
-	return nil
+	return agent, nil
 }
 
 // Open opens connection to the remote peer starting ICE candidate gathering process.
 // Blocks until connection has been closed or connection timeout.
 // ConnStatus will be set accordingly
-func (conn *Conn) Open() error {
-	log.Debugf("trying to connect to peer %s", conn.config.Key)
+func (conn *Conn) Open() (err error) {
+	conn.log.Debugf("trying to connect to peer %s", conn.config.Key)
+
+	defer func() {
+		// the established connection is reported once connected, an attempt closed externally has no outcome
+		if class := FailureClassOf(err); class != "" {
+			conn.reportOutcome(class)
+		}
+	}()
 
 	defer func() {
 		err := conn.cleanup()
 		if err != nil {
-			log.Warnf("error while cleaning up peer connection %s: %v", conn.config.Key, err)
+			conn.log.Warnf("error while cleaning up peer connection %s: %v", conn.config.Key, err)
 			return
 		}
 	}()
 
-	err := conn.reCreateAgent()
+	conn.mu.Lock()
+	conn.status = StatusDisconnected
+	conn.stage = ""
+	conn.traceReset()
+	relay := conn.config.EnableSignalRelay && conn.failedAttempts >= SignalRelayAttempts
+	conn.mu.Unlock()
+
+	if relay {
+		conn.log.Warnf("ICE negotiation with peer %s failed %d times in a row", conn.config.Key, SignalRelayAttempts)
+		return conn.openSignalRelay()
+	}
+
+	attemptTimeout := conn.config.AttemptTimeout
+	if attemptTimeout <= 0 {
+		attemptTimeout = DefaultAttemptTimeout
+	}
+	signalingTimeout := conn.config.Timeout
+	if signalingTimeout > attemptTimeout {
+		signalingTimeout = attemptTimeout
+	}
+
+	// the slot is held until the connection has been established or the attempt has failed
+	releaseSlot := func() {}
+	if limiter := conn.config.DialLimiter; limiter != nil {
+		if limiter.Dialing() == cap(limiter.slots) {
+			conn.log.Debugf("waiting for other connection attempts to finish before connecting to peer %s", conn.config.Key)
+		}
+		acquired, err := conn.acquireDialSlot(limiter, signalingTimeout)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			var once sync.Once
+			releaseSlot = func() { once.Do(limiter.release) }
+			defer releaseSlot()
+		}
+	}
+	deadline := time.Now().Add(attemptTimeout)
+
+	var remoteConn *ice.Conn
+	var isControlling bool
+	if conn.config.StagedICE && !conn.config.IceLite {
+		remoteConn, isControlling, err = conn.negotiateStaged(deadline, signalingTimeout)
+	} else {
+		remoteConn, isControlling, err = conn.negotiate(allCandidateTypes, deadline, signalingTimeout)
+	}
+	if class := FailureClassOf(err); class == FailureICEFailed || class == FailureNoCandidates {
+		conn.mu.Lock()
+		conn.failedAttempts++
+		conn.mu.Unlock()
+	}
 	if err != nil {
 		return err
 	}
+	conn.tracePhase(TraceCheckSucceeded)
+	var lastHandshake time.Time
+	if conn.config.Trace {
+		lastHandshake = conn.lastHandshake()
+	}
 
-	err = conn.sendOffer()
+	// the connection has been established successfully so we are ready to start the proxy
+	err = conn.startProxy(remoteConn)
+	if conn.rejectedEndpoint(err) {
+		return conn.failed(FailureEndpointRejected, err)
+	}
 	if err != nil {
-		return err
+		return conn.failed(FailureProxyFailed, err)
 	}
 
-	log.Debugf("connection offer sent to peer %s, waiting for the confirmation", conn.config.Key)
+	conn.onConnected(remoteConn)
+	conn.reportOutcome("")
+	releaseSlot()
+	if conn.config.Trace {
+		go conn.traceHandshake(conn.ctx, lastHandshake)
+	}
 
-	// Only continue once we got a connection confirmation from the remote peer.
-	// The connection timeout could have happened before a confirmation received from the remote.
-	// The connection could have also been closed externally (e.g. when we received an update from the management that peer shouldn't be connected)
-	var remoteCredentials IceCredentials
-	select {
-	case remoteCredentials = <-conn.remoteOffersCh:
-		// received confirmation from the remote peer -> ready to proceed
-		err = conn.sendAnswer()
-		if err != nil {
-			return err
-		}
-	case remoteCredentials = <-conn.remoteAnswerCh:
-	case <-time.After(conn.config.Timeout):
-		return NewConnectionTimeoutError(conn.config.Key, conn.config.Timeout)
-	case <-conn.closeCh:
-		// closed externally
-		return NewConnectionClosedError(conn.config.Key)
+	// wait until connection disconnected or has been closed externally (upper layer, e.g. engine),
+	// a relayed connection is upgraded meanwhile once the peers can reach each other without the relay
+	return conn.waitDisconnected(isControlling)
+}
+
+// negotiate exchanges the credentials with the remote peer and negotiates a connection over the local and the remote
+// candidates of the given types, the negotiation is aborted once the deadline has passed.
+// Returns the established ICE connection and whether the local agent is the controlling one
+func (conn *Conn) negotiate(candidateTypes []ice.CandidateType, deadline time.Time,
+	signalingTimeout time.Duration) (*ice.Conn, bool, error) {
+	err := conn.reCreateAgent(candidateTypes)
+	if err != nil {
+		return nil, false, err
 	}
 
-	log.Debugf("received connection confirmation from peer %s", conn.config.Key)
+	remoteCredentials, err := conn.exchangeCredentials(signalingTimeout)
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn.log.Debugf("received connection confirmation from peer %s", conn.config.Key)
+	conn.tracePhase(TraceAnswerReceived)
 
 	// at this point we received offer/answer and we are ready to gather candidates
 	conn.mu.Lock()
 	conn.status = StatusConnecting
-	conn.ctx, conn.notifyDisconnected = context.WithCancel(context.Background())
-	defer conn.notifyDisconnected()
+	conn.remoteUFrag = remoteCredentials.UFrag
 	conn.mu.Unlock()
 
-	err = conn.agent.GatherCandidates()
+	err = conn.harvest(conn.agent)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
 
 	// will block until connection succeeded
 	// but it won't release if ICE Agent went into Disconnected or Failed state,
-	// so we have to cancel it with the provided context once agent detected a broken connection
-	isControlling := conn.config.LocalKey > conn.config.Key
+	// so we have to cancel it with the provided context once agent detected a broken connection.
+	// The deadline releases it when the gathering hangs, e.g. on a blackholed STUN server
+	dialCtx, cancelDial := context.WithDeadline(conn.ctx, deadline)
+	defer cancelDial()
+	go conn.cancelOnRemoteOffer(dialCtx, cancelDial, signalingTimeout)
+	isControlling := conn.isControlling(remoteCredentials.IceLite)
 	var remoteConn *ice.Conn
 	if isControlling {
-		remoteConn, err = conn.agent.Dial(conn.ctx, remoteCredentials.UFrag, remoteCredentials.Pwd)
+		remoteConn, err = conn.agent.Dial(dialCtx, remoteCredentials.UFrag, remoteCredentials.Pwd)
 	} else {
-		remoteConn, err = conn.agent.Accept(conn.ctx, remoteCredentials.UFrag, remoteCredentials.Pwd)
+		remoteConn, err = conn.agent.Accept(dialCtx, remoteCredentials.UFrag, remoteCredentials.Pwd)
 	}
 	if err != nil {
-		return err
+		return nil, false, conn.failed(conn.iceFailureClass(), err)
 	}
 
-	// the connection has been established successfully so we are ready to start the proxy
-	err = conn.startProxy(remoteConn)
+	return remoteConn, isControlling, nil
+}
+
+// exchangeCredentials offers the connection to the remote peer and waits for its credentials, either in the answer
+// to the offer or in its own offer. An offer of the remote peer queued meanwhile is answered without offering, as the
+// remote peer waits for the answer and a new offer would make it abandon its negotiation
+func (conn *Conn) exchangeCredentials(signalingTimeout time.Duration) (IceCredentials, error) {
+	if offer, ok := conn.pendingRemoteOffer(signalingTimeout); ok {
+		conn.log.Debugf("answering pending connection offer of peer %s", conn.config.Key)
+		return offer, conn.sendAnswer()
+	}
+
+	err := conn.sendOffer()
+	if errors.Is(err, ErrPeerOffline) {
+		// no point in waiting for the answer of a peer the offer hasn't reached
+		return IceCredentials{}, conn.failed(FailurePeerOffline, err)
+	}
 	if err != nil {
-		return err
+		return IceCredentials{}, err
 	}
+	conn.tracePhase(TraceOfferSent)
+
+	conn.log.Debugf("connection offer sent to peer %s, waiting for the confirmation", conn.config.Key)
 
+	// Only continue once we got a connection confirmation from the remote peer.
+	// The connection timeout could have happened before a confirmation received from the remote.
+	// The connection could have also been closed externally (e.g. when we received an update from the management that peer shouldn't be connected)
+	signalingTimer := time.NewTimer(signalingTimeout)
+	defer signalingTimer.Stop()
+	for {
+		select {
+		case offer := <-conn.remoteOffersCh:
+			if conn.outdatedOffer(offer, signalingTimeout) {
+				continue
+			}
+			// received confirmation from the remote peer -> ready to proceed
+			return offer.IceCredentials, conn.sendAnswer()
+		case remoteCredentials := <-conn.remoteAnswerCh:
+			return remoteCredentials, nil
+		case <-conn.relayRequestCh:
+			// the remote peer has given up on ICE
+			conn.log.Infof("peer %s relays the connection through the Signal Service", conn.config.Key)
+			return IceCredentials{}, conn.openSignalRelay()
+		case <-signalingTimer.C:
+			return IceCredentials{}, conn.failed(FailureSignalingTimeout, NewConnectionTimeoutError(conn.config.Key, signalingTimeout))
+		case <-conn.closeCh:
+			// closed externally
+			return IceCredentials{}, NewConnectionClosedError(conn.config.Key)
+		}
+	}
+}
+
+// isControlling tells whether the local agent takes the controlling role. A full agent always controls the connection
+// to an ICE-lite one, otherwise the role is decided by the keys
+func (conn *Conn) isControlling(remoteIceLite bool) bool {
+	if conn.config.IceLite != remoteIceLite {
+		return remoteIceLite
+	}
+	return conn.config.LocalKey > conn.config.Key
+}
+
+// failed records the class of the failed attempt and returns the classified error
+func (conn *Conn) failed(class FailureClass, err error) error {
+	conn.mu.Lock()
+	conn.lastFailure = class
+	conn.mu.Unlock()
+	return NewConnectionFailedError(conn.config.Key, class, err)
+}
+
+// reportOutcome notifies about the outcome of the connection attempt, an empty class for the established connection
+func (conn *Conn) reportOutcome(class FailureClass) {
+	if conn.onAttemptOutcome != nil {
+		conn.onAttemptOutcome(class)
+	}
+}
+
+// iceFailureClass classifies a failed ICE negotiation, telling apart the one without candidates on either side
+func (conn *Conn) iceFailureClass() FailureClass {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.localCandidates == 0 || conn.remoteCandidates == 0 {
+		return FailureNoCandidates
+	}
+	return FailureICEFailed
+}
+
+// onConnected logs the established connection and notifies about the remote Wireguard endpoint of a direct one
+func (conn *Conn) onConnected(remoteConn net.Conn) {
 	if conn.proxy.Type() == proxy.TypeNoProxy {
 		host, _, _ := net.SplitHostPort(remoteConn.LocalAddr().String())
 		rhost, _, _ := net.SplitHostPort(remoteConn.RemoteAddr().String())
+		_, port, _ := net.SplitHostPort(conn.config.ProxyConfig.WgListenAddr)
+		rport := conn.config.ProxyConfig.RemoteWgListenPort()
 		// direct Wireguard connection
-		log.Infof("directly connected to peer %s [laddr <-> raddr] [%s:%d <-> %s:%d]", conn.config.Key, host, iface.DefaultWgPort, rhost, iface.DefaultWgPort)
+		conn.log.Infof("directly connected to peer %s [laddr <-> raddr] [%s:%s <-> %s:%d]", conn.config.Key, host, port, rhost, rport)
+		if conn.onDirectConnection != nil {
+			conn.onDirectConnection(&net.UDPAddr{IP: net.ParseIP(rhost), Port: rport})
+		}
 	} else {
-		log.Infof("connected to peer %s [laddr <-> raddr] [%s <-> %s]", conn.config.Key, remoteConn.LocalAddr().String(), remoteConn.RemoteAddr().String())
-	}
-
-	// wait until connection disconnected or has been closed externally (upper layer, e.g. engine)
-	select {
-	case <-conn.closeCh:
-		// closed externally
-		return NewConnectionClosedError(conn.config.Key)
-	case <-conn.ctx.Done():
-		// disconnected from the remote peer
-		return NewConnectionDisconnectedError(conn.config.Key)
+		conn.log.Infof("connected to peer %s [laddr <-> raddr] [%s <-> %s]", conn.config.Key, remoteConn.LocalAddr().String(), remoteConn.RemoteAddr().String())
+		conn.mu.Lock()
+		relay := conn.remoteRelay
+		conn.mu.Unlock()
+		if relay != nil && conn.onRelayedConnection != nil {
+			conn.onRelayedConnection(relay)
+		}
 	}
 }
 
@@ -278,7 +613,8 @@ func IsPublicIP(ip net.IP) bool {
 	return true
 }
 
-// startProxy starts proxying traffic from/to local Wireguard and sets connection status to StatusConnected
+// startProxy starts proxying traffic from/to local Wireguard and sets connection status to StatusConnected.
+// Returns EndpointRejectedError if the remote peer hasn't advertised the negotiated endpoint
 func (conn *Conn) startProxy(remoteConn net.Conn) error {
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
@@ -289,13 +625,13 @@ func (conn *Conn) startProxy(remoteConn net.Conn) error {
 		return err
 	}
 
-	useProxy := shouldUseProxy(pair)
-	var p proxy.Proxy
-	if useProxy {
-		p = proxy.NewWireguardProxy(conn.config.ProxyConfig)
-	} else {
-		p = proxy.NewNoProxy(conn.config.ProxyConfig)
+	err = conn.verifyEndpoint(pair)
+	if err != nil {
+		return err
 	}
+
+	useProxy := shouldUseProxy(pair)
+	p := conn.newProxy(useProxy)
 	conn.proxy = p
 	err = p.Start(remoteConn)
 	if err != nil {
@@ -303,13 +639,28 @@ func (conn *Conn) startProxy(remoteConn net.Conn) error {
 	}
 
 	conn.status = StatusConnected
+	conn.failedAttempts = 0
+	conn.lastFailure = ""
+	conn.ipFamily = ipFamilyOf(pair.Local.Address())
+	conn.connType = connTypeOf(pair, useProxy)
+	conn.relayAddress = relayAddressOf(pair)
+	conn.remoteAddress = pair.Remote.Address()
+	conn.remoteRelay = remoteRelayOf(pair)
 
 	return nil
 }
 
+// newProxy creates a proxy between the local Wireguard and the ICE connection, no proxy if the connection is direct
+func (conn *Conn) newProxy(useProxy bool) proxy.Proxy {
+	if useProxy {
+		return proxy.NewWireguardProxy(conn.proxyConfig())
+	}
+	return proxy.NewNoProxy(conn.proxyConfig())
+}
+
 // cleanup closes all open resources and sets status to StatusDisconnected
 func (conn *Conn) cleanup() error {
-	log.Debugf("trying to cleanup %s", conn.config.Key)
+	conn.log.Debugf("trying to cleanup %s", conn.config.Key)
 	conn.mu.Lock()
 	defer conn.mu.Unlock()
 
@@ -329,14 +680,30 @@ func (conn *Conn) cleanup() error {
 		conn.proxy = nil
 	}
 
+	if conn.relayConn != nil {
+		// the proxy has stopped reading, closing the relay connection releases it
+		_ = conn.relayConn.Close()
+		conn.relayConn = nil
+		// ICE is negotiated again once the relayed connection has been closed, e.g. on a network change
+		conn.failedAttempts = 0
+	}
+
 	if conn.notifyDisconnected != nil {
 		conn.notifyDisconnected()
 		conn.notifyDisconnected = nil
 	}
 
 	conn.status = StatusDisconnected
+	conn.ipFamily = ""
+	conn.connType = ""
+	conn.relayAddress = ""
+	conn.remoteAddress = ""
+	conn.remoteRelay = nil
+	conn.upgradedFrom = ""
+	conn.upgradedAt = time.Time{}
+	conn.relayCredentialsStale = false
 
-	log.Debugf("cleaned up connection to peer %s", conn.config.Key)
+	conn.log.Debugf("cleaned up connection to peer %s", conn.config.Key)
 
 	return nil
 }
@@ -356,30 +723,158 @@ func (conn *Conn) SetSignalCandidate(handler func(candidate ice.Candidate) error
 	conn.signalCandidate = handler
 }
 
+// SetStunTurn updates STUN and TURN URLs (e.g. with refreshed TURN credentials) used by the next connection attempt
+// A relayed connection is renegotiated with the new TURN credentials before the old ones expire
+func (conn *Conn) SetStunTurn(stunTurn []*ice.URL) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	turnChanged := !sameTURNCredentials(conn.config.StunTurn, stunTurn)
+	conn.config.StunTurn = stunTurn
+	if turnChanged && conn.connType == ConnTypeRelayed {
+		conn.relayCredentialsStale = true
+		select {
+		case conn.relayRefreshCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sameTURNCredentials returns true if the TURN servers of both lists and their credentials are the same
+func sameTURNCredentials(a, b []*ice.URL) bool {
+	turns := func(urls []*ice.URL) []string {
+		var result []string
+		for _, url := range urls {
+			if url.Scheme == ice.SchemeTypeTURN || url.Scheme == ice.SchemeTypeTURNS {
+				result = append(result, fmt.Sprintf("%s|%s|%s", url.String(), url.Username, url.Password))
+			}
+		}
+		return result
+	}
+
+	turnsA, turnsB := turns(a), turns(b)
+	if len(turnsA) != len(turnsB) {
+		return false
+	}
+	for i := range turnsA {
+		if turnsA[i] != turnsB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// SetOnDirectConnection sets a handler function to be triggered by Conn when a direct Wireguard connection
+// (without a proxy) to the remote peer has been established. The handler receives the remote Wireguard endpoint
+func (conn *Conn) SetOnDirectConnection(handler func(endpoint *net.UDPAddr)) {
+	conn.onDirectConnection = handler
+}
+
+// SetOnRelayedConnection sets a handler function to be triggered by Conn when a connection through the TURN relay
+// of the remote peer has been established. The handler receives the relay address of the remote peer
+func (conn *Conn) SetOnRelayedConnection(handler func(relay *net.UDPAddr)) {
+	conn.onRelayedConnection = handler
+}
+
+// SetOnAttemptOutcome sets a handler function to be triggered by Conn once a connection attempt to the remote peer has
+// either established the connection (an empty class) or failed with a classified error
+func (conn *Conn) SetOnAttemptOutcome(handler func(class FailureClass)) {
+	conn.onAttemptOutcome = handler
+}
+
+// SetSignalRelayPacket sets a handler function to be triggered by Conn when a Wireguard packet of a connection relayed
+// through the Signal Service has to be sent to the remote peer
+func (conn *Conn) SetSignalRelayPacket(handler func(packet []byte) error) {
+	conn.signalRelayPacket = handler
+}
+
+// openSignalRelay relays the connection through the Signal Service until it has been closed externally.
+// The connection is degraded: every packet passes the Signal Service and the bandwidth is capped
+func (conn *Conn) openSignalRelay() error {
+	conn.mu.Lock()
+	if conn.signalRelayPacket == nil {
+		conn.mu.Unlock()
+		return fmt.Errorf("relaying connection to peer %s through the Signal Service isn't supported", conn.config.Key)
+	}
+
+	conn.relayConn = newSignalRelayConn(conn.config.LocalKey, conn.config.Key, conn.signalRelayPacket, conn.config.SignalRelayLimitKbps)
+	// the packets are sent by the local Wireguard interface through the proxy
+	p := proxy.NewWireguardProxy(conn.proxyConfig())
+	conn.proxy = p
+	err := p.Start(conn.relayConn)
+	if err != nil {
+		conn.mu.Unlock()
+		return err
+	}
+
+	conn.status = StatusConnected
+	conn.connType = ConnTypeSignalRelayed
+	conn.lastFailure = ""
+	conn.mu.Unlock()
+
+	conn.log.Warnf("relaying connection to peer %s through the Signal Service, the connection is degraded", conn.config.Key)
+	conn.reportOutcome("")
+
+	<-conn.closeCh
+	return NewConnectionClosedError(conn.config.Key)
+}
+
+// OnRelayPacket handles a Wireguard packet relayed by the remote peer through the Signal Service, doesn't block.
+// A packet received while the connection isn't relayed makes the Conn relay it too (if enabled), the packet itself is
+// dropped and retransmitted by Wireguard
+func (conn *Conn) OnRelayPacket(packet []byte) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.relayConn != nil {
+		conn.relayConn.deliver(packet)
+		return
+	}
+	if !conn.config.EnableSignalRelay {
+		conn.log.Debugf("dropping packet relayed by peer %s through the Signal Service, the relay is disabled", conn.config.Key)
+		return
+	}
+
+	select {
+	case conn.relayRequestCh <- struct{}{}:
+	default:
+	}
+}
+
 // onICECandidate is a callback attached to an ICE Agent to receive new local connection candidates
 // and then signals them to the remote peer
 func (conn *Conn) onICECandidate(candidate ice.Candidate) {
-	if candidate != nil {
-		// log.Debugf("discovered local candidate %s", candidate.String())
-		go func() {
-			err := conn.signalCandidate(candidate)
-			if err != nil {
-				log.Errorf("failed signaling candidate to the remote peer %s %s", conn.config.Key, err)
-			}
-		}()
+	if candidate == nil {
+		// the gathering has completed
+		conn.tracePhase(TraceGatheringDone)
+		return
 	}
+
+	// log.Debugf("discovered local candidate %s", candidate.String())
+	conn.mu.Lock()
+	conn.localCandidates++
+	conn.mu.Unlock()
+	go func() {
+		err := conn.signalCandidate(candidate)
+		if err != nil {
+			conn.log.Errorf("failed signaling candidate to the remote peer %s %s", conn.config.Key, err)
+		}
+	}()
 }
 
 func (conn *Conn) onICESelectedCandidatePair(c1 ice.Candidate, c2 ice.Candidate) {
-	log.Debugf("selected candidate pair [local <-> remote] -> [%s <-> %s], peer %s", c1.String(), c2.String(),
+	conn.log.Debugf("selected candidate pair [local <-> remote] -> [%s <-> %s], peer %s", c1.String(), c2.String(),
 		conn.config.Key)
 }
 
-// onICEConnectionStateChange registers callback of an ICE Agent to track connection state
-func (conn *Conn) onICEConnectionStateChange(state ice.ConnectionState) {
-	log.Debugf("peer %s ICE ConnectionState has changed to %s", conn.config.Key, state.String())
-	if state == ice.ConnectionStateFailed || state == ice.ConnectionStateDisconnected {
-		conn.notifyDisconnected()
+// onICEConnectionStateChange returns a callback of an ICE Agent to track connection state, a broken connection
+// cancels the context of the agent's negotiation
+func (conn *Conn) onICEConnectionStateChange(notifyDisconnected context.CancelFunc) func(state ice.ConnectionState) {
+	return func(state ice.ConnectionState) {
+		conn.log.Debugf("peer %s ICE ConnectionState has changed to %s", conn.config.Key, state.String())
+		if state == ice.ConnectionStateFailed || state == ice.ConnectionStateDisconnected {
+			notifyDisconnected()
+		}
 	}
 }
 
@@ -392,7 +887,7 @@ func (conn *Conn) sendAnswer() error {
 		return err
 	}
 
-	log.Debugf("sending asnwer to %s", conn.config.Key)
+	conn.log.Debugf("sending asnwer to %s", conn.config.Key)
 	err = conn.signalAnswer(localUFrag, localPwd)
 	if err != nil {
 		return err
@@ -437,7 +932,7 @@ func (conn *Conn) Close() error {
 		// before conn.Open() another update from management arrives with peers: [1,2,3,4,5]
 		// engine adds a new Conn for 4 and 5
 		// therefore peer 4 has 2 Conn objects
-		log.Warnf("closing not started coonection %s", conn.config.Key)
+		conn.log.Warnf("closing not started coonection %s", conn.config.Key)
 		return NewConnectionAlreadyClosed(conn.config.Key)
 	}
 }
@@ -449,39 +944,157 @@ func (conn *Conn) Status() ConnStatus {
 	return conn.status
 }
 
-// OnRemoteOffer handles an offer from the remote peer and returns true if the message was accepted, false otherwise
-// doesn't block, discards the message if connection wasn't ready
+// SetIdle marks a not opened Conn as idle, i.e. kept dormant until there is traffic to the remote peer.
+// Open resets the status
+func (conn *Conn) SetIdle() {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.status == StatusDisconnected {
+		conn.status = StatusIdle
+	}
+}
+
+// IPFamily returns the IP family used by the established connection or an empty string if the peer isn't connected
+func (conn *Conn) IPFamily() IPFamily {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.ipFamily
+}
+
+// ConnType returns the type of the established connection or an empty string if the peer isn't connected
+func (conn *Conn) ConnType() ConnType {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.connType
+}
+
+// RelayAddress returns the address of the TURN relay of the established connection,
+// an empty string if the peer isn't connected or the connection isn't relayed
+func (conn *Conn) RelayAddress() string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.relayAddress
+}
+
+// RemoteAddress returns the address of the remote candidate of the established connection (e.g. the public address
+// of the remote peer or its TURN relay), an empty string if the peer isn't connected or the connection isn't negotiated
+func (conn *Conn) RemoteAddress() string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.remoteAddress
+}
+
+// LastFailure returns the class of the latest failed connection attempt, an empty class if the connection has been
+// established since or no attempt has failed
+func (conn *Conn) LastFailure() FailureClass {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.lastFailure
+}
+
+// UpgradedFrom returns the type of the relayed connection and the time it has been upgraded to the established one,
+// an empty type if the connection hasn't been upgraded
+func (conn *Conn) UpgradedFrom() (ConnType, time.Time) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.upgradedFrom, conn.upgradedAt
+}
+
+// OnRemoteOffer handles an offer from the remote peer and returns true if the message was accepted, false otherwise.
+// Doesn't block, the latest offer is queued until the connection is ready to proceed with it, e.g. while it is
+// between the stages of a staged connection attempt
 func (conn *Conn) OnRemoteOffer(remoteAuth IceCredentials) bool {
-	log.Debugf("OnRemoteOffer from peer %s on status %s", conn.config.Key, conn.status.String())
+	conn.log.Debugf("OnRemoteOffer from peer %s on status %s", conn.config.Key, conn.Status().String())
 
+	conn.queueRemoteOffer(remoteOffer{IceCredentials: remoteAuth, received: time.Now()})
 	select {
-	case conn.remoteOffersCh <- remoteAuth:
-		return true
+	case conn.remoteOfferedCh <- struct{}{}:
 	default:
-		log.Debugf("OnRemoteOffer skipping message from peer %s on status %s because is not ready", conn.config.Key, conn.status.String())
-		// connection might not be ready yet to receive so we ignore the message
-		return false
+	}
+	return true
+}
+
+// RemoteOffered returns a channel notified when the remote peer offers the connection, e.g. to connect right away
+// instead of backing off before the next connection attempt
+func (conn *Conn) RemoteOffered() <-chan struct{} {
+	return conn.remoteOfferedCh
+}
+
+// queueRemoteOffer queues the offer of the remote peer replacing the one the connection hasn't proceeded with yet
+func (conn *Conn) queueRemoteOffer(offer remoteOffer) {
+	for {
+		select {
+		case conn.remoteOffersCh <- offer:
+			return
+		default:
+		}
+		select {
+		case <-conn.remoteOffersCh:
+		default:
+		}
+	}
+}
+
+// pendingRemoteOffer returns the queued offer of the remote peer the connection can proceed with
+func (conn *Conn) pendingRemoteOffer(signalingTimeout time.Duration) (IceCredentials, bool) {
+	for {
+		select {
+		case offer := <-conn.remoteOffersCh:
+			if !conn.outdatedOffer(offer, signalingTimeout) {
+				return offer.IceCredentials, true
+			}
+		default:
+			return IceCredentials{}, false
+		}
+	}
+}
+
+// outdatedOffer returns true if the remote peer can't be waiting for the answer to the offer anymore, i.e. it has
+// been queued for longer than the signaling timeout or the last negotiation has already proceeded with it
+func (conn *Conn) outdatedOffer(offer remoteOffer, signalingTimeout time.Duration) bool {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return time.Since(offer.received) > signalingTimeout || offer.UFrag == conn.remoteUFrag
+}
+
+// cancelOnRemoteOffer cancels the ICE negotiation once the remote peer sends a new offer, e.g. when it has escalated
+// to the next stage, as the remote agent the negotiation is dialing has been closed. The offer stays queued for the
+// next negotiation
+func (conn *Conn) cancelOnRemoteOffer(ctx context.Context, cancel context.CancelFunc, signalingTimeout time.Duration) {
+	for {
+		select {
+		case offer := <-conn.remoteOffersCh:
+			if conn.outdatedOffer(offer, signalingTimeout) {
+				continue
+			}
+			conn.log.Debugf("peer %s has sent a new offer, abandoning the current negotiation", conn.config.Key)
+			conn.queueRemoteOffer(offer)
+			cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
 	}
 }
 
 // OnRemoteAnswer handles an offer from the remote peer and returns true if the message was accepted, false otherwise
 // doesn't block, discards the message if connection wasn't ready
 func (conn *Conn) OnRemoteAnswer(remoteAuth IceCredentials) bool {
-	log.Debugf("OnRemoteAnswer from peer %s on status %s", conn.config.Key, conn.status.String())
+	conn.log.Debugf("OnRemoteAnswer from peer %s on status %s", conn.config.Key, conn.Status().String())
 
 	select {
 	case conn.remoteAnswerCh <- remoteAuth:
 		return true
 	default:
 		// connection might not be ready yet to receive so we ignore the message
-		log.Debugf("OnRemoteAnswer skipping message from peer %s on status %s because is not ready", conn.config.Key, conn.status.String())
+		conn.log.Debugf("OnRemoteAnswer skipping message from peer %s on status %s because is not ready", conn.config.Key, conn.Status().String())
 		return false
 	}
 }
 
 // OnRemoteCandidate Handles ICE connection Candidate provided by the remote peer.
 func (conn *Conn) OnRemoteCandidate(candidate ice.Candidate) {
-	log.Debugf("OnRemoteCandidate from peer %s -> %s", conn.config.Key, candidate.String())
+	conn.log.Debugf("OnRemoteCandidate from peer %s -> %s", conn.config.Key, candidate.String())
 	go func() {
 		conn.mu.Lock()
 		defer conn.mu.Unlock()
@@ -489,15 +1102,71 @@ func (conn *Conn) OnRemoteCandidate(candidate ice.Candidate) {
 		if conn.agent == nil {
 			return
 		}
+		if !conn.acceptsRemoteCandidate(candidate) {
+			conn.log.Debugf("skipping remote %s candidate of peer %s in the %s stage", candidate.Type(), conn.config.Key,
+				conn.stage)
+			return
+		}
 
 		err := conn.agent.AddRemoteCandidate(candidate)
 		if err != nil {
-			log.Errorf("error while handling remote candidate from peer %s", conn.config.Key)
+			conn.log.Errorf("error while handling remote candidate from peer %s", conn.config.Key)
 			return
 		}
+		conn.remoteCandidates++
+		conn.advertiseRemoteCandidate(candidate)
 	}()
 }
 
 func (conn *Conn) GetKey() string {
 	return conn.config.Key
 }
+
+// GetAllowedIPs returns a comma separated list of the remote peer allowed IPs
+func (conn *Conn) GetAllowedIPs() string {
+	return conn.config.ProxyConfig.AllowedIps
+}
+
+// SetRoutedNetworks sets the networks routed through the remote peer. They are added to the allowed IPs of the Wireguard
+// peer once the connection is established, the caller updates the Wireguard peer of an established one
+func (conn *Conn) SetRoutedNetworks(networks []string) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.routedNetworks = networks
+}
+
+// GetRoutedNetworks returns the networks routed through the remote peer
+func (conn *Conn) GetRoutedNetworks() []string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return append([]string(nil), conn.routedNetworks...)
+}
+
+// GetWgAllowedIPs returns a comma separated list of the allowed IPs of the Wireguard peer: the remote peer allowed IPs
+// and the routed networks
+func (conn *Conn) GetWgAllowedIPs() string {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.wgAllowedIPs()
+}
+
+// wgAllowedIPs returns the allowed IPs of the Wireguard peer, the caller holds the lock
+func (conn *Conn) wgAllowedIPs() string {
+	if len(conn.routedNetworks) == 0 {
+		return conn.config.ProxyConfig.AllowedIps
+	}
+	return conn.config.ProxyConfig.AllowedIps + "," + strings.Join(conn.routedNetworks, ",")
+}
+
+// proxyConfig returns the config of a proxy of the connection, the Wireguard peer is configured with the routed
+// networks too. The caller holds the lock
+func (conn *Conn) proxyConfig() proxy.Config {
+	config := conn.config.ProxyConfig
+	config.AllowedIps = conn.wgAllowedIPs()
+	return config
+}
+
+// GetRemoteWgPort returns the listen port of the remote peer Wireguard interface sent by the Management service, 0 if unknown
+func (conn *Conn) GetRemoteWgPort() int {
+	return conn.config.ProxyConfig.RemoteWgPort
+}
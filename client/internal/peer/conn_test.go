@@ -1,9 +1,11 @@
 package peer
 
 import (
+	"fmt"
 	"github.com/magiconair/properties/assert"
 	"github.com/netbirdio/netbird/client/internal/proxy"
 	"github.com/pion/ice/v2"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -29,6 +31,25 @@ func TestConn_GetKey(t *testing.T) {
 	assert.Equal(t, got, connConf.Key, "they should be equal")
 }
 
+func TestConn_SetStunTurn(t *testing.T) {
+	conn, err := NewConn(connConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	turn, err := ice.ParseURL("turn:turn.wiretrustee.com:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+	turn.Username = "refreshed"
+
+	conn.SetStunTurn([]*ice.URL{turn})
+
+	assert.Equal(t, len(conn.config.StunTurn), 1)
+	assert.Equal(t, conn.config.StunTurn[0].Username, "refreshed")
+	assert.Equal(t, len(connConf.StunTurn), 0, "the initial config shouldn't be changed")
+}
+
 func TestConn_OnRemoteOffer(t *testing.T) {
 
 	conn, err := NewConn(connConf)
@@ -142,3 +163,604 @@ func TestConn_Close(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestConn_isControlling(t *testing.T) {
+	tables := []struct {
+		name          string
+		localIceLite  bool
+		remoteIceLite bool
+		want          bool
+	}{
+		// connConf.LocalKey > connConf.Key
+		{"Full Agents", false, false, true},
+		{"Lite Agents", true, true, true},
+		{"Lite Remote Agent", false, true, true},
+		{"Lite Local Agent", true, false, false},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			config := connConf
+			config.IceLite = table.localIceLite
+			conn, err := NewConn(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, conn.isControlling(table.remoteIceLite), table.want)
+		})
+	}
+}
+
+func TestConn_newAgent_IceLite(t *testing.T) {
+	stun, err := ice.ParseURL("stun:192.0.2.1:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := connConf
+	config.StunTurn = []*ice.URL{stun}
+	config.IceLite = true
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a lite agent gathering server reflexive candidates or using the STUN servers fails to be created
+	agent, err := conn.newAgent(allCandidateTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close() //nolint
+
+	candidates := make(chan ice.Candidate, 16)
+	err = agent.OnCandidate(func(candidate ice.Candidate) {
+		candidates <- candidate
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agent.GatherCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for candidate := range candidates {
+		if candidate == nil {
+			// gathering completed
+			return
+		}
+		assert.Equal(t, candidate.Type(), ice.CandidateTypeHost)
+	}
+}
+
+func TestConn_SetStunTurn_RelayRefresh(t *testing.T) {
+	turn := func(user string) *ice.URL {
+		url, err := ice.ParseURL("turn:turn.wiretrustee.com:3478")
+		if err != nil {
+			t.Fatal(err)
+		}
+		url.Username, url.Password = user, "password"
+		return url
+	}
+	stun, err := ice.ParseURL("stun:stun.wiretrustee.com:3478")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := NewConn(connConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetStunTurn([]*ice.URL{stun, turn("initial")})
+	conn.connType = ConnTypeRelayed
+
+	// the same credentials don't renegotiate the relayed connection
+	conn.SetStunTurn([]*ice.URL{stun, turn("initial")})
+	assert.Equal(t, conn.relayCredentialsStale, false)
+	assert.Equal(t, len(conn.relayRefreshCh), 0)
+
+	conn.SetStunTurn([]*ice.URL{stun, turn("refreshed")})
+	assert.Equal(t, conn.relayCredentialsStale, true)
+	assert.Equal(t, len(conn.relayRefreshCh), 1)
+
+	// a connection without relay keeps working with the new credentials
+	direct, err := NewConn(connConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct.connType = ConnTypeDirect
+	direct.SetStunTurn([]*ice.URL{stun, turn("refreshed")})
+	assert.Equal(t, direct.relayCredentialsStale, false)
+	assert.Equal(t, len(direct.relayRefreshCh), 0)
+}
+
+// noCandidatesHarvester gathers no candidates, like the gathering of a device with a blackholed STUN server
+type noCandidatesHarvester struct{}
+
+func (noCandidatesHarvester) Harvest(*ice.Agent, func(ice.Candidate, net.PacketConn) error) error {
+	return nil
+}
+
+func (noCandidatesHarvester) OnNetworkChange(func()) {}
+
+// deliver retries a signal message until the receiving Conn is ready to accept it
+func deliver(accept func() bool) {
+	for i := 0; i < 500 && !accept(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// answerOffers answers the offers of conn on behalf of a remote peer and passes it the remote candidates
+func answerOffers(conn *Conn, candidates ...ice.Candidate) {
+	conn.SetSignalCandidate(func(ice.Candidate) error {
+		return nil
+	})
+	conn.SetSignalOffer(func(string, string) error {
+		go func() {
+			deliver(func() bool {
+				return conn.OnRemoteAnswer(IceCredentials{UFrag: "remoteufragremot", Pwd: "remotepwdremotepwdremotepwdremot"})
+			})
+			for _, candidate := range candidates {
+				conn.OnRemoteCandidate(candidate)
+			}
+		}()
+		return nil
+	})
+}
+
+func TestConn_Open_SignalingTimeout(t *testing.T) {
+	config := connConf
+	config.Timeout = 200 * time.Millisecond
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the remote peer never answers
+	conn.SetSignalOffer(func(string, string) error {
+		return nil
+	})
+
+	err = conn.Open()
+	assert.Equal(t, FailureClassOf(err), FailureSignalingTimeout)
+	assert.Equal(t, conn.LastFailure(), FailureSignalingTimeout)
+}
+
+func TestConn_Open_PeerOffline(t *testing.T) {
+	config := connConf
+	config.Timeout = 10 * time.Second
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Signal Service acknowledges that the remote peer isn't connected
+	conn.SetSignalOffer(func(string, string) error {
+		return fmt.Errorf("%w: peer not connected", ErrPeerOffline)
+	})
+
+	start := time.Now()
+	err = conn.Open()
+	assert.Equal(t, FailureClassOf(err), FailurePeerOffline)
+	assert.Equal(t, conn.LastFailure(), FailurePeerOffline)
+	if elapsed := time.Since(start); elapsed >= config.Timeout {
+		t.Errorf("expecting the attempt to fail without waiting for the timeout, took %s", elapsed)
+	}
+}
+
+func TestConn_Open_ReportsOutcome(t *testing.T) {
+	conn, err := NewConn(connConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetSignalOffer(func(string, string) error {
+		return fmt.Errorf("%w: peer not connected", ErrPeerOffline)
+	})
+	var outcomes []FailureClass
+	conn.SetOnAttemptOutcome(func(class FailureClass) {
+		outcomes = append(outcomes, class)
+	})
+
+	err = conn.Open()
+	assert.Equal(t, FailureClassOf(err), FailurePeerOffline)
+	assert.Equal(t, outcomes, []FailureClass{FailurePeerOffline})
+
+	// an attempt closed externally has no outcome
+	conn.SetSignalOffer(func(string, string) error {
+		return nil
+	})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = conn.Close()
+	}()
+	_ = conn.Open()
+	assert.Equal(t, outcomes, []FailureClass{FailurePeerOffline})
+}
+
+func TestConn_Open_NoCandidates(t *testing.T) {
+	config := connConf
+	config.AttemptTimeout = time.Second
+	config.CandidateHarvester = noCandidatesHarvester{}
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerOffers(conn)
+
+	start := time.Now()
+	err = conn.Open()
+	assert.Equal(t, FailureClassOf(err), FailureNoCandidates)
+	assert.Equal(t, conn.LastFailure(), FailureNoCandidates)
+	assert.Equal(t, time.Since(start) < 5*time.Second, true, "expecting the attempt to be aborted after the timeout")
+}
+
+func TestConn_Open_ICEFailed(t *testing.T) {
+	config := connConf
+	config.AttemptTimeout = 2 * time.Second
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the remote candidate is unreachable (TEST-NET-1)
+	unreachable, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network:   "udp",
+		Address:   "192.0.2.1",
+		Port:      9,
+		Component: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	answerOffers(conn, unreachable)
+
+	err = conn.Open()
+	assert.Equal(t, FailureClassOf(err), FailureICEFailed)
+	assert.Equal(t, conn.LastFailure(), FailureICEFailed)
+}
+
+func TestConn_Open_ProxyFailed(t *testing.T) {
+	// the peers connect but there is no local Wireguard interface to proxy to
+	localConf := connConf
+	localConf.AttemptTimeout = 5 * time.Second
+	remoteConf := localConf
+	remoteConf.Key, remoteConf.LocalKey = connConf.LocalKey, connConf.Key
+	local, err := NewConn(localConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewConn(remoteConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][]*Conn{{local, remote}, {remote, local}} {
+		from, to := pair[0], pair[1]
+		from.SetSignalOffer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteOffer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalAnswer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteAnswer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalCandidate(func(candidate ice.Candidate) error {
+			to.OnRemoteCandidate(candidate)
+			return nil
+		})
+	}
+
+	errs := make(chan error, 2)
+	for _, conn := range []*Conn{local, remote} {
+		go func(conn *Conn) {
+			errs <- conn.Open()
+		}(conn)
+	}
+	var classes []FailureClass
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			classes = append(classes, FailureClassOf(err))
+		case <-time.After(30 * time.Second):
+			t.Fatal("expecting the connection attempts to fail")
+		}
+	}
+	assert.Equal(t, classes[0], FailureProxyFailed)
+	// the other peer might not have connected before the first one has given up
+	assert.Equal(t, classes[1] == FailureProxyFailed || classes[1] == FailureICEFailed, true, string(classes[1]))
+}
+
+func TestConn_Open_DialLimiterRemoteOffer(t *testing.T) {
+	limiter := NewDialLimiter(1)
+	localConf := connConf
+	localConf.AttemptTimeout = 5 * time.Second
+	localConf.DialLimiter = limiter
+	remoteConf := connConf
+	remoteConf.AttemptTimeout = 5 * time.Second
+	remoteConf.Key, remoteConf.LocalKey = connConf.LocalKey, connConf.Key
+	local, err := NewConn(localConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewConn(remoteConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][]*Conn{{local, remote}, {remote, local}} {
+		from, to := pair[0], pair[1]
+		from.SetSignalOffer(func(uFrag string, pwd string) error {
+			go to.OnRemoteOffer(IceCredentials{UFrag: uFrag, Pwd: pwd})
+			return nil
+		})
+		from.SetSignalAnswer(func(uFrag string, pwd string) error {
+			go to.OnRemoteAnswer(IceCredentials{UFrag: uFrag, Pwd: pwd})
+			return nil
+		})
+		from.SetSignalCandidate(func(candidate ice.Candidate) error {
+			to.OnRemoteCandidate(candidate)
+			return nil
+		})
+	}
+
+	// another connection attempt holds the only slot
+	limiter.slots <- struct{}{}
+	localErr := make(chan error, 1)
+	go func() {
+		localErr <- local.Open()
+	}()
+	time.Sleep(500 * time.Millisecond)
+	go func() {
+		_ = remote.Open()
+	}()
+
+	select {
+	case err := <-localErr:
+		// the peers negotiate but there is no local Wireguard interface to proxy to,
+		// the local peer might not have connected before the remote one has given up
+		class := FailureClassOf(err)
+		assert.Equal(t, class == FailureProxyFailed || class == FailureICEFailed, true, string(class))
+	case <-time.After(10 * time.Second):
+		_ = local.Close()
+		t.Fatal("expecting the remote offer to be answered while waiting for a slot")
+	}
+	assert.Equal(t, limiter.Dialing(), 1)
+	_ = remote.Close()
+}
+
+func TestConn_verifyEndpoint(t *testing.T) {
+	local, err := ice.NewCandidateHost(&ice.CandidateHostConfig{Network: "udp", Address: "10.0.0.1", Port: 51820, Component: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the remote peer advertises its server reflexive candidate only
+	advertised, err := ice.NewCandidateServerReflexive(&ice.CandidateServerReflexiveConfig{
+		Network: "udp", Address: "198.51.100.1", Port: 40000, Component: 1, RelAddr: "192.168.1.2", RelPort: 51820,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofed, err := ice.NewCandidateHost(&ice.CandidateHostConfig{Network: "udp", Address: "203.0.113.7", Port: 51820, Component: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	natMapped, err := ice.NewCandidatePeerReflexive(&ice.CandidatePeerReflexiveConfig{
+		Network: "udp", Address: "198.51.100.1", Port: 40001, Component: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofedMapped, err := ice.NewCandidatePeerReflexive(&ice.CandidatePeerReflexiveConfig{
+		Network: "udp", Address: "203.0.113.7", Port: 40001, Component: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []struct {
+		name     string
+		remote   ice.Candidate
+		rejected bool
+	}{
+		{"Advertised Candidate", advertised, false},
+		{"Mismatched Endpoint", spoofed, true},
+		{"Peer Reflexive Of Advertised Address", natMapped, false},
+		{"Peer Reflexive Of Other Address", spoofedMapped, true},
+	}
+
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			conn, err := NewConn(connConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var rejected []string
+			conn.SetOnEndpointRejected(func(endpoint string) {
+				rejected = append(rejected, endpoint)
+			})
+			conn.advertiseRemoteCandidate(advertised)
+
+			err = conn.verifyEndpoint(&ice.CandidatePair{Local: local, Remote: table.remote})
+			assert.Equal(t, conn.rejectedEndpoint(err), table.rejected)
+			if table.rejected {
+				assert.Equal(t, rejected, []string{candidateEndpoint(table.remote)})
+			} else {
+				assert.Equal(t, err, nil)
+				assert.Equal(t, len(rejected), 0)
+			}
+		})
+	}
+}
+
+func TestConn_Trace(t *testing.T) {
+	config := connConf
+	config.Timeout = 200 * time.Millisecond
+	config.Trace = true
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetSignalOffer(func(string, string) error {
+		return nil
+	})
+
+	// the remote peer never answers, so the attempt stops after the offer
+	_ = conn.Open()
+	trace := conn.Trace()
+	assert.Equal(t, len(trace), 1)
+	assert.Equal(t, trace[0].Phase, TraceOfferSent)
+
+	// a phase is recorded once per attempt
+	conn.tracePhase(TraceGatheringDone)
+	conn.tracePhase(TraceGatheringDone)
+	assert.Equal(t, len(conn.Trace()), 2)
+
+	// the next attempt starts over
+	_ = conn.Open()
+	assert.Equal(t, len(conn.Trace()), 1)
+
+	untraced, err := NewConn(connConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untraced.tracePhase(TraceOfferSent)
+	assert.Equal(t, untraced.Trace() == nil, true)
+}
+
+// connectStaged bridges the signaling of two staged Conns delivering each message once, rewriting each candidate with the given function
+func connectStaged(t *testing.T, rewrite func(candidate ice.Candidate) ice.Candidate) (*Conn, *Conn) {
+	localConf := connConf
+	localConf.AttemptTimeout = 20 * time.Second
+	localConf.StagedICE = true
+	localConf.StageTimeouts = StageTimeouts{Direct: time.Second, ServerReflexive: time.Second, Relay: 10 * time.Second}
+	remoteConf := localConf
+	remoteConf.Key, remoteConf.LocalKey = connConf.LocalKey, connConf.Key
+	local, err := NewConn(localConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewConn(remoteConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][]*Conn{{local, remote}, {remote, local}} {
+		from, to := pair[0], pair[1]
+		// the messages are delivered once like the Signal Service does, the Conns have to take the offers sent
+		// while they are between the stages
+		from.SetSignalOffer(func(uFrag string, pwd string) error {
+			go to.OnRemoteOffer(IceCredentials{UFrag: uFrag, Pwd: pwd})
+			return nil
+		})
+		from.SetSignalAnswer(func(uFrag string, pwd string) error {
+			go to.OnRemoteAnswer(IceCredentials{UFrag: uFrag, Pwd: pwd})
+			return nil
+		})
+		from.SetSignalCandidate(func(candidate ice.Candidate) error {
+			to.OnRemoteCandidate(rewrite(candidate))
+			return nil
+		})
+	}
+	return local, remote
+}
+
+// openBoth opens both Conns and returns them with their failure classes in the order the attempts have ended
+func openBoth(t *testing.T, local, remote *Conn) ([]*Conn, []FailureClass) {
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, conn := range []*Conn{local, remote} {
+		go func(conn *Conn) {
+			results <- result{conn: conn, err: conn.Open()}
+		}(conn)
+	}
+	var conns []*Conn
+	var classes []FailureClass
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <-results:
+			conns = append(conns, r.conn)
+			classes = append(classes, FailureClassOf(r.err))
+		case <-time.After(30 * time.Second):
+			t.Fatal("expecting the connection attempts to end")
+		}
+	}
+	return conns, classes
+}
+
+func TestConn_Open_StagedICE_Direct(t *testing.T) {
+	local, remote := connectStaged(t, func(candidate ice.Candidate) ice.Candidate {
+		return candidate
+	})
+
+	// the peers connect but there is no local Wireguard interface to proxy to,
+	// the other peer escalates once the first one has given up
+	conns, classes := openBoth(t, local, remote)
+	assert.Equal(t, classes[0], FailureProxyFailed)
+	assert.Equal(t, conns[0].Stage(), ICEStageDirect)
+}
+
+func TestConn_Open_StagedICE_Relay(t *testing.T) {
+	// the fake signaling presents the host candidates of the peers as relay candidates,
+	// so the peers can't connect before the relay stage
+	local, remote := connectStaged(t, func(candidate ice.Candidate) ice.Candidate {
+		relay, err := ice.NewCandidateRelay(&ice.CandidateRelayConfig{
+			Network:   "udp",
+			Address:   candidate.Address(),
+			Port:      candidate.Port(),
+			Component: candidate.Component(),
+			RelAddr:   "0.0.0.0",
+			RelPort:   0,
+		})
+		if err != nil {
+			t.Error(err)
+			return candidate
+		}
+		return relay
+	})
+
+	start := time.Now()
+	conns, classes := openBoth(t, local, remote)
+	assert.Equal(t, classes[0], FailureProxyFailed)
+	assert.Equal(t, conns[0].Stage(), ICEStageRelay)
+	assert.Equal(t, time.Since(start) >= 2*time.Second, true, "expecting the direct and srflx stages to time out first")
+	// the failed stages don't count as failed attempts
+	assert.Equal(t, conns[0].failedAttempts, 0)
+}
+
+func TestConn_Open_StagedICE_UnevenStages(t *testing.T) {
+	local, remote := connectStaged(t, func(candidate ice.Candidate) ice.Candidate {
+		relay, err := ice.NewCandidateRelay(&ice.CandidateRelayConfig{
+			Network:   "udp",
+			Address:   candidate.Address(),
+			Port:      candidate.Port(),
+			Component: candidate.Component(),
+			RelAddr:   "0.0.0.0",
+			RelPort:   0,
+		})
+		if err != nil {
+			t.Error(err)
+			return candidate
+		}
+		return relay
+	})
+	// the remote peer is still checking the direct candidates when the local one sends the offer of the srflx stage,
+	// it has to give up on the direct stage and proceed with the offer instead of letting the local peer time out
+	remote.config.StageTimeouts.Direct = 5 * time.Second
+
+	start := time.Now()
+	conns, classes := openBoth(t, local, remote)
+	assert.Equal(t, classes[0], FailureProxyFailed)
+	assert.Equal(t, conns[0].Stage(), ICEStageRelay)
+	assert.Equal(t, time.Since(start) < 5*time.Second, true, "expecting the remote peer to leave the direct stage early")
+}
+
+func TestStageTimeouts_stages(t *testing.T) {
+	stages := StageTimeouts{ServerReflexive: time.Second}.stages()
+	assert.Equal(t, len(stages), 3)
+	assert.Equal(t, stages[0].stage, ICEStageDirect)
+	assert.Equal(t, stages[0].timeout, DefaultDirectStageTimeout)
+	assert.Equal(t, stages[0].candidateTypes, []ice.CandidateType{ice.CandidateTypeHost})
+	assert.Equal(t, stages[1].stage, ICEStageServerReflexive)
+	assert.Equal(t, stages[1].timeout, time.Second)
+	assert.Equal(t, stages[2].stage, ICEStageRelay)
+	assert.Equal(t, stages[2].timeout, DefaultRelayStageTimeout)
+	assert.Equal(t, stages[2].candidateTypes, allCandidateTypes)
+}
@@ -0,0 +1,48 @@
+package peer
+
+import "time"
+
+// DialLimiter limits the number of the connection attempts negotiating at the same time, so a NetworkMap with many
+// new peers doesn't flood the Signal Service and the local sockets with simultaneous ICE negotiations.
+// An attempt holds a slot from sending the offer until the connection has been established or the attempt has failed,
+// the established connections don't hold any. An attempt answering the offer of a remote peer doesn't wait for a slot,
+// as the remote peer is waiting for the answer
+type DialLimiter struct {
+	slots chan struct{}
+}
+
+// NewDialLimiter returns a limiter of max connection attempts negotiating at the same time
+func NewDialLimiter(max int) *DialLimiter {
+	return &DialLimiter{slots: make(chan struct{}, max)}
+}
+
+// Dialing returns the number of the connection attempts negotiating
+func (l *DialLimiter) Dialing() int {
+	return len(l.slots)
+}
+
+// acquireDialSlot waits for a free slot of the limiter and returns true once it has been taken. It returns false
+// without a slot once the remote peer has offered the connection meanwhile, the offer stays queued for the attempt
+// to answer it
+func (conn *Conn) acquireDialSlot(limiter *DialLimiter, signalingTimeout time.Duration) (bool, error) {
+	for {
+		select {
+		case limiter.slots <- struct{}{}:
+			return true, nil
+		case offer := <-conn.remoteOffersCh:
+			if conn.outdatedOffer(offer, signalingTimeout) {
+				continue
+			}
+			conn.log.Debugf("answering connection offer of peer %s without waiting for other connection attempts",
+				conn.config.Key)
+			conn.queueRemoteOffer(offer)
+			return false, nil
+		case <-conn.closeCh:
+			return false, NewConnectionClosedError(conn.config.Key)
+		}
+	}
+}
+
+func (l *DialLimiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,67 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	"github.com/pion/ice/v2"
+)
+
+// candidateEndpoint returns the address and the port of the candidate
+func candidateEndpoint(candidate ice.Candidate) string {
+	return net.JoinHostPort(candidate.Address(), strconv.Itoa(candidate.Port()))
+}
+
+// advertiseRemoteCandidate records a candidate the remote peer has signalled, the caller holds the lock
+func (conn *Conn) advertiseRemoteCandidate(candidate ice.Candidate) {
+	if conn.advertisedEndpoints == nil {
+		conn.advertisedEndpoints = map[string]struct{}{}
+	}
+	conn.advertisedEndpoints[candidateEndpoint(candidate)] = struct{}{}
+}
+
+// verifyEndpoint checks that the remote candidate of the selected pair, which has passed the connectivity checks,
+// is one the remote peer has advertised, so the Wireguard endpoint can't be pointed at a host the peer doesn't own.
+// A peer reflexive candidate is learned from a connectivity check rather than signalled, it is accepted if its address
+// matches an advertised candidate as a symmetric NAT maps every destination to another port.
+// The caller holds the lock
+func (conn *Conn) verifyEndpoint(pair *ice.CandidatePair) error {
+	endpoint := candidateEndpoint(pair.Remote)
+	if _, ok := conn.advertisedEndpoints[endpoint]; ok {
+		return nil
+	}
+
+	if pair.Remote.Type() == ice.CandidateTypePeerReflexive {
+		for advertised := range conn.advertisedEndpoints {
+			host, _, err := net.SplitHostPort(advertised)
+			if err == nil && host == pair.Remote.Address() {
+				return nil
+			}
+		}
+	}
+
+	return NewEndpointRejectedError(conn.config.Key, endpoint)
+}
+
+// rejectedEndpoint logs and reports an endpoint rejected by verifyEndpoint, returns false if err is of another kind.
+// The caller doesn't hold the lock
+func (conn *Conn) rejectedEndpoint(err error) bool {
+	var rejectedErr *EndpointRejectedError
+	if !errors.As(err, &rejectedErr) {
+		return false
+	}
+
+	conn.log.Warnf("rejected endpoint %s of peer %s, it doesn't match a candidate the peer has advertised",
+		rejectedErr.Endpoint, conn.config.Key)
+	if conn.onEndpointRejected != nil {
+		conn.onEndpointRejected(rejectedErr.Endpoint)
+	}
+	return true
+}
+
+// SetOnEndpointRejected sets a handler function to be triggered by Conn when the endpoint negotiated with the remote
+// peer has been rejected because the peer hasn't advertised it
+func (conn *Conn) SetOnEndpointRejected(handler func(endpoint string)) {
+	conn.onEndpointRejected = handler
+}
@@ -1,6 +1,7 @@
 package peer
 
 import (
+	"errors"
 	"fmt"
 	"time"
 )
@@ -70,3 +71,80 @@ func NewConnectionAlreadyClosed(peer string) error {
 		peer: peer,
 	}
 }
+
+// EndpointRejectedError is an error indicating that the endpoint negotiated with a peer doesn't match a candidate
+// the peer has advertised
+type EndpointRejectedError struct {
+	peer     string
+	Endpoint string
+}
+
+func (e *EndpointRejectedError) Error() string {
+	return fmt.Sprintf("endpoint %s of peer %s doesn't match an advertised candidate", e.Endpoint, e.peer)
+}
+
+// NewEndpointRejectedError creates a new EndpointRejectedError error
+func NewEndpointRejectedError(peer string, endpoint string) error {
+	return &EndpointRejectedError{
+		peer:     peer,
+		Endpoint: endpoint,
+	}
+}
+
+// FailureClass is a class of a failed connection attempt telling what has failed
+type FailureClass string
+
+const (
+	// FailureNoCandidates is an attempt neither peer gathered a candidate for, e.g. when the STUN and TURN servers are
+	// blackholed and no network interface is usable
+	FailureNoCandidates FailureClass = "no-candidates"
+	// FailureSignalingTimeout is an attempt the remote peer hasn't answered through the Signal Service
+	FailureSignalingTimeout FailureClass = "signaling-timeout"
+	// FailureICEFailed is an attempt none of the exchanged candidate pairs has connected
+	FailureICEFailed FailureClass = "ice-failed"
+	// FailureProxyFailed is an attempt that connected but the proxy to the local Wireguard hasn't started
+	FailureProxyFailed FailureClass = "proxy-failed"
+	// FailureEndpointRejected is an attempt that connected to an endpoint the remote peer hasn't advertised
+	FailureEndpointRejected FailureClass = "endpoint-rejected"
+	// FailurePeerOffline is an attempt the Signal Service has acknowledged the remote peer isn't connected to,
+	// so the offer hasn't reached it
+	FailurePeerOffline FailureClass = "peer-offline"
+)
+
+// ErrPeerOffline is returned by the signal handlers of a Conn when the Signal Service has acknowledged that the remote
+// peer isn't connected to it
+var ErrPeerOffline = errors.New("remote peer is offline")
+
+// ConnectionFailedError is an error of a failed connection attempt to a peer with its failure class
+type ConnectionFailedError struct {
+	peer  string
+	Class FailureClass
+	err   error
+}
+
+func (e *ConnectionFailedError) Error() string {
+	return fmt.Sprintf("connection to peer %s failed (%s): %v", e.peer, e.Class, e.err)
+}
+
+func (e *ConnectionFailedError) Unwrap() error {
+	return e.err
+}
+
+// NewConnectionFailedError creates a new ConnectionFailedError error classifying err
+func NewConnectionFailedError(peer string, class FailureClass, err error) error {
+	return &ConnectionFailedError{
+		peer:  peer,
+		Class: class,
+		err:   err,
+	}
+}
+
+// FailureClassOf returns the failure class of an error returned by Conn.Open, an empty class if the attempt
+// hasn't failed, e.g. the connection has been closed
+func FailureClassOf(err error) FailureClass {
+	var failedErr *ConnectionFailedError
+	if errors.As(err, &failedErr) {
+		return failedErr.Class
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package peer
+
+import (
+	"context"
+	"net"
+	_ "unsafe" // go:linkname
+
+	"github.com/pion/ice/v2"
+)
+
+// CandidateHarvester gathers the local ICE candidates of the connections to the remote peers.
+// Platform bindings (e.g. iOS and Android) where the default gathering misses network interfaces supply their own
+// implementation through the EngineConfig
+type CandidateHarvester interface {
+	// Harvest gathers the local candidates of a connection once both peers have exchanged their credentials.
+	// The candidates discovered by the agent are delivered to the agent's OnCandidate handler which signals them to
+	// the remote peer. An implementation that discovers candidates on its own passes them to add along with the
+	// sockets they are bound to. They are added to the agent, which signals them and runs the connectivity checks on
+	// them like on the candidates it has discovered itself
+	Harvest(agent *ice.Agent, add func(candidate ice.Candidate, conn net.PacketConn) error) error
+	// OnNetworkChange registers a handler the harvester calls when the network of the device has changed
+	// (e.g. switched from Wi-Fi to cellular), so the connections are re-established with the new candidates
+	OnNetworkChange(handler func())
+}
+
+// defaultCandidateHarvester gathers the candidates of the network interfaces discovered by the ICE agent
+type defaultCandidateHarvester struct{}
+
+// Harvest starts the candidate gathering of the agent
+func (defaultCandidateHarvester) Harvest(agent *ice.Agent, _ func(ice.Candidate, net.PacketConn) error) error {
+	return agent.GatherCandidates()
+}
+
+// OnNetworkChange does nothing, the network changes are detected by the ICE agent as broken connections
+func (defaultCandidateHarvester) OnNetworkChange(func()) {}
+
+// NewDefaultCandidateHarvester returns the CandidateHarvester used when no other one is configured
+func NewDefaultCandidateHarvester() CandidateHarvester {
+	return defaultCandidateHarvester{}
+}
+
+// harvest gathers the local candidates of the agent with the configured harvester
+func (conn *Conn) harvest(agent *ice.Agent) error {
+	harvester := conn.config.CandidateHarvester
+	if harvester == nil {
+		harvester = NewDefaultCandidateHarvester()
+	}
+	return harvester.Harvest(agent, func(candidate ice.Candidate, candidateConn net.PacketConn) error {
+		return AddLocalCandidate(agent, candidate, candidateConn)
+	})
+}
+
+// AddLocalCandidate adds a local candidate discovered outside of the agent, reading and writing on the conn, to the
+// agent. The agent pairs it with the remote candidates, checks the pairs and passes it to its OnCandidate handler
+func AddLocalCandidate(agent *ice.Agent, candidate ice.Candidate, conn net.PacketConn) error {
+	return agentAddCandidate(agent, context.Background(), candidate, conn)
+}
+
+// agentAddCandidate is the method the agent adds its own gathered candidates with, the ICE library doesn't export it
+//
+//go:linkname agentAddCandidate github.com/pion/ice/v2.(*Agent).addCandidate
+func agentAddCandidate(agent *ice.Agent, ctx context.Context, candidate ice.Candidate, conn net.PacketConn) error
@@ -0,0 +1,168 @@
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/ice/v2"
+)
+
+// loopbackCandidateHarvester supplies a host candidate of its own loopback socket instead of gathering the candidates
+// of the agent
+type loopbackCandidateHarvester struct {
+	harvested chan harvested
+}
+
+// harvested is a candidate supplied to an agent
+type harvested struct {
+	agent     *ice.Agent
+	candidate ice.Candidate
+}
+
+func (h *loopbackCandidateHarvester) Harvest(agent *ice.Agent, add func(ice.Candidate, net.PacketConn) error) error {
+	socket, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	addr := socket.LocalAddr().(*net.UDPAddr)
+	candidate, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network:   "udp",
+		Address:   addr.IP.String(),
+		Port:      addr.Port,
+		Component: 1,
+	})
+	if err != nil {
+		_ = socket.Close()
+		return err
+	}
+	// the agent closes the socket along with the candidate
+	err = add(candidate, socket)
+	if err != nil {
+		_ = socket.Close()
+		return err
+	}
+	select {
+	case h.harvested <- harvested{agent: agent, candidate: candidate}:
+	default:
+	}
+	return nil
+}
+
+func (h *loopbackCandidateHarvester) OnNetworkChange(func()) {}
+
+func TestConn_CandidateHarvester(t *testing.T) {
+	harvester := &loopbackCandidateHarvester{harvested: make(chan harvested, 1)}
+
+	config := connConf
+	config.AttemptTimeout = 5 * time.Second
+	config.CandidateHarvester = harvester
+	conn, err := NewConn(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signaled := make(chan ice.Candidate, 1)
+	conn.SetSignalOffer(func(uFrag string, pwd string) error { return nil })
+	conn.SetSignalAnswer(func(uFrag string, pwd string) error { return nil })
+	conn.SetSignalCandidate(func(candidate ice.Candidate) error {
+		signaled <- candidate
+		return nil
+	})
+
+	opened := make(chan error, 1)
+	go func() {
+		opened <- conn.Open()
+	}()
+
+	for !conn.OnRemoteAnswer(IceCredentials{UFrag: "test", Pwd: "testtesttesttesttesttest"}) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var supplied harvested
+	select {
+	case supplied = <-harvester.harvested:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expecting the connection to harvest the candidates with the configured harvester")
+	}
+
+	select {
+	case got := <-signaled:
+		if got.Address() != supplied.candidate.Address() || got.Port() != supplied.candidate.Port() {
+			t.Errorf("expecting the harvested candidate %s to be signaled, got %s", supplied.candidate, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("expecting the harvested candidate to be signaled to the remote peer")
+	}
+
+	candidates, err := supplied.agent.GetLocalCandidates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || !candidates[0].Equal(supplied.candidate) {
+		t.Errorf("expecting the harvested candidate to be added to the agent, got %v", candidates)
+	}
+
+	// there is no remote peer, closing the agent stops the connection
+	err = supplied.agent.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-opened:
+	case <-time.After(3 * time.Second):
+		t.Fatal("expecting the connection to stop after the agent has been closed")
+	}
+}
+
+func TestConn_CandidateHarvester_Connects(t *testing.T) {
+	// the peers have only the loopback candidates of their harvesters, the agents gather none, so connecting proves
+	// the harvested candidates are checked and selected by the agents
+	localConf := connConf
+	localConf.AttemptTimeout = 5 * time.Second
+	localConf.CandidateHarvester = &loopbackCandidateHarvester{}
+	remoteConf := localConf
+	remoteConf.Key, remoteConf.LocalKey = connConf.LocalKey, connConf.Key
+	remoteConf.CandidateHarvester = &loopbackCandidateHarvester{}
+	local, err := NewConn(localConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewConn(remoteConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pair := range [][]*Conn{{local, remote}, {remote, local}} {
+		from, to := pair[0], pair[1]
+		from.SetSignalOffer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteOffer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalAnswer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteAnswer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalCandidate(func(candidate ice.Candidate) error {
+			to.OnRemoteCandidate(candidate)
+			return nil
+		})
+	}
+
+	errs := make(chan error, 2)
+	for _, conn := range []*Conn{local, remote} {
+		go func(conn *Conn) {
+			errs <- conn.Open()
+		}(conn)
+	}
+	select {
+	case err := <-errs:
+		// the peers connect but there is no local Wireguard interface to proxy to
+		if class := FailureClassOf(err); class != FailureProxyFailed {
+			t.Errorf("expecting the peers to connect over the harvested candidates, got %s: %v", class, err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("expecting the connection attempts to end")
+	}
+	_ = local.Close()
+	_ = remote.Close()
+}
@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"net"
+
+	"github.com/pion/ice/v2"
+)
+
+// IPFamily is an IP address family of the ICE candidates
+type IPFamily string
+
+const (
+	// IPFamilyAuto gathers IPv4 candidates through the shared UDP sockets and uses the STUN and TURN servers as received
+	IPFamilyAuto IPFamily = "auto"
+	// IPFamilyIPv4 gathers IPv4 candidates only and drops the STUN and TURN servers having no IPv4 address
+	// (e.g. on networks with broken IPv6)
+	IPFamilyIPv4 IPFamily = "ipv4"
+	// IPFamilyIPv6 gathers IPv6 candidates before the IPv4 ones and puts the STUN and TURN servers having an IPv6 address first.
+	// The shared UDP sockets are IPv4 only, so the candidates are gathered on the sockets of the ICE agent
+	IPFamilyIPv6 IPFamily = "ipv6"
+)
+
+// IsValid indicates whether the IP family is supported. An empty IP family is treated as IPFamilyAuto
+func (f IPFamily) IsValid() bool {
+	switch f {
+	case "", IPFamilyAuto, IPFamilyIPv4, IPFamilyIPv6:
+		return true
+	default:
+		return false
+	}
+}
+
+// networkTypes returns the ICE network types to gather candidates of, in the order of the IP family preference
+func (f IPFamily) networkTypes() []ice.NetworkType {
+	if f == IPFamilyIPv6 {
+		return []ice.NetworkType{ice.NetworkTypeUDP6, ice.NetworkTypeUDP4}
+	}
+	return []ice.NetworkType{ice.NetworkTypeUDP4}
+}
+
+// ipFamilyOf returns the IP family of an IP address or an empty string if the address is invalid
+func ipFamilyOf(address string) IPFamily {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return IPFamilyIPv4
+	default:
+		return IPFamilyIPv6
+	}
+}
+
+// orderStunTurn returns the STUN and TURN URLs ordered by the IP family preference of the hosts' addresses.
+// The URLs keep their relative order within the same group, the hosts which couldn't be resolved are kept after the preferred ones.
+// With IPFamilyIPv4 the hosts having only IPv6 addresses are dropped
+func orderStunTurn(urls []*ice.URL, preference IPFamily, lookupIP func(host string) ([]net.IP, error)) []*ice.URL {
+	if preference != IPFamilyIPv4 && preference != IPFamilyIPv6 {
+		return urls
+	}
+
+	var preferred, unresolved, other []*ice.URL
+	for _, url := range urls {
+		ips, err := lookupIP(url.Host)
+		if err != nil || len(ips) == 0 {
+			log.Debugf("failed resolving host %s of %s, keeping it: %v", url.Host, url.String(), err)
+			unresolved = append(unresolved, url)
+			continue
+		}
+
+		hasPreferred := false
+		for _, ip := range ips {
+			if ipFamilyOf(ip.String()) == preference {
+				hasPreferred = true
+				break
+			}
+		}
+
+		switch {
+		case hasPreferred:
+			preferred = append(preferred, url)
+		case preference == IPFamilyIPv4:
+			log.Debugf("dropping %s having no IPv4 address", url.String())
+		default:
+			other = append(other, url)
+		}
+	}
+
+	ordered := make([]*ice.URL, 0, len(preferred)+len(unresolved)+len(other))
+	ordered = append(ordered, preferred...)
+	ordered = append(ordered, unresolved...)
+	return append(ordered, other...)
+}
@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/pion/ice/v2"
+)
+
+func TestIPFamily_IsValid(t *testing.T) {
+	for _, family := range []IPFamily{"", IPFamilyAuto, IPFamilyIPv4, IPFamilyIPv6} {
+		assert.Equal(t, family.IsValid(), true, fmt.Sprintf("IP family %q should be valid", family))
+	}
+	assert.Equal(t, IPFamily("ipv5").IsValid(), false)
+}
+
+func TestIPFamily_NetworkTypes(t *testing.T) {
+	assert.Equal(t, IPFamilyAuto.networkTypes(), []ice.NetworkType{ice.NetworkTypeUDP4})
+	assert.Equal(t, IPFamilyIPv4.networkTypes(), []ice.NetworkType{ice.NetworkTypeUDP4})
+	assert.Equal(t, IPFamilyIPv6.networkTypes(), []ice.NetworkType{ice.NetworkTypeUDP6, ice.NetworkTypeUDP4})
+}
+
+func TestIPFamilyOf(t *testing.T) {
+	assert.Equal(t, ipFamilyOf("100.64.0.1"), IPFamilyIPv4)
+	assert.Equal(t, ipFamilyOf("2001:db8::1"), IPFamilyIPv6)
+	assert.Equal(t, ipFamilyOf("turn.wiretrustee.com"), IPFamily(""))
+}
+
+func TestOrderStunTurn(t *testing.T) {
+	hosts := map[string][]net.IP{
+		"v4.example.com":        {net.ParseIP("192.0.2.1")},
+		"v6.example.com":        {net.ParseIP("2001:db8::1")},
+		"dualstack.example.com": {net.ParseIP("2001:db8::2"), net.ParseIP("192.0.2.2")},
+	}
+	lookupIP := func(host string) ([]net.IP, error) {
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+
+	var urls []*ice.URL
+	for _, raw := range []string{
+		"turn:v6.example.com:3478",
+		"stun:unknown.example.com:3478",
+		"turn:v4.example.com:3478",
+		"stun:dualstack.example.com:3478",
+	} {
+		url, err := ice.ParseURL(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, url)
+	}
+
+	hostsOf := func(urls []*ice.URL) []string {
+		var res []string
+		for _, url := range urls {
+			res = append(res, url.Host)
+		}
+		return res
+	}
+
+	testCases := []struct {
+		name       string
+		preference IPFamily
+		expected   []string
+	}{
+		{
+			name:       "auto keeps the order",
+			preference: IPFamilyAuto,
+			expected:   []string{"v6.example.com", "unknown.example.com", "v4.example.com", "dualstack.example.com"},
+		},
+		{
+			name:       "ipv4 drops IPv6 only hosts",
+			preference: IPFamilyIPv4,
+			expected:   []string{"v4.example.com", "dualstack.example.com", "unknown.example.com"},
+		},
+		{
+			name:       "ipv6 puts IPv6 hosts first",
+			preference: IPFamilyIPv6,
+			expected:   []string{"v6.example.com", "dualstack.example.com", "unknown.example.com", "v4.example.com"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			assert.Equal(t, hostsOf(orderStunTurn(urls, testCase.preference, lookupIP)), testCase.expected)
+		})
+	}
+}
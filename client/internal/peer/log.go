@@ -0,0 +1,6 @@
+package peer
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the connections to the remote peers, its level is set by the peer component level
+var log = util.Logger("peer")
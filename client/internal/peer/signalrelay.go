@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// SignalRelayAttempts is the number of failed ICE negotiations after which the connection is relayed
+	// through the Signal Service, if enabled
+	SignalRelayAttempts = 3
+	// DefaultSignalRelayLimitKbps caps the bandwidth of a connection relayed through the Signal Service if no limit is set
+	DefaultSignalRelayLimitKbps = 1024
+	// signalRelayQueueSize is the number of received packets buffered until the proxy reads them.
+	// The packets are dropped when the queue is full, like on a congested link
+	signalRelayQueueSize = 128
+)
+
+// signalRelayAddr is the address of both ends of a signalRelayConn, the key of the peer
+type signalRelayAddr string
+
+func (a signalRelayAddr) Network() string {
+	return "signal-relay"
+}
+
+func (a signalRelayAddr) String() string {
+	return string(a)
+}
+
+// signalRelayConn is a net.Conn passing the Wireguard packets through the Signal Service instead of an ICE connection.
+// The packets exceeding the bandwidth limit or the receive queue are dropped, Wireguard retransmits the handshakes
+type signalRelayConn struct {
+	localKey  string
+	remoteKey string
+	send      func(packet []byte) error
+	limiter   *tokenBucket
+	packets   chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSignalRelayConn(localKey string, remoteKey string, send func(packet []byte) error, limitKbps int) *signalRelayConn {
+	if limitKbps <= 0 {
+		limitKbps = DefaultSignalRelayLimitKbps
+	}
+	return &signalRelayConn{
+		localKey:  localKey,
+		remoteKey: remoteKey,
+		send:      send,
+		limiter:   newTokenBucket(limitKbps * 1000 / 8),
+		packets:   make(chan []byte, signalRelayQueueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+// deliver queues a packet received from the remote peer, doesn't block
+func (c *signalRelayConn) deliver(packet []byte) {
+	select {
+	case <-c.closed:
+	case c.packets <- packet:
+	default:
+	}
+}
+
+func (c *signalRelayConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	case packet := <-c.packets:
+		return copy(b, packet), nil
+	}
+}
+
+func (c *signalRelayConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	if !c.limiter.Allow(len(b), time.Now()) {
+		return len(b), nil
+	}
+
+	packet := make([]byte, len(b))
+	copy(packet, b)
+	err := c.send(packet)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *signalRelayConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *signalRelayConn) LocalAddr() net.Addr {
+	return signalRelayAddr(c.localKey)
+}
+
+func (c *signalRelayConn) RemoteAddr() net.Addr {
+	return signalRelayAddr(c.remoteKey)
+}
+
+func (c *signalRelayConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *signalRelayConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *signalRelayConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// tokenBucket limits the number of bytes per second allowing bursts of up to a second of traffic
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSecond int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+	}
+}
+
+// Allow takes n bytes from the bucket and returns true if there were enough of them
+func (b *tokenBucket) Allow(n int, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.rate {
+			b.tokens = b.rate
+		}
+	}
+	b.last = now
+
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
@@ -0,0 +1,75 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+)
+
+func TestSignalRelayConn(t *testing.T) {
+	var sent [][]byte
+	conn := newSignalRelayConn("local", "remote", func(packet []byte) error {
+		sent = append(sent, packet)
+		return nil
+	}, 0)
+
+	n, err := conn.Write([]byte("handshake initiation"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, n, 20)
+	assert.Equal(t, sent, [][]byte{[]byte("handshake initiation")})
+
+	conn.deliver([]byte("handshake response"))
+	buf := make([]byte, 1500)
+	n, err = conn.Read(buf)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(buf[:n]), "handshake response")
+
+	read := make(chan error)
+	go func() {
+		_, err := conn.Read(buf)
+		read <- err
+	}()
+	_ = conn.Close()
+
+	select {
+	case err = <-read:
+		assert.Equal(t, errors.Is(err, net.ErrClosed), true, "expecting a closed connection error")
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting Close to release the blocked Read")
+	}
+
+	_, err = conn.Write([]byte("data"))
+	assert.Equal(t, errors.Is(err, net.ErrClosed), true, "expecting a closed connection error")
+}
+
+func TestSignalRelayConn_Limit(t *testing.T) {
+	sent := 0
+	// 8 kbit/s allows 1000 bytes per second
+	conn := newSignalRelayConn("local", "remote", func(packet []byte) error {
+		sent += len(packet)
+		return nil
+	}, 8)
+
+	packet := make([]byte, 400)
+	for i := 0; i < 10; i++ {
+		n, err := conn.Write(packet)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, n, len(packet), "expecting the dropped packets to be reported as written")
+	}
+	assert.Equal(t, sent, 800, "expecting the packets exceeding the limit to be dropped")
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(1000)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bucket.Allow(1000, now), true, "expecting a burst of a second of traffic")
+	assert.Equal(t, bucket.Allow(1, now), false)
+	assert.Equal(t, bucket.Allow(500, now.Add(500*time.Millisecond)), true, "expecting the bucket to refill")
+	assert.Equal(t, bucket.Allow(1, now.Add(500*time.Millisecond)), false)
+	assert.Equal(t, bucket.Allow(1000, now.Add(time.Hour)), true, "expecting the bucket to refill up to a second of traffic")
+	assert.Equal(t, bucket.Allow(1, now.Add(time.Hour)), false)
+}
@@ -0,0 +1,141 @@
+package peer
+
+import (
+	"time"
+
+	"github.com/pion/ice/v2"
+)
+
+// ICEStage is a stage of the staged connection attempt, see ConnConfig.StagedICE
+type ICEStage string
+
+const (
+	// ICEStageDirect negotiates a connection over the host candidates only
+	ICEStageDirect ICEStage = "direct"
+	// ICEStageServerReflexive negotiates a connection over the host and the server-reflexive (STUN) candidates
+	ICEStageServerReflexive ICEStage = "srflx"
+	// ICEStageRelay negotiates a connection over all the candidates including the relay (TURN) ones
+	ICEStageRelay ICEStage = "relay"
+)
+
+const (
+	// DefaultDirectStageTimeout limits the direct stage of a staged connection attempt if no other timeout is configured
+	DefaultDirectStageTimeout = 5 * time.Second
+	// DefaultServerReflexiveStageTimeout limits the server-reflexive stage of a staged connection attempt if no other
+	// timeout is configured
+	DefaultServerReflexiveStageTimeout = 10 * time.Second
+	// DefaultRelayStageTimeout limits the relay stage of a staged connection attempt if no other timeout is configured
+	DefaultRelayStageTimeout = 30 * time.Second
+)
+
+// StageTimeouts limit the stages of a staged connection attempt, the defaults are used for the ones not set.
+// The whole attempt is still limited by ConnConfig.AttemptTimeout
+type StageTimeouts struct {
+	Direct          time.Duration
+	ServerReflexive time.Duration
+	Relay           time.Duration
+}
+
+// iceStage is a stage of the staged connection attempt with the types of the candidates it negotiates over
+type iceStage struct {
+	stage          ICEStage
+	candidateTypes []ice.CandidateType
+	timeout        time.Duration
+}
+
+// stages returns the stages of a staged connection attempt in the order they are attempted
+func (t StageTimeouts) stages() []iceStage {
+	orDefault := func(timeout, defaultTimeout time.Duration) time.Duration {
+		if timeout <= 0 {
+			return defaultTimeout
+		}
+		return timeout
+	}
+
+	return []iceStage{
+		{
+			stage:          ICEStageDirect,
+			candidateTypes: []ice.CandidateType{ice.CandidateTypeHost},
+			timeout:        orDefault(t.Direct, DefaultDirectStageTimeout),
+		},
+		{
+			stage:          ICEStageServerReflexive,
+			candidateTypes: []ice.CandidateType{ice.CandidateTypeHost, ice.CandidateTypeServerReflexive},
+			timeout:        orDefault(t.ServerReflexive, DefaultServerReflexiveStageTimeout),
+		},
+		{
+			stage:          ICEStageRelay,
+			candidateTypes: allCandidateTypes,
+			timeout:        orDefault(t.Relay, DefaultRelayStageTimeout),
+		},
+	}
+}
+
+// negotiateStaged negotiates the connection stage by stage, escalating to the next stage only when ICE has failed
+// over the candidates of the current one. Each stage is a new negotiation limited by its timeout and the deadline
+// of the whole attempt
+func (conn *Conn) negotiateStaged(deadline time.Time, signalingTimeout time.Duration) (*ice.Conn, bool, error) {
+	stages := conn.config.StageTimeouts.stages()
+
+	var err error
+	for i, s := range stages {
+		if i > 0 && !time.Now().Before(deadline) {
+			// no time left for the next stage
+			return nil, false, err
+		}
+
+		stageDeadline := time.Now().Add(s.timeout)
+		if stageDeadline.After(deadline) {
+			stageDeadline = deadline
+		}
+		stageSignalingTimeout := signalingTimeout
+		if stageSignalingTimeout > s.timeout {
+			stageSignalingTimeout = s.timeout
+		}
+
+		conn.mu.Lock()
+		conn.stage = s.stage
+		conn.mu.Unlock()
+		conn.log.Debugf("trying to connect to peer %s in the %s stage", conn.config.Key, s.stage)
+
+		var remoteConn *ice.Conn
+		var isControlling bool
+		remoteConn, isControlling, err = conn.negotiate(s.candidateTypes, stageDeadline, stageSignalingTimeout)
+		if err == nil {
+			return remoteConn, isControlling, nil
+		}
+
+		class := FailureClassOf(err)
+		if class != FailureICEFailed && class != FailureNoCandidates {
+			// e.g. the remote peer hasn't answered or the connection has been closed, there is no point in escalating
+			return nil, false, err
+		}
+		if i < len(stages)-1 {
+			conn.log.Debugf("%s stage of connection to peer %s failed, escalating to the %s stage: %v", s.stage,
+				conn.config.Key, stages[i+1].stage, err)
+		}
+	}
+
+	return nil, false, err
+}
+
+// acceptsRemoteCandidate returns true if the candidate of the remote peer may be used by the current stage of the
+// staged connection attempt, e.g. the relay candidates are dropped until the relay stage. The caller holds the lock
+func (conn *Conn) acceptsRemoteCandidate(candidate ice.Candidate) bool {
+	if conn.remoteCandidateTypes == nil {
+		return true
+	}
+	for _, candidateType := range conn.remoteCandidateTypes {
+		if candidate.Type() == candidateType {
+			return true
+		}
+	}
+	return false
+}
+
+// Stage returns the stage reached by the latest staged connection attempt, empty if the staged mode is disabled
+func (conn *Conn) Stage() ICEStage {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.stage
+}
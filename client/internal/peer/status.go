@@ -1,6 +1,11 @@
 package peer
 
-import log "github.com/sirupsen/logrus"
+import (
+	"net"
+	"strconv"
+
+	"github.com/pion/ice/v2"
+)
 
 type ConnStatus int
 
@@ -12,6 +17,8 @@ func (s ConnStatus) String() string {
 		return "StatusConnected"
 	case StatusDisconnected:
 		return "StatusDisconnected"
+	case StatusIdle:
+		return "StatusIdle"
 	default:
 		log.Errorf("unknown status: %d", s)
 		return "INVALID_PEER_CONNECTION_STATUS"
@@ -22,4 +29,52 @@ const (
 	StatusConnected = iota
 	StatusConnecting
 	StatusDisconnected
+	// StatusIdle is a disconnected peer kept dormant in the on-demand mode until there is traffic to it
+	StatusIdle
+)
+
+// ConnType is a type of an established connection to a remote peer
+type ConnType string
+
+const (
+	// ConnTypeDirect is a direct Wireguard connection, one of the peers is reachable on its host address
+	ConnTypeDirect ConnType = "direct"
+	// ConnTypeP2P is a peer-to-peer connection proxied by the local proxy, e.g. traversing a NAT
+	ConnTypeP2P ConnType = "p2p"
+	// ConnTypeRelayed is a connection relayed by a TURN server
+	ConnTypeRelayed ConnType = "relayed"
+	// ConnTypeSignalRelayed is a degraded connection relayed through the Signal Service when ICE fails
+	ConnTypeSignalRelayed ConnType = "signal-relayed"
 )
+
+// connTypeOf returns the type of the connection established with the selected ICE candidate pair
+func connTypeOf(pair *ice.CandidatePair, useProxy bool) ConnType {
+	switch {
+	case pair.Local.Type() == ice.CandidateTypeRelay || pair.Remote.Type() == ice.CandidateTypeRelay:
+		return ConnTypeRelayed
+	case useProxy:
+		return ConnTypeP2P
+	default:
+		return ConnTypeDirect
+	}
+}
+
+// relayAddressOf returns the address of the relayed candidate of the selected ICE candidate pair,
+// an empty string if the connection isn't relayed
+func relayAddressOf(pair *ice.CandidatePair) string {
+	for _, candidate := range []ice.Candidate{pair.Local, pair.Remote} {
+		if candidate.Type() == ice.CandidateTypeRelay {
+			return net.JoinHostPort(candidate.Address(), strconv.Itoa(candidate.Port()))
+		}
+	}
+	return ""
+}
+
+// remoteRelayOf returns the relay address of the remote candidate of the selected ICE candidate pair,
+// nil if the remote peer isn't connected through its TURN relay
+func remoteRelayOf(pair *ice.CandidatePair) *net.UDPAddr {
+	if pair.Remote.Type() != ice.CandidateTypeRelay {
+		return nil
+	}
+	return &net.UDPAddr{IP: net.ParseIP(pair.Remote.Address()), Port: pair.Remote.Port()}
+}
@@ -1,8 +1,11 @@
 package peer
 
 import (
-	"github.com/magiconair/properties/assert"
+	"net"
 	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/pion/ice/v2"
 )
 
 func TestConnStatus_String(t *testing.T) {
@@ -15,6 +18,7 @@ func TestConnStatus_String(t *testing.T) {
 		{"StatusConnected", StatusConnected, "StatusConnected"},
 		{"StatusDisconnected", StatusDisconnected, "StatusDisconnected"},
 		{"StatusConnecting", StatusConnecting, "StatusConnecting"},
+		{"StatusIdle", StatusIdle, "StatusIdle"},
 	}
 
 	for _, table := range tables {
@@ -25,3 +29,26 @@ func TestConnStatus_String(t *testing.T) {
 	}
 
 }
+
+func TestRemoteRelayOf(t *testing.T) {
+	host, err := ice.NewCandidateHost(&ice.CandidateHostConfig{
+		Network: "udp", Address: "192.168.1.10", Port: 51820, Component: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := ice.NewCandidateRelay(&ice.CandidateRelayConfig{
+		Network: "udp", Address: "198.51.100.1", Port: 40001, Component: 1, RelAddr: "0.0.0.0", RelPort: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the local relay carries the packets to the host candidate of the remote peer
+	assert.Equal(t, remoteRelayOf(&ice.CandidatePair{Local: relay, Remote: host}) == nil, true,
+		"expecting no remote relay for a local relay candidate")
+
+	got := remoteRelayOf(&ice.CandidatePair{Local: host, Remote: relay})
+	want := &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 40001}
+	assert.Equal(t, got.String(), want.String())
+}
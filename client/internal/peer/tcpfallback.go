@@ -0,0 +1,33 @@
+package peer
+
+import (
+	"github.com/pion/ice/v2"
+)
+
+// withTCPRelays adds a TCP variant of every TURN URL over UDP (TLS for the turns scheme), so relay candidates can be
+// gathered on the networks that block UDP. The Wireguard packets are then tunneled through the relayed ICE
+// connection by the Wireguard proxy.
+// Note: the ICE agent supports only passive TCP host candidates that can't connect two Netbird peers,
+// hence the fallback goes through the TURN servers
+func withTCPRelays(urls []*ice.URL) []*ice.URL {
+	existing := make(map[string]struct{}, len(urls))
+	for _, url := range urls {
+		existing[url.String()] = struct{}{}
+	}
+
+	result := append([]*ice.URL{}, urls...)
+	for _, url := range urls {
+		if (url.Scheme != ice.SchemeTypeTURN && url.Scheme != ice.SchemeTypeTURNS) || url.Proto != ice.ProtoTypeUDP {
+			continue
+		}
+		tcpURL := *url
+		tcpURL.Proto = ice.ProtoTypeTCP
+		if _, ok := existing[tcpURL.String()]; ok {
+			continue
+		}
+		existing[tcpURL.String()] = struct{}{}
+		result = append(result, &tcpURL)
+	}
+
+	return result
+}
@@ -0,0 +1,141 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/pion/ice/v2"
+	"github.com/pion/turn/v2"
+)
+
+func TestWithTCPRelays(t *testing.T) {
+	var urls []*ice.URL
+	for _, raw := range []string{
+		"stun:stun.example.com:3478",
+		"turn:turn.example.com:3478?transport=udp",
+		"turns:turn.example.com:5349?transport=udp",
+		"turn:other.example.com:3478?transport=udp",
+		"turn:other.example.com:3478?transport=tcp",
+	} {
+		url, err := ice.ParseURL(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		url.Username = "user"
+		url.Password = "password"
+		urls = append(urls, url)
+	}
+
+	var result []string
+	for _, url := range withTCPRelays(urls) {
+		result = append(result, url.String())
+		assert.Equal(t, url.Username, "user", "the TCP variants should keep the TURN credentials")
+	}
+
+	assert.Equal(t, result, []string{
+		"stun:stun.example.com:3478",
+		"turn:turn.example.com:3478?transport=udp",
+		"turns:turn.example.com:5349?transport=udp",
+		"turn:other.example.com:3478?transport=udp",
+		"turn:other.example.com:3478?transport=tcp",
+		"turn:turn.example.com:3478?transport=tcp",
+		"turns:turn.example.com:5349?transport=tcp",
+	})
+	assert.Equal(t, len(urls), 5, "the original URLs should be kept")
+}
+
+// newTCPTurnServer starts a TURN server accepting the clients over TCP only, as the TURN servers reachable from networks
+// blocking UDP, and returns its URL over UDP with the credentials
+func newTCPTurnServer(t *testing.T) *ice.URL {
+	t.Helper()
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authKey := turn.GenerateAuthKey("user", "netbird", "password")
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm: "netbird",
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return authKey, username == "user"
+		},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener: listener,
+			RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	url, err := ice.ParseURL(fmt.Sprintf("turn:%s?transport=udp", listener.Addr().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	url.Username = "user"
+	url.Password = "password"
+	return url
+}
+
+func TestConn_Open_TCPFallback(t *testing.T) {
+	// the TURN server isn't reachable over UDP and the peers exchange their relay candidates only,
+	// so connecting proves the relay candidates are gathered over TCP
+	localConf := connConf
+	localConf.AttemptTimeout = 20 * time.Second
+	localConf.StunTurn = []*ice.URL{newTCPTurnServer(t)}
+	localConf.EnableTCPFallback = true
+	remoteConf := localConf
+	remoteConf.Key, remoteConf.LocalKey = connConf.LocalKey, connConf.Key
+	local, err := NewConn(localConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := NewConn(remoteConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	relayProtocols := map[string]struct{}{}
+	for _, pair := range [][]*Conn{{local, remote}, {remote, local}} {
+		from, to := pair[0], pair[1]
+		from.SetSignalOffer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteOffer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalAnswer(func(uFrag string, pwd string) error {
+			go deliver(func() bool { return to.OnRemoteAnswer(IceCredentials{UFrag: uFrag, Pwd: pwd}) })
+			return nil
+		})
+		from.SetSignalCandidate(func(candidate ice.Candidate) error {
+			relay, ok := candidate.(*ice.CandidateRelay)
+			if !ok {
+				return nil
+			}
+			mu.Lock()
+			relayProtocols[relay.RelayProtocol()] = struct{}{}
+			mu.Unlock()
+			to.OnRemoteCandidate(candidate)
+			return nil
+		})
+	}
+
+	_, classes := openBoth(t, local, remote)
+	_ = local.Close()
+	_ = remote.Close()
+
+	// the peers connect but there is no local Wireguard interface to proxy to
+	assert.Equal(t, classes[0], FailureProxyFailed, "expecting the peers to connect over the TURN/TCP relay")
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, relayProtocols, map[string]struct{}{"tcp": {}}, "expecting relay candidates gathered over TCP only")
+}
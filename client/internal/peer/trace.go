@@ -0,0 +1,118 @@
+package peer
+
+import (
+	"context"
+	"time"
+)
+
+// TracePhase is a phase of the connection establishment recorded by the connection trace
+type TracePhase string
+
+const (
+	// TraceOfferSent is recorded once the connection offer has been signalled to the remote peer
+	TraceOfferSent TracePhase = "offer-sent"
+	// TraceAnswerReceived is recorded once the remote peer has confirmed the connection with its answer or offer
+	TraceAnswerReceived TracePhase = "answer-received"
+	// TraceGatheringDone is recorded once the local candidates have been gathered
+	TraceGatheringDone TracePhase = "gathering-done"
+	// TraceCheckSucceeded is recorded once the connectivity checks have succeeded on the selected candidate pair
+	TraceCheckSucceeded TracePhase = "check-succeeded"
+	// TraceHandshakeComplete is recorded at the first Wireguard handshake over the established connection
+	TraceHandshakeComplete TracePhase = "handshake-complete"
+)
+
+const (
+	// traceHandshakeTimeout is a time to wait for the first Wireguard handshake over the established connection
+	traceHandshakeTimeout = 30 * time.Second
+	// traceHandshakeInterval is an interval of polling the Wireguard interface for the handshake
+	traceHandshakeInterval = 100 * time.Millisecond
+)
+
+// TraceEvent is a phase of the connection establishment and the time it has been reached
+type TraceEvent struct {
+	Phase TracePhase
+	At    time.Time
+}
+
+// traceReset starts the trace of a new connection attempt dropping the previous one, the caller holds the lock
+func (conn *Conn) traceReset() {
+	if conn.config.Trace {
+		conn.trace = []TraceEvent{}
+	}
+}
+
+// tracePhase records the phase of the current connection attempt if the tracing is enabled.
+// The caller doesn't hold the lock
+func (conn *Conn) tracePhase(phase TracePhase) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.tracePhaseAt(phase, time.Now())
+}
+
+// tracePhaseAt records the phase reached at the given time, a phase is recorded once per attempt.
+// The caller holds the lock
+func (conn *Conn) tracePhaseAt(phase TracePhase, at time.Time) {
+	if !conn.config.Trace {
+		return
+	}
+	for _, event := range conn.trace {
+		if event.Phase == phase {
+			return
+		}
+	}
+	conn.trace = append(conn.trace, TraceEvent{Phase: phase, At: at})
+}
+
+// traceHandshake waits for the first Wireguard handshake with the remote peer over the connection established with
+// the previous handshake at lastHandshake and records it. Gives up once the connection is gone or after
+// traceHandshakeTimeout
+func (conn *Conn) traceHandshake(ctx context.Context, lastHandshake time.Time) {
+	ticker := time.NewTicker(traceHandshakeInterval)
+	defer ticker.Stop()
+	timeout := time.After(traceHandshakeTimeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			conn.log.Debugf("no Wireguard handshake with peer %s within %s, not tracing it", conn.config.Key,
+				traceHandshakeTimeout)
+			return
+		case <-ticker.C:
+			handshake := conn.lastHandshake()
+			if handshake.IsZero() || handshake.Equal(lastHandshake) {
+				continue
+			}
+			conn.mu.Lock()
+			conn.tracePhaseAt(TraceHandshakeComplete, handshake)
+			conn.mu.Unlock()
+			return
+		}
+	}
+}
+
+// lastHandshake returns the time of the latest Wireguard handshake with the remote peer, zero if there was none
+func (conn *Conn) lastHandshake() time.Time {
+	getStats := conn.config.ProxyConfig.WgInterface.GetPeerStats
+	if conn.config.WgStats != nil {
+		getStats = conn.config.WgStats
+	}
+	stats, err := getStats(conn.config.Key)
+	if err != nil {
+		return time.Time{}
+	}
+	return stats.LastHandshake
+}
+
+// Trace returns the phases reached by the latest connection attempt in the order they have been reached,
+// nil if the tracing is disabled
+func (conn *Conn) Trace() []TraceEvent {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.trace == nil {
+		return nil
+	}
+	trace := make([]TraceEvent, len(conn.trace))
+	copy(trace, conn.trace)
+	return trace
+}
@@ -0,0 +1,260 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pion/ice/v2"
+)
+
+const (
+	// upgradeInitialInterval is the delay of the first attempt to upgrade a relayed connection,
+	// the delay doubles after every failed attempt up to upgradeMaxInterval
+	upgradeInitialInterval = 5 * time.Second
+	upgradeMaxInterval     = 5 * time.Minute
+)
+
+// upgradeCandidateTypes are the types of the local candidates gathered to upgrade a relayed connection
+var upgradeCandidateTypes = []ice.CandidateType{ice.CandidateTypeHost, ice.CandidateTypeServerReflexive}
+
+// waitDisconnected blocks until the established connection has been disconnected or closed externally.
+// The ICE Agent keeps the selected candidate pair, so while the connection is relayed the controlling peer
+// periodically negotiates a new connection without relay candidates and the controlled peer answers it.
+// A negotiated connection replaces the relayed one (make-before-break), the Wireguard session is kept.
+// The controlling peer renegotiates right away once the TURN credentials of the relayed connection have changed
+func (conn *Conn) waitDisconnected(isControlling bool) error {
+	interval := upgradeInitialInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		conn.mu.Lock()
+		ctx := conn.ctx
+		relayed := conn.connType == ConnTypeRelayed
+		conn.mu.Unlock()
+
+		var upgradeTimer <-chan time.Time
+		var relayRefresh <-chan struct{}
+		var remoteOffers <-chan remoteOffer
+		if relayed && isControlling {
+			upgradeTimer = timer.C
+			relayRefresh = conn.relayRefreshCh
+		}
+		if relayed && !isControlling {
+			remoteOffers = conn.remoteOffersCh
+		}
+
+		var err error
+		select {
+		case <-conn.closeCh:
+			// closed externally
+			return NewConnectionClosedError(conn.config.Key)
+		case <-ctx.Done():
+			// disconnected from the remote peer
+			return NewConnectionDisconnectedError(conn.config.Key)
+		case <-upgradeTimer:
+			err = conn.upgrade(ctx, nil)
+			interval *= 2
+			if interval > upgradeMaxInterval {
+				interval = upgradeMaxInterval
+			}
+			timer.Reset(interval)
+		case <-relayRefresh:
+			err = conn.upgrade(ctx, nil)
+		case offer := <-remoteOffers:
+			if conn.outdatedOffer(offer, conn.config.Timeout) {
+				continue
+			}
+			err = conn.upgrade(ctx, &offer.IceCredentials)
+		}
+
+		var closedErr *ConnectionClosedError
+		var disconnectedErr *ConnectionDisconnectedError
+		if errors.As(err, &closedErr) || errors.As(err, &disconnectedErr) {
+			return err
+		}
+		if err != nil {
+			conn.log.Debugf("failed upgrading relayed connection to peer %s: %v", conn.config.Key, err)
+		}
+	}
+}
+
+// upgrade negotiates a connection without relay candidates with the remote peer, offering it or answering
+// the remote offer, and replaces the relayed connection with it. The relayed connection is kept on failure.
+// Once the TURN credentials have changed the relay candidates are gathered too, so a connection without a direct path
+// is relayed through a new TURN allocation
+func (conn *Conn) upgrade(relayedCtx context.Context, remoteOffer *IceCredentials) error {
+	conn.log.Debugf("trying to upgrade relayed connection to peer %s", conn.config.Key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	conn.mu.Lock()
+	candidateTypes := upgradeCandidateTypes
+	if conn.relayCredentialsStale {
+		candidateTypes = allCandidateTypes
+	}
+	agent, err := conn.newAgent(candidateTypes)
+	if err != nil {
+		conn.mu.Unlock()
+		cancel()
+		if agent != nil {
+			_ = agent.Close()
+		}
+		return err
+	}
+	relayedAgent := conn.agent
+	relayedEndpoints := conn.advertisedEndpoints
+	// the credentials signalled and the remote candidates received belong to the new negotiation
+	conn.agent = agent
+	conn.advertisedEndpoints = map[string]struct{}{}
+	conn.mu.Unlock()
+
+	upgraded := false
+	defer func() {
+		if upgraded {
+			return
+		}
+		cancel()
+		conn.mu.Lock()
+		conn.agent = relayedAgent
+		conn.advertisedEndpoints = relayedEndpoints
+		conn.mu.Unlock()
+		err := agent.Close()
+		if err != nil {
+			conn.log.Debugf("failed closing upgrade ICE Agent of peer %s: %v", conn.config.Key, err)
+		}
+	}()
+
+	// once the connection has been upgraded a broken connection disconnects the peer
+	err = agent.OnConnectionStateChange(func(state ice.ConnectionState) {
+		conn.log.Debugf("peer %s upgrade ICE ConnectionState has changed to %s", conn.config.Key, state.String())
+		if state == ice.ConnectionStateFailed || state == ice.ConnectionStateDisconnected {
+			cancel()
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	var remoteCredentials IceCredentials
+	if remoteOffer != nil {
+		remoteCredentials = *remoteOffer
+		err = conn.sendAnswer()
+		if err != nil {
+			return err
+		}
+	} else {
+		err = conn.sendOffer()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case remoteCredentials = <-conn.remoteAnswerCh:
+		case <-time.After(conn.config.Timeout):
+			return NewConnectionTimeoutError(conn.config.Key, conn.config.Timeout)
+		case <-conn.closeCh:
+			return NewConnectionClosedError(conn.config.Key)
+		case <-relayedCtx.Done():
+			return NewConnectionDisconnectedError(conn.config.Key)
+		}
+	}
+	conn.mu.Lock()
+	conn.remoteUFrag = remoteCredentials.UFrag
+	conn.mu.Unlock()
+
+	err = conn.harvest(agent)
+	if err != nil {
+		return err
+	}
+
+	type dialResult struct {
+		remoteConn *ice.Conn
+		err        error
+	}
+	dialCtx, dialCancel := context.WithTimeout(ctx, conn.config.Timeout)
+	defer dialCancel()
+	results := make(chan dialResult, 1)
+	go func() {
+		var r dialResult
+		if conn.isControlling(remoteCredentials.IceLite) {
+			r.remoteConn, r.err = agent.Dial(dialCtx, remoteCredentials.UFrag, remoteCredentials.Pwd)
+		} else {
+			r.remoteConn, r.err = agent.Accept(dialCtx, remoteCredentials.UFrag, remoteCredentials.Pwd)
+		}
+		results <- r
+	}()
+
+	var r dialResult
+	select {
+	case r = <-results:
+		if r.err != nil {
+			return r.err
+		}
+	case <-conn.closeCh:
+		return NewConnectionClosedError(conn.config.Key)
+	case <-relayedCtx.Done():
+		return NewConnectionDisconnectedError(conn.config.Key)
+	}
+
+	conn.mu.Lock()
+	pair, err := agent.GetSelectedCandidatePair()
+	if err != nil {
+		conn.mu.Unlock()
+		return err
+	}
+
+	// the relayed connection is kept if the remote peer hasn't advertised the new endpoint
+	err = conn.verifyEndpoint(pair)
+	if err != nil {
+		conn.mu.Unlock()
+		conn.rejectedEndpoint(err)
+		return err
+	}
+
+	useProxy := shouldUseProxy(pair)
+	p := conn.newProxy(useProxy)
+	// the new proxy updates the endpoint of the Wireguard peer, the session survives
+	err = p.Start(r.remoteConn)
+	if err != nil {
+		conn.mu.Unlock()
+		_ = p.Detach()
+		return err
+	}
+
+	err = conn.proxy.Detach()
+	if err != nil {
+		conn.log.Debugf("failed detaching relayed proxy of peer %s: %v", conn.config.Key, err)
+	}
+	if relayedAgent != nil {
+		err = relayedAgent.Close()
+		if err != nil {
+			conn.log.Debugf("failed closing relayed ICE Agent of peer %s: %v", conn.config.Key, err)
+		}
+	}
+
+	upgradedFrom := conn.connType
+	conn.proxy = p
+	conn.notifyDisconnected()
+	conn.ctx, conn.notifyDisconnected = ctx, cancel
+	conn.ipFamily = ipFamilyOf(pair.Local.Address())
+	conn.connType = connTypeOf(pair, useProxy)
+	conn.relayAddress = relayAddressOf(pair)
+	conn.remoteAddress = pair.Remote.Address()
+	conn.remoteRelay = remoteRelayOf(pair)
+	conn.relayCredentialsStale = false
+	if conn.connType == ConnTypeRelayed {
+		conn.log.Infof("renegotiated relayed connection to peer %s with the current TURN credentials", conn.config.Key)
+	} else {
+		conn.upgradedFrom = upgradedFrom
+		conn.upgradedAt = time.Now()
+		conn.log.Infof("upgraded %s connection to peer %s to %s", upgradedFrom, conn.config.Key, conn.connType)
+	}
+	upgraded = true
+	conn.mu.Unlock()
+
+	conn.onConnected(r.remoteConn)
+
+	return nil
+}
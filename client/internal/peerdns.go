@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/netbirdio/netbird/client/internal/dns"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// startDNSServer starts resolving the names of the remote peers in the zone of the config on the address of
+// the Wireguard interface. Name resolution is an add-on, so a failure doesn't stop the Engine. The caller holds the lock
+func (e *Engine) startDNSServer() {
+	server, err := dns.NewServer(e.config.DNSZone)
+	if err != nil {
+		log.Errorf("not resolving names of the peers: %v", err)
+		return
+	}
+	e.dnsServer = server
+	e.listenDNS()
+}
+
+// listenDNS starts the DNS responder on the current address of the Wireguard interface
+func (e *Engine) listenDNS() {
+	ip, _, err := net.ParseCIDR(e.config.WgAddr)
+	if err != nil {
+		log.Errorf("not resolving names of the peers, invalid address %s: %v", e.config.WgAddr, err)
+		return
+	}
+	port := e.config.DNSPort
+	if port == 0 {
+		port = dns.DefaultPort
+	}
+	err = e.dnsServer.Start(e.config.WgIfaceName, ip, port)
+	if err != nil {
+		log.Errorf("not resolving names of the peers: %v", err)
+	}
+}
+
+// stopDNSServer stops the DNS responder and removes the resolver configuration of the system
+func (e *Engine) stopDNSServer() {
+	if e.dnsServer == nil {
+		return
+	}
+	err := e.dnsServer.Stop()
+	if err != nil {
+		log.Warnf("failed stopping DNS responder: %v", err)
+	}
+}
+
+// updateDNSRecords updates the names of the remote peers resolved by the DNS responder. The caller holds the lock
+func (e *Engine) updateDNSRecords(remotePeers []*mgmProto.RemotePeerConfig) {
+	if e.dnsServer == nil {
+		return
+	}
+	e.dnsServer.UpdateRecords(peerDNSRecords(remotePeers))
+}
+
+// updateDNSConfig applies the changes of the DNS config pushed by Management to the DNS responder, a missing config
+// reverts to the zone configured locally. The config is ignored if the names aren't resolved locally. The caller holds the lock
+func (e *Engine) updateDNSConfig(config *mgmProto.DNSConfig) {
+	previous := e.dnsConfig
+	if config == nil && previous == nil {
+		return
+	}
+	if proto.Equal(config, previous) {
+		return
+	}
+	e.dnsConfig = config
+
+	if e.dnsServer == nil {
+		log.Debugf("ignoring DNS config received from Management, no DNS zone has been configured")
+		return
+	}
+
+	if config.GetZone() != previous.GetZone() {
+		zone := config.GetZone()
+		if zone == "" {
+			zone = e.config.DNSZone
+		}
+		err := e.dnsServer.UpdateZone(zone)
+		if err != nil {
+			log.Errorf("failed updating DNS zone to %s: %v", zone, err)
+		}
+	}
+
+	if !customRecordsEqual(config.GetCustomRecords(), previous.GetCustomRecords()) {
+		e.dnsServer.UpdateCustomRecords(customDNSRecords(config.GetCustomRecords()))
+	}
+
+	if !nameServerGroupsEqual(config.GetNameServerGroups(), previous.GetNameServerGroups()) {
+		err := e.dnsServer.UpdateUpstreams(upstreamNameServers(config.GetNameServerGroups()))
+		if err != nil {
+			log.Errorf("failed updating upstream nameservers: %v", err)
+		}
+	}
+}
+
+func customRecordsEqual(a, b []*mgmProto.CustomRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func nameServerGroupsEqual(a, b []*mgmProto.NameServerGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// upstreamNameServers returns the addresses of the nameservers as IP:port, the port defaulting to dns.DefaultPort,
+// by the match domains. The invalid nameservers and domains are skipped, a domain of several groups gets the
+// nameservers of all of them
+func upstreamNameServers(groups []*mgmProto.NameServerGroup) map[string][]string {
+	upstreams := make(map[string][]string)
+	for _, group := range groups {
+		var nameServers []string
+		for _, nameServer := range group.GetNameServers() {
+			address, err := nameServerAddress(nameServer)
+			if err != nil {
+				log.Warnf("skipping invalid nameserver %s: %v", nameServer, err)
+				continue
+			}
+			nameServers = append(nameServers, address)
+		}
+		if len(nameServers) == 0 {
+			continue
+		}
+		for _, domain := range group.GetDomains() {
+			if err := dns.ValidateZone(domain); err != nil {
+				log.Warnf("skipping invalid match domain %s: %v", domain, err)
+				continue
+			}
+			domain = strings.ToLower(strings.Trim(domain, "."))
+			upstreams[domain] = append(upstreams[domain], nameServers...)
+		}
+	}
+	return upstreams
+}
+
+// nameServerAddress returns the IP:port address of the nameserver given as an IP or IP:port
+func nameServerAddress(nameServer string) (string, error) {
+	if ip := net.ParseIP(nameServer); ip != nil {
+		return net.JoinHostPort(ip.String(), strconv.Itoa(dns.DefaultPort)), nil
+	}
+	host, port, err := net.SplitHostPort(nameServer)
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return "", fmt.Errorf("not an IP address: %s", host)
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %s", port)
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// customDNSRecords returns the IPs of the custom records by their names, the records of an unknown type or with an
+// IP not matching the type are skipped
+func customDNSRecords(records []*mgmProto.CustomRecord) map[string][]net.IP {
+	ips := make(map[string][]net.IP, len(records))
+	for _, record := range records {
+		ip := net.ParseIP(record.GetRData())
+		switch {
+		case ip == nil:
+		case record.GetType() == "A" && ip.To4() != nil:
+		case record.GetType() == "AAAA" && ip.To4() == nil:
+		default:
+			ip = nil
+		}
+		if ip == nil {
+			log.Warnf("skipping invalid DNS record %s %s %s", record.GetName(), record.GetType(), record.GetRData())
+			continue
+		}
+		name := strings.ToLower(record.GetName())
+		ips[name] = append(ips[name], ip)
+	}
+	return ips
+}
+
+// peerDNSRecords returns the IPs of the remote peers by their names as DNS labels. The peers sharing a name are told
+// apart by a numeric suffix in the order of their IPs, so the names don't swap between NetworkMaps
+func peerDNSRecords(remotePeers []*mgmProto.RemotePeerConfig) map[string][]net.IP {
+	type namedPeer struct {
+		label string
+		ip    net.IP
+	}
+	peers := make([]namedPeer, 0, len(remotePeers))
+	for _, p := range remotePeers {
+		label := dns.Label(p.GetName())
+		if label == "" {
+			continue
+		}
+		ip, err := peerIPFromAllowedIPs(p.GetAllowedIps())
+		if err != nil {
+			continue
+		}
+		peers = append(peers, namedPeer{label: label, ip: ip})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return bytes.Compare(peers[i].ip.To16(), peers[j].ip.To16()) < 0
+	})
+
+	records := make(map[string][]net.IP, len(peers))
+	taken := make(map[string]int, len(peers))
+	for _, p := range peers {
+		name := p.label
+		// the suffixed name may be the name of another peer as well
+		for n := taken[p.label]; records[name] != nil; n++ {
+			name = p.label + "-" + strconv.Itoa(n+1)
+		}
+		taken[p.label]++
+		records[name] = []net.IP{p.ip}
+	}
+	return records
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/dns"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerDNSRecords(t *testing.T) {
+	records := peerDNSRecords([]*mgmProto.RemotePeerConfig{
+		{WgPubKey: "a", Name: "Laptop", AllowedIps: []string{"100.64.0.12/32", "10.10.0.0/16"}},
+		{WgPubKey: "b", Name: "laptop", AllowedIps: []string{"100.64.0.11/32"}},
+		{WgPubKey: "c", Name: "laptop-2", AllowedIps: []string{"100.64.0.10/32"}},
+		{WgPubKey: "d", Name: "Bob's Server", AllowedIps: []string{"100.64.0.13/32"}},
+		// neither a name nor an IP to resolve
+		{WgPubKey: "e", Name: "???", AllowedIps: []string{"100.64.0.14/32"}},
+		{WgPubKey: "f", Name: "phone"},
+	})
+
+	assert.Equal(t, map[string][]net.IP{
+		"laptop-2":     {net.ParseIP("100.64.0.10")},
+		"laptop":       {net.ParseIP("100.64.0.11")},
+		"laptop-3":     {net.ParseIP("100.64.0.12")},
+		"bob-s-server": {net.ParseIP("100.64.0.13")},
+	}, records)
+}
+
+func TestEngine_UpdateDNSConfig(t *testing.T) {
+	server, err := dns.NewServer("wt.local")
+	require.NoError(t, err)
+	// the interface doesn't exist, so the resolver of the system is left alone
+	require.NoError(t, server.Start("wt-dns-test", net.IPv4(127, 0, 0, 1), 0))
+	defer server.Stop() //nolint
+
+	upstream, err := dns.NewServer("corp.example")
+	require.NoError(t, err)
+	require.NoError(t, upstream.Start("wt-dns-test-upstream", net.IPv4(127, 0, 0, 1), 0))
+	defer upstream.Stop() //nolint
+	upstream.UpdateRecords(map[string][]net.IP{"git": {net.ParseIP("10.1.0.5")}})
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", server.Addr().String())
+		},
+	}
+	lookup := func(name string) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ips, err := resolver.LookupIP(ctx, "ip4", name)
+		if err != nil {
+			return nil
+		}
+		var resolved []string
+		for _, ip := range ips {
+			resolved = append(resolved, ip.String())
+		}
+		return resolved
+	}
+
+	engine := &Engine{config: &EngineConfig{DNSZone: "wt.local"}, dnsServer: server}
+	engine.updateDNSRecords([]*mgmProto.RemotePeerConfig{{WgPubKey: "a", Name: "db", AllowedIps: []string{"100.64.0.10/32"}}})
+
+	// add
+	engine.updateDNSConfig(&mgmProto.DNSConfig{
+		Zone:          "netbird.cloud",
+		CustomRecords: []*mgmProto.CustomRecord{{Name: "api", Type: "A", RData: "10.0.0.5"}},
+	})
+	assert.Equal(t, "netbird.cloud", server.Zone())
+	assert.Equal(t, []string{"10.0.0.5"}, lookup("api.netbird.cloud."))
+	assert.Equal(t, []string{"100.64.0.10"}, lookup("db.netbird.cloud."))
+	assert.Empty(t, lookup("db.wt.local."))
+
+	assert.Empty(t, lookup("git.corp.example."))
+
+	// change, the custom record overrides the name of the peer
+	engine.updateDNSConfig(&mgmProto.DNSConfig{
+		Zone: "netbird.cloud",
+		CustomRecords: []*mgmProto.CustomRecord{
+			{Name: "api", Type: "A", RData: "10.0.0.6"},
+			{Name: "db", Type: "A", RData: "10.0.0.7"},
+			{Name: "bad", Type: "A", RData: "fd00::1"},
+		},
+		NameServerGroups: []*mgmProto.NameServerGroup{
+			{NameServers: []string{upstream.Addr().String()}, Domains: []string{"corp.example"}},
+		},
+	})
+	assert.Equal(t, []string{"10.0.0.6"}, lookup("api.netbird.cloud."))
+	assert.Equal(t, []string{"10.0.0.7"}, lookup("db.netbird.cloud."))
+	assert.Empty(t, lookup("bad.netbird.cloud."))
+	assert.Equal(t, []string{"10.1.0.5"}, lookup("git.corp.example."))
+
+	// remove, back to the local zone
+	engine.updateDNSConfig(nil)
+	assert.Equal(t, "wt.local", server.Zone())
+	assert.Equal(t, []string{"100.64.0.10"}, lookup("db.wt.local."))
+	assert.Empty(t, lookup("api.wt.local."))
+	assert.Empty(t, lookup("git.corp.example."))
+}
+
+func TestUpstreamNameServers(t *testing.T) {
+	upstreams := upstreamNameServers([]*mgmProto.NameServerGroup{
+		{NameServers: []string{"10.0.0.53", "ns.example.com", "[fd00::53]:5353"}, Domains: []string{"Corp.Example.com.", "corp_example"}},
+		{NameServers: []string{"10.0.1.53:53"}, Domains: []string{"corp.example.com", "lab"}},
+		// no valid nameserver
+		{NameServers: []string{"10.0.2.53:0"}, Domains: []string{"test"}},
+	})
+	assert.Equal(t, map[string][]string{
+		"corp.example.com": {"10.0.0.53:53", "[fd00::53]:5353", "10.0.1.53:53"},
+		"lab":              {"10.0.1.53:53"},
+	}, upstreams)
+}
+
+func TestEngine_UpdateDNSConfigWithoutZone(t *testing.T) {
+	engine := &Engine{config: &EngineConfig{}}
+	config := &mgmProto.DNSConfig{Zone: "netbird.cloud"}
+	engine.updateDNSConfig(config)
+	engine.updateDNSRecords([]*mgmProto.RemotePeerConfig{{WgPubKey: "a", Name: "db", AllowedIps: []string{"100.64.0.10/32"}}})
+	assert.Nil(t, engine.dnsServer)
+	engine.updateDNSConfig(nil)
+	assert.Nil(t, engine.dnsConfig)
+}
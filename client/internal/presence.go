@@ -0,0 +1,85 @@
+package internal
+
+import (
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// updatePeersPresence records the presence of the remote peers of a NetworkMap. The caller holds the lock
+func (e *Engine) updatePeersPresence(remotePeers []*mgmProto.RemotePeerConfig) {
+	for _, p := range remotePeers {
+		e.updatePeerPresence(p.GetWgPubKey(), p.GetPresence())
+	}
+}
+
+// applyPresenceUpdates records the changed presence of the remote peers sent without a NetworkMap.
+// The peers missing in the latest NetworkMap are skipped, the next NetworkMap carries their presence. The caller holds the lock
+func (e *Engine) applyPresenceUpdates(updates []*mgmProto.RemotePeerPresence) {
+	for _, update := range updates {
+		if _, ok := e.peerConns[update.GetWgPubKey()]; !ok {
+			continue
+		}
+		e.updatePeerPresence(update.GetWgPubKey(), update.GetPresence())
+	}
+}
+
+// updatePeerPresence marks a remote peer offline if the Management service reports it disconnected, otherwise online.
+// A peer without the presence (the account doesn't share it) is online. The caller holds the lock
+func (e *Engine) updatePeerPresence(peerKey string, presence *mgmProto.PeerPresence) {
+	if presence == nil || presence.GetConnected() {
+		if e.markPeerOnline(peerKey) {
+			log.Debugf("peer %s has come online", peerKey)
+		}
+		return
+	}
+
+	e.peersMux.Lock()
+	defer e.peersMux.Unlock()
+	if _, ok := e.offlinePeers[peerKey]; ok {
+		return
+	}
+	log.Debugf("peer %s is offline, last seen %s, won't connect until it comes online", peerKey,
+		presence.GetLastSeen().AsTime())
+	e.offlinePeers[peerKey] = make(chan struct{})
+}
+
+// markPeerOnline wakes up the connection attempts waiting for an offline remote peer, e.g. once it has sent
+// a connection offer before the Management service has reported it online. Returns false if the peer isn't offline
+func (e *Engine) markPeerOnline(peerKey string) bool {
+	e.peersMux.Lock()
+	defer e.peersMux.Unlock()
+
+	online, ok := e.offlinePeers[peerKey]
+	if !ok {
+		return false
+	}
+	delete(e.offlinePeers, peerKey)
+	close(online)
+	return true
+}
+
+// waitPeerOnline blocks the connection attempts to a remote peer while it is offline.
+// Returns whether the peer has been offline and false for exists if the peer has been removed meanwhile or the engine
+// has stopped
+func (e *Engine) waitPeerOnline(peerKey string) (wasOffline bool, exists bool) {
+	e.peersMux.RLock()
+	online, ok := e.offlinePeers[peerKey]
+	e.peersMux.RUnlock()
+	if !ok {
+		return false, true
+	}
+
+	select {
+	case <-e.ctx.Done():
+		return true, false
+	case <-online:
+	}
+	return true, e.peerExists(peerKey)
+}
+
+// isPeerOffline returns true if the remote peer is offline
+func (e *Engine) isPeerOffline(peerKey string) bool {
+	e.peersMux.RLock()
+	defer e.peersMux.RUnlock()
+	_, ok := e.offlinePeers[peerKey]
+	return ok
+}
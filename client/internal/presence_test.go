@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	mgmt "github.com/netbirdio/netbird/management/client"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+	signal "github.com/netbirdio/netbird/signal/client"
+	sProto "github.com/netbirdio/netbird/signal/proto"
+)
+
+func newPresenceTestEngine(ctx context.Context, peerKeys ...string) *Engine {
+	engine := &Engine{
+		ctx:          ctx,
+		syncMsgMux:   &sync.Mutex{},
+		peersMux:     &sync.RWMutex{},
+		peerConns:    map[string]*peer.Conn{},
+		offlinePeers: map[string]chan struct{}{},
+	}
+	for _, peerKey := range peerKeys {
+		engine.peerConns[peerKey] = nil
+	}
+	return engine
+}
+
+// waitPeerOnlineAsync runs waitPeerOnline in the background, the result is sent once it returns
+func waitPeerOnlineAsync(engine *Engine, peerKey string) chan bool {
+	exists := make(chan bool, 1)
+	go func() {
+		_, ok := engine.waitPeerOnline(peerKey)
+		exists <- ok
+	}()
+	return exists
+}
+
+func TestEngine_PeerPresence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine := newPresenceTestEngine(ctx, "online", "offline", "unshared")
+
+	engine.updatePeersPresence([]*mgmProto.RemotePeerConfig{
+		{WgPubKey: "online", Presence: &mgmProto.PeerPresence{Connected: true}},
+		{WgPubKey: "offline", Presence: &mgmProto.PeerPresence{Connected: false}},
+		{WgPubKey: "unshared"},
+	})
+	assert.False(t, engine.isPeerOffline("online"))
+	assert.True(t, engine.isPeerOffline("offline"))
+	assert.False(t, engine.isPeerOffline("unshared"), "a peer which presence isn't shared is online")
+
+	wasOffline, exists := engine.waitPeerOnline("online")
+	assert.False(t, wasOffline)
+	assert.True(t, exists)
+
+	offline := waitPeerOnlineAsync(engine, "offline")
+	select {
+	case <-offline:
+		t.Fatal("expecting the connection attempts to wait for the offline peer")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the presence of the peers missing in the NetworkMap isn't recorded
+	engine.syncMsgMux.Lock()
+	engine.applyPresenceUpdates([]*mgmProto.RemotePeerPresence{
+		{WgPubKey: "unknown", Presence: &mgmProto.PeerPresence{Connected: false}},
+		{WgPubKey: "offline", Presence: &mgmProto.PeerPresence{Connected: true}},
+	})
+	engine.syncMsgMux.Unlock()
+	assert.False(t, engine.isPeerOffline("unknown"))
+
+	select {
+	case ok := <-offline:
+		assert.True(t, ok, "expecting the peer to exist")
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting the connection attempts to resume once the peer comes online")
+	}
+}
+
+func TestEngine_PeerPresence_Removed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine := newPresenceTestEngine(ctx, "removed", "stopped")
+	engine.updatePeerPresence("removed", &mgmProto.PeerPresence{Connected: false})
+	engine.updatePeerPresence("stopped", &mgmProto.PeerPresence{Connected: false})
+
+	removed := waitPeerOnlineAsync(engine, "removed")
+	select {
+	case <-removed:
+		t.Fatal("expecting the connection attempts to wait for the offline peer")
+	case <-time.After(100 * time.Millisecond):
+	}
+	engine.syncMsgMux.Lock()
+	engine.peersMux.Lock()
+	delete(engine.peerConns, "removed")
+	engine.peersMux.Unlock()
+	engine.markPeerOnline("removed")
+	engine.syncMsgMux.Unlock()
+
+	select {
+	case ok := <-removed:
+		assert.False(t, ok, "expecting the removed peer not to exist")
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting the connection attempts to the removed peer to give up")
+	}
+
+	stopped := waitPeerOnlineAsync(engine, "stopped")
+	cancel()
+	select {
+	case ok := <-stopped:
+		assert.False(t, ok)
+	case <-time.After(2 * time.Second):
+		t.Fatal("expecting the connection attempts to give up once the engine stops")
+	}
+}
+
+func TestEngine_OfflinePeerOffer(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	remoteKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peerKey := remoteKey.PublicKey().String()
+
+	// the Signal Service acknowledges that the remote peer isn't connected until it offers the connection
+	var offline sync.Once
+	sent := make(chan *sProto.Message, 10)
+	signalClient := &signal.MockClient{
+		ReadyFunc: func() bool { return true },
+		SendFunc: func(msg *sProto.Message) error {
+			var err error
+			offline.Do(func() {
+				err = fmt.Errorf("%w: %s", signal.ErrPeerNotConnected, msg.GetRemoteKey())
+			})
+			sent <- msg
+			return err
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	engine := NewEngine(ctx, cancel, signalClient, &mgmt.MockClient{}, &EngineConfig{
+		WgIfaceName:  "utun122",
+		WgAddr:       "100.64.0.1/24",
+		WgPrivateKey: key,
+		WgPort:       33122,
+	})
+	conn, err := engine.createPeerConn(peerKey, "100.64.0.10/32", 0)
+	require.NoError(t, err)
+	defer conn.Close() //nolint
+	engine.peersMux.Lock()
+	engine.peerConns[peerKey] = conn
+	engine.peersMux.Unlock()
+	go engine.connWorker(conn, peerKey)
+
+	select {
+	case msg := <-sent:
+		assert.Equal(t, sProto.Body_OFFER, msg.GetBody().GetType())
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting the connection to be offered")
+	}
+
+	// the attempt has found the peer offline and backs off for at least 2 seconds, the peer coming back is answered
+	// right away
+	time.Sleep(200 * time.Millisecond)
+	offered := time.Now()
+	conn.OnRemoteOffer(peer.IceCredentials{UFrag: "remoteufragremot", Pwd: "remotepwdremotepwdremotepwdremot"})
+	select {
+	case msg := <-sent:
+		assert.Equal(t, sProto.Body_ANSWER, msg.GetBody().GetType())
+		assert.Less(t, time.Since(offered), time.Second, "expecting the offer to cut the back off short")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expecting the offer to be answered")
+	}
+}
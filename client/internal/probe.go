@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// probeCount is a number of echo requests sent to a remote peer in a single probe round
+	probeCount = 3
+	// probeTimeout is a time to wait for a single echo reply
+	probeTimeout = time.Second
+	// icmpProtocolNumber is the IANA protocol number of ICMP for IPv4
+	icmpProtocolNumber = 1
+)
+
+// Pinger sends a single echo request to the remote IP and returns the round trip time
+type Pinger interface {
+	Ping(ctx context.Context, ip net.IP) (time.Duration, error)
+}
+
+// icmpPinger is a Pinger sending ICMP echo requests over the tunnel.
+// The remote machine's kernel replies, so the remote peer doesn't have to run the probe
+type icmpPinger struct {
+	id  int
+	seq uint32
+}
+
+func newICMPPinger() *icmpPinger {
+	return &icmpPinger{id: os.Getpid() & 0xffff}
+}
+
+// Ping sends an ICMP echo request and waits for the reply until the context deadline (or probeTimeout) is reached
+func (p *icmpPinger) Ping(ctx context.Context, ip net.IP) (time.Duration, error) {
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(probeTimeout)
+	}
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return 0, err
+	}
+
+	seq := int(atomic.AddUint32(&p.seq, 1) & 0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: []byte("netbird-probe")},
+	}
+	request, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	_, err = conn.WriteTo(request, &net.IPAddr{IP: ip})
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if from.String() != ip.String() {
+			continue
+		}
+		reply, err := icmp.ParseMessage(icmpProtocolNumber, buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == p.id && echo.Seq == seq {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// probeTarget is a connected remote peer to probe
+type probeTarget struct {
+	key string
+	ip  net.IP
+}
+
+// probePeer sends probeCount echo requests to the remote peer and calculates the average RTT and the loss
+func probePeer(ctx context.Context, pinger Pinger, ip net.IP) ProbeStats {
+	var total time.Duration
+	var received int
+	for i := 0; i < probeCount; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		rtt, err := pinger.Ping(pingCtx, ip)
+		cancel()
+		if err != nil {
+			continue
+		}
+		total += rtt
+		received++
+	}
+
+	stats := ProbeStats{
+		Loss:      float64(probeCount-received) / probeCount,
+		UpdatedAt: time.Now(),
+	}
+	if received > 0 {
+		stats.RTT = total / time.Duration(received)
+	}
+	return stats
+}
+
+// probePeers probes all the targets concurrently and returns the measured stats mapped by the peer key
+func probePeers(ctx context.Context, pinger Pinger, targets []probeTarget) map[string]ProbeStats {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	result := make(map[string]ProbeStats, len(targets))
+	for _, target := range targets {
+		wg.Add(1)
+		go func(target probeTarget) {
+			defer wg.Done()
+			stats := probePeer(ctx, pinger, target.ip)
+			mu.Lock()
+			result[target.key] = stats
+			mu.Unlock()
+		}(target)
+	}
+	wg.Wait()
+	return result
+}
+
+// probeIP extracts a remote peer tunnel IP from the comma separated list of its allowed IPs
+func probeIP(allowedIPs string) (net.IP, error) {
+	first := strings.TrimSpace(strings.Split(allowedIPs, ",")[0])
+	ip, _, err := net.ParseCIDR(first)
+	if err != nil {
+		ip = net.ParseIP(first)
+	}
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("no IPv4 address to probe in allowed IPs %s", allowedIPs)
+	}
+	return ip, nil
+}
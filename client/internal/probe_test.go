@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// mockPinger replies to every second echo request with a fixed RTT
+type mockPinger struct {
+	rtt   time.Duration
+	calls int
+}
+
+func (m *mockPinger) Ping(ctx context.Context, ip net.IP) (time.Duration, error) {
+	m.calls++
+	if m.calls%2 == 0 {
+		return 0, fmt.Errorf("timeout")
+	}
+	return m.rtt, nil
+}
+
+func TestProbePeer(t *testing.T) {
+	pinger := &mockPinger{rtt: 20 * time.Millisecond}
+
+	stats := probePeer(context.Background(), pinger, net.ParseIP("100.64.0.2"))
+
+	if pinger.calls != probeCount {
+		t.Errorf("expecting %d echo requests, got %d", probeCount, pinger.calls)
+	}
+	if stats.RTT != 20*time.Millisecond {
+		t.Errorf("expecting average RTT 20ms, got %s", stats.RTT)
+	}
+	expectedLoss := 1.0 / probeCount
+	if stats.Loss != expectedLoss {
+		t.Errorf("expecting loss %f, got %f", expectedLoss, stats.Loss)
+	}
+}
+
+func TestProbeIP(t *testing.T) {
+	testCases := []struct {
+		name       string
+		allowedIPs string
+		expected   string
+		err        bool
+	}{
+		{name: "Single Prefix", allowedIPs: "100.64.0.2/32", expected: "100.64.0.2"},
+		{name: "Multiple Prefixes", allowedIPs: "100.64.0.3/32,10.0.0.0/24", expected: "100.64.0.3"},
+		{name: "Plain IP", allowedIPs: "100.64.0.4", expected: "100.64.0.4"},
+		{name: "IPv6 Only", allowedIPs: "fd00::1/128", err: true},
+		{name: "Empty", allowedIPs: "", err: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			ip, err := probeIP(c.allowedIPs)
+			if c.err {
+				if err == nil {
+					t.Errorf("expecting an error for allowed IPs %q", c.allowedIPs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ip.String() != c.expected {
+				t.Errorf("expecting IP %s, got %s", c.expected, ip)
+			}
+		})
+	}
+}
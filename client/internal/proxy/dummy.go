@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"context"
-	log "github.com/sirupsen/logrus"
 	"net"
 	"time"
 )
@@ -26,6 +25,11 @@ func (p *DummyProxy) Close() error {
 	return nil
 }
 
+func (p *DummyProxy) Detach() error {
+	p.cancel()
+	return nil
+}
+
 func (p *DummyProxy) Start(remoteConn net.Conn) error {
 	p.conn = remoteConn
 	go func() {
@@ -0,0 +1,6 @@
+package proxy
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the proxies of the connections to the remote peers, its level is set by the peer component level
+var log = util.Logger("peer")
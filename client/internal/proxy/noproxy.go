@@ -1,8 +1,6 @@
 package proxy
 
 import (
-	"github.com/netbirdio/netbird/iface"
-	log "github.com/sirupsen/logrus"
 	"net"
 )
 
@@ -10,7 +8,7 @@ import (
 // This is possible in either of these cases:
 // - peers are in the same local network
 // - one of the peers has a public static IP (host)
-// NoProxy will just update remote peer with a remote host and fixed Wireguard port (r.g. 51820).
+// NoProxy will just update remote peer with a remote host and the remote Wireguard port (51820 by default).
 // In order NoProxy to work, Wireguard port has to be fixed for the time being.
 type NoProxy struct {
 	config Config
@@ -28,7 +26,12 @@ func (p *NoProxy) Close() error {
 	return nil
 }
 
-// Start just updates Wireguard peer with the remote IP and default Wireguard port
+// Detach does nothing, there is nothing proxied
+func (p *NoProxy) Detach() error {
+	return nil
+}
+
+// Start just updates Wireguard peer with the remote IP and the remote Wireguard port
 func (p *NoProxy) Start(remoteConn net.Conn) error {
 
 	log.Debugf("using NoProxy while connecting to peer %s", p.config.RemoteKey)
@@ -36,7 +39,7 @@ func (p *NoProxy) Start(remoteConn net.Conn) error {
 	if err != nil {
 		return err
 	}
-	addr.Port = iface.DefaultWgPort
+	addr.Port = p.config.RemoteWgListenPort()
 	err = p.config.WgInterface.UpdatePeer(p.config.RemoteKey, p.config.AllowedIps, DefaultWgKeepAlive,
 		addr, p.config.PreSharedKey)
 
@@ -24,11 +24,23 @@ type Config struct {
 	WgInterface  iface.WGIface
 	AllowedIps   string
 	PreSharedKey *wgtypes.Key
+	// RemoteWgPort is the listen port of the remote Wireguard interface, iface.DefaultWgPort is assumed if 0
+	RemoteWgPort int
+}
+
+// RemoteWgListenPort returns the listen port of the remote Wireguard interface used by direct connections
+func (c Config) RemoteWgListenPort() int {
+	if c.RemoteWgPort == 0 {
+		return iface.DefaultWgPort
+	}
+	return c.RemoteWgPort
 }
 
 type Proxy interface {
 	io.Closer
 	// Start creates a local remoteConn and starts proxying data from/to remoteConn
 	Start(remoteConn net.Conn) error
+	// Detach stops proxying but keeps the Wireguard peer, e.g. when another Proxy has taken over the connection
+	Detach() error
 	Type() Type
 }
@@ -2,7 +2,6 @@ package proxy
 
 import (
 	"context"
-	log "github.com/sirupsen/logrus"
 	"net"
 )
 
@@ -61,6 +60,19 @@ func (p *WireguardProxy) Start(remoteConn net.Conn) error {
 }
 
 func (p *WireguardProxy) Close() error {
+	err := p.Detach()
+	if err != nil {
+		return err
+	}
+	err = p.config.WgInterface.RemovePeer(p.config.RemoteKey)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Detach stops proxying and closes the local connection, the Wireguard peer is kept
+func (p *WireguardProxy) Detach() error {
 	p.cancel()
 	if c := p.localConn; c != nil {
 		err := p.localConn.Close()
@@ -68,10 +80,6 @@ func (p *WireguardProxy) Close() error {
 			return err
 		}
 	}
-	err := p.config.WgInterface.RemovePeer(p.config.RemoteKey)
-	if err != nil {
-		return err
-	}
 	return nil
 }
 
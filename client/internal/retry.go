@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+)
+
+// retryBackoff is an exponential back off of the connection attempts failing with a failure class
+type retryBackoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+// connRetryBackoffs are the back offs by the failure class. An attempt without candidates won't succeed before the
+// network changes, so it backs off harder than the failed negotiations which often succeed on the next attempt
+var connRetryBackoffs = map[peer.FailureClass]retryBackoff{
+	peer.FailureNoCandidates:     {initial: 5 * time.Second, max: 2 * time.Minute},
+	peer.FailureSignalingTimeout: {initial: 2 * time.Second, max: 30 * time.Second},
+	peer.FailureICEFailed:        {initial: time.Second, max: 10 * time.Second},
+	peer.FailureProxyFailed:      {initial: time.Second, max: 10 * time.Second},
+	peer.FailurePeerOffline:      {initial: 2 * time.Second, max: 30 * time.Second},
+}
+
+// connRetryBackoff returns the delay of the next connection attempt to a peer after failures attempts in a row
+// failed with the failure class, 0 if the last attempt hasn't failed
+func connRetryBackoff(class peer.FailureClass, failures int) time.Duration {
+	backoff, ok := connRetryBackoffs[class]
+	if !ok || failures <= 0 {
+		return 0
+	}
+
+	delay := backoff.initial
+	for i := 1; i < failures && delay < backoff.max; i++ {
+		delay *= 2
+	}
+	if delay > backoff.max {
+		delay = backoff.max
+	}
+	return delay
+}
@@ -0,0 +1,25 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+)
+
+func TestConnRetryBackoff(t *testing.T) {
+	assert.Equal(t, time.Duration(0), connRetryBackoff("", 0), "expecting no back off after a closed connection")
+	assert.Equal(t, time.Duration(0), connRetryBackoff(peer.FailureICEFailed, 0))
+
+	assert.Equal(t, time.Second, connRetryBackoff(peer.FailureICEFailed, 1))
+	assert.Equal(t, 4*time.Second, connRetryBackoff(peer.FailureICEFailed, 3))
+	assert.Equal(t, 10*time.Second, connRetryBackoff(peer.FailureICEFailed, 10), "expecting the back off to be capped")
+
+	// no candidates won't come before the network changes
+	assert.Equal(t, 5*time.Second, connRetryBackoff(peer.FailureNoCandidates, 1))
+	assert.Equal(t, 20*time.Second, connRetryBackoff(peer.FailureNoCandidates, 3))
+	assert.Equal(t, 2*time.Minute, connRetryBackoff(peer.FailureNoCandidates, 100))
+	assert.Greater(t, connRetryBackoff(peer.FailureNoCandidates, 3), connRetryBackoff(peer.FailureSignalingTimeout, 3))
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+)
+
+// RouteInfo is a route through the Wireguard interface of the Engine
+type RouteInfo struct {
+	// Network is the destination CIDR of the route
+	Network string
+	// Peer is the public key of the remote peer the traffic is routed to or an empty string if the route isn't
+	// attributed to a peer, e.g. the interface network route
+	Peer string
+	// Interface is the name of the network interface the traffic is routed through
+	Interface string
+}
+
+// GetInstalledRoutes returns the IPv4 routes through the Wireguard interface read from the routing table of the OS.
+// A route is attributed to the remote peer with the most specific allowed IP covering its network while the Wireguard
+// interface routes the traffic to the peer, see peerRouted. The route to the interface network isn't attributed to a peer.
+// No routes are returned in the monitor only mode
+func (e *Engine) GetInstalledRoutes() ([]RouteInfo, error) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	routes := []RouteInfo{}
+	if e.config.MonitorOnly || e.wgInterface.Address.Network == nil {
+		return routes, nil
+	}
+
+	ifaceName := e.wgInterface.Name
+	networks, err := e.wgInterface.Routes()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the routes through interface %s: %v", ifaceName, err)
+	}
+
+	allowedIPs := e.routedAllowedIPs()
+	ifaceNetwork := e.wgInterface.Address.Network.String()
+	for _, network := range networks {
+		route := RouteInfo{Network: network.String(), Interface: ifaceName}
+		if route.Network != ifaceNetwork {
+			route.Peer = routePeer(network, allowedIPs)
+		}
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Peer != routes[j].Peer {
+			return routes[i].Peer < routes[j].Peer
+		}
+		return routes[i].Network < routes[j].Network
+	})
+
+	return routes, nil
+}
+
+// routedAllowedIPs returns the allowed IPs of the remote peers the Wireguard interface routes the traffic to.
+// The caller holds the lock
+func (e *Engine) routedAllowedIPs() map[string][]*net.IPNet {
+	allowedIPs := make(map[string][]*net.IPNet)
+	for peerKey, conn := range e.peerConns {
+		if !e.peerRouted(peerKey) {
+			continue
+		}
+
+		for _, allowedIP := range strings.Split(conn.GetWgAllowedIPs(), ",") {
+			allowedIP = strings.TrimSpace(allowedIP)
+			if allowedIP == "" {
+				continue
+			}
+			_, network, err := net.ParseCIDR(allowedIP)
+			if err != nil {
+				log.Debugf("skipping invalid allowed IP %s of peer %s: %v", allowedIP, peerKey, err)
+				continue
+			}
+			allowedIPs[peerKey] = append(allowedIPs[peerKey], network)
+		}
+	}
+	return allowedIPs
+}
+
+// routePeer returns the key of the peer with the most specific allowed IP covering the network, the lowest key if
+// several peers have the same one, or an empty string if no allowed IP covers the network
+func routePeer(network *net.IPNet, allowedIPs map[string][]*net.IPNet) string {
+	ones, _ := network.Mask.Size()
+	peerKey, best := "", -1
+	for key, networks := range allowedIPs {
+		for _, allowedIP := range networks {
+			allowedOnes, _ := allowedIP.Mask.Size()
+			if allowedOnes > ones || !allowedIP.Contains(network.IP) {
+				continue
+			}
+			if allowedOnes > best || (allowedOnes == best && key < peerKey) {
+				peerKey, best = key, allowedOnes
+			}
+		}
+	}
+	return peerKey
+}
+
+// peerRouted returns true if the Wireguard interface routes the traffic to the remote peer: the peer is connected,
+// restored from the cached endpoint or configured with its static endpoint. The caller holds the lock
+func (e *Engine) peerRouted(peerKey string) bool {
+	conn, ok := e.peerConns[peerKey]
+	if !ok {
+		return false
+	}
+	endpoint, cached := e.peerEndpoints[peerKey]
+	static, isStatic := e.peerStaticEndpoints[peerKey]
+	return conn.Status() == peer.StatusConnected || (cached && endpoint.restored) || (isStatic && static.active)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"net"
+
+	"github.com/netbirdio/netbird/client/ssh"
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// updateSSH records the SSH keys of the remote peers and starts, updates or stops the SSH server as configured by
+// the Management Service. The server doesn't run unless the config allows it. The caller holds the lock
+func (e *Engine) updateSSH(sshConfig *mgmProto.SSHConfig, remotePeers []*mgmProto.RemotePeerConfig) {
+	peerSSHKeys := make(map[string]string, len(remotePeers))
+	for _, p := range remotePeers {
+		if p.GetSshPubKey() != "" {
+			peerSSHKeys[p.GetWgPubKey()] = p.GetSshPubKey()
+		}
+	}
+	e.peersMux.Lock()
+	e.peerSSHKeys = peerSSHKeys
+	e.peersMux.Unlock()
+
+	if sshConfig == nil || !e.config.ServerSSHAllowed || len(e.config.SSHKey) == 0 {
+		if e.sshServer != nil {
+			e.stopSSHServer()
+			e.sshServer = nil
+			log.Infof("stopped SSH server")
+		}
+		return
+	}
+
+	if e.sshServer == nil {
+		server, err := ssh.NewServer(e.config.SSHKey, e.config.ServerSSHUsers, e.config.ServerSSHRootAllowed)
+		if err != nil {
+			log.Errorf("not starting SSH server: %v", err)
+			return
+		}
+		if len(e.config.ServerSSHUsers) == 0 {
+			log.Warnf("SSH server refuses all the logins, map them to the local users with ServerSSHUsers in the config")
+		}
+		e.sshServer = server
+		if !e.listenSSH() {
+			// retried with the next NetworkMap
+			e.sshServer = nil
+			return
+		}
+	}
+	e.sshServer.UpdateAllowedPeers(sshAllowedPeers(sshConfig.GetAllowedPeers(), remotePeers))
+}
+
+// listenSSH starts the SSH server on the current address of the Wireguard interface, returns false if it has failed
+func (e *Engine) listenSSH() bool {
+	ip, _, err := net.ParseCIDR(e.config.WgAddr)
+	if err != nil {
+		log.Errorf("not starting SSH server, invalid address %s: %v", e.config.WgAddr, err)
+		return false
+	}
+	err = e.sshServer.Start(ip, ssh.DefaultSSHPort)
+	if err != nil {
+		log.Errorf("not starting SSH server: %v", err)
+		return false
+	}
+	return true
+}
+
+// stopSSHServer stops the SSH server closing the open connections
+func (e *Engine) stopSSHServer() {
+	if e.sshServer == nil {
+		return
+	}
+	err := e.sshServer.Stop()
+	if err != nil {
+		log.Warnf("failed stopping SSH server: %v", err)
+	}
+}
+
+// sshPublicKey returns the SSH public key of the peer in the authorized_keys format, empty if the key is invalid
+func (e *Engine) sshPublicKey() string {
+	key, err := ssh.PublicKey(e.config.SSHKey)
+	if err != nil {
+		log.Warnf("not reporting SSH public key: %v", err)
+		return ""
+	}
+	return key
+}
+
+// sshAllowedPeers returns the SSH keys of the remote peers allowed to connect by their IP, the allowed peers without
+// an SSH key (e.g. an older client) or a valid IP are skipped
+func sshAllowedPeers(allowedPeers []string, remotePeers []*mgmProto.RemotePeerConfig) map[string]string {
+	allowed := make(map[string]bool, len(allowedPeers))
+	for _, key := range allowedPeers {
+		allowed[key] = true
+	}
+
+	keys := make(map[string]string, len(allowedPeers))
+	for _, p := range remotePeers {
+		if !allowed[p.GetWgPubKey()] || p.GetSshPubKey() == "" {
+			continue
+		}
+		ip, err := peerIPFromAllowedIPs(p.GetAllowedIps())
+		if err != nil {
+			continue
+		}
+		keys[ip.String()] = p.GetSshPubKey()
+	}
+	return keys
+}
@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+func TestSSHAllowedPeers(t *testing.T) {
+	remotePeers := []*mgmProto.RemotePeerConfig{
+		{WgPubKey: "a", AllowedIps: []string{"100.64.0.10/32", "10.10.0.0/16"}, SshPubKey: "ssh-ed25519 AAAA-a"},
+		{WgPubKey: "b", AllowedIps: []string{"100.64.0.11/32"}, SshPubKey: "ssh-ed25519 AAAA-b"},
+		// an older client without an SSH key
+		{WgPubKey: "c", AllowedIps: []string{"100.64.0.12/32"}},
+		{WgPubKey: "d", SshPubKey: "ssh-ed25519 AAAA-d"},
+	}
+
+	allowed := sshAllowedPeers([]string{"a", "c", "d", "unknown"}, remotePeers)
+
+	assert.Equal(t, map[string]string{"100.64.0.10": "ssh-ed25519 AAAA-a"}, allowed)
+}
@@ -2,7 +2,11 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
+
+	"github.com/netbirdio/netbird/iface"
 )
 
 type StatusType string
@@ -31,10 +35,50 @@ func CtxGetState(ctx context.Context) *contextState {
 	return ctx.Value(stateCtx).(*contextState)
 }
 
+// ctxLookupState returns the state object of the context and true if the context was initialized with CtxInitState
+func ctxLookupState(ctx context.Context) (*contextState, bool) {
+	state, ok := ctx.Value(stateCtx).(*contextState)
+	return state, ok
+}
+
+// ErrEngineNotRunning is returned by the state operations requiring a running Engine when there is none
+var ErrEngineNotRunning = errors.New("the client isn't connected")
+
+// ClientUpdate is a client version recommendation received from the Management Service
+type ClientUpdate struct {
+	MinVersion         string
+	RecommendedVersion string
+	DownloadURL        string
+}
+
+// ProbeStats is a connection quality of a remote peer measured by the connection quality probe
+type ProbeStats struct {
+	// RTT is an average round trip time of the last probe round
+	RTT time.Duration
+	// Loss is a fraction (0..1) of echo requests lost in the last probe round
+	Loss float64
+	// UpdatedAt is the time of the last probe round
+	UpdatedAt time.Time
+}
+
 type contextState struct {
-	err    error
-	status StatusType
-	mutex  sync.Mutex
+	err          error
+	status       StatusType
+	clientUpdate *ClientUpdate
+	// availableUpdate is the version of a newer client release found by the update check, empty if there is none
+	availableUpdate string
+	peerProbes      map[string]ProbeStats
+	peerNames       map[string]string
+	wgPort          int
+	// installedRoutes returns the routes installed by the running Engine, nil if no Engine is running
+	installedRoutes func() ([]RouteInfo, error)
+	// engineStatus returns the connectivity status of the running Engine, nil if no Engine is running
+	engineStatus func() EngineStatus
+	// debugSnapshot returns the state of the running Engine for the debug bundle, nil if no Engine is running
+	debugSnapshot func() DebugSnapshot
+	// packetCapture starts a packet capture on the interface of the running Engine, nil if no Engine is running
+	packetCapture func(path string, maxBytes int64) (*iface.PacketCapture, error)
+	mutex         sync.Mutex
 }
 
 func (c *contextState) Set(update StatusType) {
@@ -64,6 +108,179 @@ func (c *contextState) Wrap(err error) error {
 	return err
 }
 
+// SetClientUpdate stores the latest client version recommendation
+func (c *contextState) SetClientUpdate(update *ClientUpdate) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.clientUpdate = update
+}
+
+// ClientUpdate returns the latest client version recommendation or nil if there is none
+func (c *contextState) ClientUpdate() *ClientUpdate {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.clientUpdate
+}
+
+// SetAvailableUpdate stores the version of a newer client release, empty if the running client is up to date
+func (c *contextState) SetAvailableUpdate(version string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.availableUpdate = version
+}
+
+// AvailableUpdate returns the version of a newer client release or empty if there is none
+func (c *contextState) AvailableUpdate() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.availableUpdate
+}
+
+// SetPeerProbes stores the latest connection quality of the remote peers mapped by the peer key
+func (c *contextState) SetPeerProbes(probes map[string]ProbeStats) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.peerProbes = probes
+}
+
+// PeerProbes returns the latest connection quality of the remote peers mapped by the peer key
+func (c *contextState) PeerProbes() map[string]ProbeStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	probes := make(map[string]ProbeStats, len(c.peerProbes))
+	for k, v := range c.peerProbes {
+		probes[k] = v
+	}
+	return probes
+}
+
+// SetPeerNames stores the friendly names of the remote peers mapped by the peer key
+func (c *contextState) SetPeerNames(names map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.peerNames = names
+}
+
+// PeerNames returns the friendly names of the remote peers mapped by the peer key
+func (c *contextState) PeerNames() map[string]string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	names := make(map[string]string, len(c.peerNames))
+	for k, v := range c.peerNames {
+		names[k] = v
+	}
+	return names
+}
+
+// SetWgPort stores the listen port of the Wireguard interface
+func (c *contextState) SetWgPort(port int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.wgPort = port
+}
+
+// WgPort returns the listen port of the Wireguard interface or 0 if the interface hasn't been configured yet
+func (c *contextState) WgPort() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.wgPort
+}
+
+// SetInstalledRoutesSource sets the function returning the routes installed by the running Engine, nil when the Engine stops
+func (c *contextState) SetInstalledRoutesSource(source func() ([]RouteInfo, error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.installedRoutes = source
+}
+
+// InstalledRoutes returns the routes installed by the running Engine or nil if no Engine is running
+func (c *contextState) InstalledRoutes() ([]RouteInfo, error) {
+	c.mutex.Lock()
+	source := c.installedRoutes
+	c.mutex.Unlock()
+
+	if source == nil {
+		return nil, nil
+	}
+	return source()
+}
+
+// SetEngineStatusSource sets the function returning the connectivity status of the running Engine, nil when the Engine stops
+func (c *contextState) SetEngineStatusSource(source func() EngineStatus) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.engineStatus = source
+}
+
+// EngineStatus returns the connectivity status of the running Engine or nil if no Engine is running
+func (c *contextState) EngineStatus() *EngineStatus {
+	c.mutex.Lock()
+	source := c.engineStatus
+	c.mutex.Unlock()
+
+	if source == nil {
+		return nil
+	}
+	status := source()
+	return &status
+}
+
+// SetDebugSnapshotSource sets the function returning the state of the running Engine for the debug bundle,
+// nil when the Engine stops
+func (c *contextState) SetDebugSnapshotSource(source func() DebugSnapshot) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.debugSnapshot = source
+}
+
+// DebugSnapshot returns the state of the running Engine for the debug bundle or nil if no Engine is running
+func (c *contextState) DebugSnapshot() *DebugSnapshot {
+	c.mutex.Lock()
+	source := c.debugSnapshot
+	c.mutex.Unlock()
+
+	if source == nil {
+		return nil
+	}
+	snapshot := source()
+	return &snapshot
+}
+
+// SetPacketCaptureSource sets the function starting a packet capture on the interface of the running Engine,
+// nil when the Engine stops
+func (c *contextState) SetPacketCaptureSource(source func(path string, maxBytes int64) (*iface.PacketCapture, error)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.packetCapture = source
+}
+
+// StartPacketCapture starts capturing the packets of the interface of the running Engine to a pcap file at the path.
+// Returns ErrEngineNotRunning if no Engine is running
+func (c *contextState) StartPacketCapture(path string, maxBytes int64) (*iface.PacketCapture, error) {
+	c.mutex.Lock()
+	source := c.packetCapture
+	c.mutex.Unlock()
+
+	if source == nil {
+		return nil, ErrEngineNotRunning
+	}
+	return source(path, maxBytes)
+}
+
 type stateKey int
 
 var stateCtx stateKey
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"net"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	"github.com/netbirdio/netbird/client/internal/proxy"
+)
+
+var (
+	// staticEndpointHandshakeTimeout is a time to wait for the first Wireguard handshake with a remote peer
+	// configured with its static endpoint before falling back to the connection negotiation
+	staticEndpointHandshakeTimeout = 20 * time.Second
+	// staticEndpointCheckInterval is an interval of the Wireguard handshake checks of the static endpoint connections
+	staticEndpointCheckInterval = 5 * time.Second
+)
+
+// staticEndpointHandshakeTTL is a maximum age of the latest Wireguard handshake of a working static endpoint connection.
+// Wireguard re-handshakes every 2 minutes and rejects sessions older than 3 minutes
+const staticEndpointHandshakeTTL = 3 * time.Minute
+
+// staticEndpoint is a well-known public endpoint of a remote peer sent by the Management service
+type staticEndpoint struct {
+	addr string
+	// active indicates that the Wireguard peer is currently configured with this endpoint without negotiation
+	active bool
+}
+
+// staticEndpointChanged returns true if the static endpoint of a connected remote peer differs from the update,
+// so the peer has to be reconnected using the new endpoint or the negotiation
+func (e *Engine) staticEndpointChanged(peerKey string, update string) bool {
+	var current string
+	if endpoint, ok := e.peerStaticEndpoints[peerKey]; ok {
+		current = endpoint.addr
+	}
+	if current == update {
+		return false
+	}
+
+	log.Infof("static endpoint of peer %s has changed from %q to %q, reconnecting", peerKey, current, update)
+	return true
+}
+
+// connectStaticEndpoint configures the Wireguard peer with the static endpoint of the remote peer skipping the negotiation.
+// It blocks while the connection works and returns when the peer has been removed, the Engine stopped
+// or the Wireguard handshake hasn't happened in time, so the connection has to be negotiated.
+// Returns true if the negotiation is required
+func (e *Engine) connectStaticEndpoint(peerKey string, allowedIPs string, endpoint string) bool {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		log.Warnf("failed resolving static endpoint %s of peer %s, falling back to negotiation: %v", endpoint, peerKey, err)
+		return true
+	}
+
+	if !e.activateStaticEndpoint(peerKey, allowedIPs, addr) {
+		return e.peerExists(peerKey)
+	}
+	log.Infof("connecting to peer %s using static endpoint %s", peerKey, addr)
+
+	deadline := time.Now().Add(staticEndpointHandshakeTimeout)
+	ticker := time.NewTicker(staticEndpointCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+
+		if !e.peerExists(peerKey) {
+			return false
+		}
+
+		stats, err := e.wgStats.PeerStats(peerKey)
+		if err != nil {
+			log.Debugf("failed checking the handshake of peer %s: %v", peerKey, err)
+		} else if !stats.LastHandshake.IsZero() {
+			deadline = stats.LastHandshake.Add(staticEndpointHandshakeTTL)
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+	}
+
+	log.Infof("no Wireguard handshake with peer %s using static endpoint %s, falling back to negotiation", peerKey, addr)
+	e.deactivateStaticEndpoint(peerKey)
+	return e.peerExists(peerKey)
+}
+
+// activateStaticEndpoint configures the Wireguard peer with the static endpoint. Returns true if the peer has been configured
+func (e *Engine) activateStaticEndpoint(peerKey string, allowedIPs string, addr *net.UDPAddr) bool {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	endpoint, ok := e.peerStaticEndpoints[peerKey]
+	if !ok {
+		return false
+	}
+
+	err := e.wgInterface.UpdatePeer(peerKey, allowedIPs, proxy.DefaultWgKeepAlive, addr, e.config.PreSharedKey)
+	if err != nil {
+		log.Warnf("failed configuring peer %s with static endpoint %s, falling back to negotiation: %v", peerKey, addr, err)
+		return false
+	}
+
+	endpoint.active = true
+	return true
+}
+
+// deactivateStaticEndpoint removes the Wireguard peer configured with the static endpoint, so the negotiation can take over
+func (e *Engine) deactivateStaticEndpoint(peerKey string) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	endpoint, ok := e.peerStaticEndpoints[peerKey]
+	if !ok || !endpoint.active {
+		return
+	}
+
+	endpoint.active = false
+	err := e.wgInterface.RemovePeer(peerKey)
+	if err != nil {
+		log.Warnf("failed removing peer %s configured with static endpoint: %v", peerKey, err)
+	}
+}
+
+// getStaticEndpoint returns the static endpoint of the remote peer or an empty string if the peer has none
+func (e *Engine) getStaticEndpoint(peerKey string) string {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if endpoint, ok := e.peerStaticEndpoints[peerKey]; ok {
+		return endpoint.addr
+	}
+	return ""
+}
+
+// acceptStaticEndpointConnection configures the Wireguard peer of a remote peer without an endpoint when this peer
+// has a static endpoint, so the handshakes of the remote peers connecting to the static endpoint are accepted.
+// Wireguard learns the endpoint of the remote peer from its handshake
+func (e *Engine) acceptStaticEndpointConnection(conn *peer.Conn, peerKey string) {
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if e.staticEndpoint == "" {
+		return
+	}
+	if _, ok := e.peerConns[peerKey]; !ok {
+		return
+	}
+
+	err := e.wgInterface.UpdatePeer(peerKey, conn.GetWgAllowedIPs(), 0, nil, e.config.PreSharedKey)
+	if err != nil {
+		log.Warnf("failed configuring peer %s to accept connections to static endpoint %s: %v", peerKey, e.staticEndpoint, err)
+	}
+}
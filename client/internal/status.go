@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/client/internal/peer"
+	"github.com/netbirdio/netbird/iface"
+)
+
+// EngineStatus is a connectivity status of the running Engine
+type EngineStatus struct {
+	// Management is the status of the Sync stream of the Management Service
+	Management StreamStatus
+	// Signal is the status of the stream of the Signal Service
+	Signal StreamStatus
+	// Relays are the TURN servers the connections to the remote peers can be relayed through
+	Relays []string
+	// RelaysExpireAt is the expiration time of the TURN credentials, zero if they don't expire
+	RelaysExpireAt time.Time
+	// Peers are the connections to the remote peers sorted by the peer key
+	Peers []PeerConnStatus
+	// ConnFailures counts the failed connection attempts to the remote peers by the failure class
+	ConnFailures map[peer.FailureClass]int
+	// RejectedEndpoints counts the negotiated endpoints rejected because the remote peers haven't advertised them
+	RejectedEndpoints int
+	// ExitNode is the key of the remote peer selected as the exit node, empty if there is none
+	ExitNode string
+	// ExitNodeActive indicates that all the traffic is currently routed through the exit node
+	ExitNodeActive bool
+	// WgMode is the Wireguard implementation of the interface (kernel or userspace), empty if it hasn't been created
+	WgMode iface.WGMode
+	// NetworkRoutes are the networks behind the routing peers sorted by the network
+	NetworkRoutes []NetworkRouteStatus
+	// AllowedIPsConflicts are the allowed IPs of the remote peers refused because another remote peer has the same
+	// or an overlapping one
+	AllowedIPsConflicts []AllowedIPsConflictStatus
+	// WgStatsPolledAt is the time of the latest read of the Wireguard interface statistics (e.g. the LastHandshake of
+	// the Peers), zero if they haven't been read yet
+	WgStatsPolledAt time.Time
+}
+
+// AllowedIPsConflictStatus is an allowed IP of a remote peer refused because of a conflict with another remote peer
+type AllowedIPsConflictStatus struct {
+	// Prefix is the allowed IP of Peer that keeps it
+	Prefix string
+	Peer   string
+	// ConflictingPrefix is the allowed IP refused to ConflictingPeer, equal to Prefix for a duplicate
+	ConflictingPrefix string
+	ConflictingPeer   string
+	// Duplicate distinguishes the same prefix of both peers from different but overlapping prefixes
+	Duplicate bool
+}
+
+// NetworkRouteStatus is a status of a network behind the routing peers
+type NetworkRouteStatus struct {
+	Network string
+	// Peer is the key of the routing peer the network is routed through, empty if none of them is connected
+	Peer string
+	// Peers are the keys of the routing peers of the network ordered by the metric, the most preferred first
+	Peers []string
+}
+
+// StreamStatus is a status of the stream to the Management or the Signal Service
+type StreamStatus struct {
+	Connected bool
+	// Since is the time the stream has connected or disconnected, zero if it hasn't connected yet
+	Since time.Time
+	// URL is the service the client is connected to out of the configured ones, empty if there is a single one
+	URL string
+}
+
+// PeerConnStatus is a status of the connection to a remote peer
+type PeerConnStatus struct {
+	PubKey string
+	// Status is Connected, Connecting, Idle or Disconnected
+	Status string
+	// ConnType is the type of the established connection, empty if the peer isn't connected
+	ConnType peer.ConnType
+	// RelayAddress is the address of the TURN relay of a relayed connection
+	RelayAddress string
+	// IPFamily is the IP family used by the established connection, empty if the peer isn't connected
+	IPFamily peer.IPFamily
+	// UpgradedFrom is the type of the relayed connection upgraded to the established one at UpgradedAt,
+	// empty if the connection hasn't been upgraded
+	UpgradedFrom peer.ConnType
+	UpgradedAt   time.Time
+	// LastFailure is the class of the latest failed connection attempt, empty once the connection has been established
+	LastFailure peer.FailureClass
+	// LastHandshake is the time of the latest Wireguard handshake with the peer, zero if there was none
+	LastHandshake time.Time
+	// Trace is the timeline of the latest connection attempt, empty if the tracing is disabled
+	Trace []peer.TraceEvent
+	// IP is the Netbird IP of the peer
+	IP string
+	// SSHHostKey is the SSH public key of the peer in the authorized_keys format, empty if the peer has no SSH key
+	SSHHostKey string
+}
+
+// GetStatus returns the connectivity status of the Engine. It doesn't wait for a NetworkMap being applied:
+// the network part is the one published once the latest change has been applied
+func (e *Engine) GetStatus() EngineStatus {
+	e.statusMux.RLock()
+	// the published status isn't changed, it is replaced by the next one
+	status := e.networkStatus
+	status.RejectedEndpoints = e.rejectedEndpoints
+	status.ConnFailures = make(map[peer.FailureClass]int, len(e.connFailures))
+	for class, count := range e.connFailures {
+		status.ConnFailures[class] = count
+	}
+	e.statusMux.RUnlock()
+
+	mgmClient, mgmURL := e.managementClient(), e.managementURL()
+	status.Management = StreamStatus{Connected: mgmClient.StreamConnected(), Since: mgmClient.StatusSince()}
+	if len(e.config.ManagementURLs) > 1 && mgmURL != nil {
+		status.Management.URL = mgmURL.String()
+	}
+	status.Signal = StreamStatus{Connected: e.signal.StreamConnected(), Since: e.signal.StatusSince()}
+
+	status.Peers = e.peersStatus()
+	status.WgStatsPolledAt = e.wgStats.PolledAt()
+
+	return status
+}
+
+// peersStatus returns the status of the connections to the remote peers sorted by the peer key. The lock is held
+// only while looking the connections up, each Conn reports its own state
+func (e *Engine) peersStatus() []PeerConnStatus {
+	type peerConn struct {
+		key        string
+		conn       *peer.Conn
+		sshHostKey string
+	}
+	e.peersMux.RLock()
+	conns := make([]peerConn, 0, len(e.peerConns))
+	for key, conn := range e.peerConns {
+		conns = append(conns, peerConn{key: key, conn: conn, sshHostKey: e.peerSSHKeys[key]})
+	}
+	e.peersMux.RUnlock()
+
+	peers := []PeerConnStatus{}
+	for _, p := range conns {
+		conn := p.conn
+		peerStatus := PeerConnStatus{
+			PubKey:       p.key,
+			Status:       connStatusName(conn.Status()),
+			ConnType:     conn.ConnType(),
+			RelayAddress: conn.RelayAddress(),
+			IPFamily:     conn.IPFamily(),
+			LastFailure:  conn.LastFailure(),
+			Trace:        conn.Trace(),
+			SSHHostKey:   p.sshHostKey,
+		}
+		if ip, err := peerIPFromAllowedIPs(strings.Split(conn.GetWgAllowedIPs(), ",")); err == nil {
+			peerStatus.IP = ip.String()
+		}
+		peerStatus.UpgradedFrom, peerStatus.UpgradedAt = conn.UpgradedFrom()
+		if stats, err := e.wgStats.PeerStats(p.key); err == nil {
+			peerStatus.LastHandshake = stats.LastHandshake
+		}
+		peers = append(peers, peerStatus)
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].PubKey < peers[j].PubKey
+	})
+	return peers
+}
+
+// publishNetworkStatus publishes the network part of the EngineStatus (the relays, the exit node, the network routes
+// and the allowed IPs conflicts) read by GetStatus. The caller holds syncMsgMux
+func (e *Engine) publishNetworkStatus() {
+	status := EngineStatus{
+		Relays:         []string{},
+		RelaysExpireAt: e.turnCredentialsExpiresAt,
+		WgMode:         e.wgInterface.ActiveMode(),
+		ExitNode:       e.exitNodeKey,
+		ExitNodeActive: e.exitNodeRouted,
+	}
+
+	for _, turn := range e.TURNs {
+		status.Relays = append(status.Relays, turn.String())
+	}
+
+	for network, route := range e.networkRoutes {
+		routeStatus := NetworkRouteStatus{Network: network, Peer: route.active}
+		for _, candidate := range route.candidates {
+			routeStatus.Peers = append(routeStatus.Peers, candidate.GetPeer())
+		}
+		status.NetworkRoutes = append(status.NetworkRoutes, routeStatus)
+	}
+	sort.Slice(status.NetworkRoutes, func(i, j int) bool {
+		return status.NetworkRoutes[i].Network < status.NetworkRoutes[j].Network
+	})
+
+	for _, conflict := range e.allowedIPsConflicts {
+		status.AllowedIPsConflicts = append(status.AllowedIPsConflicts, AllowedIPsConflictStatus{
+			Prefix:            conflict.prefix,
+			Peer:              conflict.peer,
+			ConflictingPrefix: conflict.conflictingPrefix,
+			ConflictingPeer:   conflict.conflictingPeer,
+			Duplicate:         conflict.duplicate,
+		})
+	}
+
+	e.statusMux.Lock()
+	e.networkStatus = status
+	e.statusMux.Unlock()
+}
+
+// connStatusName returns a stable name of a connection status reported to the daemon clients
+func connStatusName(status peer.ConnStatus) string {
+	switch status {
+	case peer.StatusConnected:
+		return "Connected"
+	case peer.StatusConnecting:
+		return "Connecting"
+	case peer.StatusIdle:
+		return "Idle"
+	default:
+		return "Disconnected"
+	}
+}
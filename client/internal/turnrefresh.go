@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"time"
+
+	mgmProto "github.com/netbirdio/netbird/management/proto"
+)
+
+// turnRefreshRetryInterval is a delay before the next request of new TURN credentials after a failed one
+var turnRefreshRetryInterval = 10 * time.Second
+
+// turnCredentialsExpiration returns the earliest expiration time of the TURN credentials, zero if none of them expires
+func turnCredentialsExpiration(turns []*mgmProto.ProtectedHostConfig) time.Time {
+	var expiresAt time.Time
+	for _, turn := range turns {
+		if turn.GetExpiresAt() == nil {
+			continue
+		}
+		turnExpiresAt := turn.GetExpiresAt().AsTime()
+		if expiresAt.IsZero() || turnExpiresAt.Before(expiresAt) {
+			expiresAt = turnExpiresAt
+		}
+	}
+	return expiresAt
+}
+
+// turnRefreshDelay returns how long to wait before requesting new TURN credentials expiring in lifetime.
+// The request is sent the refresh margin before the expiration, 1/8 of the lifetime if the margin isn't set or exceeds it,
+// so the credentials pushed by the Management Service at 3/4 of their lifetime usually arrive first
+func turnRefreshDelay(lifetime time.Duration, margin time.Duration) time.Duration {
+	if margin <= 0 || margin >= lifetime {
+		margin = lifetime / 8
+	}
+	return lifetime - margin
+}
+
+// trackTURNCredentials records the expiration of the received TURN credentials and schedules the request of new ones.
+// Must be called with syncMsgMux held
+func (e *Engine) trackTURNCredentials(turns []*mgmProto.ProtectedHostConfig) {
+	e.turnCredentialsExpiresAt = turnCredentialsExpiration(turns)
+	if e.turnCredentialsExpiresAt.IsZero() {
+		e.stopTURNRefresh()
+		return
+	}
+
+	lifetime := time.Until(e.turnCredentialsExpiresAt)
+	if lifetime <= 0 {
+		log.Warnf("received TURN credentials that expired at %s, check the clock of the host", e.turnCredentialsExpiresAt)
+		e.stopTURNRefresh()
+		return
+	}
+	e.scheduleTURNRefresh(turnRefreshDelay(lifetime, e.config.TURNRefreshMargin))
+}
+
+// scheduleTURNRefresh schedules a request of new TURN credentials after the delay, replacing the previously
+// scheduled one. Must be called with syncMsgMux held
+func (e *Engine) scheduleTURNRefresh(delay time.Duration) {
+	e.stopTURNRefresh()
+
+	expiresAt := e.turnCredentialsExpiresAt
+	e.turnRefreshTimer = time.AfterFunc(delay, func() {
+		e.refreshTURNCredentials(expiresAt)
+	})
+}
+
+// refreshTURNCredentials requests new TURN credentials from the Management Service unless new ones expiring after
+// expiresAt have been pushed meanwhile. A failed request is retried until the credentials expire
+func (e *Engine) refreshTURNCredentials(expiresAt time.Time) {
+	if e.ctx.Err() != nil {
+		return
+	}
+
+	log.Debugf("TURN credentials expire at %s, requesting new ones from Management Service", expiresAt)
+	resp, err := e.managementClient().GetTURNCredentials()
+
+	e.syncMsgMux.Lock()
+	defer e.syncMsgMux.Unlock()
+
+	if e.ctx.Err() != nil || e.turnCredentialsExpiresAt.After(expiresAt) {
+		return
+	}
+
+	if err != nil {
+		log.Warnf("failed requesting new TURN credentials from Management Service: %v", err)
+		if time.Until(expiresAt) > turnRefreshRetryInterval {
+			e.scheduleTURNRefresh(turnRefreshRetryInterval)
+		}
+		return
+	}
+
+	err = e.updateTURNs(resp.GetTurns())
+	if err != nil {
+		log.Warnf("failed updating TURN credentials: %v", err)
+		return
+	}
+	e.updatePeersStunTurn()
+}
+
+// stopTURNRefresh cancels the scheduled request of new TURN credentials. Must be called with syncMsgMux held
+func (e *Engine) stopTURNRefresh() {
+	if e.turnRefreshTimer != nil {
+		e.turnRefreshTimer.Stop()
+		e.turnRefreshTimer = nil
+	}
+}
@@ -0,0 +1,207 @@
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxArtifactSize limits the size of the downloaded artifacts and of the extracted binary
+const maxArtifactSize = 256 << 20
+
+// ArtifactName returns the name of the release archive with the client binary for the OS and the architecture.
+// The project releases the ARM v6 and the hard-float MIPS builds by default
+func ArtifactName(version, goos, goarch string) string {
+	arch := goarch
+	switch goarch {
+	case "arm":
+		arch = "armv6"
+	case "mips":
+		arch = "mips_hardfloat"
+	}
+	return fmt.Sprintf("netbird_%s_%s_%s.tar.gz", strings.TrimPrefix(version, "v"), goos, arch)
+}
+
+// checksumsName returns the name of the file with the SHA-256 checksums of the release artifacts
+func checksumsName(version string) string {
+	return fmt.Sprintf("netbird_%s_checksums.txt", strings.TrimPrefix(version, "v"))
+}
+
+// ParsePublicKey parses a base64 encoded ed25519 public key the release checksums are signed with
+func ParsePublicKey(key string) (ed25519.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key size %d", len(decoded))
+	}
+	return decoded, nil
+}
+
+// Download downloads the client binary of the release for the OS and the architecture into a new file in dir.
+// The archive is verified against the checksums file of the release, which is verified against its detached
+// signature (the checksums file name with the .sig suffix) with publicKey. A nil publicKey, see PublicKey, skips the
+// signature verification. Returns the path of the binary, the caller removes the file if it isn't installed
+func Download(ctx context.Context, client *http.Client, release *Release, goos, goarch string,
+	publicKey ed25519.PublicKey, dir string) (string, error) {
+	archiveName := ArtifactName(release.Version, goos, goarch)
+	archive := release.asset(archiveName)
+	if archive == nil {
+		return "", fmt.Errorf("release %s has no client build for %s/%s", release.Version, goos, goarch)
+	}
+	checksums := release.asset(checksumsName(release.Version))
+	if checksums == nil {
+		return "", fmt.Errorf("release %s has no checksums file", release.Version)
+	}
+
+	checksumsContent, err := fetch(ctx, client, checksums.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if publicKey != nil {
+		signature := release.asset(checksums.Name + ".sig")
+		if signature == nil {
+			return "", fmt.Errorf("release %s isn't signed", release.Version)
+		}
+		signatureContent, err := fetch(ctx, client, signature.URL)
+		if err != nil {
+			return "", err
+		}
+		err = verifySignature(publicKey, checksumsContent, signatureContent)
+		if err != nil {
+			return "", fmt.Errorf("failed verifying the checksums of release %s: %v", release.Version, err)
+		}
+	}
+
+	expected, err := findChecksum(checksumsContent, archiveName)
+	if err != nil {
+		return "", err
+	}
+
+	archiveContent, err := fetch(ctx, client, archive.URL)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(archiveContent)
+	if hex.EncodeToString(sum[:]) != expected {
+		return "", fmt.Errorf("checksum of %s doesn't match the release checksums", archiveName)
+	}
+
+	binaryName := "netbird"
+	if goos == "windows" {
+		binaryName = "netbird.exe"
+	}
+	return extractBinary(archiveContent, binaryName, dir)
+}
+
+// fetch downloads the content of the URL
+func fetch(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed downloading %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed downloading %s: %s", url, resp.Status)
+	}
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxArtifactSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed downloading %s: %v", url, err)
+	}
+	if len(content) > maxArtifactSize {
+		return nil, fmt.Errorf("failed downloading %s: exceeds %d bytes", url, maxArtifactSize)
+	}
+	return content, nil
+}
+
+// verifySignature verifies the ed25519 signature of the content, raw or base64 encoded
+func verifySignature(publicKey ed25519.PublicKey, content, signature []byte) error {
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("invalid signature encoding: %v", err)
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(publicKey, content, signature) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// findChecksum returns the hex encoded SHA-256 checksum of the file from the checksums file in the sha256sum format
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("checksums file has no checksum of %s", name)
+}
+
+// extractBinary extracts the binary from the tar.gz archive into a new executable file in dir
+func extractBinary(archive []byte, binaryName string, dir string) (string, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return "", fmt.Errorf("failed reading the release archive: %v", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return "", fmt.Errorf("release archive has no %s binary", binaryName)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed reading the release archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg || path.Base(header.Name) != binaryName {
+			continue
+		}
+
+		file, err := os.CreateTemp(dir, "."+binaryName+"-update-*")
+		if err != nil {
+			return "", err
+		}
+		written, err := io.Copy(file, io.LimitReader(tarReader, maxArtifactSize+1))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil && written > maxArtifactSize {
+			err = fmt.Errorf("%s exceeds %d bytes", binaryName, maxArtifactSize)
+		}
+		if err == nil {
+			err = os.Chmod(file.Name(), 0755)
+		}
+		if err != nil {
+			_ = os.Remove(file.Name())
+			return "", fmt.Errorf("failed extracting %s from the release archive: %v", binaryName, err)
+		}
+		return file.Name(), nil
+	}
+}
@@ -0,0 +1,69 @@
+package update
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Installation is an executable replaced by a new binary. The old binary is kept next to it until the installation
+// is committed, so it can be restored if the new binary fails to start
+type Installation struct {
+	executable string
+	backup     string
+}
+
+// Install replaces the executable with the new binary. On Windows, where a running executable can't be replaced
+// but can be renamed, the old binary is moved aside first, elsewhere the executable is replaced atomically.
+// The executable is left untouched if the replacement fails
+func Install(newBinary, executable string) (*Installation, error) {
+	info, err := os.Stat(executable)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(newBinary, info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+
+	backup := executable + ".old"
+	// a leftover of a previous update
+	_ = os.Remove(backup)
+
+	if runtime.GOOS == "windows" {
+		err = os.Rename(executable, backup)
+		if err != nil {
+			return nil, fmt.Errorf("failed backing up %s: %v", executable, err)
+		}
+		err = os.Rename(newBinary, executable)
+		if err != nil {
+			if restoreErr := os.Rename(backup, executable); restoreErr != nil {
+				return nil, fmt.Errorf("failed replacing %s: %v, restoring it failed: %v", executable, err, restoreErr)
+			}
+			return nil, fmt.Errorf("failed replacing %s: %v", executable, err)
+		}
+		return &Installation{executable: executable, backup: backup}, nil
+	}
+
+	err = os.Link(executable, backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed backing up %s: %v", executable, err)
+	}
+	err = os.Rename(newBinary, executable)
+	if err != nil {
+		_ = os.Remove(backup)
+		return nil, fmt.Errorf("failed replacing %s: %v", executable, err)
+	}
+	return &Installation{executable: executable, backup: backup}, nil
+}
+
+// Rollback restores the old binary
+func (i *Installation) Rollback() error {
+	return os.Rename(i.backup, i.executable)
+}
+
+// Commit removes the old binary. On Windows it can't be removed while the old process is still running,
+// it is removed by the next update then
+func (i *Installation) Commit() {
+	_ = os.Remove(i.backup)
+}
@@ -0,0 +1,38 @@
+package update
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+)
+
+// releasePublicKey is the base64 encoded ed25519 public key the checksums of the project releases are signed with.
+// The release builds embed it with -ldflags "-X github.com/netbirdio/netbird/client/internal/update.releasePublicKey=<key>"
+var releasePublicKey string
+
+// ErrNoPublicKey is returned by PublicKey when the build has no embedded release key and none has been configured
+var ErrNoPublicKey = errors.New("this build has no embedded release signing key, set UpdatePublicKey in the config " +
+	"or disable the signature verification with UpdateSkipSignature")
+
+// PublicKey returns the public key the checksums of the releases are verified with: the configured key or the embedded
+// release key. Returns nil only if the signature verification has been disabled explicitly with skipSignature
+func PublicKey(configured string, skipSignature bool) (ed25519.PublicKey, error) {
+	if skipSignature {
+		return nil, nil
+	}
+	if configured != "" {
+		key, err := ParsePublicKey(configured)
+		if err != nil {
+			return nil, fmt.Errorf("invalid UpdatePublicKey in the config: %v", err)
+		}
+		return key, nil
+	}
+	if releasePublicKey == "" {
+		return nil, ErrNoPublicKey
+	}
+	key, err := ParsePublicKey(releasePublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid embedded release signing key: %v", err)
+	}
+	return key, nil
+}
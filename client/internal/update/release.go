@@ -0,0 +1,126 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultReleaseURL is the latest release of the project, other release URLs have to serve the release in the same format
+const DefaultReleaseURL = "https://api.github.com/repos/netbirdio/netbird/releases/latest"
+
+// Release is a client release with its downloadable artifacts
+type Release struct {
+	// Version of the release, e.g. v0.9.1
+	Version string  `json:"tag_name"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a downloadable artifact of a release (e.g. an archive with the client binary or the checksums file)
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// LatestRelease fetches the latest release from the release URL
+func LatestRelease(ctx context.Context, client *http.Client, releaseURL string) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, releaseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed fetching the latest release from %s: %v", releaseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed fetching the latest release from %s: %s", releaseURL, resp.Status)
+	}
+
+	release := &Release{}
+	err = json.NewDecoder(resp.Body).Decode(release)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing the latest release from %s: %v", releaseURL, err)
+	}
+	if release.Version == "" {
+		return nil, fmt.Errorf("the latest release from %s has no version", releaseURL)
+	}
+	return release, nil
+}
+
+// asset returns the asset of the release with the name, nil if there is none
+func (r *Release) asset(name string) *Asset {
+	for i := range r.Assets {
+		if r.Assets[i].Name == name {
+			return &r.Assets[i]
+		}
+	}
+	return nil
+}
+
+// NewerThan returns true if the release is newer than the version
+func (r *Release) NewerThan(version string) (bool, error) {
+	cmp, err := CompareVersions(r.Version, version)
+	if err != nil {
+		return false, err
+	}
+	return cmp > 0, nil
+}
+
+// parseVersion parses a version like v0.6.1 into its numeric components ignoring a pre-release or build suffix
+func parseVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+
+	parts := strings.Split(trimmed, ".")
+	parsed := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// CompareVersions compares two versions returning -1 if a is older than b, 1 if a is newer than b and 0 if they are equal.
+// Missing components are treated as 0, e.g. 0.6 equals 0.6.0
+func CompareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1, nil
+		}
+		if x > y {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,298 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"v0.9.1", "0.9.0", 1},
+		{"0.9", "v0.9.0", 0},
+		{"0.10.0", "0.9.9", 1},
+		{"0.9.0-rc1", "0.9.1", -1},
+	}
+	for _, testCase := range testCases {
+		cmp, err := CompareVersions(testCase.a, testCase.b)
+		require.NoError(t, err)
+		assert.Equal(t, testCase.expected, cmp, "%s vs %s", testCase.a, testCase.b)
+	}
+
+	_, err := CompareVersions("development", "0.9.0")
+	assert.Error(t, err)
+}
+
+// testRelease serves a release with a client archive for linux/amd64, its checksums and their signature
+type testRelease struct {
+	archive    []byte
+	checksums  []byte
+	signature  []byte
+	unsigned   bool
+	privateKey ed25519.PrivateKey
+}
+
+func newTestRelease(t *testing.T, version string, binary []byte) *testRelease {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range map[string][]byte{"README.md": []byte("readme"), "netbird": binary} {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		require.NoError(t, err)
+		_, err = tarWriter.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	archive := buf.Bytes()
+	sum := sha256.Sum256(archive)
+	checksums := []byte(fmt.Sprintf("0000  netbird_0.9.1_darwin_amd64.tar.gz\n%s  %s\n",
+		hex.EncodeToString(sum[:]), ArtifactName(version, "linux", "amd64")))
+
+	return &testRelease{
+		archive:    archive,
+		checksums:  checksums,
+		signature:  []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, checksums))),
+		privateKey: privateKey,
+	}
+}
+
+func (r *testRelease) serve(t *testing.T, version string) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	checksums := checksumsName(version)
+	mux.HandleFunc("/latest", func(w http.ResponseWriter, _ *http.Request) {
+		release := Release{Version: version, Assets: []Asset{
+			{Name: ArtifactName(version, "linux", "amd64"), URL: server.URL + "/archive"},
+			{Name: checksums, URL: server.URL + "/checksums"},
+		}}
+		if !r.unsigned {
+			release.Assets = append(release.Assets, Asset{Name: checksums + ".sig", URL: server.URL + "/signature"})
+		}
+		_ = json.NewEncoder(w).Encode(release)
+	})
+	mux.HandleFunc("/archive", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(r.archive) })
+	mux.HandleFunc("/checksums", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(r.checksums) })
+	mux.HandleFunc("/signature", func(w http.ResponseWriter, _ *http.Request) { _, _ = w.Write(r.signature) })
+	return server
+}
+
+func TestDownload(t *testing.T) {
+	binary := []byte("new netbird binary")
+	version := "v0.9.1"
+
+	testCases := []struct {
+		name        string
+		modify      func(r *testRelease)
+		signed      bool
+		expectedErr bool
+	}{
+		{
+			name: "Verified Checksum",
+		},
+		{
+			name:   "Verified Signature",
+			signed: true,
+		},
+		{
+			name:        "Tampered Archive",
+			modify:      func(r *testRelease) { r.archive = append(r.archive, 0) },
+			expectedErr: true,
+		},
+		{
+			name:        "Missing Signature",
+			modify:      func(r *testRelease) { r.unsigned = true },
+			signed:      true,
+			expectedErr: true,
+		},
+		{
+			name: "Invalid Signature",
+			modify: func(r *testRelease) {
+				r.signature = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(r.privateKey, []byte("other"))))
+			},
+			signed:      true,
+			expectedErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			release := newTestRelease(t, version, binary)
+			if testCase.modify != nil {
+				testCase.modify(release)
+			}
+			server := release.serve(t, version)
+
+			var publicKey ed25519.PublicKey
+			if testCase.signed {
+				publicKey = release.privateKey.Public().(ed25519.PublicKey)
+			}
+
+			latest, err := LatestRelease(context.Background(), server.Client(), server.URL+"/latest")
+			require.NoError(t, err)
+			newer, err := latest.NewerThan("0.9.0")
+			require.NoError(t, err)
+			assert.True(t, newer)
+
+			dir := t.TempDir()
+			path, err := Download(context.Background(), server.Client(), latest, "linux", "amd64", publicKey, dir)
+			if testCase.expectedErr {
+				assert.Error(t, err)
+				entries, err := os.ReadDir(dir)
+				require.NoError(t, err)
+				assert.Empty(t, entries, "a failed download shouldn't leave files behind")
+				return
+			}
+			require.NoError(t, err)
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, binary, content)
+		})
+	}
+}
+
+func TestDownload_UnsupportedPlatform(t *testing.T) {
+	version := "v0.9.1"
+	server := newTestRelease(t, version, []byte("binary")).serve(t, version)
+
+	latest, err := LatestRelease(context.Background(), server.Client(), server.URL+"/latest")
+	require.NoError(t, err)
+	_, err = Download(context.Background(), server.Client(), latest, "plan9", "amd64", nil, t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestPublicKey(t *testing.T) {
+	embedded, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	configured, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		embedded      string
+		configured    string
+		skipSignature bool
+		expectedKey   ed25519.PublicKey
+		expectedErr   bool
+	}{
+		{
+			name:        "Embedded Key",
+			embedded:    base64.StdEncoding.EncodeToString(embedded),
+			expectedKey: embedded,
+		},
+		{
+			name:        "Configured Key Overrides Embedded",
+			embedded:    base64.StdEncoding.EncodeToString(embedded),
+			configured:  base64.StdEncoding.EncodeToString(configured),
+			expectedKey: configured,
+		},
+		{
+			name:        "Configured Key Without Embedded",
+			configured:  base64.StdEncoding.EncodeToString(configured),
+			expectedKey: configured,
+		},
+		{
+			name:        "No Key",
+			expectedErr: true,
+		},
+		{
+			name:        "Invalid Configured Key",
+			embedded:    base64.StdEncoding.EncodeToString(embedded),
+			configured:  "invalid",
+			expectedErr: true,
+		},
+		{
+			name:          "Skip Signature",
+			embedded:      base64.StdEncoding.EncodeToString(embedded),
+			configured:    base64.StdEncoding.EncodeToString(configured),
+			skipSignature: true,
+		},
+		{
+			name:          "Skip Signature Without Key",
+			skipSignature: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			defer func(key string) { releasePublicKey = key }(releasePublicKey)
+			releasePublicKey = testCase.embedded
+
+			key, err := PublicKey(testCase.configured, testCase.skipSignature)
+			if testCase.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, testCase.expectedKey, key)
+		})
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "netbird")
+	require.NoError(t, os.WriteFile(executable, []byte("old"), 0755))
+
+	writeBinary := func(content string) string {
+		path := filepath.Join(dir, ".netbird-update-"+content)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+	assertContent := func(expected string) {
+		content, err := os.ReadFile(executable)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+
+	installation, err := Install(writeBinary("new"), executable)
+	require.NoError(t, err)
+	assertContent("new")
+	info, err := os.Stat(executable)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm(), "the new binary should get the permissions of the old one")
+
+	require.NoError(t, installation.Rollback())
+	assertContent("old")
+
+	installation, err = Install(writeBinary("newer"), executable)
+	require.NoError(t, err)
+	installation.Commit()
+	assertContent("newer")
+	_, err = os.Stat(executable + ".old")
+	assert.True(t, os.IsNotExist(err), "the old binary should be removed once the installation is committed")
+
+	_, err = Install(filepath.Join(dir, "missing"), executable)
+	assert.Error(t, err)
+	assertContent("newer")
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/netbirdio/netbird/iface"
+)
+
+// DefaultWgStatsInterval is the interval of reading the Wireguard interface statistics if no other one is configured
+const DefaultWgStatsInterval = time.Second
+
+// wgStatsReader reads the statistics of all the peers of the Wireguard interface by the peer key
+type wgStatsReader func() (map[string]iface.PeerStats, error)
+
+// wgStatsCache shares a single read of the Wireguard interface statistics per interval between the features polling
+// them (the static endpoint and idle connection checks, the handshake tracing and the status), so the device is read
+// at most once per interval regardless of the number of the pollers and the peers
+type wgStatsCache struct {
+	reader   wgStatsReader
+	interval time.Duration
+
+	mu       sync.Mutex
+	stats    map[string]iface.PeerStats
+	err      error
+	polledAt time.Time
+}
+
+// newWgStatsCache creates a wgStatsCache reading the statistics with the reader, DefaultWgStatsInterval is used if the
+// interval isn't positive
+func newWgStatsCache(reader wgStatsReader, interval time.Duration) *wgStatsCache {
+	if interval <= 0 {
+		interval = DefaultWgStatsInterval
+	}
+	return &wgStatsCache{reader: reader, interval: interval}
+}
+
+// PeerStats returns the statistics of the remote peer from the latest read of the interface, the interface is read
+// again if the latest read is older than the interval. A failed read is cached for the interval as well
+func (c *wgStatsCache) PeerStats(peerKey string) (*iface.PeerStats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.polledAt.IsZero() || now.Sub(c.polledAt) >= c.interval {
+		c.stats, c.err = c.reader()
+		c.polledAt = now
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	stats, ok := c.stats[peerKey]
+	if !ok {
+		return nil, fmt.Errorf("peer %s doesn't exist on the interface", peerKey)
+	}
+	return &stats, nil
+}
+
+// PolledAt returns the time of the latest read of the interface, zero if it hasn't been read yet
+func (c *wgStatsCache) PolledAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.polledAt
+}
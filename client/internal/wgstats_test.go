@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netbirdio/netbird/iface"
+)
+
+func TestWgStatsCache_SharesReads(t *testing.T) {
+	reads := 0
+	var readErr error
+	cache := newWgStatsCache(func() (map[string]iface.PeerStats, error) {
+		reads++
+		if readErr != nil {
+			return nil, readErr
+		}
+		return map[string]iface.PeerStats{
+			"peer1": {RxBytes: int64(reads)},
+			"peer2": {TxBytes: int64(reads)},
+		}, nil
+	}, 50*time.Millisecond)
+
+	assert.True(t, cache.PolledAt().IsZero(), "expecting no read before the first poll")
+
+	for _, peerKey := range []string{"peer1", "peer2", "peer1"} {
+		_, err := cache.PeerStats(peerKey)
+		require.NoError(t, err)
+	}
+	_, err := cache.PeerStats("unknown")
+	assert.Error(t, err, "expecting an error for a peer missing on the interface")
+	assert.Equal(t, 1, reads, "expecting the pollers to share a single read within the interval")
+	polledAt := cache.PolledAt()
+	assert.False(t, polledAt.IsZero())
+
+	time.Sleep(60 * time.Millisecond)
+	stats, err := cache.PeerStats("peer1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.RxBytes, "expecting the interface to be read again after the interval")
+	assert.True(t, cache.PolledAt().After(polledAt))
+
+	readErr = errors.New("no device")
+	time.Sleep(60 * time.Millisecond)
+	_, err = cache.PeerStats("peer1")
+	assert.ErrorIs(t, err, readErr)
+	_, err = cache.PeerStats("peer2")
+	assert.ErrorIs(t, err, readErr, "expecting the failed read to be cached for the interval")
+	assert.Equal(t, 3, reads)
+}
+
+func TestNewWgStatsCache_DefaultInterval(t *testing.T) {
+	cache := newWgStatsCache(nil, 0)
+	assert.Equal(t, DefaultWgStatsInterval, cache.interval)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	gstatus "google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerCredentials returns the transport credentials of the daemon server. The daemon serves the local CLI
+// in plaintext, the credentials only record whether the CLI runs as an administrator (root connected over the unix
+// socket, an elevated process connected over the loopback TCP address on Windows), so the privileged requests
+// (e.g. the packet capture) are served to the administrators only
+func PeerCredentials() credentials.TransportCredentials {
+	return &peerCredentials{TransportCredentials: insecure.NewCredentials()}
+}
+
+// peerCredentials are plaintext transport credentials recording whether the peers are administrators
+type peerCredentials struct {
+	credentials.TransportCredentials
+}
+
+// peerAuthInfo is the auth info of a CLI connected to the daemon, admin is true if it runs as an administrator
+type peerAuthInfo struct {
+	credentials.AuthInfo
+	admin bool
+}
+
+// ServerHandshake records whether the peer runs as an administrator
+func (c *peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	admin, err := peerIsAdmin(conn)
+	if err != nil {
+		log.Debugf("failed reading the user of the daemon peer %s: %v", conn.RemoteAddr(), err)
+		return conn, info, nil
+	}
+	return conn, peerAuthInfo{AuthInfo: info, admin: admin}, nil
+}
+
+// Clone returns a copy of the credentials
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	return &peerCredentials{TransportCredentials: c.TransportCredentials.Clone()}
+}
+
+// requireAdmin returns a PermissionDenied error unless the caller runs as an administrator: as root connected
+// over the unix socket or, on Windows where the daemon listens on a loopback TCP address, as an elevated process
+func requireAdmin(ctx context.Context) error {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(peerAuthInfo); ok && info.admin {
+			return nil
+		}
+	}
+	return gstatus.Errorf(codes.PermissionDenied, "the request requires an administrator, run the command with sudo or from an elevated prompt on Windows")
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// peerIsAdmin is not supported on this platform, the privileged requests are refused
+func peerIsAdmin(conn net.Conn) (bool, error) {
+	return false, errors.New("the user of a daemon peer can't be read on this platform")
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package server
+
+import (
+	"net"
+)
+
+// peerIsAdmin checks whether the peer connected over the unix socket runs as root. The socket is accessible to all
+// the users, the peers connected over TCP aren't trusted
+func peerIsAdmin(conn net.Conn) (bool, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return false, nil
+	}
+	uid, err := peerUID(unixConn)
+	if err != nil {
+		return false, err
+	}
+	return uid == 0, nil
+}
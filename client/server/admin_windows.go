@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// tcpTableOwnerPIDConnections is TCP_TABLE_OWNER_PID_CONNECTIONS, the table of the TCP connections with their processes
+const tcpTableOwnerPIDConnections = 4
+
+var (
+	modIPHlpAPI             = windows.NewLazySystemDLL("iphlpapi.dll")
+	procGetExtendedTCPTable = modIPHlpAPI.NewProc("GetExtendedTcpTable")
+)
+
+// mibTCPRowOwnerPID is MIB_TCPROW_OWNER_PID, the addresses and the ports are in the network byte order
+type mibTCPRowOwnerPID struct {
+	state      uint32
+	localAddr  uint32
+	localPort  uint32
+	remoteAddr uint32
+	remotePort uint32
+	owningPID  uint32
+}
+
+// peerIsAdmin checks whether the process connected to the loopback TCP address of the daemon is elevated.
+// The process is looked up by the client side of the connection in the TCP table of the system
+func peerIsAdmin(conn net.Conn) (bool, error) {
+	client, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !client.IP.IsLoopback() {
+		return false, nil
+	}
+	server, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return false, nil
+	}
+
+	pid, err := tcpConnOwnerPID(client, server)
+	if err != nil {
+		return false, err
+	}
+	return processIsElevated(pid)
+}
+
+// tcpConnOwnerPID returns the id of the process owning the IPv4 TCP connection from the local to the remote address
+func tcpConnOwnerPID(local *net.TCPAddr, remote *net.TCPAddr) (uint32, error) {
+	if local.IP.To4() == nil || remote.IP.To4() == nil {
+		return 0, fmt.Errorf("connection %s-%s isn't an IPv4 one", local, remote)
+	}
+
+	table, err := tcpTableOwnerPID()
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range table {
+		if row.localPort == uint32(local.Port) && row.remotePort == uint32(remote.Port) &&
+			row.localAddr.Equal(local.IP) && row.remoteAddr.Equal(remote.IP) {
+			return row.owningPID, nil
+		}
+	}
+	return 0, fmt.Errorf("connection %s-%s not found in the TCP table", local, remote)
+}
+
+// tcpConnection is a row of the TCP table with the addresses and the ports in the host byte order
+type tcpConnection struct {
+	localAddr  net.IP
+	localPort  uint32
+	remoteAddr net.IP
+	remotePort uint32
+	owningPID  uint32
+}
+
+// tcpTableOwnerPID reads the IPv4 TCP connections of the system with the processes owning them
+func tcpTableOwnerPID() ([]tcpConnection, error) {
+	var size uint32
+	var buf []byte
+	for {
+		var ptr uintptr
+		if len(buf) > 0 {
+			ptr = uintptr(unsafe.Pointer(&buf[0]))
+		}
+		ret, _, _ := procGetExtendedTCPTable.Call(ptr, uintptr(unsafe.Pointer(&size)), 0, windows.AF_INET,
+			tcpTableOwnerPIDConnections, 0)
+		if ret == uintptr(windows.ERROR_INSUFFICIENT_BUFFER) {
+			buf = make([]byte, size)
+			continue
+		}
+		if ret != 0 {
+			return nil, fmt.Errorf("failed reading the TCP table: %w", windows.Errno(ret))
+		}
+		break
+	}
+	if len(buf) < 4 {
+		return nil, errors.New("failed reading the TCP table: empty table")
+	}
+
+	entries := binary.LittleEndian.Uint32(buf[:4])
+	rowSize := unsafe.Sizeof(mibTCPRowOwnerPID{})
+	// the rows follow the number of the entries, aligned to the 4 bytes of their fields
+	if uintptr(len(buf)) < 4+uintptr(entries)*rowSize {
+		return nil, errors.New("failed reading the TCP table: truncated table")
+	}
+
+	connections := make([]tcpConnection, 0, entries)
+	for i := uintptr(0); i < uintptr(entries); i++ {
+		row := (*mibTCPRowOwnerPID)(unsafe.Pointer(&buf[4+i*rowSize]))
+		connections = append(connections, tcpConnection{
+			localAddr:  ipv4FromTable(row.localAddr),
+			localPort:  portFromTable(row.localPort),
+			remoteAddr: ipv4FromTable(row.remoteAddr),
+			remotePort: portFromTable(row.remotePort),
+			owningPID:  row.owningPID,
+		})
+	}
+	return connections, nil
+}
+
+// ipv4FromTable converts an address of the TCP table, stored in the network byte order, to an IP
+func ipv4FromTable(addr uint32) net.IP {
+	ip := make(net.IP, net.IPv4len)
+	binary.LittleEndian.PutUint32(ip, addr)
+	return ip
+}
+
+// portFromTable converts a port of the TCP table, stored in the network byte order in the lower 2 bytes
+func portFromTable(port uint32) uint32 {
+	return uint32(uint16(port)>>8 | uint16(port)<<8)
+}
+
+// processIsElevated checks whether the process runs with the administrator privileges, e.g. started with
+// Run as administrator or as a service of the LocalSystem account
+func processIsElevated(pid uint32) (bool, error) {
+	process, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return false, fmt.Errorf("failed opening process %d: %w", pid, err)
+	}
+	defer windows.CloseHandle(process) //nolint
+
+	var token windows.Token
+	err = windows.OpenProcessToken(process, windows.TOKEN_QUERY, &token)
+	if err != nil {
+		return false, fmt.Errorf("failed opening the token of process %d: %w", pid, err)
+	}
+	defer token.Close() //nolint
+
+	return token.IsElevated(), nil
+}
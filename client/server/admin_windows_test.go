@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestPeerIsAdmin(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("expecting the connection to be accepted")
+	}
+	defer server.Close()
+
+	// the test process is on both sides of the connection, so the peer is as elevated as the test
+	pid, err := tcpConnOwnerPID(server.RemoteAddr().(*net.TCPAddr), server.LocalAddr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != uint32(os.Getpid()) {
+		t.Errorf("expecting the connection to be owned by the test process %d, got %d", os.Getpid(), pid)
+	}
+
+	admin, err := peerIsAdmin(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := windows.GetCurrentProcessToken().IsElevated(); admin != expected {
+		t.Errorf("expecting the peer to be an administrator %t, got %t", expected, admin)
+	}
+}
+
+func TestTableConversions(t *testing.T) {
+	// 127.0.0.1:41731 as stored in the TCP table
+	if ip := ipv4FromTable(0x0100007f); !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("expecting 127.0.0.1, got %s", ip)
+	}
+	if port := portFromTable(0x03a3); port != 41731 {
+		t.Errorf("expecting port 41731, got %d", port)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	gstatus "google.golang.org/grpc/status"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/iface"
+)
+
+const (
+	// DefaultCaptureDuration and maxCaptureDuration are the default and the longest duration of a packet capture
+	DefaultCaptureDuration = 30 * time.Second
+	maxCaptureDuration     = 5 * time.Minute
+	// defaultCaptureSize and maxCaptureSize are the default and the largest size limit of a pcap file
+	defaultCaptureSize = 32 << 20
+	maxCaptureSize     = 256 << 20
+)
+
+// StartCapture starts capturing the packets of the Wireguard interface to a pcap file next to the config,
+// the capture stops after the duration or once the file has reached the size limit. One capture runs at a time
+// and it is served to the administrators only
+func (s *Server) StartCapture(ctx context.Context, msg *proto.StartCaptureRequest) (*proto.StartCaptureResponse, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	duration := DefaultCaptureDuration
+	if msg.GetDuration() != nil {
+		duration = msg.GetDuration().AsDuration()
+	}
+	if duration <= 0 || duration > maxCaptureDuration {
+		return nil, gstatus.Errorf(codes.InvalidArgument, "capture duration %s is out of range, the longest one is %s", duration, maxCaptureDuration)
+	}
+
+	maxBytes := msg.GetMaxBytes()
+	if maxBytes == 0 {
+		maxBytes = defaultCaptureSize
+	}
+	if maxBytes < 0 || maxBytes > maxCaptureSize {
+		return nil, gstatus.Errorf(codes.InvalidArgument, "capture size limit %d is out of range, the largest one is %d bytes", maxBytes, maxCaptureSize)
+	}
+
+	s.captureMutex.Lock()
+	defer s.captureMutex.Unlock()
+
+	if s.capture != nil {
+		select {
+		case <-s.capture.Done():
+		default:
+			return nil, gstatus.Errorf(codes.FailedPrecondition, "a capture to %s is already running", s.capture.Path())
+		}
+	}
+
+	path := filepath.Join(filepath.Dir(s.configPath), fmt.Sprintf("capture-%s.pcap", time.Now().Format("20060102-150405.000")))
+	capture, err := internal.CtxGetState(s.rootCtx).StartPacketCapture(path, maxBytes)
+	switch {
+	case errors.Is(err, internal.ErrEngineNotRunning):
+		return nil, gstatus.Errorf(codes.FailedPrecondition, "%v", err)
+	case errors.Is(err, iface.ErrCaptureNotSupported):
+		return nil, gstatus.Errorf(codes.Unimplemented, "%v", err)
+	case err != nil:
+		return nil, gstatus.Errorf(codes.Internal, "failed starting capture: %v", err)
+	}
+	s.capture = capture
+
+	go func() {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-capture.Done():
+		case <-s.rootCtx.Done():
+		}
+		err := capture.Close()
+		if err != nil {
+			log.Warnf("failed writing capture %s: %v", capture.Path(), err)
+		}
+	}()
+
+	log.Infof("capturing packets to %s for %s", path, duration)
+	return &proto.StartCaptureResponse{Path: path}, nil
+}
+
+// StopCapture stops the running capture, if any, and returns the results of the latest capture
+func (s *Server) StopCapture(ctx context.Context, _ *proto.StopCaptureRequest) (*proto.StopCaptureResponse, error) {
+	err := requireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.captureMutex.Lock()
+	capture := s.capture
+	s.captureMutex.Unlock()
+
+	if capture == nil {
+		return nil, gstatus.Errorf(codes.FailedPrecondition, "no capture has been started")
+	}
+	// a failure writing the file is logged by the goroutine started with the capture
+	_ = capture.Close()
+
+	return &proto.StopCaptureResponse{
+		Path:    capture.Path(),
+		Packets: capture.Packets(),
+		Bytes:   capture.Size(),
+	}, nil
+}
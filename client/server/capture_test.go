@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	gstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/iface"
+)
+
+// peerContext returns a context of a request from a CLI connected to the daemon as an administrator or not
+func peerContext(admin bool) context.Context {
+	_, info, _ := insecure.NewCredentials().ServerHandshake(nil)
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: peerAuthInfo{AuthInfo: info, admin: admin}})
+}
+
+func TestRequireAdmin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		expected codes.Code
+	}{
+		{"no peer", context.Background(), codes.PermissionDenied},
+		{"tcp peer", peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}}), codes.PermissionDenied},
+		{"unprivileged user", peerContext(false), codes.PermissionDenied},
+		{"administrator", peerContext(true), codes.OK},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if code := gstatus.Code(requireAdmin(testCase.ctx)); code != testCase.expected {
+				t.Errorf("expecting %s, got %s", testCase.expected, code)
+			}
+		})
+	}
+}
+
+func TestPeerCredentials(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "daemon.sock")
+	lis, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(PeerCredentials()))
+	proto.RegisterDaemonServiceServer(server, New(internal.CtxInitState(context.Background()), "", "", "", ""))
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the caller passes the admin check unless the test doesn't run as root
+	expected := codes.FailedPrecondition
+	if os.Geteuid() != 0 {
+		expected = codes.PermissionDenied
+	}
+	_, err = proto.NewDaemonServiceClient(conn).StopCapture(context.Background(), &proto.StopCaptureRequest{})
+	if code := gstatus.Code(err); code != expected {
+		t.Errorf("expecting %s, got %v", expected, err)
+	}
+}
+
+func TestServer_Capture(t *testing.T) {
+	ctx := internal.CtxInitState(context.Background())
+	dir := t.TempDir()
+	s := New(ctx, "", "", filepath.Join(dir, "config.json"), "")
+	admin := peerContext(true)
+
+	_, err := s.StartCapture(peerContext(false), &proto.StartCaptureRequest{})
+	if gstatus.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the capture of an unprivileged user to fail with PermissionDenied, got %v", err)
+	}
+	_, err = s.StartCapture(admin, &proto.StartCaptureRequest{})
+	if gstatus.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expecting the capture without a running engine to fail with FailedPrecondition, got %v", err)
+	}
+	_, err = s.StopCapture(admin, &proto.StopCaptureRequest{})
+	if gstatus.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expecting stopping before any capture to fail with FailedPrecondition, got %v", err)
+	}
+
+	// the packets of the loopback interface are captured like the ones of a kernel Wireguard interface
+	internal.CtxGetState(ctx).SetPacketCaptureSource(func(path string, maxBytes int64) (*iface.PacketCapture, error) {
+		return (&iface.WGIface{Name: "lo"}).StartCapture(path, maxBytes)
+	})
+
+	invalid := []*proto.StartCaptureRequest{
+		{Duration: durationpb.New(10 * time.Minute)},
+		{Duration: durationpb.New(-time.Second)},
+		{MaxBytes: maxCaptureSize + 1},
+		{MaxBytes: -1},
+	}
+	for _, req := range invalid {
+		_, err = s.StartCapture(admin, req)
+		if gstatus.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting capture %v to fail with InvalidArgument, got %v", req, err)
+		}
+	}
+
+	resp, err := s.StartCapture(admin, &proto.StartCaptureRequest{Duration: durationpb.New(time.Minute)})
+	if gstatus.Code(err) == codes.Internal && strings.Contains(err.Error(), syscall.EPERM.Error()) {
+		t.Skipf("capturing packets requires CAP_NET_RAW: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(resp.GetPath()) != dir {
+		t.Errorf("expecting the capture next to the config in %s, got %s", dir, resp.GetPath())
+	}
+
+	_, err = s.StartCapture(admin, &proto.StartCaptureRequest{})
+	if gstatus.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expecting a second capture to fail with FailedPrecondition, got %v", err)
+	}
+
+	conn, err := net.Dial("udp", "127.0.0.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = conn.Write([]byte("captured"))
+	}
+	conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	stopped, err := s.StopCapture(admin, &proto.StopCaptureRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stopped.GetPath() != resp.GetPath() || stopped.GetPackets() == 0 {
+		t.Errorf("expecting packets captured to %s, got %v", resp.GetPath(), stopped)
+	}
+	info, err := os.Stat(stopped.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != stopped.GetBytes() {
+		t.Errorf("expecting the pcap file of %d bytes, got %d", stopped.GetBytes(), info.Size())
+	}
+
+	// the stopped capture doesn't block the next one
+	_, err = s.StartCapture(admin, &proto.StartCaptureRequest{Duration: durationpb.New(time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.StopCapture(admin, &proto.StopCaptureRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
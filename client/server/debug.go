@@ -0,0 +1,269 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	gstatus "google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/client/system"
+)
+
+const (
+	// maxDebugBundleSize caps the debug bundle below the 4 MiB limit of the gRPC messages received by the CLI
+	maxDebugBundleSize = 3 << 20
+	// debugBundleMargin is kept free for the zip headers when the logs fill the rest of the bundle
+	debugBundleMargin = 64 << 10
+	// routeDumpTimeout limits the command dumping the routing tables of the OS
+	routeDumpTimeout = 5 * time.Second
+)
+
+// setupKeyPattern matches the setup keys (UUIDs) that may have been logged, e.g. with a failed login
+var setupKeyPattern = regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// debugState is the state of the daemon included in the debug bundle
+type debugState interface {
+	Status() (internal.StatusType, error)
+	DebugSnapshot() *internal.DebugSnapshot
+	InstalledRoutes() ([]internal.RouteInfo, error)
+}
+
+// debugStatus is the status entry of the debug bundle
+type debugStatus struct {
+	// Status of the daemon, e.g. Connected
+	Status string
+	// Error is the error the daemon has failed with, empty if there is none
+	Error string
+	// Engine is the state of the running Engine, null if no Engine is running
+	Engine *internal.DebugSnapshot
+}
+
+// DebugBundle assembles a zip archive for troubleshooting: the tail of the log, the engine status with the ICE details
+// of the peers, the config with the secrets redacted, the interfaces and the routes
+func (s *Server) DebugBundle(_ context.Context, _ *proto.DebugBundleRequest) (*proto.DebugBundleResponse, error) {
+	s.mutex.Lock()
+	config := s.config
+	s.mutex.Unlock()
+
+	archive, err := createDebugBundle(internal.CtxGetState(s.rootCtx), config, s.logFile, maxDebugBundleSize)
+	if err != nil {
+		return nil, gstatus.Errorf(codes.Internal, "failed creating debug bundle: %v", err)
+	}
+
+	return &proto.DebugBundleResponse{Archive: archive}, nil
+}
+
+// debugBundle writes the entries of a debug bundle scrubbing the secrets from them
+type debugBundle struct {
+	buf     *bytes.Buffer
+	zip     *zip.Writer
+	secrets []string
+}
+
+// add writes an entry replacing the secrets with internal.RedactedSecret
+func (b *debugBundle) add(name string, data []byte) error {
+	for _, secret := range b.secrets {
+		data = bytes.ReplaceAll(data, []byte(secret), []byte(internal.RedactedSecret))
+	}
+
+	w, err := b.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	return b.zip.Flush()
+}
+
+// addJSON writes an entry with the indented JSON of the value
+func (b *debugBundle) addJSON(name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, data)
+}
+
+// createDebugBundle returns the zip archive of a debug bundle of at most maxSize bytes. The log fills the space left
+// by the other entries, its oldest lines are dropped. The config may be nil if the daemon hasn't read it yet and
+// the logFile "console" if the daemon doesn't log to a file
+func createDebugBundle(state debugState, config *internal.Config, logFile string, maxSize int) ([]byte, error) {
+	bundle := &debugBundle{buf: &bytes.Buffer{}}
+	bundle.zip = zip.NewWriter(bundle.buf)
+	if config != nil {
+		bundle.secrets = config.Secrets()
+	}
+
+	version := fmt.Sprintf("version: %s\nos: %s/%s\ncreated at: %s\n",
+		system.NetbirdVersion(), runtime.GOOS, runtime.GOARCH, time.Now().Format(time.RFC3339))
+	err := bundle.add("version.txt", []byte(version))
+	if err != nil {
+		return nil, err
+	}
+
+	status := debugStatus{Engine: state.DebugSnapshot()}
+	statusType, err := state.Status()
+	status.Status = string(statusType)
+	if err != nil {
+		status.Error = err.Error()
+	}
+	err = bundle.addJSON("status.json", status)
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		err = bundle.addJSON("config.json", config.Redacted())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = bundle.add("interfaces.txt", dumpInterfaces())
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := state.InstalledRoutes()
+	err = bundle.add("routes.txt", dumpRoutes(installed, err))
+	if err != nil {
+		return nil, err
+	}
+
+	if logFile != "" && logFile != "console" {
+		err = addLogTail(bundle, logFile, int64(maxSize-bundle.buf.Len()-debugBundleMargin))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = bundle.zip.Close()
+	if err != nil {
+		return nil, err
+	}
+	if bundle.buf.Len() > maxSize {
+		return nil, fmt.Errorf("debug bundle of %d bytes exceeds the limit of %d bytes", bundle.buf.Len(), maxSize)
+	}
+	return bundle.buf.Bytes(), nil
+}
+
+// addLogTail writes the last maxBytes of the log file starting with a whole line, the setup keys are scrubbed.
+// A missing or unreadable log is noted in the entry instead
+func addLogTail(bundle *debugBundle, logFile string, maxBytes int64) error {
+	data, err := readTail(logFile, maxBytes)
+	if err != nil {
+		data = []byte(fmt.Sprintf("failed reading log %s: %v\n", logFile, err))
+	}
+	data = setupKeyPattern.ReplaceAll(data, []byte(internal.RedactedSecret))
+	return bundle.add("client.log", data)
+}
+
+// readTail returns at most the last maxBytes of the file, the partial first line is dropped if the file has been cut
+func readTail(path string, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() <= maxBytes {
+		return io.ReadAll(file)
+	}
+
+	// the byte before the tail is read as well to tell whether the tail starts with a whole line
+	offset := info.Size() - maxBytes - 1
+	data, err := io.ReadAll(io.NewSectionReader(file, offset, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[i+1:], nil
+	}
+	return nil, nil
+}
+
+// dumpInterfaces lists the network interfaces of the machine with their addresses
+func dumpInterfaces() []byte {
+	var buf bytes.Buffer
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		fmt.Fprintf(&buf, "failed listing interfaces: %v\n", err)
+		return buf.Bytes()
+	}
+
+	for _, iface := range interfaces {
+		fmt.Fprintf(&buf, "%s: mtu %d flags %s hwaddr %s\n", iface.Name, iface.MTU, iface.Flags, iface.HardwareAddr)
+		addrs, err := iface.Addrs()
+		if err != nil {
+			fmt.Fprintf(&buf, "\tfailed listing addresses: %v\n", err)
+			continue
+		}
+		for _, addr := range addrs {
+			fmt.Fprintf(&buf, "\t%s\n", addr)
+		}
+	}
+	return buf.Bytes()
+}
+
+// dumpRoutes lists the routes through the Wireguard interface attributed to the peers followed by the routing tables of the OS
+func dumpRoutes(installed []internal.RouteInfo, installedErr error) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("Routes installed by the engine:\n")
+	if installedErr != nil {
+		fmt.Fprintf(&buf, "failed reading routes: %v\n", installedErr)
+	}
+	for _, route := range installed {
+		peer := route.Peer
+		if peer == "" {
+			peer = "-"
+		}
+		fmt.Fprintf(&buf, "%s dev %s peer %s\n", route.Network, route.Interface, peer)
+	}
+
+	command := routeDumpCommand()
+	fmt.Fprintf(&buf, "\n%s:\n", strings.Join(command, " "))
+	ctx, cancel := context.WithTimeout(context.Background(), routeDumpTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	buf.Write(out)
+	if err != nil {
+		fmt.Fprintf(&buf, "failed dumping routes: %v\n", err)
+	}
+	return buf.Bytes()
+}
+
+// routeDumpCommand returns the command printing the routing tables of the OS
+func routeDumpCommand() []string {
+	switch runtime.GOOS {
+	case "linux":
+		return []string{"ip", "route", "show", "table", "all"}
+	case "windows":
+		return []string{"route", "print"}
+	default:
+		return []string{"netstat", "-rn"}
+	}
+}
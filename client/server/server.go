@@ -2,24 +2,32 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	gstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/netbirdio/netbird/client/internal"
 	"github.com/netbirdio/netbird/client/proto"
+	"github.com/netbirdio/netbird/client/system"
+	"github.com/netbirdio/netbird/iface"
+	"github.com/netbirdio/netbird/util"
 )
 
 // Server for service control.
 type Server struct {
 	rootCtx   context.Context
 	actCancel context.CancelFunc
+	// clientDone is closed when the client run by the daemon has stopped, nil if it hasn't been run
+	clientDone chan struct{}
 
 	managementURL string
 	adminURL      string
@@ -30,6 +38,10 @@ type Server struct {
 
 	mutex  sync.Mutex
 	config *internal.Config
+
+	// captureMutex guards capture, the latest packet capture started by StartCapture, nil if none has been started
+	captureMutex sync.Mutex
+	capture      *iface.PacketCapture
 	proto.UnimplementedDaemonServiceServer
 }
 
@@ -38,6 +50,8 @@ type oauthAuthFlow struct {
 	client     internal.OAuthClient
 	info       internal.DeviceAuthInfo
 	waitCancel context.CancelFunc
+	// reRegister registers the peer with the SSO login even if it is registered already
+	reRegister bool
 }
 
 // New server instance constructor.
@@ -85,25 +99,75 @@ func (s *Server) Start() error {
 		return err
 	}
 
-	// if configuration exists, we just start connections.
+	// fail early with the hint how to unlock the key encrypted at rest instead of retrying the connection
+	if _, err := config.WgPrivateKey(); errors.Is(err, internal.ErrPrivateKeyLocked) {
+		log.Errorf("unable to read the Wireguard private key: %v", err)
+		return err
+	}
 
 	s.config = config
 
+	if config.AutoUpdateCheck {
+		go s.checkUpdates(s.rootCtx)
+	}
+
+	if err := util.SetLogRotation(config.LogRotation()); err != nil {
+		log.Warnf("failed setting the log rotation: %v", err)
+	}
+
+	if config.DisableAutoConnect {
+		log.Infof("connections have been turned down, waiting for the up command")
+		return nil
+	}
+
+	// if configuration exists, we just start connections.
+	s.runClient(ctx)
+
+	return nil
+}
+
+// runClient runs the client connections in the background until the context is cancelled
+func (s *Server) runClient(ctx context.Context) {
+	done := make(chan struct{})
+	s.clientDone = done
+
 	go func() {
-		if err := internal.RunClient(ctx, config); err != nil {
-			log.Errorf("init connections: %v", err)
+		defer close(done)
+		if err := internal.RunClient(ctx, s.config); err != nil {
+			log.Errorf("run client connection: %v", internal.CtxGetState(ctx).Wrap(err))
 		}
 	}()
+}
 
-	return nil
+// setAutoConnect persists whether the daemon connects on start, so that the connections turned down by the user
+// stay down after a restart of the daemon
+func (s *Server) setAutoConnect(enabled bool) error {
+	if s.config.DisableAutoConnect == !enabled {
+		return nil
+	}
+	s.config.DisableAutoConnect = !enabled
+	return util.WriteJson(s.configPath, s.config)
+}
+
+// setExitNode persists the peer selected as the exit node, the daemon routes the traffic through it on the next start
+func (s *Server) setExitNode(exitNode string) error {
+	if s.config.ExitNode == exitNode {
+		return nil
+	}
+	s.config.ExitNode = exitNode
+	return util.WriteJson(s.configPath, s.config)
 }
 
 // loginAttempt attempts to login using the provided information. it returns a status in case something fails
-func (s *Server) loginAttempt(ctx context.Context, setupKey, jwtToken string) (internal.StatusType, error) {
+func (s *Server) loginAttempt(ctx context.Context, setupKey, jwtToken string, reRegister bool) (internal.StatusType, error) {
+	return loginStatus(internal.Login(ctx, s.config, setupKey, jwtToken, reRegister))
+}
+
+// loginStatus returns the status of the daemon after a failed login attempt
+func loginStatus(err error) (internal.StatusType, error) {
 	var status internal.StatusType
-	err := internal.Login(ctx, s.config, setupKey, jwtToken)
 	if err != nil {
-		if s, ok := gstatus.FromError(err); ok && (s.Code() == codes.InvalidArgument || s.Code() == codes.PermissionDenied) {
+		if errors.Is(err, internal.ErrLoginRequired) || errors.Is(err, internal.ErrInvalidSetupKey) {
 			log.Warnf("failed login: %v", err)
 			status = internal.StatusNeedsLogin
 		} else {
@@ -162,37 +226,45 @@ func (s *Server) Login(callerCtx context.Context, msg *proto.LoginRequest) (*pro
 	s.config = config
 	s.mutex.Unlock()
 
-	if _, err := s.loginAttempt(ctx, "", ""); err == nil {
-		state.Set(internal.StatusIdle)
-		return &proto.LoginResponse{}, nil
+	// a peer registering again doesn't login with its registered key
+	if !msg.ReRegister {
+		if _, err := s.loginAttempt(ctx, "", "", false); err == nil {
+			state.Set(internal.StatusIdle)
+			return &proto.LoginResponse{}, nil
+		}
 	}
 
 	state.Set(internal.StatusConnecting)
 
 	if msg.SetupKey == "" {
+		s.mutex.Lock()
+		s.oauthAuthFlow.reRegister = msg.ReRegister
+		s.mutex.Unlock()
+
+		var hostedClient internal.OAuthClient
 		providerConfig, err := internal.GetDeviceAuthorizationFlowInfo(ctx, config)
 		if err != nil {
-			state.Set(internal.StatusLoginFailed)
 			s, ok := gstatus.FromError(err)
 			if ok && s.Code() == codes.NotFound {
-				return nil, gstatus.Errorf(codes.NotFound, "no SSO provider returned from management. "+
-					"If you are using hosting Netbird see documentation at "+
-					"https://github.com/netbirdio/netbird/tree/main/management for details")
+				// no identity provider device flow, the user approves the login in the dashboard instead
+				hostedClient = internal.NewManagementDeviceFlow(config)
 			} else if ok && s.Code() == codes.Unimplemented {
+				state.Set(internal.StatusLoginFailed)
 				return nil, gstatus.Errorf(codes.Unimplemented, "the management server, %s, does not support SSO providers, "+
 					"please update your server or use Setup Keys to login", config.ManagementURL)
 			} else {
+				state.Set(internal.StatusLoginFailed)
 				log.Errorf("getting device authorization flow info failed with error: %v", err)
 				return nil, err
 			}
+		} else {
+			hostedClient = internal.NewHostedDeviceFlow(
+				providerConfig.ProviderConfig.Audience,
+				providerConfig.ProviderConfig.ClientID,
+				providerConfig.ProviderConfig.Domain,
+			)
 		}
 
-		hostedClient := internal.NewHostedDeviceFlow(
-			providerConfig.ProviderConfig.Audience,
-			providerConfig.ProviderConfig.ClientID,
-			providerConfig.ProviderConfig.Domain,
-		)
-
 		if s.oauthAuthFlow.client != nil && s.oauthAuthFlow.client.GetClientID(ctx) == hostedClient.GetClientID(context.TODO()) {
 			if s.oauthAuthFlow.expiresAt.After(time.Now().Add(90 * time.Second)) {
 				log.Debugf("using previous device flow info")
@@ -210,6 +282,12 @@ func (s *Server) Login(callerCtx context.Context, msg *proto.LoginRequest) (*pro
 
 		deviceAuthInfo, err := hostedClient.RequestDeviceCode(context.TODO())
 		if err != nil {
+			if s, ok := gstatus.FromError(err); ok && s.Code() == codes.Unimplemented {
+				state.Set(internal.StatusLoginFailed)
+				return nil, gstatus.Errorf(codes.NotFound, "no SSO provider returned from management. "+
+					"If you are using hosting Netbird see documentation at "+
+					"https://github.com/netbirdio/netbird/tree/main/management for details")
+			}
 			log.Errorf("getting a request device code failed: %v", err)
 			return nil, err
 		}
@@ -230,7 +308,7 @@ func (s *Server) Login(callerCtx context.Context, msg *proto.LoginRequest) (*pro
 		}, nil
 	}
 
-	if loginStatus, err := s.loginAttempt(ctx, msg.SetupKey, ""); err != nil {
+	if loginStatus, err := s.loginAttempt(ctx, msg.SetupKey, "", msg.ReRegister); err != nil {
 		state.Set(loginStatus)
 		return nil, err
 	}
@@ -271,6 +349,7 @@ func (s *Server) WaitSSOLogin(callerCtx context.Context, msg *proto.WaitSSOLogin
 
 	s.mutex.Lock()
 	deviceAuthInfo := s.oauthAuthFlow.info
+	reRegister := s.oauthAuthFlow.reRegister
 	s.mutex.Unlock()
 
 	if deviceAuthInfo.UserCode != msg.UserCode {
@@ -303,8 +382,14 @@ func (s *Server) WaitSSOLogin(callerCtx context.Context, msg *proto.WaitSSOLogin
 		return nil, err
 	}
 
-	if loginStatus, err := s.loginAttempt(ctx, "", tokenInfo.AccessToken); err != nil {
-		state.Set(loginStatus)
+	var loginErr error
+	if tokenInfo.TokenType == internal.ManagementDeviceAuthTokenType {
+		loginErr = internal.LoginWithDeviceAuth(ctx, s.config, tokenInfo.AccessToken, reRegister)
+	} else {
+		loginErr = internal.Login(ctx, s.config, "", tokenInfo.AccessToken, reRegister)
+	}
+	if status, err := loginStatus(loginErr); err != nil {
+		state.Set(status)
 		return nil, err
 	}
 
@@ -346,29 +431,76 @@ func (s *Server) Up(callerCtx context.Context, msg *proto.UpRequest) (*proto.UpR
 		return nil, fmt.Errorf("config is not defined, please call login command first")
 	}
 
-	go func() {
-		if err := internal.RunClient(ctx, s.config); err != nil {
-			log.Errorf("run client connection: %v", state.Wrap(err))
-			return
+	if err := s.setAutoConnect(true); err != nil {
+		log.Errorf("failed persisting the up state: %v", err)
+		return nil, gstatus.Errorf(codes.Internal, "failed persisting the up state: %v", err)
+	}
+
+	if msg.ExitNode != nil {
+		if err := s.setExitNode(msg.GetExitNode()); err != nil {
+			log.Errorf("failed persisting the exit node: %v", err)
+			return nil, gstatus.Errorf(codes.Internal, "failed persisting the exit node: %v", err)
 		}
-	}()
+	}
+
+	state.Set(internal.StatusConnecting)
+	s.runClient(ctx)
 
 	return &proto.UpResponse{}, nil
 }
 
-// Down engine work in the daemon.
+// Down stops engine work in the daemon and waits for the connections to close. The down state is persisted,
+// so the daemon doesn't connect on the next start until the up command. Calling Down when it is down has no effect
 func (s *Server) Down(ctx context.Context, msg *proto.DownRequest) (*proto.DownResponse, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if s.config != nil {
+		if err := s.setAutoConnect(false); err != nil {
+			log.Errorf("failed persisting the down state: %v", err)
+			return nil, gstatus.Errorf(codes.Internal, "failed persisting the down state: %v", err)
+		}
+	}
+
 	if s.actCancel == nil {
-		return nil, fmt.Errorf("service is not up")
+		return &proto.DownResponse{}, nil
 	}
 	s.actCancel()
+	s.actCancel = nil
+
+	if s.clientDone != nil {
+		select {
+		case <-s.clientDone:
+		case <-ctx.Done():
+			return nil, gstatus.Errorf(codes.DeadlineExceeded, "connections haven't been closed: %v", ctx.Err())
+		}
+	}
 
 	return &proto.DownResponse{}, nil
 }
 
+// Stop stops the client run by the daemon and waits until the Engine has stopped or the context is done.
+// Unlike Down, the state isn't persisted, so the daemon connects again on the next start (e.g. after a reboot)
+func (s *Server) Stop(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.actCancel != nil {
+		s.actCancel()
+		s.actCancel = nil
+	}
+	done := s.clientDone
+	s.mutex.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Status starts engine work in the daemon.
 func (s *Server) Status(
 	ctx context.Context,
@@ -377,12 +509,110 @@ func (s *Server) Status(
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	status, err := internal.CtxGetState(s.rootCtx).Status()
+	state := internal.CtxGetState(s.rootCtx)
+	status, err := state.Status()
 	if err != nil {
 		return nil, err
 	}
 
-	return &proto.StatusResponse{Status: string(status)}, nil
+	resp := &proto.StatusResponse{Status: string(status), WgPort: int32(state.WgPort()), AvailableUpdate: state.AvailableUpdate()}
+	if clientUpdate := state.ClientUpdate(); clientUpdate != nil {
+		resp.ClientUpdate = &proto.ClientUpdate{
+			MinVersion:         clientUpdate.MinVersion,
+			RecommendedVersion: clientUpdate.RecommendedVersion,
+			DownloadUrl:        clientUpdate.DownloadURL,
+			CurrentVersion:     system.NetbirdVersion(),
+		}
+	}
+	for key, probe := range state.PeerProbes() {
+		resp.PeerProbes = append(resp.PeerProbes, &proto.PeerProbe{
+			PubKey: key,
+			RttMs:  float64(probe.RTT) / float64(time.Millisecond),
+			Loss:   probe.Loss,
+		})
+	}
+	sort.Slice(resp.PeerProbes, func(i, j int) bool {
+		return resp.PeerProbes[i].PubKey < resp.PeerProbes[j].PubKey
+	})
+	peers := map[string]*proto.PeerState{}
+	for key, name := range state.PeerNames() {
+		peers[key] = &proto.PeerState{PubKey: key, Name: name}
+	}
+	if engineStatus := state.EngineStatus(); engineStatus != nil {
+		resp.Management = toStreamState(engineStatus.Management)
+		resp.Signal = toStreamState(engineStatus.Signal)
+		resp.Relays = engineStatus.Relays
+		resp.RelaysExpireAt = toTimestamp(engineStatus.RelaysExpireAt)
+		resp.WgStatsPolledAt = toTimestamp(engineStatus.WgStatsPolledAt)
+		resp.WgMode = string(engineStatus.WgMode)
+		resp.RejectedEndpoints = int64(engineStatus.RejectedEndpoints)
+		resp.ExitNode = engineStatus.ExitNode
+		resp.ExitNodeActive = engineStatus.ExitNodeActive
+		for _, route := range engineStatus.NetworkRoutes {
+			resp.NetworkRoutes = append(resp.NetworkRoutes, &proto.NetworkRouteState{
+				Network: route.Network,
+				Peer:    route.Peer,
+				Peers:   route.Peers,
+			})
+		}
+		for _, conflict := range engineStatus.AllowedIPsConflicts {
+			resp.AllowedIpsConflicts = append(resp.AllowedIpsConflicts, &proto.AllowedIPsConflictState{
+				Prefix:            conflict.Prefix,
+				Peer:              conflict.Peer,
+				ConflictingPrefix: conflict.ConflictingPrefix,
+				ConflictingPeer:   conflict.ConflictingPeer,
+				Duplicate:         conflict.Duplicate,
+			})
+		}
+		resp.ConnFailures = make(map[string]int64, len(engineStatus.ConnFailures))
+		for class, count := range engineStatus.ConnFailures {
+			resp.ConnFailures[string(class)] = int64(count)
+		}
+		for _, connStatus := range engineStatus.Peers {
+			peer, ok := peers[connStatus.PubKey]
+			if !ok {
+				peer = &proto.PeerState{PubKey: connStatus.PubKey}
+				peers[connStatus.PubKey] = peer
+			}
+			peer.ConnStatus = connStatus.Status
+			peer.ConnType = string(connStatus.ConnType)
+			peer.RelayAddress = connStatus.RelayAddress
+			peer.IpFamily = string(connStatus.IPFamily)
+			peer.LastHandshake = toTimestamp(connStatus.LastHandshake)
+			peer.UpgradedFrom = string(connStatus.UpgradedFrom)
+			peer.UpgradedAt = toTimestamp(connStatus.UpgradedAt)
+			peer.LastFailure = string(connStatus.LastFailure)
+			peer.Ip = connStatus.IP
+			peer.SshHostKey = connStatus.SSHHostKey
+			for _, event := range connStatus.Trace {
+				peer.Trace = append(peer.Trace, &proto.TraceEvent{Phase: string(event.Phase), At: toTimestamp(event.At)})
+			}
+		}
+	}
+	for _, peer := range peers {
+		resp.Peers = append(resp.Peers, peer)
+	}
+	sort.Slice(resp.Peers, func(i, j int) bool {
+		if resp.Peers[i].Name != resp.Peers[j].Name {
+			return resp.Peers[i].Name < resp.Peers[j].Name
+		}
+		return resp.Peers[i].PubKey < resp.Peers[j].PubKey
+	})
+
+	return resp, nil
+}
+
+// toStreamState converts the status of a stream of the Engine to its proto representation
+func toStreamState(status internal.StreamStatus) *proto.StreamState {
+	return &proto.StreamState{Connected: status.Connected, Since: toTimestamp(status.Since), Url: status.URL}
+}
+
+// toTimestamp converts a time to a proto timestamp, nil for the zero time
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
 }
 
 // GetConfig of the daemon.
@@ -418,3 +648,86 @@ func (s *Server) GetConfig(ctx context.Context, msg *proto.GetConfigRequest) (*p
 		PreSharedKey:  preSharedKey,
 	}, nil
 }
+
+// ListRoutes installed by the running engine.
+func (s *Server) ListRoutes(ctx context.Context, msg *proto.ListRoutesRequest) (*proto.ListRoutesResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	routes, err := internal.CtxGetState(s.rootCtx).InstalledRoutes()
+	if err != nil {
+		return nil, gstatus.Errorf(codes.Internal, "%v", err)
+	}
+
+	resp := &proto.ListRoutesResponse{}
+	for _, route := range routes {
+		resp.Routes = append(resp.Routes, &proto.Route{
+			Network:    route.Network,
+			PeerPubKey: route.Peer,
+			Interface:  route.Interface,
+		})
+	}
+
+	return resp, nil
+}
+
+// SetLogLevel of the daemon at runtime. The log levels of the components are set until the next up command,
+// which sets them from the config
+func (s *Server) SetLogLevel(_ context.Context, msg *proto.SetLogLevelRequest) (*proto.SetLogLevelResponse, error) {
+	if msg.Level != "" {
+		if err := util.SetLogLevel(msg.Level); err != nil {
+			return nil, gstatus.Errorf(codes.InvalidArgument, "invalid log level %s: %v", msg.Level, err)
+		}
+		log.Infof("log level has been set to %s", msg.Level)
+	}
+
+	if msg.Components != "" {
+		if err := util.InitComponentLevels(msg.Components); err != nil {
+			return nil, gstatus.Errorf(codes.InvalidArgument, "invalid log levels of the components: %v", err)
+		}
+		log.Infof("log levels of the components have been set to %s", msg.Components)
+	}
+
+	return &proto.SetLogLevelResponse{}, nil
+}
+
+// RotateKey replaces the Wireguard key of the peer keeping its registration on the Management Service. The running
+// connections are stopped for the rotation, the old key can't be used once it has been replaced, and are restarted
+// with the new key. The old key is kept if the rotation fails
+func (s *Server) RotateKey(ctx context.Context, _ *proto.RotateKeyRequest) (*proto.RotateKeyResponse, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.config == nil {
+		return nil, gstatus.Errorf(codes.FailedPrecondition, "config is not defined, please call login command first")
+	}
+
+	running := s.actCancel != nil
+	if running {
+		s.actCancel()
+		s.actCancel = nil
+		if s.clientDone != nil {
+			select {
+			case <-s.clientDone:
+			case <-ctx.Done():
+				return nil, gstatus.Errorf(codes.DeadlineExceeded, "connections haven't been closed: %v", ctx.Err())
+			}
+		}
+	}
+
+	publicKey, err := internal.RotateKey(ctx, s.config)
+
+	if running {
+		clientCtx, cancel := context.WithCancel(s.rootCtx)
+		s.actCancel = cancel
+		internal.CtxGetState(s.rootCtx).Set(internal.StatusConnecting)
+		s.runClient(clientCtx)
+	}
+
+	if err != nil {
+		log.Errorf("failed rotating the Wireguard key: %v", err)
+		return nil, gstatus.Errorf(codes.Internal, "failed rotating the Wireguard key: %v", err)
+	}
+
+	return &proto.RotateKeyResponse{PublicKey: publicKey.String()}, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/netbirdio/netbird/client/internal"
+	"github.com/netbirdio/netbird/client/internal/update"
+	"github.com/netbirdio/netbird/client/system"
+)
+
+// updateCheckInterval is the interval of the checks for a newer client release
+var updateCheckInterval = 24 * time.Hour
+
+// updateCheckTimeout limits a single check for a newer client release
+const updateCheckTimeout = 30 * time.Second
+
+// checkUpdates checks for a newer client release until the context is done, Status reports the available update
+func (s *Server) checkUpdates(ctx context.Context) {
+	ticker := time.NewTicker(updateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		s.checkUpdate(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkUpdate compares the running version with the latest client release of the configured release URL
+func (s *Server) checkUpdate(ctx context.Context) {
+	s.mutex.Lock()
+	releaseURL := s.config.UpdateReleaseURL
+	s.mutex.Unlock()
+	if releaseURL == "" {
+		releaseURL = update.DefaultReleaseURL
+	}
+
+	release, err := update.LatestRelease(ctx, &http.Client{Timeout: updateCheckTimeout}, releaseURL)
+	if err != nil {
+		log.Warnf("failed checking for a client update: %v", err)
+		return
+	}
+
+	state := internal.CtxGetState(s.rootCtx)
+	newer, err := release.NewerThan(system.NetbirdVersion())
+	if err != nil {
+		log.Debugf("can't compare the running version %s with the latest release %s: %v",
+			system.NetbirdVersion(), release.Version, err)
+		return
+	}
+	if !newer {
+		state.SetAvailableUpdate("")
+		return
+	}
+
+	if state.AvailableUpdate() != release.Version {
+		log.Infof("client update available: %s, run netbird update to install it", release.Version)
+	}
+	state.SetAvailableUpdate(release.Version)
+}
@@ -0,0 +1,108 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// dialTimeout limits the connection to the SSH server including the handshake
+const dialTimeout = 10 * time.Second
+
+// Client is a connection to the SSH server of a remote peer
+type Client struct {
+	client *ssh.Client
+}
+
+// Dial connects to the SSH server of a remote peer on the address as the user, authenticating with the PEM encoded
+// SSH key of the local peer. The server is verified with the SSH public key of the remote peer in the authorized_keys
+// format, the host key of its server
+func Dial(addr, user string, privateKey []byte, hostKey string) (*Client, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing SSH key: %v", err)
+	}
+	hostPublicKey, err := parsePublicKey(hostKey)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.FixedHostKey(hostPublicKey),
+		Timeout:         dialTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to SSH server %s: %v", addr, err)
+	}
+	return &Client{client: client}, nil
+}
+
+// OpenTerminal runs the login shell of the user on a PTY of the size of the local terminal. The local terminal is
+// in the raw mode until the shell exits
+func (c *Client) OpenTerminal() error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed putting the terminal in the raw mode: %v", err)
+	}
+	defer func() {
+		_ = term.Restore(fd, state)
+	}()
+
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = "xterm-256color"
+	}
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+	err = session.RequestPty(termType, height, width, modes)
+	if err != nil {
+		return fmt.Errorf("failed requesting PTY: %v", err)
+	}
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	stop := watchWindowSize(fd, session)
+	defer stop()
+
+	err = session.Shell()
+	if err != nil {
+		return err
+	}
+	return session.Wait()
+}
+
+// Exec runs the command line with the login shell of the user forwarding its output to the standard output and error.
+// An *ssh.ExitError is returned if the command has exited with a non-zero status
+func (c *Client) Exec(commandLine string) error {
+	session, err := c.client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	return session.Run(commandLine)
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.client.Close()
+}
@@ -0,0 +1,118 @@
+//go:build !windows
+// +build !windows
+
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// sessionsSupported indicates that the sessions can run the commands as the users of the OS
+	sessionsSupported = true
+	// defaultShell is the shell of the users without a login shell in /etc/passwd (e.g. the directory users of macOS)
+	defaultShell = "/bin/sh"
+	// defaultPath is the PATH of the sessions
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// userCommand returns the command running the command line with the login shell of the user or the login shell itself
+// if the command line is empty. The command runs as the user, which requires the root privileges unless the server
+// runs as the user already. A user with uid 0 is refused unless rootAllowed
+func userCommand(userName, commandLine string, rootAllowed bool) (*exec.Cmd, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	if u.Uid == "0" && !rootAllowed {
+		return nil, fmt.Errorf("sessions of user %s with uid 0 aren't allowed", userName)
+	}
+	current, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	shell := loginShell(u.Username)
+	var cmd *exec.Cmd
+	if commandLine == "" {
+		cmd = exec.Command(shell)
+		// the leading dash makes the shell a login shell
+		cmd.Args[0] = "-" + filepath.Base(shell)
+	} else {
+		cmd = exec.Command(shell, "-c", commandLine)
+	}
+	cmd.Dir = u.HomeDir
+	cmd.Env = []string{
+		"HOME=" + u.HomeDir,
+		"USER=" + u.Username,
+		"LOGNAME=" + u.Username,
+		"SHELL=" + shell,
+		"PATH=" + defaultPath,
+	}
+
+	if u.Uid == current.Uid {
+		return cmd, nil
+	}
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("can't run a session as user %s, the SSH server doesn't run as root", userName)
+	}
+
+	credential, err := userCredential(u)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	return cmd, nil
+}
+
+// userCredential returns the user and group IDs of the user including its supplementary groups
+func userCredential(u *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %s of user %s", u.Uid, u.Username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %s of user %s", u.Gid, u.Username)
+	}
+
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed looking up groups of user %s: %v", u.Username, err)
+	}
+	for _, groupID := range groupIDs {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err == nil {
+			credential.Groups = append(credential.Groups, uint32(group))
+		}
+	}
+	return credential, nil
+}
+
+// loginShell returns the login shell of the user from /etc/passwd, defaultShell if there is none
+func loginShell(userName string) string {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return defaultShell
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:uid:gid:gecos:home:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == userName && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return defaultShell
+}
@@ -0,0 +1,11 @@
+package ssh
+
+import "os/exec"
+
+// sessionsSupported indicates that the sessions can run the commands as the users of the OS
+const sessionsSupported = false
+
+// userCommand isn't supported on Windows, the server isn't created
+func userCommand(string, string, bool) (*exec.Cmd, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,6 @@
+package ssh
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the SSH server of the client, its level is set by the ssh component level
+var log = util.Logger("ssh")
@@ -0,0 +1,205 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrNotSupported is returned by NewServer on the OS that can't run the sessions of the users
+var ErrNotSupported = errors.New("SSH server is not supported on this OS")
+
+// publicKeyExtension is the permission extension holding the SSH key a connection has been authenticated with
+const publicKeyExtension = "public-key"
+
+// Server is an SSH server of the peer listening on the address of the Wireguard interface. A remote peer is
+// authenticated by its tunnel IP: a connection is accepted only from the IP of an allowed remote peer presenting
+// the SSH key the remote peer has reported to the Management Service. The sessions run as the local user the login
+// is mapped to. Shell and exec sessions with an optional PTY are supported, SFTP and port forwarding aren't
+type Server struct {
+	mux    sync.Mutex
+	config *ssh.ServerConfig
+	// users maps the logins the remote peers can use to the local users the sessions run as
+	users map[string]string
+	// rootAllowed allows the sessions to run as a user with uid 0
+	rootAllowed bool
+	// allowedKeys are the SSH public keys of the allowed remote peers by their tunnel IP
+	allowedKeys map[string]ssh.PublicKey
+	listener    net.Listener
+	// conns are the open connections, closed when the server stops or the remote peer is no longer allowed
+	conns map[*ssh.ServerConn]struct{}
+}
+
+// NewServer creates an SSH server with the PEM encoded host key. The users map the logins to the local users the
+// sessions run as, the other logins are refused. The sessions of a user with uid 0 are refused unless rootAllowed.
+// It doesn't listen until started
+func NewServer(hostKey []byte, users map[string]string, rootAllowed bool) (*Server, error) {
+	if !sessionsSupported {
+		return nil, ErrNotSupported
+	}
+
+	signer, err := ssh.ParsePrivateKey(hostKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing SSH host key: %v", err)
+	}
+
+	s := &Server{
+		users:       make(map[string]string, len(users)),
+		rootAllowed: rootAllowed,
+		allowedKeys: map[string]ssh.PublicKey{},
+		conns:       map[*ssh.ServerConn]struct{}{},
+	}
+	for login, localUser := range users {
+		s.users[login] = localUser
+	}
+	s.config = &ssh.ServerConfig{PublicKeyCallback: s.authenticate}
+	s.config.AddHostKey(signer)
+	return s, nil
+}
+
+// UpdateAllowedPeers replaces the remote peers allowed to connect with their SSH public keys in the authorized_keys
+// format by their tunnel IP. The open connections of the remote peers no longer allowed are closed
+func (s *Server) UpdateAllowedPeers(keys map[string]string) {
+	allowed := make(map[string]ssh.PublicKey, len(keys))
+	for ip, key := range keys {
+		publicKey, err := parsePublicKey(key)
+		if err != nil {
+			log.Warnf("not allowing SSH connections from %s: %v", ip, err)
+			continue
+		}
+		allowed[ip] = publicKey
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.allowedKeys = allowed
+	for conn := range s.conns {
+		if !s.isAllowed(conn.RemoteAddr(), []byte(conn.Permissions.Extensions[publicKeyExtension])) {
+			log.Infof("closing SSH connection from %s, the peer is no longer allowed", conn.RemoteAddr())
+			_ = conn.Close()
+		}
+	}
+}
+
+// authenticate accepts the SSH key of the allowed remote peer connecting from the tunnel IP with a mapped login
+func (s *Server) authenticate(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if !s.isAllowed(meta.RemoteAddr(), key.Marshal()) {
+		return nil, fmt.Errorf("peer %s is not allowed to connect with the key", meta.RemoteAddr())
+	}
+	if _, ok := s.users[meta.User()]; !ok {
+		return nil, fmt.Errorf("login %s of peer %s isn't mapped to a local user", meta.User(), meta.RemoteAddr())
+	}
+	return &ssh.Permissions{Extensions: map[string]string{publicKeyExtension: string(key.Marshal())}}, nil
+}
+
+// isAllowed returns true if the key is the SSH key of the allowed remote peer with the IP of the address.
+// The caller holds the lock
+func (s *Server) isAllowed(addr net.Addr, key []byte) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	allowed, ok := s.allowedKeys[tcpAddr.IP.String()]
+	return ok && bytes.Equal(allowed.Marshal(), key)
+}
+
+// Start listens on the IP (the address of the Wireguard interface) and the port
+func (s *Server) Start(ip net.IP, port int) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.listener != nil {
+		return fmt.Errorf("SSH server is already listening on %s", s.listener.Addr())
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed starting SSH server: %v", err)
+	}
+	s.listener = listener
+	go s.serve(listener)
+
+	log.Infof("SSH server listening on %s", listener.Addr())
+	return nil
+}
+
+// Stop stops listening and closes the open connections
+func (s *Server) Stop() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.listener = nil
+	return err
+}
+
+// serve accepts the connections until the listener is closed
+func (s *Server) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Errorf("SSH server stopped accepting connections: %v", err)
+			}
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// handleConn authenticates the connection and serves its session channels
+func (s *Server) handleConn(conn net.Conn) {
+	sshConn, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		log.Debugf("refused SSH connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer sshConn.Close()
+
+	s.mux.Lock()
+	// the allowed peers may have been updated during the handshake
+	if !s.isAllowed(sshConn.RemoteAddr(), []byte(sshConn.Permissions.Extensions[publicKeyExtension])) {
+		s.mux.Unlock()
+		return
+	}
+	s.conns[sshConn] = struct{}{}
+	s.mux.Unlock()
+	defer func() {
+		s.mux.Lock()
+		delete(s.conns, sshConn)
+		s.mux.Unlock()
+	}()
+
+	localUser := s.users[sshConn.User()]
+	log.Infof("accepted SSH connection from %s with login %s as user %s", sshConn.RemoteAddr(), sshConn.User(), localUser)
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			log.Warnf("failed accepting SSH session from %s: %v", sshConn.RemoteAddr(), err)
+			continue
+		}
+		sess := &session{channel: channel, user: localUser, rootAllowed: s.rootAllowed}
+		go sess.serve(channelRequests)
+	}
+}
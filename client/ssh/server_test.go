@@ -0,0 +1,151 @@
+package ssh
+
+import (
+	"bytes"
+	"net"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateKeys(t *testing.T) ([]byte, string) {
+	t.Helper()
+	privateKey, err := GeneratePrivateKey()
+	require.NoError(t, err)
+	publicKey, err := PublicKey(privateKey)
+	require.NoError(t, err)
+	return privateKey, publicKey
+}
+
+func startServer(t *testing.T, hostKey []byte, allowedKeys map[string]string, users map[string]string, rootAllowed bool) string {
+	t.Helper()
+	server, err := NewServer(hostKey, users, rootAllowed)
+	require.NoError(t, err)
+	server.UpdateAllowedPeers(allowedKeys)
+	require.NoError(t, server.Start(net.ParseIP("127.0.0.1"), 0))
+	t.Cleanup(func() {
+		_ = server.Stop()
+	})
+	return server.listener.Addr().String()
+}
+
+func TestServer_Exec(t *testing.T) {
+	hostKey, hostPublicKey := generateKeys(t)
+	clientKey, clientPublicKey := generateKeys(t)
+	current, err := user.Current()
+	require.NoError(t, err)
+	addr := startServer(t, hostKey, map[string]string{"127.0.0.1": clientPublicKey},
+		map[string]string{current.Username: current.Username}, true)
+
+	client, err := Dial(addr, current.Username, clientKey, hostPublicKey)
+	require.NoError(t, err)
+	defer client.Close()
+
+	session, err := client.client.NewSession()
+	require.NoError(t, err)
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	require.NoError(t, session.Run("echo hello"))
+	assert.Equal(t, "hello\n", stdout.String())
+
+	session, err = client.client.NewSession()
+	require.NoError(t, err)
+	err = session.Run("exit 3")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitStatus())
+}
+
+func TestServer_Authentication(t *testing.T) {
+	hostKey, hostPublicKey := generateKeys(t)
+	clientKey, clientPublicKey := generateKeys(t)
+	otherKey, _ := generateKeys(t)
+
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		allowedKeys map[string]string
+		clientKey   []byte
+		hostKey     string
+	}{
+		{
+			name:        "other key of allowed peer",
+			allowedKeys: map[string]string{"127.0.0.1": clientPublicKey},
+			clientKey:   otherKey,
+			hostKey:     hostPublicKey,
+		},
+		{
+			name:        "key of peer with other IP",
+			allowedKeys: map[string]string{"100.64.0.2": clientPublicKey},
+			clientKey:   clientKey,
+			hostKey:     hostPublicKey,
+		},
+		{
+			name:        "unknown host key",
+			allowedKeys: map[string]string{"127.0.0.1": clientPublicKey},
+			clientKey:   clientKey,
+			hostKey:     clientPublicKey,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			addr := startServer(t, hostKey, testCase.allowedKeys, map[string]string{current.Username: current.Username}, true)
+			client, err := Dial(addr, current.Username, testCase.clientKey, testCase.hostKey)
+			if err == nil {
+				client.Close()
+			}
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestServer_Users(t *testing.T) {
+	hostKey, hostPublicKey := generateKeys(t)
+	clientKey, clientPublicKey := generateKeys(t)
+	allowedKeys := map[string]string{"127.0.0.1": clientPublicKey}
+
+	current, err := user.Current()
+	require.NoError(t, err)
+	root, err := user.LookupId("0")
+	require.NoError(t, err)
+
+	t.Run("mapped login", func(t *testing.T) {
+		addr := startServer(t, hostKey, allowedKeys, map[string]string{"admin": current.Username}, true)
+		client, err := Dial(addr, "admin", clientKey, hostPublicKey)
+		require.NoError(t, err)
+		defer client.Close()
+
+		session, err := client.client.NewSession()
+		require.NoError(t, err)
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		require.NoError(t, session.Run("echo $USER"))
+		assert.Equal(t, current.Username+"\n", stdout.String())
+	})
+
+	t.Run("unmapped login", func(t *testing.T) {
+		addr := startServer(t, hostKey, allowedKeys, map[string]string{"admin": current.Username}, true)
+		client, err := Dial(addr, current.Username, clientKey, hostPublicKey)
+		if err == nil {
+			client.Close()
+		}
+		assert.Error(t, err)
+	})
+
+	t.Run("root not allowed", func(t *testing.T) {
+		addr := startServer(t, hostKey, allowedKeys, map[string]string{"admin": root.Username}, false)
+		client, err := Dial(addr, "admin", clientKey, hostPublicKey)
+		require.NoError(t, err)
+		defer client.Close()
+
+		session, err := client.client.NewSession()
+		require.NoError(t, err)
+		assert.Error(t, session.Run("id"), "expecting the session of a user with uid 0 to be refused")
+	})
+}
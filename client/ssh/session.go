@@ -0,0 +1,229 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+)
+
+// ptyOutputGrace is how long the output of the PTY is still forwarded after the process has exited,
+// e.g. while a background process holds the terminal open
+const ptyOutputGrace = time.Second
+
+// ptyRequest is the payload of the pty-req request (RFC 4254 section 6.2)
+type ptyRequest struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+// windowChange is the payload of the window-change request (RFC 4254 section 6.7)
+type windowChange struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// session is a session channel of an SSH connection running a single shell or command as the local user the login
+// of the connection is mapped to
+type session struct {
+	channel ssh.Channel
+	user    string
+	// rootAllowed allows the session to run as a user with uid 0
+	rootAllowed bool
+
+	mux sync.Mutex
+	// env are the environment variables set by the client
+	env []string
+	// pty is the requested pseudo terminal, nil if the session runs without one
+	pty *ptyRequest
+	// ptmx is the PTY of the running process, nil until it has started
+	ptmx    *os.File
+	started bool
+}
+
+// serve handles the requests of the session until the channel is closed
+func (sess *session) serve(requests <-chan *ssh.Request) {
+	for req := range requests {
+		switch req.Type {
+		case "shell", "exec":
+			cmd, err := sess.command(req)
+			if err != nil {
+				log.Warnf("refused SSH %s request of user %s: %v", req.Type, sess.user, err)
+				_ = req.Reply(false, nil)
+				continue
+			}
+			// the reply precedes the output and the exit status of the command
+			_ = req.Reply(true, nil)
+			go sess.run(cmd)
+		default:
+			ok := sess.handleRequest(req)
+			if req.WantReply {
+				_ = req.Reply(ok, nil)
+			}
+		}
+	}
+}
+
+// handleRequest handles a request configuring the session, returns false if it has been refused
+func (sess *session) handleRequest(req *ssh.Request) bool {
+	sess.mux.Lock()
+	defer sess.mux.Unlock()
+
+	switch req.Type {
+	case "pty-req":
+		request := &ptyRequest{}
+		if sess.started || ssh.Unmarshal(req.Payload, request) != nil {
+			return false
+		}
+		sess.pty = request
+		return true
+	case "window-change":
+		change := windowChange{}
+		if sess.pty == nil || ssh.Unmarshal(req.Payload, &change) != nil {
+			return false
+		}
+		sess.pty.Columns, sess.pty.Rows = change.Columns, change.Rows
+		if sess.ptmx != nil {
+			_ = pty.Setsize(sess.ptmx, &pty.Winsize{Rows: uint16(change.Rows), Cols: uint16(change.Columns)})
+		}
+		return true
+	case "env":
+		variable := struct{ Name, Value string }{}
+		if sess.started || ssh.Unmarshal(req.Payload, &variable) != nil || !acceptedEnv(variable.Name) {
+			return false
+		}
+		sess.env = append(sess.env, variable.Name+"="+variable.Value)
+		return true
+	default:
+		return false
+	}
+}
+
+// acceptedEnv returns true for the environment variables the client can set, the locale ones only like OpenSSH
+func acceptedEnv(name string) bool {
+	return name == "LANG" || strings.HasPrefix(name, "LC_")
+}
+
+// command returns the command of a shell or exec request, the login shell of the user for the shell one
+func (sess *session) command(req *ssh.Request) (*exec.Cmd, error) {
+	sess.mux.Lock()
+	defer sess.mux.Unlock()
+
+	if sess.started {
+		return nil, errors.New("session is already running")
+	}
+
+	commandLine := ""
+	if req.Type == "exec" {
+		payload := struct{ Command string }{}
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			return nil, fmt.Errorf("invalid exec request: %v", err)
+		}
+		commandLine = payload.Command
+	}
+
+	cmd, err := userCommand(sess.user, commandLine, sess.rootAllowed)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, sess.env...)
+	if sess.pty != nil {
+		cmd.Env = append(cmd.Env, "TERM="+sess.pty.Term)
+	}
+	sess.started = true
+	return cmd, nil
+}
+
+// run runs the command of the session, sends its exit status and closes the channel
+func (sess *session) run(cmd *exec.Cmd) {
+	defer sess.channel.Close()
+
+	sess.mux.Lock()
+	withPTY := sess.pty != nil
+	sess.mux.Unlock()
+
+	var err error
+	if withPTY {
+		err = sess.runPTY(cmd)
+	} else {
+		err = sess.runPipes(cmd)
+	}
+
+	status := uint32(0)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		status = uint32(exitErr.ExitCode())
+	default:
+		// the process hasn't started or has been killed by a signal
+		log.Debugf("SSH session of user %s has failed: %v", sess.user, err)
+		status = 255
+	}
+	_, _ = sess.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+}
+
+// runPTY runs the command on a new PTY of the requested size
+func (sess *session) runPTY(cmd *exec.Cmd) error {
+	sess.mux.Lock()
+	size := &pty.Winsize{Rows: uint16(sess.pty.Rows), Cols: uint16(sess.pty.Columns)}
+	ptmx, err := pty.StartWithSize(cmd, size)
+	sess.ptmx = ptmx
+	sess.mux.Unlock()
+	if err != nil {
+		_, _ = fmt.Fprintf(sess.channel.Stderr(), "failed starting session: %v\r\n", err)
+		return err
+	}
+	defer ptmx.Close()
+
+	go func() {
+		_, _ = io.Copy(ptmx, sess.channel)
+	}()
+	outputDone := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(sess.channel, ptmx)
+		close(outputDone)
+	}()
+
+	err = cmd.Wait()
+	select {
+	case <-outputDone:
+	case <-time.After(ptyOutputGrace):
+	}
+	return err
+}
+
+// runPipes runs the command with its standard streams forwarded to the channel
+func (sess *session) runPipes(cmd *exec.Cmd) error {
+	cmd.Stdout = sess.channel
+	cmd.Stderr = sess.channel.Stderr()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		_, _ = fmt.Fprintf(sess.channel.Stderr(), "failed starting session: %v\n", err)
+		return err
+	}
+
+	go func() {
+		_, _ = io.Copy(stdin, sess.channel)
+		_ = stdin.Close()
+	}()
+	return cmd.Wait()
+}
@@ -0,0 +1,47 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSSHPort is the port the SSH server of a peer listens on the address of the Wireguard interface
+const DefaultSSHPort = 44338
+
+// GeneratePrivateKey generates a new ed25519 SSH key encoded in PEM. The key is both the host key of the SSH server
+// of the peer and the key the peer authenticates to the SSH servers of the remote peers with
+func GeneratePrivateKey() ([]byte, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// PublicKey returns the public key of a PEM encoded SSH key in the authorized_keys format without the trailing newline
+func PublicKey(privateKey []byte) (string, error) {
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing SSH key: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), nil
+}
+
+// parsePublicKey parses a public key in the authorized_keys format
+func parsePublicKey(key string) (ssh.PublicKey, error) {
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing SSH public key %s: %v", key, err)
+	}
+	return publicKey, nil
+}
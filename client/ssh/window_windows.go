@@ -0,0 +1,8 @@
+package ssh
+
+import "golang.org/x/crypto/ssh"
+
+// watchWindowSize doesn't forward the size changes on Windows, there is no signal of them
+func watchWindowSize(int, *ssh.Session) (stop func()) {
+	return func() {}
+}
@@ -23,6 +23,17 @@ type Info struct {
 	CPUs               int
 	WiretrusteeVersion string
 	UIVersion          string
+	// Labels are user defined labels of the peer reported to the Management service
+	Labels map[string]string
+	// WgPort is the listen port of the Wireguard interface reported to the Management service, 0 if it isn't configured yet
+	WgPort int
+	// SSHPubKey is the SSH public key of the peer in the authorized_keys format reported to the Management service,
+	// empty if it isn't known yet
+	SSHPubKey string
+	// DiskEncrypted indicates that the system disk is encrypted (device posture)
+	DiskEncrypted bool
+	// FirewallEnabled indicates that a host firewall is enabled (device posture)
+	FirewallEnabled bool
 }
 
 // NetbirdVersion returns the Netbird version
@@ -25,6 +25,8 @@ func GetInfo(ctx context.Context) *Info {
 	gio.Hostname, _ = os.Hostname()
 	gio.WiretrusteeVersion = NetbirdVersion()
 	gio.UIVersion = extractUserAgent(ctx)
+	gio.DiskEncrypted = diskEncrypted()
+	gio.FirewallEnabled = firewallEnabled()
 
 	return gio
 }
@@ -0,0 +1,24 @@
+package system
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// diskEncrypted checks whether FileVault is turned on
+func diskEncrypted() bool {
+	out, err := exec.Command("fdesetup", "status").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "FileVault is On")
+}
+
+// firewallEnabled checks whether the application firewall is enabled
+func firewallEnabled() bool {
+	out, err := exec.Command("/usr/libexec/ApplicationFirewall/socketfilterfw", "--getglobalstate").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "enabled")
+}
@@ -0,0 +1,29 @@
+package system
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// diskEncrypted checks whether any GELI provider is attached
+func diskEncrypted() bool {
+	out, err := exec.Command("geli", "status").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "ACTIVE")
+}
+
+// firewallEnabled checks whether pf or ipfw is enabled
+func firewallEnabled() bool {
+	out, err := exec.Command("pfctl", "-s", "info").Output()
+	if err == nil && strings.Contains(string(out), "Status: Enabled") {
+		return true
+	}
+
+	out, err = exec.Command("sysctl", "-n", "net.inet.ip.fw.enable").Output()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(string(out)) == "1"
+}
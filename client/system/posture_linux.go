@@ -0,0 +1,85 @@
+package system
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// diskEncrypted checks whether the root filesystem is stored on a dm-crypt (LUKS) volume,
+// directly or through other device mapper volumes (e.g. LVM on LUKS)
+func diskEncrypted() bool {
+	device := rootDevice()
+	if device == "" {
+		return false
+	}
+
+	resolved, err := filepath.EvalSymlinks(device)
+	if err != nil {
+		return false
+	}
+
+	return isCryptDevice(filepath.Base(resolved), 0)
+}
+
+// rootDevice returns the device the root filesystem is mounted from, empty if unknown
+func rootDevice() string {
+	file, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	var device string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// the last mount over / is the visible one
+		if len(fields) > 1 && fields[1] == "/" {
+			device = fields[0]
+		}
+	}
+	return device
+}
+
+// isCryptDevice checks whether a block device (e.g. dm-0) is a dm-crypt volume or is backed by one
+func isCryptDevice(name string, depth int) bool {
+	// device mapper volumes are rarely nested deeper
+	if depth > 8 {
+		return false
+	}
+
+	uuid, err := os.ReadFile(filepath.Join("/sys/block", name, "dm", "uuid"))
+	if err == nil && strings.HasPrefix(string(uuid), "CRYPT-") {
+		return true
+	}
+
+	slaves, err := os.ReadDir(filepath.Join("/sys/block", name, "slaves"))
+	if err != nil {
+		return false
+	}
+	for _, slave := range slaves {
+		if isCryptDevice(slave.Name(), depth+1) {
+			return true
+		}
+	}
+	return false
+}
+
+// firewallEnabled checks whether nftables or iptables filter the incoming traffic
+func firewallEnabled() bool {
+	out, err := exec.Command("nft", "list", "ruleset").Output()
+	if err == nil && strings.Contains(string(out), "hook input") &&
+		(strings.Contains(string(out), "drop") || strings.Contains(string(out), "reject")) {
+		return true
+	}
+
+	out, err = exec.Command("iptables", "-S", "INPUT").Output()
+	if err != nil {
+		return false
+	}
+	rules := string(out)
+	return strings.Contains(rules, "-P INPUT DROP") || strings.Contains(rules, "-j DROP") || strings.Contains(rules, "-j REJECT")
+}
@@ -0,0 +1,41 @@
+package system
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// diskEncrypted checks whether BitLocker protects the system drive
+func diskEncrypted() bool {
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+	out, err := exec.Command("manage-bde", "-status", drive).Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "Protection On")
+}
+
+// firewallEnabled checks whether Windows Defender Firewall is turned on for every profile
+func firewallEnabled() bool {
+	out, err := exec.Command("netsh", "advfirewall", "show", "allprofiles", "state").Output()
+	if err != nil {
+		return false
+	}
+
+	states := 0
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "State" {
+			continue
+		}
+		if fields[1] != "ON" {
+			return false
+		}
+		states++
+	}
+	return states > 0
+}
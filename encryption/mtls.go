@@ -0,0 +1,323 @@
+package encryption
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// reloadDelay coalesces the burst of the file events of a single update (e.g. the certificate and the key written
+// one after another) into a single reload
+const reloadDelay = 500 * time.Millisecond
+
+// CertificateReloader holds a TLS certificate read from a PEM encoded certificate and key or from a PKCS#12 bundle.
+// Once Watch is running the files are read again whenever they change or the process receives SIGHUP, so a rotated
+// certificate is presented by the next handshakes without a restart
+type CertificateReloader struct {
+	files []string
+	load  func() (*tls.Certificate, error)
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// NewKeyPairReloader reads the certificate and the private key from the PEM encoded files
+func NewKeyPairReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	return newCertificateReloader([]string{certFile, keyFile}, func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	})
+}
+
+// NewPKCS12Reloader reads the certificate and the private key from the PKCS#12 bundle encrypted with the password.
+// Only the legacy 3DES and RC2 encryption of the bundles is supported, e.g. use openssl pkcs12 -export -legacy
+func NewPKCS12Reloader(file, password string) (*CertificateReloader, error) {
+	return newCertificateReloader([]string{file}, func() (*tls.Certificate, error) {
+		return loadPKCS12(file, password)
+	})
+}
+
+func newCertificateReloader(files []string, load func() (*tls.Certificate, error)) (*CertificateReloader, error) {
+	r := &CertificateReloader{files: files, load: load}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// loadPKCS12 reads the certificate chain and the private key of a PKCS#12 bundle, the leaf is the certificate
+// matching the key regardless of its position in the bundle
+func loadPKCS12(file, password string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding PKCS#12 bundle %s: %w", file, err)
+	}
+
+	var keyPEM []byte
+	var certs []*pem.Block
+	for _, block := range blocks {
+		// the headers hold the bag attributes (e.g. friendlyName) that aren't valid in the PEM read by tls.X509KeyPair
+		block.Headers = nil
+		switch block.Type {
+		case "CERTIFICATE":
+			certs = append(certs, block)
+		case "PRIVATE KEY":
+			keyPEM = pem.EncodeToMemory(block)
+		}
+	}
+	if keyPEM == nil || len(certs) == 0 {
+		return nil, fmt.Errorf("PKCS#12 bundle %s doesn't hold a certificate and its private key", file)
+	}
+
+	for i := range certs {
+		certPEM := pem.EncodeToMemory(certs[i])
+		for j, cert := range certs {
+			if j != i {
+				certPEM = append(certPEM, pem.EncodeToMemory(cert)...)
+			}
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err == nil {
+			return &cert, nil
+		}
+	}
+	return nil, fmt.Errorf("none of the certificates of PKCS#12 bundle %s matches its private key", file)
+}
+
+// Reload reads the certificate again, the certificate read last is kept if it fails
+func (r *CertificateReloader) Reload() error {
+	cert, err := r.load()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the certificate read last
+func (r *CertificateReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// GetClientCertificate presents the certificate read last to the servers requiring mutual TLS, see tls.Config
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.Certificate(), nil
+}
+
+// Watch reloads the certificate whenever its files change or the process receives SIGHUP until the context is done
+func (r *CertificateReloader) Watch(ctx context.Context) error {
+	return watchFiles(ctx, r.files, func() {
+		err := r.Reload()
+		if err != nil {
+			log.Errorf("failed reloading client certificate, keeping the previous one: %v", err)
+			return
+		}
+		log.Infof("reloaded client certificate %s", r.files[0])
+	})
+}
+
+// ClientCAReloader verifies the client certificates presented to a server against a PEM encoded bundle of the CAs
+// issuing them. Once Watch is running the bundle is read again whenever it changes or the process receives SIGHUP
+type ClientCAReloader struct {
+	file string
+
+	mu   sync.RWMutex
+	pool *x509.CertPool
+}
+
+// NewClientCAReloader reads the CA bundle from the PEM encoded file
+func NewClientCAReloader(file string) (*ClientCAReloader, error) {
+	r := &ClientCAReloader{file: file}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the CA bundle again, the bundle read last is kept if it fails
+func (r *ClientCAReloader) Reload() error {
+	data, err := os.ReadFile(r.file)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return fmt.Errorf("CA bundle %s doesn't hold any PEM encoded certificate", r.file)
+	}
+
+	r.mu.Lock()
+	r.pool = pool
+	r.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the CA bundle whenever it changes or the process receives SIGHUP until the context is done
+func (r *ClientCAReloader) Watch(ctx context.Context) error {
+	return watchFiles(ctx, []string{r.file}, func() {
+		err := r.Reload()
+		if err != nil {
+			log.Errorf("failed reloading client CA bundle, keeping the previous one: %v", err)
+			return
+		}
+		log.Infof("reloaded client CA bundle %s", r.file)
+	})
+}
+
+// RequireClientCertificates returns a copy of the TLS config of a server rejecting the clients that don't present
+// a certificate issued by a CA of the bundle
+func (r *ClientCAReloader) RequireClientCertificates(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	// the chain is verified by VerifyPeerCertificate against the bundle read last rather than by ClientCAs
+	// fixed at the start of the server
+	config.ClientAuth = tls.RequireAnyClientCert
+	config.VerifyPeerCertificate = r.verifyPeerCertificate
+	return config
+}
+
+// RequireClientCertificatesWithACME is RequireClientCertificates for a server getting its certificate from Let's
+// Encrypt. The TLS-ALPN challenges don't present a client certificate, so the handshakes offering only the
+// acme-tls/1 protocol are served by a config negotiating that protocol only and presenting the challenge
+// certificates of the cert manager only, a client can't reach the server over it
+func (r *ClientCAReloader) RequireClientCertificatesWithACME(config *tls.Config, certManager *autocert.Manager) *tls.Config {
+	acmeConfig := &tls.Config{
+		NextProtos: []string{acme.ALPNProto},
+		MinVersion: tls.VersionTLS12,
+		// the cert manager answers the hellos offering only acme-tls/1 with a pending challenge certificate or fails
+		GetCertificate: certManager.GetCertificate,
+	}
+
+	config = r.RequireClientCertificates(config)
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+			return acmeConfig, nil
+		}
+		return nil, nil
+	}
+	return config
+}
+
+// verifyPeerCertificate verifies the chain presented by a client, see tls.Config
+func (r *ClientCAReloader) verifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no client certificate presented")
+	}
+
+	intermediates := x509.NewCertPool()
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed parsing client certificate: %w", err)
+		}
+		if i == 0 {
+			leaf = cert
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	r.mu.RLock()
+	roots := r.pool
+	r.mu.RUnlock()
+
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("client certificate %s: %w", leaf.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// watchFiles calls reload whenever the files change or the process receives SIGHUP until the context is done.
+// The directories of the files are watched rather than the files, so the files replaced by a rename (e.g. by an editor
+// or the symlink swap of a mounted Kubernetes secret) keep being noticed
+func watchFiles(ctx context.Context, files []string, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[string]bool)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if watched[dir] {
+			continue
+		}
+		err = watcher.Add(dir)
+		if err != nil {
+			_ = watcher.Close()
+			return fmt.Errorf("failed watching %s: %w", dir, err)
+		}
+		watched[dir] = true
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		defer watcher.Close() //nolint
+
+		delay := time.NewTimer(reloadDelay)
+		delay.Stop()
+		defer delay.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				reload()
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				delay.Reset(reloadDelay)
+			case <-delay.C:
+				reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("failed watching certificate files: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
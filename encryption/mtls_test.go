@@ -0,0 +1,237 @@
+package encryption_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/netbirdio/netbird/encryption"
+	"github.com/netbirdio/netbird/testutil"
+)
+
+var _ = Describe("Mutual TLS", func() {
+
+	var (
+		dir string
+		ca  *testutil.TestCA
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "mtls")
+		Expect(err).NotTo(HaveOccurred())
+		ca, err = testutil.NewTestCA(dir)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	// copyFile replaces the dst with the contents of the src the way a certificate is rotated in place
+	copyFile := func(src, dst string) {
+		data, err := os.ReadFile(src)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(dst, data, 0600)).To(Succeed())
+	}
+
+	commonName := func(cert *tls.Certificate) string {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		return leaf.Subject.CommonName
+	}
+
+	Context("reading a client certificate", func() {
+		Specify("should reload the rotated certificate once its files change", func() {
+			issuedCert, issuedKey, err := ca.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+			certFile := filepath.Join(dir, "client.pem")
+			keyFile := filepath.Join(dir, "client.key")
+			copyFile(issuedCert, certFile)
+			copyFile(issuedKey, keyFile)
+
+			reloader, err := encryption.NewKeyPairReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+			cert, err := reloader.GetClientCertificate(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonName(cert)).To(Equal("peer-a"))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			Expect(reloader.Watch(ctx)).To(Succeed())
+
+			rotatedCert, rotatedKey, err := ca.IssueClientCert("peer-b")
+			Expect(err).NotTo(HaveOccurred())
+			copyFile(rotatedCert, certFile)
+			copyFile(rotatedKey, keyFile)
+
+			Eventually(func() string {
+				return commonName(reloader.Certificate())
+			}, 5*time.Second, 100*time.Millisecond).Should(Equal("peer-b"))
+		})
+
+		Specify("should keep the previous certificate if the rotated one is invalid", func() {
+			certFile, keyFile, err := ca.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+			reloader, err := encryption.NewKeyPairReloader(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.WriteFile(certFile, []byte("garbage"), 0600)).To(Succeed())
+			Expect(reloader.Reload()).NotTo(Succeed())
+			Expect(commonName(reloader.Certificate())).To(Equal("peer-a"))
+		})
+
+		Specify("should read a PKCS#12 bundle", func() {
+			openssl, err := exec.LookPath("openssl")
+			if err != nil {
+				Skip("openssl isn't installed")
+			}
+			certFile, keyFile, err := ca.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+
+			// the bundle holds the CA certificate first, the leaf is picked by its private key
+			bundle := filepath.Join(dir, "client.p12")
+			out, err := exec.Command(openssl, "pkcs12", "-export", "-legacy", "-passout", "pass:secret",
+				"-in", certFile, "-inkey", keyFile, "-certfile", ca.CertFile, "-out", bundle).CombinedOutput()
+			if err != nil {
+				Skip("openssl can't export a legacy PKCS#12 bundle: " + string(out))
+			}
+
+			reloader, err := encryption.NewPKCS12Reloader(bundle, "secret")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(commonName(reloader.Certificate())).To(Equal("peer-a"))
+
+			_, err = encryption.NewPKCS12Reloader(bundle, "wrong")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("verifying client certificates", func() {
+		// verify runs the chain verification of a server requiring the client certificates of the bundle
+		verify := func(reloader *encryption.ClientCAReloader, certFile, keyFile string) error {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+			config := reloader.RequireClientCertificates(&tls.Config{})
+			Expect(config.ClientAuth).To(Equal(tls.RequireAnyClientCert))
+			return config.VerifyPeerCertificate(cert.Certificate, nil)
+		}
+
+		Specify("should accept the certificates of the bundle only", func() {
+			reloader, err := encryption.NewClientCAReloader(ca.CertFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			certFile, keyFile, err := ca.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verify(reloader, certFile, keyFile)).To(Succeed())
+
+			serverCert, serverKey, err := ca.IssueServerCert()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verify(reloader, serverCert, serverKey)).NotTo(Succeed(), "expecting a server certificate to be rejected")
+
+			otherCA, err := testutil.NewTestCA(dir)
+			Expect(err).NotTo(HaveOccurred())
+			otherCert, otherKey, err := otherCA.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verify(reloader, otherCert, otherKey)).NotTo(Succeed())
+		})
+
+		Specify("should accept the certificates of the reloaded bundle", func() {
+			bundle := filepath.Join(dir, "client-ca.pem")
+			copyFile(ca.CertFile, bundle)
+			reloader, err := encryption.NewClientCAReloader(bundle)
+			Expect(err).NotTo(HaveOccurred())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			Expect(reloader.Watch(ctx)).To(Succeed())
+
+			newCA, err := testutil.NewTestCA(dir)
+			Expect(err).NotTo(HaveOccurred())
+			certFile, keyFile, err := newCA.IssueClientCert("peer-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verify(reloader, certFile, keyFile)).NotTo(Succeed())
+
+			copyFile(newCA.CertFile, bundle)
+			Eventually(func() error {
+				return verify(reloader, certFile, keyFile)
+			}, 5*time.Second, 100*time.Millisecond).Should(Succeed())
+		})
+	})
+
+	Context("answering the TLS-ALPN challenges", func() {
+		// handshake dials a server with the config built from the one presenting a server certificate offering only
+		// the acme-tls/1 protocol without a client certificate and returns the error of the server side
+		handshake := func(configure func(config *tls.Config) *tls.Config) error {
+			certFile, keyFile, err := ca.IssueServerCert()
+			Expect(err).NotTo(HaveOccurred())
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			Expect(err).NotTo(HaveOccurred())
+			config := configure(&tls.Config{Certificates: []tls.Certificate{cert}})
+
+			listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+			Expect(err).NotTo(HaveOccurred())
+			defer listener.Close()
+
+			result := make(chan error, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					result <- err
+					return
+				}
+				defer conn.Close()
+				result <- conn.(*tls.Conn).Handshake()
+			}()
+
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+				NextProtos:         []string{acme.ALPNProto},
+				ServerName:         "localhost",
+				InsecureSkipVerify: true,
+			})
+			if err == nil {
+				// the server rejects the missing certificate after the client has finished its side with TLS 1.3
+				_, _ = conn.Read(make([]byte, 1))
+				_ = conn.Close()
+			}
+
+			select {
+			case err = <-result:
+				return err
+			case <-time.After(5 * time.Second):
+				Fail("the handshake hasn't finished")
+				return nil
+			}
+		}
+
+		Specify("should reject the clients without a certificate", func() {
+			reloader, err := encryption.NewClientCAReloader(ca.CertFile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(handshake(reloader.RequireClientCertificates)).NotTo(Succeed())
+		})
+
+		Specify("should serve the challenge certificates only with the Let's Encrypt cert manager", func() {
+			reloader, err := encryption.NewClientCAReloader(ca.CertFile)
+			Expect(err).NotTo(HaveOccurred())
+			certManager := &autocert.Manager{
+				Prompt:     autocert.AcceptTOS,
+				Cache:      autocert.DirCache(filepath.Join(dir, "letsencrypt")),
+				HostPolicy: autocert.HostWhitelist("localhost"),
+			}
+
+			// no challenge is pending, so there is no certificate to present
+			Expect(handshake(func(config *tls.Config) *tls.Config {
+				return reloader.RequireClientCertificatesWithACME(config, certManager)
+			})).NotTo(Succeed())
+		})
+	})
+})
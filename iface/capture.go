@@ -0,0 +1,194 @@
+package iface
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// pcapHeaderLen and pcapRecordHeaderLen are the sizes of the global header of a pcap file and the header
+	// of each of its packets
+	pcapHeaderLen       = 24
+	pcapRecordHeaderLen = 16
+	// pcapSnapLen is the longest packet written to a capture, the packets of the interface never exceed it
+	pcapSnapLen = 65535
+	// pcapLinkTypeRaw is the link type of the packets starting with the IP header, the interface has no link layer
+	pcapLinkTypeRaw = 101
+)
+
+// ErrCaptureNotSupported is returned by StartCapture on platforms where the packets of a kernel Wireguard interface
+// can't be captured (wireguard-nt on Windows)
+var ErrCaptureNotSupported = errors.New("packet capture is supported in the userspace mode and on Linux only")
+
+// PacketCapture writes the packets of the interface to a pcap file until it is closed or the file has reached
+// its size limit
+type PacketCapture struct {
+	path     string
+	maxBytes int64
+
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	packets int64
+	closed  bool
+	// err is the first error writing the file, the capture stops on it
+	err error
+
+	// stop detaches the capture from the interface, nil if the capture detaches itself once done is closed
+	stop      func()
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newPacketCapture creates the pcap file at the path, replacing an existing one, and writes its header
+func newPacketCapture(path string, maxBytes int64) (*PacketCapture, error) {
+	if maxBytes < pcapHeaderLen {
+		return nil, fmt.Errorf("capture size limit %d is smaller than the pcap header", maxBytes)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, pcapHeaderLen)
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:6], 2)
+	binary.LittleEndian.PutUint16(header[6:8], 4)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeRaw)
+
+	writer := bufio.NewWriter(file)
+	_, err = writer.Write(header)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &PacketCapture{
+		path:     path,
+		maxBytes: maxBytes,
+		file:     file,
+		writer:   writer,
+		size:     pcapHeaderLen,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// StartCapture starts capturing the packets sent and received through the interface to a pcap file at the path.
+// The capture stops once it is closed or the file would exceed maxBytes. The packets are captured by the wireguard-go
+// device in the userspace mode and by an AF_PACKET socket in the kernel mode on Linux
+func (w *WGIface) StartCapture(path string, maxBytes int64) (*PacketCapture, error) {
+	capture, err := newPacketCapture(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter, ok := w.userspaceFilter(); ok {
+		capture.stop = func() { filter.setCapture(nil) }
+		filter.setCapture(capture)
+	} else {
+		err = w.startHostCapture(capture)
+		if err != nil {
+			_ = capture.Close()
+			return nil, err
+		}
+	}
+
+	log.Debugf("capturing packets of interface %s to %s", w.Name, path)
+	return capture, nil
+}
+
+// writePacket appends a packet to the file, the capture is closed once the packet doesn't fit the size limit
+func (c *PacketCapture) writePacket(packet []byte, at time.Time) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+
+	length := len(packet)
+	if length > pcapSnapLen {
+		length = pcapSnapLen
+	}
+	full := c.size+pcapRecordHeaderLen+int64(length) > c.maxBytes
+	if !full {
+		record := make([]byte, pcapRecordHeaderLen)
+		binary.LittleEndian.PutUint32(record[0:4], uint32(at.Unix()))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(at.Nanosecond()/1000))
+		binary.LittleEndian.PutUint32(record[8:12], uint32(length))
+		binary.LittleEndian.PutUint32(record[12:16], uint32(len(packet)))
+		_, err := c.writer.Write(record)
+		if err == nil {
+			_, err = c.writer.Write(packet[:length])
+		}
+		if err != nil {
+			c.err = err
+			full = true
+		} else {
+			c.size += pcapRecordHeaderLen + int64(length)
+			c.packets++
+		}
+	}
+	c.mu.Unlock()
+
+	if full {
+		_ = c.Close()
+	}
+}
+
+// Close stops the capture and closes the file, it is safe to call it more than once
+func (c *PacketCapture) Close() error {
+	c.closeOnce.Do(func() {
+		if c.stop != nil {
+			c.stop()
+		}
+
+		c.mu.Lock()
+		c.closed = true
+		err := c.writer.Flush()
+		if closeErr := c.file.Close(); err == nil {
+			err = closeErr
+		}
+		if c.err == nil {
+			c.err = err
+		}
+		c.mu.Unlock()
+
+		close(c.done)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Done returns a channel closed once the capture has stopped
+func (c *PacketCapture) Done() <-chan struct{} {
+	return c.done
+}
+
+// Path returns the path of the pcap file
+func (c *PacketCapture) Path() string {
+	return c.path
+}
+
+// Packets returns the number of the packets written to the file
+func (c *PacketCapture) Packets() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.packets
+}
+
+// Size returns the size of the file in bytes
+func (c *PacketCapture) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
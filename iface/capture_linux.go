@@ -0,0 +1,71 @@
+package iface
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// captureReadTimeout bounds a read of the AF_PACKET socket, so the capture notices it has been closed
+const captureReadTimeout = 250 * time.Millisecond
+
+// startHostCapture captures the packets of the kernel Wireguard interface with an AF_PACKET socket bound to it.
+// The interface has no link layer, so the datagram socket returns the packets starting with the IP header
+func (w *WGIface) startHostCapture(capture *PacketCapture) error {
+	link, err := net.InterfaceByName(w.Name)
+	if err != nil {
+		return err
+	}
+
+	// the socket is bound to the interface before it receives any packet, so it is created without a protocol
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed creating packet socket: %v", err)
+	}
+
+	err = unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: link.Index})
+	if err == nil {
+		timeout := unix.NsecToTimeval(captureReadTimeout.Nanoseconds())
+		err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &timeout)
+	}
+	if err != nil {
+		_ = unix.Close(fd)
+		return fmt.Errorf("failed binding packet socket to interface %s: %v", w.Name, err)
+	}
+
+	go readHostCapture(fd, capture)
+	return nil
+}
+
+// readHostCapture writes the packets read from the socket to the capture until it is closed
+func readHostCapture(fd int, capture *PacketCapture) {
+	defer unix.Close(fd)
+
+	buf := make([]byte, pcapSnapLen)
+	for {
+		select {
+		case <-capture.Done():
+			return
+		default:
+		}
+
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			log.Debugf("stopping packet capture after a failed read: %v", err)
+			_ = capture.Close()
+			return
+		}
+		capture.writePacket(buf[:n], time.Now())
+	}
+}
+
+// htons converts a short from the host to the network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
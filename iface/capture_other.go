@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+// startHostCapture is not supported on this platform, the packets are captured in the userspace mode only
+func (w *WGIface) startHostCapture(capture *PacketCapture) error {
+	return ErrCaptureNotSupported
+}
@@ -0,0 +1,124 @@
+package iface
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTestCapture returns the packets of a pcap file written by a PacketCapture
+func readTestCapture(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < pcapHeaderLen || binary.LittleEndian.Uint32(data[0:4]) != 0xa1b2c3d4 {
+		t.Fatalf("expected a pcap header, got %x", data)
+	}
+	if linkType := binary.LittleEndian.Uint32(data[20:24]); linkType != pcapLinkTypeRaw {
+		t.Fatalf("expected the raw IP link type, got %d", linkType)
+	}
+
+	var packets [][]byte
+	for offset := pcapHeaderLen; offset < len(data); {
+		if offset+pcapRecordHeaderLen > len(data) {
+			t.Fatalf("truncated record header at %d", offset)
+		}
+		length := int(binary.LittleEndian.Uint32(data[offset+8 : offset+12]))
+		offset += pcapRecordHeaderLen
+		if offset+length > len(data) {
+			t.Fatalf("truncated packet at %d", offset)
+		}
+		packets = append(packets, data[offset:offset+length])
+		offset += length
+	}
+	return packets
+}
+
+func Test_PacketFilterCapture(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	capture, err := newPacketCapture(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := newPacketFilter()
+	capture.stop = func() { filter.setCapture(nil) }
+	filter.setCapture(capture)
+
+	outbound := testPacket(protocolTCP, "100.64.0.1", "100.64.0.10", 40000, 443)
+	inbound := testPacket(protocolUDP, "100.64.0.10", "100.64.0.1", 53, 40000)
+	filter.capturePacket(outbound)
+	filter.capturePacket(inbound)
+
+	err = capture.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closed captures are detached from the filter
+	filter.capturePacket(outbound)
+
+	select {
+	case <-capture.Done():
+	default:
+		t.Error("expected the capture to be done once closed")
+	}
+	if capture.Packets() != 2 {
+		t.Errorf("expected 2 captured packets, got %d", capture.Packets())
+	}
+
+	packets := readTestCapture(t, path)
+	if len(packets) != 2 || !bytes.Equal(packets[0], outbound) || !bytes.Equal(packets[1], inbound) {
+		t.Errorf("expected the captured packets in order, got %x", packets)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != capture.Size() {
+		t.Errorf("expected the file of %d bytes, got %d", capture.Size(), info.Size())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the capture to be readable by the owner only, got %s", info.Mode().Perm())
+	}
+}
+
+func Test_PacketCaptureSizeLimit(t *testing.T) {
+	packet := testPacket(protocolUDP, "100.64.0.10", "100.64.0.1", 53, 40000)
+	maxBytes := int64(pcapHeaderLen + 2*(pcapRecordHeaderLen+len(packet)) + 10)
+
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	capture, err := newPacketCapture(path, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		capture.writePacket(packet, time.Now())
+	}
+
+	select {
+	case <-capture.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected the capture to stop once the size limit has been reached")
+	}
+	if capture.Packets() != 2 {
+		t.Errorf("expected 2 packets to fit the size limit, got %d", capture.Packets())
+	}
+	if packets := readTestCapture(t, path); len(packets) != 2 {
+		t.Errorf("expected 2 packets in the file, got %d", len(packets))
+	}
+	if capture.Size() > maxBytes {
+		t.Errorf("expected the file of at most %d bytes, got %d", maxBytes, capture.Size())
+	}
+
+	_, err = newPacketCapture(path, pcapHeaderLen-1)
+	if err == nil {
+		t.Error("expected a size limit smaller than the pcap header to be rejected")
+	}
+}
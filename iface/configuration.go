@@ -2,10 +2,10 @@ package iface
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -74,13 +74,12 @@ func (w *WGIface) GetListenPort() (*int, error) {
 }
 
 // UpdatePeer updates existing Wireguard Peer or creates a new one if doesn't exist
-// Endpoint is optional
+// Endpoint is optional, allowedIps is a comma separated list
 func (w *WGIface) UpdatePeer(peerKey string, allowedIps string, keepAlive time.Duration, endpoint *net.UDPAddr, preSharedKey *wgtypes.Key) error {
 
 	log.Debugf("updating interface %s peer %s: endpoint %s ", w.Name, peerKey, endpoint)
 
-	//parse allowed ips
-	_, ipNet, err := net.ParseCIDR(allowedIps)
+	ipNets, err := parseAllowedIPs(allowedIps)
 	if err != nil {
 		return err
 	}
@@ -92,7 +91,7 @@ func (w *WGIface) UpdatePeer(peerKey string, allowedIps string, keepAlive time.D
 	peer := wgtypes.PeerConfig{
 		PublicKey:                   peerKeyParsed,
 		ReplaceAllowedIPs:           true,
-		AllowedIPs:                  []net.IPNet{*ipNet},
+		AllowedIPs:                  ipNets,
 		PersistentKeepaliveInterval: &keepAlive,
 		PresharedKey:                preSharedKey,
 		Endpoint:                    endpoint,
@@ -108,6 +107,45 @@ func (w *WGIface) UpdatePeer(peerKey string, allowedIps string, keepAlive time.D
 	return nil
 }
 
+// SetAllowedIPs replaces the allowed IPs (a comma separated list) of an existing Wireguard Peer keeping its endpoint
+// and keepalive. An allowed IP assigned to another peer is moved to this one
+func (w *WGIface) SetAllowedIPs(peerKey string, allowedIps string) error {
+	ipNets, err := parseAllowedIPs(allowedIps)
+	if err != nil {
+		return err
+	}
+
+	peerKeyParsed, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return err
+	}
+	peer := wgtypes.PeerConfig{
+		PublicKey:         peerKeyParsed,
+		UpdateOnly:        true,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        ipNets,
+	}
+
+	err = w.configureDevice(wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}})
+	if err != nil {
+		return fmt.Errorf("received error \"%v\" while setting allowed ips %s of peer %s on interface %s", err, allowedIps, peerKey, w.Name)
+	}
+	return nil
+}
+
+// parseAllowedIPs parses a comma separated list of allowed IPs
+func parseAllowedIPs(allowedIps string) ([]net.IPNet, error) {
+	var ipNets []net.IPNet
+	for _, allowedIP := range strings.Split(allowedIps, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(allowedIP))
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, *ipNet)
+	}
+	return ipNets, nil
+}
+
 // RemovePeer removes a Wireguard Peer from the interface iface
 func (w *WGIface) RemovePeer(peerKey string) error {
 	log.Debugf("Removing peer %s from interface %s ", peerKey, w.Name)
@@ -131,3 +169,54 @@ func (w *WGIface) RemovePeer(peerKey string) error {
 	}
 	return nil
 }
+
+// PeerStats holds the transfer counters of a Wireguard Peer
+type PeerStats struct {
+	RxBytes       int64
+	TxBytes       int64
+	LastHandshake time.Time
+}
+
+// GetPeerStats returns the transfer counters of a Wireguard Peer of the interface iface
+func (w *WGIface) GetPeerStats(peerKey string) (*PeerStats, error) {
+	peerKeyParsed, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := w.GetStats()
+	if err != nil {
+		return nil, err
+	}
+
+	peerStats, ok := stats[peerKeyParsed.String()]
+	if !ok {
+		return nil, fmt.Errorf("peer %s doesn't exist on interface %s", peerKey, w.Name)
+	}
+	return &peerStats, nil
+}
+
+// GetStats returns the transfer counters of all the Wireguard Peers of the interface iface by the peer key,
+// read from the device at once
+func (w *WGIface) GetStats() (map[string]PeerStats, error) {
+	wg, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	defer wg.Close()
+
+	d, err := wg.Device(w.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make(map[string]PeerStats, len(d.Peers))
+	for _, peer := range d.Peers {
+		stats[peer.PublicKey.String()] = PeerStats{
+			RxBytes:       peer.ReceiveBytes,
+			TxBytes:       peer.TransmitBytes,
+			LastHandshake: peer.LastHandshakeTime,
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,12 @@
+package iface
+
+import "errors"
+
+// ErrExitNodeNotSupported is returned by SetDefaultRoute and RemoveDefaultRoute on platforms where the traffic
+// can't be routed through an exit node, currently supported on Linux only
+var ErrExitNodeNotSupported = errors.New("exit nodes are supported on Linux only")
+
+// DefaultRouteHalves are the networks routed through the interface to route all the IPv4 traffic through an exit node.
+// Together they cover the whole address space and are more specific than the default route of the system,
+// which is kept, so the traffic falls back to it once they are removed
+var DefaultRouteHalves = []string{"0.0.0.0/1", "128.0.0.0/1"}
@@ -0,0 +1,149 @@
+package iface
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// exitNodeRouteProtocol marks the routes added for an exit node, so they are found and removed
+// even if the client has been restarted meanwhile
+const exitNodeRouteProtocol = 0x6e
+
+// SetDefaultRoute routes all the IPv4 traffic through the interface with the DefaultRouteHalves routes of the priority
+// set by SetRouteMetric.
+// The bypass IPs (e.g. the Management and the Signal Service, the TURN servers and the endpoints of the peers) get
+// host routes through the gateway of the system, so the tunnel doesn't carry its own traffic and the connections
+// to the services survive. Calling it again adds the host routes of new bypass IPs and removes the ones no longer listed
+func (w *WGIface) SetDefaultRoute(bypass []net.IP) error {
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return err
+	}
+	linkIndex := link.Attrs().Index
+
+	existing, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Protocol: exitNodeRouteProtocol},
+		netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return err
+	}
+
+	// the host routes to add by the destination
+	bypassRoutes := map[string]*net.IPNet{}
+	for _, ip := range bypass {
+		ip = ip.To4()
+		// IPv6 isn't routed through the exit node
+		if ip == nil {
+			continue
+		}
+		dst := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+		bypassRoutes[dst.String()] = dst
+	}
+
+	for i := range existing {
+		route := existing[i]
+		if route.LinkIndex == linkIndex || route.Dst == nil {
+			continue
+		}
+		if _, ok := bypassRoutes[route.Dst.String()]; ok {
+			delete(bypassRoutes, route.Dst.String())
+			continue
+		}
+		err = netlink.RouteDel(&route)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed removing bypass route to %s: %v", route.Dst, err)
+		}
+	}
+
+	for _, dst := range bypassRoutes {
+		gateway, err := w.systemRoute(dst.IP, linkIndex)
+		if err != nil {
+			return err
+		}
+		// a peer reached through the tunnel doesn't need a bypass route
+		if gateway == nil {
+			continue
+		}
+		route := &netlink.Route{
+			LinkIndex: gateway.LinkIndex,
+			Dst:       dst,
+			Gw:        gateway.Gw,
+			Protocol:  exitNodeRouteProtocol,
+		}
+		err = netlink.RouteAdd(route)
+		if err != nil && !errors.Is(err, syscall.EEXIST) {
+			return fmt.Errorf("failed adding bypass route to %s: %v", dst, err)
+		}
+	}
+
+	for _, network := range DefaultRouteHalves {
+		_, dst, err := net.ParseCIDR(network)
+		if err != nil {
+			return err
+		}
+		route := &netlink.Route{
+			LinkIndex: linkIndex,
+			Dst:       dst,
+			Scope:     netlink.SCOPE_LINK,
+			Protocol:  exitNodeRouteProtocol,
+			Priority:  int(w.routeMetric),
+		}
+		err = netlink.RouteAdd(route)
+		if err != nil && !errors.Is(err, syscall.EEXIST) {
+			return fmt.Errorf("failed adding route to %s through interface %s: %v", dst, w.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// systemRoute returns the route of the system to the IP, nil if the IP is reached through the interface itself
+// (e.g. an address of the interface network). Once the DefaultRouteHalves routes are in place the kernel routes
+// the IP through the interface, the gateway of the system recorded by the previous lookups is used then
+func (w *WGIface) systemRoute(ip net.IP, linkIndex int) (*netlink.Route, error) {
+	routes, err := netlink.RouteGet(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed looking up the route to %s: %v", ip, err)
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("no route to %s", ip)
+	}
+
+	route := routes[0]
+	if route.LinkIndex != linkIndex {
+		if route.Gw != nil {
+			w.exitNodeGateway, w.exitNodeGatewayIndex = route.Gw, route.LinkIndex
+		}
+		return &route, nil
+	}
+
+	if w.Address.Network != nil && w.Address.Network.Contains(ip) {
+		return nil, nil
+	}
+	if w.exitNodeGateway == nil {
+		return nil, fmt.Errorf("no gateway of the system to route %s through", ip)
+	}
+	return &netlink.Route{LinkIndex: w.exitNodeGatewayIndex, Gw: w.exitNodeGateway}, nil
+}
+
+// RemoveDefaultRoute removes the routes added by SetDefaultRoute, the traffic is routed by the default route
+// of the system again
+func (w *WGIface) RemoveDefaultRoute() error {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Protocol: exitNodeRouteProtocol},
+		netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return err
+	}
+
+	for i := range routes {
+		err = netlink.RouteDel(&routes[i])
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed removing route to %s: %v", routes[i].Dst, err)
+		}
+	}
+	w.exitNodeGateway, w.exitNodeGatewayIndex = nil, 0
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+import "net"
+
+// SetDefaultRoute is not supported on this platform
+func (w *WGIface) SetDefaultRoute(bypass []net.IP) error {
+	return ErrExitNodeNotSupported
+}
+
+// RemoveDefaultRoute is not supported on this platform
+func (w *WGIface) RemoveDefaultRoute() error {
+	return ErrExitNodeNotSupported
+}
@@ -0,0 +1,25 @@
+package iface
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ErrSourceFilterNotSupported is returned by SetAllowedSources and RemoveSourceFilter on platforms
+// where the host firewall isn't programmed. Source filtering is currently supported on Linux and macOS only.
+var ErrSourceFilterNotSupported = errors.New("source filtering is supported on Linux and macOS only")
+
+// sourceFilterName is the name of the firewall table (nftables), chain (iptables) or anchor (pf) of the interface
+func sourceFilterName(ifaceName string) string {
+	return "netbird-src-" + ifaceName
+}
+
+// joinSources formats the sources as a comma separated list of CIDRs
+func joinSources(sources []*net.IPNet) string {
+	cidrs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		cidrs = append(cidrs, source.String())
+	}
+	return strings.Join(cidrs, ", ")
+}
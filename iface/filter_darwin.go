@@ -0,0 +1,90 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// pfTokenRegexp matches the reference token printed by pfctl -E
+var pfTokenRegexp = regexp.MustCompile(`Token : (\d+)`)
+
+var (
+	// pfTokens holds the pf enable references taken per interface, released by RemoveSourceFilter
+	pfTokens   = map[string]string{}
+	pfTokensMu sync.Mutex
+)
+
+// SetAllowedSources makes the host firewall accept only the packets coming from the sources on the interface
+// and drop all the others (e.g. spoofed by a relay). It replaces the sources set before atomically.
+// The rules are loaded into a pf anchor under com.apple/, which the default pf.conf evaluates,
+// and pf gets enabled with a reference, so it stays enabled for other users when the filter is removed
+func (w *WGIface) SetAllowedSources(sources []*net.IPNet) error {
+	anchor := pfAnchor(w.Name)
+
+	rules := fmt.Sprintf("table <allowed> const { %s }\nblock drop in quick on %s from ! <allowed> to any\n",
+		joinSources(sources), w.Name)
+	cmd := exec.Command("pfctl", "-a", anchor, "-f", "-")
+	cmd.Stdin = strings.NewReader(rules)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed setting allowed sources of interface %s: %v: %s", w.Name, err, strings.TrimSpace(string(out)))
+	}
+
+	err = w.enablePf()
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("allowed sources of interface %s: %s", w.Name, joinSources(sources))
+	return nil
+}
+
+// RemoveSourceFilter removes the firewall rules installed by SetAllowedSources, if any
+func (w *WGIface) RemoveSourceFilter() error {
+	out, err := exec.Command("pfctl", "-a", pfAnchor(w.Name), "-F", "all").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed removing source filter of interface %s: %v: %s", w.Name, err, strings.TrimSpace(string(out)))
+	}
+
+	pfTokensMu.Lock()
+	defer pfTokensMu.Unlock()
+	if token, ok := pfTokens[w.Name]; ok {
+		delete(pfTokens, w.Name)
+		out, err = exec.Command("pfctl", "-X", token).CombinedOutput()
+		if err != nil {
+			log.Warnf("failed releasing pf reference %s of interface %s: %v: %s", token, w.Name, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	log.Debugf("removed source filter of interface %s", w.Name)
+	return nil
+}
+
+// enablePf enables pf with a reference once per interface
+func (w *WGIface) enablePf() error {
+	pfTokensMu.Lock()
+	defer pfTokensMu.Unlock()
+	if _, ok := pfTokens[w.Name]; ok {
+		return nil
+	}
+
+	out, err := exec.Command("pfctl", "-E").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed enabling pf: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	match := pfTokenRegexp.FindStringSubmatch(string(out))
+	if match == nil {
+		return fmt.Errorf("failed enabling pf, no reference token in the output: %s", strings.TrimSpace(string(out)))
+	}
+	pfTokens[w.Name] = match[1]
+
+	return nil
+}
+
+func pfAnchor(ifaceName string) string {
+	return "com.apple/" + sourceFilterName(ifaceName)
+}
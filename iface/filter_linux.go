@@ -0,0 +1,130 @@
+package iface
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// SetAllowedSources makes the host firewall accept only the packets coming from the sources on the interface
+// and drop all the others (e.g. spoofed by a relay). It replaces the sources set before atomically.
+// nftables is used if the nft command is available, iptables otherwise. In both cases the packets are dropped
+// before the connection tracking, so the rules apply to the traffic to the host and the forwarded traffic
+func (w *WGIface) SetAllowedSources(sources []*net.IPNet) error {
+	var err error
+	if _, lookErr := exec.LookPath("nft"); lookErr == nil {
+		err = w.setNftablesSources(sources)
+	} else if _, lookErr := exec.LookPath("iptables-restore"); lookErr == nil {
+		err = w.setIptablesSources(sources)
+	} else {
+		return fmt.Errorf("neither nft nor iptables-restore found to filter the sources of interface %s", w.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed setting allowed sources of interface %s: %v", w.Name, err)
+	}
+
+	log.Debugf("allowed sources of interface %s: %s", w.Name, joinSources(sources))
+	return nil
+}
+
+// RemoveSourceFilter removes the firewall rules installed by SetAllowedSources, if any
+func (w *WGIface) RemoveSourceFilter() error {
+	name := sourceFilterName(w.Name)
+
+	if _, err := exec.LookPath("nft"); err == nil {
+		// adding the table first makes the deletion succeed if it doesn't exist
+		script := fmt.Sprintf("table ip %s\ndelete table ip %s\n", name, name)
+		if err := runWithStdin(script, "nft", "-f", "-"); err != nil {
+			return fmt.Errorf("failed removing source filter of interface %s: %v", w.Name, err)
+		}
+	}
+
+	if _, err := exec.LookPath("iptables"); err == nil {
+		// the jump is deleted until none is left in case it was added more than once
+		for {
+			err = runIptables("-t", "raw", "-D", "PREROUTING", "-i", w.Name, "-j", name)
+			if err != nil {
+				break
+			}
+		}
+		// the chain doesn't exist if the filter hasn't been installed with iptables
+		_ = runIptables("-t", "raw", "-F", name)
+		_ = runIptables("-t", "raw", "-X", name)
+	}
+
+	log.Debugf("removed source filter of interface %s", w.Name)
+	return nil
+}
+
+// setNftablesSources replaces the table of the interface in a single nftables transaction
+func (w *WGIface) setNftablesSources(sources []*net.IPNet) error {
+	name := sourceFilterName(w.Name)
+
+	var elements string
+	if len(sources) > 0 {
+		elements = fmt.Sprintf("\t\telements = { %s }\n", joinSources(sources))
+	}
+
+	script := fmt.Sprintf(`table ip %[1]s
+delete table ip %[1]s
+table ip %[1]s {
+	set allowed {
+		type ipv4_addr
+		flags interval
+%[2]s	}
+	chain prerouting {
+		type filter hook prerouting priority -300; policy accept;
+		iifname "%[3]s" ip saddr != @allowed drop
+	}
+}
+`, name, elements, w.Name)
+
+	return runWithStdin(script, "nft", "-f", "-")
+}
+
+// setIptablesSources replaces the rules of the chain of the interface with iptables-restore,
+// which commits the raw table atomically, and jumps to the chain from the raw PREROUTING chain
+func (w *WGIface) setIptablesSources(sources []*net.IPNet) error {
+	name := sourceFilterName(w.Name)
+
+	var rules strings.Builder
+	rules.WriteString("*raw\n")
+	// declaring an existing chain flushes it
+	fmt.Fprintf(&rules, ":%s - [0:0]\n", name)
+	for _, source := range sources {
+		fmt.Fprintf(&rules, "-A %s -s %s -j RETURN\n", name, source.String())
+	}
+	fmt.Fprintf(&rules, "-A %s -j DROP\n", name)
+	rules.WriteString("COMMIT\n")
+
+	err := runWithStdin(rules.String(), "iptables-restore", "--noflush")
+	if err != nil {
+		return err
+	}
+
+	if runIptables("-t", "raw", "-C", "PREROUTING", "-i", w.Name, "-j", name) == nil {
+		return nil
+	}
+	return runIptables("-t", "raw", "-I", "PREROUTING", "-i", w.Name, "-j", name)
+}
+
+func runIptables(args ...string) error {
+	// wait for the xtables lock held by other programs
+	out, err := exec.Command("iptables", append([]string{"-w"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func runWithStdin(stdin string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewBufferString(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_SourceFilter(t *testing.T) {
+	_, nftErr := exec.LookPath("nft")
+	_, iptablesErr := exec.LookPath("iptables-restore")
+	if nftErr != nil && iptablesErr != nil {
+		t.Skip("neither nft nor iptables-restore found")
+	}
+
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+6)
+	wgIP := "10.99.99.25/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	_, peerNet, _ := net.ParseCIDR("10.99.99.26/32")
+	_, routedNet, _ := net.ParseCIDR("192.168.100.0/24")
+	err = iface.SetAllowedSources([]*net.IPNet{peerNet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replacing the sources must not duplicate the rules
+	err = iface.SetAllowedSources([]*net.IPNet{peerNet, routedNet})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := listSourceFilter(t, ifaceName)
+	for _, source := range []string{"10.99.99.26", "192.168.100.0/24"} {
+		if strings.Count(rules, source) != 1 {
+			t.Errorf("expected the source %s to be allowed once, got rules:\n%s", source, rules)
+		}
+	}
+	if !strings.Contains(rules, "drop") && !strings.Contains(rules, "DROP") {
+		t.Errorf("expected the other sources to be dropped, got rules:\n%s", rules)
+	}
+
+	err = iface.RemoveSourceFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := listSourceFilter(t, ifaceName); rules != "" {
+		t.Errorf("expected the source filter to be removed, got rules:\n%s", rules)
+	}
+}
+
+// listSourceFilter returns the rules of the source filter of the interface, empty if there is none
+func listSourceFilter(t *testing.T, ifaceName string) string {
+	name := sourceFilterName(ifaceName)
+	if _, err := exec.LookPath("nft"); err == nil {
+		out, err := exec.Command("nft", "list", "table", "ip", name).CombinedOutput()
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	}
+
+	out, err := exec.Command("iptables", "-w", "-t", "raw", "-S", name).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	jump, _ := exec.Command("iptables", "-w", "-t", "raw", "-S", "PREROUTING").CombinedOutput()
+	if strings.Count(string(jump), "-j "+name) != 1 {
+		t.Errorf("expected a single jump to the source filter chain, got:\n%s", jump)
+	}
+	return string(out)
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package iface
+
+import "net"
+
+// SetAllowedSources is not supported on this platform
+func (w *WGIface) SetAllowedSources(sources []*net.IPNet) error {
+	return ErrSourceFilterNotSupported
+}
+
+// RemoveSourceFilter is not supported on this platform
+func (w *WGIface) RemoveSourceFilter() error {
+	return ErrSourceFilterNotSupported
+}
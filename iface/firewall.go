@@ -0,0 +1,116 @@
+package iface
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrFirewallNotSupported is returned by SetFirewallRules and RemoveFirewallRules on platforms where neither
+// the userspace packet filter nor the host firewall filter the kernel Wireguard interface (wireguard-nt on Windows)
+var ErrFirewallNotSupported = errors.New("firewall rules are supported in the userspace mode and with nftables on Linux only")
+
+// FirewallAction is the verdict of a firewall rule
+type FirewallAction int
+
+const (
+	// FirewallAccept lets the packet through
+	FirewallAccept FirewallAction = iota
+	// FirewallDrop drops the packet
+	FirewallDrop
+)
+
+func (a FirewallAction) String() string {
+	if a == FirewallDrop {
+		return "drop"
+	}
+	return "accept"
+}
+
+// FirewallProtocol is the transport protocol a firewall rule matches
+type FirewallProtocol int
+
+const (
+	// FirewallProtocolAll matches all the protocols
+	FirewallProtocolAll FirewallProtocol = iota
+	FirewallProtocolTCP
+	FirewallProtocolUDP
+	FirewallProtocolICMP
+)
+
+// FirewallRule matches the IPv4 packets the interface receives from the remote peers
+type FirewallRule struct {
+	Action   FirewallAction
+	Protocol FirewallProtocol
+	// Sources are the allowed IPs of the remote peers the rule applies to, all the peers if empty
+	Sources []*net.IPNet
+	// Port is the destination port of the TCP and UDP packets, any port if 0.
+	// Only TCP and UDP packets match a rule with a port
+	Port uint16
+}
+
+func (r FirewallRule) String() string {
+	var b strings.Builder
+	b.WriteString(r.Action.String())
+	switch r.Protocol {
+	case FirewallProtocolTCP:
+		b.WriteString(" tcp")
+	case FirewallProtocolUDP:
+		b.WriteString(" udp")
+	case FirewallProtocolICMP:
+		b.WriteString(" icmp")
+	}
+	if r.Port != 0 {
+		fmt.Fprintf(&b, " port %d", r.Port)
+	}
+	if len(r.Sources) > 0 {
+		fmt.Fprintf(&b, " from %s", joinSources(r.Sources))
+	}
+	return b.String()
+}
+
+// SetFirewallRules makes the interface filter the packets it receives from the remote peers with the rules,
+// replacing the rules set before atomically. The first rule matching a packet decides and the packets no rule matches
+// are dropped, except the packets of the connections initiated by the host, which are always accepted.
+// The packet filter of the wireguard-go device applies the rules in the userspace mode, nftables in the kernel mode on Linux
+func (w *WGIface) SetFirewallRules(rules []FirewallRule) error {
+	if filter, ok := w.userspaceFilter(); ok {
+		filter.setRules(rules)
+	} else {
+		err := w.setHostFirewallRules(rules)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("firewall rules of interface %s: %d rules", w.Name, len(rules))
+	for _, rule := range rules {
+		log.Tracef("firewall rule of interface %s: %s", w.Name, rule)
+	}
+	return nil
+}
+
+// RemoveFirewallRules removes the rules set by SetFirewallRules, all the packets are accepted again
+func (w *WGIface) RemoveFirewallRules() error {
+	if filter, ok := w.userspaceFilter(); ok {
+		filter.setRules(nil)
+	} else {
+		err := w.removeHostFirewallRules()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("removed firewall rules of interface %s", w.Name)
+	return nil
+}
+
+// userspaceFilter returns the packet filter of the wireguard-go device if the interface is in the userspace mode
+func (w *WGIface) userspaceFilter() (*packetFilter, bool) {
+	device, ok := w.Interface.(interface{ packetFilter() *packetFilter })
+	if !ok {
+		return nil, false
+	}
+	return device.packetFilter(), true
+}
@@ -0,0 +1,99 @@
+package iface
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// setHostFirewallRules replaces the table of the interface in a single nftables transaction. The rules chain is jumped to
+// from the input and the forward hooks, so the rules apply to the traffic to the host and the forwarded traffic
+func (w *WGIface) setHostFirewallRules(rules []FirewallRule) error {
+	if _, err := exec.LookPath("nft"); err != nil {
+		return fmt.Errorf("nft not found to apply the firewall rules of interface %s", w.Name)
+	}
+
+	var ruleLines strings.Builder
+	for _, rule := range rules {
+		fmt.Fprintf(&ruleLines, "\t\t%s\n", nftablesRule(rule))
+	}
+
+	script := fmt.Sprintf(`table ip %[1]s
+delete table ip %[1]s
+table ip %[1]s {
+	chain input {
+		type filter hook input priority 0; policy accept;
+		iifname "%[2]s" jump rules
+	}
+	chain forward {
+		type filter hook forward priority 0; policy accept;
+		iifname "%[2]s" jump rules
+	}
+	chain rules {
+		ct state established,related accept
+%[3]s		drop
+	}
+}
+`, firewallName(w.Name), w.Name, ruleLines.String())
+
+	err := runWithStdin(script, "nft", "-f", "-")
+	if err != nil {
+		return fmt.Errorf("failed setting firewall rules of interface %s: %v", w.Name, err)
+	}
+	return nil
+}
+
+// removeHostFirewallRules removes the table of the interface, if any
+func (w *WGIface) removeHostFirewallRules() error {
+	if _, err := exec.LookPath("nft"); err != nil {
+		// the rules can't have been set without nft
+		return nil
+	}
+
+	name := firewallName(w.Name)
+	// adding the table first makes the deletion succeed if it doesn't exist
+	script := fmt.Sprintf("table ip %s\ndelete table ip %s\n", name, name)
+	err := runWithStdin(script, "nft", "-f", "-")
+	if err != nil {
+		return fmt.Errorf("failed removing firewall rules of interface %s: %v", w.Name, err)
+	}
+	return nil
+}
+
+// nftablesRule formats a firewall rule as an nftables rule statement
+func nftablesRule(rule FirewallRule) string {
+	var matches []string
+	if len(rule.Sources) > 0 {
+		matches = append(matches, fmt.Sprintf("ip saddr { %s }", joinSources(rule.Sources)))
+	}
+
+	switch rule.Protocol {
+	case FirewallProtocolTCP, FirewallProtocolUDP:
+		protocol := "tcp"
+		if rule.Protocol == FirewallProtocolUDP {
+			protocol = "udp"
+		}
+		if rule.Port != 0 {
+			matches = append(matches, fmt.Sprintf("%s dport %d", protocol, rule.Port))
+		} else {
+			matches = append(matches, "meta l4proto "+protocol)
+		}
+	case FirewallProtocolICMP:
+		matches = append(matches, "meta l4proto icmp")
+		if rule.Port != 0 {
+			// ICMP packets have no ports, the rule can't match
+			return "# " + rule.String()
+		}
+	default:
+		if rule.Port != 0 {
+			matches = append(matches, fmt.Sprintf("meta l4proto { tcp, udp } th dport %d", rule.Port))
+		}
+	}
+
+	return strings.TrimSpace(strings.Join(matches, " ") + " " + rule.Action.String())
+}
+
+// firewallName is the name of the nftables table of the interface
+func firewallName(ifaceName string) string {
+	return "netbird-fw-" + ifaceName
+}
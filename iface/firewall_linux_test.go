@@ -0,0 +1,85 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_NftablesRule(t *testing.T) {
+	_, peer1, _ := net.ParseCIDR("100.64.0.10/32")
+	_, peer2, _ := net.ParseCIDR("100.64.0.11/32")
+
+	testCases := []struct {
+		rule     FirewallRule
+		expected string
+	}{
+		{FirewallRule{Action: FirewallDrop}, "drop"},
+		{FirewallRule{Action: FirewallAccept, Protocol: FirewallProtocolTCP, Port: 22}, "tcp dport 22 accept"},
+		{FirewallRule{Action: FirewallAccept, Protocol: FirewallProtocolUDP}, "meta l4proto udp accept"},
+		{FirewallRule{Action: FirewallAccept, Port: 53}, "meta l4proto { tcp, udp } th dport 53 accept"},
+		{
+			FirewallRule{Action: FirewallDrop, Protocol: FirewallProtocolICMP, Sources: []*net.IPNet{peer1, peer2}},
+			"ip saddr { 100.64.0.10/32, 100.64.0.11/32 } meta l4proto icmp drop",
+		},
+	}
+	for _, testCase := range testCases {
+		if rule := nftablesRule(testCase.rule); rule != testCase.expected {
+			t.Errorf("expected rule %q, got %q", testCase.expected, rule)
+		}
+	}
+}
+
+func Test_FirewallRulesKernel(t *testing.T) {
+	if _, err := exec.LookPath("nft"); err != nil {
+		t.Skip("nft not found")
+	}
+
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+9)
+	wgIP := "10.99.99.29/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeKernel
+	err = iface.Create()
+	if err != nil {
+		t.Skipf("kernel Wireguard isn't available: %v", err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	_, peerNet, _ := net.ParseCIDR("10.99.99.30/32")
+	err = iface.SetFirewallRules([]FirewallRule{{Action: FirewallAccept, Protocol: FirewallProtocolTCP, Port: 22, Sources: []*net.IPNet{peerNet}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replacing the rules must not duplicate them
+	err = iface.SetFirewallRules([]FirewallRule{{Action: FirewallAccept, Protocol: FirewallProtocolTCP, Port: 443}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("nft", "list", "table", "ip", firewallName(ifaceName)).CombinedOutput()
+	if err != nil {
+		t.Fatalf("failed listing firewall rules: %v: %s", err, out)
+	}
+	rules := string(out)
+	if strings.Contains(rules, "dport 22") || strings.Count(rules, "dport 443") != 1 {
+		t.Errorf("expected only the replacing rule, got rules:\n%s", rules)
+	}
+
+	err = iface.RemoveFirewallRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exec.Command("nft", "list", "table", "ip", firewallName(ifaceName)).Run() == nil {
+		t.Error("expected the firewall table to be removed")
+	}
+}
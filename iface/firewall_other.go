@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+// setHostFirewallRules is not supported on this platform, the interface is filtered in the userspace mode only
+func (w *WGIface) setHostFirewallRules(rules []FirewallRule) error {
+	return ErrFirewallNotSupported
+}
+
+// removeHostFirewallRules is not supported on this platform
+func (w *WGIface) removeHostFirewallRules() error {
+	return ErrFirewallNotSupported
+}
@@ -0,0 +1,58 @@
+package iface
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// adapterGUIDPrefix is the first part of the GUIDs of the Windows adapters created by Netbird ("nbwg"),
+// it tells them apart from the other adapters when looking for the orphaned ones
+const adapterGUIDPrefix uint32 = 0x6e627767
+
+// adapterKind is the driver of a Windows adapter encoded in its GUID
+type adapterKind uint16
+
+const (
+	// adapterKindWintun is a Wintun adapter configured by the embedded wireguard-go
+	adapterKindWintun adapterKind = 1
+	// adapterKindWireguardNT is an adapter of the wireguard-nt kernel driver
+	adapterKindWireguardNT adapterKind = 2
+)
+
+// adapterGUID has the layout of a Windows GUID
+type adapterGUID struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// stableAdapterGUID derives the GUID of a Windows adapter from the interface name, so that the adapter re-created
+// on every start keeps its network profile instead of Windows adding a new one each time
+func stableAdapterGUID(name string, kind adapterKind) adapterGUID {
+	hash := sha256.Sum256([]byte("netbird-adapter:" + name))
+
+	guid := adapterGUID{
+		Data1: adapterGUIDPrefix,
+		Data2: uint16(kind),
+		// version 5 (name-based) in the high nibble
+		Data3: binary.BigEndian.Uint16(hash[0:2])&0x0fff | 0x5000,
+	}
+	copy(guid.Data4[:], hash[2:10])
+	// RFC 4122 variant
+	guid.Data4[0] = guid.Data4[0]&0x3f | 0x80
+
+	return guid
+}
+
+// isNetbirdAdapterGUID returns the kind of the adapter and true if the GUID has been derived by stableAdapterGUID
+func isNetbirdAdapterGUID(guid adapterGUID) (adapterKind, bool) {
+	if guid.Data1 != adapterGUIDPrefix {
+		return 0, false
+	}
+	kind := adapterKind(guid.Data2)
+	if kind != adapterKindWintun && kind != adapterKindWireguardNT {
+		return 0, false
+	}
+	return kind, true
+}
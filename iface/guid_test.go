@@ -0,0 +1,30 @@
+package iface
+
+import (
+	"testing"
+)
+
+func Test_StableAdapterGUID(t *testing.T) {
+	guid := stableAdapterGUID("wt0", adapterKindWintun)
+	if guid != stableAdapterGUID("wt0", adapterKindWintun) {
+		t.Fatal("expecting the GUID of the same interface to be stable")
+	}
+	if guid == stableAdapterGUID("wt1", adapterKindWintun) {
+		t.Error("expecting the interfaces with different names to have different GUIDs")
+	}
+	if guid == stableAdapterGUID("wt0", adapterKindWireguardNT) {
+		t.Error("expecting the adapters of different drivers to have different GUIDs")
+	}
+
+	kind, ok := isNetbirdAdapterGUID(guid)
+	if !ok || kind != adapterKindWintun {
+		t.Errorf("expecting a Netbird Wintun adapter GUID, got %v %v", kind, ok)
+	}
+	if guid.Data3>>12 != 5 || guid.Data4[0]&0xc0 != 0x80 {
+		t.Errorf("expecting a version 5 RFC 4122 GUID, got %+v", guid)
+	}
+
+	if _, ok = isNetbirdAdapterGUID(adapterGUID{Data1: 0x12345678, Data2: uint16(adapterKindWintun)}); ok {
+		t.Error("expecting a GUID without the Netbird prefix not to be recognized")
+	}
+}
@@ -12,6 +12,28 @@ const (
 	DefaultWgPort = 51820
 )
 
+// WGMode is the Wireguard implementation of the interface
+type WGMode string
+
+const (
+	// WGModeAuto uses the kernel Wireguard module if available and falls back to the userspace wireguard-go otherwise
+	WGModeAuto WGMode = "auto"
+	// WGModeKernel uses the kernel Wireguard module only (the wireguard-nt driver on Windows)
+	WGModeKernel WGMode = "kernel"
+	// WGModeUserspace uses the wireguard-go device bound to a TUN interface only
+	WGModeUserspace WGMode = "userspace"
+)
+
+// IsValid returns true if the mode is supported, an empty mode is WGModeAuto
+func (m WGMode) IsValid() bool {
+	switch m {
+	case "", WGModeAuto, WGModeKernel, WGModeUserspace:
+		return true
+	default:
+		return false
+	}
+}
+
 // WGIface represents a interface instance
 type WGIface struct {
 	Name      string
@@ -19,6 +41,17 @@ type WGIface struct {
 	MTU       int
 	Address   WGAddress
 	Interface NetInterface
+	// Mode forces the Wireguard implementation created by Create, WGModeAuto if not set
+	Mode WGMode
+	// activeMode is the Wireguard implementation of the created interface
+	activeMode WGMode
+	// routeMetric is the metric of the routes through the interface set by SetRouteMetric, the kernel adds the route
+	// to the interface network with the default metric again when the interface is readdressed on Linux
+	routeMetric uint32
+	// exitNodeGateway and exitNodeGatewayIndex are the gateway of the system and the index of its interface the bypass
+	// routes of an exit node are added through, recorded before the traffic has been routed through the exit node
+	exitNodeGateway      net.IP
+	exitNodeGatewayIndex int
 }
 
 // WGAddress Wireguard parsed address
@@ -73,6 +106,12 @@ func Exists(iface string) (*bool, error) {
 	return &exists, nil
 }
 
+// ActiveMode returns the Wireguard implementation of the created interface (kernel or userspace),
+// empty if it hasn't been created
+func (w *WGIface) ActiveMode() WGMode {
+	return w.activeMode
+}
+
 // parseAddress parse a string ("1.2.3.4/24") address to WG Address
 func parseAddress(address string) (WGAddress, error) {
 	ip, network, err := net.ParseCIDR(address)
@@ -1,15 +1,40 @@
 package iface
 
 import (
-	log "github.com/sirupsen/logrus"
+	"fmt"
 	"os/exec"
 )
 
 // Create Creates a new Wireguard interface, sets a given IP and brings it up.
+// There is no kernel Wireguard on darwin, the userspace one is used
 func (w *WGIface) Create() error {
+	if w.Mode == WGModeKernel {
+		return fmt.Errorf("kernel Wireguard isn't supported on darwin, use the %s or %s mode", WGModeAuto, WGModeUserspace)
+	}
 	return w.CreateWithUserspace()
 }
 
+// UpdateAddr replaces the address of the tunnel interface (e.g. "100.64.0.10/16") and the route to its network
+func (w *WGIface) UpdateAddr(newAddr string) error {
+	address, err := parseAddress(newAddr)
+	if err != nil {
+		return err
+	}
+
+	routeCmd := exec.Command("route", "delete", "-net", w.Address.Network.String(), "-interface", w.Name)
+	if out, err := routeCmd.CombinedOutput(); err != nil {
+		log.Debugf("removing route command \"%v\" failed with output %s and error: %v", routeCmd.String(), out, err)
+	}
+
+	w.Address = address
+	return w.assignAddr()
+}
+
+// RemovePreviousAddr does nothing on darwin, the previous address is replaced by UpdateAddr right away
+func (w *WGIface) RemovePreviousAddr() error {
+	return nil
+}
+
 // assignAddr Adds IP address to the tunnel interface and network route based on the range provided
 func (w *WGIface) assignAddr() error {
 	//mask,_ := w.Address.Network.Mask.Size()
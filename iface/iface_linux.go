@@ -4,10 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net"
 	"os"
 	"syscall"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 )
 
@@ -34,16 +34,35 @@ func WireguardModExists() bool {
 }
 
 // Create Creates a new Wireguard interface, sets a given IP and brings it up.
-// Will reuse an existing one.
+// Will reuse an existing one. Unless the mode is forced, the kernel Wireguard is tried first and the userspace one
+// is used if the module is missing or the kernel interface can't be created
 func (w *WGIface) Create() error {
+	switch w.Mode {
+	case WGModeKernel:
+		log.Info("using kernel WireGuard")
+		return w.CreateWithKernel()
+	case WGModeUserspace:
+		log.Info("using userspace WireGuard")
+		return w.CreateWithUserspace()
+	}
 
 	if WireguardModExists() {
 		log.Info("using kernel WireGuard")
-		return w.CreateWithKernel()
+		err := w.CreateWithKernel()
+		if err == nil {
+			return nil
+		}
+		log.Warnf("failed creating kernel Wireguard interface %s, falling back to userspace WireGuard: %v", w.Name, err)
+		// remove the partially configured kernel interface, so that the TUN interface can take its name
+		if delErr := netlink.LinkDel(newWGLink(w.Name)); delErr != nil {
+			log.Debugf("failed removing kernel Wireguard interface %s: %v", w.Name, delErr)
+		}
+		w.Interface = nil
 	} else {
 		log.Info("using userspace WireGuard")
-		return w.CreateWithUserspace()
 	}
+
+	return w.CreateWithUserspace()
 }
 
 // CreateWithKernel Creates a new Wireguard interface using kernel Wireguard module.
@@ -101,6 +120,70 @@ func (w *WGIface) CreateWithKernel() error {
 		return err
 	}
 
+	w.activeMode = WGModeKernel
+	return nil
+}
+
+// UpdateAddr replaces the address of the tunnel interface (e.g. "100.64.0.10/16"). The previous IP is kept as a host
+// address without the route to the network until RemovePreviousAddr, so the remote peers still sending to it aren't cut
+// off meanwhile. The interface keeps an address throughout, otherwise the kernel would remove the routes through it
+func (w *WGIface) UpdateAddr(newAddr string) error {
+	address, err := parseAddress(newAddr)
+	if err != nil {
+		return err
+	}
+
+	link := newWGLink(w.Name)
+	if w.Address.IP != nil {
+		previous := &netlink.Addr{IPNet: &net.IPNet{IP: w.Address.IP, Mask: net.CIDRMask(32, 32)}}
+		err = netlink.AddrAdd(link, previous)
+		if err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed keeping previous address %s of interface %s: %w", w.Address.IP, w.Name, err)
+		}
+		// the address with the route to the network is removed before the new one is added, otherwise the new address
+		// of the same network would become a secondary address removed along with it
+		err = removeAddrs(link, previous.IPNet)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Address = address
+	mask, _ := address.Network.Mask.Size()
+	log.Debugf("adding address %s/%d to interface: %s", address.IP, mask, w.Name)
+	err = netlink.AddrAdd(link, &netlink.Addr{IPNet: &net.IPNet{IP: address.IP, Mask: address.Network.Mask}})
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	if w.routeMetric != 0 {
+		return w.SetRouteMetric(w.routeMetric)
+	}
+	return nil
+}
+
+// RemovePreviousAddr removes the previous IP kept by UpdateAddr once the remote peers have learned the new address
+func (w *WGIface) RemovePreviousAddr() error {
+	return removeAddrs(newWGLink(w.Name), &net.IPNet{IP: w.Address.IP, Mask: w.Address.Network.Mask})
+}
+
+// removeAddrs removes the addresses of the link except the kept one
+func removeAddrs(link netlink.Link, keep *net.IPNet) error {
+	list, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		addr := list[i]
+		if addr.IP.Equal(keep.IP) && addr.Mask.String() == keep.Mask.String() {
+			continue
+		}
+		log.Debugf("removing address %s from interface: %s", addr.IPNet, link.Attrs().Name)
+		err = netlink.AddrDel(link, &addr)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,238 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_CreateUserspace(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+7)
+	wgIP := "10.99.99.25/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeUserspace
+
+	// the interface is re-created after it has been closed, e.g. when the engine restarts
+	for i := 0; i < 2; i++ {
+		err = iface.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iface.ActiveMode() != WGModeUserspace {
+			t.Fatalf("expecting the %s mode, got %s", WGModeUserspace, iface.ActiveMode())
+		}
+
+		link, err := netlink.LinkByName(ifaceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if link.Type() != "tuntap" {
+			t.Errorf("expecting a TUN interface, got %s", link.Type())
+		}
+
+		err = iface.Configure(key, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		port, err := iface.GetListenPort()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *port == 0 {
+			t.Error("expecting the userspace Wireguard to listen on a port")
+		}
+
+		err = iface.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = netlink.LinkByName(ifaceName); err == nil {
+			t.Fatal("expecting the TUN interface to be removed on close")
+		}
+		if _, err = os.Stat("/var/run/wireguard/" + ifaceName + ".sock"); !os.IsNotExist(err) {
+			t.Fatalf("expecting the UAPI socket to be removed on close, got %v", err)
+		}
+	}
+}
+
+func Test_CreateKernel(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+8)
+	wgIP := "10.99.99.29/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeKernel
+
+	err = iface.Create()
+	if !WireguardModExists() {
+		if err == nil {
+			_ = iface.Close()
+			t.Fatal("expecting the kernel mode to fail without the Wireguard module")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if iface.ActiveMode() != WGModeKernel {
+		t.Fatalf("expecting the %s mode, got %s", WGModeKernel, iface.ActiveMode())
+	}
+}
+
+func Test_UpdateAddr(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+11)
+	iface, err := NewWGIface(ifaceName, "10.99.98.10/24", DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeUserspace
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = iface.AddNetworkRoute("10.99.97.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := func() map[string]bool {
+		list, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]bool)
+		for _, addr := range list {
+			result[addr.IPNet.String()] = true
+		}
+		return result
+	}
+
+	// the network is resized and the peer gets an address of the new network
+	err = iface.UpdateAddr("10.99.96.20/22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(); !got["10.99.96.20/22"] || !got["10.99.98.10/32"] || len(got) != 2 {
+		t.Errorf("expecting the new address and the previous IP as a host address, got %v", got)
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Protocol: networkRouteProtocol},
+		netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Dst.String() != "10.99.97.0/24" {
+		t.Errorf("expecting the route through the interface to survive the readdressing, got %v", routes)
+	}
+
+	err = iface.RemovePreviousAddr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addrs(); !got["10.99.96.20/22"] || len(got) != 1 {
+		t.Errorf("expecting only the new address to be left, got %v", got)
+	}
+}
+
+func Test_SetRouteMetric(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+12)
+	iface, err := NewWGIface(ifaceName, "10.99.95.10/24", DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeUserspace
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priorities := func() map[string][]int {
+		list, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{LinkIndex: link.Attrs().Index},
+			netlink.RT_FILTER_OIF)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string][]int)
+		for _, route := range list {
+			if route.Dst != nil {
+				result[route.Dst.String()] = append(result[route.Dst.String()], route.Priority)
+			}
+		}
+		return result
+	}
+
+	// a network route added after the metric has been set gets it
+	err = iface.SetRouteMetric(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.AddNetworkRoute("10.99.94.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a route through an exit node with the default priority, the DefaultRouteHalves would route the traffic of the host
+	_, dst, _ := net.ParseCIDR("10.99.93.0/24")
+	err = netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Scope: netlink.SCOPE_LINK,
+		Protocol: exitNodeRouteProtocol})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := priorities()
+	if len(got["10.99.94.0/24"]) != 1 || got["10.99.94.0/24"][0] != 300 {
+		t.Errorf("expecting the network route to get the metric, got %v", got)
+	}
+
+	err = iface.SetRouteMetric(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = priorities()
+	for _, network := range []string{"10.99.95.0/24", "10.99.94.0/24", "10.99.93.0/24"} {
+		if len(got[network]) != 1 || got[network][0] != 200 {
+			t.Errorf("expecting a single route to %s with the new metric, got %v", network, got)
+		}
+	}
+
+	err = iface.RemoveNetworkRoute("10.99.94.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = priorities(); len(got["10.99.94.0/24"]) != 0 {
+		t.Errorf("expecting the network route to be removed, got %v", got)
+	}
+}
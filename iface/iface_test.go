@@ -2,10 +2,12 @@ package iface
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"net"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -21,7 +23,7 @@ var (
 )
 
 func init() {
-	log.SetLevel(log.DebugLevel)
+	log.Logger.SetLevel(logrus.DebugLevel)
 	privateKey, _ := wgtypes.GeneratePrivateKey()
 	key = privateKey.String()
 	peerPrivateKey, _ := wgtypes.GeneratePrivateKey()
@@ -190,6 +192,66 @@ func Test_UpdatePeer(t *testing.T) {
 	if !foundAllowedIP {
 		t.Fatal("configured peer with mismatched Allowed IPs")
 	}
+
+	stats, err := iface.GetPeerStats(peerPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.RxBytes != peer.ReceiveBytes || stats.TxBytes != peer.TransmitBytes {
+		t.Fatal("got mismatched peer transfer counters")
+	}
+}
+
+func Test_UpdatePeer_MultipleAllowedIPs(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+12)
+	wgIP := "10.99.99.17/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	port, err := iface.GetListenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Configure(key, *port)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tunnel IP of an exit node along with the default route and a network behind it
+	allowedIPs := []string{"10.99.99.18/32", "0.0.0.0/0", "192.168.10.0/24"}
+	err = iface.UpdatePeer(peerPubKey, strings.Join(allowedIPs, ","), 15*time.Second, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := getPeer(ifaceName, peerPubKey, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configured []string
+	for _, aip := range peer.AllowedIPs {
+		configured = append(configured, aip.String())
+	}
+	sort.Strings(configured)
+	sort.Strings(allowedIPs)
+	if strings.Join(configured, ",") != strings.Join(allowedIPs, ",") {
+		t.Fatalf("expecting allowed IPs %v, got %v", allowedIPs, configured)
+	}
+
+	err = iface.UpdatePeer(peerPubKey, "10.99.99.18/32,not-a-prefix", 15*time.Second, nil, nil)
+	if err == nil {
+		t.Fatal("expecting an invalid allowed IP to be rejected")
+	}
 }
 
 func Test_RemovePeer(t *testing.T) {
@@ -4,9 +4,6 @@
 package iface
 
 import (
-	log "github.com/sirupsen/logrus"
-	"golang.zx2c4.com/wireguard/conn"
-	"golang.zx2c4.com/wireguard/device"
 	"golang.zx2c4.com/wireguard/ipc"
 	"golang.zx2c4.com/wireguard/tun"
 	"net"
@@ -20,36 +17,25 @@ func (w *WGIface) CreateWithUserspace() error {
 		return err
 	}
 
-	w.Interface = tunIface
-
 	// We need to create a wireguard-go device and listen to configuration requests
-	tunDevice := device.NewDevice(tunIface, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, "[wiretrustee] "))
-	err = tunDevice.Up()
+	userspace := newUserspaceDevice(tunIface)
+	w.Interface = userspace
+
+	uapi, err := getUAPI(w.Name)
 	if err != nil {
 		return err
 	}
-	uapi, err := getUAPI(w.Name)
+	err = userspace.start(uapi)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		for {
-			uapiConn, uapiErr := uapi.Accept()
-			if uapiErr != nil {
-				log.Traceln("uapi Accept failed with error: ", uapiErr)
-				continue
-			}
-			go tunDevice.IpcHandle(uapiConn)
-		}
-	}()
-
-	log.Debugln("UAPI listener started")
-
 	err = w.assignAddr()
 	if err != nil {
 		return err
 	}
+
+	w.activeMode = WGModeUserspace
 	return nil
 }
 
@@ -2,18 +2,38 @@ package iface
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/sys/windows"
 	"golang.zx2c4.com/wireguard/windows/driver"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 	"net"
 )
 
+// luidAdapter is a created Windows adapter, either the wireguard-nt adapter or the Wintun device
+type luidAdapter interface {
+	LUID() winipcfg.LUID
+}
+
 // Create Creates a new Wireguard interface, sets a given IP and brings it up.
+// A Wintun adapter configured by the embedded wireguard-go is used unless the kernel mode is requested,
+// the adapters left behind by a previous run that didn't stop cleanly are removed first.
+// An in-memory adapter is created instead if UseMockAdapters is set
 func (w *WGIface) Create() error {
+	if mockAdaptersEnabled() {
+		return w.createMockAdapter()
+	}
 
-	WintunStaticRequestedGUID, _ := windows.GenerateGUID()
-	adapter, err := driver.CreateAdapter(w.Name, "WireGuard", &WintunStaticRequestedGUID)
+	removeOrphanedAdapters()
+
+	if w.Mode == WGModeKernel {
+		return w.CreateWithKernel()
+	}
+	return w.CreateWithUserspace()
+}
+
+// CreateWithKernel Creates a new Wireguard interface using the wireguard-nt kernel driver
+func (w *WGIface) CreateWithKernel() error {
+	guid := windows.GUID(stableAdapterGUID(w.Name, adapterKindWireguardNT))
+	adapter, err := driver.CreateAdapter(w.Name, "WireGuard", &guid)
 	if err != nil {
 		err = fmt.Errorf("error creating adapter: %w", err)
 		return err
@@ -24,9 +44,47 @@ func (w *WGIface) Create() error {
 	if err != nil {
 		return err
 	}
-	state, _ := luid.GUID()
-	log.Debugln("device guid: ", state.String())
-	return w.assignAddr(luid)
+	log.Debugln("device guid: ", guid.String())
+	err = w.configureAdapter(luid)
+	if err != nil {
+		return err
+	}
+
+	w.activeMode = WGModeKernel
+	return nil
+}
+
+// UpdateAddr replaces the address of the tunnel interface (e.g. "100.64.0.10/16")
+func (w *WGIface) UpdateAddr(newAddr string) error {
+	address, err := parseAddress(newAddr)
+	if err != nil {
+		return err
+	}
+	if w.isMockAdapter() {
+		w.Address = address
+		return nil
+	}
+
+	adapter, ok := w.Interface.(luidAdapter)
+	if !ok {
+		return fmt.Errorf("interface %s hasn't been created", w.Name)
+	}
+	w.Address = address
+	return w.assignAddr(adapter.LUID())
+}
+
+// RemovePreviousAddr does nothing on Windows, the previous address is replaced by UpdateAddr right away
+func (w *WGIface) RemovePreviousAddr() error {
+	return nil
+}
+
+// configureAdapter assigns the address and the MTU of the interface to the adapter
+func (w *WGIface) configureAdapter(luid winipcfg.LUID) error {
+	err := w.assignAddr(luid)
+	if err != nil {
+		return err
+	}
+	return w.setMTU(luid)
 }
 
 // assignAddr Adds IP address to the tunnel interface and network route based on the range provided
@@ -40,3 +98,19 @@ func (w *WGIface) assignAddr(luid winipcfg.LUID) error {
 
 	return nil
 }
+
+// setMTU sets the IPv4 MTU of the tunnel interface
+func (w *WGIface) setMTU(luid winipcfg.LUID) error {
+	ipInterface, err := luid.IPInterface(windows.AF_INET)
+	if err != nil {
+		return fmt.Errorf("error reading the IP interface of %s: %w", w.Name, err)
+	}
+	ipInterface.NLMTU = uint32(w.MTU)
+	// the site prefix length has to be 0 for IPv4 interfaces, Set fails otherwise
+	ipInterface.SitePrefixLength = 0
+	err = ipInterface.Set()
+	if err != nil {
+		return fmt.Errorf("error setting the MTU of %s: %w", w.Name, err)
+	}
+	return nil
+}
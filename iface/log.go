@@ -0,0 +1,6 @@
+package iface
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the Wireguard interface, its level is set by the iface component level
+var log = util.Logger("iface")
@@ -0,0 +1,117 @@
+package iface
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// mockAdapters is set by UseMockAdapters
+var mockAdapters int32
+
+// UseMockAdapters makes the interfaces created afterwards run the embedded wireguard-go on an in-memory TUN device
+// instead of a Wintun adapter, so the configuration is applied on the machines without the Wintun driver
+// (e.g. the Windows CI runners). The packets sent to a mock adapter are dropped and it receives none, its address
+// and MTU are kept by the WGIface only. The tests of the Engine use it, false restores the Wintun adapters
+func UseMockAdapters(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&mockAdapters, value)
+}
+
+func mockAdaptersEnabled() bool {
+	return atomic.LoadInt32(&mockAdapters) == 1
+}
+
+// mockAdapter is the wireguard-go device of an in-memory adapter, it has no LUID
+type mockAdapter struct {
+	*userspaceDevice
+}
+
+// createMockAdapter creates the interface on an in-memory TUN device configured over the UAPI of the embedded
+// wireguard-go like a Wintun adapter
+func (w *WGIface) createMockAdapter() error {
+	adapter := &mockAdapter{userspaceDevice: newUserspaceDevice(newMockTUN(w.Name, w.MTU))}
+	w.Interface = adapter
+
+	uapi, err := ipc.UAPIListen(w.Name)
+	if err != nil {
+		return err
+	}
+	err = adapter.start(uapi)
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("created mock adapter %s", w.Name)
+	w.activeMode = WGModeUserspace
+	return nil
+}
+
+// isMockAdapter returns true if the interface has been created on a mock adapter
+func (w *WGIface) isMockAdapter() bool {
+	_, ok := w.Interface.(*mockAdapter)
+	return ok
+}
+
+// mockTUN is an in-memory TUN device dropping the packets written to it, reading blocks until it is closed
+type mockTUN struct {
+	name      string
+	mtu       int
+	events    chan tun.Event
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMockTUN(name string, mtu int) *mockTUN {
+	events := make(chan tun.Event, 1)
+	events <- tun.EventUp
+	return &mockTUN{name: name, mtu: mtu, events: events, closed: make(chan struct{})}
+}
+
+func (t *mockTUN) File() *os.File {
+	return nil
+}
+
+func (t *mockTUN) Read([]byte, int) (int, error) {
+	<-t.closed
+	return 0, os.ErrClosed
+}
+
+func (t *mockTUN) Write(buf []byte, offset int) (int, error) {
+	select {
+	case <-t.closed:
+		return 0, os.ErrClosed
+	default:
+		return len(buf) - offset, nil
+	}
+}
+
+func (t *mockTUN) Flush() error {
+	return nil
+}
+
+func (t *mockTUN) MTU() (int, error) {
+	return t.mtu, nil
+}
+
+func (t *mockTUN) Name() (string, error) {
+	return t.name, nil
+}
+
+func (t *mockTUN) Events() chan tun.Event {
+	return t.events
+}
+
+func (t *mockTUN) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		close(t.events)
+	})
+	return nil
+}
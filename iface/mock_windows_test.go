@@ -0,0 +1,83 @@
+package iface
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_MockAdapter(t *testing.T) {
+	UseMockAdapters(true)
+	defer UseMockAdapters(false)
+
+	iface, err := NewWGIface(fmt.Sprintf("utun%d", WgIntNumber+40), "10.99.99.40/24", DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if !iface.isMockAdapter() || iface.ActiveMode() != WGModeUserspace {
+		t.Fatalf("expecting a mock adapter configured by the embedded wireguard-go, got %T in the %s mode", iface.Interface, iface.ActiveMode())
+	}
+
+	// the configuration is applied over the UAPI like on a Wintun adapter
+	err = iface.Configure(key, 33140)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := iface.GetListenPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *port != 33140 {
+		t.Errorf("expecting listen port 33140, got %d", *port)
+	}
+
+	presharedKey, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.UpdatePeer(peerPubKey, "10.99.99.41/32", 15*time.Second, nil, &presharedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := iface.GetStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats[peerPubKey]; !ok {
+		t.Errorf("expecting peer %s to be configured, got %v", peerPubKey, stats)
+	}
+	err = iface.RemovePeer(peerPubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the address, the metric and the routes of a mock adapter are kept in memory
+	err = iface.UpdateAddr("10.99.98.40/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Address.IP.String() != "10.99.98.40" || iface.Address.Network.String() != "10.99.98.0/24" {
+		t.Errorf("expecting the updated address 10.99.98.40/24, got %s %s", iface.Address.IP, iface.Address.Network)
+	}
+	err = iface.SetRouteMetric(10)
+	if err != nil {
+		t.Error(err)
+	}
+	routes, err := iface.Routes()
+	if err != nil || len(routes) != 0 {
+		t.Errorf("expecting no routes through a mock adapter, got %v %v", routes, err)
+	}
+}
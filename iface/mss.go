@@ -0,0 +1,134 @@
+package iface
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ipv4TCPHeadersLen is the size of the IPv4 and TCP headers without options the MSS excludes
+	ipv4TCPHeadersLen = 40
+	// minMSS is the smallest MSS an IPv4 host must accept (RFC 879)
+	minMSS = 536
+
+	tcpFlagSYN      = 0x02
+	tcpOptionEnd    = 0
+	tcpOptionNOP    = 1
+	tcpOptionMSS    = 2
+	tcpOptionMSSLen = 4
+)
+
+// ErrMSSClampNotSupported is returned by SetMSSClamp and RemoveMSSClamp on platforms where neither the userspace
+// packet filter nor the host firewall handle the kernel Wireguard interface (wireguard-nt on Windows)
+var ErrMSSClampNotSupported = errors.New("MSS clamping is supported in the userspace mode and on Linux only")
+
+// DefaultMSS returns the MSS of the TCP segments fitting the MTU of the interface, the MTU minus the IPv4 and TCP headers
+func DefaultMSS(mtu int) int {
+	return mtu - ipv4TCPHeadersLen
+}
+
+// ValidateMSS checks that the MSS is accepted by all the hosts and the segments fit the MTU of the interface
+func ValidateMSS(mss, mtu int) error {
+	if mss < minMSS || mss > DefaultMSS(mtu) {
+		return fmt.Errorf("MSS %d is out of range, use %d-%d", mss, minMSS, DefaultMSS(mtu))
+	}
+	return nil
+}
+
+// SetMSSClamp lowers the MSS advertised by the TCP connections entering and leaving the tunnel to the value,
+// so the peers send segments fitting the path MTU (e.g. a gateway peer behind PPPoE). The packet filter of
+// the wireguard-go device rewrites the SYN packets in the userspace mode, nftables or iptables in the kernel mode on Linux
+func (w *WGIface) SetMSSClamp(mss int) error {
+	if filter, ok := w.userspaceFilter(); ok {
+		filter.setMSS(uint16(mss))
+	} else {
+		err := w.setHostMSSClamp(mss)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("clamping TCP MSS of interface %s to %d", w.Name, mss)
+	return nil
+}
+
+// RemoveMSSClamp removes the clamping set by SetMSSClamp, if any
+func (w *WGIface) RemoveMSSClamp() error {
+	if filter, ok := w.userspaceFilter(); ok {
+		filter.setMSS(0)
+	} else {
+		err := w.removeHostMSSClamp()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("removed TCP MSS clamping of interface %s", w.Name)
+	return nil
+}
+
+// mssClampName is the name of the firewall table (nftables) or chain (iptables) clamping the MSS of the interface
+func mssClampName(ifaceName string) string {
+	return "netbird-mss-" + ifaceName
+}
+
+// clampMSS lowers the MSS option of an IPv4 TCP SYN packet to the value and updates the TCP checksum.
+// Returns false if the packet hasn't been changed
+func clampMSS(packet []byte, mss uint16) bool {
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != protocolTCP {
+		return false
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	// only the first fragment carries the transport header
+	if headerLen < 20 || binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+		return false
+	}
+	if len(packet) < headerLen+20 {
+		return false
+	}
+
+	segment := packet[headerLen:]
+	if segment[13]&tcpFlagSYN == 0 {
+		return false
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || len(segment) < dataOffset {
+		return false
+	}
+
+	options := segment[20:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case tcpOptionEnd:
+			return false
+		case tcpOptionNOP:
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return false
+		}
+		if options[i] == tcpOptionMSS && options[i+1] == tcpOptionMSSLen {
+			current := binary.BigEndian.Uint16(options[i+2 : i+4])
+			if current <= mss {
+				return false
+			}
+			binary.BigEndian.PutUint16(options[i+2:i+4], mss)
+			checksum := binary.BigEndian.Uint16(segment[16:18])
+			binary.BigEndian.PutUint16(segment[16:18], updateChecksum(checksum, current, mss))
+			return true
+		}
+		i += int(options[i+1])
+	}
+	return false
+}
+
+// updateChecksum updates an internet checksum after a 16-bit word of the checksummed data has changed (RFC 1624)
+func updateChecksum(checksum, oldWord, newWord uint16) uint16 {
+	sum := uint32(^checksum) + uint32(^oldWord) + uint32(newWord)
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,115 @@
+package iface
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// setHostMSSClamp replaces the MSS clamping of the interface. nftables is used if the nft command is available,
+// iptables otherwise. The SYN packets forwarded through the interface in both directions and the ones the host sends
+// through it are clamped
+func (w *WGIface) setHostMSSClamp(mss int) error {
+	var err error
+	if _, lookErr := exec.LookPath("nft"); lookErr == nil {
+		err = w.setNftablesMSSClamp(mss)
+	} else if _, lookErr := exec.LookPath("iptables-restore"); lookErr == nil {
+		err = w.setIptablesMSSClamp(mss)
+	} else {
+		return fmt.Errorf("neither nft nor iptables-restore found to clamp the MSS of interface %s", w.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed clamping MSS of interface %s: %v", w.Name, err)
+	}
+	return nil
+}
+
+// removeHostMSSClamp removes the rules installed by setHostMSSClamp, if any
+func (w *WGIface) removeHostMSSClamp() error {
+	name := mssClampName(w.Name)
+
+	if _, err := exec.LookPath("nft"); err == nil {
+		// adding the table first makes the deletion succeed if it doesn't exist
+		script := fmt.Sprintf("table ip %s\ndelete table ip %s\n", name, name)
+		if err := runWithStdin(script, "nft", "-f", "-"); err != nil {
+			return fmt.Errorf("failed removing MSS clamping of interface %s: %v", w.Name, err)
+		}
+	}
+
+	if _, err := exec.LookPath("iptables"); err == nil {
+		for _, jump := range mssClampJumps(w.Name) {
+			// the jump is deleted until none is left in case it was added more than once
+			for {
+				err = runIptables(append([]string{"-t", "mangle", "-D"}, jump...)...)
+				if err != nil {
+					break
+				}
+			}
+		}
+		// the chain doesn't exist if the clamping hasn't been installed with iptables
+		_ = runIptables("-t", "mangle", "-F", name)
+		_ = runIptables("-t", "mangle", "-X", name)
+	}
+	return nil
+}
+
+// setNftablesMSSClamp replaces the table of the interface in a single nftables transaction
+func (w *WGIface) setNftablesMSSClamp(mss int) error {
+	clamp := fmt.Sprintf("tcp flags & (syn|rst) == syn tcp option maxseg size > %[1]d tcp option maxseg size set %[1]d", mss)
+	script := fmt.Sprintf(`table ip %[1]s
+delete table ip %[1]s
+table ip %[1]s {
+	chain forward {
+		type filter hook forward priority -150; policy accept;
+		iifname "%[2]s" %[3]s
+		oifname "%[2]s" %[3]s
+	}
+	chain output {
+		type filter hook output priority -150; policy accept;
+		oifname "%[2]s" %[3]s
+	}
+}
+`, mssClampName(w.Name), w.Name, clamp)
+
+	return runWithStdin(script, "nft", "-f", "-")
+}
+
+// setIptablesMSSClamp replaces the rule of the chain of the interface with iptables-restore, which commits
+// the mangle table atomically, and jumps to the chain from the FORWARD and OUTPUT chains
+func (w *WGIface) setIptablesMSSClamp(mss int) error {
+	name := mssClampName(w.Name)
+
+	var rules strings.Builder
+	rules.WriteString("*mangle\n")
+	// declaring an existing chain flushes it
+	fmt.Fprintf(&rules, ":%s - [0:0]\n", name)
+	fmt.Fprintf(&rules, "-A %s -p tcp --tcp-flags SYN,RST SYN -m tcpmss --mss %d:65535 -j TCPMSS --set-mss %d\n",
+		name, mss+1, mss)
+	rules.WriteString("COMMIT\n")
+
+	err := runWithStdin(rules.String(), "iptables-restore", "--noflush")
+	if err != nil {
+		return err
+	}
+
+	for _, jump := range mssClampJumps(w.Name) {
+		if runIptables(append([]string{"-t", "mangle", "-C"}, jump...)...) == nil {
+			continue
+		}
+		err = runIptables(append([]string{"-t", "mangle", "-A"}, jump...)...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mssClampJumps are the rules jumping to the iptables chain clamping the MSS of the interface
+func mssClampJumps(ifaceName string) [][]string {
+	name := mssClampName(ifaceName)
+	return [][]string{
+		{"FORWARD", "-i", ifaceName, "-j", name},
+		{"FORWARD", "-o", ifaceName, "-j", name},
+		{"OUTPUT", "-o", ifaceName, "-j", name},
+	}
+}
@@ -0,0 +1,75 @@
+package iface
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_MSSClampKernel(t *testing.T) {
+	_, nftErr := exec.LookPath("nft")
+	_, iptablesErr := exec.LookPath("iptables-restore")
+	if nftErr != nil && iptablesErr != nil {
+		t.Skip("neither nft nor iptables-restore found")
+	}
+
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+10)
+	wgIP := "10.99.99.33/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface.Mode = WGModeKernel
+	err = iface.Create()
+	if err != nil {
+		t.Skipf("kernel Wireguard isn't available: %v", err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	err = iface.SetMSSClamp(1300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replacing the clamping must not duplicate the rules
+	err = iface.SetMSSClamp(DefaultMSS(DefaultMTU))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := listMSSClamp(t, ifaceName)
+	if strings.Contains(rules, "1300") || strings.Count(rules, fmt.Sprint(DefaultMSS(DefaultMTU))) == 0 {
+		t.Errorf("expected only the replacing MSS, got rules:\n%s", rules)
+	}
+
+	err = iface.RemoveMSSClamp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules := listMSSClamp(t, ifaceName); rules != "" {
+		t.Errorf("expected the MSS clamping to be removed, got rules:\n%s", rules)
+	}
+}
+
+// listMSSClamp returns the rules clamping the MSS of the interface, empty if there are none
+func listMSSClamp(t *testing.T, ifaceName string) string {
+	name := mssClampName(ifaceName)
+	if _, err := exec.LookPath("nft"); err == nil {
+		out, err := exec.Command("nft", "list", "table", "ip", name).CombinedOutput()
+		if err != nil {
+			return ""
+		}
+		return string(out)
+	}
+
+	out, err := exec.Command("iptables", "-w", "-t", "mangle", "-S", name).CombinedOutput()
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
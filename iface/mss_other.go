@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+// setHostMSSClamp is not supported on this platform, the MSS is clamped in the userspace mode only
+func (w *WGIface) setHostMSSClamp(mss int) error {
+	return ErrMSSClampNotSupported
+}
+
+// removeHostMSSClamp is not supported on this platform
+func (w *WGIface) removeHostMSSClamp() error {
+	return ErrMSSClampNotSupported
+}
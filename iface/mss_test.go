@@ -0,0 +1,84 @@
+package iface
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testSYNPacket builds an IPv4 TCP packet with the flags and the options, the TCP checksum covers the TCP header only
+func testSYNPacket(flags byte, options ...byte) []byte {
+	packet := testPacket(protocolTCP, "100.64.0.10", "100.64.0.1", 40000, 443)[:20]
+	segment := make([]byte, 20+len(options))
+	binary.BigEndian.PutUint16(segment[0:2], 40000)
+	binary.BigEndian.PutUint16(segment[2:4], 443)
+	segment[12] = byte(len(segment)/4) << 4
+	segment[13] = flags
+	copy(segment[20:], options)
+	binary.BigEndian.PutUint16(segment[16:18], testChecksum(segment))
+	return append(packet, segment...)
+}
+
+func testChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+func Test_ClampMSS(t *testing.T) {
+	mssOption := []byte{tcpOptionMSS, tcpOptionMSSLen, 0x05, 0xb4} // 1460
+	testCases := []struct {
+		name     string
+		packet   []byte
+		clamped  bool
+		expected uint16
+	}{
+		{"syn", testSYNPacket(tcpFlagSYN, mssOption...), true, 1240},
+		{"syn-ack after other options", testSYNPacket(tcpFlagSYN|0x10, append([]byte{tcpOptionNOP, tcpOptionNOP, 4, 2}, mssOption...)...), true, 1240},
+		{"lower mss is kept", testSYNPacket(tcpFlagSYN, tcpOptionMSS, tcpOptionMSSLen, 0x04, 0x00), false, 1024},
+		{"not a syn", testSYNPacket(0x10, mssOption...), false, 1460},
+		{"no mss option", testSYNPacket(tcpFlagSYN, tcpOptionNOP, tcpOptionNOP, tcpOptionNOP, tcpOptionEnd), false, 0},
+		{"truncated option", testSYNPacket(tcpFlagSYN, tcpOptionNOP, tcpOptionNOP, tcpOptionMSS, 8), false, 0},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			clamped := clampMSS(testCase.packet, 1240)
+			if clamped != testCase.clamped {
+				t.Fatalf("expected the packet to be clamped %t, got %t", testCase.clamped, clamped)
+			}
+
+			segment := testCase.packet[20:]
+			if testCase.expected != 0 {
+				mssAt := len(segment) - 2
+				if mss := binary.BigEndian.Uint16(segment[mssAt:]); mss != testCase.expected {
+					t.Errorf("expected MSS %d, got %d", testCase.expected, mss)
+				}
+			}
+			// the checksum of a valid segment including its checksum is 0
+			if checksum := testChecksum(segment); checksum != 0 {
+				t.Errorf("expected a valid checksum, got %#x", checksum)
+			}
+		})
+	}
+}
+
+func Test_PacketFilterClampsMSS(t *testing.T) {
+	filter := newPacketFilter()
+	packet := testSYNPacket(tcpFlagSYN, tcpOptionMSS, tcpOptionMSSLen, 0x05, 0xb4)
+
+	filter.clampMSS(packet)
+	if mss := binary.BigEndian.Uint16(packet[len(packet)-2:]); mss != 1460 {
+		t.Fatalf("expected the MSS not to be clamped before it is set, got %d", mss)
+	}
+
+	filter.setMSS(uint16(DefaultMSS(DefaultMTU)))
+	filter.clampMSS(packet)
+	if mss := binary.BigEndian.Uint16(packet[len(packet)-2:]); mss != 1240 {
+		t.Errorf("expected MSS %d, got %d", DefaultMSS(DefaultMTU), mss)
+	}
+}
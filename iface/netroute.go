@@ -0,0 +1,7 @@
+package iface
+
+import "errors"
+
+// ErrNetworkRoutesNotSupported is returned by AddNetworkRoute, RemoveNetworkRoute and RemoveNetworkRoutes on platforms
+// where the networks behind the routing peers can't be routed through the interface, currently supported on Linux only
+var ErrNetworkRoutesNotSupported = errors.New("network routes are supported on Linux only")
@@ -0,0 +1,76 @@
+package iface
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// networkRouteProtocol marks the routes added for the networks behind the routing peers, so they are found and removed
+// even if the client has been restarted meanwhile
+const networkRouteProtocol = 0x6f
+
+// AddNetworkRoute routes the network (e.g. 192.168.10.0/24) through the interface with the priority set by
+// SetRouteMetric. Wireguard sends the traffic to the peer the network is an allowed IP of
+func (w *WGIface) AddNetworkRoute(network string) error {
+	route, err := w.networkRoute(network)
+	if err != nil {
+		return err
+	}
+	err = netlink.RouteAdd(route)
+	if err != nil && !errors.Is(err, syscall.EEXIST) {
+		return fmt.Errorf("failed adding route to %s through interface %s: %v", network, w.Name, err)
+	}
+	return nil
+}
+
+// RemoveNetworkRoute removes the route to the network added by AddNetworkRoute
+func (w *WGIface) RemoveNetworkRoute(network string) error {
+	route, err := w.networkRoute(network)
+	if err != nil {
+		return err
+	}
+	err = netlink.RouteDel(route)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed removing route to %s through interface %s: %v", network, w.Name, err)
+	}
+	return nil
+}
+
+// RemoveNetworkRoutes removes all the routes added by AddNetworkRoute, including the ones left behind by a previous run
+func (w *WGIface) RemoveNetworkRoutes() error {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Protocol: networkRouteProtocol},
+		netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return err
+	}
+
+	for i := range routes {
+		err = netlink.RouteDel(&routes[i])
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed removing route to %s: %v", routes[i].Dst, err)
+		}
+	}
+	return nil
+}
+
+func (w *WGIface) networkRoute(network string) (*netlink.Route, error) {
+	_, dst, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, err
+	}
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       dst,
+		Scope:     netlink.SCOPE_LINK,
+		Protocol:  networkRouteProtocol,
+		Priority:  int(w.routeMetric),
+	}, nil
+}
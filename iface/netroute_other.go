@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+// AddNetworkRoute is not supported on this platform
+func (w *WGIface) AddNetworkRoute(network string) error {
+	return ErrNetworkRoutesNotSupported
+}
+
+// RemoveNetworkRoute is not supported on this platform
+func (w *WGIface) RemoveNetworkRoute(network string) error {
+	return ErrNetworkRoutesNotSupported
+}
+
+// RemoveNetworkRoutes is not supported on this platform
+func (w *WGIface) RemoveNetworkRoutes() error {
+	return ErrNetworkRoutesNotSupported
+}
@@ -0,0 +1,303 @@
+package iface
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const (
+	// connTrackTimeout is how long the packets of a connection initiated by the host are accepted
+	// after the last packet the host has sent on it
+	connTrackTimeout = 5 * time.Minute
+	// connTrackPurgeInterval is the minimal interval between two removals of the expired connections
+	connTrackPurgeInterval = time.Minute
+)
+
+const (
+	protocolICMP = 1
+	protocolTCP  = 6
+	protocolUDP  = 17
+
+	icmpEchoReply   = 0
+	icmpEchoRequest = 8
+)
+
+// packetInfo is the parsed IPv4 header of a packet and the ports (the echo identifier for ICMP) of its transport header
+type packetInfo struct {
+	protocol uint8
+	src      [4]byte
+	dst      [4]byte
+	// hasPorts indicates a TCP or UDP packet with the ports set, the non-first fragments have no ports
+	hasPorts bool
+	srcPort  uint16
+	dstPort  uint16
+	// hasEcho indicates an ICMP echo request or reply with the echo identifier set
+	hasEcho  bool
+	icmpType uint8
+	echoID   uint16
+}
+
+// connKey identifies a connection initiated by the host by the remote address and the ports on both sides,
+// the echo identifier is the local port of the ICMP echo requests
+type connKey struct {
+	protocol   uint8
+	remoteIP   [4]byte
+	remotePort uint16
+	localPort  uint16
+}
+
+// packetFilter applies the firewall rules to the packets the wireguard-go device writes to the TUN device,
+// i.e. the packets received from the remote peers. It tracks the connections of the packets the device reads from
+// the TUN device, i.e. sent by the host, to accept their replies, and clamps the MSS of the TCP SYN packets both ways
+type packetFilter struct {
+	mu    sync.RWMutex
+	rules []FirewallRule
+	// enabled is false until rules are set, all the packets are accepted then
+	enabled bool
+	// mss is the MSS the TCP SYN packets in both directions are clamped to, 0 if they aren't clamped
+	mss uint16
+	// capture is the packet capture the packets passing the filter are written to, nil if there is none
+	capture *PacketCapture
+
+	connsMu   sync.Mutex
+	conns     map[connKey]time.Time
+	lastPurge time.Time
+}
+
+func newPacketFilter() *packetFilter {
+	return &packetFilter{conns: map[connKey]time.Time{}}
+}
+
+// setRules replaces the rules, nil rules disable the filter
+func (f *packetFilter) setRules(rules []FirewallRule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+	f.enabled = rules != nil
+	if !f.enabled {
+		f.connsMu.Lock()
+		f.conns = map[connKey]time.Time{}
+		f.connsMu.Unlock()
+	}
+}
+
+// setMSS sets the MSS the TCP SYN packets are clamped to, 0 stops clamping
+func (f *packetFilter) setMSS(mss uint16) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mss = mss
+}
+
+// setCapture sets the packet capture the packets passing the filter in both directions are written to, nil stops
+// capturing
+func (f *packetFilter) setCapture(capture *PacketCapture) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.capture = capture
+}
+
+// capturePacket writes a packet to the packet capture, if any
+func (f *packetFilter) capturePacket(packet []byte) {
+	f.mu.RLock()
+	capture := f.capture
+	f.mu.RUnlock()
+	if capture != nil {
+		capture.writePacket(packet, time.Now())
+	}
+}
+
+// clampMSS lowers the MSS option of a TCP SYN packet to the configured MSS, if any
+func (f *packetFilter) clampMSS(packet []byte) {
+	f.mu.RLock()
+	mss := f.mss
+	f.mu.RUnlock()
+	if mss != 0 {
+		clampMSS(packet, mss)
+	}
+}
+
+// allowInbound returns true if a packet received from a remote peer is accepted. The first matching rule decides,
+// the packets no rule matches are dropped unless they belong to a connection initiated by the host.
+// Only IPv4 packets are filtered
+func (f *packetFilter) allowInbound(packet []byte) bool {
+	f.mu.RLock()
+	rules, enabled := f.rules, f.enabled
+	f.mu.RUnlock()
+	if !enabled {
+		return true
+	}
+
+	info, ok := parsePacket(packet)
+	if !ok {
+		return true
+	}
+
+	if f.isTracked(info) {
+		return true
+	}
+
+	for _, rule := range rules {
+		if rule.matches(info) {
+			return rule.Action == FirewallAccept
+		}
+	}
+	return false
+}
+
+// trackOutbound records the connection of a packet sent by the host to a remote peer
+func (f *packetFilter) trackOutbound(packet []byte) {
+	f.mu.RLock()
+	enabled := f.enabled
+	f.mu.RUnlock()
+	if !enabled {
+		return
+	}
+
+	info, ok := parsePacket(packet)
+	if !ok {
+		return
+	}
+
+	var key connKey
+	switch {
+	case info.hasPorts:
+		key = connKey{protocol: info.protocol, remoteIP: info.dst, remotePort: info.dstPort, localPort: info.srcPort}
+	case info.hasEcho && info.icmpType == icmpEchoRequest:
+		key = connKey{protocol: protocolICMP, remoteIP: info.dst, localPort: info.echoID}
+	default:
+		return
+	}
+
+	now := time.Now()
+	f.connsMu.Lock()
+	defer f.connsMu.Unlock()
+	f.conns[key] = now
+	if now.Sub(f.lastPurge) > connTrackPurgeInterval {
+		for k, lastSent := range f.conns {
+			if now.Sub(lastSent) > connTrackTimeout {
+				delete(f.conns, k)
+			}
+		}
+		f.lastPurge = now
+	}
+}
+
+// isTracked returns true if a packet received from a remote peer is a reply to a connection initiated by the host
+func (f *packetFilter) isTracked(info packetInfo) bool {
+	var key connKey
+	switch {
+	case info.hasPorts:
+		key = connKey{protocol: info.protocol, remoteIP: info.src, remotePort: info.srcPort, localPort: info.dstPort}
+	case info.hasEcho && info.icmpType == icmpEchoReply:
+		key = connKey{protocol: protocolICMP, remoteIP: info.src, localPort: info.echoID}
+	default:
+		return false
+	}
+
+	f.connsMu.Lock()
+	defer f.connsMu.Unlock()
+	lastSent, ok := f.conns[key]
+	return ok && time.Since(lastSent) <= connTrackTimeout
+}
+
+// matches returns true if the rule applies to the packet
+func (r FirewallRule) matches(info packetInfo) bool {
+	switch r.Protocol {
+	case FirewallProtocolTCP:
+		if info.protocol != protocolTCP {
+			return false
+		}
+	case FirewallProtocolUDP:
+		if info.protocol != protocolUDP {
+			return false
+		}
+	case FirewallProtocolICMP:
+		if info.protocol != protocolICMP {
+			return false
+		}
+	}
+
+	if r.Port != 0 && (!info.hasPorts || info.dstPort != r.Port) {
+		return false
+	}
+
+	if len(r.Sources) == 0 {
+		return true
+	}
+	for _, source := range r.Sources {
+		if source.Contains(info.src[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePacket parses an IPv4 packet, returns false for the other packets
+func parsePacket(packet []byte) (packetInfo, bool) {
+	var info packetInfo
+	if len(packet) < 20 || packet[0]>>4 != 4 {
+		return info, false
+	}
+	headerLen := int(packet[0]&0x0f) * 4
+	if headerLen < 20 || len(packet) < headerLen {
+		return info, false
+	}
+
+	info.protocol = packet[9]
+	copy(info.src[:], packet[12:16])
+	copy(info.dst[:], packet[16:20])
+
+	// only the first fragment carries the transport header
+	if binary.BigEndian.Uint16(packet[6:8])&0x1fff != 0 {
+		return info, true
+	}
+
+	transport := packet[headerLen:]
+	switch info.protocol {
+	case protocolTCP, protocolUDP:
+		if len(transport) >= 4 {
+			info.hasPorts = true
+			info.srcPort = binary.BigEndian.Uint16(transport[0:2])
+			info.dstPort = binary.BigEndian.Uint16(transport[2:4])
+		}
+	case protocolICMP:
+		if len(transport) >= 8 && (transport[0] == icmpEchoRequest || transport[0] == icmpEchoReply) {
+			info.hasEcho = true
+			info.icmpType = transport[0]
+			info.echoID = binary.BigEndian.Uint16(transport[4:6])
+		}
+	}
+	return info, true
+}
+
+// filteredTun is a TUN device passing the packets through the packet filter
+type filteredTun struct {
+	tun.Device
+	filter *packetFilter
+}
+
+// Read reads a packet sent by the host, tracks its connection, clamps its MSS and captures it
+func (t *filteredTun) Read(buf []byte, offset int) (int, error) {
+	n, err := t.Device.Read(buf, offset)
+	if err == nil && n > 0 {
+		t.filter.trackOutbound(buf[offset : offset+n])
+		t.filter.clampMSS(buf[offset : offset+n])
+		t.filter.capturePacket(buf[offset : offset+n])
+	}
+	return n, err
+}
+
+// Write writes a packet received from a remote peer with its MSS clamped if the packet filter accepts it.
+// The accepted packet is captured as the host receives it
+func (t *filteredTun) Write(buf []byte, offset int) (int, error) {
+	if !t.filter.allowInbound(buf[offset:]) {
+		// the dropped packet is reported as written, wireguard-go logs the failed writes otherwise
+		return len(buf) - offset, nil
+	}
+	t.filter.clampMSS(buf[offset:])
+	t.filter.capturePacket(buf[offset:])
+	return t.Device.Write(buf, offset)
+}
@@ -0,0 +1,105 @@
+package iface
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// testPacket builds an IPv4 packet with a transport header, ports are the echo type and identifier for ICMP
+func testPacket(protocol uint8, src, dst string, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = protocol
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	if protocol == protocolICMP {
+		packet[20] = byte(srcPort)
+		binary.BigEndian.PutUint16(packet[24:26], dstPort)
+		return packet
+	}
+	binary.BigEndian.PutUint16(packet[20:22], srcPort)
+	binary.BigEndian.PutUint16(packet[22:24], dstPort)
+	return packet
+}
+
+func Test_PacketFilterRules(t *testing.T) {
+	_, peer1, _ := net.ParseCIDR("100.64.0.10/32")
+	_, peer2, _ := net.ParseCIDR("100.64.0.11/32")
+	local := "100.64.0.1"
+
+	filter := newPacketFilter()
+	if !filter.allowInbound(testPacket(protocolTCP, "100.64.0.10", local, 40000, 22)) {
+		t.Fatal("expected all the packets to be accepted before rules are set")
+	}
+
+	filter.setRules([]FirewallRule{
+		{Action: FirewallDrop, Protocol: FirewallProtocolTCP, Port: 22, Sources: []*net.IPNet{peer2}},
+		{Action: FirewallAccept, Protocol: FirewallProtocolTCP, Port: 22},
+		{Action: FirewallAccept, Protocol: FirewallProtocolICMP, Sources: []*net.IPNet{peer1}},
+		{Action: FirewallAccept, Port: 53},
+	})
+
+	testCases := []struct {
+		name     string
+		packet   []byte
+		expected bool
+	}{
+		{"ssh from peer1", testPacket(protocolTCP, "100.64.0.10", local, 40000, 22), true},
+		{"ssh from peer2 is denied first", testPacket(protocolTCP, "100.64.0.11", local, 40000, 22), false},
+		{"http isn't allowed", testPacket(protocolTCP, "100.64.0.10", local, 40000, 80), false},
+		{"ping from peer1", testPacket(protocolICMP, "100.64.0.10", local, icmpEchoRequest, 1), true},
+		{"ping from peer2", testPacket(protocolICMP, "100.64.0.11", local, icmpEchoRequest, 1), false},
+		{"dns over udp for any protocol", testPacket(protocolUDP, "100.64.0.11", local, 40000, 53), true},
+		{"dns over tcp for any protocol", testPacket(protocolTCP, "100.64.0.11", local, 40000, 53), true},
+		{"non IPv4 packets aren't filtered", []byte{0x60, 0, 0, 0}, true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if allowed := filter.allowInbound(testCase.packet); allowed != testCase.expected {
+				t.Errorf("expected packet to be allowed %t, got %t", testCase.expected, allowed)
+			}
+		})
+	}
+
+	filter.setRules(nil)
+	if !filter.allowInbound(testPacket(protocolTCP, "100.64.0.10", local, 40000, 80)) {
+		t.Error("expected all the packets to be accepted after the rules are removed")
+	}
+}
+
+func Test_PacketFilterTracksOutboundConnections(t *testing.T) {
+	local := "100.64.0.1"
+	remote := "100.64.0.10"
+
+	filter := newPacketFilter()
+	filter.setRules([]FirewallRule{})
+
+	reply := testPacket(protocolTCP, remote, local, 443, 50000)
+	if filter.allowInbound(reply) {
+		t.Fatal("expected packet of an unknown connection to be dropped")
+	}
+
+	filter.trackOutbound(testPacket(protocolTCP, local, remote, 50000, 443))
+	if !filter.allowInbound(reply) {
+		t.Error("expected reply to a connection initiated by the host to be accepted")
+	}
+	if filter.allowInbound(testPacket(protocolTCP, remote, local, 443, 50001)) {
+		t.Error("expected packet to another local port to be dropped")
+	}
+	if filter.allowInbound(testPacket(protocolUDP, remote, local, 443, 50000)) {
+		t.Error("expected packet of another protocol to be dropped")
+	}
+
+	echoReply := testPacket(protocolICMP, remote, local, icmpEchoReply, 7)
+	if filter.allowInbound(echoReply) {
+		t.Fatal("expected echo reply without a request to be dropped")
+	}
+	filter.trackOutbound(testPacket(protocolICMP, local, remote, icmpEchoRequest, 7))
+	if !filter.allowInbound(echoReply) {
+		t.Error("expected echo reply to a request of the host to be accepted")
+	}
+	if filter.allowInbound(testPacket(protocolICMP, remote, local, icmpEchoRequest, 7)) {
+		t.Error("expected echo request of the remote peer to be dropped")
+	}
+}
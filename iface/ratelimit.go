@@ -0,0 +1,7 @@
+package iface
+
+import "errors"
+
+// ErrRateLimitNotSupported is returned by SetPeerRateLimit and RemovePeerRateLimit on platforms
+// where traffic shaping isn't implemented. Rate limiting is currently supported on Linux only.
+var ErrRateLimitNotSupported = errors.New("peer rate limiting is supported on Linux only")
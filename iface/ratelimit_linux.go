@@ -0,0 +1,258 @@
+package iface
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	// rateLimitQdiscMajor is the major number of the HTB qdisc handle the peer classes are attached to
+	rateLimitQdiscMajor = 0x1
+	// rateLimitDestinationOffset is the offset of the destination address in the IPv4 header matched by the filters
+	rateLimitDestinationOffset = 16
+)
+
+// SetPeerRateLimit limits the egress traffic of the interface towards peerIP to kbps kilobits per second.
+// An HTB qdisc gets attached to the interface with a class and an u32 destination filter per limited peer.
+// Traffic of the peers without a limit isn't classified and isn't shaped.
+func (w *WGIface) SetPeerRateLimit(peerIP net.IP, kbps uint64) error {
+	dstIP, err := rateLimitDestination(peerIP)
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return err
+	}
+
+	err = ensureRateLimitQdisc(link)
+	if err != nil {
+		return fmt.Errorf("failed adding rate limit qdisc to interface %s: %v", w.Name, err)
+	}
+
+	classIDs, err := rateLimitClassIDs(link)
+	if err != nil {
+		return err
+	}
+	classID, limited := classIDs[dstIP]
+	if !limited {
+		classID, err = allocateRateLimitClassID(link, classIDs)
+		if err != nil {
+			return fmt.Errorf("failed allocating rate limit class of peer %s on interface %s: %v", peerIP, w.Name, err)
+		}
+	}
+
+	class := netlink.NewHtbClass(netlink.ClassAttrs{
+		LinkIndex: link.Attrs().Index,
+		Parent:    netlink.MakeHandle(rateLimitQdiscMajor, 0),
+		Handle:    classID,
+	}, netlink.HtbClassAttrs{
+		Rate: kbps * 1000,
+	})
+	err = netlink.ClassReplace(class)
+	if err != nil {
+		return fmt.Errorf("failed setting rate limit of peer %s on interface %s: %v", peerIP, w.Name, err)
+	}
+
+	if limited {
+		// the class has just been updated with the new rate, the filter stays the same
+		return nil
+	}
+
+	filter := &netlink.U32{
+		FilterAttrs: netlink.FilterAttrs{
+			LinkIndex: link.Attrs().Index,
+			Parent:    netlink.MakeHandle(rateLimitQdiscMajor, 0),
+			Priority:  1,
+			Protocol:  syscall.ETH_P_IP,
+		},
+		ClassId: classID,
+		Sel: &netlink.TcU32Sel{
+			Flags: netlink.TC_U32_TERMINAL,
+			Keys: []netlink.TcU32Key{
+				{
+					Mask: 0xffffffff,
+					Val:  dstIP,
+					Off:  rateLimitDestinationOffset,
+				},
+			},
+		},
+	}
+	err = netlink.FilterAdd(filter)
+	if err != nil {
+		return fmt.Errorf("failed adding rate limit filter of peer %s on interface %s: %v", peerIP, w.Name, err)
+	}
+
+	log.Debugf("limited traffic of interface %s to peer %s to %d kbit/s", w.Name, peerIP, kbps)
+	return nil
+}
+
+// RemovePeerRateLimit removes the rate limit of the peerIP if it was set before.
+// The HTB qdisc gets removed from the interface together with the last limited peer
+func (w *WGIface) RemovePeerRateLimit(peerIP net.IP) error {
+	dstIP, err := rateLimitDestination(peerIP)
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return err
+	}
+
+	qdisc, err := findRateLimitQdisc(link)
+	if err != nil {
+		return err
+	}
+	if qdisc == nil {
+		return nil
+	}
+
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		return err
+	}
+	classID, limited := uint32(0), false
+	for _, filter := range filters {
+		if ip, ok := rateLimitFilterDestination(filter); ok && ip == dstIP {
+			classID, limited = filter.(*netlink.U32).ClassId, true
+			err = netlink.FilterDel(filter)
+			if err != nil {
+				return fmt.Errorf("failed removing rate limit filter of peer %s on interface %s: %v", peerIP, w.Name, err)
+			}
+		}
+	}
+	if !limited {
+		return nil
+	}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		return err
+	}
+
+	remaining := 0
+	for _, class := range classes {
+		if class.Attrs().Handle != classID {
+			remaining++
+			continue
+		}
+		err = netlink.ClassDel(class)
+		if err != nil {
+			return fmt.Errorf("failed removing rate limit of peer %s on interface %s: %v", peerIP, w.Name, err)
+		}
+	}
+
+	if remaining == 0 {
+		err = netlink.QdiscDel(qdisc)
+		if err != nil {
+			return fmt.Errorf("failed removing rate limit qdisc from interface %s: %v", w.Name, err)
+		}
+	}
+
+	log.Debugf("removed rate limit of interface %s to peer %s", w.Name, peerIP)
+	return nil
+}
+
+// rateLimitDestination returns the IP of the peer as a number to be matched by the u32 filter
+func rateLimitDestination(peerIP net.IP) (uint32, error) {
+	ip4 := peerIP.To4()
+	if ip4 == nil {
+		return 0, fmt.Errorf("only IPv4 peers can be rate limited, got %s", peerIP)
+	}
+	return binary.BigEndian.Uint32(ip4), nil
+}
+
+// rateLimitClassIDs maps the destinations of the limited peers to their HTB classes, read from the u32 filters
+// attached to the HTB qdisc of the link
+func rateLimitClassIDs(link netlink.Link) (map[uint32]uint32, error) {
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	classIDs := make(map[uint32]uint32)
+	for _, filter := range filters {
+		if dstIP, ok := rateLimitFilterDestination(filter); ok {
+			classIDs[dstIP] = filter.(*netlink.U32).ClassId
+		}
+	}
+	return classIDs, nil
+}
+
+// allocateRateLimitClassID returns the lowest HTB class of the qdisc that is neither assigned to a limited peer
+// nor attached to the qdisc
+func allocateRateLimitClassID(link netlink.Link, classIDs map[uint32]uint32) (uint32, error) {
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		return 0, err
+	}
+
+	used := make(map[uint32]struct{}, len(classIDs)+len(classes))
+	for _, classID := range classIDs {
+		used[classID] = struct{}{}
+	}
+	for _, class := range classes {
+		used[class.Attrs().Handle] = struct{}{}
+	}
+
+	for minor := uint32(1); minor <= 0xffff; minor++ {
+		classID := netlink.MakeHandle(rateLimitQdiscMajor, uint16(minor))
+		if _, ok := used[classID]; !ok {
+			return classID, nil
+		}
+	}
+	return 0, fmt.Errorf("all %d rate limit classes are in use", 0xffff)
+}
+
+// rateLimitFilterDestination returns the destination IP matched by a rate limit filter
+func rateLimitFilterDestination(filter netlink.Filter) (uint32, bool) {
+	u32, ok := filter.(*netlink.U32)
+	if !ok || u32.Sel == nil || len(u32.Sel.Keys) != 1 {
+		return 0, false
+	}
+	key := u32.Sel.Keys[0]
+	if key.Off != rateLimitDestinationOffset || key.Mask != 0xffffffff {
+		return 0, false
+	}
+	return key.Val, true
+}
+
+// ensureRateLimitQdisc attaches the HTB qdisc to the link replacing its root qdisc if it wasn't attached yet
+func ensureRateLimitQdisc(link netlink.Link) error {
+	qdisc, err := findRateLimitQdisc(link)
+	if err != nil {
+		return err
+	}
+	if qdisc != nil {
+		return nil
+	}
+
+	return netlink.QdiscReplace(netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: link.Attrs().Index,
+		Handle:    netlink.MakeHandle(rateLimitQdiscMajor, 0),
+		Parent:    netlink.HANDLE_ROOT,
+	}))
+}
+
+func findRateLimitQdisc(link netlink.Link) (netlink.Qdisc, error) {
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if qdisc.Type() == "htb" && attrs.Parent == netlink.HANDLE_ROOT &&
+			attrs.Handle == netlink.MakeHandle(rateLimitQdiscMajor, 0) {
+			return qdisc, nil
+		}
+	}
+
+	return nil, nil
+}
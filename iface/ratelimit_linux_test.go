@@ -0,0 +1,195 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func Test_PeerRateLimit(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+5)
+	wgIP := "10.99.99.21/30"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerIP := net.ParseIP("10.99.99.22")
+	err = iface.SetPeerRateLimit(peerIP, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classIDs, err := rateLimitClassIDs(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstIP, err := rateLimitDestination(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classID, ok := classIDs[dstIP]
+	if !ok {
+		t.Fatalf("expected peer %s to have a rate limit class, got %v", peerIP, classIDs)
+	}
+
+	// updating the rate must not add a second filter
+	err = iface.SetPeerRateLimit(peerIP, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 1 || classes[0].Attrs().Handle != classID {
+		t.Fatalf("expected a single rate limit class %d, got %v", classID, classes)
+	}
+	htbClass, ok := classes[0].(*netlink.HtbClass)
+	if !ok || htbClass.Rate != 2000*1000/8 {
+		t.Fatalf("rate limit class has mismatched rate %v", classes[0])
+	}
+
+	filters, err := netlink.FilterList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var peerFilters int
+	for _, filter := range filters {
+		if u32, ok := filter.(*netlink.U32); ok && u32.ClassId == classID {
+			peerFilters++
+		}
+	}
+	if peerFilters != 1 {
+		t.Fatalf("expected a single rate limit filter, got %d", peerFilters)
+	}
+
+	err = iface.RemovePeerRateLimit(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qdisc, err := findRateLimitQdisc(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qdisc != nil {
+		t.Fatal("rate limit qdisc should be removed together with the last limited peer")
+	}
+
+	// removing a limit that doesn't exist is a no-op
+	err = iface.RemovePeerRateLimit(peerIP)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_PeerRateLimit_DistinctClasses(t *testing.T) {
+	ifaceName := fmt.Sprintf("utun%d", WgIntNumber+13)
+	wgIP := "10.99.92.1/24"
+	iface, err := NewWGIface(ifaceName, wgIP, DefaultMTU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = iface.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the peers share the last two bytes of their IPs
+	first := net.ParseIP("10.99.92.2")
+	second := net.ParseIP("10.98.92.2")
+	err = iface.SetPeerRateLimit(first, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = iface.SetPeerRateLimit(second, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	classIDs, err := rateLimitClassIDs(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classIDs) != 2 {
+		t.Fatalf("expected a rate limit filter per peer, got %v", classIDs)
+	}
+	firstIP, _ := rateLimitDestination(first)
+	secondIP, _ := rateLimitDestination(second)
+	if classIDs[firstIP] == classIDs[secondIP] {
+		t.Fatalf("expected the peers to have distinct rate limit classes, got %d", classIDs[firstIP])
+	}
+
+	classes, err := netlink.ClassList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := make(map[uint32]uint64)
+	for _, class := range classes {
+		if htbClass, ok := class.(*netlink.HtbClass); ok {
+			rates[class.Attrs().Handle] = htbClass.Rate
+		}
+	}
+	if rates[classIDs[firstIP]] != 1000*1000/8 || rates[classIDs[secondIP]] != 2000*1000/8 {
+		t.Fatalf("rate limit classes have mismatched rates %v", rates)
+	}
+
+	err = iface.RemovePeerRateLimit(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	classIDs, err = rateLimitClassIDs(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := classIDs[secondIP]; !ok || len(classIDs) != 1 {
+		t.Fatalf("expected only the rate limit of peer %s to be kept, got %v", second, classIDs)
+	}
+	classes, err = netlink.ClassList(link, netlink.MakeHandle(rateLimitQdiscMajor, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(classes) != 1 || classes[0].Attrs().Handle != classIDs[secondIP] {
+		t.Fatalf("expected only the rate limit class of peer %s to be kept, got %v", second, classes)
+	}
+
+	err = iface.RemovePeerRateLimit(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qdisc, err := findRateLimitQdisc(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qdisc != nil {
+		t.Fatal("rate limit qdisc should be removed together with the last limited peer")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package iface
+
+import "net"
+
+// SetPeerRateLimit is not supported on this platform
+func (w *WGIface) SetPeerRateLimit(peerIP net.IP, kbps uint64) error {
+	return ErrRateLimitNotSupported
+}
+
+// RemovePeerRateLimit is not supported on this platform
+func (w *WGIface) RemovePeerRateLimit(peerIP net.IP) error {
+	return ErrRateLimitNotSupported
+}
@@ -0,0 +1,8 @@
+package iface
+
+import "errors"
+
+// ErrRouteMetricNotSupported is returned by SetRouteMetric on platforms where the routes have no metric.
+// Route metrics are currently supported on Linux and Windows only, macOS prefers the more specific routes
+// and among equal ones the first added
+var ErrRouteMetricNotSupported = errors.New("route metrics are supported on Linux and Windows only")
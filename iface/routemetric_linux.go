@@ -0,0 +1,85 @@
+package iface
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// SetRouteMetric sets the priority of the routes through the interface: the route to the interface network, the routes
+// to the networks behind the routing peers and the routes through an exit node. The kernel prefers the route with
+// the lowest priority among the routes to the same network, 0 is the priority of the route added along with the address.
+// The routes with the new priority are added before the previous ones are removed, so that the networks stay routed.
+// The routes added later get the priority as well
+func (w *WGIface) SetRouteMetric(metric uint32) error {
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return err
+	}
+
+	network := w.Address.Network
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       network,
+		Src:       w.Address.IP,
+		Scope:     netlink.SCOPE_LINK,
+		Priority:  int(metric),
+	}
+	err = netlink.RouteAdd(route)
+	if err != nil && !errors.Is(err, syscall.EEXIST) {
+		return fmt.Errorf("failed adding route to %s with metric %d: %v", network, metric, err)
+	}
+
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{LinkIndex: link.Attrs().Index, Dst: network},
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_DST)
+	if err != nil {
+		return err
+	}
+	for i := range routes {
+		if routes[i].Priority == int(metric) {
+			continue
+		}
+		err = netlink.RouteDel(&routes[i])
+		if err != nil {
+			return fmt.Errorf("failed removing route to %s with metric %d: %v", network, routes[i].Priority, err)
+		}
+	}
+
+	for _, protocol := range []int{networkRouteProtocol, exitNodeRouteProtocol} {
+		err = reprioritizeRoutes(link.Attrs().Index, protocol, metric)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.routeMetric = metric
+	return nil
+}
+
+// reprioritizeRoutes replaces the routes of the protocol through the interface with the routes of the priority.
+// The bypass routes of an exit node go through the gateway of the system and are left alone
+func reprioritizeRoutes(linkIndex int, protocol int, metric uint32) error {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{LinkIndex: linkIndex, Protocol: protocol},
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		return err
+	}
+	for i := range routes {
+		if routes[i].Priority == int(metric) {
+			continue
+		}
+		route := routes[i]
+		route.Priority = int(metric)
+		err = netlink.RouteAdd(&route)
+		if err != nil && !errors.Is(err, syscall.EEXIST) {
+			return fmt.Errorf("failed adding route to %s with metric %d: %v", route.Dst, metric, err)
+		}
+		err = netlink.RouteDel(&routes[i])
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("failed removing route to %s with metric %d: %v", routes[i].Dst, routes[i].Priority, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package iface
+
+// SetRouteMetric is not supported on this platform
+func (w *WGIface) SetRouteMetric(metric uint32) error {
+	return ErrRouteMetricNotSupported
+}
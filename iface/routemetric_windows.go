@@ -0,0 +1,33 @@
+package iface
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// SetRouteMetric sets the metric of the interface. Windows adds it to the metric of every route through the interface
+// and prefers the route with the lowest sum, 0 restores the automatic metric Windows derives from the link speed
+func (w *WGIface) SetRouteMetric(metric uint32) error {
+	if w.isMockAdapter() {
+		return nil
+	}
+	adapter, ok := w.Interface.(luidAdapter)
+	if !ok {
+		return fmt.Errorf("interface %s hasn't been created", w.Name)
+	}
+
+	ipInterface, err := adapter.LUID().IPInterface(windows.AF_INET)
+	if err != nil {
+		return fmt.Errorf("error reading the IP interface of %s: %w", w.Name, err)
+	}
+	ipInterface.UseAutomaticMetric = metric == 0
+	ipInterface.Metric = metric
+	// see setMTU
+	ipInterface.SitePrefixLength = 0
+	err = ipInterface.Set()
+	if err != nil {
+		return fmt.Errorf("error setting the metric of %s: %w", w.Name, err)
+	}
+	return nil
+}
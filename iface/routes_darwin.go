@@ -0,0 +1,51 @@
+package iface
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/route"
+)
+
+// Routes returns the destination networks of the IPv4 routes through the interface in the routing table,
+// the host routes cloned from them are skipped
+func (w *WGIface) Routes() ([]*net.IPNet, error) {
+	ifc, err := net.InterfaceByName(w.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	rib, err := route.FetchRIB(syscall.AF_INET, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*net.IPNet
+	for _, message := range messages {
+		m, ok := message.(*route.RouteMessage)
+		if !ok || m.Index != ifc.Index || m.Flags&syscall.RTF_UP == 0 || m.Flags&syscall.RTF_WASCLONED != 0 {
+			continue
+		}
+		if len(m.Addrs) <= syscall.RTAX_NETMASK {
+			continue
+		}
+		dst, ok := m.Addrs[syscall.RTAX_DST].(*route.Inet4Addr)
+		if !ok {
+			continue
+		}
+
+		ones := 0
+		if m.Flags&syscall.RTF_HOST != 0 {
+			ones = 32
+		} else if mask, ok := m.Addrs[syscall.RTAX_NETMASK].(*route.Inet4Addr); ok {
+			ones, _ = net.IPMask(mask.IP[:]).Size()
+		}
+		mask := net.CIDRMask(ones, 32)
+		networks = append(networks, &net.IPNet{IP: net.IP(dst.IP[:]).Mask(mask), Mask: mask})
+	}
+	return networks, nil
+}
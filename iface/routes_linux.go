@@ -0,0 +1,30 @@
+package iface
+
+import (
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Routes returns the destination networks of the IPv4 routes through the interface in the main routing table
+func (w *WGIface) Routes() ([]*net.IPNet, error) {
+	link, err := netlink.LinkByName(w.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make([]*net.IPNet, 0, len(routes))
+	for _, route := range routes {
+		if route.Dst == nil {
+			networks = append(networks, &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)})
+			continue
+		}
+		networks = append(networks, route.Dst)
+	}
+	return networks, nil
+}
@@ -0,0 +1,59 @@
+package iface
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// Routes returns the destination networks of the IPv4 routes through the interface in the forwarding table,
+// the multicast and the broadcast routes Windows adds to every interface and the route to the interface address
+// are skipped. A mock adapter has no routes
+func (w *WGIface) Routes() ([]*net.IPNet, error) {
+	if w.isMockAdapter() {
+		return nil, nil
+	}
+	adapter, ok := w.Interface.(luidAdapter)
+	if !ok {
+		return nil, fmt.Errorf("interface %s hasn't been created", w.Name)
+	}
+
+	rows, err := winipcfg.GetIPForwardTable2(windows.AF_INET)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*net.IPNet
+	for i := range rows {
+		if rows[i].InterfaceLUID != adapter.LUID() {
+			continue
+		}
+		network := rows[i].DestinationPrefix.IPNet()
+		ones, _ := network.Mask.Size()
+		if network.IP.IsMulticast() || (ones == 32 && w.localOrBroadcast(network.IP)) {
+			continue
+		}
+		networks = append(networks, &network)
+	}
+	return networks, nil
+}
+
+// localOrBroadcast returns true if ip is the address of the interface, the broadcast address of its network
+// or the limited broadcast address
+func (w *WGIface) localOrBroadcast(ip net.IP) bool {
+	if ip.Equal(net.IPv4bcast) || ip.Equal(w.Address.IP) {
+		return true
+	}
+	network := w.Address.Network
+	if network == nil || network.IP.To4() == nil {
+		return false
+	}
+	broadcast := make(net.IP, net.IPv4len)
+	mask := network.Mask[len(network.Mask)-net.IPv4len:]
+	for i, b := range network.IP.To4() {
+		broadcast[i] = b | ^mask[i]
+	}
+	return ip.Equal(broadcast)
+}
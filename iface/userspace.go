@@ -0,0 +1,79 @@
+package iface
+
+import (
+	"net"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// userspaceDevice is the wireguard-go device of the interface, the UAPI listener configuring it
+// and the packet filter of the TUN interface
+type userspaceDevice struct {
+	device    *device.Device
+	uapi      net.Listener
+	filter    *packetFilter
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newUserspaceDevice creates a wireguard-go device bound to the TUN interface, the device is down until it is started
+func newUserspaceDevice(tunIface tun.Device) *userspaceDevice {
+	filter := newPacketFilter()
+	filtered := &filteredTun{Device: tunIface, filter: filter}
+	return &userspaceDevice{
+		device: device.NewDevice(filtered, conn.NewDefaultBind(), device.NewLogger(device.LogLevelSilent, "[wiretrustee] ")),
+		filter: filter,
+		closed: make(chan struct{}),
+	}
+}
+
+// packetFilter returns the packet filter applying the firewall rules to the packets received from the remote peers
+func (d *userspaceDevice) packetFilter() *packetFilter {
+	return d.filter
+}
+
+// start brings the device up and serves the configuration requests of the UAPI listener until the device is closed
+func (d *userspaceDevice) start(uapi net.Listener) error {
+	d.uapi = uapi
+	err := d.device.Up()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			uapiConn, uapiErr := uapi.Accept()
+			if uapiErr != nil {
+				select {
+				case <-d.closed:
+					return
+				default:
+				}
+				log.Traceln("uapi Accept failed with error: ", uapiErr)
+				continue
+			}
+			go d.device.IpcHandle(uapiConn)
+		}
+	}()
+
+	log.Debugln("UAPI listener started")
+	return nil
+}
+
+// Close stops the UAPI listener and closes the device removing its TUN interface
+func (d *userspaceDevice) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+		if d.uapi != nil {
+			// the listener fails closing its socket watcher if the socket has been removed first, it is closed anyway
+			if err := d.uapi.Close(); err != nil {
+				log.Debugf("closing UAPI listener: %v", err)
+			}
+		}
+		d.device.Close()
+	})
+	return nil
+}
@@ -0,0 +1,103 @@
+package iface
+
+import (
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wintun"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/windows/driver"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// wintunDevice is the wireguard-go device of a Wintun adapter, closing it removes the adapter
+type wintunDevice struct {
+	*userspaceDevice
+	luid winipcfg.LUID
+}
+
+// LUID returns the LUID of the Wintun adapter
+func (d *wintunDevice) LUID() winipcfg.LUID {
+	return d.luid
+}
+
+// CreateWithUserspace Creates a new Wireguard interface on a Wintun adapter configured by the embedded wireguard-go
+func (w *WGIface) CreateWithUserspace() error {
+	guid := windows.GUID(stableAdapterGUID(w.Name, adapterKindWintun))
+	tunIface, err := tun.CreateTUNWithRequestedGUID(w.Name, &guid, w.MTU)
+	if err != nil {
+		return err
+	}
+
+	wintunDev := &wintunDevice{
+		userspaceDevice: newUserspaceDevice(tunIface),
+		luid:            winipcfg.LUID(tunIface.(*tun.NativeTun).LUID()),
+	}
+	w.Interface = wintunDev
+	log.Debugln("device guid: ", guid.String())
+
+	uapi, err := ipc.UAPIListen(w.Name)
+	if err != nil {
+		return err
+	}
+	err = wintunDev.start(uapi)
+	if err != nil {
+		return err
+	}
+
+	err = w.configureAdapter(wintunDev.luid)
+	if err != nil {
+		return err
+	}
+
+	w.activeMode = WGModeUserspace
+	return nil
+}
+
+// removeOrphanedAdapters removes the adapters with a Netbird GUID that are down, they have been left behind
+// by a previous run that crashed before closing its interface
+func removeOrphanedAdapters() {
+	adapters, err := winipcfg.GetAdaptersAddresses(windows.AF_UNSPEC, winipcfg.GAAFlagDefault)
+	if err != nil {
+		log.Debugf("failed listing adapters while looking for orphaned ones: %v", err)
+		return
+	}
+
+	for _, adapter := range adapters {
+		if adapter.OperStatus == winipcfg.IfOperStatusUp {
+			continue
+		}
+		guid, err := adapter.LUID.GUID()
+		if err != nil {
+			continue
+		}
+		kind, ok := isNetbirdAdapterGUID(adapterGUID(*guid))
+		if !ok {
+			continue
+		}
+
+		name := adapter.FriendlyName()
+		log.Infof("removing orphaned adapter %s %s", name, guid.String())
+		err = removeAdapter(name, guid, kind)
+		if err != nil {
+			log.Warnf("failed removing orphaned adapter %s: %v", name, err)
+		}
+	}
+}
+
+// removeAdapter takes the adapter over by creating it again with its name and GUID,
+// the drivers remove the adapters they have created when they are closed
+func removeAdapter(name string, guid *windows.GUID, kind adapterKind) error {
+	if kind == adapterKindWireguardNT {
+		adapter, err := driver.CreateAdapter(name, "WireGuard", guid)
+		if err != nil {
+			return err
+		}
+		return adapter.Close()
+	}
+
+	adapter, err := wintun.CreateAdapter(name, tun.WintunTunnelType, guid)
+	if err != nil {
+		return err
+	}
+	return adapter.Close()
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"io"
+	"time"
 
 	"github.com/netbirdio/netbird/client/system"
 	"github.com/netbirdio/netbird/management/proto"
@@ -12,7 +13,16 @@ type Client interface {
 	io.Closer
 	Sync(msgHandler func(msg *proto.SyncResponse) error) error
 	GetServerPublicKey() (*wgtypes.Key, error)
-	Register(serverKey wgtypes.Key, setupKey string, jwtToken string, sysInfo *system.Info) (*proto.LoginResponse, error)
+	Register(serverKey wgtypes.Key, setupKey string, jwtToken string, sysInfo *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error)
 	Login(serverKey wgtypes.Key, sysInfo *system.Info) (*proto.LoginResponse, error)
 	GetDeviceAuthorizationFlow(serverKey wgtypes.Key) (*proto.DeviceAuthorizationFlow, error)
+	StartDeviceAuth(serverKey wgtypes.Key) (*proto.StartDeviceAuthResponse, error)
+	PollDeviceAuth(serverKey wgtypes.Key, deviceCode string) (*proto.PollDeviceAuthResponse, error)
+	RegisterWithDeviceAuth(serverKey wgtypes.Key, deviceAuthToken string, sysInfo *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error)
+	GetTURNCredentials() (*proto.TURNCredentialsResponse, error)
+	ReplaceKey(serverKey wgtypes.Key, newKey wgtypes.Key) (*proto.ReplaceKeyResponse, error)
+	SendFeedback(feedback *proto.FeedbackRequest) error
+	StreamConnected() bool
+	StatusSince() time.Time
+	Reconnect()
 }
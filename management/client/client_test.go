@@ -2,7 +2,10 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"os"
 	"path/filepath"
 	"sync"
 	"testing"
@@ -15,21 +18,22 @@ import (
 	mgmtProto "github.com/netbirdio/netbird/management/proto"
 	mgmt "github.com/netbirdio/netbird/management/server"
 	"github.com/netbirdio/netbird/management/server/mock_server"
-	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/netbirdio/netbird/testutil"
 	"github.com/netbirdio/netbird/util"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/status"
 )
 
 const ValidKey = "A2C8E62B-38F5-4553-B31E-DD66C696CEBB"
 
-func startManagement(t *testing.T) (*grpc.Server, net.Listener) {
-	level, _ := log.ParseLevel("debug")
-	log.SetLevel(level)
+func startManagement(t *testing.T, opts ...grpc.ServerOption) (*grpc.Server, net.Listener) {
+	log.Logger.SetLevel(logrus.DebugLevel)
 
 	testDir := t.TempDir()
 
@@ -48,14 +52,14 @@ func startManagement(t *testing.T) (*grpc.Server, net.Listener) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(opts...)
 	store, err := mgmt.NewStore(config.Datadir)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	peersUpdateManager := mgmt.NewPeersUpdateManager()
-	accountManager, err := mgmt.BuildManager(store, peersUpdateManager, nil)
+	accountManager, err := mgmt.BuildManager(store, peersUpdateManager, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -186,7 +190,7 @@ func TestClient_LoginRegistered(t *testing.T) {
 		t.Error(err)
 	}
 	info := system.GetInfo(context.TODO())
-	resp, err := client.Register(*key, ValidKey, "", info)
+	resp, err := client.Register(*key, ValidKey, "", info, "", false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -196,6 +200,103 @@ func TestClient_LoginRegistered(t *testing.T) {
 	}
 }
 
+func TestClient_MutualTLS(t *testing.T) {
+	ca, err := testutil.NewTestCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCertFile, serverKeyFile, err := ca.IssueServerCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs, err := encryption.NewClientCAReloader(ca.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	caPEM, err := os.ReadFile(ca.CertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots.AppendCertsFromPEM(caPEM)
+
+	tlsConfig := clientCAs.RequireClientCertificates(&tls.Config{Certificates: []tls.Certificate{serverCert}})
+	unary, stream := mgmt.PeerCertificateInterceptors()
+	s, listener := startManagement(t, grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+	defer closeManagementSilently(s, listener)
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := net.JoinHostPort("localhost", port)
+
+	// register connects with the client certificate issued for the common name and registers the peer
+	register := func(commonName string, key wgtypes.Key) error {
+		clientTLSConfig := &tls.Config{RootCAs: roots}
+		if commonName != "" {
+			certFile, keyFile, err := ca.IssueClientCert(commonName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientCert, err := encryption.NewKeyPairReloader(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientTLSConfig.GetClientCertificate = clientCert.GetClientCertificate
+		}
+
+		client, err := NewClientWithTLS(context.Background(), addr, key, clientTLSConfig)
+		if err != nil {
+			return err
+		}
+		defer client.Close() //nolint
+
+		serverKey, err := client.GetServerPublicKey()
+		if err != nil {
+			return err
+		}
+		_, err = client.Register(*serverKey, ValidKey, "", system.GetInfo(context.TODO()), "", false)
+		return err
+	}
+
+	t.Run("without client certificate", func(t *testing.T) {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = register("", key)
+		assert.Error(t, err, "expecting the connection without a client certificate to be rejected")
+	})
+
+	t.Run("with certificate of another peer", func(t *testing.T) {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherKey, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = register(otherKey.PublicKey().String(), key)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err), "expecting the certificate of another peer to be rejected: %v", err)
+	})
+
+	t.Run("with certificate of the peer", func(t *testing.T) {
+		key, err := wgtypes.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = register(key.PublicKey().String(), key)
+		assert.NoError(t, err)
+	})
+}
+
 func TestClient_Sync(t *testing.T) {
 	testKey, err := wgtypes.GenerateKey()
 	if err != nil {
@@ -216,7 +317,7 @@ func TestClient_Sync(t *testing.T) {
 	}
 
 	info := system.GetInfo(context.TODO())
-	_, err = client.Register(*serverKey, ValidKey, "", info)
+	_, err = client.Register(*serverKey, ValidKey, "", info, "", false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -232,7 +333,7 @@ func TestClient_Sync(t *testing.T) {
 	}
 
 	info = system.GetInfo(context.TODO())
-	_, err = remoteClient.Register(*serverKey, ValidKey, "", info)
+	_, err = remoteClient.Register(*serverKey, ValidKey, "", info, "", false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -330,7 +431,7 @@ func Test_SystemMetaDataFromClient(t *testing.T) {
 	}
 
 	info := system.GetInfo(context.TODO())
-	_, err = testClient.Register(*key, ValidKey, "", info)
+	_, err = testClient.Register(*key, ValidKey, "", info, "", false)
 	if err != nil {
 		t.Errorf("error while trying to register client: %v", err)
 	}
@@ -394,3 +495,46 @@ func Test_GetDeviceAuthorizationFlow(t *testing.T) {
 	assert.Equal(t, expectedFlowInfo.Provider, flowInfo.Provider, "provider should match")
 	assert.Equal(t, expectedFlowInfo.ProviderConfig.ClientID, flowInfo.ProviderConfig.ClientID, "provider configured client ID should match")
 }
+
+func TestClient_ApplyRemotePeersDelta(t *testing.T) {
+	client := &GrpcClient{}
+
+	keys := func(networkMap *proto.NetworkMap) []string {
+		var keys []string
+		for _, peer := range networkMap.GetRemotePeers() {
+			keys = append(keys, peer.GetWgPubKey()+":"+peer.GetName())
+		}
+		return keys
+	}
+
+	// a delta without a complete network map received before can't be applied
+	err := client.applyRemotePeersDelta(&proto.NetworkMap{Serial: 2, RemotePeersDelta: true, BaseSerial: 1})
+	assert.Error(t, err)
+
+	full := &proto.NetworkMap{Serial: 1, RemotePeers: []*proto.RemotePeerConfig{
+		{WgPubKey: "a", Name: "a"}, {WgPubKey: "b", Name: "b"}, {WgPubKey: "c", Name: "c"},
+	}}
+	err = client.applyRemotePeersDelta(full)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a:a", "b:b", "c:c"}, keys(full))
+
+	delta := &proto.NetworkMap{Serial: 2, RemotePeersDelta: true, BaseSerial: 1, RemovedPeers: []string{"a"},
+		RemotePeers: []*proto.RemotePeerConfig{{WgPubKey: "d", Name: "d"}, {WgPubKey: "b", Name: "renamed"}}}
+	err = client.applyRemotePeersDelta(delta)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b:renamed", "c:c", "d:d"}, keys(delta))
+	assert.False(t, delta.GetRemotePeersDelta())
+	assert.False(t, delta.GetRemotePeersIsEmpty())
+
+	// every peer has been removed
+	empty := &proto.NetworkMap{Serial: 3, RemotePeersDelta: true, BaseSerial: 2, RemotePeersIsEmpty: true,
+		RemovedPeers: []string{"b", "c", "d"}}
+	err = client.applyRemotePeersDelta(empty)
+	assert.NoError(t, err)
+	assert.Empty(t, empty.GetRemotePeers())
+	assert.True(t, empty.GetRemotePeersIsEmpty())
+
+	// a delta to another serial than the last received one
+	err = client.applyRemotePeersDelta(&proto.NetworkMap{Serial: 5, RemotePeersDelta: true, BaseSerial: 4})
+	assert.Error(t, err)
+}
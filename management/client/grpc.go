@@ -7,13 +7,14 @@ import (
 	"google.golang.org/grpc/codes"
 	gstatus "google.golang.org/grpc/status"
 	"io"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	pb "github.com/golang/protobuf/proto" //nolint
 	"github.com/netbirdio/netbird/client/system"
 	"github.com/netbirdio/netbird/encryption"
 	"github.com/netbirdio/netbird/management/proto"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
@@ -26,28 +27,69 @@ type GrpcClient struct {
 	key        wgtypes.Key
 	realClient proto.ManagementServiceClient
 	ctx        context.Context
-	conn       *grpc.ClientConn
+	// cancel stops the retries of the Sync stream once the client is closed
+	cancel context.CancelFunc
+	conn   *grpc.ClientConn
+	// sessionToken and serial of the last Sync stream, sent on reconnect to resume the stream
+	sessionToken string
+	serial       uint64
+	// remotePeers of the last NetworkMap received on the session, the base of the remote peers deltas,
+	// remotePeersSerial is its serial
+	remotePeers       []*proto.RemotePeerConfig
+	remotePeersSerial uint64
+	hasRemotePeers    bool
+	// streamConnected indicates whether the Sync stream is connected, statusSince is the time it has changed last
+	streamConnected bool
+	statusSince     time.Time
+	// streamCancel cancels the context of the current Sync stream
+	streamCancel context.CancelFunc
+	mux          sync.Mutex
 }
 
 // NewClient creates a new client to Management service
 func NewClient(ctx context.Context, addr string, ourPrivateKey wgtypes.Key, tlsEnabled bool) (*GrpcClient, error) {
+	var tlsConfig *tls.Config
+	if tlsEnabled {
+		tlsConfig = &tls.Config{}
+	}
+	return NewClientWithTLS(ctx, addr, ourPrivateKey, tlsConfig)
+}
+
+// NewClientWithTLS creates a new client to Management service connected over TLS with the given config, or in plaintext
+// if it is nil. The config sets e.g. the client certificate of the services requiring mutual TLS
+func NewClientWithTLS(ctx context.Context, addr string, ourPrivateKey wgtypes.Key, tlsConfig *tls.Config) (*GrpcClient, error) {
+	return newClient(ctx, addr, ourPrivateKey, tlsConfig, true)
+}
+
+// NewLazyClientWithTLS creates a new client to Management service like NewClientWithTLS, but doesn't wait for
+// the connection. It is established in the background and the calls fail until then, e.g. while the client runs
+// from a bootstrap bundle with the service unreachable
+func NewLazyClientWithTLS(ctx context.Context, addr string, ourPrivateKey wgtypes.Key, tlsConfig *tls.Config) (*GrpcClient, error) {
+	return newClient(ctx, addr, ourPrivateKey, tlsConfig, false)
+}
+
+// newClient creates a new client to Management service, waiting up to 3 seconds for the connection if blocking
+func newClient(ctx context.Context, addr string, ourPrivateKey wgtypes.Key, tlsConfig *tls.Config, blocking bool) (*GrpcClient, error) {
 	transportOption := grpc.WithTransportCredentials(insecure.NewCredentials())
 
-	if tlsEnabled {
-		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	if tlsConfig != nil {
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
 	}
 
-	mgmCtx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
-	conn, err := grpc.DialContext(
-		mgmCtx,
-		addr,
+	opts := []grpc.DialOption{
 		transportOption,
-		grpc.WithBlock(),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:    15 * time.Second,
 			Timeout: 10 * time.Second,
-		}))
+		}),
+	}
+	if blocking {
+		opts = append(opts, grpc.WithBlock())
+	}
+
+	mgmCtx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+	conn, err := grpc.DialContext(mgmCtx, addr, opts...)
 	if err != nil {
 		log.Errorf("failed creating connection to Management Service %v", err)
 		return nil, err
@@ -55,16 +97,19 @@ func NewClient(ctx context.Context, addr string, ourPrivateKey wgtypes.Key, tlsE
 
 	realClient := proto.NewManagementServiceClient(conn)
 
+	clientCtx, clientCancel := context.WithCancel(ctx)
 	return &GrpcClient{
 		key:        ourPrivateKey,
 		realClient: realClient,
-		ctx:        ctx,
+		ctx:        clientCtx,
+		cancel:     clientCancel,
 		conn:       conn,
 	}, nil
 }
 
-// Close closes connection to the Management Service
+// Close closes connection to the Management Service, Sync returns
 func (c *GrpcClient) Close() error {
+	c.cancel()
 	return c.conn.Close()
 }
 
@@ -107,6 +152,7 @@ func (c *GrpcClient) Sync(msgHandler func(msg *proto.SyncResponse) error) error
 		}
 
 		stream, err := c.connectToStream(*serverPubKey)
+		defer c.cancelStream()
 		if err != nil {
 			log.Errorf("failed to open Management Service stream: %s", err)
 			return err
@@ -116,6 +162,7 @@ func (c *GrpcClient) Sync(msgHandler func(msg *proto.SyncResponse) error) error
 
 		// blocking until error
 		err = c.receiveEvents(stream, *serverPubKey, msgHandler)
+		c.setStreamConnected(false)
 		if err != nil {
 			if s, ok := gstatus.FromError(err); ok && (s.Code() == codes.InvalidArgument || s.Code() == codes.PermissionDenied) {
 				return backoff.Permanent(err)
@@ -137,7 +184,7 @@ func (c *GrpcClient) Sync(msgHandler func(msg *proto.SyncResponse) error) error
 }
 
 func (c *GrpcClient) connectToStream(serverPubKey wgtypes.Key) (proto.ManagementService_SyncClient, error) {
-	req := &proto.SyncRequest{}
+	req := &proto.SyncRequest{SessionToken: c.sessionToken, Serial: c.serial, RemotePeersDelta: true}
 
 	myPrivateKey := c.key
 	myPublicKey := myPrivateKey.PublicKey()
@@ -148,8 +195,30 @@ func (c *GrpcClient) connectToStream(serverPubKey wgtypes.Key) (proto.Management
 		return nil, err
 	}
 
+	streamCtx, cancel := context.WithCancel(c.ctx)
+	c.mux.Lock()
+	c.streamCancel = cancel
+	c.mux.Unlock()
+
 	syncReq := &proto.EncryptedMessage{WgPubKey: myPublicKey.String(), Body: encryptedReq}
-	return c.realClient.Sync(c.ctx, syncReq)
+	return c.realClient.Sync(streamCtx, syncReq)
+}
+
+// Reconnect closes the current Sync stream, so that Sync reconnects to the Management Service right away
+// (e.g. after a network change) instead of waiting for the keepalive to detect a broken connection
+func (c *GrpcClient) Reconnect() {
+	c.conn.ResetConnectBackoff()
+	c.cancelStream()
+}
+
+// cancelStream cancels the context of the current Sync stream if there is one
+func (c *GrpcClient) cancelStream() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.streamCancel != nil {
+		c.streamCancel()
+		c.streamCancel = nil
+	}
 }
 
 func (c *GrpcClient) receiveEvents(stream proto.ManagementService_SyncClient, serverPubKey wgtypes.Key, msgHandler func(msg *proto.SyncResponse) error) error {
@@ -165,6 +234,7 @@ func (c *GrpcClient) receiveEvents(stream proto.ManagementService_SyncClient, se
 		}
 
 		log.Debugf("got an update message from Management Service")
+		c.setStreamConnected(true)
 		decryptedResp := &proto.SyncResponse{}
 		err = encryption.DecryptMessage(serverPubKey, c.key, update.Body, decryptedResp)
 		if err != nil {
@@ -172,11 +242,116 @@ func (c *GrpcClient) receiveEvents(stream proto.ManagementService_SyncClient, se
 			return err
 		}
 
+		// a new session starts with a complete NetworkMap
+		if decryptedResp.GetSessionToken() != "" && decryptedResp.GetSessionToken() != c.sessionToken {
+			c.sessionToken = decryptedResp.GetSessionToken()
+			c.serial = 0
+			c.hasRemotePeers = false
+		}
+
+		err = c.applyRemotePeersDelta(decryptedResp.GetNetworkMap())
+		if err != nil {
+			// the next stream starts a new session with a complete NetworkMap
+			c.sessionToken = ""
+			c.serial = 0
+			c.hasRemotePeers = false
+			log.Errorf("failed applying the remote peers received from Management Service: %v", err)
+			return err
+		}
+		// the Management Service sends them in the NetworkMap only to the clients applying the deltas
+		if networkMap := decryptedResp.GetNetworkMap(); networkMap != nil {
+			decryptedResp.PeerConfig = networkMap.GetPeerConfig()
+			decryptedResp.RemotePeers = networkMap.GetRemotePeers()
+			decryptedResp.RemotePeersIsEmpty = networkMap.GetRemotePeersIsEmpty()
+		}
+
 		err = msgHandler(decryptedResp)
 		if err != nil {
 			log.Errorf("failed handling an update message received from Management Service: %v", err.Error())
 			return err
 		}
+
+		// only the updates applied by the handler are acknowledged on resuming the stream
+		if decryptedResp.GetNetworkMap().GetSerial() > c.serial {
+			c.serial = decryptedResp.GetNetworkMap().GetSerial()
+		}
+	}
+}
+
+// applyRemotePeersDelta replaces the remote peers delta of the networkMap, see NetworkMap.remotePeersDelta,
+// with the complete remote peers and records them as the base of the next delta
+func (c *GrpcClient) applyRemotePeersDelta(networkMap *proto.NetworkMap) error {
+	if networkMap == nil {
+		return nil
+	}
+
+	if networkMap.GetRemotePeersDelta() {
+		if !c.hasRemotePeers || networkMap.GetBaseSerial() != c.remotePeersSerial {
+			return fmt.Errorf("received the remote peers delta to serial %d, the last received serial is %d",
+				networkMap.GetBaseSerial(), c.remotePeersSerial)
+		}
+
+		changed := make(map[string]*proto.RemotePeerConfig, len(networkMap.GetRemotePeers()))
+		for _, peer := range networkMap.GetRemotePeers() {
+			changed[peer.GetWgPubKey()] = peer
+		}
+		removed := make(map[string]struct{}, len(networkMap.GetRemovedPeers()))
+		for _, key := range networkMap.GetRemovedPeers() {
+			removed[key] = struct{}{}
+		}
+
+		var peers []*proto.RemotePeerConfig
+		for _, peer := range c.remotePeers {
+			key := peer.GetWgPubKey()
+			if _, ok := removed[key]; ok {
+				continue
+			}
+			if update, ok := changed[key]; ok {
+				peer = update
+				delete(changed, key)
+			}
+			peers = append(peers, peer)
+		}
+		// the added peers in the order they have been sent
+		for _, peer := range networkMap.GetRemotePeers() {
+			if _, ok := changed[peer.GetWgPubKey()]; ok {
+				peers = append(peers, peer)
+			}
+		}
+
+		networkMap.RemotePeers = peers
+		networkMap.RemotePeersIsEmpty = len(peers) == 0
+		networkMap.RemotePeersDelta = false
+		networkMap.BaseSerial = 0
+		networkMap.RemovedPeers = nil
+	}
+
+	c.remotePeers = networkMap.GetRemotePeers()
+	c.remotePeersSerial = networkMap.GetSerial()
+	c.hasRemotePeers = true
+	return nil
+}
+
+// StreamConnected indicates whether the client has received a message over the current Sync stream
+func (c *GrpcClient) StreamConnected() bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.streamConnected
+}
+
+// StatusSince returns the time the Sync stream has connected or disconnected, zero if it hasn't connected yet
+func (c *GrpcClient) StatusSince() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.statusSince
+}
+
+func (c *GrpcClient) setStreamConnected(connected bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.streamConnected != connected {
+		c.streamConnected = connected
+		c.statusSince = time.Now()
 	}
 }
 
@@ -233,8 +408,16 @@ func (c *GrpcClient) login(serverKey wgtypes.Key, req *proto.LoginRequest) (*pro
 // Register registers peer on Management Server. It actually calls a Login endpoint with a provided setup key
 // Takes care of encrypting and decrypting messages.
 // This method will also collect system info and send it with the request (e.g. hostname, os, etc)
-func (c *GrpcClient) Register(serverKey wgtypes.Key, setupKey string, jwtToken string, sysInfo *system.Info) (*proto.LoginResponse, error) {
-	return c.login(serverKey, &proto.LoginRequest{SetupKey: setupKey, Meta: infoToMetaData(sysInfo), JwtToken: jwtToken})
+// The requestedIP is an optional IP of the account network the peer asks to get assigned.
+// With reRegister an already registered peer registers again, the Management Service reuses the existing peer
+func (c *GrpcClient) Register(serverKey wgtypes.Key, setupKey string, jwtToken string, sysInfo *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error) {
+	return c.login(serverKey, &proto.LoginRequest{SetupKey: setupKey, Meta: infoToMetaData(sysInfo), JwtToken: jwtToken, RequestedIp: requestedIP, ReRegister: reRegister})
+}
+
+// RegisterWithDeviceAuth registers peer on Management Server with the token of an approved device authorization
+// (see StartDeviceAuth). Takes care of encrypting and decrypting messages.
+func (c *GrpcClient) RegisterWithDeviceAuth(serverKey wgtypes.Key, deviceAuthToken string, sysInfo *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error) {
+	return c.login(serverKey, &proto.LoginRequest{DeviceAuthToken: deviceAuthToken, Meta: infoToMetaData(sysInfo), RequestedIp: requestedIP, ReRegister: reRegister})
 }
 
 // Login attempts login to Management Server. Takes care of encrypting and decrypting messages.
@@ -276,6 +459,181 @@ func (c *GrpcClient) GetDeviceAuthorizationFlow(serverKey wgtypes.Key) (*proto.D
 	return flowInfoResp, nil
 }
 
+// StartDeviceAuth starts a device authorization of the peer. The user approves the returned user code in the dashboard
+// while the peer polls PollDeviceAuth. Takes care of encrypting and decrypting messages.
+func (c *GrpcClient) StartDeviceAuth(serverKey wgtypes.Key) (*proto.StartDeviceAuthResponse, error) {
+	resp := &proto.StartDeviceAuthResponse{}
+	err := c.deviceAuthCall(serverKey, c.realClient.StartDeviceAuth, &proto.StartDeviceAuthRequest{}, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// PollDeviceAuth returns the registration token of the peer once the device authorization has been approved,
+// the token is empty while the authorization is pending. Takes care of encrypting and decrypting messages.
+func (c *GrpcClient) PollDeviceAuth(serverKey wgtypes.Key, deviceCode string) (*proto.PollDeviceAuthResponse, error) {
+	resp := &proto.PollDeviceAuthResponse{}
+	err := c.deviceAuthCall(serverKey, c.realClient.PollDeviceAuth, &proto.PollDeviceAuthRequest{DeviceCode: deviceCode}, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// GetTURNCredentials requests new credentials of the TURN servers, e.g. when the current ones are about to expire
+// and the Management Service hasn't pushed new ones over the Sync stream. Takes care of encrypting and decrypting messages.
+func (c *GrpcClient) GetTURNCredentials() (*proto.TURNCredentialsResponse, error) {
+	if !c.ready() {
+		return nil, fmt.Errorf("no connection to management in order to get TURN credentials")
+	}
+
+	serverKey, err := c.GetServerPublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedMSG, err := encryption.EncryptMessage(*serverKey, c.key, &proto.TURNCredentialsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	mgmCtx, cancel := context.WithTimeout(c.ctx, time.Second*2)
+	defer cancel()
+	resp, err := c.realClient.GetTURNCredentials(mgmCtx, &proto.EncryptedMessage{
+		WgPubKey: c.key.PublicKey().String(),
+		Body:     encryptedMSG,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	credentialsResp := &proto.TURNCredentialsResponse{}
+	err = encryption.DecryptMessage(*serverKey, c.key, resp.Body, credentialsResp)
+	if err != nil {
+		errWithMSG := fmt.Errorf("failed to decrypt TURN credentials message: %s", err)
+		log.Error(errWithMSG)
+		return nil, errWithMSG
+	}
+
+	return credentialsResp, nil
+}
+
+// ReplaceKey replaces the Wireguard key of the peer on the Management Service with the newKey keeping its IP, name and
+// groups. The request is sent with the current key of the client, which can't be used with the Management Service anymore
+// once the request succeeds
+func (c *GrpcClient) ReplaceKey(serverKey wgtypes.Key, newKey wgtypes.Key) (*proto.ReplaceKeyResponse, error) {
+	if !c.ready() {
+		return nil, fmt.Errorf("no connection to management in order to replace the key")
+	}
+
+	// proves the Management Service that the client holds the new private key
+	proof, err := encryption.Encrypt([]byte(c.key.PublicKey().String()), serverKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedMSG, err := encryption.EncryptMessage(serverKey, c.key, &proto.ReplaceKeyRequest{
+		NewWgPubKey: newKey.PublicKey().String(),
+		NewKeyProof: proof,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	mgmCtx, cancel := context.WithTimeout(c.ctx, time.Second*2)
+	defer cancel()
+	resp, err := c.realClient.ReplaceKey(mgmCtx, &proto.EncryptedMessage{
+		WgPubKey: c.key.PublicKey().String(),
+		Body:     encryptedMSG,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	replaceResp := &proto.ReplaceKeyResponse{}
+	err = encryption.DecryptMessage(serverKey, newKey, resp.Body, replaceResp)
+	if err != nil {
+		errWithMSG := fmt.Errorf("failed to decrypt replace key message: %s", err)
+		log.Error(errWithMSG)
+		return nil, errWithMSG
+	}
+
+	return replaceResp, nil
+}
+
+// SendFeedback reports the problems the client has detected in its NetworkMap to the Management Service
+func (c *GrpcClient) SendFeedback(feedback *proto.FeedbackRequest) error {
+	if !c.ready() {
+		return fmt.Errorf("no connection to management in order to send the feedback")
+	}
+
+	serverKey, err := c.GetServerPublicKey()
+	if err != nil {
+		return err
+	}
+
+	encryptedMSG, err := encryption.EncryptMessage(*serverKey, c.key, feedback)
+	if err != nil {
+		return err
+	}
+
+	mgmCtx, cancel := context.WithTimeout(c.ctx, time.Second*2)
+	defer cancel()
+	resp, err := c.realClient.SendFeedback(mgmCtx, &proto.EncryptedMessage{
+		WgPubKey: c.key.PublicKey().String(),
+		Body:     encryptedMSG,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = encryption.DecryptMessage(*serverKey, c.key, resp.Body, &proto.FeedbackResponse{})
+	if err != nil {
+		errWithMSG := fmt.Errorf("failed to decrypt feedback message: %s", err)
+		log.Error(errWithMSG)
+		return errWithMSG
+	}
+
+	return nil
+}
+
+// deviceAuthCall encrypts the request, calls a device authorization endpoint and decrypts its response into resp
+func (c *GrpcClient) deviceAuthCall(
+	serverKey wgtypes.Key,
+	call func(ctx context.Context, in *proto.EncryptedMessage, opts ...grpc.CallOption) (*proto.EncryptedMessage, error),
+	req pb.Message,
+	resp pb.Message,
+) error {
+	if !c.ready() {
+		return fmt.Errorf("no connection to management in order to authorize the device")
+	}
+	mgmCtx, cancel := context.WithTimeout(c.ctx, time.Second*2)
+	defer cancel()
+
+	encryptedMSG, err := encryption.EncryptMessage(serverKey, c.key, req)
+	if err != nil {
+		return err
+	}
+
+	encryptedResp, err := call(mgmCtx, &proto.EncryptedMessage{
+		WgPubKey: c.key.PublicKey().String(),
+		Body:     encryptedMSG,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = encryption.DecryptMessage(serverKey, c.key, encryptedResp.Body, resp)
+	if err != nil {
+		errWithMSG := fmt.Errorf("failed to decrypt device authorization message: %s", err)
+		log.Error(errWithMSG)
+		return errWithMSG
+	}
+
+	return nil
+}
+
 func infoToMetaData(info *system.Info) *proto.PeerSystemMeta {
 	if info == nil {
 		return nil
@@ -289,5 +647,10 @@ func infoToMetaData(info *system.Info) *proto.PeerSystemMeta {
 		Kernel:             info.Kernel,
 		WiretrusteeVersion: info.WiretrusteeVersion,
 		UiVersion:          info.UIVersion,
+		Labels:             info.Labels,
+		WgPort:             int32(info.WgPort),
+		SshPubKey:          info.SSHPubKey,
+		DiskEncrypted:      info.DiskEncrypted,
+		FirewallEnabled:    info.FirewallEnabled,
 	}
 }
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/netbirdio/netbird/client/system"
 	"github.com/netbirdio/netbird/management/proto"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
@@ -10,9 +13,18 @@ type MockClient struct {
 	CloseFunc                      func() error
 	SyncFunc                       func(msgHandler func(msg *proto.SyncResponse) error) error
 	GetServerPublicKeyFunc         func() (*wgtypes.Key, error)
-	RegisterFunc                   func(serverKey wgtypes.Key, setupKey string, jwtToken string, info *system.Info) (*proto.LoginResponse, error)
+	RegisterFunc                   func(serverKey wgtypes.Key, setupKey string, jwtToken string, info *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error)
 	LoginFunc                      func(serverKey wgtypes.Key, info *system.Info) (*proto.LoginResponse, error)
 	GetDeviceAuthorizationFlowFunc func(serverKey wgtypes.Key) (*proto.DeviceAuthorizationFlow, error)
+	StartDeviceAuthFunc            func(serverKey wgtypes.Key) (*proto.StartDeviceAuthResponse, error)
+	PollDeviceAuthFunc             func(serverKey wgtypes.Key, deviceCode string) (*proto.PollDeviceAuthResponse, error)
+	RegisterWithDeviceAuthFunc     func(serverKey wgtypes.Key, deviceAuthToken string, info *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error)
+	GetTURNCredentialsFunc         func() (*proto.TURNCredentialsResponse, error)
+	ReplaceKeyFunc                 func(serverKey wgtypes.Key, newKey wgtypes.Key) (*proto.ReplaceKeyResponse, error)
+	SendFeedbackFunc               func(feedback *proto.FeedbackRequest) error
+	StreamConnectedFunc            func() bool
+	StatusSinceFunc                func() time.Time
+	ReconnectFunc                  func()
 }
 
 func (m *MockClient) Close() error {
@@ -31,16 +43,16 @@ func (m *MockClient) Sync(msgHandler func(msg *proto.SyncResponse) error) error
 
 func (m *MockClient) GetServerPublicKey() (*wgtypes.Key, error) {
 	if m.GetServerPublicKeyFunc == nil {
-		return nil, nil
+		return nil, fmt.Errorf("GetServerPublicKey isn't mocked")
 	}
 	return m.GetServerPublicKeyFunc()
 }
 
-func (m *MockClient) Register(serverKey wgtypes.Key, setupKey string, jwtToken string, info *system.Info) (*proto.LoginResponse, error) {
+func (m *MockClient) Register(serverKey wgtypes.Key, setupKey string, jwtToken string, info *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error) {
 	if m.RegisterFunc == nil {
 		return nil, nil
 	}
-	return m.RegisterFunc(serverKey, setupKey, jwtToken, info)
+	return m.RegisterFunc(serverKey, setupKey, jwtToken, info, requestedIP, reRegister)
 }
 
 func (m *MockClient) Login(serverKey wgtypes.Key, info *system.Info) (*proto.LoginResponse, error) {
@@ -56,3 +68,66 @@ func (m *MockClient) GetDeviceAuthorizationFlow(serverKey wgtypes.Key) (*proto.D
 	}
 	return m.GetDeviceAuthorizationFlowFunc(serverKey)
 }
+
+func (m *MockClient) StreamConnected() bool {
+	if m.StreamConnectedFunc == nil {
+		return false
+	}
+	return m.StreamConnectedFunc()
+}
+
+func (m *MockClient) StatusSince() time.Time {
+	if m.StatusSinceFunc == nil {
+		return time.Time{}
+	}
+	return m.StatusSinceFunc()
+}
+
+func (m *MockClient) Reconnect() {
+	if m.ReconnectFunc == nil {
+		return
+	}
+	m.ReconnectFunc()
+}
+
+func (m *MockClient) StartDeviceAuth(serverKey wgtypes.Key) (*proto.StartDeviceAuthResponse, error) {
+	if m.StartDeviceAuthFunc == nil {
+		return nil, nil
+	}
+	return m.StartDeviceAuthFunc(serverKey)
+}
+
+func (m *MockClient) PollDeviceAuth(serverKey wgtypes.Key, deviceCode string) (*proto.PollDeviceAuthResponse, error) {
+	if m.PollDeviceAuthFunc == nil {
+		return nil, nil
+	}
+	return m.PollDeviceAuthFunc(serverKey, deviceCode)
+}
+
+func (m *MockClient) RegisterWithDeviceAuth(serverKey wgtypes.Key, deviceAuthToken string, info *system.Info, requestedIP string, reRegister bool) (*proto.LoginResponse, error) {
+	if m.RegisterWithDeviceAuthFunc == nil {
+		return nil, nil
+	}
+	return m.RegisterWithDeviceAuthFunc(serverKey, deviceAuthToken, info, requestedIP, reRegister)
+}
+
+func (m *MockClient) GetTURNCredentials() (*proto.TURNCredentialsResponse, error) {
+	if m.GetTURNCredentialsFunc == nil {
+		return nil, fmt.Errorf("GetTURNCredentials isn't mocked")
+	}
+	return m.GetTURNCredentialsFunc()
+}
+
+func (m *MockClient) ReplaceKey(serverKey wgtypes.Key, newKey wgtypes.Key) (*proto.ReplaceKeyResponse, error) {
+	if m.ReplaceKeyFunc == nil {
+		return nil, fmt.Errorf("ReplaceKey isn't mocked")
+	}
+	return m.ReplaceKeyFunc(serverKey, newKey)
+}
+
+func (m *MockClient) SendFeedback(feedback *proto.FeedbackRequest) error {
+	if m.SendFeedbackFunc == nil {
+		return nil
+	}
+	return m.SendFeedbackFunc(feedback)
+}
@@ -10,21 +10,25 @@ import (
 	"io/fs"
 	"io/ioutil"
 	"net"
+	nethttp "net/http"
 	"os"
 	"path"
 	"time"
 
 	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/netbirdio/netbird/management/server/http"
 	"github.com/netbirdio/netbird/management/server/idp"
 	"github.com/netbirdio/netbird/util"
 
 	"github.com/netbirdio/netbird/encryption"
-	mgmtProto "github.com/netbirdio/netbird/management/proto"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 )
 
@@ -33,6 +37,8 @@ var (
 	mgmtLetsencryptDomain string
 	certFile              string
 	certKey               string
+	clientCAFile          string
+	pinPeerKey            bool
 
 	kaep = keepalive.EnforcementPolicy{
 		MinTime:             15 * time.Second,
@@ -51,7 +57,7 @@ var (
 		Short: "start Netbird Management Server",
 		Run: func(cmd *cobra.Command, args []string) {
 			flag.Parse()
-			err := util.InitLog(logLevel, logFile)
+			err := util.InitLog(logLevel, logFile, logFormat)
 			if err != nil {
 				log.Fatalf("failed initializing log %v", err)
 			}
@@ -66,6 +72,11 @@ var (
 				log.Fatalf("failed reading provided config file: %s: %v", mgmtConfig, err)
 			}
 
+			err = util.InitComponentLevels(config.LogLevels)
+			if err != nil {
+				log.Fatalf("failed initializing the log levels of the components: %v", err)
+			}
+
 			if _, err = os.Stat(config.Datadir); os.IsNotExist(err) {
 				err = os.MkdirAll(config.Datadir, os.ModeDir)
 				if err != nil {
@@ -73,7 +84,7 @@ var (
 				}
 			}
 
-			store, err := server.NewStore(config.Datadir)
+			store, err := server.NewStoreFromEngine(config.StoreConfig.Engine, config.Datadir)
 			if err != nil {
 				log.Fatalf("failed creating a store: %s: %v", config.Datadir, err)
 			}
@@ -87,19 +98,48 @@ var (
 				}
 			}
 
-			accountManager, err := server.BuildManager(store, peersUpdateManager, idpManager)
+			var eventStore activity.Store
+			eventStore, err = activity.NewFileStore(config.Datadir)
+			if err != nil {
+				log.Fatalf("failed creating an events store: %s: %v", config.Datadir, err)
+			}
+			if config.Webhooks != nil && len(config.Webhooks.URLs) > 0 {
+				eventStore = activity.NewWebhookStore(eventStore, *config.Webhooks)
+			}
+
+			accountManager, err := server.BuildManager(store, peersUpdateManager, idpManager, eventStore)
 			if err != nil {
 				log.Fatalln("failed build default manager: ", err)
 			}
+			accountManager.SetMaxPeersPerAccount(config.MaxPeersPerAccount)
+			if delay := config.PresenceDisconnectDelay.Duration; delay != 0 {
+				accountManager.SetPresenceDisconnectDelay(delay)
+			}
+
+			// peers which login has expired are removed from the network maps of the other peers
+			expirationCtx, stopExpiration := context.WithCancel(context.Background())
+			defer stopExpiration()
+			accountManager.ScheduleLoginExpiration(expirationCtx, server.DefaultLoginExpirationCheckInterval)
+
+			if retention := config.StoreConfig.AuditRetention.Duration; retention > 0 {
+				retentionCtx, stopRetention := context.WithCancel(context.Background())
+				defer stopRetention()
+				accountManager.ScheduleAuditRetention(retentionCtx, retention, server.DefaultAuditRetentionCheckInterval)
+			}
+
+			healthChecker := server.NewHealthChecker(store, peersUpdateManager)
 
 			var opts []grpc.ServerOption
 
 			var httpServer *http.Server
+			// grpcTLSConfig is the TLS config of the gRPC server, nil if it runs without TLS
+			var grpcTLSConfig *tls.Config
+			// certManager gets the certificate from Let's Encrypt, nil if it is provided
+			var certManager *autocert.Manager
 			if config.HttpConfig.LetsEncryptDomain != "" {
 				// automatically generate a new certificate with Let's Encrypt
-				certManager := encryption.CreateCertManager(config.Datadir, config.HttpConfig.LetsEncryptDomain)
-				transportCredentials := credentials.NewTLS(certManager.TLSConfig())
-				opts = append(opts, grpc.Creds(transportCredentials))
+				certManager = encryption.CreateCertManager(config.Datadir, config.HttpConfig.LetsEncryptDomain)
+				grpcTLSConfig = certManager.TLSConfig()
 
 				httpServer = http.NewHttpsServer(config.HttpConfig, certManager, accountManager)
 			} else if config.HttpConfig.CertFile != "" && config.HttpConfig.CertKey != "" {
@@ -108,24 +148,85 @@ var (
 				if err != nil {
 					log.Fatal("cannot load TLS credentials: ", err)
 				}
-				transportCredentials := credentials.NewTLS(tlsConfig)
-				opts = append(opts, grpc.Creds(transportCredentials))
+				grpcTLSConfig = tlsConfig
 				httpServer = http.NewHttpsServerWithTLSConfig(config.HttpConfig, tlsConfig, accountManager)
 			} else {
 				// start server without SSL
 				httpServer = http.NewHttpServer(config.HttpConfig, accountManager)
 			}
 
+			if config.ClientAuth != nil && config.ClientAuth.CAFile != "" {
+				if grpcTLSConfig == nil {
+					log.Fatal("client certificates require TLS, set a certificate or a Let's Encrypt domain")
+				}
+				clientCAs, err := encryption.NewClientCAReloader(config.ClientAuth.CAFile)
+				if err != nil {
+					log.Fatalf("failed reading client CA bundle: %v", err)
+				}
+				watchCtx, stopWatch := context.WithCancel(context.Background())
+				defer stopWatch()
+				err = clientCAs.Watch(watchCtx)
+				if err != nil {
+					log.Warnf("client CA bundle changes won't be noticed, send SIGHUP after updating it: %v", err)
+				}
+				// the HTTP API keeps its own config, the dashboard doesn't present client certificates
+				if certManager != nil {
+					grpcTLSConfig = clientCAs.RequireClientCertificatesWithACME(grpcTLSConfig, certManager)
+				} else {
+					grpcTLSConfig = clientCAs.RequireClientCertificates(grpcTLSConfig)
+				}
+				log.Infof("requiring client certificates issued by %s", config.ClientAuth.CAFile)
+
+				if config.ClientAuth.PinPeerKey {
+					unary, stream := server.PeerCertificateInterceptors()
+					opts = append(opts, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+				}
+			}
+			if grpcTLSConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(grpcTLSConfig)))
+			}
+
 			opts = append(opts, grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
 			grpcServer := grpc.NewServer(opts...)
+
+			// the health service reports the Management service status to the gRPC health checks
+			grpcHealthServer := health.NewServer()
+			grpc_health_v1.RegisterHealthServer(grpcServer, grpcHealthServer)
+			healthCtx, stopHealth := context.WithCancel(context.Background())
+			defer stopHealth()
+			healthChecker.WatchGRPCHealth(healthCtx, grpcHealthServer)
+
+			var healthServer *nethttp.Server
+			if config.HealthConfig != nil && config.HealthConfig.Address != "" {
+				healthServer = http.NewHealthServer(config.HealthConfig.Address, healthChecker)
+			} else {
+				httpServer.ServeHealth(healthChecker)
+			}
+
 			turnManager := server.NewTimeBasedAuthSecretsManager(peersUpdateManager, config.TURNConfig)
 			server, err := server.NewServer(config, accountManager, peersUpdateManager, turnManager)
 			if err != nil {
 				log.Fatalf("failed creating new server: %v", err)
 			}
-			mgmtProto.RegisterManagementServiceServer(grpcServer, server)
+			server.Register(grpcServer)
 			log.Printf("started server: localhost:%v", mgmtPort)
 
+			var debugServer *nethttp.Server
+			if config.Debug != nil && config.Debug.Address != "" {
+				debugServer, err = http.NewDebugServer(config.Debug.Address, server)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
+			var adminServer *nethttp.Server
+			if config.Admin != nil && config.Admin.Address != "" {
+				adminServer, err = http.NewAdminServer(config.Admin.Address, accountManager, server)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+
 			lis, err := net.Listen("tcp", fmt.Sprintf(":%d", mgmtPort))
 			if err != nil {
 				log.Fatalf("failed to listen: %v", err)
@@ -144,6 +245,36 @@ var (
 				}
 			}()
 
+			if healthServer != nil {
+				go func() {
+					log.Infof("health server listening on %s", healthServer.Addr)
+					err := healthServer.ListenAndServe()
+					if err != nil && err != nethttp.ErrServerClosed {
+						log.Fatalf("failed to serve health server: %v", err)
+					}
+				}()
+			}
+
+			if debugServer != nil {
+				go func() {
+					log.Warnf("debug server listening on %s", debugServer.Addr)
+					err := debugServer.ListenAndServe()
+					if err != nil && err != nethttp.ErrServerClosed {
+						log.Fatalf("failed to serve debug server: %v", err)
+					}
+				}()
+			}
+
+			if adminServer != nil {
+				go func() {
+					log.Infof("admin server listening on %s", adminServer.Addr)
+					err := adminServer.ListenAndServe()
+					if err != nil && err != nethttp.ErrServerClosed {
+						log.Fatalf("failed to serve admin server: %v", err)
+					}
+				}()
+			}
+
 			SetupCloseHandler()
 			<-stopCh
 			log.Println("Receive signal to stop running Management server")
@@ -154,7 +285,38 @@ var (
 				log.Fatalf("failed stopping the http server %v", err)
 			}
 
+			if healthServer != nil {
+				err = healthServer.Shutdown(ctx)
+				if err != nil {
+					log.Errorf("failed stopping the health server %v", err)
+				}
+			}
+
+			if debugServer != nil {
+				err = debugServer.Shutdown(ctx)
+				if err != nil {
+					log.Errorf("failed stopping the debug server %v", err)
+				}
+			}
+
+			if adminServer != nil {
+				err = adminServer.Shutdown(ctx)
+				if err != nil {
+					log.Errorf("failed stopping the admin server %v", err)
+				}
+			}
+
 			grpcServer.Stop()
+
+			err = eventStore.Close()
+			if err != nil {
+				log.Errorf("failed closing the events store %v", err)
+			}
+
+			err = store.Close()
+			if err != nil {
+				log.Errorf("failed closing the store %v", err)
+			}
 		},
 	}
 )
@@ -177,6 +339,16 @@ func loadMgmtConfig(mgmtConfigPath string) (*server.Config, error) {
 		config.HttpConfig.CertKey = certKey
 	}
 
+	if clientCAFile != "" {
+		if config.ClientAuth == nil {
+			config.ClientAuth = &server.ClientAuthConfig{}
+		}
+		config.ClientAuth.CAFile = clientCAFile
+	}
+	if pinPeerKey && config.ClientAuth != nil {
+		config.ClientAuth.PinPeerKey = true
+	}
+
 	return config, err
 }
 
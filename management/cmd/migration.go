@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/util"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var migrateStoreCmd = &cobra.Command{
+	Use:   "migrate-store",
+	Short: "import the accounts of the JSON file store (store.json) of the datadir into a new SQLite store (store.db)",
+	Long: "Imports the accounts of the JSON file store of the datadir into a new SQLite store. The JSON file store is left untouched.\n" +
+		"Stop the Management service before running the migration and set the StoreConfig.Engine of the config to sqlite afterwards.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flag.Parse()
+		err := util.InitLog(logLevel, logFile, logFormat)
+		if err != nil {
+			return fmt.Errorf("failed initializing log %v", err)
+		}
+
+		err = server.MigrateFileStoreToSqlite(mgmtDataDir)
+		if err != nil {
+			return err
+		}
+
+		log.Info("the store has been migrated successfully")
+		return nil
+	},
+}
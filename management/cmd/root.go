@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/netbirdio/netbird/util"
 	"github.com/spf13/cobra"
 	"os"
 	"os/signal"
@@ -26,6 +27,7 @@ var (
 	mgmtDataDir             string
 	mgmtConfig              string
 	logLevel                string
+	logFormat               string
 	logFile                 string
 
 	rootCmd = &cobra.Command{
@@ -66,11 +68,17 @@ func init() {
 	mgmtCmd.Flags().StringVar(&mgmtLetsencryptDomain, "letsencrypt-domain", "", "a domain to issue Let's Encrypt certificate for. Enables TLS using Let's Encrypt. Will fetch and renew certificate, and run the server with TLS")
 	mgmtCmd.Flags().StringVar(&certFile, "cert-file", "", "Location of your SSL certificate. Can be used when you have an existing certificate and don't want a new certificate be generated automatically. If letsencrypt-domain is specified this property has no effect")
 	mgmtCmd.Flags().StringVar(&certKey, "cert-key", "", "Location of your SSL certificate private key. Can be used when you have an existing certificate and don't want a new certificate be generated automatically. If letsencrypt-domain is specified this property has no effect")
+	mgmtCmd.Flags().StringVar(&clientCAFile, "client-ca-file", "", "Location of a PEM bundle of the CAs issuing the client certificates. Requires the peers to present a client certificate (mutual TLS) on the gRPC connections. Reloaded on SIGHUP or once the file changes")
+	mgmtCmd.Flags().BoolVar(&pinPeerKey, "pin-peer-key", false, "requires the common name of the client certificate to be the Wireguard public key of the peer. Has effect only if the client certificates are required")
 	rootCmd.MarkFlagRequired("config") //nolint
 
+	migrateStoreCmd.Flags().StringVar(&mgmtDataDir, "datadir", defaultMgmtDataDir, "server data directory location")
+
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", util.LogFormatText, "sets Netbird log format [text|json]")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", defaultLogFile, "sets Netbird log path. If console is specified the the log will be output to stdout")
 	rootCmd.AddCommand(mgmtCmd)
+	rootCmd.AddCommand(migrateStoreCmd)
 }
 
 // SetupCloseHandler handles SIGTERM signal and exits with success
@@ -37,6 +37,30 @@ type ManagementServiceClient interface {
 	// EncryptedMessage of the request has a body of DeviceAuthorizationFlowRequest.
 	// EncryptedMessage of the response has a body of DeviceAuthorizationFlow.
 	GetDeviceAuthorizationFlow(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
+	// Starts a device authorization of an unregistered peer. A user of the account the peer is going to join
+	// approves the returned user code, while the peer polls PollDeviceAuth for the registration token.
+	// EncryptedMessage of the request has a body of StartDeviceAuthRequest.
+	// EncryptedMessage of the response has a body of StartDeviceAuthResponse.
+	StartDeviceAuth(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
+	// Returns the registration token of the peer once the device authorization has been approved.
+	// EncryptedMessage of the request has a body of PollDeviceAuthRequest.
+	// EncryptedMessage of the response has a body of PollDeviceAuthResponse.
+	PollDeviceAuth(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
+	// Returns new credentials of the TURN servers. Peers request them shortly before the current ones expire
+	// in case the refresh pushed over the Sync stream hasn't arrived (e.g. the stream has been reconnecting).
+	// EncryptedMessage of the request has a body of TURNCredentialsRequest.
+	// EncryptedMessage of the response has a body of TURNCredentialsResponse.
+	GetTURNCredentials(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
+	// Replaces the Wireguard key of a registered peer keeping its IP, name and groups.
+	// The request is sent with the current (old) key of the peer.
+	// EncryptedMessage of the request has a body of ReplaceKeyRequest.
+	// EncryptedMessage of the response has a body of ReplaceKeyResponse.
+	ReplaceKey(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
+	// Reports the problems a peer has detected in its NetworkMap, e.g. the conflicting allowed IPs of the remote peers,
+	// so that they are shown to the admin.
+	// EncryptedMessage of the request has a body of FeedbackRequest.
+	// EncryptedMessage of the response has a body of FeedbackResponse.
+	SendFeedback(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error)
 }
 
 type managementServiceClient struct {
@@ -115,6 +139,51 @@ func (c *managementServiceClient) GetDeviceAuthorizationFlow(ctx context.Context
 	return out, nil
 }
 
+func (c *managementServiceClient) StartDeviceAuth(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error) {
+	out := new(EncryptedMessage)
+	err := c.cc.Invoke(ctx, "/management.ManagementService/StartDeviceAuth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementServiceClient) PollDeviceAuth(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error) {
+	out := new(EncryptedMessage)
+	err := c.cc.Invoke(ctx, "/management.ManagementService/PollDeviceAuth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementServiceClient) GetTURNCredentials(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error) {
+	out := new(EncryptedMessage)
+	err := c.cc.Invoke(ctx, "/management.ManagementService/GetTURNCredentials", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementServiceClient) ReplaceKey(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error) {
+	out := new(EncryptedMessage)
+	err := c.cc.Invoke(ctx, "/management.ManagementService/ReplaceKey", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementServiceClient) SendFeedback(ctx context.Context, in *EncryptedMessage, opts ...grpc.CallOption) (*EncryptedMessage, error) {
+	out := new(EncryptedMessage)
+	err := c.cc.Invoke(ctx, "/management.ManagementService/SendFeedback", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ManagementServiceServer is the server API for ManagementService service.
 // All implementations must embed UnimplementedManagementServiceServer
 // for forward compatibility
@@ -138,6 +207,30 @@ type ManagementServiceServer interface {
 	// EncryptedMessage of the request has a body of DeviceAuthorizationFlowRequest.
 	// EncryptedMessage of the response has a body of DeviceAuthorizationFlow.
 	GetDeviceAuthorizationFlow(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
+	// Starts a device authorization of an unregistered peer. A user of the account the peer is going to join
+	// approves the returned user code, while the peer polls PollDeviceAuth for the registration token.
+	// EncryptedMessage of the request has a body of StartDeviceAuthRequest.
+	// EncryptedMessage of the response has a body of StartDeviceAuthResponse.
+	StartDeviceAuth(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
+	// Returns the registration token of the peer once the device authorization has been approved.
+	// EncryptedMessage of the request has a body of PollDeviceAuthRequest.
+	// EncryptedMessage of the response has a body of PollDeviceAuthResponse.
+	PollDeviceAuth(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
+	// Returns new credentials of the TURN servers. Peers request them shortly before the current ones expire
+	// in case the refresh pushed over the Sync stream hasn't arrived (e.g. the stream has been reconnecting).
+	// EncryptedMessage of the request has a body of TURNCredentialsRequest.
+	// EncryptedMessage of the response has a body of TURNCredentialsResponse.
+	GetTURNCredentials(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
+	// Replaces the Wireguard key of a registered peer keeping its IP, name and groups.
+	// The request is sent with the current (old) key of the peer.
+	// EncryptedMessage of the request has a body of ReplaceKeyRequest.
+	// EncryptedMessage of the response has a body of ReplaceKeyResponse.
+	ReplaceKey(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
+	// Reports the problems a peer has detected in its NetworkMap, e.g. the conflicting allowed IPs of the remote peers,
+	// so that they are shown to the admin.
+	// EncryptedMessage of the request has a body of FeedbackRequest.
+	// EncryptedMessage of the response has a body of FeedbackResponse.
+	SendFeedback(context.Context, *EncryptedMessage) (*EncryptedMessage, error)
 	mustEmbedUnimplementedManagementServiceServer()
 }
 
@@ -160,6 +253,21 @@ func (UnimplementedManagementServiceServer) IsHealthy(context.Context, *Empty) (
 func (UnimplementedManagementServiceServer) GetDeviceAuthorizationFlow(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetDeviceAuthorizationFlow not implemented")
 }
+func (UnimplementedManagementServiceServer) StartDeviceAuth(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartDeviceAuth not implemented")
+}
+func (UnimplementedManagementServiceServer) PollDeviceAuth(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PollDeviceAuth not implemented")
+}
+func (UnimplementedManagementServiceServer) GetTURNCredentials(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTURNCredentials not implemented")
+}
+func (UnimplementedManagementServiceServer) ReplaceKey(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplaceKey not implemented")
+}
+func (UnimplementedManagementServiceServer) SendFeedback(context.Context, *EncryptedMessage) (*EncryptedMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendFeedback not implemented")
+}
 func (UnimplementedManagementServiceServer) mustEmbedUnimplementedManagementServiceServer() {}
 
 // UnsafeManagementServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -266,6 +374,96 @@ func _ManagementService_GetDeviceAuthorizationFlow_Handler(srv interface{}, ctx
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ManagementService_StartDeviceAuth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptedMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).StartDeviceAuth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/management.ManagementService/StartDeviceAuth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).StartDeviceAuth(ctx, req.(*EncryptedMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManagementService_PollDeviceAuth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptedMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).PollDeviceAuth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/management.ManagementService/PollDeviceAuth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).PollDeviceAuth(ctx, req.(*EncryptedMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManagementService_GetTURNCredentials_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptedMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).GetTURNCredentials(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/management.ManagementService/GetTURNCredentials",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).GetTURNCredentials(ctx, req.(*EncryptedMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManagementService_ReplaceKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptedMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).ReplaceKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/management.ManagementService/ReplaceKey",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).ReplaceKey(ctx, req.(*EncryptedMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ManagementService_SendFeedback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EncryptedMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).SendFeedback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/management.ManagementService/SendFeedback",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).SendFeedback(ctx, req.(*EncryptedMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ManagementService_ServiceDesc is the grpc.ServiceDesc for ManagementService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -289,6 +487,26 @@ var ManagementService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetDeviceAuthorizationFlow",
 			Handler:    _ManagementService_GetDeviceAuthorizationFlow_Handler,
 		},
+		{
+			MethodName: "StartDeviceAuth",
+			Handler:    _ManagementService_StartDeviceAuth_Handler,
+		},
+		{
+			MethodName: "PollDeviceAuth",
+			Handler:    _ManagementService_PollDeviceAuth_Handler,
+		},
+		{
+			MethodName: "GetTURNCredentials",
+			Handler:    _ManagementService_GetTURNCredentials_Handler,
+		},
+		{
+			MethodName: "ReplaceKey",
+			Handler:    _ManagementService_ReplaceKey_Handler,
+		},
+		{
+			MethodName: "SendFeedback",
+			Handler:    _ManagementService_SendFeedback_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -2,15 +2,18 @@ package server
 
 import (
 	"fmt"
+	"net"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/netbirdio/netbird/management/server/idp"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
 	"github.com/netbirdio/netbird/util"
 	"github.com/rs/xid"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -29,43 +32,96 @@ type AccountManager interface {
 		keyName string,
 		keyType SetupKeyType,
 		expiresIn *util.Duration,
+		usageLimit int,
+		userID string,
 	) (*SetupKey, error)
-	RevokeSetupKey(accountId string, keyId string) (*SetupKey, error)
-	RenameSetupKey(accountId string, keyId string, newName string) (*SetupKey, error)
+	RevokeSetupKey(accountId string, keyId string, userID string) (*SetupKey, error)
+	RenameSetupKey(accountId string, keyId string, newName string, userID string) (*SetupKey, error)
+	ListSetupKeys(accountId string) ([]*SetupKey, error)
 	GetAccountById(accountId string) (*Account, error)
 	GetAccountByUserOrAccountId(userId, accountId, domain string) (*Account, error)
 	GetAccountWithAuthorizationClaims(claims jwtclaims.AuthorizationClaims) (*Account, error)
 	IsUserAdmin(claims jwtclaims.AuthorizationClaims) (bool, error)
 	AccountExists(accountId string) (*bool, error)
 	AddAccount(accountId, userId, domain string) (*Account, error)
+	CreateAccount(accountId string, network *net.IPNet, setupKeys []*SetupKey, userID string) (*Account, error)
+	ListAccounts() ([]*Account, error)
 	GetPeer(peerKey string) (*Peer, error)
 	MarkPeerConnected(peerKey string, connected bool) error
-	RenamePeer(accountId string, peerKey string, newName string) (*Peer, error)
-	DeletePeer(accountId string, peerKey string) (*Peer, error)
+	MarkPeerDisconnected(peerKey string, lastSeen time.Time) error
+	RenamePeer(accountId string, peerKey string, newName string, userID string) (*Peer, error)
+	UpdatePeerIP(accountId string, peerKey string, ip net.IP, userID string) (*Peer, error)
+	UpdatePeerRateLimit(accountId string, peerKey string, rateLimit uint64, userID string) (*Peer, error)
+	UpdatePeerStaticEndpoint(accountId string, peerKey string, endpoint string, userID string) (*Peer, error)
+	UpdatePeerRouteMetric(accountId string, peerKey string, metric uint32, userID string) (*Peer, error)
+	UpdatePeerSSH(accountId string, peerKey string, enabled bool, allowedGroups []string, userID string) (*Peer, error)
+	UpdatePeerTags(accountId string, peerKey string, tags []string, userID string) (*Peer, error)
+	SuspendPeer(accountId string, peerKey string, suspended bool, userID string) (*Peer, error)
+	MarkPeerLoggedIn(peerKey string) error
+	CheckPeerLogin(peerKey string) error
+	LoginPeerWithSetupKey(setupKey string, peerKey string) error
+	UpdateAccountLoginExpiration(accountId string, expiration time.Duration, userID string) (*Account, error)
+	UpdateAccountPresenceSharing(accountId string, enabled bool, userID string) (*Account, error)
+	UpdateAccountPostureChecks(accountId string, checks *PostureChecks, userID string) (*Account, error)
+	UpdateAccountDNSSettings(accountId string, settings *DNSSettings, userID string) (*Account, error)
+	ExportAccount(accountId string) (*AccountExport, error)
+	ImportAccount(accountId string, export *AccountExport, dryRun bool, userID string) (*ImportResult, error)
+	DeletePeer(accountId string, peerKey string, userID string) (*Peer, error)
+	ReplacePeerKey(oldKey string, newKey string) (*Peer, error)
 	GetPeerByIP(accountId string, peerIP string) (*Peer, error)
 	GetNetworkMap(peerKey string) (*NetworkMap, error)
+	GetPeerReachability(peerKey string) ([]*ReachablePeer, error)
 	AddPeer(setupKey string, userId string, peer *Peer) (*Peer, error)
+	GetPeersQuota(accountId string) (*PeersQuota, error)
 	UpdatePeerMeta(peerKey string, meta PeerSystemMeta) error
+	UpdatePeerAllowedIPsConflicts(peerKey string, conflicts []AllowedIPsConflict) error
+	RecordConnectionOutcomes(peerKey string, outcomes []ConnectionOutcome) error
+	GetPeerConnectivity(peerKey string) (*PeerConnectivity, error)
 	GetUsersFromAccount(accountId string) ([]*UserInfo, error)
 	GetGroup(accountId, groupID string) (*Group, error)
-	SaveGroup(accountId string, group *Group) error
-	DeleteGroup(accountId, groupID string) error
+	SaveGroup(accountId, userID string, group *Group) error
+	DeleteGroup(accountId, userID, groupID string) error
 	ListGroups(accountId string) ([]*Group, error)
-	GroupAddPeer(accountId, groupID, peerKey string) error
-	GroupDeletePeer(accountId, groupID, peerKey string) error
+	GroupAddPeer(accountId, userID, groupID, peerKey string) error
+	GroupDeletePeer(accountId, userID, groupID, peerKey string) error
 	GroupListPeers(accountId, groupID string) ([]*Peer, error)
 	GetRule(accountId, ruleID string) (*Rule, error)
-	SaveRule(accountID string, rule *Rule) error
-	DeleteRule(accountId, ruleID string) error
+	SaveRule(accountID, userID string, rule *Rule) error
+	DeleteRule(accountId, userID, ruleID string) error
 	ListRules(accountId string) ([]*Rule, error)
+	GetRoute(accountID, routeID string) (*Route, error)
+	SaveRoute(accountID, userID string, route *Route) error
+	DeleteRoute(accountID, userID, routeID string) error
+	ListRoutes(accountID string) ([]*Route, error)
+	UpdateAccountNetwork(accountId string, ipNet net.IPNet, userID string) (*Network, error)
+	SaveIPReservation(accountID, userID string, reservation *IPReservation) error
+	DeleteIPReservation(accountID, userID, reservationID string) error
+	GetEvents(accountId string, from, to time.Time) ([]*activity.Event, error)
+	GetAuditEvents(accountId string, filter AuditFilter) ([]*activity.Event, int, error)
+	StartDeviceAuth(peerKey string) (*DeviceAuth, error)
+	ApproveDeviceAuth(userCode string, userID string) error
+	PollDeviceAuth(deviceCode string, peerKey string) (string, error)
+	RedeemDeviceAuth(token string, peerKey string) (string, error)
 }
 
 type DefaultAccountManager struct {
 	Store Store
-	// mutex to synchronise account operations (e.g. generating Peer IP address inside the Network)
+	// mutex to synchronise the operations creating accounts or looking them up by other than the account ID
+	// (e.g. registering a peer with a setup key). Changes of an existing account hold the account lock of the Store
 	mux                sync.Mutex
 	peersUpdateManager *PeersUpdateManager
 	idpManager         idp.Manager
+	eventStore         activity.Store
+	// maxPeersPerAccount is the number of peers an account can register unless the account has its own limit, 0 means unlimited
+	maxPeersPerAccount int
+	// clock provides the current time to the login expiration of the peers
+	clock Clock
+	// deviceAuths are the pending device authorizations of the peers
+	deviceAuths *deviceAuthSessions
+	// presenceDisconnectDelay is how long a disconnect of a peer lasts before it is shared with the other peers
+	presenceDisconnectDelay time.Duration
+	// connectivity keeps the outcomes of the connection attempts reported by the peers
+	connectivity *connectivityTracker
 }
 
 // Account represents a unique account of the system
@@ -82,6 +138,20 @@ type Account struct {
 	Users                  map[string]*User
 	Groups                 map[string]*Group
 	Rules                  map[string]*Rule
+	// Routes are the networks behind the routing peers advertised to the peers of the account
+	Routes map[string]*Route
+	// MaxPeers overrides the MaxPeersPerAccount of the Management service config for this account if positive
+	MaxPeers int
+	// LoginExpiration is the period after which the peers registered by users have to log in again, 0 means never
+	LoginExpiration time.Duration
+	// PresenceSharing shares the presence (connected or not, last seen) of the peers with the peers that can reach them
+	PresenceSharing bool
+	// PostureChecks are the device posture requirements of the peers, nil means no requirements
+	PostureChecks *PostureChecks
+	// DNSSettings are the name resolution settings pushed to the peers, nil means none
+	DNSSettings *DNSSettings
+	// IPReservations are the IPs of the account network reserved for peers by the reservation ID
+	IPReservations map[string]*IPReservation
 }
 
 type UserInfo struct {
@@ -123,15 +193,35 @@ func (a *Account) Copy() *Account {
 		rules[id] = rule.Copy()
 	}
 
+	routes := map[string]*Route{}
+	for id, route := range a.Routes {
+		routes[id] = route.Copy()
+	}
+
+	ipReservations := map[string]*IPReservation{}
+	for id, reservation := range a.IPReservations {
+		ipReservations[id] = reservation.Copy()
+	}
+
 	return &Account{
-		Id:        a.Id,
-		CreatedBy: a.CreatedBy,
-		SetupKeys: setupKeys,
-		Network:   a.Network.Copy(),
-		Peers:     peers,
-		Users:     users,
-		Groups:    groups,
-		Rules:     rules,
+		Id:                     a.Id,
+		CreatedBy:              a.CreatedBy,
+		Domain:                 a.Domain,
+		DomainCategory:         a.DomainCategory,
+		IsDomainPrimaryAccount: a.IsDomainPrimaryAccount,
+		SetupKeys:              setupKeys,
+		Network:                a.Network.Copy(),
+		Peers:                  peers,
+		Users:                  users,
+		Groups:                 groups,
+		Rules:                  rules,
+		Routes:                 routes,
+		MaxPeers:               a.MaxPeers,
+		LoginExpiration:        a.LoginExpiration,
+		PresenceSharing:        a.PresenceSharing,
+		PostureChecks:          a.PostureChecks.Copy(),
+		DNSSettings:            a.DNSSettings.Copy(),
+		IPReservations:         ipReservations,
 	}
 }
 
@@ -144,15 +234,22 @@ func (a *Account) GetGroupAll() (*Group, error) {
 	return nil, fmt.Errorf("no group ALL found")
 }
 
-// BuildManager creates a new DefaultAccountManager with a provided Store
+// BuildManager creates a new DefaultAccountManager with a provided Store.
+// The eventStore is optional, account events aren't recorded if it is nil
 func BuildManager(
-	store Store, peersUpdateManager *PeersUpdateManager, idpManager idp.Manager,
+	store Store, peersUpdateManager *PeersUpdateManager, idpManager idp.Manager, eventStore activity.Store,
 ) (*DefaultAccountManager, error) {
 	dam := &DefaultAccountManager{
 		Store:              store,
 		mux:                sync.Mutex{},
 		peersUpdateManager: peersUpdateManager,
 		idpManager:         idpManager,
+		eventStore:         eventStore,
+		clock:              systemClock{},
+		deviceAuths:        newDeviceAuthSessions(),
+		connectivity:       newConnectivityTracker(),
+		// the peers reconnecting right away (e.g. after a network change) don't flap in the network maps of the others
+		presenceDisconnectDelay: DefaultPresenceDisconnectDelay,
 	}
 
 	// if account has not default account
@@ -169,15 +266,34 @@ func BuildManager(
 	return dam, nil
 }
 
-// AddSetupKey generates a new setup key with a given name and type, and adds it to the specified account
+// SetMaxPeersPerAccount sets the number of peers an account can register unless the account has its own limit, 0 means unlimited.
+// Has to be called before the manager is used
+func (am *DefaultAccountManager) SetMaxPeersPerAccount(maxPeers int) {
+	am.maxPeersPerAccount = maxPeers
+}
+
+// SetPresenceDisconnectDelay sets how long a disconnect of a peer lasts before it is shared with the other peers,
+// 0 shares it right away. Has to be called before the manager is used
+func (am *DefaultAccountManager) SetPresenceDisconnectDelay(delay time.Duration) {
+	am.presenceDisconnectDelay = delay
+}
+
+// AddSetupKey generates a new setup key with a given name and type, and adds it to the specified account.
+// The usageLimit limits the number of peers a reusable key can register, 0 means unlimited
 func (am *DefaultAccountManager) AddSetupKey(
 	accountId string,
 	keyName string,
 	keyType SetupKeyType,
 	expiresIn *util.Duration,
+	usageLimit int,
+	userID string,
 ) (*SetupKey, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	if usageLimit < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "setup key usage limit can't be negative")
+	}
 
 	keyDuration := DefaultSetupKeyDuration
 	if expiresIn != nil {
@@ -189,10 +305,11 @@ func (am *DefaultAccountManager) AddSetupKey(
 		return nil, status.Errorf(codes.NotFound, "account not found")
 	}
 
-	setupKey := GenerateSetupKey(keyName, keyType, keyDuration)
+	setupKey := GenerateSetupKey(keyName, keyType, keyDuration, usageLimit)
 	account.SetupKeys[setupKey.Key] = setupKey
 
-	err = am.Store.SaveAccount(account)
+	err = am.saveAccount(account, newAuditEvent(userID, setupKey.Id, accountId, activity.SetupKeyCreated,
+		map[string]string{"name": setupKey.Name}, nil, auditSetupKey(setupKey)))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed adding account key")
 	}
@@ -200,10 +317,10 @@ func (am *DefaultAccountManager) AddSetupKey(
 	return setupKey, nil
 }
 
-// RevokeSetupKey marks SetupKey as revoked - becomes not valid anymore
-func (am *DefaultAccountManager) RevokeSetupKey(accountId string, keyId string) (*SetupKey, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// RevokeSetupKey marks SetupKey as revoked - becomes not valid anymore. The userID is the user who revoked the key
+func (am *DefaultAccountManager) RevokeSetupKey(accountId string, keyId string, userID string) (*SetupKey, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
@@ -218,7 +335,8 @@ func (am *DefaultAccountManager) RevokeSetupKey(accountId string, keyId string)
 	keyCopy := setupKey.Copy()
 	keyCopy.Revoked = true
 	account.SetupKeys[keyCopy.Key] = keyCopy
-	err = am.Store.SaveAccount(account)
+	err = am.saveAccount(account, newAuditEvent(userID, keyCopy.Id, accountId, activity.SetupKeyRevoked,
+		map[string]string{"name": keyCopy.Name}, auditSetupKey(setupKey), auditSetupKey(keyCopy)))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed adding account key")
 	}
@@ -226,14 +344,15 @@ func (am *DefaultAccountManager) RevokeSetupKey(accountId string, keyId string)
 	return keyCopy, nil
 }
 
-// RenameSetupKey renames existing setup key of the specified account.
+// RenameSetupKey renames existing setup key of the specified account. The userID is the user who renamed the key
 func (am *DefaultAccountManager) RenameSetupKey(
 	accountId string,
 	keyId string,
 	newName string,
+	userID string,
 ) (*SetupKey, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
@@ -248,7 +367,8 @@ func (am *DefaultAccountManager) RenameSetupKey(
 	keyCopy := setupKey.Copy()
 	keyCopy.Name = newName
 	account.SetupKeys[keyCopy.Key] = keyCopy
-	err = am.Store.SaveAccount(account)
+	err = am.saveAccount(account, newAuditEvent(userID, keyCopy.Id, accountId, activity.SetupKeyRenamed,
+		map[string]string{"name": keyCopy.Name}, auditSetupKey(setupKey), auditSetupKey(keyCopy)))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed adding account key")
 	}
@@ -256,10 +376,35 @@ func (am *DefaultAccountManager) RenameSetupKey(
 	return keyCopy, nil
 }
 
+// ListSetupKeys returns the setup keys of the account including the revoked and expired ones along with their usage,
+// sorted by the creation time
+func (am *DefaultAccountManager) ListSetupKeys(accountId string) ([]*SetupKey, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	keys := make([]*SetupKey, 0, len(account.SetupKeys))
+	for _, key := range account.SetupKeys {
+		keys = append(keys, key.Copy())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].Name < keys[j].Name
+		}
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
 // GetAccountById returns an existing account using its ID or error (NotFound) if doesn't exist
 func (am *DefaultAccountManager) GetAccountById(accountId string) (*Account, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
@@ -424,7 +569,14 @@ func (am *DefaultAccountManager) handleNewUserAccount(
 	lowerDomain := strings.ToLower(claims.Domain)
 	// if domain already has a primary account, add regular user
 	if domainAcc != nil {
-		account = domainAcc
+		unlock := am.Store.AcquireAccountLock(domainAcc.Id)
+		defer unlock()
+
+		// read the account again holding the lock, it could have been changed in the meantime
+		account, err = am.Store.GetAccount(domainAcc.Id)
+		if err != nil {
+			return nil, err
+		}
 		account.Users[claims.UserId] = NewRegularUser(claims.UserId)
 		err = am.Store.SaveAccount(account)
 		if err != nil {
@@ -496,6 +648,15 @@ func (am *DefaultAccountManager) GetAccountWithAuthorizationClaims(
 
 	account, err := am.Store.GetUserAccount(claims.UserId)
 	if err == nil {
+		unlock := am.Store.AcquireAccountLock(account.Id)
+		defer unlock()
+
+		// read the account again holding the lock, it could have been changed in the meantime
+		account, err = am.Store.GetAccount(account.Id)
+		if err != nil {
+			return nil, err
+		}
+
 		err = am.handleExistingUserAccount(account, domainAccount, claims)
 		if err != nil {
 			return nil, err
@@ -511,8 +672,8 @@ func (am *DefaultAccountManager) GetAccountWithAuthorizationClaims(
 
 // AccountExists checks whether account exists (returns true) or not (returns false)
 func (am *DefaultAccountManager) AccountExists(accountId string) (*bool, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	var res bool
 	_, err := am.Store.GetAccount(accountId)
@@ -534,9 +695,76 @@ func (am *DefaultAccountManager) AddAccount(accountId, userId, domain string) (*
 	am.mux.Lock()
 	defer am.mux.Unlock()
 
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
 	return am.createAccount(accountId, userId, domain)
 }
 
+// CreateAccount creates an account without users, e.g. by the operator of a Management service hosting several
+// accounts. The account gets the network if provided (a random one otherwise) and the setup keys if provided
+// (the default ones otherwise). The setup keys have to be unique across the accounts. The userID is the operator who
+// initiated the creation
+func (am *DefaultAccountManager) CreateAccount(accountId string, network *net.IPNet, setupKeys []*SetupKey, userID string) (*Account, error) {
+	if accountId == "" {
+		accountId = xid.New().String()
+	}
+	if network != nil {
+		network = &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: network.Mask}
+		if err := validateNetwork(*network); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid network: %v", err)
+		}
+	}
+	for _, key := range setupKeys {
+		if key.Type != SetupKeyReusable && key.Type != SetupKeyOneOff {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown setup key type %s", key.Type)
+		}
+		if key.UsageLimit < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "setup key usage limit can't be negative")
+		}
+	}
+
+	am.mux.Lock()
+	defer am.mux.Unlock()
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	if _, err := am.Store.GetAccount(accountId); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "account %s already exists", accountId)
+	}
+
+	account := newAccountWithId(accountId, userID, "")
+	if network != nil {
+		account.Network.Net = *network
+	}
+	if len(setupKeys) > 0 {
+		account.SetupKeys = make(map[string]*SetupKey, len(setupKeys))
+		for _, key := range setupKeys {
+			account.SetupKeys[key.Key] = key.Copy()
+		}
+	}
+	am.addAllGroup(account)
+
+	// a setup key of another account is rejected by the Store
+	err := am.saveAccount(account, newAuditEvent(userID, accountId, accountId, activity.AccountCreated,
+		map[string]string{"network": account.Network.Net.String()}, nil, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// ListAccounts returns all the accounts of the Management service sorted by ID
+func (am *DefaultAccountManager) ListAccounts() ([]*Account, error) {
+	accounts := am.Store.GetAllAccounts()
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Id < accounts[j].Id
+	})
+	return accounts, nil
+}
+
 func (am *DefaultAccountManager) createAccount(accountId, userId, domain string) (*Account, error) {
 	account := newAccountWithId(accountId, userId, domain)
 
@@ -578,7 +806,7 @@ func newAccountWithId(accountId, userId, domain string) *Account {
 
 	setupKeys := make(map[string]*SetupKey)
 	defaultKey := GenerateDefaultSetupKey()
-	oneOffKey := GenerateSetupKey("One-off key", SetupKeyOneOff, DefaultSetupKeyDuration)
+	oneOffKey := GenerateSetupKey("One-off key", SetupKeyOneOff, DefaultSetupKeyDuration, 0)
 	setupKeys[defaultKey.Key] = defaultKey
 	setupKeys[oneOffKey.Key] = oneOffKey
 	network := NewNetwork()
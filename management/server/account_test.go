@@ -1,13 +1,21 @@
 package server
 
 import (
+	"fmt"
+	"math/rand"
 	"net"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestAccountManager_GetOrCreateAccountByUser(t *testing.T) {
@@ -548,7 +556,7 @@ func TestAccountManager_DeletePeer(t *testing.T) {
 		return
 	}
 
-	_, err = manager.DeletePeer(account.Id, peerKey)
+	_, err = manager.DeletePeer(account.Id, peerKey, "account_creator")
 	if err != nil {
 		return
 	}
@@ -564,6 +572,403 @@ func TestAccountManager_DeletePeer(t *testing.T) {
 	}
 }
 
+func TestAccountManager_ConcurrentUpdates(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	const workers = 10
+	const updatesPerWorker = 5
+
+	var accounts []*Account
+	for i := 0; i < 2; i++ {
+		account, err := manager.AddAccount(fmt.Sprintf("test_account%d", i), "account_creator", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		accounts = append(accounts, account)
+	}
+
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		var setupKey *SetupKey
+		for _, key := range account.SetupKeys {
+			if key.Type == SetupKeyReusable {
+				setupKey = key
+			}
+		}
+
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(accountId string, worker int) {
+				defer wg.Done()
+				for i := 0; i < updatesPerWorker; i++ {
+					key, err := wgtypes.GeneratePrivateKey()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: "peer"})
+					if err != nil {
+						t.Errorf("expecting peer to be added, got failure %v", err)
+						return
+					}
+
+					group := &Group{ID: fmt.Sprintf("worker%d-group%d", worker, i), Name: "group", Peers: []string{peer.Key}}
+					err = manager.SaveGroup(accountId, "account_creator", group)
+					if err != nil {
+						t.Errorf("expecting group to be saved, got failure %v", err)
+						return
+					}
+
+					_, err = manager.AddSetupKey(accountId, fmt.Sprintf("worker%d-key%d", worker, i), SetupKeyOneOff, nil, 0, "account_creator")
+					if err != nil {
+						t.Errorf("expecting setup key to be added, got failure %v", err)
+						return
+					}
+
+					err = manager.MarkPeerConnected(peer.Key, true)
+					if err != nil {
+						t.Errorf("expecting peer to be marked as connected, got failure %v", err)
+						return
+					}
+				}
+			}(account.Id, w)
+		}
+	}
+	wg.Wait()
+
+	for _, initial := range accounts {
+		account, err := manager.GetAccountById(initial.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectedChanges := workers * updatesPerWorker
+		assert.Len(t, account.Peers, expectedChanges, "lost registered peers")
+		// the All group, the worker groups
+		assert.Len(t, account.Groups, expectedChanges+1, "lost saved groups")
+		// the default keys and the added ones
+		assert.Len(t, account.SetupKeys, expectedChanges+len(initial.SetupKeys), "lost added setup keys")
+
+		allGroup, err := account.GetGroupAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, allGroup.Peers, expectedChanges, "lost peers of the All group")
+
+		ips := map[string]struct{}{}
+		for _, peer := range account.Peers {
+			assert.True(t, peer.Status.Connected, "lost the status of peer %s", peer.Key)
+			ips[peer.IP.String()] = struct{}{}
+		}
+		assert.Len(t, ips, expectedChanges, "allocated the same IP to multiple peers")
+
+		for _, key := range account.SetupKeys {
+			if key.Type == SetupKeyReusable {
+				assert.Equal(t, expectedChanges, key.UsedTimes, "lost setup key usages")
+			}
+		}
+	}
+}
+
+func TestAccountManager_SetupKeys(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.AddSetupKey(account.Id, "negative", SetupKeyReusable, nil, -1, "account_creator")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting a negative usage limit to fail with InvalidArgument, got %v", err)
+	}
+
+	limitedKey, err := manager.AddSetupKey(account.Id, "limited", SetupKeyReusable, nil, 2, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revokedKey, err := manager.AddSetupKey(account.Id, "revoked", SetupKeyReusable, nil, 0, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addPeer := func(setupKey string) error {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = manager.AddPeer(setupKey, "", &Peer{Key: key.PublicKey().String(), Name: "peer"})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		err = addPeer(limitedKey.Key)
+		if err != nil {
+			t.Fatalf("expecting peer to be added with a key within its usage limit, got failure %v", err)
+		}
+	}
+	err = addPeer(limitedKey.Key)
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "usage limit reached") {
+		t.Errorf("expecting registration with a key having reached its usage limit to fail, got %v", err)
+	}
+
+	_, err = manager.RevokeSetupKey(account.Id, revokedKey.Id, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = addPeer(revokedKey.Key)
+	if status.Code(err) != codes.PermissionDenied || !strings.Contains(err.Error(), "setup key revoked") {
+		t.Errorf("expecting registration with a revoked key to fail with PermissionDenied, got %v", err)
+	}
+
+	_, err = manager.RevokeSetupKey(account.Id, "unknown", "account_creator")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting revoking an unknown key to fail with NotFound, got %v", err)
+	}
+
+	keys, err := manager.ListSetupKeys(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the default keys and the added ones
+	assert.Len(t, keys, len(account.SetupKeys)+2)
+	for i := 1; i < len(keys); i++ {
+		assert.False(t, keys[i].CreatedAt.Before(keys[i-1].CreatedAt), "expecting keys sorted by the creation time")
+	}
+
+	listed := map[string]*SetupKey{}
+	for _, key := range keys {
+		listed[key.Id] = key
+	}
+	if assert.Contains(t, listed, limitedKey.Id) {
+		assert.Equal(t, 2, listed[limitedKey.Id].UsedTimes)
+		assert.Equal(t, 2, listed[limitedKey.Id].UsageLimit)
+		assert.False(t, listed[limitedKey.Id].LastUsed.IsZero())
+		assert.True(t, listed[limitedKey.Id].IsOverUsed())
+	}
+	if assert.Contains(t, listed, revokedKey.Id) {
+		assert.True(t, listed[revokedKey.Id].Revoked)
+		assert.Equal(t, 0, listed[revokedKey.Id].UsedTimes)
+	}
+
+	_, err = manager.ListSetupKeys("unknown")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting listing keys of an unknown account to fail with NotFound, got %v", err)
+	}
+}
+
+func TestAccountManager_ConcurrentRevokeSetupKey(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const keysCount = 10
+	var keys []*SetupKey
+	for i := 0; i < keysCount; i++ {
+		key, err := manager.AddSetupKey(account.Id, fmt.Sprintf("key%d", i), SetupKeyReusable, nil, 0, "account_creator")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	// register peers with the first key while all the keys are being revoked
+	var registered int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < 5; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5; i++ {
+				peerKey, err := wgtypes.GeneratePrivateKey()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, err = manager.AddPeer(keys[0].Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+				if err == nil {
+					mu.Lock()
+					registered++
+					mu.Unlock()
+					continue
+				}
+				if status.Code(err) != codes.PermissionDenied {
+					t.Errorf("expecting registration to fail with PermissionDenied after the key is revoked, got %v", err)
+				}
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func(keyID string) {
+				defer wg.Done()
+				_, err := manager.RevokeSetupKey(account.Id, keyID, "account_creator")
+				if err != nil {
+					t.Errorf("expecting setup key to be revoked, got failure %v", err)
+				}
+			}(key.Id)
+		}
+	}
+	wg.Wait()
+
+	listed, err := manager.ListSetupKeys(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range listed {
+		for _, revoked := range keys {
+			if key.Id == revoked.Id {
+				assert.True(t, key.Revoked, "lost the revocation of key %s", key.Name)
+			}
+		}
+		if key.Id == keys[0].Id {
+			assert.Equal(t, registered, key.UsedTimes, "expecting the key usage to match the registered peers")
+		}
+	}
+
+	account, err = manager.GetAccountById(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, account.Peers, registered)
+}
+
+func TestAccountManager_PeersQuota(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	manager.SetMaxPeersPerAccount(2)
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := manager.AddSetupKey(account.Id, "key", SetupKeyReusable, nil, 0, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addPeer := func() error {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = manager.AddPeer(key.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = addPeer(); err != nil {
+			t.Fatalf("expecting peer %d to be registered, got failure %v", i, err)
+		}
+	}
+	err = addPeer()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "expecting registration over the limit to fail, got %v", err)
+
+	quota, err := manager.GetPeersQuota(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &PeersQuota{Count: 2, Limit: 2}, quota)
+
+	// the account limit overrides the default one
+	unlock := manager.Store.AcquireAccountLock(account.Id)
+	account, err = manager.Store.GetAccount(account.Id)
+	if err == nil {
+		account.MaxPeers = 3
+		err = manager.Store.SaveAccount(account)
+	}
+	unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = addPeer(); err != nil {
+		t.Fatalf("expecting peer to be registered within the account limit, got failure %v", err)
+	}
+	err = addPeer()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err), "expecting registration over the account limit to fail, got %v", err)
+
+	quota, err = manager.GetPeersQuota(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &PeersQuota{Count: 3, Limit: 3}, quota)
+}
+
+func TestAccountManager_ConcurrentPeersQuota(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	const maxPeers = 5
+	manager.SetMaxPeersPerAccount(maxPeers)
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := manager.AddSetupKey(account.Id, "key", SetupKeyReusable, nil, 0, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// register more peers than the limit at the same time
+	var registered int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < 4*maxPeers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			peerKey, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, err = manager.AddPeer(key.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+			if err == nil {
+				mu.Lock()
+				registered++
+				mu.Unlock()
+				return
+			}
+			if status.Code(err) != codes.ResourceExhausted {
+				t.Errorf("expecting registration to fail with ResourceExhausted over the limit, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, maxPeers, registered)
+	account, err = manager.GetAccountById(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, account.Peers, maxPeers)
+}
+
 func TestGetUsersFromAccount(t *testing.T) {
 	manager, err := createManager(t)
 	if err != nil {
@@ -667,12 +1072,201 @@ func TestAccountManager_UpdatePeerMeta(t *testing.T) {
 
 }
 
+func TestAccountManager_UpdatePeerMetaWgPort(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setupKey, err := manager.AddSetupKey(account.Id, "key", SetupKeyReusable, nil, 0, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var peerKeys []string
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Meta: PeerSystemMeta{Hostname: "host"}})
+		if err != nil {
+			t.Fatalf("expecting peer to be added, got failure %v", err)
+		}
+		peerKeys = append(peerKeys, peer.Key)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peerKeys[1])
+	defer manager.peersUpdateManager.CloseChannel(peerKeys[1])
+
+	serial := func() uint64 {
+		account, err := manager.GetAccountById(account.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return account.Network.CurrentSerial()
+	}
+	remotePort := func(msg *UpdateMessage) int32 {
+		for _, remotePeer := range msg.Update.GetRemotePeers() {
+			if remotePeer.GetWgPubKey() == peerKeys[0] {
+				return remotePeer.GetWgPort()
+			}
+		}
+		t.Fatalf("expecting peer %s in the update", peerKeys[0])
+		return 0
+	}
+
+	// the port reported once the interface is configured is sent to the remote peers
+	before := serial()
+	err = manager.UpdatePeerMeta(peerKeys[0], PeerSystemMeta{Hostname: "host", WgPort: 40000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before+1, serial())
+	select {
+	case msg := <-updates:
+		assert.Equal(t, int32(40000), remotePort(msg))
+	default:
+		t.Fatal("expecting the remote peer to receive the new port")
+	}
+
+	// the login request doesn't carry the port, the reported one is kept
+	err = manager.UpdatePeerMeta(peerKeys[0], PeerSystemMeta{Hostname: "new-host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := manager.GetPeer(peerKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 40000, peer.Meta.WgPort)
+	assert.Equal(t, before+1, serial())
+	assert.Len(t, updates, 0)
+
+	// a restart picked another port
+	err = manager.UpdatePeerMeta(peerKeys[0], PeerSystemMeta{Hostname: "new-host", WgPort: 40001})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before+2, serial())
+	select {
+	case msg := <-updates:
+		assert.Equal(t, int32(40001), remotePort(msg))
+	default:
+		t.Fatal("expecting the remote peer to receive the changed port")
+	}
+}
+
+func TestAccountManager_AccountIsolation(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accountA, err := manager.AddAccount("account_a", "user_a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accountB, err := manager.AddAccount("account_b", "user_b", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both accounts use the same network and their peers get the same IPs
+	accountB.Network.Net = accountA.Network.Net
+	base := accountA.Network.Net.IP.To4()
+	err = manager.Store.SaveAccount(accountB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addPeers := func(account *Account, prefix string) []string {
+		var setupKey *SetupKey
+		for _, key := range account.SetupKeys {
+			if key.Type == SetupKeyReusable {
+				setupKey = key
+			}
+		}
+
+		var keys []string
+		for i := 0; i < 3; i++ {
+			key, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ip := net.IP{base[0], base[1], base[2], byte(10 + i)}
+			peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("%s-%d", prefix, i), IP: ip})
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, peer.Key)
+		}
+		return keys
+	}
+	peersA := addPeers(accountA, "a")
+	peersB := addPeers(accountB, "b")
+
+	assertNetworkMap := func(peers []string, others []string) {
+		for _, key := range peers {
+			networkMap, err := manager.GetNetworkMap(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(networkMap.Peers) != len(peers)-1 {
+				t.Errorf("expecting the network map of peer %s to have %d peers, got %d", key, len(peers)-1, len(networkMap.Peers))
+			}
+			for _, remote := range networkMap.Peers {
+				for _, other := range others {
+					if remote.Key == other {
+						t.Errorf("expecting the network map of peer %s not to include peer %s of another account", key, other)
+					}
+				}
+			}
+		}
+	}
+	assertNetworkMap(peersA, peersB)
+	assertNetworkMap(peersB, peersA)
+
+	// changes of one account aren't sent to the peers of another account
+	for _, key := range peersB {
+		manager.peersUpdateManager.CreateChannel(key)
+		defer manager.peersUpdateManager.CloseChannel(key)
+	}
+	_, err = manager.DeletePeer(accountA.Id, peersA[0], "user_a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range peersB {
+		if len(manager.peersUpdateManager.peerChannels[key]) != 0 {
+			t.Errorf("expecting peer %s not to receive an update of another account", key)
+		}
+	}
+
+	// a peer of one account can't be registered in another one
+	var setupKeyA *SetupKey
+	for _, key := range accountA.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKeyA = key
+		}
+	}
+	_, err = manager.AddPeer(setupKeyA.Key, "", &Peer{Key: peersB[0], Name: "stolen"})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting a peer of another account to be rejected with %s, got %v", codes.AlreadyExists, err)
+	}
+}
+
 func createManager(t *testing.T) (*DefaultAccountManager, error) {
 	store, err := createStore(t)
 	if err != nil {
 		return nil, err
 	}
-	return BuildManager(store, NewPeersUpdateManager(), nil)
+	return BuildManager(store, NewPeersUpdateManager(), nil, nil)
 }
 
 func createStore(t *testing.T) (Store, error) {
@@ -684,3 +1278,371 @@ func createStore(t *testing.T) (Store, error) {
 
 	return store, nil
 }
+
+func TestAccountManager_CreateAccount(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, network, _ := net.ParseCIDR("100.70.0.0/16")
+	setupKey := GenerateSetupKey("servers", SetupKeyReusable, time.Hour, 0)
+	account, err := manager.CreateAccount("acme", network, []*SetupKey{setupKey}, "admin")
+	require.NoError(t, err)
+	assert.Equal(t, "100.70.0.0/16", account.Network.Net.String())
+	assert.Len(t, account.SetupKeys, 1, "expecting the requested setup keys instead of the default ones")
+	_, err = account.GetGroupAll()
+	assert.NoError(t, err)
+	assert.Len(t, account.Rules, 1)
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: "server"})
+	require.NoError(t, err)
+	assert.True(t, network.Contains(peer.IP), "expecting peer IP %s to belong to the account network", peer.IP)
+
+	_, err = manager.CreateAccount("acme", nil, nil, "admin")
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, tooSmall, _ := net.ParseCIDR("100.70.0.0/31")
+	_, err = manager.CreateAccount("small", tooSmall, nil, "admin")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// a setup key belongs to a single account
+	_, err = manager.CreateAccount("copycat", nil, []*SetupKey{setupKey}, "admin")
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = manager.GetAccountById("copycat")
+	assert.Equal(t, codes.NotFound, status.Code(err), "expecting the account not to be created")
+
+	generated, err := manager.CreateAccount("", nil, nil, "admin")
+	require.NoError(t, err)
+	assert.NotEmpty(t, generated.Id)
+	assert.Len(t, generated.SetupKeys, 2, "expecting the default setup keys")
+
+	accounts, err := manager.ListAccounts()
+	require.NoError(t, err)
+	var ids []string
+	for _, account := range accounts {
+		ids = append(ids, account.Id)
+	}
+	expected := []string{"acme", generated.Id}
+	sort.Strings(expected)
+	assert.Equal(t, expected, ids)
+}
+
+func TestAccountManager_CrossAccountAccess(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, networkA, _ := net.ParseCIDR("100.70.0.0/16")
+	_, networkB, _ := net.ParseCIDR("100.71.0.0/16")
+	keyA := GenerateSetupKey("a", SetupKeyReusable, time.Hour, 0)
+	keyB := GenerateSetupKey("b", SetupKeyReusable, time.Hour, 0)
+	accountA, err := manager.CreateAccount("account_a", networkA, []*SetupKey{keyA}, "admin")
+	require.NoError(t, err)
+	accountB, err := manager.CreateAccount("account_b", networkB, []*SetupKey{keyB}, "admin")
+	require.NoError(t, err)
+
+	addPeer := func(setupKey *SetupKey, name string) *Peer {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: name})
+		require.NoError(t, err)
+		return peer
+	}
+	peerA := addPeer(keyA, "a")
+	peerB := addPeer(keyB, "b")
+
+	groupA := &Group{ID: "group_a", Name: "a", Peers: []string{peerA.Key}}
+	require.NoError(t, manager.SaveGroup(accountA.Id, "admin", groupA))
+	ruleA := &Rule{ID: "rule_a", Name: "a", Source: []string{groupA.ID}, Destination: []string{groupA.ID}}
+	require.NoError(t, manager.SaveRule(accountA.Id, "admin", ruleA))
+	routeA := &Route{ID: "route_a", Prefix: "192.168.10.0/24", Peer: peerA.Key, Groups: []string{groupA.ID}}
+	require.NoError(t, manager.SaveRoute(accountA.Id, "admin", routeA))
+	reservationA := &IPReservation{ID: "reservation_a", IP: net.ParseIP("100.70.0.200"), PeerName: "db"}
+	require.NoError(t, manager.SaveIPReservation(accountA.Id, "admin", reservationA))
+
+	allB, err := accountB.GetGroupAll()
+	require.NoError(t, err)
+
+	before, err := manager.GetAccountById(accountA.Id)
+	require.NoError(t, err)
+
+	// every call made on behalf of account B with the objects of account A has to fail
+	calls := map[string]func() error{
+		"RenamePeer": func() error {
+			_, err := manager.RenamePeer(accountB.Id, peerA.Key, "stolen", "user_b")
+			return err
+		},
+		"UpdatePeerIP": func() error {
+			_, err := manager.UpdatePeerIP(accountB.Id, peerA.Key, net.ParseIP("100.71.0.100"), "user_b")
+			return err
+		},
+		"UpdatePeerRateLimit": func() error {
+			_, err := manager.UpdatePeerRateLimit(accountB.Id, peerA.Key, 1, "user_b")
+			return err
+		},
+		"UpdatePeerStaticEndpoint": func() error {
+			_, err := manager.UpdatePeerStaticEndpoint(accountB.Id, peerA.Key, "203.0.113.1:51820", "user_b")
+			return err
+		},
+		"UpdatePeerRouteMetric": func() error {
+			_, err := manager.UpdatePeerRouteMetric(accountB.Id, peerA.Key, 10, "user_b")
+			return err
+		},
+		"UpdatePeerSSH": func() error {
+			_, err := manager.UpdatePeerSSH(accountB.Id, peerA.Key, true, []string{allB.ID}, "user_b")
+			return err
+		},
+		"UpdatePeerSSHWithForeignGroup": func() error {
+			_, err := manager.UpdatePeerSSH(accountB.Id, peerB.Key, true, []string{groupA.ID}, "user_b")
+			return err
+		},
+		"UpdatePeerTags": func() error {
+			_, err := manager.UpdatePeerTags(accountB.Id, peerA.Key, []string{"stolen"}, "user_b")
+			return err
+		},
+		"SuspendPeer": func() error {
+			_, err := manager.SuspendPeer(accountB.Id, peerA.Key, true, "user_b")
+			return err
+		},
+		"DeletePeer": func() error {
+			_, err := manager.DeletePeer(accountB.Id, peerA.Key, "user_b")
+			return err
+		},
+		"GetPeerByIP": func() error {
+			_, err := manager.GetPeerByIP(accountB.Id, peerA.IP.String())
+			return err
+		},
+		"RevokeSetupKey": func() error {
+			_, err := manager.RevokeSetupKey(accountB.Id, keyA.Id, "user_b")
+			return err
+		},
+		"RenameSetupKey": func() error {
+			_, err := manager.RenameSetupKey(accountB.Id, keyA.Id, "stolen", "user_b")
+			return err
+		},
+		"GetGroup": func() error {
+			_, err := manager.GetGroup(accountB.Id, groupA.ID)
+			return err
+		},
+		"GroupListPeers": func() error {
+			_, err := manager.GroupListPeers(accountB.Id, groupA.ID)
+			return err
+		},
+		"GroupAddPeerToForeignGroup": func() error {
+			return manager.GroupAddPeer(accountB.Id, "user_b", groupA.ID, peerB.Key)
+		},
+		"GroupAddForeignPeer": func() error {
+			return manager.GroupAddPeer(accountB.Id, "user_b", allB.ID, peerA.Key)
+		},
+		"GroupDeletePeer": func() error {
+			return manager.GroupDeletePeer(accountB.Id, "user_b", groupA.ID, peerA.Key)
+		},
+		"SaveGroupWithForeignPeer": func() error {
+			return manager.SaveGroup(accountB.Id, "user_b", &Group{ID: "stolen", Name: "stolen", Peers: []string{peerA.Key}})
+		},
+		"GetRule": func() error {
+			_, err := manager.GetRule(accountB.Id, ruleA.ID)
+			return err
+		},
+		"SaveRuleWithForeignGroup": func() error {
+			return manager.SaveRule(accountB.Id, "user_b", &Rule{ID: "stolen", Name: "stolen",
+				Source: []string{allB.ID}, Destination: []string{groupA.ID}})
+		},
+		"GetRoute": func() error {
+			_, err := manager.GetRoute(accountB.Id, routeA.ID)
+			return err
+		},
+		"SaveRouteThroughForeignPeer": func() error {
+			return manager.SaveRoute(accountB.Id, "user_b", &Route{ID: "stolen", Prefix: "192.168.20.0/24", Peer: peerA.Key})
+		},
+		"AddPeerWithForeignKey": func() error {
+			_, err := manager.AddPeer(keyA.Key, "", &Peer{Key: peerB.Key, Name: "stolen"})
+			return err
+		},
+		"ReplacePeerKeyWithForeignKey": func() error {
+			_, err := manager.ReplacePeerKey(peerB.Key, peerA.Key)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("expecting %s to fail with the objects of another account", name)
+		}
+	}
+
+	// removing unknown objects is a no-op
+	assert.NoError(t, manager.DeleteGroup(accountB.Id, "user_b", groupA.ID))
+	assert.NoError(t, manager.DeleteRule(accountB.Id, "user_b", ruleA.ID))
+	assert.NoError(t, manager.DeleteRoute(accountB.Id, "user_b", routeA.ID))
+	_ = manager.DeleteIPReservation(accountB.Id, "user_b", reservationA.ID)
+
+	after, err := manager.GetAccountById(accountA.Id)
+	require.NoError(t, err)
+	assert.Equal(t, before, after, "expecting account A not to be changed on behalf of account B")
+
+	networkMap, err := manager.GetNetworkMap(peerB.Key)
+	require.NoError(t, err)
+	assert.Empty(t, networkMap.Peers)
+	assert.Empty(t, networkMap.Routes)
+}
+
+// TestAccountManager_ConcurrentMultiAccountRegistration registers random peer keys with the setup keys of random
+// accounts concurrently, each key being tried in several accounts, and checks that every key ends up in one account
+// only and that the network maps never mix the accounts
+func TestAccountManager_ConcurrentMultiAccountRegistration(t *testing.T) {
+	stores := map[string]storeOpener{
+		"FileStore":   func(dataDir string) (Store, error) { return NewStore(dataDir) },
+		"SqliteStore": func(dataDir string) (Store, error) { return NewSqliteStore(dataDir) },
+	}
+	for name, open := range stores {
+		t.Run(name, func(t *testing.T) {
+			testConcurrentMultiAccountRegistration(t, open)
+		})
+	}
+}
+
+func testConcurrentMultiAccountRegistration(t *testing.T, open storeOpener) {
+	const (
+		accountsCount = 8
+		workers       = 16
+		attempts      = 20
+		keysCount     = workers * attempts / 2
+	)
+	seed := time.Now().UnixNano()
+	t.Logf("seed %d", seed)
+
+	dataDir := t.TempDir()
+	store, err := open(dataDir)
+	require.NoError(t, err)
+	manager, err := BuildManager(store, NewPeersUpdateManager(), nil, nil)
+	require.NoError(t, err)
+
+	var setupKeys []*SetupKey
+	var allGroups []string
+	for i := 0; i < accountsCount; i++ {
+		_, network, _ := net.ParseCIDR(fmt.Sprintf("100.%d.0.0/16", 64+i))
+		setupKey := GenerateSetupKey("key", SetupKeyReusable, time.Hour, 0)
+		account, err := manager.CreateAccount(fmt.Sprintf("account_%d", i), network, []*SetupKey{setupKey}, "admin")
+		require.NoError(t, err)
+		all, err := account.GetGroupAll()
+		require.NoError(t, err)
+		setupKeys = append(setupKeys, setupKey)
+		allGroups = append(allGroups, all.ID)
+	}
+
+	peerKeys := make([]string, keysCount)
+	for i := range peerKeys {
+		key, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		peerKeys[i] = key.PublicKey().String()
+	}
+
+	var mu sync.Mutex
+	owners := map[string]int{}
+	// grouped are the accounts that have accepted a peer in their All group
+	grouped := map[string][]int{}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed + int64(worker)))
+			for i := 0; i < attempts; i++ {
+				key := peerKeys[r.Intn(len(peerKeys))]
+				account := r.Intn(accountsCount)
+
+				switch r.Intn(3) {
+				case 0, 1:
+					_, err := manager.AddPeer(setupKeys[account].Key, "",
+						&Peer{Key: key, Name: fmt.Sprintf("worker%d-%d", worker, i)})
+					if err == nil {
+						mu.Lock()
+						if previous, ok := owners[key]; ok {
+							t.Errorf("peer %s registered in accounts %d and %d", key, previous, account)
+						}
+						owners[key] = account
+						mu.Unlock()
+					} else if status.Code(err) != codes.AlreadyExists {
+						t.Errorf("expecting a registered peer to be rejected with %s, got %v", codes.AlreadyExists, err)
+					}
+				case 2:
+					accountID := fmt.Sprintf("account_%d", account)
+					err := manager.GroupAddPeer(accountID, "admin", allGroups[account], key)
+					if err != nil {
+						if status.Code(err) != codes.NotFound {
+							t.Errorf("expecting a peer of another account to be rejected with %s, got %v", codes.NotFound, err)
+						}
+						continue
+					}
+					mu.Lock()
+					grouped[key] = append(grouped[key], account)
+					mu.Unlock()
+					if _, err := manager.GetNetworkMap(key); err != nil {
+						t.Errorf("failed getting network map of peer %s: %v", key, err)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for key, accounts := range grouped {
+		for _, account := range accounts {
+			if owner, ok := owners[key]; !ok || owner != account {
+				t.Errorf("peer %s of account %d has been added to a group of account %d", key, owner, account)
+			}
+		}
+	}
+
+	checkOwners := func(store Store) {
+		accounts := store.GetAllAccounts()
+		require.Len(t, accounts, accountsCount)
+		registered := 0
+		for _, account := range accounts {
+			ips := map[string]bool{}
+			for key, peer := range account.Peers {
+				registered++
+				owner, ok := owners[key]
+				if !ok || fmt.Sprintf("account_%d", owner) != account.Id {
+					t.Errorf("expecting peer %s in account %d only, found in %s", key, owner, account.Id)
+				}
+				if !account.Network.Net.Contains(peer.IP) {
+					t.Errorf("peer %s has IP %s outside of the network %s of its account", key, peer.IP, account.Network.Net.String())
+				}
+				if ips[peer.IP.String()] {
+					t.Errorf("IP %s is assigned twice in account %s", peer.IP, account.Id)
+				}
+				ips[peer.IP.String()] = true
+
+				peerAccount, err := store.GetPeerAccount(key)
+				require.NoError(t, err)
+				assert.Equal(t, account.Id, peerAccount.Id)
+			}
+		}
+		assert.Equal(t, len(owners), registered)
+	}
+	checkOwners(store)
+
+	for key, owner := range owners {
+		networkMap, err := manager.GetNetworkMap(key)
+		require.NoError(t, err)
+		for _, remote := range networkMap.Peers {
+			if owners[remote.Key] != owner {
+				t.Errorf("network map of peer %s of account %d includes peer %s of account %d", key, owner,
+					remote.Key, owners[remote.Key])
+			}
+		}
+	}
+
+	// the ownership is persisted
+	require.NoError(t, store.Close())
+	reopened, err := open(dataDir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	checkOwners(reopened)
+}
@@ -0,0 +1,147 @@
+package activity
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Activity is the type of the account event
+type Activity int
+
+const (
+	// PeerAddedByUser indicates that a user registered a new peer with the SSO login
+	PeerAddedByUser Activity = iota
+	// PeerAddedWithSetupKey indicates that a new peer was registered with a setup key (the setup key was used)
+	PeerAddedWithSetupKey
+	// PeerRemovedByUser indicates that a user removed a peer from the account
+	PeerRemovedByUser
+	// SetupKeyCreated indicates that a user created a new setup key
+	SetupKeyCreated
+	// GroupCreated indicates that a user created a new group
+	GroupCreated
+	// GroupUpdated indicates that a user updated a group (e.g. added or removed peers)
+	GroupUpdated
+	// GroupDeleted indicates that a user deleted a group
+	GroupDeleted
+	// PeerLoginExpired indicates that the login of a peer registered by a user has expired
+	PeerLoginExpired
+	// SetupKeyRevoked indicates that a user revoked a setup key
+	SetupKeyRevoked
+	// SetupKeyRenamed indicates that a user renamed a setup key
+	SetupKeyRenamed
+	// RuleCreated indicates that a user created a new access rule
+	RuleCreated
+	// RuleUpdated indicates that a user updated an access rule
+	RuleUpdated
+	// RuleDeleted indicates that a user deleted an access rule
+	RuleDeleted
+	// PeerUpdated indicates that a user changed the settings of a peer (e.g. its name, IP or rate limit)
+	PeerUpdated
+	// AccountSettingsUpdated indicates that a user changed the settings of the account (e.g. the login expiration)
+	AccountSettingsUpdated
+	// AccountNetworkUpdated indicates that a user changed the network range of the account
+	AccountNetworkUpdated
+	// AccountImported indicates that a user imported an account export into the account
+	AccountImported
+	// PeerKeyReplaced indicates that a peer replaced its Wireguard key (the old key is the initiator)
+	PeerKeyReplaced
+	// PeerSuspended indicates that a user cut off a peer from the network keeping its configuration
+	PeerSuspended
+	// PeerResumed indicates that a user resumed a suspended peer
+	PeerResumed
+	// RouteCreated indicates that a user created a new network route
+	RouteCreated
+	// RouteUpdated indicates that a user updated a network route
+	RouteUpdated
+	// RouteDeleted indicates that a user deleted a network route
+	RouteDeleted
+	// IPReservationCreated indicates that a user reserved an IP for a peer
+	IPReservationCreated
+	// IPReservationUpdated indicates that a user updated an IP reservation
+	IPReservationUpdated
+	// IPReservationDeleted indicates that a user deleted an IP reservation
+	IPReservationDeleted
+	// AccountCreated indicates that the operator of the Management service created an account
+	AccountCreated
+)
+
+var activityStrings = map[Activity]string{
+	PeerAddedByUser:        "peer.user.add",
+	PeerAddedWithSetupKey:  "peer.setupkey.add",
+	PeerRemovedByUser:      "peer.user.delete",
+	SetupKeyCreated:        "setupkey.add",
+	GroupCreated:           "group.add",
+	GroupUpdated:           "group.update",
+	GroupDeleted:           "group.delete",
+	PeerLoginExpired:       "peer.login.expire",
+	SetupKeyRevoked:        "setupkey.revoke",
+	SetupKeyRenamed:        "setupkey.rename",
+	RuleCreated:            "rule.add",
+	RuleUpdated:            "rule.update",
+	RuleDeleted:            "rule.delete",
+	PeerUpdated:            "peer.update",
+	AccountSettingsUpdated: "account.settings.update",
+	AccountNetworkUpdated:  "account.network.update",
+	AccountImported:        "account.import",
+	PeerKeyReplaced:        "peer.key.replace",
+	PeerSuspended:          "peer.suspend",
+	PeerResumed:            "peer.resume",
+	RouteCreated:           "route.add",
+	RouteUpdated:           "route.update",
+	RouteDeleted:           "route.delete",
+	IPReservationCreated:   "ipreservation.add",
+	IPReservationUpdated:   "ipreservation.update",
+	IPReservationDeleted:   "ipreservation.delete",
+	AccountCreated:         "account.add",
+}
+
+// String returns a machine readable code of the activity
+func (a Activity) String() string {
+	if s, ok := activityStrings[a]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// ParseActivity returns the activity of a machine readable code, false if the code is unknown
+func ParseActivity(code string) (Activity, bool) {
+	for activity, s := range activityStrings {
+		if s == code {
+			return activity, true
+		}
+	}
+	return 0, false
+}
+
+// Event is an account change record
+type Event struct {
+	// ID is a sequential number of the event assigned when it is persisted
+	ID uint64
+	// Timestamp of the event
+	Timestamp time.Time
+	// Activity that happened during the event
+	Activity Activity
+	// AccountID of the account where the event happened
+	AccountID string
+	// InitiatorID is the ID of a user or a setup key that initiated the event
+	InitiatorID string
+	// TargetID is the ID of the changed object (e.g. peer key, setup key ID or group ID)
+	TargetID string
+	// Meta holds additional human readable details of the event (e.g. the name of the changed object)
+	Meta map[string]string
+	// Before is the JSON state of the changed object before the event, empty if the object has been created
+	Before json.RawMessage `json:",omitempty"`
+	// After is the JSON state of the changed object after the event, empty if the object has been deleted
+	After json.RawMessage `json:",omitempty"`
+}
+
+// Store provides a way to record and query account events
+type Store interface {
+	// Save records the event. Implementations must not block the caller
+	Save(event *Event)
+	// Get returns events of the account that happened within the [from, to] time range sorted by ID.
+	// A zero from or to time doesn't limit the range
+	Get(accountID string, from, to time.Time) ([]*Event, error)
+	// Close flushes pending events and releases the resources
+	Close() error
+}
@@ -0,0 +1,255 @@
+package activity
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// eventsFileName is a name of the file holding the account events, one JSON object per line
+	eventsFileName = "events.log"
+	// maxEventsFileSize is a size of the events file after which the file is rotated
+	maxEventsFileSize = 10 * 1024 * 1024
+	// maxEventsBackups is a number of the rotated events files kept (events.log.1 being the most recent)
+	maxEventsBackups = 5
+	// eventQueueSize is a number of events buffered before being written to the file.
+	// Events are dropped when the queue is full so that the callers are never blocked
+	eventQueueSize = 1000
+)
+
+// FileStore is an append-only Store persisting events to a rotated file in the data directory.
+// Events are buffered in a bounded queue and written asynchronously
+type FileStore struct {
+	file      string
+	maxSize   int64
+	queue     chan *Event
+	done      chan struct{}
+	lastID    uint64
+	closed    bool
+	closeLock sync.RWMutex
+	// fileLock synchronises writing, rotating and reading the events files
+	fileLock sync.Mutex
+}
+
+// NewFileStore creates a FileStore persisting events in the dataDir and starts the background writer
+func NewFileStore(dataDir string) (*FileStore, error) {
+	return newFileStore(filepath.Join(dataDir, eventsFileName), maxEventsFileSize)
+}
+
+func newFileStore(file string, maxSize int64) (*FileStore, error) {
+	s := &FileStore{
+		file:    file,
+		maxSize: maxSize,
+		queue:   make(chan *Event, eventQueueSize),
+		done:    make(chan struct{}),
+	}
+
+	// continue the IDs sequence of the persisted events
+	for _, f := range s.files() {
+		err := readEvents(f, func(event *Event) {
+			if event.ID > s.lastID {
+				s.lastID = event.ID
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Save queues the event for writing. The event is dropped if the queue is full
+func (s *FileStore) Save(event *Event) {
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.queue <- event:
+	default:
+		log.Warnf("dropping %s event of account %s, the events queue is full", event.Activity, event.AccountID)
+	}
+}
+
+// Get returns events of the account that happened within the [from, to] time range sorted by ID.
+// Events still waiting in the queue are not included
+func (s *FileStore) Get(accountID string, from, to time.Time) ([]*Event, error) {
+	s.fileLock.Lock()
+	defer s.fileLock.Unlock()
+
+	events := []*Event{}
+	for _, f := range s.files() {
+		err := readEvents(f, func(event *Event) {
+			if event.AccountID != accountID {
+				return
+			}
+			if !from.IsZero() && event.Timestamp.Before(from) {
+				return
+			}
+			if !to.IsZero() && event.Timestamp.After(to) {
+				return
+			}
+			events = append(events, event)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, nil
+}
+
+// Close stops accepting new events and waits until the queued ones are written
+func (s *FileStore) Close() error {
+	s.closeLock.Lock()
+	if s.closed {
+		s.closeLock.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.closeLock.Unlock()
+
+	<-s.done
+	return nil
+}
+
+// run writes queued events to the file until the queue is closed.
+// Events are written in batches of whatever is queued at the moment
+func (s *FileStore) run() {
+	defer close(s.done)
+
+	for event := range s.queue {
+		batch := []*Event{event}
+	drain:
+		for {
+			select {
+			case next, ok := <-s.queue:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		err := s.write(batch)
+		if err != nil {
+			log.Errorf("failed writing %d account events to %s: %v", len(batch), s.file, err)
+		}
+	}
+}
+
+// write appends events to the file rotating it if it has grown too large
+func (s *FileStore) write(events []*Event) error {
+	s.fileLock.Lock()
+	defer s.fileLock.Unlock()
+
+	err := s.rotateIfNeeded()
+	if err != nil {
+		log.Warnf("failed rotating account events file %s: %v", s.file, err)
+	}
+
+	f, err := os.OpenFile(s.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		s.lastID++
+		event.ID = s.lastID
+		err = encoder.Encode(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// rotateIfNeeded renames the events file to events.log.1 shifting older backups
+// if the file exceeds the max size. The oldest backup is removed
+func (s *FileStore) rotateIfNeeded() error {
+	info, err := os.Stat(s.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < s.maxSize {
+		return nil
+	}
+
+	err = os.Remove(backupFileName(s.file, maxEventsBackups))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := maxEventsBackups - 1; i > 0; i-- {
+		err = os.Rename(backupFileName(s.file, i), backupFileName(s.file, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(s.file, backupFileName(s.file, 1))
+}
+
+// files returns the events files from the oldest to the most recent
+func (s *FileStore) files() []string {
+	var files []string
+	for i := maxEventsBackups; i > 0; i-- {
+		files = append(files, backupFileName(s.file, i))
+	}
+	return append(files, s.file)
+}
+
+func backupFileName(file string, index int) string {
+	return fmt.Sprintf("%s.%d", file, index)
+}
+
+// readEvents decodes events from the file calling the handler for each of them. Missing files are ignored.
+// A corrupted record (e.g. partially written on a crash) stops reading the file without failing
+func readEvents(file string, handler func(event *Event)) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		event := &Event{}
+		err = decoder.Decode(event)
+		if err != nil {
+			log.Warnf("skipping the rest of the corrupted account events file %s: %v", file, err)
+			return nil
+		}
+		handler(event)
+	}
+
+	return nil
+}
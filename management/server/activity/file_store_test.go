@@ -0,0 +1,118 @@
+package activity
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_SaveAndGet(t *testing.T) {
+	dataDir := t.TempDir()
+	store, err := NewFileStore(dataDir)
+	require.NoError(t, err)
+
+	start := time.Now().UTC()
+	for i := 0; i < 10; i++ {
+		accountID := "account1"
+		if i%2 == 1 {
+			accountID = "account2"
+		}
+		store.Save(&Event{
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+			Activity:    PeerAddedWithSetupKey,
+			AccountID:   accountID,
+			InitiatorID: "setup-key",
+			TargetID:    fmt.Sprintf("peer%d", i),
+		})
+	}
+	require.NoError(t, store.Close())
+
+	events, err := store.Get("account1", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 5)
+	for i, event := range events {
+		assert.Equal(t, fmt.Sprintf("peer%d", i*2), event.TargetID)
+		assert.Equal(t, uint64(i*2+1), event.ID)
+	}
+
+	events, err = store.Get("account2", start.Add(2*time.Minute), start.Add(6*time.Minute))
+	require.NoError(t, err)
+	var targets []string
+	for _, event := range events {
+		targets = append(targets, event.TargetID)
+	}
+	assert.Equal(t, []string{"peer3", "peer5"}, targets)
+
+	// saving after close must not panic
+	store.Save(&Event{AccountID: "account1"})
+
+	// reopened store continues the IDs sequence
+	store, err = NewFileStore(dataDir)
+	require.NoError(t, err)
+	store.Save(&Event{Timestamp: time.Now().UTC(), Activity: GroupCreated, AccountID: "account1"})
+	require.NoError(t, store.Close())
+
+	events, err = store.Get("account1", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+	assert.Equal(t, uint64(11), events[5].ID)
+	assert.Equal(t, GroupCreated, events[5].Activity)
+
+	info, err := os.Stat(filepath.Join(dataDir, eventsFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestFileStore_Rotation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), eventsFileName)
+
+	// a tiny max size rotates the file before every write
+	for i := 0; i < maxEventsBackups+3; i++ {
+		store, err := newFileStore(file, 1)
+		require.NoError(t, err)
+		store.Save(&Event{Timestamp: time.Now().UTC(), AccountID: "account", TargetID: fmt.Sprintf("peer%d", i)})
+		require.NoError(t, store.Close())
+	}
+
+	_, err := os.Stat(backupFileName(file, maxEventsBackups+1))
+	assert.True(t, os.IsNotExist(err), "only %d backups should be kept", maxEventsBackups)
+
+	store, err := newFileStore(file, 1)
+	require.NoError(t, err)
+	defer store.Close()
+
+	events, err := store.Get("account", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, events, maxEventsBackups+1)
+	assert.Equal(t, "peer2", events[0].TargetID)
+	assert.Equal(t, fmt.Sprintf("peer%d", maxEventsBackups+2), events[maxEventsBackups].TargetID)
+}
+
+func TestFileStore_SaveDoesNotBlock(t *testing.T) {
+	store := &FileStore{queue: make(chan *Event, 1), done: make(chan struct{})}
+
+	done := make(chan struct{})
+	go func() {
+		// nobody consumes the queue, the second event has to be dropped
+		store.Save(&Event{AccountID: "account"})
+		store.Save(&Event{AccountID: "account"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("saving an event blocked on the full queue")
+	}
+	assert.Len(t, store.queue, 1)
+}
+
+func TestActivity_String(t *testing.T) {
+	assert.Equal(t, "peer.user.delete", PeerRemovedByUser.String())
+	assert.Equal(t, "unknown", Activity(-1).String())
+}
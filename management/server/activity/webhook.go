@@ -0,0 +1,266 @@
+package activity
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+const (
+	// WebhookSignatureHeader holds the hex encoded HMAC-SHA256 of the request body keyed with the webhook secret,
+	// prefixed with sha256=
+	WebhookSignatureHeader = "X-Netbird-Signature"
+	// WebhookEventHeader holds the activity code of the event, e.g. peer.user.add
+	WebhookEventHeader = "X-Netbird-Event"
+
+	// webhookQueueSize is a number of events buffered per webhook before being delivered.
+	// Events are dropped when the queue of a webhook is full so that the callers and the other webhooks are never blocked
+	webhookQueueSize = 1000
+	// webhookRequestTimeout limits a single delivery attempt
+	webhookRequestTimeout = 10 * time.Second
+	// webhookMaxRetryTime limits the time an event delivery is retried for
+	webhookMaxRetryTime = 5 * time.Minute
+
+	// outcomes of the deliveries in webhookDeliveriesMetric
+	webhookDelivered = "delivered"
+	webhookFailed    = "failed"
+	webhookDropped   = "dropped"
+)
+
+// webhookDeliveriesMetric counts the deliveries of the events to the webhooks by the outcome: delivered, failed after
+// the retries or dropped because the queue of the webhook was full. Published on the debug endpoint /debug/vars
+var webhookDeliveriesMetric = expvar.NewMap("management_webhook_deliveries")
+
+// webhookActivities are the activities delivered to the webhooks: the peer lifecycle and the setup key creation
+var webhookActivities = map[Activity]struct{}{
+	PeerAddedByUser:       {},
+	PeerAddedWithSetupKey: {},
+	PeerRemovedByUser:     {},
+	PeerLoginExpired:      {},
+	PeerSuspended:         {},
+	PeerResumed:           {},
+	SetupKeyCreated:       {},
+}
+
+// WebhookConfig is a config of the outbound webhooks
+type WebhookConfig struct {
+	// URLs are the endpoints receiving the events, every event is POSTed to each of them
+	URLs []string
+	// Secret signs the payloads, see WebhookSignatureHeader
+	Secret string
+}
+
+// WebhookPayload is the JSON body POSTed to the webhooks
+type WebhookPayload struct {
+	Timestamp   time.Time         `json:"timestamp"`
+	Activity    string            `json:"activity"`
+	AccountID   string            `json:"account_id"`
+	InitiatorID string            `json:"initiator_id"`
+	TargetID    string            `json:"target_id"`
+	Meta        map[string]string `json:"meta,omitempty"`
+}
+
+// webhookDelivery is a signed payload waiting for the delivery
+type webhookDelivery struct {
+	activity  Activity
+	accountID string
+	body      []byte
+	signature string
+}
+
+// webhookEndpoint is a webhook with its own queue, so a slow or failing webhook holds up only its own events
+type webhookEndpoint struct {
+	url   string
+	queue chan *webhookDelivery
+}
+
+// WebhookStore is a Store that delivers the peer lifecycle and setup key events to the webhooks in addition to
+// recording them in the wrapped Store. The events are delivered asynchronously and retried with a backoff,
+// every webhook in order of the events
+type WebhookStore struct {
+	Store
+	config     WebhookConfig
+	httpClient *http.Client
+	endpoints  []*webhookEndpoint
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	closed     bool
+	closeLock  sync.RWMutex
+}
+
+// NewWebhookStore wraps the store and starts the background delivery of the events to the webhooks
+func NewWebhookStore(store Store, config WebhookConfig) *WebhookStore {
+	return newWebhookStore(store, config, webhookQueueSize)
+}
+
+func newWebhookStore(store Store, config WebhookConfig, queueSize int) *WebhookStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &WebhookStore{
+		Store:      store,
+		config:     config,
+		httpClient: &http.Client{Timeout: webhookRequestTimeout},
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, url := range config.URLs {
+		endpoint := &webhookEndpoint{url: url, queue: make(chan *webhookDelivery, queueSize)}
+		s.endpoints = append(s.endpoints, endpoint)
+		s.wg.Add(1)
+		go s.run(endpoint)
+	}
+	return s
+}
+
+// Save records the event in the wrapped Store and queues its delivery to the webhooks. It never blocks
+func (s *WebhookStore) Save(event *Event) {
+	// the payload is built before the event is passed on, the wrapped Store may modify it asynchronously
+	delivery, err := s.newDelivery(event)
+	if err != nil {
+		log.Errorf("failed building the webhook payload of %s event of account %s: %v", event.Activity, event.AccountID, err)
+	}
+
+	s.Store.Save(event)
+
+	if delivery == nil {
+		return
+	}
+
+	s.closeLock.RLock()
+	defer s.closeLock.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	for _, endpoint := range s.endpoints {
+		select {
+		case endpoint.queue <- delivery:
+		default:
+			webhookDeliveriesMetric.Add(webhookDropped, 1)
+			log.Warnf("dropping webhook of %s event of account %s to %s, the queue of the webhook is full",
+				event.Activity, event.AccountID, endpoint.url)
+		}
+	}
+}
+
+// Close stops the delivery, the pending deliveries are dropped, and closes the wrapped Store
+func (s *WebhookStore) Close() error {
+	s.closeLock.Lock()
+	if !s.closed {
+		s.closed = true
+		s.cancel()
+		for _, endpoint := range s.endpoints {
+			close(endpoint.queue)
+		}
+	}
+	s.closeLock.Unlock()
+
+	s.wg.Wait()
+
+	return s.Store.Close()
+}
+
+// newDelivery builds the signed payload of the event, nil if the event isn't delivered to the webhooks
+func (s *WebhookStore) newDelivery(event *Event) (*webhookDelivery, error) {
+	if _, ok := webhookActivities[event.Activity]; !ok || len(s.config.URLs) == 0 {
+		return nil, nil
+	}
+
+	body, err := json.Marshal(WebhookPayload{
+		Timestamp:   event.Timestamp,
+		Activity:    event.Activity.String(),
+		AccountID:   event.AccountID,
+		InitiatorID: event.InitiatorID,
+		TargetID:    event.TargetID,
+		Meta:        event.Meta,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhookDelivery{
+		activity:  event.Activity,
+		accountID: event.AccountID,
+		body:      body,
+		signature: SignWebhookPayload(s.config.Secret, body),
+	}, nil
+}
+
+// run delivers the queued events to the webhook until the store is closed
+func (s *WebhookStore) run(endpoint *webhookEndpoint) {
+	defer s.wg.Done()
+
+	for delivery := range endpoint.queue {
+		err := s.deliver(endpoint.url, delivery)
+		if s.ctx.Err() != nil {
+			// closed, the pending deliveries are dropped
+			return
+		}
+		if err != nil {
+			webhookDeliveriesMetric.Add(webhookFailed, 1)
+			log.Errorf("failed delivering webhook of %s event of account %s to %s: %v",
+				delivery.activity, delivery.accountID, endpoint.url, err)
+			continue
+		}
+		webhookDeliveriesMetric.Add(webhookDelivered, 1)
+	}
+}
+
+// deliver POSTs the payload to the webhook retrying with a backoff until it is accepted with a 2xx status.
+// The 4xx statuses (except 429) aren't retried
+func (s *WebhookStore) deliver(url string, delivery *webhookDelivery) error {
+	bo := backoff.WithContext(&backoff.ExponentialBackOff{
+		InitialInterval:     time.Second,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         time.Minute,
+		MaxElapsedTime:      webhookMaxRetryTime,
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	}, s.ctx)
+
+	operation := func() error {
+		req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, url, bytes.NewReader(delivery.body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(WebhookEventHeader, delivery.activity.String())
+		req.Header.Set(WebhookSignatureHeader, delivery.signature)
+
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+			return backoff.Permanent(fmt.Errorf("webhook rejected the event with status %d", resp.StatusCode))
+		default:
+			return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+		}
+	}
+
+	return backoff.Retry(operation, bo)
+}
+
+// SignWebhookPayload returns the value of the WebhookSignatureHeader of a payload signed with the secret
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body) //nolint
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
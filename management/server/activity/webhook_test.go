@@ -0,0 +1,178 @@
+package activity
+
+import (
+	"encoding/json"
+	"expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookStore(t *testing.T) {
+	type received struct {
+		body      []byte
+		signature string
+		event     string
+	}
+
+	var mux sync.Mutex
+	attempts := 0
+	deliveries := make(chan received, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		attempts++
+		attempt := attempts
+		mux.Unlock()
+
+		// the first attempt fails to check the delivery is retried
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		deliveries <- received{
+			body:      body,
+			signature: r.Header.Get(WebhookSignatureHeader),
+			event:     r.Header.Get(WebhookEventHeader),
+		}
+	}))
+	defer server.Close()
+
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewWebhookStore(fileStore, WebhookConfig{URLs: []string{server.URL}, Secret: "secret"})
+	defer store.Close() //nolint
+
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	// groups aren't delivered to the webhooks
+	store.Save(&Event{Timestamp: now, Activity: GroupCreated, AccountID: "account", InitiatorID: "user", TargetID: "group"})
+	store.Save(&Event{Timestamp: now, Activity: PeerAddedWithSetupKey, AccountID: "account", InitiatorID: "setup_key",
+		TargetID: "peer_key", Meta: map[string]string{"name": "laptop"}})
+
+	var delivery received
+	select {
+	case delivery = <-deliveries:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expecting the event to be delivered to the webhook")
+	}
+
+	if delivery.signature != SignWebhookPayload("secret", delivery.body) {
+		t.Errorf("expecting the payload to be signed with the secret, got signature %s", delivery.signature)
+	}
+	if SignWebhookPayload("other", delivery.body) == delivery.signature {
+		t.Errorf("expecting the signature to depend on the secret")
+	}
+	if delivery.event != "peer.setupkey.add" {
+		t.Errorf("expecting the %s header to hold the activity, got %s", WebhookEventHeader, delivery.event)
+	}
+
+	var payload WebhookPayload
+	err = json.Unmarshal(delivery.body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := WebhookPayload{
+		Timestamp:   now,
+		Activity:    "peer.setupkey.add",
+		AccountID:   "account",
+		InitiatorID: "setup_key",
+		TargetID:    "peer_key",
+		Meta:        map[string]string{"name": "laptop"},
+	}
+	if payload.Timestamp != expected.Timestamp || payload.Activity != expected.Activity ||
+		payload.AccountID != expected.AccountID || payload.InitiatorID != expected.InitiatorID ||
+		payload.TargetID != expected.TargetID || payload.Meta["name"] != expected.Meta["name"] {
+		t.Errorf("expecting payload %+v, got %+v", expected, payload)
+	}
+
+	select {
+	case delivery = <-deliveries:
+		t.Errorf("expecting a single delivery, got %s", delivery.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := fileStore.Get("account", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expecting all events to be recorded in the wrapped store, got %d", len(events))
+	}
+}
+
+func TestWebhookStore_SlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the body is read for the request context to be cancelled when the client gives up
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	deliveries := make(chan string, 10)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deliveries <- r.Header.Get(WebhookEventHeader)
+	}))
+	defer fast.Close()
+
+	fileStore, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newWebhookStore(fileStore, WebhookConfig{URLs: []string{slow.URL, fast.URL}, Secret: "secret"}, 2)
+	defer store.Close() //nolint
+
+	dropped := webhookMetric(webhookDropped)
+	delivered := webhookMetric(webhookDelivered)
+	for i := 0; i < 5; i++ {
+		store.Save(&Event{Timestamp: time.Now(), Activity: PeerAddedByUser, AccountID: "account", InitiatorID: "user",
+			TargetID: "peer_key"})
+		select {
+		case <-deliveries:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expecting event %d to be delivered to the fast webhook while the slow one is stuck", i)
+		}
+	}
+
+	// the slow webhook holds an event in flight and 2 queued, the others are dropped
+	if n := webhookMetric(webhookDropped) - dropped; n < 2 || n > 3 {
+		t.Errorf("expecting 2 or 3 events to the slow webhook to be dropped, got %d", n)
+	}
+	// the delivery is counted once the fast webhook has responded
+	deadline := time.Now().Add(5 * time.Second)
+	for webhookMetric(webhookDelivered)-delivered < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := webhookMetric(webhookDelivered) - delivered; n != 5 {
+		t.Errorf("expecting 5 deliveries to be counted, got %d", n)
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func webhookMetric(outcome string) int64 {
+	if v, ok := webhookDeliveriesMetric.Get(outcome).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
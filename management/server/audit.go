@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultAuditRetentionCheckInterval is how often the audit events older than the retention are removed
+const DefaultAuditRetentionCheckInterval = time.Hour
+
+// AuditFilter selects the audit events of an account, the zero value selects all of them
+type AuditFilter struct {
+	// InitiatorID selects the events initiated by a user or a setup key, all the events if empty
+	InitiatorID string
+	// Activity selects the events of an activity, all the events if nil
+	Activity *activity.Activity
+	// Offset is the number of the selected events skipped, to page through the audit log
+	Offset int
+	// Limit is the maximum number of returned events, 0 means unlimited
+	Limit int
+}
+
+// matches returns true if the filter selects the event regardless of the Offset and the Limit
+func (f AuditFilter) matches(event *activity.Event) bool {
+	if f.InitiatorID != "" && event.InitiatorID != f.InitiatorID {
+		return false
+	}
+	if f.Activity != nil && event.Activity != *f.Activity {
+		return false
+	}
+	return true
+}
+
+// page returns the events within the Offset and the Limit of the filter
+func (f AuditFilter) page(events []*activity.Event) []*activity.Event {
+	if f.Offset >= len(events) {
+		return []*activity.Event{}
+	}
+	events = events[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(events) {
+		events = events[:f.Limit]
+	}
+	return events
+}
+
+// GetAuditEvents returns the audit events of the account selected by the filter sorted by ID
+// and the number of the selected events regardless of the Offset and the Limit
+func (am *DefaultAccountManager) GetAuditEvents(accountID string, filter AuditFilter) ([]*activity.Event, int, error) {
+	events, total, err := am.Store.GetAuditEvents(accountID, filter)
+	if err != nil {
+		return nil, 0, status.Errorf(codes.Internal, "failed getting audit events of account %s: %v", accountID, err)
+	}
+	return events, total, nil
+}
+
+// ScheduleAuditRetention removes the audit events older than the retention now and then periodically
+// until the context is done
+func (am *DefaultAccountManager) ScheduleAuditRetention(ctx context.Context, retention, interval time.Duration) {
+	removeExpired := func() {
+		removed, err := am.Store.DeleteAuditEventsBefore(time.Now().UTC().Add(-retention))
+		if err != nil {
+			log.Errorf("failed removing audit events older than %s: %v", retention, err)
+			return
+		}
+		if removed > 0 {
+			log.Infof("removed %d audit events older than %s", removed, retention)
+		}
+	}
+
+	go func() {
+		removeExpired()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				removeExpired()
+			}
+		}
+	}()
+}
+
+// saveAccount saves the account together with the audit events of the change, so that no change is persisted
+// without its audit trail, and records the events in the activity stream once they are saved
+func (am *DefaultAccountManager) saveAccount(account *Account, events ...*activity.Event) error {
+	err := am.Store.SaveAccountWithAuditEvents(account, events)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		am.storeEvent(event.InitiatorID, event.TargetID, event.AccountID, event.Activity, event.Meta)
+	}
+	return nil
+}
+
+// newAuditEvent creates an audit event of a change of the account. The before and after states of the changed object
+// are stored as JSON, nil if the object has been created or deleted
+func newAuditEvent(
+	initiatorID, targetID, accountID string,
+	activityID activity.Activity,
+	meta map[string]string,
+	before, after interface{},
+) *activity.Event {
+	return &activity.Event{
+		Timestamp:   time.Now().UTC(),
+		Activity:    activityID,
+		AccountID:   accountID,
+		InitiatorID: initiatorID,
+		TargetID:    targetID,
+		Meta:        meta,
+		Before:      auditState(before),
+		After:       auditState(after),
+	}
+}
+
+// auditState marshals the state of an object to JSON
+func auditState(state interface{}) json.RawMessage {
+	if state == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Warnf("failed marshalling audit state %T: %v", state, err)
+		return nil
+	}
+	return data
+}
+
+// auditSetupKey returns a copy of the setup key without its secret to be stored in the audit log
+func auditSetupKey(key *SetupKey) *SetupKey {
+	keyCopy := key.Copy()
+	keyCopy.Key = ""
+	return keyCopy
+}
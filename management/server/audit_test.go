@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestDefaultAccountManager_RecordsAuditEvents(t *testing.T) {
+	manager, err := createManager(t)
+	require.NoError(t, err)
+
+	userID := "account_creator"
+	account, err := manager.GetOrCreateAccountByUser(userID, "")
+	require.NoError(t, err)
+
+	setupKey, err := manager.AddSetupKey(account.Id, "key", SetupKeyReusable, nil, 0, userID)
+	require.NoError(t, err)
+
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+	require.NoError(t, err)
+
+	group := &Group{ID: "group", Name: "dev"}
+	require.NoError(t, manager.SaveGroup(account.Id, userID, group))
+	require.NoError(t, manager.GroupAddPeer(account.Id, userID, group.ID, peer.Key))
+
+	rule := &Rule{ID: "rule", Name: "dev", Source: []string{group.ID}, Destination: []string{group.ID}}
+	require.NoError(t, manager.SaveRule(account.Id, userID, rule))
+	require.NoError(t, manager.SaveRule(account.Id, userID, &Rule{ID: "rule", Name: "prod"}))
+	require.NoError(t, manager.DeleteRule(account.Id, userID, rule.ID))
+
+	_, err = manager.RenamePeer(account.Id, peer.Key, "gateway", userID)
+	require.NoError(t, err)
+	_, err = manager.RevokeSetupKey(account.Id, setupKey.Id, userID)
+	require.NoError(t, err)
+	_, err = manager.UpdateAccountPresenceSharing(account.Id, true, userID)
+	require.NoError(t, err)
+
+	events, total, err := manager.GetAuditEvents(account.Id, AuditFilter{})
+	require.NoError(t, err)
+
+	expected := []struct {
+		activity  activity.Activity
+		initiator string
+		target    string
+	}{
+		{activity.SetupKeyCreated, userID, setupKey.Id},
+		{activity.PeerAddedWithSetupKey, setupKey.Id, peer.Key},
+		{activity.GroupCreated, userID, group.ID},
+		{activity.GroupUpdated, userID, group.ID},
+		{activity.RuleCreated, userID, rule.ID},
+		{activity.RuleUpdated, userID, rule.ID},
+		{activity.RuleDeleted, userID, rule.ID},
+		{activity.PeerUpdated, userID, peer.Key},
+		{activity.SetupKeyRevoked, userID, setupKey.Id},
+		{activity.AccountSettingsUpdated, userID, account.Id},
+	}
+	require.Len(t, events, len(expected))
+	assert.Equal(t, len(expected), total)
+	for i, e := range expected {
+		assert.Equal(t, uint64(i+1), events[i].ID, "event %d", i)
+		assert.Equal(t, e.activity, events[i].Activity, "event %d", i)
+		assert.Equal(t, e.initiator, events[i].InitiatorID, "event %d", i)
+		assert.Equal(t, e.target, events[i].TargetID, "event %d", i)
+		assert.Equal(t, account.Id, events[i].AccountID, "event %d", i)
+		assert.False(t, events[i].Timestamp.IsZero(), "event %d", i)
+	}
+
+	// the created objects have no state before the change and the deleted ones after it
+	assert.Empty(t, events[2].Before)
+	var groupAfter Group
+	require.NoError(t, json.Unmarshal(events[3].After, &groupAfter))
+	assert.Equal(t, []string{peer.Key}, groupAfter.Peers)
+	assert.NotEmpty(t, events[6].Before)
+	assert.Empty(t, events[6].After)
+
+	var ruleBefore, ruleAfter Rule
+	require.NoError(t, json.Unmarshal(events[5].Before, &ruleBefore))
+	require.NoError(t, json.Unmarshal(events[5].After, &ruleAfter))
+	assert.Equal(t, "dev", ruleBefore.Name)
+	assert.Equal(t, "prod", ruleAfter.Name)
+
+	var peerBefore, peerAfter Peer
+	require.NoError(t, json.Unmarshal(events[7].Before, &peerBefore))
+	require.NoError(t, json.Unmarshal(events[7].After, &peerAfter))
+	assert.Equal(t, "peer", peerBefore.Name)
+	assert.Equal(t, "gateway", peerAfter.Name)
+
+	// the audit log doesn't expose the setup key secret
+	var keyBefore, keyAfter SetupKey
+	require.NoError(t, json.Unmarshal(events[8].Before, &keyBefore))
+	require.NoError(t, json.Unmarshal(events[8].After, &keyAfter))
+	assert.Empty(t, keyBefore.Key)
+	assert.False(t, keyBefore.Revoked)
+	assert.True(t, keyAfter.Revoked)
+	assert.NotContains(t, string(events[0].After), setupKey.Key)
+
+	peerEvents, total, err := manager.GetAuditEvents(account.Id, AuditFilter{InitiatorID: setupKey.Id})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	require.Len(t, peerEvents, 1)
+	assert.Equal(t, "key", peerEvents[0].Meta["setup_key_name"])
+}
+
+func TestDefaultAccountManager_ScheduleAuditRetention(t *testing.T) {
+	manager, err := createManager(t)
+	require.NoError(t, err)
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	require.NoError(t, err)
+
+	expired := newAuditEvent("account_creator", account.Id, account.Id, activity.AccountSettingsUpdated, nil, nil, nil)
+	expired.Timestamp = expired.Timestamp.Add(-48 * time.Hour)
+	recent := newAuditEvent("account_creator", account.Id, account.Id, activity.AccountNetworkUpdated, nil, nil, nil)
+	require.NoError(t, manager.Store.SaveAccountWithAuditEvents(account, []*activity.Event{expired, recent}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	manager.ScheduleAuditRetention(ctx, 24*time.Hour, time.Hour)
+
+	// the expired events are removed right away
+	require.Eventually(t, func() bool {
+		events, _, err := manager.GetAuditEvents(account.Id, AuditFilter{})
+		return err == nil && len(events) == 1 && events[0].Activity == activity.AccountNetworkUpdated
+	}, 5*time.Second, 10*time.Millisecond)
+}
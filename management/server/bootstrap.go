@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/netbirdio/netbird/encryption"
+	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serverKeyFile is the file in the data directory the Wireguard key of the Management service is kept in
+const serverKeyFile = "server_key.json"
+
+// serverKey is the content of serverKeyFile
+type serverKey struct {
+	PrivateKey string
+}
+
+// loadServerKey returns the Wireguard key of the Management service kept in the data directory, a new key is generated
+// and saved on the first start. The key stays the same across restarts, so the bootstrap bundles sealed with it remain
+// valid. A new key is generated on every start if the data directory isn't set
+func loadServerKey(dataDir string) (wgtypes.Key, error) {
+	if dataDir == "" {
+		return wgtypes.GeneratePrivateKey()
+	}
+
+	path := filepath.Join(dataDir, serverKeyFile)
+	if _, err := os.Stat(path); err == nil {
+		stored := &serverKey{}
+		if _, err := util.ReadJson(path, stored); err != nil {
+			return wgtypes.Key{}, fmt.Errorf("failed reading the server key %s: %v", path, err)
+		}
+		key, err := wgtypes.ParseKey(stored.PrivateKey)
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("server key %s is invalid: %v", path, err)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return wgtypes.Key{}, err
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	if err := util.WriteJson(path, &serverKey{PrivateKey: key.String()}); err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed saving the server key %s: %v", path, err)
+	}
+	log.Infof("generated the Wireguard key of the Management service %s", key.PublicKey().String())
+	return key, nil
+}
+
+// BootstrapBundle returns the current configuration and network map of a peer sealed for the peer with the key of
+// the server, the way the Sync stream sends them. The peer loads the bundle to come up before it can reach the server
+// (e.g. air-gapped or on the first boot) and validates it against the public key of the server.
+// The epoch isn't set, so the peer applies the first network map received from the server over the bundle
+func (s *Server) BootstrapBundle(peerKey string) (*proto.EncryptedMessage, error) {
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid peer key %s: %v", peerKey, err)
+	}
+
+	peer, err := s.accountManager.GetPeer(key.String())
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", key.String())
+	}
+
+	networkMap, err := s.accountManager.GetNetworkMap(peer.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the time based TURN credentials expire, the peer gets new ones once it reaches the server
+	var turnCredentials *TURNCredentials
+	if s.config.TURNConfig.TimeBasedCredentials {
+		creds := s.turnCredentialsManager.GenerateCredentials()
+		turnCredentials = &creds
+	}
+
+	body, err := encryption.EncryptMessage(key, s.wgKey, toSyncResponse(s.config, peer, networkMap, turnCredentials))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed sealing the bootstrap bundle: %v", err)
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     body,
+	}, nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_BootstrapBundle(t *testing.T) {
+	mgmtServer, client := startSessionTestServer(t, 33100, 0)
+
+	peers, err := registerPeers(2, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := getServerKey(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := mgmtServer.BootstrapBundle(peers[0].PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.GetWgPubKey() != serverKey.String() {
+		t.Fatalf("expecting the bundle to be sealed with the server key %s, got %s", serverKey, bundle.GetWgPubKey())
+	}
+
+	resp := &mgmtProto.SyncResponse{}
+	err = encryption.DecryptMessage(*serverKey, *peers[0], bundle.GetBody(), resp)
+	if err != nil {
+		t.Fatalf("failed opening the bundle with the key of the peer: %v", err)
+	}
+	if resp.GetPeerConfig().GetAddress() == "" || resp.GetWiretrusteeConfig().GetSignal() == nil {
+		t.Errorf("expecting the bundle to carry the peer config and the services, got %v", resp)
+	}
+	remotePeers := resp.GetNetworkMap().GetRemotePeers()
+	if len(remotePeers) != 1 || remotePeers[0].GetWgPubKey() != peers[1].PublicKey().String() {
+		t.Errorf("expecting the network map to include the other peer, got %v", remotePeers)
+	}
+	if resp.GetEpoch() != 0 {
+		t.Errorf("expecting no epoch in the bundle, got %d", resp.GetEpoch())
+	}
+
+	// the bundle is sealed for the peer only
+	err = encryption.DecryptMessage(*serverKey, *peers[1], bundle.GetBody(), &mgmtProto.SyncResponse{})
+	if err == nil {
+		t.Error("expecting the bundle not to be opened with the key of another peer")
+	}
+
+	unknown, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mgmtServer.BootstrapBundle(unknown.PublicKey().String())
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting %s for an unknown peer, got %v", codes.NotFound, err)
+	}
+	_, err = mgmtServer.BootstrapBundle("invalid")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting %s for an invalid peer key, got %v", codes.InvalidArgument, err)
+	}
+}
+
+func TestLoadServerKey(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := loadServerKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadServerKey(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != key {
+		t.Error("expecting the server key to be kept across restarts")
+	}
+
+	generated, err := loadServerKey("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated == key {
+		t.Error("expecting a new key without a data directory")
+	}
+}
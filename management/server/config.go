@@ -3,6 +3,7 @@ package server
 import (
 	"net/url"
 
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/netbirdio/netbird/management/server/idp"
 	"github.com/netbirdio/netbird/util"
 )
@@ -27,11 +28,104 @@ type Config struct {
 
 	Datadir string
 
+	StoreConfig StoreConfig
+
 	HttpConfig *HttpServerConfig
 
 	IdpManagerConfig *idp.Config
 
 	DeviceAuthorizationFlow *DeviceAuthorizationFlow
+
+	ClientUpdate *ClientUpdateConfig
+
+	HealthConfig *HealthServerConfig
+
+	// MaxPeersPerAccount is the number of peers an account can register, 0 means unlimited.
+	// Can be overridden per account by the MaxPeers of the account
+	MaxPeersPerAccount int
+
+	// SyncStreamInactivityTimeout is how long sending an update to a peer can take before its Sync stream is closed
+	// and the peer is marked as disconnected, e.g. a peer that has lost power. Defaults to DefaultSyncStreamInactivityTimeout
+	SyncStreamInactivityTimeout util.Duration
+
+	// SyncSessionGracePeriod is how long after its Sync stream has been closed a peer can resume it with its session token
+	// without receiving an unchanged network map again. Defaults to DefaultSyncSessionGracePeriod
+	SyncSessionGracePeriod util.Duration
+
+	// PresenceDisconnectDelay is how long a disconnect of a peer has to last before it is shared with the other peers
+	// of an account sharing the presence, so the peers reconnecting right away don't flap.
+	// Defaults to DefaultPresenceDisconnectDelay, a negative delay shares the disconnects right away
+	PresenceDisconnectDelay util.Duration
+
+	// LogLevels are the log levels of the components (e.g. mgmt=debug) that differ from the global log level.
+	// The WT_LOG environment variable overrides them
+	LogLevels string
+
+	// Webhooks receive the peer lifecycle (registration, removal, login expiration) and setup key creation events
+	Webhooks *activity.WebhookConfig
+
+	// Debug enables the development and support tooling, off if not set
+	Debug *DebugConfig
+
+	// Admin serves the API the operator of the Management service creates and lists the accounts with, off if not set
+	Admin *AdminConfig
+
+	// ClientAuth requires the peers to present a client certificate on the gRPC connections (mutual TLS) in addition to
+	// their Wireguard key. Requires the gRPC server to run with TLS, not required if not set
+	ClientAuth *ClientAuthConfig
+}
+
+// ClientAuthConfig is a config of the client certificates the peers authenticate their gRPC connections with.
+// The HTTP API used by the dashboard doesn't require a client certificate
+type ClientAuthConfig struct {
+	// CAFile is a PEM encoded bundle of the CAs issuing the client certificates. It is read again once it changes
+	// or the service receives SIGHUP
+	CAFile string
+	// PinPeerKey requires the common name of the client certificate to be the Wireguard public key of the peer,
+	// so a certificate can't be used on behalf of another peer. A peer rotating its key needs a new certificate
+	PinPeerKey bool
+}
+
+// DebugConfig enables the tooling used to inspect the Management service during development and support,
+// it is never meant to be exposed publicly
+type DebugConfig struct {
+	// Reflection enables the gRPC server reflection, so tools like grpcurl can call the service without its proto files
+	Reflection bool
+	// Address is a loopback address of the debug endpoint, e.g. 127.0.0.1:9091. The endpoint isn't served if empty
+	Address string
+}
+
+// AdminConfig is a config of the admin API managing the accounts of the Management service. The API isn't
+// authenticated, it is never meant to be exposed publicly
+type AdminConfig struct {
+	// Address is a loopback address of the admin endpoints, e.g. 127.0.0.1:9092. The endpoints aren't served if empty
+	Address string
+}
+
+// HealthServerConfig is a config of the HTTP health endpoints used by the liveness and readiness probes
+type HealthServerConfig struct {
+	// Address is a dedicated address of the health endpoints, e.g. :9090.
+	// The endpoints are served by the HttpConfig listener if empty
+	Address string
+}
+
+// ClientUpdateConfig is a client version recommendation sent to the peers via the Sync stream.
+// Peers don't update automatically, they only expose the recommendation
+type ClientUpdateConfig struct {
+	// MinVersion is the minimal client version supported by the Management service
+	MinVersion string
+	// RecommendedVersion is the client version peers should run
+	RecommendedVersion string
+	// DownloadURL is a location of the recommended client version
+	DownloadURL string
+}
+
+// StoreConfig is a config of the account Store
+type StoreConfig struct {
+	// Engine is the Store backend, either jsonfile (default) or sqlite
+	Engine StoreEngine
+	// AuditRetention is how long the audit events are kept, 0 keeps them forever
+	AuditRetention util.Duration
 }
 
 // TURNConfig is a config of the TURNCredentialsManager
@@ -40,6 +134,9 @@ type TURNConfig struct {
 	CredentialsTTL       util.Duration
 	Secret               string
 	Turns                []*Host
+	// CredentialsRefreshMargin is how long before the credentials expiration new credentials are pushed to the connected peers.
+	// Defaults to 1/4 of CredentialsTTL
+	CredentialsRefreshMargin util.Duration
 }
 
 // HttpServerConfig is a config of the HTTP Management service server
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"expvar"
+	"regexp"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConnectivityWindow is how long the connection outcomes reported by a peer are kept after its latest report
+const ConnectivityWindow = 24 * time.Hour
+
+// outcomeConnected is the outcome of the attempts that have established the connection in connectionOutcomesMetric
+const outcomeConnected = "connected"
+
+// outcomeOther replaces the failure classes that don't look like one in connectionOutcomesMetric
+const outcomeOther = "other"
+
+// failureClassRegexp matches a failure class reported by the peers, e.g. ice-failed
+var failureClassRegexp = regexp.MustCompile(`^[a-z][a-z-]{0,31}$`)
+
+// connectionOutcomesMetric counts the connection attempts between the peers reported to the Management service by
+// the outcome: connected or the failure class. Published on the debug endpoint /debug/vars
+var connectionOutcomesMetric = expvar.NewMap("management_peer_connection_outcomes")
+
+// ConnectionOutcome counts the attempts of a peer to connect to a remote peer by their outcome
+type ConnectionOutcome struct {
+	// Peer is the key of the remote peer
+	Peer string
+	// Connected is the number of the attempts that have established the connection
+	Connected int
+	// Failures is the number of the failed attempts by the failure class reported by the peer, e.g. ice-failed
+	Failures map[string]int
+}
+
+// PeerConnectivity aggregates the connection outcomes the peers of an account have reported for a remote peer
+// within ConnectivityWindow, e.g. 8 of 10 Reporters Failing to connect to a gateway
+type PeerConnectivity struct {
+	// Reporters is the number of the peers that have tried to connect to the peer
+	Reporters int
+	// Failing is the number of the Reporters none of which attempts has connected in their latest report
+	Failing int
+	// Connected is the number of the attempts that have established the connection
+	Connected int
+	// Failures is the number of the failed attempts by the failure class
+	Failures map[string]int
+}
+
+// connectivityReport accumulates the outcomes a peer has reported for a remote peer
+type connectivityReport struct {
+	connected int
+	failures  map[string]int
+	// failing is true if all the attempts of the latest report have failed
+	failing   bool
+	updatedAt time.Time
+}
+
+// connectivityTracker keeps the connection outcomes reported by the peers in memory, they are lost on restart
+type connectivityTracker struct {
+	mux sync.Mutex
+	// reports by the key of the remote peer and then by the key of the reporting peer
+	reports map[string]map[string]*connectivityReport
+}
+
+func newConnectivityTracker() *connectivityTracker {
+	return &connectivityTracker{reports: map[string]map[string]*connectivityReport{}}
+}
+
+// record adds the outcomes reported by a peer
+func (t *connectivityTracker) record(reporter string, outcomes []ConnectionOutcome, now time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	for _, outcome := range outcomes {
+		byReporter, ok := t.reports[outcome.Peer]
+		if !ok {
+			byReporter = map[string]*connectivityReport{}
+			t.reports[outcome.Peer] = byReporter
+		}
+		report, ok := byReporter[reporter]
+		if !ok || now.Sub(report.updatedAt) > ConnectivityWindow {
+			report = &connectivityReport{failures: map[string]int{}}
+			byReporter[reporter] = report
+		}
+
+		failed := 0
+		for class, count := range outcome.Failures {
+			report.failures[class] += count
+			failed += count
+		}
+		report.connected += outcome.Connected
+		report.failing = outcome.Connected == 0 && failed > 0
+		report.updatedAt = now
+	}
+}
+
+// connectivity aggregates the outcomes reported for a peer by the peers accepted by the filter within
+// ConnectivityWindow, the outdated reports are dropped
+func (t *connectivityTracker) connectivity(peerKey string, now time.Time, accept func(reporter string) bool) *PeerConnectivity {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	result := &PeerConnectivity{Failures: map[string]int{}}
+	for reporter, report := range t.reports[peerKey] {
+		if now.Sub(report.updatedAt) > ConnectivityWindow {
+			delete(t.reports[peerKey], reporter)
+			continue
+		}
+		if !accept(reporter) {
+			continue
+		}
+		result.Reporters++
+		if report.failing {
+			result.Failing++
+		}
+		result.Connected += report.connected
+		for class, count := range report.failures {
+			result.Failures[class] += count
+		}
+	}
+	if len(t.reports[peerKey]) == 0 {
+		delete(t.reports, peerKey)
+	}
+	return result
+}
+
+// forget drops the outcomes reported by and for a peer, e.g. once it has been deleted
+func (t *connectivityTracker) forget(peerKey string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.reports, peerKey)
+	for target, byReporter := range t.reports {
+		delete(byReporter, peerKey)
+		if len(byReporter) == 0 {
+			delete(t.reports, target)
+		}
+	}
+}
+
+// RecordConnectionOutcomes records the outcomes of the attempts of a peer to connect to the remote peers.
+// The outcomes for peers of other accounts or unknown ones are dropped
+func (am *DefaultAccountManager) RecordConnectionOutcomes(peerKey string, outcomes []ConnectionOutcome) error {
+	if len(outcomes) == 0 {
+		return nil
+	}
+
+	account, err := am.Store.GetPeerAccount(peerKey)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	accepted := make([]ConnectionOutcome, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		if _, ok := account.Peers[outcome.Peer]; !ok || outcome.Peer == peerKey {
+			log.Debugf("dropping connection outcomes of peer %s for unknown peer %s", peerKey, outcome.Peer)
+			continue
+		}
+		if outcome.Connected > 0 {
+			connectionOutcomesMetric.Add(outcomeConnected, int64(outcome.Connected))
+		}
+		failures := make(map[string]int, len(outcome.Failures))
+		for class, count := range outcome.Failures {
+			if !failureClassRegexp.MatchString(class) {
+				class = outcomeOther
+			}
+			failures[class] += count
+			connectionOutcomesMetric.Add(class, int64(count))
+		}
+		outcome.Failures = failures
+		accepted = append(accepted, outcome)
+	}
+
+	am.connectivity.record(peerKey, accepted, am.clock.Now())
+	return nil
+}
+
+// GetPeerConnectivity returns the connection outcomes the peers of the account have reported for a given peer
+func (am *DefaultAccountManager) GetPeerConnectivity(peerKey string) (*PeerConnectivity, error) {
+	account, err := am.Store.GetPeerAccount(peerKey)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	return am.connectivity.connectivity(peerKey, am.clock.Now(), func(reporter string) bool {
+		_, ok := account.Peers[reporter]
+		return ok
+	}), nil
+}
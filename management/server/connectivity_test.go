@@ -0,0 +1,145 @@
+package server
+
+import (
+	"expvar"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// addTestPeers registers the given number of peers in a new account of the user and returns their keys
+func addTestPeers(t *testing.T, manager *DefaultAccountManager, userID string, count int) []string {
+	t.Helper()
+	account, err := manager.GetOrCreateAccountByUser(userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var keys []string
+	for i := 0; i < count; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Meta: PeerSystemMeta{}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.PublicKey().String())
+	}
+	return keys
+}
+
+// outcomeMetric returns the number of the reported attempts with the outcome
+func outcomeMetric(outcome string) int64 {
+	if v, ok := connectionOutcomesMetric.Get(outcome).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func TestAccountManager_PeerConnectivity(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	manager.clock = clock
+
+	peers := addTestPeers(t, manager, "account_creator", 4)
+	gateway := peers[0]
+	foreign := addTestPeers(t, manager, "another_creator", 1)[0]
+
+	connectedBefore := outcomeMetric(outcomeConnected)
+	failedBefore := outcomeMetric("ice-failed")
+	otherBefore := outcomeMetric(outcomeOther)
+
+	// 2 of 3 peers fail to connect to the gateway
+	for _, reporter := range peers[1:3] {
+		err = manager.RecordConnectionOutcomes(reporter, []ConnectionOutcome{
+			{Peer: gateway, Failures: map[string]int{"ice-failed": 2, "signaling-timeout": 1}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = manager.RecordConnectionOutcomes(peers[3], []ConnectionOutcome{
+		{Peer: gateway, Connected: 1, Failures: map[string]int{"ice-failed": 1, "Not A Class!": 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a peer of another account can't report the gateway, nor be reported
+	err = manager.RecordConnectionOutcomes(foreign, []ConnectionOutcome{
+		{Peer: gateway, Failures: map[string]int{"ice-failed": 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.RecordConnectionOutcomes(peers[1], []ConnectionOutcome{
+		{Peer: foreign, Failures: map[string]int{"ice-failed": 5}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connectivity, err := manager.GetPeerConnectivity(gateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connectivity.Reporters != 3 || connectivity.Failing != 2 || connectivity.Connected != 1 {
+		t.Errorf("expecting 2 of 3 reporters failing with 1 connection, got %+v", connectivity)
+	}
+	if connectivity.Failures["ice-failed"] != 5 || connectivity.Failures["signaling-timeout"] != 2 ||
+		connectivity.Failures[outcomeOther] != 1 {
+		t.Errorf("expecting the failures of the account peers by the class, got %v", connectivity.Failures)
+	}
+	if outcomeMetric(outcomeConnected)-connectedBefore != 1 || outcomeMetric("ice-failed")-failedBefore != 5 ||
+		outcomeMetric(outcomeOther)-otherBefore != 1 {
+		t.Errorf("expecting the metric to count the outcomes of the account peers only")
+	}
+	foreignConnectivity, err := manager.GetPeerConnectivity(foreign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if foreignConnectivity.Reporters != 0 {
+		t.Errorf("expecting no outcomes reported by the peers of another account, got %+v", foreignConnectivity)
+	}
+
+	// a peer connecting in its latest report isn't failing anymore
+	err = manager.RecordConnectionOutcomes(peers[1], []ConnectionOutcome{{Peer: gateway, Connected: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectivity, err = manager.GetPeerConnectivity(gateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connectivity.Failing != 1 || connectivity.Connected != 2 {
+		t.Errorf("expecting 1 failing reporter with 2 connections, got %+v", connectivity)
+	}
+
+	// the outcomes expire
+	clock.Advance(ConnectivityWindow + time.Minute)
+	connectivity, err = manager.GetPeerConnectivity(gateway)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connectivity.Reporters != 0 {
+		t.Errorf("expecting the outdated outcomes to be dropped, got %+v", connectivity)
+	}
+
+	_, err = manager.GetPeerConnectivity("unknown")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting %s for an unknown peer, got %v", codes.NotFound, err)
+	}
+}
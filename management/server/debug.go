@@ -0,0 +1,35 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/netbirdio/netbird/management/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// DebugInfo is the state of the Management server exposed by the debug endpoint
+type DebugInfo struct {
+	// ConnectedPeers are the sorted keys of the peers receiving updates over a Sync stream
+	ConnectedPeers []string `json:"connectedPeers"`
+	// SyncStreams is the number of open Sync streams, it includes the streams being replaced by a reconnecting peer
+	SyncStreams int64 `json:"syncStreams"`
+}
+
+// Register registers the Management service on the gRPC server along with the gRPC server reflection
+// if enabled by the Debug config
+func (s *Server) Register(grpcServer *grpc.Server) {
+	proto.RegisterManagementServiceServer(grpcServer, s)
+	if s.config.Debug != nil && s.config.Debug.Reflection {
+		reflection.Register(grpcServer)
+		log.Warnf("gRPC server reflection is enabled, don't expose the server publicly")
+	}
+}
+
+// DebugInfo returns the current connected peers and open Sync streams
+func (s *Server) DebugInfo() *DebugInfo {
+	return &DebugInfo{
+		ConnectedPeers: s.peersUpdateManager.ConnectedPeers(),
+		SyncStreams:    atomic.LoadInt64(&s.syncStreams),
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DeviceAuthExpiration is a period a device authorization can be approved and completed in
+	DeviceAuthExpiration = 10 * time.Minute
+	// DeviceAuthPollInterval is the minimal interval between the polls of a device authorization
+	DeviceAuthPollInterval = 5 * time.Second
+
+	// userCodeAlphabet has no vowels and no similar looking characters, so the user codes are easy to type
+	// and don't form words (see RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+// DeviceAuth is a started device authorization of a peer
+type DeviceAuth struct {
+	// DeviceCode identifies the authorization when the peer polls it
+	DeviceCode string
+	// UserCode is approved by a user of the account the peer is going to join
+	UserCode  string
+	ExpiresAt time.Time
+}
+
+// deviceAuthSession is a device authorization bound to the peer which has started it
+type deviceAuthSession struct {
+	DeviceAuth
+	peerKey string
+	// userID is the user who has approved the authorization, empty while it is pending
+	userID string
+	// token is issued to the peer once the authorization has been approved, it registers the peer once
+	token string
+}
+
+// deviceAuthSessions holds the device authorizations in memory, so a restart of the Management service cancels them
+type deviceAuthSessions struct {
+	mux        sync.Mutex
+	byDevice   map[string]*deviceAuthSession
+	byUserCode map[string]*deviceAuthSession
+	byToken    map[string]*deviceAuthSession
+}
+
+func newDeviceAuthSessions() *deviceAuthSessions {
+	return &deviceAuthSessions{
+		byDevice:   make(map[string]*deviceAuthSession),
+		byUserCode: make(map[string]*deviceAuthSession),
+		byToken:    make(map[string]*deviceAuthSession),
+	}
+}
+
+// StartDeviceAuth starts a device authorization of an unregistered peer
+func (am *DefaultAccountManager) StartDeviceAuth(peerKey string) (*DeviceAuth, error) {
+	sessions := am.deviceAuths
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+
+	now := am.clock.Now()
+	sessions.removeExpired(now)
+
+	userCode, err := generateUserCode()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed generating user code: %v", err)
+	}
+	for sessions.byUserCode[userCode] != nil {
+		userCode, err = generateUserCode()
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed generating user code: %v", err)
+		}
+	}
+
+	session := &deviceAuthSession{
+		DeviceAuth: DeviceAuth{
+			DeviceCode: uuid.New().String(),
+			UserCode:   userCode,
+			ExpiresAt:  now.Add(DeviceAuthExpiration),
+		},
+		peerKey: peerKey,
+	}
+	sessions.byDevice[session.DeviceCode] = session
+	sessions.byUserCode[session.UserCode] = session
+
+	authCopy := session.DeviceAuth
+	return &authCopy, nil
+}
+
+// ApproveDeviceAuth approves the device authorization with the user code by a user, the peer is going to be
+// registered in the account of the user
+func (am *DefaultAccountManager) ApproveDeviceAuth(userCode string, userID string) error {
+	sessions := am.deviceAuths
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+
+	sessions.removeExpired(am.clock.Now())
+
+	session, ok := sessions.byUserCode[normalizeUserCode(userCode)]
+	if !ok {
+		return status.Errorf(codes.NotFound, "device authorization with user code %s not found or expired", userCode)
+	}
+	if session.userID != "" {
+		return status.Errorf(codes.FailedPrecondition, "device authorization with user code %s has been already approved", userCode)
+	}
+
+	session.userID = userID
+	session.token = uuid.New().String()
+	sessions.byToken[session.token] = session
+
+	return nil
+}
+
+// PollDeviceAuth returns the registration token of an approved device authorization of the peer,
+// empty if the authorization is pending
+func (am *DefaultAccountManager) PollDeviceAuth(deviceCode string, peerKey string) (string, error) {
+	sessions := am.deviceAuths
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+
+	sessions.removeExpired(am.clock.Now())
+
+	session, ok := sessions.byDevice[deviceCode]
+	if !ok || session.peerKey != peerKey {
+		return "", status.Errorf(codes.NotFound, "device authorization not found or expired")
+	}
+
+	return session.token, nil
+}
+
+// RedeemDeviceAuth completes the device authorization with the registration token of the peer and returns the user
+// who has approved it
+func (am *DefaultAccountManager) RedeemDeviceAuth(token string, peerKey string) (string, error) {
+	sessions := am.deviceAuths
+	sessions.mux.Lock()
+	defer sessions.mux.Unlock()
+
+	sessions.removeExpired(am.clock.Now())
+
+	session, ok := sessions.byToken[token]
+	if !ok || session.peerKey != peerKey {
+		return "", status.Errorf(codes.PermissionDenied, "invalid or expired device authorization token")
+	}
+	sessions.remove(session)
+
+	return session.userID, nil
+}
+
+// removeExpired removes the device authorizations which haven't been completed in time
+func (s *deviceAuthSessions) removeExpired(now time.Time) {
+	for _, session := range s.byDevice {
+		if now.After(session.ExpiresAt) {
+			s.remove(session)
+		}
+	}
+}
+
+func (s *deviceAuthSessions) remove(session *deviceAuthSession) {
+	delete(s.byDevice, session.DeviceCode)
+	delete(s.byUserCode, session.UserCode)
+	if session.token != "" {
+		delete(s.byToken, session.token)
+	}
+}
+
+// generateUserCode generates a random user code formatted as XXXX-XXXX
+func generateUserCode() (string, error) {
+	code := make([]byte, 0, userCodeLength+1)
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code = append(code, userCodeAlphabet[n.Int64()])
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode accepts a user code typed in lower case or without the dash
+func normalizeUserCode(userCode string) string {
+	code := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(userCode), "-", ""))
+	if len(code) != userCodeLength {
+		return code
+	}
+	return code[:userCodeLength/2] + "-" + code[userCodeLength/2:]
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_DeviceAuth(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	manager.clock = clock
+
+	peerKey := "peer_key"
+	auth, err := manager.StartDeviceAuth(peerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth.UserCode) != userCodeLength+1 || auth.UserCode[userCodeLength/2] != '-' {
+		t.Errorf("expecting user code formatted as XXXX-XXXX, got %s", auth.UserCode)
+	}
+
+	token, err := manager.PollDeviceAuth(auth.DeviceCode, peerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		t.Errorf("expecting no token while the device authorization is pending, got %s", token)
+	}
+
+	_, err = manager.PollDeviceAuth(auth.DeviceCode, "another_peer_key")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting the device authorization to be bound to the peer, got %v", err)
+	}
+
+	err = manager.ApproveDeviceAuth(strings.ToLower(strings.ReplaceAll(auth.UserCode, "-", "")), "user_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.ApproveDeviceAuth(auth.UserCode, "another_user_id")
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expecting an approved device authorization not to be approved again, got %v", err)
+	}
+
+	token, err = manager.PollDeviceAuth(auth.DeviceCode, peerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "" {
+		t.Fatal("expecting a token once the device authorization has been approved")
+	}
+
+	_, err = manager.RedeemDeviceAuth(token, "another_peer_key")
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the token to be redeemed only by the peer, got %v", err)
+	}
+
+	userID, err := manager.RedeemDeviceAuth(token, peerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != "user_id" {
+		t.Errorf("expecting the peer to be registered by the approving user, got %s", userID)
+	}
+
+	_, err = manager.RedeemDeviceAuth(token, peerKey)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting the token to be redeemed once, got %v", err)
+	}
+}
+
+func TestAccountManager_DeviceAuthExpiration(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	manager.clock = clock
+
+	auth, err := manager.StartDeviceAuth("peer_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(DeviceAuthExpiration + time.Second)
+
+	err = manager.ApproveDeviceAuth(auth.UserCode, "user_id")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting an expired device authorization not to be approved, got %v", err)
+	}
+	_, err = manager.PollDeviceAuth(auth.DeviceCode, "peer_key")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting an expired device authorization to be removed, got %v", err)
+	}
+}
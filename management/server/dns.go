@@ -0,0 +1,211 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DNSRecordTypeA is the type of the records of IPv4 addresses
+	DNSRecordTypeA = "A"
+	// DNSRecordTypeAAAA is the type of the records of IPv6 addresses
+	DNSRecordTypeAAAA = "AAAA"
+)
+
+// DNSSettings are the name resolution settings pushed to the peers of an account.
+// The peers apply them only if they resolve the names of the peers locally
+type DNSSettings struct {
+	// Zone the names of the peers are resolved in, e.g. netbird.cloud. Empty keeps the zone configured on the peers
+	Zone string
+	// CustomRecords are resolved in the zone in addition to the names of the peers
+	CustomRecords []DNSRecord
+	// NameServerGroups resolve the names of their match domains outside the zone
+	NameServerGroups []NameServerGroup
+}
+
+// DNSRecord is a custom record of a name of the zone
+type DNSRecord struct {
+	// Name relative to the zone, e.g. db for db.netbird.cloud
+	Name string
+	// Type is DNSRecordTypeA or DNSRecordTypeAAAA
+	Type string
+	// Value is the IP address of the record
+	Value string
+}
+
+// NameServerGroup is a group of upstream nameservers the peers forward the queries of the match domains to
+type NameServerGroup struct {
+	// NameServers are the addresses of the nameservers, IP or IP:port, tried in order. The port defaults to 53
+	NameServers []string
+	// Domains are the match domains resolved by the nameservers along with their subdomains, e.g. corp.example.com
+	Domains []string
+}
+
+// Copy copies DNSSettings object
+func (s *DNSSettings) Copy() *DNSSettings {
+	if s == nil {
+		return nil
+	}
+	var records []DNSRecord
+	if s.CustomRecords != nil {
+		records = make([]DNSRecord, len(s.CustomRecords))
+		copy(records, s.CustomRecords)
+	}
+	var groups []NameServerGroup
+	for _, group := range s.NameServerGroups {
+		groups = append(groups, NameServerGroup{
+			NameServers: append([]string(nil), group.NameServers...),
+			Domains:     append([]string(nil), group.Domains...),
+		})
+	}
+	return &DNSSettings{
+		Zone:             s.Zone,
+		CustomRecords:    records,
+		NameServerGroups: groups,
+	}
+}
+
+// IsEmpty returns true if the settings change nothing on the peers
+func (s *DNSSettings) IsEmpty() bool {
+	return s == nil || (s.Zone == "" && len(s.CustomRecords) == 0 && len(s.NameServerGroups) == 0)
+}
+
+// validate checks that the zone, the names and the match domains are domains of letters, digits and hyphens,
+// that the values of the records are addresses of their type and that the nameservers are IP addresses
+func (s *DNSSettings) validate() error {
+	if s.Zone != "" && !isValidDomain(strings.Trim(s.Zone, ".")) {
+		return status.Errorf(codes.InvalidArgument, "invalid DNS zone %s, use a domain of letters, digits and hyphens", s.Zone)
+	}
+	for _, record := range s.CustomRecords {
+		if !isValidDomain(record.Name) {
+			return status.Errorf(codes.InvalidArgument, "invalid name %q of a DNS record, use letters, digits and hyphens", record.Name)
+		}
+		ip := net.ParseIP(record.Value)
+		switch {
+		case record.Type == DNSRecordTypeA && ip != nil && ip.To4() != nil:
+		case record.Type == DNSRecordTypeAAAA && ip != nil && ip.To4() == nil:
+		case record.Type != DNSRecordTypeA && record.Type != DNSRecordTypeAAAA:
+			return status.Errorf(codes.InvalidArgument, "unsupported type %q of DNS record %s, use A or AAAA", record.Type, record.Name)
+		default:
+			return status.Errorf(codes.InvalidArgument, "invalid value %q of %s record %s", record.Value, record.Type, record.Name)
+		}
+	}
+	for _, group := range s.NameServerGroups {
+		if len(group.NameServers) == 0 || len(group.Domains) == 0 {
+			return status.Errorf(codes.InvalidArgument, "a nameserver group needs at least a nameserver and a match domain")
+		}
+		for _, nameServer := range group.NameServers {
+			if !isValidNameServer(nameServer) {
+				return status.Errorf(codes.InvalidArgument, "invalid nameserver %q, use an IP or IP:port", nameServer)
+			}
+		}
+		for _, domain := range group.Domains {
+			if !isValidDomain(strings.Trim(domain, ".")) {
+				return status.Errorf(codes.InvalidArgument, "invalid match domain %q, use a domain of letters, digits and hyphens", domain)
+			}
+		}
+	}
+	return nil
+}
+
+// isValidNameServer returns true if the address is an IP or an IP with a port
+func isValidNameServer(address string) bool {
+	if net.ParseIP(address) != nil {
+		return true
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) == nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+// isValidDomain returns true if the domain is made of labels of letters, digits and hyphens not starting or ending with a hyphen
+func isValidDomain(domain string) bool {
+	if domain == "" {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// UpdateAccountDNSSettings sets the name resolution settings of the peers of the account, nil or empty settings remove them.
+// All the peers receive an updated network map with the settings
+func (am *DefaultAccountManager) UpdateAccountDNSSettings(accountId string, settings *DNSSettings, userID string) (*Account, error) {
+	if settings.IsEmpty() {
+		settings = nil
+	} else {
+		err := settings.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	event := newAuditEvent(userID, accountId, accountId, activity.AccountSettingsUpdated,
+		map[string]string{"setting": "dns_settings"},
+		map[string]*DNSSettings{"DNSSettings": account.DNSSettings}, map[string]*DNSSettings{"DNSSettings": settings})
+
+	account.DNSSettings = settings.Copy()
+	account.Network.IncSerial()
+	err = am.saveAccount(account, event)
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateAccountPeers(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func toProtoDNSConfig(settings *DNSSettings) *proto.DNSConfig {
+	if settings == nil {
+		return nil
+	}
+	records := make([]*proto.CustomRecord, 0, len(settings.CustomRecords))
+	for _, record := range settings.CustomRecords {
+		records = append(records, &proto.CustomRecord{
+			Name:  record.Name,
+			Type:  record.Type,
+			RData: record.Value,
+		})
+	}
+	groups := make([]*proto.NameServerGroup, 0, len(settings.NameServerGroups))
+	for _, group := range settings.NameServerGroups {
+		groups = append(groups, &proto.NameServerGroup{
+			NameServers: group.NameServers,
+			Domains:     group.Domains,
+		})
+	}
+	return &proto.DNSConfig{
+		Zone:             settings.Zone,
+		CustomRecords:    records,
+		NameServerGroups: groups,
+	}
+}
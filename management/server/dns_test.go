@@ -0,0 +1,166 @@
+package server
+
+import (
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/management/proto"
+)
+
+func TestDNSSettings_Validate(t *testing.T) {
+	tt := []struct {
+		name     string
+		settings *DNSSettings
+		valid    bool
+	}{
+		{name: "zone only", settings: &DNSSettings{Zone: "netbird.cloud."}, valid: true},
+		{name: "records only", settings: &DNSSettings{CustomRecords: []DNSRecord{
+			{Name: "db", Type: DNSRecordTypeA, Value: "10.0.0.5"},
+			{Name: "db.eu", Type: DNSRecordTypeAAAA, Value: "fd00::5"},
+		}}, valid: true},
+		{name: "invalid zone", settings: &DNSSettings{Zone: "net_bird.cloud"}},
+		{name: "invalid name", settings: &DNSSettings{CustomRecords: []DNSRecord{{Name: "-db", Type: DNSRecordTypeA, Value: "10.0.0.5"}}}},
+		{name: "unsupported type", settings: &DNSSettings{CustomRecords: []DNSRecord{{Name: "db", Type: "CNAME", Value: "db.example.com"}}}},
+		{name: "IPv6 A record", settings: &DNSSettings{CustomRecords: []DNSRecord{{Name: "db", Type: DNSRecordTypeA, Value: "fd00::5"}}}},
+		{name: "IPv4 AAAA record", settings: &DNSSettings{CustomRecords: []DNSRecord{{Name: "db", Type: DNSRecordTypeAAAA, Value: "10.0.0.5"}}}},
+		{name: "nameserver groups", settings: &DNSSettings{NameServerGroups: []NameServerGroup{
+			{NameServers: []string{"10.0.0.53", "10.0.1.53:5353"}, Domains: []string{"corp.example.com", "example.net."}},
+			{NameServers: []string{"fd00::53", "[fd00::54]:53"}, Domains: []string{"lab"}},
+		}}, valid: true},
+		{name: "group without nameservers", settings: &DNSSettings{NameServerGroups: []NameServerGroup{{Domains: []string{"corp.example.com"}}}}},
+		{name: "group without domains", settings: &DNSSettings{NameServerGroups: []NameServerGroup{{NameServers: []string{"10.0.0.53"}}}}},
+		{name: "nameserver hostname", settings: &DNSSettings{NameServerGroups: []NameServerGroup{{NameServers: []string{"ns.example.com"}, Domains: []string{"corp.example.com"}}}}},
+		{name: "invalid nameserver port", settings: &DNSSettings{NameServerGroups: []NameServerGroup{{NameServers: []string{"10.0.0.53:0"}, Domains: []string{"corp.example.com"}}}}},
+		{name: "invalid match domain", settings: &DNSSettings{NameServerGroups: []NameServerGroup{{NameServers: []string{"10.0.0.53"}, Domains: []string{"corp_example.com"}}}}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.settings.validate()
+			if tc.valid && err != nil {
+				t.Errorf("expecting the settings to be valid, got %v", err)
+			}
+			if !tc.valid && status.Code(err) != codes.InvalidArgument {
+				t.Errorf("expecting the settings to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAccountManager_DNSSettings(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := manager.AddPeer(setupKey.Key, "", &Peer{
+		Key:  key.PublicKey().String(),
+		Name: "peer",
+		Meta: PeerSystemMeta{GoOS: "linux"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdateAccountDNSSettings(account.Id, &DNSSettings{Zone: "not a zone"}, "account_creator")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting an invalid zone to be rejected, got %v", err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peer.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer.Key)
+
+	receiveDNSConfig := func(action string) *proto.DNSConfig {
+		t.Helper()
+		select {
+		case update := <-updates:
+			return update.Update.GetNetworkMap().GetDnsConfig()
+		default:
+			t.Fatalf("expecting the peers to receive an update after %s the DNS settings", action)
+			return nil
+		}
+	}
+
+	// add
+	settings := &DNSSettings{
+		Zone:             "netbird.cloud",
+		CustomRecords:    []DNSRecord{{Name: "db", Type: DNSRecordTypeA, Value: "10.0.0.5"}},
+		NameServerGroups: []NameServerGroup{{NameServers: []string{"10.0.0.53"}, Domains: []string{"corp.example.com"}}},
+	}
+	serial := account.Network.CurrentSerial()
+	updated, err := manager.UpdateAccountDNSSettings(account.Id, settings, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Network.CurrentSerial() <= serial {
+		t.Errorf("expecting the network serial to be incremented")
+	}
+	config := receiveDNSConfig("adding")
+	if config.GetZone() != "netbird.cloud" || len(config.GetCustomRecords()) != 1 ||
+		config.GetCustomRecords()[0].GetRData() != "10.0.0.5" {
+		t.Errorf("expecting the DNS settings in the network map, got %v", config)
+	}
+	if len(config.GetNameServerGroups()) != 1 || config.GetNameServerGroups()[0].GetNameServers()[0] != "10.0.0.53" ||
+		config.GetNameServerGroups()[0].GetDomains()[0] != "corp.example.com" {
+		t.Errorf("expecting the nameserver groups in the network map, got %v", config.GetNameServerGroups())
+	}
+
+	// change
+	settings.CustomRecords[0].Value = "10.0.0.6"
+	settings.NameServerGroups[0].NameServers = []string{"10.0.1.53:5353"}
+	_, err = manager.UpdateAccountDNSSettings(account.Id, settings, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = receiveDNSConfig("changing")
+	if len(config.GetCustomRecords()) != 1 || config.GetCustomRecords()[0].GetRData() != "10.0.0.6" {
+		t.Errorf("expecting the changed DNS record in the network map, got %v", config)
+	}
+	if len(config.GetNameServerGroups()) != 1 || config.GetNameServerGroups()[0].GetNameServers()[0] != "10.0.1.53:5353" {
+		t.Errorf("expecting the changed nameservers in the network map, got %v", config.GetNameServerGroups())
+	}
+
+	networkMap, err := manager.GetNetworkMap(peer.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkMap.DNSSettings == nil || networkMap.DNSSettings.CustomRecords[0].Value != "10.0.0.6" {
+		t.Errorf("expecting the DNS settings in the network map of the peer, got %v", networkMap.DNSSettings)
+	}
+
+	// remove
+	_, err = manager.UpdateAccountDNSSettings(account.Id, &DNSSettings{}, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config = receiveDNSConfig("removing"); config != nil {
+		t.Errorf("expecting no DNS settings in the network map, got %v", config)
+	}
+
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.DNSSettings != nil {
+		t.Errorf("expecting the DNS settings to be removed from the account, got %v", account.DNSSettings)
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// GetEvents returns events of the account that happened within the [from, to] time range.
+// A zero from or to time doesn't limit the range
+func (am *DefaultAccountManager) GetEvents(accountID string, from, to time.Time) ([]*activity.Event, error) {
+	if am.eventStore == nil {
+		return []*activity.Event{}, nil
+	}
+
+	events, err := am.eventStore.Get(accountID, from, to)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed getting events of account %s: %v", accountID, err)
+	}
+
+	return events, nil
+}
+
+// storeEvent records an account event. It never blocks, so it is safe to call while holding the account lock
+func (am *DefaultAccountManager) storeEvent(
+	initiatorID, targetID, accountID string,
+	activityID activity.Activity,
+	meta map[string]string,
+) {
+	if am.eventStore == nil {
+		return
+	}
+
+	am.eventStore.Save(&activity.Event{
+		Timestamp:   time.Now().UTC(),
+		Activity:    activityID,
+		AccountID:   accountID,
+		InitiatorID: initiatorID,
+		TargetID:    targetID,
+		Meta:        meta,
+	})
+}
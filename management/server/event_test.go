@@ -0,0 +1,99 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// memoryEventStore is an activity.Store keeping events in memory
+type memoryEventStore struct {
+	mu     sync.Mutex
+	events []*activity.Event
+}
+
+func (s *memoryEventStore) Save(event *activity.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = uint64(len(s.events) + 1)
+	s.events = append(s.events, event)
+}
+
+func (s *memoryEventStore) Get(accountID string, from, to time.Time) ([]*activity.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var events []*activity.Event
+	for _, event := range s.events {
+		if event.AccountID == accountID {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *memoryEventStore) Close() error {
+	return nil
+}
+
+func TestDefaultAccountManager_StoresEvents(t *testing.T) {
+	store, err := createStore(t)
+	require.NoError(t, err)
+	eventStore := &memoryEventStore{}
+	manager, err := BuildManager(store, NewPeersUpdateManager(), nil, eventStore)
+	require.NoError(t, err)
+
+	userID := "account_creator"
+	account, err := manager.GetOrCreateAccountByUser(userID, "")
+	require.NoError(t, err)
+
+	setupKey, err := manager.AddSetupKey(account.Id, "key", SetupKeyReusable, nil, 0, userID)
+	require.NoError(t, err)
+
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+	require.NoError(t, err)
+
+	userPeerKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	_, err = manager.AddPeer("", userID, &Peer{Key: userPeerKey.PublicKey().String(), Name: "user-peer"})
+	require.NoError(t, err)
+
+	group := &Group{ID: "group", Name: "dev", Peers: []string{peer.Key}}
+	require.NoError(t, manager.SaveGroup(account.Id, userID, group))
+	require.NoError(t, manager.SaveGroup(account.Id, userID, group))
+	require.NoError(t, manager.DeleteGroup(account.Id, userID, group.ID))
+
+	_, err = manager.DeletePeer(account.Id, peer.Key, userID)
+	require.NoError(t, err)
+
+	events, err := manager.GetEvents(account.Id, time.Time{}, time.Time{})
+	require.NoError(t, err)
+
+	expected := []struct {
+		activity  activity.Activity
+		initiator string
+		target    string
+	}{
+		{activity.SetupKeyCreated, userID, setupKey.Id},
+		{activity.PeerAddedWithSetupKey, setupKey.Id, peer.Key},
+		{activity.PeerAddedByUser, userID, userPeerKey.PublicKey().String()},
+		{activity.GroupCreated, userID, group.ID},
+		{activity.GroupUpdated, userID, group.ID},
+		{activity.GroupDeleted, userID, group.ID},
+		{activity.PeerRemovedByUser, userID, peer.Key},
+	}
+	require.Len(t, events, len(expected))
+	for i, e := range expected {
+		assert.Equal(t, e.activity, events[i].Activity, "event %d", i)
+		assert.Equal(t, e.initiator, events[i].InitiatorID, "event %d", i)
+		assert.Equal(t, e.target, events[i].TargetID, "event %d", i)
+		assert.False(t, events[i].Timestamp.IsZero(), "event %d", i)
+	}
+	assert.Equal(t, "key", events[1].Meta["setup_key_name"])
+}
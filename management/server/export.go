@@ -0,0 +1,326 @@
+package server
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountExportVersion is the version of the AccountExport format produced by the Management service.
+// It has to be incremented on any incompatible change of the format
+const AccountExportVersion = 1
+
+// AccountExport is a document with the peers and the setup keys of an account used to migrate them between environments
+type AccountExport struct {
+	Version int `json:"version"`
+	// Network is the network (CIDR) of the exported account. The IPs of the peers belong to it
+	Network   string              `json:"network"`
+	Peers     []*ExportedPeer     `json:"peers"`
+	SetupKeys []*ExportedSetupKey `json:"setupKeys"`
+}
+
+// ExportedPeer is a peer of an AccountExport
+type ExportedPeer struct {
+	// Key is the Wireguard public key of the peer
+	Key  string `json:"key"`
+	IP   string `json:"ip"`
+	Name string `json:"name"`
+}
+
+// ExportedSetupKey is a setup key of an AccountExport
+type ExportedSetupKey struct {
+	Key        string       `json:"key"`
+	Name       string       `json:"name"`
+	Type       SetupKeyType `json:"type"`
+	ExpiresAt  time.Time    `json:"expiresAt"`
+	Revoked    bool         `json:"revoked"`
+	UsageLimit int          `json:"usageLimit"`
+}
+
+// ImportResult lists the keys of the peers and the setup keys an import has added or updated (or would add or update in a dry run)
+type ImportResult struct {
+	DryRun           bool     `json:"dryRun"`
+	AddedPeers       []string `json:"addedPeers"`
+	UpdatedPeers     []string `json:"updatedPeers"`
+	AddedSetupKeys   []string `json:"addedSetupKeys"`
+	UpdatedSetupKeys []string `json:"updatedSetupKeys"`
+}
+
+// ExportAccount returns the peers and the setup keys of the account sorted by their keys
+func (am *DefaultAccountManager) ExportAccount(accountId string) (*AccountExport, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	export := &AccountExport{
+		Version:   AccountExportVersion,
+		Network:   account.Network.Net.String(),
+		Peers:     []*ExportedPeer{},
+		SetupKeys: []*ExportedSetupKey{},
+	}
+	for _, peer := range account.Peers {
+		export.Peers = append(export.Peers, &ExportedPeer{
+			Key:  peer.Key,
+			IP:   peer.IP.String(),
+			Name: peer.Name,
+		})
+	}
+	for _, key := range account.SetupKeys {
+		export.SetupKeys = append(export.SetupKeys, &ExportedSetupKey{
+			Key:        key.Key,
+			Name:       key.Name,
+			Type:       key.Type,
+			ExpiresAt:  key.ExpiresAt.UTC(),
+			Revoked:    key.Revoked,
+			UsageLimit: key.UsageLimit,
+		})
+	}
+	sort.Slice(export.Peers, func(i, j int) bool { return export.Peers[i].Key < export.Peers[j].Key })
+	sort.Slice(export.SetupKeys, func(i, j int) bool { return export.SetupKeys[i].Key < export.SetupKeys[j].Key })
+
+	return export, nil
+}
+
+// ImportAccount adds the peers and the setup keys of the export to the account, or updates them if the account already has them.
+// The peers and the setup keys of the account missing in the export are kept. The export is validated as a whole
+// (e.g. IP collisions, duplicate keys), so either all of it is imported or nothing. A dry run only reports what would change.
+// The network serial is incremented once and every peer receives a single network map update.
+// The userID is the user who initiated the import, a dry run isn't recorded in the audit log
+func (am *DefaultAccountManager) ImportAccount(accountId string, export *AccountExport, dryRun bool, userID string) (*ImportResult, error) {
+	if export.Version != AccountExportVersion {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported export version %d, expected %d", export.Version, AccountExportVersion)
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	result := &ImportResult{
+		DryRun:           dryRun,
+		AddedPeers:       []string{},
+		UpdatedPeers:     []string{},
+		AddedSetupKeys:   []string{},
+		UpdatedSetupKeys: []string{},
+	}
+
+	peers, err := am.importPeers(account, export.Peers, result)
+	if err != nil {
+		return nil, err
+	}
+
+	setupKeys, err := am.importSetupKeys(account, export.SetupKeys, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit := am.peersLimit(account); limit > 0 && len(account.Peers)+len(result.AddedPeers) > limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "unable to import %d peers, the account has a limit of %d peers",
+			len(result.AddedPeers), limit)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	group, err := account.GetGroupAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		if _, ok := account.Peers[peer.Key]; !ok {
+			group.Peers = append(group.Peers, peer.Key)
+		}
+		account.Peers[peer.Key] = peer
+	}
+	for _, key := range setupKeys {
+		account.SetupKeys[key.Key] = key
+	}
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, accountId, accountId, activity.AccountImported,
+		map[string]string{
+			"peers":      strconv.Itoa(len(result.AddedPeers) + len(result.UpdatedPeers)),
+			"setup_keys": strconv.Itoa(len(result.AddedSetupKeys) + len(result.UpdatedSetupKeys)),
+		}, nil, result))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed importing peers and setup keys")
+	}
+
+	for peerKey := range account.Peers {
+		err = am.sendNetworkMap(account, peerKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// importPeers validates the exported peers and returns the peers to add to the account or to replace the existing ones with
+func (am *DefaultAccountManager) importPeers(account *Account, exported []*ExportedPeer, result *ImportResult) ([]*Peer, error) {
+	// key -> IP and name of the peers after the import, the peers missing in the export keep their IPs and names
+	finalIPs := map[string]string{}
+	finalNames := map[string]string{}
+	// keys of the peers added or renamed by the import
+	renamed := map[string]bool{}
+	for _, peer := range account.Peers {
+		finalIPs[peer.Key] = peer.IP.String()
+		finalNames[peer.Key] = peer.Name
+	}
+
+	keys := map[string]struct{}{}
+	var peers []*Peer
+	for _, e := range exported {
+		if _, err := wgtypes.ParseKey(e.Key); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid key of peer %q: %s", e.Name, e.Key)
+		}
+		if _, ok := keys[e.Key]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate peer %s", e.Key)
+		}
+		keys[e.Key] = struct{}{}
+
+		ip := net.ParseIP(e.IP).To4()
+		if ip == nil || !isHostIP(account.Network.Net, ip) {
+			return nil, status.Errorf(codes.InvalidArgument, "IP %s of peer %s isn't a host of the account network %s",
+				e.IP, e.Key, account.Network.Net.String())
+		}
+
+		existing, ok := account.Peers[e.Key]
+		if !ok {
+			if _, err := am.Store.GetPeer(e.Key); err == nil {
+				return nil, status.Errorf(codes.AlreadyExists, "peer %s is registered in another account", e.Key)
+			}
+		}
+
+		// the names given before the peer names had to be DNS labels are kept as they are
+		if !ok || existing.Name != e.Name {
+			if err := validatePeerName(e.Name); err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid name of peer %s: %s", e.Key, status.Convert(err).Message())
+			}
+			renamed[e.Key] = true
+		}
+
+		finalIPs[e.Key] = ip.String()
+		finalNames[e.Key] = e.Name
+
+		if !ok {
+			peers = append(peers, &Peer{
+				Key:    e.Key,
+				IP:     ip,
+				Name:   e.Name,
+				Status: &PeerStatus{Connected: false, LastSeen: time.Now()},
+			})
+			result.AddedPeers = append(result.AddedPeers, e.Key)
+			continue
+		}
+
+		if existing.IP.Equal(ip) && existing.Name == e.Name {
+			continue
+		}
+		peer := existing.Copy()
+		peer.IP = ip
+		peer.Name = e.Name
+		peers = append(peers, peer)
+		result.UpdatedPeers = append(result.UpdatedPeers, e.Key)
+	}
+
+	// check in the order of the keys to report the same collision every time
+	sortedKeys := make([]string, 0, len(finalIPs))
+	for key := range finalIPs {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+	owners := map[string]string{}
+	nameOwners := map[string]string{}
+	for _, key := range sortedKeys {
+		ip := finalIPs[key]
+		if owner, taken := owners[ip]; taken {
+			return nil, status.Errorf(codes.InvalidArgument, "IP %s of peer %s collides with peer %s", ip, key, owner)
+		}
+		owners[ip] = key
+
+		// the peers sharing a name before the peer names had to be unique are kept as they are
+		name := strings.ToLower(finalNames[key])
+		if owner, taken := nameOwners[name]; taken && (renamed[key] || renamed[owner]) {
+			return nil, status.Errorf(codes.InvalidArgument, "name %s of peer %s collides with peer %s", finalNames[key], key, owner)
+		}
+		if _, taken := nameOwners[name]; !taken || renamed[key] {
+			nameOwners[name] = key
+		}
+	}
+
+	return peers, nil
+}
+
+// importSetupKeys validates the exported setup keys and returns the keys to add to the account or to replace the existing ones with
+func (am *DefaultAccountManager) importSetupKeys(account *Account, exported []*ExportedSetupKey, result *ImportResult) ([]*SetupKey, error) {
+	keys := map[string]struct{}{}
+	var setupKeys []*SetupKey
+	for _, e := range exported {
+		key := strings.ToUpper(e.Key)
+		if key == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "setup key %q has no key", e.Name)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate setup key %s", key)
+		}
+		keys[key] = struct{}{}
+
+		if e.Type != SetupKeyReusable && e.Type != SetupKeyOneOff {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid type %q of setup key %s", e.Type, key)
+		}
+		if e.UsageLimit < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "usage limit of setup key %s can't be negative", key)
+		}
+
+		existing, ok := account.SetupKeys[key]
+		if !ok {
+			if owner, err := am.Store.GetAccountBySetupKey(key); err == nil && owner.Id != account.Id {
+				return nil, status.Errorf(codes.AlreadyExists, "setup key %s belongs to another account", key)
+			}
+
+			setupKeys = append(setupKeys, &SetupKey{
+				Id:         strconv.Itoa(int(Hash(key))),
+				Key:        key,
+				Name:       e.Name,
+				Type:       e.Type,
+				CreatedAt:  time.Now(),
+				ExpiresAt:  e.ExpiresAt,
+				Revoked:    e.Revoked,
+				UsageLimit: e.UsageLimit,
+			})
+			result.AddedSetupKeys = append(result.AddedSetupKeys, key)
+			continue
+		}
+
+		if existing.Name == e.Name && existing.Type == e.Type && existing.ExpiresAt.Equal(e.ExpiresAt) &&
+			existing.Revoked == e.Revoked && existing.UsageLimit == e.UsageLimit {
+			continue
+		}
+		setupKey := existing.Copy()
+		setupKey.Name = e.Name
+		setupKey.Type = e.Type
+		setupKey.ExpiresAt = e.ExpiresAt
+		setupKey.Revoked = e.Revoked
+		setupKey.UsageLimit = e.UsageLimit
+		setupKeys = append(setupKeys, setupKey)
+		result.UpdatedSetupKeys = append(result.UpdatedSetupKeys, key)
+	}
+
+	return setupKeys, nil
+}
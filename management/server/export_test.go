@@ -0,0 +1,252 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_ExportImport(t *testing.T) {
+	source, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sourceAccount, err := source.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range sourceAccount.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = source.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("peer-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export, err := source.ExportAccount(sourceAccount.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != AccountExportVersion || len(export.Peers) != 3 || len(export.SetupKeys) != len(sourceAccount.SetupKeys) {
+		t.Fatalf("expecting an export of 3 peers and %d setup keys, got %+v", len(sourceAccount.SetupKeys), export)
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &AccountExport{}
+	err = json.Unmarshal(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetAccount, err := target.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetAccount.Network.Net = sourceAccount.Network.Net
+	err = target.Store.SaveAccount(targetAccount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := targetAccount.Network.CurrentSerial()
+
+	result, err := target.ImportAccount(targetAccount.Id, decoded, true, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.DryRun || len(result.AddedPeers) != 3 || len(result.AddedSetupKeys) != len(export.SetupKeys) {
+		t.Errorf("expecting the dry run to report the peers and the setup keys to add, got %+v", result)
+	}
+	targetAccount, err = target.Store.GetAccount(targetAccount.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targetAccount.Peers) != 0 || targetAccount.Network.CurrentSerial() != serial {
+		t.Fatalf("expecting the dry run not to change the account, got %d peers and serial %d",
+			len(targetAccount.Peers), targetAccount.Network.CurrentSerial())
+	}
+
+	updates := map[string]chan *UpdateMessage{}
+	for _, peer := range export.Peers {
+		updates[peer.Key] = target.peersUpdateManager.CreateChannel(peer.Key)
+		defer target.peersUpdateManager.CloseChannel(peer.Key)
+	}
+
+	result, err = target.ImportAccount(targetAccount.Id, decoded, false, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DryRun || len(result.AddedPeers) != 3 || len(result.UpdatedPeers) != 0 {
+		t.Errorf("expecting the import to add the peers, got %+v", result)
+	}
+
+	targetAccount, err = target.Store.GetAccount(targetAccount.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targetAccount.Network.CurrentSerial() != serial+1 {
+		t.Errorf("expecting the import to increment the serial once, got %d, was %d", targetAccount.Network.CurrentSerial(), serial)
+	}
+	for _, peer := range export.Peers {
+		if len(updates[peer.Key]) != 1 {
+			t.Errorf("expecting peer %s to receive a single update, got %d", peer.Key, len(updates[peer.Key]))
+		}
+	}
+
+	imported, err := target.ExportAccount(targetAccount.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported.Peers, export.Peers) {
+		t.Errorf("expecting the imported peers to be exported as %v, got %v", export.Peers, imported.Peers)
+	}
+	importedSetupKeys := map[string]*ExportedSetupKey{}
+	for _, key := range imported.SetupKeys {
+		importedSetupKeys[key.Key] = key
+	}
+	for _, key := range export.SetupKeys {
+		if !reflect.DeepEqual(importedSetupKeys[key.Key], key) {
+			t.Errorf("expecting the imported setup key to be exported as %+v, got %+v", key, importedSetupKeys[key.Key])
+		}
+	}
+
+	// importing the same document again doesn't change anything
+	result, err = target.ImportAccount(targetAccount.Id, decoded, false, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.AddedPeers)+len(result.UpdatedPeers)+len(result.AddedSetupKeys)+len(result.UpdatedSetupKeys) != 0 {
+		t.Errorf("expecting nothing to change on importing the same document again, got %+v", result)
+	}
+}
+
+func TestAccountManager_ImportValidation(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key.PublicKey().String())
+	}
+
+	ips := account.Network.Net.IP.To4()
+	ip := func(host byte) string {
+		return fmt.Sprintf("%d.%d.%d.%d", ips[0], ips[1], ips[2], host)
+	}
+
+	tt := []struct {
+		name         string
+		export       *AccountExport
+		expectedCode codes.Code
+	}{
+		{
+			name:         "Unsupported Version",
+			export:       &AccountExport{Version: AccountExportVersion + 1},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "IP Collision",
+			export: &AccountExport{Version: AccountExportVersion, Peers: []*ExportedPeer{
+				{Key: keys[0], IP: ip(1), Name: "peer-0"},
+				{Key: keys[1], IP: ip(1), Name: "peer-1"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Name Collision",
+			export: &AccountExport{Version: AccountExportVersion, Peers: []*ExportedPeer{
+				{Key: keys[0], IP: ip(1), Name: "peer-0"},
+				{Key: keys[1], IP: ip(2), Name: "PEER-0"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid Peer Name",
+			export: &AccountExport{Version: AccountExportVersion, Peers: []*ExportedPeer{
+				{Key: keys[0], IP: ip(1), Name: "peer 0.local"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Duplicate Peer",
+			export: &AccountExport{Version: AccountExportVersion, Peers: []*ExportedPeer{
+				{Key: keys[0], IP: ip(1), Name: "peer-0"},
+				{Key: keys[0], IP: ip(2), Name: "peer-0"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "IP Outside Of Network",
+			export: &AccountExport{Version: AccountExportVersion, Peers: []*ExportedPeer{
+				{Key: keys[0], IP: "192.0.2.1", Name: "peer-0"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Duplicate Setup Key",
+			export: &AccountExport{Version: AccountExportVersion, SetupKeys: []*ExportedSetupKey{
+				{Key: "A2C8E62B-38F5-4553-B31E-DD66C696CEBB", Name: "key", Type: SetupKeyReusable},
+				{Key: "a2c8e62b-38f5-4553-b31e-dd66c696cebb", Name: "key", Type: SetupKeyReusable},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid Setup Key Type",
+			export: &AccountExport{Version: AccountExportVersion, SetupKeys: []*ExportedSetupKey{
+				{Key: "A2C8E62B-38F5-4553-B31E-DD66C696CEBB", Name: "key", Type: "permanent"},
+			}},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := manager.ImportAccount(account.Id, tc.export, false, "account_creator")
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("expecting error code %s, got %v", tc.expectedCode, err)
+			}
+
+			stored, err := manager.Store.GetAccount(account.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored.Peers) != 0 || len(stored.SetupKeys) != len(account.SetupKeys) {
+				t.Errorf("expecting a failed import not to change the account")
+			}
+		})
+	}
+}
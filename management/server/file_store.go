@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/netbirdio/netbird/util"
 )
 
@@ -25,10 +28,15 @@ type FileStore struct {
 	PrivateDomain2AccountId map[string]string              `json:"-"`
 	PeerKeyId2SrcRulesId    map[string]map[string]struct{} `json:"-"`
 	PeerKeyId2DstRulesId    map[string]map[string]struct{} `json:"-"`
+	// AuditEvents is the audit log of all the accounts sorted by ID
+	AuditEvents []*activity.Event
+	// LastAuditEventID is the ID of the last appended audit event, IDs of removed events aren't reused
+	LastAuditEventID uint64
 
 	// mutex to synchronise Store read/write operations
-	mux       sync.Mutex `json:"-"`
-	storeFile string     `json:"-"`
+	mux          sync.Mutex   `json:"-"`
+	storeFile    string       `json:"-"`
+	accountLocks accountLocks `json:"-"`
 }
 
 type StoredAccount struct{}
@@ -77,38 +85,24 @@ func restore(file string) (*FileStore, error) {
 	store.PeerKeyId2SrcRulesId = map[string]map[string]struct{}{}
 	store.PeerKeyId2DstRulesId = map[string]map[string]struct{}{}
 
-	for accountId, account := range store.Accounts {
+	// the accounts are indexed in the order of their IDs, so a peer or a setup key duplicated across the accounts
+	// by an older version stays in the same account on every start
+	accountIds := make([]string, 0, len(store.Accounts))
+	for accountId := range store.Accounts {
+		accountIds = append(accountIds, accountId)
+	}
+	sort.Strings(accountIds)
+
+	for _, accountId := range accountIds {
+		account := store.Accounts[accountId]
+		store.dropForeignKeys(account)
 		for setupKeyId := range account.SetupKeys {
 			store.SetupKeyId2AccountId[strings.ToUpper(setupKeyId)] = accountId
 		}
-		for _, rule := range account.Rules {
-			for _, groupID := range rule.Source {
-				if group, ok := account.Groups[groupID]; ok {
-					for _, peerID := range group.Peers {
-						rules := store.PeerKeyId2SrcRulesId[peerID]
-						if rules == nil {
-							rules = map[string]struct{}{}
-							store.PeerKeyId2SrcRulesId[peerID] = rules
-						}
-						rules[rule.ID] = struct{}{}
-					}
-				}
-			}
-			for _, groupID := range rule.Destination {
-				if group, ok := account.Groups[groupID]; ok {
-					for _, peerID := range group.Peers {
-						rules := store.PeerKeyId2DstRulesId[peerID]
-						if rules == nil {
-							rules = map[string]struct{}{}
-							store.PeerKeyId2DstRulesId[peerID] = rules
-						}
-						rules[rule.ID] = struct{}{}
-					}
-				}
-			}
-		}
+		store.indexRules(account)
 		for _, peer := range account.Peers {
 			store.PeerKeyId2AccountId[peer.Key] = accountId
+			setPeerDefaults(peer)
 		}
 		for _, user := range account.Users {
 			store.UserId2AccountId[user.Id] = accountId
@@ -125,6 +119,62 @@ func restore(file string) (*FileStore, error) {
 	return store, nil
 }
 
+// dropForeignKeys removes the peers and the setup keys of the account that have been indexed for another account
+// already, so a peer key or a setup key belongs to a single account only
+func (s *FileStore) dropForeignKeys(account *Account) {
+	for key := range account.Peers {
+		owner, ok := s.PeerKeyId2AccountId[key]
+		if !ok || owner == account.Id {
+			continue
+		}
+		log.Errorf("peer %s belongs to accounts %s and %s, removing it from account %s", key, owner, account.Id, account.Id)
+		delete(account.Peers, key)
+		for _, group := range account.Groups {
+			var peers []string
+			for _, pid := range group.Peers {
+				if pid != key {
+					peers = append(peers, pid)
+				}
+			}
+			group.Peers = peers
+		}
+	}
+
+	for key := range account.SetupKeys {
+		owner, ok := s.SetupKeyId2AccountId[strings.ToUpper(key)]
+		if !ok || owner == account.Id {
+			continue
+		}
+		log.Errorf("setup key %s belongs to accounts %s and %s, removing it from account %s", key, owner, account.Id,
+			account.Id)
+		delete(account.SetupKeys, key)
+	}
+}
+
+// indexRules indexes the rules of the account by the peers they select in their source and destination.
+// The previous indexes of the peers are dropped, so the peers removed from a group or untagged aren't selected anymore
+func (s *FileStore) indexRules(account *Account) {
+	for key := range account.Peers {
+		delete(s.PeerKeyId2SrcRulesId, key)
+		delete(s.PeerKeyId2DstRulesId, key)
+	}
+
+	index := func(rulesIndex map[string]map[string]struct{}, peerKeys []string, ruleID string) {
+		for _, pid := range peerKeys {
+			rules := rulesIndex[pid]
+			if rules == nil {
+				rules = map[string]struct{}{}
+				rulesIndex[pid] = rules
+			}
+			rules[ruleID] = struct{}{}
+		}
+	}
+	for _, rule := range account.Rules {
+		index(s.PeerKeyId2SrcRulesId, rulePeers(account, rule.Source, rule.SourceTags), rule.ID)
+		index(s.PeerKeyId2DstRulesId, rulePeers(account, rule.Destination, rule.DestinationTags), rule.ID)
+	}
+}
+
 // persist persists account data to a file
 // It is recommended to call it with locking FileStore.mux
 func (s *FileStore) persist(file string) error {
@@ -136,7 +186,12 @@ func (s *FileStore) SavePeer(accountId string, peer *Peer) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkAccountBoundary(accountId, []string{peer.Key}, nil)
 	if err != nil {
 		return err
 	}
@@ -159,16 +214,42 @@ func (s *FileStore) SavePeer(accountId string, peer *Peer) error {
 		allGroup.Peers = append(allGroup.Peers, peer.Key)
 	}
 
-	account.Peers[peer.Key] = peer
+	account.Peers[peer.Key] = peer.Copy()
+	s.PeerKeyId2AccountId[peer.Key] = accountId
 	return s.persist(s.storeFile)
 }
 
+// checkAccountBoundary checks that none of the peers and the setup keys belongs to an account other than accountId.
+// The indexes can point to an account the key has been removed from, so the owning account is checked as well
+func (s *FileStore) checkAccountBoundary(accountId string, peerKeys []string, setupKeys []string) error {
+	for _, peerKey := range peerKeys {
+		owner, ok := s.Accounts[s.PeerKeyId2AccountId[peerKey]]
+		if ok && owner.Id != accountId && owner.Peers[peerKey] != nil {
+			return errPeerOfAnotherAccount(peerKey)
+		}
+	}
+
+	for _, setupKey := range setupKeys {
+		owner, ok := s.Accounts[s.SetupKeyId2AccountId[strings.ToUpper(setupKey)]]
+		if !ok || owner.Id == accountId {
+			continue
+		}
+		for ownerKey := range owner.SetupKeys {
+			if strings.EqualFold(ownerKey, setupKey) {
+				return errSetupKeyOfAnotherAccount(setupKey)
+			}
+		}
+	}
+
+	return nil
+}
+
 // DeletePeer deletes peer from the Store
 func (s *FileStore) DeletePeer(accountId string, peerKey string) (*Peer, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
@@ -182,8 +263,8 @@ func (s *FileStore) DeletePeer(accountId string, peerKey string) (*Peer, error)
 	delete(s.PeerKeyId2AccountId, peerKey)
 
 	// cleanup groups
-	var peers []string
 	for _, g := range account.Groups {
+		var peers []string
 		for _, p := range g.Peers {
 			if p != peerKey {
 				peers = append(peers, p)
@@ -210,28 +291,56 @@ func (s *FileStore) GetPeer(peerKey string) (*Peer, error) {
 		return nil, status.Errorf(codes.NotFound, "peer not found")
 	}
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
 	if peer, ok := account.Peers[peerKey]; ok {
-		return peer, nil
+		return peer.Copy(), nil
 	}
 
 	return nil, status.Errorf(codes.NotFound, "peer not found")
 }
 
-// SaveAccount updates an existing account or adds a new one
+// SaveAccount updates an existing account or adds a new one.
+// The store keeps a copy of the account, so the caller can't change the stored state without saving it
 func (s *FileStore) SaveAccount(account *Account) error {
+	return s.SaveAccountWithAuditEvents(account, nil)
+}
+
+// SaveAccountWithAuditEvents saves the account appending the audit events to the audit log in the same file write
+func (s *FileStore) SaveAccountWithAuditEvents(account *Account, events []*activity.Event) error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	// todo will override, handle existing keys
+	account = account.Copy()
+
+	var peerKeys, setupKeys []string
+	for _, peer := range account.Peers {
+		peerKeys = append(peerKeys, peer.Key)
+	}
+	for setupKey := range account.SetupKeys {
+		setupKeys = append(setupKeys, setupKey)
+	}
+	err := s.checkAccountBoundary(account.Id, peerKeys, setupKeys)
+	if err != nil {
+		return err
+	}
+
+	// drop the indexes of the peers removed from the account other than with DeletePeer, e.g. a replaced peer key
+	if previous, ok := s.Accounts[account.Id]; ok {
+		for key := range previous.Peers {
+			if _, ok := account.Peers[key]; !ok {
+				delete(s.PeerKeyId2AccountId, key)
+				delete(s.PeerKeyId2SrcRulesId, key)
+				delete(s.PeerKeyId2DstRulesId, key)
+			}
+		}
+	}
+
 	s.Accounts[account.Id] = account
 
-	// todo check that account.Id and keyId are not exist already
-	// because if keyId exists for other accounts this can be bad
 	for keyId := range account.SetupKeys {
 		s.SetupKeyId2AccountId[strings.ToUpper(keyId)] = account.Id
 	}
@@ -240,28 +349,7 @@ func (s *FileStore) SaveAccount(account *Account) error {
 		s.PeerKeyId2AccountId[peer.Key] = account.Id
 	}
 
-	for _, rule := range account.Rules {
-		for _, gid := range rule.Source {
-			for _, pid := range account.Groups[gid].Peers {
-				rules := s.PeerKeyId2SrcRulesId[pid]
-				if rules == nil {
-					rules = map[string]struct{}{}
-					s.PeerKeyId2SrcRulesId[pid] = rules
-				}
-				rules[rule.ID] = struct{}{}
-			}
-		}
-		for _, gid := range rule.Destination {
-			for _, pid := range account.Groups[gid].Peers {
-				rules := s.PeerKeyId2DstRulesId[pid]
-				if rules == nil {
-					rules = map[string]struct{}{}
-					s.PeerKeyId2DstRulesId[pid] = rules
-				}
-				rules[rule.ID] = struct{}{}
-			}
-		}
-	}
+	s.indexRules(account)
 
 	for _, user := range account.Users {
 		s.UserId2AccountId[user.Id] = account.Id
@@ -271,10 +359,71 @@ func (s *FileStore) SaveAccount(account *Account) error {
 		s.PrivateDomain2AccountId[account.Domain] = account.Id
 	}
 
-	return s.persist(s.storeFile)
+	logLength, lastID := len(s.AuditEvents), s.LastAuditEventID
+	for _, event := range events {
+		s.LastAuditEventID++
+		event.ID = s.LastAuditEventID
+		s.AuditEvents = append(s.AuditEvents, event)
+	}
+
+	err = s.persist(s.storeFile)
+	if err != nil {
+		// the events must not be returned without being persisted
+		s.AuditEvents, s.LastAuditEventID = s.AuditEvents[:logLength], lastID
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditEvents returns the audit events of an account selected by the filter sorted by ID
+func (s *FileStore) GetAuditEvents(accountId string, filter AuditFilter) ([]*activity.Event, int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var selected []*activity.Event
+	for _, event := range s.AuditEvents {
+		if event.AccountID == accountId && filter.matches(event) {
+			eventCopy := *event
+			selected = append(selected, &eventCopy)
+		}
+	}
+
+	return filter.page(selected), len(selected), nil
+}
+
+// DeleteAuditEventsBefore removes the audit events of all the accounts older than the given time
+func (s *FileStore) DeleteAuditEventsBefore(before time.Time) (int, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	kept := make([]*activity.Event, 0, len(s.AuditEvents))
+	for _, event := range s.AuditEvents {
+		if !event.Timestamp.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	removed := len(s.AuditEvents) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	events := s.AuditEvents
+	s.AuditEvents = kept
+	err := s.persist(s.storeFile)
+	if err != nil {
+		s.AuditEvents = events
+		return 0, err
+	}
+
+	return removed, nil
 }
 
+// GetAccountByPrivateDomain returns the primary account of a private domain
 func (s *FileStore) GetAccountByPrivateDomain(domain string) (*Account, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	accountId, accountIdFound := s.PrivateDomain2AccountId[strings.ToLower(domain)]
 	if !accountIdFound {
 		return nil, status.Errorf(
@@ -283,54 +432,116 @@ func (s *FileStore) GetAccountByPrivateDomain(domain string) (*Account, error) {
 		)
 	}
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
-	return account, nil
+	return account.Copy(), nil
 }
 
+// GetAccountBySetupKey returns the account a setup key belongs to
 func (s *FileStore) GetAccountBySetupKey(setupKey string) (*Account, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	accountId, accountIdFound := s.SetupKeyId2AccountId[strings.ToUpper(setupKey)]
 	if !accountIdFound {
 		return nil, status.Errorf(codes.NotFound, "provided setup key doesn't exists")
 	}
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
-	return account, nil
+	return account.Copy(), nil
 }
 
 func (s *FileStore) GetAccountPeers(accountId string) ([]*Peer, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
 	var peers []*Peer
 	for _, peer := range account.Peers {
-		peers = append(peers, peer)
+		peers = append(peers, peer.Copy())
 	}
 
 	return peers, nil
 }
 
+// AcquireAccountLock acquires the write lock of an account and returns a function releasing it
+func (s *FileStore) AcquireAccountLock(accountId string) (unlock func()) {
+	return s.accountLocks.acquire(accountId)
+}
+
+// CheckHealth checks that the store is loaded and writes a probe file next to the store file flushing it to disk
+func (s *FileStore) CheckHealth() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if s.Accounts == nil {
+		return fmt.Errorf("store %s isn't loaded", s.storeFile)
+	}
+
+	dir, fileName := filepath.Split(s.storeFile)
+	probe, err := os.CreateTemp(dir, ".health.*"+fileName)
+	if err != nil {
+		return fmt.Errorf("store directory %s isn't writable: %v", dir, err)
+	}
+	defer os.Remove(probe.Name()) //nolint
+
+	_, err = probe.WriteString("ok")
+	if err == nil {
+		err = probe.Sync()
+	}
+	closeErr := probe.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing to store directory %s: %v", dir, err)
+	}
+
+	return nil
+}
+
+// Close does nothing as the FileStore persists every change immediately
+func (s *FileStore) Close() error {
+	return nil
+}
+
 func (s *FileStore) GetAllAccounts() (all []*Account) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
 	for _, a := range s.Accounts {
-		all = append(all, a)
+		all = append(all, a.Copy())
 	}
 
 	return all
 }
 
+// GetAccount returns a copy of an account by its ID
 func (s *FileStore) GetAccount(accountId string) (*Account, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	account, err := s.getAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Copy(), nil
+}
+
+// getAccount returns an account by its ID. It has to be called with locking FileStore.mux
+func (s *FileStore) getAccount(accountId string) (*Account, error) {
 	account, accountFound := s.Accounts[accountId]
 	if !accountFound {
 		return nil, status.Errorf(codes.NotFound, "account not found")
@@ -348,7 +559,12 @@ func (s *FileStore) GetUserAccount(userId string) (*Account, error) {
 		return nil, status.Errorf(codes.NotFound, "account not found")
 	}
 
-	return s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Copy(), nil
 }
 
 func (s *FileStore) GetPeerAccount(peerKey string) (*Account, error) {
@@ -360,28 +576,34 @@ func (s *FileStore) GetPeerAccount(peerKey string) (*Account, error) {
 		return nil, status.Errorf(codes.NotFound, "Provided peer key doesn't exists %s", peerKey)
 	}
 
-	return s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Copy(), nil
 }
 
 func (s *FileStore) GetPeerSrcRules(accountId, peerKey string) ([]*Rule, error) {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
-	ruleIDs, ok := s.PeerKeyId2SrcRulesId[peerKey]
-	if !ok {
-		return nil, fmt.Errorf("no rules for peer: %v", ruleIDs)
+	// a peer of the account might be selected by no rule, e.g. if the rules select the peers by tags only
+	if _, ok := account.Peers[peerKey]; !ok {
+		return nil, fmt.Errorf("no rules for peer: %s", peerKey)
 	}
+	ruleIDs := s.PeerKeyId2SrcRulesId[peerKey]
 
 	rules := []*Rule{}
 	for id := range ruleIDs {
 		rule, ok := account.Rules[id]
 		if ok {
-			rules = append(rules, rule)
+			rules = append(rules, rule.Copy())
 		}
 	}
 
@@ -392,21 +614,22 @@ func (s *FileStore) GetPeerDstRules(accountId, peerKey string) ([]*Rule, error)
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	account, err := s.GetAccount(accountId)
+	account, err := s.getAccount(accountId)
 	if err != nil {
 		return nil, err
 	}
 
-	ruleIDs, ok := s.PeerKeyId2DstRulesId[peerKey]
-	if !ok {
-		return nil, fmt.Errorf("no rules for peer: %v", ruleIDs)
+	// a peer of the account might be selected by no rule, e.g. if the rules select the peers by tags only
+	if _, ok := account.Peers[peerKey]; !ok {
+		return nil, fmt.Errorf("no rules for peer: %s", peerKey)
 	}
+	ruleIDs := s.PeerKeyId2DstRulesId[peerKey]
 
 	rules := []*Rule{}
 	for id := range ruleIDs {
 		rule, ok := account.Rules[id]
 		if ok {
-			rules = append(rules, rule)
+			rules = append(rules, rule.Copy())
 		}
 	}
 
@@ -4,6 +4,7 @@ import (
 	"github.com/netbirdio/netbird/util"
 	"github.com/stretchr/testify/require"
 	"net"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
@@ -150,6 +151,64 @@ func TestRestore(t *testing.T) {
 	require.Len(t, store.PrivateDomain2AccountId, 1, "failed to restore a FileStore wrong PrivateDomain2AccountId mapping length")
 }
 
+func TestRestorePeerDefaults(t *testing.T) {
+	storeDir := t.TempDir()
+
+	// a peer persisted by an older Management service version without status and system meta data
+	legacy := `{"Accounts":{"account":{"Id":"account","Peers":{"peerkey":{"Key":"peerkey","IP":"100.64.0.1","Name":"peer"}}}}}`
+	err := os.WriteFile(filepath.Join(storeDir, "store.json"), []byte(legacy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(storeDir)
+	require.NoError(t, err)
+
+	peer, err := store.GetPeer("peerkey")
+	require.NoError(t, err)
+	require.NotNil(t, peer.Status, "restored peer should have a default status")
+	require.False(t, peer.Status.Connected, "restored peer should be disconnected")
+	require.Equal(t, UnknownVersion, peer.Meta.WtVersion, "restored peer should have an unknown version")
+}
+
+func TestRestoreDuplicatedKeys(t *testing.T) {
+	storeDir := t.TempDir()
+
+	// a peer and a setup key persisted in two accounts by an older Management service version
+	legacy := `{"Accounts":{
+		"account_b":{"Id":"account_b","Network":{"Net":{"IP":"100.64.0.0","Mask":"//8AAA=="}},"SetupKeys":{"KEY":{"Key":"KEY"}},
+			"Peers":{"peerkey":{"Key":"peerkey","IP":"100.64.0.1","Name":"b"}},
+			"Groups":{"all":{"ID":"all","Name":"All","Peers":["peerkey"]}}},
+		"account_a":{"Id":"account_a","Network":{"Net":{"IP":"100.64.0.0","Mask":"//8AAA=="}},"SetupKeys":{"KEY":{"Key":"KEY"}},
+			"Peers":{"peerkey":{"Key":"peerkey","IP":"100.64.0.1","Name":"a"}}}}}`
+	err := os.WriteFile(filepath.Join(storeDir, "store.json"), []byte(legacy), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys stay in the account with the lowest ID on every start
+	for i := 0; i < 3; i++ {
+		store, err := NewStore(storeDir)
+		require.NoError(t, err)
+
+		account, err := store.GetPeerAccount("peerkey")
+		require.NoError(t, err)
+		require.Equal(t, "account_a", account.Id)
+		account, err = store.GetAccountBySetupKey("KEY")
+		require.NoError(t, err)
+		require.Equal(t, "account_a", account.Id)
+
+		account, err = store.GetAccount("account_b")
+		require.NoError(t, err)
+		require.Empty(t, account.Peers, "expecting the duplicated peer to be removed")
+		require.Empty(t, account.SetupKeys, "expecting the duplicated setup key to be removed")
+		require.Empty(t, account.Groups["all"].Peers)
+
+		// the account without the duplicates can be saved again
+		require.NoError(t, store.SaveAccount(account))
+	}
+}
+
 func TestGetAccountByPrivateDomain(t *testing.T) {
 	storeDir := t.TempDir()
 
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/netbirdio/netbird/management/server/activity"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -21,14 +22,14 @@ func (g *Group) Copy() *Group {
 	return &Group{
 		ID:    g.ID,
 		Name:  g.Name,
-		Peers: g.Peers[:],
+		Peers: append([]string(nil), g.Peers...),
 	}
 }
 
 // GetGroup object of the peers
 func (am *DefaultAccountManager) GetGroup(accountID, groupID string) (*Group, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -43,39 +44,61 @@ func (am *DefaultAccountManager) GetGroup(accountID, groupID string) (*Group, er
 	return nil, status.Errorf(codes.NotFound, "group with ID %s not found", groupID)
 }
 
-// SaveGroup object of the peers
-func (am *DefaultAccountManager) SaveGroup(accountID string, group *Group) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// SaveGroup object of the peers. The userID is the user who initiated the change
+func (am *DefaultAccountManager) SaveGroup(accountID, userID string, group *Group) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
 		return status.Errorf(codes.NotFound, "account not found")
 	}
 
+	// a peer of another account must not get into the network maps of this one
+	for _, peerKey := range group.Peers {
+		if _, ok := account.Peers[peerKey]; !ok {
+			return status.Errorf(codes.InvalidArgument, "peer %s not found", peerKey)
+		}
+	}
+
+	eventType := activity.GroupCreated
+	var before interface{}
+	if existing, exists := account.Groups[group.ID]; exists {
+		eventType = activity.GroupUpdated
+		before = existing
+	}
+	event := newAuditEvent(userID, group.ID, accountID, eventType, map[string]string{"name": group.Name}, before, group)
+
 	account.Groups[group.ID] = group
-	return am.Store.SaveAccount(account)
+	account.Network.IncSerial()
+	return am.saveAccount(account, event)
 }
 
-// DeleteGroup object of the peers
-func (am *DefaultAccountManager) DeleteGroup(accountID, groupID string) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// DeleteGroup object of the peers. The userID is the user who initiated the removal
+func (am *DefaultAccountManager) DeleteGroup(accountID, userID, groupID string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
 		return status.Errorf(codes.NotFound, "account not found")
 	}
 
+	group, ok := account.Groups[groupID]
+	if !ok {
+		return nil
+	}
 	delete(account.Groups, groupID)
 
-	return am.Store.SaveAccount(account)
+	account.Network.IncSerial()
+	return am.saveAccount(account, newAuditEvent(userID, groupID, accountID, activity.GroupDeleted,
+		map[string]string{"name": group.Name}, group, nil))
 }
 
 // ListGroups objects of the peers
 func (am *DefaultAccountManager) ListGroups(accountID string) ([]*Group, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -90,10 +113,10 @@ func (am *DefaultAccountManager) ListGroups(accountID string) ([]*Group, error)
 	return groups, nil
 }
 
-// GroupAddPeer appends peer to the group
-func (am *DefaultAccountManager) GroupAddPeer(accountID, groupID, peerKey string) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// GroupAddPeer appends peer to the group. The userID is the user who initiated the change
+func (am *DefaultAccountManager) GroupAddPeer(accountID, userID, groupID, peerKey string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -105,24 +128,28 @@ func (am *DefaultAccountManager) GroupAddPeer(accountID, groupID, peerKey string
 		return status.Errorf(codes.NotFound, "group with ID %s not found", groupID)
 	}
 
-	add := true
+	if _, ok := account.Peers[peerKey]; !ok {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
 	for _, itemID := range group.Peers {
 		if itemID == peerKey {
-			add = false
-			break
+			return nil
 		}
 	}
-	if add {
-		group.Peers = append(group.Peers, peerKey)
-	}
 
-	return am.Store.SaveAccount(account)
+	before := group.Copy()
+	group.Peers = append(group.Peers, peerKey)
+	account.Network.IncSerial()
+
+	return am.saveAccount(account, newAuditEvent(userID, groupID, accountID, activity.GroupUpdated,
+		map[string]string{"name": group.Name}, before, group))
 }
 
-// GroupDeletePeer removes peer from the group
-func (am *DefaultAccountManager) GroupDeletePeer(accountID, groupID, peerKey string) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// GroupDeletePeer removes peer from the group. The userID is the user who initiated the change
+func (am *DefaultAccountManager) GroupDeletePeer(accountID, userID, groupID, peerKey string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -136,8 +163,11 @@ func (am *DefaultAccountManager) GroupDeletePeer(accountID, groupID, peerKey str
 
 	for i, itemID := range group.Peers {
 		if itemID == peerKey {
+			before := group.Copy()
 			group.Peers = append(group.Peers[:i], group.Peers[i+1:]...)
-			return am.Store.SaveAccount(account)
+			account.Network.IncSerial()
+			return am.saveAccount(account, newAuditEvent(userID, groupID, accountID, activity.GroupUpdated,
+				map[string]string{"name": group.Name}, before, group))
 		}
 	}
 
@@ -146,8 +176,8 @@ func (am *DefaultAccountManager) GroupDeletePeer(accountID, groupID, peerKey str
 
 // GroupListPeers returns list of the peers from the group
 func (am *DefaultAccountManager) GroupListPeers(accountID, groupID string) ([]*Peer, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -2,8 +2,11 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/netbirdio/netbird/management/server/http/middleware"
@@ -12,14 +15,17 @@ import (
 	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/netbirdio/netbird/encryption"
 	"github.com/netbirdio/netbird/management/proto"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Server an instance of a Management server
 type Server struct {
+	// syncStreams is the number of open Sync streams, first for the 64-bit alignment of its atomic access
+	syncStreams int64
+
 	accountManager AccountManager
 	wgKey          wgtypes.Key
 	proto.UnimplementedManagementServiceServer
@@ -27,14 +33,23 @@ type Server struct {
 	config                 *Config
 	turnCredentialsManager TURNCredentialsManager
 	jwtMiddleware          *middleware.JWTMiddleware
+	// epoch identifies this instance of the server in the SyncResponse, so peers can detect a reset of the network serial
+	epoch uint64
+	// inactivityTimeout is how long a message can take to be sent to a peer over the Sync stream before the stream is closed
+	inactivityTimeout time.Duration
+	// syncSessions allows peers to resume their Sync stream after a reconnect without receiving the whole network map again
+	syncSessions *SyncSessionManager
 }
 
+// errSyncStreamStalled is returned when a peer has stopped receiving the messages of its Sync stream
+var errSyncStreamStalled = status.Error(codes.DeadlineExceeded, "sync stream stalled")
+
 // AllowedIPsFormat generates Wireguard AllowedIPs format (e.g. 100.30.30.1/32)
 const AllowedIPsFormat = "%s/32"
 
 // NewServer creates a new Management server
 func NewServer(config *Config, accountManager AccountManager, peersUpdateManager *PeersUpdateManager, turnCredentialsManager TURNCredentialsManager) (*Server, error) {
-	key, err := wgtypes.GeneratePrivateKey()
+	key, err := loadServerKey(config.Datadir)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +68,16 @@ func NewServer(config *Config, accountManager AccountManager, peersUpdateManager
 		log.Debug("unable to use http config to create new jwt middleware")
 	}
 
+	inactivityTimeout := config.SyncStreamInactivityTimeout.Duration
+	if inactivityTimeout <= 0 {
+		inactivityTimeout = DefaultSyncStreamInactivityTimeout
+	}
+
+	sessionGracePeriod := config.SyncSessionGracePeriod.Duration
+	if sessionGracePeriod <= 0 {
+		sessionGracePeriod = DefaultSyncSessionGracePeriod
+	}
+
 	return &Server{
 		wgKey: key,
 		// peerKey -> event channel
@@ -61,6 +86,9 @@ func NewServer(config *Config, accountManager AccountManager, peersUpdateManager
 		config:                 config,
 		turnCredentialsManager: turnCredentialsManager,
 		jwtMiddleware:          jwtMiddleware,
+		epoch:                  uint64(time.Now().UnixNano()),
+		inactivityTimeout:      inactivityTimeout,
+		syncSessions:           NewSyncSessionManager(sessionGracePeriod),
 	}, nil
 }
 
@@ -93,13 +121,33 @@ func (s *Server) Sync(req *proto.EncryptedMessage, srv proto.ManagementService_S
 		return status.Errorf(codes.PermissionDenied, "provided peer with the key wgPubKey %s is not registered", peerKey.String())
 	}
 
+	err = s.accountManager.CheckPeerLogin(peerKey.String())
+	if err != nil {
+		return err
+	}
+
 	syncReq := &proto.SyncRequest{}
 	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, syncReq)
 	if err != nil {
 		return status.Errorf(codes.InvalidArgument, "invalid request message")
 	}
 
-	err = s.sendInitialSync(peerKey, peer, srv)
+	atomic.AddInt64(&s.syncStreams, 1)
+	defer atomic.AddInt64(&s.syncStreams, -1)
+
+	// a peer reconnecting within the grace period of its previous session resumes it
+	sessionToken := syncReq.GetSessionToken()
+	resumed := sessionToken != "" && s.syncSessions.ResumeSession(sessionToken, peerKey.String())
+	if !resumed {
+		sessionToken = s.syncSessions.OpenSession(peerKey.String())
+	}
+	defer s.syncSessions.CloseSession(sessionToken)
+
+	appliedSerial := uint64(0)
+	if resumed {
+		appliedSerial = syncReq.GetSerial()
+	}
+	err = s.sendInitialSync(peerKey, peer, sessionToken, appliedSerial, syncReq.GetRemotePeersDelta(), srv)
 	if err != nil {
 		return err
 	}
@@ -119,40 +167,97 @@ func (s *Server) Sync(req *proto.EncryptedMessage, srv proto.ManagementService_S
 		// condition when there are some updates
 		case update, open := <-updates:
 			if !open {
-				// updates channel has been closed
+				// updates channel has been closed, e.g. the login of the peer has expired
+				if err = s.accountManager.CheckPeerLogin(peerKey.String()); err != nil {
+					s.turnCredentialsManager.CancelRefresh(peerKey.String())
+					if markErr := s.accountManager.MarkPeerConnected(peerKey.String(), false); markErr != nil {
+						log.Warnf("failed marking peer as disconnected %s %v", peerKey, markErr)
+					}
+					return err
+				}
 				return nil
 			}
 			log.Debugf("recevied an update for peer %s", peerKey.String())
+			update.Update.Epoch = s.epoch
+			if syncReq.GetRemotePeersDelta() && update.Update.GetNetworkMap() != nil {
+				s.syncSessions.UpdateNetworkMap(sessionToken, update.Update.NetworkMap)
+				clearLegacyNetworkMap(update.Update)
+			}
 
 			encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, update.Update)
 			if err != nil {
 				return status.Errorf(codes.Internal, "failed processing update message")
 			}
 
-			err = srv.SendMsg(&proto.EncryptedMessage{
+			sendStartedAt := time.Now()
+			err = s.sendWithTimeout(srv, &proto.EncryptedMessage{
 				WgPubKey: s.wgKey.PublicKey().String(),
 				Body:     encryptedResp,
 			})
 			if err != nil {
+				// the peer was last seen receiving the previous update
+				s.disconnectPeer(peerKey.String(), sendStartedAt)
+				if errors.Is(err, errSyncStreamStalled) {
+					log.Warnf("peer %s hasn't received an update for %s, closing its stream", peerKey.String(), s.inactivityTimeout)
+					return err
+				}
 				return status.Errorf(codes.Internal, "failed sending update message")
 			}
 			log.Debugf("sent an update to peer %s", peerKey.String())
 		// condition when client <-> server connection has been terminated
 		case <-srv.Context().Done():
-			// happens when connection drops, e.g. client disconnects
+			// happens when connection drops, e.g. client disconnects or stops responding to the keepalive pings
 			log.Debugf("stream of peer %s has been closed", peerKey.String())
-			s.peersUpdateManager.CloseChannel(peerKey.String())
-			s.turnCredentialsManager.CancelRefresh(peerKey.String())
-			err = s.accountManager.MarkPeerConnected(peerKey.String(), false)
-			if err != nil {
-				log.Warnf("failed marking peer as disconnected %s %v", peerKey, err)
-			}
-			// todo stop turn goroutine
+			s.disconnectPeer(peerKey.String(), time.Now())
 			return srv.Context().Err()
 		}
 	}
 }
 
+// sendWithTimeout sends a message over the Sync stream of a peer. A send blocks once the flow control window of the stream
+// is exhausted, e.g. the peer has lost power or stopped reading, so errSyncStreamStalled is returned if it takes longer
+// than the inactivity timeout. The stream has to be closed then, which unblocks the pending send
+func (s *Server) sendWithTimeout(srv proto.ManagementService_SyncServer, msg *proto.EncryptedMessage) error {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- srv.Send(msg)
+	}()
+
+	timer := time.NewTimer(s.inactivityTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-sent:
+		return err
+	case <-timer.C:
+		return errSyncStreamStalled
+	}
+}
+
+// disconnectPeer stops sending updates to a peer which Sync stream has been closed and marks it as disconnected
+func (s *Server) disconnectPeer(peerKey string, lastSeen time.Time) {
+	s.peersUpdateManager.CloseChannel(peerKey)
+	s.turnCredentialsManager.CancelRefresh(peerKey)
+	err := s.accountManager.MarkPeerDisconnected(peerKey, lastSeen)
+	if err != nil {
+		log.Warnf("failed marking peer as disconnected %s %v", peerKey, err)
+	}
+}
+
+// validateToken validates the JWT provided by the peer against the configured identity provider and extracts its claims
+func (s *Server) validateToken(jwtToken string) (jwtclaims.AuthorizationClaims, error) {
+	if s.jwtMiddleware == nil {
+		return jwtclaims.AuthorizationClaims{}, status.Error(codes.FailedPrecondition, "jwt authentication is not configured on the Management Service")
+	}
+
+	token, err := s.jwtMiddleware.ValidateAndParse(jwtToken)
+	if err != nil {
+		return jwtclaims.AuthorizationClaims{}, status.Errorf(codes.Unauthenticated, "invalid jwt token, err: %v", err)
+	}
+
+	return jwtclaims.ExtractClaimsWithToken(token, s.config.HttpConfig.AuthAudience), nil
+}
+
 func (s *Server) registerPeer(peerKey wgtypes.Key, req *proto.LoginRequest) (*Peer, error) {
 	var (
 		reqSetupKey string
@@ -162,20 +267,24 @@ func (s *Server) registerPeer(peerKey wgtypes.Key, req *proto.LoginRequest) (*Pe
 	if req.GetJwtToken() != "" {
 		log.Debugln("using jwt token to register peer")
 
-		if s.jwtMiddleware == nil {
-			return nil, status.Error(codes.Internal, "no jwt middleware set")
-		}
-
-		token, err := s.jwtMiddleware.ValidateAndParse(req.GetJwtToken())
+		claims, err := s.validateToken(req.GetJwtToken())
 		if err != nil {
-			return nil, status.Errorf(codes.Internal, "invalid jwt token, err: %v", err)
+			return nil, err
 		}
-		claims := jwtclaims.ExtractClaimsWithToken(token, s.config.HttpConfig.AuthAudience)
 		_, err = s.accountManager.GetAccountWithAuthorizationClaims(claims)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "unable to fetch account with claims, err: %v", err)
 		}
 		userId = claims.UserId
+	} else if req.GetDeviceAuthToken() != "" {
+		log.Debugln("using device authorization to register peer")
+
+		// the peer is registered by the user who has approved the authorization
+		var err error
+		userId, err = s.accountManager.RedeemDeviceAuth(req.GetDeviceAuthToken(), peerKey.String())
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		log.Debugln("using setup key to register peer")
 
@@ -188,24 +297,25 @@ func (s *Server) registerPeer(peerKey wgtypes.Key, req *proto.LoginRequest) (*Pe
 		return nil, status.Errorf(codes.InvalidArgument, "peer meta data was not provided")
 	}
 
+	var requestedIP net.IP
+	if req.GetRequestedIp() != "" {
+		requestedIP = net.ParseIP(req.GetRequestedIp())
+		if requestedIP == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid requested IP %s", req.GetRequestedIp())
+		}
+	}
+
 	peer, err := s.accountManager.AddPeer(reqSetupKey, userId, &Peer{
 		Key:  peerKey.String(),
 		Name: meta.GetHostname(),
-		Meta: PeerSystemMeta{
-			Hostname:  meta.GetHostname(),
-			GoOS:      meta.GetGoOS(),
-			Kernel:    meta.GetKernel(),
-			Core:      meta.GetCore(),
-			Platform:  meta.GetPlatform(),
-			OS:        meta.GetOS(),
-			WtVersion: meta.GetWiretrusteeVersion(),
-			UIVersion: meta.GetUiVersion(),
-		},
+		Meta: toPeerSystemMeta(meta),
+		IP:   requestedIP,
 	})
 	if err != nil {
 		s, ok := status.FromError(err)
 		if ok {
-			if s.Code() == codes.FailedPrecondition || s.Code() == codes.OutOfRange {
+			if s.Code() == codes.FailedPrecondition || s.Code() == codes.OutOfRange || s.Code() == codes.AlreadyExists ||
+				s.Code() == codes.PermissionDenied || s.Code() == codes.ResourceExhausted || s.Code() == codes.InvalidArgument {
 				return nil, err
 			}
 		}
@@ -220,14 +330,13 @@ func (s *Server) registerPeer(peerKey wgtypes.Key, req *proto.LoginRequest) (*Pe
 
 	// notify other peers of our registration
 	for _, remotePeer := range networkMap.Peers {
-		// exclude notified peer and add ourselves
-		peersToSend := []*Peer{peer}
-		for _, p := range networkMap.Peers {
-			if remotePeer.Key != p.Key {
-				peersToSend = append(peersToSend, p)
-			}
+		// the network map of the remote peer includes ourselves and the routes advertised to it
+		remoteNetworkMap, err := s.accountManager.GetNetworkMap(remotePeer.Key)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "unable to fetch network map of peer %s after registering peer, error: %v",
+				remotePeer.Key, err)
 		}
-		update := toSyncResponse(s.config, peer, peersToSend, nil, networkMap.Network.CurrentSerial())
+		update := toSyncResponse(s.config, remotePeer, remoteNetworkMap, nil)
 		err = s.peersUpdateManager.SendUpdate(remotePeer.Key, &UpdateMessage{Update: update})
 		if err != nil {
 			// todo rethink if we should keep this return
@@ -261,7 +370,7 @@ func (s *Server) Login(ctx context.Context, req *proto.EncryptedMessage) (*proto
 	if err != nil {
 		if errStatus, ok := status.FromError(err); ok && errStatus.Code() == codes.NotFound {
 			// peer doesn't exist -> check if setup key was provided
-			if loginReq.GetJwtToken() == "" && loginReq.GetSetupKey() == "" {
+			if loginReq.GetJwtToken() == "" && loginReq.GetSetupKey() == "" && loginReq.GetDeviceAuthToken() == "" {
 				// absent setup key -> permission denied
 				return nil, status.Errorf(codes.PermissionDenied, "provided peer with the key wgPubKey %s is not registered and no setup key or jwt was provided", peerKey.String())
 			}
@@ -275,28 +384,78 @@ func (s *Server) Login(ctx context.Context, req *proto.EncryptedMessage) (*proto
 		} else {
 			return nil, status.Error(codes.Internal, "internal server error")
 		}
-	} else if loginReq.GetMeta() != nil {
-		// update peer's system meta data on Login
-		err = s.accountManager.UpdatePeerMeta(peerKey.String(), PeerSystemMeta{
-			Hostname:  loginReq.GetMeta().GetHostname(),
-			GoOS:      loginReq.GetMeta().GetGoOS(),
-			Kernel:    loginReq.GetMeta().GetKernel(),
-			Core:      loginReq.GetMeta().GetCore(),
-			Platform:  loginReq.GetMeta().GetPlatform(),
-			OS:        loginReq.GetMeta().GetOS(),
-			WtVersion: loginReq.GetMeta().GetWiretrusteeVersion(),
-			UIVersion: loginReq.GetMeta().GetUiVersion(),
-		},
-		)
-		if err != nil {
-			log.Errorf("failed updating peer system meta data %s", peerKey.String())
-			return nil, status.Error(codes.Internal, "internal server error")
+	} else {
+		// a peer which login has expired logs in again with a setup key or as its user, it isn't registered again
+		loginErr := s.accountManager.CheckPeerLogin(peerKey.String())
+		loginExpired := status.Code(loginErr) == codes.PermissionDenied
+		if !loginExpired && (loginReq.GetSetupKey() != "" || loginReq.GetJwtToken() != "" || loginReq.GetDeviceAuthToken() != "") {
+			// peer is registered but the client is trying to register it again (e.g. a machine cloned from an image)
+			if !loginReq.GetReRegister() {
+				return nil, status.Errorf(codes.AlreadyExists, "peer with the key wgPubKey %s is already registered. "+
+					"Generate a new key if the machine was cloned or set the re-register flag to reuse the existing peer", peerKey.String())
+			}
+			log.Infof("peer %s is re-registering, reusing the existing peer", peerKey.String())
+		}
+
+		if loginReq.GetJwtToken() != "" {
+			// peer is registered but the client has provided a token -> it has to belong to the user owning the peer
+			claims, err := s.validateToken(loginReq.GetJwtToken())
+			if err != nil {
+				return nil, err
+			}
+			if peer.UserID != "" && peer.UserID != claims.UserId {
+				return nil, status.Errorf(codes.PermissionDenied, "peer %s is registered by another user", peerKey.String())
+			}
+
+			// the user has logged in with the peer, so its login expiration starts over
+			err = s.accountManager.MarkPeerLoggedIn(peerKey.String())
+			if err != nil {
+				log.Errorf("failed marking peer %s as logged in: %v", peerKey.String(), err)
+				return nil, status.Error(codes.Internal, "internal server error")
+			}
+		} else if loginReq.GetDeviceAuthToken() != "" {
+			// the user who has approved the authorization has to own the peer
+			userID, err := s.accountManager.RedeemDeviceAuth(loginReq.GetDeviceAuthToken(), peerKey.String())
+			if err != nil {
+				return nil, err
+			}
+			if peer.UserID != "" && peer.UserID != userID {
+				return nil, status.Errorf(codes.PermissionDenied, "peer %s is registered by another user", peerKey.String())
+			}
+			err = s.accountManager.MarkPeerLoggedIn(peerKey.String())
+			if err != nil {
+				log.Errorf("failed marking peer %s as logged in: %v", peerKey.String(), err)
+				return nil, status.Error(codes.Internal, "internal server error")
+			}
+		} else if loginReq.GetSetupKey() != "" {
+			// a valid setup key of the account of the peer starts its login expiration over
+			err = s.accountManager.LoginPeerWithSetupKey(loginReq.GetSetupKey(), peerKey.String())
+			if err != nil {
+				return nil, err
+			}
+		} else if loginErr != nil {
+			return nil, loginErr
+		}
+
+		if loginReq.GetMeta() != nil {
+			// update peer's system meta data on Login
+			err = s.accountManager.UpdatePeerMeta(peerKey.String(), toPeerSystemMeta(loginReq.GetMeta()))
+			if err != nil {
+				log.Errorf("failed updating peer system meta data %s", peerKey.String())
+				return nil, status.Error(codes.Internal, "internal server error")
+			}
 		}
 	}
+	networkMap, err := s.accountManager.GetNetworkMap(peer.Key)
+	if err != nil {
+		log.Errorf("failed getting network map of peer %s: %v", peerKey.String(), err)
+		return nil, status.Error(codes.Internal, "internal server error")
+	}
+
 	// if peer has reached this point then it has logged in
 	loginResp := &proto.LoginResponse{
 		WiretrusteeConfig: toWiretrusteeConfig(s.config, nil),
-		PeerConfig:        toPeerConfig(peer),
+		PeerConfig:        toPeerConfig(peer, networkMap.Network),
 	}
 	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, loginResp)
 	if err != nil {
@@ -309,6 +468,25 @@ func (s *Server) Login(ctx context.Context, req *proto.EncryptedMessage) (*proto
 	}, nil
 }
 
+// toPeerSystemMeta converts the system meta data sent by the peer to PeerSystemMeta
+func toPeerSystemMeta(meta *proto.PeerSystemMeta) PeerSystemMeta {
+	return PeerSystemMeta{
+		Hostname:        meta.GetHostname(),
+		GoOS:            meta.GetGoOS(),
+		Kernel:          meta.GetKernel(),
+		Core:            meta.GetCore(),
+		Platform:        meta.GetPlatform(),
+		OS:              meta.GetOS(),
+		WtVersion:       meta.GetWiretrusteeVersion(),
+		UIVersion:       meta.GetUiVersion(),
+		Labels:          meta.GetLabels(),
+		WgPort:          int(meta.GetWgPort()),
+		DiskEncrypted:   meta.GetDiskEncrypted(),
+		FirewallEnabled: meta.GetFirewallEnabled(),
+		SSHPubKey:       meta.GetSshPubKey(),
+	}
+}
+
 func ToResponseProto(configProto Protocol) proto.HostConfig_Protocol {
 	switch configProto {
 	case UDP:
@@ -335,30 +513,10 @@ func toWiretrusteeConfig(config *Config, turnCredentials *TURNCredentials) *prot
 			Protocol: ToResponseProto(stun.Proto),
 		})
 	}
-	var turns []*proto.ProtectedHostConfig
-	for _, turn := range config.TURNConfig.Turns {
-		var username string
-		var password string
-		if turnCredentials != nil {
-			username = turnCredentials.Username
-			password = turnCredentials.Password
-		} else {
-			username = turn.Username
-			password = turn.Password
-		}
-		turns = append(turns, &proto.ProtectedHostConfig{
-			HostConfig: &proto.HostConfig{
-				Uri:      turn.URI,
-				Protocol: ToResponseProto(turn.Proto),
-			},
-			User:     username,
-			Password: password,
-		})
-	}
 
 	return &proto.WiretrusteeConfig{
 		Stuns: stuns,
-		Turns: turns,
+		Turns: toTURNsProto(config.TURNConfig, turnCredentials),
 		Signal: &proto.HostConfig{
 			Uri:      config.Signal.URI,
 			Protocol: ToResponseProto(config.Signal.Proto),
@@ -366,30 +524,93 @@ func toWiretrusteeConfig(config *Config, turnCredentials *TURNCredentials) *prot
 	}
 }
 
-func toPeerConfig(peer *Peer) *proto.PeerConfig {
+// toTURNsProto returns the TURN servers with the given credentials, or with their static credentials if nil
+func toTURNsProto(config *TURNConfig, turnCredentials *TURNCredentials) []*proto.ProtectedHostConfig {
+	var turns []*proto.ProtectedHostConfig
+	for _, turn := range config.Turns {
+		turnProto := &proto.ProtectedHostConfig{
+			HostConfig: &proto.HostConfig{
+				Uri:      turn.URI,
+				Protocol: ToResponseProto(turn.Proto),
+			},
+			User:     turn.Username,
+			Password: turn.Password,
+		}
+		if turnCredentials != nil {
+			turnProto.User = turnCredentials.Username
+			turnProto.Password = turnCredentials.Password
+			if !turnCredentials.ExpiresAt.IsZero() {
+				turnProto.ExpiresAt = timestamppb.New(turnCredentials.ExpiresAt)
+			}
+		}
+		turns = append(turns, turnProto)
+	}
+	return turns
+}
+
+func toPeerConfig(peer *Peer, network *Network) *proto.PeerConfig {
 	return &proto.PeerConfig{
-		Address: peer.IP.String() + "/16", // todo make it explicit
+		Address:        fmt.Sprintf("%s/%d", peer.IP.String(), network.PrefixLen()),
+		StaticEndpoint: peer.StaticEndpoint,
+		RouteMetric:    peer.RouteMetric,
 	}
 }
 
-func toRemotePeerConfig(peers []*Peer) []*proto.RemotePeerConfig {
+func toRemotePeerConfig(peers []*Peer, sharePresence bool, disconnectDelay time.Duration) []*proto.RemotePeerConfig {
 	remotePeers := []*proto.RemotePeerConfig{}
 	for _, rPeer := range peers {
-		remotePeers = append(remotePeers, &proto.RemotePeerConfig{
-			WgPubKey:   rPeer.Key,
-			AllowedIps: []string{fmt.Sprintf(AllowedIPsFormat, rPeer.IP)}, // todo /32
-		})
+		remotePeer := &proto.RemotePeerConfig{
+			WgPubKey:       rPeer.Key,
+			AllowedIps:     []string{fmt.Sprintf(AllowedIPsFormat, rPeer.IP)}, // todo /32
+			RateLimitKbps:  rPeer.RateLimit,
+			Name:           rPeer.Name,
+			WgPort:         int32(rPeer.Meta.WgPort),
+			StaticEndpoint: rPeer.StaticEndpoint,
+			SshPubKey:      rPeer.Meta.SSHPubKey,
+		}
+		if sharePresence {
+			remotePeer.Presence = toPeerPresence(rPeer, disconnectDelay)
+		}
+		remotePeers = append(remotePeers, remotePeer)
 	}
 
 	return remotePeers
 }
 
-func toSyncResponse(config *Config, peer *Peer, peers []*Peer, turnCredentials *TURNCredentials, serial uint64) *proto.SyncResponse {
+// toProtoRoutes converts the routes of a NetworkMap, the peer assigns each prefix to one of its routing peers
+func toProtoRoutes(routes []*Route) []*proto.Route {
+	protoRoutes := make([]*proto.Route, 0, len(routes))
+	for _, route := range routes {
+		protoRoutes = append(protoRoutes, &proto.Route{
+			ID:     route.ID,
+			Prefix: route.Prefix,
+			Peer:   route.Peer,
+			Metric: int64(route.Metric),
+		})
+	}
+	return protoRoutes
+}
+
+func toClientUpdate(config *ClientUpdateConfig) *proto.ClientUpdate {
+	if config == nil {
+		return nil
+	}
+	return &proto.ClientUpdate{
+		MinVersion:         config.MinVersion,
+		RecommendedVersion: config.RecommendedVersion,
+		DownloadUrl:        config.DownloadURL,
+	}
+}
+
+func toSyncResponse(config *Config, peer *Peer, networkMap *NetworkMap, turnCredentials *TURNCredentials) *proto.SyncResponse {
 	wtConfig := toWiretrusteeConfig(config, turnCredentials)
 
-	pConfig := toPeerConfig(peer)
+	network := networkMap.Network
+
+	pConfig := toPeerConfig(peer, network)
+	pConfig.SshConfig = toProtoSSHConfig(networkMap.SSHAllowedPeers)
 
-	remotePeers := toRemotePeerConfig(peers)
+	remotePeers := toRemotePeerConfig(networkMap.Peers, networkMap.PresenceSharing, networkMap.PresenceDisconnectDelay)
 
 	return &proto.SyncResponse{
 		WiretrusteeConfig:  wtConfig,
@@ -397,11 +618,14 @@ func toSyncResponse(config *Config, peer *Peer, peers []*Peer, turnCredentials *
 		RemotePeers:        remotePeers,
 		RemotePeersIsEmpty: len(remotePeers) == 0,
 		NetworkMap: &proto.NetworkMap{
-			Serial:             serial,
+			Serial:             network.CurrentSerial(),
 			PeerConfig:         pConfig,
 			RemotePeers:        remotePeers,
 			RemotePeersIsEmpty: len(remotePeers) == 0,
+			Routes:             toProtoRoutes(networkMap.Routes),
+			DnsConfig:          toProtoDNSConfig(networkMap.DNSSettings),
 		},
+		ClientUpdate: toClientUpdate(config.ClientUpdate),
 	}
 }
 
@@ -410,8 +634,11 @@ func (s *Server) IsHealthy(ctx context.Context, req *proto.Empty) (*proto.Empty,
 	return &proto.Empty{}, nil
 }
 
-// sendInitialSync sends initial proto.SyncResponse to the peer requesting synchronization
-func (s *Server) sendInitialSync(peerKey wgtypes.Key, peer *Peer, srv proto.ManagementService_SyncServer) error {
+// sendInitialSync sends initial proto.SyncResponse to the peer requesting synchronization.
+// The NetworkMap is omitted if the peer resumes a session and has already applied the current serial.
+// The remote peers are sent as a delta if the peer applies deltas and resumes a session at the serial last sent on it
+func (s *Server) sendInitialSync(peerKey wgtypes.Key, peer *Peer, sessionToken string, appliedSerial uint64,
+	remotePeersDelta bool, srv proto.ManagementService_SyncServer) error {
 	networkMap, err := s.accountManager.GetNetworkMap(peer.Key)
 	if err != nil {
 		log.Warnf("error getting a list of peers for a peer %s", peer.Key)
@@ -426,26 +653,51 @@ func (s *Server) sendInitialSync(peerKey wgtypes.Key, peer *Peer, srv proto.Mana
 	} else {
 		turnCredentials = nil
 	}
-	plainResp := toSyncResponse(s.config, peer, networkMap.Peers, turnCredentials, networkMap.Network.CurrentSerial())
+	plainResp := toSyncResponse(s.config, peer, networkMap, turnCredentials)
+	plainResp.Epoch = s.epoch
+	plainResp.SessionToken = sessionToken
+	if appliedSerial != 0 && appliedSerial == networkMap.Network.CurrentSerial() {
+		log.Debugf("peer %s resumed its session at serial %d", peer.Key, appliedSerial)
+		plainResp.PeerConfig = nil
+		plainResp.RemotePeers = nil
+		plainResp.RemotePeersIsEmpty = false
+		plainResp.NetworkMap = nil
+	} else if remotePeersDelta {
+		if s.syncSessions.ResumeNetworkMap(sessionToken, plainResp.NetworkMap, appliedSerial) {
+			log.Debugf("peer %s resumed its session at serial %d, sending the remote peers changed since", peer.Key, appliedSerial)
+		}
+		clearLegacyNetworkMap(plainResp)
+	}
 
 	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, plainResp)
 	if err != nil {
 		return status.Errorf(codes.Internal, "error handling request")
 	}
 
-	err = srv.Send(&proto.EncryptedMessage{
+	err = s.sendWithTimeout(srv, &proto.EncryptedMessage{
 		WgPubKey: s.wgKey.PublicKey().String(),
 		Body:     encryptedResp,
 	})
 
 	if err != nil {
 		log.Errorf("failed sending SyncResponse %v", err)
+		if errors.Is(err, errSyncStreamStalled) {
+			return err
+		}
 		return status.Errorf(codes.Internal, "error handling request")
 	}
 
 	return nil
 }
 
+// clearLegacyNetworkMap removes the peer config and the remote peers the SyncResponse carries outside of the NetworkMap
+// for the older clients. The clients applying the remote peers deltas read them from the NetworkMap
+func clearLegacyNetworkMap(resp *proto.SyncResponse) {
+	resp.PeerConfig = nil
+	resp.RemotePeers = nil
+	resp.RemotePeersIsEmpty = false
+}
+
 // GetDeviceAuthorizationFlow returns a device authorization flow information
 // This is used for initiating an Oauth 2 device authorization grant flow
 // which will be used by our clients to Login
@@ -493,3 +745,250 @@ func (s *Server) GetDeviceAuthorizationFlow(ctx context.Context, req *proto.Encr
 		Body:     encryptedResp,
 	}, nil
 }
+
+// StartDeviceAuth starts a device authorization of the peer which is approved by a user in the dashboard
+func (s *Server) StartDeviceAuth(ctx context.Context, req *proto.EncryptedMessage) (*proto.EncryptedMessage, error) {
+	peerKey, err := wgtypes.ParseKey(req.GetWgPubKey())
+	if err != nil {
+		errMSG := fmt.Sprintf("error while parsing peer's Wireguard public key %s on StartDeviceAuth request.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, &proto.StartDeviceAuthRequest{})
+	if err != nil {
+		errMSG := fmt.Sprintf("error while decrypting peer's message with Wireguard public key %s.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	deviceAuth, err := s.accountManager.StartDeviceAuth(peerKey.String())
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, &proto.StartDeviceAuthResponse{
+		DeviceCode: deviceAuth.DeviceCode,
+		UserCode:   deviceAuth.UserCode,
+		ExpiresIn:  int64(time.Until(deviceAuth.ExpiresAt).Seconds()),
+		Interval:   int64(DeviceAuthPollInterval.Seconds()),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encrypt device authorization")
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     encryptedResp,
+	}, nil
+}
+
+// PollDeviceAuth returns the registration token of the peer once its device authorization has been approved
+func (s *Server) PollDeviceAuth(ctx context.Context, req *proto.EncryptedMessage) (*proto.EncryptedMessage, error) {
+	peerKey, err := wgtypes.ParseKey(req.GetWgPubKey())
+	if err != nil {
+		errMSG := fmt.Sprintf("error while parsing peer's Wireguard public key %s on PollDeviceAuth request.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	pollReq := &proto.PollDeviceAuthRequest{}
+	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, pollReq)
+	if err != nil {
+		errMSG := fmt.Sprintf("error while decrypting peer's message with Wireguard public key %s.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	token, err := s.accountManager.PollDeviceAuth(pollReq.GetDeviceCode(), peerKey.String())
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, &proto.PollDeviceAuthResponse{DeviceAuthToken: token})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encrypt device authorization")
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     encryptedResp,
+	}, nil
+}
+
+// GetTURNCredentials returns new credentials of the TURN servers to a registered peer, e.g. when the refresh
+// pushed over the Sync stream hasn't arrived before its current credentials expire
+func (s *Server) GetTURNCredentials(ctx context.Context, req *proto.EncryptedMessage) (*proto.EncryptedMessage, error) {
+	peerKey, err := wgtypes.ParseKey(req.GetWgPubKey())
+	if err != nil {
+		errMSG := fmt.Sprintf("error while parsing peer's Wireguard public key %s on GetTURNCredentials request.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	_, err = s.accountManager.GetPeer(peerKey.String())
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "provided peer with the key wgPubKey %s is not registered", peerKey.String())
+	}
+
+	err = s.accountManager.CheckPeerLogin(peerKey.String())
+	if err != nil {
+		return nil, err
+	}
+
+	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, &proto.TURNCredentialsRequest{})
+	if err != nil {
+		errMSG := fmt.Sprintf("error while decrypting peer's message with Wireguard public key %s.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	var turnCredentials *TURNCredentials
+	if s.config.TURNConfig.TimeBasedCredentials {
+		creds := s.turnCredentialsManager.GenerateCredentials()
+		turnCredentials = &creds
+	}
+
+	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, &proto.TURNCredentialsResponse{
+		Turns: toTURNsProto(s.config.TURNConfig, turnCredentials),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encrypt TURN credentials")
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     encryptedResp,
+	}, nil
+}
+
+// ReplaceKey replaces the Wireguard key of a registered peer keeping its IP, name and groups. The request is sent with
+// the old key and carries a proof that the peer holds the new private key. The Sync stream of the old key is closed,
+// the peer syncs again with the new key
+func (s *Server) ReplaceKey(ctx context.Context, req *proto.EncryptedMessage) (*proto.EncryptedMessage, error) {
+	peerKey, err := wgtypes.ParseKey(req.GetWgPubKey())
+	if err != nil {
+		errMSG := fmt.Sprintf("error while parsing peer's Wireguard public key %s on ReplaceKey request.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	_, err = s.accountManager.GetPeer(peerKey.String())
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "provided peer with the key wgPubKey %s is not registered", peerKey.String())
+	}
+
+	err = s.accountManager.CheckPeerLogin(peerKey.String())
+	if err != nil {
+		return nil, err
+	}
+
+	replaceReq := &proto.ReplaceKeyRequest{}
+	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, replaceReq)
+	if err != nil {
+		errMSG := fmt.Sprintf("error while decrypting peer's message with Wireguard public key %s.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	newKey, err := wgtypes.ParseKey(replaceReq.GetNewWgPubKey())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid new Wireguard public key %s", replaceReq.GetNewWgPubKey())
+	}
+
+	proof, err := encryption.Decrypt(replaceReq.GetNewKeyProof(), newKey, s.wgKey)
+	if err != nil || string(proof) != peerKey.String() {
+		return nil, status.Errorf(codes.PermissionDenied, "peer %s failed to prove it holds the private key of %s", peerKey.String(), newKey.String())
+	}
+
+	peer, err := s.accountManager.ReplacePeerKey(peerKey.String(), newKey.String())
+	if err != nil {
+		return nil, err
+	}
+	s.turnCredentialsManager.CancelRefresh(peerKey.String())
+	log.Infof("peer %s replaced its key %s with %s", peer.Name, peerKey.String(), newKey.String())
+
+	networkMap, err := s.accountManager.GetNetworkMap(newKey.String())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed getting the network map of peer %s", newKey.String())
+	}
+
+	// the peer has the new key once the request succeeds, it is the one the response is encrypted for
+	encryptedResp, err := encryption.EncryptMessage(newKey, s.wgKey, &proto.ReplaceKeyResponse{
+		PeerConfig: toPeerConfig(peer, networkMap.Network),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encrypt the replaced key response")
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     encryptedResp,
+	}, nil
+}
+
+// SendFeedback records the problems a registered peer has detected in its network map and the outcomes of its connection
+// attempts to the remote peers, so that they are shown to the admin
+func (s *Server) SendFeedback(ctx context.Context, req *proto.EncryptedMessage) (*proto.EncryptedMessage, error) {
+	peerKey, err := wgtypes.ParseKey(req.GetWgPubKey())
+	if err != nil {
+		errMSG := fmt.Sprintf("error while parsing peer's Wireguard public key %s on SendFeedback request.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	_, err = s.accountManager.GetPeer(peerKey.String())
+	if err != nil {
+		return nil, status.Errorf(codes.PermissionDenied, "provided peer with the key wgPubKey %s is not registered", peerKey.String())
+	}
+
+	feedbackReq := &proto.FeedbackRequest{}
+	err = encryption.DecryptMessage(peerKey, s.wgKey, req.Body, feedbackReq)
+	if err != nil {
+		errMSG := fmt.Sprintf("error while decrypting peer's message with Wireguard public key %s.", req.WgPubKey)
+		log.Warn(errMSG)
+		return nil, status.Error(codes.InvalidArgument, errMSG)
+	}
+
+	var conflicts []AllowedIPsConflict
+	for _, conflict := range feedbackReq.GetAllowedIpsConflicts() {
+		conflicts = append(conflicts, AllowedIPsConflict{
+			Prefix:            conflict.GetPrefix(),
+			Peer:              conflict.GetPeer(),
+			ConflictingPrefix: conflict.GetConflictingPrefix(),
+			ConflictingPeer:   conflict.GetConflictingPeer(),
+			Duplicate:         conflict.GetType() == proto.AllowedIPsConflict_DUPLICATE,
+		})
+	}
+	err = s.accountManager.UpdatePeerAllowedIPsConflicts(peerKey.String(), conflicts)
+	if err != nil {
+		return nil, err
+	}
+
+	var outcomes []ConnectionOutcome
+	for _, outcome := range feedbackReq.GetConnectionOutcomes() {
+		failures := make(map[string]int, len(outcome.GetFailures()))
+		for class, count := range outcome.GetFailures() {
+			failures[class] = int(count)
+		}
+		outcomes = append(outcomes, ConnectionOutcome{
+			Peer:      outcome.GetPeer(),
+			Connected: int(outcome.GetConnected()),
+			Failures:  failures,
+		})
+	}
+	err = s.accountManager.RecordConnectionOutcomes(peerKey.String(), outcomes)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedResp, err := encryption.EncryptMessage(peerKey, s.wgKey, &proto.FeedbackResponse{})
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to encrypt the feedback response")
+	}
+
+	return &proto.EncryptedMessage{
+		WgPubKey: s.wgKey.PublicKey().String(),
+		Body:     encryptedResp,
+	}, nil
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/netbirdio/netbird/management/proto"
+)
+
+const (
+	// DefaultHealthCheckTimeout is how long the store health check may take before the service is reported not ready
+	DefaultHealthCheckTimeout = 5 * time.Second
+	// DefaultMaxDispatchBlocked is how long an update may be blocked on a peer channel before the service is reported not alive
+	DefaultMaxDispatchBlocked = 30 * time.Second
+	// grpcHealthCheckInterval is how often the gRPC health service status is updated
+	grpcHealthCheckInterval = 10 * time.Second
+)
+
+// HealthChecker reports the readiness and liveness of the Management service
+type HealthChecker struct {
+	store              Store
+	peersUpdateManager *PeersUpdateManager
+	checkTimeout       time.Duration
+	maxDispatchBlocked time.Duration
+}
+
+// NewHealthChecker creates a HealthChecker of the store and the update manager with the default timeouts
+func NewHealthChecker(store Store, peersUpdateManager *PeersUpdateManager) *HealthChecker {
+	return &HealthChecker{
+		store:              store,
+		peersUpdateManager: peersUpdateManager,
+		checkTimeout:       DefaultHealthCheckTimeout,
+		maxDispatchBlocked: DefaultMaxDispatchBlocked,
+	}
+}
+
+// Ready returns an error if the store isn't loaded or writable.
+// A store check not finishing within the timeout (e.g. a wedged store lock) is reported as an error
+func (h *HealthChecker) Ready() error {
+	result := make(chan error, 1)
+	go func() {
+		result <- h.store.CheckHealth()
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("store isn't ready: %v", err)
+		}
+		return nil
+	case <-time.After(h.checkTimeout):
+		return fmt.Errorf("store health check didn't finish within %s", h.checkTimeout)
+	}
+}
+
+// Alive returns an error if the update manager is stuck sending an update to a peer
+func (h *HealthChecker) Alive() error {
+	if blocked := h.peersUpdateManager.DispatchBlockedFor(); blocked > h.maxDispatchBlocked {
+		return fmt.Errorf("update dispatch has been blocked for %s", blocked.Round(time.Second))
+	}
+	return nil
+}
+
+// WatchGRPCHealth keeps the status of the Management service in the gRPC health service up to date until the context is done.
+// The service is serving when it is both alive and ready
+func (h *HealthChecker) WatchGRPCHealth(ctx context.Context, healthServer *health.Server) {
+	update := func() {
+		status := grpc_health_v1.HealthCheckResponse_SERVING
+		err := h.Alive()
+		if err == nil {
+			err = h.Ready()
+		}
+		if err != nil {
+			log.Warnf("management service isn't healthy: %v", err)
+			status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+		}
+		healthServer.SetServingStatus("", status)
+		healthServer.SetServingStatus(proto.ManagementService_ServiceDesc.ServiceName, status)
+	}
+
+	update()
+	go func() {
+		ticker := time.NewTicker(grpcHealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				update()
+			}
+		}
+	}()
+}
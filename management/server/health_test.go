@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// wedgedStore is a Store which health check blocks until released
+type wedgedStore struct {
+	Store
+	release chan struct{}
+}
+
+func (s *wedgedStore) CheckHealth() error {
+	<-s.release
+	return s.Store.CheckHealth()
+}
+
+func TestHealthChecker_Ready(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	checker := NewHealthChecker(store, NewPeersUpdateManager())
+	assert.NoError(t, checker.Ready())
+
+	wedged := &wedgedStore{Store: store, release: make(chan struct{})}
+	defer close(wedged.release)
+	checker = NewHealthChecker(wedged, NewPeersUpdateManager())
+	checker.checkTimeout = 100 * time.Millisecond
+	assert.Error(t, checker.Ready(), "a store not answering within the timeout shouldn't be ready")
+}
+
+func TestHealthChecker_Alive(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	peersUpdateManager := NewPeersUpdateManager()
+	checker := NewHealthChecker(store, peersUpdateManager)
+	checker.maxDispatchBlocked = 50 * time.Millisecond
+	assert.NoError(t, checker.Alive())
+
+	channel := peersUpdateManager.CreateChannel("peer")
+	defer peersUpdateManager.CloseChannel("peer")
+	for i := 0; i <= cap(channel); i++ {
+		go func() {
+			_ = peersUpdateManager.SendUpdate("peer", &UpdateMessage{})
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Error(t, checker.Alive(), "an update manager blocked on a full channel shouldn't be alive")
+
+	<-channel
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, checker.Alive())
+}
+
+func TestHealthChecker_WatchGRPCHealth(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	healthServer := health.NewServer()
+	NewHealthChecker(store, NewPeersUpdateManager()).WatchGRPCHealth(ctx, healthServer)
+
+	for _, service := range []string{"", "management.ManagementService"} {
+		resp, err := healthServer.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.GetStatus())
+	}
+}
@@ -0,0 +1,46 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	s "github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/http/handler"
+	"github.com/netbirdio/netbird/util"
+)
+
+const (
+	// adminAccountsPath is the path of the endpoint creating and listing the accounts
+	adminAccountsPath = "/admin/accounts"
+	// adminBootstrapPath is the path of the endpoint exporting the bootstrap bundles of the peers
+	adminBootstrapPath = "/admin/bootstrap"
+)
+
+// newAdminHandler returns a handler of the admin endpoints managing the accounts of the Management service and
+// exporting the bootstrap bundles of the peers
+func newAdminHandler(accountManager s.AccountManager, bundler handler.BootstrapBundler) http.Handler {
+	r := mux.NewRouter()
+	accountsHandler := handler.NewAdminAccounts(accountManager)
+	r.HandleFunc(adminAccountsPath, accountsHandler.ListAccountsHandler).Methods("GET")
+	r.HandleFunc(adminAccountsPath, accountsHandler.CreateAccountHandler).Methods("POST")
+	bootstrapHandler := handler.NewAdminBootstrap(bundler)
+	r.HandleFunc(adminBootstrapPath, bootstrapHandler.GetBundleHandler).Methods("GET")
+	return r
+}
+
+// NewAdminServer creates an HTTP server serving the admin endpoints on a dedicated address. The endpoints aren't
+// authenticated, so the address has to be a loopback one reachable by the operator of the Management service only
+func NewAdminServer(address string, accountManager s.AccountManager, server *s.Server) (*http.Server, error) {
+	if err := util.ValidateLoopbackAddress(address); err != nil {
+		return nil, fmt.Errorf("refusing to serve the admin endpoints: %w", err)
+	}
+	return &http.Server{
+		Addr:         address,
+		Handler:      newAdminHandler(accountManager, server),
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+	}, nil
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	s "github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/util"
+)
+
+// debugPeersPath is the path of the endpoint returning the connected peers and the stream counts
+const debugPeersPath = "/debug/peers"
+
+// debugVarsPath is the path of the endpoint returning the metrics of the server, e.g. the reported outcomes of the
+// connection attempts between the peers
+const debugVarsPath = "/debug/vars"
+
+// newDebugHandler returns a handler of the debug endpoints reporting the state of the gRPC server
+func newDebugHandler(server *s.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPeersPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(server.DebugInfo()); err != nil {
+			log.Errorf("failed encoding debug response: %v", err)
+		}
+	})
+	mux.Handle(debugVarsPath, expvar.Handler())
+	return mux
+}
+
+// NewDebugServer creates an HTTP server serving the debug endpoints on a dedicated address,
+// the address has to be a loopback one so the endpoints aren't exposed publicly
+func NewDebugServer(address string, server *s.Server) (*http.Server, error) {
+	if err := util.ValidateLoopbackAddress(address); err != nil {
+		return nil, fmt.Errorf("refusing to serve the debug endpoints: %w", err)
+	}
+	return &http.Server{
+		Addr:         address,
+		Handler:      newDebugHandler(server),
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+	}, nil
+}
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/netbirdio/netbird/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountSettingsResponse is a response with the settings of the account sent to the client
+type AccountSettingsResponse struct {
+	// LoginExpiration is the period after which the peers registered by users have to log in again, 0 means never
+	LoginExpiration util.Duration
+	// PresenceSharing indicates that the peers receive the presence of the peers they can reach
+	PresenceSharing bool
+	// PostureChecks are the device posture requirements the peers have to meet to be reachable by the other peers
+	PostureChecks PostureChecks
+	// DNSSettings are the name resolution settings pushed to the peers
+	DNSSettings DNSSettings
+}
+
+// PostureChecks are the device posture requirements of the peers of the account
+type PostureChecks struct {
+	// MinOSVersions is the minimal OS version per OS (e.g. linux, darwin, windows)
+	MinOSVersions map[string]string
+	// DiskEncryption requires an encrypted system disk
+	DiskEncryption bool
+	// Firewall requires an enabled host firewall
+	Firewall bool
+}
+
+// DNSSettings are the name resolution settings of the peers of the account
+type DNSSettings struct {
+	// Zone the names of the peers are resolved in, empty keeps the zone configured on the peers
+	Zone string
+	// CustomRecords are resolved in the zone in addition to the names of the peers
+	CustomRecords []DNSRecord
+	// NameServerGroups resolve the names of their match domains outside the zone
+	NameServerGroups []NameServerGroup
+}
+
+// DNSRecord is a custom record of a name of the zone
+type DNSRecord struct {
+	// Name relative to the zone
+	Name string
+	// Type is A or AAAA
+	Type string
+	// Value is the IP address of the record
+	Value string
+}
+
+// NameServerGroup is a group of upstream nameservers resolving the names of the match domains
+type NameServerGroup struct {
+	// NameServers are the addresses of the nameservers, IP or IP:port
+	NameServers []string
+	// Domains are the match domains resolved by the nameservers along with their subdomains
+	Domains []string
+}
+
+// AccountSettingsRequest to update the settings of the account
+type AccountSettingsRequest struct {
+	// LoginExpiration is an optional period after which the peers registered by users have to log in again, 0 disables it
+	LoginExpiration *util.Duration
+	// PresenceSharing optionally enables or disables sharing the presence of the peers with the peers that can reach them
+	PresenceSharing *bool
+	// PostureChecks optionally replaces the device posture requirements of the peers
+	PostureChecks *PostureChecks
+	// DNSSettings optionally replaces the name resolution settings of the peers, empty settings remove them
+	DNSSettings *DNSSettings
+}
+
+// Accounts is a handler that returns and updates the settings of the account
+type Accounts struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewAccounts(accountManager server.AccountManager, authAudience string) *Accounts {
+	return &Accounts{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// GetSettingsHandler returns the settings of the account
+func (h *Accounts) GetSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, toAccountSettingsResponse(account))
+}
+
+// UpdateSettingsHandler changes the settings of the account
+func (h *Accounts) UpdateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	var req AccountSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if req.LoginExpiration != nil {
+		updated, err := h.accountManager.UpdateAccountLoginExpiration(account.Id, req.LoginExpiration.Duration, jwtClaims.UserId)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			default:
+				log.Errorf("failed updating login expiration of account %s %v", account.Id, err)
+				http.Redirect(w, r, "/", http.StatusInternalServerError)
+			}
+			return
+		}
+		account = updated
+	}
+
+	if req.PresenceSharing != nil {
+		updated, err := h.accountManager.UpdateAccountPresenceSharing(account.Id, *req.PresenceSharing, jwtClaims.UserId)
+		if err != nil {
+			log.Errorf("failed updating presence sharing of account %s %v", account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+		account = updated
+	}
+
+	if req.PostureChecks != nil {
+		updated, err := h.accountManager.UpdateAccountPostureChecks(account.Id, &server.PostureChecks{
+			MinOSVersions:  req.PostureChecks.MinOSVersions,
+			DiskEncryption: req.PostureChecks.DiskEncryption,
+			Firewall:       req.PostureChecks.Firewall,
+		}, jwtClaims.UserId)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			default:
+				log.Errorf("failed updating posture checks of account %s %v", account.Id, err)
+				http.Redirect(w, r, "/", http.StatusInternalServerError)
+			}
+			return
+		}
+		account = updated
+	}
+
+	if req.DNSSettings != nil {
+		settings := &server.DNSSettings{Zone: req.DNSSettings.Zone}
+		for _, record := range req.DNSSettings.CustomRecords {
+			settings.CustomRecords = append(settings.CustomRecords, server.DNSRecord{
+				Name:  record.Name,
+				Type:  record.Type,
+				Value: record.Value,
+			})
+		}
+		for _, group := range req.DNSSettings.NameServerGroups {
+			settings.NameServerGroups = append(settings.NameServerGroups, server.NameServerGroup{
+				NameServers: group.NameServers,
+				Domains:     group.Domains,
+			})
+		}
+		updated, err := h.accountManager.UpdateAccountDNSSettings(account.Id, settings, jwtClaims.UserId)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			default:
+				log.Errorf("failed updating DNS settings of account %s %v", account.Id, err)
+				http.Redirect(w, r, "/", http.StatusInternalServerError)
+			}
+			return
+		}
+		account = updated
+	}
+
+	writeJSONObject(w, toAccountSettingsResponse(account))
+}
+
+// ExportHandler returns a document with the peers and the setup keys of the account
+func (h *Accounts) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getPathAccount(w, r)
+	if err != nil {
+		return
+	}
+
+	export, err := h.accountManager.ExportAccount(account.Id)
+	if err != nil {
+		log.Errorf("failed exporting account %s %v", account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, export)
+}
+
+// ImportHandler adds (or updates) the peers and the setup keys of an exported document to the account.
+// With the dryRun=true query parameter it only reports what would change
+func (h *Accounts) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getPathAccount(w, r)
+	if err != nil {
+		return
+	}
+
+	var export server.AccountExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	result, err := h.accountManager.ImportAccount(account.Id, &export, dryRun, jwtClaims.UserId)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		case codes.AlreadyExists:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+		case codes.ResourceExhausted:
+			http.Error(w, status.Convert(err).Message(), http.StatusForbidden)
+		default:
+			log.Errorf("failed importing account %s %v", account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, result)
+}
+
+// getPathAccount returns the account of the user if it is the account of the request path, otherwise writes an error response
+func (h *Accounts) getPathAccount(w http.ResponseWriter, r *http.Request) (*server.Account, error) {
+	account, err := h.getAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return nil, err
+	}
+
+	accountID := mux.Vars(r)["id"]
+	if accountID != account.Id {
+		http.Error(w, fmt.Sprintf("account %s not found", accountID), http.StatusNotFound)
+		return nil, fmt.Errorf("account %s doesn't match the account %s of the user", accountID, account.Id)
+	}
+
+	return account, nil
+}
+
+func (h *Accounts) getAccount(r *http.Request) (*server.Account, error) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+
+	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err)
+	}
+
+	return account, nil
+}
+
+func toAccountSettingsResponse(account *server.Account) *AccountSettingsResponse {
+	response := &AccountSettingsResponse{
+		LoginExpiration: util.Duration{Duration: account.LoginExpiration},
+		PresenceSharing: account.PresenceSharing,
+	}
+	if account.PostureChecks != nil {
+		response.PostureChecks = PostureChecks{
+			MinOSVersions:  account.PostureChecks.MinOSVersions,
+			DiskEncryption: account.PostureChecks.DiskEncryption,
+			Firewall:       account.PostureChecks.Firewall,
+		}
+	}
+	if account.DNSSettings != nil {
+		response.DNSSettings.Zone = account.DNSSettings.Zone
+		for _, record := range account.DNSSettings.CustomRecords {
+			response.DNSSettings.CustomRecords = append(response.DNSSettings.CustomRecords, DNSRecord{
+				Name:  record.Name,
+				Type:  record.Type,
+				Value: record.Value,
+			})
+		}
+		for _, group := range account.DNSSettings.NameServerGroups {
+			response.DNSSettings.NameServerGroups = append(response.DNSSettings.NameServerGroups, NameServerGroup{
+				NameServers: group.NameServers,
+				Domains:     group.Domains,
+			})
+		}
+	}
+	return response
+}
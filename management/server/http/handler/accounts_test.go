@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+func initAccountsTestData(account *server.Account) *Accounts {
+	return &Accounts{
+		accountManager: &mock_server.MockAccountManager{
+			UpdateAccountLoginExpirationFunc: func(accountId string, expiration time.Duration, userID string) (*server.Account, error) {
+				if expiration < 0 {
+					return nil, status.Errorf(codes.InvalidArgument, "login expiration can't be negative")
+				}
+				account.LoginExpiration = expiration
+				return account, nil
+			},
+			UpdateAccountPresenceSharingFunc: func(accountId string, enabled bool, userID string) (*server.Account, error) {
+				account.PresenceSharing = enabled
+				return account, nil
+			},
+			UpdateAccountPostureChecksFunc: func(accountId string, checks *server.PostureChecks, userID string) (*server.Account, error) {
+				account.PostureChecks = checks
+				return account, nil
+			},
+			UpdateAccountDNSSettingsFunc: func(accountId string, settings *server.DNSSettings, userID string) (*server.Account, error) {
+				for _, record := range settings.CustomRecords {
+					if record.Type != server.DNSRecordTypeA && record.Type != server.DNSRecordTypeAAAA {
+						return nil, status.Errorf(codes.InvalidArgument, "unsupported type %q of DNS record %s", record.Type, record.Name)
+					}
+				}
+				if settings.IsEmpty() {
+					settings = nil
+				}
+				account.DNSSettings = settings
+				return account, nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return account, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestAccountSettingsHandlers(t *testing.T) {
+	tt := []struct {
+		name                    string
+		requestType             string
+		requestBody             io.Reader
+		expectedStatus          int
+		expectedLoginExpiration time.Duration
+		expectedPresenceSharing bool
+		expectedDiskEncryption  bool
+		expectedDNSSettings     DNSSettings
+	}{
+		{
+			name:                    "Get Settings",
+			requestType:             http.MethodGet,
+			expectedStatus:          http.StatusOK,
+			expectedLoginExpiration: 0,
+		},
+		{
+			name:                    "Update Login Expiration",
+			requestType:             http.MethodPut,
+			requestBody:             bytes.NewBufferString(`{"LoginExpiration": "168h"}`),
+			expectedStatus:          http.StatusOK,
+			expectedLoginExpiration: 168 * time.Hour,
+		},
+		{
+			name:                    "Update Without Login Expiration",
+			requestType:             http.MethodPut,
+			requestBody:             bytes.NewBufferString(`{}`),
+			expectedStatus:          http.StatusOK,
+			expectedLoginExpiration: 0,
+		},
+		{
+			name:                    "Enable Presence Sharing",
+			requestType:             http.MethodPut,
+			requestBody:             bytes.NewBufferString(`{"PresenceSharing": true}`),
+			expectedStatus:          http.StatusOK,
+			expectedPresenceSharing: true,
+		},
+		{
+			name:                   "Update Posture Checks",
+			requestType:            http.MethodPut,
+			requestBody:            bytes.NewBufferString(`{"PostureChecks": {"DiskEncryption": true}}`),
+			expectedStatus:         http.StatusOK,
+			expectedDiskEncryption: true,
+		},
+		{
+			name:        "Update DNS Settings",
+			requestType: http.MethodPut,
+			requestBody: bytes.NewBufferString(
+				`{"DNSSettings": {"Zone": "netbird.cloud", "CustomRecords": [{"Name": "db", "Type": "A", "Value": "10.0.0.5"}]}}`),
+			expectedStatus: http.StatusOK,
+			expectedDNSSettings: DNSSettings{
+				Zone:          "netbird.cloud",
+				CustomRecords: []DNSRecord{{Name: "db", Type: "A", Value: "10.0.0.5"}},
+			},
+		},
+		{
+			name:        "Update DNS Nameserver Groups",
+			requestType: http.MethodPut,
+			requestBody: bytes.NewBufferString(
+				`{"DNSSettings": {"NameServerGroups": [{"NameServers": ["10.0.0.53"], "Domains": ["corp.example.com"]}]}}`),
+			expectedStatus: http.StatusOK,
+			expectedDNSSettings: DNSSettings{
+				NameServerGroups: []NameServerGroup{{NameServers: []string{"10.0.0.53"}, Domains: []string{"corp.example.com"}}},
+			},
+		},
+		{
+			name:           "Remove DNS Settings",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"DNSSettings": {}}`),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Update Invalid DNS Record",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"DNSSettings": {"CustomRecords": [{"Name": "db", "Type": "MX", "Value": "mail"}]}}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Update Negative Login Expiration",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"LoginExpiration": "-1h"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h := initAccountsTestData(&server.Account{Id: "test_id", Domain: "hotmail.com"})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.requestType, "/api/accounts/settings", tc.requestBody)
+
+			if tc.requestType == http.MethodGet {
+				h.GetSettingsHandler(recorder, req)
+			} else {
+				h.UpdateSettingsHandler(recorder, req)
+			}
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, content: %s",
+					status, tc.expectedStatus, recorder.Body.String())
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			got := &AccountSettingsResponse{}
+			if err := json.NewDecoder(res.Body).Decode(got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.LoginExpiration.Duration, tc.expectedLoginExpiration)
+			assert.Equal(t, got.PresenceSharing, tc.expectedPresenceSharing)
+			assert.Equal(t, got.PostureChecks.DiskEncryption, tc.expectedDiskEncryption)
+			assert.Equal(t, got.DNSSettings, tc.expectedDNSSettings)
+		})
+	}
+}
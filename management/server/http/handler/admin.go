@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "google.golang.org/protobuf/proto"
+)
+
+// adminInitiatorID is the initiator of the audit events of the changes made through the admin API
+const adminInitiatorID = "admin-api"
+
+// AdminAccountRequest is a request of the operator of the Management service creating an account
+type AdminAccountRequest struct {
+	// ID of the account, generated if empty
+	ID string
+	// Network is the CIDR (e.g. 100.70.0.0/16) the peers of the account get their IPs from,
+	// a random /16 of 100.64.0.0/10 if empty
+	Network string
+	// SetupKeys are created with the account instead of the default reusable and one-off keys
+	SetupKeys []SetupKeyRequest
+}
+
+// AdminAccountResponse is an account of the Management service sent to the operator
+type AdminAccountResponse struct {
+	ID        string
+	Network   string
+	CreatedBy string
+	Domain    string
+	// Peers is the number of the peers registered in the account
+	Peers     int
+	SetupKeys []*SetupKeyResponse
+}
+
+// AdminAccounts is a handler that creates and lists the accounts of the Management service.
+// It isn't authenticated, so it is served on the loopback admin address only
+type AdminAccounts struct {
+	accountManager server.AccountManager
+}
+
+func NewAdminAccounts(accountManager server.AccountManager) *AdminAccounts {
+	return &AdminAccounts{accountManager: accountManager}
+}
+
+// ListAccountsHandler returns all the accounts sorted by ID
+func (h *AdminAccounts) ListAccountsHandler(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.accountManager.ListAccounts()
+	if err != nil {
+		log.Errorf("failed listing accounts %v", err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]*AdminAccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		response = append(response, toAdminAccountResponse(account))
+	}
+
+	writeJSONObject(w, response)
+}
+
+// CreateAccountHandler creates an account with its own network and setup keys
+func (h *AdminAccounts) CreateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req AdminAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var network *net.IPNet
+	if req.Network != "" {
+		_, ipNet, err := net.ParseCIDR(req.Network)
+		if err != nil {
+			http.Error(w, "invalid network "+req.Network, http.StatusBadRequest)
+			return
+		}
+		network = ipNet
+	}
+
+	var setupKeys []*server.SetupKey
+	for _, key := range req.SetupKeys {
+		validFor := server.DefaultSetupKeyDuration
+		if key.ExpiresIn != nil {
+			validFor = key.ExpiresIn.Duration
+		}
+		setupKeys = append(setupKeys, server.GenerateSetupKey(key.Name, key.Type, validFor, key.UsageLimit))
+	}
+
+	account, err := h.accountManager.CreateAccount(req.ID, network, setupKeys, adminInitiatorID)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		case codes.AlreadyExists:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+		default:
+			log.Errorf("failed creating account %s %v", req.ID, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, toAdminAccountResponse(account))
+}
+
+func toAdminAccountResponse(account *server.Account) *AdminAccountResponse {
+	response := &AdminAccountResponse{
+		ID:        account.Id,
+		CreatedBy: account.CreatedBy,
+		Domain:    account.Domain,
+		Peers:     len(account.Peers),
+		SetupKeys: []*SetupKeyResponse{},
+	}
+	if account.Network != nil {
+		response.Network = account.Network.Net.String()
+	}
+	for _, key := range account.SetupKeys {
+		response.SetupKeys = append(response.SetupKeys, toResponseBody(key))
+	}
+	sort.Slice(response.SetupKeys, func(i, j int) bool {
+		if response.SetupKeys[i].Name != response.SetupKeys[j].Name {
+			return response.SetupKeys[i].Name < response.SetupKeys[j].Name
+		}
+		return response.SetupKeys[i].Id < response.SetupKeys[j].Id
+	})
+	return response
+}
+
+// BootstrapBundler seals the bootstrap bundles of the peers with the key of the Management service
+type BootstrapBundler interface {
+	BootstrapBundle(peerKey string) (*proto.EncryptedMessage, error)
+}
+
+// AdminBootstrap is a handler exporting the bootstrap bundles the peers load to come up before they can reach
+// the Management service. It isn't authenticated, so it is served on the loopback admin address only
+type AdminBootstrap struct {
+	bundler BootstrapBundler
+}
+
+func NewAdminBootstrap(bundler BootstrapBundler) *AdminBootstrap {
+	return &AdminBootstrap{bundler: bundler}
+}
+
+// GetBundleHandler returns the bootstrap bundle of the peer with the Wireguard public key in the peer query parameter,
+// the binary protobuf EncryptedMessage the client reads from its BootstrapFile
+func (h *AdminBootstrap) GetBundleHandler(w http.ResponseWriter, r *http.Request) {
+	peerKey := r.URL.Query().Get("peer")
+	if peerKey == "" {
+		http.Error(w, "peer query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	bundle, err := h.bundler.BootstrapBundle(peerKey)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		case codes.NotFound:
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+		default:
+			log.Errorf("failed exporting the bootstrap bundle of peer %s: %v", peerKey, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, err := pb.Marshal(bundle)
+	if err != nil {
+		log.Errorf("failed marshalling the bootstrap bundle of peer %s: %v", peerKey, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if _, err := w.Write(body); err != nil {
+		log.Errorf("failed sending the bootstrap bundle of peer %s: %v", peerKey, err)
+	}
+}
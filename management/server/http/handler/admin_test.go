@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "google.golang.org/protobuf/proto"
+
+	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+func initAdminAccountsTestData(accounts map[string]*server.Account) *AdminAccounts {
+	return &AdminAccounts{
+		accountManager: &mock_server.MockAccountManager{
+			ListAccountsFunc: func() ([]*server.Account, error) {
+				var list []*server.Account
+				for _, id := range []string{"account_a", "account_b"} {
+					if account, ok := accounts[id]; ok {
+						list = append(list, account)
+					}
+				}
+				return list, nil
+			},
+			CreateAccountFunc: func(accountId string, network *net.IPNet, setupKeys []*server.SetupKey, userID string) (*server.Account, error) {
+				if _, ok := accounts[accountId]; ok {
+					return nil, status.Errorf(codes.AlreadyExists, "account %s already exists", accountId)
+				}
+				if network != nil {
+					if ones, _ := network.Mask.Size(); ones > server.MaxNetworkPrefixLen {
+						return nil, status.Errorf(codes.InvalidArgument, "invalid network")
+					}
+				}
+				account := &server.Account{
+					Id:        accountId,
+					CreatedBy: userID,
+					Network:   &server.Network{Net: *network},
+					Peers:     map[string]*server.Peer{},
+					SetupKeys: map[string]*server.SetupKey{},
+				}
+				for _, key := range setupKeys {
+					account.SetupKeys[key.Key] = key
+				}
+				return account, nil
+			},
+		},
+	}
+}
+
+func TestAdminAccounts(t *testing.T) {
+	_, network, _ := net.ParseCIDR("100.70.0.0/16")
+	existing := &server.Account{
+		Id:        "account_a",
+		Network:   &server.Network{Net: *network},
+		Peers:     map[string]*server.Peer{"peer": {Key: "peer"}},
+		SetupKeys: map[string]*server.SetupKey{},
+	}
+	accounts := map[string]*server.Account{existing.Id: existing}
+
+	tt := []struct {
+		name           string
+		requestType    string
+		requestBody    io.Reader
+		expectedStatus int
+		expected       []*AdminAccountResponse
+	}{
+		{
+			name:           "ListAccounts",
+			requestType:    http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expected: []*AdminAccountResponse{
+				{ID: "account_a", Network: "100.70.0.0/16", Peers: 1, SetupKeys: []*SetupKeyResponse{}},
+			},
+		},
+		{
+			name:        "CreateAccount",
+			requestType: http.MethodPost,
+			requestBody: bytes.NewBufferString(
+				`{"ID":"account_b","Network":"100.71.0.0/16","SetupKeys":[{"Name":"servers","Type":"reusable","UsageLimit":5}]}`),
+			expectedStatus: http.StatusOK,
+			expected: []*AdminAccountResponse{
+				{ID: "account_b", Network: "100.71.0.0/16", CreatedBy: adminInitiatorID, SetupKeys: []*SetupKeyResponse{
+					{Name: "servers", Type: server.SetupKeyReusable, Valid: true, State: "valid", UsageLimit: 5},
+				}},
+			},
+		},
+		{
+			name:           "CreateExistingAccount",
+			requestType:    http.MethodPost,
+			requestBody:    bytes.NewBufferString(`{"ID":"account_a","Network":"100.72.0.0/16"}`),
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "CreateAccountInvalidCIDR",
+			requestType:    http.MethodPost,
+			requestBody:    bytes.NewBufferString(`{"ID":"account_c","Network":"100.72.0.0"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "CreateAccountTooSmallNetwork",
+			requestType:    http.MethodPost,
+			requestBody:    bytes.NewBufferString(`{"ID":"account_c","Network":"100.72.0.0/31"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	h := initAdminAccountsTestData(accounts)
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.requestType, "/admin/accounts", tc.requestBody)
+			recorder := httptest.NewRecorder()
+			if tc.requestType == http.MethodGet {
+				h.ListAccountsHandler(recorder, req)
+			} else {
+				h.CreateAccountHandler(recorder, req)
+			}
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			assert.Equal(t, res.StatusCode, tc.expectedStatus)
+			if tc.expected == nil {
+				return
+			}
+
+			content, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []*AdminAccountResponse
+			if tc.requestType == http.MethodGet {
+				err = json.Unmarshal(content, &got)
+			} else {
+				account := &AdminAccountResponse{}
+				err = json.Unmarshal(content, account)
+				got = []*AdminAccountResponse{account}
+			}
+			if err != nil {
+				t.Fatalf("failed parsing response %s: %v", content, err)
+			}
+
+			// the generated parts of the setup keys aren't compared
+			for _, account := range got {
+				for _, key := range account.SetupKeys {
+					if key.Key == "" || key.Expires.Before(time.Now()) {
+						t.Errorf("expecting setup key %s to be generated and valid", key.Name)
+					}
+					key.Id, key.Key, key.Expires = "", "", time.Time{}
+				}
+			}
+			assert.Equal(t, got, tc.expected)
+		})
+	}
+}
+
+// bootstrapBundlerFunc is a BootstrapBundler calling the function
+type bootstrapBundlerFunc func(peerKey string) (*proto.EncryptedMessage, error)
+
+func (f bootstrapBundlerFunc) BootstrapBundle(peerKey string) (*proto.EncryptedMessage, error) {
+	return f(peerKey)
+}
+
+func TestAdminBootstrap(t *testing.T) {
+	bundle := &proto.EncryptedMessage{WgPubKey: "server", Body: []byte("sealed")}
+	h := NewAdminBootstrap(bootstrapBundlerFunc(func(peerKey string) (*proto.EncryptedMessage, error) {
+		switch peerKey {
+		case "peer":
+			return bundle, nil
+		case "invalid":
+			return nil, status.Errorf(codes.InvalidArgument, "invalid peer key")
+		default:
+			return nil, status.Errorf(codes.NotFound, "peer not found")
+		}
+	}))
+
+	tt := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{name: "Bundle", query: "?peer=peer", expectedStatus: http.StatusOK},
+		{name: "MissingPeer", query: "", expectedStatus: http.StatusBadRequest},
+		{name: "InvalidPeerKey", query: "?peer=invalid", expectedStatus: http.StatusBadRequest},
+		{name: "UnknownPeer", query: "?peer=unknown", expectedStatus: http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/bootstrap"+tc.query, nil)
+			recorder := httptest.NewRecorder()
+			h.GetBundleHandler(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+			assert.Equal(t, res.StatusCode, tc.expectedStatus)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			content, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := &proto.EncryptedMessage{}
+			if err := pb.Unmarshal(content, got); err != nil {
+				t.Fatalf("failed parsing bundle: %v", err)
+			}
+			assert.Equal(t, got.GetWgPubKey(), bundle.GetWgPubKey())
+			assert.Equal(t, got.GetBody(), bundle.GetBody())
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+)
+
+// AuditEventResponse is a response sent to the client
+type AuditEventResponse struct {
+	ID          uint64
+	Timestamp   time.Time
+	Activity    string
+	InitiatorID string
+	TargetID    string
+	Meta        map[string]string
+	Before      json.RawMessage `json:",omitempty"`
+	After       json.RawMessage `json:",omitempty"`
+}
+
+// Audit is a handler that returns the audit log of the account
+type Audit struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewAudit(accountManager server.AccountManager, authAudience string) *Audit {
+	return &Audit{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// GetAuditEventsHandler lists the audit events of the account sorted by ID, available to admins only.
+// The events can be filtered by the actor (the ID of the initiating user or setup key) and the activity
+// (e.g. peer.user.delete) query parameters and paginated with the page and page_size ones
+func (h *Audit) GetAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+
+	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		log.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	isAdmin, err := h.accountManager.IsUserAdmin(jwtClaims)
+	if err != nil {
+		log.Errorf("failed checking admin role of a user %s: %v", jwtClaims.UserId, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+	if !isAdmin {
+		http.Error(w, "user is not admin", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	page, err := parsePageParam(query.Get("page"), 1)
+	if err != nil {
+		http.Error(w, "invalid page parameter", http.StatusBadRequest)
+		return
+	}
+	pageSize, err := parsePageParam(query.Get("page_size"), 0)
+	if err != nil {
+		http.Error(w, "invalid page_size parameter", http.StatusBadRequest)
+		return
+	}
+
+	filter := server.AuditFilter{
+		InitiatorID: query.Get("actor"),
+		Offset:      (page - 1) * pageSize,
+		Limit:       pageSize,
+	}
+	if code := query.Get("activity"); code != "" {
+		activityID, ok := activity.ParseActivity(code)
+		if !ok {
+			http.Error(w, "invalid activity parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Activity = &activityID
+	}
+
+	events, total, err := h.accountManager.GetAuditEvents(account.Id, filter)
+	if err != nil {
+		log.Errorf("failed getting audit events of account %s %v", account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	respBody := []*AuditEventResponse{}
+	for _, event := range events {
+		respBody = append(respBody, toAuditEventResponse(event))
+	}
+	writeJSONObject(w, respBody)
+}
+
+func toAuditEventResponse(event *activity.Event) *AuditEventResponse {
+	return &AuditEventResponse{
+		ID:          event.ID,
+		Timestamp:   event.Timestamp,
+		Activity:    event.Activity.String(),
+		InitiatorID: event.InitiatorID,
+		TargetID:    event.TargetID,
+		Meta:        event.Meta,
+		Before:      event.Before,
+		After:       event.After,
+	}
+}
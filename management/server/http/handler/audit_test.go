@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+func initAuditTestData(isAdmin bool, events ...*activity.Event) *Audit {
+	return &Audit{
+		accountManager: &mock_server.MockAccountManager{
+			GetAuditEventsFunc: func(accountID string, filter server.AuditFilter) ([]*activity.Event, int, error) {
+				var selected []*activity.Event
+				for _, event := range events {
+					if event.AccountID != accountID {
+						continue
+					}
+					if filter.InitiatorID != "" && event.InitiatorID != filter.InitiatorID {
+						continue
+					}
+					if filter.Activity != nil && event.Activity != *filter.Activity {
+						continue
+					}
+					selected = append(selected, event)
+				}
+				page := selected
+				if filter.Offset >= len(page) {
+					page = nil
+				} else {
+					page = page[filter.Offset:]
+				}
+				if filter.Limit > 0 && filter.Limit < len(page) {
+					page = page[:filter.Limit]
+				}
+				return page, len(selected), nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:     claims.AccountId,
+					Domain: "hotmail.com",
+				}, nil
+			},
+			IsUserAdminFunc: func(claims jwtclaims.AuthorizationClaims) (bool, error) {
+				return isAdmin, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestGetAuditEvents(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := initAuditTestData(true,
+		&activity.Event{ID: 1, Timestamp: now, Activity: activity.SetupKeyCreated, AccountID: "test_id", InitiatorID: "test_user"},
+		&activity.Event{ID: 2, Timestamp: now, Activity: activity.PeerAddedWithSetupKey, AccountID: "test_id", InitiatorID: "key"},
+		&activity.Event{ID: 3, Timestamp: now, Activity: activity.GroupUpdated, AccountID: "test_id", InitiatorID: "test_user",
+			TargetID: "group", Before: json.RawMessage(`{"Name":"dev"}`), After: json.RawMessage(`{"Name":"prod"}`)},
+		&activity.Event{ID: 4, Timestamp: now, Activity: activity.GroupCreated, AccountID: "other_id", InitiatorID: "test_user"},
+	)
+
+	tt := []struct {
+		name           string
+		requestPath    string
+		expectedStatus int
+		expectedIDs    []uint64
+		expectedTotal  string
+	}{
+		{
+			name:           "All Events",
+			requestPath:    "/api/audit",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{1, 2, 3},
+			expectedTotal:  "3",
+		},
+		{
+			name:           "Events Of Actor",
+			requestPath:    "/api/audit?actor=test_user",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{1, 3},
+			expectedTotal:  "2",
+		},
+		{
+			name:           "Events Of Activity",
+			requestPath:    "/api/audit?activity=group.update",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{3},
+			expectedTotal:  "1",
+		},
+		{
+			name:           "Second Page",
+			requestPath:    "/api/audit?page=2&page_size=2",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{3},
+			expectedTotal:  "3",
+		},
+		{
+			name:           "Unknown Activity",
+			requestPath:    "/api/audit?activity=peer.teleport",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Page Size",
+			requestPath:    "/api/audit?page_size=0",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+
+			h.GetAuditEventsHandler(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, res.Header.Get("X-Total-Count"), tc.expectedTotal)
+
+			respBody := []*AuditEventResponse{}
+			err := json.NewDecoder(res.Body).Decode(&respBody)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			gotIDs := []uint64{}
+			for _, event := range respBody {
+				gotIDs = append(gotIDs, event.ID)
+				if event.ID == 3 {
+					assert.Equal(t, event.Activity, "group.update")
+					assert.Equal(t, string(event.Before), `{"Name":"dev"}`)
+					assert.Equal(t, string(event.After), `{"Name":"prod"}`)
+				}
+			}
+			assert.Equal(t, gotIDs, tc.expectedIDs)
+		})
+	}
+}
+
+func TestGetAuditEventsForbidden(t *testing.T) {
+	h := initAuditTestData(false)
+
+	recorder := httptest.NewRecorder()
+	h.GetAuditEventsHandler(recorder, httptest.NewRequest(http.MethodGet, "/api/audit", nil))
+
+	if recorder.Code != http.StatusForbidden {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DeviceAuthRequest to approve a device authorization of a peer
+type DeviceAuthRequest struct {
+	// UserCode is the code the peer shows to the user
+	UserCode string
+}
+
+// DeviceAuth is a handler that approves the device authorizations of the peers joining the account of the user
+type DeviceAuth struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewDeviceAuth(accountManager server.AccountManager, authAudience string) *DeviceAuth {
+	return &DeviceAuth{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// ApproveHandler approves the device authorization with the user code, the peer is registered by the user
+func (h *DeviceAuth) ApproveHandler(w http.ResponseWriter, r *http.Request) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	_, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		log.Error(fmt.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err))
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	var req DeviceAuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.UserCode == "" {
+		http.Error(w, "user code shouldn't be empty", http.StatusBadRequest)
+		return
+	}
+
+	err = h.accountManager.ApproveDeviceAuth(req.UserCode, jwtClaims.UserId)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			http.Error(w, status.Convert(err).Message(), http.StatusNotFound)
+		case codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+		default:
+			log.Errorf("failed approving device authorization by user %s: %v", jwtClaims.UserId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, struct{}{})
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func initDeviceAuthTestData(approved map[string]string) *DeviceAuth {
+	return &DeviceAuth{
+		accountManager: &mock_server.MockAccountManager{
+			ApproveDeviceAuthFunc: func(userCode string, userID string) error {
+				if userCode == "BCDF-GHJK" {
+					return status.Errorf(codes.NotFound, "device authorization with user code %s not found or expired", userCode)
+				}
+				if _, ok := approved[userCode]; ok {
+					return status.Errorf(codes.FailedPrecondition, "device authorization with user code %s has been already approved", userCode)
+				}
+				approved[userCode] = userID
+				return nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:     claims.AccountId,
+					Domain: "hotmail.com",
+				}, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestApproveDeviceAuth(t *testing.T) {
+	approved := map[string]string{"LMNP-QRST": "other_user"}
+	h := initDeviceAuthTestData(approved)
+
+	tt := []struct {
+		name           string
+		requestBody    string
+		expectedStatus int
+	}{
+		{
+			name:           "Approve",
+			requestBody:    `{"UserCode":"VWXZ-BCDF"}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Unknown User Code",
+			requestBody:    `{"UserCode":"BCDF-GHJK"}`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Already Approved",
+			requestBody:    `{"UserCode":"LMNP-QRST"}`,
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Empty User Code",
+			requestBody:    `{}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid Body",
+			requestBody:    `VWXZ-BCDF`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/device-auth", bytes.NewBufferString(tc.requestBody))
+
+			h.ApproveHandler(recorder, req)
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+		})
+	}
+
+	if approved["VWXZ-BCDF"] != "test_user" {
+		t.Errorf("expecting the device authorization to be approved by the user of the request")
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+)
+
+// EventResponse is a response sent to the client
+type EventResponse struct {
+	ID          uint64
+	Timestamp   time.Time
+	Activity    string
+	InitiatorID string
+	TargetID    string
+	Meta        map[string]string
+}
+
+// Events is a handler that returns the activity events of the account
+type Events struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewEvents(accountManager server.AccountManager, authAudience string) *Events {
+	return &Events{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// GetEventsHandler list of the account events optionally limited by the from and to (RFC3339) query parameters
+func (h *Events) GetEventsHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getEventsAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil {
+		http.Error(w, "invalid from parameter", http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		http.Error(w, "invalid to parameter", http.StatusBadRequest)
+		return
+	}
+
+	events, err := h.accountManager.GetEvents(account.Id, from, to)
+	if err != nil {
+		log.Errorf("failed getting events of account %s %v", account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	respBody := []*EventResponse{}
+	for _, event := range events {
+		respBody = append(respBody, toEventResponse(event))
+	}
+	writeJSONObject(w, respBody)
+}
+
+func (h *Events) getEventsAccount(r *http.Request) (*server.Account, error) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+
+	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err)
+	}
+
+	return account, nil
+}
+
+// parseTimeParam parses an RFC3339 time query parameter returning zero time if it wasn't provided
+func parseTimeParam(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func toEventResponse(event *activity.Event) *EventResponse {
+	return &EventResponse{
+		ID:          event.ID,
+		Timestamp:   event.Timestamp,
+		Activity:    event.Activity.String(),
+		InitiatorID: event.InitiatorID,
+		TargetID:    event.TargetID,
+		Meta:        event.Meta,
+	}
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+func initEventsTestData(events ...*activity.Event) *Events {
+	return &Events{
+		accountManager: &mock_server.MockAccountManager{
+			GetEventsFunc: func(accountID string, from, to time.Time) ([]*activity.Event, error) {
+				var filtered []*activity.Event
+				for _, event := range events {
+					if event.AccountID != accountID {
+						continue
+					}
+					if (!from.IsZero() && event.Timestamp.Before(from)) || (!to.IsZero() && event.Timestamp.After(to)) {
+						continue
+					}
+					filtered = append(filtered, event)
+				}
+				return filtered, nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:     claims.AccountId,
+					Domain: "hotmail.com",
+				}, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestGetEvents(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := initEventsTestData(
+		&activity.Event{ID: 1, Timestamp: now.Add(-2 * time.Hour), Activity: activity.SetupKeyCreated, AccountID: "test_id"},
+		&activity.Event{ID: 2, Timestamp: now.Add(-time.Hour), Activity: activity.PeerAddedWithSetupKey, AccountID: "test_id"},
+		&activity.Event{ID: 3, Timestamp: now, Activity: activity.PeerRemovedByUser, AccountID: "test_id",
+			InitiatorID: "test_user", TargetID: "peer", Meta: map[string]string{"name": "peer"}},
+		&activity.Event{ID: 4, Timestamp: now, Activity: activity.GroupCreated, AccountID: "other_id"},
+	)
+
+	tt := []struct {
+		name           string
+		requestPath    string
+		expectedStatus int
+		expectedIDs    []uint64
+	}{
+		{
+			name:           "All Events",
+			requestPath:    "/api/events",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{1, 2, 3},
+		},
+		{
+			name:           "Events From",
+			requestPath:    "/api/events?from=2022-06-01T11:00:00Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{2, 3},
+		},
+		{
+			name:           "Events Within Range",
+			requestPath:    "/api/events?from=2022-06-01T10:30:00Z&to=2022-06-01T11:30:00Z",
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []uint64{2},
+		},
+		{
+			name:           "Invalid From",
+			requestPath:    "/api/events?from=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid To",
+			requestPath:    "/api/events?to=2022-06-01",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+
+			h.GetEventsHandler(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			respBody := []*EventResponse{}
+			err := json.NewDecoder(res.Body).Decode(&respBody)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			gotIDs := []uint64{}
+			for _, event := range respBody {
+				gotIDs = append(gotIDs, event.ID)
+				if event.ID == 3 {
+					assert.Equal(t, event.Activity, "peer.user.delete")
+					assert.Equal(t, event.InitiatorID, "test_user")
+					assert.Equal(t, event.TargetID, "peer")
+					assert.Equal(t, event.Meta["name"], "peer")
+				}
+			}
+			assert.Equal(t, gotIDs, tc.expectedIDs)
+		})
+	}
+}
@@ -8,9 +8,10 @@ import (
 	"github.com/netbirdio/netbird/management/server"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
 	"github.com/rs/xid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/gorilla/mux"
-	log "github.com/sirupsen/logrus"
 )
 
 // GroupResponse is a response sent to the client
@@ -88,7 +89,12 @@ func (h *Groups) CreateOrUpdateGroupHandler(w http.ResponseWriter, r *http.Reque
 		Peers: req.Peers,
 	}
 
-	if err := h.accountManager.SaveGroup(account.Id, &group); err != nil {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.SaveGroup(account.Id, jwtClaims.UserId, &group); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
 		log.Errorf("failed updating group %s under account %s %v", req.ID, account.Id, err)
 		http.Redirect(w, r, "/", http.StatusInternalServerError)
 		return
@@ -111,7 +117,8 @@ func (h *Groups) DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.accountManager.DeleteGroup(aID, gID); err != nil {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.DeleteGroup(aID, jwtClaims.UserId, gID); err != nil {
 		log.Errorf("failed delete group %s under account %s %v", gID, aID, err)
 		http.Redirect(w, r, "/", http.StatusInternalServerError)
 		return
@@ -0,0 +1,6 @@
+package handler
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the HTTP API handlers of the Management service, its level is set by the mgmt component level
+var log = util.Logger("mgmt")
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/rs/xid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// NetworkResponse is a response sent to the client
+type NetworkResponse struct {
+	ID     string
+	CIDR   string
+	Serial uint64
+}
+
+// NetworkRequest to update the account network
+type NetworkRequest struct {
+	CIDR string
+}
+
+// IPReservationResponse is an IP reservation sent to the client
+type IPReservationResponse struct {
+	ID          string
+	Description string
+	PeerKey     string
+	PeerName    string
+	IP          string
+}
+
+// IPReservationRequest to create or update an IP reservation, either the PeerKey or the PeerName has to be set
+type IPReservationRequest struct {
+	Description string
+	PeerKey     string
+	PeerName    string
+	IP          string
+}
+
+// Network is a handler that returns and updates the network of the account
+type Network struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewNetwork(accountManager server.AccountManager, authAudience string) *Network {
+	return &Network{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// GetNetworkHandler returns the network of the account
+func (h *Network) GetNetworkHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getNetworkAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, toNetworkResponse(account.Network))
+}
+
+// UpdateNetworkHandler changes the CIDR of the account network
+func (h *Network) UpdateNetworkHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getNetworkAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	var req NetworkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, ipNet, err := net.ParseCIDR(req.CIDR)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid CIDR %s", req.CIDR), http.StatusBadRequest)
+		return
+	}
+
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	network, err := h.accountManager.UpdateAccountNetwork(account.Id, *ipNet, jwtClaims.UserId)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		case codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+		default:
+			log.Errorf("failed updating network of account %s %v", account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, toNetworkResponse(network))
+}
+
+// GetIPReservationsHandler lists the IP reservations of the account sorted by their IPs
+func (h *Network) GetIPReservationsHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getNetworkAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	reservations := []*IPReservationResponse{}
+	for _, reservation := range account.IPReservations {
+		reservations = append(reservations, toIPReservationResponse(reservation))
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(reservations[i].IP), net.ParseIP(reservations[j].IP)) < 0
+	})
+
+	writeJSONObject(w, reservations)
+}
+
+// SaveIPReservationHandler creates an IP reservation with a new ID on POST and updates the reservation of the path ID
+// on PUT
+func (h *Network) SaveIPReservationHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getNetworkAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	var req IPReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		http.Error(w, fmt.Sprintf("invalid IP %s", req.IP), http.StatusBadRequest)
+		return
+	}
+
+	reservation := &server.IPReservation{
+		ID:          mux.Vars(r)["id"],
+		Description: req.Description,
+		PeerKey:     req.PeerKey,
+		PeerName:    req.PeerName,
+		IP:          ip,
+	}
+	if r.Method == http.MethodPost {
+		reservation.ID = xid.New().String()
+	} else if _, ok := account.IPReservations[reservation.ID]; !ok {
+		http.Error(w, "IP reservation not found", http.StatusNotFound)
+		return
+	}
+
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	err = h.accountManager.SaveIPReservation(account.Id, jwtClaims.UserId, reservation)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		case codes.AlreadyExists, codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+		default:
+			log.Errorf("failed saving IP reservation %s of account %s %v", reservation.ID, account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, toIPReservationResponse(reservation))
+}
+
+// DeleteIPReservationHandler removes the IP reservation of the path ID
+func (h *Network) DeleteIPReservationHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getNetworkAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	reservationID := mux.Vars(r)["id"]
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	err = h.accountManager.DeleteIPReservation(account.Id, jwtClaims.UserId, reservationID)
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "IP reservation not found", http.StatusNotFound)
+			return
+		}
+		log.Errorf("failed deleting IP reservation %s of account %s %v", reservationID, account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, "")
+}
+
+func (h *Network) getNetworkAccount(r *http.Request) (*server.Account, error) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+
+	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err)
+	}
+
+	return account, nil
+}
+
+func toNetworkResponse(network *server.Network) *NetworkResponse {
+	return &NetworkResponse{
+		ID:     network.Id,
+		CIDR:   network.Net.String(),
+		Serial: network.CurrentSerial(),
+	}
+}
+
+func toIPReservationResponse(reservation *server.IPReservation) *IPReservationResponse {
+	return &IPReservationResponse{
+		ID:          reservation.ID,
+		Description: reservation.Description,
+		PeerKey:     reservation.PeerKey,
+		PeerName:    reservation.PeerName,
+		IP:          reservation.IP.String(),
+	}
+}
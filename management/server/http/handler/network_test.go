@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gorilla/mux"
+	"github.com/magiconair/properties/assert"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+func initNetworkTestData(network *server.Network) *Network {
+	return &Network{
+		accountManager: &mock_server.MockAccountManager{
+			UpdateAccountNetworkFunc: func(accountID string, ipNet net.IPNet, userID string) (*server.Network, error) {
+				if ipNet.String() == "10.20.0.0/16" {
+					return nil, status.Errorf(codes.FailedPrecondition, "network collides with peer")
+				}
+				network.Net = ipNet
+				return network, nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:      claims.AccountId,
+					Domain:  "hotmail.com",
+					Network: network,
+				}, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestNetworkHandlers(t *testing.T) {
+	tt := []struct {
+		name           string
+		requestType    string
+		requestBody    io.Reader
+		expectedStatus int
+		expectedCIDR   string
+	}{
+		{
+			name:           "Get Network",
+			requestType:    http.MethodGet,
+			expectedStatus: http.StatusOK,
+			expectedCIDR:   "100.64.0.0/16",
+		},
+		{
+			name:           "Update Network",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"CIDR": "10.10.0.0/16"}`),
+			expectedStatus: http.StatusOK,
+			expectedCIDR:   "10.10.0.0/16",
+		},
+		{
+			name:           "Update Network With Invalid CIDR",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"CIDR": "10.10.0.0"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Update Network Colliding With Peers",
+			requestType:    http.MethodPut,
+			requestBody:    bytes.NewBufferString(`{"CIDR": "10.20.0.0/16"}`),
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ipNet, _ := net.ParseCIDR("100.64.0.0/16")
+			h := initNetworkTestData(&server.Network{Id: "network", Net: *ipNet})
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.requestType, "/api/network", tc.requestBody)
+
+			if tc.requestType == http.MethodGet {
+				h.GetNetworkHandler(recorder, req)
+			} else {
+				h.UpdateNetworkHandler(recorder, req)
+			}
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, content: %s",
+					status, tc.expectedStatus, recorder.Body.String())
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			got := &NetworkResponse{}
+			if err := json.NewDecoder(res.Body).Decode(got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.CIDR, tc.expectedCIDR)
+			assert.Equal(t, got.ID, "network")
+		})
+	}
+}
+
+func TestIPReservationHandlers(t *testing.T) {
+	tt := []struct {
+		name           string
+		requestType    string
+		requestPath    string
+		requestBody    io.Reader
+		expectedStatus int
+		expectedIP     string
+	}{
+		{
+			name:           "Create IP Reservation",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/network/reservations",
+			requestBody:    bytes.NewBufferString(`{"PeerName": "gateway", "IP": "100.64.0.10"}`),
+			expectedStatus: http.StatusOK,
+			expectedIP:     "100.64.0.10",
+		},
+		{
+			name:           "Create IP Reservation With Invalid IP",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/network/reservations",
+			requestBody:    bytes.NewBufferString(`{"PeerName": "gateway", "IP": "100.64.0"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Create IP Reservation Of Taken IP",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/network/reservations",
+			requestBody:    bytes.NewBufferString(`{"PeerName": "gateway", "IP": "100.64.0.2"}`),
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:           "Update IP Reservation",
+			requestType:    http.MethodPut,
+			requestPath:    "/api/network/reservations/reservation",
+			requestBody:    bytes.NewBufferString(`{"PeerKey": "key", "IP": "100.64.0.20"}`),
+			expectedStatus: http.StatusOK,
+			expectedIP:     "100.64.0.20",
+		},
+		{
+			name:           "Update Unknown IP Reservation",
+			requestType:    http.MethodPut,
+			requestPath:    "/api/network/reservations/unknown",
+			requestBody:    bytes.NewBufferString(`{"PeerKey": "key", "IP": "100.64.0.20"}`),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Delete IP Reservation",
+			requestType:    http.MethodDelete,
+			requestPath:    "/api/network/reservations/reservation",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Delete Unknown IP Reservation",
+			requestType:    http.MethodDelete,
+			requestPath:    "/api/network/reservations/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ipNet, _ := net.ParseCIDR("100.64.0.0/16")
+			h := initNetworkTestData(&server.Network{Id: "network", Net: *ipNet})
+			reservations := map[string]*server.IPReservation{
+				"reservation": {ID: "reservation", PeerName: "server", IP: net.ParseIP("100.64.0.5")},
+			}
+			accountManager := h.accountManager.(*mock_server.MockAccountManager)
+			getAccount := accountManager.GetAccountWithAuthorizationClaimsFunc
+			accountManager.GetAccountWithAuthorizationClaimsFunc = func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				account, err := getAccount(claims)
+				if err != nil {
+					return nil, err
+				}
+				account.IPReservations = reservations
+				return account, nil
+			}
+			accountManager.SaveIPReservationFunc = func(accountID, userID string, reservation *server.IPReservation) error {
+				if reservation.IP.Equal(net.ParseIP("100.64.0.2")) {
+					return status.Errorf(codes.AlreadyExists, "IP is already assigned to peer")
+				}
+				reservations[reservation.ID] = reservation
+				return nil
+			}
+			accountManager.DeleteIPReservationFunc = func(accountID, userID, reservationID string) error {
+				if _, ok := reservations[reservationID]; !ok {
+					return status.Errorf(codes.NotFound, "IP reservation not found")
+				}
+				delete(reservations, reservationID)
+				return nil
+			}
+
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.requestType, tc.requestPath, tc.requestBody)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/network/reservations", h.SaveIPReservationHandler).Methods("POST")
+			router.HandleFunc("/api/network/reservations/{id}", h.SaveIPReservationHandler).Methods("PUT")
+			router.HandleFunc("/api/network/reservations/{id}", h.DeleteIPReservationHandler).Methods("DELETE")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, content: %s",
+					status, tc.expectedStatus, recorder.Body.String())
+			}
+
+			if tc.expectedStatus != http.StatusOK || tc.requestType == http.MethodDelete {
+				return
+			}
+
+			got := &IPReservationResponse{}
+			if err := json.NewDecoder(res.Body).Decode(got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.IP, tc.expectedIP)
+			if _, ok := reservations[got.ID]; !ok {
+				t.Errorf("expecting reservation %s to be saved", got.ID)
+			}
+		})
+	}
+}
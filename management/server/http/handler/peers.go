@@ -4,12 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/netbirdio/netbird/management/server"
-	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 //Peers is a handler that returns peers of the account
@@ -21,17 +26,110 @@ type Peers struct {
 
 //PeerResponse is a response sent to the client
 type PeerResponse struct {
+	Key       string
 	Name      string
 	IP        string
 	Connected bool
 	LastSeen  time.Time
 	OS        string
 	Version   string
+	Kernel    string
+	Hostname  string
+	Labels    map[string]string
+	RateLimit uint64
+	// StaticEndpoint is the well-known public endpoint (host:port) other peers connect to the peer with directly
+	StaticEndpoint string
+	// RouteMetric is the metric of the routes the peer installs through its Wireguard interface, 0 is the OS default
+	RouteMetric uint32
+	// LastLogin is the last time the user that registered the peer has logged in with it
+	LastLogin time.Time
+	// LoginExpired indicates that the peer has to log in again to connect to the other peers
+	LoginExpired bool
+	// DisconnectedAt is the last time the peer has disconnected from the Management service
+	DisconnectedAt time.Time
+	// DiskEncrypted indicates that the peer has reported an encrypted system disk
+	DiskEncrypted bool
+	// FirewallEnabled indicates that the peer has reported an enabled host firewall
+	FirewallEnabled bool
+	// Suspended indicates that the peer is cut off from the network keeping its configuration
+	Suspended bool
+	// AllowedIPsConflicts are the conflicting allowed IPs of the remote peers the peer has reported in its network map
+	AllowedIPsConflicts []AllowedIPsConflictResponse
+	// SSH are the settings of the SSH server of the peer
+	SSH PeerSSH
+	// Tags are the tags of the peer of the form value or key:value selecting it in the rules
+	Tags []string
+}
+
+//PeerSSH are the settings of the SSH server of a peer listening on its IP
+type PeerSSH struct {
+	// Enabled enables the SSH server, the peer runs it only if its config allows it as well
+	Enabled bool
+	// AllowedGroups are the IDs of the groups of the peers allowed to connect, required to enable the server
+	AllowedGroups []string
+}
+
+//AllowedIPsConflictResponse is an allowed IP of a remote peer a peer has refused because another remote peer has the
+//same (Duplicate) or an overlapping one
+type AllowedIPsConflictResponse struct {
+	Prefix            string
+	Peer              string
+	ConflictingPrefix string
+	ConflictingPeer   string
+	Duplicate         bool
+}
+
+//ReachablePeerResponse is a remote peer reachable by a peer along with the rules allowing the connection
+type ReachablePeerResponse struct {
+	Peer  *PeerResponse
+	Rules []ReachabilityRuleResponse
+}
+
+//ReachabilityRuleResponse is a rule allowing the connection to a reachable peer
+type ReachabilityRuleResponse struct {
+	ID   string
+	Name string
+}
+
+//PeerConnectivityResponse is the outcomes of the connection attempts to a peer reported by the other peers of the account
+type PeerConnectivityResponse struct {
+	// Reporters is the number of the peers that have tried to connect to the peer within the last day
+	Reporters int
+	// Failing is the number of the Reporters that have failed all their latest attempts
+	Failing int
+	// FailingPercent is the share of the failing Reporters, e.g. 80 if 8 of 10 peers fail to connect to a gateway
+	FailingPercent float64
+	// Connected is the number of the attempts that have established the connection
+	Connected int
+	// Failures is the number of the failed attempts by the failure class, e.g. ice-failed or signaling-timeout
+	Failures map[string]int
 }
 
 //PeerRequest is a request sent by the client
 type PeerRequest struct {
-	Name string
+	// Name is an optional friendly name of the peer sent to the other peers. It has to be a DNS label unique within
+	// the account. An empty name resets it to the peer's hostname
+	Name *string
+	// RateLimit is an optional egress rate limit in kbit/s other peers apply to the traffic sent to the peer.
+	// 0 removes the limit. Applied by Linux peers only
+	RateLimit *uint64
+	// StaticEndpoint is an optional well-known public endpoint (host:port) of the peer other peers configure
+	// Wireguard with directly, skipping the connection negotiation. An empty string removes it
+	StaticEndpoint *string
+	// RouteMetric is an optional metric of the routes the peer installs through its Wireguard interface.
+	// The lower the metric the more preferred the routes, 0 restores the OS default. Applied by Linux and Windows peers only
+	RouteMetric *uint32
+	// IP is an optional fixed IP within the account network assigned to the peer (e.g. a gateway or a DNS server).
+	// The peer readdresses its interface with its next network map
+	IP *string
+	// Suspended optionally suspends the peer, excluding it from the network maps of other peers and emptying its own,
+	// or resumes it. The peer keeps its IP, key and groups
+	Suspended *bool
+	// SSH optionally replaces the settings of the SSH server of the peer
+	SSH *PeerSSH
+	// Tags optionally replace the tags of the peer of the form value or key:value, e.g. role:db.
+	// An empty list removes them
+	Tags *[]string
 }
 
 func NewPeers(accountManager server.AccountManager, authAudience string) *Peers {
@@ -50,17 +148,110 @@ func (h *Peers) updatePeer(accountId string, peer *server.Peer, w http.ResponseW
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	peer, err = h.accountManager.RenamePeer(accountId, peer.Key, req.Name)
-	if err != nil {
-		log.Errorf("failed updating peer %s under account %s %v", peerIp, accountId, err)
-		http.Redirect(w, r, "/", http.StatusInternalServerError)
-		return
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if req.Name != nil {
+		peer, err = h.accountManager.RenamePeer(accountId, peer.Key, *req.Name, jwtClaims.UserId)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			case codes.AlreadyExists:
+				http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			case codes.NotFound:
+				http.Error(w, "peer not found", http.StatusNotFound)
+			default:
+				log.Errorf("failed updating peer %s under account %s %v", peerIp, accountId, err)
+				http.Redirect(w, r, "/", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if req.RateLimit != nil {
+		peer, err = h.accountManager.UpdatePeerRateLimit(accountId, peer.Key, *req.RateLimit, jwtClaims.UserId)
+		if err != nil {
+			log.Errorf("failed updating rate limit of peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.StaticEndpoint != nil {
+		peer, err = h.accountManager.UpdatePeerStaticEndpoint(accountId, peer.Key, *req.StaticEndpoint, jwtClaims.UserId)
+		if err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+				return
+			}
+			log.Errorf("failed updating static endpoint of peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.RouteMetric != nil {
+		peer, err = h.accountManager.UpdatePeerRouteMetric(accountId, peer.Key, *req.RouteMetric, jwtClaims.UserId)
+		if err != nil {
+			log.Errorf("failed updating route metric of peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.IP != nil {
+		ip := net.ParseIP(*req.IP)
+		if ip == nil {
+			http.Error(w, fmt.Sprintf("invalid IP %s", *req.IP), http.StatusBadRequest)
+			return
+		}
+		peer, err = h.accountManager.UpdatePeerIP(accountId, peer.Key, ip, jwtClaims.UserId)
+		if err != nil {
+			switch status.Code(err) {
+			case codes.InvalidArgument:
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			case codes.AlreadyExists:
+				http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			default:
+				log.Errorf("failed updating IP of peer %s under account %s %v", peerIp, accountId, err)
+				http.Redirect(w, r, "/", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+	if req.SSH != nil {
+		peer, err = h.accountManager.UpdatePeerSSH(accountId, peer.Key, req.SSH.Enabled, req.SSH.AllowedGroups, jwtClaims.UserId)
+		if err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+				return
+			}
+			log.Errorf("failed updating SSH server of peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Tags != nil {
+		peer, err = h.accountManager.UpdatePeerTags(accountId, peer.Key, *req.Tags, jwtClaims.UserId)
+		if err != nil {
+			if status.Code(err) == codes.InvalidArgument {
+				http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+				return
+			}
+			log.Errorf("failed updating tags of peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+	}
+	if req.Suspended != nil {
+		peer, err = h.accountManager.SuspendPeer(accountId, peer.Key, *req.Suspended, jwtClaims.UserId)
+		if err != nil {
+			log.Errorf("failed suspending peer %s under account %s %v", peerIp, accountId, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
 	}
 	writeJSONObject(w, toPeerResponse(peer))
 }
 
 func (h *Peers) deletePeer(accountId string, peer *server.Peer, w http.ResponseWriter, r *http.Request) {
-	_, err := h.accountManager.DeletePeer(accountId, peer.Key)
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	_, err := h.accountManager.DeletePeer(accountId, peer.Key, jwtClaims.UserId)
 	if err != nil {
 		log.Errorf("failed deleteing peer %s, %v", peer.IP, err)
 		http.Redirect(w, r, "/", http.StatusInternalServerError)
@@ -117,6 +308,72 @@ func (h *Peers) HandlePeer(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetPeerReachability returns the peers the requested peer can reach annotated with the rules allowing the connections
+func (h *Peers) GetPeerReachability(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getPeerAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+	vars := mux.Vars(r)
+	peerId := vars["id"] //effectively peer IP address
+	if len(peerId) == 0 {
+		http.Error(w, "invalid peer Id", http.StatusBadRequest)
+		return
+	}
+
+	peer, err := h.accountManager.GetPeerByIP(account.Id, peerId)
+	if err != nil {
+		http.Error(w, "peer not found", http.StatusNotFound)
+		return
+	}
+
+	reachable, err := h.accountManager.GetPeerReachability(peer.Key)
+	if err != nil {
+		log.Errorf("failed getting reachability of peer %s under account %s %v", peer.IP, account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	respBody := []*ReachablePeerResponse{}
+	for _, rp := range reachable {
+		respBody = append(respBody, toReachablePeerResponse(rp))
+	}
+	writeJSONObject(w, respBody)
+}
+
+// GetPeerConnectivity returns the outcomes of the connection attempts to the requested peer reported by the other peers
+func (h *Peers) GetPeerConnectivity(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getPeerAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+	vars := mux.Vars(r)
+	peerId := vars["id"] //effectively peer IP address
+	if len(peerId) == 0 {
+		http.Error(w, "invalid peer Id", http.StatusBadRequest)
+		return
+	}
+
+	peer, err := h.accountManager.GetPeerByIP(account.Id, peerId)
+	if err != nil {
+		http.Error(w, "peer not found", http.StatusNotFound)
+		return
+	}
+
+	connectivity, err := h.accountManager.GetPeerConnectivity(peer.Key)
+	if err != nil {
+		log.Errorf("failed getting connectivity of peer %s under account %s %v", peer.IP, account.Id, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, toPeerConnectivityResponse(connectivity))
+}
+
 func (h *Peers) GetPeers(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -127,8 +384,48 @@ func (h *Peers) GetPeers(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		query := r.URL.Query()
+		page, err := parsePageParam(query.Get("page"), 1)
+		if err != nil {
+			http.Error(w, "invalid page parameter", http.StatusBadRequest)
+			return
+		}
+		pageSize, err := parsePageParam(query.Get("page_size"), 0)
+		if err != nil {
+			http.Error(w, "invalid page_size parameter", http.StatusBadRequest)
+			return
+		}
+
+		versionLt := query.Get("version_lt")
+		if versionLt != "" {
+			if _, err = server.ParseVersion(versionLt); err != nil {
+				http.Error(w, "invalid version_lt parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		filter := peersFilter{
+			name:      strings.ToLower(query.Get("name")),
+			ip:        query.Get("ip"),
+			labels:    parseLabelsParam(query["label"]),
+			versionLt: versionLt,
+		}
+		peers := filterPeers(account.Peers, filter)
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(peers)))
+
+		quota, err := h.accountManager.GetPeersQuota(account.Id)
+		if err != nil {
+			log.Errorf("failed getting peers quota of account %s %v", account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+		// the number of peers registered in the account and the maximum number of peers, 0 means unlimited
+		w.Header().Set("X-Peers-Count", strconv.Itoa(quota.Count))
+		w.Header().Set("X-Peers-Limit", strconv.Itoa(quota.Limit))
+
 		respBody := []*PeerResponse{}
-		for _, peer := range account.Peers {
+		for _, peer := range paginatePeers(peers, page, pageSize) {
 			respBody = append(respBody, toPeerResponse(peer))
 		}
 		writeJSONObject(w, respBody)
@@ -138,13 +435,155 @@ func (h *Peers) GetPeers(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parsePageParam parses a positive integer pagination parameter returning defaultValue if it wasn't provided
+func parsePageParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 1 {
+		return 0, fmt.Errorf("invalid pagination value %s", value)
+	}
+	return parsed, nil
+}
+
+// peersFilter holds the query parameters used to filter the peers list
+type peersFilter struct {
+	// name is a lower case substring of the peer name
+	name string
+	// ip is a prefix of the peer IP
+	ip string
+	// labels the peer must have. An empty value matches any value of the label
+	labels map[string]string
+	// versionLt matches peers running a client version older than this one
+	versionLt string
+}
+
+// match checks whether a peer satisfies all the filter conditions
+func (f peersFilter) match(peer *server.Peer) bool {
+	if f.name != "" && !strings.Contains(strings.ToLower(peer.Name), f.name) {
+		return false
+	}
+	if f.ip != "" && !strings.HasPrefix(peer.IP.String(), f.ip) {
+		return false
+	}
+	if f.versionLt != "" && !peer.Meta.VersionOlderThan(f.versionLt) {
+		return false
+	}
+	return peer.Meta.HasLabels(f.labels)
+}
+
+// parseLabelsParam parses label query parameters of the form key=value or key into a map
+func parseLabelsParam(values []string) map[string]string {
+	labels := make(map[string]string)
+	for _, value := range values {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		} else {
+			labels[kv[0]] = ""
+		}
+	}
+	return labels
+}
+
+// filterPeers returns peers matching the filter.
+// The result is sorted by name and then by IP so that pagination is stable between requests
+func filterPeers(peers map[string]*server.Peer, filter peersFilter) []*server.Peer {
+	var filtered []*server.Peer
+	for _, peer := range peers {
+		if filter.match(peer) {
+			filtered = append(filtered, peer)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].Name != filtered[j].Name {
+			return filtered[i].Name < filtered[j].Name
+		}
+		return filtered[i].IP.String() < filtered[j].IP.String()
+	})
+
+	return filtered
+}
+
+// paginatePeers returns a page (starting from 1) of peers. A pageSize of 0 returns all the peers
+func paginatePeers(peers []*server.Peer, page, pageSize int) []*server.Peer {
+	if pageSize == 0 {
+		return peers
+	}
+
+	start := (page - 1) * pageSize
+	if start >= len(peers) {
+		return []*server.Peer{}
+	}
+
+	end := start + pageSize
+	if end > len(peers) {
+		end = len(peers)
+	}
+
+	return peers[start:end]
+}
+
 func toPeerResponse(peer *server.Peer) *PeerResponse {
-	return &PeerResponse{
-		Name:      peer.Name,
-		IP:        peer.IP.String(),
-		Connected: peer.Status.Connected,
-		LastSeen:  peer.Status.LastSeen,
-		OS:        fmt.Sprintf("%s %s", peer.Meta.OS, peer.Meta.Core),
-		Version:   peer.Meta.WtVersion,
+	response := &PeerResponse{
+		Key:             peer.Key,
+		Name:            peer.Name,
+		IP:              peer.IP.String(),
+		OS:              fmt.Sprintf("%s %s", peer.Meta.OS, peer.Meta.Core),
+		Version:         peer.Meta.WtVersion,
+		Kernel:          peer.Meta.Kernel,
+		Hostname:        peer.Meta.Hostname,
+		Labels:          peer.Meta.Labels,
+		RateLimit:       peer.RateLimit,
+		StaticEndpoint:  peer.StaticEndpoint,
+		RouteMetric:     peer.RouteMetric,
+		LastLogin:       peer.LastLogin,
+		DiskEncrypted:   peer.Meta.DiskEncrypted,
+		FirewallEnabled: peer.Meta.FirewallEnabled,
+		Suspended:       peer.Suspended,
+		SSH:             PeerSSH{Enabled: peer.SSHEnabled, AllowedGroups: []string{}},
+		Tags:            []string{},
+	}
+	response.SSH.AllowedGroups = append(response.SSH.AllowedGroups, peer.SSHAllowedGroups...)
+	response.Tags = append(response.Tags, peer.Tags...)
+	response.AllowedIPsConflicts = []AllowedIPsConflictResponse{}
+	for _, conflict := range peer.AllowedIPsConflicts {
+		response.AllowedIPsConflicts = append(response.AllowedIPsConflicts, AllowedIPsConflictResponse(conflict))
+	}
+	if peer.Status != nil {
+		response.Connected = peer.Status.Connected
+		response.LastSeen = peer.Status.LastSeen
+		response.LoginExpired = peer.Status.LoginExpired
+		response.DisconnectedAt = peer.Status.DisconnectedAt
+	}
+	return response
+}
+
+func toPeerConnectivityResponse(connectivity *server.PeerConnectivity) *PeerConnectivityResponse {
+	response := &PeerConnectivityResponse{
+		Reporters: connectivity.Reporters,
+		Failing:   connectivity.Failing,
+		Connected: connectivity.Connected,
+		Failures:  map[string]int{},
+	}
+	if connectivity.Reporters > 0 {
+		response.FailingPercent = float64(connectivity.Failing) * 100 / float64(connectivity.Reporters)
+	}
+	for class, count := range connectivity.Failures {
+		response.Failures[class] = count
+	}
+	return response
+}
+
+func toReachablePeerResponse(reachable *server.ReachablePeer) *ReachablePeerResponse {
+	response := &ReachablePeerResponse{
+		Peer:  toPeerResponse(reachable.Peer),
+		Rules: []ReachabilityRuleResponse{},
+	}
+	for _, rule := range reachable.Rules {
+		response.Rules = append(response.Rules, ReachabilityRuleResponse{ID: rule.ID, Name: rule.Name})
 	}
+	return response
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 	"net"
@@ -8,25 +9,33 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/gorilla/mux"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/magiconair/properties/assert"
 	"github.com/netbirdio/netbird/management/server"
 	"github.com/netbirdio/netbird/management/server/mock_server"
 )
 
-func initTestMetaData(peer ...*server.Peer) *Peers {
+func initTestMetaData(peers ...*server.Peer) *Peers {
+	accountPeers := make(map[string]*server.Peer)
+	for _, peer := range peers {
+		accountPeers[peer.Key] = peer
+	}
 	return &Peers{
 		accountManager: &mock_server.MockAccountManager{
 			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
 				return &server.Account{
 					Id:     claims.AccountId,
 					Domain: "hotmail.com",
-					Peers: map[string]*server.Peer{
-						"test_peer": peer[0],
-					},
+					Peers:  accountPeers,
 				}, nil
 			},
+			GetPeersQuotaFunc: func(accountId string) (*server.PeersQuota, error) {
+				return &server.PeersQuota{Count: len(accountPeers), Limit: 10}, nil
+			},
 		},
 		authAudience: "",
 		jwtExtractor: jwtclaims.ClaimsExtractor{
@@ -105,6 +114,8 @@ func TestGetPeers(t *testing.T) {
 			}
 
 			got := respBody[0]
+			assert.Equal(t, got.Key, peer.Key)
+			assert.Equal(t, got.Hostname, peer.Meta.Hostname)
 			assert.Equal(t, got.Name, peer.Name)
 			assert.Equal(t, got.Version, peer.Meta.WtVersion)
 			assert.Equal(t, got.IP, peer.IP.String())
@@ -112,3 +123,484 @@ func TestGetPeers(t *testing.T) {
 		})
 	}
 }
+
+// Tests filtering and pagination of the GetPeers endpoint reachable in the route /api/peers
+func TestGetPeersFilterAndPagination(t *testing.T) {
+	peers := []*server.Peer{
+		{Key: "key1", IP: net.ParseIP("100.64.0.1"), Name: "alpha", Status: &server.PeerStatus{Connected: true},
+			Meta: server.PeerSystemMeta{WtVersion: "0.6.1", Labels: map[string]string{"env": "prod", "team": "infra"}}},
+		{Key: "key2", IP: net.ParseIP("100.64.0.2"), Name: "beta", Status: &server.PeerStatus{},
+			Meta: server.PeerSystemMeta{WtVersion: "0.5.9", Labels: map[string]string{"env": "dev"}}},
+		{Key: "key3", IP: net.ParseIP("100.64.1.3"), Name: "Alpine", Status: &server.PeerStatus{},
+			Meta: server.PeerSystemMeta{WtVersion: "development"}},
+		{Key: "key4", IP: net.ParseIP("100.64.1.4"), Name: "gamma", Meta: server.PeerSystemMeta{WtVersion: "0.6.0"}},
+	}
+
+	tt := []struct {
+		name           string
+		requestPath    string
+		expectedStatus int
+		expectedKeys   []string
+		expectedTotal  string
+	}{
+		{
+			name:           "All Peers Sorted By Name",
+			requestPath:    "/api/peers",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key3", "key1", "key2", "key4"},
+			expectedTotal:  "4",
+		},
+		{
+			name:           "Filter By Name",
+			requestPath:    "/api/peers?name=alp",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key3", "key1"},
+			expectedTotal:  "2",
+		},
+		{
+			name:           "Filter By IP",
+			requestPath:    "/api/peers?ip=100.64.1.",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key3", "key4"},
+			expectedTotal:  "2",
+		},
+		{
+			name:           "Filter By Label Value",
+			requestPath:    "/api/peers?label=env=prod",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key1"},
+			expectedTotal:  "1",
+		},
+		{
+			name:           "Filter By Label Key",
+			requestPath:    "/api/peers?label=env",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key1", "key2"},
+			expectedTotal:  "2",
+		},
+		{
+			name:           "Filter By Multiple Labels",
+			requestPath:    "/api/peers?label=env&label=team=infra",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key1"},
+			expectedTotal:  "1",
+		},
+		{
+			name:           "Filter By Version Older Than",
+			requestPath:    "/api/peers?version_lt=0.6.1",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key2", "key4"},
+			expectedTotal:  "2",
+		},
+		{
+			name:           "Invalid Version",
+			requestPath:    "/api/peers?version_lt=latest",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Second Page",
+			requestPath:    "/api/peers?page=2&page_size=3",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{"key4"},
+			expectedTotal:  "4",
+		},
+		{
+			name:           "Page Out Of Range",
+			requestPath:    "/api/peers?page=3&page_size=3",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{},
+			expectedTotal:  "4",
+		},
+		{
+			name:           "Invalid Page Size",
+			requestPath:    "/api/peers?page_size=-1",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	p := initTestMetaData(peers...)
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+
+			p.GetPeers(rr, req)
+
+			res := rr.Result()
+			defer res.Body.Close()
+
+			if status := rr.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			assert.Equal(t, res.Header.Get("X-Total-Count"), tc.expectedTotal)
+			// the quota counts all the peers of the account regardless of the filter
+			assert.Equal(t, res.Header.Get("X-Peers-Count"), "4")
+			assert.Equal(t, res.Header.Get("X-Peers-Limit"), "10")
+
+			respBody := []*PeerResponse{}
+			err := json.NewDecoder(res.Body).Decode(&respBody)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			gotKeys := []string{}
+			for _, peer := range respBody {
+				gotKeys = append(gotKeys, peer.Key)
+			}
+			assert.Equal(t, gotKeys, tc.expectedKeys)
+		})
+	}
+}
+
+func TestGetPeerConnectivity(t *testing.T) {
+	gateway := &server.Peer{Key: "keyA", Name: "gateway", IP: net.ParseIP("100.64.0.1"), Status: &server.PeerStatus{}}
+
+	p := initTestMetaData(gateway)
+	p.accountManager.(*mock_server.MockAccountManager).GetPeerByIPFunc = func(accountId string, peerIP string) (*server.Peer, error) {
+		if gateway.IP.String() == peerIP {
+			return gateway, nil
+		}
+		return nil, status.Errorf(codes.NotFound, "peer with IP %s not found", peerIP)
+	}
+	p.accountManager.(*mock_server.MockAccountManager).GetPeerConnectivityFunc = func(peerKey string) (*server.PeerConnectivity, error) {
+		return &server.PeerConnectivity{
+			Reporters: 10,
+			Failing:   8,
+			Connected: 3,
+			Failures:  map[string]int{"ice-failed": 12, "signaling-timeout": 4},
+		}, nil
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/peers/{id}/connectivity", p.GetPeerConnectivity).Methods("GET")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/peers/100.64.0.1/connectivity", nil))
+	assert.Equal(t, recorder.Code, http.StatusOK)
+
+	respBody := &PeerConnectivityResponse{}
+	err := json.NewDecoder(recorder.Body).Decode(respBody)
+	if err != nil {
+		t.Fatalf("Sent content is not in correct json format; %v", err)
+	}
+	assert.Equal(t, respBody, &PeerConnectivityResponse{
+		Reporters:      10,
+		Failing:        8,
+		FailingPercent: 80,
+		Connected:      3,
+		Failures:       map[string]int{"ice-failed": 12, "signaling-timeout": 4},
+	})
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/peers/100.64.0.2/connectivity", nil))
+	assert.Equal(t, recorder.Code, http.StatusNotFound)
+}
+
+func TestGetPeerReachability(t *testing.T) {
+	peerA := &server.Peer{Key: "keyA", Name: "peerA", IP: net.ParseIP("100.64.0.1"), Status: &server.PeerStatus{}}
+	peerB := &server.Peer{Key: "keyB", Name: "peerB", IP: net.ParseIP("100.64.0.2"), Status: &server.PeerStatus{}}
+	rule := &server.Rule{ID: "ruleID", Name: "A to B"}
+
+	p := initTestMetaData(peerA, peerB)
+	p.accountManager.(*mock_server.MockAccountManager).GetPeerByIPFunc = func(accountId string, peerIP string) (*server.Peer, error) {
+		for _, peer := range []*server.Peer{peerA, peerB} {
+			if peer.IP.String() == peerIP {
+				return peer, nil
+			}
+		}
+		return nil, status.Errorf(codes.NotFound, "peer with IP %s not found", peerIP)
+	}
+	p.accountManager.(*mock_server.MockAccountManager).GetPeerReachabilityFunc = func(peerKey string) ([]*server.ReachablePeer, error) {
+		if peerKey != peerA.Key {
+			return nil, nil
+		}
+		return []*server.ReachablePeer{{Peer: peerB, Rules: []*server.Rule{rule}}}, nil
+	}
+
+	tt := []struct {
+		name           string
+		requestPath    string
+		expectedStatus int
+		expectedKeys   []string
+	}{
+		{
+			name:           "Reachable Peers",
+			requestPath:    "/api/peers/100.64.0.1/reachability",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{peerB.Key},
+		},
+		{
+			name:           "No Reachable Peers",
+			requestPath:    "/api/peers/100.64.0.2/reachability",
+			expectedStatus: http.StatusOK,
+			expectedKeys:   []string{},
+		},
+		{
+			name:           "Unknown Peer",
+			requestPath:    "/api/peers/100.64.0.3/reachability",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/peers/{id}/reachability", p.GetPeerReachability).Methods("GET")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			respBody := []*ReachablePeerResponse{}
+			err := json.NewDecoder(res.Body).Decode(&respBody)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			gotKeys := []string{}
+			for _, reachable := range respBody {
+				gotKeys = append(gotKeys, reachable.Peer.Key)
+				assert.Equal(t, len(reachable.Rules), 1)
+				assert.Equal(t, reachable.Rules[0].ID, rule.ID)
+				assert.Equal(t, reachable.Rules[0].Name, rule.Name)
+			}
+			assert.Equal(t, gotKeys, tc.expectedKeys)
+		})
+	}
+}
+
+func TestUpdatePeerName(t *testing.T) {
+	peer := &server.Peer{
+		Key:    "key",
+		Name:   "laptop",
+		IP:     net.ParseIP("100.64.0.1"),
+		Status: &server.PeerStatus{},
+		Meta:   server.PeerSystemMeta{Hostname: "hostname"},
+	}
+
+	p := initTestMetaData(peer)
+	p.accountManager.(*mock_server.MockAccountManager).GetPeerByIPFunc = func(accountId string, peerIP string) (*server.Peer, error) {
+		if peerIP != peer.IP.String() {
+			return nil, status.Errorf(codes.NotFound, "peer with IP %s not found", peerIP)
+		}
+		return peer, nil
+	}
+	p.accountManager.(*mock_server.MockAccountManager).RenamePeerFunc = func(accountId string, peerKey string, newName string, userID string) (*server.Peer, error) {
+		if newName == "bad/name" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid peer name")
+		}
+		if newName == "taken" {
+			return nil, status.Errorf(codes.AlreadyExists, "peer name taken is already used")
+		}
+		renamed := peer.Copy()
+		renamed.Name = newName
+		if newName == "" {
+			renamed.Name = peer.Meta.Hostname
+		}
+		return renamed, nil
+	}
+
+	tt := []struct {
+		name           string
+		requestBody    string
+		expectedStatus int
+		expectedName   string
+	}{
+		{
+			name:           "Rename Peer",
+			requestBody:    `{"Name":"office-gateway"}`,
+			expectedStatus: http.StatusOK,
+			expectedName:   "office-gateway",
+		},
+		{
+			name:           "Reset Peer Name",
+			requestBody:    `{"Name":""}`,
+			expectedStatus: http.StatusOK,
+			expectedName:   "hostname",
+		},
+		{
+			name:           "Update Without Name",
+			requestBody:    `{}`,
+			expectedStatus: http.StatusOK,
+			expectedName:   "laptop",
+		},
+		{
+			name:           "Invalid Peer Name",
+			requestBody:    `{"Name":"bad/name"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Duplicate Peer Name",
+			requestBody:    `{"Name":"taken"}`,
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/api/peers/100.64.0.1", bytes.NewBufferString(tc.requestBody))
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/peers/{id}", p.HandlePeer).Methods("PUT")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			got := &PeerResponse{}
+			err := json.NewDecoder(res.Body).Decode(got)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.Name, tc.expectedName)
+		})
+	}
+}
+
+func TestSuspendPeer(t *testing.T) {
+	peer := &server.Peer{
+		Key:    "key",
+		Name:   "hostname",
+		IP:     net.ParseIP("100.64.0.1"),
+		Status: &server.PeerStatus{},
+		Meta:   server.PeerSystemMeta{Hostname: "hostname"},
+	}
+
+	p := initTestMetaData(peer)
+	mock := p.accountManager.(*mock_server.MockAccountManager)
+	mock.GetPeerByIPFunc = func(accountId string, peerIP string) (*server.Peer, error) {
+		return peer, nil
+	}
+	mock.RenamePeerFunc = func(accountId string, peerKey string, newName string, userID string) (*server.Peer, error) {
+		return peer, nil
+	}
+	mock.SuspendPeerFunc = func(accountId string, peerKey string, suspended bool, userID string) (*server.Peer, error) {
+		updated := peer.Copy()
+		updated.Suspended = suspended
+		return updated, nil
+	}
+
+	for _, suspended := range []bool{true, false} {
+		body, err := json.Marshal(&PeerRequest{Suspended: &suspended})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/api/peers/100.64.0.1", bytes.NewBuffer(body))
+
+		router := mux.NewRouter()
+		router.HandleFunc("/api/peers/{id}", p.HandlePeer).Methods("PUT")
+		router.ServeHTTP(recorder, req)
+
+		if status := recorder.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+
+		got := &PeerResponse{}
+		err = json.NewDecoder(recorder.Body).Decode(got)
+		if err != nil {
+			t.Fatalf("Sent content is not in correct json format; %v", err)
+		}
+		assert.Equal(t, got.Suspended, suspended)
+		assert.Equal(t, got.IP, peer.IP.String(), "a suspended peer should keep its IP")
+	}
+}
+
+func TestUpdatePeerTags(t *testing.T) {
+	peer := &server.Peer{
+		Key:    "key",
+		Name:   "hostname",
+		IP:     net.ParseIP("100.64.0.1"),
+		Status: &server.PeerStatus{},
+		Meta:   server.PeerSystemMeta{Hostname: "hostname"},
+	}
+
+	p := initTestMetaData(peer)
+	mock := p.accountManager.(*mock_server.MockAccountManager)
+	mock.GetPeerByIPFunc = func(accountId string, peerIP string) (*server.Peer, error) {
+		return peer, nil
+	}
+	mock.RenamePeerFunc = func(accountId string, peerKey string, newName string, userID string) (*server.Peer, error) {
+		return peer, nil
+	}
+	mock.UpdatePeerTagsFunc = func(accountId string, peerKey string, tags []string, userID string) (*server.Peer, error) {
+		for _, tag := range tags {
+			if tag == "invalid tag" {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid tag %q", tag)
+			}
+		}
+		updated := peer.Copy()
+		updated.Tags = tags
+		return updated, nil
+	}
+
+	tt := []struct {
+		name           string
+		tags           []string
+		expectedStatus int
+		expectedTags   []string
+	}{
+		{name: "Set", tags: []string{"role:db", "env:prod"}, expectedStatus: http.StatusOK, expectedTags: []string{"role:db", "env:prod"}},
+		{name: "Remove", tags: []string{}, expectedStatus: http.StatusOK, expectedTags: []string{}},
+		{name: "Invalid", tags: []string{"invalid tag"}, expectedStatus: http.StatusBadRequest},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tags := tc.tags
+			body, err := json.Marshal(&PeerRequest{Tags: &tags})
+			if err != nil {
+				t.Fatal(err)
+			}
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/api/peers/100.64.0.1", bytes.NewBuffer(body))
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/peers/{id}", p.HandlePeer).Methods("PUT")
+			router.ServeHTTP(recorder, req)
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, tc.expectedStatus)
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			got := &PeerResponse{}
+			err = json.NewDecoder(recorder.Body).Decode(got)
+			if err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.Tags, tc.expectedTags)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/rs/xid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteResponse is a response sent to the client
+type RouteResponse struct {
+	ID          string
+	Description string
+	Prefix      string
+	Peer        RoutePeerResponse
+	Groups      []RuleGroupResponse
+	Metric      int
+	Enabled     bool
+}
+
+// RoutePeerResponse is a routing peer of a route sent to the client
+type RoutePeerResponse struct {
+	Key  string
+	Name string
+	IP   string
+}
+
+// RouteRequest to create or update route
+type RouteRequest struct {
+	ID          string
+	Description string
+	Prefix      string
+	Peer        string
+	Groups      []string
+	Metric      int
+	Enabled     bool
+}
+
+// Routes is a handler that returns routes of the account
+type Routes struct {
+	jwtExtractor   jwtclaims.ClaimsExtractor
+	accountManager server.AccountManager
+	authAudience   string
+}
+
+func NewRoutes(accountManager server.AccountManager, authAudience string) *Routes {
+	return &Routes{
+		accountManager: accountManager,
+		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
+	}
+}
+
+// GetAllRoutesHandler list for the account
+func (h *Routes) GetAllRoutesHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getRouteAccount(r)
+	if err != nil {
+		log.Error(err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	routes := []*RouteResponse{}
+	for _, route := range account.Routes {
+		routes = append(routes, toRouteResponse(account, route))
+	}
+
+	writeJSONObject(w, routes)
+}
+
+// CreateOrUpdateRouteHandler creates a route with a new ID on POST and updates the route of the request ID on PUT.
+// The updated network map is sent to the peers of the account
+func (h *Routes) CreateOrUpdateRouteHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getRouteAccount(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	var req RouteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		req.ID = xid.New().String()
+	}
+
+	route := server.Route{
+		ID:          req.ID,
+		Description: req.Description,
+		Prefix:      req.Prefix,
+		Peer:        req.Peer,
+		Groups:      req.Groups,
+		Metric:      req.Metric,
+		Enabled:     req.Enabled,
+	}
+
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.SaveRoute(account.Id, jwtClaims.UserId, &route); err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		default:
+			log.Errorf("failed updating route %s under account %s %v", req.ID, account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSONObject(w, toRouteResponse(account, &route))
+}
+
+func (h *Routes) DeleteRouteHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getRouteAccount(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+	aID := account.Id
+
+	rID := mux.Vars(r)["id"]
+	if len(rID) == 0 {
+		http.Error(w, "invalid route ID", http.StatusBadRequest)
+		return
+	}
+
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.DeleteRoute(aID, jwtClaims.UserId, rID); err != nil {
+		log.Errorf("failed delete route %s under account %s %v", rID, aID, err)
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSONObject(w, "")
+}
+
+func (h *Routes) GetRouteHandler(w http.ResponseWriter, r *http.Request) {
+	account, err := h.getRouteAccount(r)
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		routeID := mux.Vars(r)["id"]
+		if len(routeID) == 0 {
+			http.Error(w, "invalid route ID", http.StatusBadRequest)
+			return
+		}
+
+		route, err := h.accountManager.GetRoute(account.Id, routeID)
+		if err != nil {
+			http.Error(w, "route not found", http.StatusNotFound)
+			return
+		}
+
+		writeJSONObject(w, toRouteResponse(account, route))
+	default:
+		http.Error(w, "", http.StatusNotFound)
+	}
+}
+
+func (h *Routes) getRouteAccount(r *http.Request) (*server.Account, error) {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+
+	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting account of a user %s: %v", jwtClaims.UserId, err)
+	}
+
+	return account, nil
+}
+
+func toRouteResponse(account *server.Account, route *server.Route) *RouteResponse {
+	rr := RouteResponse{
+		ID:          route.ID,
+		Description: route.Description,
+		Prefix:      route.Prefix,
+		Peer:        RoutePeerResponse{Key: route.Peer},
+		Groups:      []RuleGroupResponse{},
+		Metric:      route.Metric,
+		Enabled:     route.Enabled,
+	}
+
+	if peer, ok := account.Peers[route.Peer]; ok {
+		rr.Peer.Name = peer.Name
+		rr.Peer.IP = peer.IP.String()
+	}
+
+	for _, gid := range route.Groups {
+		if group, ok := account.Groups[gid]; ok {
+			rr.Groups = append(rr.Groups, RuleGroupResponse{
+				ID:         group.ID,
+				Name:       group.Name,
+				PeersCount: len(group.Peers),
+			})
+		}
+	}
+
+	return &rr
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+)
+
+const testRoutingPeerKey = "LLHf3Ma6z6mdLbriAJbqhX7+nM/B71lgw2+91q3LfhU="
+
+func initRoutesTestData() *Routes {
+	return &Routes{
+		accountManager: &mock_server.MockAccountManager{
+			SaveRouteFunc: func(_, _ string, route *server.Route) error {
+				if route.Prefix == "invalid" {
+					return status.Errorf(codes.InvalidArgument, "invalid route prefix %s", route.Prefix)
+				}
+				return nil
+			},
+			GetRouteFunc: func(_, routeID string) (*server.Route, error) {
+				if routeID != "idoftheroute" {
+					return nil, status.Errorf(codes.NotFound, "route with ID %s not found", routeID)
+				}
+				return &server.Route{
+					ID:          "idoftheroute",
+					Description: "Office",
+					Prefix:      "192.168.10.0/24",
+					Peer:        testRoutingPeerKey,
+					Groups:      []string{"idofthegroup"},
+					Metric:      10,
+					Enabled:     true,
+				}, nil
+			},
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:     claims.AccountId,
+					Domain: "hotmail.com",
+					Peers: map[string]*server.Peer{
+						testRoutingPeerKey: {Key: testRoutingPeerKey, Name: "office-gateway", IP: net.ParseIP("100.64.0.10")},
+					},
+					Groups: map[string]*server.Group{
+						"idofthegroup": {ID: "idofthegroup", Name: "Office", Peers: []string{testRoutingPeerKey}},
+					},
+				}, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestRoutesGetRoute(t *testing.T) {
+	tt := []struct {
+		name           string
+		expectedStatus int
+		expectedBody   bool
+		requestPath    string
+	}{
+		{
+			name:           "GetRoute OK",
+			expectedBody:   true,
+			requestPath:    "/api/routes/idoftheroute",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "GetRoute not found",
+			requestPath:    "/api/routes/notexists",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	p := initRoutesTestData()
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, tc.requestPath, nil)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/routes/{id}", p.GetRouteHandler).Methods("GET")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v",
+					status, tc.expectedStatus)
+				return
+			}
+
+			if !tc.expectedBody {
+				return
+			}
+
+			content, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("I don't know what I expected; %v", err)
+			}
+
+			var got RouteResponse
+			if err = json.Unmarshal(content, &got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			assert.Equal(t, got.ID, "idoftheroute")
+			assert.Equal(t, got.Prefix, "192.168.10.0/24")
+			assert.Equal(t, got.Peer.Name, "office-gateway")
+			assert.Equal(t, got.Peer.IP, "100.64.0.10")
+			assert.Equal(t, len(got.Groups), 1)
+			assert.Equal(t, got.Groups[0].Name, "Office")
+		})
+	}
+}
+
+func TestRoutesSaveRoute(t *testing.T) {
+	tt := []struct {
+		name           string
+		expectedStatus int
+		expectedID     string
+		requestType    string
+		requestBody    io.Reader
+	}{
+		{
+			name:        "SaveRoute POST OK",
+			requestType: http.MethodPost,
+			requestBody: bytes.NewBuffer(
+				[]byte(`{"Prefix":"192.168.10.0/24","Peer":"` + testRoutingPeerKey + `","Groups":["idofthegroup"],"Enabled":true}`)),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:        "SaveRoute PUT OK",
+			requestType: http.MethodPut,
+			requestBody: bytes.NewBuffer(
+				[]byte(`{"ID":"id-existed","Prefix":"192.168.10.0/24","Peer":"` + testRoutingPeerKey + `","Enabled":true}`)),
+			expectedStatus: http.StatusOK,
+			expectedID:     "id-existed",
+		},
+		{
+			name:           "SaveRoute invalid prefix",
+			requestType:    http.MethodPost,
+			requestBody:    bytes.NewBuffer([]byte(`{"Prefix":"invalid","Peer":"` + testRoutingPeerKey + `"}`)),
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	p := initRoutesTestData()
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.requestType, "/api/routes", tc.requestBody)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/routes", p.CreateOrUpdateRouteHandler).Methods("PUT", "POST")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			content, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("I don't know what I expected; %v", err)
+			}
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v, content: %s",
+					status, tc.expectedStatus, string(content))
+				return
+			}
+
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			got := &RouteResponse{}
+			if err = json.Unmarshal(content, &got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			if tc.expectedID != "" {
+				assert.Equal(t, got.ID, tc.expectedID)
+			} else {
+				assert.Equal(t, got.ID != "", true)
+			}
+			assert.Equal(t, got.Prefix, "192.168.10.0/24")
+			assert.Equal(t, got.Peer.Name, "office-gateway")
+			assert.Equal(t, got.Enabled, true)
+		})
+	}
+}
@@ -8,9 +8,10 @@ import (
 	"github.com/netbirdio/netbird/management/server"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
 	"github.com/rs/xid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/gorilla/mux"
-	log "github.com/sirupsen/logrus"
 )
 
 const FlowBidirectString = "bidirect"
@@ -21,7 +22,10 @@ type RuleResponse struct {
 	Name        string
 	Source      []RuleGroupResponse
 	Destination []RuleGroupResponse
-	Flow        string
+	// SourceTags and DestinationTags are the tags selecting the peers in addition to the groups
+	SourceTags      []string
+	DestinationTags []string
+	Flow            string
 }
 
 // RuleGroupResponse is a response sent to the client
@@ -37,7 +41,10 @@ type RuleRequest struct {
 	Name        string
 	Source      []string
 	Destination []string
-	Flow        string
+	// SourceTags and DestinationTags optionally select the peers having any of the tags in addition to the groups
+	SourceTags      []string
+	DestinationTags []string
+	Flow            string
 }
 
 // Rules is a handler that returns rules of the account
@@ -90,10 +97,12 @@ func (h *Rules) CreateOrUpdateRuleHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	rule := server.Rule{
-		ID:          req.ID,
-		Name:        req.Name,
-		Source:      req.Source,
-		Destination: req.Destination,
+		ID:              req.ID,
+		Name:            req.Name,
+		Source:          req.Source,
+		Destination:     req.Destination,
+		SourceTags:      req.SourceTags,
+		DestinationTags: req.DestinationTags,
 	}
 
 	switch req.Flow {
@@ -104,7 +113,12 @@ func (h *Rules) CreateOrUpdateRuleHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if err := h.accountManager.SaveRule(account.Id, &rule); err != nil {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.SaveRule(account.Id, jwtClaims.UserId, &rule); err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
 		log.Errorf("failed updating rule %s under account %s %v", req.ID, account.Id, err)
 		http.Redirect(w, r, "/", http.StatusInternalServerError)
 		return
@@ -127,7 +141,8 @@ func (h *Rules) DeleteRuleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.accountManager.DeleteRule(aID, rID); err != nil {
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	if err := h.accountManager.DeleteRule(aID, jwtClaims.UserId, rID); err != nil {
 		log.Errorf("failed delete rule %s under account %s %v", rID, aID, err)
 		http.Redirect(w, r, "/", http.StatusInternalServerError)
 		return
@@ -176,8 +191,10 @@ func (h *Rules) getRuleAccount(r *http.Request) (*server.Account, error) {
 
 func toRuleResponse(account *server.Account, rule *server.Rule) *RuleResponse {
 	gr := RuleResponse{
-		ID:   rule.ID,
-		Name: rule.Name,
+		ID:              rule.ID,
+		Name:            rule.Name,
+		SourceTags:      append([]string{}, rule.SourceTags...),
+		DestinationTags: append([]string{}, rule.DestinationTags...),
 	}
 
 	switch rule.Flow {
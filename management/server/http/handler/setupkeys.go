@@ -10,7 +10,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/netbirdio/netbird/management/server"
 	"github.com/netbirdio/netbird/util"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -19,6 +18,7 @@ import (
 type SetupKeys struct {
 	accountManager server.AccountManager
 	authAudience   string
+	jwtExtractor   jwtclaims.ClaimsExtractor
 }
 
 // SetupKeyResponse is a response sent to the client
@@ -33,6 +33,8 @@ type SetupKeyResponse struct {
 	UsedTimes int
 	LastUsed  time.Time
 	State     string
+	// UsageLimit is the number of peers a reusable key can register, 0 means unlimited
+	UsageLimit int
 }
 
 // SetupKeyRequest is a request sent by client. This object contains fields that can be modified
@@ -41,12 +43,15 @@ type SetupKeyRequest struct {
 	Type      server.SetupKeyType
 	ExpiresIn *util.Duration
 	Revoked   bool
+	// UsageLimit is the number of peers a new reusable key can register, 0 means unlimited
+	UsageLimit int
 }
 
 func NewSetupKeysHandler(accountManager server.AccountManager, authAudience string) *SetupKeys {
 	return &SetupKeys{
 		accountManager: accountManager,
 		authAudience:   authAudience,
+		jwtExtractor:   *jwtclaims.NewClaimsExtractor(nil),
 	}
 }
 
@@ -58,17 +63,22 @@ func (h *SetupKeys) updateKey(accountId string, keyId string, w http.ResponseWri
 		return
 	}
 
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
 	var key *server.SetupKey
 	if req.Revoked {
 		//handle only if being revoked, don't allow to enable key again for now
-		key, err = h.accountManager.RevokeSetupKey(accountId, keyId)
+		key, err = h.accountManager.RevokeSetupKey(accountId, keyId, jwtClaims.UserId)
 		if err != nil {
+			if errStatus, ok := status.FromError(err); ok && errStatus.Code() == codes.NotFound {
+				http.Error(w, "setup key not found", http.StatusNotFound)
+				return
+			}
 			http.Error(w, "failed revoking key", http.StatusInternalServerError)
 			return
 		}
 	}
 	if len(req.Name) != 0 {
-		key, err = h.accountManager.RenameSetupKey(accountId, keyId, req.Name)
+		key, err = h.accountManager.RenameSetupKey(accountId, keyId, req.Name, jwtClaims.UserId)
 		if err != nil {
 			http.Error(w, "failed renaming key", http.StatusInternalServerError)
 			return
@@ -108,13 +118,18 @@ func (h *SetupKeys) createKey(accountId string, w http.ResponseWriter, r *http.R
 		return
 	}
 
-	setupKey, err := h.accountManager.AddSetupKey(accountId, req.Name, req.Type, req.ExpiresIn)
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	setupKey, err := h.accountManager.AddSetupKey(accountId, req.Name, req.Type, req.ExpiresIn, req.UsageLimit, jwtClaims.UserId)
 	if err != nil {
 		errStatus, ok := status.FromError(err)
 		if ok && errStatus.Code() == codes.NotFound {
 			http.Error(w, "account not found", http.StatusNotFound)
 			return
 		}
+		if ok && errStatus.Code() == codes.InvalidArgument {
+			http.Error(w, errStatus.Message(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "failed adding setup key", http.StatusInternalServerError)
 		return
 	}
@@ -123,8 +138,7 @@ func (h *SetupKeys) createKey(accountId string, w http.ResponseWriter, r *http.R
 }
 
 func (h *SetupKeys) getSetupKeyAccount(r *http.Request) (*server.Account, error) {
-	extractor := jwtclaims.NewClaimsExtractor(nil)
-	jwtClaims := extractor.ExtractClaimsFromRequestContext(r, h.authAudience)
+	jwtClaims := h.jwtExtractor.ExtractClaimsFromRequestContext(r, h.authAudience)
 
 	account, err := h.accountManager.GetAccountWithAuthorizationClaims(jwtClaims)
 	if err != nil {
@@ -175,11 +189,18 @@ func (h *SetupKeys) GetKeys(w http.ResponseWriter, r *http.Request) {
 		h.createKey(account.Id, w, r)
 		return
 	case http.MethodGet:
+		keys, err := h.accountManager.ListSetupKeys(account.Id)
+		if err != nil {
+			log.Errorf("failed listing setup keys of account %s: %v", account.Id, err)
+			http.Redirect(w, r, "/", http.StatusInternalServerError)
+			return
+		}
+
 		w.WriteHeader(200)
 		w.Header().Set("Content-Type", "application/json")
 
 		respBody := []*SetupKeyResponse{}
-		for _, key := range account.SetupKeys {
+		for _, key := range keys {
 			respBody = append(respBody, toResponseBody(key))
 		}
 
@@ -216,15 +237,16 @@ func toResponseBody(key *server.SetupKey) *SetupKeyResponse {
 		state = "valid"
 	}
 	return &SetupKeyResponse{
-		Id:        key.Id,
-		Key:       key.Key,
-		Name:      key.Name,
-		Expires:   key.ExpiresAt,
-		Type:      key.Type,
-		Valid:     key.IsValid(),
-		Revoked:   key.Revoked,
-		UsedTimes: key.UsedTimes,
-		LastUsed:  key.LastUsed,
-		State:     state,
+		Id:         key.Id,
+		Key:        key.Key,
+		Name:       key.Name,
+		Expires:    key.ExpiresAt,
+		Type:       key.Type,
+		Valid:      key.IsValid(),
+		Revoked:    key.Revoked,
+		UsedTimes:  key.UsedTimes,
+		LastUsed:   key.LastUsed,
+		State:      state,
+		UsageLimit: key.UsageLimit,
 	}
 }
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/magiconair/properties/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/jwtclaims"
+	"github.com/netbirdio/netbird/management/server/mock_server"
+	"github.com/netbirdio/netbird/util"
+)
+
+func initSetupKeysTestData(keys ...*server.SetupKey) *SetupKeys {
+	accountKeys := make(map[string]*server.SetupKey)
+	for _, key := range keys {
+		accountKeys[key.Key] = key
+	}
+	return &SetupKeys{
+		accountManager: &mock_server.MockAccountManager{
+			GetAccountWithAuthorizationClaimsFunc: func(claims jwtclaims.AuthorizationClaims) (*server.Account, error) {
+				return &server.Account{
+					Id:        claims.AccountId,
+					Domain:    "hotmail.com",
+					SetupKeys: accountKeys,
+				}, nil
+			},
+			GetAccountByIdFunc: func(accountId string) (*server.Account, error) {
+				return &server.Account{
+					Id:        accountId,
+					Domain:    "hotmail.com",
+					SetupKeys: accountKeys,
+				}, nil
+			},
+			AddSetupKeyFunc: func(accountId string, keyName string, keyType server.SetupKeyType, expiresIn *util.Duration,
+				usageLimit int, userID string) (*server.SetupKey, error) {
+				if usageLimit < 0 {
+					return nil, status.Errorf(codes.InvalidArgument, "setup key usage limit can't be negative")
+				}
+				return server.GenerateSetupKey(keyName, keyType, time.Hour, usageLimit), nil
+			},
+			RevokeSetupKeyFunc: func(accountId string, keyId string, userID string) (*server.SetupKey, error) {
+				for _, key := range accountKeys {
+					if key.Id == keyId {
+						revoked := key.Copy()
+						revoked.Revoked = true
+						return revoked, nil
+					}
+				}
+				return nil, status.Errorf(codes.NotFound, "unknown setupKey %s", keyId)
+			},
+			ListSetupKeysFunc: func(accountId string) ([]*server.SetupKey, error) {
+				return keys, nil
+			},
+		},
+		authAudience: "",
+		jwtExtractor: jwtclaims.ClaimsExtractor{
+			ExtractClaimsFromRequestContext: func(r *http.Request, authAudiance string) jwtclaims.AuthorizationClaims {
+				return jwtclaims.AuthorizationClaims{
+					UserId:    "test_user",
+					Domain:    "hotmail.com",
+					AccountId: "test_id",
+				}
+			},
+		},
+	}
+}
+
+func TestSetupKeysHandler(t *testing.T) {
+	usedKey := server.GenerateSetupKey("used", server.SetupKeyReusable, time.Hour, 2)
+	usedKey.UsedTimes = 2
+	usedKey.LastUsed = time.Now().UTC().Truncate(time.Second)
+
+	tt := []struct {
+		name             string
+		requestType      string
+		requestPath      string
+		requestBody      io.Reader
+		expectedStatus   int
+		expectedResponse *SetupKeyResponse
+	}{
+		{
+			name:           "CreateSetupKey",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/setup-keys",
+			requestBody:    bytes.NewBufferString(`{"Name":"limited","Type":"reusable","UsageLimit":5}`),
+			expectedStatus: http.StatusOK,
+			expectedResponse: &SetupKeyResponse{
+				Name:       "limited",
+				Type:       server.SetupKeyReusable,
+				Valid:      true,
+				State:      "valid",
+				UsageLimit: 5,
+			},
+		},
+		{
+			name:           "CreateSetupKeyNegativeUsageLimit",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/setup-keys",
+			requestBody:    bytes.NewBufferString(`{"Name":"limited","Type":"reusable","UsageLimit":-1}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "CreateSetupKeyUnknownType",
+			requestType:    http.MethodPost,
+			requestPath:    "/api/setup-keys",
+			requestBody:    bytes.NewBufferString(`{"Name":"key","Type":"unknown"}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "GetSetupKey",
+			requestType:    http.MethodGet,
+			requestPath:    "/api/setup-keys/" + usedKey.Id,
+			expectedStatus: http.StatusOK,
+			expectedResponse: &SetupKeyResponse{
+				Name:       "used",
+				Type:       server.SetupKeyReusable,
+				Valid:      false,
+				State:      "overused",
+				UsedTimes:  2,
+				LastUsed:   usedKey.LastUsed,
+				UsageLimit: 2,
+			},
+		},
+		{
+			name:           "RevokeSetupKey",
+			requestType:    http.MethodPut,
+			requestPath:    "/api/setup-keys/" + usedKey.Id,
+			requestBody:    bytes.NewBufferString(`{"Revoked":true}`),
+			expectedStatus: http.StatusOK,
+			expectedResponse: &SetupKeyResponse{
+				Name:       "used",
+				Type:       server.SetupKeyReusable,
+				Valid:      false,
+				Revoked:    true,
+				State:      "revoked",
+				UsedTimes:  2,
+				LastUsed:   usedKey.LastUsed,
+				UsageLimit: 2,
+			},
+		},
+		{
+			name:           "RevokeUnknownSetupKey",
+			requestType:    http.MethodPut,
+			requestPath:    "/api/setup-keys/unknown",
+			requestBody:    bytes.NewBufferString(`{"Revoked":true}`),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	h := initSetupKeysTestData(usedKey)
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.requestType, tc.requestPath, tc.requestBody)
+
+			router := mux.NewRouter()
+			router.HandleFunc("/api/setup-keys", h.GetKeys).Methods("GET", "POST")
+			router.HandleFunc("/api/setup-keys/{id}", h.HandleKey).Methods("GET", "PUT")
+			router.ServeHTTP(recorder, req)
+
+			res := recorder.Result()
+			defer res.Body.Close()
+
+			content, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("I don't know what I expected; %v", err)
+			}
+
+			if status := recorder.Code; status != tc.expectedStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v, content: %s",
+					status, tc.expectedStatus, string(content))
+			}
+
+			if tc.expectedResponse == nil {
+				return
+			}
+
+			got := &SetupKeyResponse{}
+			if err = json.Unmarshal(content, got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+
+			assert.Equal(t, got.Name, tc.expectedResponse.Name)
+			assert.Equal(t, got.Type, tc.expectedResponse.Type)
+			assert.Equal(t, got.Valid, tc.expectedResponse.Valid)
+			assert.Equal(t, got.Revoked, tc.expectedResponse.Revoked)
+			assert.Equal(t, got.State, tc.expectedResponse.State)
+			assert.Equal(t, got.UsedTimes, tc.expectedResponse.UsedTimes)
+			assert.Equal(t, got.LastUsed.Equal(tc.expectedResponse.LastUsed), true)
+			assert.Equal(t, got.UsageLimit, tc.expectedResponse.UsageLimit)
+		})
+	}
+}
+
+func TestGetSetupKeys(t *testing.T) {
+	usedKey := server.GenerateSetupKey("used", server.SetupKeyReusable, time.Hour, 0)
+	usedKey.UsedTimes = 7
+	revokedKey := server.GenerateSetupKey("revoked", server.SetupKeyOneOff, time.Hour, 0)
+	revokedKey.Revoked = true
+
+	h := initSetupKeysTestData(usedKey, revokedKey)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/setup-keys", nil)
+	h.GetKeys(recorder, req)
+
+	res := recorder.Result()
+	defer res.Body.Close()
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", recorder.Code, http.StatusOK)
+	}
+
+	var got []*SetupKeyResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("Sent content is not in correct json format; %v", err)
+	}
+
+	assert.Equal(t, len(got), 2)
+	assert.Equal(t, got[0].Name, "used")
+	assert.Equal(t, got[0].UsedTimes, 7)
+	assert.Equal(t, got[0].State, "valid")
+	assert.Equal(t, got[1].Name, "revoked")
+	assert.Equal(t, got[1].State, "revoked")
+}
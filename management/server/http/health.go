@@ -0,0 +1,79 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	s "github.com/netbirdio/netbird/management/server"
+)
+
+const (
+	// healthLivePath is the path of the liveness endpoint
+	healthLivePath = "/health/live"
+	// healthReadyPath is the path of the readiness endpoint
+	healthReadyPath = "/health/ready"
+)
+
+// healthResponse is the body of the health endpoints
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// newHealthHandler returns a handler of the liveness and readiness endpoints.
+// The endpoints respond with 200 when the check passes and 503 otherwise
+func newHealthHandler(checker *s.HealthChecker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthLivePath, healthCheckHandler(checker.Alive))
+	mux.HandleFunc(healthReadyPath, healthCheckHandler(checker.Ready))
+	return mux
+}
+
+func healthCheckHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		code := http.StatusOK
+		resp := healthResponse{Status: "ok"}
+		if err := check(); err != nil {
+			log.Warnf("health check %s failed: %v", r.URL.Path, err)
+			code = http.StatusServiceUnavailable
+			resp = healthResponse{Status: "unavailable", Error: err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Errorf("failed encoding health response: %v", err)
+		}
+	}
+}
+
+// withHealth serves the health endpoints in front of the handler bypassing its middlewares
+func withHealth(handler http.Handler, checker *s.HealthChecker) http.Handler {
+	if checker == nil {
+		return handler
+	}
+
+	mux := http.NewServeMux()
+	healthHandler := newHealthHandler(checker)
+	mux.Handle(healthLivePath, healthHandler)
+	mux.Handle(healthReadyPath, healthHandler)
+	mux.Handle("/", handler)
+	return mux
+}
+
+// NewHealthServer creates an HTTP server serving only the health endpoints on a dedicated address
+func NewHealthServer(address string, checker *s.HealthChecker) *http.Server {
+	return &http.Server{
+		Addr:         address,
+		Handler:      newHealthHandler(checker),
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+	}
+}
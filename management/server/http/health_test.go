@@ -0,0 +1,57 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+
+	s "github.com/netbirdio/netbird/management/server"
+)
+
+func TestWithHealth(t *testing.T) {
+	store, err := s.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := s.NewHealthChecker(store, s.NewPeersUpdateManager())
+
+	// the API handler requires authentication
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	handler := withHealth(api, checker)
+
+	tt := []struct {
+		name           string
+		requestType    string
+		requestPath    string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "Liveness", requestType: http.MethodGet, requestPath: healthLivePath, expectedStatus: http.StatusOK, expectedBody: "ok"},
+		{name: "Readiness", requestType: http.MethodGet, requestPath: healthReadyPath, expectedStatus: http.StatusOK, expectedBody: "ok"},
+		{name: "WrongMethod", requestType: http.MethodPost, requestPath: healthReadyPath, expectedStatus: http.StatusMethodNotAllowed},
+		{name: "API", requestType: http.MethodGet, requestPath: "/api/peers", expectedStatus: http.StatusUnauthorized},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tc.requestType, tc.requestPath, nil))
+
+			assert.Equal(t, recorder.Code, tc.expectedStatus)
+			if tc.expectedBody == "" {
+				return
+			}
+
+			got := &healthResponse{}
+			if err := json.NewDecoder(recorder.Body).Decode(got); err != nil {
+				t.Fatalf("Sent content is not in correct json format; %v", err)
+			}
+			assert.Equal(t, got.Status, tc.expectedBody)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+package http
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the HTTP API of the Management service, its level is set by the mgmt component level
+var log = util.Logger("mgmt")
@@ -9,6 +9,11 @@ import (
 
 type IsUserAdminFunc func(claims jwtclaims.AuthorizationClaims) (bool, error)
 
+// userWritablePaths can be modified by any user, e.g. approving the device authorization of a peer registered by the user
+var userWritablePaths = map[string]bool{
+	"/api/device-auth": true,
+}
+
 // AccessControll middleware to restrict to make POST/PUT/DELETE requests by admin only
 type AccessControll struct {
 	jwtExtractor jwtclaims.ClaimsExtractor
@@ -37,7 +42,7 @@ func (a *AccessControll) Handler(h http.Handler) http.Handler {
 
 		}
 
-		if !ok {
+		if !ok && !userWritablePaths[r.URL.Path] {
 			switch r.Method {
 			case http.MethodDelete, http.MethodPost, http.MethodPatch, http.MethodPut:
 				http.Error(w, "user is not admin", http.StatusForbidden)
@@ -5,8 +5,13 @@ import (
 	"errors"
 	"github.com/golang-jwt/jwt"
 	"net/http"
+	"time"
 )
 
+// defaultLeeway is a clock skew tolerated between the identity provider and the Management service
+// when validating the token expiration and issue times
+const defaultLeeway = time.Minute
+
 //Jwks is a collection of JSONWebKeys obtained from Config.HttpServerConfig.AuthKeysLocation
 type Jwks struct {
 	Keys []JSONWebKeys `json:"keys"`
@@ -45,14 +50,14 @@ func NewJwtMiddleware(issuer string, audience string, keysLocation string) (*JWT
 
 			cert, err := getPemCert(token, keys)
 			if err != nil {
-				panic(err.Error())
+				return nil, err
 			}
 
-			result, _ := jwt.ParseRSAPublicKeyFromPEM([]byte(cert))
-			return result, nil
+			return jwt.ParseRSAPublicKeyFromPEM([]byte(cert))
 		},
 		SigningMethod:       jwt.SigningMethodRS256,
 		EnableAuthOnOptions: false,
+		Leeway:              defaultLeeway,
 	}), nil
 }
 
@@ -78,7 +83,7 @@ func getPemCert(token *jwt.Token, jwks *Jwks) (string, error) {
 	cert := ""
 
 	for k := range jwks.Keys {
-		if token.Header["kid"] == jwks.Keys[k].Kid {
+		if token.Header["kid"] == jwks.Keys[k].Kid && len(jwks.Keys[k].X5c) > 0 {
 			cert = "-----BEGIN CERTIFICATE-----\n" + jwks.Keys[k].X5c[0] + "\n-----END CERTIFICATE-----"
 		}
 	}
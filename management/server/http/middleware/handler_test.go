@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testIssuer   = "https://idp.example.com/"
+	testAudience = "netbird-test"
+	testKid      = "test-key"
+)
+
+// startJWKSServer starts a fake identity provider serving a JWKS with a single self-signed certificate of the key
+func startJWKSServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := Jwks{Keys: []JSONWebKeys{{
+		Kty: "RSA",
+		Kid: testKid,
+		Use: "sig",
+		X5c: []string{base64.StdEncoding.EncodeToString(cert)},
+	}}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(jwks)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJwtMiddleware_ValidateAndParse(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := startJWKSServer(t, key)
+
+	m, err := NewJwtMiddleware(testIssuer, testAudience, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	claimsWith := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss": testIssuer,
+			"aud": testAudience,
+			"sub": "test-user",
+			"iat": now.Unix(),
+			"exp": now.Add(time.Hour).Unix(),
+		}
+		for k, v := range overrides {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{
+			name:  "Valid Token",
+			token: signToken(t, key, testKid, claimsWith(nil)),
+			valid: true,
+		},
+		{
+			name:  "Expired Token",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"exp": now.Add(-2 * defaultLeeway).Unix()})),
+		},
+		{
+			name:  "Expired Token Within Clock Skew",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"exp": now.Add(-defaultLeeway / 2).Unix()})),
+			valid: true,
+		},
+		{
+			name:  "Issued In The Future Within Clock Skew",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"iat": now.Add(defaultLeeway / 2).Unix()})),
+			valid: true,
+		},
+		{
+			name:  "Not Valid Yet",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"nbf": now.Add(2 * defaultLeeway).Unix()})),
+		},
+		{
+			name:  "Invalid Audience",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"aud": "other"})),
+		},
+		{
+			name:  "Invalid Issuer",
+			token: signToken(t, key, testKid, claimsWith(jwt.MapClaims{"iss": "https://other.example.com/"})),
+		},
+		{
+			name:  "Unknown Key ID",
+			token: signToken(t, key, "unknown", claimsWith(nil)),
+		},
+		{
+			name:  "Invalid Signature",
+			token: signToken(t, otherKey, testKid, claimsWith(nil)),
+		},
+		{
+			name:  "Empty Token",
+			token: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := m.ValidateAndParse(tc.token)
+			if tc.valid {
+				if err != nil {
+					t.Fatalf("expected a valid token, got error: %v", err)
+				}
+				if token.Claims.(jwt.MapClaims)["sub"] != "test-user" {
+					t.Errorf("expected the token claims to be parsed")
+				}
+				return
+			}
+			if err == nil {
+				t.Errorf("expected an invalid token error")
+			}
+		})
+	}
+}
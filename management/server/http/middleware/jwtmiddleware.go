@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"time"
 )
 
 // A function called whenever an error is encountered
@@ -50,6 +51,10 @@ type Options struct {
 	// Important to avoid security issues described here: https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
 	// Default: nil
 	SigningMethod jwt.SigningMethod
+	// Leeway is a time margin applied when validating the exp, iat and nbf claims
+	// to account for the clock skew between the token issuer and this service
+	// Default: 0
+	Leeway time.Duration
 }
 
 type JWTMiddleware struct {
@@ -219,8 +224,9 @@ func (m *JWTMiddleware) ValidateAndParse(token string) (*jwt.Token, error) {
 		return nil, fmt.Errorf(errorMsg)
 	}
 
-	// Now parse the token
-	parsedToken, err := jwt.Parse(token, m.Options.ValidationKeyGetter)
+	// Now parse the token. Time based claims are validated below taking the leeway into account
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	parsedToken, err := parser.Parse(token, m.Options.ValidationKeyGetter)
 
 	// Check if there was an error in parsing...
 	if err != nil {
@@ -236,6 +242,14 @@ func (m *JWTMiddleware) ValidateAndParse(token string) (*jwt.Token, error) {
 		return nil, fmt.Errorf("error validating token algorithm: %s", errorMsg)
 	}
 
+	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok {
+		err = validateTimeClaims(claims, m.Options.Leeway)
+		if err != nil {
+			m.logf("error validating token claims: %v", err)
+			return nil, fmt.Errorf("error validating token claims: %w", err)
+		}
+	}
+
 	// Check if the parsed token is valid...
 	if !parsedToken.Valid {
 		errorMsg := "token is invalid"
@@ -245,3 +259,18 @@ func (m *JWTMiddleware) ValidateAndParse(token string) (*jwt.Token, error) {
 
 	return parsedToken, nil
 }
+
+// validateTimeClaims validates the exp, iat and nbf claims of the token allowing the leeway for clock skew
+func validateTimeClaims(claims jwt.MapClaims, leeway time.Duration) error {
+	now := jwt.TimeFunc()
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return errors.New("token used before issued")
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
@@ -11,7 +11,6 @@ import (
 	"github.com/netbirdio/netbird/management/server/http/handler"
 	"github.com/netbirdio/netbird/management/server/http/middleware"
 	"github.com/rs/cors"
-	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/acme/autocert"
 )
 
@@ -21,6 +20,8 @@ type Server struct {
 	certManager    *autocert.Manager
 	tlsConfig      *tls.Config
 	accountManager s.AccountManager
+	// healthChecker serves the health endpoints on this server if set
+	healthChecker *s.HealthChecker
 }
 
 // NewHttpsServer creates a new HTTPs server (with HTTPS support) and a certManager that is responsible for generating and renewing Let's Encrypt certificate
@@ -70,6 +71,12 @@ func NewHttpServer(config *s.HttpServerConfig, accountManager s.AccountManager)
 	return NewHttpsServer(config, nil, accountManager)
 }
 
+// ServeHealth serves the liveness and readiness endpoints of the checker on this server without authentication.
+// Has to be called before Start
+func (s *Server) ServeHealth(checker *s.HealthChecker) {
+	s.healthChecker = checker
+}
+
 // Stop stops the http server
 func (s *Server) Stop(ctx context.Context) error {
 	err := s.server.Shutdown(ctx)
@@ -101,11 +108,14 @@ func (s *Server) Start() error {
 
 	groupsHandler := handler.NewGroups(s.accountManager, s.config.AuthAudience)
 	rulesHandler := handler.NewRules(s.accountManager, s.config.AuthAudience)
+	routesHandler := handler.NewRoutes(s.accountManager, s.config.AuthAudience)
 	peersHandler := handler.NewPeers(s.accountManager, s.config.AuthAudience)
 	keysHandler := handler.NewSetupKeysHandler(s.accountManager, s.config.AuthAudience)
 	r.HandleFunc("/api/peers", peersHandler.GetPeers).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/peers/{id}", peersHandler.HandlePeer).
 		Methods("GET", "PUT", "DELETE", "OPTIONS")
+	r.HandleFunc("/api/peers/{id}/reachability", peersHandler.GetPeerReachability).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/peers/{id}/connectivity", peersHandler.GetPeerConnectivity).Methods("GET", "OPTIONS")
 
 	userHandler := handler.NewUserHandler(s.accountManager, s.config.AuthAudience)
 	r.HandleFunc("/api/users", userHandler.GetUsers).Methods("GET", "OPTIONS")
@@ -123,12 +133,43 @@ func (s *Server) Start() error {
 	r.HandleFunc("/api/rules/{id}", rulesHandler.GetRuleHandler).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/rules/{id}", rulesHandler.DeleteRuleHandler).Methods("DELETE", "OPTIONS")
 
+	r.HandleFunc("/api/routes", routesHandler.GetAllRoutesHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/routes", routesHandler.CreateOrUpdateRouteHandler).
+		Methods("POST", "PUT", "OPTIONS")
+	r.HandleFunc("/api/routes/{id}", routesHandler.GetRouteHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/routes/{id}", routesHandler.DeleteRouteHandler).Methods("DELETE", "OPTIONS")
+
 	r.HandleFunc("/api/groups", groupsHandler.GetAllGroupsHandler).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/groups", groupsHandler.CreateOrUpdateGroupHandler).
 		Methods("POST", "PUT", "OPTIONS")
 	r.HandleFunc("/api/groups/{id}", groupsHandler.GetGroupHandler).Methods("GET", "OPTIONS")
 	r.HandleFunc("/api/groups/{id}", groupsHandler.DeleteGroupHandler).Methods("DELETE", "OPTIONS")
-	http.Handle("/", r)
+
+	networkHandler := handler.NewNetwork(s.accountManager, s.config.AuthAudience)
+	r.HandleFunc("/api/network", networkHandler.GetNetworkHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/network", networkHandler.UpdateNetworkHandler).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/api/network/reservations", networkHandler.GetIPReservationsHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/network/reservations", networkHandler.SaveIPReservationHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/network/reservations/{id}", networkHandler.SaveIPReservationHandler).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/api/network/reservations/{id}", networkHandler.DeleteIPReservationHandler).
+		Methods("DELETE", "OPTIONS")
+
+	accountsHandler := handler.NewAccounts(s.accountManager, s.config.AuthAudience)
+	r.HandleFunc("/api/accounts/settings", accountsHandler.GetSettingsHandler).Methods("GET", "OPTIONS")
+	r.HandleFunc("/api/accounts/settings", accountsHandler.UpdateSettingsHandler).Methods("PUT", "OPTIONS")
+	r.HandleFunc("/api/accounts/{id}/export", accountsHandler.ExportHandler).Methods("POST", "OPTIONS")
+	r.HandleFunc("/api/accounts/{id}/import", accountsHandler.ImportHandler).Methods("POST", "OPTIONS")
+
+	eventsHandler := handler.NewEvents(s.accountManager, s.config.AuthAudience)
+	r.HandleFunc("/api/events", eventsHandler.GetEventsHandler).Methods("GET", "OPTIONS")
+
+	auditHandler := handler.NewAudit(s.accountManager, s.config.AuthAudience)
+	r.HandleFunc("/api/audit", auditHandler.GetAuditEventsHandler).Methods("GET", "OPTIONS")
+
+	deviceAuthHandler := handler.NewDeviceAuth(s.accountManager, s.config.AuthAudience)
+	r.HandleFunc("/api/device-auth", deviceAuthHandler.ApproveHandler).Methods("POST", "OPTIONS")
+	handler := withHealth(r, s.healthChecker)
+	s.server.Handler = handler
 
 	if s.certManager != nil {
 		// if HTTPS is enabled we reuse the listener from the cert manager
@@ -137,7 +178,7 @@ func (s *Server) Start() error {
 			"HTTPs server listening on %s with Let's Encrypt autocert configured",
 			listener.Addr(),
 		)
-		if err = http.Serve(listener, s.certManager.HTTPHandler(r)); err != nil {
+		if err = http.Serve(listener, s.certManager.HTTPHandler(handler)); err != nil {
 			log.Errorf("failed to serve https server: %v", err)
 			return err
 		}
@@ -149,7 +190,7 @@ func (s *Server) Start() error {
 		}
 		log.Infof("HTTPs server listening on %s", listener.Addr())
 
-		if err = http.Serve(listener, r); err != nil {
+		if err = http.Serve(listener, handler); err != nil {
 			log.Errorf("failed to serve https server: %v", err)
 			return err
 		}
@@ -0,0 +1,6 @@
+package idp
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the identity provider managers of the Management service, its level is set by the mgmt component level
+var log = util.Logger("mgmt")
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IPReservation reserves an IP of the account network for a peer (e.g. a gateway or a server) selected by its key or
+// its name. The peer is registered with the reserved IP and the IP isn't allocated to any other peer. A reservation by
+// the name is bound to the key of the first peer registered with the name
+type IPReservation struct {
+	// ID of the reservation
+	ID string
+
+	// Description visible in the UI
+	Description string
+
+	// PeerKey is the Wireguard key of the peer the IP is reserved for
+	PeerKey string
+
+	// PeerName is the name of the peer the IP is reserved for if the PeerKey is empty (the key is usually unknown
+	// before the peer is installed), matched case-insensitively
+	PeerName string
+
+	// IP is the reserved IP
+	IP net.IP
+}
+
+func (r *IPReservation) Copy() *IPReservation {
+	return &IPReservation{
+		ID:          r.ID,
+		Description: r.Description,
+		PeerKey:     r.PeerKey,
+		PeerName:    r.PeerName,
+		IP:          append(net.IP(nil), r.IP...),
+	}
+}
+
+// peer returns the key or the name of the peer the IP is reserved for
+func (r *IPReservation) peer() string {
+	if r.PeerKey != "" {
+		return r.PeerKey
+	}
+	return r.PeerName
+}
+
+// reservedFor checks whether the IP is reserved for the peer with the key or the name
+func (r *IPReservation) reservedFor(peerKey, peerName string) bool {
+	if r.PeerKey != "" {
+		return r.PeerKey == peerKey
+	}
+	return peerName != "" && strings.EqualFold(r.PeerName, peerName)
+}
+
+// getIPReservation returns the reservation of the peer with the key or the name, nil if there is none.
+// A reservation by the key takes precedence over a reservation by the name
+func (a *Account) getIPReservation(peerKey, peerName string) *IPReservation {
+	var byName *IPReservation
+	for _, reservation := range a.IPReservations {
+		if !reservation.reservedFor(peerKey, peerName) {
+			continue
+		}
+		if reservation.PeerKey != "" {
+			return reservation
+		}
+		byName = reservation
+	}
+	return byName
+}
+
+// ipAssigned checks whether the IP is assigned to a peer of the account
+func (a *Account) ipAssigned(ip net.IP) bool {
+	for _, peer := range a.Peers {
+		if peer.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIPReservation checks that the reserved IP is a host IP of the account network that is neither reserved
+// nor assigned to another peer, and that the peer has no other reservation. The IP is normalized to its 4-byte form
+func validateIPReservation(account *Account, reservation *IPReservation) error {
+	if (reservation.PeerKey == "") == (reservation.PeerName == "") {
+		return status.Errorf(codes.InvalidArgument, "either the key or the name of the peer has to be set")
+	}
+
+	ip := reservation.IP.To4()
+	if ip == nil || !isHostIP(account.Network.Net, ip) {
+		return status.Errorf(codes.InvalidArgument, "IP %s isn't a host of the account network %s",
+			reservation.IP, account.Network.Net.String())
+	}
+	reservation.IP = ip
+
+	for id, other := range account.IPReservations {
+		if id == reservation.ID {
+			continue
+		}
+		if other.IP.Equal(ip) {
+			return status.Errorf(codes.AlreadyExists, "IP %s is already reserved", ip)
+		}
+		if other.PeerKey == reservation.PeerKey && strings.EqualFold(other.PeerName, reservation.PeerName) {
+			return status.Errorf(codes.AlreadyExists, "peer %s has an IP reserved already", reservation.peer())
+		}
+	}
+
+	for _, peer := range account.Peers {
+		reservedFor := reservation.reservedFor(peer.Key, peer.Name)
+		if peer.IP.Equal(ip) && !reservedFor {
+			return status.Errorf(codes.AlreadyExists, "IP %s is already assigned to peer %s", ip, peer.Name)
+		}
+		// the IP of a registered peer is changed with UpdatePeerIP, the reservation only applies to the registration
+		if reservation.PeerKey != "" && reservedFor && !peer.IP.Equal(ip) {
+			return status.Errorf(codes.FailedPrecondition, "peer %s is registered with IP %s already", peer.Name, peer.IP)
+		}
+	}
+
+	return nil
+}
+
+// SaveIPReservation creates or updates an IP reservation of the account. The peers registered afterwards get the
+// reserved IP, the userID is the user who initiated the change
+func (am *DefaultAccountManager) SaveIPReservation(accountID, userID string, reservation *IPReservation) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "account not found")
+	}
+
+	err = validateIPReservation(account, reservation)
+	if err != nil {
+		return err
+	}
+
+	if account.IPReservations == nil {
+		account.IPReservations = map[string]*IPReservation{}
+	}
+
+	eventType := activity.IPReservationCreated
+	var before interface{}
+	if existing, exists := account.IPReservations[reservation.ID]; exists {
+		eventType = activity.IPReservationUpdated
+		before = existing
+	}
+	event := newAuditEvent(userID, reservation.ID, accountID, eventType,
+		map[string]string{"ip": reservation.IP.String(), "peer": reservation.peer()},
+		before, reservation)
+
+	account.IPReservations[reservation.ID] = reservation
+	return am.saveAccount(account, event)
+}
+
+// DeleteIPReservation removes an IP reservation of the account, the peer keeps the IP it has been registered with.
+// The userID is the user who initiated the removal
+func (am *DefaultAccountManager) DeleteIPReservation(accountID, userID, reservationID string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "account not found")
+	}
+
+	reservation, ok := account.IPReservations[reservationID]
+	if !ok {
+		return status.Errorf(codes.NotFound, "IP reservation with ID %s not found", reservationID)
+	}
+	delete(account.IPReservations, reservationID)
+
+	return am.saveAccount(account, newAuditEvent(userID, reservationID, accountID, activity.IPReservationDeleted,
+		map[string]string{"ip": reservation.IP.String(), "peer": reservation.peer()},
+		reservation, nil))
+}
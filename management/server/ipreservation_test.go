@@ -0,0 +1,158 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_IPReservation(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	ip := func(host byte) net.IP {
+		network := account.Network.Net.IP.To4()
+		return net.IPv4(network[0], network[1], network[2], host).To4()
+	}
+	newKey := func() string {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key.PublicKey().String()
+	}
+
+	existing, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: newKey(), Name: "existing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gatewayKey := newKey()
+	invalid := []*IPReservation{
+		{ID: "no-peer", IP: ip(100)},
+		{ID: "key-and-name", PeerKey: gatewayKey, PeerName: "gateway", IP: ip(100)},
+		{ID: "outside-network", PeerKey: gatewayKey, IP: net.ParseIP("192.0.2.1")},
+		{ID: "network-address", PeerKey: gatewayKey, IP: account.Network.Net.IP},
+	}
+	for _, reservation := range invalid {
+		err = manager.SaveIPReservation(account.Id, "account_creator", reservation)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting reservation %s to fail with InvalidArgument, got %v", reservation.ID, err)
+		}
+	}
+
+	err = manager.SaveIPReservation(account.Id, "account_creator", &IPReservation{ID: "taken", PeerKey: gatewayKey, IP: existing.IP})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting reserving the IP of another peer to fail with AlreadyExists, got %v", err)
+	}
+
+	// the lowest free IP is reserved, so it mustn't be allocated to the peers without a reservation
+	reservedIP := ip(existing.IP.To4()[3] + 1)
+	err = manager.SaveIPReservation(account.Id, "account_creator", &IPReservation{ID: "gateway", PeerKey: gatewayKey, IP: reservedIP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.SaveIPReservation(account.Id, "account_creator", &IPReservation{ID: "server", PeerName: "Server", IP: ip(200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.SaveIPReservation(account.Id, "account_creator", &IPReservation{ID: "duplicate", PeerName: "other", IP: ip(200)})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting reserving a reserved IP to fail with AlreadyExists, got %v", err)
+	}
+
+	other, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: newKey(), Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.IP.Equal(reservedIP) {
+		t.Errorf("expecting the reserved IP %s not to be allocated to another peer", reservedIP)
+	}
+	_, err = manager.AddPeer(setupKey.Key, "", &Peer{Key: newKey(), Name: "another", IP: ip(200)})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting the registration with a reserved IP to fail with AlreadyExists, got %v", err)
+	}
+
+	// the reserved IP takes precedence over the requested one
+	gateway, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: gatewayKey, Name: "gateway", IP: ip(150)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gateway.IP.Equal(reservedIP) {
+		t.Errorf("expecting the peer to be registered with the reserved IP %s, got %s", reservedIP, gateway.IP)
+	}
+
+	server, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: newKey(), Name: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !server.IP.Equal(ip(200)) {
+		t.Errorf("expecting the peer to be registered with the IP %s reserved for its name, got %s", ip(200), server.IP)
+	}
+
+	// the reservation by the name is bound to the first peer registered with the name
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bound := account.IPReservations["server"]; bound.PeerKey != server.Key || bound.PeerName != "" {
+		t.Errorf("expecting the reservation to be bound to the key %s, got key %q and name %q", server.Key,
+			bound.PeerKey, bound.PeerName)
+	}
+	sameName, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: newKey(), Name: "server"})
+	if err != nil {
+		t.Fatalf("expecting another peer with the same name to be registered, got %v", err)
+	}
+	if sameName.IP.Equal(ip(200)) {
+		t.Errorf("expecting the IP reserved for the first peer %s not to be allocated to another one", ip(200))
+	}
+
+	err = manager.DeleteIPReservation(account.Id, "account_creator", "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.DeleteIPReservation(account.Id, "account_creator", "server")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting deleting a deleted reservation to fail with NotFound, got %v", err)
+	}
+
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(account.IPReservations) != 1 || account.IPReservations["gateway"] == nil {
+		t.Fatalf("expecting the gateway reservation to be left, got %v", account.IPReservations)
+	}
+
+	// the reservation follows the peer replacing its key
+	newGatewayKey := newKey()
+	_, err = manager.ReplacePeerKey(gatewayKey, newGatewayKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.IPReservations["gateway"].PeerKey != newGatewayKey {
+		t.Errorf("expecting the reservation to be moved to the key %s, got %s", newGatewayKey,
+			account.IPReservations["gateway"].PeerKey)
+	}
+}
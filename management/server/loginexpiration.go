@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultLoginExpirationCheckInterval is an interval of the scans for the peers which login has expired
+const DefaultLoginExpirationCheckInterval = time.Minute
+
+// Clock provides the current time. Replaced in tests to simulate the time passing
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is a Clock returning the system time
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// loginExpired checks whether the login of a peer registered by a user has expired.
+// Peers registered with a setup key don't expire
+func (p *Peer) loginExpired(expiration time.Duration, now time.Time) bool {
+	if p.UserID == "" || expiration <= 0 {
+		return false
+	}
+	return now.After(p.LastLogin.Add(expiration))
+}
+
+// CheckPeerLogin returns a PermissionDenied error if the login of the peer has expired and it has to log in again
+func (am *DefaultAccountManager) CheckPeerLogin(peerKey string) error {
+	account, err := am.Store.GetPeerAccount(peerKey)
+	if err != nil {
+		return err
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	if peer.loginExpired(account.LoginExpiration, am.clock.Now()) {
+		return status.Errorf(codes.PermissionDenied, "login of peer %s has expired, please log in again", peerKey)
+	}
+
+	return nil
+}
+
+// MarkPeerLoggedIn records that the user that registered the peer has logged in with it, so its login expiration starts over.
+// A peer which login has expired is added back to the network maps of the other peers
+func (am *DefaultAccountManager) MarkPeerLoggedIn(peerKey string) error {
+	account, unlock, err := am.lockPeerAccount(peerKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.LastLogin = am.clock.Now().UTC()
+	if peerCopy.Status == nil || !peerCopy.Status.LoginExpired {
+		return am.Store.SavePeer(account.Id, peerCopy)
+	}
+
+	peerCopy.Status.LoginExpired = false
+	account.Peers[peerKey] = peerCopy
+	account.Network.IncSerial()
+	err = am.Store.SaveAccount(account)
+	if err != nil {
+		return err
+	}
+
+	return am.updateReachablePeers(account, peerKey)
+}
+
+// LoginPeerWithSetupKey logs a registered peer in again with a valid setup key of its account, e.g. once its login
+// has expired, so its login expiration starts over like with MarkPeerLoggedIn. The usage of the key isn't counted,
+// no peer is added
+func (am *DefaultAccountManager) LoginPeerWithSetupKey(setupKey string, peerKey string) error {
+	account, err := am.Store.GetPeerAccount(peerKey)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	key := getAccountSetupKeyByKey(account, strings.ToUpper(setupKey))
+	if key == nil {
+		return status.Errorf(codes.PermissionDenied, "setup key isn't a key of the account of peer %s", peerKey)
+	}
+	if !key.IsValid() {
+		return status.Errorf(codes.PermissionDenied, "setup key %s is expired or revoked", key.Name)
+	}
+
+	return am.MarkPeerLoggedIn(peerKey)
+}
+
+// UpdateAccountLoginExpiration sets the period after which the peers registered by users have to log in again, 0 disables it.
+// The peers that have never logged in since the expiration was disabled start their expiration period now
+func (am *DefaultAccountManager) UpdateAccountLoginExpiration(accountId string, expiration time.Duration, userID string) (*Account, error) {
+	if expiration < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "login expiration can't be negative")
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	settingEvent := newAuditEvent(userID, accountId, accountId, activity.AccountSettingsUpdated,
+		map[string]string{"setting": "login_expiration"},
+		map[string]time.Duration{"LoginExpiration": account.LoginExpiration}, map[string]time.Duration{"LoginExpiration": expiration})
+
+	now := am.clock.Now().UTC()
+	for _, peer := range account.Peers {
+		if peer.UserID != "" && peer.LastLogin.IsZero() {
+			peer.LastLogin = now
+		}
+	}
+	account.LoginExpiration = expiration
+
+	changed := am.updatePeersLoginExpired(account)
+	if len(changed) > 0 {
+		account.Network.IncSerial()
+	}
+
+	err = am.saveAccount(account, append([]*activity.Event{settingEvent}, loginExpiredEvents(account, changed)...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.notifyPeersLoginExpired(account, changed)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// ExpirePeerLogins marks the peers which login has expired and removes them from the network maps of the other peers
+func (am *DefaultAccountManager) ExpirePeerLogins() error {
+	for _, account := range am.Store.GetAllAccounts() {
+		// check a copy first to avoid locking the accounts without expired peers
+		if len(am.updatePeersLoginExpired(account.Copy())) == 0 {
+			continue
+		}
+
+		err := am.expireAccountPeerLogins(account.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (am *DefaultAccountManager) expireAccountPeerLogins(accountId string) error {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return err
+	}
+
+	changed := am.updatePeersLoginExpired(account)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, loginExpiredEvents(account, changed)...)
+	if err != nil {
+		return err
+	}
+
+	return am.notifyPeersLoginExpired(account, changed)
+}
+
+// updatePeersLoginExpired marks the peers of the account which login has expired or has been extended
+// (e.g. the login expiration setting has changed). Returns the keys of the changed peers sorted
+func (am *DefaultAccountManager) updatePeersLoginExpired(account *Account) []string {
+	now := am.clock.Now()
+
+	var changed []string
+	for key, peer := range account.Peers {
+		if peer.Status == nil {
+			peer.Status = &PeerStatus{}
+		}
+		expired := peer.loginExpired(account.LoginExpiration, now)
+		if peer.Status.LoginExpired == expired {
+			continue
+		}
+		peer.Status.LoginExpired = expired
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+
+	return changed
+}
+
+// loginExpiredEvents returns the audit events of the changed peers which login has expired
+func loginExpiredEvents(account *Account, changed []string) []*activity.Event {
+	var events []*activity.Event
+	for _, key := range changed {
+		peer := account.Peers[key]
+		if peer.Status.LoginExpired {
+			events = append(events, newAuditEvent(peer.UserID, key, account.Id, activity.PeerLoginExpired,
+				map[string]string{"name": peer.Name}, nil, nil))
+		}
+	}
+	return events
+}
+
+// notifyPeersLoginExpired sends updated network maps to the peers that can reach the changed peers
+// and closes the update channels of the expired peers, so they have to log in again
+func (am *DefaultAccountManager) notifyPeersLoginExpired(account *Account, changed []string) error {
+	for _, key := range changed {
+		if account.Peers[key].Status.LoginExpired {
+			log.Infof("login of peer %s has expired", key)
+			am.peersUpdateManager.CloseChannel(key)
+		}
+
+		err := am.updateReachablePeers(account, key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScheduleLoginExpiration periodically expires the logins of the peers until the context is done
+func (am *DefaultAccountManager) ScheduleLoginExpiration(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := am.ExpirePeerLogins()
+				if err != nil {
+					log.Errorf("failed expiring logins of peers: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock is a Clock which time passes only when advanced
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestAccountManager_LoginExpiration(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	manager.clock = clock
+
+	userID := "account_creator"
+	account, err := manager.GetOrCreateAccountByUser(userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	laptopKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := manager.AddPeer("", userID, &Peer{Key: laptopKey.PublicKey().String(), Name: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: serverKey.PublicKey().String(), Name: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdateAccountLoginExpiration(account.Id, -time.Hour, "account_creator")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting a negative login expiration to be rejected, got %v", err)
+	}
+
+	_, err = manager.UpdateAccountLoginExpiration(account.Id, 24*time.Hour, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	laptopUpdates := manager.peersUpdateManager.CreateChannel(laptop.Key)
+	serverUpdates := manager.peersUpdateManager.CreateChannel(server.Key)
+	defer manager.peersUpdateManager.CloseChannel(server.Key)
+
+	clock.Advance(23 * time.Hour)
+	err = manager.ExpirePeerLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.CheckPeerLogin(laptop.Key); err != nil {
+		t.Errorf("expecting the login of the peer not to expire yet, got %v", err)
+	}
+	if len(serverUpdates) != 0 {
+		t.Errorf("expecting no updates before the login expires, got %d", len(serverUpdates))
+	}
+
+	clock.Advance(2 * time.Hour)
+	if status.Code(manager.CheckPeerLogin(laptop.Key)) != codes.PermissionDenied {
+		t.Error("expecting the expired login of the peer registered by a user to be rejected")
+	}
+	if err = manager.CheckPeerLogin(server.Key); err != nil {
+		t.Errorf("expecting the login of the peer registered with a setup key never to expire, got %v", err)
+	}
+
+	networkMap, err := manager.GetNetworkMap(server.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := networkMap.Network.CurrentSerial()
+
+	err = manager.ExpirePeerLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-serverUpdates:
+		if update.Update.GetNetworkMap().GetSerial() <= serial {
+			t.Errorf("expecting network map serial to be incremented, got %d", update.Update.GetNetworkMap().GetSerial())
+		}
+		if len(update.Update.GetNetworkMap().GetRemotePeers()) != 0 {
+			t.Errorf("expecting the expired peer to be removed from the network map, got %v",
+				update.Update.GetNetworkMap().GetRemotePeers())
+		}
+	default:
+		t.Error("expecting the peers that can reach the expired peer to receive an update")
+	}
+
+	if _, open := <-laptopUpdates; open {
+		t.Error("expecting the updates channel of the expired peer to be closed")
+	}
+
+	// the expired peer is handled once
+	err = manager.ExpirePeerLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serverUpdates) != 0 {
+		t.Errorf("expecting no updates for an already expired peer, got %d", len(serverUpdates))
+	}
+
+	// the user logs in again with the peer
+	err = manager.MarkPeerLoggedIn(laptop.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.CheckPeerLogin(laptop.Key); err != nil {
+		t.Errorf("expecting the login of the peer to be valid after logging in again, got %v", err)
+	}
+
+	select {
+	case update := <-serverUpdates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		if len(remotePeers) != 1 || remotePeers[0].GetWgPubKey() != laptop.Key {
+			t.Errorf("expecting the peer to be added back to the network map, got %v", remotePeers)
+		}
+	default:
+		t.Error("expecting the peers that can reach the peer to receive an update after it logs in again")
+	}
+
+	// disabling the expiration restores the expired peers
+	clock.Advance(48 * time.Hour)
+	err = manager.ExpirePeerLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-serverUpdates:
+	default:
+		t.Fatal("expecting the peers that can reach the expired peer to receive an update")
+	}
+
+	_, err = manager.UpdateAccountLoginExpiration(account.Id, 0, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.CheckPeerLogin(laptop.Key); err != nil {
+		t.Errorf("expecting no login expiration when it is disabled, got %v", err)
+	}
+	peer, err := manager.GetPeer(laptop.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Status.LoginExpired {
+		t.Error("expecting the peer not to be expired when the login expiration is disabled")
+	}
+}
+
+func TestAccountManager_LoginPeerWithSetupKey(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)}
+	manager.clock = clock
+
+	userID := "account_creator"
+	account, err := manager.GetOrCreateAccountByUser(userID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	laptopKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	laptop, err := manager.AddPeer("", userID, &Peer{Key: laptopKey.PublicKey().String(), Name: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdateAccountLoginExpiration(account.Id, 24*time.Hour, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(25 * time.Hour)
+	err = manager.ExpirePeerLogins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Code(manager.CheckPeerLogin(laptop.Key)) != codes.PermissionDenied {
+		t.Fatal("expecting the login of the peer to be expired")
+	}
+
+	err = manager.LoginPeerWithSetupKey("unknown-key", laptop.Key)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting a setup key of no account to be rejected, got %v", err)
+	}
+
+	err = manager.LoginPeerWithSetupKey(setupKey.Key, laptop.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = manager.CheckPeerLogin(laptop.Key); err != nil {
+		t.Errorf("expecting the login of the peer to be valid after logging in with a setup key, got %v", err)
+	}
+	peer, err := manager.GetPeer(laptop.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !peer.LastLogin.Equal(clock.Now()) {
+		t.Errorf("expecting the last login of the peer to be refreshed to %v, got %v", clock.Now(), peer.LastLogin)
+	}
+	if peer.Status.LoginExpired {
+		t.Error("expecting the peer not to be expired after logging in with a setup key")
+	}
+
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(account.Peers) != 1 {
+		t.Errorf("expecting the peer to log in again instead of being registered again, got %d peers", len(account.Peers))
+	}
+
+	_, err = manager.RevokeSetupKey(account.Id, setupKey.Id, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = manager.LoginPeerWithSetupKey(setupKey.Key, laptop.Key)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expecting a revoked setup key to be rejected, got %v", err)
+	}
+}
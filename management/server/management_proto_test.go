@@ -16,8 +16,10 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 )
 
 var (
@@ -251,6 +253,10 @@ func Test_SyncProtocol(t *testing.T) {
 		t.Fatal("expecting SyncResponse to have NetworkMap with a non-nil PeerConfig")
 	}
 
+	if syncResp.GetEpoch() == 0 {
+		t.Fatal("expecting SyncResponse to have a non-zero Epoch of the Management Service instance")
+	}
+
 	expectedIPNet := net.IPNet{IP: net.ParseIP("100.64.0.0"), Mask: net.IPMask{255, 192, 0, 0}}
 	ip, _, _ := net.ParseCIDR(networkMap.GetPeerConfig().GetAddress())
 	if !expectedIPNet.Contains(ip) {
@@ -299,6 +305,397 @@ func loginPeerWithValidSetupKey(key wgtypes.Key, client mgmtProto.ManagementServ
 	return loginResp, nil
 }
 
+func TestServer_RegisterDuplicateKey(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mport := 33092
+	mgmtServer, err := startManagement(t, mport, &Config{
+		TURNConfig: &TURNConfig{
+			TimeBasedCredentials: false,
+			CredentialsTTL:       util.Duration{},
+			Secret:               "whatever",
+		},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgmtServer.GracefulStop()
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	serverKey, err := getServerKey(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	register := func(reRegister bool) (*mgmtProto.LoginResponse, error) {
+		message, err := encryption.EncryptMessage(*serverKey, key, &mgmtProto.LoginRequest{
+			SetupKey:   TestValidSetupKey,
+			Meta:       &mgmtProto.PeerSystemMeta{Hostname: "cloned-machine"},
+			ReRegister: reRegister,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Login(context.TODO(), &mgmtProto.EncryptedMessage{
+			WgPubKey: key.PublicKey().String(),
+			Body:     message,
+		})
+		if err != nil {
+			return nil, err
+		}
+		loginResp := &mgmtProto.LoginResponse{}
+		err = encryption.DecryptMessage(*serverKey, key, resp.Body, loginResp)
+		return loginResp, err
+	}
+
+	firstResp, err := register(false)
+	require.NoError(t, err, "first registration should succeed")
+
+	_, err = register(false)
+	require.Error(t, err, "registering the same key twice should fail")
+	require.Equal(t, codes.AlreadyExists, status.Code(err), "expecting AlreadyExists error")
+
+	reRegisterResp, err := register(true)
+	require.NoError(t, err, "re-registration should succeed")
+	require.Equal(t, firstResp.GetPeerConfig().GetAddress(), reRegisterResp.GetPeerConfig().GetAddress(),
+		"re-registered peer should keep its address")
+}
+
+func TestServer_RegisterReservedIP(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := store.GetAccountBySetupKey(TestValidSetupKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reservedIP := net.ParseIP("100.64.0.100").To4()
+	account.IPReservations = map[string]*IPReservation{
+		"gateway": {ID: "gateway", PeerKey: key.PublicKey().String(), IP: reservedIP},
+	}
+	err = store.SaveAccount(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mport := 33093
+	mgmtServer, err := startManagement(t, mport, &Config{
+		TURNConfig: &TURNConfig{
+			TimeBasedCredentials: false,
+			CredentialsTTL:       util.Duration{},
+			Secret:               "whatever",
+		},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgmtServer.GracefulStop()
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	resp, err := loginPeerWithValidSetupKey(key, client)
+	require.NoError(t, err, "registration should succeed")
+	require.Equal(t, fmt.Sprintf("%s/%d", reservedIP, account.Network.PrefixLen()), resp.GetPeerConfig().GetAddress(),
+		"peer should be registered with the reserved IP")
+}
+
+func TestServer_GetTURNCredentials(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := time.Hour
+	secret := "whatever"
+	mport := 33097
+	mgmtServer, err := startManagement(t, mport, &Config{
+		TURNConfig: &TURNConfig{
+			TimeBasedCredentials: true,
+			CredentialsTTL:       util.Duration{Duration: ttl},
+			Secret:               secret,
+			Turns:                []*Host{TurnTestHost},
+		},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgmtServer.GracefulStop()
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	serverKey, err := getServerKey(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := registerPeers(1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unregistered, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getTURNCredentials := func(key wgtypes.Key) (*mgmtProto.TURNCredentialsResponse, error) {
+		message, err := encryption.EncryptMessage(*serverKey, key, &mgmtProto.TURNCredentialsRequest{})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.GetTURNCredentials(context.TODO(), &mgmtProto.EncryptedMessage{
+			WgPubKey: key.PublicKey().String(),
+			Body:     message,
+		})
+		if err != nil {
+			return nil, err
+		}
+		credentialsResp := &mgmtProto.TURNCredentialsResponse{}
+		err = encryption.DecryptMessage(*serverKey, key, resp.Body, credentialsResp)
+		return credentialsResp, err
+	}
+
+	_, err = getTURNCredentials(unregistered)
+	require.Equal(t, codes.PermissionDenied, status.Code(err), "expecting PermissionDenied error for an unregistered peer")
+
+	requestedAt := time.Now()
+	resp, err := getTURNCredentials(*peers[0])
+	require.NoError(t, err)
+	require.Len(t, resp.GetTurns(), 1)
+
+	turn := resp.GetTurns()[0]
+	require.Equal(t, TurnTestHost.URI, turn.GetHostConfig().GetUri())
+	validateMAC(turn.GetUser(), turn.GetPassword(), []byte(secret), t)
+	require.NotNil(t, turn.GetExpiresAt(), "expecting the credentials expiration time")
+	require.WithinDuration(t, requestedAt.Add(ttl), turn.GetExpiresAt().AsTime(), 2*time.Second)
+}
+
+func TestServer_ReplaceKey(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mport := 33098
+	mgmtServer, err := startManagement(t, mport, &Config{
+		Stuns:      []*Host{},
+		TURNConfig: &TURNConfig{},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgmtServer.GracefulStop()
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	serverKey, err := getServerKey(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	loginResp, err := loginPeerWithValidSetupKey(oldKey, client)
+	require.NoError(t, err)
+
+	newKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	replaceKey := func(proofKey wgtypes.Key) (*mgmtProto.ReplaceKeyResponse, error) {
+		proof, err := encryption.Encrypt([]byte(oldKey.PublicKey().String()), *serverKey, proofKey)
+		if err != nil {
+			return nil, err
+		}
+		message, err := encryption.EncryptMessage(*serverKey, oldKey, &mgmtProto.ReplaceKeyRequest{
+			NewWgPubKey: newKey.PublicKey().String(),
+			NewKeyProof: proof,
+		})
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.ReplaceKey(context.TODO(), &mgmtProto.EncryptedMessage{
+			WgPubKey: oldKey.PublicKey().String(),
+			Body:     message,
+		})
+		if err != nil {
+			return nil, err
+		}
+		replaceResp := &mgmtProto.ReplaceKeyResponse{}
+		err = encryption.DecryptMessage(*serverKey, newKey, resp.Body, replaceResp)
+		return replaceResp, err
+	}
+
+	// the proof made without the new private key is rejected
+	otherKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	_, err = replaceKey(otherKey)
+	require.Equal(t, codes.PermissionDenied, status.Code(err), "expecting PermissionDenied error for an invalid proof")
+
+	resp, err := replaceKey(newKey)
+	require.NoError(t, err)
+	require.Equal(t, loginResp.GetPeerConfig().GetAddress(), resp.GetPeerConfig().GetAddress(),
+		"the peer should keep its address with the new key")
+
+	// the old key is unknown once it has been replaced
+	_, err = replaceKey(newKey)
+	require.Equal(t, codes.PermissionDenied, status.Code(err), "expecting PermissionDenied error for the replaced key")
+}
+
+func TestServer_SendFeedback(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mport := 33099
+	mgmtServer, err := startManagement(t, mport, &Config{
+		Stuns:      []*Host{},
+		TURNConfig: &TURNConfig{},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mgmtServer.GracefulStop()
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", mport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	serverKey, err := getServerKey(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peers, err := registerPeers(2, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unregistered, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	sendFeedback := func(key wgtypes.Key, feedback *mgmtProto.FeedbackRequest) error {
+		message, err := encryption.EncryptMessage(*serverKey, key, feedback)
+		if err != nil {
+			return err
+		}
+		resp, err := client.SendFeedback(context.TODO(), &mgmtProto.EncryptedMessage{
+			WgPubKey: key.PublicKey().String(),
+			Body:     message,
+		})
+		if err != nil {
+			return err
+		}
+		return encryption.DecryptMessage(*serverKey, key, resp.Body, &mgmtProto.FeedbackResponse{})
+	}
+	storedConflicts := func() []AllowedIPsConflict {
+		store, err := NewStore(dir)
+		require.NoError(t, err)
+		peer, err := store.GetPeer(peers[0].PublicKey().String())
+		require.NoError(t, err)
+		return peer.AllowedIPsConflicts
+	}
+
+	err = sendFeedback(unregistered, &mgmtProto.FeedbackRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err), "expecting PermissionDenied error for an unregistered peer")
+
+	err = sendFeedback(*peers[0], &mgmtProto.FeedbackRequest{
+		AllowedIpsConflicts: []*mgmtProto.AllowedIPsConflict{
+			{Type: mgmtProto.AllowedIPsConflict_DUPLICATE, Prefix: "100.64.0.10/32", Peer: "peer-a",
+				ConflictingPrefix: "100.64.0.10/32", ConflictingPeer: "peer-b"},
+			{Type: mgmtProto.AllowedIPsConflict_OVERLAP, Prefix: "10.0.0.0/16", Peer: "peer-a",
+				ConflictingPrefix: "10.0.1.0/24", ConflictingPeer: "peer-c"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []AllowedIPsConflict{
+		{Prefix: "100.64.0.10/32", Peer: "peer-a", ConflictingPrefix: "100.64.0.10/32", ConflictingPeer: "peer-b", Duplicate: true},
+		{Prefix: "10.0.0.0/16", Peer: "peer-a", ConflictingPrefix: "10.0.1.0/24", ConflictingPeer: "peer-c"},
+	}, storedConflicts())
+
+	// the conflicts are cleared once the peer reports none
+	err = sendFeedback(*peers[0], &mgmtProto.FeedbackRequest{})
+	require.NoError(t, err)
+	require.Empty(t, storedConflicts())
+
+	// the connection outcomes are counted for the peers of the account only
+	failedBefore := outcomeMetric("peer-offline")
+	err = sendFeedback(*peers[0], &mgmtProto.FeedbackRequest{
+		ConnectionOutcomes: []*mgmtProto.PeerConnectionOutcome{
+			{Peer: peers[1].PublicKey().String(), Failures: map[string]uint32{"peer-offline": 2}},
+			{Peer: unregistered.PublicKey().String(), Failures: map[string]uint32{"peer-offline": 3}},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), outcomeMetric("peer-offline")-failedBefore)
+}
+
 func TestServer_GetDeviceAuthorizationFlow(t *testing.T) {
 	testingServerKey, err := wgtypes.GeneratePrivateKey()
 	if err != nil {
@@ -403,7 +800,7 @@ func startManagement(t *testing.T, port int, config *Config) (*grpc.Server, erro
 		return nil, err
 	}
 	peersUpdateManager := NewPeersUpdateManager()
-	accountManager, err := BuildManager(store, peersUpdateManager, nil)
+	accountManager, err := BuildManager(store, peersUpdateManager, nil, nil)
 	if err != nil {
 		return nil, err
 	}
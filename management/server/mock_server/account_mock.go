@@ -1,7 +1,11 @@
 package mock_server
 
 import (
+	"net"
+	"time"
+
 	"github.com/netbirdio/netbird/management/server"
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/netbirdio/netbird/management/server/jwtclaims"
 	"github.com/netbirdio/netbird/util"
 	"google.golang.org/grpc/codes"
@@ -11,35 +15,74 @@ import (
 type MockAccountManager struct {
 	GetOrCreateAccountByUserFunc          func(userId, domain string) (*server.Account, error)
 	GetAccountByUserFunc                  func(userId string) (*server.Account, error)
-	AddSetupKeyFunc                       func(accountId string, keyName string, keyType server.SetupKeyType, expiresIn *util.Duration) (*server.SetupKey, error)
-	RevokeSetupKeyFunc                    func(accountId string, keyId string) (*server.SetupKey, error)
-	RenameSetupKeyFunc                    func(accountId string, keyId string, newName string) (*server.SetupKey, error)
+	AddSetupKeyFunc                       func(accountId string, keyName string, keyType server.SetupKeyType, expiresIn *util.Duration, usageLimit int, userID string) (*server.SetupKey, error)
+	RevokeSetupKeyFunc                    func(accountId string, keyId string, userID string) (*server.SetupKey, error)
+	RenameSetupKeyFunc                    func(accountId string, keyId string, newName string, userID string) (*server.SetupKey, error)
+	ListSetupKeysFunc                     func(accountId string) ([]*server.SetupKey, error)
+	GetPeersQuotaFunc                     func(accountId string) (*server.PeersQuota, error)
 	GetAccountByIdFunc                    func(accountId string) (*server.Account, error)
 	GetAccountByUserOrAccountIdFunc       func(userId, accountId, domain string) (*server.Account, error)
 	GetAccountWithAuthorizationClaimsFunc func(claims jwtclaims.AuthorizationClaims) (*server.Account, error)
 	IsUserAdminFunc                       func(claims jwtclaims.AuthorizationClaims) (bool, error)
 	AccountExistsFunc                     func(accountId string) (*bool, error)
 	AddAccountFunc                        func(accountId, userId, domain string) (*server.Account, error)
+	CreateAccountFunc                     func(accountId string, network *net.IPNet, setupKeys []*server.SetupKey, userID string) (*server.Account, error)
+	ListAccountsFunc                      func() ([]*server.Account, error)
 	GetPeerFunc                           func(peerKey string) (*server.Peer, error)
 	MarkPeerConnectedFunc                 func(peerKey string, connected bool) error
-	RenamePeerFunc                        func(accountId string, peerKey string, newName string) (*server.Peer, error)
-	DeletePeerFunc                        func(accountId string, peerKey string) (*server.Peer, error)
+	MarkPeerDisconnectedFunc              func(peerKey string, lastSeen time.Time) error
+	RenamePeerFunc                        func(accountId string, peerKey string, newName string, userID string) (*server.Peer, error)
+	UpdatePeerIPFunc                      func(accountId string, peerKey string, ip net.IP, userID string) (*server.Peer, error)
+	UpdatePeerRateLimitFunc               func(accountId string, peerKey string, rateLimit uint64, userID string) (*server.Peer, error)
+	UpdatePeerStaticEndpointFunc          func(accountId string, peerKey string, endpoint string, userID string) (*server.Peer, error)
+	UpdatePeerRouteMetricFunc             func(accountId string, peerKey string, metric uint32, userID string) (*server.Peer, error)
+	UpdatePeerSSHFunc                     func(accountId string, peerKey string, enabled bool, allowedGroups []string, userID string) (*server.Peer, error)
+	UpdatePeerTagsFunc                    func(accountId string, peerKey string, tags []string, userID string) (*server.Peer, error)
+	SuspendPeerFunc                       func(accountId string, peerKey string, suspended bool, userID string) (*server.Peer, error)
+	MarkPeerLoggedInFunc                  func(peerKey string) error
+	CheckPeerLoginFunc                    func(peerKey string) error
+	LoginPeerWithSetupKeyFunc             func(setupKey string, peerKey string) error
+	UpdateAccountLoginExpirationFunc      func(accountId string, expiration time.Duration, userID string) (*server.Account, error)
+	UpdateAccountPresenceSharingFunc      func(accountId string, enabled bool, userID string) (*server.Account, error)
+	UpdateAccountPostureChecksFunc        func(accountId string, checks *server.PostureChecks, userID string) (*server.Account, error)
+	UpdateAccountDNSSettingsFunc          func(accountId string, settings *server.DNSSettings, userID string) (*server.Account, error)
+	ExportAccountFunc                     func(accountId string) (*server.AccountExport, error)
+	ImportAccountFunc                     func(accountId string, export *server.AccountExport, dryRun bool, userID string) (*server.ImportResult, error)
+	DeletePeerFunc                        func(accountId string, peerKey string, userID string) (*server.Peer, error)
+	ReplacePeerKeyFunc                    func(oldKey string, newKey string) (*server.Peer, error)
 	GetPeerByIPFunc                       func(accountId string, peerIP string) (*server.Peer, error)
 	GetNetworkMapFunc                     func(peerKey string) (*server.NetworkMap, error)
+	GetPeerReachabilityFunc               func(peerKey string) ([]*server.ReachablePeer, error)
 	AddPeerFunc                           func(setupKey string, userId string, peer *server.Peer) (*server.Peer, error)
 	GetGroupFunc                          func(accountID, groupID string) (*server.Group, error)
-	SaveGroupFunc                         func(accountID string, group *server.Group) error
-	DeleteGroupFunc                       func(accountID, groupID string) error
+	SaveGroupFunc                         func(accountID, userID string, group *server.Group) error
+	DeleteGroupFunc                       func(accountID, userID, groupID string) error
 	ListGroupsFunc                        func(accountID string) ([]*server.Group, error)
-	GroupAddPeerFunc                      func(accountID, groupID, peerKey string) error
-	GroupDeletePeerFunc                   func(accountID, groupID, peerKey string) error
+	GroupAddPeerFunc                      func(accountID, userID, groupID, peerKey string) error
+	GroupDeletePeerFunc                   func(accountID, userID, groupID, peerKey string) error
 	GroupListPeersFunc                    func(accountID, groupID string) ([]*server.Peer, error)
 	GetRuleFunc                           func(accountID, ruleID string) (*server.Rule, error)
-	SaveRuleFunc                          func(accountID string, rule *server.Rule) error
-	DeleteRuleFunc                        func(accountID, ruleID string) error
+	SaveRuleFunc                          func(accountID, userID string, rule *server.Rule) error
+	DeleteRuleFunc                        func(accountID, userID, ruleID string) error
 	ListRulesFunc                         func(accountID string) ([]*server.Rule, error)
+	GetRouteFunc                          func(accountID, routeID string) (*server.Route, error)
+	SaveRouteFunc                         func(accountID, userID string, route *server.Route) error
+	DeleteRouteFunc                       func(accountID, userID, routeID string) error
+	ListRoutesFunc                        func(accountID string) ([]*server.Route, error)
 	GetUsersFromAccountFunc               func(accountID string) ([]*server.UserInfo, error)
 	UpdatePeerMetaFunc                    func(peerKey string, meta server.PeerSystemMeta) error
+	UpdatePeerAllowedIPsConflictsFunc     func(peerKey string, conflicts []server.AllowedIPsConflict) error
+	RecordConnectionOutcomesFunc          func(peerKey string, outcomes []server.ConnectionOutcome) error
+	GetPeerConnectivityFunc               func(peerKey string) (*server.PeerConnectivity, error)
+	UpdateAccountNetworkFunc              func(accountID string, ipNet net.IPNet, userID string) (*server.Network, error)
+	SaveIPReservationFunc                 func(accountID, userID string, reservation *server.IPReservation) error
+	DeleteIPReservationFunc               func(accountID, userID, reservationID string) error
+	GetEventsFunc                         func(accountID string, from, to time.Time) ([]*activity.Event, error)
+	GetAuditEventsFunc                    func(accountID string, filter server.AuditFilter) ([]*activity.Event, int, error)
+	StartDeviceAuthFunc                   func(peerKey string) (*server.DeviceAuth, error)
+	ApproveDeviceAuthFunc                 func(userCode string, userID string) error
+	PollDeviceAuthFunc                    func(deviceCode string, peerKey string) (string, error)
+	RedeemDeviceAuthFunc                  func(token string, peerKey string) (string, error)
 }
 
 func (am *MockAccountManager) GetUsersFromAccount(accountID string) ([]*server.UserInfo, error) {
@@ -73,9 +116,11 @@ func (am *MockAccountManager) AddSetupKey(
 	keyName string,
 	keyType server.SetupKeyType,
 	expiresIn *util.Duration,
+	usageLimit int,
+	userID string,
 ) (*server.SetupKey, error) {
 	if am.AddSetupKeyFunc != nil {
-		return am.AddSetupKeyFunc(accountId, keyName, keyType, expiresIn)
+		return am.AddSetupKeyFunc(accountId, keyName, keyType, expiresIn, usageLimit, userID)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method AddSetupKey not implemented")
 }
@@ -83,9 +128,10 @@ func (am *MockAccountManager) AddSetupKey(
 func (am *MockAccountManager) RevokeSetupKey(
 	accountId string,
 	keyId string,
+	userID string,
 ) (*server.SetupKey, error) {
 	if am.RevokeSetupKeyFunc != nil {
-		return am.RevokeSetupKeyFunc(accountId, keyId)
+		return am.RevokeSetupKeyFunc(accountId, keyId, userID)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method RevokeSetupKey not implemented")
 }
@@ -94,13 +140,21 @@ func (am *MockAccountManager) RenameSetupKey(
 	accountId string,
 	keyId string,
 	newName string,
+	userID string,
 ) (*server.SetupKey, error) {
 	if am.RenameSetupKeyFunc != nil {
-		return am.RenameSetupKeyFunc(accountId, keyId, newName)
+		return am.RenameSetupKeyFunc(accountId, keyId, newName, userID)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method RenameSetupKey not implemented")
 }
 
+func (am *MockAccountManager) ListSetupKeys(accountId string) ([]*server.SetupKey, error) {
+	if am.ListSetupKeysFunc != nil {
+		return am.ListSetupKeysFunc(accountId)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ListSetupKeys not implemented")
+}
+
 func (am *MockAccountManager) GetAccountById(accountId string) (*server.Account, error) {
 	if am.GetAccountByIdFunc != nil {
 		return am.GetAccountByIdFunc(accountId)
@@ -148,6 +202,20 @@ func (am *MockAccountManager) AddAccount(
 	return nil, status.Errorf(codes.Unimplemented, "method AddAccount not implemented")
 }
 
+func (am *MockAccountManager) CreateAccount(accountId string, network *net.IPNet, setupKeys []*server.SetupKey, userID string) (*server.Account, error) {
+	if am.CreateAccountFunc != nil {
+		return am.CreateAccountFunc(accountId, network, setupKeys, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAccount not implemented")
+}
+
+func (am *MockAccountManager) ListAccounts() ([]*server.Account, error) {
+	if am.ListAccountsFunc != nil {
+		return am.ListAccountsFunc()
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ListAccounts not implemented")
+}
+
 func (am *MockAccountManager) GetPeer(peerKey string) (*server.Peer, error) {
 	if am.GetPeerFunc != nil {
 		return am.GetPeerFunc(peerKey)
@@ -162,24 +230,167 @@ func (am *MockAccountManager) MarkPeerConnected(peerKey string, connected bool)
 	return status.Errorf(codes.Unimplemented, "method MarkPeerConnected not implemented")
 }
 
+// MarkPeerDisconnected mock implementation of MarkPeerDisconnected from server.AccountManager interface
+func (am *MockAccountManager) MarkPeerDisconnected(peerKey string, lastSeen time.Time) error {
+	if am.MarkPeerDisconnectedFunc != nil {
+		return am.MarkPeerDisconnectedFunc(peerKey, lastSeen)
+	}
+	return status.Errorf(codes.Unimplemented, "method MarkPeerDisconnected not implemented")
+}
+
 func (am *MockAccountManager) RenamePeer(
 	accountId string,
 	peerKey string,
 	newName string,
+	userID string,
 ) (*server.Peer, error) {
 	if am.RenamePeerFunc != nil {
-		return am.RenamePeerFunc(accountId, peerKey, newName)
+		return am.RenamePeerFunc(accountId, peerKey, newName, userID)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method RenamePeer not implemented")
 }
 
-func (am *MockAccountManager) DeletePeer(accountId string, peerKey string) (*server.Peer, error) {
+func (am *MockAccountManager) UpdatePeerIP(accountId string, peerKey string, ip net.IP, userID string) (*server.Peer, error) {
+	if am.UpdatePeerIPFunc != nil {
+		return am.UpdatePeerIPFunc(accountId, peerKey, ip, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerIP not implemented")
+}
+
+func (am *MockAccountManager) UpdatePeerRateLimit(accountId string, peerKey string, rateLimit uint64, userID string) (*server.Peer, error) {
+	if am.UpdatePeerRateLimitFunc != nil {
+		return am.UpdatePeerRateLimitFunc(accountId, peerKey, rateLimit, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerRateLimit not implemented")
+}
+
+// UpdatePeerStaticEndpoint mock implementation of UpdatePeerStaticEndpoint from server.AccountManager interface
+func (am *MockAccountManager) UpdatePeerStaticEndpoint(accountId string, peerKey string, endpoint string, userID string) (*server.Peer, error) {
+	if am.UpdatePeerStaticEndpointFunc != nil {
+		return am.UpdatePeerStaticEndpointFunc(accountId, peerKey, endpoint, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerStaticEndpoint not implemented")
+}
+
+// UpdatePeerRouteMetric mock implementation of UpdatePeerRouteMetric from server.AccountManager interface
+func (am *MockAccountManager) UpdatePeerRouteMetric(accountId string, peerKey string, metric uint32, userID string) (*server.Peer, error) {
+	if am.UpdatePeerRouteMetricFunc != nil {
+		return am.UpdatePeerRouteMetricFunc(accountId, peerKey, metric, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerRouteMetric not implemented")
+}
+
+// UpdatePeerSSH mock implementation of UpdatePeerSSH from server.AccountManager interface
+func (am *MockAccountManager) UpdatePeerSSH(accountId string, peerKey string, enabled bool, allowedGroups []string, userID string) (*server.Peer, error) {
+	if am.UpdatePeerSSHFunc != nil {
+		return am.UpdatePeerSSHFunc(accountId, peerKey, enabled, allowedGroups, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerSSH not implemented")
+}
+
+// UpdatePeerTags mock implementation of UpdatePeerTags from server.AccountManager interface
+func (am *MockAccountManager) UpdatePeerTags(accountId string, peerKey string, tags []string, userID string) (*server.Peer, error) {
+	if am.UpdatePeerTagsFunc != nil {
+		return am.UpdatePeerTagsFunc(accountId, peerKey, tags, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdatePeerTags not implemented")
+}
+
+// SuspendPeer mock implementation of SuspendPeer from server.AccountManager interface
+func (am *MockAccountManager) SuspendPeer(accountId string, peerKey string, suspended bool, userID string) (*server.Peer, error) {
+	if am.SuspendPeerFunc != nil {
+		return am.SuspendPeerFunc(accountId, peerKey, suspended, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method SuspendPeer not implemented")
+}
+
+// MarkPeerLoggedIn mock implementation of MarkPeerLoggedIn from server.AccountManager interface
+func (am *MockAccountManager) MarkPeerLoggedIn(peerKey string) error {
+	if am.MarkPeerLoggedInFunc != nil {
+		return am.MarkPeerLoggedInFunc(peerKey)
+	}
+	return status.Errorf(codes.Unimplemented, "method MarkPeerLoggedIn not implemented")
+}
+
+// CheckPeerLogin mock implementation of CheckPeerLogin from server.AccountManager interface
+func (am *MockAccountManager) CheckPeerLogin(peerKey string) error {
+	if am.CheckPeerLoginFunc != nil {
+		return am.CheckPeerLoginFunc(peerKey)
+	}
+	return status.Errorf(codes.Unimplemented, "method CheckPeerLogin not implemented")
+}
+
+// LoginPeerWithSetupKey mock implementation of LoginPeerWithSetupKey from server.AccountManager interface
+func (am *MockAccountManager) LoginPeerWithSetupKey(setupKey string, peerKey string) error {
+	if am.LoginPeerWithSetupKeyFunc != nil {
+		return am.LoginPeerWithSetupKeyFunc(setupKey, peerKey)
+	}
+	return status.Errorf(codes.Unimplemented, "method LoginPeerWithSetupKey not implemented")
+}
+
+// UpdateAccountLoginExpiration mock implementation of UpdateAccountLoginExpiration from server.AccountManager interface
+func (am *MockAccountManager) UpdateAccountLoginExpiration(accountId string, expiration time.Duration, userID string) (*server.Account, error) {
+	if am.UpdateAccountLoginExpirationFunc != nil {
+		return am.UpdateAccountLoginExpirationFunc(accountId, expiration, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccountLoginExpiration not implemented")
+}
+
+// UpdateAccountPresenceSharing mock implementation of UpdateAccountPresenceSharing from server.AccountManager interface
+func (am *MockAccountManager) UpdateAccountPresenceSharing(accountId string, enabled bool, userID string) (*server.Account, error) {
+	if am.UpdateAccountPresenceSharingFunc != nil {
+		return am.UpdateAccountPresenceSharingFunc(accountId, enabled, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccountPresenceSharing not implemented")
+}
+
+// UpdateAccountPostureChecks mock implementation of UpdateAccountPostureChecks from server.AccountManager interface
+func (am *MockAccountManager) UpdateAccountPostureChecks(accountId string, checks *server.PostureChecks, userID string) (*server.Account, error) {
+	if am.UpdateAccountPostureChecksFunc != nil {
+		return am.UpdateAccountPostureChecksFunc(accountId, checks, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccountPostureChecks not implemented")
+}
+
+// UpdateAccountDNSSettings mock implementation of UpdateAccountDNSSettings from server.AccountManager interface
+func (am *MockAccountManager) UpdateAccountDNSSettings(accountId string, settings *server.DNSSettings, userID string) (*server.Account, error) {
+	if am.UpdateAccountDNSSettingsFunc != nil {
+		return am.UpdateAccountDNSSettingsFunc(accountId, settings, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccountDNSSettings not implemented")
+}
+
+// ExportAccount mock implementation of ExportAccount from server.AccountManager interface
+func (am *MockAccountManager) ExportAccount(accountId string) (*server.AccountExport, error) {
+	if am.ExportAccountFunc != nil {
+		return am.ExportAccountFunc(accountId)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ExportAccount not implemented")
+}
+
+// ImportAccount mock implementation of ImportAccount from server.AccountManager interface
+func (am *MockAccountManager) ImportAccount(accountId string, export *server.AccountExport, dryRun bool, userID string) (*server.ImportResult, error) {
+	if am.ImportAccountFunc != nil {
+		return am.ImportAccountFunc(accountId, export, dryRun, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ImportAccount not implemented")
+}
+
+func (am *MockAccountManager) DeletePeer(accountId string, peerKey string, userID string) (*server.Peer, error) {
 	if am.DeletePeerFunc != nil {
-		return am.DeletePeerFunc(accountId, peerKey)
+		return am.DeletePeerFunc(accountId, peerKey, userID)
 	}
 	return nil, status.Errorf(codes.Unimplemented, "method DeletePeer not implemented")
 }
 
+// ReplacePeerKey mock implementation of ReplacePeerKey from server.AccountManager interface
+func (am *MockAccountManager) ReplacePeerKey(oldKey string, newKey string) (*server.Peer, error) {
+	if am.ReplacePeerKeyFunc != nil {
+		return am.ReplacePeerKeyFunc(oldKey, newKey)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ReplacePeerKey not implemented")
+}
+
 func (am *MockAccountManager) GetPeerByIP(accountId string, peerIP string) (*server.Peer, error) {
 	if am.GetPeerByIPFunc != nil {
 		return am.GetPeerByIPFunc(accountId, peerIP)
@@ -194,6 +405,13 @@ func (am *MockAccountManager) GetNetworkMap(peerKey string) (*server.NetworkMap,
 	return nil, status.Errorf(codes.Unimplemented, "method GetNetworkMap not implemented")
 }
 
+func (am *MockAccountManager) GetPeerReachability(peerKey string) ([]*server.ReachablePeer, error) {
+	if am.GetPeerReachabilityFunc != nil {
+		return am.GetPeerReachabilityFunc(peerKey)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method GetPeerReachability not implemented")
+}
+
 func (am *MockAccountManager) AddPeer(
 	setupKey string,
 	userId string,
@@ -212,16 +430,16 @@ func (am *MockAccountManager) GetGroup(accountID, groupID string) (*server.Group
 	return nil, status.Errorf(codes.Unimplemented, "method GetGroup not implemented")
 }
 
-func (am *MockAccountManager) SaveGroup(accountID string, group *server.Group) error {
+func (am *MockAccountManager) SaveGroup(accountID, userID string, group *server.Group) error {
 	if am.SaveGroupFunc != nil {
-		return am.SaveGroupFunc(accountID, group)
+		return am.SaveGroupFunc(accountID, userID, group)
 	}
 	return status.Errorf(codes.Unimplemented, "method SaveGroup not implemented")
 }
 
-func (am *MockAccountManager) DeleteGroup(accountID, groupID string) error {
+func (am *MockAccountManager) DeleteGroup(accountID, userID, groupID string) error {
 	if am.DeleteGroupFunc != nil {
-		return am.DeleteGroupFunc(accountID, groupID)
+		return am.DeleteGroupFunc(accountID, userID, groupID)
 	}
 	return status.Errorf(codes.Unimplemented, "method DeleteGroup not implemented")
 }
@@ -233,16 +451,16 @@ func (am *MockAccountManager) ListGroups(accountID string) ([]*server.Group, err
 	return nil, status.Errorf(codes.Unimplemented, "method ListGroups not implemented")
 }
 
-func (am *MockAccountManager) GroupAddPeer(accountID, groupID, peerKey string) error {
+func (am *MockAccountManager) GroupAddPeer(accountID, userID, groupID, peerKey string) error {
 	if am.GroupAddPeerFunc != nil {
-		return am.GroupAddPeerFunc(accountID, groupID, peerKey)
+		return am.GroupAddPeerFunc(accountID, userID, groupID, peerKey)
 	}
 	return status.Errorf(codes.Unimplemented, "method GroupAddPeer not implemented")
 }
 
-func (am *MockAccountManager) GroupDeletePeer(accountID, groupID, peerKey string) error {
+func (am *MockAccountManager) GroupDeletePeer(accountID, userID, groupID, peerKey string) error {
 	if am.GroupDeletePeerFunc != nil {
-		return am.GroupDeletePeerFunc(accountID, groupID, peerKey)
+		return am.GroupDeletePeerFunc(accountID, userID, groupID, peerKey)
 	}
 	return status.Errorf(codes.Unimplemented, "method GroupDeletePeer not implemented")
 }
@@ -261,16 +479,16 @@ func (am *MockAccountManager) GetRule(accountID, ruleID string) (*server.Rule, e
 	return nil, status.Errorf(codes.Unimplemented, "method GetRule not implemented")
 }
 
-func (am *MockAccountManager) SaveRule(accountID string, rule *server.Rule) error {
+func (am *MockAccountManager) SaveRule(accountID, userID string, rule *server.Rule) error {
 	if am.SaveRuleFunc != nil {
-		return am.SaveRuleFunc(accountID, rule)
+		return am.SaveRuleFunc(accountID, userID, rule)
 	}
 	return status.Errorf(codes.Unimplemented, "method SaveRule not implemented")
 }
 
-func (am *MockAccountManager) DeleteRule(accountID, ruleID string) error {
+func (am *MockAccountManager) DeleteRule(accountID, userID, ruleID string) error {
 	if am.DeleteRuleFunc != nil {
-		return am.DeleteRuleFunc(accountID, ruleID)
+		return am.DeleteRuleFunc(accountID, userID, ruleID)
 	}
 	return status.Errorf(codes.Unimplemented, "method DeleteRule not implemented")
 }
@@ -282,6 +500,34 @@ func (am *MockAccountManager) ListRules(accountID string) ([]*server.Rule, error
 	return nil, status.Errorf(codes.Unimplemented, "method ListRules not implemented")
 }
 
+func (am *MockAccountManager) GetRoute(accountID, routeID string) (*server.Route, error) {
+	if am.GetRouteFunc != nil {
+		return am.GetRouteFunc(accountID, routeID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method GetRoute not implemented")
+}
+
+func (am *MockAccountManager) SaveRoute(accountID, userID string, route *server.Route) error {
+	if am.SaveRouteFunc != nil {
+		return am.SaveRouteFunc(accountID, userID, route)
+	}
+	return status.Errorf(codes.Unimplemented, "method SaveRoute not implemented")
+}
+
+func (am *MockAccountManager) DeleteRoute(accountID, userID, routeID string) error {
+	if am.DeleteRouteFunc != nil {
+		return am.DeleteRouteFunc(accountID, userID, routeID)
+	}
+	return status.Errorf(codes.Unimplemented, "method DeleteRoute not implemented")
+}
+
+func (am *MockAccountManager) ListRoutes(accountID string) ([]*server.Route, error) {
+	if am.ListRoutesFunc != nil {
+		return am.ListRoutesFunc(accountID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method ListRoutes not implemented")
+}
+
 func (am *MockAccountManager) UpdatePeerMeta(peerKey string, meta server.PeerSystemMeta) error {
 	if am.UpdatePeerMetaFunc != nil {
 		return am.UpdatePeerMetaFunc(peerKey, meta)
@@ -289,9 +535,106 @@ func (am *MockAccountManager) UpdatePeerMeta(peerKey string, meta server.PeerSys
 	return status.Errorf(codes.Unimplemented, "method UpdatePeerMetaFunc not implemented")
 }
 
+// UpdatePeerAllowedIPsConflicts mock implementation of UpdatePeerAllowedIPsConflicts from server.AccountManager interface
+func (am *MockAccountManager) UpdatePeerAllowedIPsConflicts(peerKey string, conflicts []server.AllowedIPsConflict) error {
+	if am.UpdatePeerAllowedIPsConflictsFunc != nil {
+		return am.UpdatePeerAllowedIPsConflictsFunc(peerKey, conflicts)
+	}
+	return status.Errorf(codes.Unimplemented, "method UpdatePeerAllowedIPsConflicts not implemented")
+}
+
+// RecordConnectionOutcomes mock implementation of RecordConnectionOutcomes from server.AccountManager interface
+func (am *MockAccountManager) RecordConnectionOutcomes(peerKey string, outcomes []server.ConnectionOutcome) error {
+	if am.RecordConnectionOutcomesFunc != nil {
+		return am.RecordConnectionOutcomesFunc(peerKey, outcomes)
+	}
+	return status.Errorf(codes.Unimplemented, "method RecordConnectionOutcomes not implemented")
+}
+
+// GetPeerConnectivity mock implementation of GetPeerConnectivity from server.AccountManager interface
+func (am *MockAccountManager) GetPeerConnectivity(peerKey string) (*server.PeerConnectivity, error) {
+	if am.GetPeerConnectivityFunc != nil {
+		return am.GetPeerConnectivityFunc(peerKey)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method GetPeerConnectivity not implemented")
+}
+
 func (am *MockAccountManager) IsUserAdmin(claims jwtclaims.AuthorizationClaims) (bool, error) {
 	if am.IsUserAdminFunc != nil {
 		return am.IsUserAdminFunc(claims)
 	}
 	return false, status.Errorf(codes.Unimplemented, "method IsUserAdmin not implemented")
 }
+
+func (am *MockAccountManager) UpdateAccountNetwork(accountID string, ipNet net.IPNet, userID string) (*server.Network, error) {
+	if am.UpdateAccountNetworkFunc != nil {
+		return am.UpdateAccountNetworkFunc(accountID, ipNet, userID)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccountNetwork not implemented")
+}
+
+// SaveIPReservation mock implementation of SaveIPReservation from server.AccountManager interface
+func (am *MockAccountManager) SaveIPReservation(accountID, userID string, reservation *server.IPReservation) error {
+	if am.SaveIPReservationFunc != nil {
+		return am.SaveIPReservationFunc(accountID, userID, reservation)
+	}
+	return status.Errorf(codes.Unimplemented, "method SaveIPReservation not implemented")
+}
+
+// DeleteIPReservation mock implementation of DeleteIPReservation from server.AccountManager interface
+func (am *MockAccountManager) DeleteIPReservation(accountID, userID, reservationID string) error {
+	if am.DeleteIPReservationFunc != nil {
+		return am.DeleteIPReservationFunc(accountID, userID, reservationID)
+	}
+	return status.Errorf(codes.Unimplemented, "method DeleteIPReservation not implemented")
+}
+
+func (am *MockAccountManager) GetEvents(accountID string, from, to time.Time) ([]*activity.Event, error) {
+	if am.GetEventsFunc != nil {
+		return am.GetEventsFunc(accountID, from, to)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method GetEvents not implemented")
+}
+
+// GetAuditEvents mock implementation of GetAuditEvents from server.AccountManager interface
+func (am *MockAccountManager) GetAuditEvents(accountID string, filter server.AuditFilter) ([]*activity.Event, int, error) {
+	if am.GetAuditEventsFunc != nil {
+		return am.GetAuditEventsFunc(accountID, filter)
+	}
+	return nil, 0, status.Errorf(codes.Unimplemented, "method GetAuditEvents not implemented")
+}
+
+func (am *MockAccountManager) GetPeersQuota(accountId string) (*server.PeersQuota, error) {
+	if am.GetPeersQuotaFunc != nil {
+		return am.GetPeersQuotaFunc(accountId)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method GetPeersQuota not implemented")
+}
+
+func (am *MockAccountManager) StartDeviceAuth(peerKey string) (*server.DeviceAuth, error) {
+	if am.StartDeviceAuthFunc != nil {
+		return am.StartDeviceAuthFunc(peerKey)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "method StartDeviceAuth not implemented")
+}
+
+func (am *MockAccountManager) ApproveDeviceAuth(userCode string, userID string) error {
+	if am.ApproveDeviceAuthFunc != nil {
+		return am.ApproveDeviceAuthFunc(userCode, userID)
+	}
+	return status.Errorf(codes.Unimplemented, "method ApproveDeviceAuth not implemented")
+}
+
+func (am *MockAccountManager) PollDeviceAuth(deviceCode string, peerKey string) (string, error) {
+	if am.PollDeviceAuthFunc != nil {
+		return am.PollDeviceAuthFunc(deviceCode, peerKey)
+	}
+	return "", status.Errorf(codes.Unimplemented, "method PollDeviceAuth not implemented")
+}
+
+func (am *MockAccountManager) RedeemDeviceAuth(token string, peerKey string) (string, error) {
+	if am.RedeemDeviceAuthFunc != nil {
+		return am.RedeemDeviceAuthFunc(token, peerKey)
+	}
+	return "", status.Errorf(codes.Unimplemented, "method RedeemDeviceAuth not implemented")
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/management/proto"
+)
+
+// PeerCertificateInterceptors return the unary and the stream interceptors rejecting the requests of a peer unless the
+// common name of its client certificate is its Wireguard public key, see ClientAuthConfig.PinPeerKey.
+// The requests not made on behalf of a peer (e.g. GetServerKey) are let through
+func PeerCertificateInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		err := checkPeerCertificate(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &pinnedServerStream{ServerStream: ss})
+	}
+
+	return unary, stream
+}
+
+// pinnedServerStream checks the peer certificate against the messages received from a stream, e.g. the Sync request
+type pinnedServerStream struct {
+	grpc.ServerStream
+}
+
+func (s *pinnedServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	return checkPeerCertificate(s.Context(), m)
+}
+
+// checkPeerCertificate verifies that the message of a peer comes from the connection authenticated with the client
+// certificate of the peer
+func checkPeerCertificate(ctx context.Context, msg interface{}) error {
+	encrypted, ok := msg.(*proto.EncryptedMessage)
+	if !ok {
+		return nil
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no client certificate presented")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return status.Error(codes.Unauthenticated, "no client certificate presented")
+	}
+
+	commonName := tlsInfo.State.PeerCertificates[0].Subject.CommonName
+	if commonName != encrypted.GetWgPubKey() {
+		log.Warnf("rejected request of peer %s authenticated with client certificate %s", encrypted.GetWgPubKey(), commonName)
+		return status.Errorf(codes.PermissionDenied, "client certificate %s doesn't belong to peer %s",
+			commonName, encrypted.GetWgPubKey())
+	}
+	return nil
+}
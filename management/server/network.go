@@ -1,7 +1,10 @@
 package server
 
 import (
+	"encoding/binary"
+	"fmt"
 	"github.com/c-robinson/iplib"
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/rs/xid"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -11,9 +14,27 @@ import (
 	"time"
 )
 
+const (
+	// MinNetworkPrefixLen is the shortest prefix (the largest network) allowed for an account network
+	MinNetworkPrefixLen = 8
+	// MaxNetworkPrefixLen is the longest prefix (the smallest network) allowed for an account network
+	MaxNetworkPrefixLen = 30
+)
+
 type NetworkMap struct {
 	Peers   []*Peer
 	Network *Network
+	// PresenceSharing indicates that the presence of the Peers is shared
+	PresenceSharing bool
+	// PresenceDisconnectDelay is how long a disconnect of a Peer lasts before it is shared, see PresenceSharing
+	PresenceDisconnectDelay time.Duration
+	// Routes are the routes advertised to the peer, their prefixes are routed through the routing Peers
+	Routes []*Route
+	// DNSSettings are the name resolution settings of the account, nil if it has none
+	DNSSettings *DNSSettings
+	// SSHAllowedPeers are the keys of the Peers allowed to connect to the SSH server of the peer,
+	// nil if the SSH server is disabled
+	SSHAllowedPeers []string
 }
 
 type Network struct {
@@ -45,6 +66,12 @@ func NewNetwork() *Network {
 		Serial: 0}
 }
 
+// PrefixLen returns the prefix length of the network (e.g. 16 for 100.64.0.0/16)
+func (n *Network) PrefixLen() int {
+	ones, _ := n.Net.Mask.Size()
+	return ones
+}
+
 // IncSerial increments Serial by 1 reflecting that the network state has been changed
 func (n *Network) IncSerial() {
 	n.mu.Lock()
@@ -68,62 +95,141 @@ func (n *Network) Copy() *Network {
 	}
 }
 
-// AllocatePeerIP pics an available IP from an net.IPNet.
-// This method considers already taken IPs and reuses IPs if there are gaps in takenIps
-// E.g. if ipNet=100.30.0.0/16 and takenIps=[100.30.0.1, 100.30.0.4] then the result would be 100.30.0.2 or 100.30.0.3
+// AllocatePeerIP picks the lowest available IP from an net.IPNet.
+// This method considers already taken IPs and reuses IPs of the removed peers, so the allocation is deterministic.
+// The network address, the first address (reserved as a gateway), the broadcast address and addresses ending with .0 are never allocated.
+// E.g. if ipNet=100.30.0.0/16 and takenIps=[100.30.0.2, 100.30.0.4] then the result would be 100.30.0.3
+// The caller has to hold a lock preventing concurrent allocations within the same network (e.g. the account lock of the Store)
 func AllocatePeerIP(ipNet net.IPNet, takenIps []net.IP) (net.IP, error) {
-	takenIPMap := make(map[string]struct{})
-	takenIPMap[ipNet.IP.String()] = struct{}{}
+	first, last, ok := hostRange(ipNet)
+	if !ok {
+		return nil, status.Errorf(codes.OutOfRange, "failed allocating new IP for the ipNet %s - network is too small", ipNet.String())
+	}
+
+	taken := make(map[uint32]struct{}, len(takenIps))
 	for _, ip := range takenIps {
-		takenIPMap[ip.String()] = struct{}{}
+		if ip4 := ip.To4(); ip4 != nil {
+			taken[binary.BigEndian.Uint32(ip4)] = struct{}{}
+		}
+	}
+
+	for candidate := first; candidate <= last; candidate++ {
+		if _, ok := taken[candidate]; ok || candidate&0xff == 0 {
+			continue
+		}
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, candidate)
+		return ip, nil
 	}
 
-	ips, _ := generateIPs(&ipNet, takenIPMap)
+	return nil, status.Errorf(codes.OutOfRange, "failed allocating new IP for the ipNet %s - network is out of IPs", ipNet.String())
+}
 
-	if len(ips) == 0 {
-		return nil, status.Errorf(codes.OutOfRange, "failed allocating new IP for the ipNet %s - network is out of IPs", ipNet.String())
+// hostRange returns the first and the last IPv4 address (as uint32) that can be allocated to peers in the ipNet.
+// Returns false if there are no such addresses
+func hostRange(ipNet net.IPNet) (uint32, uint32, bool) {
+	ip4 := ipNet.IP.To4()
+	ones, bits := ipNet.Mask.Size()
+	if ip4 == nil || bits != 32 || ones > 30 {
+		return 0, 0, false
 	}
 
-	// pick a random IP
-	s := rand.NewSource(time.Now().Unix())
-	r := rand.New(s)
-	intn := r.Intn(len(ips))
+	network, broadcast := networkBounds(ip4, ones)
 
-	return ips[intn], nil
+	// skip the network and the gateway addresses, and the broadcast address
+	return network + 2, broadcast - 1, network+2 <= broadcast-1
 }
 
-// generateIPs generates a list of all possible IPs of the given network excluding IPs specified in the exclusion list
-func generateIPs(ipNet *net.IPNet, exclusions map[string]struct{}) ([]net.IP, int) {
-
-	var ips []net.IP
-	for ip := ipNet.IP.Mask(ipNet.Mask); ipNet.Contains(ip); incIP(ip) {
-		if _, ok := exclusions[ip.String()]; !ok && ip[3] != 0 {
-			ips = append(ips, copyIP(ip))
-		}
+// validateNetwork checks whether the ipNet can be used as an account network
+func validateNetwork(ipNet net.IPNet) error {
+	ones, bits := ipNet.Mask.Size()
+	if ipNet.IP.To4() == nil || bits != 32 {
+		return fmt.Errorf("network %s is not an IPv4 network", ipNet.String())
+	}
+	if ones < MinNetworkPrefixLen || ones > MaxNetworkPrefixLen {
+		return fmt.Errorf("network prefix length has to be between /%d and /%d, got /%d",
+			MinNetworkPrefixLen, MaxNetworkPrefixLen, ones)
 	}
+	return nil
+}
 
-	// remove network address and broadcast address
-	lenIPs := len(ips)
-	switch {
-	case lenIPs < 2:
-		return ips, lenIPs
+// networkBounds returns the network and the broadcast address (as uint32) of the IPv4 network with the prefix length
+func networkBounds(ip4 net.IP, ones int) (uint32, uint32) {
+	mask := ^uint32(0) << (32 - ones)
+	network := binary.BigEndian.Uint32(ip4) & mask
+	return network, network | ^mask
+}
 
-	default:
-		return ips[1 : len(ips)-1], lenIPs - 2
+// isHostIP checks whether the ip belongs to the ipNet and isn't its network, gateway (the first address) or broadcast
+// address, which are never allocated to the peers
+func isHostIP(ipNet net.IPNet, ip net.IP) bool {
+	ip4 := ip.To4()
+	if ip4 == nil || !ipNet.Contains(ip4) || ipNet.IP.To4() == nil {
+		return false
 	}
-}
 
-func copyIP(ip net.IP) net.IP {
-	dup := make(net.IP, len(ip))
-	copy(dup, ip)
-	return dup
+	ones, _ := ipNet.Mask.Size()
+	network, broadcast := networkBounds(ipNet.IP.To4(), ones)
+	value := binary.BigEndian.Uint32(ip4)
+	return value != network && value != network+1 && value != broadcast
 }
 
-func incIP(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+// UpdateAccountNetwork changes the network (CIDR) peers of the account get their IPs from.
+// Already registered peers keep their IPs, therefore all of them have to belong to the new network.
+// Peers get the new network prefix on the next login
+func (am *DefaultAccountManager) UpdateAccountNetwork(accountID string, ipNet net.IPNet, userID string) (*Network, error) {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	ipNet = net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask}
+	err := validateNetwork(ipNet)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid network: %v", err)
+	}
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	for _, peer := range account.Peers {
+		if !isHostIP(ipNet, peer.IP) {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"network %s collides with peer %s having IP %s", ipNet.String(), peer.Name, peer.IP)
+		}
+	}
+
+	for _, reservation := range account.IPReservations {
+		if !isHostIP(ipNet, reservation.IP) {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"network %s collides with IP %s reserved for peer %s", ipNet.String(), reservation.IP, reservation.peer())
+		}
+	}
+
+	for _, route := range account.Routes {
+		_, prefix, err := net.ParseCIDR(route.Prefix)
+		if err == nil && (prefix.Contains(ipNet.IP) || ipNet.Contains(prefix.IP)) {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"network %s overlaps route %s through peer %s", ipNet.String(), route.Prefix, route.Peer)
 		}
 	}
+
+	event := newAuditEvent(userID, accountID, accountID, activity.AccountNetworkUpdated,
+		map[string]string{"network": ipNet.String()},
+		map[string]string{"Net": account.Network.Net.String()}, map[string]string{"Net": ipNet.String()})
+
+	account.Network.Net = ipNet
+	account.Network.IncSerial()
+
+	err = am.saveAccount(account, event)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed updating account network")
+	}
+
+	err = am.updateAccountPeers(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return account.Network.Copy(), nil
 }
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net"
 	"testing"
 )
@@ -14,6 +17,24 @@ func TestNewNetwork(t *testing.T) {
 	assert.Equal(t, ipNet.Contains(network.Net.IP), true)
 }
 
+func TestIsHostIP(t *testing.T) {
+	_, ipNet, err := net.ParseCIDR("100.64.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"100.64.0.0":   false,
+		"100.64.0.1":   false,
+		"100.64.0.2":   true,
+		"100.64.0.254": true,
+		"100.64.0.255": false,
+		"100.64.1.2":   false,
+	} {
+		assert.Equal(t, expected, isHostIP(*ipNet, net.ParseIP(ip)), "host IP %s", ip)
+	}
+}
+
 func TestAllocatePeerIP(t *testing.T) {
 
 	ipNet := net.IPNet{IP: net.ParseIP("100.64.0.0"), Mask: net.IPMask{255, 255, 255, 0}}
@@ -37,3 +58,165 @@ func TestAllocatePeerIP(t *testing.T) {
 		}
 	}
 }
+
+func TestAllocatePeerIP_LowestFree(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("100.64.0.0/24")
+
+	ip, err := AllocatePeerIP(*ipNet, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "100.64.0.2", ip.String(), "network and gateway addresses should be skipped")
+
+	ip, err = AllocatePeerIP(*ipNet, []net.IP{net.ParseIP("100.64.0.2"), net.ParseIP("100.64.0.4")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "100.64.0.3", ip.String(), "the lowest free IP should be reused")
+
+	var taken []net.IP
+	for i := 2; i < 254; i++ {
+		taken = append(taken, net.IPv4(100, 64, 0, byte(i)))
+	}
+	ip, err = AllocatePeerIP(*ipNet, taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "100.64.0.254", ip.String())
+
+	_, err = AllocatePeerIP(*ipNet, append(taken, ip))
+	assert.Equal(t, codes.OutOfRange, status.Code(err), "broadcast address should never be allocated")
+}
+
+func TestAccountManager_ReusePeerIPs(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 13 peers fit into a /28 network (16 addresses without network, gateway and broadcast)
+	_, ipNet, _ := net.ParseCIDR("10.10.0.0/28")
+	_, err = manager.UpdateAccountNetwork(account.Id, *ipNet, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey string
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key.Key
+		}
+	}
+
+	registered := 0
+	addPeer := func() (*Peer, error) {
+		registered++
+		return manager.AddPeer(setupKey, "", &Peer{
+			Key:  fmt.Sprintf("peer-key-%d", registered),
+			Name: fmt.Sprintf("peer-%d", registered),
+		})
+	}
+
+	var peers []*Peer
+	for i := 0; i < 13; i++ {
+		peer, err := addPeer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+
+	_, err = addPeer()
+	assert.Equal(t, codes.OutOfRange, status.Code(err), "network should be out of IPs")
+
+	// churn: delete and re-register peers many more times than the network size
+	for round := 0; round < 10; round++ {
+		for _, i := range []int{7, 3} {
+			_, err = manager.DeletePeer(account.Id, peers[i].Key, "account_creator")
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the lowest free address is reused first
+		for _, i := range []int{3, 7} {
+			released := peers[i].IP
+			peer, err := addPeer()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, released.String(), peer.IP.String())
+			peers[i] = peer
+		}
+	}
+}
+
+func TestUpdateAccountNetwork(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ipNet, _ := net.ParseCIDR("10.10.0.0/20")
+	network, err := manager.UpdateAccountNetwork(account.Id, *ipNet, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "10.10.0.0/20", network.Net.String())
+
+	var setupKey string
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key.Key
+		}
+	}
+
+	peer, err := manager.AddPeer(setupKey, "", &Peer{
+		Key:  "peer-key",
+		Name: "test-peer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, ipNet.Contains(peer.IP), "peer IP should be allocated from the account network")
+	assert.Equal(t, peer.IP.String()+"/20", toPeerConfig(peer, network).GetAddress())
+
+	// the connected peers receive their address with the new prefix length
+	updates := manager.peersUpdateManager.CreateChannel(peer.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer.Key)
+
+	_, widerNet, _ := net.ParseCIDR("10.10.0.0/16")
+	_, err = manager.UpdateAccountNetwork(account.Id, *widerNet, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case update := <-updates:
+		assert.Equal(t, peer.IP.String()+"/16", update.Update.GetPeerConfig().GetAddress())
+		assert.Equal(t, peer.IP.String()+"/16", update.Update.GetNetworkMap().GetPeerConfig().GetAddress())
+	default:
+		t.Fatal("expecting the peer to receive an update after changing the account network")
+	}
+
+	_, collidingNet, _ := net.ParseCIDR("10.20.0.0/16")
+	_, err = manager.UpdateAccountNetwork(account.Id, *collidingNet, "account_creator")
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err), "network not containing peer IPs should be rejected")
+
+	_, smallNet, _ := net.ParseCIDR("10.10.0.0/31")
+	_, err = manager.UpdateAccountNetwork(account.Id, *smallNet, "account_creator")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "too small network should be rejected")
+
+	_, v6Net, _ := net.ParseCIDR("fd00::/64")
+	_, err = manager.UpdateAccountNetwork(account.Id, *v6Net, "account_creator")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "IPv6 network should be rejected")
+}
@@ -1,17 +1,35 @@
 package server
 
 import (
+	"fmt"
 	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	log "github.com/sirupsen/logrus"
-
 	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server/activity"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// UnknownVersion is a client version assigned to peers that haven't reported it yet
+const UnknownVersion = "unknown"
+
+// maxPeerNameLength is the maximal length of a peer name set by a user, the maximal length of a DNS label
+const maxPeerNameLength = 63
+
+// defaultPeerName is the name of a peer registered without a hostname a valid name can be derived from
+const defaultPeerName = "peer"
+
+// peerNameRegexp matches a DNS label (RFC 1123)
+var peerNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+// invalidPeerNameChars matches the runs of characters not allowed in a DNS label
+var invalidPeerNameChars = regexp.MustCompile(`[^a-zA-Z0-9-]+`)
+
 // PeerSystemMeta is a metadata of a Peer machine system
 type PeerSystemMeta struct {
 	Hostname  string
@@ -22,6 +40,104 @@ type PeerSystemMeta struct {
 	OS        string
 	WtVersion string
 	UIVersion string
+	// Labels are user defined labels reported by the peer
+	Labels map[string]string
+	// WgPort is the listen port of the Wireguard interface of the peer, 0 if it hasn't been reported yet
+	WgPort int
+	// DiskEncrypted indicates that the peer has reported an encrypted system disk
+	DiskEncrypted bool
+	// FirewallEnabled indicates that the peer has reported an enabled host firewall
+	FirewallEnabled bool
+	// SSHPubKey is the public SSH key of the peer in the authorized_keys format, empty if the peer doesn't support SSH
+	SSHPubKey string
+}
+
+// Copy copies PeerSystemMeta object
+func (m PeerSystemMeta) Copy() PeerSystemMeta {
+	var labels map[string]string
+	if m.Labels != nil {
+		labels = make(map[string]string, len(m.Labels))
+		for k, v := range m.Labels {
+			labels[k] = v
+		}
+	}
+	m.Labels = labels
+	return m
+}
+
+// HasLabels checks whether the peer has all the provided labels.
+// A label with an empty value matches any value of the label with the same key
+func (m PeerSystemMeta) HasLabels(labels map[string]string) bool {
+	for k, v := range labels {
+		value, ok := m.Labels[k]
+		if !ok || (v != "" && value != v) {
+			return false
+		}
+	}
+	return true
+}
+
+// VersionOlderThan checks whether the peer runs a client version older than the provided one.
+// Peers reporting a version that can't be parsed (e.g. development builds or unknown) never match
+func (m PeerSystemMeta) VersionOlderThan(version string) bool {
+	result, err := CompareVersions(m.WtVersion, version)
+	if err != nil {
+		return false
+	}
+	return result < 0
+}
+
+// ParseVersion parses a version string of the form [v]MAJOR[.MINOR[.PATCH]][-suffix] into its numeric components.
+// Pre-release and build suffixes are ignored
+func ParseVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid version %q", version)
+	}
+
+	parts := strings.Split(trimmed, ".")
+	parsed := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version %q", version)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// CompareVersions compares two versions returning -1 if a is older than b, 1 if a is newer than b and 0 if they are equal.
+// Missing components are treated as 0, e.g. 0.6 equals 0.6.0
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x < y {
+			return -1, nil
+		}
+		if x > y {
+			return 1, nil
+		}
+	}
+	return 0, nil
 }
 
 type PeerStatus struct {
@@ -29,6 +145,10 @@ type PeerStatus struct {
 	LastSeen time.Time
 	// Connected indicates whether peer is connected to the management service or not
 	Connected bool
+	// LoginExpired indicates that the login of the peer has expired and it is excluded from the network maps of other peers
+	LoginExpired bool
+	// DisconnectedAt is the time the Management service has detected the peer disconnecting
+	DisconnectedAt time.Time
 }
 
 // Peer represents a machine connected to the network.
@@ -47,26 +167,93 @@ type Peer struct {
 	Status *PeerStatus
 	// The user ID that registered the peer
 	UserID string
+	// RateLimit is the egress rate limit in kbit/s other peers apply to the traffic sent to this peer. 0 means unlimited
+	RateLimit uint64
+	// StaticEndpoint is a well-known public endpoint (host:port) of this peer other peers connect to directly
+	// without negotiating the connection. Empty means the connection is always negotiated
+	StaticEndpoint string
+	// RouteMetric is the metric of the routes this peer installs through its Wireguard interface.
+	// 0 keeps the default metric of the OS
+	RouteMetric uint32
+	// LastLogin is the last time the user that registered the peer has logged in with it
+	LastLogin time.Time
+	// Suspended indicates that the peer is cut off from the network by an admin: it is excluded from the network maps
+	// of other peers and gets an empty one itself, but keeps its IP, key and groups
+	Suspended bool
+	// AllowedIPsConflicts are the conflicting allowed IPs of the remote peers the peer has reported in its latest
+	// network map, empty if there are none
+	AllowedIPsConflicts []AllowedIPsConflict
+	// SSHEnabled enables the SSH server of the peer, the peer runs it only if its config allows it as well
+	SSHEnabled bool
+	// SSHAllowedGroups are the groups of the remote peers allowed to connect to the SSH server of the peer.
+	// None of the remote peers are allowed if empty
+	SSHAllowedGroups []string
+	// Tags are free-form labels of the form value or key:value (e.g. role:db) set by the account admins.
+	// The rules select the peers by their tags and the peers having a tag are resolved by its name in DNS
+	Tags []string
+}
+
+// AllowedIPsConflict is an allowed IP of a remote peer a peer has refused to apply because another remote peer
+// already has the same or an overlapping one
+type AllowedIPsConflict struct {
+	// Prefix is the allowed IP of Peer that keeps it
+	Prefix string
+	Peer   string
+	// ConflictingPrefix is the allowed IP refused to ConflictingPeer, equal to Prefix for a duplicate
+	ConflictingPrefix string
+	ConflictingPeer   string
+	// Duplicate distinguishes the same prefix of both peers from different but overlapping prefixes
+	Duplicate bool
+}
+
+// Copy copies PeerStatus object
+func (p *PeerStatus) Copy() *PeerStatus {
+	if p == nil {
+		return nil
+	}
+	return &PeerStatus{
+		LastSeen:       p.LastSeen,
+		Connected:      p.Connected,
+		LoginExpired:   p.LoginExpired,
+		DisconnectedAt: p.DisconnectedAt,
+	}
 }
 
 // Copy copies Peer object
 func (p *Peer) Copy() *Peer {
 	return &Peer{
-		Key:      p.Key,
-		SetupKey: p.SetupKey,
-		IP:       p.IP,
-		Meta:     p.Meta,
-		Name:     p.Name,
-		Status:   p.Status,
-		UserID:   p.UserID,
+		Key:            p.Key,
+		SetupKey:       p.SetupKey,
+		IP:             p.IP,
+		Meta:           p.Meta.Copy(),
+		Name:           p.Name,
+		Status:         p.Status.Copy(),
+		UserID:         p.UserID,
+		RateLimit:      p.RateLimit,
+		StaticEndpoint: p.StaticEndpoint,
+		RouteMetric:    p.RouteMetric,
+		LastLogin:      p.LastLogin,
+		Suspended:      p.Suspended,
+		// the conflicts are replaced as a whole on each report, never modified in place
+		AllowedIPsConflicts: p.AllowedIPsConflicts,
+		SSHEnabled:          p.SSHEnabled,
+		SSHAllowedGroups:    append([]string(nil), p.SSHAllowedGroups...),
+		Tags:                append([]string(nil), p.Tags...),
+	}
+}
+
+// setPeerDefaults sets the status and system meta data that peers stored by older versions of the Management service may miss
+func setPeerDefaults(peer *Peer) {
+	if peer.Status == nil {
+		peer.Status = &PeerStatus{}
+	}
+	if peer.Meta.WtVersion == "" {
+		peer.Meta.WtVersion = UnknownVersion
 	}
 }
 
 // GetPeer returns a peer from a Store
 func (am *DefaultAccountManager) GetPeer(peerKey string) (*Peer, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
-
 	peer, err := am.Store.GetPeer(peerKey)
 	if err != nil {
 		return nil, err
@@ -75,48 +262,94 @@ func (am *DefaultAccountManager) GetPeer(peerKey string) (*Peer, error) {
 	return peer, nil
 }
 
-// MarkPeerConnected marks peer as connected (true) or disconnected (false)
-func (am *DefaultAccountManager) MarkPeerConnected(peerKey string, connected bool) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
-
-	peer, err := am.Store.GetPeer(peerKey)
+// lockPeerAccount acquires the lock of the account a peer belongs to and returns the account read holding the lock.
+// The caller has to release the lock calling unlock if no error is returned
+func (am *DefaultAccountManager) lockPeerAccount(peerKey string) (account *Account, unlock func(), err error) {
+	account, err = am.Store.GetPeerAccount(peerKey)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	account, err := am.Store.GetPeerAccount(peerKey)
+	unlock = am.Store.AcquireAccountLock(account.Id)
+
+	// the peer could have been removed while waiting for the lock
+	account, err = am.Store.GetPeerAccount(peerKey)
 	if err != nil {
-		return err
+		unlock()
+		return nil, nil, err
 	}
 
-	peerCopy := peer.Copy()
-	peerCopy.Status.LastSeen = time.Now()
-	peerCopy.Status.Connected = connected
-	err = am.Store.SavePeer(account.Id, peerCopy)
+	return account, unlock, nil
+}
+
+// MarkPeerConnected marks peer as connected (true) or disconnected (false)
+func (am *DefaultAccountManager) MarkPeerConnected(peerKey string, connected bool) error {
+	account, unlock, err := am.lockPeerAccount(peerKey)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer unlock()
+
+	now := time.Now()
+	return am.savePeerPresence(account, peerKey, func(status *PeerStatus) {
+		status.LastSeen = now
+		if status.Connected && !connected {
+			status.DisconnectedAt = now
+		}
+		status.Connected = connected
+	})
 }
 
-// RenamePeer changes peer's name
+// RenamePeer changes peer's name. An empty name resets it to the peer's hostname.
+// The name has to be unique within the account, compared case-insensitively as DNS names are.
+// The peers that can reach the peer receive an updated network map with the new name
 func (am *DefaultAccountManager) RenamePeer(
 	accountId string,
 	peerKey string,
 	newName string,
+	userID string,
 ) (*Peer, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
-	peer, err := am.Store.GetPeer(peerKey)
+	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	if newName == "" {
+		newName = uniquePeerName(account, peerKey, peerNameFromHostname(peer.Meta.Hostname))
+	} else if err = validatePeerName(newName); err != nil {
 		return nil, err
 	}
 
+	if peer.Name == newName {
+		return peer.Copy(), nil
+	}
+
+	for key, other := range account.Peers {
+		if key != peerKey && strings.EqualFold(other.Name, newName) {
+			return nil, status.Errorf(codes.AlreadyExists, "peer name %s is already used by peer %s", newName, other.IP)
+		}
+	}
+
 	peerCopy := peer.Copy()
 	peerCopy.Name = newName
-	err = am.Store.SavePeer(accountId, peerCopy)
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateReachablePeers(account, peerKey)
 	if err != nil {
 		return nil, err
 	}
@@ -124,23 +357,443 @@ func (am *DefaultAccountManager) RenamePeer(
 	return peerCopy, nil
 }
 
-// DeletePeer removes peer from the account by it's IP
-func (am *DefaultAccountManager) DeletePeer(accountId string, peerKey string) (*Peer, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// validatePeerName checks that a peer name set by a user is a valid DNS label: not longer than maxPeerNameLength,
+// consisting of letters, digits and dashes only and starting and ending with a letter or a digit
+func validatePeerName(name string) error {
+	if len(name) > maxPeerNameLength {
+		return status.Errorf(codes.InvalidArgument, "peer name is longer than %d characters", maxPeerNameLength)
+	}
+	if !peerNameRegexp.MatchString(name) {
+		return status.Errorf(codes.InvalidArgument,
+			"peer name %q has to start and end with a letter or a digit and may contain only letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// peerNameFromHostname derives a valid peer name from the hostname a peer has reported: the first label of the hostname
+// with the characters not allowed in a DNS label replaced with dashes, trimmed to maxPeerNameLength.
+// Returns defaultPeerName if no letter or digit is left
+func peerNameFromHostname(hostname string) string {
+	if i := strings.IndexByte(hostname, '.'); i >= 0 {
+		hostname = hostname[:i]
+	}
+	name := strings.Trim(invalidPeerNameChars.ReplaceAllString(hostname, "-"), "-")
+	if len(name) > maxPeerNameLength {
+		name = strings.TrimRight(name[:maxPeerNameLength], "-")
+	}
+	if name == "" {
+		return defaultPeerName
+	}
+	return name
+}
+
+// uniquePeerName returns the name if no other peer of the account has it or the name with the lowest numeric suffix
+// (e.g. laptop-2) no other peer has, compared case-insensitively
+func uniquePeerName(account *Account, peerKey string, name string) string {
+	taken := func(candidate string) bool {
+		for key, other := range account.Peers {
+			if key != peerKey && strings.EqualFold(other.Name, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+
+	candidate := name
+	for i := 2; taken(candidate); i++ {
+		suffix := "-" + strconv.Itoa(i)
+		base := name
+		if len(base)+len(suffix) > maxPeerNameLength {
+			base = strings.TrimRight(base[:maxPeerNameLength-len(suffix)], "-")
+		}
+		candidate = base + suffix
+	}
+	return candidate
+}
+
+// UpdatePeerRateLimit sets the egress rate limit in kbit/s the peers connected to a given peer apply to the traffic sent to it.
+// 0 removes the limit. The peers that can reach the peer receive an updated network map
+func (am *DefaultAccountManager) UpdatePeerRateLimit(accountId string, peerKey string, rateLimit uint64, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.RateLimit = rateLimit
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateReachablePeers(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// UpdatePeerStaticEndpoint sets a static endpoint (host:port) the peers connected to a given peer use to configure
+// Wireguard directly, skipping the connection negotiation. An empty endpoint removes it
+func (am *DefaultAccountManager) UpdatePeerStaticEndpoint(accountId string, peerKey string, endpoint string, userID string) (*Peer, error) {
+	if endpoint != "" {
+		_, port, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid static endpoint %s, expecting host:port", endpoint)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid port of static endpoint %s", endpoint)
+		}
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.StaticEndpoint = endpoint
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateReachablePeers(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// UpdatePeerRouteMetric sets the metric of the routes a given peer installs through its Wireguard interface,
+// so that the account admin controls whether they win over the routes of other interfaces (e.g. a corporate VPN).
+// 0 restores the default metric of the OS. The peer gets the metric with its next network map
+func (am *DefaultAccountManager) UpdatePeerRouteMetric(accountId string, peerKey string, metric uint32, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.RouteMetric = metric
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	// the metric is a setting of the peer itself, the peers that can reach it aren't affected
+	err = am.sendNetworkMap(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// UpdatePeerSSH enables or disables the SSH server of a given peer and sets the groups of the remote peers allowed
+// to connect to it, at least one group is required to enable it. The peer runs the server only if its config allows
+// it as well. The peer gets the settings with its next network map
+func (am *DefaultAccountManager) UpdatePeerSSH(accountId string, peerKey string, enabled bool, allowedGroups []string, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	if enabled && len(allowedGroups) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "enabling the SSH server requires at least one allowed group")
+	}
+	for _, groupID := range allowedGroups {
+		if _, ok := account.Groups[groupID]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "group %s not found", groupID)
+		}
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.SSHEnabled = enabled
+	peerCopy.SSHAllowedGroups = allowedGroups
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	// the SSH server is a setting of the peer itself, the peers that can reach it aren't affected
+	err = am.sendNetworkMap(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// SuspendPeer suspends or resumes a peer. Unlike the removal, a suspended peer keeps its IP, key and groups,
+// it is excluded from the network maps of other peers and its own network map is empty until it is resumed
+func (am *DefaultAccountManager) SuspendPeer(accountId string, peerKey string, suspended bool, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	if peer.Suspended == suspended {
+		return peer.Copy(), nil
+	}
+
+	// the peers reaching the peer while it isn't suspended get it removed or added back
+	var affected []*ReachablePeer
+	if suspended {
+		affected = am.getReachablePeers(account, peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.Suspended = suspended
+	account.Peers[peerKey] = peerCopy
+
+	if !suspended {
+		affected = am.getReachablePeers(account, peerKey)
+	}
+
+	event := activity.PeerResumed
+	if suspended {
+		event = activity.PeerSuspended
+	}
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, event,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.sendNetworkMap(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reachable := range affected {
+		err = am.sendNetworkMap(account, reachable.Peer.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return peerCopy, nil
+}
+
+// UpdatePeerIP assigns a fixed IP of the account network to the peer (e.g. a gateway or a DNS server).
+// The peer gets the new address with its next network map and readdresses its interface,
+// the peers that can reach it get the new allowed IP
+func (am *DefaultAccountManager) UpdatePeerIP(accountId string, peerKey string, ip net.IP, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "account not found")
 	}
 
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	if peer.IP.Equal(ip) {
+		return peer.Copy(), nil
+	}
+
+	err = validatePeerIP(account, peerKey, peer.Name, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.IP = ip.To4()
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.sendNetworkMap(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateReachablePeers(account, peerKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// validatePeerIP checks that the ip is a host IP of the account network neither taken by a peer other than the peerKey
+// one nor reserved for another peer
+func validatePeerIP(account *Account, peerKey, peerName string, ip net.IP) error {
+	if !isHostIP(account.Network.Net, ip) {
+		return status.Errorf(codes.InvalidArgument, "IP %s isn't a host of the account network %s", ip, account.Network.Net.String())
+	}
+
+	for _, reservation := range account.IPReservations {
+		if reservation.IP.Equal(ip) && !reservation.reservedFor(peerKey, peerName) {
+			return status.Errorf(codes.AlreadyExists, "IP %s is reserved for peer %s", ip, reservation.peer())
+		}
+	}
+
+	for key, other := range account.Peers {
+		if key != peerKey && other.IP.Equal(ip) {
+			return status.Errorf(codes.AlreadyExists, "IP %s is already assigned to peer %s", ip, other.Name)
+		}
+	}
+
+	return nil
+}
+
+// updateReachablePeers sends an updated network map to the peers that can reach a given peer (e.g. after a change of its settings)
+func (am *DefaultAccountManager) updateReachablePeers(account *Account, peerKey string) error {
+	for _, reachable := range am.getReachablePeers(account, peerKey) {
+		err := am.sendNetworkMap(account, reachable.Peer.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateAccountPeers sends the updated network map to all the peers of the account (e.g. after a change of the routes),
+// a suspended peer keeps its empty network map
+func (am *DefaultAccountManager) updateAccountPeers(account *Account) error {
+	for _, peer := range account.Peers {
+		if peer.Suspended {
+			continue
+		}
+		err := am.sendNetworkMap(account, peer.Key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendNetworkMap sends the current network map to a given peer
+func (am *DefaultAccountManager) sendNetworkMap(account *Account, peerKey string) error {
+	var peersToSend []*Peer
+	for _, remote := range am.getReachablePeers(account, peerKey) {
+		peersToSend = append(peersToSend, remote.Peer)
+	}
+	update := toRemotePeerConfig(peersToSend, account.PresenceSharing, am.presenceDisconnectDelay)
+	routes := toProtoRoutes(getPeerRoutes(account, peerKey, peersToSend))
+	// the peer config carries the settings of the peer itself (e.g. its address), which can change too
+	peerConfig := toPeerConfig(account.Peers[peerKey], account.Network)
+	peerConfig.SshConfig = toProtoSSHConfig(getSSHAllowedPeers(account, peerKey, peersToSend))
+	return am.peersUpdateManager.SendUpdate(peerKey,
+		&UpdateMessage{
+			Update: &proto.SyncResponse{
+				// fill those field for backward compatibility
+				PeerConfig:         peerConfig,
+				RemotePeers:        update,
+				RemotePeersIsEmpty: len(update) == 0,
+				// new field
+				NetworkMap: &proto.NetworkMap{
+					Serial:             account.Network.CurrentSerial(),
+					PeerConfig:         peerConfig,
+					RemotePeers:        update,
+					RemotePeersIsEmpty: len(update) == 0,
+					Routes:             routes,
+					DnsConfig:          toProtoDNSConfig(withTagDNSRecords(account.DNSSettings, getTagDNSRecords(account, peerKey, peersToSend))),
+				},
+			},
+		})
+}
+
+// DeletePeer removes peer from the account by it's IP. The userID is the user who initiated the removal
+func (am *DefaultAccountManager) DeletePeer(accountId string, peerKey string, userID string) (*Peer, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
 	peer, err := am.Store.DeletePeer(accountId, peerKey)
 	if err != nil {
 		return nil, err
 	}
+	am.connectivity.forget(peerKey)
+
+	// read the account after the peer removal, otherwise saving it would bring the peer back
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	// the routes through the removed peer are gone with it
+	for id, route := range account.Routes {
+		if route.Peer == peerKey {
+			delete(account.Routes, id)
+		}
+	}
 
 	account.Network.IncSerial()
-	err = am.Store.SaveAccount(account)
+	err = am.saveAccount(account, newAuditEvent(userID, peer.Key, accountId, activity.PeerRemovedByUser,
+		map[string]string{"name": peer.Name, "ip": peer.IP.String()}, peer, nil))
 	if err != nil {
 		return nil, err
 	}
@@ -170,13 +823,17 @@ func (am *DefaultAccountManager) DeletePeer(accountId string, peerKey string) (*
 	}
 
 	for _, p := range peers {
+		// a suspended peer keeps its empty network map
+		if p.Suspended {
+			continue
+		}
 		peersToSend := []*Peer{}
 		for _, remote := range peers {
-			if p.Key != remote.Key {
+			if p.Key != remote.Key && !remote.Suspended {
 				peersToSend = append(peersToSend, remote)
 			}
 		}
-		update := toRemotePeerConfig(peersToSend)
+		update := toRemotePeerConfig(peersToSend, account.PresenceSharing, am.presenceDisconnectDelay)
 		err = am.peersUpdateManager.SendUpdate(p.Key,
 			&UpdateMessage{
 				Update: &proto.SyncResponse{
@@ -188,6 +845,8 @@ func (am *DefaultAccountManager) DeletePeer(accountId string, peerKey string) (*
 						Serial:             account.Network.CurrentSerial(),
 						RemotePeers:        update,
 						RemotePeersIsEmpty: len(update) == 0,
+						Routes:             toProtoRoutes(getPeerRoutes(account, p.Key, peersToSend)),
+						DnsConfig:          toProtoDNSConfig(withTagDNSRecords(account.DNSSettings, getTagDNSRecords(account, p.Key, peersToSend))),
 					},
 				},
 			})
@@ -200,10 +859,75 @@ func (am *DefaultAccountManager) DeletePeer(accountId string, peerKey string) (*
 	return peer, nil
 }
 
+// ReplacePeerKey replaces the Wireguard key of a peer keeping its IP, name and groups, e.g. when the peer rotates its key.
+// The updates channel of the old key is closed, the peer syncs again with the new key and
+// the peers that can reach it get the new key with their next network map
+func (am *DefaultAccountManager) ReplacePeerKey(oldKey string, newKey string) (*Peer, error) {
+	if oldKey == newKey {
+		return nil, status.Errorf(codes.InvalidArgument, "the new key of the peer is the same as the old one")
+	}
+
+	account, unlock, err := am.lockPeerAccount(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	peer, ok := account.Peers[oldKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", oldKey)
+	}
+
+	if _, err = am.Store.GetPeer(newKey); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "peer with the key %s is already registered", newKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.Key = newKey
+	delete(account.Peers, oldKey)
+	account.Peers[newKey] = peerCopy
+
+	for _, group := range account.Groups {
+		for i, key := range group.Peers {
+			if key == oldKey {
+				group.Peers[i] = newKey
+			}
+		}
+	}
+
+	for _, route := range account.Routes {
+		if route.Peer == oldKey {
+			route.Peer = newKey
+		}
+	}
+
+	for _, reservation := range account.IPReservations {
+		if reservation.PeerKey == oldKey {
+			reservation.PeerKey = newKey
+		}
+	}
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(oldKey, newKey, account.Id, activity.PeerKeyReplaced,
+		map[string]string{"name": peerCopy.Name, "old_key": oldKey}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	am.peersUpdateManager.CloseChannel(oldKey)
+
+	err = am.updateReachablePeers(account, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
 // GetPeerByIP returns peer by it's IP
 func (am *DefaultAccountManager) GetPeerByIP(accountId string, peerIP string) (*Peer, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountId)
 	if err != nil {
@@ -219,68 +943,140 @@ func (am *DefaultAccountManager) GetPeerByIP(accountId string, peerIP string) (*
 	return nil, status.Errorf(codes.NotFound, "peer with IP %s not found", peerIP)
 }
 
+// ReachablePeer is a remote peer included in the NetworkMap of a peer along with the rules allowing the connection
+type ReachablePeer struct {
+	Peer  *Peer
+	Rules []*Rule
+}
+
 // GetNetworkMap returns Network map for a given peer (omits original peer from the Peers result)
 func (am *DefaultAccountManager) GetNetworkMap(peerKey string) (*NetworkMap, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
-
-	account, err := am.Store.GetPeerAccount(peerKey)
+	account, unlock, err := am.lockPeerAccount(peerKey)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Invalid peer key %s", peerKey)
 	}
+	defer unlock()
 
 	var res []*Peer
+	for _, reachable := range am.getReachablePeers(account, peerKey) {
+		res = append(res, reachable.Peer)
+	}
+
+	return &NetworkMap{
+		Peers:                   res,
+		Network:                 account.Network.Copy(),
+		PresenceSharing:         account.PresenceSharing,
+		PresenceDisconnectDelay: am.presenceDisconnectDelay,
+		Routes:                  getPeerRoutes(account, peerKey, res),
+		DNSSettings:             withTagDNSRecords(account.DNSSettings, getTagDNSRecords(account, peerKey, res)),
+		SSHAllowedPeers:         getSSHAllowedPeers(account, peerKey, res),
+	}, nil
+}
+
+// GetPeerReachability returns the remote peers a given peer can reach along with the rules allowing each connection.
+// The result is computed the same way as the Peers of the peer's NetworkMap
+func (am *DefaultAccountManager) GetPeerReachability(peerKey string) ([]*ReachablePeer, error) {
+	account, unlock, err := am.lockPeerAccount(peerKey)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+	defer unlock()
+
+	return am.getReachablePeers(account, peerKey), nil
+}
+
+// getReachablePeers returns the remote peers of the account allowed by the ACL rules to connect to a given peer.
+// The result is sorted by the peer key
+func (am *DefaultAccountManager) getReachablePeers(account *Account, peerKey string) []*ReachablePeer {
+	// a suspended peer can't reach any peer
+	if peer, ok := account.Peers[peerKey]; ok && peer.Suspended {
+		return []*ReachablePeer{}
+	}
+
 	srcRules, err := am.Store.GetPeerSrcRules(account.Id, peerKey)
 	if err != nil {
-		return &NetworkMap{
-			Peers:   res,
-			Network: account.Network.Copy(),
-		}, nil
+		return nil
 	}
 
 	dstRules, err := am.Store.GetPeerDstRules(account.Id, peerKey)
 	if err != nil {
-		return &NetworkMap{
-			Peers:   res,
-			Network: account.Network.Copy(),
-		}, nil
+		return nil
 	}
 
-	groups := map[string]*Group{}
+	// peer key -> rules allowing the traffic between the peer and the remote peer
+	peerRules := map[string][]*Rule{}
 	for _, r := range srcRules {
 		if r.Flow == TrafficFlowBidirect {
-			for _, gid := range r.Destination {
-				groups[gid] = account.Groups[gid]
+			for _, pid := range rulePeers(account, r.Destination, r.DestinationTags) {
+				peerRules[pid] = append(peerRules[pid], r)
 			}
 		}
 	}
 
 	for _, r := range dstRules {
 		if r.Flow == TrafficFlowBidirect {
-			for _, gid := range r.Source {
-				groups[gid] = account.Groups[gid]
+			for _, pid := range rulePeers(account, r.Source, r.SourceTags) {
+				peerRules[pid] = append(peerRules[pid], r)
 			}
 		}
 	}
 
-	for _, g := range groups {
-		for _, pid := range g.Peers {
-			peer, ok := account.Peers[pid]
-			if !ok {
-				log.Warnf("peer %s found in group %s but doesn't belong to account %s", pid, g.ID, account.Id)
-				continue
-			}
-			// exclude original peer
-			if peer.Key != peerKey {
-				res = append(res, peer.Copy())
+	reachable := map[string]*ReachablePeer{}
+	for pid, rules := range peerRules {
+		peer, ok := account.Peers[pid]
+		if !ok {
+			log.Warnf("peer %s selected by rules but doesn't belong to account %s", pid, account.Id)
+			continue
+		}
+		// exclude original peer
+		if peer.Key == peerKey {
+			continue
+		}
+		// peers which login has expired can't connect until they log in again
+		if peer.Status != nil && peer.Status.LoginExpired {
+			continue
+		}
+		// suspended peers can't connect until they are resumed
+		if peer.Suspended {
+			continue
+		}
+		// peers failing the device posture checks can't be reached until they meet the requirements
+		if len(account.PostureChecks.Failures(peer.Meta)) > 0 {
+			continue
+		}
+		rp, ok := reachable[peer.Key]
+		if !ok {
+			rp = &ReachablePeer{Peer: peer.Copy()}
+			reachable[peer.Key] = rp
+		}
+		for _, r := range rules {
+			if !containsRule(rp.Rules, r.ID) {
+				rp.Rules = append(rp.Rules, r.Copy())
 			}
 		}
 	}
 
-	return &NetworkMap{
-		Peers:   res,
-		Network: account.Network.Copy(),
-	}, err
+	res := make([]*ReachablePeer, 0, len(reachable))
+	for _, rp := range reachable {
+		sort.Slice(rp.Rules, func(i, j int) bool {
+			return rp.Rules[i].ID < rp.Rules[j].ID
+		})
+		res = append(res, rp)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Peer.Key < res[j].Peer.Key
+	})
+
+	return res
+}
+
+func containsRule(rules []*Rule, ruleID string) bool {
+	for _, r := range rules {
+		if r.ID == ruleID {
+			return true
+		}
+	}
+	return false
 }
 
 // AddPeer adds a new peer to the Store.
@@ -289,6 +1085,8 @@ func (am *DefaultAccountManager) GetNetworkMap(peerKey string) (*NetworkMap, err
 // If a User ID is provided, it means that we passed the authentication using JWT, then we look for account by User ID and register the peer
 // to it. We also add the User ID to the peer metadata to identify registrant.
 // Each new Peer will be assigned a new next net.IP from the Account.Network and Account.Network.LastIP will be updated (IP's are not reused).
+// If the peer property has an IP, the peer gets it instead of the next IP, provided it's a free host IP of the Account.Network.
+// The peer gets a valid unique name derived from the name of the peer property, usually its hostname, see peerNameFromHostname.
 // The peer property is just a placeholder for the Peer properties to pass further
 func (am *DefaultAccountManager) AddPeer(
 	setupKey string,
@@ -312,7 +1110,26 @@ func (am *DefaultAccountManager) AddPeer(
 				upperKey,
 			)
 		}
+	} else if len(userID) != 0 {
+		account, err = am.Store.GetUserAccount(userID)
+		if err != nil {
+			return nil, status.Errorf(codes.NotFound, "unable to register peer, unknown user with ID: %s", userID)
+		}
+	} else {
+		// Empty setup key and jwt fail
+		return nil, status.Errorf(codes.InvalidArgument, "no setup key or user id provided")
+	}
+
+	unlock := am.Store.AcquireAccountLock(account.Id)
+	defer unlock()
 
+	// read the account again holding the lock, it could have been changed in the meantime
+	account, err = am.Store.GetAccount(account.Id)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	if len(upperKey) != 0 {
 		sk = getAccountSetupKeyByKey(account, upperKey)
 		if sk == nil {
 			// shouldn't happen actually
@@ -323,32 +1140,66 @@ func (am *DefaultAccountManager) AddPeer(
 			)
 		}
 
-		if !sk.IsValid() {
-			return nil, status.Errorf(
-				codes.FailedPrecondition,
-				"unable to register peer, its setup key is invalid (expired, overused or revoked)",
-			)
+		switch {
+		case sk.IsRevoked():
+			return nil, status.Errorf(codes.PermissionDenied, "unable to register peer, setup key revoked")
+		case sk.IsExpired():
+			return nil, status.Errorf(codes.FailedPrecondition, "unable to register peer, setup key expired")
+		case sk.IsOverUsed():
+			return nil, status.Errorf(codes.FailedPrecondition, "unable to register peer, setup key usage limit reached")
 		}
+	}
 
-	} else if len(userID) != 0 {
-		account, err = am.Store.GetUserAccount(userID)
-		if err != nil {
-			return nil, status.Errorf(codes.NotFound, "unable to register peer, unknown user with ID: %s", userID)
-		}
-	} else {
-		// Empty setup key and jwt fail
-		return nil, status.Errorf(codes.InvalidArgument, "no setup key or user id provided")
+	// overwriting an existing peer would break the machine that registered it first
+	if _, err = am.Store.GetPeer(peer.Key); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "unable to register peer, peer with the key %s is already registered", peer.Key)
 	}
 
-	var takenIps []net.IP
-	for _, peer := range account.Peers {
-		takenIps = append(takenIps, peer.IP)
+	// the account lock is held, so concurrent registrations can't exceed the limit
+	if limit := am.peersLimit(account); limit > 0 && len(account.Peers) >= limit {
+		return nil, status.Errorf(codes.ResourceExhausted, "unable to register peer, the account has reached its limit of %d peers", limit)
 	}
 
-	network := account.Network
-	nextIp, err := AllocatePeerIP(network.Net, takenIps)
-	if err != nil {
-		return nil, err
+	// the peer registers with its hostname, which isn't necessarily a valid and unique peer name
+	peerName := uniquePeerName(account, peer.Key, peerNameFromHostname(peer.Name))
+
+	var nextIp net.IP
+	reservation := account.getIPReservation(peer.Key, peerName)
+	if reservation != nil && reservation.PeerKey == "" && account.ipAssigned(reservation.IP) {
+		// another peer with the same name has been registered with the IP reserved for the name
+		reservation = nil
+	}
+	if reservation != nil {
+		// the reserved IP takes precedence over the IP requested by the peer
+		err = validatePeerIP(account, peer.Key, peerName, reservation.IP)
+		if err != nil {
+			return nil, err
+		}
+		nextIp = reservation.IP.To4()
+		if reservation.PeerKey == "" {
+			// the IP reserved for the name belongs to the first peer registered with it
+			reservation.PeerKey = peer.Key
+			reservation.PeerName = ""
+		}
+	} else if peer.IP != nil {
+		err = validatePeerIP(account, peer.Key, peerName, peer.IP)
+		if err != nil {
+			return nil, err
+		}
+		nextIp = peer.IP.To4()
+	} else {
+		var takenIps []net.IP
+		for _, peer := range account.Peers {
+			takenIps = append(takenIps, peer.IP)
+		}
+		for _, reservation := range account.IPReservations {
+			takenIps = append(takenIps, reservation.IP)
+		}
+
+		nextIp, err = AllocatePeerIP(account.Network.Net, takenIps)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	newPeer := &Peer{
@@ -356,10 +1207,13 @@ func (am *DefaultAccountManager) AddPeer(
 		SetupKey: upperKey,
 		IP:       nextIp,
 		Meta:     peer.Meta,
-		Name:     peer.Name,
+		Name:     peerName,
 		UserID:   userID,
 		Status:   &PeerStatus{Connected: false, LastSeen: time.Now()},
 	}
+	if userID != "" {
+		newPeer.LastLogin = am.clock.Now().UTC()
+	}
 
 	// add peer to 'All' group
 	group, err := account.GetGroupAll()
@@ -374,8 +1228,21 @@ func (am *DefaultAccountManager) AddPeer(
 	}
 	account.Network.IncSerial()
 
-	err = am.Store.SaveAccount(account)
+	meta := map[string]string{"name": newPeer.Name, "ip": newPeer.IP.String()}
+	var event *activity.Event
+	if sk != nil {
+		meta["setup_key_name"] = sk.Name
+		event = newAuditEvent(sk.Id, newPeer.Key, account.Id, activity.PeerAddedWithSetupKey, meta, nil, newPeer)
+	} else {
+		event = newAuditEvent(userID, newPeer.Key, account.Id, activity.PeerAddedByUser, meta, nil, newPeer)
+	}
+
+	err = am.saveAccount(account, event)
 	if err != nil {
+		// the peer might have been registered in another account in the meantime
+		if s, ok := status.FromError(err); ok && s.Code() == codes.AlreadyExists {
+			return nil, err
+		}
 		return nil, status.Errorf(codes.Internal, "failed adding peer")
 	}
 
@@ -384,15 +1251,13 @@ func (am *DefaultAccountManager) AddPeer(
 
 // UpdatePeerMeta updates peer's system metadata
 func (am *DefaultAccountManager) UpdatePeerMeta(peerKey string, meta PeerSystemMeta) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
-
-	peer, err := am.Store.GetPeer(peerKey)
+	account, unlock, err := am.lockPeerAccount(peerKey)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	account, err := am.Store.GetPeerAccount(peerKey)
+	peer, err := am.Store.GetPeer(peerKey)
 	if err != nil {
 		return err
 	}
@@ -402,12 +1267,82 @@ func (am *DefaultAccountManager) UpdatePeerMeta(peerKey string, meta PeerSystemM
 	if meta.UIVersion == "" {
 		meta.UIVersion = peerCopy.Meta.UIVersion
 	}
+	// the login request is sent before the Wireguard interface is configured, so it carries neither the port
+	// nor the SSH key
+	if meta.WgPort == 0 {
+		meta.WgPort = peerCopy.Meta.WgPort
+	}
+	if meta.SSHPubKey == "" {
+		meta.SSHPubKey = peerCopy.Meta.SSHPubKey
+	}
+	portChanged := meta.WgPort != peerCopy.Meta.WgPort
+	// the remote peers authenticate the SSH server of the peer and the peer itself with its SSH key
+	sshKeyChanged := meta.SSHPubKey != peerCopy.Meta.SSHPubKey
+	passedPosture := len(account.PostureChecks.Failures(peerCopy.Meta)) == 0
+	postureChanged := passedPosture != (len(account.PostureChecks.Failures(meta)) == 0)
 
 	peerCopy.Meta = meta
 
-	err = am.Store.SavePeer(account.Id, peerCopy)
+	if !portChanged && !sshKeyChanged && !postureChanged {
+		return am.Store.SavePeer(account.Id, peerCopy)
+	}
+
+	// the remote peers connect to the new port, e.g. picked at random after a restart,
+	// or add (remove) the peer that has started (stopped) meeting the posture requirements
+	account.Peers[peerKey] = peerCopy
+	account.Network.IncSerial()
+	err = am.Store.SaveAccount(account)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return am.updateReachablePeers(account, peerKey)
+}
+
+// UpdatePeerAllowedIPsConflicts records the conflicting allowed IPs of the remote peers reported by the peer,
+// an empty list clears the previously reported ones
+func (am *DefaultAccountManager) UpdatePeerAllowedIPsConflicts(peerKey string, conflicts []AllowedIPsConflict) error {
+	account, unlock, err := am.lockPeerAccount(peerKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	peer, err := am.Store.GetPeer(peerKey)
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		log.Warnf("peer %s has reported %d conflicting allowed IPs of the remote peers", peer.Name, len(conflicts))
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.AllowedIPsConflicts = conflicts
+	return am.Store.SavePeer(account.Id, peerCopy)
+}
+
+// PeersQuota is the number of peers registered in an account and the number of peers the account can register
+type PeersQuota struct {
+	Count int
+	// Limit is the maximum number of peers of the account, 0 means unlimited
+	Limit int
+}
+
+// peersLimit returns the maximum number of peers of the account, 0 means unlimited
+func (am *DefaultAccountManager) peersLimit(account *Account) int {
+	if account.MaxPeers > 0 {
+		return account.MaxPeers
+	}
+	return am.maxPeersPerAccount
+}
+
+// GetPeersQuota returns the number of peers registered in the account and the maximum number of peers of the account
+func (am *DefaultAccountManager) GetPeersQuota(accountId string) (*PeersQuota, error) {
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	return &PeersQuota{Count: len(account.Peers), Limit: am.peersLimit(account)}, nil
 }
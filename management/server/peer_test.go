@@ -1,10 +1,17 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/netbirdio/netbird/management/server/activity"
 	"github.com/rs/xid"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestAccountManager_GetNetworkMap(t *testing.T) {
@@ -140,7 +147,7 @@ func TestAccountManager_GetNetworkMapWithRule(t *testing.T) {
 		return
 	}
 
-	err = manager.DeleteRule(account.Id, rules[0].ID)
+	err = manager.DeleteRule(account.Id, "account_creator", rules[0].ID)
 	if err != nil {
 		t.Errorf("expecting to delete 1 group, got failure %v", err)
 		return
@@ -159,12 +166,12 @@ func TestAccountManager_GetNetworkMapWithRule(t *testing.T) {
 	group1.Peers = append(group1.Peers, peerKey1.PublicKey().String())
 	group2.Peers = append(group2.Peers, peerKey2.PublicKey().String())
 
-	err = manager.SaveGroup(account.Id, &group1)
+	err = manager.SaveGroup(account.Id, userId, &group1)
 	if err != nil {
 		t.Errorf("expecting group1 to be added, got failure %v", err)
 		return
 	}
-	err = manager.SaveGroup(account.Id, &group2)
+	err = manager.SaveGroup(account.Id, userId, &group2)
 	if err != nil {
 		t.Errorf("expecting group2 to be added, got failure %v", err)
 		return
@@ -174,7 +181,7 @@ func TestAccountManager_GetNetworkMapWithRule(t *testing.T) {
 	rule.Source = append(rule.Source, group1.ID)
 	rule.Destination = append(rule.Destination, group2.ID)
 	rule.Flow = TrafficFlowBidirect
-	err = manager.SaveRule(account.Id, &rule)
+	err = manager.SaveRule(account.Id, "account_creator", &rule)
 	if err != nil {
 		t.Errorf("expecting rule to be added, got failure %v", err)
 		return
@@ -219,4 +226,844 @@ func TestAccountManager_GetNetworkMapWithRule(t *testing.T) {
 			networkMap2.Peers[0].Key,
 		)
 	}
+
+	reachable, err := manager.GetPeerReachability(peerKey1.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	if len(reachable) != 1 || reachable[0].Peer.Key != peerKey2.PublicKey().String() {
+		t.Fatalf("expecting peer %s to be reachable, got %v", peerKey2.PublicKey().String(), reachable)
+	}
+
+	if len(reachable[0].Rules) != 1 || reachable[0].Rules[0].ID != rule.ID {
+		t.Errorf("expecting peer %s to be reachable by rule %s, got %v", peerKey2.PublicKey().String(), rule.ID, reachable[0].Rules)
+	}
+
+	_, err = manager.GetPeerReachability("unknown")
+	if err == nil {
+		t.Errorf("expecting an error for an unknown peer")
+	}
+}
+
+func TestAccountManager_UpdatePeerRateLimit(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peerKey1, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey1.PublicKey().String(), Name: "guest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerKey2, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey2.PublicKey().String(), Name: "gateway"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peer2.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer2.Key)
+
+	serial := account.Network.CurrentSerial()
+	updated, err := manager.UpdatePeerRateLimit(account.Id, peer1.Key, 1000, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.RateLimit != 1000 {
+		t.Errorf("expecting peer rate limit to be 1000, got %d", updated.RateLimit)
+	}
+
+	select {
+	case update := <-updates:
+		networkMap := update.Update.GetNetworkMap()
+		if networkMap.GetSerial() <= serial {
+			t.Errorf("expecting network map serial to be incremented, got %d", networkMap.GetSerial())
+		}
+		if len(networkMap.GetRemotePeers()) != 1 {
+			t.Fatalf("expecting network map to have 1 remote peer, got %d", len(networkMap.GetRemotePeers()))
+		}
+		remotePeer := networkMap.GetRemotePeers()[0]
+		if remotePeer.GetWgPubKey() != peer1.Key || remotePeer.GetRateLimitKbps() != 1000 {
+			t.Errorf("expecting remote peer %s limited to 1000 kbit/s, got %s limited to %d kbit/s",
+				peer1.Key, remotePeer.GetWgPubKey(), remotePeer.GetRateLimitKbps())
+		}
+	default:
+		t.Error("expecting reachable peer to receive an update")
+	}
+
+	_, err = manager.UpdatePeerRateLimit(account.Id, "unknown", 1000, "account_creator")
+	if err == nil {
+		t.Error("expecting updating rate limit of an unknown peer to fail")
+	}
+}
+
+func TestAccountManager_UpdatePeerRouteMetric(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peer.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer.Key)
+
+	serial := account.Network.CurrentSerial()
+	updated, err := manager.UpdatePeerRouteMetric(account.Id, peer.Key, 50, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.RouteMetric != 50 {
+		t.Errorf("expecting peer route metric to be 50, got %d", updated.RouteMetric)
+	}
+
+	select {
+	case update := <-updates:
+		networkMap := update.Update.GetNetworkMap()
+		if networkMap.GetSerial() <= serial {
+			t.Errorf("expecting network map serial to be incremented, got %d", networkMap.GetSerial())
+		}
+		if networkMap.GetPeerConfig().GetRouteMetric() != 50 {
+			t.Errorf("expecting peer config route metric to be 50, got %d", networkMap.GetPeerConfig().GetRouteMetric())
+		}
+	default:
+		t.Error("expecting peer to receive an update")
+	}
+
+	_, err = manager.UpdatePeerRouteMetric(account.Id, "unknown", 50, "account_creator")
+	if err == nil {
+		t.Error("expecting updating route metric of an unknown peer to fail")
+	}
+}
+
+func TestAccountManager_UpdatePeerStaticEndpoint(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peerKey1, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey1.PublicKey().String(), Name: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerKey2, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey2.PublicKey().String(), Name: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peer2.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer2.Key)
+
+	for _, invalid := range []string{"203.0.113.1", "203.0.113.1:0", "203.0.113.1:port"} {
+		_, err = manager.UpdatePeerStaticEndpoint(account.Id, peer1.Key, invalid, "account_creator")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting static endpoint %s to be rejected as invalid, got %v", invalid, err)
+		}
+	}
+
+	endpoint := "203.0.113.1:51820"
+	updated, err := manager.UpdatePeerStaticEndpoint(account.Id, peer1.Key, endpoint, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.StaticEndpoint != endpoint {
+		t.Errorf("expecting peer static endpoint to be %s, got %s", endpoint, updated.StaticEndpoint)
+	}
+
+	select {
+	case update := <-updates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		if len(remotePeers) != 1 {
+			t.Fatalf("expecting network map to have 1 remote peer, got %d", len(remotePeers))
+		}
+		if remotePeers[0].GetWgPubKey() != peer1.Key || remotePeers[0].GetStaticEndpoint() != endpoint {
+			t.Errorf("expecting remote peer %s with static endpoint %s, got %s with static endpoint %s",
+				peer1.Key, endpoint, remotePeers[0].GetWgPubKey(), remotePeers[0].GetStaticEndpoint())
+		}
+	default:
+		t.Error("expecting reachable peer to receive an update")
+	}
+
+	networkMap, err := manager.GetNetworkMap(peer1.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkMap.Peers[0].StaticEndpoint != "" {
+		t.Errorf("expecting remote peer without a static endpoint, got %s", networkMap.Peers[0].StaticEndpoint)
+	}
+
+	updated, err = manager.UpdatePeerStaticEndpoint(account.Id, peer1.Key, "", "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.StaticEndpoint != "" {
+		t.Errorf("expecting peer static endpoint to be removed, got %s", updated.StaticEndpoint)
+	}
+
+	_, err = manager.UpdatePeerStaticEndpoint(account.Id, "unknown", endpoint, "account_creator")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting updating static endpoint of an unknown peer to fail with NotFound, got %v", err)
+	}
+}
+
+func TestAccountManager_RenamePeer(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peerKey1, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1, err := manager.AddPeer(setupKey.Key, "", &Peer{
+		Key:  peerKey1.PublicKey().String(),
+		Name: "host-1",
+		Meta: PeerSystemMeta{Hostname: "host-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerKey2, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey2.PublicKey().String(), Name: "host-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peer2.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer2.Key)
+
+	serial := account.Network.CurrentSerial()
+	renamed, err := manager.RenamePeer(account.Id, peer1.Key, "office-gateway", "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "office-gateway" {
+		t.Errorf("expecting peer to be renamed to office-gateway, got %s", renamed.Name)
+	}
+
+	select {
+	case update := <-updates:
+		networkMap := update.Update.GetNetworkMap()
+		if networkMap.GetSerial() <= serial {
+			t.Errorf("expecting network map serial to be incremented, got %d", networkMap.GetSerial())
+		}
+		if len(networkMap.GetRemotePeers()) != 1 || networkMap.GetRemotePeers()[0].GetName() != "office-gateway" {
+			t.Errorf("expecting network map to have the renamed remote peer, got %v", networkMap.GetRemotePeers())
+		}
+	default:
+		t.Error("expecting reachable peer to receive an update")
+	}
+
+	for _, name := range []string{"Office Gateway", "-leading-dash", "trailing-dash-", "dot.name", "under_score", "semi;colon",
+		"emoji\U0001F600", strings.Repeat("a", maxPeerNameLength+1)} {
+		_, err = manager.RenamePeer(account.Id, peer1.Key, name, "account_creator")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting renaming peer to %q to fail with InvalidArgument, got %v", name, err)
+		}
+	}
+
+	// the names are unique within the account regardless of the case
+	_, err = manager.RenamePeer(account.Id, peer1.Key, "HOST-2", "account_creator")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting renaming peer to the name of another peer to fail with AlreadyExists, got %v", err)
+	}
+
+	renamed, err = manager.RenamePeer(account.Id, peer1.Key, "", "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "host-1" {
+		t.Errorf("expecting empty name to reset the peer name to its hostname host-1, got %s", renamed.Name)
+	}
+
+	// the hostname is made a valid name unique within the account, host-2 is taken by peer2
+	peerKey3, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer3, err := manager.AddPeer(setupKey.Key, "", &Peer{
+		Key:  peerKey3.PublicKey().String(),
+		Name: "Host_2.local",
+		Meta: PeerSystemMeta{Hostname: "Host_2.local"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer3.Name != "Host-2-2" {
+		t.Errorf("expecting peer registered with hostname Host_2.local to be named Host-2-2, got %s", peer3.Name)
+	}
+	_, err = manager.RenamePeer(account.Id, peer3.Key, "office", "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed, err = manager.RenamePeer(account.Id, peer3.Key, "", "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Name != "Host-2-2" {
+		t.Errorf("expecting empty name to reset the peer name to its unique hostname Host-2-2, got %s", renamed.Name)
+	}
+
+	_, err = manager.RenamePeer(account.Id, "unknown", "name", "account_creator")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting renaming an unknown peer to fail with NotFound, got %v", err)
+	}
+}
+
+func TestPeerNameFromHostname(t *testing.T) {
+	testCases := []struct {
+		hostname string
+		expected string
+	}{
+		{hostname: "laptop", expected: "laptop"},
+		{hostname: "laptop.example.com", expected: "laptop"},
+		{hostname: "John's MacBook Pro", expected: "John-s-MacBook-Pro"},
+		{hostname: "-under_score-", expected: "under-score"},
+		{hostname: "\u00e9t\u00e9", expected: "t"},
+		{hostname: "", expected: defaultPeerName},
+		{hostname: "___", expected: defaultPeerName},
+		{hostname: strings.Repeat("a", maxPeerNameLength) + "b", expected: strings.Repeat("a", maxPeerNameLength)},
+		{hostname: strings.Repeat("a", maxPeerNameLength-1) + "_b", expected: strings.Repeat("a", maxPeerNameLength-1)},
+	}
+	for _, testCase := range testCases {
+		name := peerNameFromHostname(testCase.hostname)
+		if name != testCase.expected {
+			t.Errorf("expecting hostname %q to be named %q, got %q", testCase.hostname, testCase.expected, name)
+		}
+		if validatePeerName(name) != nil {
+			t.Errorf("expecting name %q derived from hostname %q to be valid", name, testCase.hostname)
+		}
+	}
+}
+
+func TestUniquePeerName(t *testing.T) {
+	long := strings.Repeat("a", maxPeerNameLength)
+	account := &Account{Peers: map[string]*Peer{
+		"a": {Key: "a", Name: "laptop"},
+		"b": {Key: "b", Name: "Laptop-2"},
+		"c": {Key: "c", Name: long},
+	}}
+
+	if name := uniquePeerName(account, "a", "laptop"); name != "laptop" {
+		t.Errorf("expecting the own name of a peer to be kept, got %s", name)
+	}
+	if name := uniquePeerName(account, "new", "LAPTOP"); name != "LAPTOP-3" {
+		t.Errorf("expecting the lowest free suffix LAPTOP-3, got %s", name)
+	}
+	if name := uniquePeerName(account, "new", long); name != long[:maxPeerNameLength-2]+"-2" || validatePeerName(name) != nil {
+		t.Errorf("expecting a long name to be shortened to fit the suffix, got %s", name)
+	}
+}
+
+func TestAccountManager_ReplacePeerKey(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peerKey1, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey1.PublicKey().String(), Name: "host-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerKey2, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey2.PublicKey().String(), Name: "host-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = manager.SaveGroup(account.Id, "account_creator", &Group{ID: "gateways", Name: "gateways", Peers: []string{peer1.Key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldUpdates := manager.peersUpdateManager.CreateChannel(peer1.Key)
+	updates := manager.peersUpdateManager.CreateChannel(peer2.Key)
+	defer manager.peersUpdateManager.CloseChannel(peer2.Key)
+
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := account.Network.CurrentSerial()
+
+	newKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced, err := manager.ReplacePeerKey(peer1.Key, newKey.PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.Key != newKey.PublicKey().String() || replaced.Name != peer1.Name || !replaced.IP.Equal(peer1.IP) {
+		t.Errorf("expecting the peer to keep its name %s and IP %s with the new key, got %v", peer1.Name, peer1.IP, replaced)
+	}
+
+	if _, err = manager.GetPeer(peer1.Key); status.Code(err) != codes.NotFound {
+		t.Errorf("expecting the old key to be unknown, got %v", err)
+	}
+	if _, err = manager.GetPeer(replaced.Key); err != nil {
+		t.Errorf("expecting the peer to be found by the new key, got %v", err)
+	}
+
+	group, err := manager.GetGroup(account.Id, "gateways")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Peers) != 1 || group.Peers[0] != replaced.Key {
+		t.Errorf("expecting the group to have the new key of the peer, got %v", group.Peers)
+	}
+
+	if _, open := <-oldUpdates; open {
+		t.Error("expecting the updates channel of the old key to be closed")
+	}
+
+	select {
+	case update := <-updates:
+		networkMap := update.Update.GetNetworkMap()
+		if networkMap.GetSerial() <= serial {
+			t.Errorf("expecting network map serial to be incremented, got %d", networkMap.GetSerial())
+		}
+		if len(networkMap.GetRemotePeers()) != 1 || networkMap.GetRemotePeers()[0].GetWgPubKey() != replaced.Key {
+			t.Errorf("expecting network map to have the new key of the remote peer, got %v", networkMap.GetRemotePeers())
+		}
+	default:
+		t.Error("expecting reachable peer to receive an update")
+	}
+
+	_, err = manager.ReplacePeerKey(replaced.Key, peer2.Key)
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting replacing the key with the key of another peer to fail with AlreadyExists, got %v", err)
+	}
+
+	_, err = manager.ReplacePeerKey(peer1.Key, peerKey1.PublicKey().String())
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting replacing the key with the same key to fail with InvalidArgument, got %v", err)
+	}
+}
+
+func TestAccountManager_SuspendPeer(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var peers []*Peer
+	for i := 0; i < 3; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("host-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+	suspended, other := peers[0], peers[1]
+
+	suspendedUpdates := manager.peersUpdateManager.CreateChannel(suspended.Key)
+	defer manager.peersUpdateManager.CloseChannel(suspended.Key)
+	otherUpdates := manager.peersUpdateManager.CreateChannel(other.Key)
+	defer manager.peersUpdateManager.CloseChannel(other.Key)
+
+	expectRemotePeers := func(updates chan *UpdateMessage, peerKey string, expected int) {
+		t.Helper()
+		select {
+		case update := <-updates:
+			if remotePeers := update.Update.GetNetworkMap().GetRemotePeers(); len(remotePeers) != expected {
+				t.Errorf("expecting the update of peer %s to have %d remote peers, got %d", peerKey, expected, len(remotePeers))
+			}
+		default:
+			t.Errorf("expecting peer %s to receive an update", peerKey)
+		}
+
+		networkMap, err := manager.GetNetworkMap(peerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(networkMap.Peers) != expected {
+			t.Errorf("expecting the network map of peer %s to have %d peers, got %d", peerKey, expected, len(networkMap.Peers))
+		}
+	}
+
+	updated, err := manager.SuspendPeer(account.Id, suspended.Key, true, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Suspended || !updated.IP.Equal(suspended.IP) {
+		t.Errorf("expecting the peer to be suspended keeping its IP %s, got %v", suspended.IP, updated)
+	}
+
+	expectRemotePeers(suspendedUpdates, suspended.Key, 0)
+	expectRemotePeers(otherUpdates, other.Key, 1)
+
+	stored, err := manager.GetPeer(suspended.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Suspended || !stored.IP.Equal(suspended.IP) {
+		t.Errorf("expecting the suspended peer to be kept with its IP %s, got %v", suspended.IP, stored)
+	}
+
+	_, err = manager.SuspendPeer(account.Id, suspended.Key, false, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectRemotePeers(suspendedUpdates, suspended.Key, 2)
+	expectRemotePeers(otherUpdates, other.Key, 2)
+
+	_, err = manager.SuspendPeer(account.Id, "unknown", true, "account_creator")
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("expecting suspending an unknown peer to fail with NotFound, got %v", err)
+	}
+}
+
+// serialRecordingStore is a Store recording the network serial of every saved account
+type serialRecordingStore struct {
+	Store
+	mu      sync.Mutex
+	serials []uint64
+}
+
+func (s *serialRecordingStore) SaveAccount(account *Account) error {
+	return s.SaveAccountWithAuditEvents(account, nil)
+}
+
+func (s *serialRecordingStore) SaveAccountWithAuditEvents(account *Account, events []*activity.Event) error {
+	s.mu.Lock()
+	s.serials = append(s.serials, account.Network.CurrentSerial())
+	s.mu.Unlock()
+	return s.Store.SaveAccountWithAuditEvents(account, events)
+}
+
+func TestAccountManager_ConcurrentSerials(t *testing.T) {
+	store, err := createStore(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordingStore := &serialRecordingStore{Store: store}
+	manager, err := BuildManager(recordingStore, NewPeersUpdateManager(), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	recordingStore.mu.Lock()
+	recordingStore.serials = nil
+	recordingStore.mu.Unlock()
+	initialSerial := account.Network.CurrentSerial()
+
+	const workers = 10
+	const peersPerWorker = 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < peersPerWorker; i++ {
+				peerKey, err := wgtypes.GeneratePrivateKey()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: "peer"})
+				if err != nil {
+					t.Errorf("expecting peer to be added, got failure %v", err)
+					return
+				}
+				_, err = manager.DeletePeer(account.Id, peer.Key, "account_creator")
+				if err != nil {
+					t.Errorf("expecting peer to be deleted, got failure %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	recordingStore.mu.Lock()
+	serials := recordingStore.serials
+	recordingStore.mu.Unlock()
+
+	expectedChanges := workers * peersPerWorker * 2
+	if len(serials) != expectedChanges {
+		t.Fatalf("expecting %d saved accounts, got %d", expectedChanges, len(serials))
+	}
+	previous := initialSerial
+	for i, serial := range serials {
+		if serial <= previous {
+			t.Fatalf("expecting strictly increasing serials, got %d after %d at change %d", serial, previous, i)
+		}
+		previous = serial
+	}
+
+	account, err = manager.GetAccountById(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Network.CurrentSerial() != initialSerial+uint64(expectedChanges) {
+		t.Errorf("expecting stored serial %d, got %d", initialSerial+uint64(expectedChanges), account.Network.CurrentSerial())
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tt := []struct {
+		a, b     string
+		expected int
+		err      bool
+	}{
+		{a: "0.6.0", b: "0.6.0", expected: 0},
+		{a: "0.6", b: "0.6.0", expected: 0},
+		{a: "v0.5.3", b: "0.6.0", expected: -1},
+		{a: "0.10.0", b: "0.9.1", expected: 1},
+		{a: "0.6.1-rc1", b: "0.6.1", expected: 0},
+		{a: "development", b: "0.6.0", err: true},
+		{a: UnknownVersion, b: "0.6.0", err: true},
+		{a: "", b: "0.6.0", err: true},
+	}
+
+	for _, tc := range tt {
+		result, err := CompareVersions(tc.a, tc.b)
+		if tc.err {
+			if err == nil {
+				t.Errorf("expected an error comparing %s and %s", tc.a, tc.b)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != tc.expected {
+			t.Errorf("comparing %s and %s: expected %d, got %d", tc.a, tc.b, tc.expected, result)
+		}
+	}
+}
+
+func TestAccountManager_UpdatePeerIP(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	ip := func(host byte) net.IP {
+		network := account.Network.Net.IP.To4()
+		return net.IPv4(network[0], network[1], network[2], host).To4()
+	}
+
+	key1, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the gateway registers with a fixed IP
+	gateway, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key1.PublicKey().String(), Name: "gateway", IP: ip(200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gateway.IP.Equal(ip(200)) {
+		t.Errorf("expecting the peer to be registered with the requested IP %s, got %s", ip(200), gateway.IP)
+	}
+
+	key2, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = manager.AddPeer(setupKey.Key, "", &Peer{Key: key2.PublicKey().String(), Name: "other", IP: ip(200)})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting the registration with a taken IP to fail with AlreadyExists, got %v", err)
+	}
+	other, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key2.PublicKey().String(), Name: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdatePeerIP(account.Id, gateway.Key, other.IP, "account_creator")
+	if status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expecting assigning the IP of another peer to fail with AlreadyExists, got %v", err)
+	}
+	for _, invalid := range []net.IP{net.ParseIP("192.0.2.1"), account.Network.Net.IP, ip(1)} {
+		_, err = manager.UpdatePeerIP(account.Id, gateway.Key, invalid, "account_creator")
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting assigning IP %s to fail with InvalidArgument, got %v", invalid, err)
+		}
+	}
+
+	gatewayUpdates := manager.peersUpdateManager.CreateChannel(gateway.Key)
+	defer manager.peersUpdateManager.CloseChannel(gateway.Key)
+	otherUpdates := manager.peersUpdateManager.CreateChannel(other.Key)
+	defer manager.peersUpdateManager.CloseChannel(other.Key)
+
+	serial := account.Network.CurrentSerial()
+	gateway, err = manager.UpdatePeerIP(account.Id, gateway.Key, ip(250), "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gateway.IP.Equal(ip(250)) {
+		t.Errorf("expecting the peer to get IP %s, got %s", ip(250), gateway.IP)
+	}
+
+	select {
+	case update := <-gatewayUpdates:
+		networkMap := update.Update.GetNetworkMap()
+		if networkMap.GetSerial() <= serial {
+			t.Errorf("expecting the network map serial to be incremented, got %d", networkMap.GetSerial())
+		}
+		expected := fmt.Sprintf("%s/%d", ip(250), account.Network.PrefixLen())
+		if networkMap.GetPeerConfig().GetAddress() != expected {
+			t.Errorf("expecting the peer to get the address %s, got %s", expected, networkMap.GetPeerConfig().GetAddress())
+		}
+	default:
+		t.Error("expecting the peer to receive its new address")
+	}
+
+	select {
+	case update := <-otherUpdates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		expected := fmt.Sprintf(AllowedIPsFormat, ip(250))
+		if len(remotePeers) != 1 || remotePeers[0].GetAllowedIps()[0] != expected {
+			t.Errorf("expecting the remote peer to be allowed %s, got %v", expected, remotePeers)
+		}
+	default:
+		t.Error("expecting the peers that can reach the peer to receive its new allowed IP")
+	}
 }
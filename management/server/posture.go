@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PostureChecks are the device posture requirements the peers of an account have to meet
+// to be included in the network maps of the other peers
+type PostureChecks struct {
+	// MinOSVersions is the minimal OS version per OS (e.g. linux, darwin, windows as reported in GoOS).
+	// Peers of a listed OS reporting an older or unparsable OS version fail the check
+	MinOSVersions map[string]string
+	// DiskEncryption requires the peers to report an encrypted system disk
+	DiskEncryption bool
+	// Firewall requires the peers to report an enabled host firewall
+	Firewall bool
+}
+
+// Copy copies PostureChecks object
+func (c *PostureChecks) Copy() *PostureChecks {
+	if c == nil {
+		return nil
+	}
+	var minOSVersions map[string]string
+	if c.MinOSVersions != nil {
+		minOSVersions = make(map[string]string, len(c.MinOSVersions))
+		for os, version := range c.MinOSVersions {
+			minOSVersions[os] = version
+		}
+	}
+	return &PostureChecks{
+		MinOSVersions:  minOSVersions,
+		DiskEncryption: c.DiskEncryption,
+		Firewall:       c.Firewall,
+	}
+}
+
+// validate checks that the minimal OS versions can be compared with the versions reported by the peers
+func (c *PostureChecks) validate() error {
+	for os, version := range c.MinOSVersions {
+		if _, err := ParseVersion(version); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid minimal version %q of OS %s", version, os)
+		}
+	}
+	return nil
+}
+
+// Failures returns the requirements the system meta data of a peer doesn't meet, sorted. Empty if the peer passes the checks
+func (c *PostureChecks) Failures(meta PeerSystemMeta) []string {
+	if c == nil {
+		return nil
+	}
+
+	var failures []string
+	if minVersion, ok := c.MinOSVersions[meta.GoOS]; ok {
+		// the OS version is reported in Core
+		result, err := CompareVersions(meta.Core, minVersion)
+		if err != nil || result < 0 {
+			failures = append(failures, fmt.Sprintf("OS version %q is older than %s", meta.Core, minVersion))
+		}
+	}
+	if c.DiskEncryption && !meta.DiskEncrypted {
+		failures = append(failures, "disk isn't encrypted")
+	}
+	if c.Firewall && !meta.FirewallEnabled {
+		failures = append(failures, "firewall isn't enabled")
+	}
+	sort.Strings(failures)
+
+	return failures
+}
+
+// UpdateAccountPostureChecks sets the device posture requirements of the peers of the account, nil removes them.
+// All the peers receive an updated network map without the peers failing the checks
+func (am *DefaultAccountManager) UpdateAccountPostureChecks(accountId string, checks *PostureChecks, userID string) (*Account, error) {
+	if checks != nil {
+		err := checks.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	event := newAuditEvent(userID, accountId, accountId, activity.AccountSettingsUpdated,
+		map[string]string{"setting": "posture_checks"},
+		map[string]*PostureChecks{"PostureChecks": account.PostureChecks}, map[string]*PostureChecks{"PostureChecks": checks})
+
+	account.PostureChecks = checks.Copy()
+	account.Network.IncSerial()
+	err = am.saveAccount(account, event)
+	if err != nil {
+		return nil, err
+	}
+
+	for peerKey := range account.Peers {
+		err = am.sendNetworkMap(account, peerKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return account, nil
+}
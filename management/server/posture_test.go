@@ -0,0 +1,163 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPostureChecks_Failures(t *testing.T) {
+	checks := &PostureChecks{
+		MinOSVersions:  map[string]string{"linux": "20.04", "windows": "10.0.19044"},
+		DiskEncryption: true,
+	}
+
+	tt := []struct {
+		name     string
+		meta     PeerSystemMeta
+		expected []string
+	}{
+		{
+			name:     "Passing Peer",
+			meta:     PeerSystemMeta{GoOS: "linux", Core: "22.04", DiskEncrypted: true},
+			expected: nil,
+		},
+		{
+			name:     "OS Without Minimal Version",
+			meta:     PeerSystemMeta{GoOS: "darwin", Core: "21.6.0", DiskEncrypted: true},
+			expected: nil,
+		},
+		{
+			name:     "Old OS Version",
+			meta:     PeerSystemMeta{GoOS: "windows", Core: "10.0.17763", DiskEncrypted: true},
+			expected: []string{`OS version "10.0.17763" is older than 10.0.19044`},
+		},
+		{
+			name:     "Unknown OS Version",
+			meta:     PeerSystemMeta{GoOS: "linux", Core: "", DiskEncrypted: true},
+			expected: []string{`OS version "" is older than 20.04`},
+		},
+		{
+			name:     "Unencrypted Disk",
+			meta:     PeerSystemMeta{GoOS: "linux", Core: "22.04"},
+			expected: []string{"disk isn't encrypted"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			failures := checks.Failures(tc.meta)
+			if !reflect.DeepEqual(failures, tc.expected) {
+				t.Errorf("expecting failures %v, got %v", tc.expected, failures)
+			}
+		})
+	}
+
+	var noChecks *PostureChecks
+	if failures := noChecks.Failures(PeerSystemMeta{}); len(failures) != 0 {
+		t.Errorf("expecting no failures without posture checks, got %v", failures)
+	}
+}
+
+func TestAccountManager_PostureChecks(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	encryptedKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := manager.AddPeer(setupKey.Key, "", &Peer{
+		Key:  encryptedKey.PublicKey().String(),
+		Name: "encrypted",
+		Meta: PeerSystemMeta{GoOS: "linux", Core: "22.04", DiskEncrypted: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unencryptedKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unencrypted, err := manager.AddPeer(setupKey.Key, "", &Peer{
+		Key:  unencryptedKey.PublicKey().String(),
+		Name: "unencrypted",
+		Meta: PeerSystemMeta{GoOS: "linux", Core: "22.04"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdateAccountPostureChecks(account.Id, &PostureChecks{MinOSVersions: map[string]string{"linux": "latest"}}, "account_creator")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting an invalid minimal OS version to be rejected, got %v", err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(encrypted.Key)
+	defer manager.peersUpdateManager.CloseChannel(encrypted.Key)
+
+	_, err = manager.UpdateAccountPostureChecks(account.Id, &PostureChecks{DiskEncryption: true}, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-updates:
+		if len(update.Update.GetNetworkMap().GetRemotePeers()) != 0 {
+			t.Errorf("expecting the peer failing the posture checks to be removed from the network map, got %v",
+				update.Update.GetNetworkMap().GetRemotePeers())
+		}
+	default:
+		t.Fatal("expecting the peers to receive an update after changing the posture checks")
+	}
+
+	networkMap, err := manager.GetNetworkMap(encrypted.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networkMap.Peers) != 0 {
+		t.Errorf("expecting the peer failing the posture checks to be excluded from the network map, got %v", networkMap.Peers)
+	}
+
+	networkMap, err = manager.GetNetworkMap(unencrypted.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networkMap.Peers) != 1 || networkMap.Peers[0].Key != encrypted.Key {
+		t.Errorf("expecting the peer passing the posture checks to stay in the network map, got %v", networkMap.Peers)
+	}
+
+	// the peer reports an encrypted disk on the next login
+	err = manager.UpdatePeerMeta(unencrypted.Key, PeerSystemMeta{GoOS: "linux", Core: "22.04", DiskEncrypted: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-updates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		if len(remotePeers) != 1 || remotePeers[0].GetWgPubKey() != unencrypted.Key {
+			t.Errorf("expecting the peer passing the posture checks to be added to the network map, got %v", remotePeers)
+		}
+	default:
+		t.Fatal("expecting the peers to receive an update after the peer has started passing the posture checks")
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"time"
+
+	"github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// DefaultSyncStreamInactivityTimeout is a period after which a Sync stream of a peer that has stopped receiving
+// the updates is closed and the peer is marked as disconnected
+const DefaultSyncStreamInactivityTimeout = 30 * time.Second
+
+// DefaultPresenceDisconnectDelay is how long a disconnect of a peer lasts before it is shared with the other peers,
+// so a peer reconnecting right away (e.g. after switching networks) isn't reported offline
+const DefaultPresenceDisconnectDelay = 10 * time.Second
+
+// MarkPeerDisconnected marks a peer as disconnected after the Management service has detected the loss of its Sync stream.
+// The lastSeen is the last time the peer was known to be connected, which can be earlier than the detection
+func (am *DefaultAccountManager) MarkPeerDisconnected(peerKey string, lastSeen time.Time) error {
+	account, unlock, err := am.lockPeerAccount(peerKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	now := time.Now()
+	return am.savePeerPresence(account, peerKey, func(status *PeerStatus) {
+		status.LastSeen = lastSeen
+		status.DisconnectedAt = now
+		status.Connected = false
+	})
+}
+
+// savePeerPresence applies a change of the connection status to a peer of the locked account.
+// If the account shares the presence of the peers, the peers that can reach the peer receive its new presence.
+// A disconnect is shared once it has lasted the presence disconnect delay, so a reconnect within the delay isn't shared either
+func (am *DefaultAccountManager) savePeerPresence(account *Account, peerKey string, update func(status *PeerStatus)) error {
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	if peerCopy.Status == nil {
+		// peers restored from an older store might not have a status yet
+		peerCopy.Status = &PeerStatus{}
+	}
+	wasConnected := peerCopy.Status.Connected
+	update(peerCopy.Status)
+
+	err := am.Store.SavePeer(account.Id, peerCopy)
+	if err != nil {
+		return err
+	}
+
+	if !account.PresenceSharing || wasConnected == peerCopy.Status.Connected {
+		return nil
+	}
+
+	account.Peers[peerKey] = peerCopy
+	if am.presenceDisconnectDelay > 0 {
+		if !peerCopy.Status.Connected {
+			disconnectedAt := peerCopy.Status.DisconnectedAt
+			time.AfterFunc(am.presenceDisconnectDelay, func() {
+				am.sharePeerDisconnect(peerKey, disconnectedAt)
+			})
+			return nil
+		}
+		if time.Since(peerCopy.Status.DisconnectedAt) < am.presenceDisconnectDelay {
+			log.Debugf("peer %s has reconnected within %s, its disconnect isn't shared", peerKey, am.presenceDisconnectDelay)
+			return nil
+		}
+	}
+
+	return am.sendPeerPresence(account, peerCopy)
+}
+
+// sharePeerDisconnect shares the disconnect of a peer once it has lasted the presence disconnect delay.
+// Nothing is shared if the peer has reconnected or disconnected again meanwhile
+func (am *DefaultAccountManager) sharePeerDisconnect(peerKey string, disconnectedAt time.Time) {
+	account, unlock, err := am.lockPeerAccount(peerKey)
+	if err != nil {
+		// the peer has been removed meanwhile
+		log.Debugf("not sharing disconnect of peer %s: %v", peerKey, err)
+		return
+	}
+	defer unlock()
+
+	peer, ok := account.Peers[peerKey]
+	if !ok || !account.PresenceSharing || peer.Status == nil || peer.Status.Connected ||
+		!peer.Status.DisconnectedAt.Equal(disconnectedAt) {
+		return
+	}
+
+	err = am.sendPeerPresence(account, peer)
+	if err != nil {
+		log.Warnf("failed sharing disconnect of peer %s: %v", peerKey, err)
+	}
+}
+
+// sendPeerPresence sends the presence of a peer to the peers that can reach it. Unlike a network map,
+// the presence update doesn't change the serial of the network
+func (am *DefaultAccountManager) sendPeerPresence(account *Account, peer *Peer) error {
+	presence := &proto.RemotePeerPresence{
+		WgPubKey: peer.Key,
+		Presence: toPeerPresence(peer, am.presenceDisconnectDelay),
+	}
+	for _, reachable := range am.getReachablePeers(account, peer.Key) {
+		err := am.peersUpdateManager.SendUpdate(reachable.Peer.Key, &UpdateMessage{
+			Update: &proto.SyncResponse{
+				PresenceUpdates: []*proto.RemotePeerPresence{presence},
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateAccountPresenceSharing enables or disables sharing the presence of the peers of the account
+// with the peers that can reach them. All the peers receive an updated network map
+func (am *DefaultAccountManager) UpdateAccountPresenceSharing(accountId string, enabled bool, userID string) (*Account, error) {
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	if account.PresenceSharing == enabled {
+		return account, nil
+	}
+
+	account.PresenceSharing = enabled
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, accountId, accountId, activity.AccountSettingsUpdated,
+		map[string]string{"setting": "presence_sharing"},
+		map[string]bool{"PresenceSharing": !enabled}, map[string]bool{"PresenceSharing": enabled}))
+	if err != nil {
+		return nil, err
+	}
+
+	for peerKey := range account.Peers {
+		err = am.sendNetworkMap(account, peerKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return account, nil
+}
+
+// toPeerPresence returns the presence of a peer shared with the other peers. A peer disconnected for less than
+// the disconnectDelay is still shared as connected
+func toPeerPresence(peer *Peer, disconnectDelay time.Duration) *proto.PeerPresence {
+	presence := &proto.PeerPresence{}
+	if peer.Status != nil {
+		presence.Connected = peer.Status.Connected || time.Since(peer.Status.DisconnectedAt) < disconnectDelay
+		presence.LastSeen = timestamppb.New(peer.Status.LastSeen)
+	}
+	return presence
+}
@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func Test_SyncStreamStalled(t *testing.T) {
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		TURNConfig: &TURNConfig{
+			TimeBasedCredentials: false,
+			Secret:               "whatever",
+		},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir:                     dir,
+		SyncStreamInactivityTimeout: util.Duration{Duration: time.Second},
+	}
+
+	store, err := NewStore(config.Datadir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peersUpdateManager := NewPeersUpdateManager()
+	accountManager, err := BuildManager(store, peersUpdateManager, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turnManager := NewTimeBasedAuthSecretsManager(peersUpdateManager, config.TURNConfig)
+	mgmtServer, err := NewServer(config, accountManager, peersUpdateManager, turnManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:33094")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
+	mgmtProto.RegisterManagementServiceServer(s, mgmtServer)
+	go func() {
+		if err = s.Serve(lis); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+	defer s.Stop()
+
+	client, clientConn, err := createRawClient("localhost:33094")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+
+	peers, err := registerPeers(1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *peers[0]
+	peerKey := key.PublicKey().String()
+
+	// a connection with a fixed flow control window, so the server can send only a limited amount of data
+	// the peer doesn't read
+	stalledConn, err := grpc.Dial("localhost:33094",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithBlock(),
+		grpc.WithInitialWindowSize(64*1024),
+		grpc.WithInitialConnWindowSize(64*1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stalledConn.Close()
+	stalledClient := mgmtProto.NewManagementServiceClient(stalledConn)
+
+	message, err := encryption.EncryptMessage(mgmtServer.wgKey.PublicKey(), key, &mgmtProto.SyncRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync, err := stalledClient.Sync(context.Background(), &mgmtProto.EncryptedMessage{
+		WgPubKey: peerKey,
+		Body:     message,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read the initial sync and stop reading
+	err = sync.RecvMsg(&mgmtProto.EncryptedMessage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := false
+	for i := 0; i < 50 && !connected; i++ {
+		peer, err := accountManager.GetPeer(peerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		connected = peer.Status.Connected
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !connected {
+		t.Fatal("expecting the peer to be connected after opening the Sync stream")
+	}
+
+	// updates bigger than the flow control window
+	update := &mgmtProto.SyncResponse{NetworkMap: &mgmtProto.NetworkMap{
+		RemotePeers: []*mgmtProto.RemotePeerConfig{{Name: strings.Repeat("x", 32*1024)}},
+	}}
+	stalledAt := time.Now()
+	for i := 0; i < 10; i++ {
+		err = peersUpdateManager.SendUpdate(peerKey, &UpdateMessage{Update: update})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var peer *Peer
+	for i := 0; i < 50; i++ {
+		peer, err = accountManager.GetPeer(peerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !peer.Status.Connected {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if peer.Status.Connected {
+		t.Fatal("expecting the peer that stopped reading its Sync stream to be marked as disconnected")
+	}
+	if peer.Status.DisconnectedAt.Sub(peer.Status.LastSeen) < time.Second {
+		t.Errorf("expecting the peer to be disconnected after the inactivity timeout, last seen %s, disconnected at %s",
+			peer.Status.LastSeen, peer.Status.DisconnectedAt)
+	}
+	if peer.Status.LastSeen.Before(stalledAt) {
+		t.Errorf("expecting the peer to be last seen receiving the updates, got %s", peer.Status.LastSeen)
+	}
+}
+
+func TestAccountManager_PresenceSharing(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var peers []*Peer
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("peer-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peers[1].Key)
+	defer manager.peersUpdateManager.CloseChannel(peers[1].Key)
+
+	manager.SetPresenceDisconnectDelay(0)
+
+	// the presence isn't shared by default
+	err = manager.MarkPeerConnected(peers[0].Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 0 {
+		t.Fatalf("expecting no updates when the presence isn't shared, got %d", len(updates))
+	}
+
+	account, err = manager.UpdateAccountPresenceSharing(account.Id, true, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !account.PresenceSharing {
+		t.Fatal("expecting the presence sharing to be enabled")
+	}
+
+	select {
+	case update := <-updates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		if len(remotePeers) != 1 || !remotePeers[0].GetPresence().GetConnected() {
+			t.Errorf("expecting the network map to include the presence of the connected peer, got %v", remotePeers)
+		}
+	default:
+		t.Fatal("expecting the peers to receive an update after enabling the presence sharing")
+	}
+
+	serial := account.Network.CurrentSerial()
+	lastSeen := time.Now().Add(-time.Minute)
+	err = manager.MarkPeerDisconnected(peers[0].Key, lastSeen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-updates:
+		if update.Update.GetNetworkMap() != nil {
+			t.Error("expecting a presence update without the network map")
+		}
+		presenceUpdates := update.Update.GetPresenceUpdates()
+		if len(presenceUpdates) != 1 || presenceUpdates[0].GetWgPubKey() != peers[0].Key ||
+			presenceUpdates[0].GetPresence().GetConnected() {
+			t.Fatalf("expecting the presence update of the disconnected peer, got %v", presenceUpdates)
+		}
+		if !presenceUpdates[0].GetPresence().GetLastSeen().AsTime().Equal(lastSeen) {
+			t.Errorf("expecting the peer to be last seen at %s, got %s", lastSeen, presenceUpdates[0].GetPresence().GetLastSeen().AsTime())
+		}
+	default:
+		t.Fatal("expecting the peers that can reach the disconnected peer to receive an update")
+	}
+
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Network.CurrentSerial() != serial {
+		t.Errorf("expecting the presence update to keep the network serial %d, got %d", serial, account.Network.CurrentSerial())
+	}
+
+	peer, err := manager.GetPeer(peers[0].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Status.Connected || peer.Status.DisconnectedAt.IsZero() {
+		t.Errorf("expecting the peer to be disconnected, got %+v", peer.Status)
+	}
+}
+
+func TestAccountManager_PresenceDisconnectDelay(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delay := 300 * time.Millisecond
+	manager.SetPresenceDisconnectDelay(delay)
+
+	account, err := manager.GetOrCreateAccountByUser("account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = manager.UpdateAccountPresenceSharing(account.Id, true, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var peers []*Peer
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("peer-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+
+	err = manager.MarkPeerConnected(peers[0].Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(peers[1].Key)
+	defer manager.peersUpdateManager.CloseChannel(peers[1].Key)
+
+	// a peer reconnecting within the delay isn't reported offline
+	err = manager.MarkPeerConnected(peers[0].Key, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkMap, err := manager.GetNetworkMap(peers[1].Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remotePeers := toRemotePeerConfig(networkMap.Peers, networkMap.PresenceSharing, networkMap.PresenceDisconnectDelay)
+	if len(remotePeers) != 1 || !remotePeers[0].GetPresence().GetConnected() {
+		t.Errorf("expecting the network map to report the peer disconnected within the delay as connected, got %v", remotePeers)
+	}
+	err = manager.MarkPeerConnected(peers[0].Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-updates:
+		t.Fatalf("expecting no update for a peer reconnecting within the delay, got %v", update.Update)
+	case <-time.After(2 * delay):
+	}
+
+	// a disconnect lasting the delay is shared once the delay has passed
+	disconnectedAt := time.Now()
+	err = manager.MarkPeerConnected(peers[0].Key, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case update := <-updates:
+		if time.Since(disconnectedAt) < delay {
+			t.Errorf("expecting the disconnect to be shared after %s, shared after %s", delay, time.Since(disconnectedAt))
+		}
+		presenceUpdates := update.Update.GetPresenceUpdates()
+		if len(presenceUpdates) != 1 || presenceUpdates[0].GetPresence().GetConnected() {
+			t.Errorf("expecting the presence update of the disconnected peer, got %v", presenceUpdates)
+		}
+	case <-time.After(5 * delay):
+		t.Fatal("expecting the disconnect lasting the delay to be shared")
+	}
+
+	// the reconnect after the shared disconnect is shared right away
+	err = manager.MarkPeerConnected(peers[0].Key, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case update := <-updates:
+		presenceUpdates := update.Update.GetPresenceUpdates()
+		if len(presenceUpdates) != 1 || !presenceUpdates[0].GetPresence().GetConnected() {
+			t.Errorf("expecting the presence update of the connected peer, got %v", presenceUpdates)
+		}
+	default:
+		t.Fatal("expecting the reconnect to be shared right away")
+	}
+}
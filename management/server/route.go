@@ -0,0 +1,229 @@
+package server
+
+import (
+	"net"
+	"sort"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Route is a network behind a routing peer (e.g. an office LAN behind a gateway) advertised to the peers of the groups.
+// Several routes to the same prefix through different routing peers make the network redundant
+type Route struct {
+	// ID of the route
+	ID string
+
+	// Description visible in the UI
+	Description string
+
+	// Prefix is the routed network in the CIDR notation, e.g. 192.168.10.0/24
+	Prefix string
+
+	// Peer is the key of the routing peer the traffic to the Prefix is sent to
+	Peer string
+
+	// Groups list of groups IDs of peers the route is advertised to
+	Groups []string
+
+	// Metric orders the routes to the same prefix, the peers route the prefix through the connected routing peer
+	// with the lowest metric
+	Metric int
+
+	// Enabled indicates that the route is advertised
+	Enabled bool
+}
+
+func (r *Route) Copy() *Route {
+	return &Route{
+		ID:          r.ID,
+		Description: r.Description,
+		Prefix:      r.Prefix,
+		Peer:        r.Peer,
+		Groups:      append([]string(nil), r.Groups...),
+		Metric:      r.Metric,
+		Enabled:     r.Enabled,
+	}
+}
+
+// validateRoute checks that the route refers to the peer and the groups of the account and that its prefix doesn't
+// overlap the account network. The prefix is normalized to the network address, e.g. 192.168.10.1/24 -> 192.168.10.0/24
+func validateRoute(account *Account, route *Route) error {
+	_, prefix, err := net.ParseCIDR(route.Prefix)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid route prefix %s: %v", route.Prefix, err)
+	}
+	if prefix.Contains(account.Network.Net.IP) || account.Network.Net.Contains(prefix.IP) {
+		return status.Errorf(codes.InvalidArgument, "route prefix %s overlaps the account network %s",
+			prefix.String(), account.Network.Net.String())
+	}
+	route.Prefix = prefix.String()
+
+	if _, ok := account.Peers[route.Peer]; !ok {
+		return status.Errorf(codes.InvalidArgument, "routing peer %s not found", route.Peer)
+	}
+
+	for _, groupID := range route.Groups {
+		if _, ok := account.Groups[groupID]; !ok {
+			return status.Errorf(codes.InvalidArgument, "group with ID %s not found", groupID)
+		}
+	}
+
+	if route.Metric < 0 {
+		return status.Errorf(codes.InvalidArgument, "route metric can't be negative")
+	}
+
+	return nil
+}
+
+// GetRoute of the account from the store
+func (am *DefaultAccountManager) GetRoute(accountID, routeID string) (*Route, error) {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	route, ok := account.Routes[routeID]
+	if ok {
+		return route, nil
+	}
+
+	return nil, status.Errorf(codes.NotFound, "route with ID %s not found", routeID)
+}
+
+// SaveRoute of the account in the store and sends the updated network map to the peers of the account.
+// The userID is the user who initiated the change
+func (am *DefaultAccountManager) SaveRoute(accountID, userID string, route *Route) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "account not found")
+	}
+
+	err = validateRoute(account, route)
+	if err != nil {
+		return err
+	}
+
+	if account.Routes == nil {
+		account.Routes = map[string]*Route{}
+	}
+
+	eventType := activity.RouteCreated
+	var before interface{}
+	if existing, exists := account.Routes[route.ID]; exists {
+		eventType = activity.RouteUpdated
+		before = existing
+	}
+	event := newAuditEvent(userID, route.ID, accountID, eventType,
+		map[string]string{"prefix": route.Prefix, "peer": route.Peer}, before, route)
+
+	account.Routes[route.ID] = route
+	account.Network.IncSerial()
+	err = am.saveAccount(account, event)
+	if err != nil {
+		return err
+	}
+
+	return am.updateAccountPeers(account)
+}
+
+// DeleteRoute of the account from the store and sends the updated network map to the peers of the account.
+// The userID is the user who initiated the removal
+func (am *DefaultAccountManager) DeleteRoute(accountID, userID, routeID string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "account not found")
+	}
+
+	route, ok := account.Routes[routeID]
+	if !ok {
+		return nil
+	}
+	delete(account.Routes, routeID)
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, routeID, accountID, activity.RouteDeleted,
+		map[string]string{"prefix": route.Prefix, "peer": route.Peer}, route, nil))
+	if err != nil {
+		return err
+	}
+
+	return am.updateAccountPeers(account)
+}
+
+// ListRoutes of the account from the store
+func (am *DefaultAccountManager) ListRoutes(accountID string) ([]*Route, error) {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	routes := make([]*Route, 0, len(account.Routes))
+	for _, item := range account.Routes {
+		routes = append(routes, item)
+	}
+
+	return routes, nil
+}
+
+// getPeerRoutes returns the enabled routes advertised to a given peer: the peer belongs to one of the groups of a route
+// and can reach its routing peer (one of the reachable peers). All the routes to the same prefix are returned,
+// the peer picks the routing peer among them. The result is sorted by the prefix, the metric and the ID
+func getPeerRoutes(account *Account, peerKey string, reachable []*Peer) []*Route {
+	reachableKeys := make(map[string]struct{}, len(reachable))
+	for _, peer := range reachable {
+		reachableKeys[peer.Key] = struct{}{}
+	}
+
+	peerGroups := map[string]struct{}{}
+	for _, group := range account.Groups {
+		if contains(group.Peers, peerKey) {
+			peerGroups[group.ID] = struct{}{}
+		}
+	}
+
+	var routes []*Route
+	for _, route := range account.Routes {
+		if !route.Enabled || route.Peer == peerKey {
+			continue
+		}
+		if _, ok := reachableKeys[route.Peer]; !ok {
+			continue
+		}
+		advertised := false
+		for _, groupID := range route.Groups {
+			if _, ok := peerGroups[groupID]; ok {
+				advertised = true
+				break
+			}
+		}
+		if advertised {
+			routes = append(routes, route.Copy())
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Prefix != routes[j].Prefix {
+			return routes[i].Prefix < routes[j].Prefix
+		}
+		if routes[i].Metric != routes[j].Metric {
+			return routes[i].Metric < routes[j].Metric
+		}
+		return routes[i].ID < routes[j].ID
+	})
+
+	return routes
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_SaveRoute(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var peers []*Peer
+	for i := 0; i < 2; i++ {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: key.PublicKey().String(), Name: fmt.Sprintf("host-%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+	gateway, client := peers[0], peers[1]
+
+	group, err := account.GetGroupAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []*Route{
+		{ID: "route", Prefix: "192.168.10.0", Peer: gateway.Key, Groups: []string{group.ID}},
+		{ID: "route", Prefix: account.Network.Net.String(), Peer: gateway.Key, Groups: []string{group.ID}},
+		{ID: "route", Prefix: "192.168.10.0/24", Peer: "unknown", Groups: []string{group.ID}},
+		{ID: "route", Prefix: "192.168.10.0/24", Peer: gateway.Key, Groups: []string{"unknown"}},
+		{ID: "route", Prefix: "192.168.10.0/24", Peer: gateway.Key, Groups: []string{group.ID}, Metric: -1},
+	}
+	for _, route := range invalid {
+		err = manager.SaveRoute(account.Id, "account_creator", route)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expecting saving route %v to fail with InvalidArgument, got %v", route, err)
+		}
+	}
+
+	clientUpdates := manager.peersUpdateManager.CreateChannel(client.Key)
+	defer manager.peersUpdateManager.CloseChannel(client.Key)
+
+	err = manager.SaveRoute(account.Id, "account_creator", &Route{
+		ID: "route", Prefix: "192.168.10.1/24", Peer: gateway.Key, Groups: []string{group.ID}, Enabled: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, err := manager.GetRoute(account.Id, "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.Prefix != "192.168.10.0/24" {
+		t.Errorf("expecting the route prefix to be normalized to 192.168.10.0/24, got %s", route.Prefix)
+	}
+
+	select {
+	case update := <-clientUpdates:
+		routes := update.Update.GetNetworkMap().GetRoutes()
+		if len(routes) != 1 || routes[0].GetPrefix() != "192.168.10.0/24" || routes[0].GetPeer() != gateway.Key {
+			t.Errorf("expecting the route through the routing peer in the update, got %v", routes)
+		}
+	default:
+		t.Errorf("expecting peer %s to receive an update", client.Key)
+	}
+
+	networkMap, err := manager.GetNetworkMap(gateway.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networkMap.Routes) != 0 {
+		t.Errorf("expecting no route advertised to the routing peer itself, got %v", networkMap.Routes)
+	}
+
+	err = manager.DeleteRoute(account.Id, "account_creator", "route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkMap, err = manager.GetNetworkMap(client.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networkMap.Routes) != 0 {
+		t.Errorf("expecting no routes once the route has been deleted, got %v", networkMap.Routes)
+	}
+}
+
+func TestGetPeerRoutes(t *testing.T) {
+	account := &Account{
+		Groups: map[string]*Group{
+			"office":  {ID: "office", Peers: []string{"client", "gateway-a"}},
+			"lab":     {ID: "lab", Peers: []string{"other"}},
+			"routers": {ID: "routers", Peers: []string{"gateway-a", "gateway-b"}},
+		},
+		Routes: map[string]*Route{
+			"lan-a":       {ID: "lan-a", Prefix: "192.168.10.0/24", Peer: "gateway-a", Groups: []string{"office"}, Metric: 100, Enabled: true},
+			"lan-b":       {ID: "lan-b", Prefix: "192.168.10.0/24", Peer: "gateway-b", Groups: []string{"office"}, Metric: 10, Enabled: true},
+			"subnet":      {ID: "subnet", Prefix: "192.168.0.0/16", Peer: "gateway-a", Groups: []string{"office"}, Enabled: true},
+			"lab":         {ID: "lab", Prefix: "10.10.0.0/16", Peer: "gateway-a", Groups: []string{"lab"}, Enabled: true},
+			"disabled":    {ID: "disabled", Prefix: "172.16.0.0/12", Peer: "gateway-a", Groups: []string{"office"}},
+			"unreachable": {ID: "unreachable", Prefix: "172.20.0.0/16", Peer: "gateway-c", Groups: []string{"office"}, Enabled: true},
+			"tie-b":       {ID: "tie-b", Prefix: "10.20.0.0/16", Peer: "gateway-b", Groups: []string{"office"}, Enabled: true},
+			"tie-a":       {ID: "tie-a", Prefix: "10.20.0.0/16", Peer: "gateway-a", Groups: []string{"office"}, Enabled: true},
+		},
+	}
+	reachable := []*Peer{{Key: "gateway-a"}, {Key: "gateway-b"}}
+
+	routes := getPeerRoutes(account, "client", reachable)
+
+	// the routes are sorted by the prefix, the metric and the ID
+	expected := []string{"tie-a", "tie-b", "subnet", "lan-b", "lan-a"}
+	if len(routes) != len(expected) {
+		t.Fatalf("expecting routes %v, got %v", expected, routes)
+	}
+	for i, route := range routes {
+		if route.ID != expected[i] {
+			t.Errorf("expecting route %s, got %s", expected[i], route.ID)
+		}
+	}
+
+	// the routing peer doesn't get its own routes, the lab route isn't advertised to the office
+	routes = getPeerRoutes(account, "gateway-a", []*Peer{{Key: "client"}, {Key: "gateway-b"}})
+	expected = []string{"tie-b", "lan-b"}
+	if len(routes) != len(expected) {
+		t.Fatalf("expecting routes %v, got %v", expected, routes)
+	}
+	for i, route := range routes {
+		if route.ID != expected[i] {
+			t.Errorf("expecting route %s, got %s", expected[i], route.ID)
+		}
+	}
+
+	// a peer outside of the groups of the routes gets none
+	if routes = getPeerRoutes(account, "stranger", reachable); len(routes) != 0 {
+		t.Errorf("expecting no routes advertised to a peer outside of the groups, got %v", routes)
+	}
+}
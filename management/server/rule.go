@@ -1,6 +1,7 @@
 package server
 
 import (
+	"github.com/netbirdio/netbird/management/server/activity"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -27,24 +28,32 @@ type Rule struct {
 	// Destination list of groups IDs of peers
 	Destination []string
 
+	// SourceTags select the peers having any of the tags in addition to the Source groups
+	SourceTags []string
+
+	// DestinationTags select the peers having any of the tags in addition to the Destination groups
+	DestinationTags []string
+
 	// Flow of the traffic allowed by the rule
 	Flow TrafficFlowType
 }
 
 func (r *Rule) Copy() *Rule {
 	return &Rule{
-		ID:          r.ID,
-		Name:        r.Name,
-		Source:      r.Source[:],
-		Destination: r.Destination[:],
-		Flow:        r.Flow,
+		ID:              r.ID,
+		Name:            r.Name,
+		Source:          append([]string(nil), r.Source...),
+		Destination:     append([]string(nil), r.Destination...),
+		Flow:            r.Flow,
+		SourceTags:      append([]string(nil), r.SourceTags...),
+		DestinationTags: append([]string(nil), r.DestinationTags...),
 	}
 }
 
 // GetRule of ACL from the store
 func (am *DefaultAccountManager) GetRule(accountID, ruleID string) (*Rule, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -59,39 +68,71 @@ func (am *DefaultAccountManager) GetRule(accountID, ruleID string) (*Rule, error
 	return nil, status.Errorf(codes.NotFound, "rule with ID %s not found", ruleID)
 }
 
-// SaveRule of ACL in the store
-func (am *DefaultAccountManager) SaveRule(accountID string, rule *Rule) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// SaveRule of ACL in the store. The userID is the user who initiated the change
+func (am *DefaultAccountManager) SaveRule(accountID, userID string, rule *Rule) error {
+	var err error
+	rule.SourceTags, err = normalizeTags(rule.SourceTags)
+	if err != nil {
+		return err
+	}
+	rule.DestinationTags, err = normalizeTags(rule.DestinationTags)
+	if err != nil {
+		return err
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
 		return status.Errorf(codes.NotFound, "account not found")
 	}
 
+	// the groups of another account must not select the peers of this one
+	for _, groupID := range append(append([]string{}, rule.Source...), rule.Destination...) {
+		if _, ok := account.Groups[groupID]; !ok {
+			return status.Errorf(codes.InvalidArgument, "group %s not found", groupID)
+		}
+	}
+
+	eventType := activity.RuleCreated
+	var before interface{}
+	if existing, exists := account.Rules[rule.ID]; exists {
+		eventType = activity.RuleUpdated
+		before = existing
+	}
+	event := newAuditEvent(userID, rule.ID, accountID, eventType, map[string]string{"name": rule.Name}, before, rule)
+
 	account.Rules[rule.ID] = rule
-	return am.Store.SaveAccount(account)
+	account.Network.IncSerial()
+	return am.saveAccount(account, event)
 }
 
-// DeleteRule of ACL from the store
-func (am *DefaultAccountManager) DeleteRule(accountID, ruleID string) error {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+// DeleteRule of ACL from the store. The userID is the user who initiated the removal
+func (am *DefaultAccountManager) DeleteRule(accountID, userID, ruleID string) error {
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
 		return status.Errorf(codes.NotFound, "account not found")
 	}
 
+	rule, ok := account.Rules[ruleID]
+	if !ok {
+		return nil
+	}
 	delete(account.Rules, ruleID)
 
-	return am.Store.SaveAccount(account)
+	account.Network.IncSerial()
+	return am.saveAccount(account, newAuditEvent(userID, ruleID, accountID, activity.RuleDeleted,
+		map[string]string{"name": rule.Name}, rule, nil))
 }
 
 // ListRules of ACL from the store
 func (am *DefaultAccountManager) ListRules(accountID string) ([]*Rule, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
+	unlock := am.Store.AcquireAccountLock(accountID)
+	defer unlock()
 
 	account, err := am.Store.GetAccount(accountID)
 	if err != nil {
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	pb "google.golang.org/protobuf/proto"
+
+	"github.com/netbirdio/netbird/management/proto"
+)
+
+// DefaultSyncSessionGracePeriod is a period after the Sync stream of a peer has been closed during which the peer can
+// resume the stream with its session token
+const DefaultSyncSessionGracePeriod = 5 * time.Minute
+
+// SyncSessionManager issues the session tokens of the Sync streams and keeps track of the sessions that can be resumed.
+// Sessions are held in memory, so a restart of the Management service invalidates all the tokens
+type SyncSessionManager struct {
+	mux         sync.Mutex
+	sessions    map[string]*syncSession
+	gracePeriod time.Duration
+}
+
+// syncSession is a Sync stream session bound to the peer it has been issued to
+type syncSession struct {
+	peerKey string
+	// closedAt is the time the stream of the session has been closed, zero while the stream is open
+	closedAt time.Time
+	// sent indicates that a NetworkMap has been sent on the session, sentSerial and sentPeers are its serial and remote
+	// peers by key, the base of the next remote peers delta
+	sent       bool
+	sentSerial uint64
+	sentPeers  map[string]*proto.RemotePeerConfig
+}
+
+// NewSyncSessionManager returns a new instance of SyncSessionManager
+func NewSyncSessionManager(gracePeriod time.Duration) *SyncSessionManager {
+	return &SyncSessionManager{
+		sessions:    make(map[string]*syncSession),
+		gracePeriod: gracePeriod,
+	}
+}
+
+// OpenSession issues a token of a new session of the peer's Sync stream
+func (m *SyncSessionManager) OpenSession(peerKey string) string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	m.removeExpired()
+
+	token := uuid.New().String()
+	m.sessions[token] = &syncSession{peerKey: peerKey}
+	return token
+}
+
+// ResumeSession reopens a closed session of the peer. Returns false if the token hasn't been issued to the peer,
+// the session is still open or its grace period has passed
+func (m *SyncSessionManager) ResumeSession(token string, peerKey string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	session, ok := m.sessions[token]
+	if !ok || session.peerKey != peerKey || session.closedAt.IsZero() {
+		return false
+	}
+
+	if m.expired(session) {
+		delete(m.sessions, token)
+		return false
+	}
+
+	session.closedAt = time.Time{}
+	return true
+}
+
+// CloseSession starts the grace period of a session which stream has been closed
+func (m *SyncSessionManager) CloseSession(token string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if session, ok := m.sessions[token]; ok {
+		session.closedAt = time.Now()
+	}
+}
+
+// ResumeNetworkMap records the first NetworkMap sent on the stream of the session. The remote peers of the networkMap are
+// replaced with a delta, see NetworkMap.remotePeersDelta, if the peer resumes the session and appliedSerial is the serial
+// of the last NetworkMap sent on it, 0 for a new session. Returns true if the networkMap has been turned into a delta
+func (m *SyncSessionManager) ResumeNetworkMap(token string, networkMap *proto.NetworkMap, appliedSerial uint64) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	session, ok := m.sessions[token]
+	if !ok {
+		return false
+	}
+	delta := appliedSerial != 0 && session.sent && session.sentSerial == appliedSerial
+	return session.sendNetworkMap(networkMap, delta)
+}
+
+// UpdateNetworkMap records the NetworkMap sent on the open session and replaces its remote peers with a delta to the
+// last NetworkMap sent on the session, see NetworkMap.remotePeersDelta. Returns true if the networkMap has been turned
+// into a delta
+func (m *SyncSessionManager) UpdateNetworkMap(token string, networkMap *proto.NetworkMap) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	session, ok := m.sessions[token]
+	if !ok {
+		return false
+	}
+	return session.sendNetworkMap(networkMap, session.sent)
+}
+
+// sendNetworkMap records the remote peers of the networkMap and replaces them with the delta to the remote peers
+// recorded before if delta is true. The caller holds the lock
+func (s *syncSession) sendNetworkMap(networkMap *proto.NetworkMap, delta bool) bool {
+	peers := make(map[string]*proto.RemotePeerConfig, len(networkMap.GetRemotePeers()))
+	for _, peer := range networkMap.GetRemotePeers() {
+		peers[peer.GetWgPubKey()] = peer
+	}
+
+	baseSerial, basePeers := s.sentSerial, s.sentPeers
+	s.sent, s.sentSerial, s.sentPeers = true, networkMap.GetSerial(), peers
+	if !delta {
+		return false
+	}
+
+	var changed []*proto.RemotePeerConfig
+	for _, peer := range networkMap.GetRemotePeers() {
+		if base, ok := basePeers[peer.GetWgPubKey()]; !ok || !pb.Equal(base, peer) {
+			changed = append(changed, peer)
+		}
+	}
+	var removed []string
+	for key := range basePeers {
+		if _, ok := peers[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(removed)
+
+	networkMap.RemotePeers = changed
+	networkMap.RemotePeersIsEmpty = len(changed) == 0
+	networkMap.RemotePeersDelta = true
+	networkMap.BaseSerial = baseSerial
+	networkMap.RemovedPeers = removed
+	return true
+}
+
+// removeExpired removes the closed sessions which grace period has passed
+func (m *SyncSessionManager) removeExpired() {
+	for token, session := range m.sessions {
+		if !session.closedAt.IsZero() && m.expired(session) {
+			delete(m.sessions, token)
+		}
+	}
+}
+
+func (m *SyncSessionManager) expired(session *syncSession) bool {
+	return time.Since(session.closedAt) > m.gracePeriod
+}
@@ -0,0 +1,288 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/encryption"
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	"github.com/netbirdio/netbird/util"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+)
+
+func Test_SyncSessionResume(t *testing.T) {
+	mgmtServer, client := startSessionTestServer(t, 33095, 0)
+
+	peers, err := registerPeers(2, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *peers[0]
+
+	first := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{})
+	if first.GetSessionToken() == "" || first.GetNetworkMap() == nil {
+		t.Fatalf("expecting the first sync to issue a session token and to send the network map, got %v", first)
+	}
+	serial := first.GetNetworkMap().GetSerial()
+
+	resumed := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial})
+	if resumed.GetSessionToken() != first.GetSessionToken() {
+		t.Errorf("expecting the resumed session to keep the token %s, got %s", first.GetSessionToken(), resumed.GetSessionToken())
+	}
+	if resumed.GetNetworkMap() != nil || len(resumed.GetRemotePeers()) != 0 {
+		t.Errorf("expecting the resumed session not to send the unchanged network map, got %v", resumed.GetNetworkMap())
+	}
+	if resumed.GetWiretrusteeConfig() == nil {
+		t.Error("expecting the resumed session to send the Wiretrustee config")
+	}
+
+	// the network has changed while the peer was disconnected
+	_, err = registerPeers(1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial})
+	if changed.GetSessionToken() != first.GetSessionToken() {
+		t.Errorf("expecting the resumed session to keep the token %s, got %s", first.GetSessionToken(), changed.GetSessionToken())
+	}
+	if changed.GetNetworkMap().GetSerial() <= serial || len(changed.GetNetworkMap().GetRemotePeers()) != 2 {
+		t.Errorf("expecting the resumed session to send the changed network map, got %v", changed.GetNetworkMap())
+	}
+
+	// a token is bound to the peer it has been issued to
+	other := syncOnce(t, mgmtServer, client, *peers[1], &mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial})
+	if other.GetSessionToken() == first.GetSessionToken() || other.GetNetworkMap() == nil {
+		t.Errorf("expecting another peer not to resume the session, got token %s and network map %v",
+			other.GetSessionToken(), other.GetNetworkMap())
+	}
+}
+
+func Test_SyncSessionResumeExpired(t *testing.T) {
+	gracePeriod := 100 * time.Millisecond
+	mgmtServer, client := startSessionTestServer(t, 33096, gracePeriod)
+
+	peers, err := registerPeers(1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *peers[0]
+
+	first := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{})
+	serial := first.GetNetworkMap().GetSerial()
+
+	time.Sleep(2 * gracePeriod)
+
+	expired := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial})
+	if expired.GetSessionToken() == "" || expired.GetSessionToken() == first.GetSessionToken() {
+		t.Errorf("expecting a new session token after the grace period, got %s", expired.GetSessionToken())
+	}
+	if expired.GetNetworkMap().GetSerial() != serial {
+		t.Errorf("expecting the full network map with serial %d after the grace period, got %v", serial, expired.GetNetworkMap())
+	}
+}
+
+func Test_SyncSessionResumeDelta(t *testing.T) {
+	mgmtServer, client := startSessionTestServer(t, 33101, 0)
+
+	peers, err := registerPeers(2, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := *peers[0]
+
+	first := syncOnce(t, mgmtServer, client, key, &mgmtProto.SyncRequest{RemotePeersDelta: true})
+	if first.GetNetworkMap().GetRemotePeersDelta() || len(first.GetNetworkMap().GetRemotePeers()) != 1 {
+		t.Fatalf("expecting the first sync to send the complete network map, got %v", first.GetNetworkMap())
+	}
+	if len(first.GetRemotePeers()) != 0 || first.GetPeerConfig() != nil {
+		t.Errorf("expecting the remote peers to be sent in the network map only, got %v", first.GetRemotePeers())
+	}
+	serial := first.GetNetworkMap().GetSerial()
+
+	added, err := registerPeers(1, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := syncOnce(t, mgmtServer, client, key,
+		&mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial, RemotePeersDelta: true})
+	networkMap := resumed.GetNetworkMap()
+	if !networkMap.GetRemotePeersDelta() || networkMap.GetBaseSerial() != serial || networkMap.GetSerial() <= serial {
+		t.Fatalf("expecting the resumed session to send the remote peers delta to serial %d, got %v", serial, networkMap)
+	}
+	if len(networkMap.GetRemotePeers()) != 1 || networkMap.GetRemotePeers()[0].GetWgPubKey() != added[0].PublicKey().String() ||
+		len(networkMap.GetRemovedPeers()) != 0 {
+		t.Errorf("expecting the delta to hold only the added peer %s, got %v", added[0].PublicKey(), networkMap)
+	}
+	if networkMap.GetPeerConfig() == nil {
+		t.Error("expecting the delta to hold the complete peer config")
+	}
+
+	// the peer hasn't applied the last network map sent on the session
+	stale := syncOnce(t, mgmtServer, client, key,
+		&mgmtProto.SyncRequest{SessionToken: first.GetSessionToken(), Serial: serial, RemotePeersDelta: true})
+	if stale.GetNetworkMap().GetRemotePeersDelta() || len(stale.GetNetworkMap().GetRemotePeers()) != 2 {
+		t.Errorf("expecting the complete network map for a serial other than the last sent one, got %v", stale.GetNetworkMap())
+	}
+}
+
+func TestSyncSessionManager_UpdateNetworkMap(t *testing.T) {
+	manager := NewSyncSessionManager(DefaultSyncSessionGracePeriod)
+	token := manager.OpenSession("peer")
+
+	networkMap := &mgmtProto.NetworkMap{Serial: 1, RemotePeers: []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: "a", AllowedIps: []string{"100.64.0.1/32"}},
+		{WgPubKey: "b", AllowedIps: []string{"100.64.0.2/32"}},
+		{WgPubKey: "c", AllowedIps: []string{"100.64.0.3/32"}},
+	}}
+	if manager.UpdateNetworkMap(token, networkMap) || len(networkMap.GetRemotePeers()) != 3 {
+		t.Fatalf("expecting the first network map of the session to be sent complete, got %v", networkMap)
+	}
+
+	networkMap = &mgmtProto.NetworkMap{Serial: 2, RemotePeers: []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: "a", AllowedIps: []string{"100.64.0.1/32"}},
+		{WgPubKey: "b", AllowedIps: []string{"100.64.0.2/32"}, Name: "renamed"},
+		{WgPubKey: "d", AllowedIps: []string{"100.64.0.4/32"}},
+	}}
+	if !manager.UpdateNetworkMap(token, networkMap) {
+		t.Fatal("expecting the network map to be sent as a delta")
+	}
+	var changed []string
+	for _, peer := range networkMap.GetRemotePeers() {
+		changed = append(changed, peer.GetWgPubKey())
+	}
+	if fmt.Sprint(changed) != "[b d]" || fmt.Sprint(networkMap.GetRemovedPeers()) != "[c]" || networkMap.GetBaseSerial() != 1 {
+		t.Errorf("expecting peers b and d changed and c removed since serial 1, got %v", networkMap)
+	}
+
+	// nothing has changed
+	networkMap = &mgmtProto.NetworkMap{Serial: 3, RemotePeers: []*mgmtProto.RemotePeerConfig{
+		{WgPubKey: "a", AllowedIps: []string{"100.64.0.1/32"}},
+		{WgPubKey: "b", AllowedIps: []string{"100.64.0.2/32"}, Name: "renamed"},
+		{WgPubKey: "d", AllowedIps: []string{"100.64.0.4/32"}},
+	}}
+	if !manager.UpdateNetworkMap(token, networkMap) || len(networkMap.GetRemotePeers()) != 0 ||
+		!networkMap.GetRemotePeersIsEmpty() || networkMap.GetBaseSerial() != 2 {
+		t.Errorf("expecting an empty delta to serial 2, got %v", networkMap)
+	}
+
+	if manager.ResumeNetworkMap(token, &mgmtProto.NetworkMap{Serial: 4}, 2) {
+		t.Error("expecting no delta to a serial other than the last sent one")
+	}
+	if manager.UpdateNetworkMap("unknown", &mgmtProto.NetworkMap{Serial: 1}) {
+		t.Error("expecting no delta on an unknown session")
+	}
+}
+
+// startSessionTestServer starts a Management service with the given session grace period, 0 for the default one
+func startSessionTestServer(t *testing.T, port int, gracePeriod time.Duration) (*Server, mgmtProto.ManagementServiceClient) {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := util.CopyFileContents("testdata/store.json", filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		TURNConfig: &TURNConfig{
+			TimeBasedCredentials: false,
+			Secret:               "whatever",
+		},
+		Signal: &Host{
+			Proto: "http",
+			URI:   "signal.wiretrustee.com:10000",
+		},
+		Datadir:                dir,
+		SyncSessionGracePeriod: util.Duration{Duration: gracePeriod},
+	}
+
+	store, err := NewStore(config.Datadir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peersUpdateManager := NewPeersUpdateManager()
+	accountManager, err := BuildManager(store, peersUpdateManager, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	turnManager := NewTimeBasedAuthSecretsManager(peersUpdateManager, config.TURNConfig)
+	mgmtServer, err := NewServer(config, accountManager, peersUpdateManager, turnManager)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
+	mgmtProto.RegisterManagementServiceServer(s, mgmtServer)
+	go func() {
+		if err = s.Serve(lis); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(s.Stop)
+
+	client, clientConn, err := createRawClient(fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		clientConn.Close() //nolint
+	})
+
+	return mgmtServer, client
+}
+
+// syncOnce opens a Sync stream of the peer, reads the initial SyncResponse and waits for the server to close the session
+func syncOnce(t *testing.T, mgmtServer *Server, client mgmtProto.ManagementServiceClient, key wgtypes.Key, req *mgmtProto.SyncRequest) *mgmtProto.SyncResponse {
+	t.Helper()
+
+	serverKey := mgmtServer.wgKey.PublicKey()
+	message, err := encryption.EncryptMessage(serverKey, key, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sync, err := client.Sync(ctx, &mgmtProto.EncryptedMessage{
+		WgPubKey: key.PublicKey().String(),
+		Body:     message,
+	})
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+
+	encryptedResp := &mgmtProto.EncryptedMessage{}
+	err = sync.RecvMsg(encryptedResp)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := &mgmtProto.SyncResponse{}
+	err = encryption.DecryptMessage(serverKey, key, encryptedResp.Body, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 50; i++ {
+		mgmtServer.syncSessions.mux.Lock()
+		session, ok := mgmtServer.syncSessions.sessions[resp.GetSessionToken()]
+		closed := ok && !session.closedAt.IsZero()
+		mgmtServer.syncSessions.mux.Unlock()
+		if closed {
+			return resp
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expecting the session %s to be closed after the stream has been closed", resp.GetSessionToken())
+	return nil
+}
@@ -37,20 +37,23 @@ type SetupKey struct {
 	UsedTimes int
 	// LastUsed last time the key was used for peer registration
 	LastUsed time.Time
+	// UsageLimit is the number of peers a reusable key can register. 0 means unlimited, one-off keys register a single peer
+	UsageLimit int
 }
 
 //Copy copies SetupKey to a new object
 func (key *SetupKey) Copy() *SetupKey {
 	return &SetupKey{
-		Id:        key.Id,
-		Key:       key.Key,
-		Name:      key.Name,
-		Type:      key.Type,
-		CreatedAt: key.CreatedAt,
-		ExpiresAt: key.ExpiresAt,
-		Revoked:   key.Revoked,
-		UsedTimes: key.UsedTimes,
-		LastUsed:  key.LastUsed,
+		Id:         key.Id,
+		Key:        key.Key,
+		Name:       key.Name,
+		Type:       key.Type,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		Revoked:    key.Revoked,
+		UsedTimes:  key.UsedTimes,
+		LastUsed:   key.LastUsed,
+		UsageLimit: key.UsageLimit,
 	}
 }
 
@@ -79,28 +82,34 @@ func (key *SetupKey) IsExpired() bool {
 
 // IsOverUsed if key was used too many times
 func (key *SetupKey) IsOverUsed() bool {
-	return key.Type == SetupKeyOneOff && key.UsedTimes >= 1
+	limit := key.UsageLimit
+	if key.Type == SetupKeyOneOff {
+		limit = 1
+	}
+	return limit > 0 && key.UsedTimes >= limit
 }
 
-// GenerateSetupKey generates a new setup key
-func GenerateSetupKey(name string, t SetupKeyType, validFor time.Duration) *SetupKey {
+// GenerateSetupKey generates a new setup key. The usageLimit of a reusable key limits the number of peers it can register,
+// 0 means unlimited
+func GenerateSetupKey(name string, t SetupKeyType, validFor time.Duration, usageLimit int) *SetupKey {
 	key := strings.ToUpper(uuid.New().String())
 	createdAt := time.Now()
 	return &SetupKey{
-		Id:        strconv.Itoa(int(Hash(key))),
-		Key:       key,
-		Name:      name,
-		Type:      t,
-		CreatedAt: createdAt,
-		ExpiresAt: createdAt.Add(validFor),
-		Revoked:   false,
-		UsedTimes: 0,
+		Id:         strconv.Itoa(int(Hash(key))),
+		Key:        key,
+		Name:       name,
+		Type:       t,
+		CreatedAt:  createdAt,
+		ExpiresAt:  createdAt.Add(validFor),
+		Revoked:    false,
+		UsedTimes:  0,
+		UsageLimit: usageLimit,
 	}
 }
 
 // GenerateDefaultSetupKey generates a default setup key
 func GenerateDefaultSetupKey() *SetupKey {
-	return GenerateSetupKey(DefaultSetupKeyName, SetupKeyReusable, DefaultSetupKeyDuration)
+	return GenerateSetupKey(DefaultSetupKeyName, SetupKeyReusable, DefaultSetupKeyDuration, 0)
 }
 
 func Hash(s string) uint32 {
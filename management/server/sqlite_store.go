@@ -0,0 +1,797 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+
+	// registers the pure Go "sqlite" database/sql driver
+	_ "modernc.org/sqlite"
+)
+
+// storeSqliteFileName SQLite Store database file name. Stored in the datadir
+const storeSqliteFileName = "store.db"
+
+// sqliteSchema creates the tables of the SqliteStore.
+// Accounts, peers, setup keys and users have a table each with the columns used for lookups,
+// the rest of an object is kept as JSON in the data column the same way the FileStore persists it
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS accounts (
+	id TEXT PRIMARY KEY,
+	domain TEXT NOT NULL COLLATE NOCASE,
+	domain_category TEXT NOT NULL,
+	is_domain_primary_account INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS accounts_domain ON accounts (domain);
+
+CREATE TABLE IF NOT EXISTS peers (
+	account_id TEXT NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+	key TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (account_id, key)
+);
+CREATE INDEX IF NOT EXISTS peers_key ON peers (key);
+
+CREATE TABLE IF NOT EXISTS setup_keys (
+	account_id TEXT NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+	key TEXT NOT NULL COLLATE NOCASE,
+	data TEXT NOT NULL,
+	PRIMARY KEY (account_id, key)
+);
+CREATE INDEX IF NOT EXISTS setup_keys_key ON setup_keys (key);
+
+CREATE TABLE IF NOT EXISTS users (
+	account_id TEXT NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+	id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (account_id, id)
+);
+CREATE INDEX IF NOT EXISTS users_id ON users (id);
+
+-- audit events aren't removed together with their account
+CREATE TABLE IF NOT EXISTS audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	account_id TEXT NOT NULL,
+	initiator_id TEXT NOT NULL,
+	activity INTEGER NOT NULL,
+	timestamp INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_events_account ON audit_events (account_id, id);
+CREATE INDEX IF NOT EXISTS audit_events_timestamp ON audit_events (timestamp);
+
+CREATE TABLE IF NOT EXISTS health (
+	id INTEGER PRIMARY KEY,
+	checked_at TEXT NOT NULL
+);
+`
+
+// SqliteStore represents an account storage backed by a SQLite database persisted to disk.
+// Unlike the FileStore it writes only the changed account on every change
+type SqliteStore struct {
+	db           *sql.DB
+	accountLocks accountLocks
+}
+
+// NewSqliteStore opens a SQLite store located in the datadir creating it if doesn't exist
+func NewSqliteStore(dataDir string) (*SqliteStore, error) {
+	return openSqliteStore(filepath.Join(dataDir, storeSqliteFileName))
+}
+
+func openSqliteStore(file string) (*SqliteStore, error) {
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)", file))
+	if err != nil {
+		return nil, err
+	}
+
+	// a single connection serializes the transactions the same way the FileStore lock does
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed creating the store schema in %s: %v", file, err)
+	}
+
+	err = os.Chmod(file, 0600)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SqliteStore{db: db}, nil
+}
+
+// AcquireAccountLock acquires the write lock of an account and returns a function releasing it
+func (s *SqliteStore) AcquireAccountLock(accountId string) (unlock func()) {
+	return s.accountLocks.acquire(accountId)
+}
+
+// CheckHealth checks that the database is reachable and writable updating the health check row in a transaction
+func (s *SqliteStore) CheckHealth() error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec("INSERT OR REPLACE INTO health (id, checked_at) VALUES (1, ?)", time.Now().UTC().Format(time.RFC3339Nano))
+		if err != nil {
+			return fmt.Errorf("failed writing to the store database: %v", err)
+		}
+		return nil
+	})
+}
+
+// Close closes the underlying database
+func (s *SqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// SavePeer saves updated peer adding it to the 'All' group of the account if it is new
+func (s *SqliteStore) SavePeer(accountId string, peer *Peer) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		account, err := getSqliteAccountRow(tx, accountId)
+		if err != nil {
+			return err
+		}
+
+		allGroup, err := account.GetGroupAll()
+		if err != nil {
+			return err
+		}
+
+		if !contains(allGroup.Peers, peer.Key) {
+			allGroup.Peers = append(allGroup.Peers, peer.Key)
+			err = saveSqliteAccountRow(tx, account)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = insertSqliteObject(tx, "INSERT OR REPLACE INTO peers (account_id, key, data) VALUES (?, ?, ?)",
+			accountId, peer.Key, peer)
+		if err != nil {
+			return err
+		}
+
+		return checkSqliteAccountBoundary(tx, accountId)
+	})
+}
+
+// DeletePeer deletes peer from the Store removing it from the account groups
+func (s *SqliteStore) DeletePeer(accountId string, peerKey string) (*Peer, error) {
+	var peer *Peer
+	err := s.inTx(func(tx *sql.Tx) error {
+		account, err := getSqliteAccountRow(tx, accountId)
+		if err != nil {
+			return err
+		}
+
+		peer = &Peer{}
+		err = getSqliteObject(tx, peer, "SELECT data FROM peers WHERE account_id = ? AND key = ?", accountId, peerKey)
+		if err == sql.ErrNoRows {
+			return status.Errorf(codes.NotFound, "peer not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM peers WHERE account_id = ? AND key = ?", accountId, peerKey)
+		if err != nil {
+			return err
+		}
+
+		for _, g := range account.Groups {
+			var peers []string
+			for _, p := range g.Peers {
+				if p != peerKey {
+					peers = append(peers, p)
+				}
+			}
+			g.Peers = peers
+		}
+
+		return saveSqliteAccountRow(tx, account)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return peer, nil
+}
+
+// GetPeer returns a peer from a Store
+func (s *SqliteStore) GetPeer(peerKey string) (*Peer, error) {
+	peer := &Peer{}
+	err := s.inTx(func(tx *sql.Tx) error {
+		return getSqliteObject(tx, peer, "SELECT data FROM peers WHERE key = ? LIMIT 1", peerKey)
+	})
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "peer not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	setPeerDefaults(peer)
+	return peer, nil
+}
+
+// SaveAccount updates an existing account or adds a new one replacing its peers, setup keys and users
+func (s *SqliteStore) SaveAccount(account *Account) error {
+	return s.SaveAccountWithAuditEvents(account, nil)
+}
+
+// SaveAccountWithAuditEvents saves the account inserting the audit events in the same transaction
+func (s *SqliteStore) SaveAccountWithAuditEvents(account *Account, events []*activity.Event) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		err := saveSqliteAccountRow(tx, account)
+		if err != nil {
+			return err
+		}
+
+		for _, table := range []string{"peers", "setup_keys", "users"} {
+			_, err = tx.Exec("DELETE FROM "+table+" WHERE account_id = ?", account.Id)
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, peer := range account.Peers {
+			err = insertSqliteObject(tx, "INSERT INTO peers (account_id, key, data) VALUES (?, ?, ?)",
+				account.Id, key, peer)
+			if err != nil {
+				return err
+			}
+		}
+
+		for key, setupKey := range account.SetupKeys {
+			err = insertSqliteObject(tx, "INSERT INTO setup_keys (account_id, key, data) VALUES (?, ?, ?)",
+				account.Id, key, setupKey)
+			if err != nil {
+				return err
+			}
+		}
+
+		for id, user := range account.Users {
+			err = insertSqliteObject(tx, "INSERT INTO users (account_id, id, data) VALUES (?, ?, ?)",
+				account.Id, id, user)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = checkSqliteAccountBoundary(tx, account.Id)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			err = insertSqliteAuditEvent(tx, event)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetAuditEvents returns the audit events of an account selected by the filter sorted by ID
+func (s *SqliteStore) GetAuditEvents(accountId string, filter AuditFilter) ([]*activity.Event, int, error) {
+	where := "account_id = ?"
+	args := []interface{}{accountId}
+	if filter.InitiatorID != "" {
+		where += " AND initiator_id = ?"
+		args = append(args, filter.InitiatorID)
+	}
+	if filter.Activity != nil {
+		where += " AND activity = ?"
+		args = append(args, int(*filter.Activity))
+	}
+
+	// SQLite treats a negative limit as no limit
+	limit := -1
+	if filter.Limit > 0 {
+		limit = filter.Limit
+	}
+
+	events := []*activity.Event{}
+	var total int
+	err := s.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow("SELECT COUNT(*) FROM audit_events WHERE "+where, args...).Scan(&total)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query("SELECT id, data FROM audit_events WHERE "+where+" ORDER BY id LIMIT ? OFFSET ?",
+			append(args, limit, filter.Offset)...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id uint64
+			var data []byte
+			err = rows.Scan(&id, &data)
+			if err != nil {
+				return err
+			}
+
+			event := &activity.Event{}
+			err = json.Unmarshal(data, event)
+			if err != nil {
+				return err
+			}
+			event.ID = id
+			events = append(events, event)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// DeleteAuditEventsBefore removes the audit events of all the accounts older than the given time
+func (s *SqliteStore) DeleteAuditEventsBefore(before time.Time) (int, error) {
+	var removed int64
+	err := s.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM audit_events WHERE timestamp < ?", before.UnixNano())
+		if err != nil {
+			return err
+		}
+		removed, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int(removed), nil
+}
+
+// GetAccountByPrivateDomain returns the primary account of a private domain
+func (s *SqliteStore) GetAccountByPrivateDomain(domain string) (*Account, error) {
+	return s.getAccountBy(
+		"SELECT id FROM accounts WHERE domain = ? AND domain_category = ? AND is_domain_primary_account = 1 LIMIT 1",
+		status.Errorf(codes.NotFound, "provided domain is not registered or is not private"),
+		domain, PrivateCategory,
+	)
+}
+
+// GetAccountBySetupKey returns the account a setup key belongs to
+func (s *SqliteStore) GetAccountBySetupKey(setupKey string) (*Account, error) {
+	return s.getAccountBy(
+		"SELECT account_id FROM setup_keys WHERE key = ? LIMIT 1",
+		status.Errorf(codes.NotFound, "provided setup key doesn't exists"),
+		setupKey,
+	)
+}
+
+// GetAccountPeers returns the peers of an account
+func (s *SqliteStore) GetAccountPeers(accountId string) ([]*Peer, error) {
+	account, err := s.GetAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []*Peer
+	for _, peer := range account.Peers {
+		peers = append(peers, peer)
+	}
+
+	return peers, nil
+}
+
+// GetAllAccounts returns all the accounts of the Store. Accounts failed to load are skipped
+func (s *SqliteStore) GetAllAccounts() (all []*Account) {
+	err := s.inTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM accounts")
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			err = rows.Scan(&id)
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		err = rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			account, err := getSqliteAccount(tx, id)
+			if err != nil {
+				log.Errorf("failed loading account %s from the store: %v", id, err)
+				continue
+			}
+			all = append(all, account)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Errorf("failed loading accounts from the store: %v", err)
+	}
+
+	return all
+}
+
+// GetAccount returns an account by its ID
+func (s *SqliteStore) GetAccount(accountId string) (*Account, error) {
+	var account *Account
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		account, err = getSqliteAccount(tx, accountId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// GetUserAccount returns the account of a user
+func (s *SqliteStore) GetUserAccount(userId string) (*Account, error) {
+	return s.getAccountBy(
+		"SELECT account_id FROM users WHERE id = ? LIMIT 1",
+		status.Errorf(codes.NotFound, "account not found"),
+		userId,
+	)
+}
+
+// GetPeerAccount returns the account of a peer
+func (s *SqliteStore) GetPeerAccount(peerKey string) (*Account, error) {
+	return s.getAccountBy(
+		"SELECT account_id FROM peers WHERE key = ? LIMIT 1",
+		status.Errorf(codes.NotFound, "Provided peer key doesn't exists %s", peerKey),
+		peerKey,
+	)
+}
+
+// GetPeerSrcRules returns the rules selecting the peer in their source by its groups or its tags
+func (s *SqliteStore) GetPeerSrcRules(accountId, peerKey string) ([]*Rule, error) {
+	return s.getPeerRules(accountId, peerKey, func(account *Account, rule *Rule) []string {
+		return rulePeers(account, rule.Source, rule.SourceTags)
+	})
+}
+
+// GetPeerDstRules returns the rules selecting the peer in their destination by its groups or its tags
+func (s *SqliteStore) GetPeerDstRules(accountId, peerKey string) ([]*Rule, error) {
+	return s.getPeerRules(accountId, peerKey, func(account *Account, rule *Rule) []string {
+		return rulePeers(account, rule.Destination, rule.DestinationTags)
+	})
+}
+
+// getPeerRules returns the account rules having the peer in the peers returned by rulePeers
+func (s *SqliteStore) getPeerRules(accountId, peerKey string, rulePeers func(account *Account, rule *Rule) []string) ([]*Rule, error) {
+	account, err := s.GetAccount(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := account.Peers[peerKey]; !ok {
+		return nil, fmt.Errorf("no rules for peer: %s", peerKey)
+	}
+
+	rules := []*Rule{}
+	for _, rule := range account.Rules {
+		if contains(rulePeers(account, rule), peerKey) {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+// getAccountBy returns the account which ID is selected by the query or notFoundErr if nothing was selected
+func (s *SqliteStore) getAccountBy(query string, notFoundErr error, args ...interface{}) (*Account, error) {
+	var account *Account
+	err := s.inTx(func(tx *sql.Tx) error {
+		var accountId string
+		err := tx.QueryRow(query, args...).Scan(&accountId)
+		if err == sql.ErrNoRows {
+			return notFoundErr
+		}
+		if err != nil {
+			return err
+		}
+
+		account, err = getSqliteAccount(tx, accountId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// inTx runs fn in a transaction committing it if fn succeeds
+func (s *SqliteStore) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getSqliteAccount reads an account together with its peers, setup keys and users
+func getSqliteAccount(tx *sql.Tx, accountId string) (*Account, error) {
+	account, err := getSqliteAccountRow(tx, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	account.Peers = make(map[string]*Peer)
+	err = getSqliteObjects(tx, "SELECT key, data FROM peers WHERE account_id = ?", accountId,
+		func(key string, data []byte) error {
+			peer := &Peer{}
+			err := json.Unmarshal(data, peer)
+			if err != nil {
+				return err
+			}
+			setPeerDefaults(peer)
+			account.Peers[key] = peer
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	account.SetupKeys = make(map[string]*SetupKey)
+	err = getSqliteObjects(tx, "SELECT key, data FROM setup_keys WHERE account_id = ?", accountId,
+		func(key string, data []byte) error {
+			setupKey := &SetupKey{}
+			account.SetupKeys[key] = setupKey
+			return json.Unmarshal(data, setupKey)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	account.Users = make(map[string]*User)
+	err = getSqliteObjects(tx, "SELECT id, data FROM users WHERE account_id = ?", accountId,
+		func(id string, data []byte) error {
+			user := &User{}
+			account.Users[id] = user
+			return json.Unmarshal(data, user)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// getSqliteAccountRow reads an account without its peers, setup keys and users
+func getSqliteAccountRow(tx *sql.Tx, accountId string) (*Account, error) {
+	account := &Account{}
+	err := getSqliteObject(tx, account, "SELECT data FROM accounts WHERE id = ?", accountId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if account.Groups == nil {
+		account.Groups = make(map[string]*Group)
+	}
+	if account.Rules == nil {
+		account.Rules = make(map[string]*Rule)
+	}
+
+	return account, nil
+}
+
+// saveSqliteAccountRow writes an account without its peers, setup keys and users
+func saveSqliteAccountRow(tx *sql.Tx, account *Account) error {
+	row := *account
+	row.Peers = nil
+	row.SetupKeys = nil
+	row.Users = nil
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO accounts (id, domain, domain_category, is_domain_primary_account, data)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET
+			domain = excluded.domain,
+			domain_category = excluded.domain_category,
+			is_domain_primary_account = excluded.is_domain_primary_account,
+			data = excluded.data`,
+		account.Id, account.Domain, account.DomainCategory, account.IsDomainPrimaryAccount, data)
+	return err
+}
+
+// checkSqliteAccountBoundary checks that none of the peers and the setup keys of an account belongs to another account.
+// It is run after the rows of the account have been written, so the transaction is rolled back on a violation
+func checkSqliteAccountBoundary(tx *sql.Tx, accountId string) error {
+	var key string
+	err := tx.QueryRow("SELECT key FROM peers WHERE account_id != ? AND key IN (SELECT key FROM peers WHERE account_id = ?) LIMIT 1",
+		accountId, accountId).Scan(&key)
+	if err == nil {
+		return errPeerOfAnotherAccount(key)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	err = tx.QueryRow("SELECT key FROM setup_keys WHERE account_id != ? AND key IN (SELECT key FROM setup_keys WHERE account_id = ?) LIMIT 1",
+		accountId, accountId).Scan(&key)
+	if err == nil {
+		return errSetupKeyOfAnotherAccount(key)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	return nil
+}
+
+func getSqliteObject(tx *sql.Tx, object interface{}, query string, args ...interface{}) error {
+	var data []byte
+	err := tx.QueryRow(query, args...).Scan(&data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, object)
+}
+
+func getSqliteObjects(tx *sql.Tx, query string, accountId string, handle func(key string, data []byte) error) error {
+	rows, err := tx.Query(query, accountId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var data []byte
+		err = rows.Scan(&key, &data)
+		if err != nil {
+			return err
+		}
+
+		err = handle(key, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func insertSqliteObject(tx *sql.Tx, query string, accountId string, key string, object interface{}) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(query, accountId, key, data)
+	return err
+}
+
+// insertSqliteAuditEvent inserts an audit event assigning it the next ID unless it has one already
+func insertSqliteAuditEvent(tx *sql.Tx, event *activity.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	id := sql.NullInt64{Int64: int64(event.ID), Valid: event.ID != 0}
+	result, err := tx.Exec(
+		"INSERT INTO audit_events (id, account_id, initiator_id, activity, timestamp, data) VALUES (?, ?, ?, ?, ?, ?)",
+		id, event.AccountID, event.InitiatorID, int(event.Activity), event.Timestamp.UnixNano(), data,
+	)
+	if err != nil {
+		return err
+	}
+
+	lastID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	event.ID = uint64(lastID)
+
+	return nil
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateFileStoreToSqlite imports the accounts of the FileStore located in the datadir into a new SqliteStore
+// created in the same datadir. The FileStore is left untouched
+func MigrateFileStoreToSqlite(dataDir string) error {
+	fileStorePath := filepath.Join(dataDir, storeFileName)
+	if _, err := os.Stat(fileStorePath); err != nil {
+		return fmt.Errorf("failed reading the file store %s: %v", fileStorePath, err)
+	}
+
+	sqliteStorePath := filepath.Join(dataDir, storeSqliteFileName)
+	if _, err := os.Stat(sqliteStorePath); err == nil {
+		return fmt.Errorf("the SQLite store %s already exists", sqliteStorePath)
+	}
+
+	fileStore, err := NewStore(dataDir)
+	if err != nil {
+		return err
+	}
+
+	sqliteStore, err := NewSqliteStore(dataDir)
+	if err != nil {
+		return err
+	}
+
+	abort := func(err error) error {
+		_ = sqliteStore.Close()
+		// remove the partially imported store to allow running the migration again
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			_ = os.Remove(sqliteStorePath + suffix)
+		}
+		return err
+	}
+
+	accounts := fileStore.GetAllAccounts()
+	for _, account := range accounts {
+		err = sqliteStore.SaveAccount(account)
+		if err != nil {
+			return abort(fmt.Errorf("failed importing account %s: %v", account.Id, err))
+		}
+	}
+
+	// the audit events keep their IDs
+	err = sqliteStore.inTx(func(tx *sql.Tx) error {
+		for _, event := range fileStore.AuditEvents {
+			err := insertSqliteAuditEvent(tx, event)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return abort(fmt.Errorf("failed importing audit events: %v", err))
+	}
+
+	log.Infof("imported %d accounts and %d audit events from %s to %s",
+		len(accounts), len(fileStore.AuditEvents), fileStorePath, sqliteStorePath)
+
+	return sqliteStore.Close()
+}
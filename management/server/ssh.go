@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/netbirdio/netbird/management/proto"
+)
+
+// getSSHAllowedPeers returns the keys of the reachable peers allowed to connect to the SSH server of a given peer:
+// the members of the allowed groups of the peer, none of them if it has no groups. The result keeps the order of the
+// reachable peers and is nil if the SSH server of the peer is disabled
+func getSSHAllowedPeers(account *Account, peerKey string, reachable []*Peer) []string {
+	peer, ok := account.Peers[peerKey]
+	if !ok || !peer.SSHEnabled {
+		return nil
+	}
+
+	allowed := make([]string, 0, len(reachable))
+	for _, remotePeer := range reachable {
+		if inGroups(account, remotePeer.Key, peer.SSHAllowedGroups) {
+			allowed = append(allowed, remotePeer.Key)
+		}
+	}
+	return allowed
+}
+
+// inGroups returns true if the peer is a member of any of the groups of the account, missing groups are skipped
+func inGroups(account *Account, peerKey string, groupIDs []string) bool {
+	for _, groupID := range groupIDs {
+		group, ok := account.Groups[groupID]
+		if ok && contains(group.Peers, peerKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// toProtoSSHConfig converts the keys of the peers allowed to connect to the SSH server of a peer, nil if it's disabled
+func toProtoSSHConfig(allowedPeers []string) *proto.SSHConfig {
+	if allowedPeers == nil {
+		return nil
+	}
+	return &proto.SSHConfig{AllowedPeers: allowedPeers}
+}
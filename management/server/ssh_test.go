@@ -0,0 +1,133 @@
+package server
+
+import (
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAccountManager_UpdatePeerSSH(t *testing.T) {
+	manager, err := createManager(t)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	var peers []*Peer
+	for _, name := range []string{"server", "laptop"} {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers = append(peers, peer)
+	}
+	server, laptop := peers[0], peers[1]
+
+	laptops := &Group{ID: "laptops", Name: "laptops", Peers: []string{laptop.Key}}
+	err = manager.SaveGroup(account.Id, "account_creator", laptops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates := manager.peersUpdateManager.CreateChannel(server.Key)
+	defer manager.peersUpdateManager.CloseChannel(server.Key)
+
+	_, err = manager.UpdatePeerSSH(account.Id, server.Key, true, nil, "account_creator")
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expecting enabling SSH server without allowed groups to fail with %s, got %v", codes.InvalidArgument, err)
+	}
+
+	updated, err := manager.UpdatePeerSSH(account.Id, server.Key, true, []string{laptops.ID}, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated.SSHEnabled {
+		t.Error("expecting SSH server of the peer to be enabled")
+	}
+
+	select {
+	case update := <-updates:
+		allowed := update.Update.GetNetworkMap().GetPeerConfig().GetSshConfig().GetAllowedPeers()
+		if len(allowed) != 1 || allowed[0] != laptop.Key {
+			t.Errorf("expecting SSH server to allow peer %s, got %v", laptop.Key, allowed)
+		}
+	default:
+		t.Error("expecting peer to receive an update")
+	}
+
+	group := &Group{ID: "admins", Name: "admins", Peers: []string{server.Key}}
+	err = manager.SaveGroup(account.Id, "account_creator", group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = manager.UpdatePeerSSH(account.Id, server.Key, true, []string{group.ID}, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkMap, err := manager.GetNetworkMap(server.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkMap.SSHAllowedPeers == nil || len(networkMap.SSHAllowedPeers) != 0 {
+		t.Errorf("expecting SSH server to allow no peers outside of group %s, got %v", group.ID, networkMap.SSHAllowedPeers)
+	}
+
+	// a peer enabled before the groups were required allows no one
+	account, err = manager.Store.GetAccount(account.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account.Peers[server.Key].SSHAllowedGroups = nil
+	err = manager.Store.SaveAccount(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkMap, err = manager.GetNetworkMap(server.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkMap.SSHAllowedPeers == nil || len(networkMap.SSHAllowedPeers) != 0 {
+		t.Errorf("expecting SSH server without allowed groups to allow no peers, got %v", networkMap.SSHAllowedPeers)
+	}
+
+	_, err = manager.UpdatePeerSSH(account.Id, server.Key, false, nil, "account_creator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	networkMap, err = manager.GetNetworkMap(server.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if networkMap.SSHAllowedPeers != nil {
+		t.Errorf("expecting no SSH config of a disabled SSH server, got %v", networkMap.SSHAllowedPeers)
+	}
+
+	_, err = manager.UpdatePeerSSH(account.Id, server.Key, true, []string{"unknown"}, "account_creator")
+	if err == nil {
+		t.Error("expecting enabling SSH server for an unknown group to fail")
+	}
+
+	_, err = manager.UpdatePeerSSH(account.Id, "unknown", true, nil, "account_creator")
+	if err == nil {
+		t.Error("expecting updating SSH server of an unknown peer to fail")
+	}
+}
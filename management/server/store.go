@@ -1,5 +1,19 @@
 package server
 
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Store is a storage of the accounts and their peers, setup keys and users.
+// SaveAccount and GetAccount are atomic: a concurrent GetAccount returns either the previous or the saved state of the account.
+// The returned accounts are copies, changing them has no effect until they are saved
 type Store interface {
 	GetPeer(peerKey string) (*Peer, error)
 	DeletePeer(accountId string, peerKey string) (*Peer, error)
@@ -14,4 +28,64 @@ type Store interface {
 	GetAccountBySetupKey(setupKey string) (*Account, error)
 	GetAccountByPrivateDomain(domain string) (*Account, error)
 	SaveAccount(account *Account) error
+	// SaveAccountWithAuditEvents saves the account like SaveAccount appending the audit events of the change in the same write,
+	// so that either both or none are persisted. The events get sequential IDs and outlive the account
+	SaveAccountWithAuditEvents(account *Account, events []*activity.Event) error
+	// GetAuditEvents returns the audit events of an account selected by the filter sorted by ID
+	// and the number of the selected events regardless of the Offset and the Limit of the filter
+	GetAuditEvents(accountId string, filter AuditFilter) ([]*activity.Event, int, error)
+	// DeleteAuditEventsBefore removes the audit events of all the accounts older than the given time
+	// and returns the number of the removed events
+	DeleteAuditEventsBefore(before time.Time) (int, error)
+	// AcquireAccountLock acquires the write lock of an account and returns a function releasing it.
+	// Reading an account, changing and saving it has to be done holding the lock, otherwise concurrent changes of the account get lost
+	AcquireAccountLock(accountId string) (unlock func())
+	// CheckHealth checks that the store is loaded and writable performing a cheap test write
+	CheckHealth() error
+	Close() error
+}
+
+// errPeerOfAnotherAccount is returned by a Store saving a peer to an account while the peer belongs to another account
+func errPeerOfAnotherAccount(peerKey string) error {
+	return status.Errorf(codes.AlreadyExists, "peer %s belongs to another account", peerKey)
+}
+
+// errSetupKeyOfAnotherAccount is returned by a Store saving a setup key to an account while the key belongs to another account
+func errSetupKeyOfAnotherAccount(setupKey string) error {
+	return status.Errorf(codes.AlreadyExists, "setup key %s belongs to another account", setupKey)
+}
+
+// accountLocks holds a mutex per account ID
+type accountLocks struct {
+	locks sync.Map
+}
+
+// acquire locks the mutex of the account and returns a function unlocking it
+func (l *accountLocks) acquire(accountId string) (unlock func()) {
+	value, _ := l.locks.LoadOrStore(accountId, &sync.Mutex{})
+	mux := value.(*sync.Mutex)
+	mux.Lock()
+	return mux.Unlock
+}
+
+// StoreEngine is a backend of the Store
+type StoreEngine string
+
+const (
+	// FileStoreEngine keeps the accounts in a JSON file rewritten on every change
+	FileStoreEngine StoreEngine = "jsonfile"
+	// SqliteStoreEngine keeps the accounts in a SQLite database writing only the changed account on every change
+	SqliteStoreEngine StoreEngine = "sqlite"
+)
+
+// NewStoreFromEngine creates a Store of the given engine located in the datadir. Defaults to the FileStoreEngine
+func NewStoreFromEngine(engine StoreEngine, dataDir string) (Store, error) {
+	switch engine {
+	case FileStoreEngine, "":
+		return NewStore(dataDir)
+	case SqliteStoreEngine:
+		return NewSqliteStore(dataDir)
+	default:
+		return nil, fmt.Errorf("unsupported store engine %s", engine)
+	}
 }
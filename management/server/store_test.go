@@ -0,0 +1,675 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// storeOpener opens a Store located in the dataDir
+type storeOpener func(dataDir string) (Store, error)
+
+func TestFileStore_Conformance(t *testing.T) {
+	runStoreConformanceTests(t, func(dataDir string) (Store, error) {
+		return NewStore(dataDir)
+	})
+}
+
+func TestSqliteStore_Conformance(t *testing.T) {
+	runStoreConformanceTests(t, func(dataDir string) (Store, error) {
+		return NewSqliteStore(dataDir)
+	})
+}
+
+// runStoreConformanceTests checks the behaviour every Store implementation has to follow
+func runStoreConformanceTests(t *testing.T, open storeOpener) {
+	t.Run("SaveAndGetAccount", func(t *testing.T) { testStoreSaveAndGetAccount(t, open) })
+	t.Run("Lookups", func(t *testing.T) { testStoreLookups(t, open) })
+	t.Run("SavePeer", func(t *testing.T) { testStoreSavePeer(t, open) })
+	t.Run("DeletePeer", func(t *testing.T) { testStoreDeletePeer(t, open) })
+	t.Run("PeerRules", func(t *testing.T) { testStorePeerRules(t, open) })
+	t.Run("TaggedPeerRules", func(t *testing.T) { testStoreTaggedPeerRules(t, open) })
+	t.Run("AccountBoundary", func(t *testing.T) { testStoreAccountBoundary(t, open) })
+	t.Run("Persistence", func(t *testing.T) { testStorePersistence(t, open) })
+	t.Run("ConcurrentSaveAndGetAccount", func(t *testing.T) { testStoreConcurrentSaveAndGetAccount(t, open) })
+	t.Run("ConcurrentAccountUpdates", func(t *testing.T) { testStoreConcurrentAccountUpdates(t, open) })
+	t.Run("CheckHealth", func(t *testing.T) { testStoreCheckHealth(t, open) })
+	t.Run("AuditEvents", func(t *testing.T) { testStoreAuditEvents(t, open) })
+}
+
+func openTestStore(t *testing.T, open storeOpener, dataDir string) Store {
+	t.Helper()
+	store, err := open(dataDir)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = store.Close()
+	})
+	return store
+}
+
+// newTestStoreAccount creates an account with a setup key, the peers and a rule allowing the traffic from group1 to group2
+func newTestStoreAccount(userID, domain string, peerKeys ...string) *Account {
+	account := NewAccount(userID, domain)
+	account.Users[userID] = NewAdminUser(userID)
+	account.Domain = domain
+	account.DomainCategory = PrivateCategory
+	account.IsDomainPrimaryAccount = true
+
+	setupKey := GenerateDefaultSetupKey()
+	account.SetupKeys[setupKey.Key] = setupKey
+
+	allGroup := &Group{ID: "all", Name: "All"}
+	account.Groups = map[string]*Group{allGroup.ID: allGroup}
+	account.Rules = map[string]*Rule{}
+	for i, key := range peerKeys {
+		account.Peers[key] = &Peer{
+			Key:      key,
+			SetupKey: setupKey.Key,
+			IP:       net.IP{100, 64, 0, byte(i + 1)},
+			Meta:     PeerSystemMeta{Hostname: key, WtVersion: "0.8.0"},
+			Name:     key,
+			Status:   &PeerStatus{Connected: true, LastSeen: time.Now().UTC().Truncate(time.Second)},
+		}
+		allGroup.Peers = append(allGroup.Peers, key)
+	}
+
+	if len(peerKeys) > 1 {
+		account.Groups["group1"] = &Group{ID: "group1", Name: "group1", Peers: peerKeys[:1]}
+		account.Groups["group2"] = &Group{ID: "group2", Name: "group2", Peers: peerKeys[1:]}
+		account.Rules["rule"] = &Rule{
+			ID:          "rule",
+			Name:        "group1 to group2",
+			Source:      []string{"group1"},
+			Destination: []string{"group2"},
+			Flow:        TrafficFlowBidirect,
+		}
+	}
+
+	return account
+}
+
+func testStoreSaveAndGetAccount(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	_, err := store.GetAccount("missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	account := newTestStoreAccount("user", "example.com", "peer1", "peer2")
+	require.NoError(t, store.SaveAccount(account))
+
+	stored, err := store.GetAccount(account.Id)
+	require.NoError(t, err)
+	assertAccountsEqual(t, account, stored)
+
+	// saving replaces the peers, setup keys and users of the account
+	account = account.Copy()
+	delete(account.Peers, "peer2")
+	account.Users["user2"] = NewRegularUser("user2")
+	account.Network.IncSerial()
+	require.NoError(t, store.SaveAccount(account))
+
+	stored, err = store.GetAccount(account.Id)
+	require.NoError(t, err)
+	assert.Len(t, stored.Peers, 1)
+	assert.Len(t, stored.Users, 2)
+	assert.Equal(t, account.Network.CurrentSerial(), stored.Network.CurrentSerial())
+
+	other := newTestStoreAccount("other_user", "")
+	require.NoError(t, store.SaveAccount(other))
+
+	var ids []string
+	for _, a := range store.GetAllAccounts() {
+		ids = append(ids, a.Id)
+	}
+	assert.ElementsMatch(t, []string{account.Id, other.Id}, ids)
+}
+
+func testStoreLookups(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "example.com", "peer1", "peer2")
+	require.NoError(t, store.SaveAccount(account))
+	other := newTestStoreAccount("other_user", "other.com", "peer3")
+	other.IsDomainPrimaryAccount = false
+	require.NoError(t, store.SaveAccount(other))
+
+	found, err := store.GetUserAccount("user")
+	require.NoError(t, err)
+	assert.Equal(t, account.Id, found.Id)
+	_, err = store.GetUserAccount("missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	found, err = store.GetPeerAccount("peer3")
+	require.NoError(t, err)
+	assert.Equal(t, other.Id, found.Id)
+	_, err = store.GetPeerAccount("missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	var setupKey string
+	for key := range account.SetupKeys {
+		setupKey = key
+	}
+	found, err = store.GetAccountBySetupKey(strings.ToLower(setupKey))
+	require.NoError(t, err, "setup key lookup should be case insensitive")
+	assert.Equal(t, account.Id, found.Id)
+	_, err = store.GetAccountBySetupKey("missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	found, err = store.GetAccountByPrivateDomain("EXAMPLE.com")
+	require.NoError(t, err)
+	assert.Equal(t, account.Id, found.Id)
+	_, err = store.GetAccountByPrivateDomain("other.com")
+	assertStatusCode(t, codes.NotFound, err)
+
+	peer, err := store.GetPeer("peer1")
+	require.NoError(t, err)
+	assert.Equal(t, account.Peers["peer1"].IP.String(), peer.IP.String())
+	_, err = store.GetPeer("missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	peers, err := store.GetAccountPeers(account.Id)
+	require.NoError(t, err)
+	var keys []string
+	for _, p := range peers {
+		keys = append(keys, p.Key)
+	}
+	assert.ElementsMatch(t, []string{"peer1", "peer2"}, keys)
+	_, err = store.GetAccountPeers("missing")
+	assertStatusCode(t, codes.NotFound, err)
+}
+
+func testStoreSavePeer(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "", "peer1")
+	require.NoError(t, store.SaveAccount(account))
+
+	err := store.SavePeer("missing", &Peer{Key: "peer"})
+	assertStatusCode(t, codes.NotFound, err)
+
+	newPeer := &Peer{Key: "peer2", IP: net.IP{100, 64, 0, 2}, Name: "new", Status: &PeerStatus{}}
+	require.NoError(t, store.SavePeer(account.Id, newPeer))
+
+	updated := account.Peers["peer1"].Copy()
+	updated.Name = "renamed"
+	require.NoError(t, store.SavePeer(account.Id, updated))
+
+	stored, err := store.GetAccount(account.Id)
+	require.NoError(t, err)
+	assert.Len(t, stored.Peers, 2)
+	assert.Equal(t, "renamed", stored.Peers["peer1"].Name)
+	assert.Equal(t, "new", stored.Peers["peer2"].Name)
+
+	allGroup, err := stored.GetGroupAll()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"peer1", "peer2"}, allGroup.Peers, "a new peer should be added to the All group once")
+
+	found, err := store.GetPeerAccount("peer2")
+	require.NoError(t, err)
+	assert.Equal(t, account.Id, found.Id)
+}
+
+func testStoreDeletePeer(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "", "peer1", "peer2")
+	require.NoError(t, store.SaveAccount(account))
+
+	_, err := store.DeletePeer(account.Id, "missing")
+	assertStatusCode(t, codes.NotFound, err)
+
+	deleted, err := store.DeletePeer(account.Id, "peer1")
+	require.NoError(t, err)
+	assert.Equal(t, "peer1", deleted.Key)
+
+	stored, err := store.GetAccount(account.Id)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.Peers, "peer1")
+	for _, group := range stored.Groups {
+		assert.NotContains(t, group.Peers, "peer1", "deleted peer should be removed from group %s", group.Name)
+	}
+	assert.Equal(t, []string{"peer2"}, stored.Groups["group2"].Peers, "other groups peers should stay untouched")
+
+	_, err = store.GetPeer("peer1")
+	assertStatusCode(t, codes.NotFound, err)
+}
+
+func testStoreAccountBoundary(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	// the accounts have overlapping networks and peer IPs
+	accountA := newTestStoreAccount("userA", "", "peerA")
+	accountB := newTestStoreAccount("userB", "", "peerB")
+	require.NoError(t, store.SaveAccount(accountA))
+	require.NoError(t, store.SaveAccount(accountB))
+
+	withPeerOfA := accountB.Copy()
+	withPeerOfA.Peers["peerA"] = accountA.Peers["peerA"].Copy()
+	assertStatusCode(t, codes.AlreadyExists, store.SaveAccount(withPeerOfA))
+	assertStatusCode(t, codes.AlreadyExists, store.SavePeer(accountB.Id, accountA.Peers["peerA"].Copy()))
+
+	withSetupKeyOfA := accountB.Copy()
+	for _, setupKey := range accountA.SetupKeys {
+		copied := setupKey.Copy()
+		copied.Key = strings.ToLower(copied.Key)
+		withSetupKeyOfA.SetupKeys[copied.Key] = copied
+	}
+	assertStatusCode(t, codes.AlreadyExists, store.SaveAccount(withSetupKeyOfA))
+
+	owner, err := store.GetPeerAccount("peerA")
+	require.NoError(t, err)
+	assert.Equal(t, accountA.Id, owner.Id, "a rejected save shouldn't move the peer to another account")
+	stored, err := store.GetAccount(accountB.Id)
+	require.NoError(t, err)
+	assertAccountsEqual(t, accountB, stored)
+
+	// a peer removed from an account can join another one
+	_, err = store.DeletePeer(accountA.Id, "peerA")
+	require.NoError(t, err)
+	require.NoError(t, store.SaveAccount(withPeerOfA))
+	owner, err = store.GetPeerAccount("peerA")
+	require.NoError(t, err)
+	assert.Equal(t, accountB.Id, owner.Id)
+}
+
+func testStorePeerRules(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "", "peer1", "peer2")
+	require.NoError(t, store.SaveAccount(account))
+
+	srcRules, err := store.GetPeerSrcRules(account.Id, "peer1")
+	require.NoError(t, err)
+	require.Len(t, srcRules, 1)
+	assert.Equal(t, "rule", srcRules[0].ID)
+
+	dstRules, err := store.GetPeerDstRules(account.Id, "peer2")
+	require.NoError(t, err)
+	require.Len(t, dstRules, 1)
+	assert.Equal(t, "rule", dstRules[0].ID)
+
+	_, err = store.GetPeerSrcRules(account.Id, "unknown")
+	assert.Error(t, err, "a peer not belonging to the account should return an error")
+
+	_, err = store.GetPeerDstRules("missing", "peer2")
+	assert.Error(t, err)
+}
+
+func testStoreTaggedPeerRules(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "", "peer1", "peer2", "peer3")
+	account.Peers["peer3"].Tags = []string{"role:db"}
+	account.Rules["tagged"] = &Rule{
+		ID:              "tagged",
+		Name:            "group1 to databases",
+		Source:          []string{"group1"},
+		DestinationTags: []string{"role:db"},
+		Flow:            TrafficFlowBidirect,
+	}
+	require.NoError(t, store.SaveAccount(account))
+
+	dstRules, err := store.GetPeerDstRules(account.Id, "peer3")
+	require.NoError(t, err)
+	ruleIDs := []string{}
+	for _, rule := range dstRules {
+		ruleIDs = append(ruleIDs, rule.ID)
+	}
+	assert.ElementsMatch(t, []string{"rule", "tagged"}, ruleIDs, "expecting the tagged peer to be selected by the tag")
+
+	dstRules, err = store.GetPeerDstRules(account.Id, "peer2")
+	require.NoError(t, err)
+	require.Len(t, dstRules, 1)
+	assert.Equal(t, "rule", dstRules[0].ID)
+
+	// the rules selecting the peer by a removed tag don't apply anymore
+	account.Peers["peer3"].Tags = nil
+	require.NoError(t, store.SaveAccount(account))
+	dstRules, err = store.GetPeerDstRules(account.Id, "peer3")
+	require.NoError(t, err)
+	require.Len(t, dstRules, 1)
+	assert.Equal(t, "rule", dstRules[0].ID)
+}
+
+func testStorePersistence(t *testing.T, open storeOpener) {
+	dataDir := t.TempDir()
+	store, err := open(dataDir)
+	require.NoError(t, err)
+
+	account := newTestStoreAccount("user", "example.com", "peer1", "peer2")
+	limitedKey := GenerateSetupKey("limited", SetupKeyReusable, time.Hour, 5)
+	limitedKey.UsedTimes = 3
+	limitedKey.LastUsed = time.Now().UTC().Truncate(time.Second)
+	limitedKey.Revoked = true
+	account.SetupKeys[limitedKey.Key] = limitedKey
+	account.MaxPeers = 10
+	require.NoError(t, store.SaveAccount(account))
+	require.NoError(t, store.SavePeer(account.Id, &Peer{Key: "peer3", IP: net.IP{100, 64, 0, 3}, Status: &PeerStatus{}}))
+	_, err = store.DeletePeer(account.Id, "peer1")
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	expected := account.Copy()
+	expected.Domain = account.Domain
+	expected.DomainCategory = account.DomainCategory
+	expected.IsDomainPrimaryAccount = account.IsDomainPrimaryAccount
+	delete(expected.Peers, "peer1")
+	expected.Peers["peer3"] = &Peer{Key: "peer3", IP: net.IP{100, 64, 0, 3}, Status: &PeerStatus{}, Meta: PeerSystemMeta{WtVersion: UnknownVersion}}
+	expected.Groups["all"].Peers = []string{"peer2", "peer3"}
+	expected.Groups["group1"].Peers = nil
+
+	restored := openTestStore(t, open, dataDir)
+	stored, err := restored.GetAccount(account.Id)
+	require.NoError(t, err)
+	assertAccountsEqual(t, expected, stored)
+
+	found, err := restored.GetPeerAccount("peer3")
+	require.NoError(t, err)
+	assert.Equal(t, account.Id, found.Id)
+}
+
+func testStoreConcurrentSaveAndGetAccount(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	account := newTestStoreAccount("user", "")
+	require.NoError(t, store.SaveAccount(account))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// every saved state of the account has as many peers as users
+		for i := 0; i < 20; i++ {
+			state := account.Copy()
+			for j := 0; j <= i; j++ {
+				key := fmt.Sprintf("peer%d", j)
+				state.Peers[key] = &Peer{Key: key, IP: net.IP{100, 64, 0, byte(j + 1)}, Status: &PeerStatus{}}
+				state.Users[fmt.Sprintf("user%d", j)] = NewRegularUser(fmt.Sprintf("user%d", j))
+			}
+			assert.NoError(t, store.SaveAccount(state))
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				stored, err := store.GetAccount(account.Id)
+				if !assert.NoError(t, err) {
+					return
+				}
+				// the initial state has a single admin user and no peers
+				assert.Equal(t, len(stored.Users)-1, len(stored.Peers), "got a partially saved account")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func testStoreConcurrentAccountUpdates(t *testing.T, open storeOpener) {
+	store := openTestStore(t, open, t.TempDir())
+
+	accounts := []*Account{newTestStoreAccount("user1", ""), newTestStoreAccount("user2", "")}
+	for _, account := range accounts {
+		require.NoError(t, store.SaveAccount(account))
+	}
+
+	const workers = 10
+	const updatesPerWorker = 20
+	var wg sync.WaitGroup
+	for _, account := range accounts {
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(accountId string, worker int) {
+				defer wg.Done()
+				for i := 0; i < updatesPerWorker; i++ {
+					unlock := store.AcquireAccountLock(accountId)
+					stored, err := store.GetAccount(accountId)
+					if !assert.NoError(t, err) {
+						unlock()
+						return
+					}
+					userID := fmt.Sprintf("worker%d-user%d", worker, i)
+					stored.Users[userID] = NewRegularUser(userID)
+					stored.Network.IncSerial()
+					assert.NoError(t, store.SaveAccount(stored))
+					unlock()
+				}
+			}(account.Id, w)
+		}
+	}
+	wg.Wait()
+
+	for _, account := range accounts {
+		stored, err := store.GetAccount(account.Id)
+		require.NoError(t, err)
+		// every update added a user to the initial admin user and incremented the serial
+		assert.Len(t, stored.Users, workers*updatesPerWorker+1, "lost account updates")
+		assert.Equal(t, uint64(workers*updatesPerWorker), stored.Network.CurrentSerial(), "lost account updates")
+	}
+
+	// the returned accounts are copies not affecting the stored state until saved
+	stored, err := store.GetAccount(accounts[0].Id)
+	require.NoError(t, err)
+	stored.Users["unsaved"] = NewRegularUser("unsaved")
+	stored, err = store.GetAccount(accounts[0].Id)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.Users, "unsaved")
+}
+
+func testStoreCheckHealth(t *testing.T, open storeOpener) {
+	dataDir := t.TempDir()
+	store := openTestStore(t, open, dataDir)
+
+	require.NoError(t, store.SaveAccount(newTestStoreAccount("user", "example.com", "peer1")))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, store.CheckHealth())
+	}
+
+	// the test writes leave nothing behind
+	files, err := os.ReadDir(dataDir)
+	require.NoError(t, err)
+	for _, file := range files {
+		assert.False(t, strings.HasPrefix(file.Name(), ".health"), "unexpected file %s left in the datadir", file.Name())
+	}
+
+	// and don't change the stored accounts
+	stored, err := store.GetUserAccount("user")
+	require.NoError(t, err)
+	assert.Len(t, stored.Peers, 1)
+}
+
+func testStoreAuditEvents(t *testing.T, open storeOpener) {
+	dataDir := t.TempDir()
+	store, err := open(dataDir)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	account := newTestStoreAccount("user", "example.com", "peer1")
+	other := newTestStoreAccount("other_user", "")
+	newEvent := func(accountID, initiatorID string, activityID activity.Activity, age time.Duration) *activity.Event {
+		event := newAuditEvent(initiatorID, "target", accountID, activityID, map[string]string{"name": "target"},
+			map[string]string{"Name": "before"}, map[string]string{"Name": "after"})
+		event.Timestamp = now.Add(-age)
+		return event
+	}
+
+	require.NoError(t, store.SaveAccountWithAuditEvents(account, []*activity.Event{
+		newEvent(account.Id, "user", activity.SetupKeyCreated, 3*time.Hour),
+		newEvent(account.Id, "key", activity.PeerAddedWithSetupKey, 2*time.Hour),
+	}))
+	require.NoError(t, store.SaveAccountWithAuditEvents(other, []*activity.Event{
+		newEvent(other.Id, "other_user", activity.GroupCreated, time.Hour),
+	}))
+	require.NoError(t, store.SaveAccountWithAuditEvents(account, []*activity.Event{
+		newEvent(account.Id, "user", activity.RuleUpdated, 0),
+	}))
+	require.NoError(t, store.Close())
+
+	// the events are persisted with the accounts
+	store = openTestStore(t, open, dataDir)
+
+	eventIDs := func(events []*activity.Event) []uint64 {
+		ids := []uint64{}
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+
+	events, total, err := store.GetAuditEvents(account.Id, AuditFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []uint64{1, 2, 4}, eventIDs(events))
+	assert.Equal(t, activity.RuleUpdated, events[2].Activity)
+	assert.Equal(t, "user", events[2].InitiatorID)
+	assert.Equal(t, "target", events[2].Meta["name"])
+	assert.JSONEq(t, `{"Name":"before"}`, string(events[2].Before))
+	assert.JSONEq(t, `{"Name":"after"}`, string(events[2].After))
+	assert.True(t, now.Equal(events[2].Timestamp))
+
+	events, total, err = store.GetAuditEvents(account.Id, AuditFilter{InitiatorID: "user"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, []uint64{1, 4}, eventIDs(events))
+
+	peerAdded := activity.PeerAddedWithSetupKey
+	events, total, err = store.GetAuditEvents(account.Id, AuditFilter{Activity: &peerAdded})
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	assert.Equal(t, []uint64{2}, eventIDs(events))
+
+	events, total, err = store.GetAuditEvents(account.Id, AuditFilter{Offset: 1, Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Equal(t, []uint64{2}, eventIDs(events))
+
+	events, total, err = store.GetAuditEvents(account.Id, AuditFilter{Offset: 5})
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	assert.Empty(t, events)
+
+	removed, err := store.DeleteAuditEventsBefore(now.Add(-90 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	events, _, err = store.GetAuditEvents(account.Id, AuditFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4}, eventIDs(events))
+	events, _, err = store.GetAuditEvents(other.Id, AuditFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3}, eventIDs(events))
+
+	// the IDs of the removed events aren't reused
+	require.NoError(t, store.SaveAccountWithAuditEvents(account, []*activity.Event{
+		newEvent(account.Id, "user", activity.RuleDeleted, 0),
+	}))
+	events, _, err = store.GetAuditEvents(account.Id, AuditFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 5}, eventIDs(events))
+}
+
+func assertAccountsEqual(t *testing.T, expected, actual *Account) {
+	t.Helper()
+	assert.Equal(t, expected.Id, actual.Id)
+	assert.Equal(t, expected.CreatedBy, actual.CreatedBy)
+	assert.Equal(t, expected.Domain, actual.Domain)
+	assert.Equal(t, expected.DomainCategory, actual.DomainCategory)
+	assert.Equal(t, expected.IsDomainPrimaryAccount, actual.IsDomainPrimaryAccount)
+	assert.Equal(t, expected.MaxPeers, actual.MaxPeers)
+	assert.Equal(t, expected.Network.Id, actual.Network.Id)
+	assert.Equal(t, expected.Network.Net.String(), actual.Network.Net.String())
+	assert.Equal(t, expected.Network.CurrentSerial(), actual.Network.CurrentSerial())
+	assert.Equal(t, expected.Users, actual.Users)
+	assert.Equal(t, expected.Rules, actual.Rules)
+
+	require.Len(t, actual.Groups, len(expected.Groups))
+	for id, group := range expected.Groups {
+		require.Contains(t, actual.Groups, id)
+		assert.Equal(t, group.Name, actual.Groups[id].Name)
+		assert.ElementsMatch(t, group.Peers, actual.Groups[id].Peers)
+	}
+
+	require.Len(t, actual.SetupKeys, len(expected.SetupKeys))
+	for key, setupKey := range expected.SetupKeys {
+		require.Contains(t, actual.SetupKeys, key)
+		assert.Equal(t, setupKey.Id, actual.SetupKeys[key].Id)
+		assert.Equal(t, setupKey.Name, actual.SetupKeys[key].Name)
+		assert.True(t, setupKey.ExpiresAt.Equal(actual.SetupKeys[key].ExpiresAt))
+		assert.True(t, setupKey.LastUsed.Equal(actual.SetupKeys[key].LastUsed))
+		assert.Equal(t, setupKey.Revoked, actual.SetupKeys[key].Revoked)
+		assert.Equal(t, setupKey.UsedTimes, actual.SetupKeys[key].UsedTimes)
+		assert.Equal(t, setupKey.UsageLimit, actual.SetupKeys[key].UsageLimit)
+	}
+
+	require.Len(t, actual.Peers, len(expected.Peers))
+	for key, peer := range expected.Peers {
+		require.Contains(t, actual.Peers, key)
+		assert.Equal(t, peer.Key, actual.Peers[key].Key)
+		assert.Equal(t, peer.Name, actual.Peers[key].Name)
+		assert.Equal(t, peer.IP.String(), actual.Peers[key].IP.String())
+		assert.Equal(t, peer.Meta, actual.Peers[key].Meta)
+		assert.Equal(t, peer.Status.Connected, actual.Peers[key].Status.Connected)
+		assert.True(t, peer.Status.LastSeen.Equal(actual.Peers[key].Status.LastSeen))
+	}
+}
+
+func assertStatusCode(t *testing.T, code codes.Code, err error) {
+	t.Helper()
+	require.Error(t, err)
+	s, ok := status.FromError(err)
+	require.True(t, ok, "expected a status error, got %v", err)
+	assert.Equal(t, code, s.Code())
+}
+
+func TestMigrateFileStoreToSqlite(t *testing.T) {
+	dataDir := t.TempDir()
+
+	fileStore, err := NewStore(dataDir)
+	require.NoError(t, err)
+	account := newTestStoreAccount("user", "example.com", "peer1", "peer2")
+	require.NoError(t, fileStore.SaveAccount(account))
+	other := newTestStoreAccount("other_user", "")
+	require.NoError(t, fileStore.SaveAccountWithAuditEvents(other, []*activity.Event{
+		newAuditEvent("other_user", other.Id, other.Id, activity.AccountSettingsUpdated, nil, nil, nil),
+	}))
+
+	require.NoError(t, MigrateFileStoreToSqlite(dataDir))
+
+	sqliteStore, err := NewSqliteStore(dataDir)
+	require.NoError(t, err)
+	defer sqliteStore.Close()
+
+	assert.Len(t, sqliteStore.GetAllAccounts(), 2)
+	stored, err := sqliteStore.GetAccount(account.Id)
+	require.NoError(t, err)
+	assertAccountsEqual(t, account, stored)
+
+	events, _, err := sqliteStore.GetAuditEvents(other.Id, AuditFilter{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, uint64(1), events[0].ID)
+	assert.Equal(t, activity.AccountSettingsUpdated, events[0].Activity)
+
+	info, err := os.Stat(filepath.Join(dataDir, storeSqliteFileName))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// the migration runs once
+	assert.Error(t, MigrateFileStoreToSqlite(dataDir))
+	// and requires an existing file store
+	assert.Error(t, MigrateFileStoreToSqlite(t.TempDir()))
+}
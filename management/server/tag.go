@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/netbirdio/netbird/management/server/activity"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// tagDNSLabel is the label of the zone the names of the tags are resolved under, e.g. db.role.tag.netbird.cloud
+const tagDNSLabel = "tag"
+
+// tagRegexp matches a tag of the form value or key:value, each part being a lower case DNS label (RFC 1123)
+var tagRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(:[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)?$`)
+
+// normalizeTags lower cases the tags, drops the duplicates and sorts them.
+// Returns nil for no tags and an InvalidArgument error if a tag isn't of the form value or key:value made of letters,
+// digits and hyphens
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRegexp.MatchString(tag) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"invalid tag %q, use a value or key:value of letters, digits and hyphens", tag)
+		}
+		if !contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// HasAnyTag checks whether the peer has any of the provided tags
+func (p *Peer) HasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		if contains(p.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// rulePeers returns the keys of the peers selected by a side of a rule: the members of the groups and the peers
+// having any of the tags. Missing groups are skipped, the keys of the group members are returned as they are
+func rulePeers(account *Account, groupIDs []string, tags []string) []string {
+	var peers []string
+	for _, gid := range groupIDs {
+		group, ok := account.Groups[gid]
+		if !ok {
+			continue
+		}
+		for _, pid := range group.Peers {
+			if !contains(peers, pid) {
+				peers = append(peers, pid)
+			}
+		}
+	}
+
+	if len(tags) == 0 {
+		return peers
+	}
+	var tagged []string
+	for key, peer := range account.Peers {
+		if peer.HasAnyTag(tags) && !contains(peers, key) {
+			tagged = append(tagged, key)
+		}
+	}
+	sort.Strings(tagged)
+	return append(peers, tagged...)
+}
+
+// UpdatePeerTags replaces the tags of a given peer, an empty list removes them. The tags select the peer in the rules
+// and name the peers having them in DNS, so all the peers of the account get an updated network map
+func (am *DefaultAccountManager) UpdatePeerTags(accountId string, peerKey string, tags []string, userID string) (*Peer, error) {
+	tags, err := normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := am.Store.AcquireAccountLock(accountId)
+	defer unlock()
+
+	account, err := am.Store.GetAccount(accountId)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "account not found")
+	}
+
+	peer, ok := account.Peers[peerKey]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "peer %s not found", peerKey)
+	}
+
+	peerCopy := peer.Copy()
+	peerCopy.Tags = tags
+	account.Peers[peerKey] = peerCopy
+
+	account.Network.IncSerial()
+	err = am.saveAccount(account, newAuditEvent(userID, peerKey, accountId, activity.PeerUpdated,
+		map[string]string{"name": peerCopy.Name}, peer, peerCopy))
+	if err != nil {
+		return nil, err
+	}
+
+	err = am.updateAccountPeers(account)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerCopy, nil
+}
+
+// tagDNSName returns the name of a tag relative to the zone, the parts of the tag reversed under the tag label,
+// e.g. db.role.tag for role:db and web.tag for web
+func tagDNSName(tag string) string {
+	parts := strings.Split(tag, ":")
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return strings.Join(append(parts, tagDNSLabel), ".")
+}
+
+// getTagDNSRecords returns the records resolving the names of the tags to the IPs of the peers having them.
+// Only a given peer and the peers it can reach are resolved. The result is sorted by name and then by IP
+func getTagDNSRecords(account *Account, peerKey string, reachable []*Peer) []DNSRecord {
+	peers := reachable
+	if peer, ok := account.Peers[peerKey]; ok {
+		peers = append([]*Peer{peer}, reachable...)
+	}
+
+	var records []DNSRecord
+	for _, peer := range peers {
+		for _, tag := range peer.Tags {
+			record := DNSRecord{Name: tagDNSName(tag), Type: DNSRecordTypeA, Value: peer.IP.String()}
+			if peer.IP.To4() == nil {
+				record.Type = DNSRecordTypeAAAA
+			}
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		return bytes.Compare(net.ParseIP(records[i].Value), net.ParseIP(records[j].Value)) < 0
+	})
+	return records
+}
+
+// withTagDNSRecords returns a copy of the DNS settings of the account with the records of the tags added to the custom
+// records. The settings are created if the account has none, keeping the zone configured on the peers
+func withTagDNSRecords(settings *DNSSettings, records []DNSRecord) *DNSSettings {
+	if len(records) == 0 {
+		return settings.Copy()
+	}
+	result := settings.Copy()
+	if result == nil {
+		result = &DNSSettings{}
+	}
+	result.CustomRecords = append(result.CustomRecords, records...)
+	return result
+}
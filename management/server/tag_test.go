@@ -0,0 +1,143 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{"role:db", " Env:Prod ", "role:db", "web"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"env:prod", "role:db", "web"}, tags)
+
+	tags, err = normalizeTags(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tags)
+
+	for _, tag := range []string{"", "role:", ":db", "role:db:primary", "role_db", "-db", "db-"} {
+		_, err = normalizeTags([]string{tag})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), "expecting tag %q to be rejected", tag)
+	}
+}
+
+func TestTagDNSName(t *testing.T) {
+	assert.Equal(t, "db.role.tag", tagDNSName("role:db"))
+	assert.Equal(t, "web.tag", tagDNSName("web"))
+}
+
+func TestAccountManager_TaggedRules(t *testing.T) {
+	manager, err := createManager(t)
+	require.NoError(t, err)
+
+	account, err := manager.AddAccount("test_account", "account_creator", "")
+	require.NoError(t, err)
+
+	var setupKey *SetupKey
+	for _, key := range account.SetupKeys {
+		if key.Type == SetupKeyReusable {
+			setupKey = key
+		}
+	}
+
+	peers := map[string]*Peer{}
+	for _, name := range []string{"db", "app", "laptop"} {
+		peerKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		peer, err := manager.AddPeer(setupKey.Key, "", &Peer{Key: peerKey.PublicKey().String(), Name: name})
+		require.NoError(t, err)
+		peers[name] = peer
+	}
+
+	// only the tagged peers are allowed to connect
+	for _, rule := range account.Rules {
+		require.NoError(t, manager.DeleteRule(account.Id, "account_creator", rule.ID))
+	}
+	err = manager.SaveRule(account.Id, "account_creator", &Rule{
+		ID:              "app-to-db",
+		Name:            "app to db",
+		SourceTags:      []string{"role:app"},
+		DestinationTags: []string{"Role:DB"},
+		Flow:            TrafficFlowBidirect,
+	})
+	require.NoError(t, err)
+
+	_, err = manager.UpdatePeerTags(account.Id, peers["db"].Key, []string{"role:db", "env:prod"}, "account_creator")
+	require.NoError(t, err)
+
+	updates := manager.peersUpdateManager.CreateChannel(peers["app"].Key)
+	defer manager.peersUpdateManager.CloseChannel(peers["app"].Key)
+
+	updated, err := manager.UpdatePeerTags(account.Id, peers["app"].Key, []string{"role:app"}, "account_creator")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"role:app"}, updated.Tags)
+
+	select {
+	case update := <-updates:
+		remotePeers := update.Update.GetNetworkMap().GetRemotePeers()
+		require.Len(t, remotePeers, 1, "expecting the tagged peer to receive an updated network map")
+		assert.Equal(t, peers["db"].Key, remotePeers[0].GetWgPubKey())
+	default:
+		t.Error("expecting the tagged peer to receive an update")
+	}
+
+	networkMap, err := manager.GetNetworkMap(peers["db"].Key)
+	require.NoError(t, err)
+	require.Len(t, networkMap.Peers, 1)
+	assert.Equal(t, peers["app"].Key, networkMap.Peers[0].Key)
+	assert.Equal(t, []DNSRecord{
+		{Name: "app.role.tag", Type: DNSRecordTypeA, Value: peers["app"].IP.String()},
+		{Name: "db.role.tag", Type: DNSRecordTypeA, Value: peers["db"].IP.String()},
+		{Name: "prod.env.tag", Type: DNSRecordTypeA, Value: peers["db"].IP.String()},
+	}, networkMap.DNSSettings.CustomRecords)
+
+	networkMap, err = manager.GetNetworkMap(peers["laptop"].Key)
+	require.NoError(t, err)
+	assert.Empty(t, networkMap.Peers, "expecting the untagged peer not to reach any peer")
+	assert.Nil(t, networkMap.DNSSettings, "expecting the untagged peer not to resolve the tags of unreachable peers")
+
+	reachable, err := manager.GetPeerReachability(peers["app"].Key)
+	require.NoError(t, err)
+	require.Len(t, reachable, 1)
+	assert.Equal(t, peers["db"].Key, reachable[0].Peer.Key)
+	require.Len(t, reachable[0].Rules, 1)
+	assert.Equal(t, "app-to-db", reachable[0].Rules[0].ID)
+
+	// deleting a peer updates the others with the records of their own tags as well
+	_, err = manager.DeletePeer(account.Id, peers["laptop"].Key, "account_creator")
+	require.NoError(t, err)
+	select {
+	case update := <-updates:
+		records := map[string]string{}
+		for _, record := range update.Update.GetNetworkMap().GetDnsConfig().GetCustomRecords() {
+			records[record.GetName()] = record.GetRData()
+		}
+		assert.Equal(t, map[string]string{
+			"app.role.tag": peers["app"].IP.String(),
+			"db.role.tag":  peers["db"].IP.String(),
+			"prod.env.tag": peers["db"].IP.String(),
+		}, records)
+	default:
+		t.Error("expecting the remaining peers to receive an update after a peer has been deleted")
+	}
+
+	// removing the tag removes the peer from the network maps of the others
+	_, err = manager.UpdatePeerTags(account.Id, peers["db"].Key, nil, "account_creator")
+	require.NoError(t, err)
+	networkMap, err = manager.GetNetworkMap(peers["app"].Key)
+	require.NoError(t, err)
+	assert.Empty(t, networkMap.Peers)
+
+	_, err = manager.UpdatePeerTags(account.Id, peers["db"].Key, []string{"role db"}, "account_creator")
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = manager.SaveRule(account.Id, "account_creator", &Rule{ID: "invalid", SourceTags: []string{"role:"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = manager.UpdatePeerTags(account.Id, "unknown", []string{"role:db"}, "account_creator")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
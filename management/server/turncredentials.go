@@ -6,7 +6,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"github.com/netbirdio/netbird/management/proto"
-	log "github.com/sirupsen/logrus"
 	"sync"
 	"time"
 )
@@ -29,6 +28,8 @@ type TimeBasedAuthSecretsManager struct {
 type TURNCredentials struct {
 	Username string
 	Password string
+	// ExpiresAt is the expiration time of the credentials, zero if they don't expire
+	ExpiresAt time.Time
 }
 
 func NewTimeBasedAuthSecretsManager(updateManager *PeersUpdateManager, config *TURNConfig) *TimeBasedAuthSecretsManager {
@@ -44,9 +45,9 @@ func NewTimeBasedAuthSecretsManager(updateManager *PeersUpdateManager, config *T
 func (m *TimeBasedAuthSecretsManager) GenerateCredentials() TURNCredentials {
 	mac := hmac.New(sha1.New, []byte(m.config.Secret))
 
-	timeAuth := time.Now().Add(m.config.CredentialsTTL.Duration).Unix()
+	expiresAt := time.Now().Add(m.config.CredentialsTTL.Duration)
 
-	username := fmt.Sprint(timeAuth)
+	username := fmt.Sprint(expiresAt.Unix())
 
 	_, err := mac.Write([]byte(username))
 	if err != nil {
@@ -57,8 +58,9 @@ func (m *TimeBasedAuthSecretsManager) GenerateCredentials() TURNCredentials {
 	password := base64.StdEncoding.EncodeToString(bytePassword)
 
 	return TURNCredentials{
-		Username: username,
-		Password: password,
+		Username:  username,
+		Password:  password,
+		ExpiresAt: time.Unix(expiresAt.Unix(), 0),
 	}
 
 }
@@ -77,8 +79,20 @@ func (m *TimeBasedAuthSecretsManager) CancelRefresh(peerKey string) {
 	m.cancel(peerKey)
 }
 
+// refreshInterval returns how long after being issued the credentials have to be refreshed
+func (m *TimeBasedAuthSecretsManager) refreshInterval() time.Duration {
+	ttl := m.config.CredentialsTTL.Duration
+	margin := m.config.CredentialsRefreshMargin.Duration
+	if margin <= 0 || margin >= ttl {
+		//we don't want to regenerate credentials right on expiration, so we do it slightly before (at 3/4 of TTL)
+		margin = ttl / 4
+	}
+	return ttl - margin
+}
+
 //SetupRefresh starts peer credentials refresh. Since credentials are expiring (TTL) it is necessary to always generate them and send to the peer.
 //A goroutine is created and put into TimeBasedAuthSecretsManager.cancelMap. This routine should be cancelled if peer is gone.
+//The refresh is sent as a SyncResponse without the NetworkMap, so the peer doesn't have to reapply the network map
 func (m *TimeBasedAuthSecretsManager) SetupRefresh(peerKey string) {
 	m.mux.Lock()
 	defer m.mux.Unlock()
@@ -86,38 +100,31 @@ func (m *TimeBasedAuthSecretsManager) SetupRefresh(peerKey string) {
 	cancel := make(chan struct{}, 1)
 	m.cancelMap[peerKey] = cancel
 	go func() {
+		ticker := time.NewTicker(m.refreshInterval())
+		defer ticker.Stop()
 		for {
 			select {
 			case <-cancel:
+				log.Debugf("stopping TURN credentials refresh for peer %s", peerKey)
 				return
-			default:
-				//we don't want to regenerate credentials right on expiration, so we do it slightly before (at 3/4 of TTL)
-				time.Sleep(m.config.CredentialsTTL.Duration / 4 * 3)
-
-				c := m.GenerateCredentials()
-				var turns []*proto.ProtectedHostConfig
-				for _, host := range m.config.Turns {
-					turns = append(turns, &proto.ProtectedHostConfig{
-						HostConfig: &proto.HostConfig{
-							Uri:      host.URI,
-							Protocol: ToResponseProto(host.Proto),
-						},
-						User:     c.Username,
-						Password: c.Password,
-					})
-				}
-
-				update := &proto.SyncResponse{
-					WiretrusteeConfig: &proto.WiretrusteeConfig{
-						Turns: turns,
-					},
-				}
-				err := m.updateManager.SendUpdate(peerKey, &UpdateMessage{Update: update})
-				if err != nil {
-					log.Errorf("error while sending TURN update to peer %s %v", peerKey, err)
-					// todo maybe continue trying?
-				}
+			case <-ticker.C:
+				m.pushNewCredentials(peerKey)
 			}
 		}
 	}()
 }
+
+// pushNewCredentials generates new TURN credentials and sends them to the peer
+func (m *TimeBasedAuthSecretsManager) pushNewCredentials(peerKey string) {
+	c := m.GenerateCredentials()
+	update := &proto.SyncResponse{
+		WiretrusteeConfig: &proto.WiretrusteeConfig{
+			Turns: toTURNsProto(m.config, &c),
+		},
+	}
+	err := m.updateManager.SendUpdate(peerKey, &UpdateMessage{Update: update})
+	if err != nil {
+		log.Errorf("error while sending TURN update to peer %s %v", peerKey, err)
+		// todo maybe continue trying?
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
+	"fmt"
 	"github.com/netbirdio/netbird/util"
 	"testing"
 	"time"
@@ -38,6 +39,12 @@ func TestTimeBasedAuthSecretsManager_GenerateCredentials(t *testing.T) {
 
 	validateMAC(credentials.Username, credentials.Password, []byte(secret), t)
 
+	if credentials.Username != fmt.Sprint(credentials.ExpiresAt.Unix()) {
+		t.Errorf("expecting TURN username %s to be the expiration time %s", credentials.Username, credentials.ExpiresAt)
+	}
+	if until := time.Until(credentials.ExpiresAt); until <= ttl.Duration-2*time.Second || until > ttl.Duration {
+		t.Errorf("expecting credentials to expire in %s, got %s", ttl.Duration, until)
+	}
 }
 
 func TestTimeBasedAuthSecretsManager_SetupRefresh(t *testing.T) {
@@ -89,6 +96,65 @@ loop:
 
 }
 
+func TestTimeBasedAuthSecretsManager_RefreshBeforeExpiry(t *testing.T) {
+	ttl := util.Duration{Duration: 2 * time.Second}
+	peersManager := NewPeersUpdateManager()
+	peer := "some_peer"
+	updateChannel := peersManager.CreateChannel(peer)
+
+	tested := NewTimeBasedAuthSecretsManager(peersManager, &TURNConfig{
+		CredentialsTTL:           ttl,
+		CredentialsRefreshMargin: util.Duration{Duration: 1500 * time.Millisecond},
+		Secret:                   "some_secret",
+		Turns:                    []*Host{TurnTestHost},
+	})
+
+	issuedAt := time.Now()
+	tested.SetupRefresh(peer)
+	defer tested.CancelRefresh(peer)
+
+	select {
+	case update := <-updateChannel:
+		if elapsed := time.Since(issuedAt); elapsed >= ttl.Duration {
+			t.Errorf("expecting credentials refresh before expiry %s, got it after %s", ttl.Duration, elapsed)
+		}
+		if update.Update.GetNetworkMap() != nil || len(update.Update.GetRemotePeers()) != 0 {
+			t.Errorf("expecting credentials refresh not to carry the network map")
+		}
+		if len(update.Update.GetWiretrusteeConfig().GetTurns()) != 1 {
+			t.Errorf("expecting credentials refresh to carry TURN credentials")
+		} else if update.Update.GetWiretrusteeConfig().GetTurns()[0].GetExpiresAt() == nil {
+			t.Errorf("expecting credentials refresh to carry the credentials expiration time")
+		}
+	case <-time.After(ttl.Duration):
+		t.Errorf("expecting credentials refresh before expiry %s, got none", ttl.Duration)
+	}
+}
+
+func TestTimeBasedAuthSecretsManager_RefreshInterval(t *testing.T) {
+	tt := []struct {
+		name     string
+		margin   time.Duration
+		expected time.Duration
+	}{
+		{name: "Default Margin", expected: 45 * time.Minute},
+		{name: "Configured Margin", margin: 10 * time.Minute, expected: 50 * time.Minute},
+		{name: "Margin Exceeding TTL", margin: 2 * time.Hour, expected: 45 * time.Minute},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			tested := NewTimeBasedAuthSecretsManager(NewPeersUpdateManager(), &TURNConfig{
+				CredentialsTTL:           util.Duration{Duration: time.Hour},
+				CredentialsRefreshMargin: util.Duration{Duration: tc.margin},
+			})
+			if got := tested.refreshInterval(); got != tc.expected {
+				t.Errorf("expecting refresh interval %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
 func TestTimeBasedAuthSecretsManager_CancelRefresh(t *testing.T) {
 	ttl := util.Duration{Duration: time.Hour}
 	secret := "some_secret"
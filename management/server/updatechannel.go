@@ -1,9 +1,11 @@
 package server
 
 import (
-	"github.com/netbirdio/netbird/management/proto"
-	log "github.com/sirupsen/logrus"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/netbirdio/netbird/management/proto"
 )
 
 type UpdateMessage struct {
@@ -12,6 +14,10 @@ type UpdateMessage struct {
 type PeersUpdateManager struct {
 	peerChannels map[string]chan *UpdateMessage
 	channelsMux  *sync.Mutex
+	// dispatchStartedAt is the time the update currently being sent to a peer channel was dispatched, zero if no update is being sent.
+	// A send blocks on a full channel holding channelsMux, so a send lasting long stops delivering updates to all the peers
+	dispatchStartedAt time.Time
+	dispatchMux       sync.Mutex
 }
 
 // NewPeersUpdateManager returns a new instance of PeersUpdateManager
@@ -27,6 +33,9 @@ func (p *PeersUpdateManager) SendUpdate(peer string, update *UpdateMessage) erro
 	p.channelsMux.Lock()
 	defer p.channelsMux.Unlock()
 	if channel, ok := p.peerChannels[peer]; ok {
+		p.setDispatchStartedAt(time.Now())
+		defer p.setDispatchStartedAt(time.Time{})
+
 		channel <- update
 		return nil
 	}
@@ -34,6 +43,22 @@ func (p *PeersUpdateManager) SendUpdate(peer string, update *UpdateMessage) erro
 	return nil
 }
 
+func (p *PeersUpdateManager) setDispatchStartedAt(t time.Time) {
+	p.dispatchMux.Lock()
+	defer p.dispatchMux.Unlock()
+	p.dispatchStartedAt = t
+}
+
+// DispatchBlockedFor returns how long the update currently being sent to a peer channel has been blocked, 0 if no update is being sent
+func (p *PeersUpdateManager) DispatchBlockedFor() time.Duration {
+	p.dispatchMux.Lock()
+	defer p.dispatchMux.Unlock()
+	if p.dispatchStartedAt.IsZero() {
+		return 0
+	}
+	return time.Since(p.dispatchStartedAt)
+}
+
 // CreateChannel creates a go channel for a given peer used to deliver updates relevant to the peer.
 func (p *PeersUpdateManager) CreateChannel(peerKey string) chan *UpdateMessage {
 	p.channelsMux.Lock()
@@ -62,3 +87,16 @@ func (p *PeersUpdateManager) CloseChannel(peerKey string) {
 
 	log.Debugf("closed updates channel of a peer %s", peerKey)
 }
+
+// ConnectedPeers returns the sorted keys of the peers having an updates channel, i.e. connected over a Sync stream
+func (p *PeersUpdateManager) ConnectedPeers() []string {
+	p.channelsMux.Lock()
+	defer p.channelsMux.Unlock()
+
+	peers := make([]string, 0, len(p.peerChannels))
+	for peerKey := range p.peerChannels {
+		peers = append(peers, peerKey)
+	}
+	sort.Strings(peers)
+	return peers
+}
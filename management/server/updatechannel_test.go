@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/netbirdio/netbird/management/proto"
 	"testing"
+	"time"
 )
 
 var peersUpdater *PeersUpdateManager
@@ -47,3 +48,41 @@ func TestCloseChannel(t *testing.T) {
 		t.Error("Error closing the channel")
 	}
 }
+
+func TestDispatchBlockedFor(t *testing.T) {
+	peer := "test-dispatch"
+	updater := NewPeersUpdateManager()
+	channel := updater.CreateChannel(peer)
+	defer updater.CloseChannel(peer)
+
+	if blocked := updater.DispatchBlockedFor(); blocked != 0 {
+		t.Errorf("expected no blocked dispatch, got %s", blocked)
+	}
+
+	// fill the channel so the next update blocks
+	for i := 0; i < cap(channel); i++ {
+		if err := updater.SendUpdate(peer, &UpdateMessage{Update: &proto.SyncResponse{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blocked := updater.DispatchBlockedFor(); blocked != 0 {
+		t.Errorf("expected no blocked dispatch after the sent updates, got %s", blocked)
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		_ = updater.SendUpdate(peer, &UpdateMessage{Update: &proto.SyncResponse{}})
+		close(sent)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if blocked := updater.DispatchBlockedFor(); blocked < 50*time.Millisecond {
+		t.Errorf("expected the dispatch to be blocked, got %s", blocked)
+	}
+
+	<-channel
+	<-sent
+	if blocked := updater.DispatchBlockedFor(); blocked != 0 {
+		t.Errorf("expected no blocked dispatch after the update has been delivered, got %s", blocked)
+	}
+}
@@ -57,6 +57,13 @@ func (am *DefaultAccountManager) GetOrCreateAccountByUser(userId, domain string)
 	lowerDomain := strings.ToLower(domain)
 
 	account, err := am.Store.GetUserAccount(userId)
+	if err == nil {
+		unlock := am.Store.AcquireAccountLock(account.Id)
+		defer unlock()
+
+		// read the account again holding the lock, it could have been changed in the meantime
+		account, err = am.Store.GetAccount(account.Id)
+	}
 	if err != nil {
 		if s, ok := status.FromError(err); ok && s.Code() == codes.NotFound {
 			account = NewAccount(userId, lowerDomain)
@@ -85,9 +92,6 @@ func (am *DefaultAccountManager) GetOrCreateAccountByUser(userId, domain string)
 
 // GetAccountByUser returns an existing account for a given user id, NotFound if account couldn't be found
 func (am *DefaultAccountManager) GetAccountByUser(userId string) (*Account, error) {
-	am.mux.Lock()
-	defer am.mux.Unlock()
-
 	return am.Store.GetUserAccount(userId)
 }
 
@@ -1,11 +1,13 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"github.com/netbirdio/netbird/signal/proto"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"io"
 	"strings"
+	"time"
 )
 
 // A set of tools to exchange connection details (Wireguard endpoints) with the remote peer.
@@ -16,12 +18,18 @@ type Status string
 const StreamConnected Status = "Connected"
 const StreamDisconnected Status = "Disconnected"
 
+// ErrPeerNotConnected is returned by Client.Send when the Signal Exchange has acknowledged that the remote peer
+// isn't connected to it. The servers without the delivery receipts never return it
+var ErrPeerNotConnected = errors.New("remote peer is not connected to the Signal Exchange")
+
 type Client interface {
 	io.Closer
 	StreamConnected() bool
 	GetStatus() Status
+	StatusSince() time.Time
 	Receive(msgHandler func(msg *proto.Message) error) error
 	Ready() bool
+	Reconnect()
 	WaitStreamConnected()
 	SendToStream(msg *proto.EncryptedMessage) error
 	Send(msg *proto.Message) error
@@ -35,8 +43,9 @@ func UnMarshalCredential(msg *proto.Message) (*Credential, error) {
 		return nil, fmt.Errorf("error parsing message body %s", msg.Body)
 	}
 	return &Credential{
-		UFrag: credential[0],
-		Pwd:   credential[1],
+		UFrag:   credential[0],
+		Pwd:     credential[1],
+		IceLite: msg.GetBody().GetIceLite(),
 	}, nil
 }
 
@@ -48,6 +57,7 @@ func MarshalCredential(myKey wgtypes.Key, remoteKey wgtypes.Key, credential *Cre
 		Body: &proto.Body{
 			Type:    t,
 			Payload: fmt.Sprintf("%s:%s", credential.UFrag, credential.Pwd),
+			IceLite: credential.IceLite,
 		},
 	}, nil
 }
@@ -56,4 +66,6 @@ func MarshalCredential(myKey wgtypes.Key, remoteKey wgtypes.Key, credential *Cre
 type Credential struct {
 	UFrag string
 	Pwd   string
+	// IceLite indicates that the sender of the credentials runs an ICE-lite agent
+	IceLite bool
 }
@@ -2,13 +2,27 @@ package client
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/netbirdio/netbird/encryption"
 	sigProto "github.com/netbirdio/netbird/signal/proto"
 	"github.com/netbirdio/netbird/signal/server"
+	"github.com/netbirdio/netbird/testutil"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
@@ -105,6 +119,344 @@ var _ = Describe("GrpcClient", func() {
 
 			})
 		})
+
+		Context("between peers connected over TLS", func() {
+			It("should be successful", func() {
+
+				dir, err := os.MkdirTemp("", "signal-tls")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+
+				certFile, keyFile := generateSelfSignedCert(dir)
+				serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+				Expect(err).NotTo(HaveOccurred())
+
+				var mu sync.Mutex
+				var serverNames []string
+				var protos [][]string
+				tlsServer, tlsListener := startSignalWithTLS(&tls.Config{
+					Certificates: []tls.Certificate{serverCert},
+					GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+						mu.Lock()
+						defer mu.Unlock()
+						serverNames = append(serverNames, hello.ServerName)
+						protos = append(protos, hello.SupportedProtos)
+						return nil, nil
+					},
+				})
+				defer func() {
+					tlsServer.Stop()
+					tlsListener.Close()
+				}()
+				_, port, err := net.SplitHostPort(tlsListener.Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+				tlsAddr := net.JoinHostPort("localhost", port)
+
+				// a client that doesn't trust the self-signed certificate can't connect
+				untrustedKey, _ := wgtypes.GenerateKey()
+				_, err = NewClient(context.Background(), tlsAddr, untrustedKey, true)
+				Expect(err).To(HaveOccurred())
+
+				certPEM, err := os.ReadFile(certFile)
+				Expect(err).NotTo(HaveOccurred())
+				roots := x509.NewCertPool()
+				Expect(roots.AppendCertsFromPEM(certPEM)).To(BeTrue())
+
+				var msgReceived sync.WaitGroup
+				msgReceived.Add(1)
+
+				var receivedOnB string
+
+				keyA, _ := wgtypes.GenerateKey()
+				clientA, err := NewClientWithTLS(context.Background(), tlsAddr, keyA, &tls.Config{RootCAs: roots})
+				Expect(err).NotTo(HaveOccurred())
+				go func() {
+					_ = clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+				}()
+				clientA.WaitStreamConnected()
+
+				keyB, _ := wgtypes.GenerateKey()
+				clientB, err := NewClientWithTLS(context.Background(), tlsAddr, keyB, &tls.Config{RootCAs: roots})
+				Expect(err).NotTo(HaveOccurred())
+				go func() {
+					_ = clientB.Receive(func(msg *sigProto.Message) error {
+						receivedOnB = msg.GetBody().GetPayload()
+						msgReceived.Done()
+						return nil
+					})
+				}()
+				clientB.WaitStreamConnected()
+
+				err = clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Payload: "ping"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				if waitTimeout(&msgReceived, 3*time.Second) {
+					Fail("test timed out on waiting for peers to exchange messages")
+				}
+
+				Expect(receivedOnB).To(BeEquivalentTo("ping"))
+
+				mu.Lock()
+				defer mu.Unlock()
+				Expect(serverNames).NotTo(BeEmpty())
+				for i := range serverNames {
+					Expect(serverNames[i]).To(BeEquivalentTo("localhost"))
+					Expect(protos[i]).To(ContainElement("h2"))
+				}
+			})
+		})
+
+		Context("between peers connected over mutual TLS", func() {
+			It("should be successful for the peers presenting a client certificate only", func() {
+				dir, err := os.MkdirTemp("", "signal-mtls")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+
+				ca, err := testutil.NewTestCA(dir)
+				Expect(err).NotTo(HaveOccurred())
+				serverCertFile, serverKeyFile, err := ca.IssueServerCert()
+				Expect(err).NotTo(HaveOccurred())
+				serverCert, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+				Expect(err).NotTo(HaveOccurred())
+				clientCAs, err := encryption.NewClientCAReloader(ca.CertFile)
+				Expect(err).NotTo(HaveOccurred())
+
+				tlsServer, tlsListener := startSignalWithTLS(clientCAs.RequireClientCertificates(&tls.Config{
+					Certificates: []tls.Certificate{serverCert},
+				}))
+				defer func() {
+					tlsServer.Stop()
+					tlsListener.Close()
+				}()
+				_, port, err := net.SplitHostPort(tlsListener.Addr().String())
+				Expect(err).NotTo(HaveOccurred())
+				tlsAddr := net.JoinHostPort("localhost", port)
+
+				caPEM, err := os.ReadFile(ca.CertFile)
+				Expect(err).NotTo(HaveOccurred())
+				roots := x509.NewCertPool()
+				Expect(roots.AppendCertsFromPEM(caPEM)).To(BeTrue())
+
+				// clientTLSConfig presents a client certificate issued for the peer
+				clientTLSConfig := func(key wgtypes.Key) *tls.Config {
+					certFile, keyFile, err := ca.IssueClientCert(key.PublicKey().String())
+					Expect(err).NotTo(HaveOccurred())
+					clientCert, err := encryption.NewKeyPairReloader(certFile, keyFile)
+					Expect(err).NotTo(HaveOccurred())
+					return &tls.Config{RootCAs: roots, GetClientCertificate: clientCert.GetClientCertificate}
+				}
+
+				// a client that doesn't present a certificate can't connect
+				anonymousKey, _ := wgtypes.GenerateKey()
+				_, err = NewClientWithTLS(context.Background(), tlsAddr, anonymousKey, &tls.Config{RootCAs: roots})
+				Expect(err).To(HaveOccurred())
+
+				var msgReceived sync.WaitGroup
+				msgReceived.Add(1)
+
+				var receivedOnB string
+
+				keyA, _ := wgtypes.GenerateKey()
+				clientA, err := NewClientWithTLS(context.Background(), tlsAddr, keyA, clientTLSConfig(keyA))
+				Expect(err).NotTo(HaveOccurred())
+				go func() {
+					_ = clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+				}()
+				clientA.WaitStreamConnected()
+
+				keyB, _ := wgtypes.GenerateKey()
+				clientB, err := NewClientWithTLS(context.Background(), tlsAddr, keyB, clientTLSConfig(keyB))
+				Expect(err).NotTo(HaveOccurred())
+				go func() {
+					_ = clientB.Receive(func(msg *sigProto.Message) error {
+						receivedOnB = msg.GetBody().GetPayload()
+						msgReceived.Done()
+						return nil
+					})
+				}()
+				clientB.WaitStreamConnected()
+
+				err = clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Payload: "ping"},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				if waitTimeout(&msgReceived, 3*time.Second) {
+					Fail("test timed out on waiting for peers to exchange messages")
+				}
+
+				Expect(receivedOnB).To(BeEquivalentTo("ping"))
+			})
+		})
+
+		Context("between peers connected to different servers of a cluster", func() {
+			It("should be successful", func() {
+
+				backend := newMockBackend()
+				serverA, listenerA := startSignalWithBackend(backend)
+				defer func() {
+					serverA.Stop()
+					listenerA.Close()
+				}()
+				serverB, listenerB := startSignalWithBackend(backend)
+				defer func() {
+					serverB.Stop()
+					listenerB.Close()
+				}()
+
+				var msgReceived sync.WaitGroup
+				msgReceived.Add(1)
+
+				var receivedOnB string
+
+				// connect PeerA to the first server
+				keyA, _ := wgtypes.GenerateKey()
+				clientA := createSignalClient(listenerA.Addr().String(), keyA)
+				go func() {
+					err := clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+					if err != nil {
+						return
+					}
+				}()
+				clientA.WaitStreamConnected()
+
+				// connect PeerB to the second server
+				keyB, _ := wgtypes.GenerateKey()
+				clientB := createSignalClient(listenerB.Addr().String(), keyB)
+				go func() {
+					err := clientB.Receive(func(msg *sigProto.Message) error {
+						receivedOnB = msg.GetBody().GetPayload()
+						msgReceived.Done()
+						return nil
+					})
+					if err != nil {
+						return
+					}
+				}()
+				clientB.WaitStreamConnected()
+
+				err := clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Payload: "ping"},
+				})
+				if err != nil {
+					Fail("failed sending a message to PeerB")
+				}
+
+				if waitTimeout(&msgReceived, 3*time.Second) {
+					Fail("test timed out on waiting for the message to be routed to the other server")
+				}
+
+				Expect(receivedOnB).To(BeEquivalentTo("ping"))
+				Expect(backend.published()).To(BeEquivalentTo(1))
+			})
+		})
+	})
+
+	Describe("Delivery receipts", func() {
+		Context("of a message to a connected peer", func() {
+			It("should acknowledge the delivery", func() {
+
+				keyA, _ := wgtypes.GenerateKey()
+				clientA := createSignalClient(addr, keyA)
+				go func() {
+					_ = clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+				}()
+				clientA.WaitStreamConnected()
+
+				received := make(chan string, 1)
+				keyB, _ := wgtypes.GenerateKey()
+				clientB := createSignalClient(addr, keyB)
+				go func() {
+					_ = clientB.Receive(func(msg *sigProto.Message) error {
+						received <- msg.GetBody().GetPayload()
+						return nil
+					})
+				}()
+				clientB.WaitStreamConnected()
+
+				err := clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Type: sigProto.Body_OFFER, Payload: "offer"},
+				})
+				Expect(err).To(BeNil())
+
+				select {
+				case payload := <-received:
+					Expect(payload).To(BeEquivalentTo("offer"))
+				case <-time.After(3 * time.Second):
+					Fail("test timed out on waiting for the message to be delivered")
+				}
+			})
+		})
+
+		Context("of a message to a peer that isn't connected", func() {
+			It("should return a negative acknowledgement right away", func() {
+
+				keyA, _ := wgtypes.GenerateKey()
+				clientA := createSignalClient(addr, keyA)
+				go func() {
+					_ = clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+				}()
+				clientA.WaitStreamConnected()
+
+				keyB, _ := wgtypes.GenerateKey()
+				err := clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Type: sigProto.Body_OFFER, Payload: "offer"},
+				})
+				Expect(errors.Is(err, ErrPeerNotConnected)).To(BeTrue())
+			})
+		})
+
+		Context("of a message to a peer that isn't connected to any server of a cluster", func() {
+			It("should return a negative acknowledgement right away", func() {
+
+				backend := newMockBackend()
+				clusterServer, clusterListener := startSignalWithBackend(backend)
+				defer func() {
+					clusterServer.Stop()
+					clusterListener.Close()
+				}()
+
+				keyA, _ := wgtypes.GenerateKey()
+				clientA := createSignalClient(clusterListener.Addr().String(), keyA)
+				go func() {
+					_ = clientA.Receive(func(msg *sigProto.Message) error {
+						return nil
+					})
+				}()
+				clientA.WaitStreamConnected()
+
+				keyB, _ := wgtypes.GenerateKey()
+				err := clientA.Send(&sigProto.Message{
+					Key:       keyA.PublicKey().String(),
+					RemoteKey: keyB.PublicKey().String(),
+					Body:      &sigProto.Body{Type: sigProto.Body_OFFER, Payload: "offer"},
+				})
+				Expect(errors.Is(err, ErrPeerNotConnected)).To(BeTrue())
+				Expect(backend.published()).To(BeEquivalentTo(1))
+			})
+		})
 	})
 
 	Describe("Connecting to the Signal stream channel", func() {
@@ -160,6 +512,24 @@ var _ = Describe("GrpcClient", func() {
 
 })
 
+var _ = Describe("Credential", func() {
+	Context("of an ICE-lite agent", func() {
+		It("should keep the ICE-lite flag", func() {
+			keyA, _ := wgtypes.GenerateKey()
+			keyB, _ := wgtypes.GenerateKey()
+			credential := &Credential{UFrag: "ufrag", Pwd: "pwd", IceLite: true}
+
+			msg, err := MarshalCredential(keyA, keyB.PublicKey(), credential, sigProto.Body_OFFER)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(msg.GetBody().GetIceLite()).To(BeTrue())
+
+			parsed, err := UnMarshalCredential(msg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed).To(Equal(credential))
+		})
+	})
+})
+
 func createSignalClient(addr string, key wgtypes.Key) *GrpcClient {
 	var sigTLSEnabled = false
 	client, err := NewClient(context.Background(), addr, key, sigTLSEnabled)
@@ -185,12 +555,29 @@ func createRawSignalClient(addr string) sigProto.SignalExchangeClient {
 	return sigProto.NewSignalExchangeClient(conn)
 }
 
-func startSignal() (*grpc.Server, net.Listener) {
+func startSignal(opts ...server.Option) (*grpc.Server, net.Listener) {
 	lis, err := net.Listen("tcp", ":0")
 	if err != nil {
 		panic(err)
 	}
 	s := grpc.NewServer()
+	sigProto.RegisterSignalExchangeServer(s, server.NewServer(opts...))
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Fatalf("failed to serve: %v", err)
+		}
+	}()
+
+	return s, lis
+}
+
+// startSignalWithTLS starts a server serving gRPC over TLS with the given config
+func startSignalWithTLS(tlsConfig *tls.Config) (*grpc.Server, net.Listener) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
 	sigProto.RegisterSignalExchangeServer(s, server.NewServer())
 	go func() {
 		if err := s.Serve(lis); err != nil {
@@ -201,6 +588,98 @@ func startSignal() (*grpc.Server, net.Listener) {
 	return s, lis
 }
 
+// generateSelfSignedCert writes a self-signed certificate for localhost and its private key to the dir
+func generateSelfSignedCert(dir string) (certFile string, keyFile string) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		panic(err)
+	}
+	key, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		panic(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600)
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600)
+	if err != nil {
+		panic(err)
+	}
+
+	return certFile, keyFile
+}
+
+// startSignalWithBackend starts a server of a cluster routing the messages through the backend
+func startSignalWithBackend(backend server.Backend) (*grpc.Server, net.Listener) {
+	return startSignal(server.WithBackend(backend))
+}
+
+// mockBackend is an in-memory server.Backend shared by the servers of a cluster
+type mockBackend struct {
+	mu        sync.Mutex
+	handlers  map[string]func(msg *sigProto.EncryptedMessage)
+	publishes int
+}
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{handlers: map[string]func(msg *sigProto.EncryptedMessage){}}
+}
+
+func (b *mockBackend) Subscribe(peerKey string, handler func(msg *sigProto.EncryptedMessage)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[peerKey] = handler
+	return nil
+}
+
+func (b *mockBackend) Unsubscribe(peerKey string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.handlers, peerKey)
+	return nil
+}
+
+func (b *mockBackend) Publish(peerKey string, msg *sigProto.EncryptedMessage) (bool, error) {
+	b.mu.Lock()
+	handler, found := b.handlers[peerKey]
+	b.publishes++
+	b.mu.Unlock()
+	if !found {
+		return false, nil
+	}
+	handler(msg)
+	return true, nil
+}
+
+func (b *mockBackend) published() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.publishes
+}
+
+func (b *mockBackend) Close() error {
+	return nil
+}
+
 // waitTimeout waits for the waitgroup for the specified max timeout.
 // Returns true if waiting timed out.
 func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
@@ -7,7 +7,6 @@ import (
 	"github.com/cenkalti/backoff/v4"
 	"github.com/netbirdio/netbird/encryption"
 	"github.com/netbirdio/netbird/signal/proto"
-	log "github.com/sirupsen/logrus"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -34,6 +33,12 @@ type GrpcClient struct {
 	mux         sync.Mutex
 	// StreamConnected indicates whether this client is StreamConnected to the Signal stream
 	status Status
+	// statusSince is the time the status has changed last
+	statusSince time.Time
+	// streamCancel cancels the context of the current Signal stream
+	streamCancel context.CancelFunc
+	// streamSendMux serializes the messages sent to the stream, gRPC streams don't support concurrent sends
+	streamSendMux sync.Mutex
 }
 
 func (c *GrpcClient) StreamConnected() bool {
@@ -44,6 +49,14 @@ func (c *GrpcClient) GetStatus() Status {
 	return c.status
 }
 
+// StatusSince returns the time the client has connected to or disconnected from the Signal stream,
+// zero if it hasn't connected yet
+func (c *GrpcClient) StatusSince() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.statusSince
+}
+
 // Close Closes underlying connections to the Signal Exchange
 func (c *GrpcClient) Close() error {
 	return c.signalConn.Close()
@@ -51,24 +64,49 @@ func (c *GrpcClient) Close() error {
 
 // NewClient creates a new Signal client
 func NewClient(ctx context.Context, addr string, key wgtypes.Key, tlsEnabled bool) (*GrpcClient, error) {
+	var tlsConfig *tls.Config
+	if tlsEnabled {
+		// the server name (SNI) is taken from the address
+		tlsConfig = &tls.Config{}
+	}
+	return NewClientWithTLS(ctx, addr, key, tlsConfig)
+}
+
+// NewClientWithTLS creates a client connected to the server over TLS with the given config, or in plaintext if it is nil.
+// The config sets e.g. the client certificate of the servers requiring mutual TLS
+func NewClientWithTLS(ctx context.Context, addr string, key wgtypes.Key, tlsConfig *tls.Config) (*GrpcClient, error) {
+	return newClient(ctx, addr, key, tlsConfig, true)
+}
+
+// NewLazyClientWithTLS creates a client like NewClientWithTLS, but doesn't wait for the connection. It is established
+// in the background and the stream is retried until then, e.g. while the peer runs from a bootstrap bundle
+func NewLazyClientWithTLS(ctx context.Context, addr string, key wgtypes.Key, tlsConfig *tls.Config) (*GrpcClient, error) {
+	return newClient(ctx, addr, key, tlsConfig, false)
+}
+
+// newClient creates a client, waiting up to 3 seconds for the connection if blocking
+func newClient(ctx context.Context, addr string, key wgtypes.Key, tlsConfig *tls.Config, blocking bool) (*GrpcClient, error) {
 
 	transportOption := grpc.WithTransportCredentials(insecure.NewCredentials())
 
-	if tlsEnabled {
-		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{}))
+	if tlsConfig != nil {
+		transportOption = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
 	}
 
-	sigCtx, cancel := context.WithTimeout(ctx, time.Second*3)
-	defer cancel()
-	conn, err := grpc.DialContext(
-		sigCtx,
-		addr,
+	opts := []grpc.DialOption{
 		transportOption,
-		grpc.WithBlock(),
 		grpc.WithKeepaliveParams(keepalive.ClientParameters{
 			Time:    15 * time.Second,
 			Timeout: 10 * time.Second,
-		}))
+		}),
+	}
+	if blocking {
+		opts = append(opts, grpc.WithBlock())
+	}
+
+	sigCtx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+	conn, err := grpc.DialContext(sigCtx, addr, opts...)
 
 	if err != nil {
 		log.Errorf("failed to connect to the signalling server %v", err)
@@ -119,6 +157,7 @@ func (c *GrpcClient) Receive(msgHandler func(msg *proto.Message) error) error {
 		// connect to Signal stream identifying ourselves with a public Wireguard key
 		// todo once the key rotation logic has been implemented, consider changing to some other identifier (received from management)
 		stream, err := c.connect(c.key.PublicKey().String())
+		defer c.cancelStream()
 		if err != nil {
 			log.Warnf("disconnected from the Signal Exchange due to an error: %v", err)
 			return err
@@ -150,12 +189,18 @@ func (c *GrpcClient) Receive(msgHandler func(msg *proto.Message) error) error {
 func (c *GrpcClient) notifyStreamDisconnected() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	if c.status == StreamConnected {
+		c.statusSince = time.Now()
+	}
 	c.status = StreamDisconnected
 }
 
 func (c *GrpcClient) notifyStreamConnected() {
 	c.mux.Lock()
 	defer c.mux.Unlock()
+	if c.status != StreamConnected {
+		c.statusSince = time.Now()
+	}
 	c.status = StreamConnected
 	if c.connectedCh != nil {
 		// there are goroutines waiting on this channel -> release them
@@ -176,9 +221,14 @@ func (c *GrpcClient) getStreamStatusChan() <-chan struct{} {
 func (c *GrpcClient) connect(key string) (proto.SignalExchange_ConnectStreamClient, error) {
 	c.stream = nil
 
+	streamCtx, cancel := context.WithCancel(c.ctx)
+	c.mux.Lock()
+	c.streamCancel = cancel
+	c.mux.Unlock()
+
 	// add key fingerprint to the request header to be identified on the server side
 	md := metadata.New(map[string]string{proto.HeaderId: key})
-	ctx := metadata.NewOutgoingContext(c.ctx, md)
+	ctx := metadata.NewOutgoingContext(streamCtx, md)
 
 	stream, err := c.realClient.ConnectStream(ctx, grpc.WaitForReady(true))
 
@@ -199,6 +249,23 @@ func (c *GrpcClient) connect(key string) (proto.SignalExchange_ConnectStreamClie
 	return stream, nil
 }
 
+// Reconnect closes the current Signal stream, so that Receive reconnects to the Signal Exchange right away
+// (e.g. after a network change) instead of waiting for the keepalive to detect a broken connection
+func (c *GrpcClient) Reconnect() {
+	c.signalConn.ResetConnectBackoff()
+	c.cancelStream()
+}
+
+// cancelStream cancels the context of the current Signal stream if there is one
+func (c *GrpcClient) cancelStream() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.streamCancel != nil {
+		c.streamCancel()
+		c.streamCancel = nil
+	}
+}
+
 // Ready indicates whether the client is okay and Ready to be used
 // for now it just checks whether gRPC connection to the service is in state Ready
 func (c *GrpcClient) Ready() bool {
@@ -230,7 +297,9 @@ func (c *GrpcClient) SendToStream(msg *proto.EncryptedMessage) error {
 		return fmt.Errorf("connection to the Signal Exchnage has not been established yet. Please call GrpcClient.Receive before sending messages")
 	}
 
+	c.streamSendMux.Lock()
 	err := c.stream.Send(msg)
+	c.streamSendMux.Unlock()
 	if err != nil {
 		log.Errorf("error while sending message to peer [%s] [error: %v]", msg.RemoteKey, err)
 		return err
@@ -280,6 +349,8 @@ func (c *GrpcClient) encryptMessage(msg *proto.Message) (*proto.EncryptedMessage
 }
 
 // Send sends a message to the remote Peer through the Signal Exchange.
+// Returns an error wrapping ErrPeerNotConnected if the Signal Exchange has acknowledged that the remote peer isn't
+// connected, so the message has been dropped
 func (c *GrpcClient) Send(msg *proto.Message) error {
 
 	if !c.Ready() {
@@ -293,10 +364,13 @@ func (c *GrpcClient) Send(msg *proto.Message) error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
 	defer cancel()
-	_, err = c.realClient.Send(ctx, encryptedMessage)
+	resp, err := c.realClient.Send(ctx, encryptedMessage)
 	if err != nil {
 		return err
 	}
+	if resp.GetDelivery() == proto.DeliveryStatus_NOT_CONNECTED {
+		return fmt.Errorf("peer %s: %w", msg.RemoteKey, ErrPeerNotConnected)
+	}
 
 	return nil
 }
@@ -0,0 +1,6 @@
+package client
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the client of the Signal service, its level is set by the signal component level
+var log = util.Logger("signal")
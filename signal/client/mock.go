@@ -1,14 +1,18 @@
 package client
 
 import (
+	"time"
+
 	"github.com/netbirdio/netbird/signal/proto"
 )
 
 type MockClient struct {
 	CloseFunc               func() error
 	GetStatusFunc           func() Status
+	StatusSinceFunc         func() time.Time
 	StreamConnectedFunc     func() bool
 	ReadyFunc               func() bool
+	ReconnectFunc           func()
 	WaitStreamConnectedFunc func()
 	ReceiveFunc             func(msgHandler func(msg *proto.Message) error) error
 	SendToStreamFunc        func(msg *proto.EncryptedMessage) error
@@ -29,6 +33,13 @@ func (sm *MockClient) GetStatus() Status {
 	return sm.GetStatusFunc()
 }
 
+func (sm *MockClient) StatusSince() time.Time {
+	if sm.StatusSinceFunc == nil {
+		return time.Time{}
+	}
+	return sm.StatusSinceFunc()
+}
+
 func (sm *MockClient) StreamConnected() bool {
 	if sm.StreamConnectedFunc == nil {
 		return false
@@ -36,6 +47,13 @@ func (sm *MockClient) StreamConnected() bool {
 	return sm.StreamConnectedFunc()
 }
 
+func (sm *MockClient) Reconnect() {
+	if sm.ReconnectFunc == nil {
+		return
+	}
+	sm.ReconnectFunc()
+}
+
 func (sm *MockClient) Ready() bool {
 	if sm.ReadyFunc == nil {
 		return false
@@ -0,0 +1,6 @@
+package cmd
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the Signal service commands, its level is set by the signal component level
+var log = util.Logger("signal")
@@ -6,6 +6,7 @@ import (
 	"os/signal"
 	"runtime"
 
+	"github.com/netbirdio/netbird/util"
 	"github.com/spf13/cobra"
 )
 
@@ -16,6 +17,7 @@ const (
 
 var (
 	logLevel       string
+	logFormat      string
 	defaultLogFile string
 	logFile        string
 
@@ -44,6 +46,7 @@ func init() {
 	}
 
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", util.LogFormatText, "sets Netbird log format [text|json]")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", defaultLogFile, "sets Netbird log path. If console is specified the the log will be output to stdout")
 	rootCmd.AddCommand(runCmd)
 }
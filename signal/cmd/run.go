@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,11 +16,10 @@ import (
 	"time"
 
 	"github.com/netbirdio/netbird/encryption"
-	"github.com/netbirdio/netbird/signal/proto"
 	"github.com/netbirdio/netbird/signal/server"
 	"github.com/netbirdio/netbird/util"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
@@ -28,7 +29,14 @@ var (
 	signalPort              int
 	signalLetsencryptDomain string
 	signalSSLDir            string
+	signalCertFile          string
+	signalCertKey           string
 	defaultSignalSSLDir     string
+	signalRedisAddress      string
+	signalRedisPassword     string
+	signalReflection        bool
+	signalDebugAddress      string
+	signalClientCAFile      string
 
 	signalKaep = grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
 		MinTime:             5 * time.Second,
@@ -47,7 +55,7 @@ var (
 		Short: "start Netbird Signal Server daemon",
 		Run: func(cmd *cobra.Command, args []string) {
 			flag.Parse()
-			err := util.InitLog(logLevel, logFile)
+			err := util.InitLog(logLevel, logFile, logFormat)
 			if err != nil {
 				log.Fatalf("failed initializing log %v", err)
 			}
@@ -62,6 +70,10 @@ var (
 			}
 
 			var opts []grpc.ServerOption
+			// tlsConfig is the TLS config of the gRPC server, nil if it runs without TLS
+			var tlsConfig *tls.Config
+			// certManager gets the certificate from Let's Encrypt, nil if it is provided
+			var certManager *autocert.Manager
 			if signalLetsencryptDomain != "" {
 				if _, err := os.Stat(signalSSLDir); os.IsNotExist(err) {
 					err = os.MkdirAll(signalSSLDir, os.ModeDir)
@@ -69,17 +81,52 @@ var (
 						log.Fatalf("failed creating datadir: %s: %v", signalSSLDir, err)
 					}
 				}
-				certManager := encryption.CreateCertManager(signalSSLDir, signalLetsencryptDomain)
-				transportCredentials := credentials.NewTLS(certManager.TLSConfig())
-				opts = append(opts, grpc.Creds(transportCredentials))
+				certManager = encryption.CreateCertManager(signalSSLDir, signalLetsencryptDomain)
+				tlsConfig = certManager.TLSConfig()
 
-				listener := certManager.Listener()
-				log.Infof("http server listening on %s", listener.Addr())
-				go func() {
-					if err := http.Serve(listener, certManager.HTTPHandler(nil)); err != nil {
-						log.Errorf("failed to serve https server: %v", err)
-					}
-				}()
+				// on 443 the gRPC server answers the TLS-ALPN challenges itself
+				if signalPort != 443 {
+					listener := certManager.Listener()
+					log.Infof("http server listening on %s", listener.Addr())
+					go func() {
+						if err := http.Serve(listener, certManager.HTTPHandler(nil)); err != nil {
+							log.Errorf("failed to serve https server: %v", err)
+						}
+					}()
+				}
+			} else if signalCertFile != "" && signalCertKey != "" {
+				tlsConfig, err = loadTLSConfig(signalCertFile, signalCertKey)
+				if err != nil {
+					log.Fatalf("cannot load TLS credentials: %v", err)
+				}
+				log.Infof("running with TLS using certificate %s", signalCertFile)
+			} else if signalCertFile != "" || signalCertKey != "" {
+				log.Fatal("both --cert-file and --cert-key are required to run with TLS")
+			}
+
+			if signalClientCAFile != "" {
+				if tlsConfig == nil {
+					log.Fatal("--client-ca-file requires TLS, set --cert-file and --cert-key or --letsencrypt-domain")
+				}
+				clientCAs, err := encryption.NewClientCAReloader(signalClientCAFile)
+				if err != nil {
+					log.Fatalf("failed reading client CA bundle: %v", err)
+				}
+				watchCtx, stopWatch := context.WithCancel(context.Background())
+				defer stopWatch()
+				err = clientCAs.Watch(watchCtx)
+				if err != nil {
+					log.Warnf("client CA bundle changes won't be noticed, send SIGHUP after updating it: %v", err)
+				}
+				if certManager != nil {
+					tlsConfig = clientCAs.RequireClientCertificatesWithACME(tlsConfig, certManager)
+				} else {
+					tlsConfig = clientCAs.RequireClientCertificates(tlsConfig)
+				}
+				log.Infof("requiring client certificates issued by %s", signalClientCAFile)
+			}
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
 			}
 
 			opts = append(opts, signalKaep, signalKasp)
@@ -90,8 +137,39 @@ var (
 				log.Fatalf("failed to listen: %v", err)
 			}
 
-			proto.RegisterSignalExchangeServer(grpcServer, server.NewServer())
+			var serverOpts []server.Option
+			if signalRedisAddress != "" {
+				backend, err := server.NewRedisBackend(signalRedisAddress, signalRedisPassword)
+				if err != nil {
+					log.Fatalf("failed creating cluster backend: %v", err)
+				}
+				defer backend.Close()
+				serverOpts = append(serverOpts, server.WithBackend(backend))
+				log.Infof("routing messages of the cluster through Redis %s", signalRedisAddress)
+			}
+
+			if signalReflection {
+				serverOpts = append(serverOpts, server.WithReflection())
+			}
+
+			signalServer := server.NewServer(serverOpts...)
+			signalServer.Register(grpcServer)
 			log.Printf("started server: localhost:%v", signalPort)
+
+			if signalDebugAddress != "" {
+				debugServer, err := server.NewDebugServer(signalDebugAddress, signalServer)
+				if err != nil {
+					log.Fatal(err)
+				}
+				go func() {
+					log.Warnf("debug server listening on %s", debugServer.Addr)
+					err := debugServer.ListenAndServe()
+					if err != nil && err != http.ErrServerClosed {
+						log.Fatalf("failed to serve debug server: %v", err)
+					}
+				}()
+			}
+
 			if err := grpcServer.Serve(lis); err != nil {
 				log.Fatalf("failed to serve: %v", err)
 			}
@@ -103,6 +181,22 @@ var (
 	}
 )
 
+func loadTLSConfig(certFile string, certKey string) (*tls.Config, error) {
+	// Load server's certificate and private key
+	serverCert, err := tls.LoadX509KeyPair(certFile, certKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// gRPC credentials add the h2 ALPN protocol
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.NoClientCert,
+	}
+
+	return config, nil
+}
+
 func cpFile(src, dst string) error {
 	var err error
 	var srcfd *os.File
@@ -194,4 +288,11 @@ func init() {
 	runCmd.PersistentFlags().IntVar(&signalPort, "port", 10000, "Server port to listen on (e.g. 10000)")
 	runCmd.Flags().StringVar(&signalSSLDir, "ssl-dir", defaultSignalSSLDir, "server ssl directory location. *Required only for Let's Encrypt certificates.")
 	runCmd.Flags().StringVar(&signalLetsencryptDomain, "letsencrypt-domain", "", "a domain to issue Let's Encrypt certificate for. Enables TLS using Let's Encrypt. Will fetch and renew certificate, and run the server with TLS")
+	runCmd.Flags().StringVar(&signalCertFile, "cert-file", "", "Location of your SSL certificate. Can be used when you have an existing certificate and don't want a new certificate be generated automatically. If letsencrypt-domain is specified this property has no effect")
+	runCmd.Flags().StringVar(&signalCertKey, "cert-key", "", "Location of your SSL certificate private key. Can be used when you have an existing certificate and don't want a new certificate be generated automatically. If letsencrypt-domain is specified this property has no effect")
+	runCmd.Flags().StringVar(&signalClientCAFile, "client-ca-file", "", "Location of a PEM bundle of the CAs issuing the client certificates. Requires the peers to present a client certificate (mutual TLS). Reloaded on SIGHUP or once the file changes")
+	runCmd.Flags().StringVar(&signalRedisAddress, "redis-address", "", "address (host:port) of a Redis server shared by the instances of a Signal server cluster. Enables routing messages to the peers connected to other instances")
+	runCmd.Flags().StringVar(&signalRedisPassword, "redis-password", "", "password of the Redis server. *Required only if the Redis server requires authentication.")
+	runCmd.Flags().BoolVar(&signalReflection, "grpc-reflection", false, "enables the gRPC server reflection for tools like grpcurl. Meant for development and support only, don't expose such a server publicly")
+	runCmd.Flags().StringVar(&signalDebugAddress, "debug-address", "", "loopback address (host:port) of the debug endpoint reporting the connected peers and open streams, e.g. 127.0.0.1:9091. Disabled if empty")
 }
@@ -0,0 +1,6 @@
+package peer
+
+import "github.com/netbirdio/netbird/util"
+
+// log is the logger of the registry of the peers connected to the Signal service, its level is set by the signal component level
+var log = util.Logger("signal")
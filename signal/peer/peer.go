@@ -2,7 +2,7 @@ package peer
 
 import (
 	"github.com/netbirdio/netbird/signal/proto"
-	log "github.com/sirupsen/logrus"
+	"sort"
 	"sync"
 )
 
@@ -50,6 +50,17 @@ func (registry *Registry) IsPeerRegistered(peerId string) bool {
 	return false
 }
 
+// Ids returns the sorted ids of the Peers currently connected
+func (registry *Registry) Ids() []string {
+	ids := make([]string, 0)
+	registry.Peers.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(string))
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
+
 // Register registers peer in the registry
 func (registry *Registry) Register(peer *Peer) {
 	// can be that peer already exists but it is fine (e.g. reconnect)
@@ -52,3 +52,14 @@ func TestRegistry_Deregister(t *testing.T) {
 	}
 
 }
+
+func TestRegistry_Ids(t *testing.T) {
+	r := NewRegistry()
+	r.Register(NewPeer("test_peer_2", nil))
+	r.Register(NewPeer("test_peer_1", nil))
+
+	ids := r.Ids()
+	if len(ids) != 2 || ids[0] != "test_peer_1" || ids[1] != "test_peer_2" {
+		t.Errorf("expected sorted ids [test_peer_1 test_peer_2], got %v", ids)
+	}
+}
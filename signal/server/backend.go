@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/netbirdio/netbird/signal/proto"
+)
+
+// Backend routes messages between the instances of a Signal server cluster.
+// Every instance subscribes to the messages of the peers connected to it and publishes the messages addressed
+// to the peers that aren't, so that the instance holding the stream of the destination peer forwards them
+type Backend interface {
+	// Subscribe starts passing the messages published to a peer connected to this instance to the handler
+	Subscribe(peerKey string, handler func(msg *proto.EncryptedMessage)) error
+	// Unsubscribe stops passing the messages published to a peer (e.g. once it has disconnected)
+	Unsubscribe(peerKey string) error
+	// Publish sends a message to the instance the destination peer is connected to.
+	// Returns false if the peer isn't connected to any instance
+	Publish(peerKey string, msg *proto.EncryptedMessage) (bool, error)
+	// Close releases the resources of the Backend
+	Close() error
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/netbirdio/netbird/signal/proto"
+	"github.com/netbirdio/netbird/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/reflection"
+)
+
+// debugPeersPath is the path of the endpoint returning the connected peers and the stream counts
+const debugPeersPath = "/debug/peers"
+
+// DebugInfo is the state of the Signal server exposed by the debug endpoint
+type DebugInfo struct {
+	// ConnectedPeers are the sorted ids of the peers connected to this instance
+	ConnectedPeers []string `json:"connectedPeers"`
+	// Streams is the number of open ConnectStream streams, it includes the streams being replaced by a reconnecting peer
+	Streams int64 `json:"streams"`
+}
+
+// Register registers the Signal service on the gRPC server along with the gRPC server reflection
+// if enabled with WithReflection
+func (s *Server) Register(grpcServer *grpc.Server) {
+	proto.RegisterSignalExchangeServer(grpcServer, s)
+	if s.reflection {
+		reflection.Register(grpcServer)
+		log.Warnf("gRPC server reflection is enabled, don't expose the server publicly")
+	}
+}
+
+// DebugInfo returns the current connected peers and open streams
+func (s *Server) DebugInfo() *DebugInfo {
+	return &DebugInfo{
+		ConnectedPeers: s.registry.Ids(),
+		Streams:        atomic.LoadInt64(&s.streams),
+	}
+}
+
+// NewDebugServer creates an HTTP server serving the debug endpoints on a dedicated address,
+// the address has to be a loopback one so the endpoints aren't exposed publicly
+func NewDebugServer(address string, server *Server) (*http.Server, error) {
+	if err := util.ValidateLoopbackAddress(address); err != nil {
+		return nil, fmt.Errorf("refusing to serve the debug endpoints: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(debugPeersPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(server.DebugInfo()); err != nil {
+			log.Errorf("failed encoding debug response: %v", err)
+		}
+	})
+
+	return &http.Server{
+		Addr:         address,
+		Handler:      mux,
+		WriteTimeout: time.Second * 15,
+		ReadTimeout:  time.Second * 15,
+		IdleTimeout:  time.Second * 60,
+	}, nil
+}
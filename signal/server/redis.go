@@ -0,0 +1,378 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	pb "github.com/golang/protobuf/proto" //nolint
+	"github.com/netbirdio/netbird/signal/proto"
+)
+
+// redisChannelPrefix is a prefix of the Redis Pub/Sub channels of the peers. A channel of a peer is named after its key
+const redisChannelPrefix = "signal:peer:"
+
+// redisDialTimeout is a timeout of connecting to Redis
+const redisDialTimeout = 5 * time.Second
+
+// RedisBackend is a Backend routing the messages through Redis Pub/Sub.
+// Every peer has a channel the instance holding its stream subscribes to. Publishing to a channel nobody has subscribed to
+// means the peer isn't connected to any instance
+type RedisBackend struct {
+	address  string
+	password string
+
+	// pubMux guards the connection used to publish messages
+	pubMux  sync.Mutex
+	pubConn *redisConn
+
+	// subMux guards the connection subscribed to the channels of the local peers and the handlers of the channels
+	subMux   sync.Mutex
+	subConn  *redisConn
+	handlers map[string]func(msg *proto.EncryptedMessage)
+
+	closed chan struct{}
+}
+
+// NewRedisBackend connects to the Redis server at the address (host:port). The password is optional
+func NewRedisBackend(address string, password string) (*RedisBackend, error) {
+	b := &RedisBackend{
+		address:  address,
+		password: password,
+		handlers: map[string]func(msg *proto.EncryptedMessage){},
+		closed:   make(chan struct{}),
+	}
+
+	subConn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.subConn = subConn
+
+	go b.receive(subConn)
+
+	return b, nil
+}
+
+// Subscribe subscribes to the channel of the peer
+func (b *RedisBackend) Subscribe(peerKey string, handler func(msg *proto.EncryptedMessage)) error {
+	b.subMux.Lock()
+	defer b.subMux.Unlock()
+
+	b.handlers[redisChannelPrefix+peerKey] = handler
+	if b.subConn == nil {
+		// reconnecting, the channel is subscribed once connected
+		return nil
+	}
+	return b.subConn.writeCommand("SUBSCRIBE", redisChannelPrefix+peerKey)
+}
+
+// Unsubscribe unsubscribes from the channel of the peer
+func (b *RedisBackend) Unsubscribe(peerKey string) error {
+	b.subMux.Lock()
+	defer b.subMux.Unlock()
+
+	delete(b.handlers, redisChannelPrefix+peerKey)
+	if b.subConn == nil {
+		return nil
+	}
+	return b.subConn.writeCommand("UNSUBSCRIBE", redisChannelPrefix+peerKey)
+}
+
+// Publish publishes the message to the channel of the peer. Redis replies with the number of the subscribers of the channel,
+// so the message has been delivered if any instance has subscribed to it
+func (b *RedisBackend) Publish(peerKey string, msg *proto.EncryptedMessage) (bool, error) {
+	payload, err := pb.Marshal(msg)
+	if err != nil {
+		return false, err
+	}
+
+	b.pubMux.Lock()
+	defer b.pubMux.Unlock()
+
+	// retry once on a new connection when the previous one has been closed (e.g. Redis restart)
+	for attempt := 0; attempt < 2; attempt++ {
+		if b.pubConn == nil {
+			b.pubConn, err = b.dial()
+			if err != nil {
+				return false, err
+			}
+		}
+
+		var receivers int64
+		receivers, err = b.publish(b.pubConn, redisChannelPrefix+peerKey, payload)
+		if err == nil {
+			return receivers > 0, nil
+		}
+
+		var redisErr redisError
+		if errors.As(err, &redisErr) {
+			return false, err
+		}
+		_ = b.pubConn.Close()
+		b.pubConn = nil
+	}
+
+	return false, err
+}
+
+func (b *RedisBackend) publish(conn *redisConn, channel string, payload []byte) (int64, error) {
+	err := conn.writeCommand("PUBLISH", channel, string(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	reply, err := conn.readReply()
+	if err != nil {
+		return 0, err
+	}
+
+	receivers, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply to PUBLISH: %v", reply)
+	}
+	return receivers, nil
+}
+
+// Close closes the connections to Redis
+func (b *RedisBackend) Close() error {
+	select {
+	case <-b.closed:
+		return nil
+	default:
+		close(b.closed)
+	}
+
+	b.pubMux.Lock()
+	if b.pubConn != nil {
+		_ = b.pubConn.Close()
+		b.pubConn = nil
+	}
+	b.pubMux.Unlock()
+
+	b.subMux.Lock()
+	defer b.subMux.Unlock()
+	if b.subConn != nil {
+		return b.subConn.Close()
+	}
+	return nil
+}
+
+// receive reads the messages of the subscribed channels and passes them to the handlers.
+// When the connection breaks it reconnects and subscribes to the channels of the local peers again
+func (b *RedisBackend) receive(conn *redisConn) {
+	for {
+		err := b.readMessages(conn)
+		select {
+		case <-b.closed:
+			return
+		default:
+		}
+		log.Errorf("lost the connection to Redis %s, reconnecting: %v", b.address, err)
+
+		b.subMux.Lock()
+		_ = conn.Close()
+		b.subConn = nil
+		b.subMux.Unlock()
+
+		conn = b.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+func (b *RedisBackend) readMessages(conn *redisConn) error {
+	for {
+		reply, err := conn.readReply()
+		if err != nil {
+			return err
+		}
+
+		// a message is an array of "message", the channel and the payload.
+		// The confirmations of the (un)subscriptions are ignored
+		fields, ok := reply.([]interface{})
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		kind, _ := fields[0].([]byte)
+		channel, _ := fields[1].([]byte)
+		payload, _ := fields[2].([]byte)
+		if string(kind) != "message" {
+			continue
+		}
+
+		msg := &proto.EncryptedMessage{}
+		err = pb.Unmarshal(payload, msg)
+		if err != nil {
+			log.Errorf("failed decoding message received from Redis channel %s: %v", channel, err)
+			continue
+		}
+
+		b.subMux.Lock()
+		handler, found := b.handlers[string(channel)]
+		b.subMux.Unlock()
+		if found {
+			handler(msg)
+		}
+	}
+}
+
+// reconnect connects to Redis with a backoff and subscribes to the channels of the local peers.
+// Returns nil if the backend has been closed
+func (b *RedisBackend) reconnect() *redisConn {
+	var conn *redisConn
+	operation := func() error {
+		select {
+		case <-b.closed:
+			return backoff.Permanent(fmt.Errorf("backend closed"))
+		default:
+		}
+
+		newConn, err := b.dial()
+		if err != nil {
+			log.Debugf("failed reconnecting to Redis %s: %v", b.address, err)
+			return err
+		}
+
+		b.subMux.Lock()
+		defer b.subMux.Unlock()
+		for channel := range b.handlers {
+			err = newConn.writeCommand("SUBSCRIBE", channel)
+			if err != nil {
+				_ = newConn.Close()
+				return err
+			}
+		}
+		b.subConn = newConn
+		conn = newConn
+		return nil
+	}
+
+	err := backoff.Retry(operation, &backoff.ExponentialBackOff{
+		InitialInterval:     100 * time.Millisecond,
+		RandomizationFactor: backoff.DefaultRandomizationFactor,
+		Multiplier:          backoff.DefaultMultiplier,
+		MaxInterval:         5 * time.Second,
+		MaxElapsedTime:      0, // retry until closed
+		Stop:                backoff.Stop,
+		Clock:               backoff.SystemClock,
+	})
+	if err != nil {
+		return nil
+	}
+
+	log.Infof("reconnected to Redis %s", b.address)
+	return conn
+}
+
+func (b *RedisBackend) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", b.address, redisDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to Redis %s: %v", b.address, err)
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if b.password != "" {
+		err = conn.writeCommand("AUTH", b.password)
+		if err == nil {
+			_, err = conn.readReply()
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed authenticating to Redis %s: %v", b.address, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// redisError is an error reply of Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisConn is a connection to Redis speaking the RESP protocol
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// writeCommand sends a command as an array of bulk strings
+func (c *redisConn) writeCommand(args ...string) error {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+// readReply reads a reply: a string, an int64, a []byte (nil for a null bulk string), a []interface{} of replies
+// or a redisError which is returned as the error
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return []byte(nil), nil
+		}
+		data := make([]byte, size+2)
+		_, err = io.ReadFull(c.reader, data)
+		if err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return []interface{}(nil), nil
+		}
+		array := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			element, err := c.readReply()
+			if err != nil {
+				var redisErr redisError
+				if !errors.As(err, &redisErr) {
+					return nil, err
+				}
+				element = redisErr
+			}
+			array = append(array, element)
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("unknown reply type %q", kind)
+	}
+}
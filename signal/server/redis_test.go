@@ -0,0 +1,401 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netbirdio/netbird/signal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a Redis server supporting AUTH, SUBSCRIBE, UNSUBSCRIBE and PUBLISH
+type fakeRedis struct {
+	listener net.Listener
+	password string
+	// publishErr is replied to PUBLISH instead of publishing the message when set
+	publishErr string
+
+	mu          sync.Mutex
+	clients     map[*fakeRedisClient]struct{}
+	subscribers map[string]map[*fakeRedisClient]struct{}
+	accepted    int
+}
+
+type fakeRedisClient struct {
+	conn    *redisConn
+	writeMu sync.Mutex
+}
+
+func (c *fakeRedisClient) write(reply string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, _ = c.conn.Write([]byte(reply))
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeRedis{
+		listener:    listener,
+		password:    password,
+		clients:     map[*fakeRedisClient]struct{}{},
+		subscribers: map[string]map[*fakeRedisClient]struct{}{},
+	}
+	go server.serve()
+	t.Cleanup(server.close)
+	return server
+}
+
+func (s *fakeRedis) address() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		client := &fakeRedisClient{conn: &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}}
+		s.mu.Lock()
+		s.clients[client] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(client)
+	}
+}
+
+func (s *fakeRedis) handle(client *fakeRedisClient) {
+	defer s.drop(client)
+	authenticated := s.password == ""
+	for {
+		request, err := client.conn.readReply()
+		if err != nil {
+			return
+		}
+		fields, ok := request.([]interface{})
+		if !ok || len(fields) == 0 {
+			client.write("-ERR protocol error\r\n")
+			continue
+		}
+		args := make([]string, 0, len(fields))
+		for _, field := range fields {
+			arg, _ := field.([]byte)
+			args = append(args, string(arg))
+		}
+
+		switch {
+		case args[0] == "AUTH" && len(args) == 2:
+			if args[1] != s.password {
+				client.write("-WRONGPASS invalid username-password pair\r\n")
+				continue
+			}
+			authenticated = true
+			client.write("+OK\r\n")
+		case !authenticated:
+			client.write("-NOAUTH Authentication required.\r\n")
+		case args[0] == "SUBSCRIBE" && len(args) == 2:
+			s.mu.Lock()
+			if s.subscribers[args[1]] == nil {
+				s.subscribers[args[1]] = map[*fakeRedisClient]struct{}{}
+			}
+			s.subscribers[args[1]][client] = struct{}{}
+			s.mu.Unlock()
+			client.write(encodeArray("subscribe", args[1]) + ":1\r\n")
+		case args[0] == "UNSUBSCRIBE" && len(args) == 2:
+			s.mu.Lock()
+			delete(s.subscribers[args[1]], client)
+			s.mu.Unlock()
+			client.write(encodeArray("unsubscribe", args[1]) + ":0\r\n")
+		case args[0] == "PUBLISH" && len(args) == 3:
+			if s.publishErr != "" {
+				client.write("-" + s.publishErr + "\r\n")
+				continue
+			}
+			s.mu.Lock()
+			receivers := 0
+			for subscriber := range s.subscribers[args[1]] {
+				subscriber.write("*3\r\n" + encodeBulk("message") + encodeBulk(args[1]) + encodeBulk(args[2]))
+				receivers++
+			}
+			s.mu.Unlock()
+			client.write(":" + strconv.Itoa(receivers) + "\r\n")
+		default:
+			client.write("-ERR unknown command '" + args[0] + "'\r\n")
+		}
+	}
+}
+
+func (s *fakeRedis) drop(client *fakeRedisClient) {
+	_ = client.conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, client)
+	for _, subscribers := range s.subscribers {
+		delete(subscribers, client)
+	}
+}
+
+// dropConnections closes the connections of all the clients as a restart of Redis would
+func (s *fakeRedis) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for client := range s.clients {
+		_ = client.conn.Close()
+	}
+}
+
+func (s *fakeRedis) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+func (s *fakeRedis) close() {
+	_ = s.listener.Close()
+	s.dropConnections()
+}
+
+func encodeBulk(value string) string {
+	return "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+}
+
+func encodeArray(values ...string) string {
+	// the trailing element is appended by the caller
+	encoded := "*" + strconv.Itoa(len(values)+1) + "\r\n"
+	for _, value := range values {
+		encoded += encodeBulk(value)
+	}
+	return encoded
+}
+
+func TestRedisConn_ReadReply(t *testing.T) {
+	tt := []struct {
+		name        string
+		raw         string
+		expected    interface{}
+		expectedErr error
+		expectErr   bool
+	}{
+		{name: "Simple String", raw: "+OK\r\n", expected: "OK"},
+		{name: "Error", raw: "-ERR unknown command\r\n", expectedErr: redisError("ERR unknown command")},
+		{name: "Integer", raw: ":42\r\n", expected: int64(42)},
+		{name: "Negative Integer", raw: ":-1\r\n", expected: int64(-1)},
+		{name: "Bulk String", raw: "$5\r\nhello\r\n", expected: []byte("hello")},
+		{name: "Binary Bulk String", raw: "$4\r\na\r\nb\r\n", expected: []byte("a\r\nb")},
+		{name: "Null Bulk String", raw: "$-1\r\n", expected: []byte(nil)},
+		{name: "Empty Array", raw: "*0\r\n", expected: []interface{}{}},
+		{name: "Null Array", raw: "*-1\r\n", expected: []interface{}(nil)},
+		{
+			name:     "Message",
+			raw:      "*3\r\n$7\r\nmessage\r\n$4\r\npeer\r\n$3\r\nabc\r\n",
+			expected: []interface{}{[]byte("message"), []byte("peer"), []byte("abc")},
+		},
+		{
+			name:     "Nested Array With Error",
+			raw:      "*2\r\n*1\r\n:1\r\n-ERR failed\r\n",
+			expected: []interface{}{[]interface{}{int64(1)}, redisError("ERR failed")},
+		},
+		{name: "Malformed Line", raw: "+OK\n", expectErr: true},
+		{name: "Unknown Type", raw: "!5\r\n", expectErr: true},
+		{name: "Invalid Integer", raw: ":abc\r\n", expectErr: true},
+		{name: "Invalid Bulk Size", raw: "$abc\r\n", expectErr: true},
+		{name: "Truncated Bulk String", raw: "$5\r\nhel", expectedErr: io.ErrUnexpectedEOF},
+		{name: "Truncated Array", raw: "*2\r\n:1\r\n", expectedErr: io.EOF},
+		{name: "Closed Connection", raw: "", expectedErr: io.EOF},
+	}
+
+	for _, testCase := range tt {
+		t.Run(testCase.name, func(t *testing.T) {
+			conn := &redisConn{reader: bufio.NewReader(strings.NewReader(testCase.raw))}
+
+			reply, err := conn.readReply()
+			switch {
+			case testCase.expectedErr != nil:
+				assert.ErrorIs(t, err, testCase.expectedErr)
+			case testCase.expectErr:
+				assert.Error(t, err)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, testCase.expected, reply)
+			}
+		})
+	}
+}
+
+func TestRedisConn_Pipelining(t *testing.T) {
+	// replies to several commands arrive in a single read and are read one after another in order
+	raw := "+OK\r\n:1\r\n$3\r\nabc\r\n-ERR failed\r\n*1\r\n:2\r\n"
+	conn := &redisConn{reader: bufio.NewReader(strings.NewReader(raw))}
+
+	expected := []interface{}{"OK", int64(1), []byte("abc"), nil, []interface{}{int64(2)}}
+	for i, expectedReply := range expected {
+		reply, err := conn.readReply()
+		if i == 3 {
+			assert.Equal(t, redisError("ERR failed"), err, "an error reply should not break the following replies")
+			continue
+		}
+		require.NoError(t, err, "reply %d", i)
+		assert.Equal(t, expectedReply, reply, "reply %d", i)
+	}
+
+	// commands written without waiting for the replies get them in the order of the commands
+	server := newFakeRedis(t, "")
+	backend := &RedisBackend{address: server.address()}
+	client, err := backend.dial()
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.writeCommand("PUBLISH", "channel", "first"))
+	require.NoError(t, client.writeCommand("UNKNOWN"))
+	require.NoError(t, client.writeCommand("PUBLISH", "channel", "second"))
+
+	reply, err := client.readReply()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reply)
+	_, err = client.readReply()
+	assert.Equal(t, redisError("ERR unknown command 'UNKNOWN'"), err)
+	reply, err = client.readReply()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), reply)
+}
+
+func TestRedisConn_WriteCommand(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	conn := &redisConn{Conn: clientConn}
+
+	go func() {
+		_ = conn.writeCommand("PUBLISH", "signal:peer:key", "a\r\nb")
+		_ = clientConn.Close()
+	}()
+
+	written, err := io.ReadAll(serverConn)
+	require.NoError(t, err)
+	assert.Equal(t, "*3\r\n$7\r\nPUBLISH\r\n$15\r\nsignal:peer:key\r\n$4\r\na\r\nb\r\n", string(written))
+}
+
+func TestRedisBackend_Auth(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	_, err := NewRedisBackend(server.address(), "wrong")
+	assert.Error(t, err, "authenticating with a wrong password should fail")
+
+	backend, err := NewRedisBackend(server.address(), "secret")
+	require.NoError(t, err)
+	defer backend.Close()
+
+	_, err = backend.Publish("peer", &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer"})
+	assert.NoError(t, err)
+}
+
+func TestRedisBackend_PublishSubscribe(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	subscriber, err := NewRedisBackend(server.address(), "")
+	require.NoError(t, err)
+	defer subscriber.Close()
+	publisher, err := NewRedisBackend(server.address(), "")
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	received := make(chan *proto.EncryptedMessage, 10)
+	require.NoError(t, subscriber.Subscribe("peer", func(msg *proto.EncryptedMessage) {
+		received <- msg
+	}))
+
+	msg := &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer", Body: []byte("body")}
+	waitDelivered(t, publisher, "peer", msg)
+	assertReceived(t, received, msg)
+
+	delivered, err := publisher.Publish("unknown", msg)
+	require.NoError(t, err)
+	assert.False(t, delivered, "a message to a peer without a subscription should not be delivered")
+
+	require.NoError(t, subscriber.Unsubscribe("peer"))
+	require.Eventually(t, func() bool {
+		delivered, err = publisher.Publish("peer", msg)
+		return err == nil && !delivered
+	}, 5*time.Second, 50*time.Millisecond, "a message to an unsubscribed peer should not be delivered")
+}
+
+func TestRedisBackend_PublishErrorReply(t *testing.T) {
+	server := newFakeRedis(t, "")
+	server.publishErr = "OOM command not allowed when used memory > 'maxmemory'"
+
+	backend, err := NewRedisBackend(server.address(), "")
+	require.NoError(t, err)
+	defer backend.Close()
+
+	_, err = backend.Publish("peer", &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer"})
+	assert.Equal(t, redisError(server.publishErr), err)
+	_, err = backend.Publish("peer", &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer"})
+	assert.Equal(t, redisError(server.publishErr), err)
+
+	// the subscriber connection and a single publisher connection, an error reply doesn't break the connection
+	assert.Equal(t, 2, server.connections())
+}
+
+func TestRedisBackend_Reconnect(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	subscriber, err := NewRedisBackend(server.address(), "")
+	require.NoError(t, err)
+	defer subscriber.Close()
+	publisher, err := NewRedisBackend(server.address(), "")
+	require.NoError(t, err)
+	defer publisher.Close()
+
+	received := make(chan *proto.EncryptedMessage, 10)
+	require.NoError(t, subscriber.Subscribe("peer", func(msg *proto.EncryptedMessage) {
+		received <- msg
+	}))
+
+	msg := &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer", Body: []byte("before")}
+	waitDelivered(t, publisher, "peer", msg)
+	assertReceived(t, received, msg)
+
+	server.dropConnections()
+
+	// the subscriber reconnects and subscribes again, the publisher retries on a new connection
+	msg = &proto.EncryptedMessage{Key: "sender", RemoteKey: "peer", Body: []byte("after")}
+	waitDelivered(t, publisher, "peer", msg)
+	assertReceived(t, received, msg)
+
+	// a peer subscribed after the reconnection is subscribed on the new connection
+	require.NoError(t, subscriber.Subscribe("other", func(msg *proto.EncryptedMessage) {
+		received <- msg
+	}))
+	msg = &proto.EncryptedMessage{Key: "sender", RemoteKey: "other", Body: []byte("other")}
+	waitDelivered(t, publisher, "other", msg)
+	assertReceived(t, received, msg)
+}
+
+// waitDelivered publishes the message until a subscriber receives it, as subscribing is asynchronous
+func waitDelivered(t *testing.T, backend *RedisBackend, peerKey string, msg *proto.EncryptedMessage) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		delivered, err := backend.Publish(peerKey, msg)
+		return err == nil && delivered
+	}, 5*time.Second, 50*time.Millisecond, "message to %s should be delivered", peerKey)
+}
+
+func assertReceived(t *testing.T, received chan *proto.EncryptedMessage, expected *proto.EncryptedMessage) {
+	t.Helper()
+	select {
+	case msg := <-received:
+		assert.Equal(t, expected.Key, msg.Key)
+		assert.Equal(t, expected.RemoteKey, msg.RemoteKey)
+		assert.Equal(t, expected.Body, msg.Body)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message %s wasn't received", expected.Body)
+	}
+}
@@ -5,24 +5,53 @@ import (
 	"fmt"
 	"github.com/netbirdio/netbird/signal/peer"
 	"github.com/netbirdio/netbird/signal/proto"
-	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"io"
+	"sync/atomic"
 )
 
 // Server an instance of a Signal server
 type Server struct {
+	// streams is the number of open ConnectStream streams, first for the 64-bit alignment of its atomic access
+	streams int64
+
 	registry *peer.Registry
+	// backend routes the messages to the peers connected to other instances of the cluster. nil for a standalone server
+	backend Backend
+	// reflection enables the gRPC server reflection when registering the Server
+	reflection bool
 	proto.UnimplementedSignalExchangeServer
 }
 
+// Option is an optional setting of the Server
+type Option func(s *Server)
+
+// WithBackend makes the Server an instance of a cluster routing the messages through the backend
+func WithBackend(backend Backend) Option {
+	return func(s *Server) {
+		s.backend = backend
+	}
+}
+
+// WithReflection enables the gRPC server reflection, so tools like grpcurl can call the service without its proto files.
+// Meant for development and support only
+func WithReflection() Option {
+	return func(s *Server) {
+		s.reflection = true
+	}
+}
+
 // NewServer creates a new Signal server
-func NewServer() *Server {
-	return &Server{
+func NewServer(opts ...Option) *Server {
+	s := &Server{
 		registry: peer.NewRegistry(),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Send forwards a message to the signal peer
@@ -32,18 +61,9 @@ func (s *Server) Send(ctx context.Context, msg *proto.EncryptedMessage) (*proto.
 		return nil, fmt.Errorf("peer %s is not registered", msg.Key)
 	}
 
-	if dstPeer, found := s.registry.Get(msg.RemoteKey); found {
-		//forward the message to the target peer
-		err := dstPeer.Stream.Send(msg)
-		if err != nil {
-			log.Errorf("error while forwarding message from peer [%s] to peer [%s] %v", msg.Key, msg.RemoteKey, err)
-			//todo respond to the sender?
-		}
-	} else {
-		log.Debugf("message from peer [%s] can't be forwarded to peer [%s] because destination peer is not connected", msg.Key, msg.RemoteKey)
-		//todo respond to the sender?
-	}
-	return &proto.EncryptedMessage{}, nil
+	delivery := s.forward(msg)
+
+	return &proto.EncryptedMessage{Delivery: delivery}, nil
 }
 
 // ConnectStream connects to the exchange stream
@@ -54,9 +74,13 @@ func (s *Server) ConnectStream(stream proto.SignalExchange_ConnectStreamServer)
 		return err
 	}
 
+	atomic.AddInt64(&s.streams, 1)
+	defer atomic.AddInt64(&s.streams, -1)
+
 	defer func() {
 		log.Infof("peer disconnected [%s] ", p.Id)
 		s.registry.Deregister(p)
+		s.unsubscribe(p)
 	}()
 
 	//needed to confirm that the peer has been registered so that the client can proceed
@@ -77,31 +101,84 @@ func (s *Server) ConnectStream(stream proto.SignalExchange_ConnectStreamServer)
 			return err
 		}
 		log.Debugf("received a new message from peer [%s] to peer [%s]", p.Id, msg.RemoteKey)
-		// lookup the target peer where the message is going to
-		if dstPeer, found := s.registry.Get(msg.RemoteKey); found {
-			//forward the message to the target peer
-			err := dstPeer.Stream.Send(msg)
-			if err != nil {
-				log.Errorf("error while forwarding message from peer [%s] to peer [%s] %v", p.Id, msg.RemoteKey, err)
-				//todo respond to the sender?
-			}
-		} else {
-			log.Debugf("message from peer [%s] can't be forwarded to peer [%s] because destination peer is not connected", p.Id, msg.RemoteKey)
-			//todo respond to the sender?
-		}
+		s.forward(msg)
 	}
 	<-stream.Context().Done()
 	return stream.Context().Err()
 }
 
+// forward sends a message to the target peer connected to this instance or publishes it to the backend
+// when the target peer is connected to another instance of the cluster. Returns the delivery receipt of the message,
+// proto.DeliveryStatus_UNKNOWN if the backend has failed
+func (s *Server) forward(msg *proto.EncryptedMessage) proto.DeliveryStatus {
+	// lookup the target peer where the message is going to
+	if dstPeer, found := s.registry.Get(msg.RemoteKey); found {
+		//forward the message to the target peer
+		err := dstPeer.Stream.Send(msg)
+		if err != nil {
+			log.Errorf("error while forwarding message from peer [%s] to peer [%s] %v", msg.Key, msg.RemoteKey, err)
+			return proto.DeliveryStatus_NOT_CONNECTED
+		}
+		return proto.DeliveryStatus_DELIVERED
+	}
+
+	if s.backend != nil {
+		delivered, err := s.backend.Publish(msg.RemoteKey, msg)
+		if err != nil {
+			log.Errorf("error while publishing message from peer [%s] to peer [%s] %v", msg.Key, msg.RemoteKey, err)
+			return proto.DeliveryStatus_UNKNOWN
+		}
+		if delivered {
+			return proto.DeliveryStatus_DELIVERED
+		}
+	}
+
+	log.Debugf("message from peer [%s] can't be forwarded to peer [%s] because destination peer is not connected", msg.Key, msg.RemoteKey)
+	return proto.DeliveryStatus_NOT_CONNECTED
+}
+
+// deliver sends a message received from the backend to the target peer connected to this instance
+func (s *Server) deliver(msg *proto.EncryptedMessage) {
+	dstPeer, found := s.registry.Get(msg.RemoteKey)
+	if !found {
+		log.Debugf("message from peer [%s] routed to this instance can't be forwarded to peer [%s] because it has disconnected", msg.Key, msg.RemoteKey)
+		return
+	}
+
+	err := dstPeer.Stream.Send(msg)
+	if err != nil {
+		log.Errorf("error while forwarding routed message from peer [%s] to peer [%s] %v", msg.Key, msg.RemoteKey, err)
+	}
+}
+
+// unsubscribe stops routing the messages of the disconnected peer to this instance unless it has reconnected in the meantime
+func (s *Server) unsubscribe(p *peer.Peer) {
+	if s.backend == nil || s.registry.IsPeerRegistered(p.Id) {
+		return
+	}
+
+	err := s.backend.Unsubscribe(p.Id)
+	if err != nil {
+		log.Errorf("error while unsubscribing peer [%s] from the backend %v", p.Id, err)
+	}
+}
+
 // Handles initial Peer connection.
 // Each connection must provide an Id header.
 // At this moment the connecting Peer will be registered in the peer.Registry
+// and subscribed to the messages routed by the backend of the cluster
 func (s Server) connectPeer(stream proto.SignalExchange_ConnectStreamServer) (*peer.Peer, error) {
 	if meta, hasMeta := metadata.FromIncomingContext(stream.Context()); hasMeta {
 		if id, found := meta[proto.HeaderId]; found {
 			p := peer.NewPeer(id[0], stream)
 			s.registry.Register(p)
+			if s.backend != nil {
+				err := s.backend.Subscribe(p.Id, s.deliver)
+				if err != nil {
+					s.registry.Deregister(p)
+					return nil, status.Errorf(codes.Unavailable, "failed subscribing peer to the cluster backend: %v", err)
+				}
+			}
 			return p, nil
 		} else {
 			return nil, status.Errorf(codes.FailedPrecondition, "missing connection header: "+proto.HeaderId)
@@ -0,0 +1,119 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// TestCA is a throwaway CA issuing the server and client certificates of the mutual TLS tests
+type TestCA struct {
+	// CertFile is the PEM encoded certificate of the CA, the bundle the servers verify the client certificates against
+	CertFile string
+
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+// NewTestCA creates a CA valid for an hour writing its certificate and the certificates it issues to the dir
+func NewTestCA(dir string) (*TestCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Netbird Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &TestCA{cert: cert, key: key, serial: 1}
+	ca.CertFile = filepath.Join(dir, fmt.Sprintf("ca-%d.pem", time.Now().UnixNano()))
+	err = writePEM(ca.CertFile, "CERTIFICATE", der)
+	if err != nil {
+		return nil, err
+	}
+	return ca, nil
+}
+
+// IssueServerCert issues a certificate of a server reachable as localhost and 127.0.0.1,
+// returns the files of the certificate and its private key
+func (ca *TestCA) IssueServerCert() (certFile string, keyFile string, err error) {
+	return ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+}
+
+// IssueClientCert issues a client certificate with the common name, e.g. the Wireguard public key of a peer,
+// returns the files of the certificate and its private key
+func (ca *TestCA) IssueClientCert(commonName string) (certFile string, keyFile string, err error) {
+	return ca.issue(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+}
+
+func (ca *TestCA) issue(template *x509.Certificate) (certFile string, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	serial := atomic.AddInt64(&ca.serial, 1)
+	template.SerialNumber = big.NewInt(serial)
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := strings.TrimSuffix(ca.CertFile, ".pem")
+	certFile = fmt.Sprintf("%s-cert-%d.pem", prefix, serial)
+	keyFile = fmt.Sprintf("%s-key-%d.pem", prefix, serial)
+	err = writePEM(certFile, "CERTIFICATE", der)
+	if err != nil {
+		return "", "", err
+	}
+	err = writePEM(keyFile, "EC PRIVATE KEY", keyDER)
+	if err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+func writePEM(file string, blockType string, der []byte) error {
+	return os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+}
@@ -0,0 +1,179 @@
+// Package harness runs the Management and the Signal services of a test with the testutil package and registers
+// the peers of the test with them, every peer with a Wireguard port and an interface name of its own. The tests using
+// a harness can run in parallel. It is meant for the tests only and isn't imported by the Netbird binaries
+package harness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/client/system"
+	mgm "github.com/netbirdio/netbird/management/client"
+	signal "github.com/netbirdio/netbird/signal/client"
+	"github.com/netbirdio/netbird/testutil"
+)
+
+var (
+	// allocatedPortsMux guards allocatedPorts
+	allocatedPortsMux sync.Mutex
+	// allocatedPorts are the Wireguard ports handed to the peers of the harnesses of the test binary, so that
+	// the tests running in parallel never get the same one
+	allocatedPorts = map[int]struct{}{}
+)
+
+// Harness runs the Management and the Signal services of a test and registers the peers of the test with them.
+// The services and the clients of the peers are stopped once the test has completed, even if it has failed
+type Harness struct {
+	// Services are the running services, nil until the harness has been started
+	*testutil.Services
+
+	t       testing.TB
+	opts    testutil.Options
+	mux     sync.Mutex
+	peers   []*Peer
+	stopped bool
+}
+
+// Peer is a peer registered with the Management Service of a Harness along with the clients connected to
+// the services. The Engine of the peer is created by the test from its key, address, interface and clients
+type Peer struct {
+	Key wgtypes.Key
+	// Address is the address of the peer assigned by the Management Service, e.g. 100.64.0.1/16
+	Address string
+	// IfaceName is the name of the Wireguard interface of the peer, named after its port
+	IfaceName string
+	// WgPort is a free local UDP port of the Wireguard interface of the peer
+	WgPort           int
+	ManagementClient *mgm.GrpcClient
+	SignalClient     *signal.GrpcClient
+}
+
+// NewHarness creates a harness of the test with the services configured by the options, e.g. a store fixture
+// (Options.StoreFile) and the setup key of the peers (Options.SetupKey). The services are started by Start
+func NewHarness(t testing.TB, opts testutil.Options) *Harness {
+	return &Harness{t: t, opts: opts}
+}
+
+// Start starts the services listening on random local ports, the test fails if they can't be started.
+// Stop is called on the test cleanup
+func (h *Harness) Start() {
+	h.t.Helper()
+	h.t.Cleanup(h.Stop)
+	h.Services = testutil.StartServices(h.t, h.opts)
+}
+
+// Stop closes the clients of the peers and stops the services, it has no effect if they have been stopped
+func (h *Harness) Stop() {
+	h.mux.Lock()
+	peers := h.peers
+	h.peers = nil
+	h.stopped = true
+	h.mux.Unlock()
+
+	for _, peer := range peers {
+		_ = peer.ManagementClient.Close()
+		_ = peer.SignalClient.Close()
+	}
+	if h.Services != nil {
+		h.Services.Stop()
+	}
+}
+
+// CreatePeer registers a new peer with the setup key of the harness and connects it to the services.
+// The peer gets the requested IP if it isn't empty. Safe to be called concurrently, e.g. to start many peers at once
+func (h *Harness) CreatePeer(ctx context.Context, requestedIP string) (*Peer, error) {
+	h.mux.Lock()
+	stopped := h.stopped
+	h.mux.Unlock()
+	if h.Services == nil {
+		return nil, fmt.Errorf("harness hasn't been started")
+	}
+	if stopped {
+		return nil, fmt.Errorf("harness has been stopped")
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	mgmClient, err := mgm.NewClient(ctx, h.ManagementAddr, key, false)
+	if err != nil {
+		return nil, err
+	}
+	signalClient, err := signal.NewClient(ctx, h.SignalAddr, key, false)
+	if err != nil {
+		_ = mgmClient.Close()
+		return nil, err
+	}
+	peer := &Peer{Key: key, ManagementClient: mgmClient, SignalClient: signalClient}
+	if err := h.addPeer(peer); err != nil {
+		return nil, err
+	}
+
+	serverKey, err := mgmClient.GetServerPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := mgmClient.Register(*serverKey, h.SetupKey, "", system.GetInfo(ctx), requestedIP, false)
+	if err != nil {
+		return nil, err
+	}
+	peer.Address = resp.GetPeerConfig().GetAddress()
+
+	peer.WgPort, err = allocatePort()
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS == "darwin" {
+		peer.IfaceName = fmt.Sprintf("utun%d", peer.WgPort)
+	} else {
+		peer.IfaceName = fmt.Sprintf("wt%d", peer.WgPort)
+	}
+	return peer, nil
+}
+
+// addPeer keeps the peer to close its clients on Stop, they are closed right away if the harness has been stopped
+func (h *Harness) addPeer(peer *Peer) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.stopped {
+		_ = peer.ManagementClient.Close()
+		_ = peer.SignalClient.Close()
+		return fmt.Errorf("harness has been stopped")
+	}
+	h.peers = append(h.peers, peer)
+	return nil
+}
+
+// allocatePort returns a free local UDP port which hasn't been handed to another peer of the test binary
+func allocatePort() (int, error) {
+	allocatedPortsMux.Lock()
+	defer allocatedPortsMux.Unlock()
+
+	// the ports are held until all the candidates have been picked, so that a released port isn't picked again
+	var held []*net.UDPConn
+	defer func() {
+		for _, conn := range held {
+			_ = conn.Close()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return 0, err
+		}
+		held = append(held, conn)
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		if _, ok := allocatedPorts[port]; !ok {
+			allocatedPorts[port] = struct{}{}
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free UDP port found")
+}
@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netbirdio/netbird/testutil"
+)
+
+func TestHarness_Parallel(t *testing.T) {
+	portsMux := sync.Mutex{}
+	ports := map[int]string{}
+
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("Harness %d", i)
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			harness := NewHarness(t, testutil.Options{StoreFile: "../../client/testdata/store.json",
+				SetupKey: "A2C8E62B-38F5-4553-B31E-DD66C696CEBB"})
+			harness.Start()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			for j := 0; j < 2; j++ {
+				peer, err := harness.CreatePeer(ctx, "")
+				require.NoError(t, err)
+				assert.NotEmpty(t, peer.Address, "expecting the peer to be registered with the setup key of the fixture")
+				assert.Contains(t, peer.IfaceName, fmt.Sprint(peer.WgPort))
+
+				portsMux.Lock()
+				other, taken := ports[peer.WgPort]
+				ports[peer.WgPort] = name
+				portsMux.Unlock()
+				assert.False(t, taken, "expecting port %d not to be taken by %s", peer.WgPort, other)
+
+				registered, err := harness.AccountManager.GetPeer(peer.Key.PublicKey().String())
+				require.NoError(t, err)
+				assert.Equal(t, peer.Address, registered.IP.String()+"/16")
+			}
+		})
+	}
+}
+
+func TestHarness_Stop(t *testing.T) {
+	harness := NewHarness(t, testutil.Options{})
+	_, err := harness.CreatePeer(context.Background(), "")
+	assert.Error(t, err, "expecting no peers before the harness has been started")
+
+	harness.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	peer, err := harness.CreatePeer(ctx, "")
+	require.NoError(t, err)
+
+	harness.Stop()
+	_, err = peer.ManagementClient.GetServerPublicKey()
+	assert.Error(t, err, "expecting the clients of the peers to be closed")
+	_, err = harness.CreatePeer(ctx, "")
+	assert.Error(t, err, "expecting no peers once the harness has been stopped")
+
+	// stopping again on the test cleanup has no effect
+	harness.Stop()
+}
@@ -0,0 +1,181 @@
+// Package testutil runs the Management and the Signal services in-process, so that the integration tests of the
+// applications embedding the Netbird client connect peers against the real services. The harness package registers
+// the peers of a test with them. It is meant for the tests only and isn't imported by the Netbird binaries
+package testutil
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+
+	mgmtProto "github.com/netbirdio/netbird/management/proto"
+	mgmt "github.com/netbirdio/netbird/management/server"
+	sigProto "github.com/netbirdio/netbird/signal/proto"
+	sig "github.com/netbirdio/netbird/signal/server"
+	"github.com/netbirdio/netbird/util"
+)
+
+// testUserID is the user owning the account created by StartServices
+const testUserID = "testutil-user"
+
+var (
+	kaep = keepalive.EnforcementPolicy{
+		MinTime:             15 * time.Second,
+		PermitWithoutStream: true,
+	}
+
+	kasp = keepalive.ServerParameters{
+		MaxConnectionIdle:     15 * time.Second,
+		MaxConnectionAgeGrace: 5 * time.Second,
+		Time:                  5 * time.Second,
+		Timeout:               2 * time.Second,
+	}
+)
+
+// Options are the options of the services started by StartServices
+type Options struct {
+	// DataDir is the directory of the Management Service store, a temporary directory of the test if not set
+	DataDir string
+	// StoreFile is a store.json copied to DataDir before the Management Service starts, e.g. with predefined
+	// accounts and setup keys. An empty store is used if not set
+	StoreFile string
+	// Stuns are the STUN servers passed to the peers
+	Stuns []*mgmt.Host
+	// TURNConfig are the TURN servers passed to the peers
+	TURNConfig *mgmt.TURNConfig
+	// SetupKey is the setup key the peers register with, e.g. a key of the StoreFile. A reusable setup key of
+	// the account created on start if not set
+	SetupKey string
+}
+
+// Services are the Management and the Signal services running in-process
+type Services struct {
+	// ManagementURL is the URL of the Management Service for the client config, e.g. http://127.0.0.1:33073
+	ManagementURL string
+	// ManagementAddr is the host:port of the Management Service
+	ManagementAddr string
+	// SignalURL is the URL of the Signal Service, e.g. http://127.0.0.1:10000
+	SignalURL string
+	// SignalAddr is the host:port of the Signal Service
+	SignalAddr string
+	// AccountManager manages the accounts of the Management Service, e.g. to inspect the registered peers
+	AccountManager mgmt.AccountManager
+	// AccountID is the ID of the account created on start
+	AccountID string
+	// SetupKey is the setup key of Options or a reusable setup key of the account created on start
+	SetupKey string
+
+	managementServer *grpc.Server
+	signalServer     *grpc.Server
+}
+
+// StartServices starts the Signal and the Management services listening on random local ports and creates
+// an account with a reusable setup key. The services are stopped once the test and its subtests have completed
+func StartServices(t testing.TB, opts Options) *Services {
+	t.Helper()
+
+	services := &Services{}
+	t.Cleanup(services.Stop)
+
+	signalLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening for the Signal Service: %v", err)
+	}
+	services.SignalAddr = signalLis.Addr().String()
+	services.SignalURL = "http://" + services.SignalAddr
+	services.signalServer = grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
+	sigProto.RegisterSignalExchangeServer(services.signalServer, sig.NewServer())
+	go func() {
+		_ = services.signalServer.Serve(signalLis)
+	}()
+
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dataDir = t.TempDir()
+	}
+	if opts.StoreFile != "" {
+		err = util.CopyFileContents(opts.StoreFile, filepath.Join(dataDir, "store.json"))
+		if err != nil {
+			t.Fatalf("failed copying the store file %s: %v", opts.StoreFile, err)
+		}
+	}
+
+	turnConfig := opts.TURNConfig
+	if turnConfig == nil {
+		turnConfig = &mgmt.TURNConfig{}
+	}
+	stuns := opts.Stuns
+	if stuns == nil {
+		stuns = []*mgmt.Host{}
+	}
+	config := &mgmt.Config{
+		Stuns:      stuns,
+		TURNConfig: turnConfig,
+		Signal: &mgmt.Host{
+			Proto: mgmt.HTTP,
+			URI:   services.SignalAddr,
+		},
+		Datadir: dataDir,
+	}
+
+	store, err := mgmt.NewStore(dataDir)
+	if err != nil {
+		t.Fatalf("failed creating the store in %s: %v", dataDir, err)
+	}
+	peersUpdateManager := mgmt.NewPeersUpdateManager()
+	accountManager, err := mgmt.BuildManager(store, peersUpdateManager, nil, nil)
+	if err != nil {
+		t.Fatalf("failed creating the account manager: %v", err)
+	}
+	services.AccountManager = accountManager
+
+	account, err := accountManager.GetOrCreateAccountByUser(testUserID, "")
+	if err != nil {
+		t.Fatalf("failed creating the test account: %v", err)
+	}
+	services.AccountID = account.Id
+	services.SetupKey = opts.SetupKey
+	for _, key := range account.SetupKeys {
+		if services.SetupKey != "" {
+			break
+		}
+		if key.Type == mgmt.SetupKeyReusable && key.IsValid() {
+			services.SetupKey = key.Key
+			break
+		}
+	}
+
+	turnManager := mgmt.NewTimeBasedAuthSecretsManager(peersUpdateManager, config.TURNConfig)
+	mgmtServer, err := mgmt.NewServer(config, accountManager, peersUpdateManager, turnManager)
+	if err != nil {
+		t.Fatalf("failed creating the Management Service: %v", err)
+	}
+
+	mgmtLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening for the Management Service: %v", err)
+	}
+	services.ManagementAddr = mgmtLis.Addr().String()
+	services.ManagementURL = "http://" + services.ManagementAddr
+	services.managementServer = grpc.NewServer(grpc.KeepaliveEnforcementPolicy(kaep), grpc.KeepaliveParams(kasp))
+	mgmtProto.RegisterManagementServiceServer(services.managementServer, mgmtServer)
+	go func() {
+		_ = services.managementServer.Serve(mgmtLis)
+	}()
+
+	return services
+}
+
+// Stop stops the services, it is called on the test cleanup and has no effect if they have been stopped
+func (s *Services) Stop() {
+	if s.managementServer != nil {
+		s.managementServer.Stop()
+	}
+	if s.signalServer != nil {
+		s.signalServer.Stop()
+	}
+}
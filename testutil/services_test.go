@@ -0,0 +1,46 @@
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/netbirdio/netbird/client/system"
+	mgm "github.com/netbirdio/netbird/management/client"
+	signal "github.com/netbirdio/netbird/signal/client"
+)
+
+func TestStartServices(t *testing.T) {
+	services := StartServices(t, Options{})
+	require.NotEmpty(t, services.SetupKey, "expecting a reusable setup key of the test account")
+
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	mgmClient, err := mgm.NewClient(ctx, services.ManagementAddr, key, false)
+	require.NoError(t, err)
+	defer mgmClient.Close() //nolint
+
+	serverKey, err := mgmClient.GetServerPublicKey()
+	require.NoError(t, err)
+
+	resp, err := mgmClient.Register(*serverKey, services.SetupKey, "", system.GetInfo(ctx), "", false)
+	require.NoError(t, err)
+	assert.Equal(t, services.SignalAddr, resp.GetWiretrusteeConfig().GetSignal().GetUri(),
+		"expecting the peers to be pointed to the in-process Signal Service")
+
+	peer, err := services.AccountManager.GetPeer(key.PublicKey().String())
+	require.NoError(t, err)
+	assert.Equal(t, key.PublicKey().String(), peer.Key)
+
+	signalClient, err := signal.NewClient(ctx, services.SignalAddr, key, false)
+	require.NoError(t, err)
+	assert.NoError(t, signalClient.Close())
+}
@@ -1,18 +1,67 @@
 package util
 
 import (
-	log "github.com/sirupsen/logrus"
-	"gopkg.in/natefinch/lumberjack.v2"
+	"fmt"
 	"io"
+	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// LogLevelsEnv is an environment variable with the log levels of the components (e.g. peer=debug,engine=info).
+// It overrides the levels of the config file
+const LogLevelsEnv = "WT_LOG"
+
+const (
+	// LogFormatText is a human-readable log format
+	LogFormatText = "text"
+	// LogFormatJSON logs every entry as a JSON object, e.g. for log aggregation
+	LogFormatJSON = "json"
+)
+
+// LogRotation is the rotation of the log file: it is rotated once it reaches MaxSizeMB,
+// keeping up to MaxBackups rotated files for up to MaxAgeDays
+type LogRotation struct {
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+	Compress   bool
+}
+
+// DefaultLogRotation is the rotation of the log file set by InitLog
+var DefaultLogRotation = LogRotation{
+	MaxSizeMB:  5,
+	MaxBackups: 10,
+	MaxAgeDays: 30,
+	Compress:   true,
+}
+
+var (
+	// logFile is the log file output set by InitLog, nil if logging to the console
+	logFile *logFileWriter
+
+	componentsMux sync.Mutex
+	// componentLoggers are the loggers of the components, created by Logger
+	componentLoggers = map[string]*log.Logger{}
+	// componentLevels are the log levels of the components that differ from the global log level
+	componentLevels = map[string]log.Level{}
 )
 
-// InitLog parses and sets log-level input
-func InitLog(logLevel string, logPath string) error {
+// InitLog parses and sets log-level input. The log file is rotated with DefaultLogRotation
+func InitLog(logLevel string, logPath string, logFormat string) error {
+	return InitLogWithRotation(logLevel, logPath, logFormat, DefaultLogRotation)
+}
+
+// InitLogWithRotation parses and sets log-level input, the log file is rotated with the given rotation
+func InitLogWithRotation(logLevel string, logPath string, logFormat string, rotation LogRotation) error {
 	level, err := log.ParseLevel(logLevel)
 	if err != nil {
 		log.Errorf("Failed parsing log-level %s: %s", logLevel, err)
@@ -20,32 +69,194 @@ func InitLog(logLevel string, logPath string) error {
 	}
 
 	if logPath != "" && logPath != "console" {
-		lumberjackLogger := &lumberjack.Logger{
-			// Log file absolute path, os agnostic
-			Filename:   filepath.ToSlash(logPath),
-			MaxSize:    5, // MB
-			MaxBackups: 10,
-			MaxAge:     30, // days
-			Compress:   true,
+		if logFile != nil {
+			_ = logFile.Close()
 		}
-		log.SetOutput(io.Writer(lumberjackLogger))
+		// Log file absolute path, os agnostic
+		logFile = &logFileWriter{logger: newLumberjackLogger(filepath.ToSlash(logPath), rotation)}
+		log.SetOutput(io.Writer(logFile))
 	}
 
-	logFormatter := new(log.TextFormatter)
-	logFormatter.TimestampFormat = time.RFC3339 // or RFC3339
-	logFormatter.FullTimestamp = true
-	logFormatter.CallerPrettyfier = func(frame *runtime.Frame) (function string, file string) {
+	callerPrettyfier := func(frame *runtime.Frame) (function string, file string) {
 		fileName := path.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
 		//return frame.Function, fileName
 		return "", fileName
 	}
 
-	if level == log.DebugLevel {
-		log.SetReportCaller(true)
+	switch logFormat {
+	case "", LogFormatText:
+		logFormatter := new(log.TextFormatter)
+		logFormatter.TimestampFormat = time.RFC3339 // or RFC3339
+		logFormatter.FullTimestamp = true
+		logFormatter.CallerPrettyfier = callerPrettyfier
+		log.SetFormatter(logFormatter)
+	case LogFormatJSON:
+		logFormatter := new(log.JSONFormatter)
+		logFormatter.TimestampFormat = time.RFC3339
+		logFormatter.CallerPrettyfier = callerPrettyfier
+		log.SetFormatter(logFormatter)
+	default:
+		err = fmt.Errorf("unsupported log format %s, expecting %s or %s", logFormat, LogFormatText, LogFormatJSON)
+		log.Error(err)
+		return err
+	}
+
+	log.SetReportCaller(level == log.DebugLevel)
+	log.SetLevel(level)
+
+	return InitComponentLevels("")
+}
+
+// SetLogRotation changes the rotation of the log file set by InitLog, it has no effect when logging to the console
+func SetLogRotation(rotation LogRotation) error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.SetRotation(rotation)
+}
+
+// SetLogLevel changes the global log level at runtime. The components with their own level keep it
+func SetLogLevel(logLevel string) error {
+	level, err := log.ParseLevel(logLevel)
+	if err != nil {
+		return err
 	}
 
-	log.SetFormatter(logFormatter)
+	log.SetReportCaller(level == log.DebugLevel)
 	log.SetLevel(level)
 
+	componentsMux.Lock()
+	defer componentsMux.Unlock()
+
+	for component, logger := range componentLoggers {
+		configureComponentLogger(component, logger)
+	}
+
+	return nil
+}
+
+// ValidateComponentLevels checks the format of the log levels of the components (e.g. peer=debug,engine=info)
+func ValidateComponentLevels(levels string) error {
+	_, err := parseComponentLevels(levels)
+	return err
+}
+
+// InitComponentLevels sets the log levels of the components from a config (e.g. peer=debug,engine=info) and
+// the LogLevelsEnv environment variable on top of it. The components not listed log at the global log level
+func InitComponentLevels(levels string) error {
+	parsed, err := parseComponentLevels(levels)
+	if err != nil {
+		return err
+	}
+
+	fromEnv, err := parseComponentLevels(os.Getenv(LogLevelsEnv))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", LogLevelsEnv, err)
+	}
+	for component, level := range fromEnv {
+		parsed[component] = level
+	}
+
+	componentsMux.Lock()
+	defer componentsMux.Unlock()
+
+	componentLevels = parsed
+	for component, logger := range componentLoggers {
+		configureComponentLogger(component, logger)
+	}
+
 	return nil
 }
+
+// Logger returns the logger of a component. Its entries carry the component field and are logged at the level of the
+// component if set by InitComponentLevels, otherwise at the global log level
+func Logger(component string) *log.Entry {
+	componentsMux.Lock()
+	defer componentsMux.Unlock()
+
+	logger, ok := componentLoggers[component]
+	if !ok {
+		logger = log.New()
+		configureComponentLogger(component, logger)
+		componentLoggers[component] = logger
+	}
+
+	return logger.WithField("component", component)
+}
+
+// configureComponentLogger applies the output and the format of the standard logger and the level of the component
+func configureComponentLogger(component string, logger *log.Logger) {
+	std := log.StandardLogger()
+
+	level, ok := componentLevels[component]
+	if !ok {
+		level = std.GetLevel()
+	}
+
+	logger.SetOutput(std.Out)
+	logger.SetFormatter(std.Formatter)
+	logger.SetReportCaller(level >= log.DebugLevel)
+	logger.SetLevel(level)
+}
+
+// parseComponentLevels parses a comma separated list of component=level pairs
+func parseComponentLevels(levels string) (map[string]log.Level, error) {
+	parsed := make(map[string]log.Level)
+	for _, pair := range strings.Split(levels, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		component, levelName, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(component) == "" {
+			return nil, fmt.Errorf("invalid component log level %s, expecting component=level", pair)
+		}
+
+		level, err := log.ParseLevel(strings.TrimSpace(levelName))
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level of component %s: %v", component, err)
+		}
+		parsed[strings.TrimSpace(component)] = level
+	}
+
+	return parsed, nil
+}
+
+// logFileWriter writes the log entries to a file rotated by lumberjack, the rotation can be changed while logging
+type logFileWriter struct {
+	mu     sync.Mutex
+	logger *lumberjack.Logger
+}
+
+func newLumberjackLogger(filename string, rotation LogRotation) *lumberjack.Logger {
+	return &lumberjack.Logger{
+		Filename:   filename,
+		MaxSize:    rotation.MaxSizeMB,
+		MaxBackups: rotation.MaxBackups,
+		MaxAge:     rotation.MaxAgeDays,
+		Compress:   rotation.Compress,
+	}
+}
+
+func (w *logFileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.logger.Write(p)
+}
+
+// SetRotation reopens the log file with the given rotation
+func (w *logFileWriter) SetRotation(rotation LogRotation) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	old := w.logger
+	w.logger = newLumberjackLogger(old.Filename, rotation)
+	return old.Close()
+}
+
+func (w *logFileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.logger.Close()
+}
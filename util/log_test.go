@@ -0,0 +1,112 @@
+package util_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/netbirdio/netbird/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Log", func() {
+
+	var output *bytes.Buffer
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		err := util.InitLog("info", "console", util.LogFormatJSON)
+		Expect(err).NotTo(HaveOccurred())
+		log.SetOutput(output)
+	})
+
+	AfterEach(func() {
+		os.Unsetenv(util.LogLevelsEnv) //nolint
+		log.SetOutput(os.Stderr)
+		err := util.InitLog("info", "console", util.LogFormatText)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Describe("component levels", func() {
+		Context("set by the config and the environment", func() {
+			It("should log every component at its own level", func() {
+				os.Setenv(util.LogLevelsEnv, "engine=warn") //nolint
+				err := util.InitComponentLevels("peer=debug,engine=debug")
+				Expect(err).NotTo(HaveOccurred())
+
+				util.Logger("peer").Debug("peer debug")
+				util.Logger("engine").Info("engine info")
+				util.Logger("mgmt").Debug("mgmt debug")
+				util.Logger("mgmt").Info("mgmt info")
+
+				var entries []map[string]interface{}
+				decoder := json.NewDecoder(output)
+				for decoder.More() {
+					entry := map[string]interface{}{}
+					Expect(decoder.Decode(&entry)).To(Succeed())
+					entries = append(entries, entry)
+				}
+
+				Expect(entries).To(HaveLen(2))
+				Expect(entries[0]["msg"]).To(Equal("peer debug"))
+				Expect(entries[0]["component"]).To(Equal("peer"))
+				Expect(entries[1]["msg"]).To(Equal("mgmt info"))
+			})
+		})
+
+		Context("with an invalid level", func() {
+			It("should fail", func() {
+				Expect(util.InitComponentLevels("peer=loud")).NotTo(Succeed())
+				Expect(util.InitComponentLevels("debug")).NotTo(Succeed())
+			})
+		})
+	})
+
+	Describe("log level", func() {
+		It("should change the level of the components without their own level at runtime", func() {
+			err := util.InitComponentLevels("peer=warn")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(util.SetLogLevel("debug")).To(Succeed())
+			util.Logger("engine").Debug("engine debug")
+			util.Logger("peer").Debug("peer debug")
+
+			Expect(output.String()).To(ContainSubstring("engine debug"))
+			Expect(output.String()).NotTo(ContainSubstring("peer debug"))
+		})
+
+		It("should reject an unknown level", func() {
+			Expect(util.SetLogLevel("loud")).NotTo(Succeed())
+		})
+	})
+
+	Describe("log file", func() {
+		It("should keep logging to the file once the rotation has changed", func() {
+			dir, err := os.MkdirTemp("", "log")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir) //nolint
+
+			logPath := filepath.Join(dir, "client.log")
+			err = util.InitLogWithRotation("info", logPath, util.LogFormatText, util.DefaultLogRotation)
+			Expect(err).NotTo(HaveOccurred())
+
+			log.Info("before rotation change")
+			Expect(util.SetLogRotation(util.LogRotation{MaxSizeMB: 1, MaxBackups: 2, MaxAgeDays: 1})).To(Succeed())
+			util.Logger("engine").Info("after rotation change")
+
+			content, err := os.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("before rotation change"))
+			Expect(string(content)).To(ContainSubstring("after rotation change"))
+		})
+	})
+
+	Describe("log format", func() {
+		It("should reject an unknown format", func() {
+			Expect(util.InitLog("info", "console", "xml")).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,23 @@
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+// ValidateLoopbackAddress returns an error if the address (host:port) isn't a loopback one,
+// e.g. to keep a debug endpoint from being exposed publicly
+func ValidateLoopbackAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("address %s isn't a loopback address, e.g. 127.0.0.1:9091", address)
+	}
+	return nil
+}
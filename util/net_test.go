@@ -0,0 +1,25 @@
+package util_test
+
+import (
+	"github.com/netbirdio/netbird/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Net", func() {
+
+	Describe("loopback address", func() {
+		It("should accept the loopback addresses", func() {
+			Expect(util.ValidateLoopbackAddress("127.0.0.1:9091")).To(Succeed())
+			Expect(util.ValidateLoopbackAddress("[::1]:9091")).To(Succeed())
+			Expect(util.ValidateLoopbackAddress("localhost:9091")).To(Succeed())
+		})
+
+		It("should reject the addresses reachable from other hosts", func() {
+			Expect(util.ValidateLoopbackAddress(":9091")).NotTo(Succeed())
+			Expect(util.ValidateLoopbackAddress("0.0.0.0:9091")).NotTo(Succeed())
+			Expect(util.ValidateLoopbackAddress("10.0.0.1:9091")).NotTo(Succeed())
+			Expect(util.ValidateLoopbackAddress("127.0.0.1")).NotTo(Succeed())
+		})
+	})
+})